@@ -133,11 +133,16 @@ docker/.base-envoy.docker.stamp: FORCE
 	}
 clobber: docker/base-envoy.docker.clean
 
+# Build tags for the Go binaries in the image. Test builds can set this to `faultinject` to get
+# the control plane fault injection in pkg/faultinject; release builds leave it empty.
+GO_BUILD_TAGS ?=
+
 docker/.$(LCNAME).docker.stamp: %/.$(LCNAME).docker.stamp: %/base.docker.tag.local %/base-envoy.docker.tag.local %/base-pip.docker.tag.local python/ambassador.version $(BUILDER_HOME)/Dockerfile $(OSS_HOME)/build-aux/py-version.txt $(tools/dsum) vendor FORCE
 	@printf "${CYN}==> ${GRN}Building image ${BLU}$(LCNAME)${END}\n"
 	@printf "    ${BLU}base=$$(sed -n 2p $*/base.docker.tag.local)${END}\n"
 	@printf "    ${BLU}envoy=$$(cat $*/base-envoy.docker)${END}\n"
 	@printf "    ${BLU}builderbase=$$(sed -n 2p $*/base-pip.docker.tag.local)${END}\n"
+	@printf "    ${BLU}go_build_tags=$(GO_BUILD_TAGS)${END}\n"
 	{ $(tools/dsum) '$(LCNAME) build' 3s \
 	  docker build -f ${BUILDER_HOME}/Dockerfile . \
 			--platform="$(BUILD_ARCH)" \
@@ -145,6 +150,7 @@ docker/.$(LCNAME).docker.stamp: %/.$(LCNAME).docker.stamp: %/base.docker.tag.loc
 	    --build-arg=envoy="$$(cat $*/base-envoy.docker)" \
 	    --build-arg=builderbase="$$(sed -n 2p $*/base-pip.docker.tag.local)" \
 	    --build-arg=py_version="$$(cat build-aux/py-version.txt)" \
+	    --build-arg=go_build_tags="$(GO_BUILD_TAGS)" \
 	    --iidfile=$@; }
 clean: docker/$(LCNAME).docker.clean
 
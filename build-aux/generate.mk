@@ -28,6 +28,8 @@ generate/files      += $(patsubst $(OSS_HOME)/api/%.proto,                   $(O
 generate/files      += $(patsubst $(OSS_HOME)/api/%.proto,                   $(OSS_HOME)/pkg/api/%_grpc.pb.go                    , $(shell find $(OSS_HOME)/api/kat/              -name '*.proto'))
 generate/files      += $(patsubst $(OSS_HOME)/api/%.proto,                   $(OSS_HOME)/pkg/api/%.pb.go                         , $(shell find $(OSS_HOME)/api/agent/            -name '*.proto')) $(OSS_HOME)/pkg/api/agent/
 generate/files      += $(patsubst $(OSS_HOME)/api/%.proto,                   $(OSS_HOME)/pkg/api/%_grpc.pb.go                    , $(shell find $(OSS_HOME)/api/agent/            -name '*.proto'))
+generate/files      += $(patsubst $(OSS_HOME)/api/%.proto,                   $(OSS_HOME)/pkg/api/%.pb.go                         , $(shell find $(OSS_HOME)/api/resolverplugin/   -name '*.proto')) $(OSS_HOME)/pkg/api/resolverplugin/
+generate/files      += $(patsubst $(OSS_HOME)/api/%.proto,                   $(OSS_HOME)/pkg/api/%_grpc.pb.go                    , $(shell find $(OSS_HOME)/api/resolverplugin/   -name '*.proto'))
 # Whole directories with one rule for the whole directory
 generate/files      += $(OSS_HOME)/api/envoy/                # recipe in _cxx/envoy.mk
 generate/files      += $(OSS_HOME)/pkg/api/envoy/            # recipe in _cxx/envoy.mk
@@ -0,0 +1,309 @@
+package apiext
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	// k8s types
+	k8sTypesCoreV1 "k8s.io/api/core/v1"
+	k8sTypesAPIExtV1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	k8sTypesMetaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	// k8s clients
+	k8sClientAPIExtV1 "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/typed/apiextensions/v1"
+	k8sClientDynamic "k8s.io/client-go/dynamic"
+	k8sClientCoreV1 "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	// k8s utils
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	k8sRuntime "k8s.io/apimachinery/pkg/runtime"
+	k8sSchema "k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/datawire/dlib/derror"
+	"github.com/datawire/dlib/dlog"
+	crdAll "github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io"
+	"github.com/emissary-ingress/emissary/v3/pkg/k8s"
+)
+
+// Storage version migration: the API server keeps every custom resource in etcd at whatever
+// version was the storage version when the resource was last written, and lists those versions in
+// the CRD's .status.storedVersions. A CRD can't stop serving a version while resources might still
+// be stored at it, so before an upgrade drops an old version, every resource has to be rewritten.
+//
+// A Migrator does that: for each CRD that the scheme knows how to convert, it reads every resource at
+// the storage version (the API server converts them with our conversion webhook, so apiext has to
+// be running) and writes each one back unchanged, which stores it at the storage version. Once
+// every resource of a CRD has been rewritten, it sets the CRD's storedVersions to just the storage
+// version. It reports how it's going in a ConfigMap, so that a Helm hook or an operator can wait
+// for the "status" key to say "Complete" (or "Failed").
+//
+// It needs to list and update CRDs and their status, to list and update every resource of those
+// CRDs, and to get, create, and update its ConfigMap.
+
+// DefaultMigrationConfigMap is the name of the ConfigMap that a Migrator reports progress in, by
+// default.
+const DefaultMigrationConfigMap = "emissary-crd-migration"
+
+// The states of a migration, as the ConfigMap's "status" key says them.
+const (
+	MigrationRunning  = "Running"
+	MigrationComplete = "Complete"
+	MigrationFailed   = "Failed"
+)
+
+// migrationPageSize is how many resources a Migrator lists at a time.
+const migrationPageSize = 500
+
+// MigrationProgress is how the migration of one CRD is going. The ConfigMap has one for each CRD,
+// as JSON, under the CRD's name.
+type MigrationProgress struct {
+	StorageVersion string   `json:"storageVersion"`
+	StoredVersions []string `json:"storedVersions"`
+	State          string   `json:"state"`
+	Migrated       int      `json:"migrated"`
+	Failed         int      `json:"failed"`
+	Error          string   `json:"error,omitempty"`
+}
+
+// A Migrator migrates the stored resources of CRDs to their storage versions.
+type Migrator struct {
+	CRDs       k8sClientAPIExtV1.CustomResourceDefinitionInterface
+	Dynamic    k8sClientDynamic.Interface
+	ConfigMaps k8sClientCoreV1.ConfigMapInterface
+	Scheme     *k8sRuntime.Scheme
+
+	// ConfigMap is the name of the ConfigMap to report progress in.
+	ConfigMap string
+
+	// Now tells the time for the ConfigMap's "updated" key.
+	Now func() time.Time
+
+	progress map[string]*MigrationProgress
+}
+
+// MigrateMain is a `github.com/emissary-ingress/emissary/v3/pkg/busy`-compatible wrapper around
+// 'Migrator.Run()', using values appropriate for the stock Emissary.
+func MigrateMain(ctx context.Context, version string, args ...string) error {
+	cmd := &cobra.Command{
+		Use:           "migrate-crds",
+		Short:         "rewrite stored getambassador.io resources at their CRDs' storage versions",
+		Args:          cobra.NoArgs,
+		SilenceErrors: true,
+		SilenceUsage:  true,
+	}
+
+	info := k8s.NewKubeInfoFromFlags(cmd.Flags())
+	configMap := cmd.Flags().String("progress-configmap", DefaultMigrationConfigMap, "name of the ConfigMap to report progress in")
+	namespace := cmd.Flags().String("progress-namespace", PodNamespace(), "namespace of the ConfigMap to report progress in")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		dlog.Infof(ctx, "Emissary Ingress CRD storage version migration (version %q)", version)
+
+		restConfig, err := info.GetRestConfig()
+		if err != nil {
+			return err
+		}
+		apiExtClient, err := k8sClientAPIExtV1.NewForConfig(restConfig)
+		if err != nil {
+			return err
+		}
+		dynamicClient, err := k8sClientDynamic.NewForConfig(restConfig)
+		if err != nil {
+			return err
+		}
+		coreClient, err := k8sClientCoreV1.NewForConfig(restConfig)
+		if err != nil {
+			return err
+		}
+
+		m := &Migrator{
+			CRDs:       apiExtClient.CustomResourceDefinitions(),
+			Dynamic:    dynamicClient,
+			ConfigMaps: coreClient.ConfigMaps(*namespace),
+			Scheme:     crdAll.BuildScheme(),
+			ConfigMap:  *configMap,
+		}
+		return m.Run(ctx)
+	}
+
+	cmd.SetArgs(args)
+	return cmd.ExecuteContext(ctx)
+}
+
+// Run migrates every CRD that needs it, and returns an error if any of them couldn't be.
+func (m *Migrator) Run(ctx context.Context) error {
+	if m.Now == nil {
+		m.Now = time.Now
+	}
+	m.progress = make(map[string]*MigrationProgress)
+
+	crdList, err := m.CRDs.List(ctx, k8sTypesMetaV1.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	var crds []k8sTypesAPIExtV1.CustomResourceDefinition
+	for _, crd := range crdList.Items {
+		storageVersion := getStorageVersion(crd)
+		if storageVersion == "" || !m.Scheme.Recognizes(k8sSchema.GroupVersionKind{
+			Group:   crd.Spec.Group,
+			Version: storageVersion,
+			Kind:    crd.Spec.Names.Kind,
+		}) {
+			continue
+		}
+		m.progress[crd.Name] = &MigrationProgress{
+			StorageVersion: storageVersion,
+			StoredVersions: crd.Status.StoredVersions,
+			State:          MigrationRunning,
+		}
+		crds = append(crds, crd)
+	}
+	if err := m.report(ctx, MigrationRunning); err != nil {
+		return err
+	}
+
+	var errs derror.MultiError
+	for _, crd := range crds {
+		if err := m.migrateCRD(ctx, crd); err != nil {
+			dlog.Errorf(ctx, "Migrating %q: %v", crd.Name, err)
+			m.progress[crd.Name].State = MigrationFailed
+			m.progress[crd.Name].Error = err.Error()
+			errs = append(errs, fmt.Errorf("%s: %w", crd.Name, err))
+		}
+		if err := m.report(ctx, MigrationRunning); err != nil {
+			return err
+		}
+	}
+
+	if len(errs) > 0 {
+		if err := m.report(ctx, MigrationFailed); err != nil {
+			dlog.Errorf(ctx, "Reporting the failure: %v", err)
+		}
+		return errs
+	}
+	return m.report(ctx, MigrationComplete)
+}
+
+// getStorageVersion returns the name of the version that the CRD stores resources at.
+func getStorageVersion(crd k8sTypesAPIExtV1.CustomResourceDefinition) string {
+	for _, version := range crd.Spec.Versions {
+		if version.Storage {
+			return version.Name
+		}
+	}
+	return ""
+}
+
+func (m *Migrator) migrateCRD(ctx context.Context, crd k8sTypesAPIExtV1.CustomResourceDefinition) error {
+	progress := m.progress[crd.Name]
+	if len(crd.Status.StoredVersions) == 1 && crd.Status.StoredVersions[0] == progress.StorageVersion {
+		dlog.Infof(ctx, "Skipping %q because everything is already stored at %s", crd.Name, progress.StorageVersion)
+		progress.State = MigrationComplete
+		return nil
+	}
+	dlog.Infof(ctx, "Migrating %q from %v to %s", crd.Name, crd.Status.StoredVersions, progress.StorageVersion)
+
+	resources := m.Dynamic.Resource(k8sSchema.GroupVersionResource{
+		Group:    crd.Spec.Group,
+		Version:  progress.StorageVersion,
+		Resource: crd.Spec.Names.Plural,
+	})
+
+	var lastErr error
+	opts := k8sTypesMetaV1.ListOptions{Limit: migrationPageSize}
+	for {
+		list, err := resources.List(ctx, opts)
+		if err != nil {
+			return err
+		}
+		for i := range list.Items {
+			obj := &list.Items[i]
+			_, err := resources.Namespace(obj.GetNamespace()).Update(ctx, obj, k8sTypesMetaV1.UpdateOptions{})
+			switch {
+			case err == nil,
+				// Someone else wrote it since we listed it, which stored it at the storage
+				// version just as well.
+				k8sErrors.IsConflict(err),
+				// It's gone, so it's not stored at any version.
+				k8sErrors.IsNotFound(err):
+				progress.Migrated++
+			default:
+				dlog.Errorf(ctx, "Migrating %s %s/%s: %v", crd.Spec.Names.Kind, obj.GetNamespace(), obj.GetName(), err)
+				progress.Failed++
+				lastErr = err
+			}
+		}
+		if err := m.report(ctx, MigrationRunning); err != nil {
+			return err
+		}
+		if list.GetContinue() == "" {
+			break
+		}
+		opts.Continue = list.GetContinue()
+	}
+	if progress.Failed > 0 {
+		return fmt.Errorf("%d resources couldn't be migrated (the last error was: %w)", progress.Failed, lastErr)
+	}
+
+	// Everything is stored at the storage version now, so that's all the CRD has to admit to.
+	latest, err := m.CRDs.Get(ctx, crd.Name, k8sTypesMetaV1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if getStorageVersion(*latest) != progress.StorageVersion {
+		return fmt.Errorf("the storage version changed from %s to %s while migrating", progress.StorageVersion, getStorageVersion(*latest))
+	}
+	latest.Status.StoredVersions = []string{progress.StorageVersion}
+	if _, err := m.CRDs.UpdateStatus(ctx, latest, k8sTypesMetaV1.UpdateOptions{}); err != nil {
+		return err
+	}
+
+	progress.StoredVersions = latest.Status.StoredVersions
+	progress.State = MigrationComplete
+	dlog.Infof(ctx, "Migrated %d %q resources to %s", progress.Migrated, crd.Name, progress.StorageVersion)
+	return nil
+}
+
+// report writes the progress so far to the ConfigMap, creating it if need be.
+func (m *Migrator) report(ctx context.Context, status string) error {
+	data := map[string]string{
+		"status":  status,
+		"updated": m.Now().UTC().Format(time.RFC3339),
+	}
+	names := make([]string, 0, len(m.progress))
+	for name := range m.progress {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		bytes, err := json.Marshal(m.progress[name])
+		if err != nil {
+			return err
+		}
+		data[name] = string(bytes)
+	}
+
+	cm, err := m.ConfigMaps.Get(ctx, m.ConfigMap, k8sTypesMetaV1.GetOptions{})
+	if k8sErrors.IsNotFound(err) {
+		_, err = m.ConfigMaps.Create(ctx, &k8sTypesCoreV1.ConfigMap{
+			ObjectMeta: k8sTypesMetaV1.ObjectMeta{
+				Name: m.ConfigMap,
+			},
+			Data: data,
+		}, k8sTypesMetaV1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	cm.Data = data
+	_, err = m.ConfigMaps.Update(ctx, cm, k8sTypesMetaV1.UpdateOptions{})
+	return err
+}
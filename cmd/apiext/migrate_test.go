@@ -0,0 +1,92 @@
+package apiext
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	k8sTypesAPIExtV1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	k8sFakeAPIExt "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	k8sTypesMetaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	k8sRuntime "k8s.io/apimachinery/pkg/runtime"
+	k8sSchema "k8s.io/apimachinery/pkg/runtime/schema"
+	k8sFakeDynamic "k8s.io/client-go/dynamic/fake"
+	k8sFakeKubernetes "k8s.io/client-go/kubernetes/fake"
+
+	"github.com/datawire/dlib/dlog"
+	crdAll "github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io"
+)
+
+func testCRD(group, kind, plural string, storedVersions ...string) *k8sTypesAPIExtV1.CustomResourceDefinition {
+	return &k8sTypesAPIExtV1.CustomResourceDefinition{
+		ObjectMeta: k8sTypesMetaV1.ObjectMeta{Name: plural + "." + group},
+		Spec: k8sTypesAPIExtV1.CustomResourceDefinitionSpec{
+			Group: group,
+			Names: k8sTypesAPIExtV1.CustomResourceDefinitionNames{Kind: kind, Plural: plural},
+			Versions: []k8sTypesAPIExtV1.CustomResourceDefinitionVersion{
+				{Name: "v2", Served: true},
+				{Name: "v3alpha1", Served: true, Storage: true},
+			},
+		},
+		Status: k8sTypesAPIExtV1.CustomResourceDefinitionStatus{StoredVersions: storedVersions},
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := dlog.NewTestContext(t, true)
+
+	mappings := k8sSchema.GroupVersionResource{Group: "getambassador.io", Version: "v3alpha1", Resource: "mappings"}
+	mapping := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "getambassador.io/v3alpha1",
+		"kind":       "Mapping",
+		"metadata":   map[string]interface{}{"name": "quote", "namespace": "default"},
+		"spec":       map[string]interface{}{"prefix": "/quote/", "service": "quote"},
+	}}
+
+	crds := k8sFakeAPIExt.NewSimpleClientset(
+		testCRD("getambassador.io", "Mapping", "mappings", "v2", "v3alpha1"),
+		testCRD("getambassador.io", "Host", "hosts", "v3alpha1"),
+		testCRD("example.com", "Widget", "widgets", "v2", "v3alpha1"),
+	)
+	m := &Migrator{
+		CRDs: crds.ApiextensionsV1().CustomResourceDefinitions(),
+		Dynamic: k8sFakeDynamic.NewSimpleDynamicClientWithCustomListKinds(k8sRuntime.NewScheme(),
+			map[k8sSchema.GroupVersionResource]string{mappings: "MappingList"}, mapping),
+		ConfigMaps: k8sFakeKubernetes.NewSimpleClientset().CoreV1().ConfigMaps("ambassador"),
+		Scheme:     crdAll.BuildScheme(),
+		ConfigMap:  DefaultMigrationConfigMap,
+		Now:        func() time.Time { return time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC) },
+	}
+	require.NoError(t, m.Run(ctx))
+
+	crd, err := m.CRDs.Get(ctx, "mappings.getambassador.io", k8sTypesMetaV1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"v3alpha1"}, crd.Status.StoredVersions)
+
+	// We don't know how to convert Widgets, so they're left alone.
+	crd, err = m.CRDs.Get(ctx, "widgets.example.com", k8sTypesMetaV1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"v2", "v3alpha1"}, crd.Status.StoredVersions)
+
+	cm, err := m.ConfigMaps.Get(ctx, DefaultMigrationConfigMap, k8sTypesMetaV1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, MigrationComplete, cm.Data["status"])
+	assert.Equal(t, "2026-01-02T03:04:05Z", cm.Data["updated"])
+	assert.NotContains(t, cm.Data, "widgets.example.com")
+
+	var progress MigrationProgress
+	require.NoError(t, json.Unmarshal([]byte(cm.Data["mappings.getambassador.io"]), &progress))
+	assert.Equal(t, MigrationProgress{
+		StorageVersion: "v3alpha1",
+		StoredVersions: []string{"v3alpha1"},
+		State:          MigrationComplete,
+		Migrated:       1,
+	}, progress)
+
+	require.NoError(t, json.Unmarshal([]byte(cm.Data["hosts.getambassador.io"]), &progress))
+	assert.Equal(t, MigrationComplete, progress.State)
+	assert.Equal(t, 0, progress.Migrated)
+}
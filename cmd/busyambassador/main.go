@@ -53,10 +53,11 @@ func main() {
 	}
 
 	busy.Main("busyambassador", "Ambassador", version, map[string]busy.Command{
-		"kubestatus": {Setup: environment.EnvironmentSetupEntrypoint, Run: kubestatus.Main},
-		"entrypoint": {Setup: noop, Run: entrypoint.Main},
-		"reproducer": {Setup: noop, Run: reproducer.Main},
-		"version":    {Setup: noop, Run: showVersion},
-		"apiext":     {Setup: noop, Run: apiext.Main},
+		"kubestatus":   {Setup: environment.EnvironmentSetupEntrypoint, Run: kubestatus.Main},
+		"entrypoint":   {Setup: noop, Run: entrypoint.Main},
+		"reproducer":   {Setup: noop, Run: reproducer.Main},
+		"version":      {Setup: noop, Run: showVersion},
+		"apiext":       {Setup: noop, Run: apiext.Main},
+		"migrate-crds": {Setup: noop, Run: apiext.MigrateMain},
 	})
 }
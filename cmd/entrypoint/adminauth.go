@@ -0,0 +1,163 @@
+package entrypoint
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	k8sTypesAuthnV1 "k8s.io/api/authentication/v1"
+	k8sTypesAuthzV1 "k8s.io/api/authorization/v1"
+	k8sTypesMetaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sClientAuthnV1 "k8s.io/client-go/kubernetes/typed/authentication/v1"
+	k8sClientAuthzV1 "k8s.io/client-go/kubernetes/typed/authorization/v1"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/adminauth"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+)
+
+// Admin API authentication: with AMBASSADOR_ADMIN_AUTH=kubernetes, the admin endpoints (freezing
+// configuration, capturing traffic, the debug endpoints, and the external snapshot server) want a
+// bearer token that the cluster's RBAC lets do what the request does (see pkg/adminauth), instead
+// of being open to anyone who can reach them. AMBASSADOR_CONFIG_FREEZE_TOKEN isn't used then: who
+// may freeze configuration is up to RBAC, like everything else.
+//
+// The health checks, version, metrics, and the diag UI stay open either way, since kubelet,
+// Prometheus, and people looking at the diag UI don't have tokens to send.
+
+// GetAdminAuth returns how the admin endpoints authenticate requests, from AMBASSADOR_ADMIN_AUTH:
+// "none" (the default) or "kubernetes".
+func GetAdminAuth() string {
+	return strings.ToLower(env("AMBASSADOR_ADMIN_AUTH", "none"))
+}
+
+// GetAdminAuthCacheTTL returns how long what the TokenReviews and SubjectAccessReviews decide is
+// kept for, from AMBASSADOR_ADMIN_AUTH_CACHE_SECONDS.
+func GetAdminAuthCacheTTL() time.Duration {
+	secs, err := strconv.Atoi(env("AMBASSADOR_ADMIN_AUTH_CACHE_SECONDS", "60"))
+	if err != nil || secs <= 0 {
+		return adminauth.DefaultCacheTTL
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// newAdminAuthorizer returns the Authorizer for the admin endpoints, or nil if they're open.
+//
+// Anything other than "none" is taken to mean "kubernetes": a typo shouldn't leave the admin
+// endpoints open to anyone. For the same reason, if there's no way to talk to the API server, the
+// admin endpoints refuse everything.
+func newAdminAuthorizer(ctx context.Context) *adminauth.Authorizer {
+	mode := GetAdminAuth()
+	switch mode {
+	case "none", "":
+		return nil
+	case "kubernetes":
+	default:
+		dlog.Errorf(ctx, "AMBASSADOR_ADMIN_AUTH=%s isn't none or kubernetes; using kubernetes", mode)
+	}
+
+	var reviewer adminauth.Reviewer
+	if kr, err := newKubeReviewer(); err != nil {
+		dlog.Errorf(ctx, "Admin API authentication: %v; the admin endpoints will refuse every request", err)
+		reviewer = brokenReviewer{err}
+	} else {
+		reviewer = kr
+	}
+	ttl := GetAdminAuthCacheTTL()
+	dlog.Infof(ctx, "Admin API authentication: kubernetes (decisions kept for %v)", ttl)
+	return adminauth.NewAuthorizer(reviewer, clock.FromContext(ctx), ttl)
+}
+
+type adminAuthorizerKey struct{}
+
+// withAdminAuthorizer returns a copy of ctx that carries the admin Authorizer.
+func withAdminAuthorizer(ctx context.Context, a *adminauth.Authorizer) context.Context {
+	return context.WithValue(ctx, adminAuthorizerKey{}, a)
+}
+
+// adminAuthorizerFromContext returns the admin Authorizer, or nil if the admin endpoints are open.
+func adminAuthorizerFromContext(ctx context.Context) *adminauth.Authorizer {
+	a, _ := ctx.Value(adminAuthorizerKey{}).(*adminauth.Authorizer)
+	return a
+}
+
+// requester returns who made an admin request, for the logs: the user the admin Authorizer let
+// through, or else where the request came from.
+func requester(r *http.Request) string {
+	if user := adminauth.User(r.Context()); user != "" {
+		return user
+	}
+	return r.RemoteAddr
+}
+
+// kubeReviewer asks the API server with TokenReviews and SubjectAccessReviews.
+type kubeReviewer struct {
+	tokenReviews  k8sClientAuthnV1.TokenReviewInterface
+	accessReviews k8sClientAuthzV1.SubjectAccessReviewInterface
+}
+
+func newKubeReviewer() (*kubeReviewer, error) {
+	restConfig, err := kates.NewConfigFlags(false).ToRESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	authnClient, err := k8sClientAuthnV1.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	authzClient, err := k8sClientAuthzV1.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &kubeReviewer{
+		tokenReviews:  authnClient.TokenReviews(),
+		accessReviews: authzClient.SubjectAccessReviews(),
+	}, nil
+}
+
+func (k *kubeReviewer) ReviewToken(ctx context.Context, token string) (k8sTypesAuthnV1.UserInfo, bool, error) {
+	review, err := k.tokenReviews.Create(ctx, &k8sTypesAuthnV1.TokenReview{
+		Spec: k8sTypesAuthnV1.TokenReviewSpec{Token: token},
+	}, k8sTypesMetaV1.CreateOptions{})
+	if err != nil {
+		return k8sTypesAuthnV1.UserInfo{}, false, fmt.Errorf("TokenReview: %w", err)
+	}
+	return review.Status.User, review.Status.Authenticated, nil
+}
+
+func (k *kubeReviewer) ReviewAccess(ctx context.Context, user k8sTypesAuthnV1.UserInfo, attrs k8sTypesAuthzV1.NonResourceAttributes) (bool, string, error) {
+	extra := make(map[string]k8sTypesAuthzV1.ExtraValue, len(user.Extra))
+	for k, v := range user.Extra {
+		extra[k] = k8sTypesAuthzV1.ExtraValue(v)
+	}
+	review, err := k.accessReviews.Create(ctx, &k8sTypesAuthzV1.SubjectAccessReview{
+		Spec: k8sTypesAuthzV1.SubjectAccessReviewSpec{
+			NonResourceAttributes: &attrs,
+			User:                  user.Username,
+			Groups:                user.Groups,
+			UID:                   user.UID,
+			Extra:                 extra,
+		},
+	}, k8sTypesMetaV1.CreateOptions{})
+	if err != nil {
+		return false, "", fmt.Errorf("SubjectAccessReview: %w", err)
+	}
+	return review.Status.Allowed, review.Status.Reason, nil
+}
+
+// brokenReviewer fails every review, for when there's no API server to ask.
+type brokenReviewer struct {
+	err error
+}
+
+func (b brokenReviewer) ReviewToken(context.Context, string) (k8sTypesAuthnV1.UserInfo, bool, error) {
+	return k8sTypesAuthnV1.UserInfo{}, false, b.err
+}
+
+func (b brokenReviewer) ReviewAccess(context.Context, k8sTypesAuthnV1.UserInfo, k8sTypesAuthzV1.NonResourceAttributes) (bool, string, error) {
+	return false, "", b.err
+}
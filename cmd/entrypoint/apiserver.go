@@ -0,0 +1,34 @@
+package entrypoint
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/acp"
+)
+
+// API server connectivity: the Kubernetes watches tell an acp.APIServerWatcher whether they can
+// reach the API server. Once it's been unreachable for AMBASSADOR_APISERVER_STALE_SECONDS, the
+// readiness check says so, and, with AMBASSADOR_APISERVER_STALE_NOT_READY, fails, so that traffic
+// goes to replicas whose configuration is current instead of to this one.
+
+// GetAPIServerStaleTimeout returns how long the API server can be unreachable before the
+// configuration counts as stale, from AMBASSADOR_APISERVER_STALE_SECONDS.
+func GetAPIServerStaleTimeout() time.Duration {
+	secs, err := strconv.Atoi(env("AMBASSADOR_APISERVER_STALE_SECONDS", "120"))
+	if err != nil || secs < 0 {
+		secs = 120
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// GetAPIServerStaleNotReady returns whether stale configuration makes Ambassador unready, from
+// AMBASSADOR_APISERVER_STALE_NOT_READY. By default it doesn't: stale configuration is usually
+// better than none.
+func GetAPIServerStaleNotReady() bool {
+	return envbool("AMBASSADOR_APISERVER_STALE_NOT_READY")
+}
+
+func newAPIServerWatcher() *acp.APIServerWatcher {
+	return acp.NewAPIServerWatcher(GetAPIServerStaleTimeout(), GetAPIServerStaleNotReady())
+}
@@ -0,0 +1,401 @@
+package entrypoint
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+// Approvals: with AMBASSADOR_REQUIRE_APPROVAL=true, an Ambassador resource labeled
+// getambassador.io/requires-approval=true only goes to diagd once its current generation has been
+// approved. (Resources in getambassador.io/config annotations can't be labeled, so they aren't
+// held back.) That gives regulated environments a two-person rule for edge changes, enforced by the
+// gateway itself: whoever can change a resource can't approve the change, as long as approving
+// takes something they don't have.
+//
+// A generation is approved in one of two ways:
+//
+//   - The getambassador.io/approval annotation, set to <generation>:<approver>:<signature>. The
+//     signature is the HMAC-SHA256, keyed with AMBASSADOR_APPROVAL_KEY, of
+//     "<kind>/<namespace>/<name>/<generation>/<approver>", in unpadded base64url:
+//
+//     printf '%s' Mapping/default/quote/3/alice | openssl dgst -sha256 -hmac "$KEY" -binary | basenc --base64url | tr -d =
+//
+//     Changing an annotation doesn't change a resource's generation, so adding one doesn't need
+//     approving in turn.
+//
+//   - A POST to /ambassador/v0/approvals?kind=<kind>&namespace=<namespace>&name=<name>&generation=<n>.
+//     This needs AMBASSADOR_ADMIN_AUTH=kubernetes, so that who may approve is up to RBAC, and the
+//     approver is whoever made the request. The approved generation goes out with a resync. These
+//     approvals are only remembered until Ambassador restarts; the annotation is what lasts.
+//
+// Until a new generation is approved, the last approved generation of the resource that Ambassador
+// has seen since it started keeps being used, and if there isn't one, the resource is left out.
+// Taking the label off doesn't get around any of this: once a resource has needed approval, it
+// needs it until it's deleted. Deleting a resource isn't held back.
+//
+// A GET to /ambassador/v0/approvals lists the resources waiting for approval.
+const (
+	requiresApprovalLabel = "getambassador.io/requires-approval"
+	approvalAnnotation    = "getambassador.io/approval"
+)
+
+// GetRequireApproval returns whether resources labeled requiresApprovalLabel need approving, from
+// AMBASSADOR_REQUIRE_APPROVAL.
+func GetRequireApproval() bool {
+	enabled, _ := strconv.ParseBool(env("AMBASSADOR_REQUIRE_APPROVAL", "false"))
+	return enabled
+}
+
+// GetApprovalKey returns the key that approval annotations are signed with, from
+// AMBASSADOR_APPROVAL_KEY. Empty means approval annotations can't be checked, so only the admin
+// API can approve anything.
+func GetApprovalKey() string {
+	return env("AMBASSADOR_APPROVAL_KEY", "")
+}
+
+// approvalSignature is the signature that an approval annotation needs.
+func approvalSignature(key []byte, kind, namespace, name string, generation int64, approver string) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s/%s/%s/%d/%s", kind, namespace, name, generation, approver)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+type approvalKey struct {
+	kind      string
+	namespace string
+	name      string
+}
+
+// approval is an approval of one generation of a resource through the admin API.
+type approval struct {
+	Kind       string    `json:"kind"`
+	Namespace  string    `json:"namespace"`
+	Name       string    `json:"name"`
+	Generation int64     `json:"generation"`
+	Approver   string    `json:"approver"`
+	ApprovedAt time.Time `json:"approved_at"`
+}
+
+// pendingApproval is a resource whose current generation is waiting for approval.
+type pendingApproval struct {
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace"`
+	Name       string `json:"name"`
+	Generation int64  `json:"generation"`
+	// UsingGeneration is the approved generation that's being used instead, if there is one.
+	UsingGeneration int64  `json:"using_generation,omitempty"`
+	Reason          string `json:"reason"`
+}
+
+// The approvals struct decides which resources that need approval the watcher may use. Like the
+// devOverrides, it's nil-safe, and it's nil when nothing needs approval.
+type approvals struct {
+	key   []byte
+	clock clock.Clock
+
+	// The mutex protects everything below.
+	mutex sync.Mutex
+	// granted has the approvals made through the admin API.
+	granted map[approvalKey]approval
+	// gated has every resource that has needed approval, with the last approved generation of
+	// it (nil if there hasn't been one).
+	gated   map[approvalKey]*kates.Unstructured
+	pending map[approvalKey]pendingApproval
+}
+
+// newApprovals returns the approvals, or nil if AMBASSADOR_REQUIRE_APPROVAL is off.
+func newApprovals(ctx context.Context, clk clock.Clock) *approvals {
+	if !GetRequireApproval() {
+		return nil
+	}
+	key := GetApprovalKey()
+	if key == "" {
+		dlog.Warnf(ctx, "Approvals: AMBASSADOR_APPROVAL_KEY isn't set, so only the admin API can approve anything")
+	}
+	dlog.Infof(ctx, "Approvals: resources labeled %s=true need their changes approved", requiresApprovalLabel)
+	return &approvals{
+		key:     []byte(key),
+		clock:   clk,
+		granted: map[approvalKey]approval{},
+		gated:   map[approvalKey]*kates.Unstructured{},
+		pending: map[approvalKey]pendingApproval{},
+	}
+}
+
+type approvalsKey struct{}
+
+// withApprovals returns a copy of ctx that carries the approvals.
+func withApprovals(ctx context.Context, a *approvals) context.Context {
+	return context.WithValue(ctx, approvalsKey{}, a)
+}
+
+// approvalsFromContext returns the approvals, or nil if nothing needs approval.
+func approvalsFromContext(ctx context.Context) *approvals {
+	a, _ := ctx.Value(approvalsKey{}).(*approvals)
+	return a
+}
+
+// admit decides whether the watcher may use un as it is. If un needs approval and its generation
+// isn't approved, it's left out, and apply puts the last approved generation, if there is one, in
+// its place.
+func (a *approvals) admit(ctx context.Context, un *kates.Unstructured) bool {
+	if a == nil || un.GroupVersionKind().Group != "getambassador.io" {
+		return true
+	}
+	key := approvalKey{kind: un.GetKind(), namespace: un.GetNamespace(), name: un.GetName()}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	pinned, gated := a.gated[key]
+	if !gated && un.GetLabels()[requiresApprovalLabel] != "true" {
+		return true
+	}
+
+	reason := a.check(key, un)
+	if reason == "" {
+		a.gated[key] = un.DeepCopy()
+		delete(a.pending, key)
+		return true
+	}
+
+	pending := pendingApproval{
+		Kind:       key.kind,
+		Namespace:  key.namespace,
+		Name:       key.name,
+		Generation: un.GetGeneration(),
+		Reason:     reason,
+	}
+	a.gated[key] = pinned
+	if pinned == nil {
+		dlog.Warnf(ctx, "Approvals: leaving out %s %s/%s until generation %d is approved: %s",
+			key.kind, key.namespace, key.name, pending.Generation, reason)
+	} else {
+		pending.UsingGeneration = pinned.GetGeneration()
+		dlog.Warnf(ctx, "Approvals: using generation %d of %s %s/%s until generation %d is approved: %s",
+			pending.UsingGeneration, key.kind, key.namespace, key.name, pending.Generation, reason)
+	}
+	a.pending[key] = pending
+	return false
+}
+
+// apply puts the last approved generation of each resource that admit left out into the snapshot,
+// in place of whatever's there under its name. The accumulator only rebuilds the parts of the
+// snapshot that've changed, so what apply put in last time can still be there.
+func (a *approvals) apply(ctx context.Context, k8sSnapshot *snapshotTypes.KubernetesSnapshot) {
+	if a == nil {
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	snapshot := reflect.ValueOf(k8sSnapshot).Elem()
+	for key := range a.pending {
+		pinned := a.gated[key]
+		if pinned == nil {
+			continue
+		}
+		// The Ambassador resources in the snapshot are named for their kind.
+		field, ok := snapshotFieldForKind(snapshot, key.kind)
+		if !ok {
+			dlog.Errorf(ctx, "Approvals: can't use generation %d of %s %s/%s: the snapshot has no %ss",
+				pinned.GetGeneration(), key.kind, key.namespace, key.name, key.kind)
+			continue
+		}
+		obj := reflect.New(field.Type().Elem().Elem())
+		if err := convertApproved(pinned, obj.Interface()); err != nil {
+			dlog.Errorf(ctx, "Approvals: can't use generation %d of %s %s/%s: %v",
+				pinned.GetGeneration(), key.kind, key.namespace, key.name, err)
+			continue
+		}
+
+		kept := reflect.MakeSlice(field.Type(), 0, field.Len()+1)
+		for i := 0; i < field.Len(); i++ {
+			item, ok := field.Index(i).Interface().(kates.Object)
+			if ok && item.GetNamespace() == key.namespace && item.GetName() == key.name {
+				continue
+			}
+			kept = reflect.Append(kept, field.Index(i))
+		}
+		field.Set(reflect.Append(kept, obj))
+	}
+}
+
+// snapshotFieldForKind returns the field of a KubernetesSnapshot whose JSON name is kind.
+func snapshotFieldForKind(snapshot reflect.Value, kind string) (reflect.Value, bool) {
+	for i := 0; i < snapshot.NumField(); i++ {
+		tag := strings.Split(snapshot.Type().Field(i).Tag.Get("json"), ",")[0]
+		field := snapshot.Field(i)
+		if tag == kind && field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Ptr {
+			return field, true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// convertApproved turns an approved resource into one of the snapshot's types, the same way the
+// accumulator does.
+func convertApproved(un *kates.Unstructured, target interface{}) error {
+	bytes, err := json.Marshal(un)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bytes, target)
+}
+
+// check returns why un's generation isn't approved, or "" if it is. The caller must hold the
+// mutex.
+func (a *approvals) check(key approvalKey, un *kates.Unstructured) string {
+	generation := un.GetGeneration()
+	granted, ok := a.granted[key]
+	if ok && granted.Generation == generation {
+		return ""
+	}
+
+	value := un.GetAnnotations()[approvalAnnotation]
+	if value == "" {
+		if ok {
+			return fmt.Sprintf("generation %d was approved, not %d", granted.Generation, generation)
+		}
+		return "it hasn't been approved"
+	}
+	if len(a.key) == 0 {
+		return fmt.Sprintf("AMBASSADOR_APPROVAL_KEY isn't set, so the %s annotation can't be checked", approvalAnnotation)
+	}
+
+	// The approver is in the middle, since it can have colons of its own (like
+	// "system:serviceaccount:ns:name").
+	genStr, rest, _ := strings.Cut(value, ":")
+	sep := strings.LastIndex(rest, ":")
+	approved, err := strconv.ParseInt(genStr, 10, 64)
+	if err != nil || sep <= 0 {
+		return fmt.Sprintf("the %s annotation isn't <generation>:<approver>:<signature>", approvalAnnotation)
+	}
+	approver, signature := rest[:sep], rest[sep+1:]
+	if approved != generation {
+		return fmt.Sprintf("generation %d was approved, not %d", approved, generation)
+	}
+	expected := approvalSignature(a.key, key.kind, key.namespace, key.name, generation, approver)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return fmt.Sprintf("the %s annotation's signature is wrong", approvalAnnotation)
+	}
+	return ""
+}
+
+// grant approves a generation of a resource through the admin API.
+func (a *approvals) grant(key approvalKey, generation int64, approver string) approval {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	granted := approval{
+		Kind:       key.kind,
+		Namespace:  key.namespace,
+		Name:       key.name,
+		Generation: generation,
+		Approver:   approver,
+		ApprovedAt: a.clock.Now(),
+	}
+	a.granted[key] = granted
+	return granted
+}
+
+// forget forgets a resource that has been deleted.
+func (a *approvals) forget(kind, namespace, name string) {
+	if a == nil {
+		return
+	}
+	key := approvalKey{kind: kind, namespace: namespace, name: name}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	delete(a.granted, key)
+	delete(a.gated, key)
+	delete(a.pending, key)
+}
+
+// Pending returns the resources waiting for approval.
+func (a *approvals) Pending() []pendingApproval {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	pending := make([]pendingApproval, 0, len(a.pending))
+	for _, p := range a.pending {
+		pending = append(pending, p)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		if pending[i].Kind != pending[j].Kind {
+			return pending[i].Kind < pending[j].Kind
+		}
+		if pending[i].Namespace != pending[j].Namespace {
+			return pending[i].Namespace < pending[j].Namespace
+		}
+		return pending[i].Name < pending[j].Name
+	})
+	return pending
+}
+
+// handleApprovals lists, and makes, approvals:
+//
+//	GET  /ambassador/v0/approvals    the resources waiting for approval
+//	POST /ambassador/v0/approvals?kind=<kind>&namespace=<namespace>&name=<name>&generation=<n>
+//
+// A POST answers 202 with the approval, and starts a resync to put the approved generation to use.
+// It needs rbac (whether the admin API authenticates requests), since otherwise anyone could
+// approve anything.
+func handleApprovals(w http.ResponseWriter, r *http.Request, a *approvals, rbac bool, resync *resyncer) {
+	if a == nil {
+		http.Error(w, "nothing needs approval; see AMBASSADOR_REQUIRE_APPROVAL\n", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeApprovalsJSON(w, http.StatusOK, a.Pending())
+	case http.MethodPost:
+		if !rbac {
+			http.Error(w, "approving through the admin API needs AMBASSADOR_ADMIN_AUTH=kubernetes\n", http.StatusForbidden)
+			return
+		}
+		query := r.URL.Query()
+		key := approvalKey{kind: query.Get("kind"), namespace: query.Get("namespace"), name: query.Get("name")}
+		generation, err := strconv.ParseInt(query.Get("generation"), 10, 64)
+		if key.kind == "" || key.namespace == "" || key.name == "" || err != nil || generation <= 0 {
+			http.Error(w, "approvals need a kind, namespace, name, and generation\n", http.StatusBadRequest)
+			return
+		}
+		granted := a.grant(key, generation, requester(r))
+		dlog.Infof(r.Context(), "Approvals: %s approved generation %d of %s %s/%s",
+			granted.Approver, generation, key.kind, key.namespace, key.name)
+		if resync != nil {
+			resync.request(fmt.Sprintf("approval of %s %s/%s", key.kind, key.namespace, key.name))
+		}
+		writeApprovalsJSON(w, http.StatusAccepted, granted)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed\n", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeApprovalsJSON(w http.ResponseWriter, code int, v interface{}) {
+	bytes, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(append(bytes, '\n'))
+}
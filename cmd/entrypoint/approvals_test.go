@@ -0,0 +1,179 @@
+package entrypoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	amb "github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+const testApprovalKey = "sekrit"
+
+func testApprovals(t *testing.T) *approvals {
+	t.Setenv("AMBASSADOR_REQUIRE_APPROVAL", "true")
+	t.Setenv("AMBASSADOR_APPROVAL_KEY", testApprovalKey)
+	a := newApprovals(context.Background(), clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+	require.NotNil(t, a)
+	return a
+}
+
+// testGatedMapping returns generation generation of a Mapping that needs approval.
+func testGatedMapping(generation int64, prefix, annotation string) *kates.Unstructured {
+	un := &kates.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "getambassador.io/v3alpha1",
+		"kind":       "Mapping",
+		"metadata": map[string]interface{}{
+			"name":       "quote",
+			"namespace":  "default",
+			"generation": generation,
+			"labels":     map[string]interface{}{requiresApprovalLabel: "true"},
+		},
+		"spec": map[string]interface{}{"prefix": prefix},
+	}}
+	if annotation != "" {
+		un.SetAnnotations(map[string]string{approvalAnnotation: annotation})
+	}
+	return un
+}
+
+func signedApproval(generation int64, approver string) string {
+	signature := approvalSignature([]byte(testApprovalKey), "Mapping", "default", "quote", generation, approver)
+	return fmt.Sprintf("%d:%s:%s", generation, approver, signature)
+}
+
+// appliedPrefixes returns the prefixes of the Mappings that apply leaves in a snapshot that has
+// mappings in it.
+func appliedPrefixes(a *approvals, mappings ...string) []string {
+	k8sSnapshot := &snapshotTypes.KubernetesSnapshot{}
+	for _, prefix := range mappings {
+		mapping := &amb.Mapping{ObjectMeta: kates.ObjectMeta{Namespace: "default", Name: "quote"}}
+		mapping.Spec.Prefix = prefix
+		k8sSnapshot.Mappings = append(k8sSnapshot.Mappings, mapping)
+	}
+	a.apply(context.Background(), k8sSnapshot)
+	prefixes := []string{}
+	for _, mapping := range k8sSnapshot.Mappings {
+		prefixes = append(prefixes, mapping.Spec.Prefix)
+	}
+	return prefixes
+}
+
+func TestApprovalsOff(t *testing.T) {
+	t.Setenv("AMBASSADOR_REQUIRE_APPROVAL", "false")
+	a := newApprovals(context.Background(), clock.NewFake(time.Now()))
+	assert.Nil(t, a)
+	assert.True(t, a.admit(context.Background(), testGatedMapping(1, "/quote/", "")))
+	assert.Equal(t, []string{"/quote/"}, appliedPrefixes(a, "/quote/"))
+}
+
+func TestApprovalsAnnotation(t *testing.T) {
+	ctx := context.Background()
+	a := testApprovals(t)
+
+	// Nothing approved yet, so it's left out.
+	assert.False(t, a.admit(ctx, testGatedMapping(1, "/quote/", "")))
+	require.Len(t, a.Pending(), 1)
+	assert.Equal(t, "it hasn't been approved", a.Pending()[0].Reason)
+	assert.Equal(t, []string{}, appliedPrefixes(a))
+
+	// Approved, so it goes out.
+	assert.True(t, a.admit(ctx, testGatedMapping(1, "/quote/", signedApproval(1, "system:serviceaccount:sec:alice"))))
+	assert.Empty(t, a.Pending())
+	assert.Equal(t, []string{"/quote/"}, appliedPrefixes(a, "/quote/"))
+
+	// A change that isn't approved gets the approved generation instead, whatever the annotation
+	// says. That replaces what the accumulator left in the snapshot last time, too.
+	for _, annotation := range []string{
+		"",
+		signedApproval(1, "alice"),
+		"2:alice:forged",
+		"2:alice",
+		signedApproval(2, "alice")[:len(signedApproval(2, "alice"))-1],
+	} {
+		assert.False(t, a.admit(ctx, testGatedMapping(2, "/evil/", annotation)), annotation)
+		assert.Equal(t, []string{"/quote/"}, appliedPrefixes(a), annotation)
+		assert.Equal(t, []string{"/quote/"}, appliedPrefixes(a, "/quote/"), annotation)
+		require.Len(t, a.Pending(), 1, annotation)
+		assert.EqualValues(t, 2, a.Pending()[0].Generation, annotation)
+		assert.EqualValues(t, 1, a.Pending()[0].UsingGeneration, annotation)
+	}
+
+	// Taking the label off doesn't help.
+	un := testGatedMapping(2, "/evil/", "")
+	un.SetLabels(nil)
+	assert.False(t, a.admit(ctx, un))
+
+	// Once it's deleted, it's forgotten.
+	a.forget("Mapping", "default", "quote")
+	assert.Empty(t, a.Pending())
+	assert.Equal(t, []string{}, appliedPrefixes(a))
+	assert.True(t, a.admit(ctx, un))
+
+	// Resources that aren't Ambassador's, or don't need approval, go out as they are.
+	un = testGatedMapping(1, "/quote/", "")
+	un.SetAPIVersion("v1")
+	assert.True(t, a.admit(ctx, un))
+	un = testGatedMapping(1, "/other/", "")
+	un.SetName("other")
+	un.SetLabels(map[string]string{requiresApprovalLabel: "false"})
+	assert.True(t, a.admit(ctx, un))
+}
+
+func TestHandleApprovals(t *testing.T) {
+	ctx := context.Background()
+	a := testApprovals(t)
+	resync := newResyncer(clock.NewFake(time.Now()))
+
+	assert.False(t, a.admit(ctx, testGatedMapping(3, "/quote/", "")))
+
+	w := httptest.NewRecorder()
+	handleApprovals(w, httptest.NewRequest(http.MethodGet, "/ambassador/v0/approvals", nil), a, true, resync)
+	require.Equal(t, http.StatusOK, w.Code)
+	var pending []pendingApproval
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &pending))
+	assert.Equal(t, []pendingApproval{{
+		Kind: "Mapping", Namespace: "default", Name: "quote", Generation: 3, Reason: "it hasn't been approved",
+	}}, pending)
+
+	// Without RBAC, anyone could approve anything.
+	approve := "/ambassador/v0/approvals?kind=Mapping&namespace=default&name=quote&generation=3"
+	w = httptest.NewRecorder()
+	handleApprovals(w, httptest.NewRequest(http.MethodPost, approve, nil), a, false, resync)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	handleApprovals(w, httptest.NewRequest(http.MethodPost, "/ambassador/v0/approvals?kind=Mapping", nil), a, true, resync)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	handleApprovals(w, httptest.NewRequest(http.MethodPost, approve, nil), a, true, resync)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var granted approval
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &granted))
+	assert.EqualValues(t, 3, granted.Generation)
+	assert.NotEmpty(t, granted.Approver)
+
+	// The approval starts a resync, which brings the resource back.
+	select {
+	case <-resync.requested():
+	default:
+		t.Error("approving didn't start a resync")
+	}
+	assert.True(t, a.admit(ctx, testGatedMapping(3, "/quote/", "")))
+	assert.Empty(t, a.Pending())
+
+	// But not the next generation.
+	assert.False(t, a.admit(ctx, testGatedMapping(4, "/evil/", "")))
+	assert.Equal(t, []string{"/quote/"}, appliedPrefixes(a))
+}
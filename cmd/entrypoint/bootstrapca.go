@@ -0,0 +1,282 @@
+package entrypoint
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/ambex"
+	"github.com/emissary-ingress/emissary/v3/pkg/bootstrapca"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+)
+
+// The bootstrap CA:
+//
+// With AMBASSADOR_BOOTSTRAP_CA set, Emissary runs its own small CA (see pkg/bootstrapca), so that
+// it can do TLS from the very start without any PKI of its own. The CA lives in the Secret
+// bootstrapCASecretName in the Ambassador namespace, so every replica shares it; its certificate
+// alone is also exported in bootstrapCACertSecretName, for clients that need to trust it. The CA
+// mints:
+//
+//   - The fallback Host's certificate. It's posted as the "fallback-self-signed-cert" Secret, which
+//     takes the same path into the snapshot as Istio certs do, and wins over any Kubernetes Secret
+//     of the same name.
+//   - Certificates for both ends of the xDS connection between Envoy and ambex, which then runs
+//     over mutual TLS instead of cleartext. They're written to GetBootstrapCADir().
+//
+// Leaf certificates are renewed two thirds of the way through their lives. The exception is
+// Envoy's xDS client certificate, which Envoy only reads when it starts: it's issued fresh at
+// startup, for as long as the CA lasts, and it's only good for talking to ambex in the same pod.
+//
+// Envoy's admin interface can't do TLS at all, so it stays on loopback. The CRD conversion
+// webhook (cmd/apiext) keeps its own CA, in the emissary-ingress-webhook-ca Secret of whichever
+// namespace it runs in.
+//
+// The CA itself is never rotated: it's good for bootstrapca.CAValidity, and replacing it means
+// deleting its Secret and restarting every replica.
+//
+// If the CA Secret can't be read or created (no RBAC for Secrets, say), the CA only lasts as long
+// as the pod does: everything still works, but clients can't usefully trust it. The Helm chart's
+// bootstrapCA.enabled sets AMBASSADOR_BOOTSTRAP_CA and grants the RBAC for both Secrets.
+const (
+	bootstrapCASecretName     = "emissary-bootstrap-ca"
+	bootstrapCACertSecretName = "emissary-bootstrap-ca-cert"
+	bootstrapCAOrganization   = "Emissary-ingress"
+	fallbackCertSecretName    = "fallback-self-signed-cert"
+
+	// bootstrapCACheckInterval is how often to check whether certificates need renewing.
+	bootstrapCACheckInterval = time.Hour
+)
+
+type bootstrapCA struct {
+	ca        *bootstrapca.CA
+	dir       string
+	namespace string
+	clock     clock.Clock
+
+	fallbackCert []byte
+}
+
+type bootstrapCAKey struct{}
+
+// withBootstrapCA returns a copy of ctx that carries the bootstrap CA.
+func withBootstrapCA(ctx context.Context, b *bootstrapCA) context.Context {
+	return context.WithValue(ctx, bootstrapCAKey{}, b)
+}
+
+// bootstrapCAFromContext returns the bootstrap CA, or nil if it isn't running.
+func bootstrapCAFromContext(ctx context.Context) *bootstrapCA {
+	b, _ := ctx.Value(bootstrapCAKey{}).(*bootstrapCA)
+	return b
+}
+
+// setupBootstrapCA loads (or creates) the bootstrap CA, and writes out the xDS certificates, so
+// that they're there before ambex and Envoy start.
+func setupBootstrapCA(ctx context.Context) (*bootstrapCA, error) {
+	namespace := GetAmbassadorNamespace()
+	ca, err := ensureBootstrapCA(ctx, namespace)
+	if err != nil {
+		dlog.Errorf(ctx, "Bootstrap CA: using a CA that only lasts as long as this pod: %v", err)
+		if ca, err = bootstrapca.New(bootstrapCAOrganization, clock.FromContext(ctx).Now()); err != nil {
+			return nil, err
+		}
+	}
+	b := &bootstrapCA{
+		ca:        ca,
+		dir:       GetBootstrapCADir(),
+		namespace: namespace,
+		clock:     clock.FromContext(ctx),
+	}
+	if err := ensureDir(b.dir); err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(filepath.Join(b.dir, ambex.ADSTLSCAFile), ca.CertPEM()); err != nil {
+		return nil, err
+	}
+	clientCert, clientKey, err := ca.Issue(bootstrapca.LeafSpec{
+		CommonName: "envoy",
+		Client:     true,
+		Validity:   bootstrapca.CAValidity,
+	}, b.clock.Now())
+	if err != nil {
+		return nil, err
+	}
+	if err := writeKeyPair(b.dir, ambex.ADSTLSClientCertFile, clientCert, ambex.ADSTLSClientKeyFile, clientKey); err != nil {
+		return nil, err
+	}
+	// Whatever server certificate is left over from before might be from a different CA.
+	_ = os.Remove(filepath.Join(b.dir, ambex.ADSTLSServerCertFile))
+	if err := b.renewXDSServerCert(ctx); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// ensureBootstrapCA makes sure that the CA Secret exists (creating it if it doesn't), exports its
+// certificate, and returns the CA.
+func ensureBootstrapCA(ctx context.Context, namespace string) (*bootstrapca.CA, error) {
+	client, err := kates.NewClient(kates.ClientConfig{})
+	if err != nil {
+		return nil, err
+	}
+
+	var ca *bootstrapca.CA
+	for ca == nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		// Does it already exist?
+		secret := &kates.Secret{
+			TypeMeta:   kates.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: kates.ObjectMeta{Name: bootstrapCASecretName, Namespace: namespace},
+		}
+		err := client.Get(ctx, secret, secret)
+		if err == nil {
+			if ca, err = bootstrapca.Parse(secret.Data["tls.crt"], secret.Data["tls.key"]); err != nil {
+				return nil, err
+			}
+			break
+		}
+		if !kates.IsNotFound(err) {
+			return nil, err
+		}
+
+		// Try to create it.
+		newCA, err := bootstrapca.New(bootstrapCAOrganization, clock.FromContext(ctx).Now())
+		if err != nil {
+			return nil, err
+		}
+		secret.Type = kates.SecretTypeTLS
+		markGenerated(secret, "bootstrap-ca")
+		secret.Data = map[string][]byte{
+			"tls.crt": newCA.CertPEM(),
+			"tls.key": newCA.KeyPEM(),
+		}
+		err = client.Create(ctx, secret, nil)
+		if err == nil {
+			dlog.Infof(ctx, "Bootstrap CA: created Secret %s.%s", bootstrapCASecretName, namespace)
+			ca = newCA
+			break
+		}
+		if !kates.IsAlreadyExists(err) {
+			return nil, err
+		}
+
+		// Another replica beat us to it. Loop around, and use theirs.
+	}
+
+	// The CA's certificate is public, and it's what clients need to trust us.
+	export := &kates.Secret{
+		TypeMeta:   kates.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: kates.ObjectMeta{Name: bootstrapCACertSecretName, Namespace: namespace},
+		Data:       map[string][]byte{"ca.crt": ca.CertPEM()},
+	}
+	markGenerated(export, "bootstrap-ca")
+	if err := client.Upsert(ctx, export, export, nil); err != nil {
+		dlog.Errorf(ctx, "Bootstrap CA: exporting the CA certificate in Secret %s.%s: %v", bootstrapCACertSecretName, namespace, err)
+	}
+	return ca, nil
+}
+
+// renewXDSServerCert issues ambex a new xDS server certificate, if it needs one. ambex reads it
+// afresh for every connection, so there's nothing else to do.
+func (b *bootstrapCA) renewXDSServerCert(ctx context.Context) error {
+	certFile := filepath.Join(b.dir, ambex.ADSTLSServerCertFile)
+	current, _ := os.ReadFile(certFile)
+	if !bootstrapca.NeedsRenewal(current, b.clock.Now()) {
+		return nil
+	}
+	cert, key, err := b.ca.Issue(bootstrapca.LeafSpec{
+		CommonName: "ambex",
+		DNSNames:   []string{"localhost"},
+		IPs:        []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		Server:     true,
+	}, b.clock.Now())
+	if err != nil {
+		return err
+	}
+	dlog.Infof(ctx, "Bootstrap CA: issued a new xDS server certificate")
+	return writeKeyPair(b.dir, ambex.ADSTLSServerCertFile, cert, ambex.ADSTLSServerKeyFile, key)
+}
+
+// fallbackSecret returns a new certificate for the fallback Host, as a Secret, if it needs one.
+// Otherwise it returns nil.
+func (b *bootstrapCA) fallbackSecret() (*kates.Secret, error) {
+	if !bootstrapca.NeedsRenewal(b.fallbackCert, b.clock.Now()) {
+		return nil, nil
+	}
+	cert, key, err := b.ca.Issue(bootstrapca.LeafSpec{
+		CommonName: bootstrapCAOrganization,
+		DNSNames:   []string{"localhost"},
+		Server:     true,
+	}, b.clock.Now())
+	if err != nil {
+		return nil, err
+	}
+	b.fallbackCert = cert
+	return &kates.Secret{
+		TypeMeta:   kates.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: kates.ObjectMeta{Name: fallbackCertSecretName, Namespace: b.namespace},
+		Type:       kates.SecretTypeTLS,
+		Data: map[string][]byte{
+			"tls.crt": cert,
+			"tls.key": key,
+		},
+	}, nil
+}
+
+// watch posts the fallback Host's certificate to updates, and keeps it and the xDS server
+// certificate renewed, until ctx is canceled.
+//
+// XXX This rides on IstioCertUpdate, which is really "a Secret that didn't come from Kubernetes".
+func (b *bootstrapCA) watch(ctx context.Context, updates chan<- IstioCertUpdate) {
+	ticker := b.clock.NewTicker(bootstrapCACheckInterval)
+	defer ticker.Stop()
+	for {
+		secret, err := b.fallbackSecret()
+		if err != nil {
+			dlog.Errorf(ctx, "Bootstrap CA: issuing the fallback certificate: %v", err)
+		} else if secret != nil {
+			dlog.Infof(ctx, "Bootstrap CA: issued a new fallback certificate")
+			select {
+			case updates <- IstioCertUpdate{Op: "update", Name: secret.Name, Namespace: secret.Namespace, Secret: secret}:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err := b.renewXDSServerCert(ctx); err != nil {
+			dlog.Errorf(ctx, "Bootstrap CA: renewing the xDS server certificate: %v", err)
+		}
+
+		select {
+		case <-ticker.C():
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// writeKeyPair writes a certificate and its key. Somebody reading them just as they change can get
+// a new key with the old certificate, and fail; ambex only reads them for new connections, and
+// Envoy retries those.
+func writeKeyPair(dir, certName string, cert []byte, keyName string, key []byte) error {
+	if err := writeFileAtomic(filepath.Join(dir, keyName), key); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, certName), cert)
+}
+
+// writeFileAtomic writes a file that only we can read, such that readers see either all of the old
+// contents or all of the new ones.
+func writeFileAtomic(name string, data []byte) error {
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
@@ -0,0 +1,60 @@
+package entrypoint
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/ambex"
+	"github.com/emissary-ingress/emissary/v3/pkg/bootstrapca"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+)
+
+func TestBootstrapCARenewal(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	ca, err := bootstrapca.New(bootstrapCAOrganization, now)
+	require.NoError(t, err)
+	b := &bootstrapCA{
+		ca:        ca,
+		dir:       t.TempDir(),
+		namespace: "ambassador",
+		clock:     clock.NowFunc(func() time.Time { return now }),
+	}
+
+	secret, err := b.fallbackSecret()
+	require.NoError(t, err)
+	require.NotNil(t, secret)
+	assert.Equal(t, fallbackCertSecretName, secret.Name)
+	assert.Equal(t, "ambassador", secret.Namespace)
+
+	require.NoError(t, b.renewXDSServerCert(ctx))
+	serverCert, err := os.ReadFile(filepath.Join(b.dir, ambex.ADSTLSServerCertFile))
+	require.NoError(t, err)
+
+	// Nothing needs renewing for a while...
+	now = now.Add(30 * 24 * time.Hour)
+	secret, err = b.fallbackSecret()
+	require.NoError(t, err)
+	assert.Nil(t, secret)
+	require.NoError(t, b.renewXDSServerCert(ctx))
+	unchanged, err := os.ReadFile(filepath.Join(b.dir, ambex.ADSTLSServerCertFile))
+	require.NoError(t, err)
+	assert.Equal(t, serverCert, unchanged)
+
+	// ...until two thirds of the way through.
+	now = now.Add(31 * 24 * time.Hour)
+	secret, err = b.fallbackSecret()
+	require.NoError(t, err)
+	assert.NotNil(t, secret)
+	require.NoError(t, b.renewXDSServerCert(ctx))
+	renewed, err := os.ReadFile(filepath.Join(b.dir, ambex.ADSTLSServerCertFile))
+	require.NoError(t, err)
+	assert.NotEqual(t, serverCert, renewed)
+}
@@ -0,0 +1,162 @@
+package entrypoint
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/acp"
+	amb "github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
+	"github.com/emissary-ingress/emissary/v3/pkg/capture"
+	"github.com/emissary-ingress/emissary/v3/pkg/featuregate"
+	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+// handleCapture drives the capturer:
+//
+//	POST   /ambassador/v0/capture?mapping=<namespace>/<name>  start capturing a Mapping's traffic
+//	GET    /ambassador/v0/capture                             the capture's status and traces
+//	DELETE /ambassador/v0/capture                             stop capturing
+//
+// POST also takes sample_rate, max_body_bytes, max_traces, duration (e.g. "30s"), and redact (a
+// comma-separated list of headers to redact on top of the defaults). Captures have real traffic in
+// them, so they're only available from localhost (e.g. through kubectl port-forward).
+//
+// ctx outlives the request, and is what a capture runs under.
+func handleCapture(ctx context.Context, w http.ResponseWriter, r *http.Request, capturer *capture.Capturer, snapshot *atomic.Value) {
+	if !acp.HostPortIsLocal(r.RemoteAddr) {
+		http.Error(w, "captures are only available from localhost\n", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		status := capturer.Status()
+		if status == nil {
+			http.Error(w, "no capture has been started\n", http.StatusNotFound)
+			return
+		}
+		writeCaptureStatus(w, http.StatusOK, status)
+	case http.MethodDelete:
+		capturer.Stop()
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPost:
+		if !featuregate.FromContext(ctx).Enabled(featuregate.TrafficCapture) {
+			http.Error(w, "the TrafficCapture feature gate is off, so Envoy has no tap filter to capture with\n", http.StatusServiceUnavailable)
+			return
+		}
+		spec, err := parseCaptureSpec(r.URL.Query(), snapshot)
+		if err != nil {
+			http.Error(w, err.Error()+"\n", http.StatusBadRequest)
+			return
+		}
+		if err := capturer.Start(ctx, spec); err != nil {
+			code := http.StatusBadRequest
+			if errors.Is(err, capture.ErrRunning) {
+				code = http.StatusConflict
+			}
+			http.Error(w, err.Error()+"\n", code)
+			return
+		}
+		writeCaptureStatus(w, http.StatusAccepted, capturer.Status())
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed\n", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeCaptureStatus(w http.ResponseWriter, code int, status *capture.Status) {
+	bytes, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(append(bytes, '\n'))
+}
+
+// parseCaptureSpec builds a capture.Spec from the query of a POST, matching the Mapping that it
+// names in the most recent snapshot.
+func parseCaptureSpec(query url.Values, snapshot *atomic.Value) (capture.Spec, error) {
+	var spec capture.Spec
+
+	namespace, name, ok := strings.Cut(query.Get("mapping"), "/")
+	if !ok {
+		return spec, errors.New("mapping must be <namespace>/<name>")
+	}
+	mapping := findSnapshotMapping(snapshot, namespace, name)
+	if mapping == nil {
+		return spec, fmt.Errorf("no Mapping %s/%s", namespace, name)
+	}
+	spec.Mapping = namespace + "/" + name
+	spec.Prefix = mapping.Spec.Prefix
+	spec.PrefixRegex = mapping.Spec.PrefixRegex != nil && *mapping.Spec.PrefixRegex
+	spec.Hostname = mapping.Spec.Hostname
+	if spec.Hostname == "" && (mapping.Spec.DeprecatedHostRegex == nil || !*mapping.Spec.DeprecatedHostRegex) {
+		spec.Hostname = mapping.Spec.DeprecatedHost
+	}
+	spec.Method = mapping.Spec.Method
+	spec.MethodRegex = mapping.Spec.MethodRegex != nil && *mapping.Spec.MethodRegex
+
+	if v := query.Get("sample_rate"); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return spec, fmt.Errorf("sample_rate: %w", err)
+		}
+		spec.SampleRate = rate
+	}
+	if v := query.Get("max_body_bytes"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return spec, fmt.Errorf("max_body_bytes: %w", err)
+		}
+		spec.MaxBodyBytes = uint32(n)
+	}
+	if v := query.Get("max_traces"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return spec, fmt.Errorf("max_traces: %w", err)
+		}
+		spec.MaxTraces = n
+	}
+	if v := query.Get("duration"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return spec, fmt.Errorf("duration: %w", err)
+		}
+		spec.Duration = d
+	}
+	for _, h := range strings.Split(query.Get("redact"), ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			spec.RedactHeaders = append(spec.RedactHeaders, h)
+		}
+	}
+
+	return spec, nil
+}
+
+// findSnapshotMapping finds a Mapping in the most recent snapshot.
+func findSnapshotMapping(snapshot *atomic.Value, namespace, name string) *amb.Mapping {
+	raw, _ := snapshot.Load().([]byte)
+	if raw == nil {
+		return nil
+	}
+	var snap snapshotTypes.Snapshot
+	if err := json.Unmarshal(raw, &snap); err != nil || snap.Kubernetes == nil {
+		return nil
+	}
+	for _, m := range snap.Kubernetes.Mappings {
+		if m.GetNamespace() == namespace && m.GetName() == name {
+			return m
+		}
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package entrypoint
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/changewindow"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+	"github.com/emissary-ingress/emissary/v3/pkg/debug"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	"github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+// Change windows:
+//
+// With AMBASSADOR_CHANGE_WINDOWS set, configuration changes only go to diagd (and so to Envoy)
+// while a change window is open. Outside one, the watcher keeps watching but holds on to the
+// changes, and sends everything that has piled up when the next window opens. Endpoint changes
+// aren't held: they go to ambex directly, so traffic keeps going to wherever the pods are.
+//
+// An emergency change can go out anyway: annotate any Ambassador resource with
+// changeWindowOverrideAnnotation, set to an RFC 3339 time at most maxChangeWindowOverride away.
+// Until then, changes go out as they happen. Everything that was being held goes out with the
+// emergency change, not just the change to the annotated resource.
+//
+// The very first configuration always goes out, wherever it falls: holding it would leave Envoy
+// with no configuration at all.
+const (
+	changeWindowOverrideAnnotation = "getambassador.io/change-window-override-until"
+	maxChangeWindowOverride        = 24 * time.Hour
+)
+
+type changeWindows struct {
+	schedule *changewindow.Schedule
+	clock    clock.Clock
+	info     *atomic.Value
+
+	// opened gets a value whenever a window opens, so that the watcher can send what it's holding.
+	opened chan struct{}
+
+	held      int
+	heldSince time.Time
+}
+
+// changeWindowsInfo is what /debug reports about change windows.
+type changeWindowsInfo struct {
+	Schedule  string    `json:"schedule"`
+	Held      int       `json:"held"`
+	HeldSince time.Time `json:"heldSince,omitempty"`
+	NextOpen  time.Time `json:"nextOpen,omitempty"`
+}
+
+// newChangeWindows returns the change windows configured in the environment, or nil if there
+// aren't any. A bad schedule is logged, and ignored: better to keep configuring Envoy than to stop.
+func newChangeWindows(ctx context.Context, clk clock.Clock) *changeWindows {
+	schedule, err := GetChangeWindows()
+	if err != nil {
+		dlog.Errorf(ctx, "Ignoring AMBASSADOR_CHANGE_WINDOWS: %v", err)
+		return nil
+	}
+	if schedule == nil {
+		return nil
+	}
+	dlog.Infof(ctx, "Configuration changes will only go out in the change windows %q", schedule)
+	return &changeWindows{
+		schedule: schedule,
+		clock:    clk,
+		info:     debug.FromContext(ctx).Value("changeWindows"),
+		opened:   make(chan struct{}, 1),
+	}
+}
+
+// openedCh returns a channel that gets a value whenever a window opens. It's nil if there are no
+// change windows, so it never does.
+func (cw *changeWindows) openedCh() <-chan struct{} {
+	if cw == nil {
+		return nil
+	}
+	return cw.opened
+}
+
+// run wakes up the watcher whenever a window opens, until the context is done.
+func (cw *changeWindows) run(ctx context.Context) error {
+	if cw == nil {
+		return nil
+	}
+	for {
+		next := cw.schedule.NextOpen(cw.clock.Now())
+		if next.IsZero() {
+			dlog.Warnf(ctx, "No change window opens in the next year of %q", cw.schedule)
+			<-ctx.Done()
+			return nil
+		}
+		timer := cw.clock.NewTimer(clock.Until(cw.clock, next))
+		select {
+		case <-timer.C():
+			if cw.clock.Now().Before(next) {
+				// Not yet: we only woke up to check the wall clock (see clock.Until).
+				continue
+			}
+			select {
+			case cw.opened <- struct{}{}:
+			default:
+			}
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		}
+	}
+}
+
+// allow returns whether a configuration change can go out now. s is the snapshot that it would go
+// out in.
+func (cw *changeWindows) allow(ctx context.Context, s *snapshot.KubernetesSnapshot) bool {
+	if cw == nil {
+		return true
+	}
+	now := cw.clock.Now()
+
+	reason := ""
+	if cw.schedule.Contains(now) {
+		reason = "in a change window"
+	} else if until, source := changeWindowOverride(ctx, s, now); !until.IsZero() {
+		reason = fmt.Sprintf("%s overrides the change windows until %s", source, until.Format(time.RFC3339))
+	}
+
+	if reason != "" {
+		if cw.held > 0 {
+			dlog.Infof(ctx, "Sending the %d configuration changes held since %s: %s",
+				cw.held, cw.heldSince.Format(time.RFC3339), reason)
+		}
+		cw.held = 0
+		cw.heldSince = time.Time{}
+		cw.info.Store(changeWindowsInfo{Schedule: cw.schedule.String()})
+		return true
+	}
+
+	if cw.held == 0 {
+		cw.heldSince = now
+	}
+	cw.held++
+	next := cw.schedule.NextOpen(now)
+	dlog.Infof(ctx, "Holding configuration change outside the change windows until %s", next.Format(time.RFC3339))
+	cw.info.Store(changeWindowsInfo{
+		Schedule:  cw.schedule.String(),
+		Held:      cw.held,
+		HeldSince: cw.heldSince,
+		NextOpen:  next,
+	})
+	return false
+}
+
+// changeWindowOverride looks for a live override annotation in the snapshot, and returns when it
+// runs out and which resource it's on. The zero Time means there isn't one.
+func changeWindowOverride(ctx context.Context, s *snapshot.KubernetesSnapshot, now time.Time) (time.Time, string) {
+	var latest time.Time
+	var source string
+	for _, obj := range ambassadorObjects(s) {
+		value, ok := obj.GetAnnotations()[changeWindowOverrideAnnotation]
+		if !ok {
+			continue
+		}
+		kind := obj.GetObjectKind().GroupVersionKind().Kind
+		until, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			dlog.Warnf(ctx, "%s %s.%s: ignoring %s: %v", kind, obj.GetName(), obj.GetNamespace(), changeWindowOverrideAnnotation, err)
+			continue
+		}
+		if until.Sub(now) > maxChangeWindowOverride {
+			dlog.Warnf(ctx, "%s %s.%s: ignoring %s: %s is more than %s away",
+				kind, obj.GetName(), obj.GetNamespace(), changeWindowOverrideAnnotation, value, maxChangeWindowOverride)
+			continue
+		}
+		if until.After(now) && until.After(latest) {
+			latest = until
+			source = fmt.Sprintf("%s %s.%s", kind, obj.GetName(), obj.GetNamespace())
+		}
+	}
+	return latest, source
+}
+
+// ambassadorObjects returns all of the Ambassador resources in the snapshot.
+func ambassadorObjects(s *snapshot.KubernetesSnapshot) []kates.Object {
+	var objs []kates.Object
+	for _, o := range s.Listeners {
+		objs = append(objs, o)
+	}
+	for _, o := range s.Hosts {
+		objs = append(objs, o)
+	}
+	for _, o := range s.Mappings {
+		objs = append(objs, o)
+	}
+	for _, o := range s.TCPMappings {
+		objs = append(objs, o)
+	}
+	for _, o := range s.Modules {
+		objs = append(objs, o)
+	}
+	for _, o := range s.TLSContexts {
+		objs = append(objs, o)
+	}
+	for _, o := range s.AuthServices {
+		objs = append(objs, o)
+	}
+	for _, o := range s.RateLimitServices {
+		objs = append(objs, o)
+	}
+	for _, o := range s.LogServices {
+		objs = append(objs, o)
+	}
+	for _, o := range s.TracingServices {
+		objs = append(objs, o)
+	}
+	return objs
+}
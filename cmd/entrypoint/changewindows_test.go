@@ -0,0 +1,110 @@
+package entrypoint
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	amb "github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
+	"github.com/emissary-ingress/emissary/v3/pkg/changewindow"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+	"github.com/emissary-ingress/emissary/v3/pkg/debug"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	"github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+func TestChangeWindows(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+
+	// Open from 02:00 to 06:00 on Saturdays. 2026-10-16 is a Friday.
+	schedule, err := changewindow.Parse("0 2 * * 6 4h", time.UTC)
+	require.NoError(t, err)
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	cw := &changeWindows{
+		schedule: schedule,
+		clock:    clock.NowFunc(func() time.Time { return now }),
+		info:     debug.NewDebug().Value("changeWindows"),
+		opened:   make(chan struct{}, 1),
+	}
+
+	mapping := &amb.Mapping{
+		TypeMeta:   kates.TypeMeta{Kind: "Mapping"},
+		ObjectMeta: kates.ObjectMeta{Namespace: "default", Name: "hotfix"},
+	}
+	s := &snapshot.KubernetesSnapshot{Mappings: []*amb.Mapping{mapping}}
+
+	assert.False(t, cw.allow(ctx, s))
+	assert.False(t, cw.allow(ctx, s))
+	assert.Equal(t, 2, cw.held)
+
+	// Overrides that are too far out, or already over, or garbage, don't count.
+	for _, until := range []string{"2026-10-18T12:00:00Z", "2026-10-16T11:00:00Z", "tomorrow"} {
+		mapping.Annotations = map[string]string{changeWindowOverrideAnnotation: until}
+		assert.False(t, cw.allow(ctx, s), until)
+	}
+
+	mapping.Annotations = map[string]string{changeWindowOverrideAnnotation: "2026-10-16T14:00:00Z"}
+	assert.True(t, cw.allow(ctx, s))
+	assert.Equal(t, 0, cw.held)
+
+	until, source := changeWindowOverride(ctx, s, now)
+	assert.Equal(t, time.Date(2026, 10, 16, 14, 0, 0, 0, time.UTC), until)
+	assert.Equal(t, "Mapping hotfix.default", source)
+
+	// Once the override runs out, changes are held again until the window opens.
+	now = time.Date(2026, 10, 16, 14, 0, 0, 0, time.UTC)
+	assert.False(t, cw.allow(ctx, s))
+	now = time.Date(2026, 10, 17, 2, 0, 0, 0, time.UTC)
+	assert.True(t, cw.allow(ctx, s))
+
+	// No change windows means no holding.
+	var none *changeWindows
+	assert.True(t, none.allow(ctx, s))
+	assert.Nil(t, none.openedCh())
+}
+
+func TestChangeWindowsRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	// Open from 02:00 to 06:00 on Saturdays. 2026-10-16 is a Friday.
+	schedule, err := changewindow.Parse("0 2 * * 6 4h", time.UTC)
+	require.NoError(t, err)
+	fake := clock.NewFake(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	cw := &changeWindows{
+		schedule: schedule,
+		clock:    fake,
+		info:     debug.NewDebug().Value("changeWindows"),
+		opened:   make(chan struct{}, 1),
+	}
+	done := make(chan error)
+	go func() {
+		done <- cw.run(ctx)
+	}()
+	waitForTimer := func() {
+		require.Eventually(t, func() bool { return fake.Timers() == 1 }, 5*time.Second, time.Millisecond)
+	}
+
+	// The window is hours away, but run checks the wall clock every clock.MaxWait.
+	waitForTimer()
+	fake.Advance(clock.MaxWait)
+	waitForTimer()
+	assert.Empty(t, cw.opened)
+
+	// A VM that's suspended until after the window opens finds out within clock.MaxWait of waking
+	// up, even though its monotonic clock didn't move.
+	fake.Jump(time.Date(2026, 10, 17, 2, 30, 0, 0, time.UTC))
+	fake.Advance(clock.MaxWait)
+	select {
+	case <-cw.opened:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the change window didn't open")
+	}
+
+	cancel()
+	assert.NoError(t, <-done)
+}
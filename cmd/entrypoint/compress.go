@@ -0,0 +1,163 @@
+package entrypoint
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Compression for the endpoints that serve big payloads: the snapshot servers, and diagd (config
+// dumps, metrics) behind the health check server. A snapshot can run to hundreds of megabytes of
+// JSON, which takes long enough to fetch over the pod network that tooling times out, and JSON
+// shrinks a lot under gzip.
+//
+// compressHandler buffers the whole response, so that it can give it an ETag (a hash of the
+// uncompressed body) and answer a matching If-None-Match with a 304 instead of sending it all
+// again. Responses of at least AMBASSADOR_ADMIN_COMPRESSION_MIN_BYTES get gzipped for clients that
+// ask for it. Only successful GETs and HEADs are touched; everything else goes straight through.
+
+// GetAdminCompressionMinBytes returns how big a response has to be before it's compressed, from
+// AMBASSADOR_ADMIN_COMPRESSION_MIN_BYTES.
+func GetAdminCompressionMinBytes() int {
+	minBytes, err := strconv.Atoi(env("AMBASSADOR_ADMIN_COMPRESSION_MIN_BYTES", "1024"))
+	if err != nil || minBytes < 0 {
+		minBytes = 1024
+	}
+	return minBytes
+}
+
+// compressHandler wraps next with ETags and gzip; see above.
+func compressHandler(next http.Handler) http.Handler {
+	minBytes := GetAdminCompressionMinBytes()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Whatever is behind us (diagd, in particular) has to hand us the body as it is: we
+		// compress it ourselves, and appendMetrics can only add to an uncompressed /metrics.
+		inner := r.Clone(r.Context())
+		inner.Header.Del("Accept-Encoding")
+
+		bw := &bufferedResponseWriter{header: w.Header()}
+		next.ServeHTTP(bw, inner)
+		bw.finish(w, r, minBytes)
+	})
+}
+
+// bufferedResponseWriter holds on to a response until the handler is done with it.
+type bufferedResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (bw *bufferedResponseWriter) Header() http.Header {
+	return bw.header
+}
+
+func (bw *bufferedResponseWriter) WriteHeader(status int) {
+	if bw.status == 0 {
+		bw.status = status
+	}
+}
+
+func (bw *bufferedResponseWriter) Write(p []byte) (int, error) {
+	if bw.status == 0 {
+		bw.status = http.StatusOK
+	}
+	return bw.body.Write(p)
+}
+
+func (bw *bufferedResponseWriter) finish(w http.ResponseWriter, r *http.Request, minBytes int) {
+	if bw.status == 0 {
+		bw.status = http.StatusOK
+	}
+
+	if bw.status != http.StatusOK || w.Header().Get("Content-Encoding") != "" {
+		w.WriteHeader(bw.status)
+		_, _ = w.Write(bw.body.Bytes())
+		return
+	}
+
+	// The same ETag goes on every encoding of the body, so it has to be a weak one.
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		sum := sha256.Sum256(bw.body.Bytes())
+		etag = `W/"` + hex.EncodeToString(sum[:16]) + `"`
+		w.Header().Set("ETag", etag)
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.Header().Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if bw.body.Len() < minBytes || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+		w.WriteHeader(bw.status)
+		_, _ = w.Write(bw.body.Bytes())
+		return
+	}
+
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Encoding", "gzip")
+	w.WriteHeader(bw.status)
+
+	// These are mostly big, and someone is waiting for them: favor speed over size.
+	gz, _ := gzip.NewWriterLevel(w, gzip.BestSpeed)
+	_, _ = gz.Write(bw.body.Bytes())
+	_ = gz.Close()
+}
+
+// etagMatches says whether an If-None-Match header matches etag, using the weak comparison that
+// RFC 9110 asks for.
+func etagMatches(ifNoneMatch, etag string) bool {
+	ifNoneMatch = strings.TrimSpace(ifNoneMatch)
+	if ifNoneMatch == "" {
+		return false
+	}
+	if ifNoneMatch == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsGzip says whether an Accept-Encoding header allows gzip.
+func acceptsGzip(acceptEncoding string) bool {
+	gzipQ, wildcardQ := -1.0, -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		switch strings.ToLower(strings.TrimSpace(coding)) {
+		case "gzip", "x-gzip":
+			gzipQ = q
+		case "*":
+			wildcardQ = q
+		}
+	}
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return wildcardQ > 0
+}
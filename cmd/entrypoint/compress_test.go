@@ -0,0 +1,118 @@
+package entrypoint
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressHandler(t *testing.T) {
+	body := strings.Repeat(`{"kind":"Mapping","name":"quote"},`, 100)
+	var seenEncoding []string
+	handler := compressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seenEncoding = append(seenEncoding, r.Header.Get("Accept-Encoding"))
+		switch r.URL.Path {
+		case "/small":
+			_, _ = w.Write([]byte("ok"))
+		case "/missing":
+			http.Error(w, body, http.StatusNotFound)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(body))
+		}
+	}))
+
+	get := func(path string, headers ...string) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Result()
+	}
+
+	// No Accept-Encoding: the body as it is, with an ETag.
+	plain := get("/snapshot")
+	assert.Equal(t, http.StatusOK, plain.StatusCode)
+	assert.Empty(t, plain.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", plain.Header.Get("Vary"))
+	etag := plain.Header.Get("ETag")
+	assert.True(t, strings.HasPrefix(etag, `W/"`), etag)
+	data, err := io.ReadAll(plain.Body)
+	require.NoError(t, err)
+	assert.Equal(t, body, string(data))
+
+	// gzip, with the same ETag.
+	zipped := get("/snapshot", "Accept-Encoding", "br, gzip;q=0.8")
+	assert.Equal(t, http.StatusOK, zipped.StatusCode)
+	assert.Equal(t, "gzip", zipped.Header.Get("Content-Encoding"))
+	assert.Equal(t, etag, zipped.Header.Get("ETag"))
+	assert.Equal(t, "application/json", zipped.Header.Get("Content-Type"))
+	data, err = io.ReadAll(zipped.Body)
+	require.NoError(t, err)
+	assert.Less(t, len(data), len(body))
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	data, err = io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, body, string(data))
+
+	// Whatever is behind the handler never sees the client's Accept-Encoding.
+	for _, enc := range seenEncoding {
+		assert.Empty(t, enc)
+	}
+
+	// A matching If-None-Match gets a 304 and no body, however the ETag is quoted.
+	for _, inm := range []string{etag, strings.TrimPrefix(etag, "W/"), `"nope", ` + etag, "*"} {
+		notModified := get("/snapshot", "If-None-Match", inm, "Accept-Encoding", "gzip")
+		assert.Equal(t, http.StatusNotModified, notModified.StatusCode, inm)
+		assert.Equal(t, etag, notModified.Header.Get("ETag"))
+		data, err = io.ReadAll(notModified.Body)
+		require.NoError(t, err)
+		assert.Empty(t, data)
+	}
+	assert.Equal(t, http.StatusOK, get("/snapshot", "If-None-Match", `W/"nope"`).StatusCode)
+
+	// Small responses, and anything that isn't a 200, are left alone.
+	small := get("/small", "Accept-Encoding", "gzip")
+	assert.Empty(t, small.Header.Get("Content-Encoding"))
+	missing := get("/missing", "Accept-Encoding", "gzip")
+	assert.Equal(t, http.StatusNotFound, missing.StatusCode)
+	assert.Empty(t, missing.Header.Get("Content-Encoding"))
+	assert.Empty(t, missing.Header.Get("ETag"))
+
+	// Other methods go straight through.
+	req := httptest.NewRequest(http.MethodPost, "/snapshot", bytes.NewReader(nil))
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Empty(t, rec.Result().Header.Get("Content-Encoding"))
+	assert.Equal(t, "gzip", seenEncoding[len(seenEncoding)-1])
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	for header, expected := range map[string]bool{
+		"":                     false,
+		"gzip":                 true,
+		"GZIP":                 true,
+		"x-gzip":               true,
+		"deflate, gzip;q=0.5":  true,
+		"gzip;q=0":             false,
+		"*":                    true,
+		"*;q=0":                false,
+		"*, gzip;q=0":          false,
+		"identity":             false,
+		"gzip;q=bogus, br":     false,
+		"br;q=1.0, gzip;q=0.1": true,
+	} {
+		assert.Equal(t, expected, acceptsGzip(header), header)
+	}
+}
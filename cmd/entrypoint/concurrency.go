@@ -0,0 +1,192 @@
+package entrypoint
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/cpulimit"
+)
+
+// Concurrency alignment: the Go runtime, Envoy, and diagd each size their thread pools by the
+// number of CPUs on the node, not by the pod's CPU limit, so a small pod on a big node runs far
+// more threads than it has CPU for, and gets throttled. Unless AMBASSADOR_AUTO_CONCURRENCY=false,
+// we read the CPU limit from the cgroup (see pkg/cpulimit) and size all three by it instead:
+//
+//   - GOMAXPROCS, and Envoy's --concurrency, to the limit, rounded up;
+//   - diagd's worker threads to twice that, plus one (which is what diagd does with the CPU count).
+//
+// GOMAXPROCS, ENVOY_CONCURRENCY, and AMBASSADOR_DIAGD_WORKERS override each of them. Whatever
+// they end up as, any that's more than twice what the CPU limit calls for is logged, and shows up
+// as ambassador_concurrency_mismatch in /metrics.
+
+// IsAutoConcurrency returns whether thread counts follow the CPU limit, from
+// AMBASSADOR_AUTO_CONCURRENCY.
+func IsAutoConcurrency() bool {
+	return strings.ToLower(env("AMBASSADOR_AUTO_CONCURRENCY", "true")) != "false"
+}
+
+// GetDiagdWorkers returns the number of diagd worker threads asked for with
+// AMBASSADOR_DIAGD_WORKERS, or "" if it's up to us.
+func GetDiagdWorkers() string {
+	return env("AMBASSADOR_DIAGD_WORKERS", "")
+}
+
+// Where a concurrencySetting came from.
+const (
+	concurrencyAuto    = "auto"    // the CPU limit
+	concurrencyDefault = "default" // whatever the component does by itself
+)
+
+// concurrencySetting is how many threads one component gets.
+type concurrencySetting struct {
+	Component string
+	Value     int
+	// Aligned is what the CPU limit calls for.
+	Aligned int
+	// Source is concurrencyAuto, concurrencyDefault, or the environment variable that set Value.
+	Source string
+}
+
+// mismatched returns whether the setting is badly out of line with the CPU limit.
+func (s concurrencySetting) mismatched() bool {
+	return s.Value > 2*s.Aligned
+}
+
+// concurrencyPlan is how many threads everything gets.
+type concurrencyPlan struct {
+	CPULimit float64 // 0 if there's no limit
+	Cores    int
+
+	GOMAXPROCS concurrencySetting
+	Envoy      concurrencySetting
+	Diagd      concurrencySetting
+
+	Errors []error
+}
+
+func (p *concurrencyPlan) settings() []concurrencySetting {
+	return []concurrencySetting{p.GOMAXPROCS, p.Envoy, p.Diagd}
+}
+
+// planConcurrency works out a concurrencyPlan for a CPU limit (if there is one) on a machine with
+// numCPU CPUs.
+func planConcurrency(limit float64, hasLimit bool, numCPU int, auto bool, getenv func(string) string) concurrencyPlan {
+	plan := concurrencyPlan{Cores: cpulimit.Cores(limit, hasLimit, numCPU)}
+	if hasLimit {
+		plan.CPULimit = limit
+	}
+
+	setting := func(component, name string, aligned, byDefault int) concurrencySetting {
+		s := concurrencySetting{Component: component, Value: byDefault, Aligned: aligned, Source: concurrencyDefault}
+		if value := getenv(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err == nil && n > 0 {
+				s.Value, s.Source = n, name
+				return s
+			}
+			plan.Errors = append(plan.Errors, fmt.Errorf("%s=%q isn't a positive number", name, value))
+		}
+		if auto {
+			s.Value, s.Source = aligned, concurrencyAuto
+		}
+		return s
+	}
+	plan.GOMAXPROCS = setting("gomaxprocs", "GOMAXPROCS", plan.Cores, numCPU)
+	plan.Envoy = setting("envoy", "ENVOY_CONCURRENCY", plan.Cores, numCPU)
+	plan.Diagd = setting("diagd", "AMBASSADOR_DIAGD_WORKERS", 2*plan.Cores+1, 2*numCPU+1)
+	return plan
+}
+
+var (
+	concurrencyPlanOnce sync.Once
+	theConcurrencyPlan  concurrencyPlan
+)
+
+// getConcurrencyPlan works out the concurrencyPlan for this container the first time it's needed.
+func getConcurrencyPlan() *concurrencyPlan {
+	concurrencyPlanOnce.Do(func() {
+		limit, hasLimit, err := cpulimit.Read(cpulimit.DefaultCgroupRoot)
+		theConcurrencyPlan = planConcurrency(limit, hasLimit, runtime.NumCPU(), IsAutoConcurrency(), os.Getenv)
+		if err != nil {
+			theConcurrencyPlan.Errors = append(theConcurrencyPlan.Errors, fmt.Errorf("reading the CPU limit: %w", err))
+		}
+	})
+	return &theConcurrencyPlan
+}
+
+// alignConcurrency sets GOMAXPROCS by the plan, and logs the plan, and anything wrong with it.
+// Envoy and diagd pick up theirs from their flags.
+func alignConcurrency(ctx context.Context) {
+	plan := getConcurrencyPlan()
+	for _, err := range plan.Errors {
+		dlog.Errorf(ctx, "Concurrency: ignoring %v", err)
+	}
+
+	// The Go runtime has already taken care of GOMAXPROCS if it's set.
+	if plan.GOMAXPROCS.Source == concurrencyAuto {
+		runtime.GOMAXPROCS(plan.GOMAXPROCS.Value)
+	}
+
+	limit := "no CPU limit"
+	if plan.CPULimit > 0 {
+		limit = fmt.Sprintf("CPU limit %g", plan.CPULimit)
+	}
+	dlog.Infof(ctx, "Concurrency: %s, %d CPUs: GOMAXPROCS %d (%s), Envoy concurrency %d (%s), diagd workers %d (%s)",
+		limit, plan.Cores,
+		plan.GOMAXPROCS.Value, plan.GOMAXPROCS.Source,
+		plan.Envoy.Value, plan.Envoy.Source,
+		plan.Diagd.Value, plan.Diagd.Source)
+	for _, s := range plan.settings() {
+		if s.mismatched() {
+			dlog.Warnf(ctx, "Concurrency: %s is %d (%s), more than twice the %d that %d CPUs call for; expect CPU throttling and latency",
+				s.Component, s.Value, s.Source, s.Aligned, plan.Cores)
+		}
+	}
+}
+
+// envoyConcurrencyFlag returns the --concurrency to give Envoy, or "" to leave it to Envoy.
+func envoyConcurrencyFlag() string {
+	if s := getConcurrencyPlan().Envoy; s.Source != concurrencyDefault {
+		return strconv.Itoa(s.Value)
+	}
+	return ""
+}
+
+// diagdWorkersFlag returns the --workers to give diagd, or "" to leave it to diagd.
+func diagdWorkersFlag() string {
+	if s := getConcurrencyPlan().Diagd; s.Source != concurrencyDefault {
+		return strconv.Itoa(s.Value)
+	}
+	return ""
+}
+
+// concurrencyMetrics renders the plan in the Prometheus text format, to add to what diagd serves
+// on /metrics.
+func concurrencyMetrics(plan *concurrencyPlan) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "# HELP ambassador_cpu_limit_cores The container's CPU limit, or 0 if it has none.")
+	fmt.Fprintln(&buf, "# TYPE ambassador_cpu_limit_cores gauge")
+	fmt.Fprintf(&buf, "ambassador_cpu_limit_cores %s\n", strconv.FormatFloat(plan.CPULimit, 'f', -1, 64))
+	fmt.Fprintln(&buf, "# HELP ambassador_concurrency Threads, by component.")
+	fmt.Fprintln(&buf, "# TYPE ambassador_concurrency gauge")
+	for _, s := range plan.settings() {
+		fmt.Fprintf(&buf, "ambassador_concurrency{component=%q,source=%q} %d\n", s.Component, s.Source, s.Value)
+	}
+	fmt.Fprintln(&buf, "# HELP ambassador_concurrency_mismatch Whether a component has more than twice the threads that the CPU limit calls for.")
+	fmt.Fprintln(&buf, "# TYPE ambassador_concurrency_mismatch gauge")
+	for _, s := range plan.settings() {
+		mismatch := 0
+		if s.mismatched() {
+			mismatch = 1
+		}
+		fmt.Fprintf(&buf, "ambassador_concurrency_mismatch{component=%q} %d\n", s.Component, mismatch)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,52 @@
+package entrypoint
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanConcurrency(t *testing.T) {
+	getenv := func(env map[string]string) func(string) string {
+		return func(name string) string { return env[name] }
+	}
+
+	// A small pod on a big node gets what its limit calls for.
+	plan := planConcurrency(1.5, true, 64, true, getenv(nil))
+	assert.Equal(t, 1.5, plan.CPULimit)
+	assert.Equal(t, 2, plan.Cores)
+	assert.Equal(t, concurrencySetting{Component: "gomaxprocs", Value: 2, Aligned: 2, Source: "auto"}, plan.GOMAXPROCS)
+	assert.Equal(t, concurrencySetting{Component: "envoy", Value: 2, Aligned: 2, Source: "auto"}, plan.Envoy)
+	assert.Equal(t, concurrencySetting{Component: "diagd", Value: 5, Aligned: 5, Source: "auto"}, plan.Diagd)
+	assert.Empty(t, plan.Errors)
+
+	// Overrides win, and get flagged if they're far off.
+	plan = planConcurrency(1.5, true, 64, true, getenv(map[string]string{
+		"ENVOY_CONCURRENCY":        "16",
+		"AMBASSADOR_DIAGD_WORKERS": "3",
+		"GOMAXPROCS":               "lots",
+	}))
+	assert.Equal(t, concurrencySetting{Component: "envoy", Value: 16, Aligned: 2, Source: "ENVOY_CONCURRENCY"}, plan.Envoy)
+	assert.True(t, plan.Envoy.mismatched())
+	assert.Equal(t, concurrencySetting{Component: "diagd", Value: 3, Aligned: 5, Source: "AMBASSADOR_DIAGD_WORKERS"}, plan.Diagd)
+	assert.False(t, plan.Diagd.mismatched())
+	assert.Equal(t, "auto", plan.GOMAXPROCS.Source)
+	assert.Equal(t, []error{errors.New(`GOMAXPROCS="lots" isn't a positive number`)}, plan.Errors)
+
+	// Without auto, everything does what it does by itself, which is what we warn about.
+	plan = planConcurrency(1.5, true, 64, false, getenv(nil))
+	assert.Equal(t, concurrencySetting{Component: "envoy", Value: 64, Aligned: 2, Source: "default"}, plan.Envoy)
+	assert.True(t, plan.Envoy.mismatched())
+	assert.Equal(t, 129, plan.Diagd.Value)
+	assert.Contains(t, string(concurrencyMetrics(&plan)), "\nambassador_concurrency_mismatch{component=\"envoy\"} 1\n")
+
+	// With no limit, there's nothing to be out of line with.
+	plan = planConcurrency(0, false, 8, true, getenv(nil))
+	assert.Equal(t, 0.0, plan.CPULimit)
+	assert.Equal(t, 8, plan.Envoy.Value)
+	for _, s := range plan.settings() {
+		assert.False(t, s.mismatched())
+	}
+	assert.Contains(t, string(concurrencyMetrics(&plan)), "\nambassador_cpu_limit_cores 0\n")
+}
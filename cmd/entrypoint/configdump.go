@@ -0,0 +1,38 @@
+package entrypoint
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/acp"
+)
+
+// Config dumps: a GET of /ambassador/v0/envoy_config_dump answers with Envoy's /config_dump, so
+// that support can see exactly what Envoy is running without exec'ing into the pod to ask it.
+// Secrets are redacted, big dumps are refused, and a dump is reused for a few seconds, so that a
+// support script hammering the endpoint doesn't keep Envoy busy serializing its config (see
+// pkg/acp/envoyconfigdump.go).
+
+// handleEnvoyConfigDump answers with the EnvoyWatcher's config dump. X-Envoy-Config-Dump-Fetched
+// says when Envoy was asked for it, and X-Envoy-Config-Dump-Redacted how many secrets came out.
+func handleEnvoyConfigDump(w http.ResponseWriter, r *http.Request, ew *acp.EnvoyWatcher) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed\n", http.StatusMethodNotAllowed)
+		return
+	}
+
+	dump, err := ew.ConfigDump(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Envoy-Config-Dump-Fetched", dump.Fetched.UTC().Format(time.RFC3339))
+	w.Header().Set("X-Envoy-Config-Dump-Redacted", strconv.Itoa(dump.Redactions))
+	// The dump is shared with whoever else asks for it, so no appending to it.
+	_, _ = w.Write(dump.JSON)
+	_, _ = w.Write([]byte("\n"))
+}
@@ -0,0 +1,42 @@
+package entrypoint
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/acp"
+)
+
+func TestHandleEnvoyConfigDump(t *testing.T) {
+	status := http.StatusOK
+	ew := acp.NewEnvoyWatcher()
+	ew.SetConfigDumpCheck(func(ctx context.Context) (*acp.EnvoyFetcherResponse, error) {
+		return &acp.EnvoyFetcherResponse{
+			StatusCode: status,
+			Text:       []byte(`{"configs": [{"password": "hunter2"}]}`),
+		}, nil
+	})
+
+	rec := httptest.NewRecorder()
+	handleEnvoyConfigDump(rec, httptest.NewRequest(http.MethodPost, "/ambassador/v0/envoy_config_dump", nil), ew)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	status = http.StatusInternalServerError
+	rec = httptest.NewRecorder()
+	handleEnvoyConfigDump(rec, httptest.NewRequest(http.MethodGet, "/ambassador/v0/envoy_config_dump", nil), ew)
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	assert.Equal(t, "could not fetch Envoy's config dump: status 500\n", rec.Body.String())
+
+	status = http.StatusOK
+	rec = httptest.NewRecorder()
+	handleEnvoyConfigDump(rec, httptest.NewRequest(http.MethodGet, "/ambassador/v0/envoy_config_dump", nil), ew)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "1", rec.Header().Get("X-Envoy-Config-Dump-Redacted"))
+	assert.NotEmpty(t, rec.Header().Get("X-Envoy-Config-Dump-Fetched"))
+	assert.JSONEq(t, `{"configs": [{"password": "[redacted]"}]}`, rec.Body.String())
+}
@@ -0,0 +1,60 @@
+package entrypoint
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/ambex"
+	"github.com/emissary-ingress/emissary/v3/pkg/statuswriter"
+)
+
+// With AMBASSADOR_CONFIG_LIMITS_MODE=enforce, ambex leaves the routes of some Mappings out of
+// Envoy's configuration to keep it within the config limits (see pkg/ambex/guardrails.go), and
+// tells us which Mappings those are every time it builds a snapshot. Each of them gets an Inactive
+// status saying why, and goes back to Running once it fits again.
+
+// mappingOverLimitsCode is the error code for a rejected Mapping, registered in
+// python/ambassador/errorcodes.py.
+const mappingOverLimitsCode = "AMB2003"
+
+// rejectionStatus returns the ambex.RejectionHandler that hands the status of rejected Mappings to
+// enqueue (the status writer's Enqueue).
+func rejectionStatus(enqueue func(...statuswriter.Update)) ambex.RejectionHandler {
+	var mutex sync.Mutex
+	current := map[ambex.MappingRef]bool{}
+
+	return func(rejected []ambex.Rejection) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		var updates []statuswriter.Update
+		next := make(map[ambex.MappingRef]bool, len(rejected))
+		for _, r := range rejected {
+			next[r.Mapping] = true
+			updates = append(updates, mappingStatus(r.Mapping, map[string]string{
+				"state":  "Inactive",
+				"reason": fmt.Sprintf("left out of Envoy's configuration to stay within %s", r.Limit),
+				"code":   mappingOverLimitsCode,
+			}))
+		}
+		for m := range current {
+			if !next[m] {
+				updates = append(updates, mappingStatus(m, map[string]string{"state": "Running"}))
+			}
+		}
+		current = next
+
+		if len(updates) > 0 {
+			enqueue(updates...)
+		}
+	}
+}
+
+func mappingStatus(m ambex.MappingRef, status map[string]string) statuswriter.Update {
+	bs, _ := json.Marshal(status)
+	return statuswriter.Update{
+		Key:    statuswriter.Key{Kind: "Mapping", Namespace: m.Namespace, Name: m.Name},
+		Status: bs,
+	}
+}
@@ -0,0 +1,48 @@
+package entrypoint
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/ambex"
+	"github.com/emissary-ingress/emissary/v3/pkg/statuswriter"
+)
+
+func TestRejectionStatus(t *testing.T) {
+	var got []statuswriter.Update
+	handler := rejectionStatus(func(updates ...statuswriter.Update) {
+		got = append(got, updates...)
+	})
+	statuses := func() map[string]string {
+		statuses := map[string]string{}
+		for _, u := range got {
+			statuses[u.Key.String()] = string(u.Status)
+		}
+		got = nil
+		return statuses
+	}
+
+	foo := ambex.MappingRef{Name: "foo", Namespace: "default"}
+	bar := ambex.MappingRef{Name: "bar", Namespace: "other"}
+
+	// Nothing rejected, nothing to say.
+	handler(nil)
+	assert.Empty(t, statuses())
+
+	handler([]ambex.Rejection{{Mapping: foo, Limit: "max_clusters"}, {Mapping: bar, Limit: "max_clusters"}})
+	assert.Equal(t, map[string]string{
+		"Mapping/foo.default": `{"code":"AMB2003","reason":"left out of Envoy's configuration to stay within max_clusters","state":"Inactive"}`,
+		"Mapping/bar.other":   `{"code":"AMB2003","reason":"left out of Envoy's configuration to stay within max_clusters","state":"Inactive"}`,
+	}, statuses())
+
+	// foo fits again.
+	handler([]ambex.Rejection{{Mapping: bar, Limit: "max_config_bytes"}})
+	assert.Equal(t, map[string]string{
+		"Mapping/foo.default": `{"state":"Running"}`,
+		"Mapping/bar.other":   `{"code":"AMB2003","reason":"left out of Envoy's configuration to stay within max_config_bytes","state":"Inactive"}`,
+	}, statuses())
+
+	handler(nil)
+	assert.Equal(t, map[string]string{"Mapping/bar.other": `{"state":"Running"}`}, statuses())
+}
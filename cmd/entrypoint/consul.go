@@ -9,55 +9,79 @@ import (
 
 	amb "github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
 	"github.com/emissary-ingress/emissary/v3/pkg/consulwatch"
+	"github.com/emissary-ingress/emissary/v3/pkg/debug"
 	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
 )
 
-// consulMapping contains the necessary subset of Ambassador Mapping and TCPMapping
-// definitions needed for consul reconcilation and watching to happen.
-type consulMapping struct {
+// resolverMapping contains the necessary subset of Ambassador Mapping and TCPMapping definitions
+// needed for reconciling and watching resolvers that get endpoints from outside of Kubernetes
+// (Consul, and resolver plugins).
+type resolverMapping struct {
 	Service  string
 	Resolver string
 }
 
-func ReconcileConsul(ctx context.Context, consulWatcher *consulWatcher, s *snapshotTypes.KubernetesSnapshot) error {
+// resolverMappings returns the resolverMapping of every Mapping and TCPMapping, annotations
+// included, that belongs to this Ambassador.
+func resolverMappings(s *snapshotTypes.KubernetesSnapshot) []resolverMapping {
 	envAmbID := GetAmbassadorID()
 
-	var mappings []consulMapping
+	var mappings []resolverMapping
 	for _, list := range s.Annotations {
 		for _, a := range list {
 			switch m := a.(type) {
 			case *amb.Mapping:
 				if m.Spec.AmbassadorID.Matches(envAmbID) {
-					mappings = append(mappings, consulMapping{Service: m.Spec.Service, Resolver: m.Spec.Resolver})
+					mappings = append(mappings, mappingResolverMappings(m)...)
 				}
 			case *amb.TCPMapping:
 				if m.Spec.AmbassadorID.Matches(envAmbID) {
-					mappings = append(mappings, consulMapping{Service: m.Spec.Service, Resolver: m.Spec.Resolver})
+					mappings = append(mappings, resolverMapping{Service: m.Spec.Service, Resolver: m.Spec.Resolver})
 				}
 			}
 		}
 	}
 
-	var resolvers []*amb.ConsulResolver
-	for _, cr := range s.ConsulResolvers {
-		if cr.Spec.AmbassadorID.Matches(envAmbID) {
-			resolvers = append(resolvers, cr)
-		}
-	}
-
 	for _, m := range s.Mappings {
 		if m.Spec.AmbassadorID.Matches(envAmbID) {
-			mappings = append(mappings, consulMapping{Service: m.Spec.Service, Resolver: m.Spec.Resolver})
+			mappings = append(mappings, mappingResolverMappings(m)...)
 		}
 	}
 
 	for _, tm := range s.TCPMappings {
 		if tm.Spec.AmbassadorID.Matches(envAmbID) {
-			mappings = append(mappings, consulMapping{Service: tm.Spec.Service, Resolver: tm.Spec.Resolver})
+			mappings = append(mappings, resolverMapping{Service: tm.Spec.Service, Resolver: tm.Spec.Resolver})
+		}
+	}
+
+	return mappings
+}
+
+// mappingResolverMappings returns the resolverMappings for a Mapping's service and for each of
+// its upstreams, which use the Mapping's resolver unless they name their own.
+func mappingResolverMappings(m *amb.Mapping) []resolverMapping {
+	mappings := []resolverMapping{{Service: m.Spec.Service, Resolver: m.Spec.Resolver}}
+	for _, upstream := range m.Spec.Upstreams {
+		resolver := upstream.Resolver
+		if resolver == "" {
+			resolver = m.Spec.Resolver
+		}
+		mappings = append(mappings, resolverMapping{Service: upstream.Service, Resolver: resolver})
+	}
+	return mappings
+}
+
+func ReconcileConsul(ctx context.Context, consulWatcher *consulWatcher, s *snapshotTypes.KubernetesSnapshot) error {
+	envAmbID := GetAmbassadorID()
+
+	var resolvers []*amb.ConsulResolver
+	for _, cr := range s.ConsulResolvers {
+		if cr.Spec.AmbassadorID.Matches(envAmbID) {
+			resolvers = append(resolvers, cr)
 		}
 	}
 
-	return consulWatcher.reconcile(ctx, s.ConsulResolvers, mappings)
+	return consulWatcher.reconcile(ctx, s.ConsulResolvers, resolverMappings(s))
 }
 
 type consulWatcher struct {
@@ -169,7 +193,7 @@ func (c *consulWatcher) cleanup(ctx context.Context) error {
 
 // Start and stop consul service watches as needed in order to match the supplied set of resolvers
 // and mappings.
-func (c *consulWatcher) reconcile(ctx context.Context, resolvers []*amb.ConsulResolver, mappings []consulMapping) error {
+func (c *consulWatcher) reconcile(ctx context.Context, resolvers []*amb.ConsulResolver, mappings []resolverMapping) error {
 	// ==First we compute resolvers and their related mappings without actualy changing anything.==
 	resolversByName := make(map[string]*amb.ConsulResolver)
 	for _, cr := range resolvers {
@@ -178,7 +202,7 @@ func (c *consulWatcher) reconcile(ctx context.Context, resolvers []*amb.ConsulRe
 		resolversByName[cr.GetName()] = cr
 	}
 
-	mappingsByResolver := make(map[string][]consulMapping)
+	mappingsByResolver := make(map[string][]resolverMapping)
 	for _, m := range mappings {
 		// Everything here is keyed off m.Spec.Resolver -- again, it's fine to use a resolver
 		// from any namespace, as long as it was loaded.
@@ -272,7 +296,7 @@ func (r *resolver) deleted() {
 	}
 }
 
-func (r *resolver) reconcile(ctx context.Context, watchFunc watchConsulFunc, mappings []consulMapping, endpoints chan consulwatch.Endpoints) error {
+func (r *resolver) reconcile(ctx context.Context, watchFunc watchConsulFunc, mappings []resolverMapping, endpoints chan consulwatch.Endpoints) error {
 	servicesByName := make(map[string]bool)
 	for _, m := range mappings {
 		// XXX: how to parse this?
@@ -341,5 +365,5 @@ func watchConsul(
 		}
 	}()
 
-	return w, nil
+	return countedWatch{Stopper: w, closeWatch: debug.FromContext(ctx).Leaks().OpenWatch("consul")}, nil
 }
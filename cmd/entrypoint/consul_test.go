@@ -78,7 +78,7 @@ func TestReconcile(t *testing.T) {
 		"consultest-resolver.default:consultest-consul-service:watch",
 		"consultest-resolver.default:consultest-consul-service-tcp:watch",
 	)
-	extra := consulMapping{
+	extra := resolverMapping{
 		Service:  "foo",
 		Resolver: "consultest-resolver",
 	}
@@ -122,7 +122,7 @@ func TestBootstrap(t *testing.T) {
 	assert.True(t, c.isBootstrapped())
 }
 
-func setup(t *testing.T) (ctx context.Context, resolvers []*amb.ConsulResolver, mappings []consulMapping, c *consulWatcher, tw *testWatcher) {
+func setup(t *testing.T) (ctx context.Context, resolvers []*amb.ConsulResolver, mappings []resolverMapping, c *consulWatcher, tw *testWatcher) {
 	var cancel context.CancelFunc
 	ctx, cancel = context.WithCancel(dlog.NewTestContext(t, false))
 	grp := dgroup.NewGroup(ctx, dgroup.GroupConfig{})
@@ -154,9 +154,9 @@ func setup(t *testing.T) (ctx context.Context, resolvers []*amb.ConsulResolver,
 		case *amb.ConsulResolver:
 			resolvers = append(resolvers, o)
 		case *amb.Mapping:
-			mappings = append(mappings, consulMapping{Service: o.Spec.Service, Resolver: o.Spec.Resolver})
+			mappings = append(mappings, resolverMapping{Service: o.Spec.Service, Resolver: o.Spec.Resolver})
 		case *amb.TCPMapping:
-			mappings = append(mappings, consulMapping{Service: o.Spec.Service, Resolver: o.Spec.Resolver})
+			mappings = append(mappings, resolverMapping{Service: o.Spec.Service, Resolver: o.Spec.Resolver})
 		}
 	}
 
@@ -0,0 +1,144 @@
+package entrypoint
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/datawire/dlib/dgroup"
+	"github.com/datawire/dlib/dhttp"
+	"github.com/datawire/dlib/dlog"
+	"google.golang.org/grpc"
+
+	v3extproc "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/service/ext_proc/v3"
+	amb "github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
+	"github.com/emissary-ingress/emissary/v3/pkg/capture"
+	"github.com/emissary-ingress/emissary/v3/pkg/debug"
+)
+
+// Contract capture: a Mapping with `contract_capture` set has a sampled, redacted copy of its
+// requests and responses sent to a capture endpoint, for building contract tests from real
+// traffic. Envoy's ext_proc filter sends the sampled requests here, to a capture.Mirror listening
+// on 127.0.0.1:AMBASSADOR_CONTRACT_CAPTURE_PORT (default 8007), which POSTs them on. The
+// healthcheck server has what the Mirror has done at
+//
+//	/ambassador/v0/contract_capture
+//
+// Which Mappings capture, and where to, comes from the snapshot. diagd puts the filter and the
+// routes' overrides in Envoy's config (see python/ambassador/ir/ircontractcapture.py).
+
+// contractCaptureDebugValue is the name of the debug value holding the *capture.Mirror.
+const contractCaptureDebugValue = "contractCapture"
+
+// contractCaptureDefaultPercent is the percentage of requests captured when a Mapping doesn't say.
+const contractCaptureDefaultPercent = 1
+
+// contractCaptureQueueSize is how many exchanges can be waiting to be sent before more are
+// dropped.
+const contractCaptureQueueSize = 1000
+
+// GetContractCapturePort returns the port that the contract capture server listens on, from
+// AMBASSADOR_CONTRACT_CAPTURE_PORT. It has to be the port that diagd points Envoy at.
+func GetContractCapturePort() string {
+	return env("AMBASSADOR_CONTRACT_CAPTURE_PORT", "8007")
+}
+
+// runContractCapture serves ext_proc for contract capture, and sends what it captures on, until
+// the context is done.
+func runContractCapture(ctx context.Context) error {
+	mirror := capture.NewMirror(contractCaptureQueueSize)
+	debug.FromContext(ctx).Value(contractCaptureDebugValue).Store(mirror)
+
+	grpcServer := grpc.NewServer()
+	v3extproc.RegisterExternalProcessorServer(grpcServer, mirror)
+	sc := &dhttp.ServerConfig{
+		Handler: grpcServer,
+	}
+
+	group := dgroup.NewGroup(ctx, dgroup.GroupConfig{})
+	group.Go("server", func(ctx context.Context) error {
+		return sc.ListenAndServe(ctx, net.JoinHostPort("127.0.0.1", GetContractCapturePort()))
+	})
+	group.Go("sender", mirror.Run)
+	group.Go("targets", func(ctx context.Context) error {
+		snapshots := followSnapshots(ctx, "contract_capture")
+		defer snapshots.Close()
+		for {
+			select {
+			case ev, ok := <-snapshots.Events():
+				snapshots.note(ev, ok)
+			case <-ctx.Done():
+				return nil
+			}
+			if snap := snapshots.latest(); snap != nil && snap.Kubernetes != nil {
+				mirror.SetTargets(contractCaptureTargets(ctx, snap.Kubernetes.Mappings))
+			}
+		}
+	})
+	return group.Wait()
+}
+
+// contractCaptureTargets returns where each Mapping with `contract_capture` set sends what it
+// captures, by name.namespace. Mappings whose contract_capture doesn't make sense are left out,
+// since diagd won't send their traffic here anyway.
+func contractCaptureTargets(ctx context.Context, mappings []*amb.Mapping) map[string]capture.MirrorTarget {
+	targets := map[string]capture.MirrorTarget{}
+	for _, mapping := range mappings {
+		spec := mapping.Spec.ContractCapture
+		if spec == nil {
+			continue
+		}
+		key := mapping.GetName() + "." + mapping.GetNamespace()
+
+		u, err := url.Parse(spec.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			dlog.Warnf(ctx, "Mapping %s: contract_capture endpoint %q is not an http or https URL; ignoring it", key, spec.Endpoint)
+			continue
+		}
+		target := capture.MirrorTarget{
+			Endpoint:      spec.Endpoint,
+			SampleRate:    contractCaptureDefaultPercent / 100.0,
+			MaxBodyBytes:  capture.DefaultMaxBodyBytes,
+			RedactHeaders: spec.RedactHeaders,
+		}
+		if spec.SamplePercent != nil {
+			if *spec.SamplePercent < 0 || *spec.SamplePercent > 100 {
+				dlog.Warnf(ctx, "Mapping %s: contract_capture sample_percent %d is not between 0 and 100; ignoring it", key, *spec.SamplePercent)
+				continue
+			}
+			target.SampleRate = float64(*spec.SamplePercent) / 100
+		}
+		if spec.MaxBodyBytes != nil {
+			if *spec.MaxBodyBytes < 0 || *spec.MaxBodyBytes > capture.MaxMaxBodyBytes {
+				dlog.Warnf(ctx, "Mapping %s: contract_capture max_body_bytes %d is not between 0 and %d; ignoring it", key, *spec.MaxBodyBytes, capture.MaxMaxBodyBytes)
+				continue
+			}
+			target.MaxBodyBytes = *spec.MaxBodyBytes
+		}
+		targets[key] = target
+	}
+	return targets
+}
+
+// loadContractCaptureMirror returns the running Mirror, if there is one.
+func loadContractCaptureMirror(dbg *debug.Debug) *capture.Mirror {
+	mirror, _ := dbg.Value(contractCaptureDebugValue).Load().(*capture.Mirror)
+	return mirror
+}
+
+func handleContractCapture(w http.ResponseWriter, r *http.Request) {
+	mirror := loadContractCaptureMirror(debug.FromContext(r.Context()))
+	if mirror == nil {
+		http.Error(w, "contract capture isn't running\n", http.StatusServiceUnavailable)
+		return
+	}
+	bytes, err := json.MarshalIndent(mirror.Stats(), "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(append(bytes, '\n'))
+}
@@ -0,0 +1,50 @@
+package entrypoint
+
+import (
+	"testing"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/stretchr/testify/assert"
+
+	amb "github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
+	"github.com/emissary-ingress/emissary/v3/pkg/capture"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+)
+
+func TestContractCaptureTargets(t *testing.T) {
+	intPtr := func(i int) *int { return &i }
+	mapping := func(name string, cc *amb.MappingContractCapture) *amb.Mapping {
+		return &amb.Mapping{
+			ObjectMeta: kates.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       amb.MappingSpec{Prefix: "/" + name + "/", ContractCapture: cc},
+		}
+	}
+
+	targets := contractCaptureTargets(dlog.NewTestContext(t, false), []*amb.Mapping{
+		mapping("plain", nil),
+		mapping("defaults", &amb.MappingContractCapture{Endpoint: "http://contracts:8080/ingest"}),
+		mapping("tuned", &amb.MappingContractCapture{
+			Endpoint:      "https://contracts.example.com/",
+			SamplePercent: intPtr(50),
+			MaxBodyBytes:  intPtr(0),
+			RedactHeaders: []string{"x-session"},
+		}),
+		mapping("not-a-url", &amb.MappingContractCapture{Endpoint: "contracts:8080"}),
+		mapping("too-many", &amb.MappingContractCapture{Endpoint: "http://contracts/", SamplePercent: intPtr(150)}),
+		mapping("too-big", &amb.MappingContractCapture{Endpoint: "http://contracts/", MaxBodyBytes: intPtr(capture.MaxMaxBodyBytes + 1)}),
+	})
+
+	assert.Equal(t, map[string]capture.MirrorTarget{
+		"defaults.default": {
+			Endpoint:     "http://contracts:8080/ingest",
+			SampleRate:   0.01,
+			MaxBodyBytes: capture.DefaultMaxBodyBytes,
+		},
+		"tuned.default": {
+			Endpoint:      "https://contracts.example.com/",
+			SampleRate:    0.5,
+			MaxBodyBytes:  0,
+			RedactHeaders: []string{"x-session"},
+		},
+	}, targets)
+}
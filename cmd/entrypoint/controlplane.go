@@ -0,0 +1,266 @@
+package entrypoint
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/datawire/dlib/dgroup"
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/acp"
+	"github.com/emissary-ingress/emissary/v3/pkg/ambex"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+	"github.com/emissary-ingress/emissary/v3/pkg/compilepool"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	"github.com/emissary-ingress/emissary/v3/pkg/memory"
+	"github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+// The ControlPlane is the watch -> snapshot -> xDS pipeline at the heart of Ambassador, packaged
+// so that a Go program can embed it: the watcher assembles snapshots from Kubernetes (or whatever
+// K8sSource it's given), Consul, and resolver plugins, the SnapshotProcessor gets each one
+// compiled (by default, by telling diagd, which fetches it from the snapshot server), and ambex
+// serves the result to Envoy over ADS. Main runs one, alongside diagd, Envoy, and everything else.
+//
+// A platform team building a gateway of its own can reuse the compilation and ambex layers while
+// feeding in its own resources:
+//
+//	cp := entrypoint.NewControlPlane(entrypoint.ControlPlaneConfig{
+//		Version:   "custom-1.0",
+//		K8sSource: mySource,
+//	})
+//	return cp.Run(ctx)
+//
+// The watcher still reads the usual AMBASSADOR_* environment variables, and it picks up the
+// optional subsystems that Main puts in the Context (the resyncer, approvals, the event bus, and
+// so on) if they're there; without them, it does without.
+type ControlPlane struct {
+	config     ControlPlaneConfig
+	snapshot   *atomic.Value
+	fastpathCh chan *ambex.FastpathSnapshot
+}
+
+// ControlPlaneConfig is how a ControlPlane is put together. Anything left zero gets its default.
+type ControlPlaneConfig struct {
+	// Version is the version of Ambassador that the snapshots and ambex say they're from.
+	Version string
+	// ClusterID goes in the snapshots' AmbassadorMeta. The default is GetClusterID's.
+	ClusterID string
+
+	// K8sSource is where the watcher gets resources from, and Queries are what it asks for. The
+	// default K8sSource is the Kubernetes API server. The default Queries are for all the types
+	// in interesting_types.go (that the API server has, if it's the K8sSource).
+	K8sSource K8sSource
+	Queries   []kates.Query
+	// AmbassadorMeta goes in every snapshot. The default comes from the Kubernetes API server if
+	// K8sSource is left to its default, and from ClusterID and Version otherwise.
+	AmbassadorMeta *snapshot.AmbassadorMetaInfo
+	// IstioCertSource is where Istio certificates come from. The default watches the files that
+	// the Istio sidecar writes.
+	IstioCertSource IstioCertSource
+	// SPIFFESource is where our SPIFFE SVID and trust bundles come from. The default streams them
+	// from the Workload API at AMBASSADOR_SPIFFE_ENDPOINT_SOCKET, if that's set.
+	SPIFFESource SPIFFESource
+
+	// SnapshotProcessor gets every snapshot the watcher makes. The default has diagd compile the
+	// ready ones, and tells the AmbassadorWatcher about it.
+	SnapshotProcessor SnapshotProcessor
+	// CompilePool, if set, schedules the SnapshotProcessor's compiles (what it does with ready
+	// snapshots) along with those of the other ControlPlanes that share the pool, so that none
+	// of them holds up the others' reconfiguration for long; see pkg/compilepool. Tenant is who
+	// this ControlPlane is to the pool. The default Tenant is the Ambassador ID.
+	CompilePool *compilepool.Pool
+	Tenant      string
+	// AmbassadorWatcher hears about snapshots going to diagd, and about the API server coming and
+	// going, for the readiness check. The default is one of its own.
+	AmbassadorWatcher *acp.AmbassadorWatcher
+
+	// AmbexArgs are ambex's arguments, without the directory it reads Envoy configuration from,
+	// which is always GetEnvoyDir(). The default has it serve ADS on 127.0.0.1:8003.
+	AmbexArgs []string
+	// MemoryUsage says how much of its memory limit the process is using, for ambex to rate
+	// limit with. The default is memory.GetMemoryUsage's.
+	MemoryUsage ambex.MemoryGetter
+
+	// Clock is the time the watcher sees. The default is clock.FromContext's.
+	Clock clock.Clock
+}
+
+func (c ControlPlaneConfig) withDefaults() ControlPlaneConfig {
+	if c.AmbassadorWatcher == nil {
+		c.AmbassadorWatcher = acp.NewAmbassadorWatcher(acp.NewEnvoyWatcher(), acp.NewDiagdWatcher())
+	}
+	if c.SnapshotProcessor == nil {
+		ambwatch := c.AmbassadorWatcher
+		c.SnapshotProcessor = func(ctx context.Context, disposition SnapshotDisposition, _ []byte) error {
+			if disposition == SnapshotReady {
+				return notifyReconfigWebhooks(ctx, ambwatch)
+			}
+			return nil
+		}
+	}
+	if c.CompilePool != nil {
+		if c.Tenant == "" {
+			c.Tenant = GetAmbassadorID()
+		}
+		c.SnapshotProcessor = pooledSnapshotProcessor(c.CompilePool, c.Tenant, c.SnapshotProcessor)
+	}
+	if c.IstioCertSource == nil {
+		c.IstioCertSource = newIstioCertSource()
+	}
+	if c.SPIFFESource == nil {
+		c.SPIFFESource = newSPIFFESource()
+	}
+	if c.AmbexArgs == nil {
+		c.AmbexArgs = []string{"--ads-listen-address", "127.0.0.1:8003", "--clustermap", GetClustermapFile()}
+	}
+	return c
+}
+
+// pooledSnapshotProcessor returns a SnapshotProcessor that hands ready snapshots to processor when
+// the pool says it's the tenant's turn. The rest go straight through, since there's nothing to
+// compile.
+func pooledSnapshotProcessor(pool *compilepool.Pool, tenant string, processor SnapshotProcessor) SnapshotProcessor {
+	return func(ctx context.Context, disposition SnapshotDisposition, snapshotJSON []byte) error {
+		if disposition != SnapshotReady {
+			return processor(ctx, disposition, snapshotJSON)
+		}
+		return pool.Do(ctx, tenant, func(ctx context.Context) error {
+			return processor(ctx, disposition, snapshotJSON)
+		})
+	}
+}
+
+// NewControlPlane returns a ControlPlane that isn't running yet.
+func NewControlPlane(config ControlPlaneConfig) *ControlPlane {
+	return &ControlPlane{
+		config:     config.withDefaults(),
+		snapshot:   &atomic.Value{},
+		fastpathCh: make(chan *ambex.FastpathSnapshot),
+	}
+}
+
+// Snapshot returns the last snapshot that went to the SnapshotProcessor as ready, as JSON, or nil
+// if there hasn't been one yet.
+func (cp *ControlPlane) Snapshot() []byte {
+	snapshotJSON, _ := cp.snapshot.Load().([]byte)
+	return snapshotJSON
+}
+
+// Run runs the whole pipeline: the watcher, the snapshot server that diagd fetches snapshots
+// from, and ambex. It returns when ctx is canceled, or any of them fails.
+func (cp *ControlPlane) Run(ctx context.Context) error {
+	grp := dgroup.NewGroup(ctx, dgroup.GroupConfig{})
+	grp.Go("ambex", supervise("ambex", cp.RunAmbex))
+	grp.Go("snapshot_server", supervise("snapshot_server", func(ctx context.Context) error {
+		return snapshotServer(ctx, cp.snapshot)
+	}))
+	grp.Go("watcher", supervise("watcher", cp.RunWatcher))
+	return grp.Wait()
+}
+
+// RunAmbex runs just ambex, for a caller that stops the parts of the pipeline at different times,
+// the way Main does. The watcher can't get anything to ambex unless RunAmbex is running.
+func (cp *ControlPlane) RunAmbex(ctx context.Context) error {
+	getUsage := cp.config.MemoryUsage
+	if getUsage == nil {
+		getUsage = memory.GetMemoryUsage(ctx).PercentUsed
+	}
+	args := append(append([]string(nil), cp.config.AmbexArgs...), GetEnvoyDir())
+	return ambex.Main(ctx, cp.config.Version, getUsage, cp.fastpathCh, args...)
+}
+
+// RunWatcher runs just the watcher; see RunAmbex.
+func (cp *ControlPlane) RunWatcher(ctx context.Context) error {
+	clk := cp.config.Clock
+	if clk == nil {
+		clk = clock.FromContext(ctx)
+	}
+	ctx = clock.WithClock(ctx, clk)
+
+	clusterID := cp.config.ClusterID
+	if clusterID == "" {
+		clusterID = GetClusterID(ctx)
+	}
+
+	k8sSrc, queries, ambassadorMeta := cp.config.K8sSource, cp.config.Queries, cp.config.AmbassadorMeta
+	var serverTypeList []kates.APIResource
+	if k8sSrc == nil {
+		client, err := kates.NewClient(kates.ClientConfig{})
+		if err != nil {
+			return err
+		}
+		intv, err := strconv.Atoi(env("AMBASSADOR_RECONFIG_MAX_DELAY", "1"))
+		if err != nil {
+			return err
+		}
+		maxInterval := time.Duration(intv) * time.Second
+		err = client.MaxAccumulatorInterval(maxInterval)
+		if err != nil {
+			return err
+		}
+		dlog.Infof(ctx, "AMBASSADOR_RECONFIG_MAX_DELAY set to %d", intv)
+
+		// Let the readiness check know if the API server goes away.
+		if asw := cp.config.AmbassadorWatcher.APIServerWatcher(); asw != nil {
+			client.OnConnectivity(asw.Note)
+		}
+
+		if queries == nil {
+			serverTypeList, err = client.ServerResources()
+			if err != nil {
+				// It's possible that an error prevented listing some apigroups, but not all;
+				// so process the output even if there is an error.
+				dlog.Infof(ctx, "Warning, unable to list api-resources: %v", err)
+			}
+		}
+		if ambassadorMeta == nil {
+			ambassadorMeta = getAmbassadorMeta(GetAmbassadorID(), clusterID, cp.config.Version, client)
+		}
+
+		k8sSrc = newK8sSource(client)
+		if filename := GetWatchRecordFile(); filename != "" {
+			recorder, err := newWatchRecorder(filename)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := recorder.Close(); err != nil {
+					dlog.Errorf(ctx, "Unable to finish recording watch events: %v", err)
+				}
+			}()
+			dlog.Infof(ctx, "Recording Kubernetes watch events to %s", filename)
+			k8sSrc = recorder.source(k8sSrc)
+		}
+	}
+	if queries == nil {
+		queries = GetQueries(ctx, GetInterestingTypes(ctx, serverTypeList))
+	}
+	if ambassadorMeta == nil {
+		ambassadorMeta = &snapshot.AmbassadorMetaInfo{
+			ClusterID:         clusterID,
+			AmbassadorID:      GetAmbassadorID(),
+			AmbassadorVersion: cp.config.Version,
+		}
+	}
+
+	fastpathUpdate := func(ctx context.Context, fastpathSnapshot *ambex.FastpathSnapshot) {
+		cp.fastpathCh <- fastpathSnapshot
+	}
+
+	return watchAllTheThingsInternal(
+		ctx,
+		cp.snapshot,
+		k8sSrc,
+		queries,
+		watchConsul, // watchConsulFunc
+		watchPlugin, // watchPluginFunc
+		cp.config.IstioCertSource,
+		cp.config.SPIFFESource,
+		cp.config.SnapshotProcessor,
+		fastpathUpdate, // fastpathProcessor
+		ambassadorMeta,
+		clk,
+	)
+}
@@ -0,0 +1,104 @@
+package entrypoint
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/compilepool"
+	"github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+func TestControlPlaneOwnSource(t *testing.T) {
+	// The Fake's store stands in for a resource source of some embedder's own.
+	f := NewFake(t, FakeConfig{})
+	ready := make(chan []byte, 10)
+	cp := NewControlPlane(ControlPlaneConfig{
+		Version:         "custom-1.0",
+		ClusterID:       "cluster",
+		K8sSource:       f.k8sSource,
+		IstioCertSource: f.istioCertSource,
+		SnapshotProcessor: func(_ context.Context, disposition SnapshotDisposition, snapshotJSON []byte) error {
+			if disposition == SnapshotReady {
+				ready <- snapshotJSON
+			}
+			return nil
+		},
+	})
+
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	done := make(chan error, 1)
+	go func() {
+		done <- cp.RunWatcher(ctx)
+	}()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil && err != context.Canceled {
+			t.Errorf("watcher errored out: %+v", err)
+		}
+	}()
+	// Nothing's running ambex here.
+	go func() {
+		for {
+			select {
+			case <-cp.fastpathCh:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	require.NoError(t, f.UpsertYAML(`
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+metadata:
+  name: quote
+  namespace: default
+spec:
+  hostname: "*"
+  prefix: /quote/
+  service: quote
+`))
+	f.Flush()
+
+	var snapshotJSON []byte
+	select {
+	case snapshotJSON = <-ready:
+	case <-time.After(10 * time.Second):
+		t.Fatal("no snapshot")
+	}
+	assert.Equal(t, snapshotJSON, cp.Snapshot())
+
+	var sn snapshot.Snapshot
+	require.NoError(t, json.Unmarshal(snapshotJSON, &sn))
+	require.Len(t, sn.Kubernetes.Mappings, 1)
+	assert.Equal(t, "/quote/", sn.Kubernetes.Mappings[0].Spec.Prefix)
+	assert.Equal(t, "cluster", sn.AmbassadorMeta.ClusterID)
+	assert.Equal(t, "custom-1.0", sn.AmbassadorMeta.AmbassadorVersion)
+}
+
+func TestPooledSnapshotProcessor(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	pool := compilepool.New(compilepool.Config{})
+	var seen []SnapshotDisposition
+	processor := pooledSnapshotProcessor(pool, "tenant-a", func(_ context.Context, disposition SnapshotDisposition, _ []byte) error {
+		seen = append(seen, disposition)
+		return nil
+	})
+
+	// Only ready snapshots get compiled, so only they go through the pool.
+	require.NoError(t, processor(ctx, SnapshotIncomplete, nil))
+	require.NoError(t, processor(ctx, SnapshotReady, nil))
+	require.NoError(t, processor(ctx, SnapshotDefer, nil))
+	assert.Equal(t, []SnapshotDisposition{SnapshotIncomplete, SnapshotReady, SnapshotDefer}, seen)
+
+	stats := pool.Stats()
+	require.Len(t, stats, 1)
+	assert.Equal(t, "tenant-a", stats[0].Tenant)
+	assert.Equal(t, uint64(1), stats[0].Compiles)
+}
@@ -0,0 +1,106 @@
+package entrypoint
+
+import (
+	"context"
+	"os"
+	"path"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/cpulimit"
+)
+
+// CPU pressure: the ambassador Module's load_shedding can have Envoy's overload manager shed
+// requests when the pod is short of CPU, but Envoy can't see how much of its CPU limit the pod is
+// using. So every interval, we work out what fraction of the limit (or, without a limit, of the
+// node's CPUs) the whole cgroup used since the last time, and write it to a file that Envoy's
+// injected_resource monitor reads. The monitor only notices a file that's been renamed into place,
+// so that's how it's written. See python/ambassador/ir/irloadshedding.py.
+
+// GetCPUPressureInterval returns how often to work out the CPU pressure, from
+// AMBASSADOR_CPU_PRESSURE_INTERVAL_SECONDS. Zero disables it.
+func GetCPUPressureInterval() time.Duration {
+	secs, err := strconv.Atoi(env("AMBASSADOR_CPU_PRESSURE_INTERVAL_SECONDS", "1"))
+	if err != nil || secs < 0 {
+		secs = 1
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// GetCPUPressureFile returns where the CPU pressure goes, from AMBASSADOR_CPU_PRESSURE_FILE. Keep
+// the default in sync with cpu_pressure_file in irloadshedding.py.
+func GetCPUPressureFile() string {
+	return env("AMBASSADOR_CPU_PRESSURE_FILE", path.Join(GetAmbassadorConfigBaseDir(), "cpu_pressure"))
+}
+
+// cpuPressure is the fraction of cpus CPUs that using used CPU time over elapsed comes to, from 0
+// to 1.
+func cpuPressure(used, elapsed time.Duration, cpus float64) float64 {
+	if elapsed <= 0 || cpus <= 0 || used <= 0 {
+		return 0
+	}
+	pressure := used.Seconds() / (elapsed.Seconds() * cpus)
+	if pressure > 1 {
+		pressure = 1
+	}
+	return pressure
+}
+
+// writeCPUPressure replaces file with one that holds pressure.
+func writeCPUPressure(file string, pressure float64) error {
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatFloat(pressure, 'f', 3, 64)), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// runCPUPressure writes the CPU pressure every interval until the context is done.
+func runCPUPressure(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return nil
+	}
+
+	cpus := float64(runtime.NumCPU())
+	limit, hasLimit, err := cpulimit.Read(cpulimit.DefaultCgroupRoot)
+	if err != nil {
+		dlog.Warnf(ctx, "CPU pressure: couldn't read the CPU limit, so going by the node's %v CPUs: %v", cpus, err)
+	} else if hasLimit && limit < cpus {
+		cpus = limit
+	}
+
+	file := GetCPUPressureFile()
+	last, err := cpulimit.Usage(cpulimit.DefaultCgroupRoot)
+	if err != nil {
+		dlog.Infof(ctx, "CPU pressure: couldn't read the CPU usage, so load shedding can't go by it: %v", err)
+		return nil
+	}
+	lastTime := time.Now()
+
+	// Start from no pressure, so that Envoy has something to read straight away.
+	if err := writeCPUPressure(file, 0); err != nil {
+		dlog.Errorf(ctx, "CPU pressure: %v", err)
+		return nil
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			usage, err := cpulimit.Usage(cpulimit.DefaultCgroupRoot)
+			if err != nil {
+				dlog.Errorf(ctx, "CPU pressure: %v", err)
+				continue
+			}
+			if err := writeCPUPressure(file, cpuPressure(usage-last, now.Sub(lastTime), cpus)); err != nil {
+				dlog.Errorf(ctx, "CPU pressure: %v", err)
+			}
+			last, lastTime = usage, now
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
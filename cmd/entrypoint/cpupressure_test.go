@@ -0,0 +1,39 @@
+package entrypoint
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCPUPressure(t *testing.T) {
+	t.Parallel()
+	assert.InDelta(t, 0.5, cpuPressure(time.Second, time.Second, 2), 0.0001)
+	assert.InDelta(t, 0.25, cpuPressure(250*time.Millisecond, 2*time.Second, 0.5), 0.0001)
+	// Bursting past the limit still only comes to 1.
+	assert.Equal(t, 1.0, cpuPressure(3*time.Second, time.Second, 2))
+	assert.Equal(t, 0.0, cpuPressure(time.Second, 0, 2))
+	assert.Equal(t, 0.0, cpuPressure(-time.Second, time.Second, 2))
+}
+
+func TestWriteCPUPressure(t *testing.T) {
+	t.Parallel()
+	file := filepath.Join(t.TempDir(), "cpu_pressure")
+
+	require.NoError(t, writeCPUPressure(file, 0.4567))
+	contents, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "0.457", string(contents))
+
+	require.NoError(t, writeCPUPressure(file, 1))
+	contents, err = os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "1.000", string(contents))
+
+	_, err = os.Stat(file + ".tmp")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
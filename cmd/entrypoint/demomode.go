@@ -14,6 +14,10 @@ import (
 // think, since we have to start our two demo services _and_ we have to prime
 // the acp.AmbassadorWatcher so that health checks work.
 func bootDemoMode(ctx context.Context, group *dgroup.Group, ambwatch *acp.AmbassadorWatcher) {
+	// There's no watcher in demo mode, so there's nothing to wait for.
+	startup := startupPlanFromContext(ctx)
+	startup.done(startupWatchers)
+
 	group.Go("demo_auth", func(ctx context.Context) error {
 		cmd := subcommand(ctx, "/usr/bin/python3", "/ambassador/demo-services/auth.py")
 		return cmd.Run()
@@ -43,6 +47,8 @@ func bootDemoMode(ctx context.Context, group *dgroup.Group, ambwatch *acp.Ambass
 					ambwatch.NoteSnapshotSent()
 					time.Sleep(5 * time.Millisecond)
 					ambwatch.NoteSnapshotProcessed()
+					startup.done(startupFirstSnapshot)
+					startup.done(startupDiagd)
 					break
 				}
 			}
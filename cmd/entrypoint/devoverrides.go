@@ -0,0 +1,272 @@
+package entrypoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+	"github.com/emissary-ingress/emissary/v3/pkg/debug"
+	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+// Dev overrides: with AMBASSADOR_DEV_OVERRIDES_FILE set, Emissary running on a laptop against a
+// real cluster can send selected Mappings to code running on the laptop instead. The file is YAML:
+//
+//	overrides:
+//	  - mapping: quote            # the Mapping named quote, in any namespace
+//	    target: localhost:3000
+//	  - mapping: quote
+//	    namespace: staging        # just the one in staging
+//	    target: http://quote-dev:8080   # e.g. a docker-compose service
+//	  - service: backend.default  # every Mapping whose service is backend.default
+//	    target: localhost:4000
+//
+// An override replaces the service of every Mapping (including ones in annotations) that it
+// selects with its target, and makes the Mapping use the kubernetes-service resolver, which just
+// looks the target up in DNS; if more than one override selects a Mapping, the first one wins.
+// Nothing in the cluster changes: this only changes what we hand to diagd.
+//
+// The file is checked for changes every devOverridesPoll, and applied again when it changes. If
+// it stops parsing, we keep using what it said last.
+const devOverridesPoll = time.Second
+
+// devOverridesDebugValue is the name of the debug value holding the []*DevOverrideBinding from the
+// most recent application.
+const devOverridesDebugValue = "devOverrides"
+
+// GetDevOverridesFile returns the dev overrides file, from AMBASSADOR_DEV_OVERRIDES_FILE. Empty
+// means there are no dev overrides.
+func GetDevOverridesFile() string {
+	return env("AMBASSADOR_DEV_OVERRIDES_FILE", "")
+}
+
+// The DevOverridesConfig struct is the dev overrides file.
+type DevOverridesConfig struct {
+	Overrides []DevOverride `json:"overrides"`
+}
+
+// The DevOverride struct sends the Mappings that it selects to its Target. It has to select by
+// Mapping, Service, or both.
+type DevOverride struct {
+	// Mapping selects Mappings by name, and Namespace (if it's set) by namespace.
+	Mapping   string `json:"mapping,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	// Service selects Mappings by their service, exactly as the Mapping says it.
+	Service string `json:"service,omitempty"`
+	// Target is the service to use instead.
+	Target string `json:"target"`
+}
+
+func (o DevOverride) matches(mapping *v3alpha1.Mapping, service string) bool {
+	if o.Mapping != "" && o.Mapping != mapping.GetName() {
+		return false
+	}
+	if o.Namespace != "" && o.Namespace != mapping.GetNamespace() {
+		return false
+	}
+	if o.Service != "" && o.Service != service {
+		return false
+	}
+	return true
+}
+
+// ParseDevOverrides parses a dev overrides file.
+func ParseDevOverrides(data []byte) ([]DevOverride, error) {
+	var config DevOverridesConfig
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, err
+	}
+	for i, o := range config.Overrides {
+		if o.Mapping == "" && o.Service == "" {
+			return nil, fmt.Errorf("override %d: needs a mapping or a service to select", i+1)
+		}
+		if o.Target == "" {
+			return nil, fmt.Errorf("override %d: needs a target", i+1)
+		}
+	}
+	return config.Overrides, nil
+}
+
+// DevOverrideBinding records a Mapping that a dev override applies to.
+type DevOverrideBinding struct {
+	// Mapping is the "namespace/name" of the Mapping.
+	Mapping string `json:"mapping"`
+	// Service is the Mapping's own service.
+	Service string `json:"service"`
+	// Target is the service it uses instead.
+	Target string `json:"target"`
+}
+
+// devOverrideOrig is what a Mapping had before we overrode it.
+type devOverrideOrig struct {
+	service  string
+	resolver string
+}
+
+// The devOverrides struct keeps the dev overrides file loaded, and applies it to the snapshot.
+// Like the revocationWatcher, it's nil-safe, and it's nil when there's no dev overrides file.
+type devOverrides struct {
+	filename string
+	clock    clock.Clock
+
+	// The changed method returns this channel. run writes to it (without blocking) when the file
+	// has changed.
+	coalescedDirty chan struct{}
+
+	// The mutex protects everything below.
+	mutex     sync.Mutex
+	modTime   time.Time // of the file, when we loaded it
+	overrides []DevOverride
+
+	// The Mappings that apply last overrode, and what they had before. Only the watcher loop
+	// touches this, under the SnapshotHolder's mutex.
+	applied map[*v3alpha1.Mapping]devOverrideOrig
+}
+
+// newDevOverrides loads the dev overrides file, if there is one.
+func newDevOverrides(ctx context.Context, filename string, clk clock.Clock) *devOverrides {
+	if filename == "" {
+		return nil
+	}
+	dlog.Infof(ctx, "Dev overrides: sending Mappings where %s says", filename)
+	do := &devOverrides{
+		filename:       filename,
+		clock:          clk,
+		coalescedDirty: make(chan struct{}, 1),
+	}
+	do.load(ctx)
+	return do
+}
+
+// load loads the file if it has changed since we last loaded it, and returns whether it had.
+func (do *devOverrides) load(ctx context.Context) bool {
+	do.mutex.Lock()
+	defer do.mutex.Unlock()
+
+	info, err := os.Stat(do.filename)
+	if errors.Is(err, os.ErrNotExist) {
+		// No file is no overrides, the same as an empty one.
+		if do.modTime.IsZero() && do.overrides == nil {
+			return false
+		}
+		do.modTime, do.overrides = time.Time{}, nil
+		return true
+	}
+	if err != nil {
+		dlog.Errorf(ctx, "Dev overrides: %v", err)
+		return false
+	}
+	if info.ModTime().Equal(do.modTime) {
+		return false
+	}
+	do.modTime = info.ModTime()
+
+	data, err := os.ReadFile(do.filename)
+	if err == nil {
+		var overrides []DevOverride
+		if overrides, err = ParseDevOverrides(data); err == nil {
+			do.overrides = overrides
+			return true
+		}
+	}
+	dlog.Errorf(ctx, "Dev overrides: %s: %v; still using what it said before", do.filename, err)
+	return false
+}
+
+// changed returns a channel that gets a value whenever the file has changed. It's nil if there's
+// no devOverrides, so it never does.
+func (do *devOverrides) changed() <-chan struct{} {
+	if do == nil {
+		return nil
+	}
+	return do.coalescedDirty
+}
+
+// run checks the file for changes, until the context is done.
+func (do *devOverrides) run(ctx context.Context) error {
+	if do == nil {
+		return nil
+	}
+	ticker := do.clock.NewTicker(devOverridesPoll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			if do.load(ctx) {
+				select {
+				case do.coalescedDirty <- struct{}{}:
+				default:
+				}
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// apply points the Mappings in the snapshot that the overrides select at their targets, and puts
+// back the ones that it pointed elsewhere last time but shouldn't any more. Mappings from
+// annotations are new every time PopulateAnnotations runs, but not in between, so they get put
+// back too.
+func (do *devOverrides) apply(ctx context.Context, k8sSnapshot *snapshotTypes.KubernetesSnapshot) {
+	if do == nil {
+		return
+	}
+	do.mutex.Lock()
+	overrides := do.overrides
+	do.mutex.Unlock()
+
+	for mapping, orig := range do.applied {
+		mapping.Spec.Service = orig.service
+		mapping.Spec.Resolver = orig.resolver
+	}
+
+	mappings := append([]*v3alpha1.Mapping(nil), k8sSnapshot.Mappings...)
+	for _, list := range k8sSnapshot.Annotations {
+		for _, obj := range list {
+			if mapping, ok := obj.(*v3alpha1.Mapping); ok {
+				mappings = append(mappings, mapping)
+			}
+		}
+	}
+
+	applied := make(map[*v3alpha1.Mapping]devOverrideOrig)
+	var bindings []*DevOverrideBinding
+	for _, mapping := range mappings {
+		for _, o := range overrides {
+			if !o.matches(mapping, mapping.Spec.Service) {
+				continue
+			}
+			applied[mapping] = devOverrideOrig{
+				service:  mapping.Spec.Service,
+				resolver: mapping.Spec.Resolver,
+			}
+			bindings = append(bindings, &DevOverrideBinding{
+				Mapping: mapping.GetNamespace() + "/" + mapping.GetName(),
+				Service: mapping.Spec.Service,
+				Target:  o.Target,
+			})
+			mapping.Spec.Service = o.Target
+			mapping.Spec.Resolver = "kubernetes-service"
+			break
+		}
+	}
+	do.applied = applied
+
+	sort.Slice(bindings, func(i, j int) bool {
+		return bindings[i].Mapping < bindings[j].Mapping
+	})
+	for _, binding := range bindings {
+		dlog.Debugf(ctx, "Dev overrides: Mapping %s: %s -> %s", binding.Mapping, binding.Service, binding.Target)
+	}
+	debug.FromContext(ctx).Value(devOverridesDebugValue).Store(bindings)
+}
@@ -0,0 +1,221 @@
+package entrypoint
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+)
+
+// The diagd gate: diagd does one thing at a time, so while it's compiling a snapshot, every diag UI
+// request that the health check server hands it is something else standing between the snapshot
+// and Envoy. The gate sits in front of the proxy to diagd, and knows when diagd is compiling (from
+// the snapshot hand-offs in notify.go). While it is, up to AMBASSADOR_DIAGD_GATE_QUEUE diag UI
+// requests wait for it to finish, for up to AMBASSADOR_DIAGD_GATE_WAIT_SECONDS; anything beyond
+// that gets a 503, with a Retry-After based on how long the last compile took.
+//
+// Requests that something depends on (metrics scrapes, health checks, and diagd's own internal
+// endpoints) always go straight through.
+
+// diagdEssentialPaths are the path prefixes that the gate never holds up.
+var diagdEssentialPaths = []string{
+	"/metrics",
+	"/_internal/",
+	"/ambassador/v0/check_",
+}
+
+// GetDiagdGateQueue returns how many diag UI requests can wait while diagd is compiling, from
+// AMBASSADOR_DIAGD_GATE_QUEUE. Zero turns every one of them away.
+func GetDiagdGateQueue() int {
+	queue, err := strconv.Atoi(env("AMBASSADOR_DIAGD_GATE_QUEUE", "4"))
+	if err != nil || queue < 0 {
+		queue = 4
+	}
+	return queue
+}
+
+// GetDiagdGateWait returns how long a diag UI request waits for diagd to finish compiling before
+// it's turned away, from AMBASSADOR_DIAGD_GATE_WAIT_SECONDS.
+func GetDiagdGateWait() time.Duration {
+	secs, err := strconv.Atoi(env("AMBASSADOR_DIAGD_GATE_WAIT_SECONDS", "5"))
+	if err != nil || secs < 0 {
+		secs = 5
+	}
+	return time.Duration(secs) * time.Second
+}
+
+type diagdGate struct {
+	clock    clock.Clock
+	maxQueue int
+	maxWait  time.Duration
+
+	mu          sync.Mutex
+	compiling   bool
+	since       time.Time
+	lastCompile time.Duration
+	idle        chan struct{} // closed when diagd finishes compiling
+	queued      int
+	queuedTotal uint64
+	shedTotal   uint64
+}
+
+func newDiagdGate(clk clock.Clock) *diagdGate {
+	return &diagdGate{
+		clock:    clk,
+		maxQueue: GetDiagdGateQueue(),
+		maxWait:  GetDiagdGateWait(),
+	}
+}
+
+type diagdGateKey struct{}
+
+// withDiagdGate returns a copy of ctx that carries the diagd gate.
+func withDiagdGate(ctx context.Context, g *diagdGate) context.Context {
+	return context.WithValue(ctx, diagdGateKey{}, g)
+}
+
+// diagdGateFromContext returns the diagd gate, or nil if there isn't one. A nil gate lets
+// everything through.
+func diagdGateFromContext(ctx context.Context) *diagdGate {
+	g, _ := ctx.Value(diagdGateKey{}).(*diagdGate)
+	return g
+}
+
+// startCompile notes that a snapshot has been handed to diagd.
+func (g *diagdGate) startCompile() {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.compiling {
+		return
+	}
+	g.compiling = true
+	g.since = g.clock.Now()
+	g.idle = make(chan struct{})
+}
+
+// finishCompile notes that diagd is done with the snapshot, and lets the waiting requests go.
+func (g *diagdGate) finishCompile() {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.compiling {
+		return
+	}
+	g.compiling = false
+	g.lastCompile = g.clock.Now().Sub(g.since)
+	close(g.idle)
+}
+
+// admit waits, if need be, for diagd to finish compiling. It returns false if the request should
+// be turned away instead, along with how long to tell the client to wait before trying again.
+func (g *diagdGate) admit(ctx context.Context) (bool, time.Duration) {
+	g.mu.Lock()
+	if !g.compiling {
+		g.mu.Unlock()
+		return true, 0
+	}
+	if g.queued >= g.maxQueue {
+		defer g.mu.Unlock()
+		g.shedTotal++
+		return false, g.retryAfterLocked()
+	}
+	g.queued++
+	g.queuedTotal++
+	idle := g.idle
+	g.mu.Unlock()
+
+	timer := g.clock.NewTimer(g.maxWait)
+	defer timer.Stop()
+	finished := false
+	select {
+	case <-idle:
+		finished = true
+	case <-timer.C():
+	case <-ctx.Done():
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.queued--
+	if !finished {
+		g.shedTotal++
+		return false, g.retryAfterLocked()
+	}
+	return true, 0
+}
+
+// retryAfterLocked guesses how long diagd will keep compiling, from how long it took last time, in
+// whole seconds and at least one. The caller must hold g.mu.
+func (g *diagdGate) retryAfterLocked() time.Duration {
+	remaining := g.lastCompile - g.clock.Now().Sub(g.since)
+	if remaining < time.Second {
+		return time.Second
+	}
+	return (remaining + time.Second - 1).Truncate(time.Second)
+}
+
+// handler wraps next, which hands requests to diagd, in the gate.
+func (g *diagdGate) handler(next http.Handler) http.Handler {
+	if g == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isDiagdEssential(r.URL.Path) {
+			if ok, retry := g.admit(r.Context()); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(retry/time.Second)))
+				http.Error(w, "diagd is busy reconfiguring, try again shortly\n", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isDiagdEssential(path string) bool {
+	for _, prefix := range diagdEssentialPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// diagdGateMetrics renders the gate's state in the Prometheus text format, to add to what diagd
+// serves on /metrics.
+func diagdGateMetrics(g *diagdGate) []byte {
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	compiling, lastCompile, queued, shed := 0, g.lastCompile, g.queuedTotal, g.shedTotal
+	if g.compiling {
+		compiling = 1
+	}
+	g.mu.Unlock()
+
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "# HELP ambassador_diagd_compiling Whether diagd is compiling a snapshot.")
+	fmt.Fprintln(&buf, "# TYPE ambassador_diagd_compiling gauge")
+	fmt.Fprintf(&buf, "ambassador_diagd_compiling %d\n", compiling)
+	fmt.Fprintln(&buf, "# HELP ambassador_diagd_last_compile_seconds How long diagd took to compile the last snapshot.")
+	fmt.Fprintln(&buf, "# TYPE ambassador_diagd_last_compile_seconds gauge")
+	fmt.Fprintf(&buf, "ambassador_diagd_last_compile_seconds %s\n", strconv.FormatFloat(lastCompile.Seconds(), 'f', -1, 64))
+	fmt.Fprintln(&buf, "# HELP ambassador_diag_requests_queued_total Diag UI requests that waited for diagd to finish compiling.")
+	fmt.Fprintln(&buf, "# TYPE ambassador_diag_requests_queued_total counter")
+	fmt.Fprintf(&buf, "ambassador_diag_requests_queued_total %d\n", queued)
+	fmt.Fprintln(&buf, "# HELP ambassador_diag_requests_shed_total Diag UI requests turned away because diagd was compiling.")
+	fmt.Fprintln(&buf, "# TYPE ambassador_diag_requests_shed_total counter")
+	fmt.Fprintf(&buf, "ambassador_diag_requests_shed_total %d\n", shed)
+	return buf.Bytes()
+}
@@ -0,0 +1,67 @@
+package entrypoint
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+)
+
+func TestDiagdGate(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	g := &diagdGate{clock: clk, maxQueue: 1, maxWait: 5 * time.Second}
+	h := g.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+	// getAsync starts a request that's expected to wait in the queue.
+	getAsync := func(path string) <-chan *httptest.ResponseRecorder {
+		timers := clk.Timers()
+		ch := make(chan *httptest.ResponseRecorder, 1)
+		go func() { ch <- get(path) }()
+		require.Eventually(t, func() bool { return clk.Timers() > timers }, time.Second, time.Millisecond)
+		return ch
+	}
+
+	// diagd isn't compiling, so everything goes through.
+	assert.Equal(t, http.StatusOK, get("/ambassador/v0/diag/").Code)
+
+	// The first UI request waits for diagd to finish; the second doesn't fit in the queue. Metrics
+	// don't wait at all.
+	g.startCompile()
+	waiting := getAsync("/ambassador/v0/diag/")
+	shed := get("/ambassador/v0/diag/?json=true")
+	assert.Equal(t, http.StatusServiceUnavailable, shed.Code)
+	assert.Equal(t, "1", shed.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, get("/metrics").Code)
+
+	clk.Advance(3 * time.Second)
+	g.finishCompile()
+	assert.Equal(t, http.StatusOK, (<-waiting).Code)
+
+	// The last compile took 3s, so that's about how long to come back after.
+	g.startCompile()
+	clk.Advance(time.Second)
+	waiting = getAsync("/ambassador/v0/diag/")
+	assert.Equal(t, "2", get("/ambassador/v0/diag/").Header().Get("Retry-After"))
+
+	// Waiting too long gets a 503 too.
+	clk.Advance(5 * time.Second)
+	assert.Equal(t, http.StatusServiceUnavailable, (<-waiting).Code)
+	g.finishCompile()
+
+	metrics := string(diagdGateMetrics(g))
+	assert.True(t, strings.Contains(metrics, "ambassador_diagd_compiling 0\n"), metrics)
+	assert.True(t, strings.Contains(metrics, "ambassador_diag_requests_queued_total 2\n"), metrics)
+	assert.True(t, strings.Contains(metrics, "ambassador_diag_requests_shed_total 3\n"), metrics)
+}
@@ -0,0 +1,217 @@
+package entrypoint
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+	"github.com/emissary-ingress/emissary/v3/pkg/envoystats"
+)
+
+// Drain visibility: when Envoy is draining connections, whether for shutdown or because a
+// configuration change replaced some of its listeners, /ambassador/v0/drain says how many
+// downstream connections are still open on each listener, and estimates when they'll be gone, from
+// how fast they've been going so far. Automation (a preStop hook, a rollout script) can wait on it
+// for the drain to actually finish, rather than sleeping for however long a drain might take.
+//
+// A GET with ?wait=<duration> doesn't answer until the drain is done (or, if it's only listeners
+// draining, until they're gone), or until the wait is up, whichever comes first.
+
+// maxDrainWait is the longest a request may wait for a drain to finish.
+const maxDrainWait = 5 * time.Minute
+
+// drainListener is the connections open on one of Envoy's listeners.
+type drainListener struct {
+	Address           string `json:"address"`
+	ActiveConnections uint64 `json:"active_connections"`
+}
+
+// drainReport is how Envoy's drain is going.
+type drainReport struct {
+	Time time.Time `json:"time"`
+	// Draining is whether anything is draining: Envoy as a whole (as for shutdown), or some of
+	// its listeners (after a configuration change).
+	Draining          bool `json:"draining"`
+	ShuttingDown      bool `json:"shutting_down"`
+	ListenersDraining int  `json:"listeners_draining"`
+	// Drained is whether Envoy was draining, and has no connections left.
+	Drained          bool            `json:"drained"`
+	TotalConnections uint64          `json:"total_connections"`
+	Listeners        []drainListener `json:"listeners"`
+	DrainStarted     *time.Time      `json:"drain_started,omitempty"`
+	// EstimatedDrained is when the connections should all be gone, if they keep closing at the
+	// rate they have been. It's missing if they haven't been closing.
+	EstimatedDrained *time.Time `json:"estimated_drained_at,omitempty"`
+	// Deadline is when shutdown stops waiting for the drain, if we're shutting down, or when
+	// Envoy closes whatever's left on the listeners it's draining, if we're not.
+	Deadline *time.Time `json:"deadline,omitempty"`
+}
+
+// drainSample is how many connections Envoy had open at a moment during a drain.
+type drainSample struct {
+	at          time.Time
+	connections uint64
+}
+
+// drainTracker follows a drain across requests, so that it can tell how fast it's going.
+type drainTracker struct {
+	clock    clock.Clock
+	adminURL string
+	client   *http.Client
+
+	mu sync.Mutex
+	// first is the first sample of the drain in progress, if there is one.
+	first *drainSample
+}
+
+func newDrainTracker(clk clock.Clock, adminURL string) *drainTracker {
+	return &drainTracker{
+		clock:    clk,
+		adminURL: adminURL,
+		client:   &http.Client{Timeout: 2 * time.Second},
+	}
+}
+
+// report scrapes Envoy's drain stats, and reports on them.
+func (d *drainTracker) report(ctx context.Context, plan *shutdownPlan) (*drainReport, error) {
+	stats, err := envoystats.ScrapeDrain(ctx, d.client, d.adminURL)
+	if err != nil {
+		return nil, err
+	}
+	var shutdown ShutdownStatus
+	if plan != nil {
+		shutdown = plan.Status()
+	}
+	return d.update(stats, shutdown), nil
+}
+
+// update reports on a new scrape of Envoy's drain stats, and the shutdown plan's status.
+func (d *drainTracker) update(stats *envoystats.DrainStats, shutdown ShutdownStatus) *drainReport {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.clock.Now()
+	report := &drainReport{
+		Time:              now,
+		ShuttingDown:      shutdown.ShuttingDown,
+		ListenersDraining: stats.ListenersDraining,
+		TotalConnections:  stats.TotalConnections,
+		Listeners:         []drainListener{},
+	}
+	for address, active := range stats.Listeners {
+		report.Listeners = append(report.Listeners, drainListener{Address: address, ActiveConnections: active})
+	}
+	sort.Slice(report.Listeners, func(i, j int) bool {
+		return report.Listeners[i].Address < report.Listeners[j].Address
+	})
+
+	// Shutdown drains all of Envoy when its envoy phase starts, and gives up after the drain
+	// time.
+	serverDraining := stats.Draining
+	var started *time.Time
+	for _, ph := range shutdown.Phases {
+		if ph.Name == shutdownEnvoy && ph.Started != nil {
+			serverDraining = true
+			started = ph.Started
+			deadline := ph.Started.Add(GetShutdownDrainTime())
+			report.Deadline = &deadline
+		}
+	}
+
+	report.Draining = serverDraining || stats.ListenersDraining > 0
+	if !report.Draining {
+		d.first = nil
+		return report
+	}
+	if d.first == nil {
+		d.first = &drainSample{at: now, connections: stats.TotalConnections}
+	}
+	if started == nil {
+		started = &d.first.at
+	}
+	report.DrainStarted = started
+
+	if !serverDraining {
+		// Only some listeners are draining, and their connections can't be told apart from
+		// the ones on the listeners that replaced them, so all we know is that Envoy closes
+		// whatever's left once its drain time is up (counting from when we noticed, which is
+		// as late as it could have started).
+		deadline := d.first.at.Add(GetEnvoyDrainTime())
+		report.Deadline = &deadline
+		report.EstimatedDrained = &deadline
+		return report
+	}
+
+	report.Drained = stats.TotalConnections == 0
+	if report.Drained {
+		report.EstimatedDrained = &now
+	} else if closed := int64(d.first.connections) - int64(stats.TotalConnections); closed > 0 {
+		rate := float64(closed) / now.Sub(d.first.at).Seconds()
+		eta := now.Add(time.Duration(float64(stats.TotalConnections) / rate * float64(time.Second)))
+		if report.Deadline != nil && eta.After(*report.Deadline) {
+			eta = *report.Deadline
+		}
+		report.EstimatedDrained = &eta
+	}
+	return report
+}
+
+// handleDrain reports on Envoy's drain, waiting for it to finish if asked to.
+func handleDrain(w http.ResponseWriter, r *http.Request, d *drainTracker, plan *shutdownPlan) {
+	var wait time.Duration
+	if s := r.URL.Query().Get("wait"); s != "" {
+		var err error
+		wait, err = time.ParseDuration(s)
+		if err != nil || wait < 0 {
+			http.Error(w, "wait must be a duration, like 30s\n", http.StatusBadRequest)
+			return
+		}
+		if wait > maxDrainWait {
+			wait = maxDrainWait
+		}
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	timer := d.clock.AfterFunc(wait, cancel)
+	defer timer.Stop()
+	ticker := d.clock.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var report *drainReport
+	wasDraining := false
+	for {
+		// Running out of wait shouldn't cut the last scrape short, so it doesn't get ctx.
+		latest, err := d.report(r.Context(), plan)
+		if err != nil {
+			if report == nil {
+				http.Error(w, "can't get Envoy's stats: "+err.Error()+"\n", http.StatusServiceUnavailable)
+				return
+			}
+			break
+		}
+		report = latest
+		if wait == 0 || report.Drained || (wasDraining && !report.Draining) {
+			break
+		}
+		wasDraining = report.Draining
+		select {
+		case <-ticker.C():
+			continue
+		case <-ctx.Done():
+		}
+		break
+	}
+
+	bytes, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(append(bytes, '\n'))
+}
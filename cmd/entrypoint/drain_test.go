@@ -0,0 +1,81 @@
+package entrypoint
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+	"github.com/emissary-ingress/emissary/v3/pkg/envoystats"
+)
+
+func TestDrainTrackerShutdown(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+	d := newDrainTracker(clk, "")
+
+	report := d.update(&envoystats.DrainStats{
+		TotalConnections: 100,
+		Listeners:        map[string]uint64{"0.0.0.0_8443": 70, "0.0.0.0_8080": 30},
+	}, ShutdownStatus{})
+	assert.False(t, report.Draining)
+	assert.Nil(t, report.DrainStarted)
+	assert.Equal(t, []drainListener{
+		{Address: "0.0.0.0_8080", ActiveConnections: 30},
+		{Address: "0.0.0.0_8443", ActiveConnections: 70},
+	}, report.Listeners)
+
+	shutdown := ShutdownStatus{
+		ShuttingDown: true,
+		Started:      &start,
+		Phases:       []ShutdownPhaseStatus{{Name: shutdownEnvoy, Started: &start}},
+	}
+	report = d.update(&envoystats.DrainStats{Draining: true, TotalConnections: 100}, shutdown)
+	assert.True(t, report.Draining)
+	assert.False(t, report.Drained)
+	require.NotNil(t, report.Deadline)
+	assert.Equal(t, start.Add(GetShutdownDrainTime()), *report.Deadline)
+	// Nothing has closed yet, so there's no telling when it'll be done.
+	assert.Nil(t, report.EstimatedDrained)
+
+	// 40 closed in 2 seconds, so the other 60 should take 3 more.
+	clk.Advance(2 * time.Second)
+	report = d.update(&envoystats.DrainStats{Draining: true, TotalConnections: 60}, shutdown)
+	require.NotNil(t, report.EstimatedDrained)
+	assert.Equal(t, start.Add(5*time.Second), *report.EstimatedDrained)
+
+	// If new connections come in, it'll take longer than shutdown waits, so it's done when
+	// shutdown stops waiting.
+	clk.Advance(time.Second)
+	report = d.update(&envoystats.DrainStats{Draining: true, TotalConnections: 95}, shutdown)
+	assert.Equal(t, *report.Deadline, *report.EstimatedDrained)
+
+	clk.Advance(time.Second)
+	report = d.update(&envoystats.DrainStats{Draining: true}, shutdown)
+	assert.True(t, report.Drained)
+	assert.Equal(t, start, *report.DrainStarted)
+}
+
+func TestDrainTrackerListeners(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+	d := newDrainTracker(clk, "")
+
+	report := d.update(&envoystats.DrainStats{ListenersDraining: 1, TotalConnections: 10}, ShutdownStatus{})
+	assert.True(t, report.Draining)
+	assert.False(t, report.Drained)
+	assert.Equal(t, start.Add(GetEnvoyDrainTime()), *report.EstimatedDrained)
+
+	// Once the listeners are gone, so is the drain.
+	clk.Advance(time.Minute)
+	report = d.update(&envoystats.DrainStats{TotalConnections: 10}, ShutdownStatus{})
+	assert.False(t, report.Draining)
+	assert.Nil(t, report.DrainStarted)
+
+	// The next one starts over.
+	clk.Advance(time.Minute)
+	report = d.update(&envoystats.DrainStats{ListenersDraining: 2, TotalConnections: 10}, ShutdownStatus{})
+	assert.Equal(t, start.Add(2*time.Minute), *report.DrainStarted)
+}
@@ -9,15 +9,21 @@ import (
 	"github.com/emissary-ingress/emissary/v3/pkg/ambex"
 	"github.com/emissary-ingress/emissary/v3/pkg/consulwatch"
 	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	"github.com/emissary-ingress/emissary/v3/pkg/resolverplugin"
 	"github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
 )
 
-func makeEndpoints(ctx context.Context, ksnap *snapshot.KubernetesSnapshot, consulEndpoints map[string]consulwatch.Endpoints) *ambex.Endpoints {
+func makeEndpoints(ctx context.Context, ksnap *snapshot.KubernetesSnapshot, consulEndpoints map[string]consulwatch.Endpoints, pluginEndpoints map[string]resolverplugin.Endpoints, subsetKeys map[string]map[string]bool) *ambex.Endpoints {
 	k8sServices := map[string]*kates.Service{}
 	for _, svc := range ksnap.Services {
 		k8sServices[key(svc)] = svc
 	}
 
+	// We only know zones and labels if we're watching EndpointSlices (and Pods), which we only
+	// do for zone-aware routing (see zones.go) and subset routing (see subsets.go).
+	zones := endpointZones(ksnap.EndpointSlices)
+	labels := endpointLabels(ksnap.EndpointSlices, ksnap.SubsetPods, subsetKeys)
+
 	result := map[string][]*ambex.Endpoint{}
 
 	for _, k8sEp := range ksnap.Endpoints {
@@ -26,6 +32,8 @@ func makeEndpoints(ctx context.Context, ksnap *snapshot.KubernetesSnapshot, cons
 			continue
 		}
 		for _, ep := range k8sEndpointsToAmbex(k8sEp, svc) {
+			ep.Zone = zones[key(k8sEp)][ep.Ip]
+			ep.Labels = labels[key(k8sEp)][ep.Ip]
 			result[ep.ClusterName] = append(result[ep.ClusterName], ep)
 		}
 	}
@@ -36,7 +44,13 @@ func makeEndpoints(ctx context.Context, ksnap *snapshot.KubernetesSnapshot, cons
 		}
 	}
 
-	return &ambex.Endpoints{Entries: result}
+	for _, pluginEp := range pluginEndpoints {
+		for _, ep := range pluginEndpointsToAmbex(ctx, pluginEp) {
+			result[ep.ClusterName] = append(result[ep.ClusterName], ep)
+		}
+	}
+
+	return &ambex.Endpoints{Entries: result, Zones: zoneRouting(ctx, ksnap)}
 }
 
 func key(resource kates.Object) string {
@@ -116,3 +130,23 @@ func consulEndpointsToAmbex(ctx context.Context, endpoints consulwatch.Endpoints
 
 	return
 }
+
+func pluginEndpointsToAmbex(ctx context.Context, endpoints resolverplugin.Endpoints) (result []*ambex.Endpoint) {
+	for _, ep := range endpoints.Endpoints {
+		addrs, err := net.LookupHost(ep.Address)
+		if err != nil {
+			dlog.Errorf(ctx, "error resolving address %s from PluginResolver %s: %+v", ep.Address, endpoints.Resolver, err)
+			continue
+		}
+		for _, addr := range addrs {
+			result = append(result, &ambex.Endpoint{
+				ClusterName: fmt.Sprintf("plugin/%s/%s", endpoints.Resolver, endpoints.Service),
+				Ip:          addr,
+				Port:        ep.Port,
+				Protocol:    "TCP",
+			})
+		}
+	}
+
+	return
+}
@@ -180,6 +180,71 @@ spec:
 	assert.Equal(t, "1.2.3.4", endpoints.Entries["k8s/default/foo/80"][0].Ip)
 }
 
+// Test that with subset routing, the endpoints carry the labels of the Pods behind them that
+// Mappings pick subsets by.
+func TestEndpointRoutingSubsets(t *testing.T) {
+	t.Setenv("AMBASSADOR_SUBSET_ROUTING", "true")
+	f := entrypoint.RunFake(t, entrypoint.FakeConfig{}, nil)
+	assert.NoError(t, f.Upsert(makeService("default", "foo")))
+	subset, err := makeSubset(8080, "1.2.3.4", "1.2.3.5")
+	require.NoError(t, err)
+	assert.NoError(t, f.Upsert(makeEndpoints("default", "foo", subset)))
+	assert.NoError(t, f.UpsertYAML(`
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+metadata:
+  name: foo-v2
+  namespace: default
+spec:
+  prefix: /foo
+  resolver: endpoint
+  service: foo.default
+  subset_labels:
+    version: v2
+---
+apiVersion: discovery.k8s.io/v1
+kind: EndpointSlice
+metadata:
+  name: foo-abcde
+  namespace: default
+  labels:
+    kubernetes.io/service-name: foo
+addressType: IPv4
+endpoints:
+- addresses: ["1.2.3.4"]
+  targetRef: {kind: Pod, namespace: default, name: foo-v1}
+- addresses: ["1.2.3.5"]
+  targetRef: {kind: Pod, namespace: default, name: foo-v2}
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: foo-v1
+  namespace: default
+  labels: {app: foo, version: v1}
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: foo-v2
+  namespace: default
+  labels: {app: foo, version: v2}
+`))
+	f.Flush()
+
+	endpoints, err := f.GetEndpoints(HasEndpoints("k8s/default/foo/80"))
+	require.NoError(t, err)
+	labels := map[string]map[string]string{}
+	for _, ep := range endpoints.Entries["k8s/default/foo/80"] {
+		labels[ep.Ip] = ep.Labels
+	}
+	assert.Equal(t, map[string]map[string]string{
+		"1.2.3.4": {"version": "v1"},
+		"1.2.3.5": {"version": "v2"},
+	}, labels)
+}
+
 func ClusterNameContains(substring string) func(*v3cluster.Cluster) bool {
 	return func(c *v3cluster.Cluster) bool {
 		return strings.Contains(c.Name, substring)
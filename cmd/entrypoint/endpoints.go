@@ -22,6 +22,10 @@ type endpointRoutingInfo struct {
 	module          moduleResolver
 	endpointWatches map[string]bool // A set to track the subset of kubernetes endpoints we care about.
 	previousWatches map[string]bool
+	// Map from "namespace:service" to the set of Pod label keys that Mappings to that
+	// service pick subsets by (see subsets.go).
+	subsetKeys         map[string]map[string]bool
+	previousSubsetKeys map[string]map[string]bool
 }
 
 type ResolverType int
@@ -30,6 +34,7 @@ const (
 	KubernetesServiceResolver ResolverType = iota
 	KubernetesEndpointResolver
 	ConsulResolver
+	PluginResolver
 )
 
 func (rt ResolverType) String() string {
@@ -40,6 +45,8 @@ func (rt ResolverType) String() string {
 		return "KubernetesEndpointResolver"
 	case ConsulResolver:
 		return "ConsulResolver"
+	case PluginResolver:
+		return "PluginResolver"
 	default:
 		panic(fmt.Errorf("ResolverType.String: invalid enum value: %d", rt))
 	}
@@ -59,6 +66,7 @@ func newEndpointRoutingInfo() endpointRoutingInfo {
 		resolverTypes: make(map[string]ResolverType),
 		// Track which endpoints we actually want to watch.
 		endpointWatches: make(map[string]bool),
+		subsetKeys:      make(map[string]map[string]bool),
 	}
 }
 
@@ -71,6 +79,8 @@ func (eri *endpointRoutingInfo) reconcileEndpointWatches(ctx context.Context, s
 	eri.module = moduleResolver{}
 	eri.previousWatches = eri.endpointWatches
 	eri.endpointWatches = map[string]bool{}
+	eri.previousSubsetKeys = eri.subsetKeys
+	eri.subsetKeys = map[string]map[string]bool{}
 
 	// Phase one processes all the configuration stuff that Mappings depend on. Right now this
 	// includes Modules and Resolvers. When we are done with Phase one we have processed enough
@@ -114,6 +124,12 @@ func (eri *endpointRoutingInfo) reconcileEndpointWatches(ctx context.Context, s
 		}
 	}
 
+	for _, r := range s.PluginResolvers {
+		if r.Spec.AmbassadorID.Matches(envAmbID) {
+			eri.saveResolver(ctx, r.GetName(), PluginResolver, "CRD")
+		}
+	}
+
 	// Once all THAT is done, make sure to define the default "endpoint" and
 	// "kubernetes-endpoint" resolvers if they don't exist.
 	for _, rName := range []string{"endpoint", "kubernetes-endpoint"} {
@@ -150,7 +166,8 @@ func (eri *endpointRoutingInfo) reconcileEndpointWatches(ctx context.Context, s
 }
 
 func (eri *endpointRoutingInfo) watchesChanged() bool {
-	return !reflect.DeepEqual(eri.endpointWatches, eri.previousWatches)
+	return !reflect.DeepEqual(eri.endpointWatches, eri.previousWatches) ||
+		!reflect.DeepEqual(eri.subsetKeys, eri.previousSubsetKeys)
 }
 
 // checkResourcePhase1 processes Modules and Resolvers and calls the correct type specific handler.
@@ -226,9 +243,33 @@ func (eri *endpointRoutingInfo) checkMapping(ctx context.Context, mapping *amb.M
 		dlog.Debugf(ctx, "WATCHER: Mapping %s uses the default resolver (%s)", name, source)
 	}
 
+	eri.watchMappingService(ctx, mapping, service, resolver)
+
+	// Each of the Mapping's upstreams is resolved just like its service, with its own resolver
+	// or the Mapping's.
+	for _, upstream := range mapping.Spec.Upstreams {
+		upstreamResolver := upstream.Resolver
+		if upstreamResolver == "" {
+			upstreamResolver = resolver
+		}
+		eri.watchMappingService(ctx, mapping, upstream.Service, upstreamResolver)
+	}
+}
+
+// watchMappingService watches the endpoints of a service that a Mapping sends requests to, if
+// its resolver is an endpoint resolver.
+func (eri *endpointRoutingInfo) watchMappingService(ctx context.Context, mapping *amb.Mapping, service, resolver string) {
 	if eri.resolverTypes[resolver] == KubernetesEndpointResolver {
 		svc, ns, _ := eri.module.parseService(ctx, mapping, service, mapping.GetNamespace())
-		eri.endpointWatches[fmt.Sprintf("%s:%s", ns, svc)] = true
+		key := fmt.Sprintf("%s:%s", ns, svc)
+		eri.endpointWatches[key] = true
+
+		for k := range mapping.Spec.SubsetLabels {
+			if eri.subsetKeys[key] == nil {
+				eri.subsetKeys[key] = map[string]bool{}
+			}
+			eri.subsetKeys[key][k] = true
+		}
 	}
 }
 
@@ -8,20 +8,24 @@ import (
 	"os/signal"
 	"path"
 	"strings"
-	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/google/uuid"
 
+	"github.com/datawire/dlib/dcontext"
 	"github.com/datawire/dlib/dgroup"
 	"github.com/datawire/dlib/dlog"
 	"github.com/emissary-ingress/emissary/v3/pkg/acp"
 	"github.com/emissary-ingress/emissary/v3/pkg/ambex"
 	"github.com/emissary-ingress/emissary/v3/pkg/busy"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+	"github.com/emissary-ingress/emissary/v3/pkg/eventbus"
+	"github.com/emissary-ingress/emissary/v3/pkg/featuregate"
 	"github.com/emissary-ingress/emissary/v3/pkg/kates"
 	"github.com/emissary-ingress/emissary/v3/pkg/logutil"
 	"github.com/emissary-ingress/emissary/v3/pkg/memory"
+	"github.com/emissary-ingress/emissary/v3/pkg/resolverplugin"
 )
 
 // This is the main ambassador entrypoint. It launches and manages two other
@@ -89,12 +93,28 @@ func Main(ctx context.Context, Version string, args ...string) error {
 		}
 	}
 
+	// Send the logs to syslog, or to a file, as well as to stdout, if asked to.
+	logSinks := setupLogSinks(ctx)
+	defer closeLogSinks(ctx, logSinks)
+
 	// The agent service is no longer supported, so clear it out.
 	// For good measure, we also unconditionally return the empty
 	// string in GetAgentService().
 	os.Unsetenv("AGENT_SERVICE")
 	dlog.Infof(ctx, "Started Ambassador (Version %s)", Version)
 
+	// Size the Go runtime (and, with their flags, Envoy and diagd) by the CPU limit.
+	alignConcurrency(ctx)
+
+	// Work out whether Envoy can bind privileged ports, and how, before diagd needs to know.
+	alignPrivilegedPorts(ctx)
+
+	// Make sure that we can run AMBASSADOR_ENVOY_BINARY, if it's set, before diagd generates
+	// configuration for it.
+	if err := alignEnvoyBinary(ctx); err != nil {
+		return err
+	}
+
 	demoMode := false
 
 	// XXX Yes, this is a disgusting hack. We can switch to a legit argument
@@ -109,6 +129,55 @@ func Main(ctx context.Context, Version string, args ...string) error {
 	os.Setenv("AMBASSADOR_CLUSTER_ID", clusterID)
 	dlog.Infof(ctx, "AMBASSADOR_CLUSTER_ID=%s", clusterID)
 
+	// Feature gates are settled once, at startup. Bad gate settings are logged but not fatal:
+	// better to come up with the defaults than not at all.
+	gates, gateErrs := featuregate.Load(GetFeatureGatesDir(), env("AMBASSADOR_FEATURE_GATES", ""))
+	for _, err := range gateErrs {
+		dlog.Errorf(ctx, "Ignoring bad feature gate setting: %v", err)
+	}
+	for _, status := range gates.Statuses() {
+		if status.Source != featuregate.SourceDefault {
+			dlog.Infof(ctx, "Feature gate %s=%t (from %s)", status.Name, status.Enabled, status.Source)
+		}
+	}
+	// diagd needs to see the same gates we do, however they were set.
+	os.Setenv("AMBASSADOR_FEATURE_GATES", gates.String())
+	ctx = featuregate.NewContext(ctx, gates)
+
+	// The health check server freezes and thaws configuration pushes, and ambex obeys.
+	ctx = ambex.WithFreezer(ctx, ambex.NewFreezer())
+
+	// The health check server holds up diag UI requests while diagd is compiling a snapshot.
+	ctx = withDiagdGate(ctx, newDiagdGate(clock.FromContext(ctx)))
+
+	// The watcher and supervise tell the rest of the subsystems what's going on over the event bus.
+	ctx = eventbus.WithBus(ctx, eventbus.New())
+
+	// The admin endpoints check who's asking, if AMBASSADOR_ADMIN_AUTH says to.
+	ctx = withAdminAuthorizer(ctx, newAdminAuthorizer(ctx))
+
+	// diagd hands its status updates to the status writer, to write in rate-limited batches.
+	if !demoMode {
+		ctx = withStatusWriter(ctx, newStatusWriter(ctx))
+	}
+
+	// Mappings that ambex leaves out to stay within the config limits get a status saying so.
+	if writer := statusWriterFromContext(ctx); writer != nil {
+		ctx = ambex.WithRejectionHandler(ctx, rejectionStatus(writer.Enqueue))
+	}
+
+	// SIGUSR1 or the health check server can have the watcher list everything again.
+	if !demoMode {
+		ctx = withResyncer(ctx, newResyncer(clock.FromContext(ctx)))
+	}
+
+	// Resources labeled as needing approval can wait for it, for the watcher and the health check
+	// server both.
+	ctx = withApprovals(ctx, newApprovals(ctx, clock.FromContext(ctx)))
+
+	// Maintenance can be switched on and off through the health check server, too.
+	ctx = withMaintenanceSwitches(ctx, newMaintenanceSwitches())
+
 	pec := "PYTHON_EGG_CACHE"
 	if os.Getenv(pec) == "" {
 		os.Setenv(pec, path.Join(GetAmbassadorConfigBaseDir(), ".cache"))
@@ -129,18 +198,58 @@ func Main(ctx context.Context, Version string, args ...string) error {
 		return err
 	}
 
+	// The bootstrap CA has to have written the xDS certificates before ambex and Envoy start, and
+	// diagd has to know where they are to put them in Envoy's bootstrap config.
+	ambexArgs := []string{"--ads-listen-address", "127.0.0.1:8003", "--clustermap", GetClustermapFile()}
+	if GetBootstrapCAEnabled() {
+		bca, err := setupBootstrapCA(ctx)
+		if err != nil {
+			return err
+		}
+		ctx = withBootstrapCA(ctx, bca)
+		os.Setenv("AMBASSADOR_XDS_TLS_DIR", GetBootstrapCADir())
+		ambexArgs = append(ambexArgs, "--ads-tls-dir", GetBootstrapCADir())
+	}
+
 	// We use this to wait until the bootstrap config has been written before starting envoy.
 	envoyHUP := make(chan os.Signal, 1)
 	signal.Notify(envoyHUP, syscall.SIGHUP)
 
-	// Go ahead and create an AmbassadorWatcher now, since we'll need it later.
-	ambwatch := acp.NewAmbassadorWatcher(acp.NewEnvoyWatcher(), acp.NewDiagdWatcher())
+	// Go ahead and create an AmbassadorWatcher now, since we'll need it later. Its EnvoyWatcher
+	// reaches Envoy's admin interface over AMBASSADOR_ENVOY_ADMIN_SOCKET, if that's set, and at
+	// AMBASSADOR_ENVOY_ADMIN_URL otherwise, and so does the Envoy admin proxy, which borrows its
+	// client.
+	ew := acp.NewEnvoyWatcher()
+	if os.Getenv("AMBASSADOR_ENVOY_ADMIN_SOCKET") == "" {
+		ew.SetAdminURL(GetEnvoyAdminURL())
+	}
+	ambwatch := acp.NewAmbassadorWatcher(ew, acp.NewDiagdWatcher())
+	ambwatch.SetAPIServerWatcher(newAPIServerWatcher())
+
+	// Each subsystem is stopped in its turn, by the shutdown plan (see shutdown.go), rather than
+	// all at once; the group only starts canceling things the hard way once the whole plan has had
+	// its chance.
+	plan := newShutdownPlan(clock.FromContext(ctx))
+	ctx = withShutdownPlan(ctx, plan)
+
+	// Booting goes through named phases, each with a timeout (see startup.go), so that a boot
+	// that hangs says where.
+	startup := newStartupPlan(clock.FromContext(ctx))
+	ctx = withStartupPlan(ctx, startup)
 
 	group := dgroup.NewGroup(ctx, dgroup.GroupConfig{
 		EnableSignalHandling: true,
-		SoftShutdownTimeout:  10 * time.Second,
+		SoftShutdownTimeout:  plan.Total() + 10*time.Second,
 		HardShutdownTimeout:  10 * time.Second,
 	})
+	group.Go("shutdown", func(ctx context.Context) error {
+		<-ctx.Done()
+		plan.Run(dcontext.HardContext(ctx))
+		return nil
+	})
+	plan.Go(group, shutdownWatchers, "startup", func(ctx context.Context) error {
+		return startup.Run(ctx, ambwatch)
+	})
 
 	// Demo mode: start the demo services. Starting the demo stuff first is
 	// kind of important: it's nice to give them a chance to start running before
@@ -149,54 +258,137 @@ func Main(ctx context.Context, Version string, args ...string) error {
 		bootDemoMode(ctx, group, ambwatch)
 	}
 
-	group.Go("diagd", func(ctx context.Context) error {
+	plan.Go(group, shutdownWatchers, "diagd", func(ctx context.Context) error {
 		cmd := subcommand(ctx, "diagd", GetDiagdArgs(ctx, demoMode)...)
 		if envbool("DEV_SHUTUP_DIAGD") {
 			cmd.Stdout = nil
 			cmd.Stderr = nil
 		}
+		closeTee, err := teeToLogSinks(cmd, "diagd", logSinks)
+		if err != nil {
+			return err
+		}
+		defer closeTee()
 		return cmd.Run()
 	})
 
 	usage := memory.GetMemoryUsage(ctx)
 	if !envbool("DEV_SHUTUP_MEMORY") {
-		group.Go("memory", func(ctx context.Context) error {
+		plan.Go(group, shutdownWatchers, "memory", func(ctx context.Context) error {
 			usage.Watch(ctx)
 			return nil
 		})
 	}
 
-	fastpathCh := make(chan *ambex.FastpathSnapshot)
-	group.Go("ambex", func(ctx context.Context) error {
-		return ambex.Main(ctx, Version, usage.PercentUsed, fastpathCh, "--ads-listen-address",
-			"127.0.0.1:8003", GetEnvoyDir())
+	if interval := GetLeakCheckInterval(); interval > 0 {
+		plan.Go(group, shutdownWatchers, "leaks", func(ctx context.Context) error {
+			return checkLeaks(ctx, interval)
+		})
+	}
+
+	if interval := GetCPUPressureInterval(); interval > 0 {
+		plan.Go(group, shutdownWatchers, "cpu_pressure", func(ctx context.Context) error {
+			return runCPUPressure(ctx, interval)
+		})
+	}
+
+	// The watcher and ambex are the ControlPlane (see controlplane.go), but they stop at different
+	// points in the shutdown plan, so they're run separately.
+	controlPlane := NewControlPlane(ControlPlaneConfig{
+		Version:           Version,
+		ClusterID:         clusterID,
+		AmbassadorWatcher: ambwatch,
+		AmbexArgs:         ambexArgs,
+		MemoryUsage:       usage.PercentUsed,
 	})
 
-	group.Go("envoy", func(ctx context.Context) error {
+	// The subsystems that run in-process are supervised, so that a panic in one of them restarts
+	// just that one.
+	plan.Go(group, shutdownEnvoy, "ambex", supervise("ambex", controlPlane.RunAmbex))
+
+	plan.Go(group, shutdownEnvoy, "envoy", func(ctx context.Context) error {
 		return runEnvoy(ctx, envoyHUP)
 	})
 
-	snapshot := &atomic.Value{}
-	group.Go("snapshot_server", func(ctx context.Context) error {
-		return snapshotServer(ctx, snapshot)
+	// Check on Envoy in the background, rather than whenever a probe comes in, and tell anything
+	// on the event bus whenever its health changes.
+	plan.Go(group, shutdownEnvoy, "envoy_watcher", func(ctx context.Context) error {
+		bus := eventbus.FromContext(ctx)
+		stop := ambwatch.EnvoyWatcher().OnHealthChange(func(h acp.EnvoyHealth) {
+			bus.Publish(eventbus.HealthEvent{Subsystem: "envoy", Healthy: h.Ready, Reason: h.Describe()})
+		})
+		defer stop()
+		return ambwatch.EnvoyWatcher().Run(ctx)
 	})
+
+	// Envoy sends the requests that Mappings capture for contract testing here, for as long as
+	// it's running.
+	plan.Go(group, shutdownEnvoy, "contract_capture", supervise("contract_capture", runContractCapture))
+
+	snapshot := controlPlane.snapshot
+	plan.Go(group, shutdownAgent, "snapshot_server", supervise("snapshot_server", func(ctx context.Context) error {
+		return snapshotServer(ctx, snapshot)
+	}))
+	if resync := resyncerFromContext(ctx); resync != nil {
+		plan.Go(group, shutdownWatchers, "resync_signal", func(ctx context.Context) error {
+			return watchResyncSignal(ctx, resync)
+		})
+	}
+	if writer := statusWriterFromContext(ctx); writer != nil {
+		plan.Go(group, shutdownWatchers, "status_writer", func(ctx context.Context) error {
+			return writer.Run(ctx)
+		})
+	}
 	if !envbool("AMBASSADOR_DISABLE_SNAPSHOT_SERVER") {
-		group.Go("external_snapshot_server", func(ctx context.Context) error {
-			return externalSnapshotServer(ctx, snapshot)
+		plan.Go(group, shutdownAgent, "external_snapshot_server", supervise("external_snapshot_server", func(ctx context.Context) error {
+			return externalSnapshotServer(ctx, snapshot, ambwatch)
+		}))
+	}
+
+	if interval := GetTrafficRollupInterval(); interval > 0 {
+		plan.Go(group, shutdownAgent, "traffic_rollups", func(ctx context.Context) error {
+			return runTrafficRollups(ctx, interval)
 		})
 	}
 
-	if !demoMode {
-		group.Go("watcher", func(ctx context.Context) error {
-			// We need to pass the AmbassadorWatcher to this (Kubernetes/Consul) watcher, so
-			// that it can tell the AmbassadorWatcher when snapshots are posted.
-			return WatchAllTheThings(ctx, ambwatch, snapshot, fastpathCh, clusterID, Version)
+	if interval := GetUpstreamHealthInterval(); interval > 0 {
+		plan.Go(group, shutdownAgent, "upstream_health", func(ctx context.Context) error {
+			return runUpstreamHealth(ctx, ambwatch, interval)
+		})
+	}
+
+	// The Host prober stops as soon as shutdown starts, before Envoy's listeners go away and every
+	// Host looks down.
+	if interval := GetHostProbeInterval(); interval > 0 {
+		plan.Go(group, shutdownConfig, "host_prober", func(ctx context.Context) error {
+			return runHostProber(ctx, interval)
+		})
+	}
+
+	if interval := GetOpenAPIInterval(); interval > 0 {
+		plan.Go(group, shutdownAgent, "openapi_aggregator", func(ctx context.Context) error {
+			return runOpenAPIAggregator(ctx, interval)
 		})
 	}
 
+	if !demoMode {
+		// The ControlPlane has the AmbassadorWatcher, so that the watcher can tell it when
+		// snapshots are posted.
+		plan.Go(group, shutdownWatchers, "watcher", supervise("watcher", func(ctx context.Context) error {
+			startup.done(startupWatchers)
+			return controlPlane.RunWatcher(ctx)
+		}))
+	}
+
 	// Finally, fire up the health check handler.
-	group.Go("healthchecks", func(ctx context.Context) error {
-		return healthCheckHandler(ctx, ambwatch)
+	plan.Go(group, shutdownHealth, "healthchecks", supervise("healthchecks", func(ctx context.Context) error {
+		return healthCheckHandler(ctx, Version, ambwatch, snapshot)
+	}))
+
+	// Run the resolver plugins, restarting any that exit, for the watcher to get endpoints
+	// from.
+	plan.Go(group, shutdownWatchers, "resolver_plugins", func(ctx context.Context) error {
+		return resolverplugin.Supervise(ctx, GetResolverPluginsDir(), GetResolverPluginSocketDir())
 	})
 
 	// Launch every file in the sidecar directory. Note that this is "bug compatible" with
@@ -207,7 +399,7 @@ func Main(ctx context.Context, Version string, args ...string) error {
 		return err
 	}
 	for _, sidecar := range sidecars {
-		group.Go(sidecar.Name(), func(ctx context.Context) error {
+		plan.Go(group, shutdownWatchers, sidecar.Name(), func(ctx context.Context) error {
 			cmd := subcommand(ctx, path.Join(sidecarDir, sidecar.Name()))
 			return cmd.Run()
 		})
@@ -7,10 +7,13 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/datawire/dlib/dexec"
 	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/changewindow"
 )
 
 func GetAgentService() string {
@@ -59,14 +62,24 @@ func GetEnvoyDir() string {
 	return env("ENVOY_DIR", path.Join(GetAmbassadorConfigBaseDir(), "envoy"))
 }
 
+// GetEnvoyConcurrency returns Envoy's --concurrency: ENVOY_CONCURRENCY, or else what the CPU limit
+// calls for (see concurrency.go), or "" to leave it to Envoy.
 func GetEnvoyConcurrency() string {
-	return env("ENVOY_CONCURRENCY", "")
+	if c, err := strconv.Atoi(env("ENVOY_CONCURRENCY", "")); err == nil && c > 0 {
+		return strconv.Itoa(c)
+	}
+	return envoyConcurrencyFlag()
 }
 
 func GetEnvoyBootstrapFile() string {
 	return env("ENVOY_BOOTSTRAP_FILE", path.Join(GetAmbassadorConfigBaseDir(), "bootstrap-ads.json"))
 }
 
+// GetClustermapFile returns where diagd writes its clustermap, which is next to the bootstrap file.
+func GetClustermapFile() string {
+	return path.Join(path.Dir(GetEnvoyBootstrapFile()), "clustermap.json")
+}
+
 func GetEnvoyBaseID() string {
 	return env("AMBASSADOR_ENVOY_BASE_ID", "0")
 }
@@ -148,14 +161,23 @@ func isDebug(name string) bool {
 	return strings.Contains(GetAmbassadorDebug(), name)
 }
 
+// GetEnvoyDrainTime returns how long Envoy takes to drain a listener that a configuration change
+// replaced or removed (its --drain-time-s), from AMBASSADOR_DRAIN_TIME. With the agent, it's a
+// second.
+func GetEnvoyDrainTime() time.Duration {
+	if GetAgentService() != "" {
+		return time.Second
+	}
+	secs, err := strconv.Atoi(env("AMBASSADOR_DRAIN_TIME", "600"))
+	if err != nil || secs < 0 {
+		secs = 600
+	}
+	return time.Duration(secs) * time.Second
+}
+
 func GetEnvoyFlags() []string {
 	result := []string{"-c", GetEnvoyBootstrapFile(), "--base-id", GetEnvoyBaseID()}
-	svc := GetAgentService()
-	if svc != "" {
-		result = append(result, "--drain-time-s", "1")
-	} else {
-		result = append(result, "--drain-time-s", env("AMBASSADOR_DRAIN_TIME", "600"))
-	}
+	result = append(result, "--drain-time-s", strconv.Itoa(int(GetEnvoyDrainTime()/time.Second)))
 	if isDebug("envoy") {
 		result = append(result, "-l", "trace")
 	} else {
@@ -187,7 +209,7 @@ func GetDiagdBindPort() string {
 }
 
 func IsEnvoyAvailable() bool {
-	_, err := dexec.LookPath("envoy")
+	_, err := dexec.LookPath(GetEnvoyBinary())
 	return err == nil
 }
 
@@ -206,6 +228,10 @@ func GetDiagdFlags(ctx context.Context, demoMode bool) []string {
 	// XXX: this was not in entrypoint.sh
 	result = append(result, "--port", GetDiagdBindPort())
 
+	if workers := diagdWorkersFlag(); workers != "" {
+		result = append(result, "--workers", workers)
+	}
+
 	cdir := GetConfigDir(demoMode)
 
 	if (cdir != "") && ConfigIsPresent(ctx, cdir) {
@@ -294,6 +320,24 @@ func GetSidecarUrl() string {
 	return fmt.Sprintf("%s/%s/watt", GetSidecarHost(), GetSidecarPath())
 }
 
+// GetFeatureGatesDir returns the directory holding feature gate settings, one file per gate. It's
+// meant to be a mounted ConfigMap; see pkg/featuregate.
+func GetFeatureGatesDir() string {
+	return env("AMBASSADOR_FEATURE_GATES_DIR", path.Join(GetAmbassadorConfigBaseDir(), "feature-gates"))
+}
+
+// GetResolverPluginsDir returns the directory of resolver plugin executables; see
+// pkg/resolverplugin.
+func GetResolverPluginsDir() string {
+	return env("AMBASSADOR_RESOLVER_PLUGINS_DIR", path.Join(GetAmbassadorRoot(), "resolver-plugins"))
+}
+
+// GetResolverPluginSocketDir returns the directory holding the sockets that resolver plugins
+// serve on.
+func GetResolverPluginSocketDir() string {
+	return env("AMBASSADOR_RESOLVER_PLUGIN_SOCKET_DIR", path.Join(GetAmbassadorConfigBaseDir(), "resolver-plugin-sockets"))
+}
+
 func IsKnativeEnabled() bool {
 	return strings.ToLower(env("AMBASSADOR_KNATIVE_SUPPORT", "")) == "true"
 }
@@ -304,6 +348,42 @@ func getHealthCheckHost() string {
 	return env("AMBASSADOR_HEALTHCHECK_BIND_ADDRESS", "0.0.0.0")
 }
 
+// GetEnvoyAdminURL returns the base URL of Envoy's admin interface.
+func GetEnvoyAdminURL() string {
+	return env("AMBASSADOR_ENVOY_ADMIN_URL", "http://127.0.0.1:8001")
+}
+
+// GetConfigFreezeToken returns the token that freezing and thawing configuration pushes needs. If
+// it's empty, configuration can't be frozen.
+func GetConfigFreezeToken() string {
+	return env("AMBASSADOR_CONFIG_FREEZE_TOKEN", "")
+}
+
+// GetChangeWindows returns the schedule of change windows outside of which configuration changes
+// are held back (see pkg/changewindow), or nil if changes can go out whenever they happen.
+func GetChangeWindows() (*changewindow.Schedule, error) {
+	spec := env("AMBASSADOR_CHANGE_WINDOWS", "")
+	if spec == "" {
+		return nil, nil
+	}
+	location, err := time.LoadLocation(env("AMBASSADOR_CHANGE_WINDOWS_TZ", "UTC"))
+	if err != nil {
+		return nil, err
+	}
+	return changewindow.Parse(spec, location)
+}
+
+// GetBootstrapCAEnabled returns whether to run the embedded bootstrap CA (see bootstrapca.go).
+func GetBootstrapCAEnabled() bool {
+	return envbool("AMBASSADOR_BOOTSTRAP_CA")
+}
+
+// GetBootstrapCADir returns the directory where the bootstrap CA writes the certificates that
+// ambex and Envoy use for xDS.
+func GetBootstrapCADir() string {
+	return path.Join(GetAmbassadorConfigBaseDir(), "bootstrap-ca")
+}
+
 // getHealthCheckPort will return the port that the health check server will bind to.
 // If not provided it will default to port `8877`
 func getHealthCheckPort() string {
@@ -23,10 +23,14 @@ func runEnvoy(ctx context.Context, envoyHUP chan os.Signal) error {
 	case <-envoyHUP:
 	}
 
+	if GetFakeEnvoy() {
+		return runFakeEnvoy(ctx)
+	}
+
 	// Try to run envoy directly, but fallback to running it inside docker if there is
 	// no envoy executable available.
 	if IsEnvoyAvailable() {
-		cmd := subcommand(ctx, "envoy", GetEnvoyFlags()...)
+		cmd := subcommand(ctx, getPrivilegedPortsPlan().Envoy, GetEnvoyFlags()...)
 		if envbool("DEV_SHUTUP_ENVOY") {
 			cmd.Stdout = nil
 			cmd.Stderr = nil
@@ -0,0 +1,202 @@
+package entrypoint
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/acp"
+)
+
+// Envoy admin proxy: /ambassador/v0/envoy/<endpoint> passes a request on to a few of Envoy's admin
+// endpoints, so that operators can look at Envoy without port-forwarding to its admin port, which
+// has no authentication at all and can shut Envoy down. Only these are passed on:
+//
+//	/ambassador/v0/envoy/clusters          GET: Envoy's /clusters
+//	/ambassador/v0/envoy/stats             GET: Envoy's /stats
+//	/ambassador/v0/envoy/stats/prometheus  GET: Envoy's /stats/prometheus
+//	/ambassador/v0/envoy/config_dump       GET: Envoy's /config_dump, redacted (see configdump.go)
+//	/ambassador/v0/envoy/logging           GET: Envoy's log levels; POST: change them
+//
+// and only with the query parameters that the endpoint takes for reading (or, for a POST to
+// logging, for setting log levels). AMBASSADOR_ENVOY_ADMIN_PROXY can pick fewer of them.
+//
+// The proxy is behind the admin Authorizer, and every endpoint is its own non-resource URL, so
+// RBAC can let someone read stats but not change log levels:
+//
+//	rules:
+//	- nonResourceURLs: ["/ambassador/v0/envoy/clusters", "/ambassador/v0/envoy/stats"]
+//	  verbs: ["get"]
+//	- nonResourceURLs: ["/ambassador/v0/envoy/logging"]
+//	  verbs: ["get", "post"]
+//
+// Without AMBASSADOR_ADMIN_AUTH, the reading is open like the rest of 8877, but the log levels
+// can't be changed through the proxy, since anyone who can reach 8877 could do it.
+
+// envoyAdminProxyPrefix is where the Envoy admin proxy lives on 8877.
+const envoyAdminProxyPrefix = "/ambassador/v0/envoy/"
+
+// envoyAdminProxyTimeout is how long the proxy waits for Envoy.
+const envoyAdminProxyTimeout = 30 * time.Second
+
+// envoyAdminEndpoint is one of Envoy's admin endpoints that the proxy passes requests on to.
+type envoyAdminEndpoint struct {
+	// params are the query parameters passed on with a GET.
+	params []string
+	// post is whether a POST may change something, with any query parameters: Envoy checks
+	// them itself.
+	post bool
+}
+
+// envoyAdminEndpoints are the admin endpoints the proxy can pass requests on to, by their name
+// under envoyAdminProxyPrefix. The config dump isn't here: it comes from the EnvoyWatcher, so
+// that its secrets are redacted.
+var envoyAdminEndpoints = map[string]envoyAdminEndpoint{
+	"clusters":         {params: []string{"format"}},
+	"stats":            {params: []string{"filter", "format", "histogram_buckets", "type", "usedonly"}},
+	"stats/prometheus": {params: []string{"filter", "histogram_buckets", "text_readouts", "usedonly"}},
+	"logging":          {post: true},
+}
+
+// GetEnvoyAdminProxyEndpoints returns the Envoy admin endpoints that the proxy passes requests on
+// to, from AMBASSADOR_ENVOY_ADMIN_PROXY: a comma-separated list of clusters, stats (which covers
+// stats/prometheus too), config_dump, and logging, or "none". It's all of them by default.
+func GetEnvoyAdminProxyEndpoints() map[string]bool {
+	endpoints := map[string]bool{}
+	for _, name := range strings.Split(env("AMBASSADOR_ENVOY_ADMIN_PROXY", "clusters,stats,config_dump,logging"), ",") {
+		switch name = strings.TrimSpace(strings.ToLower(name)); name {
+		case "stats":
+			endpoints["stats"] = true
+			endpoints["stats/prometheus"] = true
+		case "clusters", "config_dump", "logging":
+			endpoints[name] = true
+		}
+	}
+	return endpoints
+}
+
+// envoyAdminProxy passes requests on to Envoy's admin endpoints. It reaches them the same way
+// the EnvoyWatcher does, so over the admin socket if there is one.
+type envoyAdminProxy struct {
+	ew        *acp.EnvoyWatcher
+	endpoints map[string]bool
+	// authorized is whether requests have been through the admin Authorizer, which is what
+	// lets them change anything.
+	authorized bool
+}
+
+func newEnvoyAdminProxy(ew *acp.EnvoyWatcher, endpoints map[string]bool, authorized bool) *envoyAdminProxy {
+	return &envoyAdminProxy{
+		ew:         ew,
+		endpoints:  endpoints,
+		authorized: authorized,
+	}
+}
+
+func (p *envoyAdminProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, envoyAdminProxyPrefix)
+	if !p.endpoints[name] {
+		http.Error(w, fmt.Sprintf("%s isn't one of the Envoy admin endpoints served here (%s)", name, p.names()), http.StatusNotFound)
+		return
+	}
+
+	if name == "config_dump" {
+		if len(r.URL.Query()) > 0 {
+			http.Error(w, "config_dump takes no query parameters here", http.StatusBadRequest)
+			return
+		}
+		handleEnvoyConfigDump(w, r, p.ew)
+		return
+	}
+
+	endpoint := envoyAdminEndpoints[name]
+	query := url.Values{}
+	switch {
+	case r.Method == http.MethodGet:
+		for key, values := range r.URL.Query() {
+			if !contains(endpoint.params, key) {
+				http.Error(w, fmt.Sprintf("%s doesn't take %s here", name, key), http.StatusBadRequest)
+				return
+			}
+			query[key] = values
+		}
+	case r.Method == http.MethodPost && endpoint.post:
+		if !p.authorized {
+			http.Error(w, "changing Envoy's "+name+" here needs AMBASSADOR_ADMIN_AUTH=kubernetes", http.StatusForbidden)
+			return
+		}
+		query = r.URL.Query()
+	default:
+		if endpoint.post {
+			w.Header().Set("Allow", "GET, POST")
+		} else {
+			w.Header().Set("Allow", "GET")
+		}
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Envoy wants a POST for anything that can change its state, which for logging includes
+	// just listing the log levels.
+	method := http.MethodGet
+	if endpoint.post {
+		method = http.MethodPost
+	}
+
+	adminURL, client := p.ew.AdminClient()
+	target := adminURL + "/" + name
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), envoyAdminProxyTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unable to reach Envoy's admin endpoint: %v", err), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	if r.Method == http.MethodPost {
+		dlog.Infof(r.Context(), "Envoy admin proxy: %s changed Envoy's %s (%s): %s", requester(r), name, query.Encode(), resp.Status)
+	}
+
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(w, resp.Body)
+}
+
+// names returns the endpoints the proxy serves, for error messages.
+func (p *envoyAdminProxy) names() string {
+	var names []string
+	for name := range p.endpoints {
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return "none; see AMBASSADOR_ENVOY_ADMIN_PROXY"
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
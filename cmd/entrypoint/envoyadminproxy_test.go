@@ -0,0 +1,120 @@
+package entrypoint
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/acp"
+)
+
+func TestGetEnvoyAdminProxyEndpoints(t *testing.T) {
+	t.Setenv("AMBASSADOR_ENVOY_ADMIN_PROXY", "")
+	assert.Equal(t, map[string]bool{
+		"clusters": true, "stats": true, "stats/prometheus": true, "config_dump": true, "logging": true,
+	}, GetEnvoyAdminProxyEndpoints())
+
+	t.Setenv("AMBASSADOR_ENVOY_ADMIN_PROXY", "Stats, server_info")
+	assert.Equal(t, map[string]bool{"stats": true, "stats/prometheus": true}, GetEnvoyAdminProxyEndpoints())
+
+	t.Setenv("AMBASSADOR_ENVOY_ADMIN_PROXY", "none")
+	assert.Empty(t, GetEnvoyAdminProxyEndpoints())
+}
+
+func TestEnvoyAdminProxy(t *testing.T) {
+	var got []string
+	envoy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Method+" "+r.URL.RequestURI())
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("X-Envoy-Internal", "yes")
+		fmt.Fprintf(w, "%s %s\n", r.Method, r.URL.Path)
+	}))
+	defer envoy.Close()
+
+	ew := acp.NewEnvoyWatcher()
+	ew.SetAdminURL(envoy.URL + "/")
+	ew.SetConfigDumpCheck(func(ctx context.Context) (*acp.EnvoyFetcherResponse, error) {
+		return &acp.EnvoyFetcherResponse{StatusCode: http.StatusOK, Text: []byte(`{"password": "hunter2"}`)}, nil
+	})
+
+	serve := func(p *envoyAdminProxy, method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+
+	all := map[string]bool{"clusters": true, "stats": true, "stats/prometheus": true, "config_dump": true, "logging": true}
+	p := newEnvoyAdminProxy(ew, all, true)
+
+	rec := serve(p, http.MethodGet, "/ambassador/v0/envoy/stats?filter=%5Ecluster%5C.&usedonly")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "GET /stats\n", rec.Body.String())
+	assert.Equal(t, "text/plain", rec.Header().Get("Content-Type"))
+	assert.Empty(t, rec.Header().Get("X-Envoy-Internal"))
+
+	// Parameters that the endpoint doesn't take for reading don't get through...
+	assert.Equal(t, http.StatusBadRequest, serve(p, http.MethodGet, "/ambassador/v0/envoy/clusters?reset").Code)
+	// ...and neither do endpoints that aren't on the list, or methods that would change things.
+	assert.Equal(t, http.StatusNotFound, serve(p, http.MethodPost, "/ambassador/v0/envoy/quitquitquit").Code)
+	assert.Equal(t, http.StatusNotFound, serve(p, http.MethodGet, "/ambassador/v0/envoy/../quitquitquit").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(p, http.MethodPost, "/ambassador/v0/envoy/stats").Code)
+
+	// Envoy wants a POST to list log levels, but a GET can't change them.
+	rec = serve(p, http.MethodGet, "/ambassador/v0/envoy/logging")
+	assert.Equal(t, "POST /logging\n", rec.Body.String())
+	assert.Equal(t, http.StatusBadRequest, serve(p, http.MethodGet, "/ambassador/v0/envoy/logging?level=debug").Code)
+	assert.Equal(t, http.StatusOK, serve(p, http.MethodPost, "/ambassador/v0/envoy/logging?level=debug").Code)
+
+	rec = serve(p, http.MethodGet, "/ambassador/v0/envoy/config_dump")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"password": "[redacted]"}`, rec.Body.String())
+	assert.Equal(t, http.StatusBadRequest, serve(p, http.MethodGet, "/ambassador/v0/envoy/config_dump?include_eds").Code)
+
+	assert.Equal(t, []string{
+		"GET /stats?filter=%5Ecluster%5C.&usedonly=",
+		"POST /logging",
+		"POST /logging?level=debug",
+	}, got)
+
+	// Without the admin Authorizer, nothing changes through the proxy.
+	p = newEnvoyAdminProxy(ew, all, false)
+	assert.Equal(t, http.StatusForbidden, serve(p, http.MethodPost, "/ambassador/v0/envoy/logging?level=debug").Code)
+	assert.Equal(t, http.StatusOK, serve(p, http.MethodGet, "/ambassador/v0/envoy/logging").Code)
+
+	p = newEnvoyAdminProxy(ew, map[string]bool{"clusters": true}, true)
+	assert.Equal(t, http.StatusNotFound, serve(p, http.MethodGet, "/ambassador/v0/envoy/stats").Code)
+	assert.Equal(t, http.StatusOK, serve(p, http.MethodGet, "/ambassador/v0/envoy/clusters?format=json").Code)
+}
+
+func TestEnvoyAdminProxySocket(t *testing.T) {
+	// Unix socket paths have to be short, and t.TempDir() can be long.
+	dir, err := os.MkdirTemp("", "entrypoint")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "admin.sock")
+
+	ln, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s over the socket\n", r.Method, r.URL.RequestURI())
+	})}
+	go func() { _ = srv.Serve(ln) }()
+	defer srv.Close()
+
+	// The proxy goes wherever the EnvoyWatcher goes, which here is the admin socket.
+	t.Setenv("AMBASSADOR_ENVOY_ADMIN_SOCKET", socket)
+	p := newEnvoyAdminProxy(acp.NewEnvoyWatcher(), map[string]bool{"stats": true}, false)
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ambassador/v0/envoy/stats?usedonly", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "GET /stats?usedonly= over the socket\n", rec.Body.String())
+}
@@ -0,0 +1,280 @@
+package entrypoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/datawire/dlib/dexec"
+	"github.com/datawire/dlib/dlog"
+)
+
+// Bring your own Envoy: AMBASSADOR_ENVOY_BINARY runs a different Envoy binary than the image's --
+// one built with extra extensions, say, or with FIPS-validated crypto -- without building a whole
+// new image around it. (An init container can copy it into a volume that the Ambassador container
+// mounts.) Emissary generates configuration for the Envoy in its image, though, so at startup we
+// probe the other binary before trusting it with anything:
+//
+//   - Its --version says what version it is, and what TLS library it was built with. If its
+//     major.minor version isn't the image's, we refuse to start, since the configuration may use
+//     fields that it doesn't know about, or that it no longer supports. With
+//     AMBASSADOR_ENVOY_ALLOW_VERSION_MISMATCH=true, we start anyway, with a warning.
+//
+//   - It runs for a moment with nothing but an admin interface, and /server_info lists the
+//     extensions that it has. If it's missing any that every configuration uses, we refuse to
+//     start.
+//
+// What the probe found goes in AMBASSADOR_ENVOY_PROBE_FILE, for diagd, which checks every
+// configuration against the binary's extensions before handing it over, and names the
+// extensions that it would need (see python/ambassador/envoy/v3/v3extensions.py). diagd validates
+// configurations with the same binary, too.
+//
+// The capabilities wrapper only ever runs the image's Envoy, so a binary of your own that needs
+// to bind privileged ports needs CAP_NET_BIND_SERVICE some other way.
+
+// requiredEnvoyExtensions are the extensions that every configuration uses.
+var requiredEnvoyExtensions = []string{
+	"envoy.access_loggers.file",
+	"envoy.filters.http.router",
+	"envoy.filters.listener.tls_inspector",
+	"envoy.filters.network.http_connection_manager",
+	"envoy.transport_sockets.tls",
+}
+
+// How long the probe waits for the binary to answer.
+const envoyProbeTimeout = 30 * time.Second
+
+// GetEnvoyBinary returns the Envoy binary to run, from AMBASSADOR_ENVOY_BINARY: "envoy", the
+// image's, by default.
+func GetEnvoyBinary() string {
+	return env("AMBASSADOR_ENVOY_BINARY", "envoy")
+}
+
+// GetEnvoyProbeFile returns where the probe of AMBASSADOR_ENVOY_BINARY goes, for diagd.
+func GetEnvoyProbeFile() string {
+	return env("AMBASSADOR_ENVOY_PROBE_FILE", path.Join(GetAmbassadorConfigBaseDir(), "envoy-probe.json"))
+}
+
+// envoyProbe is what we found out about an Envoy binary.
+type envoyProbe struct {
+	Binary string `json:"binary"`
+	// Version is its version, like "1.24.1".
+	Version string `json:"version"`
+	// SSL is its TLS library, like "BoringSSL" or "BoringSSL-FIPS".
+	SSL string `json:"ssl"`
+	// Extensions are the types of the configurations of its extensions, by name. (Envoy looks
+	// extensions up by type, or by name if there's no type.)
+	Extensions map[string][]string `json:"extensions"`
+}
+
+// parseEnvoyVersion gets the version and TLS library out of envoy --version, which says
+// something like
+//
+//	envoy  version: 5a4aed88.../1.24.1/Clean/RELEASE/BoringSSL
+func parseEnvoyVersion(out string) (version, ssl string, err error) {
+	_, after, ok := strings.Cut(out, "version:")
+	if !ok {
+		return "", "", fmt.Errorf("no version in %q", strings.TrimSpace(out))
+	}
+	fields := strings.Split(strings.TrimSpace(after), "/")
+	if len(fields) < 5 || strings.TrimSpace(fields[4]) == "" {
+		return "", "", fmt.Errorf("can't parse version %q", strings.TrimSpace(after))
+	}
+	return fields[1], strings.Fields(fields[4])[0], nil
+}
+
+// envoyMinorVersion returns the major.minor of a version like "1.24.1".
+func envoyMinorVersion(version string) string {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return version
+	}
+	return parts[0] + "." + parts[1]
+}
+
+// parseServerInfo gets the extensions out of Envoy's /server_info.
+func parseServerInfo(body []byte) (map[string][]string, error) {
+	var info struct {
+		Node struct {
+			Extensions []struct {
+				Name     string   `json:"name"`
+				TypeURLs []string `json:"type_urls"`
+			} `json:"extensions"`
+		} `json:"node"`
+	}
+	if err := json.Unmarshal(body, &info); err != nil {
+		return nil, err
+	}
+	extensions := make(map[string][]string, len(info.Node.Extensions))
+	for _, ext := range info.Node.Extensions {
+		extensions[ext.Name] = append(extensions[ext.Name], ext.TypeURLs...)
+	}
+	return extensions, nil
+}
+
+// checkEnvoyProbe returns what's wrong with running the probed binary instead of the image's,
+// whose version is imageVersion ("" if we couldn't tell): errors if we shouldn't, and warnings if
+// we can, but you should know.
+func checkEnvoyProbe(probe envoyProbe, imageVersion string, allowMismatch bool) (errs []error, warnings []string) {
+	switch {
+	case imageVersion == "":
+		warnings = append(warnings, fmt.Sprintf("can't tell whether %s (Envoy %s) can take Emissary's configuration: there's no Envoy in the image to compare it to", probe.Binary, probe.Version))
+	case envoyMinorVersion(probe.Version) != envoyMinorVersion(imageVersion):
+		msg := fmt.Sprintf("%s is Envoy %s, but Emissary generates configuration for Envoy %s", probe.Binary, probe.Version, envoyMinorVersion(imageVersion))
+		if allowMismatch {
+			warnings = append(warnings, msg)
+		} else {
+			errs = append(errs, fmt.Errorf("%s; set AMBASSADOR_ENVOY_ALLOW_VERSION_MISMATCH=true to run it anyway", msg))
+		}
+	}
+
+	var missing []string
+	for _, name := range requiredEnvoyExtensions {
+		if _, ok := probe.Extensions[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		errs = append(errs, fmt.Errorf("%s doesn't have the Envoy extensions %s, which Emissary always uses", probe.Binary, strings.Join(missing, ", ")))
+	}
+	return errs, warnings
+}
+
+// envoyVersion runs binary --version.
+func envoyVersion(ctx context.Context, binary string) (version, ssl string, err error) {
+	out, err := dexec.CommandContext(ctx, binary, "--version").Output()
+	if err != nil {
+		return "", "", fmt.Errorf("%s --version: %w", binary, err)
+	}
+	return parseEnvoyVersion(string(out))
+}
+
+// probeEnvoyExtensions runs binary with just an admin interface, on a port of its choosing, and
+// asks it what extensions it has.
+func probeEnvoyExtensions(ctx context.Context, binary string) (map[string][]string, error) {
+	dir, err := os.MkdirTemp("", "envoy-probe-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	bootstrap := filepath.Join(dir, "bootstrap.json")
+	addressFile := filepath.Join(dir, "admin-address")
+	err = os.WriteFile(bootstrap, []byte(`{"admin":{"address":{"socket_address":{"address":"127.0.0.1","port_value":0}}}}`), 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, envoyProbeTimeout)
+	defer cancel()
+
+	cmd := dexec.CommandContext(ctx, binary, "-c", bootstrap, "--admin-address-path", addressFile,
+		"--disable-hot-restart", "-l", "error")
+	cmd.DisableLogging = true
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("%s: %w", binary, err)
+	}
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if address, err := os.ReadFile(addressFile); err == nil && len(address) > 0 {
+			body, err := getServerInfo(ctx, strings.TrimSpace(string(address)))
+			if err == nil {
+				return parseServerInfo(body)
+			}
+			dlog.Debugf(ctx, "Envoy probe: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%s didn't answer on its admin interface within %s", binary, envoyProbeTimeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+func getServerInfo(ctx context.Context, address string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+address+"/server_info", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("/server_info: status %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// alignEnvoyBinary probes AMBASSADOR_ENVOY_BINARY, if it's set, and returns an error if Emissary
+// can't run it. Otherwise it leaves the probe for diagd.
+func alignEnvoyBinary(ctx context.Context) error {
+	binary := GetEnvoyBinary()
+	if binary == "envoy" {
+		return nil
+	}
+
+	binPath, err := dexec.LookPath(binary)
+	if err != nil {
+		return fmt.Errorf("AMBASSADOR_ENVOY_BINARY: %w", err)
+	}
+	probe := envoyProbe{Binary: binPath}
+	if probe.Version, probe.SSL, err = envoyVersion(ctx, binPath); err != nil {
+		return fmt.Errorf("AMBASSADOR_ENVOY_BINARY: %w", err)
+	}
+	if probe.Extensions, err = probeEnvoyExtensions(ctx, binPath); err != nil {
+		return fmt.Errorf("AMBASSADOR_ENVOY_BINARY: %w", err)
+	}
+
+	imageVersion := ""
+	if _, err := dexec.LookPath("envoy"); err == nil {
+		imageVersion, _, err = envoyVersion(ctx, "envoy")
+		if err != nil {
+			dlog.Warnf(ctx, "Envoy probe: %v", err)
+		}
+	}
+
+	errs, warnings := checkEnvoyProbe(probe, imageVersion, envbool("AMBASSADOR_ENVOY_ALLOW_VERSION_MISMATCH"))
+	for _, warning := range warnings {
+		dlog.Warnf(ctx, "Envoy probe: %s", warning)
+	}
+	if len(errs) > 0 {
+		for _, err := range errs[1:] {
+			dlog.Errorf(ctx, "Envoy probe: %v", err)
+		}
+		return fmt.Errorf("AMBASSADOR_ENVOY_BINARY: %w", errs[0])
+	}
+
+	names := make([]string, 0, len(probe.Extensions))
+	for name := range probe.Extensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	dlog.Infof(ctx, "Envoy probe: running %s (Envoy %s, %s, %d extensions)", binPath, probe.Version, probe.SSL, len(names))
+	dlog.Debugf(ctx, "Envoy probe: extensions: %s", strings.Join(names, ", "))
+
+	data, err := json.MarshalIndent(probe, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(GetEnvoyProbeFile(), data, 0o644); err != nil {
+		return fmt.Errorf("AMBASSADOR_ENVOY_BINARY: %w", err)
+	}
+	os.Setenv("AMBASSADOR_ENVOY_PROBE_FILE", GetEnvoyProbeFile())
+	return nil
+}
@@ -0,0 +1,73 @@
+package entrypoint
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEnvoyVersion(t *testing.T) {
+	version, ssl, err := parseEnvoyVersion("\nenvoy  version: 5a4aed880cb9b76df21448e75d42fe95a77c3893/1.24.1/Clean/RELEASE/BoringSSL-FIPS\n\n")
+	require.NoError(t, err)
+	assert.Equal(t, "1.24.1", version)
+	assert.Equal(t, "BoringSSL-FIPS", ssl)
+
+	_, _, err = parseEnvoyVersion("envoy: command not found")
+	assert.Error(t, err)
+	_, _, err = parseEnvoyVersion("envoy  version: 1.24.1")
+	assert.Error(t, err)
+}
+
+func TestParseServerInfo(t *testing.T) {
+	extensions, err := parseServerInfo([]byte(`{
+		"version": "5a4aed88/1.24.1/Clean/RELEASE/BoringSSL",
+		"node": {
+			"extensions": [
+				{"name": "envoy.filters.http.router", "category": "envoy.filters.http",
+				 "type_urls": ["envoy.extensions.filters.http.router.v3.Router"]},
+				{"name": "envoy.filters.listener.tls_inspector", "category": "envoy.filters.listener"}
+			]
+		}
+	}`))
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"envoy.filters.http.router":            {"envoy.extensions.filters.http.router.v3.Router"},
+		"envoy.filters.listener.tls_inspector": nil,
+	}, extensions)
+}
+
+func TestCheckEnvoyProbe(t *testing.T) {
+	all := map[string][]string{}
+	for _, name := range requiredEnvoyExtensions {
+		all[name] = nil
+	}
+	probe := envoyProbe{Binary: "/envoy/envoy", Version: "1.24.3", SSL: "BoringSSL-FIPS", Extensions: all}
+
+	// A patch release is fine.
+	errs, warnings := checkEnvoyProbe(probe, "1.24.1", false)
+	assert.Empty(t, errs)
+	assert.Empty(t, warnings)
+
+	// Without the image's Envoy, we can't tell.
+	errs, warnings = checkEnvoyProbe(probe, "", false)
+	assert.Empty(t, errs)
+	assert.Len(t, warnings, 1)
+
+	// Another minor version isn't, unless you say so.
+	probe.Version = "1.25.0"
+	errs, warnings = checkEnvoyProbe(probe, "1.24.1", false)
+	require.Len(t, errs, 1)
+	assert.EqualError(t, errs[0], "/envoy/envoy is Envoy 1.25.0, but Emissary generates configuration for Envoy 1.24; set AMBASSADOR_ENVOY_ALLOW_VERSION_MISMATCH=true to run it anyway")
+	assert.Empty(t, warnings)
+	errs, warnings = checkEnvoyProbe(probe, "1.24.1", true)
+	assert.Empty(t, errs)
+	assert.Equal(t, []string{"/envoy/envoy is Envoy 1.25.0, but Emissary generates configuration for Envoy 1.24"}, warnings)
+
+	// Missing extensions that we always use are never fine.
+	probe.Version = "1.24.1"
+	probe.Extensions = map[string][]string{"envoy.filters.http.router": nil, "envoy.transport_sockets.tls": nil}
+	errs, _ = checkEnvoyProbe(probe, "1.24.1", true)
+	require.Len(t, errs, 1)
+	assert.EqualError(t, errs[0], "/envoy/envoy doesn't have the Envoy extensions envoy.access_loggers.file, envoy.filters.listener.tls_inspector, envoy.filters.network.http_connection_manager, which Emissary always uses")
+}
@@ -0,0 +1,173 @@
+package entrypoint
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/eventbus"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+// The entrypoint's subsystems find out what the others are up to from the event bus in their
+// context, rather than from channels and shared values threaded through Main. The watcher
+// publishes a ResourceEvent for every change it sees and a SnapshotEvent for every snapshot that
+// goes to diagd, supervise publishes a HealthEvent when a subsystem panics and when it's
+// restarted, and the envoy_watcher publishes a HealthEvent for the "envoy" subsystem whenever
+// Envoy's health changes (Healthy is whether it's ready; Reason says what the EnvoyWatcher made
+// of it). The upstream health fetcher follows those, so it only asks Envoy about its clusters
+// while Envoy is ready. Anything new that wants to know about those (an agent, metrics, an audit
+// log) subscribes, without anything that publishes having to change.
+
+// resourceEvent returns the ResourceEvent for a delta from the watcher.
+func resourceEvent(delta *kates.Delta) eventbus.ResourceEvent {
+	change := eventbus.ResourceUpdated
+	switch delta.DeltaType {
+	case kates.ObjectAdd:
+		change = eventbus.ResourceAdded
+	case kates.ObjectDelete:
+		change = eventbus.ResourceDeleted
+	}
+	return eventbus.ResourceEvent{
+		Change:    change,
+		Kind:      delta.Kind,
+		Namespace: delta.Namespace,
+		Name:      delta.Name,
+	}
+}
+
+// snapshotFollower keeps the newest snapshot from the bus, for consumers that only want to look at
+// it now and then. Its Events go in a select with whatever else the consumer waits for, and each
+// one goes to note.
+type snapshotFollower struct {
+	sub    *eventbus.Subscription
+	events <-chan eventbus.Event
+	raw    []byte
+}
+
+// followSnapshots subscribes to snapshots, as name.
+func followSnapshots(ctx context.Context, name string) *snapshotFollower {
+	// Only the newest snapshot matters, so there's no point buffering more than one.
+	sub := eventbus.FromContext(ctx).Subscribe(name, 1, eventbus.KindSnapshot)
+	return &snapshotFollower{sub: sub, events: sub.Events()}
+}
+
+// Events returns the channel to receive the follower's events from. It's nil once the subscription
+// has been closed, so that a select doesn't keep picking it.
+func (f *snapshotFollower) Events() <-chan eventbus.Event {
+	return f.events
+}
+
+// note takes an event received from Events.
+func (f *snapshotFollower) note(ev eventbus.Event, ok bool) {
+	if !ok {
+		f.events = nil
+		return
+	}
+	if se, isSnapshot := ev.(eventbus.SnapshotEvent); isSnapshot {
+		f.raw = se.Snapshot
+	}
+}
+
+// latest returns the newest snapshot, or nil if there hasn't been one yet.
+func (f *snapshotFollower) latest() *snapshotTypes.Snapshot {
+	return decodeSnapshot(f.raw)
+}
+
+// decodeSnapshot returns the snapshot that raw is the JSON of, or nil if it isn't one.
+func decodeSnapshot(raw []byte) *snapshotTypes.Snapshot {
+	if raw == nil {
+		return nil
+	}
+	var snap snapshotTypes.Snapshot
+	if err := json.Unmarshal(raw, &snap); err != nil {
+		return nil
+	}
+	return &snap
+}
+
+func (f *snapshotFollower) Close() {
+	f.sub.Close()
+}
+
+// envoyHealthFollower keeps track of whether the envoy_watcher last said Envoy was ready, for
+// consumers that only want to ask Envoy for things while it is. Its Events go in a select with
+// whatever else the consumer waits for, and each one goes to note. Until the envoy_watcher says
+// anything, Envoy counts as ready, so that nothing waits on an envoy_watcher that isn't running.
+type envoyHealthFollower struct {
+	sub    *eventbus.Subscription
+	events <-chan eventbus.Event
+	heard  bool
+	ready  bool
+}
+
+// followEnvoyHealth subscribes to health events, as name.
+func followEnvoyHealth(ctx context.Context, name string) *envoyHealthFollower {
+	sub := eventbus.FromContext(ctx).Subscribe(name, 0, eventbus.KindHealth)
+	return &envoyHealthFollower{sub: sub, events: sub.Events(), ready: true}
+}
+
+// Events returns the channel to receive the follower's events from. It's nil once the subscription
+// has been closed, so that a select doesn't keep picking it.
+func (f *envoyHealthFollower) Events() <-chan eventbus.Event {
+	return f.events
+}
+
+// note takes an event received from Events, and returns true if it says that Envoy has just
+// become ready.
+func (f *envoyHealthFollower) note(ev eventbus.Event, ok bool) bool {
+	if !ok {
+		f.events = nil
+		return false
+	}
+	he, isHealth := ev.(eventbus.HealthEvent)
+	if !isHealth || he.Subsystem != "envoy" {
+		return false
+	}
+	becameReady := he.Healthy && (!f.heard || !f.ready)
+	f.heard = true
+	f.ready = he.Healthy
+	return becameReady
+}
+
+// catchUp notes the events that have already come in, without waiting for more, so that a
+// consumer woken by something else sees the latest health.
+func (f *envoyHealthFollower) catchUp() {
+	for f.events != nil {
+		select {
+		case ev, ok := <-f.events:
+			f.note(ev, ok)
+		default:
+			return
+		}
+	}
+}
+
+func (f *envoyHealthFollower) Close() {
+	f.sub.Close()
+}
+
+// eventBusMetrics renders how many events each subscriber has dropped in the Prometheus text
+// format, to add to what diagd serves on /metrics.
+func eventBusMetrics(bus *eventbus.Bus) []byte {
+	dropped := bus.Dropped()
+	if len(dropped) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(dropped))
+	for name := range dropped {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "# HELP ambassador_event_bus_dropped_total Events dropped because a subscriber's buffer was full, by subscriber.")
+	fmt.Fprintln(&buf, "# TYPE ambassador_event_bus_dropped_total counter")
+	for _, name := range names {
+		fmt.Fprintf(&buf, "ambassador_event_bus_dropped_total{subscriber=%q} %d\n", name, dropped[name])
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,66 @@
+package entrypoint
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/ambex"
+	"github.com/emissary-ingress/emissary/v3/pkg/fakeenvoy"
+)
+
+// Fake Envoy: with AMBASSADOR_FAKE_ENVOY set, the entrypoint runs a fakeenvoy.FakeEnvoy in-process
+// instead of the envoy binary (or the Envoy container). It gets its configuration from ambex, and
+// serves /ready where Envoy would, so everything but the proxying works, which is handy for
+// developing the control plane on a machine without Envoy, and for integration tests that only
+// care about what the control plane does.
+
+// GetFakeEnvoy returns whether to run a fake Envoy instead of the real one, from
+// AMBASSADOR_FAKE_ENVOY.
+func GetFakeEnvoy() bool {
+	return envbool("AMBASSADOR_FAKE_ENVOY")
+}
+
+func runFakeEnvoy(ctx context.Context) error {
+	cfg := fakeenvoy.Config{
+		ADSAddress:   "127.0.0.1:8003",
+		ReadyAddress: "127.0.0.1:" + env("AMBASSADOR_READY_PORT", "8006"),
+	}
+	if GetBootstrapCAEnabled() {
+		tlsConfig, err := fakeEnvoyTLSConfig(GetBootstrapCADir())
+		if err != nil {
+			return err
+		}
+		cfg.TLS = tlsConfig
+	}
+
+	dlog.Infof(ctx, "AMBASSADOR_FAKE_ENVOY is set: running a fake Envoy, which won't proxy anything")
+	return fakeenvoy.New(cfg).Run(ctx)
+}
+
+// fakeEnvoyTLSConfig returns the TLS configuration that Envoy would use for ADS: Envoy's client
+// certificate from the bootstrap CA's directory, and the directory's CA to check ambex's with.
+func fakeEnvoyTLSConfig(dir string) (*tls.Config, error) {
+	caPEM, err := os.ReadFile(filepath.Join(dir, ambex.ADSTLSCAFile))
+	if err != nil {
+		return nil, err
+	}
+	rootCAs := x509.NewCertPool()
+	if !rootCAs.AppendCertsFromPEM(caPEM) {
+		return nil, errors.New("no certificates in " + filepath.Join(dir, ambex.ADSTLSCAFile))
+	}
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, ambex.ADSTLSClientCertFile), filepath.Join(dir, ambex.ADSTLSClientKeyFile))
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      rootCAs,
+		ServerName:   "localhost",
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
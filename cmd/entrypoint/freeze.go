@@ -0,0 +1,148 @@
+package entrypoint
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/ambex"
+)
+
+// handleFreeze freezes and thaws configuration pushes to Envoy (see pkg/ambex/freeze.go):
+//
+//	GET    /ambassador/v0/freeze                 whether configuration is frozen, and since when
+//	POST   /ambassador/v0/freeze?reason=<text>   stop pushing configuration to Envoy
+//	DELETE /ambassador/v0/freeze                 start pushing again, beginning with the latest
+//
+// POST and DELETE have to get past allow, which writes the response itself if they don't. That's
+// freezeTokenCheck, unless the admin API uses Kubernetes authentication (see adminauth.go), in which
+// case every request has already been checked.
+func handleFreeze(w http.ResponseWriter, r *http.Request, freezer *ambex.Freezer, allow func(http.ResponseWriter, *http.Request) bool) {
+	switch r.Method {
+	case http.MethodGet:
+		writeFreezeState(w, freezer.State())
+	case http.MethodPost, http.MethodDelete:
+		if !allow(w, r) {
+			return
+		}
+		if r.Method == http.MethodPost {
+			reason := r.URL.Query().Get("reason")
+			if reason == "" {
+				http.Error(w, "say why with ?reason=\n", http.StatusBadRequest)
+				return
+			}
+			state := freezer.Freeze(reason, time.Now())
+			dlog.Warnf(r.Context(), "Configuration FROZEN by %s: %s", requester(r), reason)
+			writeFreezeState(w, state)
+		} else {
+			old := freezer.Thaw()
+			if old.Frozen {
+				dlog.Warnf(r.Context(), "Configuration thawed by %s after %s, pushing the latest of %d held updates",
+					requester(r), time.Since(old.Since).Round(time.Second), old.Held)
+			}
+			writeFreezeState(w, freezer.State())
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed\n", http.StatusMethodNotAllowed)
+	}
+}
+
+// freezeTokenCheck returns the check for handleFreeze that wants "Authorization: Bearer <token>",
+// where the token is AMBASSADOR_CONFIG_FREEZE_TOKEN. Without that set, configuration can't be
+// frozen at all.
+func freezeTokenCheck(token string) func(http.ResponseWriter, *http.Request) bool {
+	return func(w http.ResponseWriter, r *http.Request) bool {
+		if token == "" {
+			http.Error(w, "set AMBASSADOR_CONFIG_FREEZE_TOKEN to allow freezing configuration\n", http.StatusForbidden)
+			return false
+		}
+		bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized\n", http.StatusUnauthorized)
+			return false
+		}
+		return true
+	}
+}
+
+func writeFreezeState(w http.ResponseWriter, state ambex.FreezeState) {
+	bytes, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(append(bytes, '\n'))
+}
+
+// freezeHealthReason is what the health checks add to their message while configuration is
+// frozen. A freeze doesn't make Ambassador unhealthy: Envoy is still serving the last configuration
+// it got.
+func freezeHealthReason(freezer *ambex.Freezer) string {
+	state := freezer.State()
+	if !state.Frozen {
+		return ""
+	}
+	return fmt.Sprintf(" (configuration frozen since %s, %d updates held: %s)",
+		state.Since.UTC().Format(time.RFC3339), state.Held, state.Reason)
+}
+
+// freezeMetrics renders the freeze state in the Prometheus text format, to add to what diagd
+// serves on /metrics.
+func freezeMetrics(freezer *ambex.Freezer) []byte {
+	state := freezer.State()
+	frozen, since := 0, 0.0
+	if state.Frozen {
+		frozen = 1
+		since = float64(state.Since.UnixNano()) / float64(time.Second)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "# HELP ambassador_config_frozen Whether configuration pushes to Envoy are frozen.")
+	fmt.Fprintln(&buf, "# TYPE ambassador_config_frozen gauge")
+	fmt.Fprintf(&buf, "ambassador_config_frozen %d\n", frozen)
+	fmt.Fprintln(&buf, "# HELP ambassador_config_frozen_since_seconds When configuration pushes were frozen, or 0 if they aren't.")
+	fmt.Fprintln(&buf, "# TYPE ambassador_config_frozen_since_seconds gauge")
+	fmt.Fprintf(&buf, "ambassador_config_frozen_since_seconds %s\n", strconv.FormatFloat(since, 'f', -1, 64))
+	fmt.Fprintln(&buf, "# HELP ambassador_config_frozen_held_updates Updates that haven't been pushed because of the freeze.")
+	fmt.Fprintln(&buf, "# TYPE ambassador_config_frozen_held_updates gauge")
+	fmt.Fprintf(&buf, "ambassador_config_frozen_held_updates %d\n", state.Held)
+	return buf.Bytes()
+}
+
+// appendFreezeMetrics is a ReverseProxy ModifyResponse that adds the freeze metrics to diagd's
+// /metrics.
+func appendFreezeMetrics(freezer *ambex.Freezer) func(*http.Response) error {
+	return appendMetrics(func() []byte { return freezeMetrics(freezer) })
+}
+
+// appendMetrics is a ReverseProxy ModifyResponse that adds whatever the given functions render to
+// diagd's /metrics.
+func appendMetrics(metrics ...func() []byte) func(*http.Response) error {
+	return func(resp *http.Response) error {
+		if resp.Request.URL.Path != "/metrics" || resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Encoding") != "" {
+			return nil
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		for _, m := range metrics {
+			body = append(body, m()...)
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		resp.ContentLength = int64(len(body))
+		resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		return nil
+	}
+}
@@ -0,0 +1,85 @@
+package entrypoint
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/ambex"
+)
+
+func freezeRequest(t *testing.T, freezer *ambex.Freezer, token, method, target, auth string) (int, ambex.FreezeState) {
+	t.Helper()
+	r := httptest.NewRequest(method, target, nil).WithContext(dlog.NewTestContext(t, false))
+	if auth != "" {
+		r.Header.Set("Authorization", auth)
+	}
+	w := httptest.NewRecorder()
+	handleFreeze(w, r, freezer, freezeTokenCheck(token))
+
+	var state ambex.FreezeState
+	if w.Code == http.StatusOK {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &state))
+	}
+	return w.Code, state
+}
+
+func TestHandleFreeze(t *testing.T) {
+	freezer := ambex.NewFreezer()
+
+	// Without a token, nobody can freeze.
+	code, _ := freezeRequest(t, freezer, "", http.MethodPost, "/ambassador/v0/freeze?reason=oops", "Bearer ")
+	assert.Equal(t, http.StatusForbidden, code)
+
+	code, _ = freezeRequest(t, freezer, "s3cret", http.MethodPost, "/ambassador/v0/freeze?reason=oops", "Bearer wrong")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = freezeRequest(t, freezer, "s3cret", http.MethodPost, "/ambassador/v0/freeze", "Bearer s3cret")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.False(t, freezer.State().Frozen)
+	assert.Empty(t, freezeHealthReason(freezer))
+
+	code, state := freezeRequest(t, freezer, "s3cret", http.MethodPost, "/ambassador/v0/freeze?reason=bad+merge", "Bearer s3cret")
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, state.Frozen)
+	assert.Equal(t, "bad merge", state.Reason)
+
+	// Anybody can see that it's frozen.
+	code, state = freezeRequest(t, freezer, "s3cret", http.MethodGet, "/ambassador/v0/freeze", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, state.Frozen)
+	assert.Contains(t, freezeHealthReason(freezer), "configuration frozen since")
+	assert.Contains(t, string(freezeMetrics(freezer)), "\nambassador_config_frozen 1\n")
+
+	code, _ = freezeRequest(t, freezer, "s3cret", http.MethodDelete, "/ambassador/v0/freeze", "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, state = freezeRequest(t, freezer, "s3cret", http.MethodDelete, "/ambassador/v0/freeze", "Bearer s3cret")
+	assert.Equal(t, http.StatusOK, code)
+	assert.False(t, state.Frozen)
+	assert.Contains(t, string(freezeMetrics(freezer)), "\nambassador_config_frozen 0\n")
+}
+
+func TestAppendFreezeMetrics(t *testing.T) {
+	freezer := ambex.NewFreezer()
+	freezer.Freeze("bad merge", time.Now())
+
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader("envoy_cluster_upstream_rq 1\n")),
+		Request:    httptest.NewRequest(http.MethodGet, "/metrics", nil),
+	}
+	require.NoError(t, appendFreezeMetrics(freezer)(resp))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(body), "envoy_cluster_upstream_rq 1\n"))
+	assert.Contains(t, string(body), "\nambassador_config_frozen 1\n")
+	assert.Equal(t, int64(len(body)), resp.ContentLength)
+}
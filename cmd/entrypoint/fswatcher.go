@@ -13,6 +13,9 @@ import (
 
 	"github.com/datawire/dlib/dlog"
 	"github.com/fsnotify/fsnotify"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+	"github.com/emissary-ingress/emissary/v3/pkg/debug"
 )
 
 // FSWatcher is a thing that can watch the filesystem for us, and
@@ -49,7 +52,7 @@ type FSWatcher struct {
 	mutex       sync.Mutex
 	handlers    map[string]FSWEventHandler
 	handleError FSWErrorHandler
-	cTimer      *time.Timer
+	cTimer      clock.Timer
 	marker      chan time.Time
 	outstanding map[string]bool
 }
@@ -138,6 +141,10 @@ func (fsw *FSWatcher) WatchDir(ctx context.Context, dir string, handler FSWEvent
 	if err := fsw.FSW.Add(dir); err != nil {
 		return err
 	}
+	if _, ok := fsw.handlers[dir]; !ok {
+		// Directories are never unwatched, so this never gets closed.
+		debug.FromContext(ctx).Leaks().OpenWatch("fswatcher")
+	}
 	fsw.handlers[dir] = handler
 
 	fileinfos, err := ioutil.ReadDir(dir)
@@ -168,6 +175,7 @@ func (fsw *FSWatcher) defaultErrorHandler(ctx context.Context, err error) {
 
 // Watch for events, and handle them.
 func (fsw *FSWatcher) Run(ctx context.Context) {
+	clk := clock.FromContext(ctx)
 	for {
 		select {
 		case event := <-fsw.FSW.Events:
@@ -182,14 +190,14 @@ func (fsw *FSWatcher) Run(ctx context.Context) {
 			if fsw.cTimer != nil {
 				dlog.Debugf(ctx, "FSW: stopping cTimer")
 
-				if !fsw.cTimer.Stop() {
-					<-fsw.cTimer.C
-				}
+				// There's no channel to drain: if the timer has already fired, its marker
+				// is on the way, and just ends this round of coalescing a little early.
+				fsw.cTimer.Stop()
 			}
 
 			dlog.Debugf(ctx, "FSW: starting cTimer")
-			fsw.cTimer = time.AfterFunc(500*time.Millisecond, func() {
-				fsw.marker <- time.Now()
+			fsw.cTimer = clk.AfterFunc(500*time.Millisecond, func() {
+				fsw.marker <- clk.Now()
 			})
 
 			dlog.Debugf(ctx, "FSW: unlocking")
@@ -220,7 +228,7 @@ func (fsw *FSWatcher) Run(ctx context.Context) {
 
 					info, err := os.Stat(evtPath)
 
-					eventTime := time.Now()
+					eventTime := clk.Now()
 
 					if err != nil {
 						op = FSWDelete
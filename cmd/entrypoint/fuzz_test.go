@@ -0,0 +1,143 @@
+package entrypoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/ambex"
+	amb "github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
+	"github.com/emissary-ingress/emissary/v3/pkg/consulwatch"
+	"github.com/emissary-ingress/emissary/v3/pkg/debug"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	"github.com/emissary-ingress/emissary/v3/pkg/resolverplugin"
+	"github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+// compileYAML runs the resources in some YAML through everything that the watcher does with a
+// change from Kubernetes, all at once, without watching, or talking to, anything, and returns the
+// snapshot that it would send on, complete or not. Resources that aren't even Kubernetes resources
+// are an error, as they would be for kubectl; anything else that's wrong with them should land in
+// the snapshot's Invalid list.
+func compileYAML(ctx context.Context, yaml string) (*snapshot.Snapshot, error) {
+	// Keep timers and resource timings out of the global debug root.
+	ctx = debug.NewContext(ctx, debug.NewDebug())
+
+	objs, err := kates.ParseManifestsToUnstructured(yaml)
+	if err != nil {
+		return nil, err
+	}
+	store := NewK8sStore()
+	for _, obj := range objs {
+		if err := store.Upsert(obj); err != nil {
+			return nil, err
+		}
+	}
+
+	sh, err := NewSnapshotHolder(nil)
+	if err != nil {
+		return nil, err
+	}
+	watcher := &fakeK8sWatcher{
+		cursor:  store.Cursor(),
+		queries: GetQueries(ctx, GetInterestingTypes(ctx, nil)),
+	}
+	consulWatcher := newConsulWatcher(nopWatchConsul)
+	pluginWatcher := newPluginWatcher(nopWatchPlugin)
+	nopFastpath := func(context.Context, *ambex.FastpathSnapshot) {}
+	if _, err := sh.K8sUpdate(ctx, watcher, consulWatcher, pluginWatcher, nopFastpath); err != nil {
+		return nil, err
+	}
+
+	var snapJSON []byte
+	err = sh.Notify(ctx, &atomic.Value{}, consulWatcher, func(_ context.Context, _ SnapshotDisposition, bytes []byte) error {
+		snapJSON = bytes
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if snapJSON == nil {
+		return nil, fmt.Errorf("no snapshot")
+	}
+	var snap *snapshot.Snapshot
+	if err := json.Unmarshal(snapJSON, &snap); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+func nopWatchConsul(context.Context, *amb.ConsulResolver, string, chan consulwatch.Endpoints) (Stopper, error) {
+	return &fakeStopper{func() {}}, nil
+}
+
+func nopWatchPlugin(context.Context, *amb.PluginResolver, []string, chan resolverplugin.Endpoints) (Stopper, error) {
+	return &fakeStopper{func() {}}, nil
+}
+
+func TestCompileYAML(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+
+	snap, err := compileYAML(ctx, `
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+metadata:
+  name: hello
+  namespace: default
+spec:
+  hostname: "*"
+  prefix: /hello/
+  service: hello
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+metadata:
+  name: broken
+  namespace: default
+spec:
+  prefix: 42
+`)
+	require.NoError(t, err)
+	require.Len(t, snap.Kubernetes.Mappings, 1)
+	assert.Equal(t, "hello", snap.Kubernetes.Mappings[0].Name)
+	require.Len(t, snap.Invalid, 1)
+	assert.Equal(t, "broken", snap.Invalid[0].GetName())
+	assert.NotEmpty(t, snap.Invalid[0].Object["errors"])
+
+	_, err = compileYAML(ctx, "kind: [Mapping\n")
+	assert.Error(t, err)
+}
+
+// FuzzCompileYAML feeds arbitrary YAML through the watcher. Anything that doesn't make sense has
+// to be rejected, with an error or as an invalid resource: a panic, even one that gets caught, is
+// a bug.
+func FuzzCompileYAML(f *testing.F) {
+	seeds, err := filepath.Glob("testdata/*.yaml")
+	require.NoError(f, err)
+	for _, seed := range seeds {
+		bytes, err := os.ReadFile(seed)
+		require.NoError(f, err)
+		f.Add(string(bytes))
+	}
+
+	f.Fuzz(func(t *testing.T, yaml string) {
+		snap, err := compileYAML(dlog.NewTestContext(t, false), yaml)
+		if err != nil {
+			assert.NotContains(t, err.Error(), "PANIC")
+			return
+		}
+		for _, invalid := range snap.Invalid {
+			assert.NotContains(t, fmt.Sprint(invalid.Object["errors"]), "PANIC",
+				"%s %s/%s", invalid.GetKind(), invalid.GetNamespace(), invalid.GetName())
+		}
+	})
+}
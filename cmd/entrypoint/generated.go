@@ -0,0 +1,31 @@
+package entrypoint
+
+import (
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+)
+
+// Everything that Emissary creates in Kubernetes is labeled with generatedByLabel (saying what
+// generated it), and annotated with generatedIDAnnotation (saying which Ambassador ID it's for: an
+// ID isn't always a valid label value), so that it's easy to find, and to tell apart from what
+// people created themselves.
+const (
+	generatedByLabel      = "getambassador.io/generated-by"
+	generatedIDAnnotation = "getambassador.io/generated-for-id"
+)
+
+// markGenerated labels obj as generated by generator.
+func markGenerated(obj kates.Object, generator string) {
+	labels := obj.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[generatedByLabel] = generator
+	obj.SetLabels(labels)
+
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[generatedIDAnnotation] = GetAmbassadorID()
+	obj.SetAnnotations(annotations)
+}
@@ -2,35 +2,59 @@ package entrypoint
 
 import (
 	"context"
+	"encoding/json"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/http/pprof"
 	"net/url"
+	"sync/atomic"
 
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 
 	"github.com/datawire/dlib/dhttp"
 	"github.com/emissary-ingress/emissary/v3/pkg/acp"
+	"github.com/emissary-ingress/emissary/v3/pkg/ambex"
+	"github.com/emissary-ingress/emissary/v3/pkg/capture"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
 	"github.com/emissary-ingress/emissary/v3/pkg/debug"
+	"github.com/emissary-ingress/emissary/v3/pkg/envoyprofile"
+	"github.com/emissary-ingress/emissary/v3/pkg/eventbus"
+	"github.com/emissary-ingress/emissary/v3/pkg/featuregate"
 )
 
-func handleCheckAlive(w http.ResponseWriter, r *http.Request, ambwatch *acp.AmbassadorWatcher) {
+func handleCheckAlive(w http.ResponseWriter, r *http.Request, ambwatch *acp.AmbassadorWatcher, freezer *ambex.Freezer, startup *startupPlan) {
 	// The liveness check needs to explicitly try to talk to Envoy...
 	ambwatch.FetchEnvoyReady(r.Context())
 
-	// ...then check if the watcher says we're alive.
+	// ...then check if the watcher says we're alive. An Envoy that's starting up or
+	// draining is alive, but say so, since it isn't ready.
 	ok := ambwatch.IsAlive()
 
+	// Either way, say how the checks of Envoy have been going, for anyone working out
+	// why the probe flaps.
+	details := ambwatch.EnvoyDetails() + "\n"
+
 	if ok {
-		_, _ = w.Write([]byte("Ambassador is alive and well\n"))
+		reason := ""
+		if envoyReason := ambwatch.EnvoyReason(); envoyReason != "" {
+			reason = " (" + envoyReason + ")"
+		}
+		_, _ = w.Write([]byte("Ambassador is alive and well" + freezeHealthReason(freezer) + reason + "\n" + details))
 	} else {
-		http.Error(w, "Ambassador is not alive\n", http.StatusServiceUnavailable)
+		http.Error(w, "Ambassador is not alive"+startupHealthReason(startup)+"\n"+details, http.StatusServiceUnavailable)
 	}
 }
 
-func handleCheckReady(w http.ResponseWriter, r *http.Request, ambwatch *acp.AmbassadorWatcher) {
+func handleCheckReady(w http.ResponseWriter, r *http.Request, ambwatch *acp.AmbassadorWatcher, freezer *ambex.Freezer, plan *shutdownPlan, startup *startupPlan) {
+	// Once we're shutting down, we're not ready, no matter what: that's how the endpoints
+	// controller finds out to stop sending us traffic.
+	if plan.failingReadiness() {
+		http.Error(w, "Ambassador is not ready (shutting down)\n", http.StatusServiceUnavailable)
+		return
+	}
+
 	// The readiness check needs to explicitly try to talk to Envoy, too. Why?
 	// Because if you have a pod configured with only the readiness check but
 	// not the liveness check, and we don't try to talk to Envoy here, then we
@@ -39,16 +63,54 @@ func handleCheckReady(w http.ResponseWriter, r *http.Request, ambwatch *acp.Amba
 	ambwatch.FetchEnvoyReady(r.Context())
 
 	ok := ambwatch.IsReady()
+	reason := readyHealthReason(ambwatch)
+	details := ambwatch.EnvoyDetails() + "\n"
 
 	if ok {
-		_, _ = w.Write([]byte("Ambassador is ready and waiting\n"))
+		_, _ = w.Write([]byte("Ambassador is ready and waiting" + freezeHealthReason(freezer) + reason + "\n" + details))
 	} else {
-		http.Error(w, "Ambassador is not ready\n", http.StatusServiceUnavailable)
+		http.Error(w, "Ambassador is not ready"+startupHealthReason(startup)+reason+"\n"+details, http.StatusServiceUnavailable)
+	}
+}
+
+// readyHealthReason is what the readiness check adds to its message about anything (like Envoy
+// draining, or a stale API server connection) that does, or could, make Ambassador unready.
+func readyHealthReason(ambwatch *acp.AmbassadorWatcher) string {
+	if reason := ambwatch.ReadyReason(); reason != "" {
+		return " (" + reason + ")"
+	}
+	return ""
+}
+
+// versionInfo is what the version endpoint returns.
+type versionInfo struct {
+	Version      string               `json:"version"`
+	FeatureGates []featuregate.Status `json:"feature_gates"`
+}
+
+func handleVersion(w http.ResponseWriter, r *http.Request, version string) {
+	bytes, err := json.MarshalIndent(versionInfo{
+		Version:      version,
+		FeatureGates: featuregate.FromContext(r.Context()).Statuses(),
+	}, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(append(bytes, '\n'))
 }
 
-func healthCheckHandler(ctx context.Context, ambwatch *acp.AmbassadorWatcher) error {
+func healthCheckHandler(ctx context.Context, version string, ambwatch *acp.AmbassadorWatcher, snapshot *atomic.Value) error {
 	dbg := debug.FromContext(ctx)
+	freezer := ambex.FreezerFromContext(ctx)
+	gate := diagdGateFromContext(ctx)
+	bus := eventbus.FromContext(ctx)
+	plan := shutdownPlanFromContext(ctx)
+	startup := startupPlanFromContext(ctx)
+
+	// The admin endpoints go through admin; a nil one (the default) lets everything through.
+	admin := adminAuthorizerFromContext(ctx)
 
 	// We need to do some HTTP stuff by hand to catch the readiness and liveness
 	// checks here, but forward everything else to diagd.
@@ -60,24 +122,140 @@ func healthCheckHandler(ctx context.Context, ambwatch *acp.AmbassadorWatcher) er
 	livenessTimer := dbg.Timer("check_alive")
 	sm.HandleFunc("/ambassador/v0/check_alive",
 		livenessTimer.TimedHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			handleCheckAlive(w, r, ambwatch)
+			handleCheckAlive(w, r, ambwatch, freezer, startup)
 		}))
 
 	readinessTimer := dbg.Timer("check_ready")
 	sm.HandleFunc("/ambassador/v0/check_ready",
 		readinessTimer.TimedHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			handleCheckReady(w, r, ambwatch)
+			handleCheckReady(w, r, ambwatch, freezer, plan, startup)
 		}))
 
+	// Report our version, and which feature gates are on.
+	sm.HandleFunc("/ambassador/v0/version", func(w http.ResponseWriter, r *http.Request) {
+		handleVersion(w, r, version)
+	})
+
+	// Capture a Mapping's requests and responses with Envoy's tap filter.
+	capturer := capture.NewCapturer(GetEnvoyAdminURL())
+	sm.Handle("/ambassador/v0/capture", admin.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleCapture(ctx, w, r, capturer, snapshot)
+	})))
+
+	// Freeze and thaw configuration pushes to Envoy.
+	allowFreeze := freezeTokenCheck(GetConfigFreezeToken())
+	if admin != nil {
+		allowFreeze = func(http.ResponseWriter, *http.Request) bool { return true }
+	}
+	sm.Handle("/ambassador/v0/freeze", admin.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleFreeze(w, r, freezer, allowFreeze)
+	})))
+
+	// Ask for, and follow, a full resync of everything the watcher watches.
+	sm.Handle("/ambassador/v0/resync", admin.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleResync(w, r, resyncerFromContext(ctx))
+	})))
+
+	// List the resources waiting for approval, and approve them.
+	sm.Handle("/ambassador/v0/approvals", admin.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleApprovals(w, r, approvalsFromContext(ctx), admin != nil, resyncerFromContext(ctx))
+	})))
+
+	// List, flip, and forget the maintenance switches of Mappings and Hosts.
+	if maintenance := maintenanceSwitchesFromContext(ctx); maintenance != nil {
+		sm.Handle("/ambassador/v0/maintenance", admin.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handleMaintenance(w, r, maintenance)
+		})))
+	}
+
+	// Where booting has got to.
+	sm.HandleFunc("/ambassador/v0/startup", func(w http.ResponseWriter, r *http.Request) {
+		handleStartupStatus(w, r, startup)
+	})
+
+	// Where the shutdown plan has got to.
+	sm.HandleFunc("/ambassador/v0/shutdown", func(w http.ResponseWriter, r *http.Request) {
+		handleShutdownStatus(w, r, plan)
+	})
+
+	// The preStop hook: fail readiness and drain Envoy, and answer once that's done. There's no
+	// token for the hook to send, so instead this only answers POSTs from inside the pod.
+	sm.HandleFunc("/shutdown-ready", func(w http.ResponseWriter, r *http.Request) {
+		handleShutdownReady(w, r, plan)
+	})
+
+	// How Envoy's drain is going, for automation that needs to wait for it.
+	drain := newDrainTracker(clock.FromContext(ctx), GetEnvoyAdminURL())
+	sm.HandleFunc("/ambassador/v0/drain", func(w http.ResponseWriter, r *http.Request) {
+		handleDrain(w, r, drain, plan)
+	})
+
+	// Profile Envoy and this process together, and bundle up the results.
+	profiler := newProfileBundler(clock.FromContext(ctx), envoyprofile.NewProfiler(GetEnvoyAdminURL(), envoyprofile.ProfilePath))
+	sm.Handle("/ambassador/v0/profile", admin.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleProfile(w, r, profiler)
+	})))
+
+	// Envoy's running config, with its secrets redacted.
+	sm.Handle("/ambassador/v0/envoy_config_dump", admin.Wrap(compressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleEnvoyConfigDump(w, r, ambwatch.EnvoyWatcher())
+	}))))
+
+	// A few of Envoy's own admin endpoints, each authorized on its own.
+	envoyAdmin := newEnvoyAdminProxy(ambwatch.EnvoyWatcher(), GetEnvoyAdminProxyEndpoints(), admin != nil)
+	sm.Handle(envoyAdminProxyPrefix, admin.Wrap(compressHandler(envoyAdmin)))
+
+	// What the Host prober last found for each Host.
+	sm.HandleFunc("/ambassador/v0/probes", handleHostProbes)
+
+	// Traffic for each Mapping and Host, rolled up from Envoy's cluster stats.
+	sm.HandleFunc("/ambassador/v0/traffic", handleTrafficRollups)
+
+	// What Envoy thinks of each upstream cluster's members.
+	sm.HandleFunc("/ambassador/v0/upstream_health", func(w http.ResponseWriter, r *http.Request) {
+		handleUpstreamHealth(w, r, ambwatch)
+	})
+
+	// What the watchers behind the health checks have been doing.
+	sm.HandleFunc("/ambassador/v0/watcher_metrics", func(w http.ResponseWriter, r *http.Request) {
+		handleWatcherMetrics(w, r, ambwatch)
+	})
+
+	// The aggregated OpenAPI document of each Host, and its docs page.
+	sm.HandleFunc("/ambassador/v0/openapi/", handleOpenAPI)
+
+	// What contract capture has captured, sent, and dropped.
+	sm.HandleFunc("/ambassador/v0/contract_capture", handleContractCapture)
+
 	// Serve any debug info from the golang codebase.
-	sm.Handle("/debug", dbg)
+	sm.Handle("/debug", admin.Wrap(dbg))
+
+	// Serve the slowest resources for each processing phase, e.g. /debug/resources/validate.
+	sm.Handle("/debug/resources/", admin.Wrap(dbg.ResourceTimingsHandler()))
+
+	// Map generated Envoy cluster names back to Kubernetes Services and Mappings, and vice versa.
+	sm.Handle("/debug/introspect", admin.Wrap(http.HandlerFunc(handleIntrospect)))
 
 	// Serve pprof endpoints to aid in live debugging.
-	sm.HandleFunc("/debug/pprof/", pprof.Index)
-	sm.HandleFunc("/debug/pprof/profile", pprof.Profile)
-	sm.HandleFunc("/debug/pprof/trace", pprof.Trace)
-	sm.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
-	sm.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	sm.Handle("/debug/pprof/", admin.Wrap(http.HandlerFunc(pprof.Index)))
+	sm.Handle("/debug/pprof/profile", admin.Wrap(http.HandlerFunc(pprof.Profile)))
+	sm.Handle("/debug/pprof/trace", admin.Wrap(http.HandlerFunc(pprof.Trace)))
+	sm.Handle("/debug/pprof/symbol", admin.Wrap(http.HandlerFunc(pprof.Symbol)))
+	sm.Handle("/debug/pprof/cmdline", admin.Wrap(http.HandlerFunc(pprof.Cmdline)))
+
+	// What this process knows about itself, that diagd doesn't.
+	ownMetrics := []func() []byte{
+		func() []byte { return freezeMetrics(freezer) },
+		func() []byte { return leakMetrics(dbg.Leaks()) },
+		func() []byte { return panicMetrics(subsystemPanics) },
+		func() []byte { return diagdGateMetrics(gate) },
+		func() []byte { return hostProbeMetrics(loadHostProbes(dbg)) },
+		func() []byte { return eventBusMetrics(bus) },
+		func() []byte { return concurrencyMetrics(getConcurrencyPlan()) },
+		func() []byte { return statusWriterMetrics(statusWriterFromContext(ctx)) },
+		func() []byte { return listenerUpdateMetrics(loadListenerUpdates(dbg)) },
+		func() []byte { return watcherMetrics(ambwatch.Metrics()) },
+	}
 
 	// For everything else, use a ReverseProxy to forward it to diagd.
 	//
@@ -98,11 +276,22 @@ func healthCheckHandler(ctx context.Context, ambwatch *acp.AmbassadorWatcher) er
 				req.Header.Set("X-Ambassador-Diag-IP", "127.0.0.1")
 			}
 		},
+		// diagd doesn't know about freezes, leaks, panics, the gate, the Host probes, the event bus,
+		// concurrency, the status writer, listener updates, or the watchers, so add them to its
+		// metrics.
+		ModifyResponse: appendMetrics(ownMetrics...),
 	}
 
-	// Finally, use the reverseProxy to handle anything coming in on
-	// the magic catchall path.
-	sm.HandleFunc("/", reverseProxy.ServeHTTP)
+	// Finally, use the reverseProxy, behind the diagd gate, to handle
+	// anything coming in on the magic catchall path. Config dumps and
+	// metrics can be big, so they get compressed on the way out.
+	sm.Handle("/", gate.handler(compressHandler(reverseProxy)))
+
+	// Our own metrics go out on /metrics whether diagd answers or not.
+	sm.Handle("/metrics", compressHandler(metricsHandler(gate.handler(reverseProxy), ownMetrics...)))
+
+	// diagd does dry runs of proposed resources, but they're admin requests.
+	sm.Handle("/ambassador/v0/dry_run", admin.Wrap(gate.handler(compressHandler(reverseProxy))))
 
 	// Set up listener.
 	// The default value for network is ANY.
@@ -115,6 +304,7 @@ func healthCheckHandler(ctx context.Context, ambwatch *acp.AmbassadorWatcher) er
 	if err != nil {
 		return err
 	}
+	startup.done(startupAdminPort)
 
 	s := &dhttp.ServerConfig{
 		Handler: sm,
@@ -0,0 +1,368 @@
+package entrypoint
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	amb "github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+	"github.com/emissary-ingress/emissary/v3/pkg/debug"
+)
+
+// Host probing: every AMBASSADOR_HOST_PROBE_INTERVAL_SECONDS, the prober sends a synthetic
+// `HEAD /` for each Host through the local Envoy listener (over HTTPS, with the Host's hostname as
+// the SNI, if the Host has a TLS secret), the same way a user's request would get there. A Host is
+// up if it gets the Host's `probe_expected_status`, or, if that isn't set, anything but a 5xx. That
+// way a vhost that's broken (no routes, a bad certificate, an upstream that's gone) shows up in the
+// diag data and in /metrics before users start reporting it.
+//
+// The prober is off unless AMBASSADOR_HOST_PROBE_INTERVAL_SECONDS is set. It talks to Envoy on
+// AMBASSADOR_HOST_PROBE_HTTP_PORT (default 8080) and AMBASSADOR_HOST_PROBE_HTTPS_PORT (default
+// 8443). A wildcard hostname is probed as if it were ambassador-probe in its domain.
+
+// hostProbesDebugValue is the name of the debug value holding the []*HostProbe from the most
+// recent round of probes.
+const hostProbesDebugValue = "hostProbes"
+
+// hostProbeWindow is how many of each Host's most recent probes its availability is figured from.
+const hostProbeWindow = 20
+
+// hostProbeTimeout is the most a single probe can take.
+const hostProbeTimeout = 5 * time.Second
+
+// GetHostProbeInterval returns how often to probe every Host, from
+// AMBASSADOR_HOST_PROBE_INTERVAL_SECONDS. Zero, the default, turns the prober off.
+func GetHostProbeInterval() time.Duration {
+	secs, err := strconv.Atoi(env("AMBASSADOR_HOST_PROBE_INTERVAL_SECONDS", "0"))
+	if err != nil || secs < 0 {
+		secs = 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// GetHostProbeHTTPPort returns the Envoy listener port to probe cleartext Hosts on.
+func GetHostProbeHTTPPort() string {
+	return env("AMBASSADOR_HOST_PROBE_HTTP_PORT", "8080")
+}
+
+// GetHostProbeHTTPSPort returns the Envoy listener port to probe Hosts with TLS on.
+func GetHostProbeHTTPSPort() string {
+	return env("AMBASSADOR_HOST_PROBE_HTTPS_PORT", "8443")
+}
+
+// HostProbe is what the prober knows about one Host.
+type HostProbe struct {
+	Namespace      string     `json:"namespace"`
+	Name           string     `json:"name"`
+	Hostname       string     `json:"hostname"`
+	URL            string     `json:"url"`
+	ExpectedStatus int        `json:"expected_status,omitempty"`
+	Up             bool       `json:"up"`
+	Status         int        `json:"status,omitempty"`
+	Error          string     `json:"error,omitempty"`
+	LatencyMs      float64    `json:"latency_ms"`
+	LastProbe      time.Time  `json:"last_probe"`
+	LastUp         *time.Time `json:"last_up,omitempty"`
+	Probes         uint64     `json:"probes"`
+	Failures       uint64     `json:"failures"`
+	// Availability is the fraction of the last hostProbeWindow probes that found the Host up.
+	Availability float64 `json:"availability"`
+
+	recent []bool
+}
+
+type hostProber struct {
+	clock     clock.Clock
+	client    *http.Client
+	httpAddr  string
+	httpsAddr string
+
+	mu     sync.Mutex
+	probes map[string]*HostProbe // by namespace/name
+}
+
+// newHostProber returns a prober that sends every request to httpAddr, or to httpsAddr for Hosts
+// with TLS, whatever the URL says, so that it goes through Envoy.
+func newHostProber(clk clock.Clock, httpAddr, httpsAddr string) *hostProber {
+	dialer := &net.Dialer{Timeout: hostProbeTimeout}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if strings.HasSuffix(addr, ":443") {
+				return dialer.DialContext(ctx, network, httpsAddr)
+			}
+			return dialer.DialContext(ctx, network, httpAddr)
+		},
+		// We're checking that the Host is served, not that its certificate is any good;
+		// plenty of Hosts have certificates that don't chain to anything we have.
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true}, //nolint:gosec
+		DisableKeepAlives: true,
+	}
+	return &hostProber{
+		clock: clk,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   hostProbeTimeout,
+			// A redirect (to HTTPS, say) is an answer, not something to follow.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		httpAddr:  httpAddr,
+		httpsAddr: httpsAddr,
+		probes:    map[string]*HostProbe{},
+	}
+}
+
+// runHostProber probes every Host in the newest snapshot every interval until the context is done.
+func runHostProber(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return nil
+	}
+	clk := clock.FromContext(ctx)
+	prober := newHostProber(clk,
+		net.JoinHostPort("127.0.0.1", GetHostProbeHTTPPort()),
+		net.JoinHostPort("127.0.0.1", GetHostProbeHTTPSPort()))
+
+	snapshots := followSnapshots(ctx, "host_prober")
+	defer snapshots.Close()
+
+	ticker := clk.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case ev, ok := <-snapshots.Events():
+			snapshots.note(ev, ok)
+			continue
+		case <-ticker.C():
+		case <-ctx.Done():
+			return nil
+		}
+
+		snap := snapshots.latest()
+		if snap == nil || snap.Kubernetes == nil {
+			continue
+		}
+		probes := prober.round(ctx, snap.Kubernetes.Hosts)
+		debug.FromContext(ctx).Value(hostProbesDebugValue).Store(probes)
+		postHostProbes(ctx, probes)
+	}
+}
+
+// round probes every one of hosts, at the same time, and returns where all of them stand, sorted
+// by namespace and name. Hosts that have gone away are forgotten.
+func (p *hostProber) round(ctx context.Context, hosts []*amb.Host) []*HostProbe {
+	type result struct {
+		key     string
+		host    *amb.Host
+		url     string
+		status  int
+		err     error
+		latency time.Duration
+	}
+	results := make([]result, len(hosts))
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		results[i].key = host.GetNamespace() + "/" + host.GetName()
+		results[i].host = host
+		results[i].url = hostProbeURL(host)
+		wg.Add(1)
+		go func(r *result) {
+			defer wg.Done()
+			r.status, r.latency, r.err = p.probe(ctx, r.url)
+		}(&results[i])
+	}
+	wg.Wait()
+
+	now := p.clock.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	probes := make(map[string]*HostProbe, len(results))
+	for _, r := range results {
+		probe := p.probes[r.key]
+		if probe == nil {
+			probe = &HostProbe{}
+		}
+		probe.Namespace, probe.Name = r.host.GetNamespace(), r.host.GetName()
+		probe.Hostname, probe.URL = hostProbeHostname(r.host), r.url
+		probe.ExpectedStatus = 0
+		if r.host.Spec != nil {
+			probe.ExpectedStatus = r.host.Spec.ProbeExpectedStatus
+		}
+		probe.record(now, r.status, r.latency, r.err)
+		probes[r.key] = probe
+	}
+	p.probes = probes
+
+	sorted := make([]*HostProbe, 0, len(probes))
+	for _, probe := range probes {
+		c := *probe
+		c.recent = nil
+		sorted = append(sorted, &c)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Namespace != sorted[j].Namespace {
+			return sorted[i].Namespace < sorted[j].Namespace
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
+
+// probe sends one `HEAD` to url, and returns the status it got.
+func (p *hostProber) probe(ctx context.Context, url string) (int, time.Duration, error) {
+	start := p.clock.Now()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header.Set("User-Agent", "ambassador-host-prober")
+	resp, err := p.client.Do(req)
+	latency := p.clock.Now().Sub(start)
+	if err != nil {
+		return 0, latency, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, latency, nil
+}
+
+func (probe *HostProbe) record(now time.Time, status int, latency time.Duration, err error) {
+	probe.Status, probe.Error = status, ""
+	if err != nil {
+		probe.Error = err.Error()
+	}
+	if probe.ExpectedStatus != 0 {
+		probe.Up = err == nil && status == probe.ExpectedStatus
+	} else {
+		probe.Up = err == nil && status < 500
+	}
+	if err == nil && !probe.Up {
+		probe.Error = fmt.Sprintf("got status %d", status)
+	}
+
+	probe.LatencyMs = float64(latency) / float64(time.Millisecond)
+	probe.LastProbe = now
+	probe.Probes++
+	if probe.Up {
+		probe.LastUp = &now
+	} else {
+		probe.Failures++
+	}
+
+	probe.recent = append(probe.recent, probe.Up)
+	if len(probe.recent) > hostProbeWindow {
+		probe.recent = probe.recent[len(probe.recent)-hostProbeWindow:]
+	}
+	up := 0
+	for _, ok := range probe.recent {
+		if ok {
+			up++
+		}
+	}
+	probe.Availability = float64(up) / float64(len(probe.recent))
+}
+
+// hostProbeHostname returns the hostname to probe a Host as: its own, unless it's a wildcard.
+func hostProbeHostname(host *amb.Host) string {
+	hostname := "*"
+	if host.Spec != nil && host.Spec.Hostname != "" {
+		hostname = host.Spec.Hostname
+	}
+	if strings.HasPrefix(hostname, "*") {
+		hostname = "ambassador-probe" + strings.TrimPrefix(hostname, "*")
+	}
+	return hostname
+}
+
+// hostProbeURL returns the URL to probe a Host at. The scheme only says whether to go to the HTTP
+// or the HTTPS listener; the prober's transport picks the real address.
+func hostProbeURL(host *amb.Host) string {
+	if host.Spec != nil && host.Spec.TLSSecret != nil && host.Spec.TLSSecret.Name != "" {
+		return "https://" + hostProbeHostname(host) + "/"
+	}
+	return "http://" + hostProbeHostname(host) + "/"
+}
+
+// postHostProbes hands the probes to diagd, for the diag UI.
+func postHostProbes(ctx context.Context, probes []*HostProbe) {
+	body, err := json.Marshal(probes)
+	if err != nil {
+		dlog.Errorf(ctx, "Host probes: %v", err)
+		return
+	}
+	url := fmt.Sprintf("%s/%s/host_probes", GetEventHost(), GetEventPath())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		dlog.Errorf(ctx, "Host probes: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := &http.Client{Timeout: hostProbeTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		// diagd might not be up yet.
+		dlog.Debugf(ctx, "Host probes: posting to diagd: %v", err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// loadHostProbes returns the most recent round of probes, if there's been one.
+func loadHostProbes(dbg *debug.Debug) []*HostProbe {
+	probes, _ := dbg.Value(hostProbesDebugValue).Load().([]*HostProbe)
+	return probes
+}
+
+func handleHostProbes(w http.ResponseWriter, r *http.Request) {
+	probes := loadHostProbes(debug.FromContext(r.Context()))
+	if probes == nil {
+		probes = []*HostProbe{}
+	}
+	bytes, err := json.MarshalIndent(probes, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(append(bytes, '\n'))
+}
+
+// hostProbeMetrics are the probes, for /metrics.
+func hostProbeMetrics(probes []*HostProbe) []byte {
+	if len(probes) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	write := func(name, typ, help string, value func(*HostProbe) string) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", name, typ)
+		for _, probe := range probes {
+			fmt.Fprintf(&buf, "%s{namespace=%q,name=%q,hostname=%q} %s\n",
+				name, probe.Namespace, probe.Name, probe.Hostname, value(probe))
+		}
+	}
+	write("ambassador_host_probe_up", "gauge", "Whether the last probe of the Host found it up.",
+		func(probe *HostProbe) string {
+			if probe.Up {
+				return "1"
+			}
+			return "0"
+		})
+	write("ambassador_host_probe_availability", "gauge", "The fraction of the Host's recent probes that found it up.",
+		func(probe *HostProbe) string { return strconv.FormatFloat(probe.Availability, 'f', -1, 64) })
+	write("ambassador_host_probe_latency_seconds", "gauge", "How long the Host's last probe took.",
+		func(probe *HostProbe) string { return strconv.FormatFloat(probe.LatencyMs/1000, 'f', -1, 64) })
+	write("ambassador_host_probe_failures_total", "counter", "Probes that found the Host down.",
+		func(probe *HostProbe) string { return strconv.FormatUint(probe.Failures, 10) })
+	return buf.Bytes()
+}
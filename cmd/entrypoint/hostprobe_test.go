@@ -0,0 +1,94 @@
+package entrypoint
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+
+	amb "github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+)
+
+func TestHostProber(t *testing.T) {
+	// A stand-in for Envoy's listeners, that answers by Host header.
+	envoy := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodHead, r.Method)
+		switch {
+		case r.Host == "broken.example.com":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.Host == "redirect.example.com":
+			http.Redirect(w, r, "https://redirect.example.com/", http.StatusMovedPermanently)
+		case strings.HasSuffix(r.Host, ".wild.example.com"):
+			w.WriteHeader(http.StatusNotFound)
+		case r.Host == "tls.example.com" && r.TLS != nil && r.TLS.ServerName == "tls.example.com":
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusTeapot)
+		}
+	})
+	plain := httptest.NewServer(envoy)
+	defer plain.Close()
+	secure := httptest.NewTLSServer(envoy)
+	defer secure.Close()
+
+	clk := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	prober := newHostProber(clk, plain.Listener.Addr().String(), secure.Listener.Addr().String())
+
+	host := func(name, hostname string, tls bool, expected int) *amb.Host {
+		h := &amb.Host{
+			ObjectMeta: kates.ObjectMeta{Namespace: "default", Name: name},
+			Spec:       &amb.HostSpec{Hostname: hostname, ProbeExpectedStatus: expected},
+		}
+		if tls {
+			h.Spec.TLSSecret = &corev1.SecretReference{Name: name}
+		}
+		return h
+	}
+	hosts := []*amb.Host{
+		host("broken", "broken.example.com", false, 0),
+		host("redirect", "redirect.example.com", false, http.StatusMovedPermanently),
+		host("tls", "tls.example.com", true, 0),
+		host("wild", "*.wild.example.com", false, 0),
+		host("picky", "picky.example.com", false, http.StatusOK),
+	}
+
+	probes := prober.round(context.Background(), hosts)
+	require.Len(t, probes, 5)
+	byName := map[string]*HostProbe{}
+	for _, probe := range probes {
+		byName[probe.Name] = probe
+	}
+
+	assert.False(t, byName["broken"].Up)
+	assert.Equal(t, http.StatusServiceUnavailable, byName["broken"].Status)
+	assert.Equal(t, "got status 503", byName["broken"].Error)
+	assert.True(t, byName["redirect"].Up)
+	assert.True(t, byName["tls"].Up)
+	assert.Equal(t, "https://tls.example.com/", byName["tls"].URL)
+	assert.True(t, byName["wild"].Up)
+	assert.Equal(t, "ambassador-probe.wild.example.com", byName["wild"].Hostname)
+	assert.False(t, byName["picky"].Up)
+	assert.Equal(t, http.StatusTeapot, byName["picky"].Status)
+
+	// Availability is over the recent probes; a Host that goes away is forgotten.
+	hosts[4].Spec.ProbeExpectedStatus = http.StatusTeapot
+	probes = prober.round(context.Background(), hosts[3:])
+	require.Len(t, probes, 2)
+	assert.Equal(t, "picky", probes[0].Name)
+	assert.True(t, probes[0].Up)
+	assert.Equal(t, 0.5, probes[0].Availability)
+	assert.Equal(t, uint64(2), probes[0].Probes)
+	assert.Equal(t, uint64(1), probes[0].Failures)
+
+	metrics := string(hostProbeMetrics(probes))
+	assert.Contains(t, metrics, `ambassador_host_probe_up{namespace="default",name="picky",hostname="picky.example.com"} 1`)
+	assert.Contains(t, metrics, `ambassador_host_probe_availability{namespace="default",name="picky",hostname="picky.example.com"} 0.5`)
+}
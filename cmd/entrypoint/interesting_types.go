@@ -8,16 +8,30 @@ import (
 	"github.com/emissary-ingress/emissary/v3/pkg/kates"
 )
 
+// grpcDescriptorsLabel marks the ConfigMaps that hold protobuf descriptors for gRPC-JSON
+// transcoding; see python/ambassador/ir/irgrpctranscoder.py.
+const grpcDescriptorsLabel = "getambassador.io/grpc-descriptors"
+
+// openAPILabel marks the ConfigMaps that hold OpenAPI documents for Mappings; see openapi.go.
+const openAPILabel = "getambassador.io/openapi"
+
+// maintenancePageLabel marks the ConfigMaps that hold the bodies of maintenance responses; see
+// maintenance.go.
+const maintenancePageLabel = "getambassador.io/maintenance-page"
+
 // thingToWatch is... uh... a thing we're gonna watch. Specifically, it's a
-// K8s type name and an optional field selector.
+// K8s type name and an optional field selector, plus an optional label selector
+// that narrows down the usual one.
 type thingToWatch struct {
 	typename      string
 	fieldselector string
+	labelselector string
 }
 
 type thingToMaybeWatch struct {
 	typename      string
 	fieldselector string
+	labelselector string
 	ignoreIf      bool
 }
 
@@ -44,6 +58,13 @@ func GetQueries(ctx context.Context, interestingTypes map[string]thingToWatch) [
 		if query.FieldSelector == "" {
 			query.FieldSelector = fs
 		}
+		if queryinfo.labelselector != "" {
+			if query.LabelSelector == "" {
+				query.LabelSelector = queryinfo.labelselector
+			} else {
+				query.LabelSelector += "," + queryinfo.labelselector
+			}
+		}
 
 		queries = append(queries, query)
 		dlog.Debugf(ctx, "WATCHER: watching %#v", query)
@@ -82,8 +103,18 @@ func GetInterestingTypes(ctx context.Context, serverTypeList []kates.APIResource
 		// over the ones we need into "Secrets" and "Endpoints" respectively.
 		"Services":   {{typename: "services.v1."}},                             // New in Kubernetes 0.16.0 (2015-04-28) (v1beta{1..3} before that)
 		"Endpoints":  {{typename: "endpoints.v1.", fieldselector: endpointFs}}, // New in Kubernetes 0.16.0 (2015-04-28) (v1beta{1..3} before that)
-		"K8sSecrets": {{typename: "secrets.v1."}},                              // New in Kubernetes 0.16.0 (2015-04-28) (v1beta{1..3} before that)
+		"K8sSecrets": {{typename: "secrets.v1.", ignoreIf: IsScopedSecrets()}}, // New in Kubernetes 0.16.0 (2015-04-28) (v1beta{1..3} before that)
 		"ConfigMaps": {{typename: "configmaps.v1.", fieldselector: configMapFs}},
+		// ConfigMaps of protobuf descriptors for gRPC-JSON transcoding, from any namespace.
+		"GRPCDescriptors": {{typename: "configmaps.v1.", labelselector: grpcDescriptorsLabel}},
+		// ConfigMaps of OpenAPI documents for Mappings, from any namespace.
+		"OpenAPIDocuments": {{typename: "configmaps.v1.", labelselector: openAPILabel}},
+		// ConfigMaps of maintenance response bodies, from any namespace.
+		"MaintenancePages": {{typename: "configmaps.v1.", labelselector: maintenancePageLabel}},
+		"EndpointSlices": {
+			{typename: "endpointslices.v1.discovery.k8s.io", fieldselector: endpointFs, ignoreIf: !IsZoneAwareRoutingEnabled() && !IsSubsetRoutingEnabled()}, // New in Kubernetes 1.21.0 (2021-04-08)
+		},
+		"SubsetPods": {{typename: "pods.v1.", fieldselector: endpointFs, ignoreIf: !IsSubsetRoutingEnabled()}}, // New in Kubernetes 0.16.0 (2015-04-28) (v1beta{1..3} before that)
 		"Ingresses": {
 			{typename: "ingresses.v1beta1.extensions"},        // New in Kubernetes 1.2.0 (2016-03-16), gone in Kubernetes 1.22.0 (2021-08-04)
 			{typename: "ingresses.v1beta1.networking.k8s.io"}, // New in Kubernetes 1.14.0 (2019-03-25), gone in Kubernetes 1.22.0 (2021-08-04)
@@ -115,6 +146,9 @@ func GetInterestingTypes(ctx context.Context, serverTypeList []kates.APIResource
 		"KNativeClusterIngresses": {{typename: "clusteringresses.v1alpha1.networking.internal.knative.dev", ignoreIf: !IsKnativeEnabled()}}, // New in Knative Serving 0.3.0 (2019-01-09)
 		"KNativeIngresses":        {{typename: "ingresses.v1alpha1.networking.internal.knative.dev", ignoreIf: !IsKnativeEnabled()}},        // New in Knative Serving 0.7.0 (2019-06-25)
 
+		// Istio types
+		"IstioDestinationRules": {{typename: "destinationrules.v1beta1.networking.istio.io", ignoreIf: !IsIstioEnabled()}}, // New in Istio 1.5.0 (2020-03-05)
+
 		// Unstructured from Edge Stack
 		"FilterPolicies": {{typename: "filterpolicies.v3alpha1.getambassador.io"}},
 		"Filters":        {{typename: "filters.v3alpha1.getambassador.io"}},
@@ -123,6 +157,7 @@ func GetInterestingTypes(ctx context.Context, serverTypeList []kates.APIResource
 		"AuthServices":                {{typename: "authservices.v3alpha1.getambassador.io"}},
 		"ConsulResolvers":             {{typename: "consulresolvers.v3alpha1.getambassador.io"}},
 		"DevPortals":                  {{typename: "devportals.v3alpha1.getambassador.io"}},
+		"EgressPolicies":              {{typename: "egresspolicies.v3alpha1.getambassador.io"}},
 		"Hosts":                       {{typename: "hosts.v3alpha1.getambassador.io"}},
 		"KubernetesEndpointResolvers": {{typename: "kubernetesendpointresolvers.v3alpha1.getambassador.io"}},
 		"KubernetesServiceResolvers":  {{typename: "kubernetesserviceresolvers.v3alpha1.getambassador.io"}},
@@ -130,10 +165,13 @@ func GetInterestingTypes(ctx context.Context, serverTypeList []kates.APIResource
 		"LogServices":                 {{typename: "logservices.v3alpha1.getambassador.io"}},
 		"Mappings":                    {{typename: "mappings.v3alpha1.getambassador.io"}},
 		"Modules":                     {{typename: "modules.v3alpha1.getambassador.io"}},
+		"PluginResolvers":             {{typename: "pluginresolvers.v3alpha1.getambassador.io"}},
 		"RateLimitServices":           {{typename: "ratelimitservices.v3alpha1.getambassador.io"}},
+		"Redirects":                   {{typename: "redirects.v3alpha1.getambassador.io"}},
 		"TCPMappings":                 {{typename: "tcpmappings.v3alpha1.getambassador.io"}},
 		"TLSContexts":                 {{typename: "tlscontexts.v3alpha1.getambassador.io"}},
 		"TracingServices":             {{typename: "tracingservices.v3alpha1.getambassador.io"}},
+		"UpstreamTLSPolicies":         {{typename: "upstreamtlspolicies.v3alpha1.getambassador.io"}},
 	}
 
 	var serverTypes map[string]kates.APIResource
@@ -151,7 +189,7 @@ func GetInterestingTypes(ctx context.Context, serverTypeList []kates.APIResource
 			if queryinfo.ignoreIf {
 				continue
 			}
-			last = thingToWatch{queryinfo.typename, queryinfo.fieldselector}
+			last = thingToWatch{queryinfo.typename, queryinfo.fieldselector, queryinfo.labelselector}
 			if _, haveType := serverTypes[queryinfo.typename]; haveType || serverTypes == nil {
 				ret[k] = last
 			}
@@ -0,0 +1,89 @@
+package entrypoint
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/ambex"
+	"github.com/emissary-ingress/emissary/v3/pkg/debug"
+)
+
+// introspectResult is what the introspection endpoint returns for a single service: the clusters
+// that route to it, and the Mappings that asked for those routes.
+type introspectResult struct {
+	Namespace string                 `json:"namespace"`
+	Service   string                 `json:"service"`
+	Clusters  []*ambex.ClusterSource `json:"clusters"`
+	Mappings  []string               `json:"mappings,omitempty"`
+}
+
+// handleIntrospect resolves generated Envoy names back to their sources, and vice versa:
+//
+//	/debug/introspect                      every cluster, with its service and routes
+//	/debug/introspect?cluster=<name>       the service and Mappings behind a cluster (or stat name)
+//	/debug/introspect?service=<ns>/<name>  the clusters and Mappings for a service
+func handleIntrospect(w http.ResponseWriter, r *http.Request) {
+	in, _ := debug.FromContext(r.Context()).Value(ambex.IntrospectionDebugValue).Load().(*ambex.Introspection)
+	if in == nil {
+		http.Error(w, "no configuration has been pushed to Envoy yet\n", http.StatusServiceUnavailable)
+		return
+	}
+
+	var result interface{}
+	query := r.URL.Query()
+	switch {
+	case query.Get("cluster") != "":
+		cs, ok := in.LookupCluster(query.Get("cluster"))
+		if !ok {
+			http.Error(w, "no such cluster\n", http.StatusNotFound)
+			return
+		}
+		if cs.ServiceKey() != "" {
+			result = introspectService(cs.Namespace, cs.Service, in.LookupService(cs.Namespace, cs.Service))
+		} else {
+			result = introspectService(cs.Namespace, cs.Service, []*ambex.ClusterSource{cs})
+		}
+	case query.Get("service") != "":
+		namespace, service, ok := strings.Cut(query.Get("service"), "/")
+		if !ok {
+			http.Error(w, "service must be <namespace>/<name>\n", http.StatusBadRequest)
+			return
+		}
+		result = introspectService(namespace, service, in.LookupService(namespace, service))
+	default:
+		result = in
+	}
+
+	bytes, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(append(bytes, '\n'))
+}
+
+// introspectService is the clusters that route to a service, and the Mappings they were
+// generated for.
+func introspectService(namespace, service string, clusters []*ambex.ClusterSource) *introspectResult {
+	seen := map[ambex.MappingRef]bool{}
+	var mappings []string
+	for _, cs := range clusters {
+		for _, m := range cs.Mappings {
+			if !seen[m] {
+				seen[m] = true
+				mappings = append(mappings, m.Namespace+"/"+m.Name)
+			}
+		}
+	}
+	sort.Strings(mappings)
+
+	return &introspectResult{
+		Namespace: namespace,
+		Service:   service,
+		Clusters:  clusters,
+		Mappings:  mappings,
+	}
+}
@@ -53,7 +53,7 @@ func (src *istioCertSource) Watch(ctx context.Context) (IstioCertWatcher, error)
 	if secretDir != "" {
 		// Yup, get to it. First, fire up the IstioCert, and tell it to
 		// post to our update channel from above.
-		icert := NewIstioCert(secretDir, "istio-certs", GetAmbassadorNamespace(), istioCertUpdateChannel)
+		icert := NewIstioCert(secretDir, istioCertsSecretName, GetAmbassadorNamespace(), istioCertUpdateChannel)
 
 		// Next up, fire up the FSWatcher...
 		fsw, err := NewFSWatcher(ctx)
@@ -83,6 +83,18 @@ func (src *istioCertSource) Watch(ctx context.Context) (IstioCertWatcher, error)
 		}
 	}
 
+	// The Istio agent's SDS socket is an alternative to the cert directory, and
+	// makes the same Secret. See istiosds.go.
+	if socket := GetIstioSDSSocket(); socket != "" {
+		go watchIstioSDS(ctx, socket, istioCertUpdateChannel)
+	}
+
+	// So does the fallback Host's certificate, if the bootstrap CA is minting it. See
+	// bootstrapca.go.
+	if b := bootstrapCAFromContext(ctx); b != nil {
+		go b.watch(ctx, istioCertUpdateChannel)
+	}
+
 	return &istioCertWatcher{
 		updateChannel: istioCertUpdateChannel,
 	}, nil
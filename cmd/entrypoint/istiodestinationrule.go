@@ -0,0 +1,182 @@
+package entrypoint
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
+	"github.com/emissary-ingress/emissary/v3/pkg/debug"
+	"github.com/emissary-ingress/emissary/v3/pkg/emissaryutil"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+)
+
+// When we're the ingress for an Istio mesh, the mesh's DestinationRules say how traffic to each
+// service is supposed to be secured, and the sidecars on the other end enforce it. We don't
+// apply DestinationRules -- we're not a sidecar -- but a Mapping that disagrees with one is
+// almost certainly a misconfiguration that will show up as opaque 503s, so we check for that
+// here and say so, both in the log and in the "istioDestinationRules" debug value.
+//
+// A Mapping is doing Istio mTLS if its `tls` names a TLSContext that uses the istio-certs Secret
+// (which is what an UpstreamTLSPolicy with `istio: true` generates).
+
+const istioDestinationRuleDebugValue = "istioDestinationRules"
+
+// IstioDestinationRuleMismatch is a Mapping that disagrees with the DestinationRule for its
+// service.
+type IstioDestinationRuleMismatch struct {
+	// DestinationRule is the "namespace/name" of the DestinationRule.
+	DestinationRule string `json:"destination_rule"`
+	// Mode is the DestinationRule's TLS mode.
+	Mode string `json:"mode"`
+	// Mapping is the "namespace/name" of the Mapping.
+	Mapping string `json:"mapping"`
+	// Problem says what's wrong.
+	Problem string `json:"problem"`
+}
+
+type istioDestinationRule struct {
+	name string
+	host string
+	mode string
+}
+
+// istioFQDN expands a service hostname, relative to namespace, the way Istio does.
+func istioFQDN(host, namespace string) string {
+	switch strings.Count(host, ".") {
+	case 0:
+		return host + "." + namespace + ".svc.cluster.local"
+	case 1:
+		return host + ".svc.cluster.local"
+	}
+	if strings.HasSuffix(host, ".svc") {
+		return host + ".cluster.local"
+	}
+	return host
+}
+
+// matches returns whether the DestinationRule applies to the (fully-qualified) host.
+func (dr *istioDestinationRule) matches(fqdn string) bool {
+	if dr.host == "*" {
+		return true
+	}
+	if strings.HasPrefix(dr.host, "*.") {
+		return strings.HasSuffix(fqdn, dr.host[1:])
+	}
+	return fqdn == dr.host
+}
+
+// parseIstioDestinationRule pulls out the parts of a DestinationRule we care about. We only look
+// at the top-level trafficPolicy: port-level and subset-level policies would need more context
+// than a Mapping gives us.
+func parseIstioDestinationRule(un *kates.Unstructured) (*istioDestinationRule, bool) {
+	spec, _ := un.Object["spec"].(map[string]interface{})
+	host, _ := spec["host"].(string)
+	policy, _ := spec["trafficPolicy"].(map[string]interface{})
+	tls, _ := policy["tls"].(map[string]interface{})
+	mode, _ := tls["mode"].(string)
+	if host == "" || mode == "" {
+		return nil, false
+	}
+	return &istioDestinationRule{
+		name: un.GetNamespace() + "/" + un.GetName(),
+		host: istioFQDN(host, un.GetNamespace()),
+		mode: mode,
+	}, true
+}
+
+// mappingUsesIstioMTLS returns whether the Mapping presents the istio-certs Secret upstream.
+func mappingUsesIstioMTLS(mapping *v3alpha1.Mapping, tlsContexts map[string]*v3alpha1.TLSContext) bool {
+	if mapping.Spec.TLS == "" {
+		return false
+	}
+	tc, ok := tlsContexts[mapping.Spec.TLS]
+	if !ok {
+		return false
+	}
+	secret := tc.Spec.Secret
+	return secret == istioCertsSecretName || strings.HasPrefix(secret, istioCertsSecretName+".")
+}
+
+// CheckIstioDestinationRules compares the Mappings in the snapshot against the Istio
+// DestinationRules for their services. It doesn't change anything; it has to run after
+// ReconcileUpstreamTLSPolicies, so that it sees the TLSContexts the Mappings will really use.
+func CheckIstioDestinationRules(ctx context.Context, sh *SnapshotHolder) []*IstioDestinationRuleMismatch {
+	var rules []*istioDestinationRule
+	for _, un := range sh.k8sSnapshot.IstioDestinationRules {
+		if dr, ok := parseIstioDestinationRule(un); ok {
+			rules = append(rules, dr)
+		}
+	}
+	// Istio prefers the most specific host, so check exact hosts before wildcards.
+	sort.SliceStable(rules, func(i, j int) bool {
+		wi, wj := strings.HasPrefix(rules[i].host, "*"), strings.HasPrefix(rules[j].host, "*")
+		if wi != wj {
+			return wj
+		}
+		return len(rules[i].host) > len(rules[j].host)
+	})
+
+	tlsContexts := make(map[string]*v3alpha1.TLSContext, len(sh.k8sSnapshot.TLSContexts))
+	for _, tc := range sh.k8sSnapshot.TLSContexts {
+		tlsContexts[tc.GetName()] = tc
+	}
+
+	mismatches := []*IstioDestinationRuleMismatch{}
+	if len(rules) > 0 {
+		envAmbID := GetAmbassadorID()
+		for _, mapping := range sh.k8sSnapshot.Mappings {
+			if !mapping.Spec.AmbassadorID.Matches(envAmbID) {
+				continue
+			}
+			_, hostname, _, err := emissaryutil.ParseServiceName(mapping.Spec.Service)
+			if err != nil {
+				continue
+			}
+			fqdn := istioFQDN(hostname, mapping.GetNamespace())
+
+			var rule *istioDestinationRule
+			for _, dr := range rules {
+				if dr.matches(fqdn) {
+					rule = dr
+					break
+				}
+			}
+			if rule == nil {
+				continue
+			}
+
+			var problem string
+			usesIstio := mappingUsesIstioMTLS(mapping, tlsContexts)
+			switch rule.mode {
+			case "ISTIO_MUTUAL":
+				if !usesIstio {
+					problem = fmt.Sprintf("%s requires Istio mTLS, but the Mapping does not use the %s Secret",
+						fqdn, istioCertsSecretName)
+				}
+			case "DISABLE":
+				if usesIstio {
+					problem = fmt.Sprintf("%s has TLS disabled, but the Mapping uses Istio mTLS", fqdn)
+				}
+			}
+			if problem == "" {
+				continue
+			}
+
+			mismatch := &IstioDestinationRuleMismatch{
+				DestinationRule: rule.name,
+				Mode:            rule.mode,
+				Mapping:         mapping.GetNamespace() + "/" + mapping.GetName(),
+				Problem:         problem,
+			}
+			mismatches = append(mismatches, mismatch)
+			dlog.Warnf(ctx, "Mapping %s disagrees with DestinationRule %s: %s",
+				mismatch.Mapping, mismatch.DestinationRule, mismatch.Problem)
+		}
+	}
+
+	debug.FromContext(ctx).Value(istioDestinationRuleDebugValue).Store(mismatches)
+	return mismatches
+}
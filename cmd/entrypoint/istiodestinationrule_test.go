@@ -0,0 +1,83 @@
+package entrypoint
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+func testDestinationRule(namespace, name, host, mode string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "networking.istio.io/v1beta1",
+			"kind":       "DestinationRule",
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": namespace,
+			},
+			"spec": map[string]interface{}{
+				"host": host,
+				"trafficPolicy": map[string]interface{}{
+					"tls": map[string]interface{}{
+						"mode": mode,
+					},
+				},
+			},
+		},
+	}
+}
+
+func testMapping(namespace, name, service, tls string) *v3alpha1.Mapping {
+	return &v3alpha1.Mapping{
+		ObjectMeta: kates.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: v3alpha1.MappingSpec{
+			Prefix:  "/" + name + "/",
+			Service: service,
+			TLS:     tls,
+		},
+	}
+}
+
+func TestCheckIstioDestinationRules(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+
+	sh := &SnapshotHolder{
+		k8sSnapshot: &snapshotTypes.KubernetesSnapshot{
+			IstioDestinationRules: []*unstructured.Unstructured{
+				testDestinationRule("foo", "mutual", "backend", "ISTIO_MUTUAL"),
+				testDestinationRule("bar", "plaintext", "*.bar.svc.cluster.local", "DISABLE"),
+				// More specific than the wildcard above, so it wins for legacy.bar.
+				testDestinationRule("bar", "legacy", "legacy.bar.svc.cluster.local", "ISTIO_MUTUAL"),
+			},
+			TLSContexts: []*v3alpha1.TLSContext{
+				{
+					ObjectMeta: kates.ObjectMeta{Name: "istio-upstream", Namespace: "foo"},
+					Spec:       v3alpha1.TLSContextSpec{Secret: "istio-certs.ambassador"},
+				},
+			},
+			Mappings: []*v3alpha1.Mapping{
+				testMapping("foo", "good", "backend", "istio-upstream"),
+				testMapping("foo", "no-mtls", "backend.foo:8080", ""),
+				testMapping("foo", "unrelated", "other.foo", ""),
+				testMapping("foo", "too-much-mtls", "web.bar", "istio-upstream"),
+				testMapping("bar", "legacy", "legacy", "istio-upstream"),
+			},
+		},
+	}
+
+	mismatches := CheckIstioDestinationRules(ctx, sh)
+	require.Len(t, mismatches, 2)
+	assert.Equal(t, "foo/no-mtls", mismatches[0].Mapping)
+	assert.Equal(t, "foo/mutual", mismatches[0].DestinationRule)
+	assert.Equal(t, "ISTIO_MUTUAL", mismatches[0].Mode)
+	assert.Equal(t, "foo/too-much-mtls", mismatches[1].Mapping)
+	assert.Equal(t, "bar/plaintext", mismatches[1].DestinationRule)
+	assert.Equal(t, "DISABLE", mismatches[1].Mode)
+}
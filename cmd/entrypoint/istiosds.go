@@ -0,0 +1,84 @@
+package entrypoint
+
+import (
+	"context"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/istio"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+)
+
+// If AMBASSADOR_ISTIO_SDS_SOCKET points at the Istio agent's SDS socket (normally
+// /var/run/secrets/workload-spiffe-uds/socket, with an Istio sidecar or the agent running
+// alongside Ambassador), we get Istio's certs straight from the agent instead of from files in
+// AMBASSADOR_ISTIO_SECRET_DIR. This produces the same "istio-certs" Secret as the file watcher
+// does, so TLSContexts that already use it don't have to change, plus an "istio-root-ca" Secret
+// holding the mesh root, so that we can verify upstreams too.
+
+const (
+	istioCertsSecretName  = "istio-certs"
+	istioRootCASecretName = "istio-root-ca"
+)
+
+// GetIstioSDSSocket returns the Istio agent's SDS socket, or "" if we shouldn't use it.
+func GetIstioSDSSocket() string {
+	return env("AMBASSADOR_ISTIO_SDS_SOCKET", "")
+}
+
+// IsIstioEnabled returns whether we've been told how to get Istio's certs, one way or another.
+func IsIstioEnabled() bool {
+	return env("AMBASSADOR_ISTIO_SECRET_DIR", "") != "" || GetIstioSDSSocket() != ""
+}
+
+// istioSDSSecrets turns the certs from the Istio agent into the Secrets described above.
+func istioSDSSecrets(certs *istio.WorkloadCerts, namespace string) []*kates.Secret {
+	secret := func(name string, data map[string][]byte) *kates.Secret {
+		return &kates.Secret{
+			TypeMeta: kates.TypeMeta{
+				APIVersion: "v1",
+				Kind:       "Secret",
+			},
+			ObjectMeta: kates.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+			},
+			Type: kates.SecretTypeTLS,
+			Data: data,
+		}
+	}
+
+	secrets := []*kates.Secret{
+		secret(istioCertsSecretName, map[string][]byte{
+			"tls.crt": certs.CertChainPEM,
+			"tls.key": certs.KeyPEM,
+		}),
+	}
+	if len(certs.RootCertPEM) > 0 {
+		secrets = append(secrets, secret(istioRootCASecretName, map[string][]byte{
+			"tls.crt": certs.RootCertPEM,
+		}))
+	}
+	return secrets
+}
+
+// watchIstioSDS streams certs from the Istio agent, and posts them to updates as Secrets until
+// ctx is canceled.
+func watchIstioSDS(ctx context.Context, socket string, updates chan<- IstioCertUpdate) {
+	namespace := GetAmbassadorNamespace()
+	nodeID := "ambassador~" + GetAmbassadorID() + "~" + namespace
+
+	err := istio.WatchSDS(ctx, socket, nodeID, func(ctx context.Context, certs *istio.WorkloadCerts) {
+		secrets := istioSDSSecrets(certs, namespace)
+		dlog.Infof(ctx, "ISTIO: received workload cert from SDS (root CA: %v)", len(secrets) > 1)
+		for _, secret := range secrets {
+			select {
+			case updates <- IstioCertUpdate{Op: "update", Name: secret.Name, Namespace: namespace, Secret: secret}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+	if err != nil {
+		dlog.Errorf(ctx, "ISTIO: %v", err)
+	}
+}
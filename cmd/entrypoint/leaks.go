@@ -0,0 +1,97 @@
+package entrypoint
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/debug"
+)
+
+// Leak detection: every so often we count goroutines (by the code that started them) and open
+// watches (by subsystem), and if they've grown at every one of the last few samples, we log what
+// grew, and by how much. The counts are on /debug, and in /metrics as ambassador_goroutines and
+// ambassador_open_watches. See debug.LeakDetector.
+
+// GetLeakCheckInterval returns how often to count goroutines, from
+// AMBASSADOR_LEAK_CHECK_INTERVAL_SECONDS. Zero disables the counting.
+func GetLeakCheckInterval() time.Duration {
+	secs, err := strconv.Atoi(env("AMBASSADOR_LEAK_CHECK_INTERVAL_SECONDS", "300"))
+	if err != nil || secs < 0 {
+		secs = 300
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// GetLeakCheckWindow returns how many samples in a row have to grow before we call it a leak,
+// from AMBASSADOR_LEAK_CHECK_WINDOW.
+func GetLeakCheckWindow() int {
+	window, err := strconv.Atoi(env("AMBASSADOR_LEAK_CHECK_WINDOW", strconv.Itoa(debug.DefaultLeakWindow)))
+	if err != nil || window < 1 {
+		window = debug.DefaultLeakWindow
+	}
+	return window
+}
+
+// checkLeaks samples goroutines and watches every interval until the context is done.
+func checkLeaks(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return nil
+	}
+	leaks := debug.FromContext(ctx).Leaks()
+	leaks.SetWindow(GetLeakCheckWindow())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if report := leaks.Sample(); report != nil {
+			dlog.Warnf(ctx, "LEAKS: goroutines or watches have grown at every check, %s", report)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// countedWatch is a Stopper whose watch is counted by the LeakDetector until it's stopped.
+type countedWatch struct {
+	Stopper
+	closeWatch func()
+}
+
+func (w countedWatch) Stop() {
+	w.Stopper.Stop()
+	w.closeWatch()
+}
+
+// leakMetrics renders the goroutine and watch counts in the Prometheus text format, to add to
+// what diagd serves on /metrics.
+func leakMetrics(leaks *debug.LeakDetector) []byte {
+	goroutines, watches := leaks.Subsystems()
+
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "# HELP ambassador_goroutines Goroutines as of the last leak check, by the subsystem that started them.")
+	fmt.Fprintln(&buf, "# TYPE ambassador_goroutines gauge")
+	writeSubsystemCounts(&buf, "ambassador_goroutines", goroutines)
+	fmt.Fprintln(&buf, "# HELP ambassador_open_watches Open watches, by subsystem.")
+	fmt.Fprintln(&buf, "# TYPE ambassador_open_watches gauge")
+	writeSubsystemCounts(&buf, "ambassador_open_watches", watches)
+	return buf.Bytes()
+}
+
+func writeSubsystemCounts(buf *bytes.Buffer, name string, counts map[string]int) {
+	subsystems := make([]string, 0, len(counts))
+	for subsystem := range counts {
+		subsystems = append(subsystems, subsystem)
+	}
+	sort.Strings(subsystems)
+	for _, subsystem := range subsystems {
+		fmt.Fprintf(buf, "%s{subsystem=%q} %d\n", name, subsystem, counts[subsystem])
+	}
+}
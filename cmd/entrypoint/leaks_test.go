@@ -0,0 +1,27 @@
+package entrypoint
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/debug"
+)
+
+func TestLeakMetrics(t *testing.T) {
+	leaks := debug.NewLeakDetector()
+	leaks.Record(map[string]int{"pkg/ambex.Main": 2, "cmd/entrypoint.watchConsul.func2": 3})
+	watch := countedWatch{Stopper: nopStopper{}, closeWatch: leaks.OpenWatch("consul")}
+
+	metrics := string(leakMetrics(leaks))
+	assert.Contains(t, metrics, "ambassador_goroutines{subsystem=\"cmd/entrypoint\"} 3\n"+
+		"ambassador_goroutines{subsystem=\"pkg/ambex\"} 2\n")
+	assert.Contains(t, metrics, "\nambassador_open_watches{subsystem=\"consul\"} 1\n")
+
+	watch.Stop()
+	assert.Contains(t, string(leakMetrics(leaks)), "\nambassador_open_watches{subsystem=\"consul\"} 0\n")
+}
+
+type nopStopper struct{}
+
+func (nopStopper) Stop() {}
@@ -0,0 +1,58 @@
+package entrypoint
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/ambex"
+	"github.com/emissary-ingress/emissary/v3/pkg/debug"
+)
+
+// loadListenerUpdates returns what ambex has counted of the updates it's handed Envoy, if it's
+// handed Envoy any. See pkg/ambex/listenerupdates.go.
+func loadListenerUpdates(dbg *debug.Debug) *ambex.ListenerUpdates {
+	updates, ok := dbg.Value(ambex.ListenerUpdatesDebugValue).Load().(ambex.ListenerUpdates)
+	if !ok {
+		return nil
+	}
+	return &updates
+}
+
+// listenerUpdateMetrics renders the listener update counts in the Prometheus text format, to add
+// to what diagd serves on /metrics.
+func listenerUpdateMetrics(updates *ambex.ListenerUpdates) []byte {
+	if updates == nil {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "# HELP ambassador_listener_updates_total Configuration updates handed to Envoy, by what they drained: nothing, some filter chains, or whole listeners.")
+	fmt.Fprintln(&buf, "# TYPE ambassador_listener_updates_total counter")
+	fmt.Fprintf(&buf, "ambassador_listener_updates_total{drain=\"none\"} %d\n", updates.Hitless)
+	fmt.Fprintf(&buf, "ambassador_listener_updates_total{drain=\"filter_chains\"} %d\n", updates.FilterChains)
+	fmt.Fprintf(&buf, "ambassador_listener_updates_total{drain=\"listener\"} %d\n", updates.Full)
+
+	names := make([]string, 0, len(updates.Listeners))
+	for name := range updates.Listeners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return buf.Bytes()
+	}
+
+	fmt.Fprintln(&buf, "# HELP ambassador_listener_drains_total Configuration updates that drained the listener, or some of its filter chains.")
+	fmt.Fprintln(&buf, "# TYPE ambassador_listener_drains_total counter")
+	for _, name := range names {
+		drains := updates.Listeners[name]
+		fmt.Fprintf(&buf, "ambassador_listener_drains_total{listener=%q,drain=\"filter_chains\"} %d\n", name, drains.FilterChains)
+		fmt.Fprintf(&buf, "ambassador_listener_drains_total{listener=%q,drain=\"listener\"} %d\n", name, drains.Full)
+	}
+	fmt.Fprintln(&buf, "# HELP ambassador_listener_filter_chains_drained_total Filter chains drained by updates that didn't drain the whole listener.")
+	fmt.Fprintln(&buf, "# TYPE ambassador_listener_filter_chains_drained_total counter")
+	for _, name := range names {
+		fmt.Fprintf(&buf, "ambassador_listener_filter_chains_drained_total{listener=%q} %d\n", name, updates.Listeners[name].ChainsDrained)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,32 @@
+package entrypoint
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/ambex"
+	"github.com/emissary-ingress/emissary/v3/pkg/debug"
+)
+
+func TestListenerUpdateMetrics(t *testing.T) {
+	dbg := debug.NewDebug()
+	assert.Nil(t, loadListenerUpdates(dbg))
+	assert.Nil(t, listenerUpdateMetrics(nil))
+
+	dbg.Value(ambex.ListenerUpdatesDebugValue).Store(ambex.ListenerUpdates{
+		Hitless:      7,
+		FilterChains: 2,
+		Full:         1,
+		Listeners: map[string]ambex.ListenerDrains{
+			"ambassador-listener-8443": {FilterChains: 2, ChainsDrained: 3},
+			"ambassador-listener-8080": {Full: 1},
+		},
+	})
+	metrics := string(listenerUpdateMetrics(loadListenerUpdates(dbg)))
+	assert.Contains(t, metrics, "\nambassador_listener_updates_total{drain=\"none\"} 7\n")
+	assert.Contains(t, metrics, "\nambassador_listener_updates_total{drain=\"filter_chains\"} 2\n")
+	assert.Contains(t, metrics, "\nambassador_listener_updates_total{drain=\"listener\"} 1\n")
+	assert.Contains(t, metrics, "\nambassador_listener_drains_total{listener=\"ambassador-listener-8080\",drain=\"listener\"} 1\n")
+	assert.Contains(t, metrics, "\nambassador_listener_filter_chains_drained_total{listener=\"ambassador-listener-8443\"} 3\n")
+}
@@ -0,0 +1,190 @@
+package entrypoint
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/datawire/dlib/dexec"
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/busy"
+	"github.com/emissary-ingress/emissary/v3/pkg/logsink"
+)
+
+// Log sinks: the control plane always logs to stdout, but some log pipelines can't scrape
+// container output, so it can send its logs (the entrypoint's own, and diagd's output) to a syslog
+// server, or to a file, as well:
+//
+//	AMBASSADOR_LOG_SYSLOG                      udp://host:port, tcp://host:port, or tls://host:port
+//	AMBASSADOR_LOG_SYSLOG_FACILITY             the syslog facility number (default 1, "user")
+//	AMBASSADOR_LOG_SYSLOG_CA_FILE              CA certificates to trust for tls:// (default: the system's)
+//	AMBASSADOR_LOG_SYSLOG_CERT_FILE, _KEY_FILE a client certificate for tls://
+//	AMBASSADOR_LOG_FILE                        a file to append to
+//	AMBASSADOR_LOG_FILE_MAX_MB                 how big the file gets before it's rotated (default 100)
+//	AMBASSADOR_LOG_FILE_MAX_BACKUPS            how many rotated files to keep (default 5)
+//
+// A sink that's set up wrong is logged and ignored: better to keep running with logs on stdout than
+// to stop.
+
+// GetLogSyslog returns the URL of the syslog server to send logs to, from AMBASSADOR_LOG_SYSLOG.
+func GetLogSyslog() string {
+	return env("AMBASSADOR_LOG_SYSLOG", "")
+}
+
+// GetLogFile returns the file to send logs to, from AMBASSADOR_LOG_FILE.
+func GetLogFile() string {
+	return env("AMBASSADOR_LOG_FILE", "")
+}
+
+// setupLogSinks starts the log sinks that the environment asks for, and sends everything logged
+// from now on to them.
+func setupLogSinks(ctx context.Context) []logsink.Sink {
+	var sinks []logsink.Sink
+	if addr := GetLogSyslog(); addr != "" {
+		sink, err := newSyslogSink(addr)
+		if err != nil {
+			dlog.Errorf(ctx, "Ignoring AMBASSADOR_LOG_SYSLOG: %v", err)
+		} else {
+			dlog.Infof(ctx, "Sending logs to syslog at %s", addr)
+			sinks = append(sinks, sink)
+		}
+	}
+	if path := GetLogFile(); path != "" {
+		sink, err := newFileSink(path)
+		if err != nil {
+			dlog.Errorf(ctx, "Ignoring AMBASSADOR_LOG_FILE: %v", err)
+		} else {
+			dlog.Infof(ctx, "Sending logs to %s", path)
+			sinks = append(sinks, sink)
+		}
+	}
+	for _, sink := range sinks {
+		busy.AddLogSink(sink)
+	}
+	return sinks
+}
+
+func newSyslogSink(addr string) (logsink.Sink, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	cfg := logsink.SyslogConfig{
+		Network: u.Scheme,
+		Address: u.Host,
+	}
+	if facility := env("AMBASSADOR_LOG_SYSLOG_FACILITY", ""); facility != "" {
+		if cfg.Facility, err = strconv.Atoi(facility); err != nil {
+			return nil, fmt.Errorf("bad AMBASSADOR_LOG_SYSLOG_FACILITY: %w", err)
+		}
+	}
+	if u.Scheme == "tls" {
+		if cfg.TLS, err = syslogTLSConfig(); err != nil {
+			return nil, err
+		}
+	}
+	return logsink.NewSyslog(cfg)
+}
+
+func syslogTLSConfig() (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile := env("AMBASSADOR_LOG_SYSLOG_CA_FILE", ""); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", caFile)
+		}
+	}
+	certFile, keyFile := env("AMBASSADOR_LOG_SYSLOG_CERT_FILE", ""), env("AMBASSADOR_LOG_SYSLOG_KEY_FILE", "")
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+func newFileSink(path string) (logsink.Sink, error) {
+	cfg := logsink.FileConfig{Path: path}
+	if mb := env("AMBASSADOR_LOG_FILE_MAX_MB", ""); mb != "" {
+		n, err := strconv.Atoi(mb)
+		if err != nil {
+			return nil, fmt.Errorf("bad AMBASSADOR_LOG_FILE_MAX_MB: %w", err)
+		}
+		cfg.MaxBytes = int64(n) * 1024 * 1024
+	}
+	if backups := env("AMBASSADOR_LOG_FILE_MAX_BACKUPS", ""); backups != "" {
+		n, err := strconv.Atoi(backups)
+		if err != nil {
+			return nil, fmt.Errorf("bad AMBASSADOR_LOG_FILE_MAX_BACKUPS: %w", err)
+		}
+		cfg.MaxBackups = n
+	}
+	return logsink.NewFile(cfg)
+}
+
+// closeLogSinks delivers whatever the sinks still have queued.
+func closeLogSinks(ctx context.Context, sinks []logsink.Sink) {
+	for _, sink := range sinks {
+		if dropped := sink.Dropped(); dropped > 0 {
+			dlog.Warnf(ctx, "A log sink dropped %d entries", dropped)
+		}
+	}
+	for _, sink := range sinks {
+		_ = sink.Close()
+	}
+}
+
+// teeToLogSinks arranges for what cmd writes to its stdout and stderr to go to the log sinks as
+// well, as from app. It uses pipes, rather than plain io.Writers, because dexec logs everything
+// written to a writer that isn't a file, which would send it all to the sinks twice. The returned
+// function closes our ends of the pipes, once cmd has exited.
+func teeToLogSinks(cmd *dexec.Cmd, app string, sinks []logsink.Sink) (func(), error) {
+	var pipes []*os.File
+	closePipes := func() {
+		for _, pipe := range pipes {
+			pipe.Close()
+		}
+	}
+	if len(sinks) == 0 {
+		return closePipes, nil
+	}
+
+	tee := func(w io.Writer) (*os.File, error) {
+		r, pw, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		pipes = append(pipes, pw)
+		dst := logsink.LineWriter(app, logsink.Informational, sinks...)
+		if w != nil {
+			dst = io.MultiWriter(w, dst)
+		}
+		go func() {
+			defer r.Close()
+			_, _ = io.Copy(dst, r)
+		}()
+		return pw, nil
+	}
+	stdout, err := tee(cmd.Stdout)
+	if err != nil {
+		return closePipes, err
+	}
+	stderr, err := tee(cmd.Stderr)
+	if err != nil {
+		closePipes()
+		return func() {}, err
+	}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	return closePipes, nil
+}
@@ -0,0 +1,278 @@
+package entrypoint
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+// Maintenance: a Mapping or Host with `maintenance.enabled` set answers its requests with a static
+// response (a status code, a Retry-After, and a body from a ConfigMap labeled
+// getambassador.io/maintenance-page) instead of routing them upstream, so planned downtime of a
+// backend doesn't mean editing its Mappings out and back in. diagd compiles the response (see
+// python/ambassador/ir/irmaintenance.py); what's here are the two ways of flipping the switch
+// without touching the spec:
+//
+//   - The getambassador.io/maintenance annotation, set to "true" or "false", overrides the spec's
+//     `enabled`.
+//
+//   - The admin API overrides both:
+//
+//     GET    /ambassador/v0/maintenance    what's in maintenance, and what put it there
+//     POST   /ambassador/v0/maintenance?kind=<Mapping|Host>&namespace=<namespace>&name=<name>&enabled=<true|false>
+//     DELETE /ambassador/v0/maintenance?kind=<Mapping|Host>&namespace=<namespace>&name=<name>
+//
+//     DELETE forgets the admin API's switch, leaving it to the annotation and the spec again.
+//     Switches made through the admin API are only remembered until Ambassador restarts; the
+//     annotation is what lasts.
+//
+// Flipping the switch keeps the rest of what `maintenance` says; a resource that doesn't say
+// anything else gets an empty 503.
+const maintenanceAnnotation = "getambassador.io/maintenance"
+
+// Where the switch for a resource was last flipped.
+const (
+	maintenanceFromSpec       = "spec"
+	maintenanceFromAnnotation = "annotation"
+	maintenanceFromAdmin      = "admin"
+)
+
+type maintenanceKey struct {
+	kind      string
+	namespace string
+	name      string
+}
+
+// maintenanceState is whether a resource is in maintenance, and what says so.
+type maintenanceState struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Enabled   bool   `json:"enabled"`
+	Source    string `json:"source"`
+}
+
+// The maintenanceSwitches struct holds the switches flipped through the admin API, and applies
+// them, and the annotations, to the snapshot. Like the devOverrides, the watcher's use of it is
+// nil-safe.
+type maintenanceSwitches struct {
+	// The changed method returns this channel. set and clear write to it (without blocking).
+	coalescedDirty chan struct{}
+
+	// The mutex protects everything below.
+	mutex    sync.Mutex
+	switches map[maintenanceKey]bool
+	// states has every resource that's in maintenance, or that something other than its spec
+	// has flipped, as of the last apply.
+	states []maintenanceState
+
+	// The Maintenance fields that apply last changed, and what they had before. Only the
+	// watcher loop touches this, under the SnapshotHolder's mutex.
+	applied map[**v3alpha1.Maintenance]*v3alpha1.Maintenance
+}
+
+func newMaintenanceSwitches() *maintenanceSwitches {
+	return &maintenanceSwitches{
+		coalescedDirty: make(chan struct{}, 1),
+		switches:       map[maintenanceKey]bool{},
+	}
+}
+
+type maintenanceSwitchesKey struct{}
+
+// withMaintenanceSwitches returns a copy of ctx that carries the maintenance switches.
+func withMaintenanceSwitches(ctx context.Context, ms *maintenanceSwitches) context.Context {
+	return context.WithValue(ctx, maintenanceSwitchesKey{}, ms)
+}
+
+// maintenanceSwitchesFromContext returns the maintenance switches, or nil if there aren't any.
+func maintenanceSwitchesFromContext(ctx context.Context) *maintenanceSwitches {
+	ms, _ := ctx.Value(maintenanceSwitchesKey{}).(*maintenanceSwitches)
+	return ms
+}
+
+// changed returns a channel that gets a value whenever a switch has been flipped. It's nil if
+// there are no maintenanceSwitches, so it never does.
+func (ms *maintenanceSwitches) changed() <-chan struct{} {
+	if ms == nil {
+		return nil
+	}
+	return ms.coalescedDirty
+}
+
+func (ms *maintenanceSwitches) dirty() {
+	select {
+	case ms.coalescedDirty <- struct{}{}:
+	default:
+	}
+}
+
+// set flips the switch for a resource.
+func (ms *maintenanceSwitches) set(key maintenanceKey, enabled bool) {
+	ms.mutex.Lock()
+	ms.switches[key] = enabled
+	ms.mutex.Unlock()
+	ms.dirty()
+}
+
+// clear forgets the switch for a resource.
+func (ms *maintenanceSwitches) clear(key maintenanceKey) {
+	ms.mutex.Lock()
+	delete(ms.switches, key)
+	ms.mutex.Unlock()
+	ms.dirty()
+}
+
+// States returns what the last apply found.
+func (ms *maintenanceSwitches) States() []maintenanceState {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	return append([]maintenanceState{}, ms.states...)
+}
+
+// apply turns maintenance on or off for the Mappings and Hosts in the snapshot (including the ones
+// in annotations) whose annotation or admin API switch says to, and puts back the ones that it
+// flipped last time but shouldn't any more.
+func (ms *maintenanceSwitches) apply(ctx context.Context, k8sSnapshot *snapshotTypes.KubernetesSnapshot) {
+	if ms == nil {
+		return
+	}
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	for field, orig := range ms.applied {
+		*field = orig
+	}
+	applied := make(map[**v3alpha1.Maintenance]*v3alpha1.Maintenance)
+	var states []maintenanceState
+
+	flip := func(kind string, obj kates.Object, field **v3alpha1.Maintenance) {
+		key := maintenanceKey{kind: kind, namespace: obj.GetNamespace(), name: obj.GetName()}
+		spec := *field != nil && (*field).Enabled
+		enabled, source := spec, maintenanceFromSpec
+
+		if value, ok := obj.GetAnnotations()[maintenanceAnnotation]; ok {
+			if b, err := strconv.ParseBool(value); err == nil {
+				enabled, source = b, maintenanceFromAnnotation
+			} else {
+				dlog.Warnf(ctx, "Maintenance: %s %s/%s: %s annotation %q isn't true or false; ignoring it",
+					kind, key.namespace, key.name, maintenanceAnnotation, value)
+			}
+		}
+		if b, ok := ms.switches[key]; ok {
+			enabled, source = b, maintenanceFromAdmin
+		}
+
+		if enabled || source != maintenanceFromSpec {
+			states = append(states, maintenanceState{
+				Kind:      kind,
+				Namespace: key.namespace,
+				Name:      key.name,
+				Enabled:   enabled,
+				Source:    source,
+			})
+		}
+		if enabled == spec {
+			return
+		}
+		applied[field] = *field
+		maintenance := &v3alpha1.Maintenance{}
+		if *field != nil {
+			maintenance = (*field).DeepCopy()
+		}
+		maintenance.Enabled = enabled
+		*field = maintenance
+	}
+
+	for _, mapping := range k8sSnapshot.Mappings {
+		flip("Mapping", mapping, &mapping.Spec.Maintenance)
+	}
+	for _, host := range k8sSnapshot.Hosts {
+		if host.Spec != nil {
+			flip("Host", host, &host.Spec.Maintenance)
+		}
+	}
+	for _, list := range k8sSnapshot.Annotations {
+		for _, obj := range list {
+			switch obj := obj.(type) {
+			case *v3alpha1.Mapping:
+				flip("Mapping", obj, &obj.Spec.Maintenance)
+			case *v3alpha1.Host:
+				if obj.Spec != nil {
+					flip("Host", obj, &obj.Spec.Maintenance)
+				}
+			}
+		}
+	}
+	ms.applied = applied
+
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].Kind != states[j].Kind {
+			return states[i].Kind < states[j].Kind
+		}
+		if states[i].Namespace != states[j].Namespace {
+			return states[i].Namespace < states[j].Namespace
+		}
+		return states[i].Name < states[j].Name
+	})
+	ms.states = states
+}
+
+// handleMaintenance lists, flips, and forgets maintenance switches; see the top of this file.
+// Flipping a switch answers 202 with the switch, since the change goes out with the next snapshot.
+func handleMaintenance(w http.ResponseWriter, r *http.Request, ms *maintenanceSwitches) {
+	switch r.Method {
+	case http.MethodGet:
+		writeMaintenanceJSON(w, http.StatusOK, ms.States())
+	case http.MethodPost, http.MethodDelete:
+		query := r.URL.Query()
+		key := maintenanceKey{kind: query.Get("kind"), namespace: query.Get("namespace"), name: query.Get("name")}
+		if (key.kind != "Mapping" && key.kind != "Host") || key.namespace == "" || key.name == "" {
+			http.Error(w, "maintenance switches need a kind (Mapping or Host), namespace, and name\n", http.StatusBadRequest)
+			return
+		}
+		state := maintenanceState{Kind: key.kind, Namespace: key.namespace, Name: key.name}
+		if r.Method == http.MethodPost {
+			enabled, err := strconv.ParseBool(query.Get("enabled"))
+			if err != nil {
+				http.Error(w, "say enabled=true or enabled=false\n", http.StatusBadRequest)
+				return
+			}
+			ms.set(key, enabled)
+			state.Enabled, state.Source = enabled, maintenanceFromAdmin
+			onOff := "off"
+			if enabled {
+				onOff = "on"
+			}
+			dlog.Infof(r.Context(), "Maintenance: %s turned maintenance %s for %s %s/%s",
+				requester(r), onOff, key.kind, key.namespace, key.name)
+		} else {
+			ms.clear(key)
+			dlog.Infof(r.Context(), "Maintenance: %s cleared the maintenance switch for %s %s/%s",
+				requester(r), key.kind, key.namespace, key.name)
+		}
+		writeMaintenanceJSON(w, http.StatusAccepted, state)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed\n", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeMaintenanceJSON(w http.ResponseWriter, code int, v interface{}) {
+	bytes, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(append(bytes, '\n'))
+}
@@ -0,0 +1,86 @@
+package entrypoint
+
+import (
+	"testing"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/stretchr/testify/assert"
+
+	amb "github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+func TestMaintenanceSwitches(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	intPtr := func(i int) *int { return &i }
+
+	planned := &amb.Mapping{
+		ObjectMeta: kates.ObjectMeta{Name: "planned", Namespace: "default"},
+		Spec: amb.MappingSpec{Prefix: "/planned/", Maintenance: &amb.Maintenance{
+			Enabled:    true,
+			StatusCode: intPtr(503),
+		}},
+	}
+	annotated := &amb.Mapping{
+		ObjectMeta: kates.ObjectMeta{Name: "annotated", Namespace: "default", Annotations: map[string]string{
+			maintenanceAnnotation: "true",
+		}},
+		Spec: amb.MappingSpec{Prefix: "/annotated/", Maintenance: &amb.Maintenance{RetryAfter: intPtr(60)}},
+	}
+	plain := &amb.Mapping{
+		ObjectMeta: kates.ObjectMeta{Name: "plain", Namespace: "default"},
+		Spec:       amb.MappingSpec{Prefix: "/plain/"},
+	}
+	host := &amb.Host{
+		ObjectMeta: kates.ObjectMeta{Name: "www", Namespace: "default"},
+		Spec:       &amb.HostSpec{Hostname: "www.example.com"},
+	}
+	snap := &snapshotTypes.KubernetesSnapshot{
+		Mappings: []*amb.Mapping{planned, annotated, plain},
+		Hosts:    []*amb.Host{host},
+	}
+
+	ms := newMaintenanceSwitches()
+	ms.apply(ctx, snap)
+
+	// The annotation turns maintenance on, and keeps the rest of what the spec says.
+	assert.True(t, planned.Spec.Maintenance.Enabled)
+	assert.Equal(t, &amb.Maintenance{Enabled: true, RetryAfter: intPtr(60)}, annotated.Spec.Maintenance)
+	assert.Nil(t, plain.Spec.Maintenance)
+	assert.Equal(t, []maintenanceState{
+		{Kind: "Mapping", Namespace: "default", Name: "annotated", Enabled: true, Source: maintenanceFromAnnotation},
+		{Kind: "Mapping", Namespace: "default", Name: "planned", Enabled: true, Source: maintenanceFromSpec},
+	}, ms.States())
+
+	// The admin API beats the spec and the annotation both.
+	ms.set(maintenanceKey{kind: "Mapping", namespace: "default", name: "planned"}, false)
+	ms.set(maintenanceKey{kind: "Mapping", namespace: "default", name: "annotated"}, false)
+	ms.set(maintenanceKey{kind: "Host", namespace: "default", name: "www"}, true)
+	select {
+	case <-ms.changed():
+	default:
+		t.Fatal("flipping a switch didn't say so")
+	}
+	ms.apply(ctx, snap)
+
+	assert.False(t, planned.Spec.Maintenance.Enabled)
+	assert.Equal(t, intPtr(503), planned.Spec.Maintenance.StatusCode)
+	assert.False(t, annotated.Spec.Maintenance.Enabled)
+	assert.Equal(t, &amb.Maintenance{Enabled: true}, host.Spec.Maintenance)
+
+	// Forgetting the switches puts everything back the way the resources say.
+	ms.clear(maintenanceKey{kind: "Mapping", namespace: "default", name: "planned"})
+	ms.clear(maintenanceKey{kind: "Mapping", namespace: "default", name: "annotated"})
+	ms.clear(maintenanceKey{kind: "Host", namespace: "default", name: "www"})
+	ms.apply(ctx, snap)
+
+	assert.True(t, planned.Spec.Maintenance.Enabled)
+	assert.True(t, annotated.Spec.Maintenance.Enabled)
+	assert.Nil(t, host.Spec.Maintenance)
+
+	// A nil maintenanceSwitches does nothing.
+	var none *maintenanceSwitches
+	none.apply(ctx, snap)
+	assert.Nil(t, none.changed())
+}
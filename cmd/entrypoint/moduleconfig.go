@@ -0,0 +1,39 @@
+package entrypoint
+
+import (
+	amb "github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
+	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+// moduleConfigErrors returns the problems with the config of every Module, annotations included,
+// that belongs to this Ambassador, by name.namespace. Modules with nothing wrong are left out.
+//
+// A Module with a bad field still gets used (the rest of its config is likely fine, and dropping
+// all of it over a typo would be worse), so these go to diagd alongside the snapshot rather than
+// into the Invalid list.
+func moduleConfigErrors(s *snapshotTypes.KubernetesSnapshot) map[string][]string {
+	envAmbID := GetAmbassadorID()
+
+	modules := append([]*amb.Module(nil), s.Modules...)
+	for _, list := range s.Annotations {
+		for _, a := range list {
+			if m, ok := a.(*amb.Module); ok {
+				modules = append(modules, m)
+			}
+		}
+	}
+
+	var errs map[string][]string
+	for _, m := range modules {
+		if !m.Spec.AmbassadorID.Matches(envAmbID) {
+			continue
+		}
+		if merrs := m.ConfigErrors(); len(merrs) > 0 {
+			if errs == nil {
+				errs = make(map[string][]string)
+			}
+			errs[m.GetName()+"."+m.GetNamespace()] = merrs
+		}
+	}
+	return errs
+}
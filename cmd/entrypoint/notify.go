@@ -45,6 +45,10 @@ func notifyReconfigWebhooksFunc(ctx context.Context, ambwatch notable, edgestack
 	// won't return, by design, until the snapshot has been processed, so first note
 	// that we're sending the snapshot...
 	ambwatch.NoteSnapshotSent()
+	startup := startupPlanFromContext(ctx)
+	startup.done(startupFirstSnapshot)
+	gate := diagdGateFromContext(ctx)
+	gate.startCompile()
 
 	for {
 		// ...then send it and wait for the webhook to return...
@@ -58,6 +62,8 @@ func notifyReconfigWebhooksFunc(ctx context.Context, ambwatch notable, edgestack
 			// was successful: it's just about whether or not diagd is making progress instead
 			// of getting stuck.
 			ambwatch.NoteSnapshotProcessed()
+			startup.done(startupDiagd)
+			gate.finishCompile()
 		}
 
 		// Then go deal with the Edge Stack sidecar.
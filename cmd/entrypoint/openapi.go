@@ -0,0 +1,451 @@
+package entrypoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	amb "github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+	"github.com/emissary-ingress/emissary/v3/pkg/debug"
+	"github.com/emissary-ingress/emissary/v3/pkg/emissaryutil"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	"github.com/emissary-ingress/emissary/v3/pkg/openapi"
+	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+// OpenAPI aggregation: every AMBASSADOR_OPENAPI_INTERVAL_SECONDS (default 60), the aggregator
+// fetches the OpenAPI document of each Mapping that has `openapi` set, either from the Mapping's
+// service, from some other URL, or from a ConfigMap labeled getambassador.io/openapi. It rewrites
+// each document's paths to the ones clients use through the Mapping, and merges the documents of
+// all the Mappings that each Host serves into one. The healthcheck server has them at
+//
+//	/ambassador/v0/openapi/                          which Hosts there are, and what went wrong
+//	/ambassador/v0/openapi/{namespace}/{name}/openapi.json
+//	/ambassador/v0/openapi/{namespace}/{name}/docs   a page listing the Host's operations
+//
+// and a Mapping to the healthcheck server can make them public. If a document can't be fetched,
+// the last one that could be is used. Setting AMBASSADOR_OPENAPI_INTERVAL_SECONDS to 0 turns this
+// off.
+
+// openAPIDebugValue is the name of the debug value holding the []*OpenAPIHost from the most recent
+// round of aggregation.
+const openAPIDebugValue = "openAPI"
+
+// openAPIFetchTimeout is the most that fetching one document can take.
+const openAPIFetchTimeout = 10 * time.Second
+
+// openAPIMaxSize is the biggest document the aggregator will take.
+const openAPIMaxSize = 4 << 20
+
+// GetOpenAPIInterval returns how often to aggregate OpenAPI documents, from
+// AMBASSADOR_OPENAPI_INTERVAL_SECONDS. Zero turns the aggregator off.
+func GetOpenAPIInterval() time.Duration {
+	secs, err := strconv.Atoi(env("AMBASSADOR_OPENAPI_INTERVAL_SECONDS", "60"))
+	if err != nil || secs < 0 {
+		secs = 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// OpenAPIHost is the aggregated API of one Host.
+type OpenAPIHost struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Hostname  string `json:"hostname"`
+	// Mappings are the Mappings whose documents went into the Host's, as name.namespace.
+	Mappings []string  `json:"mappings"`
+	Warnings []string  `json:"warnings,omitempty"`
+	Updated  time.Time `json:"updated"`
+
+	document openapi.Document
+}
+
+type openAPIAggregator struct {
+	clock  clock.Clock
+	client *http.Client
+
+	// lastGood is the last document fetched for each Mapping, by name.namespace, for when
+	// fetching it again doesn't work.
+	lastGood map[string]openapi.Document
+}
+
+func newOpenAPIAggregator(clk clock.Clock, client *http.Client) *openAPIAggregator {
+	return &openAPIAggregator{
+		clock:    clk,
+		client:   client,
+		lastGood: map[string]openapi.Document{},
+	}
+}
+
+// runOpenAPIAggregator aggregates the documents of the Mappings in the newest snapshot every
+// interval until the context is done.
+func runOpenAPIAggregator(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return nil
+	}
+	clk := clock.FromContext(ctx)
+	aggregator := newOpenAPIAggregator(clk, &http.Client{Timeout: openAPIFetchTimeout})
+
+	snapshots := followSnapshots(ctx, "openapi_aggregator")
+	defer snapshots.Close()
+
+	ticker := clk.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case ev, ok := <-snapshots.Events():
+			snapshots.note(ev, ok)
+			continue
+		case <-ticker.C():
+		case <-ctx.Done():
+			return nil
+		}
+
+		snap := snapshots.latest()
+		if snap == nil || snap.Kubernetes == nil {
+			continue
+		}
+		debug.FromContext(ctx).Value(openAPIDebugValue).Store(aggregator.round(ctx, snap.Kubernetes))
+	}
+}
+
+// round fetches the documents of every Mapping with `openapi` set, and aggregates them for each
+// Host, sorted by namespace and name.
+func (a *openAPIAggregator) round(ctx context.Context, k8sSnapshot *snapshotTypes.KubernetesSnapshot) []*OpenAPIHost {
+	type result struct {
+		key      string
+		mapping  *amb.Mapping
+		document openapi.Document
+		warnings []string
+	}
+	var results []*result
+	var wg sync.WaitGroup
+	for _, mapping := range k8sSnapshot.Mappings {
+		if mapping.Spec.OpenAPI == nil {
+			continue
+		}
+		r := &result{key: mapping.GetName() + "." + mapping.GetNamespace(), mapping: mapping}
+		results = append(results, r)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			doc, err := a.fetch(ctx, r.mapping, k8sSnapshot.OpenAPIDocuments)
+			if err != nil {
+				r.warnings = append(r.warnings, fmt.Sprintf("Mapping %s: %v", r.key, err))
+				return
+			}
+			r.document = doc
+		}()
+	}
+	wg.Wait()
+
+	lastGood := make(map[string]openapi.Document, len(results))
+	var sources []openapi.Source
+	for _, r := range results {
+		doc := r.document
+		if doc == nil {
+			if doc = a.lastGood[r.key]; doc != nil {
+				r.warnings = append(r.warnings, fmt.Sprintf("Mapping %s: using the last document that could be fetched", r.key))
+			}
+		}
+		if doc == nil {
+			continue
+		}
+		lastGood[r.key] = doc
+		if r.mapping.Spec.PrefixRegex != nil && *r.mapping.Spec.PrefixRegex {
+			r.warnings = append(r.warnings, fmt.Sprintf("Mapping %s: can't rewrite paths for a prefix_regex Mapping", r.key))
+			continue
+		}
+		rewritten, warnings := openapi.Rewrite(doc, openapi.Route{
+			Prefix:  r.mapping.Spec.Prefix,
+			Rewrite: r.mapping.Spec.Rewrite,
+		})
+		for _, warning := range warnings {
+			r.warnings = append(r.warnings, fmt.Sprintf("Mapping %s: %s", r.key, warning))
+		}
+		sources = append(sources, openapi.Source{Name: r.key, Document: rewritten})
+	}
+	a.lastGood = lastGood
+
+	now := a.clock.Now()
+	hosts := make([]*OpenAPIHost, 0, len(k8sSnapshot.Hosts))
+	for _, host := range k8sSnapshot.Hosts {
+		entry := &OpenAPIHost{
+			Namespace: host.GetNamespace(),
+			Name:      host.GetName(),
+			Hostname:  "*",
+			Mappings:  []string{},
+			Updated:   now,
+		}
+		var tlsSecret string
+		if host.Spec != nil {
+			if host.Spec.Hostname != "" {
+				entry.Hostname = host.Spec.Hostname
+			}
+			if host.Spec.TLSSecret != nil {
+				tlsSecret = host.Spec.TLSSecret.Name
+			}
+		}
+
+		var hostSources []openapi.Source
+		for _, r := range results {
+			if !openAPIHostMatchesMapping(host, r.mapping) {
+				continue
+			}
+			entry.Warnings = append(entry.Warnings, r.warnings...)
+			for _, source := range sources {
+				if source.Name == r.key {
+					hostSources = append(hostSources, source)
+					entry.Mappings = append(entry.Mappings, r.key)
+				}
+			}
+		}
+
+		var servers []string
+		if !strings.Contains(entry.Hostname, "*") {
+			if tlsSecret != "" {
+				servers = append(servers, "https://"+entry.Hostname)
+			} else {
+				servers = append(servers, "http://"+entry.Hostname)
+			}
+		}
+		var warnings []string
+		entry.document, warnings = openapi.Aggregate(entry.Hostname, servers, hostSources)
+		entry.Warnings = append(entry.Warnings, warnings...)
+		sort.Strings(entry.Mappings)
+		hosts = append(hosts, entry)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		if hosts[i].Namespace != hosts[j].Namespace {
+			return hosts[i].Namespace < hosts[j].Namespace
+		}
+		return hosts[i].Name < hosts[j].Name
+	})
+	return hosts
+}
+
+// fetch gets a Mapping's document from wherever its `openapi` says.
+func (a *openAPIAggregator) fetch(ctx context.Context, mapping *amb.Mapping, configMaps []*kates.ConfigMap) (openapi.Document, error) {
+	spec := mapping.Spec.OpenAPI
+	var data []byte
+	switch {
+	case spec.ConfigMap != "":
+		var err error
+		if data, err = openAPIFromConfigMap(mapping.GetNamespace(), spec, configMaps); err != nil {
+			return nil, err
+		}
+	case spec.URL != "":
+		url, err := openAPIURL(mapping, spec.URL)
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/json, application/yaml;q=0.9, */*;q=0.1")
+		req.Header.Set("User-Agent", "ambassador-openapi-aggregator")
+		resp, err := a.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetching %s: got status %d", url, resp.StatusCode)
+		}
+		if data, err = io.ReadAll(io.LimitReader(resp.Body, openAPIMaxSize+1)); err != nil {
+			return nil, fmt.Errorf("fetching %s: %w", url, err)
+		}
+		if len(data) > openAPIMaxSize {
+			return nil, fmt.Errorf("fetching %s: the document is bigger than %d bytes", url, openAPIMaxSize)
+		}
+	default:
+		return nil, fmt.Errorf("openapi has neither url nor config_map")
+	}
+	return openapi.Parse(data)
+}
+
+// openAPIURL returns the URL to fetch a Mapping's document from: rawURL if it's absolute, or
+// rawURL on the Mapping's service if it's a path.
+func openAPIURL(mapping *amb.Mapping, rawURL string) (string, error) {
+	if !strings.HasPrefix(rawURL, "/") {
+		if !strings.HasPrefix(rawURL, "http://") && !strings.HasPrefix(rawURL, "https://") {
+			return "", fmt.Errorf("url %q is neither a path nor an http or https URL", rawURL)
+		}
+		return rawURL, nil
+	}
+	scheme, hostname, port, err := emissaryutil.ParseServiceName(mapping.Spec.Service)
+	if err != nil {
+		return "", err
+	}
+	if scheme == "" {
+		scheme = "http"
+	}
+	// A bare Service name is in the Mapping's namespace.
+	if net.ParseIP(hostname) == nil && !strings.Contains(hostname, ".") {
+		hostname += "." + mapping.GetNamespace()
+	}
+	host := hostname
+	if port != 0 {
+		host = net.JoinHostPort(hostname, strconv.Itoa(int(port)))
+	} else if strings.Contains(hostname, ":") {
+		host = "[" + hostname + "]"
+	}
+	return scheme + "://" + host + rawURL, nil
+}
+
+// openAPIFromConfigMap finds a Mapping's document among the ConfigMaps labeled
+// getambassador.io/openapi.
+func openAPIFromConfigMap(namespace string, spec *amb.MappingOpenAPI, configMaps []*kates.ConfigMap) ([]byte, error) {
+	name := spec.ConfigMap
+	if parts := strings.SplitN(name, ".", 2); len(parts) == 2 {
+		name, namespace = parts[0], parts[1]
+	}
+	for _, cm := range configMaps {
+		if cm.GetName() != name || cm.GetNamespace() != namespace {
+			continue
+		}
+		key := spec.Key
+		if key == "" {
+			if len(cm.Data)+len(cm.BinaryData) != 1 {
+				return nil, fmt.Errorf("ConfigMap %s.%s has more than one key, and openapi doesn't say which", name, namespace)
+			}
+			for k := range cm.Data {
+				key = k
+			}
+			for k := range cm.BinaryData {
+				key = k
+			}
+		}
+		if data, ok := cm.Data[key]; ok {
+			return []byte(data), nil
+		}
+		if data, ok := cm.BinaryData[key]; ok {
+			return data, nil
+		}
+		return nil, fmt.Errorf("ConfigMap %s.%s has no key %q", name, namespace, key)
+	}
+	return nil, fmt.Errorf("no ConfigMap %s.%s labeled %s", name, namespace, openAPILabel)
+}
+
+// openAPIHostMatchesMapping mimics `python/ambassador/ir/irhost.py:IRHost.matches_httpgroup()`,
+// saying whether a Host serves a Mapping.
+func openAPIHostMatchesMapping(host *amb.Host, mapping *amb.Mapping) bool {
+	hostHostname := "*"
+	var selector *kates.LabelSelector
+	if host.Spec != nil {
+		if host.Spec.Hostname != "" {
+			hostHostname = host.Spec.Hostname
+		}
+		selector = host.Spec.MappingSelector
+		if selector == nil {
+			selector = host.Spec.DeprecatedSelector
+		}
+	}
+
+	hasHostname, hostMatch := false, false
+	if mapping.Spec.DeprecatedHostRegex != nil && *mapping.Spec.DeprecatedHostRegex {
+		hasHostname, hostMatch = true, true
+	} else {
+		glob := mapping.Spec.Hostname
+		if glob == "" {
+			glob = mapping.Spec.DeprecatedHost
+		}
+		if glob != "" {
+			hasHostname = true
+			hostMatch = emissaryutil.HostGlobMatches(hostHostname, glob)
+		}
+	}
+
+	// Strict selectors need every label to match; the old behavior needs any one of them to.
+	strict := !envbool("DISABLE_STRICT_LABEL_SELECTORS")
+	hasSelector := selector != nil && len(selector.MatchLabels) > 0
+	selMatch := false
+	if hasSelector {
+		labels := mapping.GetLabels()
+		matched := 0
+		for k, v := range selector.MatchLabels {
+			if labels[k] == v {
+				matched++
+			}
+		}
+		selMatch = matched == len(selector.MatchLabels) || (!strict && matched > 0)
+	}
+
+	switch {
+	case !strict:
+		return hostMatch || selMatch
+	case hasSelector && hasHostname:
+		return hostMatch && selMatch
+	case hasSelector:
+		return selMatch
+	default:
+		return hostMatch
+	}
+}
+
+// loadOpenAPIHosts returns the most recent round of aggregation, if there's been one.
+func loadOpenAPIHosts(dbg *debug.Debug) []*OpenAPIHost {
+	hosts, _ := dbg.Value(openAPIDebugValue).Load().([]*OpenAPIHost)
+	return hosts
+}
+
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	hosts := loadOpenAPIHosts(debug.FromContext(r.Context()))
+	rest := strings.TrimPrefix(r.URL.Path, "/ambassador/v0/openapi/")
+
+	if rest == "" {
+		if hosts == nil {
+			hosts = []*OpenAPIHost{}
+		}
+		writeOpenAPIJSON(w, hosts)
+		return
+	}
+
+	parts := strings.Split(rest, "/")
+	if len(parts) != 3 {
+		http.NotFound(w, r)
+		return
+	}
+	var host *OpenAPIHost
+	for _, h := range hosts {
+		if h.Namespace == parts[0] && h.Name == parts[1] {
+			host = h
+		}
+	}
+	if host == nil {
+		http.NotFound(w, r)
+		return
+	}
+	switch parts[2] {
+	case "openapi.json":
+		writeOpenAPIJSON(w, host.document)
+	case "docs":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := openapi.WriteHTML(w, host.document, "openapi.json"); err != nil {
+			dlog.Errorf(r.Context(), "OpenAPI docs for Host %s.%s: %v", host.Name, host.Namespace, err)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeOpenAPIJSON(w http.ResponseWriter, v interface{}) {
+	bytes, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(append(bytes, '\n'))
+}
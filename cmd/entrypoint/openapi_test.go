@@ -0,0 +1,142 @@
+package entrypoint
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	amb "github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+	"github.com/emissary-ingress/emissary/v3/pkg/debug"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+const quoteOpenAPI = `{
+  "openapi": "3.0.3",
+  "info": {"title": "quote", "version": "1"},
+  "servers": [{"url": "http://quote/v1"}],
+  "paths": {"/quotes": {"get": {"summary": "List quotes"}}}
+}`
+
+const usersOpenAPI = `
+openapi: 3.0.3
+info: {title: users, version: "1"}
+paths:
+  /users:
+    get: {summary: List users}
+`
+
+func TestOpenAPIAggregator(t *testing.T) {
+	up := true
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up || r.URL.Path != "/openapi.json" {
+			http.Error(w, "no", http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(quoteOpenAPI))
+	}))
+	defer upstream.Close()
+
+	rewrite := "/v1/"
+	mapping := func(name, hostname string, openAPI *amb.MappingOpenAPI) *amb.Mapping {
+		m := &amb.Mapping{ObjectMeta: kates.ObjectMeta{Namespace: "default", Name: name}}
+		m.Spec.Prefix = "/" + name + "/"
+		m.Spec.Hostname = hostname
+		m.Spec.Service = name
+		m.Spec.OpenAPI = openAPI
+		return m
+	}
+	k8sSnapshot := &snapshotTypes.KubernetesSnapshot{
+		Hosts: []*amb.Host{
+			{ObjectMeta: kates.ObjectMeta{Namespace: "default", Name: "api"}, Spec: &amb.HostSpec{Hostname: "api.example.com"}},
+			{ObjectMeta: kates.ObjectMeta{Namespace: "default", Name: "other"}, Spec: &amb.HostSpec{Hostname: "other.example.com"}},
+		},
+		Mappings: []*amb.Mapping{
+			mapping("quote", "*.example.com", &amb.MappingOpenAPI{URL: upstream.URL + "/openapi.json"}),
+			mapping("users", "api.example.com", &amb.MappingOpenAPI{ConfigMap: "docs"}),
+			mapping("broken", "api.example.com", &amb.MappingOpenAPI{ConfigMap: "missing.elsewhere"}),
+			mapping("plain", "*", nil),
+		},
+		OpenAPIDocuments: []*kates.ConfigMap{{
+			ObjectMeta: kates.ObjectMeta{Namespace: "default", Name: "docs"},
+			Data:       map[string]string{"users.yaml": usersOpenAPI},
+		}},
+	}
+	k8sSnapshot.Mappings[0].Spec.Rewrite = &rewrite
+
+	aggregator := newOpenAPIAggregator(clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)), upstream.Client())
+	hosts := aggregator.round(context.Background(), k8sSnapshot)
+	require.Len(t, hosts, 2)
+
+	api := hosts[0]
+	assert.Equal(t, "api", api.Name)
+	assert.Equal(t, []string{"quote.default", "users.default"}, api.Mappings)
+	assert.Equal(t, []string{"Mapping broken.default: no ConfigMap missing.elsewhere labeled getambassador.io/openapi"}, api.Warnings)
+	paths := api.document["paths"].(map[string]interface{})
+	assert.Contains(t, paths, "/quote/quotes")
+	assert.Contains(t, paths, "/users/users")
+	assert.Equal(t, []interface{}{map[string]interface{}{"url": "http://api.example.com"}}, api.document["servers"])
+
+	other := hosts[1]
+	assert.Equal(t, []string{"quote.default"}, other.Mappings)
+	assert.Empty(t, other.Warnings)
+
+	// When the upstream stops serving its document, the last one it served is used.
+	up = false
+	hosts = aggregator.round(context.Background(), k8sSnapshot)
+	assert.Equal(t, []string{"quote.default"}, hosts[1].Mappings)
+	assert.Len(t, hosts[1].Warnings, 2)
+
+	ctx := debug.NewContext(context.Background(), debug.NewDebug())
+	debug.FromContext(ctx).Value(openAPIDebugValue).Store(hosts)
+
+	w := httptest.NewRecorder()
+	handleOpenAPI(w, httptest.NewRequest(http.MethodGet, "/ambassador/v0/openapi/", nil).WithContext(ctx))
+	require.Equal(t, http.StatusOK, w.Code)
+	var index []*OpenAPIHost
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &index))
+	assert.Len(t, index, 2)
+
+	w = httptest.NewRecorder()
+	handleOpenAPI(w, httptest.NewRequest(http.MethodGet, "/ambassador/v0/openapi/default/api/openapi.json", nil).WithContext(ctx))
+	require.Equal(t, http.StatusOK, w.Code)
+	var doc map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+	assert.Equal(t, "api.example.com", doc["info"].(map[string]interface{})["title"])
+
+	w = httptest.NewRecorder()
+	handleOpenAPI(w, httptest.NewRequest(http.MethodGet, "/ambassador/v0/openapi/default/api/docs", nil).WithContext(ctx))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "List users")
+
+	w = httptest.NewRecorder()
+	handleOpenAPI(w, httptest.NewRequest(http.MethodGet, "/ambassador/v0/openapi/default/nope/docs", nil).WithContext(ctx))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestOpenAPIURL(t *testing.T) {
+	m := &amb.Mapping{ObjectMeta: kates.ObjectMeta{Namespace: "ns", Name: "m"}}
+	for service, want := range map[string]string{
+		"quote":               "http://quote.ns/openapi.json",
+		"quote.other:8080":    "http://quote.other:8080/openapi.json",
+		"https://quote.other": "https://quote.other/openapi.json",
+		"10.0.0.1:9000":       "http://10.0.0.1:9000/openapi.json",
+	} {
+		m.Spec.Service = service
+		got, err := openAPIURL(m, "/openapi.json")
+		assert.NoError(t, err, service)
+		assert.Equal(t, want, got, service)
+	}
+	got, err := openAPIURL(m, "https://docs.example.com/quote.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://docs.example.com/quote.yaml", got)
+	_, err = openAPIURL(m, "ftp://docs.example.com/quote.yaml")
+	assert.Error(t, err)
+}
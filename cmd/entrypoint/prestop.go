@@ -0,0 +1,137 @@
+package entrypoint
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/datawire/dlib/dcontext"
+	"github.com/datawire/dlib/dlog"
+)
+
+// preStop coordination: when Kubernetes deletes a pod, it runs the preStop hook, and only sends
+// SIGTERM once the hook returns, all within terminationGracePeriodSeconds. Meanwhile the endpoints
+// controller takes the pod out of its Services, at its own pace. So a preStop hook that POSTs to
+// /shutdown-ready gets the shutdown plan's readiness and envoy phases going early: it fails the
+// readiness check, gives the endpoints controller the readiness phase's deadline to notice, then
+// drains Envoy, and doesn't return until the drain is done, or the grace period (?deadline=,
+// 30s by default) is nearly up. Nothing is stopped; that's still up to SIGTERM, and the plan then
+// doesn't wait for what the hook already waited for.
+//
+// The hook has to return a little before the grace period is up, so that there's time for the
+// rest of the shutdown plan: AMBASSADOR_PRESTOP_MARGIN_SECONDS says how long before.
+//
+// There's no going back from a drain, and 8877 can be reached from anywhere in the cluster, so
+// /shutdown-ready only listens to the pod itself: the hook is an exec that POSTs to localhost, not
+// an httpGet, which kubelet sends from outside the pod.
+
+// defaultPreStopDeadline is Kubernetes' default terminationGracePeriodSeconds.
+const defaultPreStopDeadline = 30 * time.Second
+
+// GetPreStopMargin returns how long before its deadline the preStop hook returns, from
+// AMBASSADOR_PRESTOP_MARGIN_SECONDS.
+func GetPreStopMargin() time.Duration {
+	secs, err := strconv.Atoi(env("AMBASSADOR_PRESTOP_MARGIN_SECONDS", "5"))
+	if err != nil || secs < 0 {
+		secs = 5
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// PreStop fails the readiness check, waits out the readiness phase's deadline, and drains Envoy,
+// without stopping anything. It returns whether the drain is done, once it is, or once wait is up,
+// or ctx is done. Only the first call starts anything; later ones wait for the same drain.
+func (p *shutdownPlan) PreStop(ctx context.Context, wait time.Duration) bool {
+	p.mu.Lock()
+	if p.preStopDone == nil {
+		p.unready = true
+		p.preStopped = p.clock.Now()
+		p.preStopDone = make(chan struct{})
+		// The drain carries on even if whoever asked for it stops waiting.
+		go p.runPreStop(dcontext.WithoutCancel(ctx), p.preStopDone)
+	}
+	done := p.preStopDone
+	p.mu.Unlock()
+
+	timer := p.clock.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C():
+	case <-ctx.Done():
+	}
+	return false
+}
+
+func (p *shutdownPlan) runPreStop(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	readiness := p.phase(shutdownReadiness).deadline
+	dlog.Infof(ctx, "preStop: failing readiness for %s, then draining Envoy", readiness)
+	timer := p.clock.NewTimer(readiness)
+	<-timer.C()
+
+	start := p.clock.Now()
+	if err := p.drain(ctx); err != nil {
+		dlog.Errorf(ctx, "preStop: %v", err)
+	}
+	dlog.Infof(ctx, "preStop: drain finished in %s", p.clock.Now().Sub(start))
+}
+
+// handleShutdownReady is the preStop hook's endpoint. It answers once Envoy is drained, or when
+// it's nearly time for the pod to be killed anyway.
+func handleShutdownReady(w http.ResponseWriter, r *http.Request, p *shutdownPlan) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "use POST\n", http.StatusMethodNotAllowed)
+		return
+	}
+	if !fromPod(r) {
+		http.Error(w, "only the pod's own preStop hook may call this\n", http.StatusForbidden)
+		return
+	}
+	if p == nil {
+		http.Error(w, "no shutdown plan", http.StatusNotFound)
+		return
+	}
+
+	deadline := defaultPreStopDeadline
+	if s := r.URL.Query().Get("deadline"); s != "" {
+		var err error
+		deadline, err = time.ParseDuration(s)
+		if err != nil || deadline < 0 {
+			http.Error(w, "deadline must be a duration, like 30s\n", http.StatusBadRequest)
+			return
+		}
+	}
+	wait := deadline - GetPreStopMargin()
+	if wait < 0 {
+		wait = 0
+	}
+
+	start := p.clock.Now()
+	drained := p.PreStop(r.Context(), wait)
+	took := p.clock.Now().Sub(start)
+
+	w.Header().Set("Content-Type", "text/plain")
+	if drained {
+		_, _ = fmt.Fprintf(w, "Envoy drained after %s\n", took)
+	} else {
+		_, _ = fmt.Fprintf(w, "Envoy not drained yet after %s; giving up with %s to go\n", took, deadline-took)
+	}
+}
+
+// fromPod returns whether r came from inside the pod: over loopback, and not forwarded by Envoy,
+// which adds X-Forwarded-For to whatever it proxies.
+func fromPod(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback() && r.Header.Get("X-Forwarded-For") == ""
+}
@@ -0,0 +1,225 @@
+package entrypoint
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/datawire/dlib/dexec"
+	"github.com/datawire/dlib/dlog"
+)
+
+// Privileged ports: a Listener on port 80 or 443 needs Envoy to be allowed to bind ports below
+// net.ipv4.ip_unprivileged_port_start (1024 by default). Run as root, or with
+// CAP_NET_BIND_SERVICE as an ambient capability, Envoy can do that by itself. Otherwise it needs
+// the capabilities wrapper (cmd/capabilities_wrapper), which has CAP_NET_BIND_SERVICE as a file
+// capability, and passes it on to Envoy, as long as the pod hasn't dropped it from the bounding
+// set. That lets a pod with host networking serve on 80 and 443 directly, without the 8080/8443
+// and Service port remapping.
+//
+// At startup we work out which of those applies, run Envoy with the wrapper if it has to be, and
+// tell diagd the lowest port that Envoy can bind in AMBASSADOR_UNPRIVILEGED_PORT_START (0 for all
+// of them), so that a Listener that Envoy couldn't bind is an error on the Listener, rather than
+// an Envoy that rejects its config. AMBASSADOR_ENVOY_CAPABILITIES can be "wrapper" to always use
+// the wrapper, or "direct" to never use it, instead of "auto".
+//
+// (Envoy can't be handed sockets that are already bound, the way socket activation works, so
+// binding them for it isn't an option.)
+
+// capNetBindService is CAP_NET_BIND_SERVICE's bit in the capability sets in /proc/self/status.
+const capNetBindService = 10
+
+// How Envoy gets to bind privileged ports.
+const (
+	envoyCapabilitiesAuto    = "auto"
+	envoyCapabilitiesWrapper = "wrapper"
+	envoyCapabilitiesDirect  = "direct"
+)
+
+// The command for the capabilities wrapper.
+const envoyCapabilitiesWrapperCommand = "wrapper"
+
+// GetEnvoyCapabilities returns how Envoy gets to bind privileged ports, from
+// AMBASSADOR_ENVOY_CAPABILITIES.
+func GetEnvoyCapabilities() string {
+	return strings.ToLower(env("AMBASSADOR_ENVOY_CAPABILITIES", envoyCapabilitiesAuto))
+}
+
+// processCapabilities are the capability sets of a process that matter for binding ports.
+type processCapabilities struct {
+	Bounding uint64
+	Ambient  uint64
+}
+
+// hasNetBindService returns whether a capability set has CAP_NET_BIND_SERVICE.
+func hasNetBindService(set uint64) bool {
+	return set&(1<<capNetBindService) != 0
+}
+
+// privilegedPortsPlan is how Envoy is run, and what it can bind.
+type privilegedPortsPlan struct {
+	// Envoy is the command that runs Envoy: "envoy", or the capabilities wrapper.
+	Envoy string
+	// UnprivilegedPortStart is the lowest port that Envoy can bind; 0 if it can bind any.
+	UnprivilegedPortStart int
+	// Reason says why.
+	Reason string
+
+	Errors []error
+}
+
+// planPrivilegedPorts works out a privilegedPortsPlan for a process with the given effective
+// user ID and capabilities, on a machine whose ip_unprivileged_port_start is portStart.
+func planPrivilegedPorts(mode string, portStart, euid int, caps processCapabilities, haveWrapper bool) privilegedPortsPlan {
+	plan := privilegedPortsPlan{Envoy: "envoy", UnprivilegedPortStart: portStart}
+
+	switch mode {
+	case envoyCapabilitiesAuto, envoyCapabilitiesWrapper, envoyCapabilitiesDirect:
+	default:
+		plan.Errors = append(plan.Errors, fmt.Errorf("AMBASSADOR_ENVOY_CAPABILITIES=%q isn't auto, wrapper, or direct", mode))
+		mode = envoyCapabilitiesAuto
+	}
+
+	direct := ""
+	switch {
+	case portStart == 0:
+		direct = "net.ipv4.ip_unprivileged_port_start is 0"
+	case euid == 0 && hasNetBindService(caps.Bounding):
+		direct = "running as root"
+	case hasNetBindService(caps.Ambient):
+		direct = "CAP_NET_BIND_SERVICE is an ambient capability"
+	}
+
+	wrapper := ""
+	switch {
+	case !haveWrapper:
+		wrapper = "there's no capabilities wrapper"
+	case !hasNetBindService(caps.Bounding):
+		wrapper = "CAP_NET_BIND_SERVICE isn't in the bounding set"
+	}
+
+	if mode == envoyCapabilitiesWrapper {
+		if wrapper == "" {
+			plan.Envoy, plan.UnprivilegedPortStart = envoyCapabilitiesWrapperCommand, 0
+			plan.Reason = "AMBASSADOR_ENVOY_CAPABILITIES=wrapper"
+			return plan
+		}
+		plan.Errors = append(plan.Errors, fmt.Errorf("AMBASSADOR_ENVOY_CAPABILITIES=wrapper, but %s", wrapper))
+	}
+
+	switch {
+	case direct != "":
+		plan.UnprivilegedPortStart = 0
+		plan.Reason = direct
+	case mode == envoyCapabilitiesDirect:
+		plan.Reason = "AMBASSADOR_ENVOY_CAPABILITIES=direct"
+	case wrapper == "":
+		plan.Envoy, plan.UnprivilegedPortStart = envoyCapabilitiesWrapperCommand, 0
+		plan.Reason = "the capabilities wrapper gives Envoy CAP_NET_BIND_SERVICE"
+	default:
+		plan.Reason = wrapper
+	}
+	return plan
+}
+
+// readUnprivilegedPortStart reads net.ipv4.ip_unprivileged_port_start, which is 1024 on kernels
+// too old to have it.
+func readUnprivilegedPortStart(path string) (int, error) {
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 1024, nil
+	}
+	if err != nil {
+		return 1024, err
+	}
+	start, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+	if err != nil {
+		return 1024, fmt.Errorf("%s: %w", path, err)
+	}
+	return start, nil
+}
+
+// readProcessCapabilities reads the CapBnd and CapAmb lines of a /proc/<pid>/status.
+func readProcessCapabilities(path string) (processCapabilities, error) {
+	var caps processCapabilities
+
+	f, err := os.Open(path)
+	if err != nil {
+		return caps, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		var into *uint64
+		switch name {
+		case "CapBnd":
+			into = &caps.Bounding
+		case "CapAmb":
+			into = &caps.Ambient
+		default:
+			continue
+		}
+		if *into, err = strconv.ParseUint(strings.TrimSpace(value), 16, 64); err != nil {
+			return caps, fmt.Errorf("%s: %s: %w", path, name, err)
+		}
+	}
+	return caps, scanner.Err()
+}
+
+var (
+	privilegedPortsPlanOnce sync.Once
+	thePrivilegedPortsPlan  privilegedPortsPlan
+)
+
+// getPrivilegedPortsPlan works out the privilegedPortsPlan for this container the first time it's
+// needed.
+func getPrivilegedPortsPlan() *privilegedPortsPlan {
+	privilegedPortsPlanOnce.Do(func() {
+		var errs []error
+		portStart, err := readUnprivilegedPortStart("/proc/sys/net/ipv4/ip_unprivileged_port_start")
+		if err != nil {
+			errs = append(errs, fmt.Errorf("reading ip_unprivileged_port_start: %w", err))
+		}
+		caps, err := readProcessCapabilities("/proc/self/status")
+		if err != nil {
+			errs = append(errs, fmt.Errorf("reading capabilities: %w", err))
+		}
+		_, err = dexec.LookPath(envoyCapabilitiesWrapperCommand)
+		// The wrapper only runs the image's Envoy (see envoyprobe.go).
+		binary := GetEnvoyBinary()
+		haveWrapper := err == nil && binary == "envoy"
+
+		thePrivilegedPortsPlan = planPrivilegedPorts(GetEnvoyCapabilities(), portStart, os.Geteuid(), caps, haveWrapper)
+		thePrivilegedPortsPlan.Errors = append(errs, thePrivilegedPortsPlan.Errors...)
+		if thePrivilegedPortsPlan.Envoy == "envoy" {
+			thePrivilegedPortsPlan.Envoy = binary
+		}
+	})
+	return &thePrivilegedPortsPlan
+}
+
+// alignPrivilegedPorts logs the plan, and anything wrong with it, and tells diagd what Envoy can
+// bind. runEnvoy picks up the command from the plan.
+func alignPrivilegedPorts(ctx context.Context) {
+	plan := getPrivilegedPortsPlan()
+	for _, err := range plan.Errors {
+		dlog.Errorf(ctx, "Privileged ports: %v", err)
+	}
+
+	if plan.UnprivilegedPortStart == 0 {
+		dlog.Infof(ctx, "Privileged ports: Envoy can bind any port (%s)", plan.Reason)
+	} else {
+		dlog.Infof(ctx, "Privileged ports: Envoy can't bind ports below %d (%s)", plan.UnprivilegedPortStart, plan.Reason)
+	}
+	os.Setenv("AMBASSADOR_UNPRIVILEGED_PORT_START", strconv.Itoa(plan.UnprivilegedPortStart))
+}
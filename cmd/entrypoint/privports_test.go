@@ -0,0 +1,81 @@
+package entrypoint
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanPrivilegedPorts(t *testing.T) {
+	bnd := processCapabilities{Bounding: 1 << capNetBindService}
+	amb := processCapabilities{Bounding: 1 << capNetBindService, Ambient: 1 << capNetBindService}
+	none := processCapabilities{}
+
+	// A non-root Envoy with the wrapper around gets run by the wrapper.
+	plan := planPrivilegedPorts("auto", 1024, 8888, bnd, true)
+	assert.Equal(t, "wrapper", plan.Envoy)
+	assert.Equal(t, 0, plan.UnprivilegedPortStart)
+	assert.Empty(t, plan.Errors)
+
+	// Root, an ambient capability, or a kernel that lets anyone bind anything don't need it.
+	for _, plan := range []privilegedPortsPlan{
+		planPrivilegedPorts("auto", 1024, 0, bnd, true),
+		planPrivilegedPorts("auto", 1024, 8888, amb, true),
+		planPrivilegedPorts("auto", 0, 8888, none, false),
+	} {
+		assert.Equal(t, "envoy", plan.Envoy)
+		assert.Equal(t, 0, plan.UnprivilegedPortStart)
+		assert.Empty(t, plan.Errors)
+	}
+
+	// Without the capability, or the wrapper, Envoy is stuck above the start.
+	plan = planPrivilegedPorts("auto", 1024, 8888, none, true)
+	assert.Equal(t, privilegedPortsPlan{Envoy: "envoy", UnprivilegedPortStart: 1024, Reason: "CAP_NET_BIND_SERVICE isn't in the bounding set"}, plan)
+	plan = planPrivilegedPorts("auto", 1024, 0, bnd, false)
+	assert.Equal(t, "envoy", plan.Envoy)
+	assert.Equal(t, 0, plan.UnprivilegedPortStart)
+	plan = planPrivilegedPorts("auto", 1024, 8888, bnd, false)
+	assert.Equal(t, privilegedPortsPlan{Envoy: "envoy", UnprivilegedPortStart: 1024, Reason: "there's no capabilities wrapper"}, plan)
+
+	// direct never uses the wrapper; wrapper always does, if it can.
+	plan = planPrivilegedPorts("direct", 1024, 8888, bnd, true)
+	assert.Equal(t, privilegedPortsPlan{Envoy: "envoy", UnprivilegedPortStart: 1024, Reason: "AMBASSADOR_ENVOY_CAPABILITIES=direct"}, plan)
+	plan = planPrivilegedPorts("wrapper", 1024, 0, bnd, true)
+	assert.Equal(t, "wrapper", plan.Envoy)
+	assert.Equal(t, 0, plan.UnprivilegedPortStart)
+	plan = planPrivilegedPorts("wrapper", 1024, 8888, none, true)
+	assert.Equal(t, "envoy", plan.Envoy)
+	assert.Equal(t, 1024, plan.UnprivilegedPortStart)
+	assert.Equal(t, []error{errors.New("AMBASSADOR_ENVOY_CAPABILITIES=wrapper, but CAP_NET_BIND_SERVICE isn't in the bounding set")}, plan.Errors)
+
+	// Nonsense gets flagged, and treated as auto.
+	plan = planPrivilegedPorts("sometimes", 1024, 8888, bnd, true)
+	assert.Equal(t, "wrapper", plan.Envoy)
+	assert.Equal(t, []error{errors.New(`AMBASSADOR_ENVOY_CAPABILITIES="sometimes" isn't auto, wrapper, or direct`)}, plan.Errors)
+}
+
+func TestReadPrivilegedPorts(t *testing.T) {
+	dir := t.TempDir()
+
+	start, err := readUnprivilegedPortStart(filepath.Join(dir, "nonesuch"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1024, start)
+
+	file := filepath.Join(dir, "ip_unprivileged_port_start")
+	require.NoError(t, os.WriteFile(file, []byte("80\n"), 0o644))
+	start, err = readUnprivilegedPortStart(file)
+	assert.NoError(t, err)
+	assert.Equal(t, 80, start)
+
+	status := filepath.Join(dir, "status")
+	require.NoError(t, os.WriteFile(status, []byte("Name:\tenvoy\nCapInh:\t0000000000000000\nCapBnd:\t00000000a80425fb\nCapAmb:\t0000000000000400\n"), 0o644))
+	caps, err := readProcessCapabilities(status)
+	assert.NoError(t, err)
+	assert.Equal(t, processCapabilities{Bounding: 0xa80425fb, Ambient: 0x400}, caps)
+	assert.True(t, hasNetBindService(caps.Bounding))
+	assert.True(t, hasNetBindService(caps.Ambient))
+}
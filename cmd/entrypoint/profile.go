@@ -0,0 +1,196 @@
+package entrypoint
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/pprof"
+	"sync"
+	"time"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+	"github.com/emissary-ingress/emissary/v3/pkg/envoyprofile"
+)
+
+// Profile bundles: a POST to /ambassador/v0/profile profiles Envoy and this process side by side,
+// and answers with a .tar.gz of everything collected, so that a performance investigation gets
+// both halves of the pod from the same moment in one step.
+//
+// Envoy's CPU profiler and a CPU profile of this process run together for ?duration= (30s by
+// default, and at most envoyprofile.MaxCPUDuration). Heap profiles of both, and this process's
+// goroutines, are taken once that's done. A profile that can't be collected doesn't fail the
+// bundle; manifest.json says what went wrong with it.
+
+// defaultProfileDuration is how long the CPU profiles run for if the request doesn't say.
+const defaultProfileDuration = 30 * time.Second
+
+// profileEntry is one profile in a bundle.
+type profileEntry struct {
+	// Name is the profile's file in the bundle. It's missing if there's no profile.
+	Name   string `json:"name,omitempty"`
+	Source string `json:"source"`
+	Kind   string `json:"kind"`
+	Error  string `json:"error,omitempty"`
+
+	data []byte
+}
+
+// profileManifest is manifest.json in a bundle.
+type profileManifest struct {
+	Started  time.Time       `json:"started"`
+	Finished time.Time       `json:"finished"`
+	Duration string          `json:"duration"`
+	Profiles []*profileEntry `json:"profiles"`
+}
+
+// profileBundler collects profile bundles, one at a time.
+type profileBundler struct {
+	clock  clock.Clock
+	envoy  *envoyprofile.Profiler
+	active sync.Mutex
+}
+
+func newProfileBundler(clk clock.Clock, envoy *envoyprofile.Profiler) *profileBundler {
+	return &profileBundler{clock: clk, envoy: envoy}
+}
+
+// collect profiles Envoy and this process for d, or until ctx is canceled.
+func (b *profileBundler) collect(ctx context.Context, d time.Duration) *profileManifest {
+	ctx = clock.WithClock(ctx, b.clock)
+	manifest := &profileManifest{Started: b.clock.Now(), Duration: d.String()}
+
+	var envoyCPU, ownCPU *profileEntry
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		data, err := b.envoy.CPU(ctx, d)
+		envoyCPU = newProfileEntry("envoy/cpu.prof", "envoy", "cpu", data, err)
+	}()
+	data, err := cpuProfile(ctx, d)
+	ownCPU = newProfileEntry("entrypoint/cpu.pprof", "entrypoint", "cpu", data, err)
+	wg.Wait()
+
+	data, err = b.envoy.Heap(ctx)
+	envoyHeap := newProfileEntry("envoy/heap.prof", "envoy", "heap", data, err)
+
+	manifest.Profiles = []*profileEntry{
+		envoyCPU,
+		envoyHeap,
+		ownCPU,
+		lookupProfile("entrypoint/heap.pprof", "heap"),
+		lookupProfile("entrypoint/goroutine.pprof", "goroutine"),
+	}
+	manifest.Finished = b.clock.Now()
+	return manifest
+}
+
+func newProfileEntry(name, source, kind string, data []byte, err error) *profileEntry {
+	if err != nil {
+		return &profileEntry{Source: source, Kind: kind, Error: err.Error()}
+	}
+	return &profileEntry{Name: name, Source: source, Kind: kind, data: data}
+}
+
+// cpuProfile profiles this process's CPU for d, or until ctx is canceled.
+func cpuProfile(ctx context.Context, d time.Duration) ([]byte, error) {
+	var buf bytes.Buffer
+	// This fails if /debug/pprof/profile is already running one.
+	if err := pprof.StartCPUProfile(&buf); err != nil {
+		return nil, err
+	}
+	timer := clock.FromContext(ctx).NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+	case <-ctx.Done():
+	}
+	pprof.StopCPUProfile()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// lookupProfile takes one of the runtime's own profiles of this process.
+func lookupProfile(name, kind string) *profileEntry {
+	var buf bytes.Buffer
+	err := pprof.Lookup(kind).WriteTo(&buf, 0)
+	return newProfileEntry(name, "entrypoint", kind, buf.Bytes(), err)
+}
+
+// writeProfileBundle writes manifest.json and every profile in the manifest as a .tar.gz.
+func writeProfileBundle(w *bytes.Buffer, manifest *profileManifest) error {
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	add := func(name string, data []byte) error {
+		hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), ModTime: manifest.Finished}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+
+	if err := add("manifest.json", append(manifestJSON, '\n')); err != nil {
+		return err
+	}
+	for _, p := range manifest.Profiles {
+		if p.Name == "" {
+			continue
+		}
+		if err := add(p.Name, p.data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// handleProfile collects a profile bundle, and answers with it.
+func handleProfile(w http.ResponseWriter, r *http.Request, b *profileBundler) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed\n", http.StatusMethodNotAllowed)
+		return
+	}
+
+	d := defaultProfileDuration
+	if s := r.URL.Query().Get("duration"); s != "" {
+		var err error
+		d, err = time.ParseDuration(s)
+		if err != nil || d <= 0 || d > envoyprofile.MaxCPUDuration {
+			http.Error(w, fmt.Sprintf("duration must be a duration, like 30s, of at most %v\n",
+				envoyprofile.MaxCPUDuration), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if !b.active.TryLock() {
+		http.Error(w, "a profile is already being collected\n", http.StatusConflict)
+		return
+	}
+	manifest := b.collect(r.Context(), d)
+	b.active.Unlock()
+
+	var bundle bytes.Buffer
+	if err := writeProfileBundle(&bundle, manifest); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=profile-%s.tar.gz", manifest.Started.UTC().Format("20060102T150405Z")))
+	_, _ = w.Write(bundle.Bytes())
+}
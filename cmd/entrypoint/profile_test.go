@@ -0,0 +1,102 @@
+package entrypoint
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+	"github.com/emissary-ingress/emissary/v3/pkg/envoyprofile"
+)
+
+// readProfileBundle returns the files in a profile bundle.
+func readProfileBundle(t *testing.T, body io.Reader) map[string][]byte {
+	zr, err := gzip.NewReader(body)
+	require.NoError(t, err)
+	tr := tar.NewReader(zr)
+
+	files := map[string][]byte{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		files[hdr.Name] = data
+	}
+	return files
+}
+
+func TestHandleProfile(t *testing.T) {
+	// This Envoy wasn't built with a heap profiler, and its CPU profiler is broken.
+	envoy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not supported", http.StatusNotImplemented)
+	}))
+	defer envoy.Close()
+
+	clk := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	b := newProfileBundler(clk, envoyprofile.NewProfiler(envoy.URL, t.TempDir()+"/envoy.prof"))
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		w := httptest.NewRecorder()
+		handleProfile(w, httptest.NewRequest(http.MethodPost, "/ambassador/v0/profile?duration=10s", nil), b)
+		done <- w
+	}()
+
+	// Only one at a time.
+	require.Eventually(t, func() bool { return clk.Timers() > 0 }, 5*time.Second, time.Millisecond)
+	w := httptest.NewRecorder()
+	handleProfile(w, httptest.NewRequest(http.MethodPost, "/ambassador/v0/profile", nil), b)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	clk.Advance(10 * time.Second)
+	w = <-done
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/gzip", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "profile-20260101T000000Z.tar.gz")
+
+	files := readProfileBundle(t, w.Body)
+
+	var manifest profileManifest
+	require.NoError(t, json.Unmarshal(files["manifest.json"], &manifest))
+	assert.Equal(t, "10s", manifest.Duration)
+	assert.Equal(t, 10*time.Second, manifest.Finished.Sub(manifest.Started))
+
+	// Envoy's profiles failed, and say why, but this process's are all there.
+	for _, p := range manifest.Profiles {
+		if p.Source == "envoy" {
+			assert.Contains(t, p.Error, "not supported", p.Kind)
+			assert.Empty(t, p.Name)
+			continue
+		}
+		assert.Empty(t, p.Error, p.Kind)
+		assert.NotEmpty(t, files[p.Name], p.Name)
+	}
+	assert.Len(t, manifest.Profiles, 5)
+	assert.Len(t, files, 4)
+}
+
+func TestHandleProfileRequest(t *testing.T) {
+	b := newProfileBundler(clock.NewFake(time.Now()), envoyprofile.NewProfiler("http://127.0.0.1:1", ""))
+
+	w := httptest.NewRecorder()
+	handleProfile(w, httptest.NewRequest(http.MethodGet, "/ambassador/v0/profile", nil), b)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	for _, duration := range []string{"soon", "0s", "1h"} {
+		w := httptest.NewRecorder()
+		handleProfile(w, httptest.NewRequest(http.MethodPost, "/ambassador/v0/profile?duration="+duration, nil), b)
+		assert.Equal(t, http.StatusBadRequest, w.Code, duration)
+	}
+}
@@ -0,0 +1,227 @@
+package entrypoint
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/datawire/dlib/dlog"
+	amb "github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
+	rpb "github.com/emissary-ingress/emissary/v3/pkg/api/resolverplugin"
+	"github.com/emissary-ingress/emissary/v3/pkg/resolverplugin"
+	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+// ReconcilePluginResolvers starts and stops resolver plugin watches to match the PluginResolvers in
+// the snapshot, and the services of the Mappings that use them.
+func ReconcilePluginResolvers(ctx context.Context, pluginWatcher *pluginWatcher, s *snapshotTypes.KubernetesSnapshot) {
+	envAmbID := GetAmbassadorID()
+
+	var resolvers []*amb.PluginResolver
+	for _, pr := range s.PluginResolvers {
+		if pr.Spec.AmbassadorID.Matches(envAmbID) {
+			resolvers = append(resolvers, pr)
+		}
+	}
+
+	pluginWatcher.reconcile(ctx, resolvers, resolverMappings(s))
+}
+
+// pluginWatcher is the resolver plugin counterpart of the consulWatcher: it keeps one watch running
+// for each PluginResolver that some Mapping uses, and collects the endpoints that come back.
+//
+// Unlike Consul endpoints, plugin endpoints don't hold up bootstrapping: plugins are third-party
+// code, and a broken one shouldn't stop Ambassador from serving everything else. Mappings that use a
+// plugin that hasn't answered yet just have no endpoints until it does.
+type pluginWatcher struct {
+	watchFunc watchPluginFunc
+	watches   map[string]*pluginWatch
+
+	// The changed method returns this channel. We write down this channel to signal that new
+	// endpoints are available since the last time the update method was invoked.
+	coalescedDirty chan struct{}
+	// Watches write to this when new endpoint data is available. It is always being read by the
+	// implementation, so writing will never block.
+	endpointsCh chan resolverplugin.Endpoints
+
+	// The mutex protects access to endpoints.
+	mutex     sync.Mutex
+	endpoints map[string]resolverplugin.Endpoints
+}
+
+type pluginWatch struct {
+	spec     amb.PluginResolverSpec
+	services []string
+	stopper  Stopper
+}
+
+// watchPluginFunc starts watching the given services with a PluginResolver, sending endpoints to
+// the channel until it's stopped.
+type watchPluginFunc func(ctx context.Context, resolver *amb.PluginResolver, services []string, endpoints chan resolverplugin.Endpoints) (Stopper, error)
+
+func newPluginWatcher(watchFunc watchPluginFunc) *pluginWatcher {
+	return &pluginWatcher{
+		watchFunc:      watchFunc,
+		watches:        make(map[string]*pluginWatch),
+		coalescedDirty: make(chan struct{}),
+		endpointsCh:    make(chan resolverplugin.Endpoints),
+		endpoints:      make(map[string]resolverplugin.Endpoints),
+	}
+}
+
+func pluginEndpointsKey(resolver, service string) string {
+	return resolver + "/" + service
+}
+
+func (p *pluginWatcher) run(ctx context.Context) error {
+	dirty := false
+	for {
+		if dirty {
+			select {
+			case p.coalescedDirty <- struct{}{}:
+				dirty = false
+			case ep := <-p.endpointsCh:
+				p.updateEndpoints(ep)
+				dirty = true
+			case <-ctx.Done():
+				// The watches were started with contexts derived from the watcher loop's, so
+				// they're stopping too.
+				return nil
+			}
+		} else {
+			select {
+			case ep := <-p.endpointsCh:
+				p.updateEndpoints(ep)
+				dirty = true
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
+
+func (p *pluginWatcher) updateEndpoints(endpoints resolverplugin.Endpoints) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.endpoints[pluginEndpointsKey(endpoints.Resolver, endpoints.Service)] = endpoints
+}
+
+func (p *pluginWatcher) changed() chan struct{} {
+	return p.coalescedDirty
+}
+
+// update copies the latest endpoints into dst, replacing whatever was there.
+func (p *pluginWatcher) update(dst map[string]resolverplugin.Endpoints) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for k := range dst {
+		delete(dst, k)
+	}
+	for k, v := range p.endpoints {
+		dst[k] = v
+	}
+}
+
+// reconcile starts, restarts, and stops watches so that there's one for each of the given
+// resolvers that some mapping uses, watching exactly the services of those mappings. A watch
+// can't change what it's watching, so a change to a resolver or its services restarts its watch.
+func (p *pluginWatcher) reconcile(ctx context.Context, resolvers []*amb.PluginResolver, mappings []resolverMapping) {
+	servicesByResolver := make(map[string]map[string]bool)
+	for _, pr := range resolvers {
+		// As with ConsulResolvers, the namespace doesn't matter once the resolver is found.
+		servicesByResolver[pr.GetName()] = nil
+	}
+	for _, m := range mappings {
+		services, ok := servicesByResolver[m.Resolver]
+		if !ok || m.Service == "" {
+			continue
+		}
+		if services == nil {
+			services = make(map[string]bool)
+			servicesByResolver[m.Resolver] = services
+		}
+		services[m.Service] = true
+	}
+
+	wanted := make(map[string]*pluginWatch)
+	for _, pr := range resolvers {
+		services := servicesByResolver[pr.GetName()]
+		if len(services) == 0 {
+			continue
+		}
+		w := &pluginWatch{spec: pr.Spec}
+		for svc := range services {
+			w.services = append(w.services, svc)
+		}
+		sort.Strings(w.services)
+
+		old, ok := p.watches[pr.GetName()]
+		if ok && reflect.DeepEqual(old.spec, w.spec) && reflect.DeepEqual(old.services, w.services) {
+			wanted[pr.GetName()] = old
+			continue
+		}
+		if ok {
+			old.stopper.Stop()
+			delete(p.watches, pr.GetName())
+		}
+
+		var err error
+		w.stopper, err = p.watchFunc(ctx, pr, w.services, p.endpointsCh)
+		if err != nil {
+			dlog.Errorf(ctx, "PluginResolver %s: %v", pr.GetName(), err)
+			continue
+		}
+		wanted[pr.GetName()] = w
+	}
+
+	for name, w := range p.watches {
+		if _, ok := wanted[name]; !ok {
+			w.stopper.Stop()
+		}
+	}
+	p.watches = wanted
+
+	// Forget the endpoints of anything we've stopped watching, so that they don't linger in EDS.
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for key, ep := range p.endpoints {
+		if !servicesByResolver[ep.Resolver][ep.Service] {
+			delete(p.endpoints, key)
+		}
+	}
+}
+
+type pluginStopper struct {
+	cancel context.CancelFunc
+}
+
+func (s *pluginStopper) Stop() {
+	s.cancel()
+}
+
+// watchPlugin is the real watchPluginFunc: it streams endpoints from the plugin's socket in
+// GetResolverPluginSocketDir().
+func watchPlugin(
+	ctx context.Context,
+	resolver *amb.PluginResolver,
+	services []string,
+	endpointsCh chan resolverplugin.Endpoints,
+) (Stopper, error) {
+	if resolver.Spec.Plugin == "" || strings.ContainsRune(resolver.Spec.Plugin, '/') {
+		return nil, fmt.Errorf("plugin %q is not the name of a file in %s", resolver.Spec.Plugin, GetResolverPluginsDir())
+	}
+	socket := resolverplugin.Socket(GetResolverPluginSocketDir(), resolver.Spec.Plugin)
+	req := &rpb.WatchRequest{
+		Resolver: resolver.GetName(),
+		Config:   resolver.Spec.Config,
+		Services: services,
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	go resolverplugin.Watch(ctx, socket, req, endpointsCh)
+
+	return &pluginStopper{cancel: cancel}, nil
+}
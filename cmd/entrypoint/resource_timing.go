@@ -0,0 +1,67 @@
+package entrypoint
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/debug"
+)
+
+// Per-resource timing lets us find the one resource (usually a regex-heavy Mapping) that makes
+// every reconfigure slow. We time the Go side of processing every resource in a snapshot build
+// and expose the slowest ones via the debug endpoint, at /debug/resources/<phase>. diagd times
+// compiling each resource into the IR, and puts the slowest of those on /metrics, as
+// ambassador_resource_compile_seconds.
+const (
+	// validateResourcePhase covers schema validation of each resource we get from Kubernetes.
+	validateResourcePhase = "validate"
+	// compileResourcePhase covers compiling each resource handled by the gateway dispatcher.
+	compileResourcePhase = "compile"
+)
+
+// resourceTimingKey produces the key we use to track the timing info for a resource.
+func resourceTimingKey(kind, namespace, name string) string {
+	return fmt.Sprintf("%s:%s:%s", kind, namespace, name)
+}
+
+var (
+	slowResourceThresholdOnce sync.Once
+	slowResourceThreshold     time.Duration
+)
+
+// GetSlowResourceThreshold returns how long processing a single resource may take before we log
+// a warning about it, from AMBASSADOR_SLOW_RESOURCE_THRESHOLD_MS. Zero disables the warnings.
+// It's called for every resource in every snapshot, so the environment is only read the first
+// time. (diagd reads the same variable for its own warnings.)
+func GetSlowResourceThreshold() time.Duration {
+	slowResourceThresholdOnce.Do(func() {
+		ms, err := strconv.Atoi(env("AMBASSADOR_SLOW_RESOURCE_THRESHOLD_MS", "1000"))
+		if err != nil || ms < 0 {
+			ms = 1000
+		}
+		slowResourceThreshold = time.Duration(ms) * time.Millisecond
+	})
+	return slowResourceThreshold
+}
+
+// timeResource runs f, recording how long it took against the given resource in the named phase,
+// and complains if it took longer than the slow resource threshold.
+func timeResource(ctx context.Context, phase, key string, f func()) {
+	elapsed := debug.FromContext(ctx).ResourceTimings(phase).Time(key, f)
+
+	if threshold := GetSlowResourceThreshold(); threshold > 0 && elapsed > threshold {
+		dlog.Warnf(ctx, "[WATCHER]: slow resource: %s took %v to %s (threshold %v)", key, elapsed, phase, threshold)
+	}
+}
+
+// forgetResource discards the timing info for a resource that has been deleted.
+func forgetResource(ctx context.Context, key string) {
+	dbg := debug.FromContext(ctx)
+	for _, phase := range []string{validateResourcePhase, compileResourcePhase} {
+		dbg.ResourceTimings(phase).Forget(key)
+	}
+}
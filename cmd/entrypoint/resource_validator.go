@@ -3,6 +3,7 @@ package entrypoint
 import (
 	"context"
 
+	"github.com/datawire/dlib/derror"
 	"github.com/datawire/dlib/dlog"
 	getambassadorio "github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io"
 	"github.com/emissary-ingress/emissary/v3/pkg/kates"
@@ -21,7 +22,16 @@ func newResourceValidator() (*resourceValidator, error) {
 }
 
 func (v *resourceValidator) isValid(ctx context.Context, un *kates.Unstructured) bool {
-	err := v.katesValidator.Validate(ctx, un)
+	var err error
+	timeResource(ctx, validateResourcePhase, resourceTimingKey(un.GetKind(), un.GetNamespace(), un.GetName()), func() {
+		// A resource malformed enough to crash the validator is just invalid.
+		defer func() {
+			if perr := derror.PanicToError(recover()); perr != nil {
+				err = perr
+			}
+		}()
+		err = v.katesValidator.Validate(ctx, un)
+	})
 
 	if err != nil {
 		dlog.Errorf(ctx, "validation error: %s %s/%s -- %s", un.GetKind(), un.GetNamespace(), un.GetName(), err.Error())
@@ -0,0 +1,228 @@
+package entrypoint
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+)
+
+// A resync throws away everything the watcher has accumulated from Kubernetes and starts over: it
+// lists every watched resource afresh, forgets which ones failed validation, rebuilds the gateway
+// dispatcher, and has diagd rebuild its caches rather than reconfigure incrementally. It's for when
+// the configuration looks wrong and stale state is the suspect, which used to mean deleting the
+// pod.
+//
+// A resync is asked for with SIGUSR1 (SIGHUP already means "there's new Envoy configuration on
+// disk"), or with a POST to /ambassador/v0/resync; a GET there says how the latest one is going.
+// Asking again while one is in progress doesn't start another.
+
+type resyncPhase string
+
+const (
+	// No resync has been asked for.
+	resyncIdle resyncPhase = "idle"
+	// A resync has been asked for, but the watcher hasn't got to it yet.
+	resyncRequested resyncPhase = "requested"
+	// The watcher is listing everything again.
+	resyncListing resyncPhase = "listing"
+	// The listing is done, and the snapshot built from it is on its way to diagd and Envoy.
+	resyncPushing resyncPhase = "pushing"
+	// diagd has processed the snapshot built from the listing.
+	resyncDone resyncPhase = "done"
+	// The resync didn't make it.
+	resyncFailed resyncPhase = "failed"
+)
+
+// resyncStatus is how the latest resync is going.
+type resyncStatus struct {
+	Generation  uint64      `json:"generation"`
+	Phase       resyncPhase `json:"phase"`
+	Reason      string      `json:"reason,omitempty"`
+	RequestedAt *time.Time  `json:"requested_at,omitempty"`
+	ListedAt    *time.Time  `json:"listed_at,omitempty"`
+	FinishedAt  *time.Time  `json:"finished_at,omitempty"`
+	Error       string      `json:"error,omitempty"`
+}
+
+// inProgress says whether the resync has yet to finish.
+func (s resyncStatus) inProgress() bool {
+	return s.Phase == resyncRequested || s.Phase == resyncListing || s.Phase == resyncPushing
+}
+
+type resyncer struct {
+	clock clock.Clock
+
+	// requestCh has room for one request, which is all the watcher needs to know about.
+	requestCh chan struct{}
+
+	mu     sync.Mutex
+	status resyncStatus
+}
+
+func newResyncer(clk clock.Clock) *resyncer {
+	return &resyncer{
+		clock:     clk,
+		requestCh: make(chan struct{}, 1),
+		status:    resyncStatus{Phase: resyncIdle},
+	}
+}
+
+type resyncerKey struct{}
+
+// withResyncer returns a copy of ctx that carries the resyncer.
+func withResyncer(ctx context.Context, r *resyncer) context.Context {
+	return context.WithValue(ctx, resyncerKey{}, r)
+}
+
+// resyncerFromContext returns the resyncer, or nil if there isn't one. Nobody can ask a nil
+// resyncer for a resync.
+func resyncerFromContext(ctx context.Context) *resyncer {
+	r, _ := ctx.Value(resyncerKey{}).(*resyncer)
+	return r
+}
+
+// request asks for a resync, and returns its status. If one is already in progress, that's the
+// one this gets.
+func (r *resyncer) request(reason string) resyncStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.status.inProgress() {
+		return r.status
+	}
+	now := r.clock.Now()
+	r.status = resyncStatus{
+		Generation:  r.status.Generation + 1,
+		Phase:       resyncRequested,
+		Reason:      reason,
+		RequestedAt: &now,
+	}
+	select {
+	case r.requestCh <- struct{}{}:
+	default:
+	}
+	return r.status
+}
+
+// requested is where the watcher hears about resyncs. A nil resyncer's never fires.
+func (r *resyncer) requested() <-chan struct{} {
+	if r == nil {
+		return nil
+	}
+	return r.requestCh
+}
+
+// start notes that the watcher has started listing everything again, and returns the generation
+// of the resync that it's doing.
+func (r *resyncer) start() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status.Phase = resyncListing
+	return r.status.Generation
+}
+
+// listed notes that the listing for generation is done.
+func (r *resyncer) listed(generation uint64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.status.Generation != generation {
+		return
+	}
+	now := r.clock.Now()
+	r.status.Phase = resyncPushing
+	r.status.ListedAt = &now
+}
+
+// finish notes that the resync for generation is done, or failed if err isn't nil.
+func (r *resyncer) finish(generation uint64, err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.status.Generation != generation {
+		return
+	}
+	now := r.clock.Now()
+	r.status.FinishedAt = &now
+	if err != nil {
+		r.status.Phase = resyncFailed
+		r.status.Error = err.Error()
+	} else {
+		r.status.Phase = resyncDone
+	}
+}
+
+// Status returns how the latest resync is going.
+func (r *resyncer) Status() resyncStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status
+}
+
+// watchResyncSignal asks for a resync every time the process gets SIGUSR1.
+func watchResyncSignal(ctx context.Context, r *resyncer) error {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGUSR1)
+	defer signal.Stop(sigCh)
+
+	for {
+		select {
+		case <-sigCh:
+			status := r.request("SIGUSR1")
+			dlog.Warnf(ctx, "Resync %d asked for by SIGUSR1 (%s)", status.Generation, status.Phase)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// handleResync reports on, and asks for, resyncs:
+//
+//	GET  /ambassador/v0/resync                 how the latest resync is going
+//	POST /ambassador/v0/resync?reason=<text>   start a resync, unless one is already going
+//
+// A POST answers 202 with the status of the resync it started (or joined); poll with GET until the
+// phase is done or failed.
+func handleResync(w http.ResponseWriter, r *http.Request, resync *resyncer) {
+	if resync == nil {
+		http.Error(w, "resyncs need the Kubernetes watcher, which isn't running\n", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeResyncStatus(w, http.StatusOK, resync.Status())
+	case http.MethodPost:
+		reason := r.URL.Query().Get("reason")
+		if reason == "" {
+			reason = "asked for by " + requester(r)
+		}
+		status := resync.request(reason)
+		dlog.Warnf(r.Context(), "Resync %d asked for by %s (%s): %s", status.Generation, requester(r), status.Phase, reason)
+		writeResyncStatus(w, http.StatusAccepted, status)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed\n", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeResyncStatus(w http.ResponseWriter, code int, status resyncStatus) {
+	bytes, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(append(bytes, '\n'))
+}
@@ -0,0 +1,102 @@
+package entrypoint
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+)
+
+func resyncRequest(t *testing.T, resync *resyncer, method, target string) (int, resyncStatus) {
+	t.Helper()
+	r := httptest.NewRequest(method, target, nil).WithContext(dlog.NewTestContext(t, false))
+	w := httptest.NewRecorder()
+	handleResync(w, r, resync)
+
+	var status resyncStatus
+	if w.Code == http.StatusOK || w.Code == http.StatusAccepted {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	}
+	return w.Code, status
+}
+
+func TestResyncer(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	resync := newResyncer(clk)
+
+	code, status := resyncRequest(t, resync, http.MethodGet, "/ambassador/v0/resync")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, resyncIdle, status.Phase)
+
+	code, status = resyncRequest(t, resync, http.MethodPost, "/ambassador/v0/resync?reason=stale+routes")
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Equal(t, uint64(1), status.Generation)
+	assert.Equal(t, resyncRequested, status.Phase)
+	assert.Equal(t, "stale routes", status.Reason)
+
+	// Asking again while it's going joins the one that's going.
+	assert.Equal(t, uint64(1), resync.request("SIGUSR1").Generation)
+
+	select {
+	case <-resync.requested():
+	default:
+		t.Fatal("the watcher didn't hear about the resync")
+	}
+	select {
+	case <-resync.requested():
+		t.Fatal("the watcher heard about the resync twice")
+	default:
+	}
+
+	generation := resync.start()
+	assert.Equal(t, uint64(1), generation)
+	assert.Equal(t, resyncListing, resync.Status().Phase)
+
+	clk.Advance(2 * time.Second)
+	resync.listed(generation)
+	assert.Equal(t, resyncPushing, resync.Status().Phase)
+
+	clk.Advance(time.Second)
+	resync.finish(generation, nil)
+	status = resync.Status()
+	assert.Equal(t, resyncDone, status.Phase)
+	require.NotNil(t, status.ListedAt)
+	require.NotNil(t, status.FinishedAt)
+	assert.Equal(t, 3*time.Second, status.FinishedAt.Sub(*status.RequestedAt))
+
+	// Once it's done, asking again starts another.
+	status = resync.request("SIGUSR1")
+	assert.Equal(t, uint64(2), status.Generation)
+	assert.Nil(t, status.ListedAt)
+	<-resync.requested()
+	generation = resync.start()
+
+	// News of an older resync doesn't count.
+	resync.finish(1, nil)
+	assert.Equal(t, resyncListing, resync.Status().Phase)
+
+	resync.finish(generation, errors.New("diagd went away"))
+	status = resync.Status()
+	assert.Equal(t, resyncFailed, status.Phase)
+	assert.Equal(t, "diagd went away", status.Error)
+
+	code, _ = resyncRequest(t, resync, http.MethodDelete, "/ambassador/v0/resync")
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+}
+
+func TestResyncerNil(t *testing.T) {
+	var resync *resyncer
+
+	// Without a watcher, there's nothing to resync.
+	assert.Nil(t, resync.requested())
+	code, _ := resyncRequest(t, resync, http.MethodPost, "/ambassador/v0/resync")
+	assert.Equal(t, http.StatusNotFound, code)
+}
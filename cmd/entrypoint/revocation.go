@@ -0,0 +1,344 @@
+package entrypoint
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	amb "github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	"github.com/emissary-ingress/emissary/v3/pkg/revocation"
+	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+// Revocation:
+//
+// A Host or TLSContext with a `crl_url` gets the CRL at that URL, kept fresh, as if it had a
+// `crl_secret`; one with an `ocsp_staple_policy` gets an OCSP response stapled to its certificate,
+// also kept fresh. The revocationWatcher does the fetching (see pkg/revocation), and posts what it
+// fetches as Secrets, which go into the snapshot the same way Istio certs do, as FSSecrets:
+//
+//   - A CRL is the Opaque Secret crlSecretName(url), with the key "crl.pem", in the namespace of
+//     the Host or TLSContext.
+//   - An OCSP staple is the Opaque Secret "<cert secret>-ocsp", with the key "ocsp.der", in the
+//     namespace of the certificate's Secret.
+//
+// diagd looks for those names. Anything fetched is refreshed halfway through its life. If a fetch
+// fails, we keep whatever we fetched last, and try again in a few minutes; an OCSP staple is
+// dropped once it expires, since Envoy won't take an expired one.
+const (
+	crlSecretPrefix    = "crl-"
+	ocspSecretSuffix   = "-ocsp"
+	crlSecretKey       = "crl.pem"
+	ocspSecretKey      = "ocsp.der"
+	revocationRetry    = 5 * time.Minute
+	revocationTimeout  = 30 * time.Second
+	revocationIdleWait = 24 * time.Hour
+)
+
+// crlSecretName returns the name of the Secret that the CRL at url gets posted as.
+func crlSecretName(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return crlSecretPrefix + hex.EncodeToString(sum[:])[:16]
+}
+
+// A revocationWant is something for the revocationWatcher to fetch: either a CRL, or an OCSP
+// staple.
+type revocationWant struct {
+	// Where it came from, for logging.
+	Source string
+	// The Secret to post it as.
+	Secret snapshotTypes.SecretRef
+
+	// For a CRL, the URL to fetch it from.
+	CRLURL string
+
+	// For an OCSP staple, the Secret with the certificate to staple to, and its certificate chain.
+	Cert  snapshotTypes.SecretRef
+	Chain []byte
+}
+
+func (w revocationWant) equal(o revocationWant) bool {
+	return w.Secret == o.Secret && w.CRLURL == o.CRLURL && w.Cert == o.Cert && bytes.Equal(w.Chain, o.Chain)
+}
+
+// findRevocationWants returns what a resource wants fetched. The certificate chains of OCSP
+// staples aren't filled in: ReconcileSecrets does that, once it has found the certificates.
+func findRevocationWants(resource kates.Object, secretNamespacing bool) []revocationWant {
+	var wants []revocationWant
+	source := resource.GetObjectKind().GroupVersionKind().Kind + " " + resource.GetName() + "." + resource.GetNamespace()
+
+	crl := func(url string) {
+		wants = append(wants, revocationWant{
+			Source: source,
+			Secret: snapshotTypes.SecretRef{Namespace: resource.GetNamespace(), Name: crlSecretName(url)},
+			CRLURL: url,
+		})
+	}
+	staple := func(cert snapshotTypes.SecretRef) {
+		wants = append(wants, revocationWant{
+			Source: source,
+			Secret: snapshotTypes.SecretRef{Namespace: cert.Namespace, Name: cert.Name + ocspSecretSuffix},
+			Cert:   cert,
+		})
+	}
+
+	switch r := resource.(type) {
+	case *amb.Host:
+		if r.Spec == nil || r.Spec.TLS == nil {
+			return nil
+		}
+		if r.Spec.TLS.CRLURL != "" {
+			crl(r.Spec.TLS.CRLURL)
+		}
+		if r.Spec.TLS.OCSPStaplePolicy != "" && r.Spec.TLSSecret != nil && r.Spec.TLSSecret.Name != "" {
+			// As in findSecretRefs.
+			namespace := r.Spec.TLSSecret.Namespace
+			if namespace == "" {
+				namespace = r.GetNamespace()
+			}
+			secretRef(namespace, r.Spec.TLSSecret.Name, false, staple)
+		}
+
+	case *amb.TLSContext:
+		if r.Spec.SecretNamespacing != nil {
+			secretNamespacing = *r.Spec.SecretNamespacing
+		}
+		if r.Spec.CRLURL != "" {
+			crl(r.Spec.CRLURL)
+		}
+		if r.Spec.OCSPStaplePolicy != "" && r.Spec.Secret != "" {
+			secretRef(r.GetNamespace(), r.Spec.Secret, secretNamespacing, staple)
+		}
+	}
+	return wants
+}
+
+// The revocationWatcher keeps fetching what the snapshot wants fetched, and posts it as Secrets.
+// Like changeWindows, it's nil-safe, so that tests that don't care about it can ignore it.
+type revocationWatcher struct {
+	client *http.Client
+	clock  clock.Clock
+
+	// The changed method returns this channel. run writes to it (without blocking) when there's
+	// something new for update to pick up.
+	coalescedDirty chan struct{}
+	// reconcile writes to this (without blocking) when there's something new to fetch.
+	wake chan struct{}
+
+	// The mutex protects entries and posted.
+	mutex   sync.Mutex
+	entries map[snapshotTypes.SecretRef]*revocationEntry
+	// The Secrets that update last put in the snapshot.
+	posted map[snapshotTypes.SecretRef]bool
+}
+
+type revocationEntry struct {
+	want revocationWant
+	// What we fetched last, if anything.
+	secret *kates.Secret
+	// When an OCSP staple stops being any good.
+	expires time.Time
+	// When to fetch again. The zero Time means right away.
+	next time.Time
+}
+
+func newRevocationWatcher(clk clock.Clock) *revocationWatcher {
+	return &revocationWatcher{
+		client:         &http.Client{Timeout: revocationTimeout},
+		clock:          clk,
+		coalescedDirty: make(chan struct{}, 1),
+		wake:           make(chan struct{}, 1),
+		entries:        make(map[snapshotTypes.SecretRef]*revocationEntry),
+		posted:         make(map[snapshotTypes.SecretRef]bool),
+	}
+}
+
+// changed returns a channel that gets a value whenever there's something new for update to pick
+// up. It's nil if there's no revocationWatcher, so it never does.
+func (rw *revocationWatcher) changed() <-chan struct{} {
+	if rw == nil {
+		return nil
+	}
+	return rw.coalescedDirty
+}
+
+func (rw *revocationWatcher) markDirty() {
+	select {
+	case rw.coalescedDirty <- struct{}{}:
+	default:
+	}
+}
+
+// reconcile starts fetching anything new in wants, and stops fetching anything that isn't there
+// any more.
+func (rw *revocationWatcher) reconcile(ctx context.Context, wants []revocationWant) {
+	if rw == nil {
+		return
+	}
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+
+	added := false
+	entries := make(map[snapshotTypes.SecretRef]*revocationEntry, len(wants))
+	for _, want := range wants {
+		if _, dup := entries[want.Secret]; dup {
+			continue
+		}
+		if old, ok := rw.entries[want.Secret]; ok && old.want.equal(want) {
+			entries[want.Secret] = old
+			continue
+		}
+		if want.CRLURL == "" && len(want.Chain) == 0 {
+			// There's no certificate (yet?) to staple to.
+			continue
+		}
+		dlog.Debugf(ctx, "Revocation: %s wants %s.%s", want.Source, want.Secret.Name, want.Secret.Namespace)
+		entries[want.Secret] = &revocationEntry{want: want}
+		added = true
+	}
+
+	removed := false
+	for ref := range rw.entries {
+		if _, ok := entries[ref]; !ok && rw.posted[ref] {
+			removed = true
+		}
+	}
+	rw.entries = entries
+
+	if added {
+		select {
+		case rw.wake <- struct{}{}:
+		default:
+		}
+	}
+	if removed {
+		rw.markDirty()
+	}
+}
+
+// update copies the latest Secrets into dst, and removes any that it put there before that are
+// gone now.
+func (rw *revocationWatcher) update(dst map[snapshotTypes.SecretRef]*kates.Secret) {
+	if rw == nil {
+		return
+	}
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+
+	for ref := range rw.posted {
+		delete(dst, ref)
+	}
+	rw.posted = make(map[snapshotTypes.SecretRef]bool)
+	for ref, entry := range rw.entries {
+		if entry.secret != nil {
+			dst[ref] = entry.secret
+			rw.posted[ref] = true
+		}
+	}
+}
+
+// run fetches things as they come due, until the context is done.
+func (rw *revocationWatcher) run(ctx context.Context) error {
+	if rw == nil {
+		return nil
+	}
+	for {
+		next := rw.fetchDue(ctx)
+
+		timer := rw.clock.NewTimer(clock.Until(rw.clock, next))
+		select {
+		case <-timer.C():
+		case <-rw.wake:
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		}
+	}
+}
+
+// fetchDue fetches everything that's due, and returns when the next thing will be.
+func (rw *revocationWatcher) fetchDue(ctx context.Context) time.Time {
+	now := rw.clock.Now()
+
+	var due []revocationWant
+	next := now.Add(revocationIdleWait)
+	rw.mutex.Lock()
+	for _, entry := range rw.entries {
+		if !entry.next.After(now) {
+			due = append(due, entry.want)
+		} else if entry.next.Before(next) {
+			next = entry.next
+		}
+	}
+	rw.mutex.Unlock()
+
+	for _, want := range due {
+		secret, expires, refreshAt, err := rw.fetch(ctx, want)
+		if ctx.Err() != nil {
+			return next
+		}
+		if err != nil {
+			dlog.Errorf(ctx, "Revocation: %s: fetching %s.%s: %v", want.Source, want.Secret.Name, want.Secret.Namespace, err)
+		}
+
+		rw.mutex.Lock()
+		entry, ok := rw.entries[want.Secret]
+		if ok && entry.want.equal(want) {
+			if err == nil {
+				entry.secret = secret
+				entry.expires = expires
+				entry.next = refreshAt
+				rw.markDirty()
+			} else {
+				entry.next = now.Add(revocationRetry)
+				if entry.secret != nil && !entry.expires.IsZero() {
+					if !now.Before(entry.expires) {
+						dlog.Errorf(ctx, "Revocation: %s: dropping expired %s.%s", want.Source, want.Secret.Name, want.Secret.Namespace)
+						entry.secret = nil
+						rw.markDirty()
+					} else if entry.expires.Before(entry.next) {
+						entry.next = entry.expires
+					}
+				}
+			}
+			if entry.next.Before(next) {
+				next = entry.next
+			}
+		}
+		rw.mutex.Unlock()
+	}
+	return next
+}
+
+// fetch fetches one thing, and returns it as a Secret, along with when it expires (for an OCSP
+// staple) and when to fetch it again.
+func (rw *revocationWatcher) fetch(ctx context.Context, want revocationWant) (*kates.Secret, time.Time, time.Time, error) {
+	secret := &kates.Secret{
+		TypeMeta:   kates.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: kates.ObjectMeta{Name: want.Secret.Name, Namespace: want.Secret.Namespace},
+	}
+
+	if want.CRLURL != "" {
+		crl, err := revocation.FetchCRL(ctx, rw.client, want.CRLURL)
+		if err != nil {
+			return nil, time.Time{}, time.Time{}, err
+		}
+		secret.Data = map[string][]byte{crlSecretKey: crl.PEM}
+		return secret, time.Time{}, revocation.RefreshAt(crl.ThisUpdate, crl.NextUpdate, rw.clock.Now()), nil
+	}
+
+	staple, err := revocation.FetchStaple(ctx, rw.client, want.Chain)
+	if err != nil {
+		return nil, time.Time{}, time.Time{}, err
+	}
+	secret.Data = map[string][]byte{ocspSecretKey: staple.DER}
+	return secret, staple.NextUpdate, revocation.RefreshAt(staple.ThisUpdate, staple.NextUpdate, rw.clock.Now()), nil
+}
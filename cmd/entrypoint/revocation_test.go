@@ -0,0 +1,151 @@
+package entrypoint
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/datawire/dlib/dlog"
+	amb "github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+func TestFindRevocationWants(t *testing.T) {
+	host := &amb.Host{
+		TypeMeta:   kates.TypeMeta{Kind: "Host"},
+		ObjectMeta: kates.ObjectMeta{Name: "h", Namespace: "ns"},
+		Spec: &amb.HostSpec{
+			TLSSecret: &corev1.SecretReference{Name: "cert"},
+			TLS: &amb.TLSConfig{
+				CRLURL:           "http://crl.example.com/ca.crl",
+				OCSPStaplePolicy: "must_staple",
+			},
+		},
+	}
+	assert.Equal(t, []revocationWant{
+		{
+			Source: "Host h.ns",
+			Secret: snapshotTypes.SecretRef{Namespace: "ns", Name: crlSecretName("http://crl.example.com/ca.crl")},
+			CRLURL: "http://crl.example.com/ca.crl",
+		},
+		{
+			Source: "Host h.ns",
+			Secret: snapshotTypes.SecretRef{Namespace: "ns", Name: "cert-ocsp"},
+			Cert:   snapshotTypes.SecretRef{Namespace: "ns", Name: "cert"},
+		},
+	}, findRevocationWants(host, true))
+
+	tlsContext := &amb.TLSContext{
+		TypeMeta:   kates.TypeMeta{Kind: "TLSContext"},
+		ObjectMeta: kates.ObjectMeta{Name: "c", Namespace: "ns"},
+		Spec: amb.TLSContextSpec{
+			Secret:           "cert.other",
+			OCSPStaplePolicy: "lenient_stapling",
+		},
+	}
+	assert.Equal(t, []revocationWant{
+		{
+			Source: "TLSContext c.ns",
+			Secret: snapshotTypes.SecretRef{Namespace: "other", Name: "cert-ocsp"},
+			Cert:   snapshotTypes.SecretRef{Namespace: "other", Name: "cert"},
+		},
+	}, findRevocationWants(tlsContext, true))
+
+	// Nothing asked for, nothing wanted.
+	assert.Empty(t, findRevocationWants(&amb.TLSContext{Spec: amb.TLSContextSpec{Secret: "cert"}}, true))
+}
+
+func TestRevocationWatcher(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+	crlDER, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now(),
+		NextUpdate: time.Now().Add(time.Hour),
+	}, ca, key)
+	require.NoError(t, err)
+
+	fail := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write(crlDER)
+	}))
+	defer srv.Close()
+
+	rw := newRevocationWatcher(clock.Real)
+	rw.client = srv.Client()
+	want := revocationWant{
+		Source: "TLSContext c.ns",
+		Secret: snapshotTypes.SecretRef{Namespace: "ns", Name: crlSecretName(srv.URL)},
+		CRLURL: srv.URL,
+	}
+	rw.reconcile(ctx, []revocationWant{want})
+
+	next := rw.fetchDue(ctx)
+	assert.WithinDuration(t, time.Now().Add(30*time.Minute), next, time.Minute)
+	select {
+	case <-rw.changed():
+	default:
+		t.Fatal("fetching the CRL didn't fire changed")
+	}
+
+	fsSecrets := map[snapshotTypes.SecretRef]*kates.Secret{}
+	rw.update(fsSecrets)
+	require.Contains(t, fsSecrets, want.Secret)
+	assert.Contains(t, string(fsSecrets[want.Secret].Data[crlSecretKey]), "-----BEGIN X509 CRL-----")
+
+	// A failed refresh keeps the CRL we have, and tries again soon.
+	fail = true
+	rw.entries[want.Secret].next = time.Time{}
+	next = rw.fetchDue(ctx)
+	assert.WithinDuration(t, time.Now().Add(revocationRetry), next, time.Minute)
+	rw.update(fsSecrets)
+	assert.Contains(t, fsSecrets, want.Secret)
+
+	// Once nothing wants it, it goes away.
+	rw.reconcile(ctx, nil)
+	select {
+	case <-rw.changed():
+	default:
+		t.Fatal("dropping the CRL didn't fire changed")
+	}
+	rw.update(fsSecrets)
+	assert.Empty(t, fsSecrets)
+
+	// A nil watcher does nothing, and doesn't mind.
+	var nilWatcher *revocationWatcher
+	nilWatcher.reconcile(ctx, []revocationWant{want})
+	nilWatcher.update(fsSecrets)
+	assert.Nil(t, nilWatcher.changed())
+}
@@ -0,0 +1,212 @@
+package entrypoint
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	gw "sigs.k8s.io/gateway-api/apis/v1alpha1"
+
+	"github.com/datawire/dlib/dlog"
+	amb "github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
+	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+// Mappings go through diagd, and HTTPRoutes go through the gateway dispatcher, and nothing stops
+// the two from claiming the same requests. Which one actually gets them then comes down to which
+// Envoy listener happens to end up with the connection, and that isn't something anyone should
+// have to reason about halfway through a migration from one API to the other.
+//
+// So when an HTTPRoute match duplicates a Mapping's route (the same path, with neither side
+// matching on headers), the Mapping takes precedence: that match is dropped from the HTTPRoute
+// before it goes to the dispatcher, and the overlap is reported as an error on the Mapping. With
+// AMBASSADOR_ROUTE_OVERLAP_STRICT=true, the whole HTTPRoute is rejected instead.
+//
+// Hostnames don't come into it: the dispatcher serves HTTPRoutes for every hostname (it doesn't
+// look at spec.hostnames yet), so whatever hostname the Mapping has, the HTTPRoute has it too.
+// Only duplicates count, though. A Mapping for /foo/ and an HTTPRoute for /foo/bar/ route
+// different requests, so they're left alone, as are regex paths that aren't character for
+// character the same.
+
+// IsRouteOverlapStrict returns whether an HTTPRoute that duplicates a Mapping's route is rejected
+// outright, from AMBASSADOR_ROUTE_OVERLAP_STRICT.
+func IsRouteOverlapStrict() bool {
+	return strings.ToLower(env("AMBASSADOR_ROUTE_OVERLAP_STRICT", "")) == "true"
+}
+
+// routeKey is what two routes have to share to be duplicates.
+type routeKey struct {
+	pathType gw.PathMatchType
+	path     string
+}
+
+func (k routeKey) String() string {
+	switch k.pathType {
+	case gw.PathMatchExact:
+		return k.path + " (exact)"
+	case gw.PathMatchRegularExpression:
+		return k.path + " (regex)"
+	default:
+		return k.path
+	}
+}
+
+// mappingRoute returns the routeKey and the hostname of a Mapping, and false if the Mapping
+// matches on anything that an HTTPRoute match wouldn't.
+func mappingRoute(m *amb.Mapping) (routeKey, string, bool) {
+	spec := m.Spec
+	if len(spec.Headers) > 0 || len(spec.RegexHeaders) > 0 || spec.Method != "" ||
+		len(spec.QueryParameters) > 0 || len(spec.RegexQueryParameters) > 0 ||
+		(spec.DeprecatedHostRegex != nil && *spec.DeprecatedHostRegex) {
+		return routeKey{}, "", false
+	}
+
+	hostname := spec.Hostname
+	if hostname == "" {
+		hostname = spec.DeprecatedHost
+	}
+	if hostname == "" {
+		hostname = "*"
+	}
+
+	key := routeKey{pathType: gw.PathMatchPrefix, path: spec.Prefix}
+	switch {
+	case spec.PrefixRegex != nil && *spec.PrefixRegex:
+		key.pathType = gw.PathMatchRegularExpression
+	case spec.PrefixExact != nil && *spec.PrefixExact:
+		key.pathType = gw.PathMatchExact
+	}
+	return key, hostname, true
+}
+
+// httpRouteMatchKey returns the routeKey of an HTTPRoute match, and false if the match is on
+// headers.
+func httpRouteMatchKey(match gw.HTTPRouteMatch) (routeKey, bool) {
+	if match.Headers != nil && len(match.Headers.Values) > 0 {
+		return routeKey{}, false
+	}
+
+	// This is how Compile_HTTPRouteMatch reads an empty path.
+	pathType, path := match.Path.Type, match.Path.Value
+	if pathType == "" {
+		pathType = gw.PathMatchPrefix
+	}
+	if pathType == gw.PathMatchPrefix && path == "" {
+		path = "/"
+	}
+	return routeKey{pathType: pathType, path: path}, true
+}
+
+// findRouteOverlaps returns every HTTPRoute match that duplicates the route of a Mapping that
+// belongs to this Ambassador, annotations included, in a stable order.
+func findRouteOverlaps(s *snapshotTypes.KubernetesSnapshot) []snapshotTypes.RouteOverlap {
+	if len(s.HTTPRoutes) == 0 {
+		return nil
+	}
+
+	envAmbID := GetAmbassadorID()
+
+	mappings := append([]*amb.Mapping(nil), s.Mappings...)
+	for _, list := range s.Annotations {
+		for _, a := range list {
+			if m, ok := a.(*amb.Mapping); ok {
+				mappings = append(mappings, m)
+			}
+		}
+	}
+
+	type mappingRef struct {
+		name     string
+		hostname string
+	}
+	mappingsByKey := make(map[routeKey][]mappingRef)
+	for _, m := range mappings {
+		if !m.Spec.AmbassadorID.Matches(envAmbID) {
+			continue
+		}
+		if key, hostname, ok := mappingRoute(m); ok {
+			mappingsByKey[key] = append(mappingsByKey[key], mappingRef{m.GetName() + "." + m.GetNamespace(), hostname})
+		}
+	}
+
+	strict := IsRouteOverlapStrict()
+
+	var overlaps []snapshotTypes.RouteOverlap
+	for _, hr := range s.HTTPRoutes {
+		for ruleIdx, rule := range hr.Spec.Rules {
+			for matchIdx, match := range rule.Matches {
+				key, ok := httpRouteMatchKey(match)
+				if !ok {
+					continue
+				}
+				for _, mapping := range mappingsByKey[key] {
+					overlaps = append(overlaps, snapshotTypes.RouteOverlap{
+						HTTPRoute: hr.GetName() + "." + hr.GetNamespace(),
+						Rule:      ruleIdx,
+						Match:     matchIdx,
+						Mapping:   mapping.name,
+						Route:     mapping.hostname + " " + key.String(),
+						Rejected:  strict,
+					})
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(overlaps, func(i, j int) bool {
+		a, b := overlaps[i], overlaps[j]
+		if a.HTTPRoute != b.HTTPRoute {
+			return a.HTTPRoute < b.HTTPRoute
+		}
+		if a.Rule != b.Rule {
+			return a.Rule < b.Rule
+		}
+		if a.Match != b.Match {
+			return a.Match < b.Match
+		}
+		return a.Mapping < b.Mapping
+	})
+	return overlaps
+}
+
+// resolveRouteOverlaps applies the precedence above to an HTTPRoute. It returns the HTTPRoute to
+// give the dispatcher (a copy, if any of its matches had to go), or nil if it's rejected.
+func resolveRouteOverlaps(ctx context.Context, hr *gw.HTTPRoute, overlaps []snapshotTypes.RouteOverlap) *gw.HTTPRoute {
+	name := hr.GetName() + "." + hr.GetNamespace()
+
+	dropped := make(map[[2]int]bool)
+	for _, o := range overlaps {
+		if o.HTTPRoute != name {
+			continue
+		}
+		if o.Rejected {
+			dlog.Errorf(ctx, "HTTPRoute %s: rule %d match %d duplicates Mapping %s (%s); rejecting the HTTPRoute",
+				name, o.Rule, o.Match, o.Mapping, o.Route)
+			return nil
+		}
+		dlog.Warnf(ctx, "HTTPRoute %s: rule %d match %d duplicates Mapping %s (%s); the Mapping takes precedence",
+			name, o.Rule, o.Match, o.Mapping, o.Route)
+		dropped[[2]int{o.Rule, o.Match}] = true
+	}
+	if len(dropped) == 0 {
+		return hr
+	}
+
+	hr = hr.DeepCopy()
+	rules := hr.Spec.Rules[:0]
+	for ruleIdx, rule := range hr.Spec.Rules {
+		matches := rule.Matches[:0]
+		for matchIdx, match := range rule.Matches {
+			if !dropped[[2]int{ruleIdx, matchIdx}] {
+				matches = append(matches, match)
+			}
+		}
+		// A rule with no matches left has nothing to route.
+		if len(matches) > 0 {
+			rule.Matches = matches
+			rules = append(rules, rule)
+		}
+	}
+	hr.Spec.Rules = rules
+	return hr
+}
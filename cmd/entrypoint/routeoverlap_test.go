@@ -0,0 +1,102 @@
+package entrypoint
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gw "sigs.k8s.io/gateway-api/apis/v1alpha1"
+
+	"github.com/datawire/dlib/dlog"
+	amb "github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+func overlapMapping(name string, spec amb.MappingSpec) *amb.Mapping {
+	return &amb.Mapping{
+		ObjectMeta: kates.ObjectMeta{Namespace: "default", Name: name},
+		Spec:       spec,
+	}
+}
+
+func pathMatch(pathType gw.PathMatchType, value string) gw.HTTPRouteMatch {
+	return gw.HTTPRouteMatch{Path: gw.HTTPPathMatch{Type: pathType, Value: value}}
+}
+
+func overlapSnapshot() *snapshotTypes.KubernetesSnapshot {
+	exact := true
+	return &snapshotTypes.KubernetesSnapshot{
+		Mappings: []*amb.Mapping{
+			overlapMapping("quote", amb.MappingSpec{Prefix: "/quote/", Service: "quote"}),
+			overlapMapping("health", amb.MappingSpec{Prefix: "/healthz", PrefixExact: &exact, Hostname: "api.example.com", Service: "health"}),
+			// Only GETs, so not a duplicate of anything an HTTPRoute can say.
+			overlapMapping("get-only", amb.MappingSpec{Prefix: "/quote/", Method: "GET", Service: "quote"}),
+			overlapMapping("other", amb.MappingSpec{Prefix: "/other/", Service: "other"}),
+		},
+		HTTPRoutes: []*gw.HTTPRoute{
+			{
+				ObjectMeta: kates.ObjectMeta{Namespace: "default", Name: "migrated"},
+				Spec: gw.HTTPRouteSpec{
+					Rules: []gw.HTTPRouteRule{
+						{Matches: []gw.HTTPRouteMatch{
+							pathMatch(gw.PathMatchPrefix, "/quote/"),
+							pathMatch(gw.PathMatchPrefix, "/quote/v2/"),
+						}},
+						{Matches: []gw.HTTPRouteMatch{
+							pathMatch(gw.PathMatchExact, "/healthz"),
+						}},
+					},
+				},
+			},
+			{
+				ObjectMeta: kates.ObjectMeta{Namespace: "default", Name: "unrelated"},
+				Spec: gw.HTTPRouteSpec{
+					Rules: []gw.HTTPRouteRule{
+						{Matches: []gw.HTTPRouteMatch{pathMatch(gw.PathMatchPrefix, "/other/v2/")}},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestRouteOverlaps(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	snapshot := overlapSnapshot()
+
+	overlaps := findRouteOverlaps(snapshot)
+	assert.Equal(t, []snapshotTypes.RouteOverlap{
+		{HTTPRoute: "migrated.default", Rule: 0, Match: 0, Mapping: "quote.default", Route: "* /quote/"},
+		{HTTPRoute: "migrated.default", Rule: 1, Match: 0, Mapping: "health.default", Route: "api.example.com /healthz (exact)"},
+	}, overlaps)
+
+	// The Mappings win: the duplicated matches go, and so does the rule that has none left.
+	resolved := resolveRouteOverlaps(ctx, snapshot.HTTPRoutes[0], overlaps)
+	require.NotNil(t, resolved)
+	assert.Equal(t, []gw.HTTPRouteRule{
+		{Matches: []gw.HTTPRouteMatch{pathMatch(gw.PathMatchPrefix, "/quote/v2/")}},
+	}, resolved.Spec.Rules)
+
+	// ...without touching the HTTPRoute in the snapshot.
+	assert.Len(t, snapshot.HTTPRoutes[0].Spec.Rules, 2)
+	assert.Len(t, snapshot.HTTPRoutes[0].Spec.Rules[0].Matches, 2)
+
+	// An HTTPRoute with no duplicates goes through as it is.
+	assert.Same(t, snapshot.HTTPRoutes[1], resolveRouteOverlaps(ctx, snapshot.HTTPRoutes[1], overlaps))
+}
+
+func TestRouteOverlapsStrict(t *testing.T) {
+	t.Setenv("AMBASSADOR_ROUTE_OVERLAP_STRICT", "true")
+	ctx := dlog.NewTestContext(t, false)
+	snapshot := overlapSnapshot()
+
+	overlaps := findRouteOverlaps(snapshot)
+	require.Len(t, overlaps, 2)
+	for _, o := range overlaps {
+		assert.True(t, o.Rejected)
+	}
+
+	assert.Nil(t, resolveRouteOverlaps(ctx, snapshot.HTTPRoutes[0], overlaps))
+	assert.Same(t, snapshot.HTTPRoutes[1], resolveRouteOverlaps(ctx, snapshot.HTTPRoutes[1], overlaps))
+}
@@ -0,0 +1,261 @@
+package entrypoint
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+// Scoped secrets: by default, the watcher doesn't list or watch every Secret it can see. Instead,
+// the scopedSecretWatcher watches each Secret that ReconcileSecrets finds a reference to on its
+// own, with a field selector on its name, and stops when nothing refers to it any more. Kubernetes
+// checks a list or watch like that against resourceNames, so the only RBAC that takes is list and
+// watch on those Secrets, in their namespaces:
+//
+//	- apiGroups: [""]
+//	  resources: ["secrets"]
+//	  resourceNames: ["my-tls-cert", "fallback-self-signed-cert"]
+//	  verbs: ["list", "watch"]
+//
+// A Secret that isn't there (yet), or that Ambassador isn't allowed to watch, is missing from the
+// snapshot, just as if it didn't exist.
+//
+// With AMBASSADOR_SCOPED_SECRETS=false, the watcher lists and watches every Secret in the cluster
+// (or in AMBASSADOR_NAMESPACE, with AMBASSADOR_SINGLE_NAMESPACE) as it used to, and
+// ReconcileSecrets picks out the ones that something refers to. That needs RBAC that lets
+// Ambassador read every Secret it can see.
+
+// IsScopedSecrets returns whether Secrets are only ever watched by name, from
+// AMBASSADOR_SCOPED_SECRETS.
+func IsScopedSecrets() bool {
+	ret, err := strconv.ParseBool(env("AMBASSADOR_SCOPED_SECRETS", "true"))
+	if err != nil {
+		return true
+	}
+	return ret
+}
+
+// The scopedSecretWatcher keeps a watch going on each Secret that the snapshot refers to. Like the
+// revocationWatcher, it's nil-safe, so that the watcher can do without it when Secrets are
+// watched the usual way.
+type scopedSecretWatcher struct {
+	source K8sSource
+
+	// The changed method returns this channel. The watches write to it (without blocking) when a
+	// Secret has appeared, changed, or gone away.
+	coalescedDirty chan struct{}
+
+	// The mutex protects everything below.
+	mutex sync.Mutex
+	// The context that the watches run in, once run has been called.
+	ctx     context.Context
+	entries map[snapshotTypes.SecretRef]*scopedSecretEntry
+}
+
+type scopedSecretEntry struct {
+	// What the watch last saw; nil if it wasn't there.
+	secret *kates.Secret
+	// Whether the watch has heard back yet.
+	fetched bool
+	// Stops the watch; nil until it's started.
+	cancel context.CancelFunc
+}
+
+func newScopedSecretWatcher(source K8sSource) *scopedSecretWatcher {
+	return &scopedSecretWatcher{
+		source:         source,
+		coalescedDirty: make(chan struct{}, 1),
+		entries:        make(map[snapshotTypes.SecretRef]*scopedSecretEntry),
+	}
+}
+
+// changed returns a channel that gets a value whenever a Secret has changed. It's nil if there's
+// no scopedSecretWatcher, so it never does.
+func (sw *scopedSecretWatcher) changed() <-chan struct{} {
+	if sw == nil {
+		return nil
+	}
+	return sw.coalescedDirty
+}
+
+func (sw *scopedSecretWatcher) markDirty() {
+	select {
+	case sw.coalescedDirty <- struct{}{}:
+	default:
+	}
+}
+
+// reconcile starts watching any Secret in refs that it isn't watching already, and stops watching
+// the ones that aren't in refs any more.
+func (sw *scopedSecretWatcher) reconcile(ctx context.Context, refs map[snapshotTypes.SecretRef]bool) {
+	if sw == nil {
+		return
+	}
+	sw.mutex.Lock()
+	defer sw.mutex.Unlock()
+
+	entries := make(map[snapshotTypes.SecretRef]*scopedSecretEntry, len(refs))
+	for ref, wanted := range refs {
+		if !wanted {
+			continue
+		}
+		if old, ok := sw.entries[ref]; ok {
+			entries[ref] = old
+			continue
+		}
+		dlog.Debugf(ctx, "Scoped secrets: watching %s.%s", ref.Name, ref.Namespace)
+		entry := &scopedSecretEntry{}
+		entries[ref] = entry
+		if sw.ctx != nil {
+			sw.start(ref, entry)
+		}
+	}
+	for ref, old := range sw.entries {
+		if _, ok := entries[ref]; !ok && old.cancel != nil {
+			dlog.Debugf(ctx, "Scoped secrets: no longer watching %s.%s", ref.Name, ref.Namespace)
+			old.cancel()
+		}
+	}
+	sw.entries = entries
+}
+
+// secrets returns the Secrets that the watches last saw, in order.
+func (sw *scopedSecretWatcher) secrets() []*kates.Secret {
+	sw.mutex.Lock()
+	defer sw.mutex.Unlock()
+
+	ret := make([]*kates.Secret, 0, len(sw.entries))
+	for _, entry := range sw.entries {
+		if entry.secret != nil {
+			ret = append(ret, entry.secret)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].GetNamespace() != ret[j].GetNamespace() {
+			return ret[i].GetNamespace() < ret[j].GetNamespace()
+		}
+		return ret[i].GetName() < ret[j].GetName()
+	})
+	return ret
+}
+
+// isBootstrapped returns whether the watch on every Secret that the snapshot refers to has heard
+// back at least once, so that a snapshot doesn't go out without Secrets that are there to be had.
+func (sw *scopedSecretWatcher) isBootstrapped() bool {
+	if sw == nil {
+		return true
+	}
+	sw.mutex.Lock()
+	defer sw.mutex.Unlock()
+	for _, entry := range sw.entries {
+		if !entry.fetched {
+			return false
+		}
+	}
+	return true
+}
+
+// run starts the watches, and keeps them going until the context is done.
+func (sw *scopedSecretWatcher) run(ctx context.Context) error {
+	if sw == nil {
+		return nil
+	}
+	sw.mutex.Lock()
+	sw.ctx = ctx
+	for ref, entry := range sw.entries {
+		sw.start(ref, entry)
+	}
+	sw.mutex.Unlock()
+
+	<-ctx.Done()
+	return nil
+}
+
+// start starts watching a Secret. It must be called with the mutex held.
+func (sw *scopedSecretWatcher) start(ref snapshotTypes.SecretRef, entry *scopedSecretEntry) {
+	ctx, cancel := context.WithCancel(sw.ctx)
+	entry.cancel = cancel
+	go sw.watch(ctx, ref, entry)
+}
+
+// watch keeps entry up to date with the Secret that ref names, until the context is done.
+func (sw *scopedSecretWatcher) watch(ctx context.Context, ref snapshotTypes.SecretRef, entry *scopedSecretEntry) {
+	watcher, err := sw.source.Watch(ctx, kates.Query{
+		Name:          "Secrets",
+		Kind:          "secrets.v1.",
+		Namespace:     ref.Namespace,
+		FieldSelector: "metadata.name=" + ref.Name,
+	})
+	if err != nil {
+		// Don't hold up the snapshot for a Secret we can't watch.
+		dlog.Errorf(ctx, "Scoped secrets: watching Secret %s.%s: %v", ref.Name, ref.Namespace, err)
+		sw.update(entry, nil)
+		return
+	}
+
+	var found struct {
+		Secrets []*kates.Secret
+	}
+	all := func(*kates.Unstructured) bool { return true }
+	for {
+		select {
+		case <-watcher.Changed():
+			if _, err := watcher.FilteredUpdate(ctx, &found, nil, all); err != nil {
+				dlog.Errorf(ctx, "Scoped secrets: updating Secret %s.%s: %v", ref.Name, ref.Namespace, err)
+				continue
+			}
+			var secret *kates.Secret
+			if len(found.Secrets) > 0 {
+				secret = found.Secrets[0]
+			}
+			sw.update(entry, secret)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// update records what a watch saw, and marks the watcher dirty if that's news.
+func (sw *scopedSecretWatcher) update(entry *scopedSecretEntry, secret *kates.Secret) {
+	sw.mutex.Lock()
+	defer sw.mutex.Unlock()
+	if !entry.fetched {
+		// That may be the last thing that isBootstrapped was waiting for.
+		entry.fetched = true
+		sw.markDirty()
+	}
+	if !sameSecret(entry.secret, secret) {
+		entry.secret = secret
+		sw.markDirty()
+	}
+}
+
+// sameSecret returns whether two fetches of a Secret (nil if it wasn't there) got the same thing.
+func sameSecret(a, b *kates.Secret) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.GetResourceVersion() != "" && b.GetResourceVersion() != "" {
+		return a.GetUID() == b.GetUID() && a.GetResourceVersion() == b.GetResourceVersion()
+	}
+	return a.Type == b.Type && reflect.DeepEqual(a.Data, b.Data)
+}
+
+// ScopedSecretsUpdate puts the Secrets that the scopedSecretWatcher has fetched in the snapshot.
+func (sh *SnapshotHolder) ScopedSecretsUpdate(ctx context.Context) error {
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	if err := ReconcileSecrets(ctx, sh); err != nil {
+		return err
+	}
+
+	sh.snapshotChangeCount += 1
+	return nil
+}
@@ -0,0 +1,200 @@
+package entrypoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+// fakeSecretSource is a K8sSource for the scopedSecretWatcher's watches on single Secrets. Like
+// kates, each watch says it has changed once it has synced, and again whenever its Secret does.
+type fakeSecretSource struct {
+	mutex   sync.Mutex
+	secrets map[snapshotTypes.SecretRef]*kates.Secret
+	errs    map[snapshotTypes.SecretRef]error
+	watches map[snapshotTypes.SecretRef]*fakeSecretWatch
+}
+
+type fakeSecretWatch struct {
+	source  *fakeSecretSource
+	ref     snapshotTypes.SecretRef
+	changed chan struct{}
+}
+
+func (fs *fakeSecretSource) Watch(ctx context.Context, queries ...kates.Query) (K8sWatcher, error) {
+	if len(queries) != 1 || queries[0].Kind != "secrets.v1." {
+		return nil, fmt.Errorf("unexpected queries %v", queries)
+	}
+	ref := snapshotTypes.SecretRef{
+		Namespace: queries[0].Namespace,
+		Name:      strings.TrimPrefix(queries[0].FieldSelector, "metadata.name="),
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	if err := fs.errs[ref]; err != nil {
+		return nil, err
+	}
+	w := &fakeSecretWatch{source: fs, ref: ref, changed: make(chan struct{}, 1)}
+	fs.watches[ref] = w
+	w.notify()
+	go func() {
+		<-ctx.Done()
+		fs.mutex.Lock()
+		defer fs.mutex.Unlock()
+		if fs.watches[ref] == w {
+			delete(fs.watches, ref)
+		}
+	}()
+	return w, nil
+}
+
+func (fs *fakeSecretSource) set(ref snapshotTypes.SecretRef, secret *kates.Secret) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	fs.secrets[ref] = secret
+	if w := fs.watches[ref]; w != nil {
+		w.notify()
+	}
+}
+
+func (fs *fakeSecretSource) watching() []snapshotTypes.SecretRef {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	var ret []snapshotTypes.SecretRef
+	for ref := range fs.watches {
+		ret = append(ret, ref)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+func (w *fakeSecretWatch) notify() {
+	select {
+	case w.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (w *fakeSecretWatch) Changed() <-chan struct{} {
+	return w.changed
+}
+
+func (w *fakeSecretWatch) FilteredUpdate(_ context.Context, target interface{}, _ *[]*kates.Delta, _ func(*kates.Unstructured) bool) (bool, error) {
+	w.source.mutex.Lock()
+	defer w.source.mutex.Unlock()
+	var found []*kates.Secret
+	if secret := w.source.secrets[w.ref]; secret != nil {
+		found = append(found, secret)
+	}
+	reflect.ValueOf(target).Elem().FieldByName("Secrets").Set(reflect.ValueOf(found))
+	return true, nil
+}
+
+func testSecret(namespace, name, resourceVersion string) *kates.Secret {
+	return &kates.Secret{
+		TypeMeta:   kates.TypeMeta{Kind: "Secret", APIVersion: "v1"},
+		ObjectMeta: kates.ObjectMeta{Namespace: namespace, Name: name, ResourceVersion: resourceVersion},
+		Type:       kates.SecretTypeTLS,
+		Data:       map[string][]byte{"tls.crt": []byte("crt"), "tls.key": []byte("key")},
+	}
+}
+
+func isDirty(sw *scopedSecretWatcher) bool {
+	select {
+	case <-sw.changed():
+		return true
+	default:
+		return false
+	}
+}
+
+func TestScopedSecretWatcher(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	cert := snapshotTypes.SecretRef{Namespace: "ns", Name: "cert"}
+	other := snapshotTypes.SecretRef{Namespace: "ns", Name: "other"}
+	missing := snapshotTypes.SecretRef{Namespace: "ns", Name: "missing"}
+	broken := snapshotTypes.SecretRef{Namespace: "ns", Name: "broken"}
+	source := &fakeSecretSource{
+		secrets: map[snapshotTypes.SecretRef]*kates.Secret{
+			cert:  testSecret("ns", "cert", "1"),
+			other: testSecret("ns", "other", "1"),
+		},
+		errs: map[snapshotTypes.SecretRef]error{
+			broken: errors.New("no such kind"),
+		},
+		watches: map[snapshotTypes.SecretRef]*fakeSecretWatch{},
+	}
+	sw := newScopedSecretWatcher(source)
+
+	// Nothing is watched until the watcher runs, so the snapshot has to wait.
+	sw.reconcile(ctx, map[snapshotTypes.SecretRef]bool{cert: true, missing: true, broken: true, other: false})
+	assert.False(t, sw.isBootstrapped())
+	assert.Empty(t, source.watching())
+	assert.Empty(t, sw.secrets())
+
+	// Hearing back from every watch is a change, even though only one Secret is there. One that
+	// can't be watched doesn't hold anything up.
+	go func() { _ = sw.run(ctx) }()
+	require.Eventually(t, sw.isBootstrapped, time.Second, time.Millisecond)
+	assert.True(t, isDirty(sw))
+	assert.Equal(t, []snapshotTypes.SecretRef{cert, missing}, source.watching())
+	assert.Equal(t, []*kates.Secret{source.secrets[cert]}, sw.secrets())
+
+	// A new resourceVersion is a change, and so is a Secret going away.
+	source.set(cert, testSecret("ns", "cert", "2"))
+	require.Eventually(t, func() bool { return isDirty(sw) }, time.Second, time.Millisecond)
+	assert.Equal(t, "2", sw.secrets()[0].GetResourceVersion())
+	source.set(cert, nil)
+	require.Eventually(t, func() bool { return isDirty(sw) }, time.Second, time.Millisecond)
+	assert.Empty(t, sw.secrets())
+
+	// A new reference gets watched right away, and one that has gone away doesn't get watched
+	// any more.
+	sw.reconcile(ctx, map[snapshotTypes.SecretRef]bool{other: true})
+	require.Eventually(t, sw.isBootstrapped, time.Second, time.Millisecond)
+	require.Eventually(t, func() bool {
+		return reflect.DeepEqual([]snapshotTypes.SecretRef{other}, source.watching())
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []*kates.Secret{source.secrets[other]}, sw.secrets())
+
+	// And once the watcher is done, so are its watches.
+	cancel()
+	require.Eventually(t, func() bool { return len(source.watching()) == 0 }, time.Second, time.Millisecond)
+}
+
+func TestIsScopedSecrets(t *testing.T) {
+	assert.True(t, IsScopedSecrets())
+	t.Setenv("AMBASSADOR_SCOPED_SECRETS", "false")
+	assert.False(t, IsScopedSecrets())
+	t.Setenv("AMBASSADOR_SCOPED_SECRETS", "nope")
+	assert.True(t, IsScopedSecrets())
+}
+
+func TestSameSecret(t *testing.T) {
+	assert.True(t, sameSecret(nil, nil))
+	assert.False(t, sameSecret(nil, testSecret("ns", "a", "1")))
+	assert.True(t, sameSecret(testSecret("ns", "a", "1"), testSecret("ns", "a", "1")))
+	assert.False(t, sameSecret(testSecret("ns", "a", "1"), testSecret("ns", "a", "2")))
+
+	// Without resourceVersions, only the contents count.
+	a, b := testSecret("ns", "a", ""), testSecret("ns", "a", "")
+	assert.True(t, sameSecret(a, b))
+	b.Data["tls.key"] = []byte("other")
+	assert.False(t, sameSecret(a, b))
+}
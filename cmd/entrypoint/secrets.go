@@ -267,9 +267,15 @@ func ReconcileSecrets(ctx context.Context, sh *SnapshotHolder) error {
 	}
 
 	// So. Walk the list of resources...
+	var revocationWants []revocationWant
 	for _, resource := range resources {
-		// ...and for each resource, dig out any secrets being referenced.
+		// ...and for each resource, dig out any secrets being referenced. That includes the
+		// secrets that the revocationWatcher posts CRLs and OCSP staples as.
 		findSecretRefs(ctx, resource, secretNamespacing, action)
+		for _, want := range findRevocationWants(resource, secretNamespacing) {
+			action(want.Secret)
+			revocationWants = append(revocationWants, want)
+		}
 	}
 
 	// We _always_ have an implicit references to the cloud-connec-token secret...
@@ -296,6 +302,19 @@ func ReconcileSecrets(ctx context.Context, sh *SnapshotHolder) error {
 		}
 	}
 
+	// Without a watch on Secrets, the K8sSecrets are only the ones we've asked for by name. (The
+	// ones in FSSecrets win anyway, so there's no point asking for those.)
+	if sh.scopedSecrets != nil {
+		k8sRefs := make(map[snapshotTypes.SecretRef]bool, len(refs))
+		for ref := range refs {
+			if _, found := sh.k8sSnapshot.FSSecrets[ref]; !found {
+				k8sRefs[ref] = true
+			}
+		}
+		sh.scopedSecrets.reconcile(ctx, k8sRefs)
+		sh.k8sSnapshot.K8sSecrets = sh.scopedSecrets.secrets()
+	}
+
 	// OK! After all that, go copy all the matching secrets from FSSecrets and
 	// K8sSecrets to Secrets.
 	//
@@ -323,6 +342,20 @@ func ReconcileSecrets(ctx context.Context, sh *SnapshotHolder) error {
 			checkSecret(ctx, sh, "K8sSecret", ref, secret)
 		}
 	}
+
+	// Now that we have the certificates, tell the revocationWatcher what to staple them to.
+	for i, want := range revocationWants {
+		if want.CRLURL != "" {
+			continue
+		}
+		for _, secret := range sh.k8sSnapshot.Secrets {
+			if secret.GetNamespace() == want.Cert.Namespace && secret.GetName() == want.Cert.Name {
+				revocationWants[i].Chain = secret.Data[v1.TLSCertKey]
+				break
+			}
+		}
+	}
+	sh.revocation.reconcile(ctx, revocationWants)
 	return nil
 }
 
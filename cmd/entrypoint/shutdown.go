@@ -0,0 +1,502 @@
+package entrypoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/datawire/dlib/dcontext"
+	"github.com/datawire/dlib/dgroup"
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/ambex"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+)
+
+// Shutdown sequencing: when we're told to stop, the subsystems don't all stop at once. Instead the
+// shutdown plan goes through its phases in order, each with a deadline of its own:
+//
+//	config     stop accepting configuration changes (freeze pushes to Envoy)
+//	readiness  fail the readiness check, and give the endpoints controller time to notice
+//	envoy      drain Envoy's listeners, then stop Envoy and ambex
+//	agent      stop the servers that the agent pulls from
+//	watchers   stop the watchers, diagd, and everything else
+//	health     stop the health check server, last, so the readiness check fails until the end
+//
+// Each subsystem is started in a phase, and its context is only canceled when its phase starts.
+// A phase that runs out of time logs what's still running and moves on; whatever's left gets
+// canceled when the group gives up. The deadline for phase P is AMBASSADOR_SHUTDOWN_P_SECONDS.
+//
+// The plan's progress is logged, and served on /ambassador/v0/shutdown. A preStop hook can get the
+// readiness and envoy phases going before we're told to stop: see prestop.go.
+
+const (
+	shutdownConfig    = "config"
+	shutdownReadiness = "readiness"
+	shutdownEnvoy     = "envoy"
+	shutdownAgent     = "agent"
+	shutdownWatchers  = "watchers"
+	shutdownHealth    = "health"
+)
+
+// shutdownPhaseDefaults are the phases, in order, with their default deadlines in seconds.
+var shutdownPhaseDefaults = []struct {
+	name     string
+	deadline int
+}{
+	{shutdownConfig, 1},
+	{shutdownReadiness, 5},
+	{shutdownEnvoy, 15},
+	{shutdownAgent, 5},
+	{shutdownWatchers, 10},
+	{shutdownHealth, 5},
+}
+
+// GetShutdownDeadline returns how long the named shutdown phase may take, from
+// AMBASSADOR_SHUTDOWN_<PHASE>_SECONDS.
+func GetShutdownDeadline(phase string) time.Duration {
+	def := 0
+	for _, p := range shutdownPhaseDefaults {
+		if p.name == phase {
+			def = p.deadline
+		}
+	}
+	secs, err := strconv.Atoi(env("AMBASSADOR_SHUTDOWN_"+strings.ToUpper(phase)+"_SECONDS", strconv.Itoa(def)))
+	if err != nil || secs < 0 {
+		secs = def
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// GetShutdownDrainTime returns how long the envoy phase waits for Envoy's connections to drain,
+// from AMBASSADOR_SHUTDOWN_DRAIN_SECONDS. Whatever's left of the phase's deadline after that is for
+// Envoy to exit.
+func GetShutdownDrainTime() time.Duration {
+	secs, err := strconv.Atoi(env("AMBASSADOR_SHUTDOWN_DRAIN_SECONDS", "10"))
+	if err != nil || secs < 0 {
+		secs = 10
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// The states of a shutdown phase.
+const (
+	shutdownPending  = "pending"
+	shutdownRunning  = "running"
+	shutdownDone     = "done"
+	shutdownTimedOut = "timed out"
+)
+
+// ShutdownPhaseStatus is where one phase of the shutdown plan has got to.
+type ShutdownPhaseStatus struct {
+	Name       string     `json:"name"`
+	Deadline   string     `json:"deadline"`
+	State      string     `json:"state"`
+	Started    *time.Time `json:"started,omitempty"`
+	Finished   *time.Time `json:"finished,omitempty"`
+	Subsystems []string   `json:"subsystems"`
+	// Running are the subsystems that haven't stopped yet.
+	Running []string `json:"running"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// ShutdownStatus is where the shutdown plan has got to. PreStopped is when the preStop hook
+// started draining, if it has.
+type ShutdownStatus struct {
+	ShuttingDown bool                  `json:"shutting_down"`
+	Started      *time.Time            `json:"started,omitempty"`
+	PreStopped   *time.Time            `json:"prestop_started,omitempty"`
+	Phases       []ShutdownPhaseStatus `json:"phases"`
+}
+
+type shutdownPhase struct {
+	name     string
+	deadline time.Duration
+	// action, if there is one, runs when the phase starts, before its subsystems are stopped. It
+	// must return when its context is done.
+	action func(ctx context.Context) error
+
+	stop chan struct{} // closed when the phase's subsystems should stop
+
+	// These are all protected by the plan's mu.
+	state      string
+	started    time.Time
+	finished   time.Time
+	subsystems []string
+	running    map[string]int
+	err        error
+}
+
+type shutdownPlan struct {
+	clock  clock.Clock
+	phases []*shutdownPhase
+
+	// drain drains Envoy, for the envoy phase and for preStop.
+	drain func(ctx context.Context) error
+
+	mu       sync.Mutex
+	started  time.Time
+	unready  bool
+	changed  chan struct{} // closed, and replaced, whenever a subsystem stops
+	stopping bool
+
+	// preStopped is when the preStop hook ran, and preStopDone is closed once its drain is done.
+	preStopped  time.Time
+	preStopDone chan struct{}
+}
+
+func newShutdownPlan(clk clock.Clock) *shutdownPlan {
+	p := &shutdownPlan{
+		clock:   clk,
+		changed: make(chan struct{}),
+	}
+	for _, def := range shutdownPhaseDefaults {
+		p.phases = append(p.phases, &shutdownPhase{
+			name:     def.name,
+			deadline: GetShutdownDeadline(def.name),
+			stop:     make(chan struct{}),
+			state:    shutdownPending,
+			running:  map[string]int{},
+		})
+	}
+	p.setAction(shutdownConfig, func(ctx context.Context) error {
+		ambex.FreezerFromContext(ctx).Freeze("shutting down", p.clock.Now())
+		return nil
+	})
+	p.drain = func(ctx context.Context) error {
+		return drainEnvoy(ctx, p.clock, GetEnvoyAdminURL(), GetShutdownDrainTime())
+	}
+	p.setAction(shutdownReadiness, func(ctx context.Context) error {
+		p.mu.Lock()
+		p.unready = true
+		preStopped := p.preStopped
+		p.mu.Unlock()
+		// If the preStop hook already failed readiness, its time counts towards this phase's.
+		if !preStopped.IsZero() {
+			wait := p.phase(shutdownReadiness).deadline - p.clock.Now().Sub(preStopped)
+			if wait <= 0 {
+				return nil
+			}
+			timer := p.clock.NewTimer(wait)
+			defer timer.Stop()
+			select {
+			case <-timer.C():
+			case <-ctx.Done():
+			}
+			return nil
+		}
+		<-ctx.Done()
+		return nil
+	})
+	p.setAction(shutdownEnvoy, func(ctx context.Context) error {
+		// Don't start a second drain while the preStop hook's is still going. Draining again
+		// afterwards is quick if the first one finished, and gives it more time if it didn't.
+		p.mu.Lock()
+		preStopDone := p.preStopDone
+		p.mu.Unlock()
+		if preStopDone != nil {
+			select {
+			case <-preStopDone:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		return p.drain(ctx)
+	})
+	return p
+}
+
+type shutdownPlanKey struct{}
+
+// withShutdownPlan returns a copy of ctx that carries the shutdown plan.
+func withShutdownPlan(ctx context.Context, p *shutdownPlan) context.Context {
+	return context.WithValue(ctx, shutdownPlanKey{}, p)
+}
+
+// shutdownPlanFromContext returns the context's shutdown plan, or nil if it doesn't have one.
+func shutdownPlanFromContext(ctx context.Context) *shutdownPlan {
+	p, _ := ctx.Value(shutdownPlanKey{}).(*shutdownPlan)
+	return p
+}
+
+func (p *shutdownPlan) phase(name string) *shutdownPhase {
+	for _, ph := range p.phases {
+		if ph.name == name {
+			return ph
+		}
+	}
+	panic(fmt.Sprintf("no such shutdown phase %q", name))
+}
+
+func (p *shutdownPlan) setAction(name string, action func(context.Context) error) {
+	p.phase(name).action = action
+}
+
+// Total returns how long the whole plan may take.
+func (p *shutdownPlan) Total() time.Duration {
+	var total time.Duration
+	for _, ph := range p.phases {
+		total += ph.deadline
+	}
+	return total
+}
+
+// Go runs fn in the group as the named subsystem, to be stopped in the given phase.
+func (p *shutdownPlan) Go(group *dgroup.Group, phase, name string, fn func(context.Context) error) {
+	group.Go(name, p.wrap(phase, name, fn))
+}
+
+// wrap returns a function for dgroup.Group.Go that runs fn with a context that isn't soft-canceled
+// until the phase starts. Hard cancellation still comes straight from the group.
+func (p *shutdownPlan) wrap(phase, name string, fn func(context.Context) error) func(context.Context) error {
+	ph := p.phase(phase)
+	p.mu.Lock()
+	ph.subsystems = append(ph.subsystems, name)
+	p.mu.Unlock()
+
+	return func(ctx context.Context) error {
+		p.mu.Lock()
+		ph.running[name]++
+		p.mu.Unlock()
+		defer func() {
+			p.mu.Lock()
+			ph.running[name]--
+			if ph.running[name] == 0 {
+				delete(ph.running, name)
+			}
+			close(p.changed)
+			p.changed = make(chan struct{})
+			p.mu.Unlock()
+		}()
+
+		softCtx, cancel := context.WithCancel(dcontext.WithSoftness(dcontext.HardContext(ctx)))
+		defer cancel()
+		go func() {
+			select {
+			case <-ph.stop:
+				cancel()
+			case <-softCtx.Done():
+			}
+		}()
+		return fn(softCtx)
+	}
+}
+
+// failingReadiness returns whether the readiness check should fail because we're shutting down.
+func (p *shutdownPlan) failingReadiness() bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.unready
+}
+
+// Run goes through the plan's phases, in order. It returns early if ctx is done.
+func (p *shutdownPlan) Run(ctx context.Context) {
+	p.mu.Lock()
+	if p.stopping {
+		p.mu.Unlock()
+		return
+	}
+	p.stopping = true
+	p.started = p.clock.Now()
+	p.mu.Unlock()
+
+	dlog.Infof(ctx, "Shutdown: starting, with up to %s for all %d phases", p.Total(), len(p.phases))
+	for _, ph := range p.phases {
+		if ctx.Err() != nil {
+			dlog.Warnf(ctx, "Shutdown: abandoned before phase %s", ph.name)
+			return
+		}
+		p.runPhase(ctx, ph)
+	}
+	dlog.Infof(ctx, "Shutdown: finished in %s", p.clock.Now().Sub(p.started))
+}
+
+func (p *shutdownPlan) runPhase(ctx context.Context, ph *shutdownPhase) {
+	p.mu.Lock()
+	ph.state = shutdownRunning
+	ph.started = p.clock.Now()
+	p.mu.Unlock()
+	dlog.Infof(ctx, "Shutdown: phase %s (deadline %s)", ph.name, ph.deadline)
+
+	phaseCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	deadline := p.clock.AfterFunc(ph.deadline, cancel)
+	defer deadline.Stop()
+
+	var err error
+	if ph.action != nil {
+		err = ph.action(phaseCtx)
+		if err != nil {
+			dlog.Errorf(ctx, "Shutdown: phase %s: %v", ph.name, err)
+		}
+	}
+
+	close(ph.stop)
+	var running []string
+	for {
+		p.mu.Lock()
+		running = runningNames(ph.running)
+		changed := p.changed
+		p.mu.Unlock()
+		if len(running) == 0 {
+			break
+		}
+		select {
+		case <-changed:
+			continue
+		case <-phaseCtx.Done():
+		}
+		break
+	}
+
+	p.mu.Lock()
+	ph.finished = p.clock.Now()
+	ph.err = err
+	ph.state = shutdownDone
+	if len(running) > 0 {
+		ph.state = shutdownTimedOut
+	}
+	took := ph.finished.Sub(ph.started)
+	p.mu.Unlock()
+
+	if len(running) > 0 {
+		dlog.Warnf(ctx, "Shutdown: phase %s timed out after %s, still running: %s",
+			ph.name, took, strings.Join(running, ", "))
+	} else {
+		dlog.Infof(ctx, "Shutdown: phase %s done in %s", ph.name, took)
+	}
+}
+
+func runningNames(running map[string]int) []string {
+	names := make([]string, 0, len(running))
+	for name := range running {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Status returns where the plan has got to.
+func (p *shutdownPlan) Status() ShutdownStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status := ShutdownStatus{ShuttingDown: p.stopping}
+	if p.stopping {
+		started := p.started
+		status.Started = &started
+	}
+	if !p.preStopped.IsZero() {
+		preStopped := p.preStopped
+		status.PreStopped = &preStopped
+	}
+	for _, ph := range p.phases {
+		s := ShutdownPhaseStatus{
+			Name:       ph.name,
+			Deadline:   ph.deadline.String(),
+			State:      ph.state,
+			Subsystems: append([]string{}, ph.subsystems...),
+			Running:    runningNames(ph.running),
+		}
+		if !ph.started.IsZero() {
+			started := ph.started
+			s.Started = &started
+		}
+		if !ph.finished.IsZero() {
+			finished := ph.finished
+			s.Finished = &finished
+		}
+		if ph.err != nil {
+			s.Error = ph.err.Error()
+		}
+		status.Phases = append(status.Phases, s)
+	}
+	return status
+}
+
+func handleShutdownStatus(w http.ResponseWriter, r *http.Request, p *shutdownPlan) {
+	if p == nil {
+		http.Error(w, "no shutdown plan", http.StatusNotFound)
+		return
+	}
+	bytes, err := json.MarshalIndent(p.Status(), "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(append(bytes, '\n'))
+}
+
+// drainEnvoy tells Envoy to drain its listeners gracefully, and waits, for up to drainTime, for its
+// connections to go away. If Envoy isn't answering, there's nothing to drain.
+func drainEnvoy(ctx context.Context, clk clock.Clock, adminURL string, drainTime time.Duration) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	timer := clk.AfterFunc(drainTime, cancel)
+	defer timer.Stop()
+
+	client := &http.Client{Timeout: 2 * time.Second}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, adminURL+"/drain_listeners?graceful", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		dlog.Infof(ctx, "Shutdown: not draining Envoy: %v", err)
+		return nil
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("draining Envoy's listeners: %s", resp.Status)
+	}
+
+	ticker := clk.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		conns, err := envoyConnections(ctx, client, adminURL)
+		if err == nil && conns == 0 {
+			return nil
+		}
+		select {
+		case <-ticker.C():
+		case <-ctx.Done():
+			if err == nil {
+				dlog.Infof(ctx, "Shutdown: done waiting for Envoy to drain, with %d connections left", conns)
+			}
+			return nil
+		}
+	}
+}
+
+// envoyConnections returns how many connections Envoy has open, from its server.total_connections
+// stat.
+func envoyConnections(ctx context.Context, client *http.Client, adminURL string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, adminURL+"/stats?filter=^server%5C.total_connections$", nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	// The stat comes back as "server.total_connections: N".
+	_, value, ok := strings.Cut(strings.TrimSpace(string(body)), ":")
+	if !ok {
+		return 0, fmt.Errorf("no server.total_connections in Envoy's stats")
+	}
+	return strconv.Atoi(strings.TrimSpace(value))
+}
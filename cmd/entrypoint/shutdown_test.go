@@ -0,0 +1,203 @@
+package entrypoint
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/ambex"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+)
+
+func TestShutdownPlan(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	freezer := ambex.NewFreezer()
+	ctx := ambex.WithFreezer(dlog.NewTestContext(t, false), freezer)
+
+	plan := newShutdownPlan(clk)
+	// Don't go looking for an Envoy to drain.
+	plan.setAction(shutdownEnvoy, nil)
+
+	var mu sync.Mutex
+	var stopped []string
+	subsystem := func(phase, name string, ignoreCancel <-chan struct{}) {
+		run := plan.wrap(phase, name, func(ctx context.Context) error {
+			if ignoreCancel != nil {
+				<-ignoreCancel
+			} else {
+				<-ctx.Done()
+			}
+			mu.Lock()
+			stopped = append(stopped, name)
+			mu.Unlock()
+			return nil
+		})
+		go func() { _ = run(ctx) }()
+	}
+	stuck := make(chan struct{})
+	defer close(stuck)
+	subsystem(shutdownHealth, "healthchecks", nil)
+	subsystem(shutdownWatchers, "watcher", stuck)
+	subsystem(shutdownEnvoy, "envoy", nil)
+	subsystem(shutdownAgent, "snapshot_server", nil)
+	require.Eventually(t, func() bool {
+		status := plan.Status()
+		return len(status.Phases[2].Running)+len(status.Phases[3].Running)+len(status.Phases[4].Running)+len(status.Phases[5].Running) == 4
+	}, time.Second, time.Millisecond)
+
+	assert.False(t, plan.Status().ShuttingDown)
+	assert.False(t, plan.failingReadiness())
+
+	done := make(chan struct{})
+	go func() {
+		plan.Run(ctx)
+		close(done)
+	}()
+	// Nothing stops until readiness has been failing for its whole deadline.
+	require.Eventually(t, plan.failingReadiness, time.Second, time.Millisecond)
+	assert.True(t, freezer.State().Frozen)
+	mu.Lock()
+	assert.Empty(t, stopped)
+	mu.Unlock()
+
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		case <-time.After(time.Millisecond):
+			clk.Advance(time.Second)
+		}
+	}
+
+	mu.Lock()
+	assert.Equal(t, []string{"envoy", "snapshot_server", "healthchecks"}, stopped)
+	mu.Unlock()
+
+	status := plan.Status()
+	assert.True(t, status.ShuttingDown)
+	var names, states []string
+	for _, ph := range status.Phases {
+		names = append(names, ph.Name)
+		states = append(states, ph.State)
+	}
+	assert.Equal(t, []string{"config", "readiness", "envoy", "agent", "watchers", "health"}, names)
+	assert.Equal(t, []string{"done", "done", "done", "done", "timed out", "done"}, states)
+	assert.Equal(t, []string{"watcher"}, status.Phases[4].Running)
+	assert.Equal(t, "5s", status.Phases[1].Deadline)
+}
+
+func TestShutdownPreStop(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	ctx := ambex.WithFreezer(dlog.NewTestContext(t, false), ambex.NewFreezer())
+
+	plan := newShutdownPlan(clk)
+	drained := make(chan struct{})
+	drains := 0
+	plan.drain = func(ctx context.Context) error {
+		drains++
+		select {
+		case <-drained:
+		case <-ctx.Done():
+		}
+		return nil
+	}
+
+	hook := func(wait time.Duration) <-chan bool {
+		result := make(chan bool, 1)
+		go func() { result <- plan.PreStop(ctx, wait) }()
+		return result
+	}
+	first := hook(time.Minute)
+	require.Eventually(t, plan.failingReadiness, time.Second, time.Millisecond)
+	assert.NotNil(t, plan.Status().PreStopped)
+	assert.False(t, plan.Status().ShuttingDown)
+
+	// A second hook gives up at its own deadline, without starting another drain.
+	second := hook(10 * time.Second)
+	require.Eventually(t, func() bool {
+		clk.Advance(time.Second)
+		select {
+		case ok := <-second:
+			assert.False(t, ok)
+			return true
+		default:
+			return false
+		}
+	}, time.Second, time.Millisecond)
+
+	close(drained)
+	select {
+	case ok := <-first:
+		assert.True(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("preStop didn't return once the drain was done")
+	}
+	assert.Equal(t, 1, drains)
+
+	// Once we're told to stop, readiness has already been failing long enough, so the plan
+	// goes straight on to the envoy phase, whose drain doesn't have to wait.
+	done := make(chan struct{})
+	go func() {
+		plan.Run(ctx)
+		close(done)
+	}()
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		case <-time.After(time.Millisecond):
+			clk.Advance(time.Second)
+		}
+	}
+	status := plan.Status()
+	assert.Equal(t, status.Phases[1].Started, status.Phases[1].Finished)
+	assert.Equal(t, 2, drains)
+}
+
+// Test that only the pod's own preStop hook can start the drain: anything else that can reach 8877
+// gets refused, and the plan is left alone.
+func TestShutdownReadyOnlyFromPod(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	plan := newShutdownPlan(clk)
+	plan.drain = func(ctx context.Context) error { return nil }
+
+	call := func(method, remoteAddr string, header http.Header) int {
+		// The drain carries on after the test, so it can't log to t.
+		r := httptest.NewRequest(method, "/shutdown-ready?deadline=0s", nil)
+		r.RemoteAddr = remoteAddr
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		handleShutdownReady(w, r, plan)
+		return w.Code
+	}
+
+	for _, tc := range []struct {
+		name       string
+		method     string
+		remoteAddr string
+		header     http.Header
+		want       int
+	}{
+		{"another pod", http.MethodPost, "10.0.0.5:41234", nil, http.StatusForbidden},
+		{"through Envoy", http.MethodPost, "127.0.0.1:41234", http.Header{"X-Forwarded-For": {"10.0.0.5"}}, http.StatusForbidden},
+		{"kubelet's httpGet", http.MethodGet, "10.0.0.1:41234", nil, http.StatusMethodNotAllowed},
+		{"GET from the pod", http.MethodGet, "127.0.0.1:41234", nil, http.StatusMethodNotAllowed},
+	} {
+		assert.Equal(t, tc.want, call(tc.method, tc.remoteAddr, tc.header), tc.name)
+	}
+	assert.False(t, plan.failingReadiness())
+	assert.Nil(t, plan.Status().PreStopped)
+
+	assert.Equal(t, http.StatusOK, call(http.MethodPost, "[::1]:41234", nil))
+	assert.True(t, plan.failingReadiness())
+	assert.NotNil(t, plan.Status().PreStopped)
+}
@@ -55,6 +55,8 @@ func GetAmbID(ctx context.Context, resource kates.Object) amb.AmbassadorID {
 		return r.Spec.AmbassadorID
 	case *amb.KubernetesServiceResolver:
 		return r.Spec.AmbassadorID
+	case *amb.PluginResolver:
+		return r.Spec.AmbassadorID
 	}
 
 	ann := resource.GetAnnotations()
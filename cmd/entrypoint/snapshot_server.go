@@ -10,6 +10,7 @@ import (
 
 	"github.com/datawire/dlib/dhttp"
 	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/acp"
 	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
 )
 
@@ -17,9 +18,12 @@ import (
 const ExternalSnapshotPort = 8005
 
 // expose a scrubbed version of the current snapshot outside the pod
-func externalSnapshotServer(ctx context.Context, snapshot *atomic.Value) error {
+func externalSnapshotServer(ctx context.Context, snapshot *atomic.Value, ambwatch *acp.AmbassadorWatcher) error {
+	// Outside the pod means the admin API's authentication applies, if there is any.
+	admin := adminAuthorizerFromContext(ctx)
+
 	mux := http.NewServeMux()
-	mux.HandleFunc("/snapshot-external", func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/snapshot-external", admin.Wrap(compressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		sanitizedSnap, err := sanitizeExternalSnapshot(ctx, snapshot.Load().([]byte), http.DefaultClient)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
@@ -27,7 +31,13 @@ func externalSnapshotServer(ctx context.Context, snapshot *atomic.Value) error {
 		}
 		w.Header().Set("content-type", "application/json")
 		_, _ = w.Write(sanitizedSnap)
-	})
+	}))))
+	// The agent picks up traffic rollups here too.
+	mux.Handle("/traffic-external", admin.Wrap(http.HandlerFunc(handleTrafficRollups)))
+	// And upstream health.
+	mux.Handle("/upstream-health-external", admin.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleUpstreamHealth(w, r, ambwatch)
+	})))
 
 	s := &dhttp.ServerConfig{
 		Handler: mux,
@@ -38,8 +48,14 @@ func externalSnapshotServer(ctx context.Context, snapshot *atomic.Value) error {
 
 func snapshotServer(ctx context.Context, snapshot *atomic.Value) error {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/snapshot", func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/snapshot", compressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/json")
 		_, _ = w.Write(snapshot.Load().([]byte))
+	})))
+	// diagd sends its status updates here, for the status writer.
+	writer := statusWriterFromContext(ctx)
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		handleStatusUpdates(w, r, writer)
 	})
 
 	s := &dhttp.ServerConfig{
@@ -22,3 +22,11 @@ type IstioCertSource interface {
 type IstioCertWatcher interface {
 	Changed() <-chan IstioCertUpdate
 }
+
+type SPIFFESource interface {
+	Watch(ctx context.Context) (SPIFFEWatcher, error)
+}
+
+type SPIFFEWatcher interface {
+	Changed() <-chan SPIFFEUpdate
+}
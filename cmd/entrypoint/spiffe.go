@@ -0,0 +1,157 @@
+package entrypoint
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+	"github.com/emissary-ingress/emissary/v3/pkg/spiffe"
+)
+
+// If AMBASSADOR_SPIFFE_ENDPOINT_SOCKET points at a SPIFFE Workload API socket (e.g. the one the
+// SPIRE agent provides), we fetch our X.509 SVID from it and keep it up to date as it rotates.
+// The SVID and the trust bundles come out as Secrets in the Ambassador namespace, which the
+// SPIFFESource hands to the watcher, and which go into the snapshot as FSSecrets:
+//
+//   - "spiffe-svid" holds our SVID (the one named by AMBASSADOR_SPIFFE_ID, or the default one).
+//   - "spiffe-bundle-<trust-domain>" holds the CA bundle for each trust domain we know about,
+//     with dots in the trust domain replaced by dashes.
+//
+// UpstreamTLSPolicies with a `spiffe` section use these to do identity-based mTLS to upstreams.
+
+const spiffeSVIDSecretName = "spiffe-svid"
+
+// GetSPIFFEEndpointSocket returns the SPIFFE Workload API socket to fetch SVIDs from, or "" if
+// SPIFFE integration is disabled.
+func GetSPIFFEEndpointSocket() string {
+	return env("AMBASSADOR_SPIFFE_ENDPOINT_SOCKET", "")
+}
+
+// spiffeBundleSecretName returns the name of the Secret holding the CA bundle for a trust domain.
+// Trust domains are DNS-ish names, but Secret names can't have dots in them without looking
+// like they're namespaced.
+func spiffeBundleSecretName(trustDomain string) string {
+	return "spiffe-bundle-" + strings.ReplaceAll(trustDomain, ".", "-")
+}
+
+// spiffeIDPrefix returns the prefix that every SPIFFE ID in a trust domain starts with.
+func spiffeIDPrefix(trustDomain string) string {
+	return "spiffe://" + trustDomain + "/"
+}
+
+// spiffeSecrets turns an X509Context into the Secrets described above.
+func spiffeSecrets(x509Context *spiffe.X509Context, svidID, namespace string) ([]*kates.Secret, bool) {
+	svid, ok := x509Context.SVID(svidID)
+	if !ok {
+		return nil, false
+	}
+
+	secret := func(name string, data map[string][]byte) *kates.Secret {
+		return &kates.Secret{
+			TypeMeta: kates.TypeMeta{
+				APIVersion: "v1",
+				Kind:       "Secret",
+			},
+			ObjectMeta: kates.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+			},
+			Type: kates.SecretTypeTLS,
+			Data: data,
+		}
+	}
+
+	secrets := []*kates.Secret{
+		secret(spiffeSVIDSecretName, map[string][]byte{
+			"tls.crt": svid.CertChainPEM,
+			"tls.key": svid.KeyPEM,
+		}),
+	}
+	trustDomains := make([]string, 0, len(x509Context.Bundles))
+	for trustDomain := range x509Context.Bundles {
+		trustDomains = append(trustDomains, trustDomain)
+	}
+	sort.Strings(trustDomains)
+	for _, trustDomain := range trustDomains {
+		secrets = append(secrets, secret(spiffeBundleSecretName(trustDomain), map[string][]byte{
+			"tls.crt": x509Context.Bundles[trustDomain],
+		}))
+	}
+	return secrets, true
+}
+
+// SPIFFEUpdate is everything the Workload API last gave us, as Secrets. Each one replaces the
+// one before it, so a trust domain that we stop federating with just isn't in the next one.
+type SPIFFEUpdate struct {
+	Secrets []*kates.Secret
+}
+
+// spiffeSource implements SPIFFESource: its Watch() method returns a spiffeWatcher, which
+// implements SPIFFEWatcher in turn.
+type spiffeSource struct {
+}
+
+type spiffeWatcher struct {
+	updateChannel chan SPIFFEUpdate
+}
+
+func newSPIFFESource() SPIFFESource {
+	return &spiffeSource{}
+}
+
+// Watch streams SVIDs from the Workload API, if AMBASSADOR_SPIFFE_ENDPOINT_SOCKET is set. If it
+// isn't, there will never be any updates on the update channel.
+func (src *spiffeSource) Watch(ctx context.Context) (SPIFFEWatcher, error) {
+	updates := make(chan SPIFFEUpdate)
+	if socket := GetSPIFFEEndpointSocket(); socket != "" {
+		go watchSPIFFE(ctx, socket, updates)
+	}
+	return &spiffeWatcher{updateChannel: updates}, nil
+}
+
+// Changed returns the channel where SPIFFE updates will appear.
+func (w *spiffeWatcher) Changed() <-chan SPIFFEUpdate {
+	return w.updateChannel
+}
+
+// watchSPIFFE streams SVIDs from the Workload API, and posts them to updates as Secrets until
+// ctx is canceled.
+func watchSPIFFE(ctx context.Context, socket string, updates chan<- SPIFFEUpdate) {
+	svidID := env("AMBASSADOR_SPIFFE_ID", "")
+	namespace := GetAmbassadorNamespace()
+
+	err := spiffe.WatchX509Context(ctx, socket, func(ctx context.Context, x509Context *spiffe.X509Context) {
+		secrets, ok := spiffeSecrets(x509Context, svidID, namespace)
+		if !ok {
+			dlog.Errorf(ctx, "SPIFFE: Workload API did not return SVID %q", svidID)
+			return
+		}
+		dlog.Infof(ctx, "SPIFFE: received SVID with %d trust bundle(s)", len(secrets)-1)
+
+		select {
+		case updates <- SPIFFEUpdate{Secrets: secrets}:
+		case <-ctx.Done():
+		}
+	})
+	if err != nil {
+		dlog.Errorf(ctx, "SPIFFE: %v", err)
+	}
+}
+
+// spiffeUpdate replaces the SPIFFE Secrets in fsSecrets with the ones in update. posted is the
+// set of Secrets that the last update put there, and comes back as the set that this one did.
+func spiffeUpdate(fsSecrets map[snapshotTypes.SecretRef]*kates.Secret, posted map[snapshotTypes.SecretRef]bool, update SPIFFEUpdate) map[snapshotTypes.SecretRef]bool {
+	for ref := range posted {
+		delete(fsSecrets, ref)
+	}
+	current := make(map[snapshotTypes.SecretRef]bool, len(update.Secrets))
+	for _, secret := range update.Secrets {
+		ref := snapshotTypes.SecretRef{Name: secret.GetName(), Namespace: secret.GetNamespace()}
+		fsSecrets[ref] = secret
+		current[ref] = true
+	}
+	return current
+}
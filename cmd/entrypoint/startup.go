@@ -0,0 +1,345 @@
+package entrypoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/acp"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+)
+
+// Startup phases: booting goes through these phases, in order, each with a timeout of its own:
+//
+//	admin_port      the health check server is listening on 8877
+//	watchers        the watcher is running
+//	first_snapshot  the watcher has put together its first complete snapshot and sent it to diagd
+//	diagd           diagd has processed it, and written Envoy's configuration
+//	envoy           Envoy answers its health checks
+//	started         Ambassador is ready
+//
+// Much of booting happens at once, so a phase can be done before it starts: then it's done as soon
+// as it starts. A phase that takes longer than its timeout is logged, with what it's waiting for,
+// and keeps waiting, unless AMBASSADOR_STARTUP_FAIL_ON_TIMEOUT says to give up, so that Kubernetes
+// restarts the pod. The timeout for phase P is AMBASSADOR_STARTUP_P_SECONDS; 0 means none.
+//
+// The plan's progress is logged, and served on /ambassador/v0/startup, and until Ambassador has
+// started, the health checks say which phase it's stuck in. During the envoy and started phases,
+// the plan checks on Envoy itself every second, so that they finish even without probes to do it.
+
+const (
+	startupAdminPort     = "admin_port"
+	startupWatchers      = "watchers"
+	startupFirstSnapshot = "first_snapshot"
+	startupDiagd         = "diagd"
+	startupEnvoy         = "envoy"
+	startupStarted       = "started"
+)
+
+// startupPhaseDefaults are the phases, in order, with what each waits for and their default
+// timeouts in seconds.
+var startupPhaseDefaults = []struct {
+	name       string
+	waitingFor string
+	timeout    int
+}{
+	{startupAdminPort, "the health check server to listen", 30},
+	{startupWatchers, "the watcher to start", 30},
+	{startupFirstSnapshot, "the watcher's first complete snapshot", 300},
+	{startupDiagd, "diagd to process the first snapshot", 600},
+	{startupEnvoy, "Envoy to answer its health checks", 60},
+	{startupStarted, "Ambassador to be ready", 60},
+}
+
+// GetStartupTimeout returns how long the named startup phase may take, from
+// AMBASSADOR_STARTUP_<PHASE>_SECONDS. Zero means there's no limit.
+func GetStartupTimeout(phase string) time.Duration {
+	def := 0
+	for _, p := range startupPhaseDefaults {
+		if p.name == phase {
+			def = p.timeout
+		}
+	}
+	secs, err := strconv.Atoi(env("AMBASSADOR_STARTUP_"+strings.ToUpper(phase)+"_SECONDS", strconv.Itoa(def)))
+	if err != nil || secs < 0 {
+		secs = def
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// GetStartupFailOnTimeout returns whether a startup phase that times out stops Ambassador, from
+// AMBASSADOR_STARTUP_FAIL_ON_TIMEOUT.
+func GetStartupFailOnTimeout() bool {
+	return envbool("AMBASSADOR_STARTUP_FAIL_ON_TIMEOUT")
+}
+
+// The states of a startup phase.
+const (
+	startupPending  = "pending"
+	startupRunning  = "running"
+	startupTimedOut = "timed out"
+	startupDone     = "done"
+)
+
+// StartupPhaseStatus is where one phase of booting has got to. TimedOut says whether it took
+// longer than its timeout, even if it's done now.
+type StartupPhaseStatus struct {
+	Name       string     `json:"name"`
+	WaitingFor string     `json:"waiting_for"`
+	Timeout    string     `json:"timeout"`
+	State      string     `json:"state"`
+	TimedOut   bool       `json:"timed_out"`
+	Started    *time.Time `json:"started,omitempty"`
+	Finished   *time.Time `json:"finished,omitempty"`
+}
+
+// StartupStatus is where booting has got to. Phase is the phase it's in, if it hasn't started
+// yet.
+type StartupStatus struct {
+	Started  bool                 `json:"started"`
+	Boot     time.Time            `json:"boot"`
+	Finished *time.Time           `json:"finished,omitempty"`
+	Phase    string               `json:"phase,omitempty"`
+	Phases   []StartupPhaseStatus `json:"phases"`
+}
+
+type startupPhase struct {
+	name       string
+	waitingFor string
+	timeout    time.Duration
+
+	// These are all protected by the plan's mu. reached is when whatever the phase waits for
+	// happened, which can be before the phase started.
+	state    string
+	reached  time.Time
+	started  time.Time
+	finished time.Time
+	timedOut bool
+}
+
+type startupPlan struct {
+	clock  clock.Clock
+	boot   time.Time
+	phases []*startupPhase
+
+	mu      sync.Mutex
+	changed chan struct{} // closed, and replaced, whenever a phase is reached
+}
+
+func newStartupPlan(clk clock.Clock) *startupPlan {
+	p := &startupPlan{
+		clock:   clk,
+		boot:    clk.Now(),
+		changed: make(chan struct{}),
+	}
+	for _, def := range startupPhaseDefaults {
+		p.phases = append(p.phases, &startupPhase{
+			name:       def.name,
+			waitingFor: def.waitingFor,
+			timeout:    GetStartupTimeout(def.name),
+			state:      startupPending,
+		})
+	}
+	return p
+}
+
+type startupPlanKey struct{}
+
+// withStartupPlan returns a copy of ctx that carries the startup plan.
+func withStartupPlan(ctx context.Context, p *startupPlan) context.Context {
+	return context.WithValue(ctx, startupPlanKey{}, p)
+}
+
+// startupPlanFromContext returns the context's startup plan, or nil if it doesn't have one.
+func startupPlanFromContext(ctx context.Context) *startupPlan {
+	p, _ := ctx.Value(startupPlanKey{}).(*startupPlan)
+	return p
+}
+
+func (p *startupPlan) phase(name string) *startupPhase {
+	for _, ph := range p.phases {
+		if ph.name == name {
+			return ph
+		}
+	}
+	panic(fmt.Sprintf("no such startup phase %q", name))
+}
+
+// done notes that what the named phase waits for has happened. Only the first time counts. A nil
+// plan ignores it.
+func (p *startupPlan) done(name string) {
+	if p == nil {
+		return
+	}
+	ph := p.phase(name)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !ph.reached.IsZero() {
+		return
+	}
+	ph.reached = p.clock.Now()
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// Run goes through the phases, in order, until Ambassador has started. It returns early, with no
+// error, if ctx is done, and with an error if a phase times out and AMBASSADOR_STARTUP_FAIL_ON_TIMEOUT
+// is set.
+func (p *startupPlan) Run(ctx context.Context, ambwatch *acp.AmbassadorWatcher) error {
+	failOnTimeout := GetStartupFailOnTimeout()
+	ticker := p.clock.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for _, ph := range p.phases {
+		if err := p.runPhase(ctx, ph, ambwatch, ticker, failOnTimeout); err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+
+	dlog.Infof(ctx, "Startup: started in %s", p.clock.Now().Sub(p.boot))
+	return nil
+}
+
+func (p *startupPlan) runPhase(ctx context.Context, ph *startupPhase, ambwatch *acp.AmbassadorWatcher, ticker clock.Ticker, failOnTimeout bool) error {
+	p.mu.Lock()
+	ph.state = startupRunning
+	ph.started = p.clock.Now()
+	p.mu.Unlock()
+	dlog.Infof(ctx, "Startup: phase %s (timeout %s)", ph.name, ph.timeout)
+
+	var timeout <-chan time.Time
+	if ph.timeout > 0 {
+		timer := p.clock.NewTimer(ph.timeout)
+		defer timer.Stop()
+		timeout = timer.C()
+	}
+
+	for {
+		p.mu.Lock()
+		reached, changed := !ph.reached.IsZero(), p.changed
+		p.mu.Unlock()
+		if reached {
+			break
+		}
+
+		select {
+		case <-changed:
+		case <-ticker.C():
+			p.checkEnvoy(ctx, ph, ambwatch)
+		case <-timeout:
+			timeout = nil
+			p.mu.Lock()
+			ph.state = startupTimedOut
+			ph.timedOut = true
+			p.mu.Unlock()
+			dlog.Warnf(ctx, "Startup: phase %s has taken more than %s, waiting for %s", ph.name, ph.timeout, ph.waitingFor)
+			if failOnTimeout {
+				return fmt.Errorf("startup phase %s timed out after %s, waiting for %s", ph.name, ph.timeout, ph.waitingFor)
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+
+	p.mu.Lock()
+	ph.state = startupDone
+	ph.finished = p.clock.Now()
+	took := ph.finished.Sub(ph.started)
+	p.mu.Unlock()
+	dlog.Infof(ctx, "Startup: phase %s done in %s", ph.name, took)
+	return nil
+}
+
+// checkEnvoy asks the AmbassadorWatcher about Envoy, and whether Ambassador is ready, during the
+// phases that wait for them.
+func (p *startupPlan) checkEnvoy(ctx context.Context, ph *startupPhase, ambwatch *acp.AmbassadorWatcher) {
+	if ph.name != startupEnvoy && ph.name != startupStarted {
+		return
+	}
+	ambwatch.FetchEnvoyReady(ctx)
+	if ambwatch.EnvoyWatcher().IsAlive() {
+		p.done(startupEnvoy)
+	}
+	if ambwatch.IsReady() {
+		p.done(startupStarted)
+	}
+}
+
+// Status returns where booting has got to.
+func (p *startupPlan) Status() StartupStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status := StartupStatus{Boot: p.boot}
+	for _, ph := range p.phases {
+		s := StartupPhaseStatus{
+			Name:       ph.name,
+			WaitingFor: ph.waitingFor,
+			Timeout:    ph.timeout.String(),
+			State:      ph.state,
+			TimedOut:   ph.timedOut,
+		}
+		if !ph.started.IsZero() {
+			started := ph.started
+			s.Started = &started
+		}
+		if !ph.finished.IsZero() {
+			finished := ph.finished
+			s.Finished = &finished
+		}
+		if ph.state != startupDone && status.Phase == "" {
+			status.Phase = ph.name
+		}
+		status.Phases = append(status.Phases, s)
+	}
+	if status.Phase == "" {
+		status.Started = true
+		status.Finished = status.Phases[len(status.Phases)-1].Finished
+	}
+	return status
+}
+
+// startupHealthReason is what the health checks add to their messages while Ambassador is still
+// starting up: the phase it's in, and what that's waiting for.
+func startupHealthReason(p *startupPlan) string {
+	if p == nil {
+		return ""
+	}
+	status := p.Status()
+	if status.Started {
+		return ""
+	}
+	for _, ph := range status.Phases {
+		if ph.Name != status.Phase {
+			continue
+		}
+		reason := fmt.Sprintf(" (starting up: phase %s, waiting for %s", ph.Name, ph.WaitingFor)
+		if ph.TimedOut {
+			reason += ", longer than its " + ph.Timeout + " timeout"
+		}
+		return reason + ")"
+	}
+	return ""
+}
+
+func handleStartupStatus(w http.ResponseWriter, r *http.Request, p *startupPlan) {
+	if p == nil {
+		http.Error(w, "no startup plan", http.StatusNotFound)
+		return
+	}
+	bytes, err := json.MarshalIndent(p.Status(), "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(append(bytes, '\n'))
+}
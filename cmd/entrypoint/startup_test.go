@@ -0,0 +1,168 @@
+package entrypoint
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/acp"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+)
+
+func TestStartupPlan(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	clk := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	var envoyReady atomic.Bool
+	ew := acp.NewEnvoyWatcher()
+	ew.SetReadyCheck(func(context.Context) (*acp.EnvoyFetcherResponse, error) {
+		if envoyReady.Load() {
+			return &acp.EnvoyFetcherResponse{StatusCode: http.StatusOK}, nil
+		}
+		return &acp.EnvoyFetcherResponse{StatusCode: http.StatusServiceUnavailable}, nil
+	})
+	ew.SetStateCheck(func(context.Context) (*acp.EnvoyFetcherResponse, error) {
+		return &acp.EnvoyFetcherResponse{StatusCode: http.StatusServiceUnavailable}, nil
+	})
+	ambwatch := acp.NewAmbassadorWatcher(ew, acp.NewDiagdWatcher())
+
+	plan := newStartupPlan(clk)
+	assert.Equal(t, " (starting up: phase admin_port, waiting for the health check server to listen)", startupHealthReason(plan))
+
+	// The admin port and the watchers can be done before the plan gets to them.
+	plan.done(startupAdminPort)
+	plan.done(startupWatchers)
+
+	done := make(chan error, 1)
+	go func() { done <- plan.Run(ctx, ambwatch) }()
+
+	inPhase := func(phase string) func() bool {
+		return func() bool {
+			status := plan.Status()
+			return status.Phase == phase && status.Phases[indexOfStartupPhase(phase)].State != startupPending
+		}
+	}
+	require.Eventually(t, inPhase(startupFirstSnapshot), time.Second, time.Millisecond)
+	// The ticker, and the phase's timeout.
+	require.Eventually(t, func() bool { return clk.Timers() == 2 }, time.Second, time.Millisecond)
+
+	// Taking too long gets noted, but doesn't stop anything.
+	clk.Advance(5 * time.Minute)
+	require.Eventually(t, func() bool {
+		return plan.Status().Phases[2].State == startupTimedOut
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, " (starting up: phase first_snapshot, waiting for the watcher's first complete snapshot, longer than its 5m0s timeout)", startupHealthReason(plan))
+
+	// Doing it again doesn't count again.
+	plan.done(startupFirstSnapshot)
+	clk.Advance(time.Second)
+	plan.done(startupFirstSnapshot)
+	require.Eventually(t, inPhase(startupDiagd), time.Second, time.Millisecond)
+	status := plan.Status()
+	assert.True(t, status.Phases[2].TimedOut)
+	assert.Equal(t, startupDone, status.Phases[2].State)
+	assert.NotNil(t, status.Phases[2].Finished)
+
+	// Envoy isn't answering, so the envoy phase waits, checking on it every second, until it does.
+	ambwatch.NoteSnapshotSent()
+	ambwatch.NoteSnapshotProcessed()
+	plan.done(startupDiagd)
+	require.Eventually(t, inPhase(startupEnvoy), time.Second, time.Millisecond)
+	clk.Advance(time.Second)
+	assert.Equal(t, startupEnvoy, plan.Status().Phase)
+
+	envoyReady.Store(true)
+	require.Eventually(t, func() bool {
+		clk.Advance(time.Second)
+		return plan.Status().Started
+	}, time.Second, time.Millisecond)
+	require.NoError(t, <-done)
+
+	status = plan.Status()
+	assert.Empty(t, status.Phase)
+	assert.NotNil(t, status.Finished)
+	for _, ph := range status.Phases {
+		assert.Equal(t, startupDone, ph.State, ph.Name)
+	}
+	assert.Empty(t, startupHealthReason(plan))
+
+	rec := httptest.NewRecorder()
+	handleStartupStatus(rec, httptest.NewRequest(http.MethodGet, "/ambassador/v0/startup", nil), plan)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var served StartupStatus
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &served))
+	assert.True(t, served.Started)
+	assert.Len(t, served.Phases, len(startupPhaseDefaults))
+}
+
+func TestStartupPlanFailOnTimeout(t *testing.T) {
+	t.Setenv("AMBASSADOR_STARTUP_FAIL_ON_TIMEOUT", "true")
+	t.Setenv("AMBASSADOR_STARTUP_ADMIN_PORT_SECONDS", "10")
+	ctx := dlog.NewTestContext(t, false)
+	clk := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	plan := newStartupPlan(clk)
+	done := make(chan error, 1)
+	go func() { done <- plan.Run(ctx, acp.NewAmbassadorWatcher(acp.NewEnvoyWatcher(), acp.NewDiagdWatcher())) }()
+	require.Eventually(t, func() bool { return clk.Timers() == 2 }, time.Second, time.Millisecond)
+
+	clk.Advance(10 * time.Second)
+	err := <-done
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "startup phase admin_port timed out after 10s")
+	assert.Equal(t, startupTimedOut, plan.Status().Phases[0].State)
+}
+
+func TestStartupTimeouts(t *testing.T) {
+	assert.Equal(t, 5*time.Minute, GetStartupTimeout(startupFirstSnapshot))
+
+	t.Setenv("AMBASSADOR_STARTUP_DIAGD_SECONDS", "0")
+	assert.Equal(t, time.Duration(0), GetStartupTimeout(startupDiagd))
+
+	// Nonsense gets the default.
+	t.Setenv("AMBASSADOR_STARTUP_ENVOY_SECONDS", "-3")
+	assert.Equal(t, time.Minute, GetStartupTimeout(startupEnvoy))
+
+	// A phase with no timeout never times out.
+	clk := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	plan := newStartupPlan(clk)
+	plan.done(startupAdminPort)
+	plan.done(startupWatchers)
+	plan.done(startupFirstSnapshot)
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	done := make(chan error, 1)
+	go func() { done <- plan.Run(ctx, acp.NewAmbassadorWatcher(acp.NewEnvoyWatcher(), acp.NewDiagdWatcher())) }()
+	require.Eventually(t, func() bool { return plan.Status().Phases[3].State == startupRunning }, time.Second, time.Millisecond)
+	clk.Advance(time.Hour)
+	assert.False(t, plan.Status().Phases[3].TimedOut)
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestStartupPlanNil(t *testing.T) {
+	var plan *startupPlan
+	plan.done(startupAdminPort)
+	assert.Nil(t, startupPlanFromContext(context.Background()))
+	assert.Empty(t, startupHealthReason(plan))
+
+	rec := httptest.NewRecorder()
+	handleStartupStatus(rec, httptest.NewRequest(http.MethodGet, "/ambassador/v0/startup", nil), plan)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func indexOfStartupPhase(name string) int {
+	for i, p := range startupPhaseDefaults {
+		if p.name == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,171 @@
+package entrypoint
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	"github.com/emissary-ingress/emissary/v3/pkg/statuswriter"
+)
+
+// Every status update for a Kubernetes resource goes through the status writer (see
+// pkg/statuswriter), which coalesces them, and writes them in rate-limited batches. diagd POSTs
+// what it has to say about Mappings, Ingresses, and so on to /status on the snapshot server, as a
+// JSON list of {"kind", "name", "namespace", "status"}; if that doesn't work it goes back to
+// running kubestatus for each of them, the way it used to.
+
+// GetStatusWriteQPS returns how many status updates a second to make, from AMBASSADOR_STATUS_QPS.
+func GetStatusWriteQPS() float64 {
+	qps, err := strconv.ParseFloat(env("AMBASSADOR_STATUS_QPS", "5"), 64)
+	if err != nil || qps <= 0 {
+		qps = statuswriter.DefaultQPS
+	}
+	return qps
+}
+
+// GetStatusWriteBurst returns the most status updates to make at once, from
+// AMBASSADOR_STATUS_BURST.
+func GetStatusWriteBurst() int {
+	burst, err := strconv.Atoi(env("AMBASSADOR_STATUS_BURST", "20"))
+	if err != nil || burst < 1 {
+		burst = statuswriter.DefaultBurst
+	}
+	return burst
+}
+
+// newStatusWriter returns the status writer, or nil if there's no talking to the API server.
+func newStatusWriter(ctx context.Context) *statuswriter.Writer {
+	client, err := kates.NewClient(kates.ClientConfig{})
+	if err != nil {
+		dlog.Errorf(ctx, "Status writer: %v; diagd will write status updates itself", err)
+		return nil
+	}
+	return statuswriter.New(kubeStatusClient{client}, clock.FromContext(ctx), statuswriter.Config{
+		QPS:   GetStatusWriteQPS(),
+		Burst: GetStatusWriteBurst(),
+	})
+}
+
+type statusWriterKey struct{}
+
+// withStatusWriter returns a copy of ctx that carries the status writer.
+func withStatusWriter(ctx context.Context, w *statuswriter.Writer) context.Context {
+	return context.WithValue(ctx, statusWriterKey{}, w)
+}
+
+// statusWriterFromContext returns the status writer, or nil if there isn't one.
+func statusWriterFromContext(ctx context.Context) *statuswriter.Writer {
+	w, _ := ctx.Value(statusWriterKey{}).(*statuswriter.Writer)
+	return w
+}
+
+// kubeStatusClient writes statuses with kates, the way kubestatus does.
+type kubeStatusClient struct {
+	client *kates.Client
+}
+
+func (c kubeStatusClient) WriteStatus(ctx context.Context, key statuswriter.Key, status json.RawMessage) error {
+	var decoded interface{}
+	if err := json.Unmarshal(status, &decoded); err != nil {
+		return err
+	}
+
+	obj := kates.NewUnstructured(key.Kind, "")
+	obj.SetName(key.Name)
+	obj.SetNamespace(key.Namespace)
+	err := c.client.Get(ctx, obj, obj)
+	if err == nil {
+		obj.Object["status"] = decoded
+		err = c.client.UpdateStatus(ctx, obj, nil)
+	}
+	switch {
+	case err == nil:
+		return nil
+	case kates.IsConflict(err):
+		return fmt.Errorf("%w: %v", statuswriter.ErrConflict, err)
+	case kates.IsNotFound(err):
+		return fmt.Errorf("%w: %v", statuswriter.ErrNotFound, err)
+	default:
+		return err
+	}
+}
+
+// statusUpdate is one of the updates POSTed to /status.
+type statusUpdate struct {
+	Kind      string          `json:"kind"`
+	Name      string          `json:"name"`
+	Namespace string          `json:"namespace"`
+	Status    json.RawMessage `json:"status"`
+}
+
+// handleStatusUpdates queues the updates POSTed to /status with the status writer.
+func handleStatusUpdates(w http.ResponseWriter, r *http.Request, writer *statuswriter.Writer) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "status updates must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+	if writer == nil {
+		http.Error(w, "no status writer", http.StatusServiceUnavailable)
+		return
+	}
+
+	var updates []statusUpdate
+	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
+		http.Error(w, fmt.Sprintf("bad status updates: %v", err), http.StatusBadRequest)
+		return
+	}
+	queued := make([]statuswriter.Update, 0, len(updates))
+	for _, u := range updates {
+		if u.Kind == "" || u.Name == "" || len(u.Status) == 0 {
+			http.Error(w, fmt.Sprintf("status update for %s %q needs a kind, a name, and a status", u.Kind, u.Name), http.StatusBadRequest)
+			return
+		}
+		queued = append(queued, statuswriter.Update{
+			Key:    statuswriter.Key{Kind: u.Kind, Namespace: u.Namespace, Name: u.Name},
+			Status: u.Status,
+		})
+	}
+	writer.Enqueue(queued...)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// statusWriterMetrics renders what the status writer has done in the Prometheus text format, to add
+// to what diagd serves on /metrics.
+func statusWriterMetrics(writer *statuswriter.Writer) []byte {
+	if writer == nil {
+		return nil
+	}
+	stats := writer.Stats()
+
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "# HELP ambassador_status_queue_depth Resources with a status update waiting to be written.")
+	fmt.Fprintln(&buf, "# TYPE ambassador_status_queue_depth gauge")
+	fmt.Fprintf(&buf, "ambassador_status_queue_depth %d\n", stats.Queued)
+	fmt.Fprintln(&buf, "# HELP ambassador_status_writes_total Status updates written to the API server, by result.")
+	fmt.Fprintln(&buf, "# TYPE ambassador_status_writes_total counter")
+	for _, result := range []struct {
+		name  string
+		count uint64
+	}{
+		{"ok", stats.Written},
+		{"conflict", stats.Conflicts},
+		{"not_found", stats.NotFound},
+		{"error", stats.Errors},
+	} {
+		fmt.Fprintf(&buf, "ambassador_status_writes_total{result=%q} %d\n", result.name, result.count)
+	}
+	fmt.Fprintln(&buf, "# HELP ambassador_status_skipped_total Status updates that didn't need writing, by reason.")
+	fmt.Fprintln(&buf, "# TYPE ambassador_status_skipped_total counter")
+	fmt.Fprintf(&buf, "ambassador_status_skipped_total{reason=\"coalesced\"} %d\n", stats.Coalesced)
+	fmt.Fprintf(&buf, "ambassador_status_skipped_total{reason=\"unchanged\"} %d\n", stats.Unchanged)
+	fmt.Fprintln(&buf, "# HELP ambassador_status_dropped_total Status updates given up on after too many failed writes.")
+	fmt.Fprintln(&buf, "# TYPE ambassador_status_dropped_total counter")
+	fmt.Fprintf(&buf, "ambassador_status_dropped_total %d\n", stats.Dropped)
+	return buf.Bytes()
+}
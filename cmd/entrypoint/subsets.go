@@ -0,0 +1,81 @@
+package entrypoint
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+)
+
+// Subset routing: a Mapping with `subset_labels` only goes to the endpoints of its service whose
+// Pods have all of those labels, so that (say) a Mapping with a header match can send requests to
+// version=v2 without a Service just for v2. Envoy does the picking (see V3Cluster and V3Route);
+// all we have to do is tell it the labels of each endpoint's Pod. EndpointSlices say which Pod is
+// behind each endpoint, so with AMBASSADOR_SUBSET_ROUTING set we watch EndpointSlices and Pods.
+//
+// Only the label keys that some Mapping to the service actually uses get passed along, so that
+// the rest of a Pod's labels changing doesn't churn EDS.
+
+// IsSubsetRoutingEnabled reflects AMBASSADOR_SUBSET_ROUTING.
+func IsSubsetRoutingEnabled() bool {
+	enabled, _ := strconv.ParseBool(env("AMBASSADOR_SUBSET_ROUTING", "false"))
+	return enabled
+}
+
+// endpointLabels maps "namespace:service" to the labels of the Pod behind each of the Service's
+// endpoint IPs, keeping only the keys in subsetKeys for the Service. Services that no Mapping
+// picks subsets of are left out.
+func endpointLabels(slices []*kates.EndpointSlice, pods []*kates.Pod, subsetKeys map[string]map[string]bool) map[string]map[string]map[string]string {
+	if len(subsetKeys) == 0 {
+		return nil
+	}
+
+	podLabels := map[string]map[string]string{}
+	for _, pod := range pods {
+		podLabels[key(pod)] = pod.GetLabels()
+	}
+
+	result := map[string]map[string]map[string]string{}
+	for _, slice := range slices {
+		svc := slice.GetLabels()[kates.LabelServiceName]
+		k := slice.GetNamespace() + ":" + svc
+		keys := subsetKeys[k]
+		if svc == "" || len(keys) == 0 {
+			continue
+		}
+		for _, ep := range slice.Endpoints {
+			ref := ep.TargetRef
+			if ref == nil || ref.Kind != "Pod" {
+				continue
+			}
+			labels := podLabels[ref.Namespace+":"+ref.Name]
+			picked := map[string]string{}
+			for labelKey := range keys {
+				if v, ok := labels[labelKey]; ok {
+					picked[labelKey] = v
+				}
+			}
+			if len(picked) == 0 {
+				continue
+			}
+			if result[k] == nil {
+				result[k] = map[string]map[string]string{}
+			}
+			for _, addr := range ep.Addresses {
+				result[k][addr] = picked
+			}
+		}
+	}
+	return result
+}
+
+// hasSubsetsIn returns whether Mappings pick subsets of any Service in namespace, which is the
+// only time a Pod's labels changing matters.
+func (eri *endpointRoutingInfo) hasSubsetsIn(namespace string) bool {
+	for k := range eri.subsetKeys {
+		if ns, _, _ := strings.Cut(k, ":"); ns == namespace {
+			return true
+		}
+	}
+	return false
+}
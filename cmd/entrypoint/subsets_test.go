@@ -0,0 +1,56 @@
+package entrypoint
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+)
+
+func TestEndpointLabels(t *testing.T) {
+	pod := func(name string, labels map[string]string) *kates.Pod {
+		p := &kates.Pod{}
+		p.SetNamespace("default")
+		p.SetName(name)
+		p.SetLabels(labels)
+		return p
+	}
+	endpoint := func(ip, podName string) kates.EndpointSliceEndpoint {
+		return kates.EndpointSliceEndpoint{
+			Addresses: []string{ip},
+			TargetRef: &corev1.ObjectReference{Kind: "Pod", Namespace: "default", Name: podName},
+		}
+	}
+	slice := &kates.EndpointSlice{Endpoints: []kates.EndpointSliceEndpoint{
+		endpoint("10.0.0.1", "foo-v1"),
+		endpoint("10.0.0.2", "foo-v2"),
+		endpoint("10.0.0.3", "foo-unlabeled"),
+	}}
+	slice.SetNamespace("default")
+	slice.SetName("foo-abcde")
+	slice.SetLabels(map[string]string{kates.LabelServiceName: "foo"})
+	slices := []*kates.EndpointSlice{slice}
+	pods := []*kates.Pod{
+		pod("foo-v1", map[string]string{"app": "foo", "version": "v1", "pod-template-hash": "abc"}),
+		pod("foo-v2", map[string]string{"app": "foo", "version": "v2", "pod-template-hash": "def"}),
+		pod("foo-unlabeled", map[string]string{"app": "foo"}),
+	}
+
+	// Only the keys that Mappings use come through.
+	assert.Equal(t, map[string]map[string]map[string]string{
+		"default:foo": {
+			"10.0.0.1": {"version": "v1"},
+			"10.0.0.2": {"version": "v2"},
+		},
+	}, endpointLabels(slices, pods, map[string]map[string]bool{"default:foo": {"version": true}}))
+
+	// Nothing to do for Services that no Mapping picks subsets of.
+	assert.Empty(t, endpointLabels(slices, pods, map[string]map[string]bool{"default:bar": {"version": true}}))
+	assert.Empty(t, endpointLabels(slices, pods, nil))
+
+	eri := endpointRoutingInfo{subsetKeys: map[string]map[string]bool{"default:foo": {"version": true}}}
+	assert.True(t, eri.hasSubsetsIn("default"))
+	assert.False(t, eri.hasSubsetsIn("other"))
+}
@@ -0,0 +1,147 @@
+package entrypoint
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/datawire/dlib/derror"
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/eventbus"
+)
+
+// Panic isolation: the subsystems that run in-process (the watcher, ambex, the snapshot and health
+// check servers) each run under supervise, so that a panic in one of them restarts just that
+// subsystem, with backoff, rather than taking down the whole group, and Envoy with it. Every panic
+// is logged with its stack, counted in /metrics as ambassador_subsystem_panics_total, and published
+// on the event bus as a HealthEvent (as is the restart).
+//
+// A subsystem that keeps panicking as soon as it starts is no better off restarted forever than
+// the pod is, so after AMBASSADOR_PANIC_RESTART_LIMIT panics in a row its panic is passed on to the
+// group like any other error.
+
+// The backoff between restarts starts at minRestartBackoff, doubles with every panic up to
+// maxRestartBackoff, and goes back to minRestartBackoff (and the count of panics in a row goes
+// back to zero) once a subsystem has run for resetRestartBackoff.
+var (
+	minRestartBackoff   = 1 * time.Second
+	maxRestartBackoff   = 30 * time.Second
+	resetRestartBackoff = 1 * time.Minute
+)
+
+// GetPanicRestartLimit returns how many times in a row a subsystem may panic and be restarted, from
+// AMBASSADOR_PANIC_RESTART_LIMIT. Zero means a panic is never recovered from.
+func GetPanicRestartLimit() int {
+	limit, err := strconv.Atoi(env("AMBASSADOR_PANIC_RESTART_LIMIT", "5"))
+	if err != nil || limit < 0 {
+		limit = 5
+	}
+	return limit
+}
+
+// subsystemPanics counts the panics of every supervised subsystem.
+var subsystemPanics = newPanicCounter()
+
+type panicCounter struct {
+	mutex  sync.Mutex
+	counts map[string]int
+}
+
+func newPanicCounter() *panicCounter {
+	return &panicCounter{counts: map[string]int{}}
+}
+
+func (c *panicCounter) add(subsystem string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.counts[subsystem]++
+}
+
+// register makes sure that a subsystem shows up in the metrics before it first panics.
+func (c *panicCounter) register(subsystem string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.counts[subsystem] += 0
+}
+
+func (c *panicCounter) snapshot() map[string]int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	counts := make(map[string]int, len(c.counts))
+	for subsystem, count := range c.counts {
+		counts[subsystem] = count
+	}
+	return counts
+}
+
+// supervise returns a function for dgroup.Group.Go that runs fn, and runs it again, after a
+// backoff, whenever it panics. Whatever fn returns without panicking is returned as is.
+func supervise(name string, fn func(context.Context) error) func(context.Context) error {
+	return superviseWith(subsystemPanics, GetPanicRestartLimit(), name, fn)
+}
+
+func superviseWith(counter *panicCounter, limit int, name string, fn func(context.Context) error) func(context.Context) error {
+	counter.register(name)
+	return func(ctx context.Context) error {
+		backoff := minRestartBackoff
+		inARow := 0
+		for {
+			started := time.Now()
+			panicked, err := runRecovered(ctx, fn)
+			if !panicked {
+				return err
+			}
+			counter.add(name)
+			dlog.Errorf(ctx, "%s panicked: %+v", name, err)
+			bus := eventbus.FromContext(ctx)
+			bus.Publish(eventbus.HealthEvent{Subsystem: name, Reason: fmt.Sprintf("panicked: %v", err)})
+			if ctx.Err() != nil {
+				return err
+			}
+
+			if time.Since(started) > resetRestartBackoff {
+				backoff = minRestartBackoff
+				inARow = 0
+			}
+			inARow++
+			if inARow > limit {
+				dlog.Errorf(ctx, "%s has panicked %d times in a row; giving up", name, inARow)
+				return err
+			}
+			dlog.Errorf(ctx, "restarting %s in %v", name, backoff)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return nil
+			}
+			bus.Publish(eventbus.HealthEvent{Subsystem: name, Healthy: true, Reason: "restarted"})
+			backoff *= 2
+			if backoff > maxRestartBackoff {
+				backoff = maxRestartBackoff
+			}
+		}
+	}
+}
+
+// runRecovered runs fn, and turns a panic into an error (with the stack of the panic).
+func runRecovered(ctx context.Context, fn func(context.Context) error) (panicked bool, err error) {
+	defer func() {
+		if perr := derror.PanicToError(recover()); perr != nil {
+			panicked, err = true, perr
+		}
+	}()
+	return false, fn(ctx)
+}
+
+// panicMetrics renders the panic counts in the Prometheus text format, to add to what diagd serves
+// on /metrics.
+func panicMetrics(counter *panicCounter) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "# HELP ambassador_subsystem_panics_total Panics, by subsystem.")
+	fmt.Fprintln(&buf, "# TYPE ambassador_subsystem_panics_total counter")
+	writeSubsystemCounts(&buf, "ambassador_subsystem_panics_total", counter.snapshot())
+	return buf.Bytes()
+}
@@ -0,0 +1,57 @@
+package entrypoint
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/eventbus"
+)
+
+func TestSupervise(t *testing.T) {
+	defer func(min time.Duration) { minRestartBackoff = min }(minRestartBackoff)
+	minRestartBackoff = time.Millisecond
+	bus := eventbus.New()
+	health := bus.Subscribe("test", 8, eventbus.KindHealth)
+	ctx := eventbus.WithBus(dlog.NewTestContext(t, false), bus)
+
+	counter := newPanicCounter()
+	runs := 0
+	err := superviseWith(counter, 2, "flaky", func(context.Context) error {
+		runs++
+		if runs <= 2 {
+			panic("bang")
+		}
+		return nil
+	})(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, runs)
+
+	// Both panics, and both restarts, went out on the bus.
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, eventbus.HealthEvent{Subsystem: "flaky", Reason: "panicked: PANIC: bang"}, <-health.Events())
+		assert.Equal(t, eventbus.HealthEvent{Subsystem: "flaky", Healthy: true, Reason: "restarted"}, <-health.Events())
+	}
+
+	// A subsystem that keeps panicking is given up on eventually.
+	err = superviseWith(counter, 2, "broken", func(context.Context) error {
+		panic("bang bang")
+	})(ctx)
+	assert.EqualError(t, err, "PANIC: bang bang")
+
+	// Errors aren't panics.
+	err = superviseWith(counter, 2, "failing", func(context.Context) error {
+		return context.Canceled
+	})(ctx)
+	assert.Equal(t, context.Canceled, err)
+
+	assert.Equal(t, map[string]int{"flaky": 2, "broken": 3, "failing": 0}, counter.snapshot())
+	assert.Equal(t, "# HELP ambassador_subsystem_panics_total Panics, by subsystem.\n"+
+		"# TYPE ambassador_subsystem_panics_total counter\n"+
+		"ambassador_subsystem_panics_total{subsystem=\"broken\"} 3\n"+
+		"ambassador_subsystem_panics_total{subsystem=\"failing\"} 0\n"+
+		"ambassador_subsystem_panics_total{subsystem=\"flaky\"} 2\n", string(panicMetrics(counter)))
+}
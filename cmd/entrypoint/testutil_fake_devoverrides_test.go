@@ -0,0 +1,123 @@
+package entrypoint_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/emissary-ingress/emissary/v3/cmd/entrypoint"
+	"github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+// Tests that the dev overrides file sends the Mappings it selects (and only those Mappings) to
+// their targets, and that changing the file puts things back the way they were.
+func TestDevOverrides(t *testing.T) {
+	overridesFile := filepath.Join(t.TempDir(), "overrides.yaml")
+	require.NoError(t, os.WriteFile(overridesFile, []byte(`
+overrides:
+  - mapping: quote
+    namespace: foo
+    target: localhost:3000
+  - service: backend.default
+    target: http://backend-dev:8080
+`), 0644))
+	t.Setenv("AMBASSADOR_DEV_OVERRIDES_FILE", overridesFile)
+
+	f := entrypoint.RunFake(t, entrypoint.FakeConfig{}, nil)
+
+	err := f.UpsertYAML(`
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+metadata:
+  name: quote
+  namespace: foo
+spec:
+  hostname: "*"
+  prefix: /quote/
+  service: quote.foo
+  resolver: endpoint
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+metadata:
+  name: quote
+  namespace: bar
+spec:
+  hostname: "*"
+  prefix: /bar-quote/
+  service: quote.bar
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+metadata:
+  name: backend
+  namespace: default
+spec:
+  hostname: "*"
+  prefix: /backend/
+  service: backend.default
+`)
+	require.NoError(t, err)
+	f.Flush()
+
+	snap, err := f.GetSnapshot(func(snap *snapshot.Snapshot) bool {
+		return findMapping(snap, "default", "backend") != nil
+	})
+	require.NoError(t, err)
+
+	quote := findMapping(snap, "foo", "quote")
+	assert.Equal(t, "localhost:3000", quote.Spec.Service)
+	assert.Equal(t, "kubernetes-service", quote.Spec.Resolver)
+	assert.Equal(t, "quote.bar", findMapping(snap, "bar", "quote").Spec.Service)
+	assert.Equal(t, "http://backend-dev:8080", findMapping(snap, "default", "backend").Spec.Service)
+
+	// Dropping an override puts its Mapping back.
+	require.NoError(t, os.WriteFile(overridesFile, []byte(`
+overrides:
+  - service: backend.default
+    target: http://backend-dev:8080
+`), 0644))
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(overridesFile, future, future))
+
+	snap, err = f.GetSnapshot(func(snap *snapshot.Snapshot) bool {
+		return findMapping(snap, "foo", "quote").Spec.Service == "quote.foo"
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "endpoint", findMapping(snap, "foo", "quote").Spec.Resolver)
+	assert.Equal(t, "http://backend-dev:8080", findMapping(snap, "default", "backend").Spec.Service)
+}
+
+func TestParseDevOverrides(t *testing.T) {
+	overrides, err := entrypoint.ParseDevOverrides([]byte(`
+overrides:
+  - mapping: quote
+    target: localhost:3000
+`))
+	require.NoError(t, err)
+	assert.Equal(t, []entrypoint.DevOverride{{Mapping: "quote", Target: "localhost:3000"}}, overrides)
+
+	_, err = entrypoint.ParseDevOverrides([]byte(`
+overrides:
+  - target: localhost:3000
+`))
+	assert.EqualError(t, err, "override 1: needs a mapping or a service to select")
+
+	_, err = entrypoint.ParseDevOverrides([]byte(`
+overrides:
+  - mapping: quote
+`))
+	assert.EqualError(t, err, "override 1: needs a target")
+
+	_, err = entrypoint.ParseDevOverrides([]byte(`
+overrides:
+  - mapping: quote
+    taget: localhost:3000
+`))
+	assert.Error(t, err)
+}
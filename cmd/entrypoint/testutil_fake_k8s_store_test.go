@@ -211,6 +211,10 @@ func canonGVK(rawString string) (canonKind string, canonGroupVersion string, err
 		return "Service", "v1", nil
 	case "endpoints":
 		return "Endpoints", "v1", nil
+	case "endpointslice", "endpointslices":
+		return "EndpointSlice", "discovery.k8s.io/v1", nil
+	case "pod", "pods":
+		return "Pod", "v1", nil
 	case "secret", "secrets":
 		return "Secret", "v1", nil
 	case "configmap", "configmaps":
@@ -232,6 +236,9 @@ func canonGVK(rawString string) (canonKind string, canonGroupVersion string, err
 	// Knative types
 	case "clusteringress", "clusteringresses":
 		return "ClusterIngress", "networking.internal.knative.dev/v1alpha1", nil
+	// Istio types
+	case "destinationrule", "destinationrules":
+		return "DestinationRule", "networking.istio.io/v1beta1", nil
 	// Native Emissary types
 	case "authservice", "authservices":
 		return "AuthService", "getambassador.io/v3alpha1", nil
@@ -239,6 +246,8 @@ func canonGVK(rawString string) (canonKind string, canonGroupVersion string, err
 		return "ConsulResolver", "getambassador.io/v3alpha1", nil
 	case "devportal", "devportals":
 		return "DevPortal", "getambassador.io/v3alpha1", nil
+	case "egresspolicy", "egresspolicies":
+		return "EgressPolicy", "getambassador.io/v3alpha1", nil
 	case "host", "hosts":
 		return "Host", "getambassador.io/v3alpha1", nil
 	case "kubernetesendpointresolver", "kubernetesendpointresolvers":
@@ -253,14 +262,20 @@ func canonGVK(rawString string) (canonKind string, canonGroupVersion string, err
 		return "Mapping", "getambassador.io/v3alpha1", nil
 	case "module", "modules":
 		return "Module", "getambassador.io/v3alpha1", nil
+	case "pluginresolver", "pluginresolvers":
+		return "PluginResolver", "getambassador.io/v3alpha1", nil
 	case "ratelimitservice", "ratelimitservices":
 		return "RateLimitService", "getambassador.io/v3alpha1", nil
+	case "redirect", "redirects":
+		return "Redirect", "getambassador.io/v3alpha1", nil
 	case "tcpmapping", "tcpmappings":
 		return "TCPMapping", "getambassador.io/v3alpha1", nil
 	case "tlscontext", "tlscontexts":
 		return "TLSContext", "getambassador.io/v3alpha1", nil
 	case "tracingservice", "tracingservices":
 		return "TracingService", "getambassador.io/v3alpha1", nil
+	case "upstreamtlspolicy", "upstreamtlspolicies":
+		return "UpstreamTLSPolicy", "getambassador.io/v3alpha1", nil
 	case "filter", "filters":
 		return "Filter", "getambassador.io/v3alpha1", nil
 	case "filterpolicy", "filterpolicies":
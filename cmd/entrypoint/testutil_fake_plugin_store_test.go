@@ -0,0 +1,42 @@
+package entrypoint
+
+import (
+	"sync"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/resolverplugin"
+)
+
+type PluginStore struct {
+	mutex     sync.Mutex
+	endpoints map[string]resolverplugin.Endpoints
+}
+
+func NewPluginStore() *PluginStore {
+	return &PluginStore{endpoints: map[string]resolverplugin.Endpoints{}}
+}
+
+func (p *PluginStore) PluginEndpoint(resolver, service, address string, port uint32) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	key := pluginEndpointsKey(resolver, service)
+	ep, ok := p.endpoints[key]
+	if !ok {
+		ep = resolverplugin.Endpoints{
+			Resolver: resolver,
+			Service:  service,
+		}
+	}
+	ep.Endpoints = append(ep.Endpoints, resolverplugin.Endpoint{
+		Address: address,
+		Port:    port,
+	})
+	p.endpoints[key] = ep
+}
+
+func (p *PluginStore) Get(resolver, service string) (resolverplugin.Endpoints, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	ep, ok := p.endpoints[pluginEndpointsKey(resolver, service)]
+	return ep, ok
+}
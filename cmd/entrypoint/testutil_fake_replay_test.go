@@ -0,0 +1,46 @@
+package entrypoint
+
+import (
+	"os"
+)
+
+// LoadWatchEvents reads the watch events recorded in the given file (see watchrecord.go), for
+// Replay.
+func LoadWatchEvents(filename string) ([]WatchEvent, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadWatchEvents(file)
+}
+
+// Replay feeds a recorded watch event into the control plane the way the watcher first saw it:
+// the clock is set to when the event happened, and all of its changes are flushed at once.
+//
+// Replay doesn't wait for the control plane to do anything with the event. Wait for whatever it
+// should produce (with GetSnapshotEntry, GetSnapshot, GetEnvoyConfig, etc.) before replaying the
+// next one: otherwise the two may be coalesced, just as they can be in a real cluster, and the
+// clock may already have moved on by the time the first one is looked at.
+func (f *Fake) Replay(event WatchEvent) error {
+	for _, un := range event.Upserts {
+		if err := f.k8sStore.Upsert(un); err != nil {
+			return err
+		}
+	}
+	for _, del := range event.Deletes {
+		// Upsert puts resources without a namespace in "default", so look for them there.
+		namespace := del.Namespace
+		if namespace == "" {
+			namespace = "default"
+		}
+		if err := f.k8sStore.Delete(del.Kind, namespace, del.Name); err != nil {
+			return err
+		}
+	}
+
+	f.SetTime(event.Time)
+	f.k8sNotifier.Changed()
+	f.k8sNotifier.Notify()
+	return nil
+}
@@ -0,0 +1,55 @@
+package entrypoint_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/emissary-ingress/emissary/v3/cmd/entrypoint"
+	"github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+func mappingPrefixes(snap *snapshot.Snapshot) map[string]string {
+	prefixes := map[string]string{}
+	for _, mapping := range snap.Kubernetes.Mappings {
+		prefixes[mapping.Name] = mapping.Spec.Prefix
+	}
+	return prefixes
+}
+
+// TestFakeReplay replays a recording whose events straddle the opening of a change window, to
+// check that the clock follows the recording.
+func TestFakeReplay(t *testing.T) {
+	// Open from 02:00 to 06:00 on Saturdays. The recording starts at 01:00 on a Saturday.
+	t.Setenv("AMBASSADOR_CHANGE_WINDOWS", "0 2 * * 6 4h")
+	f := entrypoint.RunFake(t, entrypoint.FakeConfig{}, nil)
+
+	events, err := entrypoint.LoadWatchEvents("testdata/replay-change-windows.jsonl")
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+
+	// The very first configuration goes out, change window or not...
+	require.NoError(t, f.Replay(events[0]))
+	snap, err := f.GetSnapshot(func(snap *snapshot.Snapshot) bool {
+		return len(snap.Kubernetes.Mappings) > 0
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"hello": "/hello/", "goodbye": "/goodbye/"}, mappingPrefixes(snap))
+
+	// ...but the next change is held...
+	require.NoError(t, f.Replay(events[1]))
+	entry, err := f.GetSnapshotEntry(func(entry entrypoint.SnapshotEntry) bool {
+		return entry.Disposition == entrypoint.SnapshotDefer
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "/hello-v2/", mappingPrefixes(entry.Snapshot)["hello"])
+
+	// ...until one comes along after the window has opened.
+	require.NoError(t, f.Replay(events[2]))
+	snap, err = f.GetSnapshot(func(snap *snapshot.Snapshot) bool {
+		return true
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"hello": "/hello-v2/", "world": "/world/"}, mappingPrefixes(snap))
+}
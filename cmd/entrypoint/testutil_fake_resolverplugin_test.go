@@ -0,0 +1,64 @@
+package entrypoint_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/emissary-ingress/emissary/v3/cmd/entrypoint"
+	"github.com/emissary-ingress/emissary/v3/pkg/ambex"
+)
+
+// Tests that endpoints from a resolver plugin make it to ambex for the Mappings that use the
+// plugin's PluginResolver, and go away once nothing uses it.
+func TestFakeHelloResolverPlugin(t *testing.T) {
+	f := entrypoint.RunFake(t, entrypoint.FakeConfig{}, nil)
+
+	err := f.UpsertYAML(`
+---
+apiVersion: getambassador.io/v3alpha1
+kind: PluginResolver
+metadata:
+  name: registry
+  namespace: default
+spec:
+  plugin: eureka
+  config:
+    url: http://eureka:8761
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+metadata:
+  name: hello
+  namespace: default
+spec:
+  hostname: "*"
+  prefix: /hello/
+  service: hello
+  resolver: registry
+`)
+	require.NoError(t, err)
+	f.Flush()
+
+	f.PluginEndpoint("registry", "hello", "1.2.3.4", 8080)
+	f.Flush()
+
+	endpoints, err := f.GetEndpoints(func(endpoints *ambex.Endpoints) bool {
+		_, ok := endpoints.Entries["plugin/registry/hello"]
+		return ok
+	})
+	require.NoError(t, err)
+	require.Len(t, endpoints.Entries["plugin/registry/hello"], 1)
+	assert.Equal(t, "1.2.3.4", endpoints.Entries["plugin/registry/hello"][0].Ip)
+	assert.Equal(t, uint32(8080), endpoints.Entries["plugin/registry/hello"][0].Port)
+
+	require.NoError(t, f.Delete("Mapping", "default", "hello"))
+	f.Flush()
+
+	_, err = f.GetEndpoints(func(endpoints *ambex.Endpoints) bool {
+		_, ok := endpoints.Entries["plugin/registry/hello"]
+		return !ok
+	})
+	require.NoError(t, err)
+}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"reflect"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -20,8 +21,10 @@ import (
 	"github.com/emissary-ingress/emissary/v3/pkg/ambex"
 	v3bootstrap "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/bootstrap/v3"
 	amb "github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
 	"github.com/emissary-ingress/emissary/v3/pkg/consulwatch"
 	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	"github.com/emissary-ingress/emissary/v3/pkg/resolverplugin"
 	"github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
 )
 
@@ -61,13 +64,18 @@ type Fake struct {
 
 	k8sSource       *fakeK8sSource
 	watcher         *fakeWatcher
+	pluginWatcher   *fakePluginWatcher
 	istioCertSource *fakeIstioCertSource
-	// This group of fields are used to store kubernetes resources and consul endpoint data and
-	// provide explicit control over when changes to that data are sent to the control plane.
+	spiffeSource    *fakeSPIFFESource
+	// This group of fields are used to store kubernetes resources, consul endpoint data, and
+	// resolver plugin endpoint data, and provide explicit control over when changes to that data
+	// are sent to the control plane.
 	k8sStore       *K8sStore
 	consulStore    *ConsulStore
+	pluginStore    *PluginStore
 	k8sNotifier    *Notifier
 	consulNotifier *Notifier
+	pluginNotifier *Notifier
 
 	// This holds the current snapshot.
 	currentSnapshot *atomic.Value
@@ -81,6 +89,11 @@ type Fake struct {
 
 	ambassadorMeta *snapshot.AmbassadorMetaInfo
 
+	// This is the time the watcher sees, if SetTime has been called. Until then it sees the real
+	// time.
+	clockMutex sync.Mutex
+	now        time.Time
+
 	DiagdBindPort string
 }
 
@@ -104,6 +117,7 @@ func NewFake(t *testing.T, config FakeConfig) *Fake {
 	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
 	k8sStore := NewK8sStore()
 	consulStore := NewConsulStore()
+	pluginStore := NewPluginStore()
 
 	fake := &Fake{
 		config: config,
@@ -113,8 +127,10 @@ func NewFake(t *testing.T, config FakeConfig) *Fake {
 
 		k8sStore:       k8sStore,
 		consulStore:    consulStore,
+		pluginStore:    pluginStore,
 		k8sNotifier:    NewNotifier(),
 		consulNotifier: NewNotifier(),
+		pluginNotifier: NewNotifier(),
 
 		currentSnapshot: &atomic.Value{},
 
@@ -125,7 +141,9 @@ func NewFake(t *testing.T, config FakeConfig) *Fake {
 
 	fake.k8sSource = &fakeK8sSource{fake: fake, store: k8sStore}
 	fake.watcher = &fakeWatcher{fake: fake, store: consulStore}
+	fake.pluginWatcher = &fakePluginWatcher{fake: fake, store: pluginStore}
 	fake.istioCertSource = &fakeIstioCertSource{}
+	fake.spiffeSource = &fakeSPIFFESource{}
 
 	return fake
 }
@@ -269,14 +287,35 @@ func (f *Fake) runWatcher(ctx context.Context) error {
 		f.currentSnapshot, // encoded
 		f.k8sSource,
 		queries,
-		f.watcher.Watch, // watchConsulFunc
+		f.watcher.Watch,       // watchConsulFunc
+		f.pluginWatcher.Watch, // watchPluginFunc
 		f.istioCertSource,
+		f.spiffeSource,
 		f.notifySnapshot,
 		f.notifyFastpath,
 		f.ambassadorMeta,
+		clock.NowFunc(f.clock),
 	)
 }
 
+// SetTime sets the time that the control plane sees, from now on. The clock only moves when
+// SetTime is called again. Note that anything the control plane waits for, like the next change
+// window to open, is still waited for in real time.
+func (f *Fake) SetTime(now time.Time) {
+	f.clockMutex.Lock()
+	defer f.clockMutex.Unlock()
+	f.now = now
+}
+
+func (f *Fake) clock() time.Time {
+	f.clockMutex.Lock()
+	defer f.clockMutex.Unlock()
+	if f.now.IsZero() {
+		return time.Now()
+	}
+	return f.now
+}
+
 func (f *Fake) notifyFastpath(ctx context.Context, fastpath *ambex.FastpathSnapshot) {
 	f.fastpath.Add(f.T, fastpath)
 }
@@ -380,12 +419,14 @@ func (f *Fake) GetEnvoyConfig(predicate func(*v3bootstrap.Bootstrap) bool) (*v3b
 func (f *Fake) AutoFlush(enabled bool) {
 	f.k8sNotifier.AutoNotify(enabled)
 	f.consulNotifier.AutoNotify(enabled)
+	f.pluginNotifier.AutoNotify(enabled)
 }
 
 // Feed will cause inputs from all datasources to be delivered to the control plane.
 func (f *Fake) Flush() {
 	f.k8sNotifier.Notify()
 	f.consulNotifier.Notify()
+	f.pluginNotifier.Notify()
 }
 
 // sets the ambassador meta info that should get sent in each snapshot
@@ -437,11 +478,22 @@ func (f *Fake) ConsulEndpoint(datacenter, service, address string, port int, tag
 	f.consulNotifier.Changed()
 }
 
+// PluginEndpoint stores the supplied resolver plugin endpoint data.
+func (f *Fake) PluginEndpoint(resolver, service, address string, port uint32) {
+	f.pluginStore.PluginEndpoint(resolver, service, address, port)
+	f.pluginNotifier.Changed()
+}
+
 // SendIstioCertUpdate sends the supplied Istio certificate update.
 func (f *Fake) SendIstioCertUpdate(update IstioCertUpdate) {
 	f.istioCertSource.updateChannel <- update
 }
 
+// SendSPIFFEUpdate sends the supplied SPIFFE update.
+func (f *Fake) SendSPIFFEUpdate(update SPIFFEUpdate) {
+	f.spiffeSource.updateChannel <- update
+}
+
 type fakeK8sSource struct {
 	fake  *Fake
 	store *K8sStore
@@ -449,11 +501,19 @@ type fakeK8sSource struct {
 
 func (fs *fakeK8sSource) Watch(ctx context.Context, queries ...kates.Query) (K8sWatcher, error) {
 	fw := &fakeK8sWatcher{fs.store.Cursor(), make(chan struct{}), queries}
-	fs.fake.k8sNotifier.Listen(func() {
+	stop := fs.fake.k8sNotifier.Listen(func() {
 		go func() {
-			fw.notifyCh <- struct{}{}
+			select {
+			case fw.notifyCh <- struct{}{}:
+			case <-ctx.Done():
+			}
 		}()
 	})
+	// The scoped secret watcher starts and stops watches as Secrets come and go.
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
 	return fw, nil
 }
 
@@ -522,7 +582,19 @@ func matches(query kates.Query, obj kates.Object) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	return queryKind == objKind, nil
+	if queryKind != objKind {
+		return false, nil
+	}
+	if query.Namespace != "" && query.Namespace != obj.GetNamespace() {
+		return false, nil
+	}
+	// Of field selectors, only the scoped secret watcher's metadata.name ones are honored.
+	for _, term := range strings.Split(query.FieldSelector, ",") {
+		if name := strings.TrimPrefix(term, "metadata.name="); name != term && name != obj.GetName() {
+			return false, nil
+		}
+	}
+	return true, nil
 }
 
 type fakeWatcher struct {
@@ -542,6 +614,25 @@ func (f *fakeWatcher) Watch(ctx context.Context, resolver *amb.ConsulResolver, s
 	return &fakeStopper{stop}, nil
 }
 
+type fakePluginWatcher struct {
+	fake  *Fake
+	store *PluginStore
+}
+
+func (f *fakePluginWatcher) Watch(ctx context.Context, resolver *amb.PluginResolver, services []string, endpoints chan resolverplugin.Endpoints) (Stopper, error) {
+	sent := make(map[string]resolverplugin.Endpoints)
+	stop := f.fake.pluginNotifier.Listen(func() {
+		for _, svc := range services {
+			ep, ok := f.store.Get(resolver.GetName(), svc)
+			if ok && !reflect.DeepEqual(ep, sent[svc]) {
+				endpoints <- ep
+				sent[svc] = ep
+			}
+		}
+	})
+	return &fakeStopper{stop}, nil
+}
+
 type fakeStopper struct {
 	stop StopFunc
 }
@@ -561,3 +652,15 @@ func (src *fakeIstioCertSource) Watch(ctx context.Context) (IstioCertWatcher, er
 		updateChannel: src.updateChannel,
 	}, nil
 }
+
+type fakeSPIFFESource struct {
+	updateChannel chan SPIFFEUpdate
+}
+
+func (src *fakeSPIFFESource) Watch(ctx context.Context) (SPIFFEWatcher, error) {
+	src.updateChannel = make(chan SPIFFEUpdate)
+
+	return &spiffeWatcher{
+		updateChannel: src.updateChannel,
+	}, nil
+}
@@ -0,0 +1,374 @@
+package entrypoint_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/emissary-ingress/emissary/v3/cmd/entrypoint"
+	"github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	"github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+func findMapping(snap *snapshot.Snapshot, namespace, name string) *v3alpha1.Mapping {
+	for _, m := range snap.Kubernetes.Mappings {
+		if m.Namespace == namespace && m.Name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+func findTLSContext(snap *snapshot.Snapshot, name string) *v3alpha1.TLSContext {
+	for _, tc := range snap.Kubernetes.TLSContexts {
+		if tc.Name == name {
+			return tc
+		}
+	}
+	return nil
+}
+
+func findSecret(snap *snapshot.Snapshot, namespace, name string) *kates.Secret {
+	for _, secret := range snap.Kubernetes.Secrets {
+		if secret.Namespace == namespace && secret.Name == name {
+			return secret
+		}
+	}
+	return nil
+}
+
+// Tests that an UpstreamTLSPolicy turns into a TLSContext that the Mappings it selects (and only
+// those Mappings) use, and that deleting the policy puts things back the way they were.
+func TestUpstreamTLSPolicy(t *testing.T) {
+	f := entrypoint.RunFake(t, entrypoint.FakeConfig{}, nil)
+
+	err := f.UpsertYAML(`
+---
+apiVersion: getambassador.io/v3alpha1
+kind: UpstreamTLSPolicy
+metadata:
+  name: backend-mtls
+  namespace: foo
+spec:
+  mappingSelector:
+    matchLabels:
+      upstream-tls: mtls
+  sni: backend.internal
+  caSecret: backend-ca
+  clientCertSecret: backend-client
+  minTLSVersion: v1.2
+  alpnProtocols: [h2, http/1.1]
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+metadata:
+  name: selected
+  namespace: foo
+  labels:
+    upstream-tls: mtls
+spec:
+  hostname: "*"
+  prefix: /selected/
+  service: https://backend.foo
+  tls: legacy-context
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+metadata:
+  name: unlabeled
+  namespace: foo
+spec:
+  hostname: "*"
+  prefix: /unlabeled/
+  service: backend.foo
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+metadata:
+  name: other-namespace
+  namespace: bar
+  labels:
+    upstream-tls: mtls
+spec:
+  hostname: "*"
+  prefix: /other/
+  service: backend.bar
+`)
+	require.NoError(t, err)
+	f.Flush()
+
+	const tlsName = "upstream_tls_policy_backend-mtls_foo"
+
+	snap, err := f.GetSnapshot(func(snap *snapshot.Snapshot) bool {
+		return findTLSContext(snap, tlsName) != nil
+	})
+	require.NoError(t, err)
+
+	tc := findTLSContext(snap, tlsName)
+	assert.Equal(t, "foo", tc.Namespace)
+	assert.Equal(t, "backend.internal", tc.Spec.SNI)
+	assert.Equal(t, "backend-ca", tc.Spec.CASecret)
+	assert.Equal(t, "backend-client", tc.Spec.Secret)
+	assert.Equal(t, "v1.2", tc.Spec.MinTLSVersion)
+	assert.Equal(t, "h2,http/1.1", tc.Spec.ALPNProtocols)
+
+	assert.Equal(t, tlsName, findMapping(snap, "foo", "selected").Spec.TLS)
+	assert.Equal(t, "", findMapping(snap, "foo", "unlabeled").Spec.TLS)
+	assert.Equal(t, "", findMapping(snap, "bar", "other-namespace").Spec.TLS)
+
+	require.NoError(t, f.Delete("UpstreamTLSPolicy", "foo", "backend-mtls"))
+	f.Flush()
+
+	snap, err = f.GetSnapshot(func(snap *snapshot.Snapshot) bool {
+		return findTLSContext(snap, tlsName) == nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "legacy-context", findMapping(snap, "foo", "selected").Spec.TLS)
+}
+
+// Tests that a SPIFFE UpstreamTLSPolicy uses the SVID and trust bundle Secrets that the SPIFFE
+// watcher maintains in the Ambassador namespace.
+func TestUpstreamTLSPolicySPIFFE(t *testing.T) {
+	f := entrypoint.RunFake(t, entrypoint.FakeConfig{}, nil)
+
+	err := f.UpsertYAML(`
+---
+apiVersion: getambassador.io/v3alpha1
+kind: UpstreamTLSPolicy
+metadata:
+  name: mesh
+  namespace: foo
+spec:
+  mappingSelector:
+    matchLabels:
+      mesh: spiffe
+  spiffe:
+    trustDomain: example.org
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+metadata:
+  name: meshed
+  namespace: foo
+  labels:
+    mesh: spiffe
+spec:
+  hostname: "*"
+  prefix: /meshed/
+  service: https://backend.foo
+`)
+	require.NoError(t, err)
+	f.Flush()
+
+	const tlsName = "upstream_tls_policy_mesh_foo"
+
+	snap, err := f.GetSnapshot(func(snap *snapshot.Snapshot) bool {
+		return findTLSContext(snap, tlsName) != nil
+	})
+	require.NoError(t, err)
+
+	tc := findTLSContext(snap, tlsName)
+	ns := entrypoint.GetAmbassadorNamespace()
+	assert.Equal(t, "spiffe-svid."+ns, tc.Spec.Secret)
+	assert.Equal(t, "spiffe-bundle-example-org."+ns, tc.Spec.CASecret)
+	require.NotNil(t, tc.Spec.SecretNamespacing)
+	assert.True(t, *tc.Spec.SecretNamespacing)
+	assert.Equal(t, []v3alpha1.SubjectAltNameMatcher{
+		{SANType: "URI", Prefix: "spiffe://example.org/"},
+	}, tc.Spec.MatchSubjectAltNames)
+	assert.Equal(t, tlsName, findMapping(snap, "foo", "meshed").Spec.TLS)
+}
+
+// Tests that a SPIFFE UpstreamTLSPolicy with an ID pins the upstream to exactly that ID, and
+// that one with an ID outside its trust domain doesn't get applied at all.
+func TestUpstreamTLSPolicySPIFFEID(t *testing.T) {
+	f := entrypoint.RunFake(t, entrypoint.FakeConfig{}, nil)
+
+	err := f.UpsertYAML(`
+---
+apiVersion: getambassador.io/v3alpha1
+kind: UpstreamTLSPolicy
+metadata:
+  name: mesh
+  namespace: foo
+spec:
+  mappingSelector:
+    matchLabels:
+      mesh: spiffe
+  spiffe:
+    trustDomain: example.org
+    id: spiffe://example.org/ns/foo/sa/backend
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+metadata:
+  name: meshed
+  namespace: foo
+  labels:
+    mesh: spiffe
+spec:
+  hostname: "*"
+  prefix: /meshed/
+  service: https://backend.foo
+---
+apiVersion: getambassador.io/v3alpha1
+kind: UpstreamTLSPolicy
+metadata:
+  name: mesh
+  namespace: bar
+spec:
+  mappingSelector:
+    matchLabels:
+      mesh: spiffe
+  spiffe:
+    trustDomain: example.org
+    id: spiffe://example.com/ns/bar/sa/backend
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+metadata:
+  name: meshed
+  namespace: bar
+  labels:
+    mesh: spiffe
+spec:
+  hostname: "*"
+  prefix: /meshed-bar/
+  service: https://backend.bar
+`)
+	require.NoError(t, err)
+	f.Flush()
+
+	const tlsName = "upstream_tls_policy_mesh_foo"
+
+	snap, err := f.GetSnapshot(func(snap *snapshot.Snapshot) bool {
+		return findTLSContext(snap, tlsName) != nil
+	})
+	require.NoError(t, err)
+
+	tc := findTLSContext(snap, tlsName)
+	assert.Equal(t, []v3alpha1.SubjectAltNameMatcher{
+		{SANType: "URI", Exact: "spiffe://example.org/ns/foo/sa/backend"},
+	}, tc.Spec.MatchSubjectAltNames)
+	assert.Equal(t, tlsName, findMapping(snap, "foo", "meshed").Spec.TLS)
+
+	assert.Nil(t, findTLSContext(snap, "upstream_tls_policy_mesh_bar"))
+	assert.Equal(t, "", findMapping(snap, "bar", "meshed").Spec.TLS)
+}
+
+// Tests that each SPIFFE update replaces the last one, so a bundle that the Workload API stops
+// sending drops out of the snapshot.
+func TestSPIFFEUpdate(t *testing.T) {
+	f := entrypoint.RunFake(t, entrypoint.FakeConfig{}, nil)
+
+	err := f.UpsertYAML(`
+---
+apiVersion: getambassador.io/v3alpha1
+kind: UpstreamTLSPolicy
+metadata:
+  name: mesh
+  namespace: foo
+spec:
+  mappingSelector:
+    matchLabels:
+      mesh: spiffe
+  spiffe:
+    trustDomain: example.org
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+metadata:
+  name: meshed
+  namespace: foo
+  labels:
+    mesh: spiffe
+spec:
+  hostname: "*"
+  prefix: /meshed/
+  service: https://backend.foo
+`)
+	require.NoError(t, err)
+	f.Flush()
+
+	ns := entrypoint.GetAmbassadorNamespace()
+	secret := func(name string) *kates.Secret {
+		return &kates.Secret{
+			TypeMeta:   kates.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: kates.ObjectMeta{Name: name, Namespace: ns},
+			Type:       kates.SecretTypeTLS,
+			Data:       map[string][]byte{"tls.crt": []byte(name)},
+		}
+	}
+
+	f.SendSPIFFEUpdate(entrypoint.SPIFFEUpdate{Secrets: []*kates.Secret{
+		secret("spiffe-svid"),
+		secret("spiffe-bundle-example-org"),
+	}})
+	snap, err := f.GetSnapshot(func(snap *snapshot.Snapshot) bool {
+		return findSecret(snap, ns, "spiffe-bundle-example-org") != nil
+	})
+	require.NoError(t, err)
+	assert.NotNil(t, findSecret(snap, ns, "spiffe-svid"))
+
+	f.SendSPIFFEUpdate(entrypoint.SPIFFEUpdate{Secrets: []*kates.Secret{
+		secret("spiffe-svid"),
+	}})
+	snap, err = f.GetSnapshot(func(snap *snapshot.Snapshot) bool {
+		return findSecret(snap, ns, "spiffe-bundle-example-org") == nil
+	})
+	require.NoError(t, err)
+	assert.NotNil(t, findSecret(snap, ns, "spiffe-svid"))
+}
+
+// Tests that an Istio UpstreamTLSPolicy uses the Istio workload cert and the "istio" ALPN
+// protocol, and verifies upstreams against the mesh root when we're getting certs over SDS.
+func TestUpstreamTLSPolicyIstio(t *testing.T) {
+	t.Setenv("AMBASSADOR_ISTIO_SDS_SOCKET", "/var/run/secrets/workload-spiffe-uds/socket")
+	f := entrypoint.RunFake(t, entrypoint.FakeConfig{}, nil)
+
+	err := f.UpsertYAML(`
+---
+apiVersion: getambassador.io/v3alpha1
+kind: UpstreamTLSPolicy
+metadata:
+  name: mesh
+  namespace: foo
+spec:
+  mappingSelector:
+    matchLabels:
+      mesh: istio
+  istio: true
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+metadata:
+  name: meshed
+  namespace: foo
+  labels:
+    mesh: istio
+spec:
+  hostname: "*"
+  prefix: /meshed/
+  service: https://backend.foo
+`)
+	require.NoError(t, err)
+	f.Flush()
+
+	const tlsName = "upstream_tls_policy_mesh_foo"
+
+	snap, err := f.GetSnapshot(func(snap *snapshot.Snapshot) bool {
+		return findTLSContext(snap, tlsName) != nil
+	})
+	require.NoError(t, err)
+
+	tc := findTLSContext(snap, tlsName)
+	ns := entrypoint.GetAmbassadorNamespace()
+	assert.Equal(t, "istio-certs."+ns, tc.Spec.Secret)
+	assert.Equal(t, "istio-root-ca."+ns, tc.Spec.CASecret)
+	assert.Equal(t, "istio", tc.Spec.ALPNProtocols)
+	require.NotNil(t, tc.Spec.SecretNamespacing)
+	assert.True(t, *tc.Spec.SecretNamespacing)
+	assert.Equal(t, tlsName, findMapping(snap, "foo", "meshed").Spec.TLS)
+}
@@ -0,0 +1,170 @@
+package entrypoint
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/ambex"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+	"github.com/emissary-ingress/emissary/v3/pkg/debug"
+	"github.com/emissary-ingress/emissary/v3/pkg/envoystats"
+	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+// Traffic rollups: every AMBASSADOR_TRAFFIC_ROLLUP_INTERVAL_SECONDS, we scrape Envoy's cluster
+// traffic stats, and use the cluster introspection (see pkg/ambex/introspect.go) to add them up for
+// each Mapping (the clusters generated for it) and each Host (the clusters its virtual host routes
+// to). That way people get traffic by the names they configured, without having to relabel
+// Envoy's metrics in their own Prometheus.
+//
+// Envoy only counts traffic by cluster, so a cluster that several Mappings (or Hosts) share counts
+// toward each of them.
+//
+// The most recent rollups are served on /ambassador/v0/traffic, and on /traffic-external next to
+// /snapshot-external for the agent to pick up.
+
+// trafficRollupsDebugValue is the name of the debug value holding the *TrafficRollups from the most
+// recent scrape.
+const trafficRollupsDebugValue = "trafficRollups"
+
+// GetTrafficRollupInterval returns how often to roll up Envoy's traffic stats, from
+// AMBASSADOR_TRAFFIC_ROLLUP_INTERVAL_SECONDS. Zero disables the rollups.
+func GetTrafficRollupInterval() time.Duration {
+	secs, err := strconv.Atoi(env("AMBASSADOR_TRAFFIC_ROLLUP_INTERVAL_SECONDS", "30"))
+	if err != nil || secs < 0 {
+		secs = 30
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// TrafficRollups are the traffic for every Mapping and Host, as of Time.
+type TrafficRollups struct {
+	Time     time.Time        `json:"time"`
+	Mappings []*TrafficRollup `json:"mappings"`
+	Hosts    []*TrafficRollup `json:"hosts"`
+}
+
+// TrafficRollup is the traffic for one Mapping or Host.
+type TrafficRollup struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	envoystats.Rollup
+}
+
+// runTrafficRollups rolls up Envoy's traffic stats every interval until the context is done.
+func runTrafficRollups(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return nil
+	}
+	client := &http.Client{Timeout: interval}
+	clk := clock.FromContext(ctx)
+
+	snapshots := followSnapshots(ctx, "traffic_rollups")
+	defer snapshots.Close()
+
+	var prev map[string]*envoystats.ClusterStats
+	var prevTime time.Time
+	ticker := clk.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case ev, ok := <-snapshots.Events():
+			snapshots.note(ev, ok)
+			continue
+		case <-ticker.C():
+		case <-ctx.Done():
+			return nil
+		}
+
+		stats, err := envoystats.Scrape(ctx, client, GetEnvoyAdminURL())
+		if err != nil {
+			// Envoy might not be up yet, or might be restarting.
+			dlog.Debugf(ctx, "Traffic rollups: scraping Envoy: %v", err)
+			continue
+		}
+		now := clk.Now()
+		rates := map[string]float64{}
+		if prev != nil {
+			for name, cs := range stats {
+				rates[name] = envoystats.Rate(prev[name], cs, now.Sub(prevTime))
+			}
+		}
+		prev, prevTime = stats, now
+
+		in, _ := debug.FromContext(ctx).Value(ambex.IntrospectionDebugValue).Load().(*ambex.Introspection)
+		if in == nil {
+			continue
+		}
+		rollups := rollupTraffic(in, snapshots.latest(), stats, rates)
+		rollups.Time = now
+		debug.FromContext(ctx).Value(trafficRollupsDebugValue).Store(rollups)
+	}
+}
+
+// rollupTraffic adds up the stats (and request rates) of each Mapping's and each Host's clusters.
+func rollupTraffic(in *ambex.Introspection, snap *snapshotTypes.Snapshot, stats map[string]*envoystats.ClusterStats, rates map[string]float64) *TrafficRollups {
+	result := &TrafficRollups{
+		Mappings: []*TrafficRollup{},
+		Hosts:    []*TrafficRollup{},
+	}
+	if snap == nil || snap.Kubernetes == nil {
+		return result
+	}
+
+	add := func(r *TrafficRollup, sources []*ambex.ClusterSource) {
+		for _, cs := range sources {
+			// Envoy's stats go by the stat name, if the cluster has one.
+			name := cs.Cluster
+			if cs.StatName != "" {
+				name = cs.StatName
+			}
+			r.Add(name, stats[name], rates[name])
+		}
+	}
+
+	for _, m := range snap.Kubernetes.Mappings {
+		r := &TrafficRollup{Namespace: m.GetNamespace(), Name: m.GetName()}
+		add(r, in.LookupMapping(m.GetNamespace(), m.GetName()))
+		result.Mappings = append(result.Mappings, r)
+	}
+	for _, h := range snap.Kubernetes.Hosts {
+		hostname := "*"
+		if h.Spec != nil && h.Spec.Hostname != "" {
+			hostname = h.Spec.Hostname
+		}
+		r := &TrafficRollup{Namespace: h.GetNamespace(), Name: h.GetName()}
+		add(r, in.LookupDomain(hostname))
+		result.Hosts = append(result.Hosts, r)
+	}
+
+	for _, rollups := range [][]*TrafficRollup{result.Mappings, result.Hosts} {
+		sort.Slice(rollups, func(i, j int) bool {
+			if rollups[i].Namespace != rollups[j].Namespace {
+				return rollups[i].Namespace < rollups[j].Namespace
+			}
+			return rollups[i].Name < rollups[j].Name
+		})
+	}
+	return result
+}
+
+// handleTrafficRollups serves the most recent traffic rollups.
+func handleTrafficRollups(w http.ResponseWriter, r *http.Request) {
+	rollups, _ := debug.FromContext(r.Context()).Value(trafficRollupsDebugValue).Load().(*TrafficRollups)
+	if rollups == nil {
+		http.Error(w, "no traffic stats have been rolled up yet\n", http.StatusServiceUnavailable)
+		return
+	}
+	bytes, err := json.MarshalIndent(rollups, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(append(bytes, '\n'))
+}
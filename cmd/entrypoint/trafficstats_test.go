@@ -0,0 +1,122 @@
+package entrypoint
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/ambex"
+	v3cluster "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/cluster/v3"
+	v3route "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/route/v3"
+	amb "github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
+	ecp_cache_types "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/cache/types"
+	ecp_v3_cache "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/cache/v3"
+	ecp_v3_resource "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/resource/v3"
+	"github.com/emissary-ingress/emissary/v3/pkg/envoystats"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+func TestRollupTraffic(t *testing.T) {
+	cluster := func(name, statName, service string) *v3cluster.Cluster {
+		return &v3cluster.Cluster{
+			Name:             name,
+			AltStatName:      statName,
+			EdsClusterConfig: &v3cluster.Cluster_EdsClusterConfig{ServiceName: "k8s/default/" + service},
+		}
+	}
+	route := func(prefix, cluster string) *v3route.Route {
+		return &v3route.Route{
+			Match: &v3route.RouteMatch{PathSpecifier: &v3route.RouteMatch_Prefix{Prefix: prefix}},
+			Action: &v3route.Route_Route{Route: &v3route.RouteAction{
+				ClusterSpecifier: &v3route.RouteAction_Cluster{Cluster: cluster},
+			}},
+		}
+	}
+	envoySnapshot, err := ecp_v3_cache.NewSnapshot("v1", map[ecp_v3_resource.Type][]ecp_cache_types.Resource{
+		ecp_v3_resource.ClusterType: {
+			cluster("cluster_quote_default", "quote", "quote"),
+			cluster("cluster_echo_default", "", "echo"),
+		},
+		ecp_v3_resource.RouteType: {
+			&v3route.RouteConfiguration{
+				Name: "ambassador-listener-8080-routeconfig-0",
+				VirtualHosts: []*v3route.VirtualHost{
+					{
+						Name:    "ambassador-listener-8080-quote.example.com",
+						Domains: []string{"quote.example.com"},
+						Routes:  []*v3route.Route{route("/quote/", "cluster_quote_default")},
+					},
+					{
+						Name:    "ambassador-listener-8080-*",
+						Domains: []string{"*"},
+						Routes: []*v3route.Route{
+							route("/quote/", "cluster_quote_default"),
+							route("/echo/", "cluster_echo_default"),
+						},
+					},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+	in := ambex.NewIntrospection("v1", envoySnapshot, map[string]*ambex.ClustermapEntry{
+		"cluster_quote_default": {
+			Kind:     "KubernetesEndpointResolver",
+			Mappings: []ambex.MappingRef{{Name: "quote", Namespace: "default"}},
+		},
+		"cluster_echo_default": {
+			Kind:     "KubernetesEndpointResolver",
+			Mappings: []ambex.MappingRef{{Name: "echo", Namespace: "default"}},
+		},
+	})
+
+	mapping := func(name, service string) *amb.Mapping {
+		return &amb.Mapping{
+			ObjectMeta: kates.ObjectMeta{Namespace: "default", Name: name},
+			Spec:       amb.MappingSpec{Service: service},
+		}
+	}
+	host := func(name, hostname string) *amb.Host {
+		return &amb.Host{
+			ObjectMeta: kates.ObjectMeta{Namespace: "default", Name: name},
+			Spec:       &amb.HostSpec{Hostname: hostname},
+		}
+	}
+	snap := &snapshotTypes.Snapshot{Kubernetes: &snapshotTypes.KubernetesSnapshot{
+		Mappings: []*amb.Mapping{
+			mapping("quote", "quote"),
+			mapping("echo", "echo.default:8080"),
+			mapping("nowhere", "nowhere"),
+		},
+		Hosts: []*amb.Host{
+			host("wildcard", "*"),
+			host("quote", "quote.example.com"),
+		},
+	}}
+
+	stats := map[string]*envoystats.ClusterStats{
+		"quote":                {Requests: 100, Responses: map[string]uint64{"2xx": 100}},
+		"cluster_echo_default": {Requests: 10, Responses: map[string]uint64{"5xx": 10}},
+	}
+	rates := map[string]float64{"quote": 2, "cluster_echo_default": 1}
+
+	rollups := rollupTraffic(in, snap, stats, rates)
+	require.Len(t, rollups.Mappings, 3)
+	echo, nowhere, quote := rollups.Mappings[0], rollups.Mappings[1], rollups.Mappings[2]
+	assert.Equal(t, "echo", echo.Name)
+	assert.Equal(t, []string{"cluster_echo_default"}, echo.Clusters)
+	assert.Equal(t, map[string]uint64{"5xx": 10}, echo.Responses)
+	assert.Equal(t, uint64(0), nowhere.Requests)
+	assert.Equal(t, []string{"quote"}, quote.Clusters)
+	assert.Equal(t, uint64(100), quote.Requests)
+	assert.Equal(t, 2.0, quote.RequestsPerSecond)
+
+	require.Len(t, rollups.Hosts, 2)
+	assert.Equal(t, "quote", rollups.Hosts[0].Name)
+	assert.Equal(t, uint64(100), rollups.Hosts[0].Requests)
+	assert.Equal(t, "wildcard", rollups.Hosts[1].Name)
+	assert.Equal(t, uint64(110), rollups.Hosts[1].Requests)
+	assert.Equal(t, 3.0, rollups.Hosts[1].RequestsPerSecond)
+}
@@ -0,0 +1,124 @@
+package entrypoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/acp"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+)
+
+// Upstream health: every AMBASSADOR_UPSTREAM_HEALTH_INTERVAL_SECONDS, the AmbassadorWatcher fetches
+// the membership gauges of Envoy's clusters, which say how many of each cluster's members Envoy's
+// health checks and outlier detection think are healthy (see pkg/acp/envoyclusterhealth.go). That
+// way the diag UI and the agent can show which backends Envoy considers down, and the log says
+// when a cluster stops (or starts) being healthy.
+//
+// The most recent health is served on /ambassador/v0/upstream_health, and on
+// /upstream-health-external next to /snapshot-external for the agent to pick up. It's off by
+// default, since Envoy has to look through all its stats to find the gauges.
+//
+// There's no point asking while Envoy isn't ready (it's starting, restarting, or draining), and
+// once it's ready its clusters are worth a look right away, rather than at the next tick, so the
+// fetcher follows the envoy_watcher's health events on the event bus (see eventbus.go).
+
+// GetUpstreamHealthInterval returns how often to fetch the health of Envoy's clusters, from
+// AMBASSADOR_UPSTREAM_HEALTH_INTERVAL_SECONDS. Zero, the default, turns it off.
+func GetUpstreamHealthInterval() time.Duration {
+	secs, err := strconv.Atoi(env("AMBASSADOR_UPSTREAM_HEALTH_INTERVAL_SECONDS", "0"))
+	if err != nil || secs < 0 {
+		secs = 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// runUpstreamHealth fetches the health of Envoy's clusters every interval while Envoy is ready,
+// and whenever it becomes ready, until the context is done.
+func runUpstreamHealth(ctx context.Context, ambwatch *acp.AmbassadorWatcher, interval time.Duration) error {
+	if interval <= 0 {
+		return nil
+	}
+
+	envoy := followEnvoyHealth(ctx, "upstream_health")
+	defer envoy.Close()
+
+	var prev *acp.UpstreamHealth
+	ticker := clock.FromContext(ctx).NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			// Envoy's health might have changed at the same time.
+			envoy.catchUp()
+			if !envoy.ready {
+				continue
+			}
+		case ev, ok := <-envoy.Events():
+			if !envoy.note(ev, ok) {
+				continue
+			}
+		case <-ctx.Done():
+			return nil
+		}
+
+		if err := ambwatch.FetchUpstreamHealth(ctx); err != nil {
+			// Envoy might not be up yet, or might be restarting.
+			dlog.Debugf(ctx, "Upstream health: %v", err)
+			continue
+		}
+		health := ambwatch.UpstreamHealth()
+		for _, change := range upstreamHealthChanges(prev, health) {
+			dlog.Infof(ctx, "Upstream health: %s", change)
+		}
+		prev = health
+	}
+}
+
+// upstreamHealthChanges describes the clusters whose status changed between prev (which may be
+// nil) and next. A cluster that's new in next is only mentioned if it has members that aren't
+// healthy.
+func upstreamHealthChanges(prev, next *acp.UpstreamHealth) []string {
+	was := map[string]acp.ClusterHealthStatus{}
+	if prev != nil {
+		for _, c := range prev.Clusters {
+			was[c.Name] = c.Status
+		}
+	}
+
+	var changes []string
+	for _, c := range next.Clusters {
+		if status, ok := was[c.Name]; ok && status == c.Status {
+			continue
+		} else if !ok && (c.Status == acp.ClusterHealthy || c.Status == acp.ClusterEmpty) {
+			continue
+		}
+		changes = append(changes, fmt.Sprintf("cluster %s is %s (%d/%d members healthy, %d degraded)",
+			c.Name, c.Status, c.Healthy, c.Total, c.Degraded))
+	}
+	return changes
+}
+
+// handleUpstreamHealth serves the most recent health of Envoy's clusters.
+func handleUpstreamHealth(w http.ResponseWriter, r *http.Request, ambwatch *acp.AmbassadorWatcher) {
+	health := ambwatch.UpstreamHealth()
+	if health == nil {
+		if GetUpstreamHealthInterval() <= 0 {
+			http.Error(w, "upstream health is off; set AMBASSADOR_UPSTREAM_HEALTH_INTERVAL_SECONDS to turn it on", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "no upstream health has been fetched yet", http.StatusServiceUnavailable)
+		return
+	}
+	bytes, err := json.MarshalIndent(health, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(append(bytes, '\n'))
+}
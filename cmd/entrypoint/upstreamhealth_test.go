@@ -0,0 +1,118 @@
+package entrypoint
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/acp"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+	"github.com/emissary-ingress/emissary/v3/pkg/eventbus"
+)
+
+func TestUpstreamHealthChanges(t *testing.T) {
+	health := func(statuses ...acp.ClusterHealthStatus) *acp.UpstreamHealth {
+		h := &acp.UpstreamHealth{}
+		for i, status := range statuses {
+			h.Clusters = append(h.Clusters, acp.ClusterHealth{
+				Name: []string{"cluster_a", "cluster_b", "cluster_c"}[i], Status: status, Total: 2,
+			})
+		}
+		return h
+	}
+
+	// The first time, only the clusters that aren't healthy are mentioned...
+	assert.Equal(t, []string{"cluster cluster_b is unhealthy (0/2 members healthy, 0 degraded)"},
+		upstreamHealthChanges(nil, health(acp.ClusterHealthy, acp.ClusterUnhealthy)))
+
+	// ...and after that, the ones that change, or turn up unhealthy.
+	assert.Equal(t, []string{
+		"cluster cluster_a is degraded (0/2 members healthy, 0 degraded)",
+		"cluster cluster_b is healthy (0/2 members healthy, 0 degraded)",
+	}, upstreamHealthChanges(
+		health(acp.ClusterHealthy, acp.ClusterUnhealthy),
+		health(acp.ClusterDegraded, acp.ClusterHealthy, acp.ClusterEmpty)))
+	assert.Equal(t, []string{"cluster cluster_c is unhealthy (0/2 members healthy, 0 degraded)"},
+		upstreamHealthChanges(
+			health(acp.ClusterHealthy, acp.ClusterHealthy),
+			health(acp.ClusterHealthy, acp.ClusterHealthy, acp.ClusterUnhealthy)))
+	assert.Empty(t, upstreamHealthChanges(health(acp.ClusterHealthy), health(acp.ClusterHealthy)))
+}
+
+func TestHandleUpstreamHealth(t *testing.T) {
+	ew := acp.NewEnvoyWatcher()
+	ew.SetClusterHealthCheck(func(context.Context) (*acp.EnvoyFetcherResponse, error) {
+		return &acp.EnvoyFetcherResponse{
+			StatusCode: http.StatusOK,
+			Text:       []byte("cluster.cluster_a.membership_healthy: 0\ncluster.cluster_a.membership_total: 1\n"),
+		}, nil
+	})
+	ambwatch := acp.NewAmbassadorWatcher(ew, acp.NewDiagdWatcher())
+
+	t.Setenv("AMBASSADOR_UPSTREAM_HEALTH_INTERVAL_SECONDS", "")
+	rec := httptest.NewRecorder()
+	handleUpstreamHealth(rec, httptest.NewRequest(http.MethodGet, "/ambassador/v0/upstream_health", nil), ambwatch)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	t.Setenv("AMBASSADOR_UPSTREAM_HEALTH_INTERVAL_SECONDS", "10")
+	rec = httptest.NewRecorder()
+	handleUpstreamHealth(rec, httptest.NewRequest(http.MethodGet, "/ambassador/v0/upstream_health", nil), ambwatch)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	require.NoError(t, ambwatch.FetchUpstreamHealth(context.Background()))
+	rec = httptest.NewRecorder()
+	handleUpstreamHealth(rec, httptest.NewRequest(http.MethodGet, "/ambassador/v0/upstream_health", nil), ambwatch)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"unhealthy": 1`)
+}
+
+func TestRunUpstreamHealth(t *testing.T) {
+	fake := clock.NewFake(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	bus := eventbus.New()
+	ctx, cancel := context.WithCancel(eventbus.WithBus(clock.WithClock(dlog.NewTestContext(t, false), fake), bus))
+	defer cancel()
+
+	var fetches atomic.Int32
+	ew := acp.NewEnvoyWatcher()
+	ew.SetClusterHealthCheck(func(context.Context) (*acp.EnvoyFetcherResponse, error) {
+		fetches.Add(1)
+		return &acp.EnvoyFetcherResponse{
+			StatusCode: http.StatusOK,
+			Text:       []byte("cluster.cluster_a.membership_healthy: 1\ncluster.cluster_a.membership_total: 1\n"),
+		}, nil
+	})
+	ambwatch := acp.NewAmbassadorWatcher(ew, acp.NewDiagdWatcher())
+
+	done := make(chan error, 1)
+	go func() { done <- runUpstreamHealth(ctx, ambwatch, 10*time.Second) }()
+	require.Eventually(t, func() bool { return fake.Timers() == 1 }, time.Second, time.Millisecond)
+
+	// Until the envoy_watcher says anything, every tick fetches.
+	fake.Advance(10 * time.Second)
+	require.Eventually(t, func() bool { return fetches.Load() == 1 }, time.Second, time.Millisecond)
+
+	// While Envoy isn't ready, the ticks don't, and nothing but Envoy's health matters.
+	bus.Publish(eventbus.HealthEvent{Subsystem: "envoy", Reason: "not alive"})
+	bus.Publish(eventbus.HealthEvent{Subsystem: "watcher", Healthy: true, Reason: "restarted"})
+	fake.Advance(10 * time.Second)
+	assert.Never(t, func() bool { return fetches.Load() != 1 }, 100*time.Millisecond, time.Millisecond)
+
+	// Once it's ready, it fetches right away, and then every tick again.
+	bus.Publish(eventbus.HealthEvent{Subsystem: "envoy", Healthy: true, Reason: "alive and ready (LIVE)"})
+	require.Eventually(t, func() bool { return fetches.Load() == 2 }, time.Second, time.Millisecond)
+	fake.Advance(10 * time.Second)
+	require.Eventually(t, func() bool { return fetches.Load() == 3 }, time.Second, time.Millisecond)
+	assert.NotNil(t, ambwatch.UpstreamHealth())
+
+	cancel()
+	require.NoError(t, <-done)
+	assert.Equal(t, 0, fake.Timers())
+	assert.Zero(t, bus.Dropped()["upstream_health"])
+}
@@ -0,0 +1,261 @@
+package entrypoint
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
+	"github.com/emissary-ingress/emissary/v3/pkg/debug"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+)
+
+// UpstreamTLSPolicies are the one place to configure how we originate TLS to upstream services.
+// diagd still generates upstream TLS from TLSContexts, so rather than teach it about a new
+// resource we resolve each policy into a synthetic TLSContext here, and point every Mapping that
+// the policy selects at it. The result of that resolution is published as a debug value, so that
+// "which Mappings are doing mTLS to their backends, and with what?" has a single answer.
+//
+// Policies only select Mappings in their own namespace. If a Mapping is selected by more than one
+// policy, the oldest policy wins. A policy always wins over the Mapping's own `tls` field.
+
+// Using a name with underscores prevents it from colliding with anything real in the cluster,
+// since Kubernetes resources can't have underscores in their name.
+const syntheticUpstreamTLSPrefix = "upstream_tls_policy_"
+
+// upstreamTLSPolicyDebugValue is the name of the debug value holding the []*UpstreamTLSBinding
+// from the most recent reconciliation.
+const upstreamTLSPolicyDebugValue = "upstreamTLSPolicies"
+
+// UpstreamTLSBinding records what a single UpstreamTLSPolicy resolved to.
+type UpstreamTLSBinding struct {
+	// Policy is the "namespace/name" of the UpstreamTLSPolicy.
+	Policy string `json:"policy"`
+	// TLSContext is the name of the synthetic TLSContext generated for the policy.
+	TLSContext string `json:"tls_context,omitempty"`
+	// Mappings are the "namespace/name"s of the Mappings the policy applies to.
+	Mappings []string `json:"mappings,omitempty"`
+	// Overridden are the Mappings whose own `tls` setting was replaced by the policy.
+	Overridden []string `json:"overridden,omitempty"`
+	// Conflicts are the Mappings that the policy selected, but that an older policy already
+	// claimed.
+	Conflicts []string `json:"conflicts,omitempty"`
+	// Error is set if the policy couldn't be applied at all.
+	Error string `json:"error,omitempty"`
+}
+
+// upstreamTLSState is what ReconcileUpstreamTLSPolicies needs to remember between runs. kates
+// only re-decodes the fields of the snapshot that actually changed, so both the Mappings we edited
+// and the TLSContexts we added may well still be there next time around.
+type upstreamTLSState struct {
+	// overrides maps each Mapping we pointed at a synthetic TLSContext to the `tls` it had
+	// before we did so.
+	overrides map[*v3alpha1.Mapping]string
+	// contexts is the set of synthetic TLSContexts we generated, by name.
+	contexts map[string]*v3alpha1.TLSContext
+}
+
+func syntheticUpstreamTLSName(policy *v3alpha1.UpstreamTLSPolicy) string {
+	return fmt.Sprintf("%s%s_%s", syntheticUpstreamTLSPrefix, policy.GetName(), policy.GetNamespace())
+}
+
+// synthesizeUpstreamTLSContext builds the TLSContext that implements a policy.
+func synthesizeUpstreamTLSContext(policy *v3alpha1.UpstreamTLSPolicy) *v3alpha1.TLSContext {
+	tc := &v3alpha1.TLSContext{
+		TypeMeta: kates.TypeMeta{
+			Kind:       "TLSContext",
+			APIVersion: "getambassador.io/v3alpha1",
+		},
+		ObjectMeta: kates.ObjectMeta{
+			Name:      syntheticUpstreamTLSName(policy),
+			Namespace: policy.GetNamespace(),
+		},
+		Spec: v3alpha1.TLSContextSpec{
+			AmbassadorID:  []string{GetAmbassadorID()},
+			Secret:        policy.Spec.ClientCertSecret,
+			CASecret:      policy.Spec.CASecret,
+			SNI:           policy.Spec.SNI,
+			MinTLSVersion: policy.Spec.MinTLSVersion,
+			MaxTLSVersion: policy.Spec.MaxTLSVersion,
+			ALPNProtocols: strings.Join(policy.Spec.ALPNProtocols, ","),
+		},
+	}
+
+	// With SPIFFE, we present our SVID, and trust only the bundle for the upstream's trust
+	// domain. Both Secrets live in the Ambassador namespace rather than the policy's, so we
+	// need secret namespacing regardless of what the Ambassador Module says.
+	//
+	// A bundle can be federated, so signing alone doesn't prove the upstream is in the trust
+	// domain: we also check its URI SAN, against the exact ID if the policy names one.
+	if spiffeConfig := policy.Spec.SPIFFE; spiffeConfig != nil {
+		namespace := GetAmbassadorNamespace()
+		secretNamespacing := true
+		tc.Spec.Secret = spiffeSVIDSecretName + "." + namespace
+		tc.Spec.CASecret = spiffeBundleSecretName(spiffeConfig.TrustDomain) + "." + namespace
+		tc.Spec.SecretNamespacing = &secretNamespacing
+		san := v3alpha1.SubjectAltNameMatcher{SANType: "URI", Prefix: spiffeIDPrefix(spiffeConfig.TrustDomain)}
+		if spiffeConfig.ID != "" {
+			san = v3alpha1.SubjectAltNameMatcher{SANType: "URI", Exact: spiffeConfig.ID}
+		}
+		tc.Spec.MatchSubjectAltNames = []v3alpha1.SubjectAltNameMatcher{san}
+	}
+
+	// With Istio, we present the Istio workload cert, and offer the "istio" ALPN protocol:
+	// that's how the upstream's sidecar recognizes mesh mTLS traffic, rather than passing it
+	// through to the app as plain TLS. We can only verify the upstream if the Istio agent gave
+	// us the mesh root over SDS; the cert directory doesn't include it separately.
+	if policy.Spec.Istio {
+		namespace := GetAmbassadorNamespace()
+		secretNamespacing := true
+		tc.Spec.Secret = istioCertsSecretName + "." + namespace
+		tc.Spec.CASecret = ""
+		if GetIstioSDSSocket() != "" {
+			tc.Spec.CASecret = istioRootCASecretName + "." + namespace
+		}
+		if tc.Spec.ALPNProtocols == "" {
+			tc.Spec.ALPNProtocols = "istio"
+		}
+		tc.Spec.SecretNamespacing = &secretNamespacing
+	}
+
+	return tc
+}
+
+// ReconcileUpstreamTLSPolicies applies the UpstreamTLSPolicies in the snapshot to the Mappings
+// they select. This has to run before ReconcileSecrets, so that the Secrets referenced by the
+// synthetic TLSContexts get pulled in.
+func ReconcileUpstreamTLSPolicies(ctx context.Context, sh *SnapshotHolder, deltas *[]*kates.Delta) error {
+	envAmbID := GetAmbassadorID()
+
+	// Start by undoing whatever we did last time.
+	for _, mapping := range sh.k8sSnapshot.Mappings {
+		if orig, ok := sh.upstreamTLS.overrides[mapping]; ok {
+			mapping.Spec.TLS = orig
+		}
+	}
+	tlsContexts := make([]*v3alpha1.TLSContext, 0, len(sh.k8sSnapshot.TLSContexts))
+	for _, tc := range sh.k8sSnapshot.TLSContexts {
+		if !strings.HasPrefix(tc.GetName(), syntheticUpstreamTLSPrefix) {
+			tlsContexts = append(tlsContexts, tc)
+		}
+	}
+
+	var policies []*v3alpha1.UpstreamTLSPolicy
+	for _, policy := range sh.k8sSnapshot.UpstreamTLSPolicies {
+		if policy.Spec.AmbassadorID.Matches(envAmbID) {
+			policies = append(policies, policy)
+		}
+	}
+	sort.SliceStable(policies, func(i, j int) bool {
+		ti, tj := policies[i].GetCreationTimestamp(), policies[j].GetCreationTimestamp()
+		if !ti.Equal(&tj) {
+			return ti.Before(&tj)
+		}
+		if policies[i].GetNamespace() != policies[j].GetNamespace() {
+			return policies[i].GetNamespace() < policies[j].GetNamespace()
+		}
+		return policies[i].GetName() < policies[j].GetName()
+	})
+
+	overrides := make(map[*v3alpha1.Mapping]string)
+	contexts := make(map[string]*v3alpha1.TLSContext)
+	claimedBy := make(map[*v3alpha1.Mapping]string)
+	bindings := make([]*UpstreamTLSBinding, 0, len(policies))
+	for _, policy := range policies {
+		binding := &UpstreamTLSBinding{
+			Policy: policy.GetNamespace() + "/" + policy.GetName(),
+		}
+		bindings = append(bindings, binding)
+
+		selector, err := metav1.LabelSelectorAsSelector(policy.Spec.MappingSelector)
+		if err != nil {
+			binding.Error = fmt.Sprintf("invalid mappingSelector: %v", err)
+			dlog.Errorf(ctx, "ReconcileUpstreamTLSPolicies: %s: %s", binding.Policy, binding.Error)
+			continue
+		}
+
+		if spiffeConfig := policy.Spec.SPIFFE; spiffeConfig != nil && spiffeConfig.ID != "" &&
+			!strings.HasPrefix(spiffeConfig.ID, spiffeIDPrefix(spiffeConfig.TrustDomain)) {
+			binding.Error = fmt.Sprintf("spiffe.id %q is not in trust domain %q",
+				spiffeConfig.ID, spiffeConfig.TrustDomain)
+			dlog.Errorf(ctx, "ReconcileUpstreamTLSPolicies: %s: %s", binding.Policy, binding.Error)
+			continue
+		}
+
+		tlsName := syntheticUpstreamTLSName(policy)
+		for _, mapping := range sh.k8sSnapshot.Mappings {
+			if mapping.GetNamespace() != policy.GetNamespace() ||
+				!mapping.Spec.AmbassadorID.Matches(envAmbID) ||
+				!selector.Matches(kates.LabelSet(mapping.GetLabels())) {
+				continue
+			}
+			mappingName := mapping.GetNamespace() + "/" + mapping.GetName()
+			if owner, claimed := claimedBy[mapping]; claimed {
+				binding.Conflicts = append(binding.Conflicts, mappingName)
+				dlog.Warnf(ctx, "ReconcileUpstreamTLSPolicies: Mapping %s is selected by both %s and %s; using %s",
+					mappingName, owner, binding.Policy, owner)
+				continue
+			}
+			if mapping.Spec.TLS != "" {
+				binding.Overridden = append(binding.Overridden, mappingName)
+				dlog.Debugf(ctx, "ReconcileUpstreamTLSPolicies: %s overrides tls=%q on Mapping %s",
+					binding.Policy, mapping.Spec.TLS, mappingName)
+			}
+			claimedBy[mapping] = binding.Policy
+			overrides[mapping] = mapping.Spec.TLS
+			mapping.Spec.TLS = tlsName
+			binding.Mappings = append(binding.Mappings, mappingName)
+		}
+
+		// There's no point in handing diagd a TLSContext that nothing uses.
+		if len(binding.Mappings) > 0 {
+			binding.TLSContext = tlsName
+			contexts[tlsName] = synthesizeUpstreamTLSContext(policy)
+		}
+	}
+
+	names := make([]string, 0, len(contexts))
+	for name := range contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tc := contexts[name]
+		tlsContexts = append(tlsContexts, tc)
+		if _, existed := sh.upstreamTLS.contexts[name]; !existed {
+			*deltas = append(*deltas, &kates.Delta{
+				TypeMeta:   tc.TypeMeta,
+				ObjectMeta: tc.ObjectMeta,
+				DeltaType:  kates.ObjectAdd,
+			})
+		}
+	}
+	var removed []string
+	for name := range sh.upstreamTLS.contexts {
+		if _, exists := contexts[name]; !exists {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(removed)
+	for _, name := range removed {
+		tc := sh.upstreamTLS.contexts[name]
+		*deltas = append(*deltas, &kates.Delta{
+			TypeMeta:   tc.TypeMeta,
+			ObjectMeta: tc.ObjectMeta,
+			DeltaType:  kates.ObjectDelete,
+		})
+	}
+
+	sh.k8sSnapshot.TLSContexts = tlsContexts
+	sh.upstreamTLS = upstreamTLSState{
+		overrides: overrides,
+		contexts:  contexts,
+	}
+	debug.FromContext(ctx).Value(upstreamTLSPolicyDebugValue).Store(bindings)
+
+	return nil
+}
@@ -5,89 +5,26 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"strconv"
+	"reflect"
 	"sync"
 	"sync/atomic"
-	"time"
 
 	gw "sigs.k8s.io/gateway-api/apis/v1alpha1"
 
 	"github.com/datawire/dlib/dgroup"
 	"github.com/datawire/dlib/dlog"
-	"github.com/emissary-ingress/emissary/v3/pkg/acp"
 	"github.com/emissary-ingress/emissary/v3/pkg/ambex"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
 	"github.com/emissary-ingress/emissary/v3/pkg/debug"
 	ecp_v3_cache "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/cache/v3"
+	"github.com/emissary-ingress/emissary/v3/pkg/eventbus"
+	"github.com/emissary-ingress/emissary/v3/pkg/faultinject"
 	"github.com/emissary-ingress/emissary/v3/pkg/gateway"
 	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	"github.com/emissary-ingress/emissary/v3/pkg/resolverplugin"
 	"github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
 )
 
-func WatchAllTheThings(
-	ctx context.Context,
-	ambwatch *acp.AmbassadorWatcher,
-	encoded *atomic.Value,
-	fastpathCh chan<- *ambex.FastpathSnapshot,
-	clusterID string,
-	version string,
-) error {
-	client, err := kates.NewClient(kates.ClientConfig{})
-	if err != nil {
-		return err
-	}
-	intv, err := strconv.Atoi(env("AMBASSADOR_RECONFIG_MAX_DELAY", "1"))
-	if err != nil {
-		return err
-	}
-	maxInterval := time.Duration(intv) * time.Second
-	err = client.MaxAccumulatorInterval(maxInterval)
-	if err != nil {
-		return err
-	}
-	dlog.Infof(ctx, "AMBASSADOR_RECONFIG_MAX_DELAY set to %d", intv)
-
-	serverTypeList, err := client.ServerResources()
-	if err != nil {
-		// It's possible that an error prevented listing some apigroups, but not all; so
-		// process the output even if there is an error.
-		dlog.Infof(ctx, "Warning, unable to list api-resources: %v", err)
-	}
-
-	interestingTypes := GetInterestingTypes(ctx, serverTypeList)
-	queries := GetQueries(ctx, interestingTypes)
-
-	ambassadorMeta := getAmbassadorMeta(GetAmbassadorID(), clusterID, version, client)
-
-	// **** SETUP DONE for the Kubernetes Watcher
-
-	notify := func(ctx context.Context, disposition SnapshotDisposition, _ []byte) error {
-		if disposition == SnapshotReady {
-			return notifyReconfigWebhooks(ctx, ambwatch)
-		}
-		return nil
-	}
-
-	fastpathUpdate := func(ctx context.Context, fastpathSnapshot *ambex.FastpathSnapshot) {
-		fastpathCh <- fastpathSnapshot
-	}
-
-	k8sSrc := newK8sSource(client)
-	consulSrc := watchConsul
-	istioCertSrc := newIstioCertSource()
-
-	return watchAllTheThingsInternal(
-		ctx,
-		encoded,
-		k8sSrc,
-		queries,
-		consulSrc, // watchConsulFunc
-		istioCertSrc,
-		notify,         // snapshotProcessor
-		fastpathUpdate, // fastpathProcessor
-		ambassadorMeta,
-	)
-}
-
 func getAmbassadorMeta(ambassadorID string, clusterID string, version string, client *kates.Client) *snapshot.AmbassadorMetaInfo {
 	ambMeta := &snapshot.AmbassadorMetaInfo{
 		ClusterID:         clusterID,
@@ -109,7 +46,7 @@ const (
 	// Indicates the watcher is still in the booting process and the snapshot has dangling pointers.
 	SnapshotIncomplete SnapshotDisposition = iota
 	// Indicates that the watcher is deferring processing of the snapshot because it is considered
-	// to be a product of churn.
+	// to be a product of churn, or because it's being held until the next change window.
 	SnapshotDefer
 	// Indicates that the watcher is dropping the snapshot because it has determined that it is
 	// logically a noop.
@@ -186,10 +123,13 @@ func watchAllTheThingsInternal(
 	k8sSrc K8sSource,
 	queries []kates.Query,
 	watchConsulFunc watchConsulFunc,
+	watchPluginFunc watchPluginFunc,
 	istioCertSrc IstioCertSource,
+	spiffeSrc SPIFFESource,
 	snapshotProcessor SnapshotProcessor,
 	fastpathProcessor FastpathProcessor,
 	ambassadorMeta *snapshot.AmbassadorMetaInfo,
+	clk clock.Clock,
 ) error {
 	// Ambassador has three sources of inputs: kubernetes, consul, and the filesystem. The job
 	// of the watchAllTheThingsInternal loop is to read updates from all three of these sources,
@@ -211,6 +151,9 @@ func watchAllTheThingsInternal(
 	// consul resolver. We use the ConsulResolver that a given Mapping is configured with to find
 	// the datacenter to query.
 	//
+	// Resolver plugins work the same way as consul: we watch the services defined in Mappings that
+	// are configured to use a PluginResolver, using the plugin that the PluginResolver names.
+	//
 	// The filesystem datasource is for istio secrets. XXX fill in more
 
 	grp := dgroup.NewGroup(ctx, dgroup.GroupConfig{})
@@ -223,17 +166,29 @@ func watchAllTheThingsInternal(
 	// Setup our three sources of ambassador inputs: kubernetes, consul, and the filesystem. Each of
 	// these have interfaces that enable us to run with the "real" implementation or a mock
 	// implementation for our Fake test harness.
-	k8sWatcher, err := k8sSrc.Watch(ctx, queries...)
+	//
+	// The Kubernetes watch gets a context of its own, so that a resync can throw it away and
+	// start a new one.
+	k8sCtx, cancelK8s := context.WithCancel(ctx)
+	defer func() { cancelK8s() }()
+	k8sWatcher, err := k8sSrc.Watch(k8sCtx, queries...)
 	if err != nil {
 		return err
 	}
+	resync := resyncerFromContext(ctx)
 	consulWatcher := newConsulWatcher(watchConsulFunc)
 	grp.Go("consul", consulWatcher.run)
+	pluginWatcher := newPluginWatcher(watchPluginFunc)
+	grp.Go("resolver-plugins", pluginWatcher.run)
 	istioCertWatcher, err := istioCertSrc.Watch(ctx)
 	if err != nil {
 		return err
 	}
 	istio := newIstioCertWatchManager(ctx, istioCertWatcher)
+	spiffeWatcher, err := spiffeSrc.Watch(ctx)
+	if err != nil {
+		return err
+	}
 
 	// SnapshotHolder tracks all the data structures that get updated by the various sources of
 	// information. It also holds the business logic that converts the data as received to a more
@@ -243,6 +198,22 @@ func watchAllTheThingsInternal(
 	if err != nil {
 		return err
 	}
+	snapshots.changeWindows = newChangeWindows(ctx, clk)
+	grp.Go("change-windows", snapshots.changeWindows.run)
+	snapshots.revocation = newRevocationWatcher(clk)
+	grp.Go("revocation", snapshots.revocation.run)
+	snapshots.devOverrides = newDevOverrides(ctx, GetDevOverridesFile(), clk)
+	grp.Go("dev-overrides", snapshots.devOverrides.run)
+	if IsScopedSecrets() {
+		snapshots.scopedSecrets = newScopedSecretWatcher(k8sSrc)
+		grp.Go("scoped-secrets", snapshots.scopedSecrets.run)
+	}
+	snapshots.approvals = approvalsFromContext(ctx)
+	snapshots.maintenance = maintenanceSwitchesFromContext(ctx)
+	if snapshots.maintenance == nil {
+		// Without the admin API to flip them, there are still the annotations.
+		snapshots.maintenance = newMaintenanceSwitches()
+	}
 
 	// This points to notifyCh when we have updated information to send and nil when we have no new
 	// information. This is deliberately nil to begin with as we have nothing to send yet.
@@ -252,6 +223,7 @@ func watchAllTheThingsInternal(
 		for {
 			select {
 			case sh := <-notifyCh:
+				faultinject.DelaySnapshot(ctx)
 				if err := sh.Notify(ctx, encoded, consulWatcher, snapshotProcessor); err != nil {
 					return err
 				}
@@ -274,8 +246,11 @@ func watchAllTheThingsInternal(
 
 			select {
 			case <-k8sWatcher.Changed():
+				if faultinject.DropWatchEvent(ctx) {
+					continue
+				}
 				// Kubernetes has some changes, so we need to handle them.
-				changed, err := snapshots.K8sUpdate(ctx, k8sWatcher, consulWatcher, fastpathProcessor)
+				changed, err := snapshots.K8sUpdate(ctx, k8sWatcher, consulWatcher, pluginWatcher, fastpathProcessor)
 				if err != nil {
 					return err
 				}
@@ -283,16 +258,71 @@ func watchAllTheThingsInternal(
 					continue
 				}
 				out = notifyCh
+			case <-resync.requested():
+				// Someone wants everything listed again (see resync.go). The new watch's first
+				// update will have all of it, and the snapshot it makes will go out regardless.
+				generation := resync.start()
+				dlog.Infof(ctx, "WATCHER: resync %d: listing everything again", generation)
+				cancelK8s()
+				k8sCtx, cancelK8s = context.WithCancel(ctx)
+				newWatcher, err := k8sSrc.Watch(k8sCtx, queries...)
+				if err == nil {
+					k8sWatcher = newWatcher
+					err = snapshots.StartResync(generation)
+				}
+				if err != nil {
+					resync.finish(generation, err)
+					return err
+				}
 			case <-consulWatcher.changed():
 				dlog.Debugf(ctx, "WATCHER: Consul fired")
 				snapshots.ConsulUpdate(ctx, consulWatcher, fastpathProcessor)
 				out = notifyCh
+			case <-pluginWatcher.changed():
+				// Plugin endpoints only go to ambex, not diagd, so there's no snapshot to send.
+				dlog.Debugf(ctx, "WATCHER: resolver plugins fired")
+				snapshots.PluginUpdate(ctx, pluginWatcher, fastpathProcessor)
 			case icertUpdate := <-istio.Changed():
 				// The Istio cert has some changes, so we need to handle them.
 				if _, err := snapshots.IstioUpdate(ctx, istio, icertUpdate); err != nil {
 					return err
 				}
 				out = notifyCh
+			case spiffeUpdate := <-spiffeWatcher.Changed():
+				// The Workload API has rotated our SVID, or changed the trust bundles.
+				dlog.Debugf(ctx, "WATCHER: SPIFFE fired")
+				if err := snapshots.SPIFFEUpdate(ctx, spiffeUpdate); err != nil {
+					return err
+				}
+				out = notifyCh
+			case <-snapshots.revocation.changed():
+				// A CRL or OCSP staple has been fetched (or has expired).
+				dlog.Debugf(ctx, "WATCHER: revocation fired")
+				if err := snapshots.RevocationUpdate(ctx); err != nil {
+					return err
+				}
+				out = notifyCh
+			case <-snapshots.scopedSecrets.changed():
+				// A Secret that's watched by name has appeared, changed, or gone away.
+				dlog.Debugf(ctx, "WATCHER: scoped secrets fired")
+				if err := snapshots.ScopedSecretsUpdate(ctx); err != nil {
+					return err
+				}
+				out = notifyCh
+			case <-snapshots.devOverrides.changed():
+				// The dev overrides file has changed.
+				dlog.Debugf(ctx, "WATCHER: dev overrides fired")
+				snapshots.DevOverridesUpdate(ctx)
+				out = notifyCh
+			case <-snapshots.maintenance.changed():
+				// A maintenance switch has been flipped through the admin API.
+				dlog.Debugf(ctx, "WATCHER: maintenance fired")
+				snapshots.MaintenanceUpdate(ctx)
+				out = notifyCh
+			case <-snapshots.changeWindows.openedCh():
+				// A change window just opened, so send whatever it was holding.
+				dlog.Debugf(ctx, "WATCHER: change window opened")
+				out = notifyCh
 			case out <- snapshots:
 				out = nil
 			case <-ctx.Done():
@@ -322,6 +352,9 @@ type SnapshotHolder struct {
 	// they always represent the entire state of their respective worlds.
 	k8sSnapshot    *snapshot.KubernetesSnapshot
 	consulSnapshot *snapshot.ConsulSnapshot
+	// Endpoints from resolver plugins. These aren't part of the snapshot that goes to diagd:
+	// Mappings that use a PluginResolver always use EDS, so only ambex needs them.
+	pluginEndpoints map[string]resolverplugin.Endpoints
 	// XXX: you would expect there to be an analogous snapshot for istio secrets, however the istio
 	// source works by directly munging the k8sSnapshot.
 
@@ -333,6 +366,9 @@ type SnapshotHolder struct {
 	endpointRoutingInfo endpointRoutingInfo
 	dispatcher          *gateway.Dispatcher
 
+	// What ReconcileUpstreamTLSPolicies did to the k8sSnapshot last time, so it can undo it.
+	upstreamTLS upstreamTLSState
+
 	// Serial number that tracks if we need to send snapshot changes or not. This is incremented
 	// when a change worth sending is made, and we copy it over to snapshotNotifiedCount when the
 	// change is sent.
@@ -341,9 +377,40 @@ type SnapshotHolder struct {
 
 	// Has the very first reconfig happened?
 	firstReconfig bool
+
+	// When configuration changes are allowed to go out. nil means any time.
+	changeWindows *changeWindows
+
+	// Fetches CRLs and OCSP staples, and posts them as FSSecrets. nil means nothing does.
+	revocation *revocationWatcher
+
+	// The SPIFFE Secrets that the last SPIFFE update put in FSSecrets; see spiffe.go.
+	spiffeSecrets map[snapshot.SecretRef]bool
+
+	// Watches the Secrets that the snapshot refers to, one at a time, instead of all of them;
+	// see scopedsecrets.go. nil means they're watched the usual way.
+	scopedSecrets *scopedSecretWatcher
+
+	// Sends selected Mappings to local upstreams. nil means there's no dev overrides file.
+	devOverrides *devOverrides
+
+	// Holds back changes to resources that need approval; see approvals.go. nil means nothing
+	// needs approval.
+	approvals *approvals
+
+	// Turns maintenance on and off for Mappings and Hosts; see maintenance.go.
+	maintenance *maintenanceSwitches
+
+	// The HTTPRoute matches that duplicate a Mapping's route; see routeoverlap.go.
+	routeOverlaps []snapshot.RouteOverlap
+
+	// The generation of the resync whose listing the watcher is waiting for, and of the one whose
+	// listing is done but hasn't gone out in a snapshot yet; see resync.go. Zero means none.
+	resyncListing uint64
+	resyncListed  uint64
 }
 
-func NewSnapshotHolder(ambassadorMeta *snapshot.AmbassadorMetaInfo) (*SnapshotHolder, error) {
+func newGatewayDispatcher() (*gateway.Dispatcher, error) {
 	disp := gateway.NewDispatcher()
 	err := disp.Register("Gateway", func(untyped kates.Object) (*gateway.CompiledConfig, error) {
 		return gateway.Compile_Gateway(untyped.(*gw.Gateway))
@@ -357,6 +424,14 @@ func NewSnapshotHolder(ambassadorMeta *snapshot.AmbassadorMetaInfo) (*SnapshotHo
 	if err != nil {
 		return nil, err
 	}
+	return disp, nil
+}
+
+func NewSnapshotHolder(ambassadorMeta *snapshot.AmbassadorMetaInfo) (*SnapshotHolder, error) {
+	disp, err := newGatewayDispatcher()
+	if err != nil {
+		return nil, err
+	}
 	validator, err := newResourceValidator()
 	if err != nil {
 		return nil, err
@@ -366,25 +441,47 @@ func NewSnapshotHolder(ambassadorMeta *snapshot.AmbassadorMetaInfo) (*SnapshotHo
 		ambassadorMeta:      ambassadorMeta,
 		k8sSnapshot:         NewKubernetesSnapshot(),
 		consulSnapshot:      &snapshot.ConsulSnapshot{},
+		pluginEndpoints:     make(map[string]resolverplugin.Endpoints),
 		endpointRoutingInfo: newEndpointRoutingInfo(),
 		dispatcher:          disp,
 		firstReconfig:       true,
 	}, nil
 }
 
+// StartResync forgets what's been worked out from what the old Kubernetes watch found, for the
+// resync generation: which resources failed validation, what the gateway dispatcher compiled, and
+// the deltas that haven't been sent. The new watch's first update brings it all back.
+func (sh *SnapshotHolder) StartResync(generation uint64) error {
+	disp, err := newGatewayDispatcher()
+	if err != nil {
+		return err
+	}
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+	sh.dispatcher = disp
+	sh.validator.invalid = map[string]*kates.Unstructured{}
+	sh.unsentDeltas = nil
+	sh.resyncListing = generation
+	return nil
+}
+
 // Get the raw update from the kubernetes watcher, then redo our computed view.
 func (sh *SnapshotHolder) K8sUpdate(
 	ctx context.Context,
 	watcher K8sWatcher,
 	consulWatcher *consulWatcher,
+	pluginWatcher *pluginWatcher,
 	fastpathProcessor FastpathProcessor,
 ) (bool, error) {
 	dbg := debug.FromContext(ctx)
 
 	katesUpdateTimer := dbg.Timer("katesUpdate")
 	parseAnnotationsTimer := dbg.Timer("parseAnnotations")
+	reconcileUpstreamTLSTimer := dbg.Timer("reconcileUpstreamTLSPolicies")
+	checkIstioDestinationRulesTimer := dbg.Timer("checkIstioDestinationRules")
 	reconcileSecretsTimer := dbg.Timer("reconcileSecrets")
 	reconcileConsulTimer := dbg.Timer("reconcileConsul")
+	reconcilePluginResolversTimer := dbg.Timer("reconcilePluginResolvers")
 	reconcileAuthServicesTimer := dbg.Timer("reconcileAuthServices")
 	reconcileRateLimitServicesTimer := dbg.Timer("reconcileRateLimitServices")
 
@@ -404,7 +501,7 @@ func (sh *SnapshotHolder) K8sUpdate(
 		var err error
 		katesUpdateTimer.Time(func() {
 			changed, err = watcher.FilteredUpdate(ctx, sh.k8sSnapshot, &deltas, func(un *kates.Unstructured) bool {
-				return sh.validator.isValid(ctx, un)
+				return sh.validator.isValid(ctx, un) && sh.approvals.admit(ctx, un)
 			})
 		})
 
@@ -412,6 +509,18 @@ func (sh *SnapshotHolder) K8sUpdate(
 			dlog.Errorf(ctx, "[WATCHER]: ERROR calculating changes in an update to the cluster config: %v", err)
 			return false, err
 		}
+
+		// The first update from a resync's watch has everything, and it all goes out again,
+		// whether or not it looks any different.
+		resyncing := sh.resyncListing != 0
+		if resyncing {
+			dlog.Infof(ctx, "[WATCHER]: resync %d: listing done", sh.resyncListing)
+			resyncerFromContext(ctx).listed(sh.resyncListing)
+			sh.resyncListed, sh.resyncListing = sh.resyncListing, 0
+			changed = true
+			endpointsChanged = true
+			dispatcherChanged = true
+		}
 		if !changed {
 			dlog.Debugf(ctx, "[WATCHER]: K8sUpdate did not detected any change to the resources relevant to this instance of Ambassador")
 			return false, err
@@ -449,7 +558,27 @@ func (sh *SnapshotHolder) K8sUpdate(
 				dlog.Errorf(ctx, "[WATCHER]: ERROR parsing annotations in configuration change: %v", err)
 			}
 		})
+		sh.approvals.apply(ctx, sh.k8sSnapshot)
+		sh.devOverrides.apply(ctx, sh.k8sSnapshot)
+		sh.maintenance.apply(ctx, sh.k8sSnapshot)
+
+		// A Mapping change can add or remove an overlap, and with it what the HTTPRoutes
+		// compile to.
+		if overlaps := findRouteOverlaps(sh.k8sSnapshot); !reflect.DeepEqual(overlaps, sh.routeOverlaps) {
+			sh.routeOverlaps = overlaps
+			dispatcherChanged = true
+		}
 
+		reconcileUpstreamTLSTimer.Time(func() {
+			err = ReconcileUpstreamTLSPolicies(ctx, sh, &deltas)
+		})
+		if err != nil {
+			dlog.Errorf(ctx, "[WATCHER]: ERROR reconciling UpstreamTLSPolicies: %v", err)
+			return false, err
+		}
+		checkIstioDestinationRulesTimer.Time(func() {
+			CheckIstioDestinationRules(ctx, sh)
+		})
 		reconcileSecretsTimer.Time(func() {
 			err = ReconcileSecrets(ctx, sh)
 		})
@@ -464,6 +593,11 @@ func (sh *SnapshotHolder) K8sUpdate(
 			dlog.Errorf(ctx, "[WATCHER]: ERROR reconciling Consul resources: %v", err)
 			return false, err
 		}
+		reconcilePluginResolversTimer.Time(func() {
+			ReconcilePluginResolvers(ctx, pluginWatcher, sh.k8sSnapshot)
+			// Reconciling may have dropped the endpoints of services that nothing uses any more.
+			pluginWatcher.update(sh.pluginEndpoints)
+		})
 		reconcileAuthServicesTimer.Time(func() {
 			err = ReconcileAuthServices(ctx, sh, &deltas)
 		})
@@ -488,14 +622,30 @@ func (sh *SnapshotHolder) K8sUpdate(
 		}
 
 		endpointsOnly := true
+		bus := eventbus.FromContext(ctx)
 		for _, delta := range deltas {
 			sh.unsentDeltas = append(sh.unsentDeltas, delta)
+			bus.Publish(resourceEvent(delta))
+
+			if delta.DeltaType == kates.ObjectDelete {
+				forgetResource(ctx, resourceTimingKey(delta.Kind, delta.Namespace, delta.Name))
+				sh.approvals.forget(delta.Kind, delta.Namespace, delta.Name)
+			}
 
 			if delta.Kind == "Endpoints" {
 				key := fmt.Sprintf("%s:%s", delta.Namespace, delta.Name)
 				if sh.endpointRoutingInfo.endpointWatches[key] || sh.dispatcher.IsWatched(delta.Namespace, delta.Name) {
 					endpointsChanged = true
 				}
+			} else if delta.Kind == "EndpointSlice" {
+				// EndpointSlices are named for their Service plus a random suffix, so we can't
+				// tell which Service this is; the zones of any of them could have changed.
+				endpointsChanged = true
+			} else if delta.Kind == "Pod" {
+				// Pods are only watched for their labels, for subset routing.
+				if sh.endpointRoutingInfo.hasSubsetsIn(delta.Namespace) {
+					endpointsChanged = true
+				}
 			} else {
 				endpointsOnly = false
 			}
@@ -507,29 +657,23 @@ func (sh *SnapshotHolder) K8sUpdate(
 				}
 			}
 		}
-		if !endpointsOnly {
+		if !endpointsOnly || resyncing {
 			sh.snapshotChangeCount += 1
 		}
 
 		if endpointsChanged || dispatcherChanged {
-			endpoints = makeEndpoints(ctx, sh.k8sSnapshot, sh.consulSnapshot.Endpoints)
+			endpoints = makeEndpoints(ctx, sh.k8sSnapshot, sh.consulSnapshot.Endpoints, sh.pluginEndpoints, sh.endpointRoutingInfo.subsetKeys)
 			for _, gwc := range sh.k8sSnapshot.GatewayClasses {
-				if err := sh.dispatcher.Upsert(gwc); err != nil {
-					// TODO: Should this be more severe?
-					dlog.Error(ctx, err)
-				}
+				sh.upsertDispatched(ctx, gwc)
 			}
 			for _, gw := range sh.k8sSnapshot.Gateways {
-				if err := sh.dispatcher.Upsert(gw); err != nil {
-					// TODO: Should this be more severe?
-					dlog.Error(ctx, err)
-				}
-
+				sh.upsertDispatched(ctx, gw)
 			}
 			for _, hr := range sh.k8sSnapshot.HTTPRoutes {
-				if err := sh.dispatcher.Upsert(hr); err != nil {
-					// TODO: Should this be more severe?
-					dlog.Error(ctx, err)
+				if resolved := resolveRouteOverlaps(ctx, hr, sh.routeOverlaps); resolved != nil {
+					sh.upsertDispatched(ctx, resolved)
+				} else {
+					sh.dispatcher.DeleteKey("HTTPRoute", hr.GetNamespace(), hr.GetName())
 				}
 			}
 
@@ -557,6 +701,21 @@ func (sh *SnapshotHolder) K8sUpdate(
 	return changed, nil
 }
 
+// upsertDispatched hands a resource to the gateway dispatcher, keeping track of how long it takes
+// to compile. The caller must hold sh.mutex.
+func (sh *SnapshotHolder) upsertDispatched(ctx context.Context, obj kates.Object) {
+	key := resourceTimingKey(obj.GetObjectKind().GroupVersionKind().Kind, obj.GetNamespace(), obj.GetName())
+
+	var err error
+	timeResource(ctx, compileResourcePhase, key, func() {
+		err = sh.dispatcher.Upsert(obj)
+	})
+	if err != nil {
+		// TODO: Should this be more severe?
+		dlog.Error(ctx, err)
+	}
+}
+
 func (sh *SnapshotHolder) ConsulUpdate(ctx context.Context, consulWatcher *consulWatcher, fastpathProcessor FastpathProcessor) bool {
 	var endpoints *ambex.Endpoints
 	var dispSnapshot *ecp_v3_cache.Snapshot
@@ -564,7 +723,7 @@ func (sh *SnapshotHolder) ConsulUpdate(ctx context.Context, consulWatcher *consu
 		sh.mutex.Lock()
 		defer sh.mutex.Unlock()
 		consulWatcher.update(sh.consulSnapshot)
-		endpoints = makeEndpoints(ctx, sh.k8sSnapshot, sh.consulSnapshot.Endpoints)
+		endpoints = makeEndpoints(ctx, sh.k8sSnapshot, sh.consulSnapshot.Endpoints, sh.pluginEndpoints, sh.endpointRoutingInfo.subsetKeys)
 		_, dispSnapshot = sh.dispatcher.GetSnapshot(ctx)
 	}()
 	fastpathProcessor(ctx, &ambex.FastpathSnapshot{
@@ -574,6 +733,22 @@ func (sh *SnapshotHolder) ConsulUpdate(ctx context.Context, consulWatcher *consu
 	return true
 }
 
+func (sh *SnapshotHolder) PluginUpdate(ctx context.Context, pluginWatcher *pluginWatcher, fastpathProcessor FastpathProcessor) {
+	var endpoints *ambex.Endpoints
+	var dispSnapshot *ecp_v3_cache.Snapshot
+	func() {
+		sh.mutex.Lock()
+		defer sh.mutex.Unlock()
+		pluginWatcher.update(sh.pluginEndpoints)
+		endpoints = makeEndpoints(ctx, sh.k8sSnapshot, sh.consulSnapshot.Endpoints, sh.pluginEndpoints, sh.endpointRoutingInfo.subsetKeys)
+		_, dispSnapshot = sh.dispatcher.GetSnapshot(ctx)
+	}()
+	fastpathProcessor(ctx, &ambex.FastpathSnapshot{
+		Endpoints: endpoints,
+		Snapshot:  dispSnapshot,
+	})
+}
+
 func (sh *SnapshotHolder) IstioUpdate(ctx context.Context, istio *istioCertWatchManager,
 	icertUpdate IstioCertUpdate) (bool, error) {
 	dbg := debug.FromContext(ctx)
@@ -600,6 +775,52 @@ func (sh *SnapshotHolder) IstioUpdate(ctx context.Context, istio *istioCertWatch
 	return true, nil
 }
 
+// RevocationUpdate puts the latest CRLs and OCSP staples in the snapshot.
+func (sh *SnapshotHolder) RevocationUpdate(ctx context.Context) error {
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	sh.revocation.update(sh.k8sSnapshot.FSSecrets)
+	if err := ReconcileSecrets(ctx, sh); err != nil {
+		return err
+	}
+
+	sh.snapshotChangeCount += 1
+	return nil
+}
+
+// SPIFFEUpdate replaces the SPIFFE Secrets in the snapshot with the ones in update.
+func (sh *SnapshotHolder) SPIFFEUpdate(ctx context.Context, update SPIFFEUpdate) error {
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	sh.spiffeSecrets = spiffeUpdate(sh.k8sSnapshot.FSSecrets, sh.spiffeSecrets, update)
+	if err := ReconcileSecrets(ctx, sh); err != nil {
+		return err
+	}
+
+	sh.snapshotChangeCount += 1
+	return nil
+}
+
+// DevOverridesUpdate applies the dev overrides file again, after it has changed.
+func (sh *SnapshotHolder) DevOverridesUpdate(ctx context.Context) {
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	sh.devOverrides.apply(ctx, sh.k8sSnapshot)
+	sh.snapshotChangeCount += 1
+}
+
+// MaintenanceUpdate applies the maintenance switches again, after one has been flipped.
+func (sh *SnapshotHolder) MaintenanceUpdate(ctx context.Context) {
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	sh.maintenance.apply(ctx, sh.k8sSnapshot)
+	sh.snapshotChangeCount += 1
+}
+
 func (sh *SnapshotHolder) Notify(
 	ctx context.Context,
 	encoded *atomic.Value,
@@ -614,6 +835,8 @@ func (sh *SnapshotHolder) Notify(
 	var snapshotJSON []byte
 	var bootstrapped bool
 	changed := true
+	held := false
+	var resynced uint64
 
 	err := func() error {
 		sh.mutex.Lock()
@@ -628,8 +851,11 @@ func (sh *SnapshotHolder) Notify(
 			Kubernetes:     sh.k8sSnapshot,
 			Consul:         sh.consulSnapshot,
 			Invalid:        sh.validator.getInvalid(),
+			ModuleErrors:   moduleConfigErrors(sh.k8sSnapshot),
+			RouteOverlaps:  sh.routeOverlaps,
 			Deltas:         sh.unsentDeltas,
 			AmbassadorMeta: sh.ambassadorMeta,
+			Resync:         sh.resyncListed,
 		}
 
 		var err error
@@ -638,7 +864,13 @@ func (sh *SnapshotHolder) Notify(
 			return err
 		}
 
-		bootstrapped = consulWatcher.isBootstrapped()
+		bootstrapped = consulWatcher.isBootstrapped() && sh.scopedSecrets.isBootstrapped()
+		// Someone asking for a resync wants it now, change window or no.
+		if bootstrapped && !sh.firstReconfig && sh.resyncListed == 0 && !sh.changeWindows.allow(ctx, sh.k8sSnapshot) {
+			// Hold on to the change until the next change window opens.
+			held = true
+			return nil
+		}
 		if bootstrapped {
 			sh.unsentDeltas = nil
 			if sh.firstReconfig {
@@ -646,6 +878,7 @@ func (sh *SnapshotHolder) Notify(
 				sh.firstReconfig = false
 			}
 			sh.snapshotChangeNotified = sh.snapshotChangeCount
+			resynced, sh.resyncListed = sh.resyncListed, 0
 		}
 		return nil
 	}()
@@ -655,10 +888,17 @@ func (sh *SnapshotHolder) Notify(
 	if !changed {
 		return nil
 	}
+	if held {
+		return snapshotProcessor(ctx, SnapshotDefer, snapshotJSON)
+	}
 
 	if bootstrapped {
-		// ...then stash this snapshot and fire off webhooks.
+		// ...then stash this snapshot, tell whoever's subscribed, and fire off webhooks.
 		encoded.Store(snapshotJSON)
+		eventbus.FromContext(ctx).Publish(eventbus.SnapshotEvent{
+			Time:     clock.FromContext(ctx).Now(),
+			Snapshot: snapshotJSON,
+		})
 
 		// Finally, use the reconfigure webhooks to let the rest of Ambassador
 		// know about the new configuration.
@@ -666,6 +906,12 @@ func (sh *SnapshotHolder) Notify(
 		notifyWebhooksTimer.Time(func() {
 			err = snapshotProcessor(ctx, SnapshotReady, snapshotJSON)
 		})
+		if resynced != 0 {
+			resyncerFromContext(ctx).finish(resynced, err)
+			if err == nil {
+				dlog.Infof(ctx, "WATCHER: resync %d: done", resynced)
+			}
+		}
 		if err != nil {
 			return err
 		}
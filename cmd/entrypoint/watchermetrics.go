@@ -0,0 +1,117 @@
+package entrypoint
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/acp"
+)
+
+// Watcher metrics: what the AmbassadorWatcher, EnvoyWatcher, and DiagdWatcher that answer the
+// health checks are doing (see pkg/acp/metrics.go), so that when a probe flaps, it's possible to
+// see which check is failing, and how slow it is, without turning on debug logging. They're
+// served, with the rest of this process's metrics, on the health server's /metrics on 8877 (see
+// metricsHandler), and on their own on /ambassador/v0/watcher_metrics.
+
+// watcherMetrics renders what the watchers say about themselves, in Prometheus' text format.
+func watcherMetrics(watchers []acp.WatcherMetrics) []byte {
+	var buf bytes.Buffer
+	gauge := func(name, help string, value func(acp.WatcherMetrics) bool) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(&buf, "# TYPE %s gauge\n", name)
+		for _, w := range watchers {
+			n := 0
+			if value(w) {
+				n = 1
+			}
+			fmt.Fprintf(&buf, "%s{watcher=%q} %d\n", name, w.Watcher, n)
+		}
+	}
+	gauge("ambassador_watcher_alive", "Whether the watcher says what it watches is alive.",
+		func(w acp.WatcherMetrics) bool { return w.Alive })
+	gauge("ambassador_watcher_ready", "Whether the watcher says what it watches is ready.",
+		func(w acp.WatcherMetrics) bool { return w.Ready })
+
+	type fetch struct {
+		watcher, name string
+		metrics       acp.FetchMetrics
+	}
+	var fetches []fetch
+	for _, w := range watchers {
+		for name, metrics := range w.Fetches {
+			fetches = append(fetches, fetch{watcher: w.Watcher, name: name, metrics: metrics})
+		}
+	}
+	if len(fetches) == 0 {
+		return buf.Bytes()
+	}
+	sort.SliceStable(fetches, func(i, j int) bool {
+		if fetches[i].watcher != fetches[j].watcher {
+			return fetches[i].watcher < fetches[j].watcher
+		}
+		return fetches[i].name < fetches[j].name
+	})
+
+	fmt.Fprintln(&buf, "# HELP ambassador_watcher_fetches_total Fetches the watchers have made, by what they fetch.")
+	fmt.Fprintln(&buf, "# TYPE ambassador_watcher_fetches_total counter")
+	for _, f := range fetches {
+		fmt.Fprintf(&buf, "ambassador_watcher_fetches_total{watcher=%q,fetch=%q} %d\n", f.watcher, f.name, f.metrics.Attempts)
+	}
+	fmt.Fprintln(&buf, "# HELP ambassador_watcher_fetch_failures_total Fetches that got no answer, or one that said nothing.")
+	fmt.Fprintln(&buf, "# TYPE ambassador_watcher_fetch_failures_total counter")
+	for _, f := range fetches {
+		fmt.Fprintf(&buf, "ambassador_watcher_fetch_failures_total{watcher=%q,fetch=%q} %d\n", f.watcher, f.name, f.metrics.Failures)
+	}
+	fmt.Fprintln(&buf, "# HELP ambassador_watcher_fetch_duration_seconds How long the watchers' fetches took.")
+	fmt.Fprintln(&buf, "# TYPE ambassador_watcher_fetch_duration_seconds histogram")
+	for _, f := range fetches {
+		for i, bound := range acp.FetchLatencyBuckets {
+			var n uint64
+			if i < len(f.metrics.LatencyBuckets) {
+				n = f.metrics.LatencyBuckets[i]
+			}
+			fmt.Fprintf(&buf, "ambassador_watcher_fetch_duration_seconds_bucket{watcher=%q,fetch=%q,le=%q} %d\n",
+				f.watcher, f.name, strconv.FormatFloat(bound.Seconds(), 'f', -1, 64), n)
+		}
+		fmt.Fprintf(&buf, "ambassador_watcher_fetch_duration_seconds_bucket{watcher=%q,fetch=%q,le=\"+Inf\"} %d\n",
+			f.watcher, f.name, f.metrics.Attempts)
+		fmt.Fprintf(&buf, "ambassador_watcher_fetch_duration_seconds_sum{watcher=%q,fetch=%q} %s\n",
+			f.watcher, f.name, strconv.FormatFloat(f.metrics.LatencySum.Seconds(), 'f', -1, 64))
+		fmt.Fprintf(&buf, "ambassador_watcher_fetch_duration_seconds_count{watcher=%q,fetch=%q} %d\n",
+			f.watcher, f.name, f.metrics.Attempts)
+	}
+	return buf.Bytes()
+}
+
+// handleWatcherMetrics serves the watcher metrics on their own.
+func handleWatcherMetrics(w http.ResponseWriter, r *http.Request, ambwatch *acp.AmbassadorWatcher) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = w.Write(watcherMetrics(ambwatch.Metrics()))
+}
+
+// metricsHandler serves /metrics on the health server. When diagd answers, that's what next (the
+// reverse proxy to diagd, which adds this process's metrics to diagd's) says; when it doesn't,
+// it's this process's metrics on their own, so that the watchers can still be seen when it's
+// diagd that's failing the health checks.
+func metricsHandler(next http.Handler, metrics ...func() []byte) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bw := &bufferedResponseWriter{header: http.Header{}}
+		next.ServeHTTP(bw, r)
+		if bw.status == 0 || bw.status == http.StatusOK {
+			for key, values := range bw.header {
+				w.Header()[key] = values
+			}
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(bw.body.Bytes())
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		for _, m := range metrics {
+			_, _ = w.Write(m())
+		}
+	})
+}
@@ -0,0 +1,86 @@
+package entrypoint
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/acp"
+)
+
+func TestWatcherMetrics(t *testing.T) {
+	assert.Equal(t, `# HELP ambassador_watcher_alive Whether the watcher says what it watches is alive.
+# TYPE ambassador_watcher_alive gauge
+# HELP ambassador_watcher_ready Whether the watcher says what it watches is ready.
+# TYPE ambassador_watcher_ready gauge
+`, string(watcherMetrics(nil)))
+
+	ew := acp.NewEnvoyWatcher()
+	ew.SetReadyCheck(func(context.Context) (*acp.EnvoyFetcherResponse, error) {
+		return &acp.EnvoyFetcherResponse{StatusCode: http.StatusServiceUnavailable}, nil
+	})
+	ew.SetStateCheck(func(context.Context) (*acp.EnvoyFetcherResponse, error) {
+		return &acp.EnvoyFetcherResponse{StatusCode: http.StatusServiceUnavailable, Text: []byte("DRAINING\n")}, nil
+	})
+	ew.SetDrainCheck(func(context.Context) (*acp.EnvoyFetcherResponse, error) {
+		return &acp.EnvoyFetcherResponse{StatusCode: http.StatusOK}, nil
+	})
+	ew.SetConfigCheck(func(context.Context) (*acp.EnvoyFetcherResponse, error) {
+		return &acp.EnvoyFetcherResponse{StatusCode: http.StatusOK}, nil
+	})
+	ambwatch := acp.NewAmbassadorWatcher(ew, acp.NewDiagdWatcher())
+	ambwatch.FetchEnvoyReady(dlog.NewTestContext(t, false))
+
+	rec := httptest.NewRecorder()
+	handleWatcherMetrics(rec, httptest.NewRequest(http.MethodGet, "/ambassador/v0/watcher_metrics", nil), ambwatch)
+	metrics := rec.Body.String()
+
+	// Nothing has been sent to diagd yet, so it's alive, but not ready; Envoy is draining.
+	assert.Contains(t, metrics, "\nambassador_watcher_alive{watcher=\"ambassador\"} 1\n")
+	assert.Contains(t, metrics, "\nambassador_watcher_ready{watcher=\"ambassador\"} 0\n")
+	assert.Contains(t, metrics, "\nambassador_watcher_alive{watcher=\"envoy\"} 1\n")
+	assert.Contains(t, metrics, "\nambassador_watcher_ready{watcher=\"diagd\"} 0\n")
+
+	// The ready check failed, but the state check said something.
+	assert.Contains(t, metrics, "\nambassador_watcher_fetches_total{watcher=\"envoy\",fetch=\"ready\"} 1\n")
+	assert.Contains(t, metrics, "\nambassador_watcher_fetch_failures_total{watcher=\"envoy\",fetch=\"ready\"} 1\n")
+	assert.Contains(t, metrics, "\nambassador_watcher_fetch_failures_total{watcher=\"envoy\",fetch=\"state\"} 0\n")
+	assert.Contains(t, metrics, "\nambassador_watcher_fetches_total{watcher=\"envoy\",fetch=\"drain\"} 1\n")
+	assert.Contains(t, metrics, "\n# TYPE ambassador_watcher_fetch_duration_seconds histogram\n")
+	assert.Contains(t, metrics, "\nambassador_watcher_fetch_duration_seconds_bucket{watcher=\"envoy\",fetch=\"ready\",le=\"+Inf\"} 1\n")
+	assert.Contains(t, metrics, "\nambassador_watcher_fetch_duration_seconds_count{watcher=\"envoy\",fetch=\"ready\"} 1\n")
+	assert.Contains(t, metrics, "ambassador_watcher_fetch_duration_seconds_bucket{watcher=\"envoy\",fetch=\"config\",le=\"0.005\"}")
+}
+
+func TestMetricsHandler(t *testing.T) {
+	diagdUp := true
+	diagd := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !diagdUp {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_, _ = w.Write([]byte("diagd 1\nours 1\n"))
+	})
+	handler := metricsHandler(diagd, func() []byte { return []byte("ours 1\n") })
+
+	// When diagd answers, what it says (with ours added) is what goes out...
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "diagd 1\nours 1\n", rec.Body.String())
+
+	// ...and when it doesn't, ours still do, without anything diagd's failure said.
+	diagdUp = false
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "ours 1\n", rec.Body.String())
+	assert.Equal(t, "text/plain; version=0.0.4", rec.Header().Get("Content-Type"))
+	assert.Empty(t, rec.Header().Get("Retry-After"))
+}
@@ -0,0 +1,180 @@
+package entrypoint
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+)
+
+// Watch recording: with AMBASSADOR_WATCH_RECORD_FILE set, every batch of Kubernetes changes that
+// the watcher picks up is appended to that file, as one JSON WatchEvent per line, before anything
+// else sees it. A recording from a cluster that misbehaves can then be replayed through the same
+// watcher by the Fake test harness (see Fake.Replay), one event at a time, with the clock set to
+// when each event happened, so that ordering and timing bugs from the field can be turned into
+// regression tests.
+//
+// Secrets are recorded without their data: the keys are there, but the values are empty.
+
+// The WatchEvent struct is one batch of changes from Kubernetes, as the watcher saw it.
+type WatchEvent struct {
+	Time    time.Time             `json:"time"`
+	Upserts []*kates.Unstructured `json:"upserts,omitempty"` // resources added or updated
+	Deletes []WatchDelete         `json:"deletes,omitempty"` // resources deleted
+}
+
+// The WatchDelete struct identifies a resource that was deleted.
+type WatchDelete struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+// GetWatchRecordFile returns the file to record Kubernetes watch events to, from
+// AMBASSADOR_WATCH_RECORD_FILE. Empty means don't record them.
+func GetWatchRecordFile() string {
+	return env("AMBASSADOR_WATCH_RECORD_FILE", "")
+}
+
+// ReadWatchEvents reads WatchEvents, one per line, as a watchRecorder writes them.
+func ReadWatchEvents(r io.Reader) ([]WatchEvent, error) {
+	var events []WatchEvent
+	decoder := json.NewDecoder(r)
+	for {
+		var event WatchEvent
+		if err := decoder.Decode(&event); err != nil {
+			if err == io.EOF {
+				return events, nil
+			}
+			return nil, fmt.Errorf("watch event %d: %w", len(events)+1, err)
+		}
+		events = append(events, event)
+	}
+}
+
+// A watchRecorder writes the WatchEvents that pass through the K8sSource it wraps.
+type watchRecorder struct {
+	clock func() time.Time
+
+	mutex sync.Mutex // protects the file
+	file  *os.File
+	out   *bufio.Writer
+}
+
+func newWatchRecorder(filename string) (*watchRecorder, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &watchRecorder{
+		clock: time.Now,
+		file:  file,
+		out:   bufio.NewWriter(file),
+	}, nil
+}
+
+// source returns a K8sSource that records what src's watchers see.
+func (r *watchRecorder) source(src K8sSource) K8sSource {
+	return &recordingK8sSource{src: src, recorder: r}
+}
+
+// record writes an event, and flushes it, so that nothing is lost if we crash.
+func (r *watchRecorder) record(event WatchEvent) error {
+	bytes, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, err := r.out.Write(append(bytes, '\n')); err != nil {
+		return err
+	}
+	return r.out.Flush()
+}
+
+func (r *watchRecorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err := r.out.Flush(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+type recordingK8sSource struct {
+	src      K8sSource
+	recorder *watchRecorder
+}
+
+func (s *recordingK8sSource) Watch(ctx context.Context, queries ...kates.Query) (K8sWatcher, error) {
+	watcher, err := s.src.Watch(ctx, queries...)
+	if err != nil {
+		return nil, err
+	}
+	return &recordingK8sWatcher{K8sWatcher: watcher, recorder: s.recorder}, nil
+}
+
+type recordingK8sWatcher struct {
+	K8sWatcher
+	recorder *watchRecorder
+}
+
+// FilteredUpdate records every resource that the predicate is asked about (which is every resource
+// that was added or updated) and every delete in the deltas.
+func (w *recordingK8sWatcher) FilteredUpdate(ctx context.Context, target interface{}, deltas *[]*kates.Delta, predicate func(*kates.Unstructured) bool) (bool, error) {
+	event := WatchEvent{Time: w.recorder.clock()}
+	changed, err := w.K8sWatcher.FilteredUpdate(ctx, target, deltas, func(un *kates.Unstructured) bool {
+		event.Upserts = append(event.Upserts, redactSecret(un.DeepCopy()))
+		return predicate(un)
+	})
+	if err != nil {
+		return changed, err
+	}
+
+	for _, delta := range deltasOrNil(deltas) {
+		if delta.DeltaType == kates.ObjectDelete {
+			event.Deletes = append(event.Deletes, WatchDelete{
+				Kind:      delta.Kind,
+				Namespace: delta.Namespace,
+				Name:      delta.Name,
+			})
+		}
+	}
+	if len(event.Upserts) > 0 || len(event.Deletes) > 0 {
+		// A recording is for debugging: don't stop watching just because it can't be written.
+		if err := w.recorder.record(event); err != nil {
+			dlog.Errorf(ctx, "Unable to record watch event: %v", err)
+		}
+	}
+	return changed, nil
+}
+
+func deltasOrNil(deltas *[]*kates.Delta) []*kates.Delta {
+	if deltas == nil {
+		return nil
+	}
+	return *deltas
+}
+
+// redactSecret empties out the values of a Secret's data, and leaves anything else alone.
+func redactSecret(un *kates.Unstructured) *kates.Unstructured {
+	if un.GetKind() != "Secret" {
+		return un
+	}
+	if data, ok := un.Object["data"].(map[string]interface{}); ok {
+		for key := range data {
+			data[key] = ""
+		}
+	}
+	delete(un.Object, "stringData")
+	return un
+}
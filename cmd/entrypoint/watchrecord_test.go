@@ -0,0 +1,103 @@
+package entrypoint
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+)
+
+// scriptedK8sWatcher hands the predicate the objects, and returns the deltas, that it's given.
+type scriptedK8sWatcher struct {
+	objects []*kates.Unstructured
+	deltas  []*kates.Delta
+}
+
+func (w *scriptedK8sWatcher) Changed() <-chan struct{} {
+	return nil
+}
+
+func (w *scriptedK8sWatcher) FilteredUpdate(_ context.Context, _ interface{}, deltas *[]*kates.Delta, predicate func(*kates.Unstructured) bool) (bool, error) {
+	for _, un := range w.objects {
+		predicate(un)
+	}
+	*deltas = w.deltas
+	return true, nil
+}
+
+func TestWatchRecorder(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	filename := filepath.Join(t.TempDir(), "watch.jsonl")
+	recorder, err := newWatchRecorder(filename)
+	require.NoError(t, err)
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	recorder.clock = func() time.Time { return now }
+
+	secret := &kates.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata":   map[string]interface{}{"name": "tls-cert", "namespace": "default"},
+		"data":       map[string]interface{}{"tls.key": "c2VjcmV0"},
+	}}
+	mapping := &kates.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "getambassador.io/v3alpha1",
+		"kind":       "Mapping",
+		"metadata":   map[string]interface{}{"name": "hello", "namespace": "default"},
+		"spec":       map[string]interface{}{"prefix": "/hello/", "service": "hello"},
+	}}
+	deleted, err := kates.NewDeltaFromObject(kates.ObjectDelete, &kates.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "getambassador.io/v3alpha1",
+		"kind":       "Mapping",
+		"metadata":   map[string]interface{}{"name": "goodbye", "namespace": "default"},
+	}})
+	require.NoError(t, err)
+
+	src := recorder.source(&scriptedK8sSource{&scriptedK8sWatcher{
+		objects: []*kates.Unstructured{secret, mapping},
+		deltas:  []*kates.Delta{deleted},
+	}})
+	watcher, err := src.Watch(ctx)
+	require.NoError(t, err)
+
+	var deltas []*kates.Delta
+	var seen []string
+	_, err = watcher.FilteredUpdate(ctx, nil, &deltas, func(un *kates.Unstructured) bool {
+		seen = append(seen, un.GetName())
+		return true
+	})
+	require.NoError(t, err)
+	require.NoError(t, recorder.Close())
+
+	// The watcher's caller sees everything as it was...
+	assert.Equal(t, []string{"tls-cert", "hello"}, seen)
+	assert.Equal(t, []*kates.Delta{deleted}, deltas)
+	assert.Equal(t, "c2VjcmV0", secret.Object["data"].(map[string]interface{})["tls.key"])
+
+	// ...and the recording has it all, minus the Secret's data.
+	file, err := os.Open(filename)
+	require.NoError(t, err)
+	defer file.Close()
+	events, err := ReadWatchEvents(file)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.True(t, now.Equal(events[0].Time))
+	require.Len(t, events[0].Upserts, 2)
+	assert.Equal(t, map[string]interface{}{"tls.key": ""}, events[0].Upserts[0].Object["data"])
+	assert.Equal(t, mapping.Object, events[0].Upserts[1].Object)
+	assert.Equal(t, []WatchDelete{{Kind: "Mapping", Namespace: "default", Name: "goodbye"}}, events[0].Deletes)
+}
+
+type scriptedK8sSource struct {
+	watcher K8sWatcher
+}
+
+func (s *scriptedK8sSource) Watch(context.Context, ...kates.Query) (K8sWatcher, error) {
+	return s.watcher, nil
+}
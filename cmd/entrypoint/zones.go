@@ -0,0 +1,128 @@
+package entrypoint
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/ambex"
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+	snapshotTypes "github.com/emissary-ingress/emissary/v3/pkg/snapshot/v1"
+)
+
+// Zone-aware routing: with AMBASSADOR_ZONE_AWARE_ROUTING set, we also watch EndpointSlices, for
+// the zone of each endpoint. Every Kubernetes endpoint we hand to ambex is tagged with its zone,
+// so that each cluster's endpoints go to Envoy as one locality per zone, weighted by how many
+// endpoints it has. On top of that, the endpoints in Envoy's own zone get priority over the rest,
+// so traffic only crosses zones when the local zone can't take it:
+//
+//   - AMBASSADOR_ZONE is the zone we're in. If it isn't set, we look for our own Pod in the
+//     EndpointSlices, which will find it as long as something (the Ambassador Service, say)
+//     selects us.
+//   - AMBASSADOR_ZONE_MIN_ENDPOINTS (default 2) is how many endpoints a cluster needs in our zone
+//     for it to be preferred; below that, traffic is spread over every zone, so that a single
+//     local Pod doesn't get everything.
+//   - AMBASSADOR_ZONE_SPILLOVER_PERCENT is how much of a cluster's local zone has to be healthy to
+//     keep its traffic all local. Below it, traffic spills over to the other zones in
+//     proportion. If it isn't set, Envoy's default applies (about 71%).
+
+// IsZoneAwareRoutingEnabled reflects AMBASSADOR_ZONE_AWARE_ROUTING.
+func IsZoneAwareRoutingEnabled() bool {
+	enabled, _ := strconv.ParseBool(env("AMBASSADOR_ZONE_AWARE_ROUTING", "false"))
+	return enabled
+}
+
+// GetLocalZone returns the zone from AMBASSADOR_ZONE, if it's set.
+func GetLocalZone() string {
+	return env("AMBASSADOR_ZONE", "")
+}
+
+// GetZoneMinEndpoints returns the number of endpoints a cluster needs in the local zone for the
+// local zone to be preferred.
+func GetZoneMinEndpoints() int {
+	n, err := strconv.Atoi(env("AMBASSADOR_ZONE_MIN_ENDPOINTS", "2"))
+	if err != nil || n < 1 {
+		n = 2
+	}
+	return n
+}
+
+// GetZoneSpilloverPercent returns the percentage of a cluster's local endpoints that have to be
+// healthy to keep all its traffic local, or zero for Envoy's default.
+func GetZoneSpilloverPercent() uint32 {
+	n, err := strconv.ParseUint(env("AMBASSADOR_ZONE_SPILLOVER_PERCENT", "0"), 10, 32)
+	if err != nil || n > 100 {
+		n = 0
+	}
+	return uint32(n)
+}
+
+// endpointZones maps "namespace:service" to the zone of each of the Service's endpoint IPs.
+func endpointZones(slices []*kates.EndpointSlice) map[string]map[string]string {
+	zones := map[string]map[string]string{}
+	for _, slice := range slices {
+		svc := slice.GetLabels()[kates.LabelServiceName]
+		if svc == "" {
+			continue
+		}
+		k := slice.GetNamespace() + ":" + svc
+		for _, ep := range slice.Endpoints {
+			if ep.Zone == nil || *ep.Zone == "" {
+				continue
+			}
+			if zones[k] == nil {
+				zones[k] = map[string]string{}
+			}
+			for _, addr := range ep.Addresses {
+				zones[k][addr] = *ep.Zone
+			}
+		}
+	}
+	return zones
+}
+
+// findLocalZone returns the zone that we're in: AMBASSADOR_ZONE, or else the zone of our own Pod
+// (whose name is our hostname) in the EndpointSlices, or else "" if we can't tell.
+func findLocalZone(slices []*kates.EndpointSlice, namespace, podName string) string {
+	if zone := GetLocalZone(); zone != "" {
+		return zone
+	}
+	for _, slice := range slices {
+		if slice.GetNamespace() != namespace {
+			continue
+		}
+		for _, ep := range slice.Endpoints {
+			ref := ep.TargetRef
+			if ref != nil && ref.Kind == "Pod" && ref.Name == podName && ep.Zone != nil {
+				return *ep.Zone
+			}
+		}
+	}
+	return ""
+}
+
+// warnNoLocalZone makes sure that we only complain once about not knowing our zone.
+var warnNoLocalZone sync.Once
+
+// zoneRouting works out how ambex should route by zone, or returns nil if it shouldn't.
+func zoneRouting(ctx context.Context, ksnap *snapshotTypes.KubernetesSnapshot) *ambex.ZoneRouting {
+	if !IsZoneAwareRoutingEnabled() {
+		return nil
+	}
+
+	podName, _ := os.Hostname()
+	zone := findLocalZone(ksnap.EndpointSlices, GetAmbassadorNamespace(), podName)
+	if zone == "" {
+		warnNoLocalZone.Do(func() {
+			dlog.Warnf(ctx, "zone-aware routing: can't tell what zone we're in (set AMBASSADOR_ZONE); spreading traffic over every zone")
+		})
+	}
+
+	return &ambex.ZoneRouting{
+		LocalZone:        zone,
+		MinEndpoints:     GetZoneMinEndpoints(),
+		SpilloverPercent: GetZoneSpilloverPercent(),
+	}
+}
@@ -0,0 +1,50 @@
+package entrypoint
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/kates"
+)
+
+func TestEndpointZones(t *testing.T) {
+	zone := func(z string) *string { return &z }
+	slice := func(namespace, svc string, eps ...kates.EndpointSliceEndpoint) *kates.EndpointSlice {
+		s := &kates.EndpointSlice{Endpoints: eps}
+		s.SetNamespace(namespace)
+		s.SetName(svc + "-abcde")
+		if svc != "" {
+			s.SetLabels(map[string]string{kates.LabelServiceName: svc})
+		}
+		return s
+	}
+	slices := []*kates.EndpointSlice{
+		slice("default", "foo",
+			kates.EndpointSliceEndpoint{Addresses: []string{"10.0.0.1"}, Zone: zone("us-east-1a")},
+			kates.EndpointSliceEndpoint{Addresses: []string{"10.0.0.2"}, Zone: zone("us-east-1b")},
+			kates.EndpointSliceEndpoint{Addresses: []string{"10.0.0.3"}},
+		),
+		slice("ambassador", "ambassador",
+			kates.EndpointSliceEndpoint{
+				Addresses: []string{"10.0.1.1"},
+				Zone:      zone("us-east-1c"),
+				TargetRef: &corev1.ObjectReference{Kind: "Pod", Namespace: "ambassador", Name: "ambassador-5d8f7"},
+			},
+		),
+		// Not managed for a Service, so nothing to say about any Service's endpoints.
+		slice("default", "", kates.EndpointSliceEndpoint{Addresses: []string{"10.0.0.9"}, Zone: zone("us-east-1a")}),
+	}
+
+	assert.Equal(t, map[string]map[string]string{
+		"default:foo":           {"10.0.0.1": "us-east-1a", "10.0.0.2": "us-east-1b"},
+		"ambassador:ambassador": {"10.0.1.1": "us-east-1c"},
+	}, endpointZones(slices))
+
+	assert.Equal(t, "us-east-1c", findLocalZone(slices, "ambassador", "ambassador-5d8f7"))
+	assert.Equal(t, "", findLocalZone(slices, "ambassador", "ambassador-other"))
+
+	t.Setenv("AMBASSADOR_ZONE", "us-east-1a")
+	assert.Equal(t, "us-east-1a", findLocalZone(slices, "ambassador", "ambassador-other"))
+}
@@ -34,7 +34,8 @@
 //
 // TESTING HOOKS:
 // Since time plays a role, you can use AmbassadorWatcher.SetFetchTime to change the
-// function that the AmbassadorWatcher uses to fetch times. The default is time.Now.
+// function that the AmbassadorWatcher uses to fetch times, or AmbassadorWatcher.SetClock to
+// give it a clock.Clock (like a clock.Fake). The default is time.Now.
 //
 // This hook is NOT meant for you to change the values on the fly in a running
 // AmbassadorWatcher. Set it at instantiation if need be, then leave it alone. See
@@ -45,8 +46,11 @@ package acp
 import (
 	"context"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
 )
 
 type awState int
@@ -70,9 +74,11 @@ type AmbassadorWatcher struct {
 	// What's the current Envoy state?
 	state awState
 
-	// We encapsulate an EnvoyWatcher and a DiagdWatcher.
-	ew *EnvoyWatcher
-	dw *DiagdWatcher
+	// We encapsulate an EnvoyWatcher and a DiagdWatcher, and, optionally, an
+	// APIServerWatcher.
+	ew  *EnvoyWatcher
+	dw  *DiagdWatcher
+	asw *APIServerWatcher
 
 	// At the point that the DiagdWatcher finishes processing the very first
 	// snapshot, we have to hand the snapshot to Envoy and allow Envoy to start
@@ -99,14 +105,66 @@ func (w *AmbassadorWatcher) SetFetchTime(fetchTime timeFetcher) {
 	w.fetchTime = fetchTime
 }
 
-// FetchEnvoyReady will check whether Envoy's statistics are fetchable.
+// SetClock will change the clock we use to get the current time.
+func (w *AmbassadorWatcher) SetClock(c clock.Clock) {
+	w.SetFetchTime(c.Now)
+}
+
+// SetAPIServerWatcher will have readiness take the API server connection into account.
+// Like the other setters, it's meant to be called at instantiation.
+func (w *AmbassadorWatcher) SetAPIServerWatcher(asw *APIServerWatcher) {
+	w.asw = asw
+}
+
+// APIServerWatcher returns the APIServerWatcher, or nil if there isn't one.
+func (w *AmbassadorWatcher) APIServerWatcher() *APIServerWatcher {
+	return w.asw
+}
+
+// EnvoyWatcher returns the EnvoyWatcher.
+func (w *AmbassadorWatcher) EnvoyWatcher() *EnvoyWatcher {
+	return w.ew
+}
+
+// FetchEnvoyReady will check whether Envoy's statistics are fetchable, unless the
+// EnvoyWatcher is already checking on Envoy in the background, with Run.
 func (w *AmbassadorWatcher) FetchEnvoyReady(ctx context.Context) {
+	if w.ew.Polling() {
+		return
+	}
+
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 
 	w.ew.FetchEnvoyReady(ctx)
 }
 
+// FetchEnvoyStats will fetch Envoy's stats, for GetEnvoyStats. The EnvoyWatcher
+// looks after its own locking, so this doesn't hold up anything else while it
+// waits for Envoy.
+func (w *AmbassadorWatcher) FetchEnvoyStats(ctx context.Context) error {
+	return w.ew.FetchEnvoyStats(ctx)
+}
+
+// GetEnvoyStats returns the stats that the last FetchEnvoyStats that worked got,
+// or nil if none has.
+func (w *AmbassadorWatcher) GetEnvoyStats() *EnvoyStats {
+	return w.ew.GetStats()
+}
+
+// FetchUpstreamHealth will fetch the health of Envoy's upstream clusters, for
+// UpstreamHealth. Like FetchEnvoyStats, it doesn't hold up anything else.
+func (w *AmbassadorWatcher) FetchUpstreamHealth(ctx context.Context) error {
+	return w.ew.FetchClusterHealth(ctx)
+}
+
+// UpstreamHealth returns the health of Envoy's upstream clusters that the last
+// FetchUpstreamHealth that worked got, or nil if none has. It has no bearing on whether
+// Ambassador is alive or ready: a backend being down isn't Ambassador's problem.
+func (w *AmbassadorWatcher) UpstreamHealth() *UpstreamHealth {
+	return w.ew.ClusterHealth()
+}
+
 // NoteSnapshotSent will note that a snapshot has been sent.
 func (w *AmbassadorWatcher) NoteSnapshotSent() {
 	w.mutex.Lock()
@@ -137,11 +195,20 @@ func (w *AmbassadorWatcher) IsAlive() bool {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 
+	alive, state := w.alive()
+	w.state = state
+	return alive
+}
+
+// alive returns whether the Ambassador as a whole can be considered alive, and the
+// state that says what's been found out about Envoy in finding that out, without
+// changing anything. The caller must hold the mutex.
+func (w *AmbassadorWatcher) alive() (bool, awState) {
 	// First things first: if diagd isn't alive, Ambassador as a whole is
 	// clearly not alive.
 
 	if !w.dw.IsAlive() {
-		return false
+		return false, w.state
 	}
 
 	// OK, diagd is alive. We need to look at our current state to figure
@@ -151,24 +218,21 @@ func (w *AmbassadorWatcher) IsAlive() bool {
 	case envoyNotStarted:
 		// We haven't even tried to start Envoy yet, so we're good to go with
 		// just diagd being alive.
-		return true
+		return true, w.state
 
 	case envoyStarting:
 		// We're waiting for Envoy to start. Has it?
 		if w.ew.IsAlive() {
-			// Yes. Remember that it's running...
-			w.state = envoyRunning
-
-			// ...and then we're good to go.
-			return true
+			// Yes. It's running, and we're good to go.
+			return true, envoyRunning
 		}
 
 		// It's not yet running. Return true IFF we're still within the grace period.
-		return w.fetchTime().Before(w.GraceEnd)
+		return w.fetchTime().Before(w.GraceEnd), w.state
 
 	case envoyRunning:
 		// Envoy is already running, so check to make sure that it's still alive.
-		return w.ew.IsAlive()
+		return w.ew.IsAlive(), w.state
 
 	default:
 		// This is "impossible": w.state isn't exported, and it's deliberately
@@ -185,7 +249,56 @@ func (w *AmbassadorWatcher) IsReady() bool {
 	defer w.mutex.Unlock()
 
 	// This is much simpler that IsAlive. Ambassador is ready IFF both diagd and
-	// Envoy are ready; that's all there is to it.
+	// Envoy are ready, and the API server hasn't been unreachable for too long
+	// (if that's supposed to matter); that's all there is to it.
 
-	return w.dw.IsReady() && w.ew.IsReady()
+	return w.dw.IsReady() && w.ew.IsReady() && w.asw.IsReady()
+}
+
+// EnvoyState returns the state that Envoy's server was last found in, or
+// EnvoyStateUnknown if it couldn't be told.
+func (w *AmbassadorWatcher) EnvoyState() EnvoyServerState {
+	return w.ew.ServerState()
+}
+
+// EnvoyReason returns what the health checks should say about Envoy's server state,
+// or "" if there's nothing to say because it's LIVE (or we can't tell), has accepted
+// a configuration, and isn't draining any listeners.
+func (w *AmbassadorWatcher) EnvoyReason() string {
+	switch state := w.EnvoyState(); state {
+	case EnvoyLive, EnvoyStateUnknown:
+		draining, all := w.ew.ListenersDraining()
+		switch {
+		case all:
+			return "Envoy is draining all its listeners"
+		case w.ew.AwaitingConfig():
+			return "Envoy hasn't accepted a configuration yet"
+		case draining == 1:
+			return "Envoy is draining 1 replaced listener"
+		case draining > 1:
+			return fmt.Sprintf("Envoy is draining %d replaced listeners", draining)
+		}
+		return ""
+	default:
+		return "Envoy is " + string(state)
+	}
+}
+
+// EnvoyDetails describes what happened with the checks of Envoy so far, for the health
+// checks to say.
+func (w *AmbassadorWatcher) EnvoyDetails() string {
+	return w.ew.CheckDetails().Describe(w.fetchTime())
+}
+
+// ReadyReason returns anything that the readiness check should mention, or "" if
+// there's nothing: Envoy starting up, draining (all or some of its listeners), or
+// waiting for its first configuration, or a stale API server connection.
+func (w *AmbassadorWatcher) ReadyReason() string {
+	var reasons []string
+	for _, reason := range []string{w.EnvoyReason(), w.asw.Reason()} {
+		if reason != "" {
+			reasons = append(reasons, reason)
+		}
+	}
+	return strings.Join(reasons, "; ")
 }
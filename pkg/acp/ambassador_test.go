@@ -1,12 +1,14 @@
 package acp_test
 
 import (
+	"errors"
 	"testing"
 	"time"
 
 	"github.com/datawire/dlib/dlog"
 	"github.com/datawire/dlib/dtime"
 	"github.com/emissary-ingress/emissary/v3/pkg/acp"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
 )
 
 type awMetadata struct {
@@ -46,6 +48,7 @@ func newAWMetadata(t *testing.T) *awMetadata {
 
 	ew := acp.NewEnvoyWatcher()
 	ew.SetReadyCheck(f.readyCheck)
+	ew.SetStateCheck((&fakeState{}).stateCheck)
 
 	if ew == nil {
 		t.Error("New EnvoyWatcher is nil?")
@@ -130,3 +133,149 @@ func TestAmbassadorNoSnapshots(t *testing.T) {
 	m.stepSec(60)
 	m.check(4, 660, false, false)
 }
+
+func TestAmbassadorClock(t *testing.T) {
+	fake := clock.NewFake(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+
+	dw := acp.NewDiagdWatcher()
+	dw.SetClock(fake)
+	ew := acp.NewEnvoyWatcher()
+	ew.SetReadyCheck((&fakeReady{mode: Failure}).readyCheck)
+	ew.SetStateCheck((&fakeState{}).stateCheck)
+	aw := acp.NewAmbassadorWatcher(ew, dw)
+	aw.SetClock(fake)
+
+	aw.NoteSnapshotSent()
+	aw.NoteSnapshotProcessed()
+	aw.FetchEnvoyReady(dlog.NewTestContext(t, false))
+
+	// Envoy gets 30 seconds to come up, and no more.
+	fake.Advance(29 * time.Second)
+	if !aw.IsAlive() {
+		t.Errorf("AmbassadorWatcher.IsAlive false within Envoy's grace period")
+	}
+	fake.Advance(time.Second)
+	if aw.IsAlive() {
+		t.Errorf("AmbassadorWatcher.IsAlive true after Envoy's grace period")
+	}
+}
+
+func TestAmbassadorAPIServer(t *testing.T) {
+	m := newAWMetadata(t)
+	asw := acp.NewAPIServerWatcher(time.Minute, true)
+	asw.SetFetchTime(m.ft.Now)
+	m.aw.SetAPIServerWatcher(asw)
+
+	m.aw.NoteSnapshotSent()
+	m.aw.NoteSnapshotProcessed()
+	m.aw.FetchEnvoyReady(dlog.NewTestContext(t, false))
+	m.check(0, 0, true, true)
+
+	// Losing the API server for long enough makes us unready, but still alive.
+	asw.Note("Mapping", errors.New("connection refused"))
+	m.stepSec(61)
+	m.check(1, 61, true, false)
+	if m.aw.ReadyReason() == "" {
+		t.Errorf("AmbassadorWatcher.ReadyReason should say why it's not ready")
+	}
+
+	asw.Note("Mapping", nil)
+	m.check(2, 61, true, true)
+	if reason := m.aw.ReadyReason(); reason != "" {
+		t.Errorf("AmbassadorWatcher.ReadyReason %q, wanted nothing", reason)
+	}
+}
+
+func TestAmbassadorEnvoyDraining(t *testing.T) {
+	ft := dtime.NewFakeTime()
+	dw := acp.NewDiagdWatcher()
+	dw.SetFetchTime(ft.Now)
+	state := &fakeState{text: "LIVE"}
+	ew := acp.NewEnvoyWatcher()
+	ew.SetReadyCheck((&fakeReady{mode: Happy}).readyCheck)
+	ew.SetStateCheck(state.stateCheck)
+	ew.SetConfigCheck((&fakeConfig{text: configAccepted}).configCheck)
+	ew.SetDrainCheck((&fakeDrain{text: listenersDraining(2, 0)}).drainCheck)
+	aw := acp.NewAmbassadorWatcher(ew, dw)
+	aw.SetFetchTime(ft.Now)
+	m := &awMetadata{t: t, ft: ft, aw: aw}
+
+	aw.NoteSnapshotSent()
+	aw.NoteSnapshotProcessed()
+	aw.FetchEnvoyReady(dlog.NewTestContext(t, false))
+	m.check(0, 0, true, true)
+	if reason := aw.ReadyReason(); reason != "" {
+		t.Errorf("AmbassadorWatcher.ReadyReason %q, wanted nothing", reason)
+	}
+
+	// A draining Envoy is still alive, but it's not ready, and the reason says why.
+	state.text = "DRAINING"
+	aw.FetchEnvoyReady(dlog.NewTestContext(t, false))
+	m.check(1, 0, true, false)
+	if reason := aw.ReadyReason(); reason != "Envoy is DRAINING" {
+		t.Errorf("AmbassadorWatcher.ReadyReason %q, wanted %q", reason, "Envoy is DRAINING")
+	}
+}
+
+func TestAmbassadorEnvoyAwaitingConfig(t *testing.T) {
+	ft := dtime.NewFakeTime()
+	dw := acp.NewDiagdWatcher()
+	dw.SetFetchTime(ft.Now)
+	config := &fakeConfig{text: "cluster_manager.cds.update_success: 0\nlistener_manager.lds.update_success: 0\n"}
+	ew := acp.NewEnvoyWatcher()
+	ew.SetReadyCheck((&fakeReady{mode: Happy}).readyCheck)
+	ew.SetStateCheck((&fakeState{text: "LIVE"}).stateCheck)
+	ew.SetConfigCheck(config.configCheck)
+	ew.SetDrainCheck((&fakeDrain{text: listenersDraining(2, 0)}).drainCheck)
+	aw := acp.NewAmbassadorWatcher(ew, dw)
+	aw.SetFetchTime(ft.Now)
+	m := &awMetadata{t: t, ft: ft, aw: aw}
+
+	// Envoy is up, but until it takes a configuration from us, it has no routes.
+	aw.NoteSnapshotSent()
+	aw.NoteSnapshotProcessed()
+	aw.FetchEnvoyReady(dlog.NewTestContext(t, false))
+	m.check(0, 0, true, false)
+	if reason, want := aw.ReadyReason(), "Envoy hasn't accepted a configuration yet"; reason != want {
+		t.Errorf("AmbassadorWatcher.ReadyReason %q, wanted %q", reason, want)
+	}
+
+	config.text = configAccepted
+	aw.FetchEnvoyReady(dlog.NewTestContext(t, false))
+	m.check(1, 0, true, true)
+	if reason := aw.ReadyReason(); reason != "" {
+		t.Errorf("AmbassadorWatcher.ReadyReason %q, wanted nothing", reason)
+	}
+}
+
+func TestAmbassadorEnvoyListenersDraining(t *testing.T) {
+	ft := dtime.NewFakeTime()
+	dw := acp.NewDiagdWatcher()
+	dw.SetFetchTime(ft.Now)
+	drain := &fakeDrain{text: listenersDraining(2, 1)}
+	ew := acp.NewEnvoyWatcher()
+	ew.SetReadyCheck((&fakeReady{mode: Happy}).readyCheck)
+	ew.SetStateCheck((&fakeState{text: "LIVE"}).stateCheck)
+	ew.SetConfigCheck((&fakeConfig{text: configAccepted}).configCheck)
+	ew.SetDrainCheck(drain.drainCheck)
+	aw := acp.NewAmbassadorWatcher(ew, dw)
+	aw.SetFetchTime(ft.Now)
+	m := &awMetadata{t: t, ft: ft, aw: aw}
+
+	aw.NoteSnapshotSent()
+	aw.NoteSnapshotProcessed()
+
+	// A replaced listener draining gets mentioned, but doesn't make Ambassador unready.
+	aw.FetchEnvoyReady(dlog.NewTestContext(t, false))
+	m.check(0, 0, true, true)
+	if reason, want := aw.ReadyReason(), "Envoy is draining 1 replaced listener"; reason != want {
+		t.Errorf("AmbassadorWatcher.ReadyReason %q, wanted %q", reason, want)
+	}
+
+	drain.text = listenersDraining(0, 3)
+	aw.FetchEnvoyReady(dlog.NewTestContext(t, false))
+	m.check(1, 0, true, false)
+	if reason, want := aw.ReadyReason(), "Envoy is draining all its listeners"; reason != want {
+		t.Errorf("AmbassadorWatcher.ReadyReason %q, wanted %q", reason, want)
+	}
+}
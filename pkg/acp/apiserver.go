@@ -0,0 +1,166 @@
+// Copyright 2026 Datawire. All rights reserved.
+//
+// package acp contains stuff dealing with the Ambassador Control Plane as a whole.
+//
+// This is the APIServerWatcher, which keeps track of whether our Kubernetes watches
+// can reach the API server. While they can't, the configuration that Envoy is serving
+// is only as current as the last time they could: resources can change, and we won't
+// hear about it.
+//
+// Once the API server has been unreachable for longer than the stale threshold, the
+// APIServerWatcher says why in its Reason. Whether that also makes Ambassador unready
+// is up to the operator: serving stale configuration is usually better than serving
+// nothing, but a fleet with other replicas that can still reach their API server may
+// rather shed traffic to them.
+//
+// TESTING HOOKS:
+// Since time plays a role, you can use APIServerWatcher.SetFetchTime or
+// APIServerWatcher.SetClock, just like the DiagdWatcher.
+
+package acp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+)
+
+// APIServerWatcher encapsulates state and methods for keeping an eye on our
+// connection to the Kubernetes API server.
+type APIServerWatcher struct {
+	// How shall we fetch the current time?
+	fetchTime timeFetcher
+
+	// How long can the API server be unreachable before we call the cache stale?
+	staleAfter time.Duration
+
+	// Does a stale cache make us unready?
+	affectsReadiness bool
+
+	// This mutex protects everything below.
+	mutex sync.Mutex
+
+	// The last error for each kind whose watch is currently failing.
+	failing map[string]error
+
+	// When did we last hear from the API server?
+	LastContact time.Time
+
+	// When did the watches start failing? Zero if they aren't.
+	FailingSince time.Time
+}
+
+// NewAPIServerWatcher creates a new APIServerWatcher. The cache counts as stale once
+// the API server has been unreachable for staleAfter, and a stale cache makes
+// Ambassador unready IFF affectsReadiness.
+func NewAPIServerWatcher(staleAfter time.Duration, affectsReadiness bool) *APIServerWatcher {
+	return &APIServerWatcher{
+		fetchTime:        time.Now,
+		staleAfter:       staleAfter,
+		affectsReadiness: affectsReadiness,
+		failing:          make(map[string]error),
+	}
+}
+
+// SetFetchTime will change the function we use to get the current time.
+func (w *APIServerWatcher) SetFetchTime(fetchTime timeFetcher) {
+	w.fetchTime = fetchTime
+}
+
+// SetClock will change the clock we use to get the current time.
+func (w *APIServerWatcher) SetClock(c clock.Clock) {
+	w.SetFetchTime(c.Now)
+}
+
+// Note records how listing or watching some kind went: err is nil if the API server
+// answered, and the error if it didn't.
+func (w *APIServerWatcher) Note(kind string, err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	now := w.fetchTime()
+
+	if err == nil {
+		w.LastContact = now
+		delete(w.failing, kind)
+
+		if len(w.failing) == 0 {
+			w.FailingSince = time.Time{}
+		}
+
+		return
+	}
+
+	if len(w.failing) == 0 {
+		w.FailingSince = now
+	}
+
+	w.failing[kind] = err
+}
+
+// isStale returns true IFF the API server has been unreachable for longer than the
+// stale threshold. It must be called with the mutex held.
+func (w *APIServerWatcher) isStale() bool {
+	if w.FailingSince.IsZero() {
+		return false
+	}
+
+	return w.fetchTime().Sub(w.FailingSince) > w.staleAfter
+}
+
+// IsStale returns true IFF the API server has been unreachable for longer than the
+// stale threshold.
+func (w *APIServerWatcher) IsStale() bool {
+	if w == nil {
+		return false
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.isStale()
+}
+
+// IsReady returns false IFF the cache is stale, and a stale cache is supposed to
+// make Ambassador unready. A nil APIServerWatcher is always ready.
+func (w *APIServerWatcher) IsReady() bool {
+	if w == nil {
+		return true
+	}
+
+	return !(w.affectsReadiness && w.IsStale())
+}
+
+// Reason returns why the cache is stale, or "" if it isn't.
+func (w *APIServerWatcher) Reason() string {
+	if w == nil {
+		return ""
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if !w.isStale() {
+		return ""
+	}
+
+	kinds := make([]string, 0, len(w.failing))
+	for kind := range w.failing {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	// One error is plenty: when the API server is down, they all say the same thing.
+	reason := fmt.Sprintf("Kubernetes API server unreachable for %s (%s: %v)",
+		w.fetchTime().Sub(w.FailingSince).Round(time.Second), strings.Join(kinds, ", "), w.failing[kinds[0]])
+
+	if w.LastContact.IsZero() {
+		return reason + "; no configuration from it yet"
+	}
+
+	return reason + "; configuration is from " + w.LastContact.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,70 @@
+package acp_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/acp"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+)
+
+func TestAPIServerWatcher(t *testing.T) {
+	fake := clock.NewFake(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	asw := acp.NewAPIServerWatcher(2*time.Minute, true)
+	asw.SetClock(fake)
+
+	check := func(seq int, stale bool, reason string) {
+		if asw.IsStale() != stale {
+			t.Errorf("%d: APIServerWatcher.IsStale %t, wanted %t", seq, asw.IsStale(), stale)
+		}
+		if asw.IsReady() != !stale {
+			t.Errorf("%d: APIServerWatcher.IsReady %t, wanted %t", seq, asw.IsReady(), !stale)
+		}
+		if asw.Reason() != reason {
+			t.Errorf("%d: APIServerWatcher.Reason %q, wanted %q", seq, asw.Reason(), reason)
+		}
+	}
+
+	asw.Note("Mapping", nil)
+	asw.Note("Host", nil)
+	check(0, false, "")
+
+	// A blip isn't stale...
+	fake.Advance(time.Minute)
+	refused := errors.New("connection refused")
+	asw.Note("Mapping", refused)
+	fake.Advance(2 * time.Minute)
+	check(1, false, "")
+
+	// ...but an outage is.
+	asw.Note("Host", refused)
+	fake.Advance(time.Second)
+	check(2, true, "Kubernetes API server unreachable for 2m1s (Host, Mapping: connection refused); "+
+		"configuration is from 2026-10-16T12:00:00Z")
+
+	// It's still stale until every watch is back.
+	asw.Note("Mapping", nil)
+	check(3, true, "Kubernetes API server unreachable for 2m1s (Host: connection refused); "+
+		"configuration is from 2026-10-16T12:03:01Z")
+	asw.Note("Host", nil)
+	check(4, false, "")
+
+	// A stale cache doesn't have to make Ambassador unready.
+	lenient := acp.NewAPIServerWatcher(time.Minute, false)
+	lenient.SetClock(fake)
+	lenient.Note("Mapping", refused)
+	fake.Advance(2 * time.Minute)
+	if !lenient.IsStale() || !lenient.IsReady() {
+		t.Errorf("lenient APIServerWatcher: IsStale %t, IsReady %t", lenient.IsStale(), lenient.IsReady())
+	}
+	if reason := lenient.Reason(); reason != "Kubernetes API server unreachable for 2m0s (Mapping: connection refused); no configuration from it yet" {
+		t.Errorf("lenient APIServerWatcher: Reason %q", reason)
+	}
+
+	// Neither does no APIServerWatcher at all.
+	var none *acp.APIServerWatcher
+	if !none.IsReady() || none.IsStale() || none.Reason() != "" {
+		t.Errorf("nil APIServerWatcher should be ready, and not stale")
+	}
+}
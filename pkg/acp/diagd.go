@@ -22,7 +22,8 @@
 //
 // TESTING HOOKS:
 // Since time plays a role, you can use DiagdWatcher.SetFetchTime to change the
-// function that the DiagdWatcher uses to fetch times. The default is time.Now.
+// function that the DiagdWatcher uses to fetch times, or DiagdWatcher.SetClock to
+// give it a clock.Clock (like a clock.Fake). The default is time.Now.
 //
 // This hook is NOT meant for you to change the values on the fly in a running
 // DiagdWatcher. Set it at instantiation if need be, then leave it alone. See
@@ -33,6 +34,8 @@ package acp
 import (
 	"sync"
 	"time"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
 )
 
 // DiagdWatcher encapsulates state and methods for keeping an eye on a running
@@ -54,6 +57,9 @@ type DiagdWatcher struct {
 	// When does our grace period end? The grace period is ten minutes after
 	// the most recent event (boot, or the last time a snapshot was sent).
 	GraceEnd time.Time
+
+	// How long has diagd taken to process snapshots? See metrics.go.
+	metrics fetchMetrics
 }
 
 // NewDiagdWatcher creates a new DiagdWatcher.
@@ -85,6 +91,12 @@ func (w *DiagdWatcher) SetFetchTime(fetchTime timeFetcher) {
 	w.setGraceEnd(w.fetchTime(), 10*time.Minute) // RESET boot grace period, see above.
 }
 
+// SetClock will change the clock we use to get the current time _AND RESETS THE BOOT
+// GRACE PERIOD_, just like SetFetchTime.
+func (w *DiagdWatcher) SetClock(c clock.Clock) {
+	w.SetFetchTime(c.Now)
+}
+
 // NoteSnapshotSent marks the time at which we have sent a snapshot.
 func (w *DiagdWatcher) NoteSnapshotSent() {
 	w.mutex.Lock()
@@ -109,7 +121,14 @@ func (w *DiagdWatcher) NoteSnapshotSent() {
 func (w *DiagdWatcher) NoteSnapshotProcessed() {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
-	w.LastProcessed = w.fetchTime()
+
+	// If this is the first we've heard of the last snapshot sent being processed, count
+	// how long it took.
+	now := w.fetchTime()
+	if !w.LastSent.IsZero() && !w.LastProcessed.After(w.LastSent) {
+		w.metrics.record(FetchSnapshot, now.Sub(w.LastSent), false)
+	}
+	w.LastProcessed = now
 }
 
 // IsAlive returns true IFF diagd should be considered alive.
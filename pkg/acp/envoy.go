@@ -6,18 +6,108 @@
 // Envoy - and just Envoy, all other Ambassador elements are ignored - and tell you
 // whether it's alive and ready, or not.
 //
-// At the moment, "alive" and "ready" mean the same thing for an EnvoyWatcher. Both
-// IsAlive() and IsReady() methods exist, though, for a future in which we monitor
-// them separately.
+// "Alive" and "ready" aren't the same thing. Envoy's admin interface says what state
+// the server is in (LIVE, DRAINING, PRE_INITIALIZING, or INITIALIZING) at its /ready
+// endpoint, so an Envoy that answers with any of them is alive, but it's only ready
+// once it's LIVE and the ready listener answers, too. An Envoy that's still starting
+// up, or that's draining, is alive but not ready. If the admin interface doesn't say,
+// the ready listener decides both, like it always used to.
 //
 // TESTING HOOKS:
 // Since we try to check Envoy readiness to see how Envoy is doing, you can use
 // EnvoyWatcher.SetReadyCheck to change the function that EnvoyWatcher uses to
 // check readiness. The default is EnvoyWatcher.defaultFetcher, which tries to pull
-// readiness from http://localhost:8001/ready.
+// readiness from http://localhost:8006/ready (or AMBASSADOR_READY_PORT, if it's set).
+// NewEnvoyWatcherWithAddress points the default fetcher somewhere else, for an Envoy
+// listening on another scheme, host, or port. EnvoyWatcher.SetStateCheck does the same
+// for the server state, which comes from /ready on the admin interface.
 //
-// This hook is NOT meant for you to change the fetcher on the fly in a running
-// EnvoyWatcher. Set it at instantiation, then leave it alone. See envoy_test.go
+// STATS:
+// FetchEnvoyStats fetches Envoy's counters, gauges, and histograms from its admin
+// interface (http://localhost:8001 unless SetAdminURL says otherwise), and GetStats
+// returns what it got, so that callers can report on what Envoy is actually doing.
+// EnvoyWatcher.SetStatsCheck changes how they're fetched, like SetReadyCheck.
+//
+// THRESHOLDS:
+// A single check that fails (or works) doesn't have to change anything. Like a
+// kubelet probe, the EnvoyWatcher only decides that Envoy isn't alive or ready after
+// failureThreshold failed checks in a row, and that it is after successThreshold
+// checks in a row that worked. Both are 1 unless SetThresholds, or
+// AMBASSADOR_ENVOY_FAILURE_THRESHOLD and AMBASSADOR_ENVOY_SUCCESS_THRESHOLD at
+// instantiation, say otherwise. Envoy's server state isn't held back: once Envoy says
+// it's draining, it's not ready.
+//
+// HEALTH EVALUATORS:
+// What an answer from the ready listener means is up to a HealthEvaluator. The
+// default one, DefaultHealthEvaluator, says a 200 means Envoy is alive and ready, and
+// anything else means it isn't. SetHealthEvaluator changes that, for a caller that
+// wants more from Envoy before it's ready (a stat with a particular value, say);
+// AllHealthEvaluators combines several. See envoyhealth.go.
+//
+// ADMIN SOCKET:
+// In hardened deployments, Envoy's admin interface can be bound to a unix socket
+// instead of 127.0.0.1:8001. SetAdminSocket, or AMBASSADOR_ENVOY_ADMIN_SOCKET at
+// instantiation, has the default state and stats fetchers dial that socket instead.
+// The ready listener is a normal listener either way. AdminClient hands the same
+// client to anything else that talks to the admin interface.
+//
+// CHECK DETAILS:
+// Whether Envoy is alive or ready doesn't say why a probe is flapping, so the
+// EnvoyWatcher also remembers the last ready check that failed (and why), when the
+// last one worked, and how long the last one took. CheckDetails returns them. Since
+// they involve time, SetFetchTime or SetClock can change where the EnvoyWatcher gets
+// the time from, just like the DiagdWatcher.
+//
+// CONFIG ACCEPTANCE:
+// Envoy can be LIVE, with the ready listener answering, before it has any routes: the
+// ready listener is in the bootstrap configuration, and everything else comes over
+// xDS. So Envoy isn't ready until its listener_manager.lds.update_success and
+// cluster_manager.cds.update_success counters say that it has accepted at least one
+// set of listeners and clusters from us. Once it has, it has, so the EnvoyWatcher
+// stops asking. If the admin interface doesn't say, this doesn't hold Envoy back,
+// like the server state. EnvoyWatcher.SetConfigCheck changes how the counters are
+// fetched, like SetReadyCheck.
+//
+// LISTENER DRAINS:
+// Once Envoy is told to drain its listeners, for shutdown, it stops listening, and its
+// listener_manager.total_listeners_draining gauge counts the listeners that it's
+// draining. If it's draining listeners and has none left active, it isn't ready,
+// whatever the server state says. A configuration change that replaces a listener
+// drains the old one too, but the new one is active, so that doesn't make Envoy
+// unready: every pod gets the same change at once, and taking them all out of their
+// Services for Envoy's drain time would be an outage. The EnvoyWatcher still counts
+// those drains, for the health checks to mention. EnvoyWatcher.SetDrainCheck changes
+// how the gauges are fetched, like SetReadyCheck.
+//
+// CONFIG DUMPS:
+// ConfigDump fetches Envoy's /config_dump from the admin interface, for support, with
+// its secrets redacted. Dumps can be big, so there's a limit on how big, and one is
+// cached for a little while, so that asking again and again doesn't keep Envoy busy.
+// SetConfigDumpLimits changes both, and EnvoyWatcher.SetConfigDumpCheck changes how
+// the dump is fetched, like SetReadyCheck. See envoyconfigdump.go.
+//
+// CLUSTER HEALTH:
+// FetchClusterHealth fetches every upstream cluster's membership_healthy,
+// membership_degraded, and membership_total gauges, and ClusterHealth returns what
+// Envoy thinks of each cluster's members, so that callers can show which backends are
+// down. It's up to the caller whether, and how often, to fetch them: with thousands of
+// clusters, that's not something to do on every probe. Upstream health never changes
+// whether Envoy is alive or ready. EnvoyWatcher.SetClusterHealthCheck changes how the
+// gauges are fetched, like SetReadyCheck. See envoyclusterhealth.go.
+//
+// POLLING AND SUBSCRIPTIONS:
+// FetchEnvoyReady and FetchEnvoyStats only fetch when they're called, which is usually
+// when a probe or an admin request comes in, so how often Envoy gets checked depends on
+// how often it gets probed. Run checks on Envoy in the background instead: every
+// AMBASSADOR_ENVOY_POLL_SECONDS (2 by default), and its stats, too, every
+// AMBASSADOR_ENVOY_STATS_POLL_SECONDS, if that's set. SetPollIntervals changes both.
+// While Run is running, the AmbassadorWatcher leaves the checking to it. Subscribe (or
+// OnHealthChange) tells other subsystems whenever a check changes whether Envoy is alive
+// or ready, or what state its server is in, so they don't have to poll for it too. See
+// envoypoll.go.
+//
+// These hooks are NOT meant for you to change the fetchers on the fly in a running
+// EnvoyWatcher. Set them at instantiation, then leave them alone. See envoy_test.go
 // for more.
 
 package acp
@@ -25,14 +115,19 @@ package acp
 import (
 	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
 )
 
 // EnvoyWatcher encapsulates state and methods for keeping an eye on a running
@@ -48,16 +143,155 @@ type EnvoyWatcher struct {
 	// For default fetcher, the port for /ready endpoint listener
 	defaultReadyURL string
 
-	// Did the last ready check succeed?
+	// What does an answer to the ready check say about Envoy?
+	healthEvaluator HealthEvaluator
+
+	// How shall we determine Envoy's server state?
+	stateCheck envoyFetcher
+
+	// How shall we find out whether Envoy has accepted a configuration, has it, and
+	// if it hasn't, did the last check say so?
+	configCheck    envoyFetcher
+	configAccepted bool
+	configPending  bool
+
+	// How shall we find out how many listeners Envoy is draining, and how many did the
+	// last check find draining and active? They're both 0 if it couldn't tell.
+	drainCheck        envoyFetcher
+	listenersDraining uint64
+	listenersActive   uint64
+
+	// Did the ready check succeed, as far as the thresholds are concerned?
 	LastSucceeded bool
+
+	// What server state did the last state check get, and was Envoy alive, as far as
+	// the thresholds are concerned?
+	serverState EnvoyServerState
+	alive       bool
+
+	// How many checks in a row have to fail, or work, to change LastSucceeded and
+	// alive, and how many in a row so far have disagreed with each of them?
+	failureThreshold int
+	successThreshold int
+	readyStreak      int
+	aliveStreak      int
+
+	// How shall we fetch Envoy's stats, and from where, by default? The admin client
+	// is what talks to the admin interface, which might be over a unix socket.
+	statsCheck  envoyStatsFetcher
+	adminURL    string
+	adminClient *http.Client
+
+	// What did the last stats fetch that worked get?
+	stats *EnvoyStats
+
+	// How shall we fetch the membership gauges of Envoy's clusters, and what did the
+	// last fetch that worked make of them?
+	clusterHealthCheck envoyFetcher
+	clusterHealth      *UpstreamHealth
+
+	// How shall we fetch Envoy's config dump, how big can it be, how long do we keep
+	// it, and what did we get last? This has its own mutex, so that a slow fetch
+	// doesn't hold up the checks.
+	configDumpMutex    sync.Mutex
+	configDumpCheck    envoyFetcher
+	configDumpMaxBytes int64
+	configDumpMaxAge   time.Duration
+	configDump         *EnvoyConfigDump
+
+	// How shall we fetch the current time, and what happened with the ready checks?
+	fetchTime timeFetcher
+	details   EnvoyCheckDetails
+
+	// How have the fetches gone? See metrics.go.
+	metrics fetchMetrics
+
+	// How often does Run check on Envoy, and fetch its stats, and how many Runs are
+	// running? What did the last check make of Envoy, and who wants to know when that
+	// changes? See envoypoll.go.
+	pollInterval      time.Duration
+	statsPollInterval time.Duration
+	polling           int
+	health            EnvoyHealth
+	subscriptions     []*envoySubscription
+}
+
+// EnvoyCheckDetails says what happened with the EnvoyWatcher's ready checks, for
+// working out why a probe flaps. The times are zero if nothing's happened yet.
+type EnvoyCheckDetails struct {
+	// LastError is why the last ready check that failed did, and LastErrorTime is
+	// when it finished.
+	LastError     string
+	LastErrorTime time.Time
+
+	// LastSuccessTime is when the last ready check that worked finished.
+	LastSuccessTime time.Time
+
+	// Latency is how long the last ready check took, whether it worked or not.
+	Latency time.Duration
+}
+
+// Describe says what happened, as of now, in a line for the health checks.
+func (d EnvoyCheckDetails) Describe(now time.Time) string {
+	if d.LastSuccessTime.IsZero() && d.LastErrorTime.IsZero() {
+		return "Envoy hasn't been checked yet"
+	}
+
+	ago := func(t time.Time) string {
+		return now.Sub(t).Round(time.Millisecond).String() + " ago"
+	}
+
+	parts := []string{"last check took " + d.Latency.Round(time.Microsecond).String()}
+	if d.LastSuccessTime.IsZero() {
+		parts = append(parts, "never succeeded")
+	} else {
+		parts = append(parts, "last succeeded "+ago(d.LastSuccessTime))
+	}
+	if !d.LastErrorTime.IsZero() {
+		parts = append(parts, "last failed "+ago(d.LastErrorTime)+": "+d.LastError)
+	}
+	return "Envoy " + strings.Join(parts, "; ")
 }
 
 // NewEnvoyWatcher creates a new EnvoyWatcher, given a fetcher.
 func NewEnvoyWatcher() *EnvoyWatcher {
+	return NewEnvoyWatcherWithAddress("", "", 0)
+}
+
+// NewEnvoyWatcherWithAddress creates a new EnvoyWatcher whose default fetcher checks
+// the /ready endpoint at the given scheme, host, and port. An empty scheme means
+// "http", an empty host means "localhost", and a port of 0 means AMBASSADOR_READY_PORT
+// (8006 if that's not set), so NewEnvoyWatcherWithAddress("", "", 0) is the same as
+// NewEnvoyWatcher(). If AMBASSADOR_ENVOY_ADMIN_SOCKET is set, the admin interface is
+// reached over that unix socket.
+func NewEnvoyWatcherWithAddress(scheme, host string, port uint16) *EnvoyWatcher {
 	w := &EnvoyWatcher{
-		defaultReadyURL: getDefaultReadyURL(),
+		defaultReadyURL: getReadyURL(scheme, host, port),
+		adminURL:        "http://localhost:8001",
+		adminClient:     http.DefaultClient,
+		fetchTime:       time.Now,
 	}
 	w.SetReadyCheck(w.defaultFetcher)
+	w.SetHealthEvaluator(DefaultHealthEvaluator)
+	w.SetStateCheck(w.defaultStateFetcher)
+	w.SetConfigCheck(w.defaultConfigFetcher)
+	w.SetDrainCheck(w.defaultDrainFetcher)
+	w.SetStatsCheck(w.defaultStatsFetcher)
+	w.SetClusterHealthCheck(w.defaultClusterHealthFetcher)
+	w.SetConfigDumpCheck(w.defaultConfigDumpFetcher)
+	w.SetConfigDumpLimits(getDefaultConfigDumpMaxBytes(), DefaultConfigDumpMaxAge)
+	w.SetThresholds(
+		getDefaultThreshold("AMBASSADOR_ENVOY_FAILURE_THRESHOLD"),
+		getDefaultThreshold("AMBASSADOR_ENVOY_SUCCESS_THRESHOLD"),
+	)
+	w.SetPollIntervals(
+		getDefaultPollInterval("AMBASSADOR_ENVOY_POLL_SECONDS"),
+		getDefaultPollInterval("AMBASSADOR_ENVOY_STATS_POLL_SECONDS"),
+	)
+
+	if socket := os.Getenv("AMBASSADOR_ENVOY_ADMIN_SOCKET"); socket != "" {
+		w.SetAdminSocket(socket)
+	}
 
 	return w
 }
@@ -71,8 +305,65 @@ func (w *EnvoyWatcher) defaultFetcher(ctx context.Context) (*EnvoyFetcherRespons
 	tctx, tcancel := context.WithTimeout(ctx, 2*time.Second)
 	defer tcancel()
 
+	return fetchEnvoy(tctx, http.DefaultClient, w.defaultReadyURL)
+}
+
+// This is the default state fetcher for the EnvoyWatcher: it asks Envoy's admin
+// interface what state the server is in.
+func (w *EnvoyWatcher) defaultStateFetcher(ctx context.Context) (*EnvoyFetcherResponse, error) {
+	tctx, tcancel := context.WithTimeout(ctx, 2*time.Second)
+	defer tcancel()
+
+	return fetchEnvoy(tctx, w.adminClient, w.adminURL+"/ready")
+}
+
+// configAcceptedStats are the counters that say whether Envoy has accepted listeners and
+// clusters over xDS.
+var configAcceptedStats = []string{
+	"listener_manager.lds.update_success",
+	"cluster_manager.cds.update_success",
+}
+
+// This is the default config fetcher for the EnvoyWatcher: it gets the counters that say
+// whether Envoy has accepted a configuration from its admin interface.
+func (w *EnvoyWatcher) defaultConfigFetcher(ctx context.Context) (*EnvoyFetcherResponse, error) {
+	tctx, tcancel := context.WithTimeout(ctx, 2*time.Second)
+	defer tcancel()
+
+	filter := `^(listener_manager\.lds|cluster_manager\.cds)\.update_success$`
+	return fetchEnvoy(tctx, w.adminClient, w.adminURL+"/stats?filter="+url.QueryEscape(filter))
+}
+
+// This is the default drain fetcher for the EnvoyWatcher: it gets the gauges that count
+// Envoy's active and draining listeners from its admin interface.
+func (w *EnvoyWatcher) defaultDrainFetcher(ctx context.Context) (*EnvoyFetcherResponse, error) {
+	tctx, tcancel := context.WithTimeout(ctx, 2*time.Second)
+	defer tcancel()
+
+	filter := `^listener_manager\.total_listeners_(active|draining)$`
+	return fetchEnvoy(tctx, w.adminClient, w.adminURL+"/stats?filter="+url.QueryEscape(filter))
+}
+
+// This is the default stats fetcher for the EnvoyWatcher: it gets one type of stats
+// from Envoy's admin interface.
+func (w *EnvoyWatcher) defaultStatsFetcher(ctx context.Context, statType string) (*EnvoyFetcherResponse, error) {
+	// /stats is a lot bigger than /ready, so allow it a bit longer.
+	tctx, tcancel := context.WithTimeout(ctx, 5*time.Second)
+	defer tcancel()
+
+	return fetchEnvoy(tctx, w.adminClient, w.adminURL+"/stats?type="+url.QueryEscape(statType))
+}
+
+// fetchEnvoy GETs a URL with the given client, and returns the status code and the body.
+func fetchEnvoy(ctx context.Context, client *http.Client, target string) (*EnvoyFetcherResponse, error) {
+	return fetchEnvoyLimited(ctx, client, target, 0)
+}
+
+// fetchEnvoyLimited is fetchEnvoy, but fails if the body is bigger than limit bytes, unless
+// limit is 0.
+func fetchEnvoyLimited(ctx context.Context, client *http.Client, target string, limit int64) (*EnvoyFetcherResponse, error) {
 	// Build a request...
-	req, err := http.NewRequestWithContext(tctx, http.MethodGet, w.defaultReadyURL, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
 
 	if err != nil {
 		// ...which should never fail. WTFO?
@@ -80,12 +371,12 @@ func (w *EnvoyWatcher) defaultFetcher(ctx context.Context) (*EnvoyFetcherRespons
 	}
 
 	// We were able to create the request, so now fire it off.
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 
 	if err != nil {
 		// Unlike the last error case, this one isn't a weird situation at
 		// all -- e.g. if Envoy isn't running yet, we'll land here.
-		return nil, fmt.Errorf("error fetching /ready: %v", err)
+		return nil, fmt.Errorf("error fetching %s: %v", req.URL.Path, err)
 	}
 
 	// Don't forget to close the body once done.
@@ -94,7 +385,11 @@ func (w *EnvoyWatcher) defaultFetcher(ctx context.Context) (*EnvoyFetcherRespons
 	// We're going to return the status code and the response body, so we
 	// need to grab those.
 	statusCode := resp.StatusCode
-	text, err := ioutil.ReadAll(resp.Body)
+	body := io.Reader(resp.Body)
+	if limit > 0 {
+		body = io.LimitReader(resp.Body, limit+1)
+	}
+	text, err := ioutil.ReadAll(body)
 
 	if err != nil {
 		// This is a bit strange -- if we can't read the body, it implies
@@ -102,6 +397,9 @@ func (w *EnvoyWatcher) defaultFetcher(ctx context.Context) (*EnvoyFetcherRespons
 		// call that an error in calling ready.
 		return nil, fmt.Errorf("error reading body: %v", err)
 	}
+	if limit > 0 && int64(len(text)) > limit {
+		return nil, fmt.Errorf("%s is bigger than %d bytes", req.URL.Path, limit)
+	}
 
 	return &EnvoyFetcherResponse{StatusCode: statusCode, Text: text}, nil
 }
@@ -113,46 +411,385 @@ func (w *EnvoyWatcher) SetReadyCheck(readyCheck envoyFetcher) {
 	w.readyCheck = readyCheck
 }
 
-// FetchEnvoyReady will check whether Envoy's ready endpoint is fetchable.
+// SetStateCheck will change the function we use to get Envoy's server state. Like
+// SetReadyCheck, it's here for testing.
+func (w *EnvoyWatcher) SetStateCheck(stateCheck envoyFetcher) {
+	w.stateCheck = stateCheck
+}
+
+// SetConfigCheck will change the function we use to find out whether Envoy has accepted a
+// configuration. Like SetReadyCheck, it's here for testing.
+func (w *EnvoyWatcher) SetConfigCheck(configCheck envoyFetcher) {
+	w.configCheck = configCheck
+}
+
+// SetDrainCheck will change the function we use to count Envoy's draining listeners. Like
+// SetReadyCheck, it's here for testing.
+func (w *EnvoyWatcher) SetDrainCheck(drainCheck envoyFetcher) {
+	w.drainCheck = drainCheck
+}
+
+// SetStatsCheck will change the function we use to fetch Envoy's stats. Like
+// SetReadyCheck, it's here for testing.
+func (w *EnvoyWatcher) SetStatsCheck(statsCheck envoyStatsFetcher) {
+	w.statsCheck = statsCheck
+}
+
+// SetFetchTime will change the function we use to get the current time.
+func (w *EnvoyWatcher) SetFetchTime(fetchTime timeFetcher) {
+	w.fetchTime = fetchTime
+}
+
+// SetClock will change the clock we use to get the current time.
+func (w *EnvoyWatcher) SetClock(c clock.Clock) {
+	w.SetFetchTime(c.Now)
+}
+
+// SetAdminURL will change where the default state and stats fetchers find Envoy's admin
+// interface, e.g. "http://localhost:8001". Call it at instantiation, too.
+func (w *EnvoyWatcher) SetAdminURL(adminURL string) {
+	w.adminURL = strings.TrimSuffix(adminURL, "/")
+	w.adminClient = http.DefaultClient
+}
+
+// SetThresholds will change how many checks in a row have to fail before Envoy isn't
+// alive or ready any more, and how many in a row have to work before it is. Anything
+// less than 1 counts as 1. Call it at instantiation, too.
+func (w *EnvoyWatcher) SetThresholds(failureThreshold, successThreshold int) {
+	if failureThreshold < 1 {
+		failureThreshold = 1
+	}
+	if successThreshold < 1 {
+		successThreshold = 1
+	}
+	w.failureThreshold = failureThreshold
+	w.successThreshold = successThreshold
+}
+
+// SetAdminSocket will have the default state and stats fetchers find Envoy's admin
+// interface on the unix socket at the given path, instead of at a URL. Call it at
+// instantiation, too.
+func (w *EnvoyWatcher) SetAdminSocket(path string) {
+	// The host in the URL only goes in the Host header; every connection goes to the socket.
+	w.adminURL = "http://envoy-admin"
+	w.adminClient = &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+}
+
+// AdminClient returns the base URL of Envoy's admin interface, and the client to reach it
+// with, for anything else that talks to the admin interface: the client dials the admin socket,
+// if there is one.
+func (w *EnvoyWatcher) AdminClient() (string, *http.Client) {
+	return w.adminURL, w.adminClient
+}
+
+// FetchEnvoyStats will fetch Envoy's stats, for GetStats. If that doesn't work,
+// GetStats keeps returning whatever the last fetch that did work got.
+func (w *EnvoyWatcher) FetchEnvoyStats(ctx context.Context) error {
+	texts := make(map[string][]byte, 3)
+	for _, statType := range []string{envoyCounters, envoyGauges, envoyHistograms} {
+		resp, err := w.counted(FetchStats, func(ctx context.Context) (*EnvoyFetcherResponse, error) {
+			return w.statsCheck(ctx, statType)
+		})(ctx)
+		if err != nil {
+			return fmt.Errorf("could not fetch Envoy %s: %w", statType, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("could not fetch Envoy %s: status %d", statType, resp.StatusCode)
+		}
+		texts[statType] = resp.Text
+	}
+
+	stats, err := ParseEnvoyStats(texts[envoyCounters], texts[envoyGauges], texts[envoyHistograms])
+	if err != nil {
+		return fmt.Errorf("could not parse Envoy stats: %w", err)
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.stats = stats
+	return nil
+}
+
+// GetStats returns the stats that the last FetchEnvoyStats that worked got, or nil
+// if none has. They mustn't be modified.
+func (w *EnvoyWatcher) GetStats() *EnvoyStats {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.stats
+}
+
+// FetchEnvoyReady will check whether Envoy's ready endpoint is fetchable, and what
+// state Envoy's server is in.
 func (w *EnvoyWatcher) FetchEnvoyReady(ctx context.Context) {
-	succeeded := false
+	succeeded, alive := false, false
+	checkError := ""
 
-	// Actually check if ready...
+	// Actually check if ready, timing it...
+	start := w.fetchTime()
 	readyResponse, err := w.readyCheck(ctx)
+	end := w.fetchTime()
 
 	// ...and see if we were able to.
 	if err == nil {
-		// Well, nothing blatantly failed, so check the status. (For the
-		// moment, we don't care about the text.)
-		if readyResponse.StatusCode == 200 {
-			succeeded = true
+		// Well, nothing blatantly failed, so see what the evaluator makes of the
+		// answer.
+		var reason string
+		alive, succeeded, reason = w.healthEvaluator.Evaluate(ctx, readyResponse)
+		if !succeeded {
+			checkError = reason
+			if checkError == "" {
+				checkError = "ready check failed its health evaluation"
+			}
 		}
 	} else {
 		dlog.Debugf(ctx, "could not fetch Envoy status: %v", err)
+		checkError = err.Error()
+	}
+
+	w.metrics.record(FetchReady, end.Sub(start), !succeeded)
+
+	// The admin interface answers /ready with the state, whether or not it's LIVE.
+	state := EnvoyStateUnknown
+	stateStart := w.fetchTime()
+	stateResponse, err := w.stateCheck(ctx)
+	if err == nil {
+		state = ParseEnvoyServerState(stateResponse.Text)
+	} else {
+		dlog.Debugf(ctx, "could not fetch Envoy server state: %v", err)
+	}
+	w.metrics.record(FetchState, w.fetchTime().Sub(stateStart), state == EnvoyStateUnknown)
+
+	// Has Envoy accepted a configuration yet? There's no point asking an admin
+	// interface that didn't answer the state check.
+	w.mutex.Lock()
+	accepted := w.configAccepted
+	w.mutex.Unlock()
+	pending := false
+	if !accepted && state != EnvoyStateUnknown {
+		accepted, pending = w.checkConfigAccepted(ctx)
+	}
+
+	// Is it draining listeners?
+	var draining, active uint64
+	if state != EnvoyStateUnknown {
+		draining, active = w.checkListenerDrains(ctx)
 	}
 
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
-	w.LastSucceeded = succeeded
+	w.configAccepted, w.configPending = accepted, pending
+	w.listenersDraining, w.listenersActive = draining, active
+	w.details.Latency = end.Sub(start)
+	if succeeded {
+		w.details.LastSuccessTime = end
+	} else {
+		w.details.LastError, w.details.LastErrorTime = checkError, end
+	}
+	w.LastSucceeded = w.applyThresholds(w.LastSucceeded, succeeded, &w.readyStreak)
+	w.serverState = state
+	w.alive = w.applyThresholds(w.alive, alive || state != EnvoyStateUnknown, &w.aliveStreak)
+	w.noteHealth(end)
+}
+
+// checkConfigAccepted returns whether Envoy has accepted a configuration, and whether it's
+// known not to have. They're both false if Envoy doesn't say.
+func (w *EnvoyWatcher) checkConfigAccepted(ctx context.Context) (accepted, pending bool) {
+	counts, err := fetchStatCounts(ctx, w.counted(FetchConfig, w.configCheck))
+	if err != nil {
+		dlog.Debugf(ctx, "could not fetch Envoy xDS stats: %v", err)
+		return false, false
+	}
+
+	for _, name := range configAcceptedStats {
+		n, ok := counts[name]
+		if !ok {
+			return false, false
+		}
+		if n == 0 {
+			return false, true
+		}
+	}
+	return true, false
+}
+
+// checkListenerDrains returns how many listeners Envoy is draining, and how many it has
+// active. They're both 0 if Envoy doesn't say.
+func (w *EnvoyWatcher) checkListenerDrains(ctx context.Context) (draining, active uint64) {
+	counts, err := fetchStatCounts(ctx, w.counted(FetchDrain, w.drainCheck))
+	if err != nil {
+		dlog.Debugf(ctx, "could not fetch Envoy listener stats: %v", err)
+		return 0, 0
+	}
+	return counts["listener_manager.total_listeners_draining"], counts["listener_manager.total_listeners_active"]
+}
+
+// counted returns fetch, counted in the EnvoyWatcher's metrics as name. It fails if it
+// gets no answer, or anything but a 200.
+func (w *EnvoyWatcher) counted(name string, fetch envoyFetcher) envoyFetcher {
+	return func(ctx context.Context) (*EnvoyFetcherResponse, error) {
+		start := w.fetchTime()
+		resp, err := fetch(ctx)
+		w.metrics.record(name, w.fetchTime().Sub(start), err != nil || resp.StatusCode != http.StatusOK)
+		return resp, err
+	}
+}
+
+// fetchStatCounts fetches some of Envoy's counters or gauges with fetch, and returns them
+// by name.
+func fetchStatCounts(ctx context.Context, fetch envoyFetcher) (map[string]uint64, error) {
+	resp, err := fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	counts := map[string]uint64{}
+	err = parseStatLines(resp.Text, func(name, value string) error {
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		counts[name] = n
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// applyThresholds returns the new value of a result that's currently current, after a
+// check that came out as checked. It only changes once enough checks in a row have
+// disagreed with it; streak counts them. The caller must hold the mutex.
+func (w *EnvoyWatcher) applyThresholds(current, checked bool, streak *int) bool {
+	if checked == current {
+		*streak = 0
+		return current
+	}
+
+	*streak++
+	threshold := w.failureThreshold
+	if checked {
+		threshold = w.successThreshold
+	}
+	if *streak < threshold {
+		return current
+	}
+
+	*streak = 0
+	return checked
 }
 
-// IsAlive returns true IFF Envoy should be considered alive.
+// IsAlive returns true IFF Envoy should be considered alive: we were able to talk
+// to it, whatever state it's in.
 func (w *EnvoyWatcher) IsAlive() bool {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 
-	// Currently we just return LastSucceeded: we will not consider Envoy alive
-	// unless we were able to talk to it.
-	return w.LastSucceeded
+	return w.alive
 }
 
-// IsReady returns true IFF Envoy should be considered ready. Currently Envoy is
-// considered ready whenever it's alive; this method is here for future-proofing.
+// IsReady returns true IFF Envoy should be considered ready: the ready listener
+// answers, Envoy isn't starting up or draining (whether the server state or its
+// listeners say so), and it isn't still waiting for its first configuration.
 func (w *EnvoyWatcher) IsReady() bool {
-	return w.IsAlive()
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.ready()
+}
+
+// ready is IsReady. The caller must hold the mutex.
+func (w *EnvoyWatcher) ready() bool {
+	return w.LastSucceeded && (w.serverState == EnvoyLive || w.serverState == EnvoyStateUnknown) &&
+		!w.configPending && !w.drainingAll()
+}
+
+// drainingAll returns true IFF the last check found Envoy draining listeners, with none
+// left active. The caller must hold the mutex.
+func (w *EnvoyWatcher) drainingAll() bool {
+	return w.listenersDraining > 0 && w.listenersActive == 0
+}
+
+// ListenersDraining returns how many listeners the last check found Envoy draining, and
+// whether that's all of them.
+func (w *EnvoyWatcher) ListenersDraining() (draining uint64, all bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.listenersDraining, w.drainingAll()
+}
+
+// AwaitingConfig returns true IFF the last check found that Envoy hasn't accepted a
+// configuration yet.
+func (w *EnvoyWatcher) AwaitingConfig() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.configPending
+}
+
+// ServerState returns the state that the last check found Envoy's server in, or
+// EnvoyStateUnknown if it couldn't tell.
+func (w *EnvoyWatcher) ServerState() EnvoyServerState {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.serverState
+}
+
+// CheckDetails returns what happened with the ready checks so far.
+func (w *EnvoyWatcher) CheckDetails() EnvoyCheckDetails {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.details
+}
+
+func getReadyURL(scheme, host string, port uint16) string {
+	if scheme == "" {
+		scheme = "http"
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	if port == 0 {
+		port = getDefaultReadyPort()
+	}
+	u := url.URL{
+		Scheme: scheme,
+		Host:   net.JoinHostPort(host, strconv.Itoa(int(port))),
+		Path:   "/ready",
+	}
+	return u.String()
+}
+
+// getDefaultThreshold returns the threshold in the given environment variable, or 1 if
+// it's not set.
+func getDefaultThreshold(name string) int {
+	str := os.Getenv(name)
+	if str == "" {
+		return 1
+	}
+	threshold, err := strconv.Atoi(str)
+	if err != nil || threshold < 1 {
+		dlog.Infof(context.Background(), "Unable to parse %s, or it's less than 1: %q", name, str)
+		return 1
+	}
+	return threshold
 }
 
-func getDefaultReadyURL() string {
+func getDefaultReadyPort() uint16 {
 	var readyPort uint64
 	var err error
 	strReadyPort := os.Getenv("AMBASSADOR_READY_PORT")
@@ -165,5 +802,5 @@ func getDefaultReadyURL() string {
 	if readyPort < 1 {
 		readyPort = 8006
 	}
-	return fmt.Sprintf("http://localhost:%d/ready", readyPort)
+	return uint16(readyPort)
 }
@@ -3,10 +3,18 @@ package acp_test
 import (
 	"context"
 	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/datawire/dlib/dlog"
 	"github.com/emissary-ingress/emissary/v3/pkg/acp"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
 )
 
 type fakeReadyMode string
@@ -53,6 +61,58 @@ func (f *fakeReady) readyCheck(ctx context.Context) (*acp.EnvoyFetcherResponse,
 	return resp, err
 }
 
+// fakeState answers the state check with the given text, or an error if there's no text,
+// like an admin interface that isn't there.
+type fakeState struct {
+	text string
+}
+
+func (f *fakeState) stateCheck(ctx context.Context) (*acp.EnvoyFetcherResponse, error) {
+	if f.text == "" {
+		return nil, fmt.Errorf("fakeState has nothing to say")
+	}
+	statusCode := http.StatusServiceUnavailable
+	if f.text == "LIVE" {
+		statusCode = http.StatusOK
+	}
+	return &acp.EnvoyFetcherResponse{StatusCode: statusCode, Text: []byte(f.text + "\n")}, nil
+}
+
+// fakeConfig answers the config check with Envoy's xDS update_success counters, or an error
+// if it has no text.
+type fakeConfig struct {
+	text   string
+	checks int
+}
+
+func (f *fakeConfig) configCheck(ctx context.Context) (*acp.EnvoyFetcherResponse, error) {
+	f.checks++
+	if f.text == "" {
+		return nil, fmt.Errorf("fakeConfig has nothing to say")
+	}
+	return &acp.EnvoyFetcherResponse{StatusCode: http.StatusOK, Text: []byte(f.text)}, nil
+}
+
+const configAccepted = "cluster_manager.cds.update_success: 1\nlistener_manager.lds.update_success: 2\n"
+
+// fakeDrain answers the drain check with Envoy's listener gauges, or an error if it has no
+// text.
+type fakeDrain struct {
+	text string
+}
+
+func (f *fakeDrain) drainCheck(ctx context.Context) (*acp.EnvoyFetcherResponse, error) {
+	if f.text == "" {
+		return nil, fmt.Errorf("fakeDrain has nothing to say")
+	}
+	return &acp.EnvoyFetcherResponse{StatusCode: http.StatusOK, Text: []byte(f.text)}, nil
+}
+
+// listenersDraining returns Envoy's listener gauges with active and draining listeners.
+func listenersDraining(active, draining int) string {
+	return fmt.Sprintf("listener_manager.total_listeners_active: %d\nlistener_manager.total_listeners_draining: %d\n", active, draining)
+}
+
 type envoyMetadata struct {
 	t  *testing.T
 	f  *fakeReady
@@ -60,12 +120,16 @@ type envoyMetadata struct {
 }
 
 func (m *envoyMetadata) check(seq int, alive bool) {
+	m.checkReady(seq, alive, alive)
+}
+
+func (m *envoyMetadata) checkReady(seq int, alive bool, ready bool) {
 	if m.ew.IsAlive() != alive {
 		m.t.Errorf("%d: EnvoyWatcher.IsAlive %t, wanted %t", seq, m.ew.IsAlive(), alive)
 	}
 
-	if m.ew.IsReady() != alive {
-		m.t.Errorf("%d: EnvoyWatcher.IsReady %t, wanted %t (same as alive)", seq, m.ew.IsAlive(), alive)
+	if m.ew.IsReady() != ready {
+		m.t.Errorf("%d: EnvoyWatcher.IsReady %t, wanted %t", seq, m.ew.IsReady(), ready)
 	}
 }
 
@@ -74,6 +138,9 @@ func newEnvoyMetadata(t *testing.T, readyMode fakeReadyMode) *envoyMetadata {
 
 	ew := acp.NewEnvoyWatcher()
 	ew.SetReadyCheck(f.readyCheck)
+	ew.SetStateCheck((&fakeState{}).stateCheck)
+	ew.SetConfigCheck((&fakeConfig{text: configAccepted}).configCheck)
+	ew.SetDrainCheck((&fakeDrain{text: listenersDraining(2, 0)}).drainCheck)
 
 	if ew == nil {
 		t.Error("New EnvoyWatcher is nil?")
@@ -122,3 +189,318 @@ func TestEnvoySadToHappy(t *testing.T) {
 	m.ew.FetchEnvoyReady(dlog.NewTestContext(t, false))
 	m.check(2, true)
 }
+
+func TestEnvoyThresholds(t *testing.T) {
+	m := newEnvoyMetadata(t, Happy)
+	m.ew.SetThresholds(3, 2)
+	ctx := dlog.NewTestContext(t, false)
+
+	// It takes two checks in a row that work for Envoy to be alive and ready...
+	m.ew.FetchEnvoyReady(ctx)
+	m.check(0, false)
+	m.ew.FetchEnvoyReady(ctx)
+	m.check(1, true)
+
+	// ...and three that fail for it not to be. Two aren't enough, and a check that
+	// works in between starts the count over.
+	m.f.setMode(Error)
+	m.ew.FetchEnvoyReady(ctx)
+	m.ew.FetchEnvoyReady(ctx)
+	m.check(2, true)
+
+	m.f.setMode(Happy)
+	m.ew.FetchEnvoyReady(ctx)
+	m.check(3, true)
+
+	m.f.setMode(Failure)
+	m.ew.FetchEnvoyReady(ctx)
+	m.ew.FetchEnvoyReady(ctx)
+	m.check(4, true)
+	m.ew.FetchEnvoyReady(ctx)
+	m.check(5, false)
+
+	// One check that works isn't enough to come back, either.
+	m.f.setMode(Happy)
+	m.ew.FetchEnvoyReady(ctx)
+	m.check(6, false)
+	m.ew.FetchEnvoyReady(ctx)
+	m.check(7, true)
+
+	// Draining isn't held back, though: Envoy's alive, but not ready, straight away.
+	m.ew.SetStateCheck((&fakeState{text: "DRAINING"}).stateCheck)
+	m.ew.FetchEnvoyReady(ctx)
+	m.checkReady(8, true, false)
+}
+
+func TestEnvoyThresholdsFromEnvironment(t *testing.T) {
+	t.Setenv("AMBASSADOR_ENVOY_FAILURE_THRESHOLD", "2")
+	t.Setenv("AMBASSADOR_ENVOY_SUCCESS_THRESHOLD", "nonsense")
+	m := newEnvoyMetadata(t, Happy)
+	ctx := dlog.NewTestContext(t, false)
+
+	// A success threshold that doesn't parse is 1.
+	m.ew.FetchEnvoyReady(ctx)
+	m.check(0, true)
+
+	m.f.setMode(Error)
+	m.ew.FetchEnvoyReady(ctx)
+	m.check(1, true)
+	m.ew.FetchEnvoyReady(ctx)
+	m.check(2, false)
+}
+
+func TestEnvoyServerState(t *testing.T) {
+	for _, tc := range []struct {
+		mode  fakeReadyMode
+		state string
+		alive bool
+		ready bool
+	}{
+		// Starting up, Envoy doesn't answer on the ready listener yet, but it's alive.
+		{Error, "PRE_INITIALIZING", true, false},
+		{Error, "INITIALIZING", true, false},
+		{Happy, "LIVE", true, true},
+		// Draining, the ready listener fails, but Envoy's still alive.
+		{Failure, "DRAINING", true, false},
+		{Happy, "DRAINING", true, false},
+		// Without an admin interface to ask, the ready listener decides.
+		{Happy, "", true, true},
+		{Failure, "", false, false},
+		{Failure, "whatever", false, false},
+	} {
+		m := newEnvoyMetadata(t, tc.mode)
+		m.ew.SetStateCheck((&fakeState{text: tc.state}).stateCheck)
+		m.ew.FetchEnvoyReady(dlog.NewTestContext(t, false))
+
+		name := fmt.Sprintf("%s/%s", tc.mode, tc.state)
+		if m.ew.IsAlive() != tc.alive {
+			t.Errorf("%s: EnvoyWatcher.IsAlive %t, wanted %t", name, m.ew.IsAlive(), tc.alive)
+		}
+		if m.ew.IsReady() != tc.ready {
+			t.Errorf("%s: EnvoyWatcher.IsReady %t, wanted %t", name, m.ew.IsReady(), tc.ready)
+		}
+		if want := acp.ParseEnvoyServerState([]byte(tc.state)); m.ew.ServerState() != want {
+			t.Errorf("%s: EnvoyWatcher.ServerState %q, wanted %q", name, m.ew.ServerState(), want)
+		}
+	}
+}
+
+func TestEnvoyConfigAccepted(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	m := newEnvoyMetadata(t, Happy)
+	m.ew.SetStateCheck((&fakeState{text: "LIVE"}).stateCheck)
+	config := &fakeConfig{text: "cluster_manager.cds.update_success: 1\nlistener_manager.lds.update_success: 0\n"}
+	m.ew.SetConfigCheck(config.configCheck)
+
+	// LIVE, and the ready listener answers, but there are no listeners from us yet.
+	m.ew.FetchEnvoyReady(ctx)
+	m.checkReady(0, true, false)
+	if !m.ew.AwaitingConfig() {
+		t.Errorf("EnvoyWatcher.AwaitingConfig false, wanted true")
+	}
+
+	// If Envoy doesn't say, that doesn't hold it back.
+	config.text = ""
+	m.ew.FetchEnvoyReady(ctx)
+	m.checkReady(1, true, true)
+
+	config.text = configAccepted
+	m.ew.FetchEnvoyReady(ctx)
+	m.checkReady(2, true, true)
+	if m.ew.AwaitingConfig() {
+		t.Errorf("EnvoyWatcher.AwaitingConfig true, wanted false")
+	}
+
+	// Once Envoy has accepted a configuration, there's no need to ask again.
+	config.text = "listener_manager.lds.update_success: 0\n"
+	checks := config.checks
+	m.ew.FetchEnvoyReady(ctx)
+	m.checkReady(3, true, true)
+	if config.checks != checks {
+		t.Errorf("EnvoyWatcher checked the config again after Envoy accepted one")
+	}
+}
+
+func TestEnvoyListenersDraining(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	m := newEnvoyMetadata(t, Happy)
+	m.ew.SetStateCheck((&fakeState{text: "LIVE"}).stateCheck)
+	drain := &fakeDrain{text: listenersDraining(2, 0)}
+	m.ew.SetDrainCheck(drain.drainCheck)
+
+	checkDraining := func(wantDraining uint64, wantAll bool) {
+		t.Helper()
+		if draining, all := m.ew.ListenersDraining(); draining != wantDraining || all != wantAll {
+			t.Errorf("EnvoyWatcher.ListenersDraining %d, %v, wanted %d, %v", draining, all, wantDraining, wantAll)
+		}
+	}
+
+	m.ew.FetchEnvoyReady(ctx)
+	m.checkReady(0, true, true)
+	checkDraining(0, false)
+
+	// A configuration change replaced a listener: the old one is draining, but the new
+	// one is taking connections, so Envoy is still ready.
+	drain.text = listenersDraining(2, 1)
+	m.ew.FetchEnvoyReady(ctx)
+	m.checkReady(1, true, true)
+	checkDraining(1, false)
+
+	// Envoy's draining all its listeners, even if the server state hasn't caught up.
+	drain.text = listenersDraining(0, 2)
+	m.ew.FetchEnvoyReady(ctx)
+	m.checkReady(2, true, false)
+	checkDraining(2, true)
+
+	// If Envoy doesn't say, that doesn't hold it back.
+	drain.text = ""
+	m.ew.FetchEnvoyReady(ctx)
+	m.checkReady(3, true, true)
+	checkDraining(0, false)
+}
+
+func TestEnvoyWithAddress(t *testing.T) {
+	ready := false
+	draining := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ready" || !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if draining {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, "DRAINING\n")
+			return
+		}
+		fmt.Fprint(w, "live")
+	}))
+	defer srv.Close()
+
+	host, strPort, err := net.SplitHostPort(srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.ParseUint(strPort, 10, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same server stands in for both the ready listener and the admin interface.
+	ew := acp.NewEnvoyWatcherWithAddress("http", host, uint16(port))
+	ew.SetAdminURL(srv.URL)
+	m := &envoyMetadata{t: t, ew: ew}
+	m.check(0, false)
+
+	// The server isn't ready yet.
+	m.ew.FetchEnvoyReady(dlog.NewTestContext(t, false))
+	m.check(1, false)
+
+	ready = true
+	m.ew.FetchEnvoyReady(dlog.NewTestContext(t, false))
+	m.check(2, true)
+
+	draining = true
+	m.ew.FetchEnvoyReady(dlog.NewTestContext(t, false))
+	m.checkReady(3, true, false)
+}
+
+func TestEnvoyAdminSocket(t *testing.T) {
+	// Unix socket paths have to be short, and t.TempDir() can be long.
+	dir, err := os.MkdirTemp("", "acp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "admin.sock")
+
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/ready":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, "DRAINING\n")
+		case r.URL.Path == "/stats" && r.URL.Query().Get("type") == "Histograms":
+			fmt.Fprint(w, "latency: No recorded values\n")
+		case r.URL.Path == "/stats":
+			fmt.Fprintf(w, "%s.requests: 1\n", r.URL.Query().Get("type"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})}
+	go func() { _ = srv.Serve(ln) }()
+	defer srv.Close()
+
+	// The default state and stats fetchers both go to the socket.
+	t.Setenv("AMBASSADOR_ENVOY_ADMIN_SOCKET", socket)
+	ew := acp.NewEnvoyWatcher()
+	ew.SetReadyCheck((&fakeReady{mode: Happy}).readyCheck)
+	ctx := dlog.NewTestContext(t, false)
+
+	ew.FetchEnvoyReady(ctx)
+	if ew.ServerState() != acp.EnvoyDraining {
+		t.Errorf("EnvoyWatcher.ServerState %q over the admin socket, wanted %q", ew.ServerState(), acp.EnvoyDraining)
+	}
+	if ew.IsReady() {
+		t.Error("EnvoyWatcher.IsReady while Envoy is draining")
+	}
+
+	if err := ew.FetchEnvoyStats(ctx); err != nil {
+		t.Fatalf("EnvoyWatcher.FetchEnvoyStats over the admin socket: %v", err)
+	}
+	if got := ew.GetStats().Gauges["Gauges.requests"]; got != 1 {
+		t.Errorf("EnvoyWatcher.GetStats Gauges.requests %d, wanted 1", got)
+	}
+}
+
+func TestEnvoyCheckDetails(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	fake := clock.NewFake(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	f := &fakeReady{mode: Happy}
+
+	ew := acp.NewEnvoyWatcher()
+	ew.SetClock(fake)
+	ew.SetStateCheck((&fakeState{}).stateCheck)
+	ew.SetReadyCheck(func(ctx context.Context) (*acp.EnvoyFetcherResponse, error) {
+		fake.Advance(5 * time.Millisecond)
+		return f.readyCheck(ctx)
+	})
+
+	if got := ew.CheckDetails(); got != (acp.EnvoyCheckDetails{}) {
+		t.Errorf("before any checks, CheckDetails %+v", got)
+	}
+	if got := ew.CheckDetails().Describe(fake.Now()); got != "Envoy hasn't been checked yet" {
+		t.Errorf("before any checks, Describe %q", got)
+	}
+
+	ew.FetchEnvoyReady(ctx)
+	succeeded := fake.Now()
+
+	fake.Advance(10 * time.Second)
+	f.setMode(Failure)
+	ew.FetchEnvoyReady(ctx)
+	failed := fake.Now()
+
+	details := ew.CheckDetails()
+	want := acp.EnvoyCheckDetails{
+		LastError:       "ready check returned status 503",
+		LastErrorTime:   failed,
+		LastSuccessTime: succeeded,
+		Latency:         5 * time.Millisecond,
+	}
+	if details != want {
+		t.Errorf("CheckDetails %+v, wanted %+v", details, want)
+	}
+
+	fake.Advance(2 * time.Second)
+	f.setMode(Error)
+	ew.FetchEnvoyReady(ctx)
+
+	fake.Advance(time.Second)
+	got := ew.CheckDetails().Describe(fake.Now())
+	if want := "Envoy last check took 5ms; last succeeded 13.01s ago; last failed 1s ago: fakeReady Error always errors"; got != want {
+		t.Errorf("Describe %q, wanted %q", got, want)
+	}
+}
@@ -0,0 +1,178 @@
+package acp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ClusterHealthStatus is what Envoy thinks of an upstream cluster's members, on the whole.
+type ClusterHealthStatus string
+
+const (
+	// ClusterHealthy means every member is healthy.
+	ClusterHealthy = ClusterHealthStatus("healthy")
+	// ClusterDegraded means some members are healthy (or degraded, which Envoy still
+	// routes to, if it has to), and some aren't.
+	ClusterDegraded = ClusterHealthStatus("degraded")
+	// ClusterUnhealthy means there are members, and none of them is healthy or degraded.
+	ClusterUnhealthy = ClusterHealthStatus("unhealthy")
+	// ClusterEmpty means there are no members at all, e.g. a Service with no endpoints.
+	ClusterEmpty = ClusterHealthStatus("empty")
+)
+
+// ClusterHealth is the health of one upstream cluster, from its membership gauges.
+type ClusterHealth struct {
+	Name     string              `json:"name"`
+	Status   ClusterHealthStatus `json:"status"`
+	Healthy  uint64              `json:"healthy"`
+	Degraded uint64              `json:"degraded"`
+	Total    uint64              `json:"total"`
+}
+
+// UpstreamHealth is the health of every upstream cluster, as of Time, sorted by name.
+type UpstreamHealth struct {
+	Time     time.Time       `json:"time"`
+	Clusters []ClusterHealth `json:"clusters"`
+
+	// How many clusters are degraded, unhealthy, or empty.
+	Degraded  int `json:"degraded"`
+	Unhealthy int `json:"unhealthy"`
+	Empty     int `json:"empty"`
+}
+
+// NotHealthy returns the clusters that aren't healthy.
+func (h *UpstreamHealth) NotHealthy() []ClusterHealth {
+	var clusters []ClusterHealth
+	for _, c := range h.Clusters {
+		if c.Status != ClusterHealthy {
+			clusters = append(clusters, c)
+		}
+	}
+	return clusters
+}
+
+// The membership gauges that we want, as cluster.<name>.<gauge>.
+const (
+	membershipHealthy  = ".membership_healthy"
+	membershipDegraded = ".membership_degraded"
+	membershipTotal    = ".membership_total"
+)
+
+// This is the default cluster health fetcher for the EnvoyWatcher: it gets every cluster's
+// membership gauges from Envoy's admin interface.
+func (w *EnvoyWatcher) defaultClusterHealthFetcher(ctx context.Context) (*EnvoyFetcherResponse, error) {
+	// There are three gauges for every cluster, so allow it as long as /stats.
+	tctx, tcancel := context.WithTimeout(ctx, 5*time.Second)
+	defer tcancel()
+
+	filter := `^cluster\..+\.membership_(healthy|degraded|total)$`
+	return fetchEnvoy(tctx, w.adminClient, w.adminURL+"/stats?filter="+url.QueryEscape(filter))
+}
+
+// SetClusterHealthCheck will change the function we use to fetch the membership gauges of
+// Envoy's clusters. Like SetReadyCheck, it's here for testing.
+func (w *EnvoyWatcher) SetClusterHealthCheck(clusterHealthCheck envoyFetcher) {
+	w.clusterHealthCheck = clusterHealthCheck
+}
+
+// FetchClusterHealth will fetch the health of Envoy's upstream clusters, for
+// ClusterHealth. If that doesn't work, ClusterHealth keeps returning whatever the last
+// fetch that did work got.
+func (w *EnvoyWatcher) FetchClusterHealth(ctx context.Context) error {
+	resp, err := w.counted(FetchClusterHealth, w.clusterHealthCheck)(ctx)
+	if err != nil {
+		return fmt.Errorf("could not fetch Envoy cluster health: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not fetch Envoy cluster health: status %d", resp.StatusCode)
+	}
+
+	health, err := ParseClusterHealth(resp.Text)
+	if err != nil {
+		return fmt.Errorf("could not parse Envoy cluster health: %w", err)
+	}
+	health.Time = w.fetchTime()
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.clusterHealth = health
+	return nil
+}
+
+// ClusterHealth returns the health of Envoy's upstream clusters that the last
+// FetchClusterHealth that worked got, or nil if none has. It mustn't be modified.
+func (w *EnvoyWatcher) ClusterHealth() *UpstreamHealth {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.clusterHealth
+}
+
+// ParseClusterHealth parses Envoy's cluster.<name>.membership_* gauges, one "name: value"
+// per line, into the health of each cluster. Other stats are ignored.
+func ParseClusterHealth(text []byte) (*UpstreamHealth, error) {
+	clusters := map[string]*ClusterHealth{}
+	err := parseStatLines(text, func(name, value string) error {
+		if !strings.HasPrefix(name, "cluster.") {
+			return nil
+		}
+		dot := strings.LastIndex(name, ".")
+		if dot < len("cluster.") {
+			return nil
+		}
+		cluster, gauge := name[len("cluster."):dot], name[dot:]
+		if gauge != membershipHealthy && gauge != membershipDegraded && gauge != membershipTotal {
+			return nil
+		}
+
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		c := clusters[cluster]
+		if c == nil {
+			c = &ClusterHealth{Name: cluster}
+			clusters[cluster] = c
+		}
+		switch gauge {
+		case membershipHealthy:
+			c.Healthy = n
+		case membershipDegraded:
+			c.Degraded = n
+		case membershipTotal:
+			c.Total = n
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	health := &UpstreamHealth{Clusters: make([]ClusterHealth, 0, len(clusters))}
+	for _, c := range clusters {
+		switch {
+		case c.Total == 0:
+			c.Status = ClusterEmpty
+			health.Empty++
+		case c.Healthy >= c.Total:
+			c.Status = ClusterHealthy
+		case c.Healthy+c.Degraded == 0:
+			c.Status = ClusterUnhealthy
+			health.Unhealthy++
+		default:
+			c.Status = ClusterDegraded
+			health.Degraded++
+		}
+		health.Clusters = append(health.Clusters, *c)
+	}
+	sort.Slice(health.Clusters, func(i, j int) bool {
+		return health.Clusters[i].Name < health.Clusters[j].Name
+	})
+	return health, nil
+}
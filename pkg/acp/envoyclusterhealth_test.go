@@ -0,0 +1,102 @@
+package acp_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/datawire/dlib/dtime"
+	"github.com/emissary-ingress/emissary/v3/pkg/acp"
+)
+
+const clusterHealthText = `cluster.cluster_quote_default.membership_degraded: 0
+cluster.cluster_quote_default.membership_healthy: 3
+cluster.cluster_quote_default.membership_total: 3
+cluster.cluster_auth_default.membership_degraded: 1
+cluster.cluster_auth_default.membership_healthy: 1
+cluster.cluster_auth_default.membership_total: 3
+cluster.cluster_billing_default.membership_degraded: 0
+cluster.cluster_billing_default.membership_healthy: 0
+cluster.cluster_billing_default.membership_total: 2
+cluster.cluster_gone_default.membership_healthy: 0
+cluster.cluster_gone_default.membership_total: 0
+cluster.cluster_quote_default.upstream_rq_total: 12
+server.live: 1
+`
+
+func TestParseClusterHealth(t *testing.T) {
+	health, err := acp.ParseClusterHealth([]byte(clusterHealthText))
+	require.NoError(t, err)
+
+	assert.Equal(t, []acp.ClusterHealth{
+		{Name: "cluster_auth_default", Status: acp.ClusterDegraded, Healthy: 1, Degraded: 1, Total: 3},
+		{Name: "cluster_billing_default", Status: acp.ClusterUnhealthy, Total: 2},
+		{Name: "cluster_gone_default", Status: acp.ClusterEmpty},
+		{Name: "cluster_quote_default", Status: acp.ClusterHealthy, Healthy: 3, Total: 3},
+	}, health.Clusters)
+	assert.Equal(t, 1, health.Degraded)
+	assert.Equal(t, 1, health.Unhealthy)
+	assert.Equal(t, 1, health.Empty)
+
+	var names []string
+	for _, c := range health.NotHealthy() {
+		names = append(names, c.Name)
+	}
+	assert.Equal(t, []string{"cluster_auth_default", "cluster_billing_default", "cluster_gone_default"}, names)
+
+	_, err = acp.ParseClusterHealth([]byte("cluster.x.membership_total: lots\n"))
+	assert.Error(t, err)
+}
+
+func TestEnvoyClusterHealth(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	ft := dtime.NewFakeTime()
+	text := clusterHealthText
+
+	ew := acp.NewEnvoyWatcher()
+	ew.SetFetchTime(ft.Now)
+	ew.SetClusterHealthCheck(func(context.Context) (*acp.EnvoyFetcherResponse, error) {
+		if text == "" {
+			return nil, fmt.Errorf("no Envoy")
+		}
+		return &acp.EnvoyFetcherResponse{StatusCode: http.StatusOK, Text: []byte(text)}, nil
+	})
+	aw := acp.NewAmbassadorWatcher(ew, acp.NewDiagdWatcher())
+
+	assert.Nil(t, aw.UpstreamHealth())
+
+	require.NoError(t, aw.FetchUpstreamHealth(ctx))
+	health := aw.UpstreamHealth()
+	require.NotNil(t, health)
+	assert.Len(t, health.Clusters, 4)
+	assert.Equal(t, ft.Now(), health.Time)
+
+	// A fetch that doesn't work leaves the last one that did.
+	ft.StepSec(10)
+	text = ""
+	assert.Error(t, aw.FetchUpstreamHealth(ctx))
+	assert.Same(t, health, aw.UpstreamHealth())
+}
+
+func TestEnvoyClusterHealthFromAdmin(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stats" || r.URL.Query().Get("filter") == "" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, clusterHealthText)
+	}))
+	defer srv.Close()
+
+	ew := acp.NewEnvoyWatcher()
+	ew.SetAdminURL(srv.URL)
+
+	require.NoError(t, ew.FetchClusterHealth(dlog.NewTestContext(t, false)))
+	assert.Equal(t, 1, ew.ClusterHealth().Unhealthy)
+}
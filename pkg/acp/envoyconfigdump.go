@@ -0,0 +1,193 @@
+package acp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+// DefaultConfigDumpMaxBytes is the biggest config dump that ConfigDump takes, unless
+// AMBASSADOR_ENVOY_CONFIG_DUMP_MAX_BYTES or SetConfigDumpLimits says otherwise.
+const DefaultConfigDumpMaxBytes = 64 << 20
+
+// DefaultConfigDumpMaxAge is how long ConfigDump keeps a config dump, unless
+// SetConfigDumpLimits says otherwise.
+const DefaultConfigDumpMaxAge = 10 * time.Second
+
+// Redacted takes the place of a secret in a config dump. It's what Envoy uses itself.
+const Redacted = "[redacted]"
+
+// Envoy redacts what it knows to be secret (private keys, mostly) itself. These are the
+// fields that it doesn't, but that are secrets wherever they turn up.
+var sensitiveConfigFields = map[string]bool{
+	"client_secret":       true,
+	"generic_secret":      true,
+	"hmac_secret":         true,
+	"password":            true,
+	"private_key":         true,
+	"secret_access_key":   true,
+	"session_ticket_keys": true,
+	"token_secret":        true,
+}
+
+// Headers that Envoy is configured to add can be credentials too: an AuthService's
+// add_auth_headers, say.
+var sensitiveHeaders = map[string]bool{
+	"authorization":       true,
+	"cookie":              true,
+	"proxy-authorization": true,
+	"set-cookie":          true,
+	"x-api-key":           true,
+}
+
+// EnvoyConfigDump is Envoy's /config_dump, with its secrets redacted.
+type EnvoyConfigDump struct {
+	// JSON is the redacted config dump.
+	JSON []byte
+	// Fetched is when it was fetched.
+	Fetched time.Time
+	// Redactions is how many secrets were redacted from it, not counting the ones that
+	// Envoy redacted itself.
+	Redactions int
+}
+
+// This is the default config dump fetcher for the EnvoyWatcher. The caller must hold
+// the configDumpMutex.
+func (w *EnvoyWatcher) defaultConfigDumpFetcher(ctx context.Context) (*EnvoyFetcherResponse, error) {
+	// A config dump is even bigger than /stats.
+	tctx, tcancel := context.WithTimeout(ctx, 10*time.Second)
+	defer tcancel()
+
+	return fetchEnvoyLimited(tctx, w.adminClient, w.adminURL+"/config_dump", w.configDumpMaxBytes)
+}
+
+// SetConfigDumpCheck will change the function we use to fetch Envoy's config dump. Like
+// SetReadyCheck, it's here for testing.
+func (w *EnvoyWatcher) SetConfigDumpCheck(configDumpCheck envoyFetcher) {
+	w.configDumpCheck = configDumpCheck
+}
+
+// SetConfigDumpLimits sets the biggest config dump that ConfigDump takes, and how long it
+// keeps one.
+func (w *EnvoyWatcher) SetConfigDumpLimits(maxBytes int64, maxAge time.Duration) {
+	w.configDumpMutex.Lock()
+	defer w.configDumpMutex.Unlock()
+
+	w.configDumpMaxBytes = maxBytes
+	w.configDumpMaxAge = maxAge
+	w.configDump = nil
+}
+
+// ConfigDump returns Envoy's config dump, with its secrets redacted. If the last one was
+// fetched less than the maximum age ago, that's what it returns; otherwise it fetches a
+// new one. Only one fetch happens at a time.
+func (w *EnvoyWatcher) ConfigDump(ctx context.Context) (*EnvoyConfigDump, error) {
+	w.configDumpMutex.Lock()
+	defer w.configDumpMutex.Unlock()
+
+	now := w.fetchTime()
+	if dump := w.configDump; dump != nil && now.Sub(dump.Fetched) < w.configDumpMaxAge {
+		return dump, nil
+	}
+
+	resp, err := w.counted(FetchConfigDump, w.configDumpCheck)(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch Envoy's config dump: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not fetch Envoy's config dump: status %d", resp.StatusCode)
+	}
+	if w.configDumpMaxBytes > 0 && int64(len(resp.Text)) > w.configDumpMaxBytes {
+		return nil, fmt.Errorf("Envoy's config dump is bigger than %d bytes", w.configDumpMaxBytes)
+	}
+
+	// Numbers stay as they are, rather than turning into float64s.
+	var config interface{}
+	decoder := json.NewDecoder(bytes.NewReader(resp.Text))
+	decoder.UseNumber()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("could not parse Envoy's config dump: %w", err)
+	}
+
+	redactions := redactConfig(config)
+	text, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	w.configDump = &EnvoyConfigDump{JSON: text, Fetched: now, Redactions: redactions}
+	return w.configDump, nil
+}
+
+// redactConfig redacts the secrets in a decoded config dump, in place, and returns how
+// many it redacted.
+func redactConfig(config interface{}) int {
+	redactions := 0
+	redact := func(obj map[string]interface{}, field string) {
+		if value, ok := obj[field]; ok && !isRedacted(value) {
+			obj[field] = Redacted
+			redactions++
+		}
+	}
+
+	switch config := config.(type) {
+	case map[string]interface{}:
+		// A header, like {"key": "authorization", "value": "Bearer ..."}.
+		if key, ok := config["key"].(string); ok && sensitiveHeaders[strings.ToLower(key)] {
+			redact(config, "value")
+			redact(config, "raw_value")
+		}
+		for field, value := range config {
+			if sensitiveConfigFields[field] {
+				redact(config, field)
+				continue
+			}
+			redactions += redactConfig(value)
+		}
+	case []interface{}:
+		for _, value := range config {
+			redactions += redactConfig(value)
+		}
+	}
+	return redactions
+}
+
+// isRedacted returns whether a value has been redacted already: by Envoy, which redacts a
+// private key's DataSource like {"inline_string": "[redacted]"}, or by us.
+func isRedacted(value interface{}) bool {
+	switch value := value.(type) {
+	case string:
+		return value == Redacted
+	case map[string]interface{}:
+		for _, v := range value {
+			if !isRedacted(v) {
+				return false
+			}
+		}
+		return len(value) > 0
+	}
+	return false
+}
+
+// getDefaultConfigDumpMaxBytes returns AMBASSADOR_ENVOY_CONFIG_DUMP_MAX_BYTES, or
+// DefaultConfigDumpMaxBytes if it's not set.
+func getDefaultConfigDumpMaxBytes() int64 {
+	str := os.Getenv("AMBASSADOR_ENVOY_CONFIG_DUMP_MAX_BYTES")
+	if str == "" {
+		return DefaultConfigDumpMaxBytes
+	}
+	maxBytes, err := strconv.ParseInt(str, 10, 64)
+	if err != nil || maxBytes < 1 {
+		dlog.Infof(context.Background(), "Unable to parse AMBASSADOR_ENVOY_CONFIG_DUMP_MAX_BYTES, or it's less than 1: %q", str)
+		return DefaultConfigDumpMaxBytes
+	}
+	return maxBytes
+}
@@ -0,0 +1,163 @@
+package acp_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/datawire/dlib/dtime"
+	"github.com/emissary-ingress/emissary/v3/pkg/acp"
+)
+
+const configDumpText = `{
+  "configs": [
+    {
+      "@type": "type.googleapis.com/envoy.admin.v3.SecretsConfigDump",
+      "static_secrets": [
+        {
+          "name": "host-cert",
+          "secret": {
+            "tls_certificate": {
+              "certificate_chain": {"inline_string": "-----BEGIN CERTIFICATE-----"},
+              "private_key": {"inline_string": "[redacted]"}
+            }
+          }
+        }
+      ]
+    },
+    {
+      "@type": "type.googleapis.com/envoy.admin.v3.ClustersConfigDump",
+      "static_clusters": [
+        {
+          "cluster": {
+            "name": "cluster_oauth",
+            "connect_timeout": "3s",
+            "per_connection_buffer_limit_bytes": 1048576
+          },
+          "client_secret": "hunter2"
+        }
+      ]
+    },
+    {
+      "@type": "type.googleapis.com/envoy.admin.v3.RoutesConfigDump",
+      "request_headers_to_add": [
+        {"header": {"key": "Authorization", "value": "Bearer hunter2"}},
+        {"header": {"key": "x-forwarded-proto", "value": "https"}}
+      ]
+    }
+  ]
+}`
+
+// fakeConfigDump answers the config dump check with text, and counts how often it's asked.
+type fakeConfigDump struct {
+	status int
+	text   string
+	calls  int
+}
+
+func (f *fakeConfigDump) configDumpCheck(ctx context.Context) (*acp.EnvoyFetcherResponse, error) {
+	f.calls++
+	return &acp.EnvoyFetcherResponse{StatusCode: f.status, Text: []byte(f.text)}, nil
+}
+
+func TestEnvoyConfigDump(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	ft := dtime.NewFakeTime()
+	fake := &fakeConfigDump{status: http.StatusOK, text: configDumpText}
+
+	ew := acp.NewEnvoyWatcher()
+	ew.SetFetchTime(ft.Now)
+	ew.SetConfigDumpCheck(fake.configDumpCheck)
+
+	dump, err := ew.ConfigDump(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, ft.Now(), dump.Fetched)
+
+	// Envoy already redacted the private key, so that one doesn't count.
+	assert.Equal(t, 2, dump.Redactions)
+	assert.NotContains(t, string(dump.JSON), "hunter2")
+
+	var config struct {
+		Configs []map[string]interface{} `json:"configs"`
+	}
+	require.NoError(t, json.Unmarshal(dump.JSON, &config))
+	require.Len(t, config.Configs, 3)
+
+	clusters := config.Configs[1]["static_clusters"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, acp.Redacted, clusters["client_secret"])
+	// Numbers come through as they were.
+	cluster := clusters["cluster"].(map[string]interface{})
+	assert.Equal(t, 1048576.0, cluster["per_connection_buffer_limit_bytes"])
+
+	headers := config.Configs[2]["request_headers_to_add"].([]interface{})
+	assert.Equal(t, acp.Redacted, headers[0].(map[string]interface{})["header"].(map[string]interface{})["value"])
+	assert.Equal(t, "https", headers[1].(map[string]interface{})["header"].(map[string]interface{})["value"])
+
+	// A second ask within the maximum age gets the same dump...
+	ft.StepSec(5)
+	again, err := ew.ConfigDump(ctx)
+	require.NoError(t, err)
+	assert.Same(t, dump, again)
+	assert.Equal(t, 1, fake.calls)
+
+	// ...but after it, Envoy gets asked again.
+	ft.StepSec(5)
+	again, err = ew.ConfigDump(ctx)
+	require.NoError(t, err)
+	assert.NotSame(t, dump, again)
+	assert.Equal(t, 2, fake.calls)
+}
+
+func TestEnvoyConfigDumpErrors(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	fake := &fakeConfigDump{status: http.StatusServiceUnavailable}
+
+	ew := acp.NewEnvoyWatcher()
+	ew.SetConfigDumpCheck(fake.configDumpCheck)
+
+	_, err := ew.ConfigDump(ctx)
+	assert.EqualError(t, err, "could not fetch Envoy's config dump: status 503")
+
+	fake.status = http.StatusOK
+	fake.text = "{not json"
+	_, err = ew.ConfigDump(ctx)
+	assert.ErrorContains(t, err, "could not parse Envoy's config dump")
+
+	fake.text = configDumpText
+	ew.SetConfigDumpLimits(64, time.Minute)
+	_, err = ew.ConfigDump(ctx)
+	assert.EqualError(t, err, "Envoy's config dump is bigger than 64 bytes")
+
+	// Failures aren't cached.
+	assert.Equal(t, 3, fake.calls)
+}
+
+func TestEnvoyConfigDumpAdmin(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/config_dump" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(configDumpText))
+	}))
+	defer srv.Close()
+
+	ew := acp.NewEnvoyWatcher()
+	ew.SetAdminURL(srv.URL)
+
+	dump, err := ew.ConfigDump(dlog.NewTestContext(t, false))
+	require.NoError(t, err)
+	assert.Equal(t, 2, dump.Redactions)
+
+	// The limit applies to what's read from Envoy, too.
+	ew.SetConfigDumpLimits(64, time.Minute)
+	_, err = ew.ConfigDump(dlog.NewTestContext(t, false))
+	assert.ErrorContains(t, err, "is bigger than 64 bytes")
+}
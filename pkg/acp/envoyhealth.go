@@ -0,0 +1,72 @@
+package acp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// HealthEvaluator decides what the answer to a ready check says about Envoy. The
+// EnvoyWatcher hands it every answer its ready check gets (a ready check that fails
+// to get an answer at all is a failure, without asking), and goes by what it says,
+// subject to the thresholds.
+//
+// alive is whether the answer shows that Envoy is running; ready is whether it should
+// get traffic. reason says why it shouldn't, if it shouldn't: it's what CheckDetails
+// reports as the last error. Envoy's server state still counts for alive: if the
+// admin interface says what state Envoy is in, it's alive, whatever the evaluator
+// says. And it still counts for ready, along with whether Envoy has accepted a
+// configuration and whether it's draining: an evaluator can hold Envoy back, but not
+// make it ready when they say it isn't.
+type HealthEvaluator interface {
+	Evaluate(ctx context.Context, resp *EnvoyFetcherResponse) (alive, ready bool, reason string)
+}
+
+// HealthEvaluatorFunc is a function that's a HealthEvaluator.
+type HealthEvaluatorFunc func(ctx context.Context, resp *EnvoyFetcherResponse) (alive, ready bool, reason string)
+
+// Evaluate calls f.
+func (f HealthEvaluatorFunc) Evaluate(ctx context.Context, resp *EnvoyFetcherResponse) (alive, ready bool, reason string) {
+	return f(ctx, resp)
+}
+
+// DefaultHealthEvaluator is what the EnvoyWatcher goes by unless SetHealthEvaluator
+// says otherwise: a 200 from the ready listener means Envoy is alive and ready, and
+// anything else means it's neither.
+var DefaultHealthEvaluator HealthEvaluator = HealthEvaluatorFunc(evaluateStatusOK)
+
+func evaluateStatusOK(_ context.Context, resp *EnvoyFetcherResponse) (bool, bool, string) {
+	if resp.StatusCode != http.StatusOK {
+		return false, false, fmt.Sprintf("ready check returned status %d", resp.StatusCode)
+	}
+	return true, true, ""
+}
+
+// AllHealthEvaluators returns a HealthEvaluator that asks each of evaluators in turn.
+// Envoy is only alive if they all say so, and only ready if they all say so; the
+// reason is the first one that says it isn't ready. To add a check to the default
+// one, rather than replace it, include DefaultHealthEvaluator.
+func AllHealthEvaluators(evaluators ...HealthEvaluator) HealthEvaluator {
+	return HealthEvaluatorFunc(func(ctx context.Context, resp *EnvoyFetcherResponse) (bool, bool, string) {
+		alive, ready, reason := true, true, ""
+		for _, evaluator := range evaluators {
+			a, r, why := evaluator.Evaluate(ctx, resp)
+			alive = alive && a
+			if !r && ready {
+				ready, reason = false, why
+			}
+		}
+		return alive, ready, reason
+	})
+}
+
+// SetHealthEvaluator will change what the EnvoyWatcher makes of the answers to its ready
+// checks. Unlike the fetchers, this isn't just for testing: a caller that knows more
+// about what makes its Envoy ready can say so here. A nil evaluator means
+// DefaultHealthEvaluator. Call it at instantiation, then leave it alone.
+func (w *EnvoyWatcher) SetHealthEvaluator(evaluator HealthEvaluator) {
+	if evaluator == nil {
+		evaluator = DefaultHealthEvaluator
+	}
+	w.healthEvaluator = evaluator
+}
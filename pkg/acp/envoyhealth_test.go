@@ -0,0 +1,95 @@
+package acp_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/acp"
+)
+
+func TestDefaultHealthEvaluator(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+
+	alive, ready, reason := acp.DefaultHealthEvaluator.Evaluate(ctx, &acp.EnvoyFetcherResponse{StatusCode: 200})
+	assert.True(t, alive)
+	assert.True(t, ready)
+	assert.Empty(t, reason)
+
+	alive, ready, reason = acp.DefaultHealthEvaluator.Evaluate(ctx, &acp.EnvoyFetcherResponse{StatusCode: 503})
+	assert.False(t, alive)
+	assert.False(t, ready)
+	assert.Equal(t, "ready check returned status 503", reason)
+}
+
+func TestAllHealthEvaluators(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	says := func(alive, ready bool, reason string) acp.HealthEvaluator {
+		return acp.HealthEvaluatorFunc(func(context.Context, *acp.EnvoyFetcherResponse) (bool, bool, string) {
+			return alive, ready, reason
+		})
+	}
+
+	alive, ready, reason := acp.AllHealthEvaluators().Evaluate(ctx, nil)
+	assert.True(t, alive && ready)
+	assert.Empty(t, reason)
+
+	alive, ready, reason = acp.AllHealthEvaluators(
+		says(true, true, ""),
+		says(true, false, "warming up"),
+		says(false, false, "gone"),
+	).Evaluate(ctx, nil)
+	assert.False(t, alive)
+	assert.False(t, ready)
+	assert.Equal(t, "warming up", reason)
+}
+
+func TestEnvoyHealthEvaluator(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	m := newEnvoyMetadata(t, Happy)
+
+	// Envoy isn't ready until the ready listener's answer says it's warm, on top of the
+	// default's 200.
+	m.ew.SetHealthEvaluator(acp.AllHealthEvaluators(
+		acp.DefaultHealthEvaluator,
+		acp.HealthEvaluatorFunc(func(_ context.Context, resp *acp.EnvoyFetcherResponse) (bool, bool, string) {
+			if !strings.Contains(string(resp.Text), "warm") {
+				return true, false, "not warm yet"
+			}
+			return true, true, ""
+		}),
+	))
+
+	m.ew.FetchEnvoyReady(ctx)
+	m.checkReady(0, true, false)
+	assert.Equal(t, "not warm yet", m.ew.CheckDetails().LastError)
+
+	m.ew.SetReadyCheck(func(context.Context) (*acp.EnvoyFetcherResponse, error) {
+		return &acp.EnvoyFetcherResponse{StatusCode: 200, Text: []byte("Ready and warm")}, nil
+	})
+	m.ew.FetchEnvoyReady(ctx)
+	m.checkReady(1, true, true)
+
+	// The default's 503 still makes it neither.
+	m.f.setMode(Failure)
+	m.ew.SetReadyCheck(m.f.readyCheck)
+	m.ew.FetchEnvoyReady(ctx)
+	m.checkReady(2, false, false)
+	assert.Equal(t, "ready check returned status 503", m.ew.CheckDetails().LastError)
+
+	// An evaluator that doesn't say why still leaves a reason, and nil puts the default
+	// back.
+	m.f.setMode(Happy)
+	m.ew.SetHealthEvaluator(acp.HealthEvaluatorFunc(func(context.Context, *acp.EnvoyFetcherResponse) (bool, bool, string) {
+		return false, false, ""
+	}))
+	m.ew.FetchEnvoyReady(ctx)
+	assert.Equal(t, "ready check failed its health evaluation", m.ew.CheckDetails().LastError)
+
+	m.ew.SetHealthEvaluator(nil)
+	m.ew.FetchEnvoyReady(ctx)
+	m.checkReady(3, true, true)
+}
@@ -0,0 +1,213 @@
+package acp
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+)
+
+// DefaultEnvoyPollInterval is how often Run checks whether Envoy is ready, unless
+// SetPollIntervals, or AMBASSADOR_ENVOY_POLL_SECONDS at instantiation, says otherwise.
+const DefaultEnvoyPollInterval = 2 * time.Second
+
+// EnvoyHealth is what a check made of Envoy: whether it was alive and ready, and what
+// state its server was in, as of Time.
+type EnvoyHealth struct {
+	Alive bool             `json:"alive"`
+	Ready bool             `json:"ready"`
+	State EnvoyServerState `json:"state,omitempty"`
+	Time  time.Time        `json:"time"`
+}
+
+// Describe says what the health is, in a few words.
+func (h EnvoyHealth) Describe() string {
+	desc := "not alive"
+	switch {
+	case h.Ready:
+		desc = "alive and ready"
+	case h.Alive:
+		desc = "alive, but not ready"
+	}
+	if h.State != EnvoyStateUnknown {
+		desc += " (" + string(h.State) + ")"
+	}
+	return desc
+}
+
+// sameAs returns whether h and other say the same thing, whenever they were checked.
+func (h EnvoyHealth) sameAs(other EnvoyHealth) bool {
+	return h.Alive == other.Alive && h.Ready == other.Ready && h.State == other.State
+}
+
+// envoySubscription is one subscriber's channel. It holds only the latest health.
+type envoySubscription struct {
+	ch     chan EnvoyHealth
+	closed bool // protected by the EnvoyWatcher's mutex
+}
+
+// deliver puts h in the channel, dropping whatever's there if the subscriber hasn't got to
+// it yet. The EnvoyWatcher's mutex is held, so there's only ever one deliver at a time; the
+// subscriber can only ever make more room.
+func (s *envoySubscription) deliver(h EnvoyHealth) {
+	for {
+		select {
+		case s.ch <- h:
+			return
+		default:
+		}
+		select {
+		case <-s.ch:
+		default:
+		}
+	}
+}
+
+// SetPollIntervals will change how often Run checks whether Envoy is ready, and how often
+// it fetches Envoy's stats. A ready interval that isn't positive means
+// DefaultEnvoyPollInterval; a stats interval that isn't positive means Run doesn't fetch
+// stats at all. Call it at instantiation, too.
+func (w *EnvoyWatcher) SetPollIntervals(ready, stats time.Duration) {
+	if ready <= 0 {
+		ready = DefaultEnvoyPollInterval
+	}
+	if stats < 0 {
+		stats = 0
+	}
+	w.pollInterval = ready
+	w.statsPollInterval = stats
+}
+
+// Run checks on Envoy in the background, until ctx is done: it checks whether Envoy is
+// ready right away, then every poll interval, and fetches Envoy's stats every stats poll
+// interval, if there is one. Its timers come from the context's clock. It always returns
+// nil, once ctx is done.
+func (w *EnvoyWatcher) Run(ctx context.Context) error {
+	w.mutex.Lock()
+	w.polling++
+	readyInterval, statsInterval := w.pollInterval, w.statsPollInterval
+	w.mutex.Unlock()
+	defer func() {
+		w.mutex.Lock()
+		w.polling--
+		w.mutex.Unlock()
+	}()
+
+	clk := clock.FromContext(ctx)
+	readyTicker := clk.NewTicker(readyInterval)
+	defer readyTicker.Stop()
+
+	var statsTick <-chan time.Time
+	if statsInterval > 0 {
+		statsTicker := clk.NewTicker(statsInterval)
+		defer statsTicker.Stop()
+		statsTick = statsTicker.C()
+	}
+
+	w.FetchEnvoyReady(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-readyTicker.C():
+			w.FetchEnvoyReady(ctx)
+		case <-statsTick:
+			if err := w.FetchEnvoyStats(ctx); err != nil {
+				dlog.Debugf(ctx, "could not poll Envoy stats: %v", err)
+			}
+		}
+	}
+}
+
+// Polling returns true IFF Run is checking on Envoy in the background.
+func (w *EnvoyWatcher) Polling() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.polling > 0
+}
+
+// Health returns what the last check made of Envoy. Its Time is zero if there hasn't
+// been one.
+func (w *EnvoyWatcher) Health() EnvoyHealth {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.health
+}
+
+// Subscribe returns a channel that gets Envoy's health whenever a check changes it: whether
+// Envoy is alive, whether it's ready, or what state its server is in. It starts with the
+// health as of the last check, if there's been one. The channel only holds the latest
+// health, so a subscriber that's slow misses the ones in between, rather than holding up
+// the checks. Calling the function that comes with it closes the channel.
+func (w *EnvoyWatcher) Subscribe() (<-chan EnvoyHealth, func()) {
+	s := &envoySubscription{ch: make(chan EnvoyHealth, 1)}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.subscriptions = append(w.subscriptions, s)
+	if !w.health.Time.IsZero() {
+		s.deliver(w.health)
+	}
+
+	return s.ch, func() {
+		w.mutex.Lock()
+		defer w.mutex.Unlock()
+		if s.closed {
+			return
+		}
+		s.closed = true
+		close(s.ch)
+		for i, other := range w.subscriptions {
+			if other == s {
+				w.subscriptions = append(w.subscriptions[:i], w.subscriptions[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// OnHealthChange calls fn, in a goroutine of its own, with every health that Subscribe
+// would send, until the function that it returns is called.
+func (w *EnvoyWatcher) OnHealthChange(fn func(EnvoyHealth)) func() {
+	ch, cancel := w.Subscribe()
+	go func() {
+		for h := range ch {
+			fn(h)
+		}
+	}()
+	return cancel
+}
+
+// noteHealth notes what a check that finished at now made of Envoy, and tells the
+// subscribers if that's changed. The caller must hold the mutex.
+func (w *EnvoyWatcher) noteHealth(now time.Time) {
+	h := EnvoyHealth{Alive: w.alive, Ready: w.ready(), State: w.serverState, Time: now}
+	changed := w.health.Time.IsZero() || !h.sameAs(w.health)
+	w.health = h
+	if !changed {
+		return
+	}
+	for _, s := range w.subscriptions {
+		s.deliver(h)
+	}
+}
+
+// getDefaultPollInterval returns the number of seconds in the given environment variable,
+// or 0 if it's not set, or isn't a number of seconds.
+func getDefaultPollInterval(name string) time.Duration {
+	str := os.Getenv(name)
+	if str == "" {
+		return 0
+	}
+	secs, err := strconv.ParseFloat(str, 64)
+	if err != nil || secs < 0 {
+		dlog.Infof(context.Background(), "Unable to parse %s, or it's negative: %q", name, str)
+		return 0
+	}
+	return time.Duration(secs * float64(time.Second))
+}
@@ -0,0 +1,126 @@
+package acp_test
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/acp"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+)
+
+func TestEnvoyWatcherSubscribe(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	m := newEnvoyMetadata(t, Failure)
+
+	health, cancel := m.ew.Subscribe()
+	defer cancel()
+
+	// Nothing's been checked yet, so there's nothing to say.
+	select {
+	case h := <-health:
+		t.Fatalf("got %v before any check", h)
+	default:
+	}
+
+	m.ew.FetchEnvoyReady(ctx)
+	h := <-health
+	assert.False(t, h.Alive)
+	assert.False(t, h.Ready)
+	assert.Equal(t, "not alive", h.Describe())
+
+	// Another check that says the same thing doesn't say anything.
+	m.ew.FetchEnvoyReady(ctx)
+	select {
+	case h := <-health:
+		t.Fatalf("got %v without a change", h)
+	default:
+	}
+
+	// A subscriber that's slow only gets the latest.
+	m.f.setMode(Happy)
+	m.ew.FetchEnvoyReady(ctx)
+	m.ew.SetStateCheck((&fakeState{text: "DRAINING"}).stateCheck)
+	m.ew.FetchEnvoyReady(ctx)
+	h = <-health
+	assert.Equal(t, "alive, but not ready (DRAINING)", h.Describe())
+	assert.Equal(t, h, m.ew.Health())
+
+	// A new subscriber starts with the latest.
+	late, cancelLate := m.ew.Subscribe()
+	assert.Equal(t, h, <-late)
+
+	// Canceling closes the channel, once.
+	cancelLate()
+	cancelLate()
+	_, ok := <-late
+	assert.False(t, ok)
+	m.ew.SetStateCheck((&fakeState{text: "LIVE"}).stateCheck)
+	m.ew.FetchEnvoyReady(ctx)
+	assert.Equal(t, "alive and ready (LIVE)", (<-health).Describe())
+}
+
+func TestEnvoyWatcherRun(t *testing.T) {
+	fake := clock.NewFake(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	ctx, cancel := context.WithCancel(clock.WithClock(dlog.NewTestContext(t, false), fake))
+	defer cancel()
+
+	var ready atomic.Bool
+	var readyChecks, statsChecks atomic.Int32
+	ew := acp.NewEnvoyWatcher()
+	ew.SetReadyCheck(func(context.Context) (*acp.EnvoyFetcherResponse, error) {
+		readyChecks.Add(1)
+		if ready.Load() {
+			return &acp.EnvoyFetcherResponse{StatusCode: http.StatusOK}, nil
+		}
+		return &acp.EnvoyFetcherResponse{StatusCode: http.StatusServiceUnavailable}, nil
+	})
+	ew.SetStateCheck((&fakeState{}).stateCheck)
+	ew.SetStatsCheck(func(context.Context, string) (*acp.EnvoyFetcherResponse, error) {
+		statsChecks.Add(1)
+		return &acp.EnvoyFetcherResponse{StatusCode: http.StatusOK}, nil
+	})
+	ew.SetPollIntervals(time.Second, 10*time.Second)
+	aw := acp.NewAmbassadorWatcher(ew, acp.NewDiagdWatcher())
+
+	var changes atomic.Int32
+	stop := ew.OnHealthChange(func(acp.EnvoyHealth) { changes.Add(1) })
+	defer stop()
+
+	done := make(chan error, 1)
+	go func() { done <- ew.Run(ctx) }()
+
+	// Run checks right away...
+	require.Eventually(t, func() bool { return ew.Polling() && readyChecks.Load() == 1 }, time.Second, time.Millisecond)
+	require.Eventually(t, func() bool { return fake.Timers() == 2 }, time.Second, time.Millisecond)
+
+	// ...and leaves the checking to itself, so the probes don't fetch anything.
+	aw.FetchEnvoyReady(ctx)
+	assert.Equal(t, int32(1), readyChecks.Load())
+
+	// Then it checks every second, and fetches stats every ten.
+	ready.Store(true)
+	for i := 0; i < 10; i++ {
+		fake.Advance(time.Second)
+		want := int32(i + 2)
+		require.Eventually(t, func() bool { return readyChecks.Load() == want }, time.Second, time.Millisecond)
+	}
+	require.Eventually(t, func() bool { return statsChecks.Load() == 3 }, time.Second, time.Millisecond)
+	assert.True(t, ew.IsReady())
+	require.Eventually(t, func() bool { return changes.Load() == 2 }, time.Second, time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+	assert.False(t, ew.Polling())
+	assert.Equal(t, 0, fake.Timers())
+
+	// Once it's stopped, the probes fetch again.
+	aw.FetchEnvoyReady(ctx)
+	assert.Equal(t, int32(12), readyChecks.Load())
+}
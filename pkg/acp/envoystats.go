@@ -0,0 +1,150 @@
+package acp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// The stat types that Envoy's /stats can be asked for, one at a time, with ?type=. Its text
+// doesn't otherwise say which numbers are counters and which are gauges.
+const (
+	envoyCounters   = "Counters"
+	envoyGauges     = "Gauges"
+	envoyHistograms = "Histograms"
+)
+
+// EnvoyStats are Envoy's stats, by name, as its admin interface's /stats reports them.
+type EnvoyStats struct {
+	Counters   map[string]uint64         `json:"counters"`
+	Gauges     map[string]uint64         `json:"gauges"`
+	Histograms map[string]EnvoyHistogram `json:"histograms"`
+}
+
+// EnvoyHistogram is the summary that Envoy's /stats gives of a histogram: the value at each of
+// its percentiles, keyed like Envoy writes them ("P50", "P99.9"), over the latest stats flush
+// interval and over Envoy's whole lifetime. Envoy leaves out percentiles that it has no values
+// for, so a histogram that hasn't recorded anything is empty.
+type EnvoyHistogram struct {
+	Interval   map[string]float64 `json:"interval,omitempty"`
+	Cumulative map[string]float64 `json:"cumulative,omitempty"`
+}
+
+// ParseEnvoyStats parses the text of /stats?type=Counters, /stats?type=Gauges, and
+// /stats?type=Histograms: one "name: value" per line, where a histogram's value is a list of
+// percentiles like "P0(nan,0) P25(nan,1.05) ...", or "No recorded values".
+func ParseEnvoyStats(counters, gauges, histograms []byte) (*EnvoyStats, error) {
+	stats := &EnvoyStats{
+		Counters:   map[string]uint64{},
+		Gauges:     map[string]uint64{},
+		Histograms: map[string]EnvoyHistogram{},
+	}
+
+	for _, numbers := range []struct {
+		statType string
+		text     []byte
+		into     map[string]uint64
+	}{
+		{envoyCounters, counters, stats.Counters},
+		{envoyGauges, gauges, stats.Gauges},
+	} {
+		err := parseStatLines(numbers.text, func(name, value string) error {
+			n, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return err
+			}
+			numbers.into[name] = n
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", numbers.statType, err)
+		}
+	}
+
+	err := parseStatLines(histograms, func(name, value string) error {
+		h, err := parseHistogram(value)
+		if err != nil {
+			return err
+		}
+		stats.Histograms[name] = h
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", envoyHistograms, err)
+	}
+
+	return stats, nil
+}
+
+// parseStatLines calls fn with the name and value of each stat in text. It skips text readouts,
+// whose values are quoted strings, since Envoy includes them whatever type it's asked for.
+func parseStatLines(text []byte, fn func(name, value string) error) error {
+	scanner := bufio.NewScanner(bytes.NewReader(text))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(line, ": ")
+		if !ok {
+			// A stat without a value at all is "name:", with no space.
+			if strings.HasSuffix(line, ":") {
+				continue
+			}
+			return fmt.Errorf("line %d: no value: %q", lineno, line)
+		}
+		if strings.HasPrefix(value, `"`) {
+			continue
+		}
+		if err := fn(name, value); err != nil {
+			return fmt.Errorf("line %d: %s: %w", lineno, name, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// parseHistogram parses one histogram's percentiles.
+func parseHistogram(value string) (EnvoyHistogram, error) {
+	h := EnvoyHistogram{}
+	if value == "No recorded values" {
+		return h, nil
+	}
+
+	for _, field := range strings.Fields(value) {
+		// Each field is "P<percentile>(<interval>,<cumulative>)".
+		open := strings.IndexByte(field, '(')
+		if !strings.HasPrefix(field, "P") || open < 0 || !strings.HasSuffix(field, ")") {
+			return h, fmt.Errorf("bad percentile %q", field)
+		}
+		percentile := field[:open]
+		interval, cumulative, ok := strings.Cut(field[open+1:len(field)-1], ",")
+		if !ok {
+			return h, fmt.Errorf("bad percentile %q", field)
+		}
+
+		for _, v := range []struct {
+			text string
+			into *map[string]float64
+		}{
+			{interval, &h.Interval},
+			{cumulative, &h.Cumulative},
+		} {
+			f, err := strconv.ParseFloat(v.text, 64)
+			if err != nil {
+				return h, fmt.Errorf("bad percentile %q: %w", field, err)
+			}
+			if math.IsNaN(f) {
+				continue
+			}
+			if *v.into == nil {
+				*v.into = map[string]float64{}
+			}
+			(*v.into)[percentile] = f
+		}
+	}
+	return h, nil
+}
@@ -0,0 +1,104 @@
+package acp_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/acp"
+)
+
+const (
+	counterText = `cluster.cluster_quote.upstream_rq_total: 12
+http.ingress_http.downstream_rq_total: 40
+server.version_text: "1.24.2"
+`
+	gaugeText = `cluster_manager.active_clusters: 5
+server.state: 0
+server.total_connections: 3
+`
+	histogramText = `cluster.cluster_quote.upstream_rq_time: P0(nan,1) P25(nan,1.025) P50(nan,2.05) P99.9(nan,9.99) P100(nan,10)
+http.ingress_http.downstream_rq_time: No recorded values
+`
+)
+
+func TestParseEnvoyStats(t *testing.T) {
+	stats, err := acp.ParseEnvoyStats([]byte(counterText), []byte(gaugeText), []byte(histogramText))
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]uint64{
+		"cluster.cluster_quote.upstream_rq_total": 12,
+		"http.ingress_http.downstream_rq_total":   40,
+	}, stats.Counters)
+	assert.Equal(t, map[string]uint64{
+		"cluster_manager.active_clusters": 5,
+		"server.state":                    0,
+		"server.total_connections":        3,
+	}, stats.Gauges)
+	assert.Equal(t, map[string]acp.EnvoyHistogram{
+		"cluster.cluster_quote.upstream_rq_time": {
+			Cumulative: map[string]float64{"P0": 1, "P25": 1.025, "P50": 2.05, "P99.9": 9.99, "P100": 10},
+		},
+		"http.ingress_http.downstream_rq_time": {},
+	}, stats.Histograms)
+}
+
+func TestParseEnvoyStatsErrors(t *testing.T) {
+	_, err := acp.ParseEnvoyStats([]byte("a.b: lots\n"), nil, nil)
+	assert.ErrorContains(t, err, "Counters: line 1: a.b")
+
+	_, err = acp.ParseEnvoyStats(nil, []byte("a.b\n"), nil)
+	assert.ErrorContains(t, err, "Gauges: line 1: no value")
+
+	_, err = acp.ParseEnvoyStats(nil, nil, []byte("a.b: P50(1)\n"))
+	assert.ErrorContains(t, err, `Histograms: line 1: a.b: bad percentile "P50(1)"`)
+}
+
+func TestEnvoyStats(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	texts := map[string]string{"Counters": counterText, "Gauges": gaugeText, "Histograms": histogramText}
+	fail := false
+
+	ew := acp.NewEnvoyWatcher()
+	ew.SetStatsCheck(func(_ context.Context, statType string) (*acp.EnvoyFetcherResponse, error) {
+		if fail {
+			return nil, fmt.Errorf("connection refused")
+		}
+		return &acp.EnvoyFetcherResponse{StatusCode: 200, Text: []byte(texts[statType])}, nil
+	})
+	assert.Nil(t, ew.GetStats())
+
+	require.NoError(t, ew.FetchEnvoyStats(ctx))
+	stats := ew.GetStats()
+	require.NotNil(t, stats)
+	assert.Equal(t, uint64(3), stats.Gauges["server.total_connections"])
+
+	// A fetch that doesn't work leaves the last stats alone.
+	fail = true
+	assert.Error(t, ew.FetchEnvoyStats(ctx))
+	assert.Same(t, stats, ew.GetStats())
+}
+
+func TestEnvoyStatsFromAdmin(t *testing.T) {
+	texts := map[string]string{"Counters": counterText, "Gauges": gaugeText, "Histograms": histogramText}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		text, ok := texts[r.URL.Query().Get("type")]
+		if r.URL.Path != "/stats" || !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, text)
+	}))
+	defer srv.Close()
+
+	ew := acp.NewEnvoyWatcher()
+	ew.SetAdminURL(srv.URL + "/")
+	require.NoError(t, ew.FetchEnvoyStats(dlog.NewTestContext(t, false)))
+	assert.Len(t, ew.GetStats().Histograms, 2)
+}
@@ -0,0 +1,160 @@
+package acp
+
+import (
+	"sync"
+	"time"
+)
+
+// Metrics: the watchers count what they do, so that it's possible to see why a probe
+// flaps without turning on debug logging. Each one's Metrics returns a WatcherMetrics:
+// whether it says Ambassador (or Envoy, or diagd) is alive and ready right now, and,
+// for each kind of fetch it does, how many it has tried, how many failed, and how long
+// they took. It's up to the caller to export them; see cmd/entrypoint/watchermetrics.go.
+//
+// The EnvoyWatcher's fetches are its checks of Envoy (ready, state, config, drain),
+// and the fetches of stats, cluster health, and config dumps that its callers ask for.
+// A fetch fails if it gets no answer, or an answer that says nothing: anything but a
+// 200 for most of them, an answer the HealthEvaluator says isn't ready for the ready
+// check, and no server state for the state check. The DiagdWatcher's one "fetch" is
+// diagd processing a snapshot, from when it's sent to when it's processed; one that's
+// never processed doesn't finish, so it doesn't fail either, but diagd stops being
+// alive.
+
+// FetchLatencyBuckets are the upper bounds of the buckets that fetch latencies are
+// counted in.
+var FetchLatencyBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// The names of the fetches that the watchers count.
+const (
+	FetchReady         = "ready"
+	FetchState         = "state"
+	FetchConfig        = "config"
+	FetchDrain         = "drain"
+	FetchStats         = "stats"
+	FetchClusterHealth = "cluster_health"
+	FetchConfigDump    = "config_dump"
+	FetchSnapshot      = "snapshot"
+)
+
+// FetchMetrics counts one kind of fetch.
+type FetchMetrics struct {
+	Attempts uint64
+	Failures uint64
+
+	// LatencyBuckets counts the fetches that took at most each of FetchLatencyBuckets,
+	// cumulatively, the way Prometheus histograms do; Attempts counts them all.
+	// LatencySum is how long they took altogether.
+	LatencyBuckets []uint64
+	LatencySum     time.Duration
+}
+
+// WatcherMetrics is what a watcher says about itself.
+type WatcherMetrics struct {
+	// Watcher is "ambassador", "envoy", or "diagd".
+	Watcher string
+	Alive   bool
+	Ready   bool
+	// Fetches are the watcher's fetches, by name. The AmbassadorWatcher doesn't fetch
+	// anything itself.
+	Fetches map[string]FetchMetrics
+}
+
+// fetchMetrics counts a watcher's fetches. The zero value is ready to use.
+type fetchMetrics struct {
+	mutex   sync.Mutex
+	fetches map[string]*FetchMetrics
+}
+
+// record counts a fetch.
+func (m *fetchMetrics) record(name string, latency time.Duration, failed bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.fetches == nil {
+		m.fetches = map[string]*FetchMetrics{}
+	}
+	f := m.fetches[name]
+	if f == nil {
+		f = &FetchMetrics{LatencyBuckets: make([]uint64, len(FetchLatencyBuckets))}
+		m.fetches[name] = f
+	}
+
+	f.Attempts++
+	if failed {
+		f.Failures++
+	}
+	for i, bound := range FetchLatencyBuckets {
+		if latency <= bound {
+			f.LatencyBuckets[i]++
+		}
+	}
+	f.LatencySum += latency
+}
+
+// snapshot returns a copy of the counts so far.
+func (m *fetchMetrics) snapshot() map[string]FetchMetrics {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	fetches := make(map[string]FetchMetrics, len(m.fetches))
+	for name, f := range m.fetches {
+		c := *f
+		c.LatencyBuckets = append([]uint64(nil), f.LatencyBuckets...)
+		fetches[name] = c
+	}
+	return fetches
+}
+
+// Metrics returns what the EnvoyWatcher says about itself.
+func (w *EnvoyWatcher) Metrics() WatcherMetrics {
+	return WatcherMetrics{
+		Watcher: "envoy",
+		Alive:   w.IsAlive(),
+		Ready:   w.IsReady(),
+		Fetches: w.metrics.snapshot(),
+	}
+}
+
+// Metrics returns what the DiagdWatcher says about itself.
+func (w *DiagdWatcher) Metrics() WatcherMetrics {
+	return WatcherMetrics{
+		Watcher: "diagd",
+		Alive:   w.IsAlive(),
+		Ready:   w.IsReady(),
+		Fetches: w.metrics.snapshot(),
+	}
+}
+
+// Metrics returns what the AmbassadorWatcher, and the EnvoyWatcher and DiagdWatcher
+// in it, say about themselves. Unlike IsAlive, it doesn't note that Envoy has started,
+// so scraping the metrics doesn't change what the probes see.
+func (w *AmbassadorWatcher) Metrics() []WatcherMetrics {
+	w.mutex.Lock()
+	alive, _ := w.alive()
+	ready := w.dw.IsReady() && w.ew.IsReady() && w.asw.IsReady()
+	w.mutex.Unlock()
+
+	return []WatcherMetrics{
+		{
+			Watcher: "ambassador",
+			Alive:   alive,
+			Ready:   ready,
+			Fetches: map[string]FetchMetrics{},
+		},
+		w.ew.Metrics(),
+		w.dw.Metrics(),
+	}
+}
@@ -0,0 +1,147 @@
+package acp_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/acp"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+)
+
+func TestEnvoyWatcherMetrics(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	fake := clock.NewFake(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	m := newEnvoyMetadata(t, Happy)
+	m.ew.SetClock(fake)
+	m.ew.SetReadyCheck(func(ctx context.Context) (*acp.EnvoyFetcherResponse, error) {
+		fake.Advance(30 * time.Millisecond)
+		return m.f.readyCheck(ctx)
+	})
+
+	m.ew.FetchEnvoyReady(ctx)
+	m.f.setMode(Failure)
+	m.ew.FetchEnvoyReady(ctx)
+	m.f.setMode(Error)
+	m.ew.FetchEnvoyReady(ctx)
+
+	metrics := m.ew.Metrics()
+	assert.Equal(t, "envoy", metrics.Watcher)
+	assert.False(t, metrics.Alive)
+	assert.False(t, metrics.Ready)
+
+	ready := metrics.Fetches[acp.FetchReady]
+	assert.Equal(t, uint64(3), ready.Attempts)
+	assert.Equal(t, uint64(2), ready.Failures)
+	assert.Equal(t, 90*time.Millisecond, ready.LatencySum)
+	// 30ms is over the 25ms bucket, and under the 50ms one.
+	assert.Equal(t, []uint64{0, 0, 0, 0, 3, 3, 3, 3, 3, 3, 3, 3}, ready.LatencyBuckets)
+
+	// The fake state check never says anything, and the config and drain checks are
+	// only asked when it does.
+	assert.Equal(t, acp.FetchMetrics{
+		Attempts: 3, Failures: 3, LatencyBuckets: []uint64{3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3},
+	}, metrics.Fetches[acp.FetchState])
+	assert.NotContains(t, metrics.Fetches, acp.FetchConfig)
+
+	// Stats fetches fail on anything but a 200.
+	m.ew.SetStatsCheck(func(ctx context.Context, statType string) (*acp.EnvoyFetcherResponse, error) {
+		return &acp.EnvoyFetcherResponse{StatusCode: http.StatusServiceUnavailable}, nil
+	})
+	assert.Error(t, m.ew.FetchEnvoyStats(ctx))
+	stats := m.ew.Metrics().Fetches[acp.FetchStats]
+	assert.Equal(t, uint64(1), stats.Attempts)
+	assert.Equal(t, uint64(1), stats.Failures)
+
+	// What Metrics returns is a copy.
+	ready.LatencyBuckets[0] = 100
+	assert.Equal(t, uint64(0), m.ew.Metrics().Fetches[acp.FetchReady].LatencyBuckets[0])
+}
+
+func TestAmbassadorWatcherMetrics(t *testing.T) {
+	fake := clock.NewFake(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	ew := acp.NewEnvoyWatcher()
+	dw := acp.NewDiagdWatcher()
+	dw.SetClock(fake)
+	aw := acp.NewAmbassadorWatcher(ew, dw)
+	aw.SetClock(fake)
+
+	for i := 0; i < 2; i++ {
+		aw.NoteSnapshotSent()
+		fake.Advance(2 * time.Second)
+		aw.NoteSnapshotProcessed()
+		// Hearing about it again doesn't count again.
+		aw.NoteSnapshotProcessed()
+		fake.Advance(time.Second)
+	}
+
+	metrics := aw.Metrics()
+	require.Len(t, metrics, 3)
+
+	var watchers []string
+	for _, m := range metrics {
+		watchers = append(watchers, fmt.Sprintf("%s alive=%t ready=%t", m.Watcher, m.Alive, m.Ready))
+	}
+	// Envoy hasn't been checked, but it's still within its grace period.
+	assert.Equal(t, []string{
+		"ambassador alive=true ready=false",
+		"envoy alive=false ready=false",
+		"diagd alive=true ready=true",
+	}, watchers)
+
+	snapshot := metrics[2].Fetches[acp.FetchSnapshot]
+	assert.Equal(t, uint64(2), snapshot.Attempts)
+	assert.Equal(t, uint64(0), snapshot.Failures)
+	assert.Equal(t, 4*time.Second, snapshot.LatencySum)
+	assert.Equal(t, uint64(0), snapshot.LatencyBuckets[8])
+	assert.Equal(t, uint64(2), snapshot.LatencyBuckets[9])
+}
+
+func TestAmbassadorWatcherMetricsLeaveStateAlone(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	fake := clock.NewFake(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+
+	envoyUp := true
+	ew := acp.NewEnvoyWatcher()
+	ew.SetReadyCheck(func(context.Context) (*acp.EnvoyFetcherResponse, error) {
+		if envoyUp {
+			return &acp.EnvoyFetcherResponse{StatusCode: http.StatusOK}, nil
+		}
+		return nil, fmt.Errorf("connection refused")
+	})
+	ew.SetStateCheck(func(context.Context) (*acp.EnvoyFetcherResponse, error) {
+		return nil, fmt.Errorf("connection refused")
+	})
+	dw := acp.NewDiagdWatcher()
+	dw.SetClock(fake)
+	aw := acp.NewAmbassadorWatcher(ew, dw)
+	aw.SetClock(fake)
+
+	// Envoy is starting, and comes up; the metrics say so...
+	aw.NoteSnapshotSent()
+	aw.NoteSnapshotProcessed()
+	ew.FetchEnvoyReady(ctx)
+	assert.True(t, aw.Metrics()[0].Alive)
+
+	// ...but only IsAlive notes that it's running. Since it hasn't, Envoy going away
+	// again is still within the grace period.
+	envoyUp = false
+	ew.FetchEnvoyReady(ctx)
+	assert.True(t, aw.Metrics()[0].Alive)
+	assert.True(t, aw.IsAlive())
+
+	// Once IsAlive has seen Envoy running, Envoy going away matters.
+	envoyUp = true
+	ew.FetchEnvoyReady(ctx)
+	assert.True(t, aw.IsAlive())
+	envoyUp = false
+	ew.FetchEnvoyReady(ctx)
+	assert.False(t, aw.Metrics()[0].Alive)
+	assert.False(t, aw.IsAlive())
+}
@@ -2,6 +2,7 @@ package acp
 
 import (
 	"context"
+	"strings"
 	"time"
 )
 
@@ -15,6 +16,29 @@ type EnvoyFetcherResponse struct {
 	Text       []byte
 }
 
+// EnvoyServerState is the state of Envoy's server, as its admin interface reports it
+// (and as its server.state gauge counts it).
+type EnvoyServerState string
+
+const (
+	EnvoyStateUnknown    = EnvoyServerState("")
+	EnvoyLive            = EnvoyServerState("LIVE")
+	EnvoyDraining        = EnvoyServerState("DRAINING")
+	EnvoyPreInitializing = EnvoyServerState("PRE_INITIALIZING")
+	EnvoyInitializing    = EnvoyServerState("INITIALIZING")
+)
+
+// ParseEnvoyServerState returns the state in the text of the admin interface's /ready,
+// or EnvoyStateUnknown if the text isn't a state.
+func ParseEnvoyServerState(text []byte) EnvoyServerState {
+	switch state := EnvoyServerState(strings.ToUpper(strings.TrimSpace(string(text)))); state {
+	case EnvoyLive, EnvoyDraining, EnvoyPreInitializing, EnvoyInitializing:
+		return state
+	default:
+		return EnvoyStateUnknown
+	}
+}
+
 // timeFetcher is a function that returns the current time. We use time.Now
 // unless overridden for testing.
 type timeFetcher func() time.Time
@@ -22,3 +46,7 @@ type timeFetcher func() time.Time
 // envoyFetcher is a function that returns Envoy's stats. We supply a default
 // envoyFetcher, but it can be overridden (usually for testing).
 type envoyFetcher func(ctx context.Context) (*EnvoyFetcherResponse, error)
+
+// envoyStatsFetcher is a function that returns one type of Envoy's stats, as the
+// text of its /stats?type=statType. Like envoyFetcher, it can be overridden.
+type envoyStatsFetcher func(ctx context.Context, statType string) (*EnvoyFetcherResponse, error)
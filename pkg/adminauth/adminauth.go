@@ -0,0 +1,220 @@
+// Package adminauth decides who may use Ambassador's admin endpoints (freezing configuration,
+// capturing traffic, dumping snapshots, the debug endpoints) with the cluster's own RBAC, rather
+// than with credentials of its own. A request carries a bearer token, usually a ServiceAccount's.
+// A TokenReview says whose token it is, and a SubjectAccessReview, for the request's path and verb
+// as a non-resource URL, says whether they may. So a ClusterRole like
+//
+//	rules:
+//	- nonResourceURLs: ["/ambassador/v0/freeze"]
+//	  verbs: ["get", "post", "delete"]
+//	- nonResourceURLs: ["/debug/*"]
+//	  verbs: ["get"]
+//
+// bound to a ServiceAccount lets whatever runs as that ServiceAccount freeze and thaw
+// configuration, and look at the debug endpoints.
+//
+// # Caching
+//
+// Every review is a request to the API server, and a script polling an endpoint shouldn't turn
+// into a stream of those, so what the reviews decide is kept for a while (a minute, by default):
+// revoking someone's access takes up to that long to take effect. Reviews that fail (because the
+// API server can't be reached, say) aren't kept, and the request that needed one is refused.
+package adminauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	authnv1 "k8s.io/api/authentication/v1"
+	authzv1 "k8s.io/api/authorization/v1"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+)
+
+// A Reviewer asks the API server about tokens and access.
+type Reviewer interface {
+	// ReviewToken returns who token belongs to, or false if it isn't a valid token.
+	ReviewToken(ctx context.Context, token string) (authnv1.UserInfo, bool, error)
+	// ReviewAccess returns whether user may do what attrs describe, and if not, why not (which
+	// may be empty).
+	ReviewAccess(ctx context.Context, user authnv1.UserInfo, attrs authzv1.NonResourceAttributes) (bool, string, error)
+}
+
+// DefaultCacheTTL is how long an Authorizer keeps decisions for by default.
+const DefaultCacheTTL = time.Minute
+
+// maxCacheEntries is how many decisions an Authorizer keeps before it starts throwing out the ones
+// that have expired (and, if that isn't enough, all of them).
+const maxCacheEntries = 1024
+
+// Errors that Authorize returns.
+var (
+	// ErrNoToken is for a request without a bearer token.
+	ErrNoToken = errors.New("no bearer token")
+	// ErrInvalidToken is for a token that the TokenReview rejected.
+	ErrInvalidToken = errors.New("invalid bearer token")
+	// ErrForbidden is for a user that the SubjectAccessReview didn't allow.
+	ErrForbidden = errors.New("forbidden")
+)
+
+// An Authorizer checks requests with a Reviewer.
+type Authorizer struct {
+	reviewer Reviewer
+	clock    clock.Clock
+	ttl      time.Duration
+
+	mu    sync.Mutex
+	cache map[cacheKey]decision
+}
+
+type cacheKey struct {
+	token [sha256.Size]byte
+	verb  string
+	path  string
+}
+
+type decision struct {
+	user    string
+	err     error
+	expires time.Time
+}
+
+// NewAuthorizer returns an Authorizer that asks reviewer, and keeps what it decides for ttl
+// (DefaultCacheTTL if ttl isn't positive).
+func NewAuthorizer(reviewer Reviewer, clk clock.Clock, ttl time.Duration) *Authorizer {
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	return &Authorizer{
+		reviewer: reviewer,
+		clock:    clk,
+		ttl:      ttl,
+		cache:    map[cacheKey]decision{},
+	}
+}
+
+// Verb returns the RBAC verb for an HTTP method, the way the API server works it out for
+// non-resource URLs.
+func Verb(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		return "get"
+	default:
+		return strings.ToLower(method)
+	}
+}
+
+// Authorize returns who made r, if they may. Otherwise it returns ErrNoToken, ErrInvalidToken,
+// ErrForbidden (wrapped, with the reason, if there is one), or the error from the Reviewer.
+func (a *Authorizer) Authorize(r *http.Request) (string, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	token = strings.TrimSpace(token)
+	if !ok || token == "" {
+		return "", ErrNoToken
+	}
+
+	key := cacheKey{token: sha256.Sum256([]byte(token)), verb: Verb(r.Method), path: r.URL.Path}
+	now := a.clock.Now()
+
+	a.mu.Lock()
+	d, ok := a.cache[key]
+	a.mu.Unlock()
+	if ok && now.Before(d.expires) {
+		return d.user, d.err
+	}
+
+	user, err := a.review(r.Context(), token, key)
+	switch {
+	case err == nil, errors.Is(err, ErrInvalidToken), errors.Is(err, ErrForbidden):
+		a.remember(key, decision{user: user, err: err, expires: now.Add(a.ttl)}, now)
+	}
+	return user, err
+}
+
+func (a *Authorizer) review(ctx context.Context, token string, key cacheKey) (string, error) {
+	user, authenticated, err := a.reviewer.ReviewToken(ctx, token)
+	if err != nil {
+		return "", err
+	}
+	if !authenticated {
+		return "", ErrInvalidToken
+	}
+
+	allowed, reason, err := a.reviewer.ReviewAccess(ctx, user, authzv1.NonResourceAttributes{
+		Path: key.path,
+		Verb: key.verb,
+	})
+	if err != nil {
+		return user.Username, err
+	}
+	if !allowed {
+		if reason != "" {
+			return user.Username, &forbiddenError{reason: reason}
+		}
+		return user.Username, ErrForbidden
+	}
+	return user.Username, nil
+}
+
+type forbiddenError struct {
+	reason string
+}
+
+func (e *forbiddenError) Error() string { return ErrForbidden.Error() + ": " + e.reason }
+func (e *forbiddenError) Unwrap() error { return ErrForbidden }
+
+func (a *Authorizer) remember(key cacheKey, d decision, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.cache) >= maxCacheEntries {
+		for k, old := range a.cache {
+			if !now.Before(old.expires) {
+				delete(a.cache, k)
+			}
+		}
+		if len(a.cache) >= maxCacheEntries {
+			a.cache = map[cacheKey]decision{}
+		}
+	}
+	a.cache[key] = d
+}
+
+type userKey struct{}
+
+// User returns who made a request that a Wrap handler let through, or "" if it didn't go through
+// one.
+func User(ctx context.Context) string {
+	user, _ := ctx.Value(userKey{}).(string)
+	return user
+}
+
+// Wrap returns a handler that only passes requests on to h if Authorize lets them through. Others
+// get a 401 (for a missing or invalid token), a 403, or, if the reviews themselves failed, a 503.
+// A nil Authorizer lets everything through.
+func (a *Authorizer) Wrap(h http.Handler) http.Handler {
+	if a == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := a.Authorize(r)
+		switch {
+		case err == nil:
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+		case errors.Is(err, ErrNoToken), errors.Is(err, ErrInvalidToken):
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized: "+err.Error()+"\n", http.StatusUnauthorized)
+		case errors.Is(err, ErrForbidden):
+			dlog.Infof(r.Context(), "admin API: %s may not %s %s: %v", user, Verb(r.Method), r.URL.Path, err)
+			http.Error(w, user+" may not "+Verb(r.Method)+" "+r.URL.Path+"\n", http.StatusForbidden)
+		default:
+			dlog.Errorf(r.Context(), "admin API: reviewing %s %s: %v", Verb(r.Method), r.URL.Path, err)
+			http.Error(w, "unable to check authorization\n", http.StatusServiceUnavailable)
+		}
+	})
+}
@@ -0,0 +1,161 @@
+package adminauth_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	authnv1 "k8s.io/api/authentication/v1"
+	authzv1 "k8s.io/api/authorization/v1"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/adminauth"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+)
+
+// fakeReviewer knows a few tokens, and lets each user do a few things.
+type fakeReviewer struct {
+	users   map[string]string          // token -> username
+	allowed map[string]map[string]bool // username -> "verb path" -> allowed
+	err     error
+	reviews int
+}
+
+func (f *fakeReviewer) ReviewToken(_ context.Context, token string) (authnv1.UserInfo, bool, error) {
+	f.reviews++
+	if f.err != nil {
+		return authnv1.UserInfo{}, false, f.err
+	}
+	user, ok := f.users[token]
+	return authnv1.UserInfo{Username: user}, ok, nil
+}
+
+func (f *fakeReviewer) ReviewAccess(_ context.Context, user authnv1.UserInfo, attrs authzv1.NonResourceAttributes) (bool, string, error) {
+	if f.allowed[user.Username][attrs.Verb+" "+attrs.Path] {
+		return true, "", nil
+	}
+	return false, "no RBAC policy matched", nil
+}
+
+func newFakeReviewer() *fakeReviewer {
+	return &fakeReviewer{
+		users: map[string]string{
+			"oncall-token": "system:serviceaccount:ops:oncall",
+			"viewer-token": "system:serviceaccount:ops:viewer",
+		},
+		allowed: map[string]map[string]bool{
+			"system:serviceaccount:ops:oncall": {
+				"get /ambassador/v0/freeze":    true,
+				"post /ambassador/v0/freeze":   true,
+				"delete /ambassador/v0/freeze": true,
+			},
+			"system:serviceaccount:ops:viewer": {
+				"get /ambassador/v0/freeze": true,
+			},
+		},
+	}
+}
+
+func serve(t *testing.T, auth *adminauth.Authorizer, method, token string) (int, string) {
+	t.Helper()
+	r := httptest.NewRequest(method, "/ambassador/v0/freeze", nil).WithContext(dlog.NewTestContext(t, false))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	var user string
+	auth.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user = adminauth.User(r.Context())
+	})).ServeHTTP(w, r)
+	return w.Code, user
+}
+
+func TestWrap(t *testing.T) {
+	t.Parallel()
+	auth := adminauth.NewAuthorizer(newFakeReviewer(), clock.NewFake(time.Now()), 0)
+
+	code, user := serve(t, auth, http.MethodPost, "oncall-token")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "system:serviceaccount:ops:oncall", user)
+
+	code, user = serve(t, auth, http.MethodGet, "viewer-token")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "system:serviceaccount:ops:viewer", user)
+
+	code, _ = serve(t, auth, http.MethodDelete, "viewer-token")
+	assert.Equal(t, http.StatusForbidden, code)
+
+	code, _ = serve(t, auth, http.MethodGet, "stolen-token")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, _ = serve(t, auth, http.MethodGet, "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// A nil Authorizer lets everything through.
+	code, user = serve(t, nil, http.MethodPost, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, user)
+}
+
+func TestAuthorizeErrors(t *testing.T) {
+	t.Parallel()
+	auth := adminauth.NewAuthorizer(newFakeReviewer(), clock.NewFake(time.Now()), 0)
+
+	r := httptest.NewRequest(http.MethodDelete, "/ambassador/v0/freeze", nil)
+	r.Header.Set("Authorization", "Bearer viewer-token")
+	user, err := auth.Authorize(r)
+	assert.Equal(t, "system:serviceaccount:ops:viewer", user)
+	assert.True(t, errors.Is(err, adminauth.ErrForbidden))
+	assert.EqualError(t, err, "forbidden: no RBAC policy matched")
+
+	r.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+	_, err = auth.Authorize(r)
+	assert.Equal(t, adminauth.ErrNoToken, err)
+}
+
+func TestCache(t *testing.T) {
+	t.Parallel()
+	reviewer := newFakeReviewer()
+	clk := clock.NewFake(time.Now())
+	auth := adminauth.NewAuthorizer(reviewer, clk, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		code, _ := serve(t, auth, http.MethodPost, "oncall-token")
+		assert.Equal(t, http.StatusOK, code)
+		code, _ = serve(t, auth, http.MethodGet, "stolen-token")
+		assert.Equal(t, http.StatusUnauthorized, code)
+	}
+	assert.Equal(t, 2, reviewer.reviews)
+
+	// Each verb gets a review of its own.
+	serve(t, auth, http.MethodGet, "oncall-token")
+	assert.Equal(t, 3, reviewer.reviews)
+
+	// Once the decisions expire, revoked access is noticed.
+	delete(reviewer.users, "oncall-token")
+	clk.Advance(time.Minute)
+	code, _ := serve(t, auth, http.MethodPost, "oncall-token")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Equal(t, 4, reviewer.reviews)
+
+	// A review that fails isn't kept, and refuses the request.
+	reviewer.err = errors.New("connection refused")
+	code, _ = serve(t, auth, http.MethodPost, "viewer-token")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	reviewer.err = nil
+	code, _ = serve(t, auth, http.MethodGet, "viewer-token")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 6, reviewer.reviews)
+}
+
+func TestVerb(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, "get", adminauth.Verb(http.MethodGet))
+	assert.Equal(t, "get", adminauth.Verb(http.MethodHead))
+	assert.Equal(t, "post", adminauth.Verb(http.MethodPost))
+	assert.Equal(t, "delete", adminauth.Verb(http.MethodDelete))
+}
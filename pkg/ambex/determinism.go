@@ -0,0 +1,66 @@
+package ambex
+
+import (
+	// standard library
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+
+	// third-party libraries
+	"google.golang.org/protobuf/proto"
+
+	// envoy control plane
+	ecp_v3_cache "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/cache/v3"
+)
+
+// Determinism:
+//
+// The configuration we hand to Envoy is assembled from several places (files on disk written by
+// diagd, the fastpath snapshot from the gateway dispatcher, endpoint data from the watcher), and
+// some of those places are built by iterating over Go maps. If we just concatenate whatever we
+// get, two replicas looking at exactly the same inputs can produce snapshots that differ only in
+// ordering. The snapshot itself doesn't care: NewSnapshot indexes each type's resources by name,
+// so the order of the lists is gone by the time anything reads it. Order *within* a resource is
+// another matter, since the order of routes in a virtual host (say) is their precedence, so we
+// leave that alone. What's left is to hash the snapshot in name order, so that parity between
+// snapshots (or between replicas) is a simple string comparison.
+
+// ConfigHash returns a stable hash of all the resources in a snapshot. Two snapshots with the same
+// resources will have the same hash no matter what their version strings are, so this can be used
+// to check whether two replicas (or two generations of the same replica) are actually serving the
+// same configuration.
+func ConfigHash(snapshot *ecp_v3_cache.Snapshot) string {
+	if snapshot == nil {
+		return ""
+	}
+
+	h := sha256.New()
+	marshal := proto.MarshalOptions{Deterministic: true}
+
+	for typ, resources := range snapshot.Resources {
+		names := make([]string, 0, len(resources.Items))
+		for name := range resources.Items {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		// Include the type in the hash so that e.g. moving a name from one type to another
+		// changes the hash.
+		_, _ = h.Write([]byte{byte(typ), 0})
+
+		for _, name := range names {
+			_, _ = h.Write([]byte(name))
+			_, _ = h.Write([]byte{0})
+
+			bs, err := marshal.Marshal(resources.Items[name].Resource)
+			if err != nil {
+				// This should be impossible for anything that made it into a snapshot,
+				// but if it does happen fall back to just the name.
+				continue
+			}
+			_, _ = h.Write(bs)
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
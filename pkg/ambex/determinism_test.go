@@ -0,0 +1,94 @@
+package ambex
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v3cluster "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/cluster/v3"
+	v3endpoint "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/endpoint/v3"
+	v3listener "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/listener/v3"
+	v3route "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/route/v3"
+	ecp_cache_types "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/cache/types"
+	ecp_v3_cache "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/cache/v3"
+	ecp_v3_resource "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/resource/v3"
+)
+
+func makeTestSnapshot(t *testing.T, version string, names ...string) *ecp_v3_cache.Snapshot {
+	t.Helper()
+	clusters := []ecp_cache_types.Resource{}
+	for _, name := range names {
+		clusters = append(clusters, &v3cluster.Cluster{Name: name})
+	}
+	snap, err := ecp_v3_cache.NewSnapshot(version, map[ecp_v3_resource.Type][]ecp_cache_types.Resource{
+		ecp_v3_resource.ClusterType: clusters,
+	})
+	require.NoError(t, err)
+	return snap
+}
+
+// Tests that shuffling every resource list going into a snapshot leaves its hash alone, while
+// reordering the routes inside a virtual host (which changes their precedence) doesn't.
+func TestConfigHashShuffled(t *testing.T) {
+	routeConfig := func(name string, routes ...string) *v3route.RouteConfiguration {
+		vh := &v3route.VirtualHost{Name: "vh", Domains: []string{"*"}}
+		for _, route := range routes {
+			vh.Routes = append(vh.Routes, &v3route.Route{Name: route})
+		}
+		return &v3route.RouteConfiguration{Name: name, VirtualHosts: []*v3route.VirtualHost{vh}}
+	}
+	resources := func(routes ...ecp_cache_types.Resource) map[ecp_v3_resource.Type][]ecp_cache_types.Resource {
+		return map[ecp_v3_resource.Type][]ecp_cache_types.Resource{
+			ecp_v3_resource.ClusterType: {
+				&v3cluster.Cluster{Name: "alpha"},
+				&v3cluster.Cluster{Name: "bravo"},
+				&v3cluster.Cluster{Name: "charlie"},
+			},
+			ecp_v3_resource.EndpointType: {
+				&v3endpoint.ClusterLoadAssignment{ClusterName: "alpha"},
+				&v3endpoint.ClusterLoadAssignment{ClusterName: "bravo"},
+				&v3endpoint.ClusterLoadAssignment{ClusterName: "charlie"},
+			},
+			ecp_v3_resource.RouteType: routes,
+			ecp_v3_resource.ListenerType: {
+				&v3listener.Listener{Name: "ambassador-listener-8080"},
+				&v3listener.Listener{Name: "ambassador-listener-8443"},
+			},
+		}
+	}
+	hash := func(resources map[ecp_v3_resource.Type][]ecp_cache_types.Resource) string {
+		snap, err := ecp_v3_cache.NewSnapshot("v1", resources)
+		require.NoError(t, err)
+		return ConfigHash(snap)
+	}
+
+	want := hash(resources(routeConfig("rc-1", "a", "b"), routeConfig("rc-2", "c")))
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 10; i++ {
+		shuffled := resources(routeConfig("rc-1", "a", "b"), routeConfig("rc-2", "c"))
+		for _, list := range shuffled {
+			rng.Shuffle(len(list), func(i, j int) { list[i], list[j] = list[j], list[i] })
+		}
+		assert.Equal(t, want, hash(shuffled))
+	}
+
+	assert.NotEqual(t, want, hash(resources(routeConfig("rc-1", "b", "a"), routeConfig("rc-2", "c"))))
+}
+
+func TestConfigHash(t *testing.T) {
+	a := makeTestSnapshot(t, "v1", "alpha", "bravo")
+	b := makeTestSnapshot(t, "v2", "bravo", "alpha")
+	c := makeTestSnapshot(t, "v1", "alpha", "charlie")
+
+	// The version doesn't matter, and neither does the input order.
+	assert.NotEmpty(t, ConfigHash(a))
+	assert.Equal(t, ConfigHash(a), ConfigHash(b))
+
+	// The contents do.
+	assert.NotEqual(t, ConfigHash(a), ConfigHash(c))
+
+	assert.Equal(t, "", ConfigHash(nil))
+}
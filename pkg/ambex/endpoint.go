@@ -5,6 +5,9 @@ import (
 	"sort"
 	"strings"
 
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
 	v3core "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/core/v3"
 	v3endpoint "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/endpoint/v3"
 )
@@ -15,6 +18,23 @@ import (
 // endpoint data fairly easily with this layer of indirection.
 type Endpoints struct {
 	Entries map[string][]*Endpoint
+	// Zones, if set, says how to route by zone (see ZoneRouting).
+	Zones *ZoneRouting
+}
+
+// ZoneRouting tells ambex to keep traffic in Envoy's own zone when it can. A cluster's
+// endpoints in LocalZone go in priority 0, and the rest in priority 1, so Envoy only sends
+// traffic to other zones once too few of the local endpoints are healthy.
+type ZoneRouting struct {
+	// LocalZone is the zone that Envoy is running in.
+	LocalZone string
+	// MinEndpoints is how many endpoints a cluster needs in the local zone for the local
+	// zone to be preferred at all; with fewer, traffic is spread over every zone.
+	MinEndpoints int
+	// SpilloverPercent is the percentage of a cluster's local endpoints that have to be
+	// healthy to keep all of its traffic local. Below that, traffic spills over to the other
+	// zones in proportion. Zero leaves it to Envoy, which spills below about 71%.
+	SpilloverPercent uint32
 }
 
 func (e *Endpoints) RoutesString() string {
@@ -35,17 +55,85 @@ func (e *Endpoints) RoutesString() string {
 func (e *Endpoints) ToMap_v3() map[string]*v3endpoint.ClusterLoadAssignment {
 	result := map[string]*v3endpoint.ClusterLoadAssignment{}
 	for name, eps := range e.Entries {
-		var endpoints []*v3endpoint.LbEndpoint
-		for _, ep := range eps {
-			endpoints = append(endpoints, ep.ToLbEndpoint_v3())
+		// Sort a copy of the endpoints so that the same set of endpoints always produces the
+		// same ClusterLoadAssignment, no matter what order Kubernetes reported them in.
+		sorted := append([]*Endpoint(nil), eps...)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			if sorted[i].Ip != sorted[j].Ip {
+				return sorted[i].Ip < sorted[j].Ip
+			}
+			if sorted[i].Port != sorted[j].Port {
+				return sorted[i].Port < sorted[j].Port
+			}
+			return sorted[i].Protocol < sorted[j].Protocol
+		})
+		result[name] = e.loadAssignment(name, sorted)
+	}
+	return result
+}
+
+// loadAssignment puts a cluster's endpoints into a locality per zone, each weighted by how
+// many endpoints it has, and, for zone-aware routing, prioritizes the local zone over the
+// rest. Endpoints with no zone (which is all of them, unless we're watching EndpointSlices)
+// all go in a single locality, just as if there were no zones at all.
+func (e *Endpoints) loadAssignment(name string, eps []*Endpoint) *v3endpoint.ClusterLoadAssignment {
+	var zones []string
+	byZone := map[string][]*v3endpoint.LbEndpoint{}
+	for _, ep := range eps {
+		if _, seen := byZone[ep.Zone]; !seen {
+			zones = append(zones, ep.Zone)
+		}
+		byZone[ep.Zone] = append(byZone[ep.Zone], ep.ToLbEndpoint_v3())
+	}
+
+	loadAssignment := &v3endpoint.ClusterLoadAssignment{ClusterName: name}
+
+	if len(zones) == 0 || (len(zones) == 1 && zones[0] == "") {
+		loadAssignment.Endpoints = []*v3endpoint.LocalityLbEndpoints{{LbEndpoints: byZone[""]}}
+		return loadAssignment
+	}
+
+	sort.Strings(zones)
+
+	local := ""
+	if z := e.Zones; z != nil && z.LocalZone != "" {
+		minEndpoints := z.MinEndpoints
+		if minEndpoints < 1 {
+			minEndpoints = 1
 		}
-		loadAssignment := &v3endpoint.ClusterLoadAssignment{
-			ClusterName: name,
-			Endpoints:   []*v3endpoint.LocalityLbEndpoints{{LbEndpoints: endpoints}},
+		// If every endpoint is local, there's nothing to prefer.
+		if n := len(byZone[z.LocalZone]); n >= minEndpoints && n < len(eps) {
+			local = z.LocalZone
 		}
-		result[name] = loadAssignment
 	}
-	return result
+
+	for _, zone := range zones {
+		locality := &v3endpoint.LocalityLbEndpoints{
+			LbEndpoints:         byZone[zone],
+			LoadBalancingWeight: wrapperspb.UInt32(uint32(len(byZone[zone]))),
+		}
+		if zone != "" {
+			locality.Locality = &v3core.Locality{Zone: zone}
+		}
+		if local != "" && zone != local {
+			locality.Priority = 1
+		}
+		loadAssignment.Endpoints = append(loadAssignment.Endpoints, locality)
+	}
+
+	if local != "" && e.Zones.SpilloverPercent > 0 {
+		// Envoy spills over once the healthy percentage, scaled by the overprovisioning
+		// factor, drops below 100%.
+		percent := e.Zones.SpilloverPercent
+		if percent > 100 {
+			percent = 100
+		}
+		loadAssignment.Policy = &v3endpoint.ClusterLoadAssignment_Policy{
+			OverprovisioningFactor: wrapperspb.UInt32(10000 / percent),
+		}
+	}
+
+	return loadAssignment
 }
 
 // Endpoint contains the subset of fields we bother to expose.
@@ -54,11 +142,30 @@ type Endpoint struct {
 	Ip          string
 	Port        uint32
 	Protocol    string
+	// Zone is the failure domain (topology.kubernetes.io/zone) that the endpoint is in, if
+	// we know it.
+	Zone string
+	// Labels are the labels of the endpoint's Pod that Mappings pick subsets of the cluster
+	// by. They go to Envoy as the endpoint's envoy.lb metadata.
+	Labels map[string]string
 }
 
 // ToLBEndpoint_v3 translates to envoy v3 frinedly form of the Endpoint data.
 func (e *Endpoint) ToLbEndpoint_v3() *v3endpoint.LbEndpoint {
+	var metadata *v3core.Metadata
+	if len(e.Labels) > 0 {
+		fields := map[string]*structpb.Value{}
+		for k, v := range e.Labels {
+			fields[k] = structpb.NewStringValue(v)
+		}
+		metadata = &v3core.Metadata{
+			FilterMetadata: map[string]*structpb.Struct{
+				"envoy.lb": {Fields: fields},
+			},
+		}
+	}
 	return &v3endpoint.LbEndpoint{
+		Metadata: metadata,
 		HostIdentifier: &v3endpoint.LbEndpoint_Endpoint{
 			Endpoint: &v3endpoint.Endpoint{
 				Address: &v3core.Address{
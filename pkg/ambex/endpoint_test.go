@@ -0,0 +1,97 @@
+package ambex
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	v3endpoint "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/endpoint/v3"
+)
+
+func TestEndpointZones(t *testing.T) {
+	ep := func(ip, zone string) *Endpoint {
+		return &Endpoint{ClusterName: "k8s/default/foo", Ip: ip, Port: 8080, Protocol: "TCP", Zone: zone}
+	}
+	// localities flattens a ClusterLoadAssignment into "zone/priority/weight=ip,ip..." strings.
+	localities := func(cla *v3endpoint.ClusterLoadAssignment) []string {
+		var ret []string
+		for _, l := range cla.Endpoints {
+			var ips []string
+			for _, lb := range l.LbEndpoints {
+				ips = append(ips, lb.GetEndpoint().GetAddress().GetSocketAddress().GetAddress())
+			}
+			s := strings.Join(ips, ",")
+			if l.LoadBalancingWeight != nil {
+				s = fmt.Sprintf("%s/%d/%d=%s", l.GetLocality().GetZone(), l.Priority, l.LoadBalancingWeight.Value, s)
+			} else {
+				s = "=" + s
+			}
+			ret = append(ret, s)
+		}
+		return ret
+	}
+
+	// Without zones, everything is in one locality, as always.
+	eps := &Endpoints{Entries: map[string][]*Endpoint{
+		"k8s/default/foo": {ep("1.1.1.2", ""), ep("1.1.1.1", "")},
+	}}
+	cla := eps.ToMap_v3()["k8s/default/foo"]
+	assert.Equal(t, []string{"=1.1.1.1,1.1.1.2"}, localities(cla))
+	assert.Nil(t, cla.Policy)
+
+	// With zones, each one is a locality, weighted by its size.
+	eps.Entries["k8s/default/foo"] = []*Endpoint{
+		ep("1.1.1.1", "us-east-1a"),
+		ep("1.1.1.2", "us-east-1b"),
+		ep("1.1.1.3", "us-east-1a"),
+		ep("1.1.1.4", "us-east-1c"),
+	}
+	cla = eps.ToMap_v3()["k8s/default/foo"]
+	assert.Equal(t, []string{
+		"us-east-1a/0/2=1.1.1.1,1.1.1.3",
+		"us-east-1b/0/1=1.1.1.2",
+		"us-east-1c/0/1=1.1.1.4",
+	}, localities(cla))
+
+	// Zone-aware routing puts everything but the local zone in priority 1...
+	eps.Zones = &ZoneRouting{LocalZone: "us-east-1a", MinEndpoints: 2, SpilloverPercent: 80}
+	cla = eps.ToMap_v3()["k8s/default/foo"]
+	assert.Equal(t, []string{
+		"us-east-1a/0/2=1.1.1.1,1.1.1.3",
+		"us-east-1b/1/1=1.1.1.2",
+		"us-east-1c/1/1=1.1.1.4",
+	}, localities(cla))
+	require.NotNil(t, cla.Policy)
+	assert.Equal(t, uint32(125), cla.Policy.OverprovisioningFactor.Value)
+
+	// ...unless the local zone is too small.
+	eps.Zones.LocalZone = "us-east-1b"
+	cla = eps.ToMap_v3()["k8s/default/foo"]
+	assert.Equal(t, []string{
+		"us-east-1a/0/2=1.1.1.1,1.1.1.3",
+		"us-east-1b/0/1=1.1.1.2",
+		"us-east-1c/0/1=1.1.1.4",
+	}, localities(cla))
+	assert.Nil(t, cla.Policy)
+}
+
+func TestEndpointLabels(t *testing.T) {
+	eps := &Endpoints{Entries: map[string][]*Endpoint{
+		"k8s/default/foo": {
+			{ClusterName: "k8s/default/foo", Ip: "1.1.1.1", Port: 8080, Protocol: "TCP", Labels: map[string]string{"version": "v1"}},
+			{ClusterName: "k8s/default/foo", Ip: "1.1.1.2", Port: 8080, Protocol: "TCP"},
+		},
+	}}
+	lbEndpoints := eps.ToMap_v3()["k8s/default/foo"].Endpoints[0].LbEndpoints
+	require.Len(t, lbEndpoints, 2)
+
+	// Labels become envoy.lb metadata, for subset load balancing.
+	lb := lbEndpoints[0].GetMetadata().GetFilterMetadata()["envoy.lb"]
+	require.NotNil(t, lb)
+	assert.Equal(t, map[string]interface{}{"version": "v1"}, lb.AsMap())
+
+	assert.Nil(t, lbEndpoints[1].Metadata)
+}
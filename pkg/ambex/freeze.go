@@ -0,0 +1,118 @@
+package ambex
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Freezing:
+//
+// When a bad configuration change gets merged, the quickest way to stop the damage is often to stop
+// pushing configuration to Envoy at all, and leave it running the last configuration that worked
+// while the change is reverted. A Freezer does that: while it's frozen, everything upstream of the
+// Updater keeps going (the watcher keeps watching, diagd keeps validating and generating
+// configuration) but the Updater holds on to the latest update instead of pushing it. Thawing pushes
+// whatever is latest by then.
+//
+// Endpoint changes are held back too, since they're part of the same snapshot, so a long freeze
+// leaves Envoy sending traffic to wherever the endpoints used to be. Freezes are meant to be short.
+// The freeze only lives in memory: a restarted pod starts out thawed.
+
+// FreezeState describes whether configuration pushes are frozen.
+type FreezeState struct {
+	Frozen bool `json:"frozen"`
+	// Reason is what whoever froze the configuration said about why.
+	Reason string `json:"reason,omitempty"`
+	// Since is when the configuration was frozen.
+	Since time.Time `json:"since,omitempty"`
+	// Held is how many updates have arrived, and not been pushed, since the freeze.
+	Held int `json:"held"`
+	// HeldVersion and HeldHash identify the update that thawing would push.
+	HeldVersion string `json:"heldVersion,omitempty"`
+	HeldHash    string `json:"heldHash,omitempty"`
+}
+
+// A Freezer controls whether the Updater pushes updates to Envoy.
+type Freezer struct {
+	mutex sync.Mutex
+	state FreezeState
+
+	// thawed has a value in it when the Updater needs to push the latest update because of a thaw.
+	thawed chan struct{}
+}
+
+// NewFreezer returns a Freezer that isn't frozen.
+func NewFreezer() *Freezer {
+	return &Freezer{thawed: make(chan struct{}, 1)}
+}
+
+// Freeze stops updates from being pushed until Thaw is called. Freezing again while frozen just
+// replaces the reason.
+func (f *Freezer) Freeze(reason string, now time.Time) FreezeState {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if !f.state.Frozen {
+		f.state = FreezeState{Frozen: true, Since: now}
+	}
+	f.state.Reason = reason
+	return f.state
+}
+
+// Thaw lets updates be pushed again, starting with the latest one. It returns the state from
+// before the thaw.
+func (f *Freezer) Thaw() FreezeState {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	old := f.state
+	f.state = FreezeState{}
+	if old.Frozen {
+		select {
+		case f.thawed <- struct{}{}:
+		default:
+		}
+	}
+	return old
+}
+
+// State returns the current FreezeState.
+func (f *Freezer) State() FreezeState {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.state
+}
+
+// hold records that the update isn't being pushed because of the freeze, if there is one. It
+// returns false if there's no freeze and the update should be pushed.
+func (f *Freezer) hold(update Update, isNew bool) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if !f.state.Frozen {
+		return false
+	}
+	if isNew {
+		f.state.Held++
+		f.state.HeldVersion = update.Version
+		f.state.HeldHash = update.Hash
+	}
+	return true
+}
+
+// The default Freezer, for when the context doesn't have one.
+var rootFreezer = NewFreezer()
+
+type freezerKey struct{}
+
+// WithFreezer returns a child context that has the given Freezer.
+func WithFreezer(parent context.Context, f *Freezer) context.Context {
+	return context.WithValue(parent, freezerKey{}, f)
+}
+
+// FreezerFromContext returns the context's Freezer, or the process-wide default one if the
+// context doesn't have one.
+func FreezerFromContext(ctx context.Context) *Freezer {
+	if f, ok := ctx.Value(freezerKey{}).(*Freezer); ok {
+		return f
+	}
+	return rootFreezer
+}
@@ -0,0 +1,472 @@
+package ambex
+
+import (
+	// standard library
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	// third-party libraries
+	"google.golang.org/protobuf/proto"
+
+	// envoy api v3
+	v3routeconfig "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/route/v3"
+
+	// envoy control plane
+	ecp_cache_types "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/cache/types"
+	ecp_v3_cache "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/cache/v3"
+
+	// first-party libraries
+	"github.com/datawire/dlib/dlog"
+)
+
+// Guardrails:
+//
+// It's entirely possible to write a perfectly valid set of Mappings that produces an Envoy
+// configuration so large that Envoy spends gigabytes of memory holding it (and holding the stale
+// copies that are still draining). Once Envoy has been handed such a thing, the only fix is to
+// restart it, so instead we check the size of every snapshot before we push it.
+//
+// All the limits are off by default. In "warn" mode, violations are logged and reported via the
+// debug endpoint but the snapshot is still pushed. In "enforce" mode, the snapshot is brought
+// within the limits by rejecting Mappings: their routes are left out, along with any clusters
+// (and their endpoints) that nothing else routes to, and the rest of the snapshot is pushed as
+// usual. Refusing the whole snapshot instead would leave Envoy's endpoints and certificates to go
+// stale because of a Mapping that had nothing to do with them.
+//
+// diagd lists the Mappings that each route is for in the route's metadata (see
+// MappingsMetadataKey). The Mappings rejected are the ones of the lowest-precedence routes: the
+// ones at the end of the virtual host that has too many, or at the end of the last virtual host
+// for the other limits. A rejected Mapping stays rejected only as long as it doesn't fit; the
+// RejectionHandler in the context hears about the rejections for every snapshot. Routes with no
+// Mappings listed (the fastpath's, and diagd's own) are never left out, so if they alone break a
+// limit the snapshot is pushed anyway, with the violations logged.
+
+// LimitsMode says what to do when a snapshot exceeds one of the configured Limits.
+type LimitsMode string
+
+const (
+	// LimitsModeWarn logs violations but still pushes the snapshot.
+	LimitsModeWarn LimitsMode = "warn"
+	// LimitsModeEnforce rejects Mappings until the snapshot is within the limits.
+	LimitsModeEnforce LimitsMode = "enforce"
+)
+
+// Limits holds the configured size and cardinality limits. A zero value for any limit means that
+// limit is disabled.
+type Limits struct {
+	Mode LimitsMode
+
+	// MaxRoutesPerVirtualHost is the largest number of routes allowed in a single virtual host.
+	MaxRoutesPerVirtualHost int
+	// MaxClusters is the largest number of clusters allowed in a snapshot.
+	MaxClusters int
+	// MaxConfigBytes is the largest total size, in bytes, of all the serialized resources in a
+	// snapshot.
+	MaxConfigBytes int
+}
+
+// LimitViolation describes a single way in which a snapshot exceeds the configured Limits.
+type LimitViolation struct {
+	Limit    string `json:"limit"`
+	Resource string `json:"resource,omitempty"`
+	Value    int    `json:"value"`
+	Max      int    `json:"max"`
+}
+
+func (v LimitViolation) String() string {
+	if v.Resource != "" {
+		return fmt.Sprintf("%s: %s has %d (max %d)", v.Limit, v.Resource, v.Value, v.Max)
+	}
+	return fmt.Sprintf("%s: %d (max %d)", v.Limit, v.Value, v.Max)
+}
+
+// Enabled returns true IFF any limit is configured.
+func (l Limits) Enabled() bool {
+	return l.MaxRoutesPerVirtualHost > 0 || l.MaxClusters > 0 || l.MaxConfigBytes > 0
+}
+
+// GetLimits reads Limits from the environment:
+//
+//	AMBASSADOR_CONFIG_LIMITS_MODE           "warn" (default) or "enforce"
+//	AMBASSADOR_MAX_ROUTES_PER_VIRTUAL_HOST  max routes in any one virtual host
+//	AMBASSADOR_MAX_CLUSTERS                 max clusters in a snapshot
+//	AMBASSADOR_MAX_CONFIG_BYTES             max total serialized size of a snapshot
+func GetLimits(ctx context.Context) Limits {
+	limits := Limits{
+		Mode:                    LimitsModeWarn,
+		MaxRoutesPerVirtualHost: getLimit(ctx, "AMBASSADOR_MAX_ROUTES_PER_VIRTUAL_HOST"),
+		MaxClusters:             getLimit(ctx, "AMBASSADOR_MAX_CLUSTERS"),
+		MaxConfigBytes:          getLimit(ctx, "AMBASSADOR_MAX_CONFIG_BYTES"),
+	}
+
+	switch mode := strings.ToLower(os.Getenv("AMBASSADOR_CONFIG_LIMITS_MODE")); mode {
+	case "", string(LimitsModeWarn):
+		// Already the default.
+	case string(LimitsModeEnforce):
+		limits.Mode = LimitsModeEnforce
+	default:
+		dlog.Errorf(ctx, "Invalid AMBASSADOR_CONFIG_LIMITS_MODE: %s, using %s", mode, limits.Mode)
+	}
+
+	return limits
+}
+
+func getLimit(ctx context.Context, name string) int {
+	str := os.Getenv(name)
+	if str == "" {
+		return 0
+	}
+	val, err := strconv.Atoi(str)
+	if err != nil || val < 0 {
+		dlog.Errorf(ctx, "Invalid %s: %s, disabling the limit", name, str)
+		return 0
+	}
+	return val
+}
+
+// CheckLimits checks a set of resources (as handed to ecp_v3_cache.NewSnapshot) against the
+// limits, and returns all the violations it finds, sorted for stable output.
+func (l Limits) CheckLimits(clusters, routes []ecp_cache_types.Resource, all ...[]ecp_cache_types.Resource) []LimitViolation {
+	var violations []LimitViolation
+
+	if l.MaxClusters > 0 && len(clusters) > l.MaxClusters {
+		violations = append(violations, LimitViolation{
+			Limit: "max_clusters",
+			Value: len(clusters),
+			Max:   l.MaxClusters,
+		})
+	}
+
+	if l.MaxRoutesPerVirtualHost > 0 {
+		for _, r := range routes {
+			rc, ok := r.(*v3routeconfig.RouteConfiguration)
+			if !ok {
+				continue
+			}
+			for _, vh := range rc.VirtualHosts {
+				if len(vh.Routes) > l.MaxRoutesPerVirtualHost {
+					violations = append(violations, LimitViolation{
+						Limit:    "max_routes_per_virtual_host",
+						Resource: fmt.Sprintf("%s/%s", rc.Name, vh.Name),
+						Value:    len(vh.Routes),
+						Max:      l.MaxRoutesPerVirtualHost,
+					})
+				}
+			}
+		}
+	}
+
+	if l.MaxConfigBytes > 0 {
+		total := 0
+		for _, resources := range append([][]ecp_cache_types.Resource{clusters, routes}, all...) {
+			for _, r := range resources {
+				total += proto.Size(r)
+			}
+		}
+		if total > l.MaxConfigBytes {
+			violations = append(violations, LimitViolation{
+				Limit: "max_config_bytes",
+				Value: total,
+				Max:   l.MaxConfigBytes,
+			})
+		}
+	}
+
+	sort.SliceStable(violations, func(i, j int) bool {
+		if violations[i].Limit != violations[j].Limit {
+			return violations[i].Limit < violations[j].Limit
+		}
+		return violations[i].Resource < violations[j].Resource
+	})
+
+	return violations
+}
+
+// MappingsMetadataKey is the route filter_metadata under which diagd lists the Mappings that a
+// route is for, when AMBASSADOR_CONFIG_LIMITS_MODE is "enforce" (see
+// python/ambassador/envoy/v3/v3route.py).
+const MappingsMetadataKey = "getambassador.io/mappings"
+
+// MappingRef names a Mapping resource.
+type MappingRef struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+func (m MappingRef) String() string {
+	return m.Name + "." + m.Namespace
+}
+
+// A Rejection is a Mapping whose routes were left out of a snapshot to keep it within the limits.
+type Rejection struct {
+	Mapping MappingRef `json:"mapping"`
+	// Limit is the limit that the Mapping's routes would have broken.
+	Limit string `json:"limit"`
+}
+
+// Enforcement is what's left of a snapshot's resources once Enforce has brought them within the
+// limits.
+type Enforcement struct {
+	Clusters  []ecp_cache_types.Resource
+	Routes    []ecp_cache_types.Resource
+	Endpoints []ecp_cache_types.Resource
+
+	// Rejected are the Mappings whose routes were left out, sorted by namespace and name.
+	Rejected []Rejection
+	// Violations are the violations left that no rejection could fix.
+	Violations []LimitViolation
+}
+
+// Enforce rejects Mappings until the resources are within the limits (or there are no more
+// Mappings that would help to reject). endpoints builds the endpoints for a set of clusters, the
+// way JoinEdsClustersV3 does; the other resources are only counted towards MaxConfigBytes. The
+// resources passed in are never changed: a RouteConfiguration that loses routes is copied.
+func (l Limits) Enforce(clusters, routes []ecp_cache_types.Resource, endpoints func(clusters []ecp_cache_types.Resource) []ecp_cache_types.Resource, others ...[]ecp_cache_types.Resource) Enforcement {
+	rejected := map[MappingRef]string{}
+	for {
+		e := Enforcement{}
+		e.Clusters, e.Routes = withoutMappings(clusters, routes, rejected)
+		e.Endpoints = endpoints(e.Clusters)
+		e.Violations = l.CheckLimits(e.Clusters, e.Routes, append([][]ecp_cache_types.Resource{e.Endpoints}, others...)...)
+
+		more := false
+		for _, v := range e.Violations {
+			for _, m := range l.culprits(v, e.Routes) {
+				rejected[m] = v.Limit
+				more = true
+			}
+			if more {
+				break
+			}
+		}
+		if more {
+			continue
+		}
+
+		for m, limit := range rejected {
+			e.Rejected = append(e.Rejected, Rejection{Mapping: m, Limit: limit})
+		}
+		sort.Slice(e.Rejected, func(i, j int) bool {
+			if e.Rejected[i].Mapping.Namespace != e.Rejected[j].Mapping.Namespace {
+				return e.Rejected[i].Mapping.Namespace < e.Rejected[j].Mapping.Namespace
+			}
+			return e.Rejected[i].Mapping.Name < e.Rejected[j].Mapping.Name
+		})
+		return e
+	}
+}
+
+// culprits returns the Mappings of the lowest-precedence routes that, left out, would go some way
+// to fixing the violation. None of them are rejected already, since routes of rejected Mappings
+// aren't in routes any more.
+func (l Limits) culprits(v LimitViolation, routes []ecp_cache_types.Resource) []MappingRef {
+	var culprits []MappingRef
+	seen := map[MappingRef]bool{}
+	take := func(route *v3routeconfig.Route) bool {
+		mappings := routeMappings(route)
+		for _, m := range mappings {
+			if !seen[m] {
+				seen[m] = true
+				culprits = append(culprits, m)
+			}
+		}
+		return len(mappings) > 0
+	}
+
+	switch v.Limit {
+	case "max_routes_per_virtual_host":
+		need := v.Value - v.Max
+		eachRoute(routes, func(rc *v3routeconfig.RouteConfiguration, vh *v3routeconfig.VirtualHost, route *v3routeconfig.Route) bool {
+			if fmt.Sprintf("%s/%s", rc.Name, vh.Name) == v.Resource && take(route) {
+				need--
+			}
+			return need > 0
+		})
+	case "max_clusters":
+		// Rejecting a Mapping never gets rid of a cluster that a route with no Mappings uses.
+		pinned := map[string]bool{}
+		eachRoute(routes, func(_ *v3routeconfig.RouteConfiguration, _ *v3routeconfig.VirtualHost, route *v3routeconfig.Route) bool {
+			if len(routeMappings(route)) == 0 {
+				for _, name := range routeClusters(route) {
+					pinned[name] = true
+				}
+			}
+			return true
+		})
+		need := v.Value - v.Max
+		clusters := map[string]bool{}
+		eachRoute(routes, func(_ *v3routeconfig.RouteConfiguration, _ *v3routeconfig.VirtualHost, route *v3routeconfig.Route) bool {
+			var names []string
+			for _, name := range routeClusters(route) {
+				if !pinned[name] {
+					names = append(names, name)
+				}
+			}
+			if len(names) > 0 && take(route) {
+				for _, name := range names {
+					clusters[name] = true
+				}
+			}
+			return len(clusters) < need
+		})
+	case "max_config_bytes":
+		need := v.Value - v.Max
+		eachRoute(routes, func(_ *v3routeconfig.RouteConfiguration, _ *v3routeconfig.VirtualHost, route *v3routeconfig.Route) bool {
+			if take(route) {
+				need -= proto.Size(route)
+			}
+			return need > 0
+		})
+	}
+	return culprits
+}
+
+// eachRoute calls fn for every route, last first, until fn returns false. Within a
+// RouteConfiguration, "last" is lowest precedence; RouteConfigurations themselves go in reverse
+// order of name, since the order they come to us in means nothing to Envoy.
+func eachRoute(routes []ecp_cache_types.Resource, fn func(*v3routeconfig.RouteConfiguration, *v3routeconfig.VirtualHost, *v3routeconfig.Route) bool) {
+	rcs := make([]*v3routeconfig.RouteConfiguration, 0, len(routes))
+	for _, r := range routes {
+		if rc, ok := r.(*v3routeconfig.RouteConfiguration); ok {
+			rcs = append(rcs, rc)
+		}
+	}
+	sort.SliceStable(rcs, func(i, j int) bool {
+		return rcs[i].Name < rcs[j].Name
+	})
+	for i := len(rcs) - 1; i >= 0; i-- {
+		rc := rcs[i]
+		for j := len(rc.VirtualHosts) - 1; j >= 0; j-- {
+			vh := rc.VirtualHosts[j]
+			for k := len(vh.Routes) - 1; k >= 0; k-- {
+				if !fn(rc, vh, vh.Routes[k]) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// withoutMappings returns the resources left once the routes of the rejected Mappings are taken
+// out, along with the clusters that only those routes sent requests to.
+func withoutMappings(clusters, routes []ecp_cache_types.Resource, rejected map[MappingRef]string) ([]ecp_cache_types.Resource, []ecp_cache_types.Resource) {
+	if len(rejected) == 0 {
+		return clusters, routes
+	}
+
+	kept := map[string]bool{}
+	dropped := map[string]bool{}
+	isRejected := func(route *v3routeconfig.Route) bool {
+		for _, m := range routeMappings(route) {
+			if _, ok := rejected[m]; ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	keptRoutes := make([]ecp_cache_types.Resource, 0, len(routes))
+	for _, r := range routes {
+		rc, ok := r.(*v3routeconfig.RouteConfiguration)
+		if !ok {
+			keptRoutes = append(keptRoutes, r)
+			continue
+		}
+		var copied *v3routeconfig.RouteConfiguration
+		for i, vh := range rc.VirtualHosts {
+			var vhRoutes []*v3routeconfig.Route
+			for _, route := range vh.Routes {
+				if isRejected(route) {
+					for _, name := range routeClusters(route) {
+						dropped[name] = true
+					}
+					continue
+				}
+				for _, name := range routeClusters(route) {
+					kept[name] = true
+				}
+				vhRoutes = append(vhRoutes, route)
+			}
+			if len(vhRoutes) == len(vh.Routes) {
+				continue
+			}
+			if copied == nil {
+				copied = proto.Clone(rc).(*v3routeconfig.RouteConfiguration)
+			}
+			copied.VirtualHosts[i].Routes = vhRoutes
+		}
+		if copied != nil {
+			keptRoutes = append(keptRoutes, copied)
+		} else {
+			keptRoutes = append(keptRoutes, rc)
+		}
+	}
+
+	keptClusters := make([]ecp_cache_types.Resource, 0, len(clusters))
+	for _, c := range clusters {
+		name := ecp_v3_cache.GetResourceName(c)
+		if dropped[name] && !kept[name] {
+			continue
+		}
+		keptClusters = append(keptClusters, c)
+	}
+	return keptClusters, keptRoutes
+}
+
+// routeMappings returns the Mappings that diagd says a route is for.
+func routeMappings(route *v3routeconfig.Route) []MappingRef {
+	md, ok := route.GetMetadata().GetFilterMetadata()[MappingsMetadataKey]
+	if !ok {
+		return nil
+	}
+	var mappings []MappingRef
+	for _, v := range md.GetFields()["mappings"].GetListValue().GetValues() {
+		fields := v.GetStructValue().GetFields()
+		mappings = append(mappings, MappingRef{
+			Name:      fields["name"].GetStringValue(),
+			Namespace: fields["namespace"].GetStringValue(),
+		})
+	}
+	return mappings
+}
+
+// routeClusters returns the names of the clusters that a route sends requests to.
+func routeClusters(route *v3routeconfig.Route) []string {
+	action := route.GetRoute()
+	if action == nil {
+		return nil
+	}
+	var names []string
+	if name := action.GetCluster(); name != "" {
+		names = append(names, name)
+	}
+	for _, wc := range action.GetWeightedClusters().GetClusters() {
+		names = append(names, wc.GetName())
+	}
+	for _, mirror := range action.GetRequestMirrorPolicies() {
+		names = append(names, mirror.GetCluster())
+	}
+	return names
+}
+
+// A RejectionHandler hears which Mappings were rejected from each snapshot, every time (nil, once
+// everything fits again).
+type RejectionHandler func(rejected []Rejection)
+
+type rejectionHandlerKey struct{}
+
+// WithRejectionHandler returns a child context that has the given RejectionHandler.
+func WithRejectionHandler(parent context.Context, h RejectionHandler) context.Context {
+	return context.WithValue(parent, rejectionHandlerKey{}, h)
+}
+
+// rejectionHandlerFromContext returns the context's RejectionHandler, or nil if it doesn't have
+// one.
+func rejectionHandlerFromContext(ctx context.Context) RejectionHandler {
+	h, _ := ctx.Value(rejectionHandlerKey{}).(RejectionHandler)
+	return h
+}
@@ -0,0 +1,217 @@
+package ambex
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	v3cluster "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/cluster/v3"
+	v3core "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/core/v3"
+	v3endpoint "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/endpoint/v3"
+	v3route "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/route/v3"
+	ecp_cache_types "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/cache/types"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+func TestGetLimits(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+
+	limits := GetLimits(ctx)
+	assert.False(t, limits.Enabled())
+	assert.Equal(t, LimitsModeWarn, limits.Mode)
+
+	t.Setenv("AMBASSADOR_CONFIG_LIMITS_MODE", "Enforce")
+	t.Setenv("AMBASSADOR_MAX_CLUSTERS", "10")
+	t.Setenv("AMBASSADOR_MAX_ROUTES_PER_VIRTUAL_HOST", "bogus")
+	limits = GetLimits(ctx)
+	assert.True(t, limits.Enabled())
+	assert.Equal(t, LimitsModeEnforce, limits.Mode)
+	assert.Equal(t, 10, limits.MaxClusters)
+	assert.Equal(t, 0, limits.MaxRoutesPerVirtualHost)
+}
+
+func TestCheckLimits(t *testing.T) {
+	clusters := []ecp_cache_types.Resource{
+		&v3cluster.Cluster{Name: "a"},
+		&v3cluster.Cluster{Name: "b"},
+		&v3cluster.Cluster{Name: "c"},
+	}
+	routes := []ecp_cache_types.Resource{
+		&v3route.RouteConfiguration{
+			Name: "rc",
+			VirtualHosts: []*v3route.VirtualHost{
+				{Name: "small", Routes: []*v3route.Route{{Name: "1"}}},
+				{Name: "big", Routes: []*v3route.Route{{Name: "1"}, {Name: "2"}, {Name: "3"}}},
+			},
+		},
+	}
+
+	// No limits, no violations.
+	assert.Empty(t, Limits{}.CheckLimits(clusters, routes))
+
+	limits := Limits{
+		MaxClusters:             2,
+		MaxRoutesPerVirtualHost: 2,
+		MaxConfigBytes:          1,
+	}
+	violations := limits.CheckLimits(clusters, routes)
+	if assert.Len(t, violations, 3) {
+		assert.Equal(t, "max_clusters", violations[0].Limit)
+		assert.Equal(t, 3, violations[0].Value)
+		assert.Equal(t, "max_config_bytes", violations[1].Limit)
+		assert.Equal(t, "max_routes_per_virtual_host", violations[2].Limit)
+		assert.Equal(t, "rc/big", violations[2].Resource)
+	}
+
+	// Generous limits are fine.
+	limits = Limits{MaxClusters: 3, MaxRoutesPerVirtualHost: 3, MaxConfigBytes: 1 << 20}
+	assert.Empty(t, limits.CheckLimits(clusters, routes))
+}
+
+func mappingRoute(name, cluster string, mappings ...string) *v3route.Route {
+	refs := make([]interface{}, 0, len(mappings))
+	for _, m := range mappings {
+		refs = append(refs, map[string]interface{}{"name": m, "namespace": "default"})
+	}
+	md, err := structpb.NewStruct(map[string]interface{}{"mappings": refs})
+	if err != nil {
+		panic(err)
+	}
+	route := &v3route.Route{
+		Name:   name,
+		Action: &v3route.Route_Route{Route: &v3route.RouteAction{ClusterSpecifier: &v3route.RouteAction_Cluster{Cluster: cluster}}},
+	}
+	if len(mappings) > 0 {
+		route.Metadata = &v3core.Metadata{FilterMetadata: map[string]*structpb.Struct{MappingsMetadataKey: md}}
+	}
+	return route
+}
+
+func TestEnforce(t *testing.T) {
+	clusters := []ecp_cache_types.Resource{
+		&v3cluster.Cluster{Name: "a"},
+		&v3cluster.Cluster{Name: "b"},
+		&v3cluster.Cluster{Name: "c"},
+		&v3cluster.Cluster{Name: "auth"},
+	}
+	rc := &v3route.RouteConfiguration{
+		Name: "rc",
+		VirtualHosts: []*v3route.VirtualHost{
+			{Name: "one", Routes: []*v3route.Route{
+				mappingRoute("1", "a", "m-a"),
+				mappingRoute("2", "b", "m-b"),
+				mappingRoute("3", "c", "m-c"),
+				mappingRoute("fastpath", "a"),
+			}},
+			{Name: "two", Routes: []*v3route.Route{
+				mappingRoute("1", "a", "m-a"),
+				mappingRoute("2", "c", "m-c"),
+			}},
+		},
+	}
+	routes := []ecp_cache_types.Resource{rc}
+	endpoints := func(clusters []ecp_cache_types.Resource) []ecp_cache_types.Resource {
+		var endpoints []ecp_cache_types.Resource
+		for _, c := range clusters {
+			endpoints = append(endpoints, &v3endpoint.ClusterLoadAssignment{ClusterName: c.(*v3cluster.Cluster).Name})
+		}
+		return endpoints
+	}
+	names := func(rc ecp_cache_types.Resource, vh int) []string {
+		var names []string
+		for _, route := range rc.(*v3route.RouteConfiguration).VirtualHosts[vh].Routes {
+			names = append(names, route.Name)
+		}
+		return names
+	}
+
+	// Too many routes in "one": the lowest-precedence route with a Mapping goes, everywhere
+	// that Mapping has routes, along with the cluster nothing else routes to. The fastpath
+	// route stays.
+	e := Limits{Mode: LimitsModeEnforce, MaxRoutesPerVirtualHost: 3}.Enforce(clusters, routes, endpoints)
+	assert.Empty(t, e.Violations)
+	assert.Equal(t, []Rejection{{Mapping: MappingRef{Name: "m-c", Namespace: "default"}, Limit: "max_routes_per_virtual_host"}}, e.Rejected)
+	if assert.Len(t, e.Routes, 1) {
+		assert.Equal(t, []string{"1", "2", "fastpath"}, names(e.Routes[0], 0))
+		assert.Equal(t, []string{"1"}, names(e.Routes[0], 1))
+	}
+	assert.Len(t, e.Clusters, 3)
+	assert.Len(t, e.Endpoints, 3)
+	for _, c := range e.Clusters {
+		assert.NotEqual(t, "c", c.(*v3cluster.Cluster).Name)
+	}
+
+	// What was passed in is left alone.
+	assert.Len(t, rc.VirtualHosts[0].Routes, 4)
+	assert.Len(t, rc.VirtualHosts[1].Routes, 2)
+
+	// Too many clusters: Mappings go until enough clusters do. The cluster that no route uses,
+	// and the one the fastpath uses, stay.
+	e = Limits{Mode: LimitsModeEnforce, MaxClusters: 2}.Enforce(clusters, routes, endpoints)
+	assert.Empty(t, e.Violations)
+	assert.Equal(t, []Rejection{
+		{Mapping: MappingRef{Name: "m-b", Namespace: "default"}, Limit: "max_clusters"},
+		{Mapping: MappingRef{Name: "m-c", Namespace: "default"}, Limit: "max_clusters"},
+	}, e.Rejected)
+	if assert.Len(t, e.Clusters, 2) {
+		assert.Equal(t, "a", e.Clusters[0].(*v3cluster.Cluster).Name)
+		assert.Equal(t, "auth", e.Clusters[1].(*v3cluster.Cluster).Name)
+	}
+	assert.Len(t, e.Endpoints, 2)
+
+	// A limit that rejecting Mappings can't fix is left as a violation, and m-a isn't rejected
+	// for nothing: the fastpath route keeps its cluster anyway.
+	e = Limits{Mode: LimitsModeEnforce, MaxClusters: 1}.Enforce(clusters, routes, endpoints)
+	assert.Len(t, e.Rejected, 2)
+	if assert.Len(t, e.Violations, 1) {
+		assert.Equal(t, "max_clusters", e.Violations[0].Limit)
+		assert.Equal(t, 2, e.Violations[0].Value)
+	}
+
+	// Too big: the Mappings of the last routes go until it fits.
+	total := 0
+	for _, r := range append(append(append([]ecp_cache_types.Resource{}, clusters...), routes...), endpoints(clusters)...) {
+		total += proto.Size(r)
+	}
+	e = Limits{Mode: LimitsModeEnforce, MaxConfigBytes: total - 1}.Enforce(clusters, routes, endpoints)
+	assert.Empty(t, e.Violations)
+	assert.Equal(t, []Rejection{{Mapping: MappingRef{Name: "m-c", Namespace: "default"}, Limit: "max_config_bytes"}}, e.Rejected)
+
+	// Within the limits, nothing is rejected.
+	e = Limits{Mode: LimitsModeEnforce, MaxClusters: 4, MaxRoutesPerVirtualHost: 4}.Enforce(clusters, routes, endpoints)
+	assert.Empty(t, e.Violations)
+	assert.Empty(t, e.Rejected)
+	assert.Equal(t, routes, e.Routes)
+}
+
+// Tests that which Mappings get rejected doesn't depend on the order the RouteConfigurations
+// come in.
+func TestEnforceRouteConfigurationOrder(t *testing.T) {
+	clusters := []ecp_cache_types.Resource{
+		&v3cluster.Cluster{Name: "a"},
+		&v3cluster.Cluster{Name: "b"},
+	}
+	rc1 := &v3route.RouteConfiguration{
+		Name: "rc-1",
+		VirtualHosts: []*v3route.VirtualHost{
+			{Name: "vh", Routes: []*v3route.Route{mappingRoute("1", "a", "m-a")}},
+		},
+	}
+	rc2 := &v3route.RouteConfiguration{
+		Name: "rc-2",
+		VirtualHosts: []*v3route.VirtualHost{
+			{Name: "vh", Routes: []*v3route.Route{mappingRoute("1", "b", "m-b")}},
+		},
+	}
+	endpoints := func(clusters []ecp_cache_types.Resource) []ecp_cache_types.Resource { return nil }
+
+	want := []Rejection{{Mapping: MappingRef{Name: "m-b", Namespace: "default"}, Limit: "max_clusters"}}
+	for _, routes := range [][]ecp_cache_types.Resource{{rc1, rc2}, {rc2, rc1}} {
+		e := Limits{Mode: LimitsModeEnforce, MaxClusters: 1}.Enforce(clusters, routes, endpoints)
+		assert.Empty(t, e.Violations)
+		assert.Equal(t, want, e.Rejected)
+	}
+}
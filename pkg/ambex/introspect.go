@@ -0,0 +1,360 @@
+package ambex
+
+import (
+	// standard library
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	// envoy api v3
+	v3clusterconfig "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/cluster/v3"
+	v3routeconfig "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/route/v3"
+
+	// envoy control plane
+	ecp_cache_types "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/cache/types"
+	ecp_v3_cache "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/cache/v3"
+)
+
+// Introspection:
+//
+// Envoy's stats (and therefore most people's alerts) are keyed by generated cluster names, which
+// are nothing like the names of the Kubernetes Services and Mappings that people actually own. The
+// Introspection type is built from each snapshot we push, and lets you go from a cluster name (or
+// its alt_stat_name) back to the Service and Mappings it came from, and from a Service, a Mapping,
+// or a hostname forward to the clusters and routes that use it.
+//
+// We don't work that out from the cluster itself: diagd records it in the clustermap (see
+// V3Cluster.generate in python/ambassador/envoy/v3/v3cluster.py) when it generates the cluster,
+// and we serve that. Clusters that aren't in the clustermap (the fastpath's, say) only get what
+// their EDS service name says.
+
+// IntrospectionDebugValue is the name of the debug value holding the *Introspection for the most
+// recently pushed snapshot.
+const IntrospectionDebugValue = "clusterIntrospection"
+
+// ClusterSource describes where a single generated Envoy cluster came from.
+type ClusterSource struct {
+	// Cluster is the Envoy cluster name.
+	Cluster string `json:"cluster"`
+	// StatName is the name Envoy uses for the cluster's stats, if it's different from the
+	// cluster name.
+	StatName string `json:"stat_name,omitempty"`
+	// Resolver is "k8s", "consul", or "plugin" for EDS clusters, or "dns" for clusters that
+	// Envoy resolves itself.
+	Resolver string `json:"resolver,omitempty"`
+	// Datacenter is the Consul datacenter for Consul clusters.
+	Datacenter string `json:"datacenter,omitempty"`
+	// Service is the upstream service, as its resolver knows it. Namespace is only set if the
+	// service is a Kubernetes Service.
+	Namespace string `json:"namespace,omitempty"`
+	Service   string `json:"service,omitempty"`
+	Port      string `json:"port,omitempty"`
+	// Mappings lists the Mappings that the cluster was generated for.
+	Mappings []MappingRef `json:"mappings,omitempty"`
+	// Routes lists all the routes that send traffic to this cluster.
+	Routes []RouteRef `json:"routes,omitempty"`
+}
+
+// ServiceKey returns the "namespace/service" key for the cluster's Kubernetes Service, or "" if
+// it doesn't route to one.
+func (cs *ClusterSource) ServiceKey() string {
+	if cs.Service == "" || cs.Namespace == "" {
+		return ""
+	}
+	return cs.Namespace + "/" + cs.Service
+}
+
+// ClustermapEntry is what diagd records about a cluster in the clustermap.json that it writes
+// next to Envoy's bootstrap config.
+type ClustermapEntry struct {
+	// Kind is the kind of resolver that the cluster uses.
+	Kind       string `json:"kind"`
+	Service    string `json:"service,omitempty"`
+	Port       int    `json:"port,omitempty"`
+	Datacenter string `json:"datacenter,omitempty"`
+	// K8sService is the Kubernetes Service that the cluster routes to, if diagd found one.
+	K8sService *ServiceRef  `json:"k8s_service,omitempty"`
+	Mappings   []MappingRef `json:"mappings,omitempty"`
+}
+
+// ServiceRef names a Kubernetes Service.
+type ServiceRef struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+// LoadClustermap reads the clustermap that diagd wrote to filename.
+func LoadClustermap(filename string) (map[string]*ClustermapEntry, error) {
+	bytes, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var clustermap map[string]*ClustermapEntry
+	if err := json.Unmarshal(bytes, &clustermap); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return clustermap, nil
+}
+
+// RouteRef identifies a single route in a RouteConfiguration.
+type RouteRef struct {
+	RouteConfiguration string `json:"route_configuration"`
+	VirtualHost        string `json:"virtual_host"`
+	Match              string `json:"match"`
+	Weight             uint32 `json:"weight,omitempty"`
+}
+
+// Introspection indexes the clusters in a snapshot by cluster name, stat name, service, and the
+// domains of the virtual hosts that route to them.
+type Introspection struct {
+	Version string
+
+	byCluster  map[string]*ClusterSource
+	byStatName map[string]*ClusterSource
+	byService  map[string][]*ClusterSource
+	byMapping  map[MappingRef][]*ClusterSource
+	byDomain   map[string][]*ClusterSource
+}
+
+// NewIntrospection builds the Introspection for a snapshot, from what the clustermap says about
+// its clusters.
+func NewIntrospection(version string, snapshot *ecp_v3_cache.Snapshot, clustermap map[string]*ClustermapEntry) *Introspection {
+	in := &Introspection{
+		Version:    version,
+		byCluster:  map[string]*ClusterSource{},
+		byStatName: map[string]*ClusterSource{},
+		byService:  map[string][]*ClusterSource{},
+		byMapping:  map[MappingRef][]*ClusterSource{},
+		byDomain:   map[string][]*ClusterSource{},
+	}
+	if snapshot == nil {
+		return in
+	}
+
+	for _, item := range snapshot.Resources[ecp_cache_types.Cluster].Items {
+		c, ok := item.Resource.(*v3clusterconfig.Cluster)
+		if !ok {
+			continue
+		}
+		cs := clusterSource(c, clustermap[c.Name])
+		in.byCluster[cs.Cluster] = cs
+		if cs.StatName != "" {
+			in.byStatName[cs.StatName] = cs
+		}
+	}
+
+	for _, item := range snapshot.Resources[ecp_cache_types.Route].Items {
+		rc, ok := item.Resource.(*v3routeconfig.RouteConfiguration)
+		if !ok {
+			continue
+		}
+		for _, vh := range rc.VirtualHosts {
+			for _, route := range vh.Routes {
+				in.addRoute(rc.Name, vh, route)
+			}
+		}
+	}
+
+	for _, cs := range in.byCluster {
+		routes := cs.Routes
+		sort.Slice(routes, func(i, j int) bool {
+			if routes[i].RouteConfiguration != routes[j].RouteConfiguration {
+				return routes[i].RouteConfiguration < routes[j].RouteConfiguration
+			}
+			if routes[i].VirtualHost != routes[j].VirtualHost {
+				return routes[i].VirtualHost < routes[j].VirtualHost
+			}
+			return routes[i].Match < routes[j].Match
+		})
+		if key := cs.ServiceKey(); key != "" {
+			in.byService[key] = append(in.byService[key], cs)
+		}
+		for _, m := range cs.Mappings {
+			in.byMapping[m] = append(in.byMapping[m], cs)
+		}
+	}
+	for _, sources := range in.byService {
+		sort.Slice(sources, func(i, j int) bool { return sources[i].Cluster < sources[j].Cluster })
+	}
+	for _, sources := range in.byMapping {
+		sort.Slice(sources, func(i, j int) bool { return sources[i].Cluster < sources[j].Cluster })
+	}
+	for _, sources := range in.byDomain {
+		sort.Slice(sources, func(i, j int) bool { return sources[i].Cluster < sources[j].Cluster })
+	}
+
+	return in
+}
+
+func (in *Introspection) addRoute(rcName string, vh *v3routeconfig.VirtualHost, route *v3routeconfig.Route) {
+	action := route.GetRoute()
+	if action == nil {
+		return
+	}
+
+	ref := RouteRef{
+		RouteConfiguration: rcName,
+		VirtualHost:        vh.Name,
+		Match:              routeMatchString(route.GetMatch()),
+	}
+
+	if name := action.GetCluster(); name != "" {
+		if cs, ok := in.byCluster[name]; ok {
+			cs.Routes = append(cs.Routes, ref)
+			in.addDomains(vh.Domains, cs)
+		}
+	}
+	for _, wc := range action.GetWeightedClusters().GetClusters() {
+		if cs, ok := in.byCluster[wc.Name]; ok {
+			weighted := ref
+			weighted.Weight = wc.GetWeight().GetValue()
+			cs.Routes = append(cs.Routes, weighted)
+			in.addDomains(vh.Domains, cs)
+		}
+	}
+}
+
+func (in *Introspection) addDomains(domains []string, cs *ClusterSource) {
+	for _, domain := range domains {
+		found := false
+		for _, other := range in.byDomain[domain] {
+			if other == cs {
+				found = true
+				break
+			}
+		}
+		if !found {
+			in.byDomain[domain] = append(in.byDomain[domain], cs)
+		}
+	}
+}
+
+func routeMatchString(m *v3routeconfig.RouteMatch) string {
+	switch {
+	case m == nil:
+		return ""
+	case m.GetPrefix() != "":
+		return "prefix:" + m.GetPrefix()
+	case m.GetPath() != "":
+		return "path:" + m.GetPath()
+	case m.GetSafeRegex() != nil:
+		return "regex:" + m.GetSafeRegex().GetRegex()
+	default:
+		return ""
+	}
+}
+
+// resolverNames maps the kinds of resolver in the clustermap to ClusterSource.Resolver.
+var resolverNames = map[string]string{
+	"KubernetesEndpointResolver": "k8s",
+	"KubernetesServiceResolver":  "dns",
+	"ConsulResolver":             "consul",
+	"PluginResolver":             "plugin",
+}
+
+// clusterSource works out where a cluster came from: from its clustermap entry, if it has one, or
+// else from its EDS service name, which is "k8s/<namespace>/<service>[/<port>]" or
+// "consul/<datacenter>/<service>".
+func clusterSource(c *v3clusterconfig.Cluster, entry *ClustermapEntry) *ClusterSource {
+	cs := &ClusterSource{
+		Cluster:  c.Name,
+		StatName: c.AltStatName,
+	}
+
+	if entry != nil {
+		cs.Resolver = resolverNames[entry.Kind]
+		cs.Datacenter = entry.Datacenter
+		cs.Mappings = entry.Mappings
+		if entry.Port != 0 {
+			cs.Port = strconv.Itoa(entry.Port)
+		}
+		switch {
+		case entry.K8sService != nil:
+			cs.Namespace = entry.K8sService.Namespace
+			cs.Service = entry.K8sService.Name
+		case cs.Resolver == "dns":
+			// It's not a Service, so the best name we have is whatever Envoy resolves.
+			cs.Service = dnsHostname(c)
+		case cs.Resolver != "k8s":
+			cs.Service = entry.Service
+		}
+		return cs
+	}
+
+	if eds := c.GetEdsClusterConfig(); eds != nil {
+		parts := strings.Split(eds.ServiceName, "/")
+		switch {
+		case len(parts) >= 3 && parts[0] == "k8s":
+			cs.Resolver = "k8s"
+			cs.Namespace = parts[1]
+			cs.Service = parts[2]
+			if len(parts) >= 4 {
+				cs.Port = parts[3]
+			}
+		case len(parts) >= 3 && parts[0] == "consul":
+			cs.Resolver = "consul"
+			cs.Datacenter = parts[1]
+			cs.Service = parts[2]
+		}
+	}
+
+	return cs
+}
+
+// dnsHostname returns the hostname that Envoy resolves for a DNS cluster.
+func dnsHostname(c *v3clusterconfig.Cluster) string {
+	for _, lle := range c.GetLoadAssignment().GetEndpoints() {
+		for _, lbe := range lle.GetLbEndpoints() {
+			if sa := lbe.GetEndpoint().GetAddress().GetSocketAddress(); sa != nil {
+				return sa.GetAddress()
+			}
+		}
+	}
+	return ""
+}
+
+// LookupCluster returns the source of a cluster, given either its Envoy name or its stat name.
+func (in *Introspection) LookupCluster(name string) (*ClusterSource, bool) {
+	if cs, ok := in.byCluster[name]; ok {
+		return cs, true
+	}
+	cs, ok := in.byStatName[name]
+	return cs, ok
+}
+
+// LookupService returns all the clusters that route to the given Service.
+func (in *Introspection) LookupService(namespace, service string) []*ClusterSource {
+	return in.byService[namespace+"/"+service]
+}
+
+// LookupMapping returns all the clusters that were generated for the given Mapping.
+func (in *Introspection) LookupMapping(namespace, name string) []*ClusterSource {
+	return in.byMapping[MappingRef{Name: name, Namespace: namespace}]
+}
+
+// LookupDomain returns all the clusters that a virtual host for the given domain (exactly as the
+// virtual host has it, so "*" is only the wildcard virtual host) routes to.
+func (in *Introspection) LookupDomain(domain string) []*ClusterSource {
+	return in.byDomain[domain]
+}
+
+// Clusters returns every cluster's source, sorted by cluster name.
+func (in *Introspection) Clusters() []*ClusterSource {
+	result := make([]*ClusterSource, 0, len(in.byCluster))
+	for _, cs := range in.byCluster {
+		result = append(result, cs)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Cluster < result[j].Cluster })
+	return result
+}
+
+// MarshalJSON implements json.Marshaler, so that the debug endpoint shows the whole table.
+func (in *Introspection) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Version  string           `json:"version"`
+		Clusters []*ClusterSource `json:"clusters"`
+	}{in.Version, in.Clusters()})
+}
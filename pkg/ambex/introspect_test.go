@@ -0,0 +1,220 @@
+package ambex
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	v3cluster "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/cluster/v3"
+	v3core "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/core/v3"
+	v3endpoint "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/endpoint/v3"
+	v3route "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/route/v3"
+	ecp_cache_types "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/cache/types"
+	ecp_v3_cache "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/cache/v3"
+	ecp_v3_resource "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/resource/v3"
+)
+
+func TestIntrospection(t *testing.T) {
+	clusters := []ecp_cache_types.Resource{
+		&v3cluster.Cluster{
+			Name:        "cluster_quote_default_default",
+			AltStatName: "quote",
+			EdsClusterConfig: &v3cluster.Cluster_EdsClusterConfig{
+				ServiceName: "k8s/default/quote/80",
+			},
+		},
+		&v3cluster.Cluster{
+			// The fastpath's clusters aren't in the clustermap.
+			Name: "cluster_fastpath_default_fast",
+			EdsClusterConfig: &v3cluster.Cluster_EdsClusterConfig{
+				ServiceName: "k8s/default/fast/8080",
+			},
+		},
+		&v3cluster.Cluster{
+			Name: "cluster_consul_dc1_web",
+			EdsClusterConfig: &v3cluster.Cluster_EdsClusterConfig{
+				ServiceName: "consul/dc1/web",
+			},
+		},
+		&v3cluster.Cluster{
+			Name: "cluster_api_example_com",
+			LoadAssignment: &v3endpoint.ClusterLoadAssignment{
+				ClusterName: "cluster_api_example_com",
+				Endpoints: []*v3endpoint.LocalityLbEndpoints{{
+					LbEndpoints: []*v3endpoint.LbEndpoint{{
+						HostIdentifier: &v3endpoint.LbEndpoint_Endpoint{
+							Endpoint: &v3endpoint.Endpoint{
+								Address: &v3core.Address{
+									Address: &v3core.Address_SocketAddress{
+										SocketAddress: &v3core.SocketAddress{
+											Address:       "api.example.com",
+											PortSpecifier: &v3core.SocketAddress_PortValue{PortValue: 8080},
+										},
+									},
+								},
+							},
+						},
+					}},
+				}},
+			},
+		},
+	}
+	routes := []ecp_cache_types.Resource{
+		&v3route.RouteConfiguration{
+			Name: "ambassador-listener-8080-routeconfig-0",
+			VirtualHosts: []*v3route.VirtualHost{{
+				Name:    "ambassador-listener-8080-*",
+				Domains: []string{"*"},
+				Routes: []*v3route.Route{
+					{
+						Match: &v3route.RouteMatch{PathSpecifier: &v3route.RouteMatch_Prefix{Prefix: "/quote/"}},
+						Action: &v3route.Route_Route{Route: &v3route.RouteAction{
+							ClusterSpecifier: &v3route.RouteAction_Cluster{Cluster: "cluster_quote_default_default"},
+						}},
+					},
+					{
+						Match: &v3route.RouteMatch{PathSpecifier: &v3route.RouteMatch_Prefix{Prefix: "/split/"}},
+						Action: &v3route.Route_Route{Route: &v3route.RouteAction{
+							ClusterSpecifier: &v3route.RouteAction_WeightedClusters{
+								WeightedClusters: &v3route.WeightedCluster{
+									Clusters: []*v3route.WeightedCluster_ClusterWeight{
+										{Name: "cluster_quote_default_default", Weight: wrapperspb.UInt32(10)},
+										{Name: "cluster_api_example_com", Weight: wrapperspb.UInt32(90)},
+									},
+								},
+							},
+						}},
+					},
+				},
+			}},
+		},
+	}
+	snapshot, err := ecp_v3_cache.NewSnapshot("v1", map[ecp_v3_resource.Type][]ecp_cache_types.Resource{
+		ecp_v3_resource.ClusterType: clusters,
+		ecp_v3_resource.RouteType:   routes,
+	})
+	require.NoError(t, err)
+
+	quote := MappingRef{Name: "quote", Namespace: "default"}
+	split := MappingRef{Name: "split", Namespace: "default"}
+	clustermap := map[string]*ClustermapEntry{
+		"cluster_quote_default_default": {
+			Kind:       "KubernetesEndpointResolver",
+			Service:    "quote",
+			Port:       80,
+			K8sService: &ServiceRef{Name: "quote", Namespace: "default"},
+			Mappings:   []MappingRef{quote, split},
+		},
+		"cluster_consul_dc1_web": {
+			Kind:       "ConsulResolver",
+			Service:    "web",
+			Datacenter: "dc1",
+		},
+		"cluster_api_example_com": {
+			// api.example.com isn't service api in namespace example, so diagd says nothing
+			// about a Kubernetes Service.
+			Kind:     "KubernetesServiceResolver",
+			Service:  "api",
+			Port:     8080,
+			Mappings: []MappingRef{split},
+		},
+	}
+
+	in := NewIntrospection("v1", snapshot, clustermap)
+
+	// By cluster name...
+	cs, ok := in.LookupCluster("cluster_quote_default_default")
+	require.True(t, ok)
+	assert.Equal(t, "k8s", cs.Resolver)
+	assert.Equal(t, "default/quote", cs.ServiceKey())
+	assert.Equal(t, "80", cs.Port)
+	require.Len(t, cs.Routes, 2)
+	assert.Equal(t, "prefix:/quote/", cs.Routes[0].Match)
+	assert.Equal(t, "prefix:/split/", cs.Routes[1].Match)
+	assert.Equal(t, uint32(10), cs.Routes[1].Weight)
+
+	// ...by stat name...
+	cs, ok = in.LookupCluster("quote")
+	require.True(t, ok)
+	assert.Equal(t, "cluster_quote_default_default", cs.Cluster)
+
+	// ...for Consul, which has no namespace...
+	cs, ok = in.LookupCluster("cluster_consul_dc1_web")
+	require.True(t, ok)
+	assert.Equal(t, "consul", cs.Resolver)
+	assert.Equal(t, "dc1", cs.Datacenter)
+	assert.Equal(t, "web", cs.Service)
+	assert.Equal(t, "", cs.ServiceKey())
+	assert.Empty(t, in.LookupService("", "web"))
+
+	// ...for DNS clusters that aren't Services...
+	cs, ok = in.LookupCluster("cluster_api_example_com")
+	require.True(t, ok)
+	assert.Equal(t, "dns", cs.Resolver)
+	assert.Equal(t, "api.example.com", cs.Service)
+	assert.Equal(t, "", cs.ServiceKey())
+	assert.Equal(t, "8080", cs.Port)
+	assert.Empty(t, in.LookupService("example.com", "api"))
+
+	// ...and for clusters that the clustermap doesn't have.
+	cs, ok = in.LookupCluster("cluster_fastpath_default_fast")
+	require.True(t, ok)
+	assert.Equal(t, "k8s", cs.Resolver)
+	assert.Equal(t, "default/fast", cs.ServiceKey())
+	assert.Empty(t, cs.Mappings)
+
+	_, ok = in.LookupCluster("nonexistent")
+	assert.False(t, ok)
+
+	// And the other way around.
+	sources := in.LookupService("default", "quote")
+	require.Len(t, sources, 1)
+	assert.Equal(t, "cluster_quote_default_default", sources[0].Cluster)
+	assert.Len(t, in.Clusters(), 4)
+
+	sources = in.LookupMapping("default", "split")
+	require.Len(t, sources, 2)
+	assert.Equal(t, "cluster_api_example_com", sources[0].Cluster)
+	assert.Equal(t, "cluster_quote_default_default", sources[1].Cluster)
+	assert.Empty(t, in.LookupMapping("default", "nonexistent"))
+
+	// The wildcard virtual host routes to quote (twice) and api, but not to web.
+	sources = in.LookupDomain("*")
+	require.Len(t, sources, 2)
+	assert.Equal(t, "cluster_api_example_com", sources[0].Cluster)
+	assert.Equal(t, "cluster_quote_default_default", sources[1].Cluster)
+	assert.Empty(t, in.LookupDomain("example.com"))
+}
+
+func TestLoadClustermap(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "clustermap.json")
+	require.NoError(t, os.WriteFile(filename, []byte(`{
+		"cluster_quote_default_default": {
+			"kind": "KubernetesEndpointResolver",
+			"service": "quote",
+			"namespace": "default",
+			"port": 80,
+			"endpoint_path": "k8s/default/quote/80",
+			"k8s_service": {"name": "quote", "namespace": "default"},
+			"mappings": [{"name": "quote", "namespace": "default"}]
+		}
+	}`), 0644))
+
+	clustermap, err := LoadClustermap(filename)
+	require.NoError(t, err)
+	entry := clustermap["cluster_quote_default_default"]
+	require.NotNil(t, entry)
+	assert.Equal(t, "KubernetesEndpointResolver", entry.Kind)
+	assert.Equal(t, 80, entry.Port)
+	require.NotNil(t, entry.K8sService)
+	assert.Equal(t, "default", entry.K8sService.Namespace)
+	assert.Equal(t, []MappingRef{{Name: "quote", Namespace: "default"}}, entry.Mappings)
+
+	_, err = LoadClustermap(filepath.Join(t.TempDir(), "nonexistent.json"))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
@@ -0,0 +1,168 @@
+package ambex
+
+import (
+	// third-party libraries
+	"google.golang.org/protobuf/proto"
+
+	// envoy api v3
+	v3listener "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/listener/v3"
+
+	// envoy control plane
+	ecp_cache_types "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/cache/types"
+)
+
+// Listener updates: when a listener changes, Envoy drains it, which closes every connection that it
+// has open (after the drain time). If only the listener's filter chains changed, Envoy updates it
+// in place, and drains only the filter chains that changed. And if the listener doesn't change at
+// all -- because all that changed was a route (see V3ListenerToRdsListener), a certificate (see
+// V3ListenerToSdsListener), a cluster, or some endpoints -- nothing gets drained.
+//
+// So every time we hand Envoy a new configuration, we work out which of those it was, by comparing
+// its listeners to the last configuration's, and count them. The counts are in the
+// ListenerUpdatesDebugValue, and in /metrics.
+
+// ListenerUpdatesDebugValue is the name of the debug value holding the ListenerUpdates so far.
+const ListenerUpdatesDebugValue = "listenerUpdates"
+
+// ListenerUpdates counts the configuration updates handed to Envoy, by what they did to its
+// listeners. Updates that didn't change anything at all aren't counted.
+type ListenerUpdates struct {
+	// Hitless updates didn't drain anything.
+	Hitless uint64 `json:"hitless"`
+	// FilterChains updates drained some filter chains, but no whole listener.
+	FilterChains uint64 `json:"filterChains"`
+	// Full updates drained at least one whole listener.
+	Full uint64 `json:"full"`
+
+	// Listeners counts the drains by listener.
+	Listeners map[string]ListenerDrains `json:"listeners,omitempty"`
+}
+
+// ListenerDrains counts the updates that drained a listener, or some of its filter chains.
+type ListenerDrains struct {
+	Full         uint64 `json:"full"`
+	FilterChains uint64 `json:"filterChains"`
+	// ChainsDrained is how many filter chains the FilterChains updates drained between them.
+	ChainsDrained uint64 `json:"chainsDrained"`
+}
+
+// listenerChange is what an update did to one listener.
+type listenerChange struct {
+	// Full is true if Envoy has to drain the whole listener.
+	Full bool
+	// ChainsDrained is how many filter chains Envoy has to drain if it isn't Full.
+	ChainsDrained int
+}
+
+// compareListeners works out what changing prev to next does to a listener. A nil prev is a new
+// listener, which doesn't drain anything; a nil next is one that's gone, which drains all of it.
+func compareListeners(prev, next *v3listener.Listener) listenerChange {
+	switch {
+	case prev == nil:
+		return listenerChange{}
+	case next == nil:
+		return listenerChange{Full: true}
+	case proto.Equal(prev, next):
+		return listenerChange{}
+	}
+
+	// This is what Envoy checks to decide whether it can update the listener in place.
+	withoutChains := func(l *v3listener.Listener) *v3listener.Listener {
+		l = proto.Clone(l).(*v3listener.Listener)
+		l.FilterChains = nil
+		l.DefaultFilterChain = nil
+		l.FilterChainMatcher = nil
+		return l
+	}
+	if !proto.Equal(withoutChains(prev), withoutChains(next)) {
+		return listenerChange{Full: true}
+	}
+
+	// Envoy keeps the filter chains that are still exactly the same, and drains the rest.
+	drained := 0
+	for _, old := range prev.FilterChains {
+		kept := false
+		for _, fc := range next.FilterChains {
+			if proto.Equal(old, fc) {
+				kept = true
+				break
+			}
+		}
+		if !kept {
+			drained++
+		}
+	}
+	if prev.DefaultFilterChain != nil && !proto.Equal(prev.DefaultFilterChain, next.DefaultFilterChain) {
+		drained++
+	}
+	return listenerChange{ChainsDrained: drained}
+}
+
+// listenerUpdateTracker remembers the last configuration's listeners, to compare the next one's to.
+// It's only used by the goroutine that hands configurations to Envoy, so it needs no locking.
+type listenerUpdateTracker struct {
+	hash      string
+	listeners map[string]*v3listener.Listener
+	counts    ListenerUpdates
+}
+
+// record counts the update to the configuration with the given hash and listeners, and returns the
+// counts so far. The returned ListenerUpdates is never modified afterwards.
+func (t *listenerUpdateTracker) record(hash string, listeners []ecp_cache_types.Resource) ListenerUpdates {
+	next := make(map[string]*v3listener.Listener, len(listeners))
+	for _, r := range listeners {
+		if l, ok := r.(*v3listener.Listener); ok {
+			next[l.Name] = l
+		}
+	}
+
+	first := t.listeners == nil
+	unchanged := hash == t.hash
+	prev := t.listeners
+	t.hash, t.listeners = hash, next
+	if first || unchanged {
+		return t.counts
+	}
+
+	counts := t.counts
+	counts.Listeners = make(map[string]ListenerDrains, len(t.counts.Listeners))
+	for name, drains := range t.counts.Listeners {
+		counts.Listeners[name] = drains
+	}
+
+	full, partial := false, false
+	count := func(name string, change listenerChange) {
+		drains := counts.Listeners[name]
+		switch {
+		case change.Full:
+			full = true
+			drains.Full++
+		case change.ChainsDrained > 0:
+			partial = true
+			drains.FilterChains++
+			drains.ChainsDrained += uint64(change.ChainsDrained)
+		default:
+			return
+		}
+		counts.Listeners[name] = drains
+	}
+	for name, l := range next {
+		count(name, compareListeners(prev[name], l))
+	}
+	for name, l := range prev {
+		if _, ok := next[name]; !ok {
+			count(name, compareListeners(l, nil))
+		}
+	}
+
+	switch {
+	case full:
+		counts.Full++
+	case partial:
+		counts.FilterChains++
+	default:
+		counts.Hitless++
+	}
+	t.counts = counts
+	return counts
+}
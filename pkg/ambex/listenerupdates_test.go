@@ -0,0 +1,73 @@
+package ambex
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	v3listener "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/listener/v3"
+	ecp_cache_types "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/cache/types"
+)
+
+func testListener(name string, chains ...string) *v3listener.Listener {
+	l := &v3listener.Listener{Name: name}
+	for _, chain := range chains {
+		l.FilterChains = append(l.FilterChains, &v3listener.FilterChain{Name: chain})
+	}
+	return l
+}
+
+func TestCompareListeners(t *testing.T) {
+	l := testListener("l", "a", "b")
+
+	assert.Equal(t, listenerChange{}, compareListeners(nil, l))
+	assert.Equal(t, listenerChange{Full: true}, compareListeners(l, nil))
+	assert.Equal(t, listenerChange{}, compareListeners(l, testListener("l", "a", "b")))
+
+	// Swapping one filter chain for another drains just that one.
+	assert.Equal(t, listenerChange{ChainsDrained: 1}, compareListeners(l, testListener("l", "a", "c")))
+	assert.Equal(t, listenerChange{ChainsDrained: 0}, compareListeners(l, testListener("l", "a", "b", "c")))
+
+	// Anything else drains the whole listener.
+	changed := testListener("l", "a", "b")
+	changed.PerConnectionBufferLimitBytes = wrapperspb.UInt32(1024)
+	assert.Equal(t, listenerChange{Full: true}, compareListeners(l, changed))
+}
+
+func TestListenerUpdateTracker(t *testing.T) {
+	resources := func(listeners ...*v3listener.Listener) []ecp_cache_types.Resource {
+		var rs []ecp_cache_types.Resource
+		for _, l := range listeners {
+			rs = append(rs, l)
+		}
+		return rs
+	}
+
+	var tracker listenerUpdateTracker
+	assert.Equal(t, ListenerUpdates{}, tracker.record("h0", nil))
+
+	// Adding listeners, or changing something other than a listener, is hitless.
+	counts := tracker.record("h1", resources(testListener("l1", "a"), testListener("l2", "a")))
+	assert.Equal(t, uint64(1), counts.Hitless)
+	counts = tracker.record("h2", resources(testListener("l1", "a"), testListener("l2", "a")))
+	assert.Equal(t, uint64(2), counts.Hitless)
+
+	// An update that's no change at all isn't counted.
+	counts = tracker.record("h2", resources(testListener("l1", "a"), testListener("l2", "a")))
+	assert.Equal(t, uint64(2), counts.Hitless)
+
+	counts = tracker.record("h3", resources(testListener("l1", "b"), testListener("l2", "a")))
+	assert.Equal(t, uint64(1), counts.FilterChains)
+	assert.Equal(t, ListenerDrains{FilterChains: 1, ChainsDrained: 1}, counts.Listeners["l1"])
+
+	// Removing a listener drains it.
+	before := counts
+	counts = tracker.record("h4", resources(testListener("l1", "b")))
+	assert.Equal(t, uint64(1), counts.Full)
+	assert.Equal(t, ListenerDrains{Full: 1}, counts.Listeners["l2"])
+
+	// What record returned before doesn't change.
+	assert.Equal(t, uint64(0), before.Full)
+	assert.Equal(t, ListenerDrains{}, before.Listeners["l2"])
+}
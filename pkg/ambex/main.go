@@ -41,9 +41,12 @@ package ambex
 import (
 	// standard library
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
 	"io/ioutil"
 	"net"
 	"os"
@@ -109,6 +112,7 @@ import (
 	"github.com/datawire/dlib/dgroup"
 	"github.com/datawire/dlib/dhttp"
 	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/debug"
 )
 
 type Args struct {
@@ -116,15 +120,22 @@ type Args struct {
 
 	adsNetwork string
 	adsAddress string
+	adsTLSDir  string
 
 	dirs []string
 
+	// clustermapPath is where diagd writes its clustermap (see introspect.go)
+	clustermapPath string
+
 	snapdirPath string
 	numsnaps    int
 
 	// edsBypass will bypass using EDS and will insert the endpoints into the cluster data manually
 	// This is a stop gap solution to resolve 503s on certification rotation
 	edsBypass bool
+
+	// limits are the size and cardinality guardrails applied to every snapshot (see guardrails.go)
+	limits Limits
 }
 
 func parseArgs(ctx context.Context, rawArgs ...string) (*Args, error) {
@@ -136,6 +147,9 @@ func parseArgs(ctx context.Context, rawArgs ...string) (*Args, error) {
 	// TODO(lukeshu): Consider changing the default here so we don't need to put it in entrypoint.sh
 	flagset.StringVar(&args.adsNetwork, "ads-listen-network", "tcp", "network for ADS to listen on")
 	flagset.StringVar(&args.adsAddress, "ads-listen-address", ":18000", "address (on --ads-listen-network) for ADS to listen on")
+	flagset.StringVar(&args.adsTLSDir, "ads-tls-dir", "", "directory holding the CA and certificates to serve ADS with mutual TLS; empty means cleartext")
+
+	flagset.StringVar(&args.clustermapPath, "clustermap", "", "clustermap.json that diagd writes, to say where each cluster came from; empty means none")
 
 	var legacyAdsPort uint
 	flagset.UintVar(&legacyAdsPort, "ads", 0, "port number for ADS to listen on--deprecated, use --ads-listen-address=:1234 instead")
@@ -188,6 +202,11 @@ func parseArgs(ctx context.Context, rawArgs ...string) (*Args, error) {
 		args.edsBypass = v
 	}
 
+	args.limits = GetLimits(ctx)
+	if args.limits.Enabled() {
+		dlog.Infof(ctx, "Config limits in %s mode: %+v", args.limits.Mode, args.limits)
+	}
+
 	return &args, nil
 }
 
@@ -207,9 +226,17 @@ func (h HasherV3) ID(node *v3core.Node) string {
 
 // run stuff
 // RunManagementServer starts an xDS server at the given port.
-func runManagementServer(ctx context.Context, serverv3 ecp_v3_server.Server, adsNetwork, adsAddress string) error {
+func runManagementServer(ctx context.Context, serverv3 ecp_v3_server.Server, adsNetwork, adsAddress, adsTLSDir string) error {
 	grpcServer := grpc.NewServer()
 
+	var tlsConfig *tls.Config
+	if adsTLSDir != "" {
+		var err error
+		if tlsConfig, err = adsTLSConfig(adsTLSDir); err != nil {
+			return fmt.Errorf("failed to set up TLS: %w", err)
+		}
+	}
+
 	lis, err := net.Listen(adsNetwork, adsAddress)
 	if err != nil {
 		return fmt.Errorf("failed to listen: %w", err)
@@ -222,11 +249,15 @@ func runManagementServer(ctx context.Context, serverv3 ecp_v3_server.Server, ads
 	v3route.RegisterRouteDiscoveryServiceServer(grpcServer, serverv3)
 	v3listener.RegisterListenerDiscoveryServiceServer(grpcServer, serverv3)
 
-	dlog.Infof(ctx, "Listening on %s:%s", adsNetwork, adsAddress)
-
 	sc := &dhttp.ServerConfig{
 		Handler: grpcServer,
 	}
+	if tlsConfig != nil {
+		dlog.Infof(ctx, "Listening on %s:%s with mutual TLS", adsNetwork, adsAddress)
+		sc.TLSConfig = tlsConfig
+		return sc.ServeTLS(ctx, lis, "", "")
+	}
+	dlog.Infof(ctx, "Listening on %s:%s", adsNetwork, adsAddress)
 	return sc.Serve(ctx, lis)
 }
 
@@ -290,6 +321,7 @@ type v3ExpandedSnapshot struct {
 	Clusters  ecp_v3_cache.Resources `json:"clusters"`
 	Routes    ecp_v3_cache.Resources `json:"routes"`
 	Listeners ecp_v3_cache.Resources `json:"listeners"`
+	Secrets   ecp_v3_cache.Resources `json:"secrets"`
 	Runtimes  ecp_v3_cache.Resources `json:"runtimes"`
 }
 
@@ -299,6 +331,7 @@ func NewV3ExpandedSnapshot(v3snap *ecp_v3_cache.Snapshot) v3ExpandedSnapshot {
 		Clusters:  v3snap.Resources[ecp_cache_types.Cluster],
 		Routes:    v3snap.Resources[ecp_cache_types.Route],
 		Listeners: v3snap.Resources[ecp_cache_types.Listener],
+		Secrets:   v3snap.Resources[ecp_cache_types.Secret],
 		Runtimes:  v3snap.Resources[ecp_cache_types.Runtime],
 	}
 }
@@ -306,6 +339,7 @@ func NewV3ExpandedSnapshot(v3snap *ecp_v3_cache.Snapshot) v3ExpandedSnapshot {
 // A combinedSnapshot has both a V2 and V3 snapshot, for logging.
 type combinedSnapshot struct {
 	Version string             `json:"version"`
+	Hash    string             `json:"hash"`
 	V3      v3ExpandedSnapshot `json:"v3"`
 }
 
@@ -314,7 +348,7 @@ type combinedSnapshot struct {
 // is the newest, then ambex-2.json, etc., so ambex-$numsnaps.json is the oldest.
 // Every time we write a new one, we rename all the older ones, ditching the oldest
 // after we've written numsnaps snapshots.
-func csDump(ctx context.Context, snapdirPath string, numsnaps int, generation int, v3snap *ecp_v3_cache.Snapshot) {
+func csDump(ctx context.Context, snapdirPath string, numsnaps int, generation int, hash string, v3snap *ecp_v3_cache.Snapshot) {
 	if numsnaps <= 0 {
 		// Don't do snapshotting at all.
 		return
@@ -326,6 +360,7 @@ func csDump(ctx context.Context, snapdirPath string, numsnaps int, generation in
 	// ...and a combinedSnapshot.
 	cs := combinedSnapshot{
 		Version: version,
+		Hash:    hash,
 		V3:      NewV3ExpandedSnapshot(v3snap),
 	}
 
@@ -372,17 +407,21 @@ func update(
 	snapdirPath string,
 	numsnaps int,
 	edsBypass bool,
+	limits Limits,
 	configv3 ecp_v3_cache.SnapshotCache,
 	generation *int,
 	dirs []string,
+	clustermapPath string,
 	edsEndpointsV3 map[string]*v3endpointconfig.ClusterLoadAssignment,
 	fastpathSnapshot *FastpathSnapshot,
+	listenerUpdates *listenerUpdateTracker,
 	updates chan<- Update,
 ) error {
 
 	clustersv3 := []ecp_cache_types.Resource{}  // v3.Cluster
 	routesv3 := []ecp_cache_types.Resource{}    // v3.RouteConfiguration
 	listenersv3 := []ecp_cache_types.Resource{} // v3.Listener
+	secretsv3 := []ecp_cache_types.Resource{}   // v3.Secret
 	runtimesv3 := []ecp_cache_types.Resource{}  // v3.Runtime
 
 	var filenames []string
@@ -434,11 +473,22 @@ func update(
 					listenersv3 = append(listenersv3, proto.Clone(lst).(ecp_cache_types.Resource))
 					continue
 				}
-				listenersv3 = append(listenersv3, rdsListener)
 				for _, rc := range routeConfigs {
 					// These routes will get included in the configuration snapshot created below.
 					routesv3 = append(routesv3, rc)
 				}
+				// Likewise, inline certificates make envoy drain the filter chain whenever they're
+				// rotated, so we move them to SDS.
+				sdsListener, secrets, err := V3ListenerToSdsListener(rdsListener)
+				if err != nil {
+					dlog.Errorf(ctx, "Error converting listener to SDS: %+v", err)
+					listenersv3 = append(listenersv3, rdsListener)
+					continue
+				}
+				listenersv3 = append(listenersv3, sdsListener)
+				for _, secret := range secrets {
+					secretsv3 = append(secretsv3, secret)
+				}
 			}
 			for _, cls := range sr.Clusters {
 				clustersv3 = append(clustersv3, proto.Clone(cls).(ecp_cache_types.Resource))
@@ -489,6 +539,33 @@ func update(
 	// cluster exists but currently has no endpoints.
 	endpointsv3 := JoinEdsClustersV3(ctx, clustersv3, edsEndpointsV3, edsBypass)
 
+	// Before going any further, make sure we're not about to hand Envoy something enormous.
+	violations := limits.CheckLimits(clustersv3, routesv3, listenersv3, endpointsv3, secretsv3, runtimesv3)
+	var rejected []Rejection
+	if len(violations) > 0 {
+		for _, v := range violations {
+			dlog.Errorf(ctx, "Config limit exceeded: %s", v)
+		}
+		if limits.Mode == LimitsModeEnforce {
+			enforced := limits.Enforce(clustersv3, routesv3, func(clusters []ecp_cache_types.Resource) []ecp_cache_types.Resource {
+				return JoinEdsClustersV3(ctx, clusters, edsEndpointsV3, edsBypass)
+			}, listenersv3, secretsv3, runtimesv3)
+			clustersv3, routesv3, endpointsv3 = enforced.Clusters, enforced.Routes, enforced.Endpoints
+			rejected, violations = enforced.Rejected, enforced.Violations
+			for _, r := range rejected {
+				dlog.Errorf(ctx, "Rejecting Mapping %s to stay within %s", r.Mapping, r.Limit)
+			}
+			for _, v := range violations {
+				dlog.Errorf(ctx, "Config limit still exceeded, with no Mapping left to reject for it: %s", v)
+			}
+		}
+	}
+	debug.FromContext(ctx).Value("configLimitViolations").Store(violations)
+	debug.FromContext(ctx).Value("configLimitRejections").Store(rejected)
+	if handler := rejectionHandlerFromContext(ctx); handler != nil {
+		handler(rejected)
+	}
+
 	// Create a new configuration snapshot from everything we have just loaded from disk.
 	curgen := *generation
 	*generation++
@@ -500,6 +577,7 @@ func update(
 		ecp_v3_resource.ClusterType:  clustersv3,
 		ecp_v3_resource.RouteType:    routesv3,
 		ecp_v3_resource.ListenerType: listenersv3,
+		ecp_v3_resource.SecretType:   secretsv3,
 		ecp_v3_resource.RuntimeType:  runtimesv3,
 	}
 
@@ -519,17 +597,40 @@ func update(
 	// Update object down the channel with a function that knows how to do the update if/when
 	// the ratelimiting logic decides.
 
-	dlog.Debugf(ctx, "Created snapshot %s", version)
-	csDump(ctx, snapdirPath, numsnaps, curgen, snapshot)
+	hash := ConfigHash(snapshot)
 
-	update := Update{version, func() error {
-		dlog.Debugf(ctx, "Accepting snapshot %s", version)
+	dlog.Debugf(ctx, "Created snapshot %s (hash %s)", version, hash)
+	csDump(ctx, snapdirPath, numsnaps, curgen, hash, snapshot)
+
+	// diagd writes the clustermap before it tells us to update, so it goes with these clusters.
+	var clustermap map[string]*ClustermapEntry
+	if clustermapPath != "" {
+		cm, err := LoadClustermap(clustermapPath)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			// diagd hasn't written one yet.
+		case err != nil:
+			dlog.Warnf(ctx, "Cluster introspection: %v", err)
+		default:
+			clustermap = cm
+		}
+	}
+
+	update := Update{version, hash, func() error {
+		dlog.Debugf(ctx, "Accepting snapshot %s (hash %s)", version, hash)
 
 		err = configv3.SetSnapshot(ctx, "test-id", snapshot)
 		if err != nil {
 			return fmt.Errorf("v3 Snapshot error %q for %+v", err, snapshot)
 		}
 
+		// Now that Envoy has this configuration, make it possible to find out where its
+		// clusters came from.
+		debug.FromContext(ctx).Value(IntrospectionDebugValue).Store(NewIntrospection(version, snapshot, clustermap))
+
+		// And count what it did to Envoy's listeners.
+		debug.FromContext(ctx).Value(ListenerUpdatesDebugValue).Store(listenerUpdates.record(hash, listenersv3))
+
 		return nil
 	}}
 
@@ -678,7 +779,7 @@ func Main(
 	grp := dgroup.NewGroup(ctx, dgroup.GroupConfig{})
 
 	grp.Go("management-server", func(ctx context.Context) error {
-		return runManagementServer(ctx, serverv3, args.adsNetwork, args.adsAddress)
+		return runManagementServer(ctx, serverv3, args.adsNetwork, args.adsAddress, args.adsTLSDir)
 	})
 
 	pid := os.Getpid()
@@ -698,6 +799,7 @@ func Main(
 	grp.Go("main-loop", func(ctx context.Context) error {
 		generation := 0
 		var fastpathSnapshot *FastpathSnapshot
+		listenerUpdates := &listenerUpdateTracker{}
 		edsEndpointsV3 := map[string]*v3endpointconfig.ClusterLoadAssignment{}
 
 		// We always start by updating with a totally empty snapshot.
@@ -709,11 +811,14 @@ func Main(
 			args.snapdirPath,
 			args.numsnaps,
 			args.edsBypass,
+			args.limits,
 			configv3,
 			&generation,
 			args.dirs,
+			args.clustermapPath,
 			edsEndpointsV3,
 			fastpathSnapshot,
+			listenerUpdates,
 			updates,
 		)
 		if err != nil {
@@ -730,11 +835,14 @@ func Main(
 					args.snapdirPath,
 					args.numsnaps,
 					args.edsBypass,
+					args.limits,
 					configv3,
 					&generation,
 					args.dirs,
+					args.clustermapPath,
 					edsEndpointsV3,
 					fastpathSnapshot,
+					listenerUpdates,
 					updates,
 				)
 				if err != nil {
@@ -751,11 +859,14 @@ func Main(
 					args.snapdirPath,
 					args.numsnaps,
 					args.edsBypass,
+					args.limits,
 					configv3,
 					&generation,
 					args.dirs,
+					args.clustermapPath,
 					edsEndpointsV3,
 					fastpathSnapshot,
+					listenerUpdates,
 					updates,
 				)
 				if err != nil {
@@ -768,11 +879,14 @@ func Main(
 					args.snapdirPath,
 					args.numsnaps,
 					args.edsBypass,
+					args.limits,
 					configv3,
 					&generation,
 					args.dirs,
+					args.clustermapPath,
 					edsEndpointsV3,
 					fastpathSnapshot,
+					listenerUpdates,
 					updates,
 				)
 				if err != nil {
@@ -7,13 +7,17 @@ import (
 	"time"
 
 	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
 	"github.com/emissary-ingress/emissary/v3/pkg/debug"
+	"github.com/emissary-ingress/emissary/v3/pkg/faultinject"
 )
 
 // An Update encapsulates everything needed to perform an update (of envoy configuration). The
-// version string is for logging purposes, the Updator func does the actual work of updating.
+// version string is for logging purposes, the hash identifies the configuration independent of
+// the version (see ConfigHash), and the Updator func does the actual work of updating.
 type Update struct {
 	Version string
+	Hash    string
 	Update  func() error
 }
 
@@ -24,12 +28,13 @@ type MemoryGetter func() int
 // incoming channel. If memory usage is constrained as reported by the getUsage function, updates
 // will be rate limited to guarantee that there are only so many stale configs in memory at a
 // time. The function assumes updates are cumulative and it will drop old queued updates if a new
-// update arrives.
+// update arrives. It keeps time with the context's clock (see clock.FromContext).
 func Updater(ctx context.Context, updates <-chan Update, getUsage MemoryGetter) error {
 	drainTime := GetAmbassadorDrainTime(ctx)
-	ticker := time.NewTicker(drainTime)
+	clk := clock.FromContext(ctx)
+	ticker := clk.NewTicker(drainTime)
 	defer ticker.Stop()
-	return updaterWithTicker(ctx, updates, getUsage, drainTime, ticker, time.Now)
+	return updaterWithTicker(ctx, updates, getUsage, drainTime, ticker.C(), clk.Now)
 }
 
 type debugInfo struct {
@@ -38,13 +43,16 @@ type debugInfo struct {
 	StaleMax           int         `json:"staleMax"`
 	Synced             bool        `json:"synced"`
 	DisableRatelimiter bool        `json:"disableRatelimiter"`
+	Frozen             bool        `json:"frozen"`
 }
 
 func updaterWithTicker(ctx context.Context, updates <-chan Update, getUsage MemoryGetter,
-	drainTime time.Duration, ticker *time.Ticker, clock func() time.Time) error {
+	drainTime time.Duration, ticker <-chan time.Time, clock func() time.Time) error {
 
 	dbg := debug.FromContext(ctx)
 	info := dbg.Value("envoyReconfigs")
+	hashInfo := dbg.Value("envoyConfigHash")
+	freezer := FreezerFromContext(ctx)
 
 	// Is the rate-limiter meant to be active at all?
 	disableRatelimiter, err := strconv.ParseBool(os.Getenv("AMBASSADOR_AMBEX_NO_RATELIMIT"))
@@ -83,11 +91,18 @@ func updaterWithTicker(ctx context.Context, updates <-chan Update, getUsage Memo
 			pushed = false
 			gotFirst = true
 			now = clock()
-		case now = <-ticker.C:
+		case now = <-ticker:
 			if pushed {
 				continue
 			}
 			tick = true
+		case <-freezer.thawed:
+			// Push whatever has been held back by the freeze.
+			if pushed {
+				continue
+			}
+			now = clock()
+			tick = true
 		case <-ctx.Done():
 			return nil
 		}
@@ -132,7 +147,17 @@ func updaterWithTicker(ctx context.Context, updates <-chan Update, getUsage Memo
 
 		staleReconfigs := len(updateTimes)
 
-		info.Store(debugInfo{updateTimes, staleReconfigs, maxStaleReconfigs, pushed, disableRatelimiter})
+		frozen := freezer.State().Frozen
+		info.Store(debugInfo{updateTimes, staleReconfigs, maxStaleReconfigs, pushed, disableRatelimiter, frozen})
+
+		// While the configuration is frozen, we keep the latest update around but don't push it,
+		// whatever the rate limiter would have said.
+		if gotFirst && freezer.hold(latest, !tick) {
+			if !tick {
+				dlog.Warnf(ctx, "Configuration frozen: holding snapshot %+v (hash %s)", latest.Version, latest.Hash)
+			}
+			continue
+		}
 
 		// Decide if we have enough capacity left to perform a reconfig.
 		if maxStaleReconfigs > 0 && staleReconfigs >= maxStaleReconfigs {
@@ -148,6 +173,12 @@ func updaterWithTicker(ctx context.Context, updates <-chan Update, getUsage Memo
 			continue
 		}
 
+		// In test builds, the push might be made to fail, in which case it's tried again on the next
+		// tick.
+		if faultinject.FailXDSPush(ctx) {
+			continue
+		}
+
 		// This is going to do the actual work of pushing an update.
 		err := latest.Update()
 		if err != nil {
@@ -156,10 +187,11 @@ func updaterWithTicker(ctx context.Context, updates <-chan Update, getUsage Memo
 
 		// Since we just pushed an update, we add the current time to the set of update times.
 		updateTimes = append(updateTimes, now)
-		dlog.Infof(ctx, "Pushing snapshot %+v", latest.Version)
+		dlog.Infof(ctx, "Pushing snapshot %+v (hash %s)", latest.Version, latest.Hash)
 		pushed = true
+		hashInfo.Store(latest.Hash)
 
-		info.Store(debugInfo{updateTimes, staleReconfigs, maxStaleReconfigs, pushed, disableRatelimiter})
+		info.Store(debugInfo{updateTimes, staleReconfigs, maxStaleReconfigs, pushed, disableRatelimiter, frozen})
 	}
 }
 
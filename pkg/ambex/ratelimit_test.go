@@ -22,15 +22,18 @@ type harness struct {
 	mutex sync.Mutex // to proect the clock and usage
 	usage int        // simulated memory usage
 	clock time.Time  // current simulated time
+
+	freezer *Freezer // to freeze and thaw pushes
 }
 
 var drainTime = 10 * time.Minute
 
 func newHarness(t *testing.T) *harness {
 	C := make(chan time.Time)
-	h := &harness{t, C, 0, make(chan Update), make(chan int, 10000), 1, sync.Mutex{}, 0, time.Now()}
+	h := &harness{t, C, 0, make(chan Update), make(chan int, 10000), 1, sync.Mutex{}, 0, time.Now(), NewFreezer()}
 	go func() {
-		assert.NoError(t, updaterWithTicker(dlog.NewTestContext(t, false), h.updates, h.getUsage, drainTime, &time.Ticker{C: C}, h.time))
+		ctx := WithFreezer(dlog.NewTestContext(t, false), h.freezer)
+		assert.NoError(t, updaterWithTicker(ctx, h.updates, h.getUsage, drainTime, C, h.time))
 	}()
 	return h
 }
@@ -69,7 +72,7 @@ func (h *harness) update(d time.Duration) int {
 	h.version++
 	version := h.version
 	h.advance(d)
-	h.updates <- Update{fmt.Sprintf("%d", version), func() error {
+	h.updates <- Update{fmt.Sprintf("%d", version), "", func() error {
 		h.pushed <- version
 		return nil
 	}}
@@ -194,3 +197,30 @@ func TestConstrained(t *testing.T) {
 	}
 	h.expectUntil(6000)
 }
+
+// Check that nothing is pushed while frozen, and that thawing pushes the latest update.
+func TestFrozen(t *testing.T) {
+	h := newHarness(t)
+	h.expectUntil(h.update(0))
+
+	h.freezer.Freeze("bad merge", h.time())
+	var version int
+	for i := 0; i < 10; i++ {
+		version = h.update(0)
+	}
+	h.tick(drainTime)
+	h.expectNone()
+
+	state := h.freezer.State()
+	assert.True(t, state.Frozen)
+	assert.Equal(t, "bad merge", state.Reason)
+	assert.Equal(t, 10, state.Held)
+	assert.Equal(t, fmt.Sprintf("%d", version), state.HeldVersion)
+
+	assert.Equal(t, state, h.freezer.Thaw())
+	h.expectExact(version)
+	assert.False(t, h.freezer.State().Frozen)
+
+	// Once thawed, updates go straight through again.
+	h.expectExact(h.update(0))
+}
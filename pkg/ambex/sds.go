@@ -0,0 +1,116 @@
+package ambex
+
+import (
+	// standard library
+	"fmt"
+
+	// third-party libraries
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	// envoy api v3
+	v3core "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/core/v3"
+	v3listener "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/listener/v3"
+	v3tls "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/extensions/transport_sockets/tls/v3"
+
+	// envoy control plane
+	ecp_wellknown "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/wellknown"
+)
+
+// V3ListenerToSdsListener does for TLS what V3ListenerToRdsListener does for routes: it takes the
+// certificates and validation contexts that a listener's filter chains supply inline, and replaces
+// them with references to SDS secrets, supplied over ADS. It does not modify the supplied listener.
+//
+// The files that hold a Host's certificate are named for a hash of their contents, so when the
+// certificate is rotated, an inline certificate changes the filter chain, and Envoy drains it. With
+// SDS, the listener stays the same, and Envoy just starts using the new secret.
+//
+// The secrets are named for the listener and the filter chain, which Python names for the Host, so
+// that they stay the same when the certificate changes:
+//
+//	ambassador-listener-8443-httpshost-example-host-cert-0
+//	ambassador-listener-8443-httpshost-example-host-validation
+//
+// Anything that isn't a DownstreamTlsContext in an envoy.transport_sockets.tls transport socket
+// (QUIC, in particular) is left as it is.
+func V3ListenerToSdsListener(lnr *v3listener.Listener) (*v3listener.Listener, []*v3tls.Secret, error) {
+	l := proto.Clone(lnr).(*v3listener.Listener)
+	var secrets []*v3tls.Secret
+
+	chains := l.FilterChains
+	if l.DefaultFilterChain != nil {
+		chains = append(chains[:len(chains):len(chains)], l.DefaultFilterChain)
+	}
+
+	seen := map[string]bool{}
+	for i, fc := range chains {
+		ts := fc.TransportSocket
+		if ts == nil || ts.Name != ecp_wellknown.TransportSocketTLS || ts.GetTypedConfig() == nil {
+			continue
+		}
+		tlsContext := &v3tls.DownstreamTlsContext{}
+		if !ts.GetTypedConfig().MessageIs(tlsContext) {
+			continue
+		}
+		if err := ts.GetTypedConfig().UnmarshalTo(tlsContext); err != nil {
+			return nil, nil, err
+		}
+		common := tlsContext.CommonTlsContext
+		if common == nil {
+			continue
+		}
+
+		// Python names every filter chain, but two chains could in principle end up with the
+		// same name, and secret names have to be unique.
+		prefix := fmt.Sprintf("%s-%s", l.Name, fc.Name)
+		if fc.Name == "" || seen[prefix] {
+			prefix = fmt.Sprintf("%s-filterchain-%d", l.Name, i)
+		}
+		seen[prefix] = true
+
+		for j, cert := range common.TlsCertificates {
+			secret := &v3tls.Secret{
+				Name: fmt.Sprintf("%s-cert-%d", prefix, j),
+				Type: &v3tls.Secret_TlsCertificate{TlsCertificate: cert},
+			}
+			secrets = append(secrets, secret)
+			common.TlsCertificateSdsSecretConfigs = append(common.TlsCertificateSdsSecretConfigs, adsSecretConfig(secret.Name))
+		}
+		common.TlsCertificates = nil
+
+		if vc, ok := common.ValidationContextType.(*v3tls.CommonTlsContext_ValidationContext); ok && vc.ValidationContext != nil {
+			secret := &v3tls.Secret{
+				Name: prefix + "-validation",
+				Type: &v3tls.Secret_ValidationContext{ValidationContext: vc.ValidationContext},
+			}
+			secrets = append(secrets, secret)
+			common.ValidationContextType = &v3tls.CommonTlsContext_ValidationContextSdsSecretConfig{
+				ValidationContextSdsSecretConfig: adsSecretConfig(secret.Name),
+			}
+		}
+
+		// As with the hcm in V3ListenerToRdsListener, the TLS context is in a protobuf any, so
+		// we have to remarshal it.
+		any, err := anypb.New(tlsContext)
+		if err != nil {
+			return nil, nil, err
+		}
+		ts.ConfigType = &v3core.TransportSocket_TypedConfig{TypedConfig: any}
+	}
+
+	return l, secrets, nil
+}
+
+// adsSecretConfig refers to the secret called name, supplied by whatever ADS source the bootstrap
+// configuration defines.
+func adsSecretConfig(name string) *v3tls.SdsSecretConfig {
+	return &v3tls.SdsSecretConfig{
+		Name: name,
+		SdsConfig: &v3core.ConfigSource{
+			ConfigSourceSpecifier: &v3core.ConfigSource_Ads{
+				Ads: &v3core.AggregatedConfigSource{},
+			},
+			ResourceApiVersion: v3core.ApiVersion_V3,
+		},
+	}
+}
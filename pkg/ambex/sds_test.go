@@ -0,0 +1,83 @@
+package ambex
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	v3core "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/core/v3"
+	v3listener "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/listener/v3"
+	v3tls "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/extensions/transport_sockets/tls/v3"
+)
+
+func tlsFilterChain(t *testing.T, name, certFile string) *v3listener.FilterChain {
+	t.Helper()
+	any, err := anypb.New(&v3tls.DownstreamTlsContext{
+		CommonTlsContext: &v3tls.CommonTlsContext{
+			AlpnProtocols: []string{"h2", "http/1.1"},
+			TlsCertificates: []*v3tls.TlsCertificate{{
+				CertificateChain: &v3core.DataSource{Specifier: &v3core.DataSource_Filename{Filename: certFile + ".crt"}},
+				PrivateKey:       &v3core.DataSource{Specifier: &v3core.DataSource_Filename{Filename: certFile + ".key"}},
+			}},
+			ValidationContextType: &v3tls.CommonTlsContext_ValidationContext{
+				ValidationContext: &v3tls.CertificateValidationContext{
+					TrustedCa: &v3core.DataSource{Specifier: &v3core.DataSource_Filename{Filename: certFile + ".root.crt"}},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+	return &v3listener.FilterChain{
+		Name:             name,
+		FilterChainMatch: &v3listener.FilterChainMatch{ServerNames: []string{name + ".example.com"}},
+		TransportSocket: &v3core.TransportSocket{
+			Name:       "envoy.transport_sockets.tls",
+			ConfigType: &v3core.TransportSocket_TypedConfig{TypedConfig: any},
+		},
+	}
+}
+
+func TestV3ListenerToSdsListener(t *testing.T) {
+	in := &v3listener.Listener{
+		Name: "ambassador-listener-8443",
+		FilterChains: []*v3listener.FilterChain{
+			tlsFilterChain(t, "httpshost-foo", "/secrets/foo/ABC"),
+			{Name: "httphost-shared"},
+		},
+	}
+	orig := proto.Clone(in)
+
+	out, secrets, err := V3ListenerToSdsListener(in)
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(orig, in), "the input listener was modified")
+
+	require.Len(t, secrets, 2)
+	assert.Equal(t, "ambassador-listener-8443-httpshost-foo-cert-0", secrets[0].Name)
+	assert.Equal(t, "/secrets/foo/ABC.crt", secrets[0].GetTlsCertificate().GetCertificateChain().GetFilename())
+	assert.Equal(t, "ambassador-listener-8443-httpshost-foo-validation", secrets[1].Name)
+	assert.Equal(t, "/secrets/foo/ABC.root.crt", secrets[1].GetValidationContext().GetTrustedCa().GetFilename())
+
+	tlsContext := &v3tls.DownstreamTlsContext{}
+	require.NoError(t, out.FilterChains[0].TransportSocket.GetTypedConfig().UnmarshalTo(tlsContext))
+	common := tlsContext.CommonTlsContext
+	assert.Empty(t, common.TlsCertificates)
+	assert.Equal(t, []string{"h2", "http/1.1"}, common.AlpnProtocols)
+	require.Len(t, common.TlsCertificateSdsSecretConfigs, 1)
+	assert.Equal(t, secrets[0].Name, common.TlsCertificateSdsSecretConfigs[0].Name)
+	assert.NotNil(t, common.TlsCertificateSdsSecretConfigs[0].SdsConfig.GetAds())
+	assert.Equal(t, secrets[1].Name, common.GetValidationContextSdsSecretConfig().GetName())
+	assert.True(t, proto.Equal(in.FilterChains[1], out.FilterChains[1]))
+
+	// Rotating the certificate changes the secrets, but not the listener, so Envoy doesn't drain
+	// anything.
+	rotated := proto.Clone(in).(*v3listener.Listener)
+	rotated.FilterChains[0] = tlsFilterChain(t, "httpshost-foo", "/secrets/foo/DEF")
+	out2, secrets2, err := V3ListenerToSdsListener(rotated)
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(out, out2))
+	assert.Equal(t, "/secrets/foo/DEF.crt", secrets2[0].GetTlsCertificate().GetCertificateChain().GetFilename())
+	assert.Equal(t, listenerChange{}, compareListeners(out, out2))
+}
@@ -0,0 +1,56 @@
+package ambex
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// With --ads-tls-dir, ADS is served over mutual TLS. The directory holds these files:
+const (
+	// ADSTLSCAFile is the CA that signs both ends' certificates.
+	ADSTLSCAFile = "ca.crt"
+	// ADSTLSServerCertFile and ADSTLSServerKeyFile are ambex's certificate and key. They're read
+	// afresh for every handshake, so replacing them is all it takes to rotate them.
+	ADSTLSServerCertFile = "xds-server.crt"
+	ADSTLSServerKeyFile  = "xds-server.key"
+	// ADSTLSClientCertFile and ADSTLSClientKeyFile are Envoy's certificate and key. Envoy reads
+	// them when it starts; ambex doesn't read them at all.
+	ADSTLSClientCertFile = "envoy-client.crt"
+	ADSTLSClientKeyFile  = "envoy-client.key"
+)
+
+// adsTLSConfig returns the TLS configuration for serving ADS out of dir: our certificate comes from
+// the directory, and clients need a certificate signed by the directory's CA.
+func adsTLSConfig(dir string) (*tls.Config, error) {
+	caPEM, err := os.ReadFile(filepath.Join(dir, ADSTLSCAFile))
+	if err != nil {
+		return nil, err
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caPEM) {
+		return nil, errors.New("no certificates in " + filepath.Join(dir, ADSTLSCAFile))
+	}
+
+	certFile := filepath.Join(dir, ADSTLSServerCertFile)
+	keyFile := filepath.Join(dir, ADSTLSServerKeyFile)
+	// Fail now, rather than at the first handshake, if the certificate isn't there.
+	if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				return nil, err
+			}
+			return &cert, nil
+		},
+	}, nil
+}
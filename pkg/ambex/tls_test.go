@@ -0,0 +1,95 @@
+package ambex
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/bootstrapca"
+)
+
+func TestADSTLS(t *testing.T) {
+	now := time.Now()
+	ca, err := bootstrapca.New("Test", now)
+	require.NoError(t, err)
+	dir := t.TempDir()
+
+	write := func(name string, data []byte) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0600))
+	}
+	issue := func(spec bootstrapca.LeafSpec) ([]byte, []byte) {
+		certPEM, keyPEM, err := ca.Issue(spec, now)
+		require.NoError(t, err)
+		return certPEM, keyPEM
+	}
+
+	_, err = adsTLSConfig(dir)
+	assert.Error(t, err, "no CA")
+
+	write(ADSTLSCAFile, ca.CertPEM())
+	_, err = adsTLSConfig(dir)
+	assert.Error(t, err, "no server certificate")
+
+	serverCert, serverKey := issue(bootstrapca.LeafSpec{CommonName: "xds", DNSNames: []string{"localhost"}, Server: true})
+	write(ADSTLSServerCertFile, serverCert)
+	write(ADSTLSServerKeyFile, serverKey)
+	serverConfig, err := adsTLSConfig(dir)
+	require.NoError(t, err)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+	handshake := func(clientCerts []tls.Certificate) (*x509.Certificate, error) {
+		// A real socket, rather than a net.Pipe, so that nobody blocks on an unread alert.
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer lis.Close()
+		serverErr := make(chan error, 1)
+		go func() {
+			conn, err := lis.Accept()
+			if err != nil {
+				serverErr <- err
+				return
+			}
+			defer conn.Close()
+			serverErr <- tls.Server(conn, serverConfig).Handshake()
+		}()
+		client, err := tls.Dial("tcp", lis.Addr().String(), &tls.Config{
+			RootCAs:      roots,
+			ServerName:   "localhost",
+			Certificates: clientCerts,
+		})
+		if err != nil {
+			return nil, err
+		}
+		defer client.Close()
+		if err := <-serverErr; err != nil {
+			return nil, err
+		}
+		return client.ConnectionState().PeerCertificates[0], nil
+	}
+
+	// Clients need a certificate from the CA.
+	_, err = handshake(nil)
+	assert.Error(t, err)
+
+	clientCertPEM, clientKeyPEM := issue(bootstrapca.LeafSpec{CommonName: "envoy", Client: true})
+	clientCert, err := tls.X509KeyPair(clientCertPEM, clientKeyPEM)
+	require.NoError(t, err)
+	first, err := handshake([]tls.Certificate{clientCert})
+	require.NoError(t, err)
+
+	// Replacing the server certificate rotates it, without a new config.
+	serverCert, serverKey = issue(bootstrapca.LeafSpec{CommonName: "xds", DNSNames: []string{"localhost"}, Server: true})
+	write(ADSTLSServerCertFile, serverCert)
+	write(ADSTLSServerKeyFile, serverKey)
+	second, err := handshake([]tls.Certificate{clientCert})
+	require.NoError(t, err)
+	assert.NotEqual(t, first.SerialNumber, second.SerialNumber)
+}
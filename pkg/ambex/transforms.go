@@ -194,6 +194,14 @@ func JoinEdsClustersV3(ctx context.Context, clusters []ecp_cache_types.Resource,
 			ref = c.Name
 		}
 
+		// A cluster with more than one upstream gets its endpoints from theirs.
+		ep, found := edsEndpoints[ref]
+		if upstreams, err := clusterUpstreams(c); err != nil {
+			dlog.Errorf(ctx, "cluster %s: ignoring bad %s metadata: %v", c.Name, UpstreamsMetadataKey, err)
+		} else if upstreams != nil {
+			ep, found = mergeUpstreams(ref, upstreams, edsEndpoints), true
+		}
+
 		// This change was introduced as a stop gap solution to mitigate the 503 issues when certificates are rotated.
 		// The issue is CDS gets updated and waits for EDS to send ClusterLoadAssignment.
 		// During this wait period calls that are coming through get hit with a 503 since the cluster is in a warming state.
@@ -204,7 +212,7 @@ func JoinEdsClustersV3(ctx context.Context, clusters []ecp_cache_types.Resource,
 			// Type 0 is STATIC
 			c.ClusterDiscoveryType = &v3cluster.Cluster_Type{Type: 0}
 
-			if ep, ok := edsEndpoints[ref]; ok {
+			if found {
 				c.LoadAssignment = ep
 			} else {
 				c.LoadAssignment = &v3endpoint.ClusterLoadAssignment{
@@ -214,8 +222,7 @@ func JoinEdsClustersV3(ctx context.Context, clusters []ecp_cache_types.Resource,
 			}
 		} else {
 			var source string
-			ep, ok := edsEndpoints[ref]
-			if ok {
+			if found {
 				source = "found"
 			} else {
 				ep = &v3endpoint.ClusterLoadAssignment{
@@ -0,0 +1,108 @@
+package ambex
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	v3cluster "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/cluster/v3"
+	v3core "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/core/v3"
+	v3endpoint "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/endpoint/v3"
+)
+
+// UpstreamsMetadataKey is the cluster filter_metadata under which diagd lists the upstreams of a
+// cluster whose endpoints come from more than one place: a Mapping with `upstreams` (see
+// python/ambassador/ir/ircluster.py). Such a cluster is an EDS cluster like any other, but
+// nothing reports endpoints under its own name; ambex puts its ClusterLoadAssignment together
+// from those of its upstreams.
+const UpstreamsMetadataKey = "getambassador.io/upstreams"
+
+// Upstream is one source of a multi-upstream cluster's endpoints: either whatever endpoints there
+// are under EndpointPath (say, "k8s/default/foo/80" or "consul/dc1/foo"), or the one at Address
+// and Port.
+type Upstream struct {
+	EndpointPath string `json:"endpoint_path,omitempty"`
+	Address      string `json:"address,omitempty"`
+	Port         uint32 `json:"port,omitempty"`
+	// Weight is the upstream's share of its priority's requests.
+	Weight uint32 `json:"weight"`
+	// Priority is 0 for the most preferred upstreams. Priorities don't have to be contiguous;
+	// ambex renumbers them.
+	Priority uint32 `json:"priority"`
+}
+
+// clusterUpstreams returns the upstreams of a multi-upstream cluster, or nil if the cluster isn't
+// one.
+func clusterUpstreams(c *v3cluster.Cluster) ([]Upstream, error) {
+	st, ok := c.GetMetadata().GetFilterMetadata()[UpstreamsMetadataKey]
+	if !ok {
+		return nil, nil
+	}
+	bs, err := protojson.Marshal(st)
+	if err != nil {
+		return nil, err
+	}
+	var md struct {
+		Upstreams []Upstream `json:"upstreams"`
+	}
+	if err := json.Unmarshal(bs, &md); err != nil {
+		return nil, err
+	}
+	if len(md.Upstreams) == 0 {
+		return nil, fmt.Errorf("no upstreams")
+	}
+	return md.Upstreams, nil
+}
+
+// mergeUpstreams puts together the ClusterLoadAssignment of a multi-upstream cluster. Each
+// upstream is a locality of its own, named for where its endpoints come from, with the
+// upstream's weight and priority; Envoy weighs localities within a priority by their weights
+// (and how healthy they are), and moves traffic down a priority as the one above it loses
+// healthy endpoints. The zones that an upstream's own endpoints are in don't matter here.
+func mergeUpstreams(name string, upstreams []Upstream, edsEndpoints map[string]*v3endpoint.ClusterLoadAssignment) *v3endpoint.ClusterLoadAssignment {
+	// Envoy wants priorities to count up from 0 without gaps.
+	var priorities []uint32
+	seen := map[uint32]bool{}
+	for _, upstream := range upstreams {
+		if !seen[upstream.Priority] {
+			seen[upstream.Priority] = true
+			priorities = append(priorities, upstream.Priority)
+		}
+	}
+	sort.Slice(priorities, func(i, j int) bool { return priorities[i] < priorities[j] })
+	rank := map[uint32]uint32{}
+	for i, priority := range priorities {
+		rank[priority] = uint32(i)
+	}
+
+	loadAssignment := &v3endpoint.ClusterLoadAssignment{ClusterName: name}
+	for _, upstream := range upstreams {
+		var subZone string
+		var lbEndpoints []*v3endpoint.LbEndpoint
+		if upstream.EndpointPath != "" {
+			subZone = upstream.EndpointPath
+			for _, locality := range edsEndpoints[upstream.EndpointPath].GetEndpoints() {
+				lbEndpoints = append(lbEndpoints, locality.LbEndpoints...)
+			}
+		} else {
+			subZone = fmt.Sprintf("%s:%d", upstream.Address, upstream.Port)
+			ep := &Endpoint{ClusterName: name, Ip: upstream.Address, Port: upstream.Port, Protocol: "TCP"}
+			lbEndpoints = append(lbEndpoints, ep.ToLbEndpoint_v3())
+		}
+
+		weight := upstream.Weight
+		if weight < 1 {
+			weight = 1
+		}
+		loadAssignment.Endpoints = append(loadAssignment.Endpoints, &v3endpoint.LocalityLbEndpoints{
+			Locality:            &v3core.Locality{SubZone: subZone},
+			LbEndpoints:         lbEndpoints,
+			LoadBalancingWeight: wrapperspb.UInt32(weight),
+			Priority:            rank[upstream.Priority],
+		})
+	}
+	return loadAssignment
+}
@@ -0,0 +1,86 @@
+package ambex
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	v3cluster "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/cluster/v3"
+	v3core "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/core/v3"
+	v3endpoint "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/endpoint/v3"
+	ecp_cache_types "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/cache/types"
+)
+
+func TestUpstreams(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+
+	metadata, err := structpb.NewStruct(map[string]interface{}{
+		"upstreams": []interface{}{
+			map[string]interface{}{"endpoint_path": "k8s/default/foo/80", "weight": 90, "priority": 0},
+			map[string]interface{}{"endpoint_path": "consul/dc1/foo", "weight": 10, "priority": 0},
+			map[string]interface{}{"address": "10.0.0.1", "port": 8080, "weight": 1, "priority": 5},
+		},
+	})
+	require.NoError(t, err)
+	cluster := &v3cluster.Cluster{
+		Name:             "cluster_foo_default",
+		EdsClusterConfig: &v3cluster.Cluster_EdsClusterConfig{ServiceName: "upstreams/cluster_foo_default"},
+		Metadata:         &v3core.Metadata{FilterMetadata: map[string]*structpb.Struct{UpstreamsMetadataKey: metadata}},
+	}
+
+	eps := &Endpoints{Entries: map[string][]*Endpoint{
+		"k8s/default/foo/80": {
+			{ClusterName: "k8s/default/foo/80", Ip: "1.1.1.1", Port: 80, Protocol: "TCP", Zone: "us-east-1a"},
+			{ClusterName: "k8s/default/foo/80", Ip: "1.1.1.2", Port: 80, Protocol: "TCP", Zone: "us-east-1b"},
+		},
+		"consul/dc1/foo": {
+			{ClusterName: "consul/dc1/foo", Ip: "2.2.2.2", Port: 8080, Protocol: "TCP"},
+		},
+	}}
+
+	// localities flattens a ClusterLoadAssignment into "subzone/priority/weight=ip:port,..." strings.
+	localities := func(cla *v3endpoint.ClusterLoadAssignment) []string {
+		var ret []string
+		for _, l := range cla.Endpoints {
+			var addrs []string
+			for _, lb := range l.LbEndpoints {
+				sa := lb.GetEndpoint().GetAddress().GetSocketAddress()
+				addrs = append(addrs, fmt.Sprintf("%s:%d", sa.GetAddress(), sa.GetPortValue()))
+			}
+			ret = append(ret, fmt.Sprintf("%s/%d/%d=%s", l.GetLocality().GetSubZone(), l.Priority,
+				l.LoadBalancingWeight.GetValue(), strings.Join(addrs, ",")))
+		}
+		return ret
+	}
+
+	// Every upstream is a locality, and the priorities are renumbered from 0.
+	endpoints := JoinEdsClustersV3(ctx, []ecp_cache_types.Resource{cluster}, eps.ToMap_v3(), false)
+	require.Len(t, endpoints, 1)
+	cla := endpoints[0].(*v3endpoint.ClusterLoadAssignment)
+	assert.Equal(t, "upstreams/cluster_foo_default", cla.ClusterName)
+	assert.Equal(t, []string{
+		"k8s/default/foo/80/0/90=1.1.1.1:80,1.1.1.2:80",
+		"consul/dc1/foo/0/10=2.2.2.2:8080",
+		"10.0.0.1:8080/1/1=10.0.0.1:8080",
+	}, localities(cla))
+
+	// An upstream with no endpoints yet is still there, empty, so that it gets no traffic.
+	delete(eps.Entries, "consul/dc1/foo")
+	endpoints = JoinEdsClustersV3(ctx, []ecp_cache_types.Resource{cluster}, eps.ToMap_v3(), false)
+	assert.Equal(t, []string{
+		"k8s/default/foo/80/0/90=1.1.1.1:80,1.1.1.2:80",
+		"consul/dc1/foo/0/10=",
+		"10.0.0.1:8080/1/1=10.0.0.1:8080",
+	}, localities(endpoints[0].(*v3endpoint.ClusterLoadAssignment)))
+
+	// Bypassing EDS puts the same thing right in the cluster.
+	endpoints = JoinEdsClustersV3(ctx, []ecp_cache_types.Resource{cluster}, eps.ToMap_v3(), true)
+	assert.Empty(t, endpoints)
+	require.NotNil(t, cluster.LoadAssignment)
+	assert.Len(t, cluster.LoadAssignment.Endpoints, 3)
+}
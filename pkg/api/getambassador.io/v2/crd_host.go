@@ -22,6 +22,8 @@ package v2
 import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v3alpha1 "github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
 )
 
 type ACMEProviderSpec struct {
@@ -129,6 +131,27 @@ type HostSpec struct {
 	// TLS configuration.  It is not valid to specify both
 	// `tlsContext` and `tls`.
 	TLS *TLSConfig `json:"tls,omitempty"`
+
+	// The timeout for requests to this Host, in milliseconds. Overrides
+	// `cluster_request_timeout_ms` on the Ambassador Module, and is
+	// overridden by a Mapping's own `timeout_ms`. 0 means no timeout.
+	RequestTimeout *MillisecondDuration `json:"request_timeout_ms,omitempty"`
+
+	// The status that the Host prober's synthetic `HEAD /` request to this
+	// Host should get. The default is anything but a 5xx.
+	//
+	// +kubebuilder:validation:Minimum=100
+	// +kubebuilder:validation:Maximum=599
+	ProbeExpectedStatus int `json:"probe_expected_status,omitempty"`
+
+	// +k8s:conversion-gen:rename=IdentityHeaders
+	V3IdentityHeaders []v3alpha1.IdentityHeader `json:"v3identityHeaders,omitempty"`
+
+	// +k8s:conversion-gen:rename=Telemetry
+	V3Telemetry *v3alpha1.HostTelemetry `json:"v3telemetry,omitempty"`
+
+	// +k8s:conversion-gen:rename=Maintenance
+	V3Maintenance *v3alpha1.Maintenance `json:"v3maintenance,omitempty"`
 }
 
 type TLSConfig struct {
@@ -147,6 +170,12 @@ type TLSConfig struct {
 
 	// +k8s:conversion-gen:rename=CRLSecret
 	V3CRLSecret string `json:"v3CRLSecret,omitempty"`
+	// +k8s:conversion-gen:rename=CRLURL
+	V3CRLURL string `json:"v3CRLURL,omitempty"`
+	// +k8s:conversion-gen:rename=OCSPStaplePolicy
+	V3OCSPStaplePolicy string `json:"v3OCSPStaplePolicy,omitempty"`
+	// +k8s:conversion-gen:rename=TLSPolicy
+	V3TLSPolicy string `json:"v3TLSPolicy,omitempty"`
 }
 
 // The first value listed in the Enum marker becomes the "zero" value,
@@ -181,6 +210,11 @@ type HostStatus struct {
 	ErrorReason    string           `json:"errorReason,omitempty"`
 	ErrorTimestamp *metav1.Time     `json:"errorTimestamp,omitempty"`
 	ErrorBackoff   *metav1.Duration `json:"errorBackoff,omitempty"`
+
+	// loadBalancer is where traffic for this Host's hostname should be
+	// sent, in the same form as a Service's or an Ingress's status, so that
+	// external-dns can create DNS records for it.
+	LoadBalancer *corev1.LoadBalancerStatus `json:"loadBalancer,omitempty"`
 }
 
 // +kubebuilder:validation:Enum={"Unknown","None","Other","ACME"}
@@ -80,7 +80,7 @@ type MappingSpec struct {
 	ConnectTimeout               *MillisecondDuration `json:"connect_timeout_ms,omitempty"`
 	ClusterIdleTimeout           *MillisecondDuration `json:"cluster_idle_timeout_ms,omitempty"`
 	ClusterMaxConnectionLifetime *MillisecondDuration `json:"cluster_max_connection_lifetime_ms,omitempty"`
-	// The timeout for requests that use this Mapping. Overrides `cluster_request_timeout_ms` set on the Ambassador Module, if it exists.
+	// The timeout for requests that use this Mapping. Overrides `cluster_request_timeout_ms` set on the Ambassador Module and `request_timeout_ms` set on the Host, if they exist.
 	Timeout     *MillisecondDuration `json:"timeout_ms,omitempty"`
 	IdleTimeout *MillisecondDuration `json:"idle_timeout_ms,omitempty"`
 	// +k8s:conversion-gen=false
@@ -139,9 +139,46 @@ type MappingSpec struct {
 	// +k8s:conversion-gen=false
 	QueryParameters      map[string]BoolOrString `json:"query_parameters,omitempty"`
 	RegexQueryParameters map[string]string       `json:"regex_query_parameters,omitempty"`
+	// SubsetLabels routes this Mapping's requests only to the endpoints of its service whose
+	// Pods have all of these labels (say, version=v2), so that Mappings can pick out versions
+	// of a service without a Service per version. It needs an endpoint resolver.
+	SubsetLabels map[string]string `json:"subset_labels,omitempty"`
+
+	// +k8s:conversion-gen:rename=Failover
+	V3Failover *v3alpha1.FailoverPolicy `json:"v3failover,omitempty"`
+
+	// +k8s:conversion-gen:rename=GRPCTranscoder
+	V3GRPCTranscoder *v3alpha1.GRPCTranscoder `json:"v3grpc_transcoder,omitempty"`
+
+	// +k8s:conversion-gen:rename=Fallback
+	V3Fallback *bool `json:"v3fallback,omitempty"`
 
 	// +k8s:conversion-gen:rename=StatsName
 	V3StatsName string `json:"v3StatsName,omitempty"`
+
+	// +k8s:conversion-gen:rename=UpstreamProtocol
+	V3UpstreamProtocol string `json:"v3upstream_protocol,omitempty"`
+
+	// +k8s:conversion-gen:rename=OpenAPI
+	V3OpenAPI *v3alpha1.MappingOpenAPI `json:"v3openapi,omitempty"`
+
+	// +k8s:conversion-gen:rename=ContractCapture
+	V3ContractCapture *v3alpha1.MappingContractCapture `json:"v3contract_capture,omitempty"`
+
+	// +k8s:conversion-gen:rename=Views
+	V3Views []string `json:"v3views,omitempty"`
+
+	// +k8s:conversion-gen:rename=Maintenance
+	V3Maintenance *v3alpha1.Maintenance `json:"v3maintenance,omitempty"`
+
+	// +k8s:conversion-gen:rename=Upstreams
+	V3Upstreams []v3alpha1.MappingUpstream `json:"v3upstreams,omitempty"`
+
+	// +k8s:conversion-gen:rename=StaticResponse
+	V3StaticResponse *v3alpha1.MappingStaticResponse `json:"v3static_response,omitempty"`
+
+	// +k8s:conversion-gen:rename=Critical
+	V3Critical *bool `json:"v3critical,omitempty"`
 }
 
 type RegexMap struct {
@@ -427,6 +464,15 @@ type MappingStatus struct {
 	State string `json:"state,omitempty"`
 
 	Reason string `json:"reason,omitempty"`
+
+	// Code is the stable error code (like "AMB2000") for the error that Reason
+	// describes. Unlike Reason, it won't change from release to release.
+	Code string `json:"code,omitempty"`
+
+	// Warning describes a problem that doesn't stop the Mapping from running,
+	// but probably means that requests to it will fail, like an upstream that
+	// failed its pre-flight check.
+	Warning string `json:"warning,omitempty"`
 }
 
 // Mapping is the Schema for the mappings API
@@ -20,6 +20,7 @@
 package v2
 
 import (
+	v3alpha1 "github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -47,6 +48,16 @@ type TLSContextSpec struct {
 
 	// +k8s:conversion-gen:rename=CRLSecret
 	V3CRLSecret string `json:"v3CRLSecret,omitempty"`
+	// +k8s:conversion-gen:rename=CRLURL
+	V3CRLURL string `json:"v3CRLURL,omitempty"`
+	// +k8s:conversion-gen:rename=OCSPStaplePolicy
+	V3OCSPStaplePolicy string `json:"v3OCSPStaplePolicy,omitempty"`
+	// +k8s:conversion-gen:rename=TLSPolicy
+	V3TLSPolicy string `json:"v3TLSPolicy,omitempty"`
+	// +k8s:conversion-gen:rename=PrivateKeyProvider
+	V3PrivateKeyProvider *v3alpha1.PrivateKeyProvider `json:"v3PrivateKeyProvider,omitempty"`
+	// +k8s:conversion-gen:rename=MatchSubjectAltNames
+	V3MatchSubjectAltNames []v3alpha1.SubjectAltNameMatcher `json:"v3MatchSubjectAltNames,omitempty"`
 }
 
 // TLSContext is the Schema for the tlscontexts API
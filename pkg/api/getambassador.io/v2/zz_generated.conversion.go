@@ -2395,6 +2395,34 @@ func autoConvert_v2_HostSpec_To_v3alpha1_HostSpec(in *HostSpec, out *v3alpha1.Ho
 			}
 		}
 	}
+	if true {
+		in, out := &in.RequestTimeout, &out.RequestTimeout
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(v3alpha1.MillisecondDuration)
+			in, out := *in, *out
+			if err := Convert_v2_MillisecondDuration_To_v3alpha1_MillisecondDuration(in, out, s); err != nil {
+				return err
+			}
+		}
+	}
+	if true {
+		in, out := &in.ProbeExpectedStatus, &out.ProbeExpectedStatus
+		*out = *in
+	}
+	if true {
+		in, out := &in.V3IdentityHeaders, &out.IdentityHeaders
+		*out = *in
+	}
+	if true {
+		in, out := &in.V3Telemetry, &out.Telemetry
+		*out = *in
+	}
+	if true {
+		in, out := &in.V3Maintenance, &out.Maintenance
+		*out = *in
+	}
 	return nil
 }
 
@@ -2470,6 +2498,34 @@ func autoConvert_v3alpha1_HostSpec_To_v2_HostSpec(in *v3alpha1.HostSpec, out *Ho
 			}
 		}
 	}
+	if true {
+		in, out := &in.RequestTimeout, &out.RequestTimeout
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(MillisecondDuration)
+			in, out := *in, *out
+			if err := Convert_v3alpha1_MillisecondDuration_To_v2_MillisecondDuration(in, out, s); err != nil {
+				return err
+			}
+		}
+	}
+	if true {
+		in, out := &in.ProbeExpectedStatus, &out.ProbeExpectedStatus
+		*out = *in
+	}
+	if true {
+		in, out := &in.IdentityHeaders, &out.V3IdentityHeaders
+		*out = *in
+	}
+	if true {
+		in, out := &in.Telemetry, &out.V3Telemetry
+		*out = *in
+	}
+	if true {
+		in, out := &in.Maintenance, &out.V3Maintenance
+		*out = *in
+	}
 	return nil
 }
 
@@ -2530,6 +2586,10 @@ func autoConvert_v2_HostStatus_To_v3alpha1_HostStatus(in *HostStatus, out *v3alp
 		in, out := &in.ErrorBackoff, &out.ErrorBackoff
 		*out = *in
 	}
+	if true {
+		in, out := &in.LoadBalancer, &out.LoadBalancer
+		*out = *in
+	}
 	return nil
 }
 
@@ -2575,6 +2635,10 @@ func autoConvert_v3alpha1_HostStatus_To_v2_HostStatus(in *v3alpha1.HostStatus, o
 		in, out := &in.ErrorBackoff, &out.ErrorBackoff
 		*out = *in
 	}
+	if true {
+		in, out := &in.LoadBalancer, &out.LoadBalancer
+		*out = *in
+	}
 	return nil
 }
 
@@ -3907,10 +3971,58 @@ func autoConvert_v2_MappingSpec_To_v3alpha1_MappingSpec(in *MappingSpec, out *v3
 		in, out := &in.RegexQueryParameters, &out.RegexQueryParameters
 		*out = *in
 	}
+	if true {
+		in, out := &in.SubsetLabels, &out.SubsetLabels
+		*out = *in
+	}
+	if true {
+		in, out := &in.V3Failover, &out.Failover
+		*out = *in
+	}
+	if true {
+		in, out := &in.V3GRPCTranscoder, &out.GRPCTranscoder
+		*out = *in
+	}
+	if true {
+		in, out := &in.V3Fallback, &out.Fallback
+		*out = *in
+	}
 	if true {
 		in, out := &in.V3StatsName, &out.StatsName
 		*out = *in
 	}
+	if true {
+		in, out := &in.V3UpstreamProtocol, &out.UpstreamProtocol
+		*out = *in
+	}
+	if true {
+		in, out := &in.V3OpenAPI, &out.OpenAPI
+		*out = *in
+	}
+	if true {
+		in, out := &in.V3ContractCapture, &out.ContractCapture
+		*out = *in
+	}
+	if true {
+		in, out := &in.V3Views, &out.Views
+		*out = *in
+	}
+	if true {
+		in, out := &in.V3Maintenance, &out.Maintenance
+		*out = *in
+	}
+	if true {
+		in, out := &in.V3Upstreams, &out.Upstreams
+		*out = *in
+	}
+	if true {
+		in, out := &in.V3StaticResponse, &out.StaticResponse
+		*out = *in
+	}
+	if true {
+		in, out := &in.V3Critical, &out.Critical
+		*out = *in
+	}
 	return nil
 }
 
@@ -4414,6 +4526,54 @@ func autoConvert_v3alpha1_MappingSpec_To_v2_MappingSpec(in *v3alpha1.MappingSpec
 		in, out := &in.StatsName, &out.V3StatsName
 		*out = *in
 	}
+	if true {
+		in, out := &in.SubsetLabels, &out.SubsetLabels
+		*out = *in
+	}
+	if true {
+		in, out := &in.Failover, &out.V3Failover
+		*out = *in
+	}
+	if true {
+		in, out := &in.GRPCTranscoder, &out.V3GRPCTranscoder
+		*out = *in
+	}
+	if true {
+		in, out := &in.Fallback, &out.V3Fallback
+		*out = *in
+	}
+	if true {
+		in, out := &in.UpstreamProtocol, &out.V3UpstreamProtocol
+		*out = *in
+	}
+	if true {
+		in, out := &in.OpenAPI, &out.V3OpenAPI
+		*out = *in
+	}
+	if true {
+		in, out := &in.ContractCapture, &out.V3ContractCapture
+		*out = *in
+	}
+	if true {
+		in, out := &in.Views, &out.V3Views
+		*out = *in
+	}
+	if true {
+		in, out := &in.Maintenance, &out.V3Maintenance
+		*out = *in
+	}
+	if true {
+		in, out := &in.Upstreams, &out.V3Upstreams
+		*out = *in
+	}
+	if true {
+		in, out := &in.StaticResponse, &out.V3StaticResponse
+		*out = *in
+	}
+	if true {
+		in, out := &in.Critical, &out.V3Critical
+		*out = *in
+	}
 	// WARNING: in.V2ExplicitTLS requires manual conversion: does not exist in peer-type
 	// WARNING: in.V2BoolHeaders requires manual conversion: does not exist in peer-type
 	// WARNING: in.V2BoolQueryParameters requires manual conversion: does not exist in peer-type
@@ -5235,6 +5395,18 @@ func autoConvert_v2_TLSConfig_To_v3alpha1_TLSConfig(in *TLSConfig, out *v3alpha1
 		in, out := &in.V3CRLSecret, &out.CRLSecret
 		*out = *in
 	}
+	if true {
+		in, out := &in.V3CRLURL, &out.CRLURL
+		*out = *in
+	}
+	if true {
+		in, out := &in.V3OCSPStaplePolicy, &out.OCSPStaplePolicy
+		*out = *in
+	}
+	if true {
+		in, out := &in.V3TLSPolicy, &out.TLSPolicy
+		*out = *in
+	}
 	return nil
 }
 
@@ -5296,6 +5468,18 @@ func autoConvert_v3alpha1_TLSConfig_To_v2_TLSConfig(in *v3alpha1.TLSConfig, out
 		in, out := &in.SNI, &out.SNI
 		*out = *in
 	}
+	if true {
+		in, out := &in.CRLURL, &out.V3CRLURL
+		*out = *in
+	}
+	if true {
+		in, out := &in.OCSPStaplePolicy, &out.V3OCSPStaplePolicy
+		*out = *in
+	}
+	if true {
+		in, out := &in.TLSPolicy, &out.V3TLSPolicy
+		*out = *in
+	}
 	return nil
 }
 
@@ -5467,6 +5651,26 @@ func autoConvert_v2_TLSContextSpec_To_v3alpha1_TLSContextSpec(in *TLSContextSpec
 		in, out := &in.V3CRLSecret, &out.CRLSecret
 		*out = *in
 	}
+	if true {
+		in, out := &in.V3CRLURL, &out.CRLURL
+		*out = *in
+	}
+	if true {
+		in, out := &in.V3OCSPStaplePolicy, &out.OCSPStaplePolicy
+		*out = *in
+	}
+	if true {
+		in, out := &in.V3TLSPolicy, &out.TLSPolicy
+		*out = *in
+	}
+	if true {
+		in, out := &in.V3PrivateKeyProvider, &out.PrivateKeyProvider
+		*out = *in
+	}
+	if true {
+		in, out := &in.V3MatchSubjectAltNames, &out.MatchSubjectAltNames
+		*out = *in
+	}
 	return nil
 }
 
@@ -5546,6 +5750,26 @@ func autoConvert_v3alpha1_TLSContextSpec_To_v2_TLSContextSpec(in *v3alpha1.TLSCo
 		in, out := &in.SNI, &out.SNI
 		*out = *in
 	}
+	if true {
+		in, out := &in.CRLURL, &out.V3CRLURL
+		*out = *in
+	}
+	if true {
+		in, out := &in.OCSPStaplePolicy, &out.V3OCSPStaplePolicy
+		*out = *in
+	}
+	if true {
+		in, out := &in.TLSPolicy, &out.V3TLSPolicy
+		*out = *in
+	}
+	if true {
+		in, out := &in.PrivateKeyProvider, &out.V3PrivateKeyProvider
+		*out = *in
+	}
+	if true {
+		in, out := &in.MatchSubjectAltNames, &out.V3MatchSubjectAltNames
+		*out = *in
+	}
 	return nil
 }
 
@@ -962,6 +962,26 @@ func (in *HostSpec) DeepCopyInto(out *HostSpec) {
 		*out = new(TLSConfig)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.RequestTimeout != nil {
+		in, out := &in.RequestTimeout, &out.RequestTimeout
+		*out = new(MillisecondDuration)
+		**out = **in
+	}
+	if in.V3IdentityHeaders != nil {
+		in, out := &in.V3IdentityHeaders, &out.V3IdentityHeaders
+		*out = make([]v3alpha1.IdentityHeader, len(*in))
+		copy(*out, *in)
+	}
+	if in.V3Telemetry != nil {
+		in, out := &in.V3Telemetry, &out.V3Telemetry
+		*out = new(v3alpha1.HostTelemetry)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.V3Maintenance != nil {
+		in, out := &in.V3Maintenance, &out.V3Maintenance
+		*out = new(v3alpha1.Maintenance)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HostSpec.
@@ -986,6 +1006,11 @@ func (in *HostStatus) DeepCopyInto(out *HostStatus) {
 		*out = new(metav1.Duration)
 		**out = **in
 	}
+	if in.LoadBalancer != nil {
+		in, out := &in.LoadBalancer, &out.LoadBalancer
+		*out = new(v1.LoadBalancerStatus)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HostStatus.
@@ -1905,6 +1930,65 @@ func (in *MappingSpec) DeepCopyInto(out *MappingSpec) {
 			(*out)[key] = val
 		}
 	}
+	if in.SubsetLabels != nil {
+		in, out := &in.SubsetLabels, &out.SubsetLabels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.V3Failover != nil {
+		in, out := &in.V3Failover, &out.V3Failover
+		*out = new(v3alpha1.FailoverPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.V3GRPCTranscoder != nil {
+		in, out := &in.V3GRPCTranscoder, &out.V3GRPCTranscoder
+		*out = new(v3alpha1.GRPCTranscoder)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.V3Fallback != nil {
+		in, out := &in.V3Fallback, &out.V3Fallback
+		*out = new(bool)
+		**out = **in
+	}
+	if in.V3OpenAPI != nil {
+		in, out := &in.V3OpenAPI, &out.V3OpenAPI
+		*out = new(v3alpha1.MappingOpenAPI)
+		**out = **in
+	}
+	if in.V3ContractCapture != nil {
+		in, out := &in.V3ContractCapture, &out.V3ContractCapture
+		*out = new(v3alpha1.MappingContractCapture)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.V3Views != nil {
+		in, out := &in.V3Views, &out.V3Views
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.V3Maintenance != nil {
+		in, out := &in.V3Maintenance, &out.V3Maintenance
+		*out = new(v3alpha1.Maintenance)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.V3Upstreams != nil {
+		in, out := &in.V3Upstreams, &out.V3Upstreams
+		*out = make([]v3alpha1.MappingUpstream, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.V3StaticResponse != nil {
+		in, out := &in.V3StaticResponse, &out.V3StaticResponse
+		*out = new(v3alpha1.MappingStaticResponse)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.V3Critical != nil {
+		in, out := &in.V3Critical, &out.V3Critical
+		*out = new(bool)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MappingSpec.
@@ -2490,6 +2574,16 @@ func (in *TLSContextSpec) DeepCopyInto(out *TLSContextSpec) {
 		*out = new(int)
 		**out = **in
 	}
+	if in.V3PrivateKeyProvider != nil {
+		in, out := &in.V3PrivateKeyProvider, &out.V3PrivateKeyProvider
+		*out = new(v3alpha1.PrivateKeyProvider)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.V3MatchSubjectAltNames != nil {
+		in, out := &in.V3MatchSubjectAltNames, &out.V3MatchSubjectAltNames
+		*out = make([]v3alpha1.SubjectAltNameMatcher, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TLSContextSpec.
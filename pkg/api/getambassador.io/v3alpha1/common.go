@@ -114,6 +114,46 @@ type ErrorResponseOverride struct {
 	Body ErrorResponseOverrideBody `json:"body,omitempty"`
 }
 
+// Maintenance answers every request for a Mapping, or for a whole Host,
+// with a static response instead of routing it upstream, for planned
+// downtime.
+type Maintenance struct {
+	// Whether the maintenance response is being served. The
+	// `getambassador.io/maintenance` annotation, and the admin API, can
+	// turn it on or off without changing this.
+	Enabled bool `json:"enabled,omitempty"`
+
+	// The status code of the maintenance response. The default is 503.
+	// +kubebuilder:validation:Minimum=200
+	// +kubebuilder:validation:Maximum=599
+	StatusCode *int `json:"status_code,omitempty"`
+
+	// How many seconds clients are told to wait before trying again, as a
+	// Retry-After header. The default is to leave the header off.
+	// +kubebuilder:validation:Minimum=0
+	RetryAfter *int `json:"retry_after,omitempty"`
+
+	// Where the body of the maintenance response comes from. The default
+	// is an empty body.
+	Body *MaintenanceBody `json:"body,omitempty"`
+}
+
+// MaintenanceBody is the body of a maintenance response, from a ConfigMap.
+type MaintenanceBody struct {
+	// ConfigMap is the name of a ConfigMap labeled
+	// `getambassador.io/maintenance-page` holding the body, in the
+	// namespace of the Mapping or Host unless written as "name.namespace".
+	// +kubebuilder:validation:Required
+	ConfigMap string `json:"config_map,omitempty"`
+
+	// Key is the key of the ConfigMap to use. Defaults to the ConfigMap's
+	// only key, if it has only one.
+	Key string `json:"key,omitempty"`
+
+	// The Content-Type of the body. The default is "text/html".
+	ContentType string `json:"content_type,omitempty"`
+}
+
 // A range of response statuses from Start to End inclusive
 type StatusRange struct {
 	// Start of the statuses to include. Must be between 100 and 599 (inclusive)
@@ -0,0 +1,88 @@
+// Copyright 2020 Datawire.  All rights reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+///////////////////////////////////////////////////////////////////////////
+// Important: Run "make generate-fast" to regenerate code after modifying
+// this file.
+///////////////////////////////////////////////////////////////////////////
+
+package v3alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EgressPolicySpec lets traffic out of the cluster through Ambassador, to an allowlist of
+// destinations. Workloads send plain HTTP to Ambassador's egress port, with the destination as
+// the Host; Ambassador refuses anything that isn't on the allowlist, originates TLS to the
+// destinations that need it, and logs every request.
+type EgressPolicySpec struct {
+	AmbassadorID AmbassadorID `json:"ambassador_id,omitempty"`
+
+	// Port is the port that Ambassador listens for egress traffic on. EgressPolicies with the
+	// same port share a listener, and the destinations they allow add up.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +kubebuilder:validation:Required
+	Port int `json:"port"`
+
+	// Destinations are the places that traffic is allowed to go.
+	// +kubebuilder:validation:MinItems=1
+	// +kubebuilder:validation:Required
+	Destinations []EgressDestination `json:"destinations"`
+}
+
+// EgressDestination is a single entry in an EgressPolicy's allowlist.
+type EgressDestination struct {
+	// Host is the DNS name of the destination. Wildcards aren't allowed.
+	// +kubebuilder:validation:Required
+	Host string `json:"host"`
+
+	// Ports are the destination's ports that traffic may go to; a request picks one with the
+	// port in its Host header. A request with no port in its Host header goes to the first
+	// one. Defaults to 443.
+	Ports []int `json:"ports,omitempty"`
+
+	// TLS, if true, originates TLS to the destination, and checks the destination's
+	// certificate against the system CA bundle and SNI. Defaults to true for port 443, and
+	// false for everything else.
+	TLS *bool `json:"tls,omitempty"`
+
+	// SNI is the server name to send when originating TLS. Defaults to Host.
+	SNI string `json:"sni,omitempty"`
+}
+
+// EgressPolicy is the Schema for the egresspolicies API
+//
+// +kubebuilder:object:root=true
+// +kubebuilder:storageversion
+type EgressPolicy struct {
+	metav1.TypeMeta   `json:""`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec EgressPolicySpec `json:"spec,omitempty"`
+}
+
+// EgressPolicyList contains a list of EgressPolicies.
+//
+// +kubebuilder:object:root=true
+type EgressPolicyList struct {
+	metav1.TypeMeta `json:""`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []EgressPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&EgressPolicy{}, &EgressPolicyList{})
+}
@@ -126,6 +126,72 @@ type HostSpec struct {
 	// TLS configuration.  It is not valid to specify both
 	// `tlsContext` and `tls`.
 	TLS *TLSConfig `json:"tls,omitempty"`
+
+	// The timeout for requests to this Host, in milliseconds. Overrides
+	// `cluster_request_timeout_ms` on the Ambassador Module, and is
+	// overridden by a Mapping's own `timeout_ms`. 0 means no timeout.
+	RequestTimeout *MillisecondDuration `json:"request_timeout_ms,omitempty"`
+
+	// The status that the Host prober's synthetic `HEAD /` request to this
+	// Host should get. The default is anything but a 5xx.
+	//
+	// +kubebuilder:validation:Minimum=100
+	// +kubebuilder:validation:Maximum=599
+	ProbeExpectedStatus int `json:"probe_expected_status,omitempty"`
+
+	// Upstream headers that carry the verified identity of the client. Each
+	// one is stripped from every request to this Host before it's set, so a
+	// client can never supply it for itself.
+	IdentityHeaders []IdentityHeader `json:"identityHeaders,omitempty"`
+
+	// How requests to this Host are logged and counted, when that should
+	// differ from every other Host.
+	Telemetry *HostTelemetry `json:"telemetry,omitempty"`
+
+	// Maintenance, when it's enabled, answers every request to this Host
+	// with a static response, whatever Mapping it would have gone to.
+	Maintenance *Maintenance `json:"maintenance,omitempty"`
+}
+
+// HostTelemetry overrides the access logging and stats of one Host, so that a
+// busy Host can be logged less, or a troublesome one counted in more detail,
+// without changing anything for the rest.
+type HostTelemetry struct {
+	// The percentage of requests to this Host that get access-logged, by
+	// Envoy's own access log and by every LogService alike. The default is
+	// 100.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	AccessLogSampling *int `json:"accessLogSampling,omitempty"`
+
+	// Whether each route of this Host gets stats of its own (under
+	// vhost.<listener>-<hostname>.route.<mapping>.<namespace>), on top of the
+	// stats of the clusters it routes to.
+	RouteStats *bool `json:"routeStats,omitempty"`
+}
+
+// IdentityHeader sets an upstream header from one verified identity, which is
+// either a field of the client's certificate, a claim of a JWT that the
+// AuthService verified, or some other metadata that the AuthService returned.
+// Exactly one of ClientCert, JWTClaim, and AuthMetadata must be set. If the
+// request doesn't have that identity, the header is left off.
+type IdentityHeader struct {
+	// The name of the header to set.
+	// +kubebuilder:validation:Required
+	Name string `json:"name,omitempty"`
+
+	// A field of the client's TLS certificate. This needs the Host's TLS to
+	// require (or at least ask for) client certificates.
+	// +kubebuilder:validation:Enum={"uriSAN","dnsSAN","subject","fingerprint"}
+	ClientCert string `json:"clientCert,omitempty"`
+
+	// A claim of the JWT that the AuthService verified. The AuthService has
+	// to return the JWT's payload in its dynamic metadata, as `jwt_payload`.
+	JWTClaim string `json:"jwtClaim,omitempty"`
+
+	// A key in the dynamic metadata that the AuthService returned, with a
+	// "." between the keys of nested values (like "user.id").
+	AuthMetadata string `json:"authMetadata,omitempty"`
 }
 
 type TLSConfig struct {
@@ -142,6 +208,20 @@ type TLSConfig struct {
 	ECDHCurves            []string `json:"ecdh_curves,omitempty"`
 	RedirectCleartextFrom *int     `json:"redirect_cleartext_from,omitempty"`
 	SNI                   string   `json:"sni,omitempty"`
+
+	// See the TLSContext's `crl_url`.
+	CRLURL string `json:"crl_url,omitempty"`
+
+	// See the TLSContext's `ocsp_staple_policy`. The certificate is the
+	// Host's `tlsSecret`.
+	//
+	// +kubebuilder:validation:Enum={"lenient_stapling", "strict_stapling", "must_staple"}
+	OCSPStaplePolicy string `json:"ocsp_staple_policy,omitempty"`
+
+	// See the TLSContext's `tls_policy`.
+	//
+	// +kubebuilder:validation:Enum={"modern", "intermediate", "old", "custom"}
+	TLSPolicy string `json:"tls_policy,omitempty"`
 }
 
 // The first value listed in the Enum marker becomes the "zero" value,
@@ -176,6 +256,11 @@ type HostStatus struct {
 	ErrorReason    string           `json:"errorReason,omitempty"`
 	ErrorTimestamp *metav1.Time     `json:"errorTimestamp,omitempty"`
 	ErrorBackoff   *metav1.Duration `json:"errorBackoff,omitempty"`
+
+	// loadBalancer is where traffic for this Host's hostname should be
+	// sent, in the same form as a Service's or an Ingress's status, so that
+	// external-dns can create DNS records for it.
+	LoadBalancer *corev1.LoadBalancerStatus `json:"loadBalancer,omitempty"`
 }
 
 // +kubebuilder:validation:Enum={"Unknown","None","Other","ACME"}
@@ -132,6 +132,88 @@ type HostBindingType struct {
 	Selector  *metav1.LabelSelector `json:"selector,omitempty"`
 }
 
+// ConnectionBalanceType defines how Envoy spreads the connections to a Listener across its
+// worker threads.
+// +kubebuilder:validation:Enum=exact
+type ConnectionBalanceType string
+
+const (
+	// ExactConnectionBalanceType hands each new connection to the worker thread with the fewest
+	// active connections. It costs a lock on every accept, so it's only worth it for Listeners
+	// with relatively few, long-lived connections, where the kernel's spread is uneven.
+	ExactConnectionBalanceType ConnectionBalanceType = "exact"
+)
+
+// SocketOptionStateType defines when Envoy sets a socket option on a Listener's socket.
+// +kubebuilder:validation:Enum=PREBIND;BOUND;LISTENING
+type SocketOptionStateType string
+
+const (
+	// PREBINDSocketOptionStateType sets the option after the socket is created, but before it's
+	// bound.
+	PREBINDSocketOptionStateType SocketOptionStateType = "PREBIND"
+
+	// BOUNDSocketOptionStateType sets the option after the socket is bound, but before it's
+	// listening.
+	BOUNDSocketOptionStateType SocketOptionStateType = "BOUND"
+
+	// LISTENINGSocketOptionStateType sets the option once the socket is listening.
+	LISTENINGSocketOptionStateType SocketOptionStateType = "LISTENING"
+)
+
+// ListenerSocketOption is a raw setsockopt() for a Listener's socket. Level and Name are the
+// numbers for the platform that Envoy runs on (for example, 6 and 23 for TCP_FASTOPEN on Linux);
+// exactly one of IntValue and BufValue must be supplied.
+type ListenerSocketOption struct {
+	// Description is for humans; Envoy only uses it in logs.
+	Description string `json:"description,omitempty"`
+
+	// +kubebuilder:validation:Required
+	Level int64 `json:"level"`
+
+	// +kubebuilder:validation:Required
+	Name int64 `json:"name"`
+
+	IntValue *int64 `json:"intValue,omitempty"`
+
+	BufValue []byte `json:"bufValue,omitempty"`
+
+	// State is when to set the option. The default is PREBIND.
+	State SocketOptionStateType `json:"state,omitempty"`
+}
+
+// ListenerRequestNormalization is how Envoy normalizes request paths and headers on a Listener,
+// overriding the ambassador Module's request_normalization. Anything it doesn't set comes from
+// the Module.
+type ListenerRequestNormalization struct {
+	// NormalizePath resolves . and .. in the path before routing.
+	NormalizePath *bool `json:"normalizePath,omitempty"`
+
+	// MergeSlashes turns runs of slashes in the path into one before routing.
+	MergeSlashes *bool `json:"mergeSlashes,omitempty"`
+
+	// EscapedSlashesAction is what to do with a path with %2F or %5C in it.
+	// +kubebuilder:validation:Enum=KEEP_UNCHANGED;REJECT_REQUEST;UNESCAPE_AND_REDIRECT;UNESCAPE_AND_FORWARD
+	EscapedSlashesAction string `json:"escapedSlashesAction,omitempty"`
+
+	// HeadersWithUnderscoresAction is what to do with a header with an underscore in its name.
+	// +kubebuilder:validation:Enum=ALLOW;REJECT_REQUEST;DROP_HEADER
+	HeadersWithUnderscoresAction string `json:"headersWithUnderscoresAction,omitempty"`
+}
+
+// ListenerTLSFingerprint is how Envoy fingerprints TLS clients on a Listener.
+type ListenerTLSFingerprint struct {
+	// JA3 has Envoy compute the JA3 fingerprint of each client's TLS ClientHello, for the
+	// access logs, and for Header.
+	JA3 bool `json:"ja3,omitempty"`
+
+	// Header is the request header that gets the fingerprint, for upstreams and HTTP
+	// AuthServices. Whatever a client sends in it is dropped. There's no header if this
+	// isn't set.
+	// +kubebuilder:validation:Pattern=`^[-a-zA-Z0-9]+$`
+	Header string `json:"header,omitempty"`
+}
+
 // ListenerSpec defines the desired state of this Port
 type ListenerSpec struct {
 	AmbassadorID AmbassadorID `json:"ambassador_id,omitempty"`
@@ -167,6 +249,47 @@ type ListenerSpec struct {
 	// HostBinding allows restricting which Hosts will be used for this Listener.
 	// +kubebuilder:validation:Required
 	HostBinding HostBindingType `json:"hostBinding"`
+
+	// TLSPolicy is the TLS policy for Hosts on this Listener that don't
+	// set their own `tls_policy`. It overrides the Ambassador Module's
+	// `tls_policy`.
+	// +kubebuilder:validation:Enum={"modern", "intermediate", "old"}
+	TLSPolicy string `json:"tlsPolicy,omitempty"`
+
+	// ReusePort specifies whether Envoy sets SO_REUSEPORT on this Listener, giving each worker
+	// thread a socket of its own so that the kernel spreads connections across them. Envoy
+	// defaults to true on Linux. Envoy can't change this on a Listener that's already running,
+	// so changing it means restarting the pod.
+	ReusePort *bool `json:"reusePort,omitempty"`
+
+	// ConnectionBalance specifies how Envoy spreads the connections to this Listener across
+	// its worker threads, beyond what the kernel does. Only TCP Listeners support it.
+	ConnectionBalance ConnectionBalanceType `json:"connectionBalance,omitempty"`
+
+	// TCPBacklogSize is the most connections that can be waiting to be accepted on this
+	// Listener. The default is net.core.somaxconn on Linux.
+	// +kubebuilder:validation:Minimum=1
+	TCPBacklogSize *int32 `json:"tcpBacklogSize,omitempty"`
+
+	// SocketOptions are extra socket options to set on this Listener's socket. Unless
+	// ReusePort is on, Envoy can't change them on a Listener that's already running.
+	SocketOptions []ListenerSocketOption `json:"socketOptions,omitempty"`
+
+	// View is the DNS view that this Listener serves, like "internal" or "external", for
+	// split-horizon setups where the same hostname goes to different places depending on
+	// where the request came in. Mappings that list views only get routes on the Listeners
+	// in one of them.
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	View string `json:"view,omitempty"`
+
+	// RequestNormalization overrides how the ambassador Module has Envoy normalize request
+	// paths and headers, for this Listener. Doing less than the Module asks for works, but
+	// gets a notice.
+	RequestNormalization *ListenerRequestNormalization `json:"requestNormalization,omitempty"`
+
+	// TLSFingerprint has Envoy fingerprint the TLS clients of this Listener, for the access
+	// logs, upstreams, and AuthServices.
+	TLSFingerprint *ListenerTLSFingerprint `json:"tlsFingerprint,omitempty"`
 }
 
 // Listener is the Schema for the hosts API
@@ -75,7 +75,7 @@ type MappingSpec struct {
 	ConnectTimeout               *MillisecondDuration `json:"connect_timeout_ms,omitempty"`
 	ClusterIdleTimeout           *MillisecondDuration `json:"cluster_idle_timeout_ms,omitempty"`
 	ClusterMaxConnectionLifetime *MillisecondDuration `json:"cluster_max_connection_lifetime_ms,omitempty"`
-	// The timeout for requests that use this Mapping. Overrides `cluster_request_timeout_ms` set on the Ambassador Module, if it exists.
+	// The timeout for requests that use this Mapping. Overrides `cluster_request_timeout_ms` set on the Ambassador Module and `request_timeout_ms` set on the Host, if they exist.
 	Timeout     *MillisecondDuration `json:"timeout_ms,omitempty"`
 	IdleTimeout *MillisecondDuration `json:"idle_timeout_ms,omitempty"`
 	TLS         string               `json:"tls,omitempty"`
@@ -159,6 +159,55 @@ type MappingSpec struct {
 	QueryParameters      map[string]string `json:"query_parameters,omitempty"`
 	RegexQueryParameters map[string]string `json:"regex_query_parameters,omitempty"`
 	StatsName            string            `json:"stats_name,omitempty"`
+	// SubsetLabels routes this Mapping's requests only to the endpoints of its service whose
+	// Pods have all of these labels (say, version=v2), so that Mappings can pick out versions
+	// of a service without a Service per version. It needs an endpoint resolver.
+	SubsetLabels map[string]string `json:"subset_labels,omitempty"`
+	// Failover sends this Mapping's requests on to other services, in order, when too few of
+	// the endpoints of its own service are healthy.
+	Failover *FailoverPolicy `json:"failover,omitempty"`
+	// GRPCTranscoder transcodes REST+JSON requests to this Mapping into gRPC calls to its
+	// service, which has to be a gRPC service (so `grpc` has to be true).
+	GRPCTranscoder *GRPCTranscoder `json:"grpc_transcoder,omitempty"`
+	// Fallback sends whatever share of requests this Mapping's weights leave over to the
+	// Mappings that would get them without this Mapping's header and query parameter
+	// matches, instead of scaling this Mapping up to all of them. With a header match and a
+	// weight, that dark-launches a service to a percentage of the requests with the header.
+	Fallback *bool `json:"fallback,omitempty"`
+	// UpstreamProtocol is the protocol to speak to this Mapping's service: "http/1.1", "h2"
+	// (HTTP/2 over TLS), "h2c" (HTTP/2 in cleartext), or "auto" (whichever the service picks
+	// with ALPN, which needs TLS). Without it, `grpc` means HTTP/2; otherwise the appProtocol
+	// of the service's port decides, and without that it's HTTP/1.1.
+	// +kubebuilder:validation:Enum={"http/1.1","h2","h2c","auto"}
+	UpstreamProtocol string `json:"upstream_protocol,omitempty"`
+	// OpenAPI says where the OpenAPI document describing this Mapping's service is, so that
+	// the document can be served, rewritten to this Mapping's public paths, as part of the API
+	// docs of its Hosts.
+	OpenAPI *MappingOpenAPI `json:"openapi,omitempty"`
+	// ContractCapture mirrors a sampled, redacted copy of this Mapping's requests and
+	// responses to a capture service, so that contract tests can be built from real traffic.
+	ContractCapture *MappingContractCapture `json:"contract_capture,omitempty"`
+	// Views are the DNS views that this Mapping is in (see the Listener's `view`). A Mapping
+	// with views only gets routes on the Listeners in one of them, so the same hostname and
+	// prefix can go to one service on internal Listeners and another on external ones. A
+	// Mapping without views gets routes on every Listener.
+	Views []string `json:"views,omitempty"`
+	// Maintenance, when it's enabled, answers this Mapping's requests with a static response
+	// instead of sending them to its service.
+	Maintenance *Maintenance `json:"maintenance,omitempty"`
+	// Upstreams, if set, are where this Mapping's endpoints come from instead of its service.
+	// Kubernetes services, Consul services, and IP addresses can all be mixed in one cluster,
+	// with weights and failover priorities, to move traffic between platforms. The service
+	// still decides whether to originate TLS, and names the cluster's stats.
+	Upstreams []MappingUpstream `json:"upstreams,omitempty"`
+	// StaticResponse, if set, is what Envoy answers this Mapping's requests with itself,
+	// instead of sending them to its service: a health check stub, a robots.txt, or a
+	// redirect, say. The service still has to be given, but nothing has to answer there.
+	StaticResponse *MappingStaticResponse `json:"static_response,omitempty"`
+	// Critical, if false, keeps this Mapping's configuration errors out of Ambassador's own
+	// health: diagd's environment check and the diagnostics_errors gauge don't count them.
+	// They're still reported. The default is true.
+	Critical *bool `json:"critical,omitempty"`
 
 	V2ExplicitTLS         *V2ExplicitTLS `json:"v2ExplicitTLS,omitempty"`
 	V2BoolHeaders         []string       `json:"v2BoolHeaders,omitempty"`
@@ -316,12 +365,158 @@ type LoadBalancerCookie struct {
 	Ttl  string `json:"ttl,omitempty"`
 }
 
+// MappingUpstream is one of the sources of a Mapping's endpoints. Within a priority, each
+// upstream gets its weight's share of the requests; Envoy moves traffic down a priority as the
+// one above it loses healthy endpoints.
+type MappingUpstream struct {
+	// Service is written the same way as the Mapping's `service`, but without a scheme. Its
+	// port defaults to the Mapping's.
+	// +kubebuilder:validation:Required
+	Service string `json:"service,omitempty"`
+
+	// Resolver finds the service's endpoints, and defaults to the Mapping's. It has to be one
+	// that finds endpoints (a KubernetesEndpointResolver, ConsulResolver, or PluginResolver),
+	// unless the service is an IP address.
+	Resolver string `json:"resolver,omitempty"`
+
+	// Weight is this upstream's share of its priority's requests, relative to the other
+	// upstreams at the same priority. Defaults to 1.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=1000000
+	Weight *int `json:"weight,omitempty"`
+
+	// Priority is 0 for the most preferred upstreams, and higher for the ones to fail over to.
+	// Defaults to 0.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=127
+	Priority *int `json:"priority,omitempty"`
+}
+
+// FailoverPolicy lists the services that a Mapping falls back to, and says when an endpoint
+// counts as unhealthy. The Mapping's own service is priority 0 and each of Services is the next
+// priority down; Envoy moves traffic down a priority as the one above it loses healthy endpoints.
+type FailoverPolicy struct {
+	// Services to fail over to, most preferred first. Each is written the same way as the
+	// Mapping's `service`, and is resolved the same way.
+	// +kubebuilder:validation:MinItems=1
+	// +kubebuilder:validation:Required
+	Services []string `json:"services,omitempty"`
+
+	// HealthyPercent is how much of a priority has to be healthy for it to take all of the
+	// traffic; below that, the rest spills over to the next priority. Defaults to 71, which is
+	// what Envoy does by default.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	HealthyPercent *int `json:"healthy_percent,omitempty"`
+
+	// Consecutive5xx is how many 5xx responses in a row get an endpoint ejected as
+	// unhealthy. Defaults to 5.
+	// +kubebuilder:validation:Minimum=1
+	Consecutive5xx *int `json:"consecutive_5xx,omitempty"`
+
+	// EjectionTime is how long an unhealthy endpoint is ejected for, the first time.
+	// Defaults to 30s.
+	EjectionTime *MillisecondDuration `json:"ejection_time_ms,omitempty"`
+}
+
+// GRPCTranscoder says which protobuf descriptors a Mapping transcodes with. The descriptors
+// come from a ConfigMap labeled `getambassador.io/grpc-descriptors`, where each key of its
+// binaryData is one version of a FileDescriptorSet (as made by `protoc --include_imports
+// --descriptor_set_out`).
+type GRPCTranscoder struct {
+	// Descriptors is the name of the ConfigMap, in the Mapping's namespace unless written
+	// as "name.namespace".
+	// +kubebuilder:validation:Required
+	Descriptors string `json:"descriptors,omitempty"`
+
+	// Version is the key of the ConfigMap to use. Defaults to the ConfigMap's
+	// `getambassador.io/grpc-descriptors-default` annotation, or its only key if it has
+	// only one.
+	Version string `json:"version,omitempty"`
+
+	// Services are the fully-qualified names of the gRPC services to transcode, like
+	// "bookstore.Bookstore".
+	// +kubebuilder:validation:MinItems=1
+	// +kubebuilder:validation:Required
+	Services []string `json:"services,omitempty"`
+}
+
+// MappingOpenAPI says where a Mapping's OpenAPI document is: at a URL, or in a ConfigMap. One
+// of URL and ConfigMap has to be set.
+type MappingOpenAPI struct {
+	// URL is where to fetch the document from: a path on the Mapping's service, like
+	// "/openapi.json", or an absolute http or https URL.
+	URL string `json:"url,omitempty"`
+
+	// ConfigMap is the name of a ConfigMap labeled `getambassador.io/openapi` holding the
+	// document (as JSON or YAML), in the Mapping's namespace unless written as "name.namespace".
+	ConfigMap string `json:"config_map,omitempty"`
+
+	// Key is the key of the ConfigMap to use. Defaults to the ConfigMap's only key, if it has
+	// only one.
+	Key string `json:"key,omitempty"`
+}
+
+// MappingContractCapture says where a Mapping's traffic is mirrored to for contract testing,
+// and how much of it.
+type MappingContractCapture struct {
+	// Endpoint is the http or https URL that each captured exchange is POSTed to, as JSON.
+	// +kubebuilder:validation:Required
+	Endpoint string `json:"endpoint"`
+
+	// The percentage of requests that are captured. The default is 1.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	SamplePercent *int `json:"sample_percent,omitempty"`
+
+	// How much of each request and response body is kept. The rest is dropped, and the
+	// exchange marked as truncated. The default is 4096.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=1048576
+	MaxBodyBytes *int `json:"max_body_bytes,omitempty"`
+
+	// Headers whose values are replaced with "[REDACTED]", on top of Authorization,
+	// Proxy-Authorization, Cookie, Set-Cookie, and X-API-Key, which always are.
+	RedactHeaders []string `json:"redact_headers,omitempty"`
+}
+
+// MappingStaticResponse is a response that Envoy serves for a Mapping without going upstream.
+type MappingStaticResponse struct {
+	// The status code of the response. The default is 200.
+	// +kubebuilder:validation:Minimum=200
+	// +kubebuilder:validation:Maximum=599
+	StatusCode *int `json:"status_code,omitempty"`
+
+	// Headers to add to the response, like Location for a redirect.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// The body of the response, up to 64KiB. The default is an empty body.
+	Body string `json:"body,omitempty"`
+
+	// The Content-Type of the body. The default is "text/plain".
+	ContentType string `json:"content_type,omitempty"`
+
+	// Templated lets the body and the header values use Envoy's command operators, like
+	// %REQ(:path)% or %DOWNSTREAM_REMOTE_ADDRESS_WITHOUT_PORT%, to put attributes of the
+	// request in the response. Otherwise they're sent exactly as written.
+	Templated bool `json:"templated,omitempty"`
+}
+
 // MappingStatus defines the observed state of Mapping
 type MappingStatus struct {
 	// +kubebuilder:validation:Enum={"","Inactive","Running"}
 	State string `json:"state,omitempty"`
 
 	Reason string `json:"reason,omitempty"`
+
+	// Code is the stable error code (like "AMB2000") for the error that Reason
+	// describes. Unlike Reason, it won't change from release to release.
+	Code string `json:"code,omitempty"`
+
+	// Warning describes a problem that doesn't stop the Mapping from running,
+	// but probably means that requests to it will fail, like an upstream that
+	// failed its pre-flight check.
+	Warning string `json:"warning,omitempty"`
 }
 
 // Mapping is the Schema for the mappings API
@@ -20,6 +20,8 @@
 package v3alpha1
 
 import (
+	"encoding/json"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -32,7 +34,8 @@ type ModuleSpec struct {
 	// `diagnostics.enabled=false`.  This needs conversion support in apiext.  See the related
 	// comment in irambassador.py.
 	//
-	// TODO(lukeshu): Structurally type ModuleSpec.Config.
+	// The config of the "ambassador" Module is checked against AmbassadorConfigSpec; see
+	// ConfigErrors.
 	//
 	// +kubebuilder:validation:Required
 	Config UntypedDict `json:"config,omitempty"`
@@ -62,79 +65,17 @@ type ModuleList struct {
 	Items           []Module `json:"items"`
 }
 
-type Features struct {
-	// The diagnostic service (at /ambassador/v0/diag/) defaults on, but
-	// you can disable the api route. It will remain accessible on
-	// diag_port.
-	Diagnostics bool `json:"diagnostics,omitempty"`
-
-	// Should we automatically add Linkerd `l5d-dst-override` headers?
-	LinkerdHeaders bool `json:"linkerd_headers,omitempty"`
-
-	// Should we enable the gRPC-http11 bridge?
-	GrpcHttp11Bridge bool `json:"grpc_http11_bridge,omitempty"`
-
-	// Should we enable the grpc-Web protocol?
-	GrpcWeb bool `json:"grpc_web,omitempty"`
-
-	// Should we enable http/1.0 protocol?
-	Http10 bool `json:"http10,omitempty"`
-
-	// Should we do IPv4 DNS lookups when contacting services? Defaults to true,
-	// but can be overridden in a [`Mapping`](/reference/mappings).
-	Ipv4Dns bool `json:"ipv4_dns,omitempty"`
-
-	// Should we do IPv6 DNS lookups when contacting services? Defaults to false,
-	// but can be overridden in a [`Mapping`](/reference/mappings).
-	Ipv6Dns bool `json:"ipv6_dns,omitempty"`
-
-	// liveness_probe defaults on, but you can disable the api route.
-	// It will remain accessible on diag_port.
-	LivenessProbe bool `json:"liveness_probe,omitempty"`
-
-	// readiness_probe defaults on, but you can disable the api route.
-	// It will remain accessible on diag_port.
-	ReadinessProbe bool `json:"readiness_probe,omitempty"`
-
-	// xff_num_trusted_hops controls the how Envoy sets the trusted
-	// client IP address of a request. If you have a proxy in front
-	// of Ambassador, Envoy will set the trusted client IP to the
-	// address of that proxy. To preserve the orginal client IP address,
-	// setting x_num_trusted_hops: 1 will tell Envoy to use the client IP
-	// address in X-Forwarded-For. Please see the envoy documentation for
-	// more information: https://www.envoyproxy.io/docs/envoy/latest/configuration/http_conn_man/headers#x-forwarded-for
-	XffNumTrustedHops int `json:"xff_num_trusted_hops,omitempty"`
-
-	// proxy_proto controls whether Envoy will honor the PROXY
-	// protocol on incoming requests.
-	ProxyProto bool `json:"proxy_proto,omitempty"`
-
-	// remote_address controls whether Envoy will trust the remote
-	// address of incoming connections or rely exclusively on the
-	// X-Forwarded_For header.
-	RemoteAddress bool `json:"remote_address,omitempty"`
-
-	// Ambassador lets through only the HTTP requests with
-	// `X-FORWARDED-PROTO: https` header set, and redirects all the other
-	// requests to HTTPS if this field is set to true. Note that `use_remote_address`
-	// must be set to false for this feature to work as expected.
-	XForwardedProtoRedirect bool `json:"x_forwarded_proto_redirect,omitempty"`
-}
-
-// AmbassadorConfigSpec defines the desired state of AmbassadorConfig
+// AmbassadorConfigSpec is the config of the "ambassador" Module, typed. The config itself is
+// still an UntypedDict on the wire (what goes in a Module's config depends on its name, which a
+// CRD schema can't express), so this is what Module.ConfigErrors checks it against; see
+// module_config.go. Anything that the ambassador Module accepts has to be here, or it gets
+// reported as a typo.
 type AmbassadorConfigSpec struct {
-	// Common to all Ambassador objects (and optional).
-	AmbassadorID AmbassadorID `json:"ambassador_id,omitempty"`
-
 	// admin_port is the port where Ambassador's Envoy will listen for
 	// low-level admin requests. You should almost never need to change
 	// this.
 	AdminPort int `json:"admin_port,omitempty"`
 
-	// diag_port is the port where Ambassador will listen for requests
-	// to the diagnostic service.
-	DiagPort int `json:"diag_port,omitempty"`
-
 	// By default Envoy sets server_name response header to 'envoy'
 	// Override it with this variable
 	ServerName string `json:"server_name,omitempty"`
@@ -144,8 +85,6 @@ type AmbassadorConfigSpec struct {
 	// use 8443 if TLS is configured, 8080 otherwise.
 	ServicePort int `json:"service_port,omitempty"`
 
-	Features *Features `json:"features,omitempty"`
-
 	// run a custom lua script on every request. see below for more details.
 	LuaScripts string `json:"lua_scripts,omitempty"`
 
@@ -155,9 +94,13 @@ type AmbassadorConfigSpec struct {
 	// envoy_log_path defines the path of log envoy will use. By default this is standard output
 	EnvoyLogPath string `json:"envoy_log_path,omitempty"`
 
+	// envoy_log_format is a format string when envoy_log_type is "text", and an object of
+	// format strings when it's "json".
+	EnvoyLogFormat json.RawMessage `json:"envoy_log_format,omitempty"`
+
 	LoadBalancer *LoadBalancer `json:"load_balancer,omitempty"`
 
-	CircuitBreakers *CircuitBreaker `json:"circuit_breakers,omitempty"`
+	CircuitBreakers []*CircuitBreaker `json:"circuit_breakers,omitempty"`
 
 	// List of HTTP error response overrides.
 	// +kubebuilder:validation:MinItems=1
@@ -170,7 +113,7 @@ type AmbassadorConfigSpec struct {
 
 	RetryPolicy *RetryPolicy `json:"retry_policy,omitempty"`
 
-	Cors *CORS `json:"cors,omitempty"`
+	Cors *ModuleCORS `json:"cors,omitempty"`
 
 	// Set the default upstream-connection request timeout. If not set (the default), upstream
 	// requests will be subject to a 3000 millisecond timeout.
@@ -192,6 +135,331 @@ type AmbassadorConfigSpec struct {
 	// This field controls the RE2 “program size” which is a rough estimate of how complex a compiled regex is to
 	// evaluate.  A regex that has a program size greater than the configured value will fail to compile.
 	RegexMaxSize int `json:"regex_max_size,omitempty"`
+
+	// The TLS policy for Hosts and TLSContexts that don't set their own
+	// `tls_policy`, and that aren't on a Listener with a `tlsPolicy`. Hosts
+	// with a weaker policy than this are reported, but still work.
+	//
+	// +kubebuilder:validation:Enum={"modern", "intermediate", "old"}
+	TLSPolicy string `json:"tls_policy,omitempty"`
+
+	// Should we automatically add Linkerd `l5d-dst-override` headers?
+	AddLinkerdHeaders *bool `json:"add_linkerd_headers,omitempty"`
+
+	// Allow HTTP/1.1 requests with both Content-Length and Transfer-Encoding: chunked.
+	AllowChunkedLength *bool `json:"allow_chunked_length,omitempty"`
+
+	AuthEnabled *bool `json:"auth_enabled,omitempty"`
+
+	// Buffer whole requests before sending them upstream.
+	Buffer *ModuleBuffer `json:"buffer,omitempty"`
+
+	// The per-connection buffer limit for Envoy's listeners.
+	BufferLimitBytes *int `json:"buffer_limit_bytes,omitempty"`
+
+	DebugMode *bool `json:"debug_mode,omitempty"`
+
+	// Add response headers that say how a request was routed, for requests that carry a
+	// short-lived debug token.
+	DebugHeaders *ModuleDebugHeaders `json:"debug_headers,omitempty"`
+
+	DefaultLabelDomain string      `json:"default_label_domain,omitempty"`
+	DefaultLabels      UntypedDict `json:"default_labels,omitempty"`
+
+	// Defaults for other kinds of resources, by kind.
+	Defaults UntypedDict `json:"defaults,omitempty"`
+
+	// The diagnostic service (at /ambassador/v0/diag/) defaults on, but
+	// you can disable the api route. It will remain accessible on
+	// diag_port.
+	Diagnostics *ModuleDiagnostics `json:"diagnostics,omitempty"`
+
+	// Should we enable the gRPC-http11 bridge?
+	EnableGRPCHTTP11Bridge *bool `json:"enable_grpc_http11_bridge,omitempty"`
+
+	// Should we enable the grpc-Web protocol?
+	EnableGRPCWeb *bool `json:"enable_grpc_web,omitempty"`
+
+	// Should we enable http/1.0 protocol?
+	EnableHTTP10 *bool `json:"enable_http10,omitempty"`
+
+	// Should we do IPv4 DNS lookups when contacting services? Defaults to true,
+	// but can be overridden in a [`Mapping`](/reference/mappings).
+	EnableIPv4 *bool `json:"enable_ipv4,omitempty"`
+
+	// Should we do IPv6 DNS lookups when contacting services? Defaults to false,
+	// but can be overridden in a [`Mapping`](/reference/mappings).
+	EnableIPv6 *bool `json:"enable_ipv6,omitempty"`
+
+	// How long, in seconds, to let Envoy take to validate a new configuration.
+	EnvoyValidationTimeout *int `json:"envoy_validation_timeout,omitempty"`
+
+	// +kubebuilder:validation:Enum={"SANITIZE", "FORWARD_ONLY", "APPEND_FORWARD", "SANITIZE_SET", "ALWAYS_FORWARD_ONLY"}
+	ForwardClientCertDetails string `json:"forward_client_cert_details,omitempty"`
+
+	GRPCStats *ModuleGRPCStats `json:"grpc_stats,omitempty"`
+
+	Gzip *ModuleGzip `json:"gzip,omitempty"`
+
+	// +kubebuilder:validation:Enum={"ALLOW", "REJECT_REQUEST", "DROP_HEADER"}
+	HeadersWithUnderscoresAction string `json:"headers_with_underscores_action,omitempty"`
+
+	// Only one of ip_allow and ip_deny can be set.
+	IPAllow []ModuleIPPrincipal `json:"ip_allow,omitempty"`
+	IPDeny  []ModuleIPPrincipal `json:"ip_deny,omitempty"`
+
+	KeepAlive *KeepAlive `json:"keepalive,omitempty"`
+
+	ListenerIdleTimeout *MillisecondDuration `json:"listener_idle_timeout_ms,omitempty"`
+
+	// Severities for lint rules, by rule name.
+	Lint map[string]string `json:"lint,omitempty"`
+
+	// liveness_probe defaults on, but you can disable the api route.
+	// It will remain accessible on diag_port.
+	LivenessProbe *ModuleProbe `json:"liveness_probe,omitempty"`
+
+	// Shed some new requests, with a 503 and a Retry-After, when the pod is short of memory
+	// or CPU.
+	LoadShedding *ModuleLoadShedding `json:"load_shedding,omitempty"`
+
+	MaxRequestHeadersKb *int `json:"max_request_headers_kb,omitempty"`
+
+	MergeSlashes *bool `json:"merge_slashes,omitempty"`
+
+	PreserveExternalRequestID *bool `json:"preserve_external_request_id,omitempty"`
+
+	ProperCase *bool `json:"proper_case,omitempty"`
+
+	PruneUnreachableRoutes *bool `json:"prune_unreachable_routes,omitempty"`
+
+	// readiness_probe defaults on, but you can disable the api route.
+	// It will remain accessible on diag_port.
+	ReadinessProbe *ModuleProbe `json:"readiness_probe,omitempty"`
+
+	RejectRequestsWithEscapedSlashes *bool `json:"reject_requests_with_escaped_slashes,omitempty"`
+
+	// How Envoy normalizes request paths and headers before routing, for every Listener that
+	// doesn't set its own requestNormalization.
+	RequestNormalization *ModuleRequestNormalization `json:"request_normalization,omitempty"`
+
+	// The resolver for Mappings that don't name one.
+	Resolver string `json:"resolver,omitempty"`
+
+	SetCurrentClientCertDetails *ModuleClientCertDetails `json:"set_current_client_cert_details,omitempty"`
+
+	// Where Envoy sends its stats, and which stats it keeps. This overrides STATSD_ENABLED.
+	StatsSinks *ModuleStatsSinks `json:"stats_sinks,omitempty"`
+
+	// Statsd did something in Emissary 1.x; statsd is configured from the environment now.
+	Statsd UntypedDict `json:"statsd,omitempty"`
+
+	StripMatchingHostPort *bool `json:"strip_matching_host_port,omitempty"`
+
+	SuppressEnvoyHeaders *bool `json:"suppress_envoy_headers,omitempty"`
+
+	// TLS is deprecated (use a TLSContext), but still reported when it's used.
+	TLS UntypedDict `json:"tls,omitempty"`
+
+	UseAmbassadorNamespaceForServiceResolution *bool `json:"use_ambassador_namespace_for_service_resolution,omitempty"`
+
+	// use_proxy_proto controls whether Envoy will honor the PROXY
+	// protocol on incoming requests.
+	UseProxyProto *bool `json:"use_proxy_proto,omitempty"`
+
+	// use_remote_address controls whether Envoy will trust the remote
+	// address of incoming connections or rely exclusively on the
+	// X-Forwarded_For header.
+	UseRemoteAddress *bool `json:"use_remote_address,omitempty"`
+
+	// Ambassador lets through only the HTTP requests with
+	// `X-FORWARDED-PROTO: https` header set, and redirects all the other
+	// requests to HTTPS if this field is set to true. Note that `use_remote_address`
+	// must be set to false for this feature to work as expected.
+	XForwardedProtoRedirect *bool `json:"x_forwarded_proto_redirect,omitempty"`
+
+	// xff_num_trusted_hops controls the how Envoy sets the trusted
+	// client IP address of a request. If you have a proxy in front
+	// of Ambassador, Envoy will set the trusted client IP to the
+	// address of that proxy. To preserve the orginal client IP address,
+	// setting x_num_trusted_hops: 1 will tell Envoy to use the client IP
+	// address in X-Forwarded-For. Please see the envoy documentation for
+	// more information: https://www.envoyproxy.io/docs/envoy/latest/configuration/http_conn_man/headers#x-forwarded-for
+	XffNumTrustedHops *int `json:"xff_num_trusted_hops,omitempty"`
+}
+
+// ModuleProbe is the liveness_probe or readiness_probe of the ambassador Module.
+type ModuleProbe struct {
+	Enabled *bool  `json:"enabled,omitempty"`
+	Prefix  string `json:"prefix,omitempty"`
+	Rewrite string `json:"rewrite,omitempty"`
+	Service string `json:"service,omitempty"`
+}
+
+// ModuleLoadShedding is the load_shedding of the ambassador Module. Envoy's overload manager turns
+// away a fraction of new requests that goes from none at the scaling threshold to all of them at
+// the saturation threshold. At least one of MaxHeapBytes and CPU has to be set.
+type ModuleLoadShedding struct {
+	// Memory pressure is Envoy's heap size over this.
+	MaxHeapBytes *int64 `json:"max_heap_bytes,omitempty"`
+	// CPU pressure is the pod's CPU usage over its CPU limit, as the entrypoint works it out.
+	CPU *bool `json:"cpu,omitempty"`
+	// The pressure to start shedding at; 0.9 by default.
+	ScalingThreshold *float64 `json:"scaling_threshold,omitempty"`
+	// The pressure to shed every request at; 0.98 by default.
+	SaturationThreshold *float64 `json:"saturation_threshold,omitempty"`
+	// The Retry-After on the 503s, in seconds; 5 by default. 0 leaves it out.
+	RetryAfter *int `json:"retry_after,omitempty"`
+}
+
+// ModuleStatsSinks is the stats_sinks of the ambassador Module. Include and Exclude are Envoy's
+// stats matcher, for every stat and not just the sinks', so only one of them can be set.
+type ModuleStatsSinks struct {
+	// Seconds between flushes to the sinks; Envoy's default is 5.
+	FlushInterval *int              `json:"flush_interval,omitempty"`
+	StatsD        *ModuleStatsDSink `json:"statsd,omitempty"`
+	OTLP          *ModuleOTLPSink   `json:"otlp,omitempty"`
+	// Regular expressions for the only stats to keep; Emissary adds the ones that it watches.
+	Include []string `json:"include,omitempty"`
+	// Regular expressions for the stats to drop.
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// ModuleStatsDSink is a StatsD server for Envoy's stats.
+type ModuleStatsDSink struct {
+	// A host, or host:port; the port defaults to 8125.
+	Address string `json:"address,omitempty"`
+	// statsd (the default) folds Envoy's tags into the stat names; dogstatsd sends them as tags.
+	// +kubebuilder:validation:Enum={"statsd", "dogstatsd"}
+	TagStyle string `json:"tag_style,omitempty"`
+	// The prefix of every stat name; Envoy's default is "envoy".
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// ModuleOTLPSink is an OpenTelemetry collector for Envoy's stats, over gRPC. It needs an Envoy
+// with the OpenTelemetry stats sink.
+type ModuleOTLPSink struct {
+	// A host, or host:port; the port defaults to 4317.
+	Address string `json:"address,omitempty"`
+	// Report counters and histograms as deltas, rather than cumulatively.
+	Deltas *bool `json:"deltas,omitempty"`
+}
+
+// ModuleRequestNormalization is the request_normalization of the ambassador Module. Anything it
+// doesn't set comes from merge_slashes, reject_requests_with_escaped_slashes, and
+// headers_with_underscores_action.
+type ModuleRequestNormalization struct {
+	// Resolve . and .. in the path before routing; true by default.
+	NormalizePath *bool `json:"normalize_path,omitempty"`
+	// Turn runs of slashes in the path into one before routing; false by default.
+	MergeSlashes *bool `json:"merge_slashes,omitempty"`
+	// What to do with a path with %2F or %5C in it; Envoy's default is KEEP_UNCHANGED.
+	// +kubebuilder:validation:Enum={"KEEP_UNCHANGED", "REJECT_REQUEST", "UNESCAPE_AND_REDIRECT", "UNESCAPE_AND_FORWARD"}
+	EscapedSlashesAction string `json:"escaped_slashes_action,omitempty"`
+	// What to do with a header with an underscore in its name; Envoy's default is ALLOW.
+	// +kubebuilder:validation:Enum={"ALLOW", "REJECT_REQUEST", "DROP_HEADER"}
+	HeadersWithUnderscoresAction string `json:"headers_with_underscores_action,omitempty"`
+}
+
+// ModuleDebugHeaders is the debug_headers of the ambassador Module.
+type ModuleDebugHeaders struct {
+	// The JSON Web Key Set that debug tokens are checked against.
+	JWKS      string   `json:"jwks,omitempty"`
+	Issuer    string   `json:"issuer,omitempty"`
+	Audiences []string `json:"audiences,omitempty"`
+	// The request header that carries the debug token; x-ambassador-debug-token by default.
+	Header string `json:"header,omitempty"`
+	// Tokens that expire further in the future than this don't count; 900 by default.
+	MaxLifetimeS *int `json:"max_lifetime_s,omitempty"`
+}
+
+// ModuleDiagnostics is the diagnostics of the ambassador Module.
+type ModuleDiagnostics struct {
+	Enabled *bool  `json:"enabled,omitempty"`
+	Prefix  string `json:"prefix,omitempty"`
+	Rewrite string `json:"rewrite,omitempty"`
+	Service string `json:"service,omitempty"`
+
+	// Let the diagnostics through from other than localhost even when they're disabled.
+	AllowNonLocal *bool `json:"allow_non_local,omitempty"`
+}
+
+// ModuleCORS is the default CORS policy in the ambassador Module. Unlike a Mapping's, its
+// methods and headers can be written as comma-separated strings.
+type ModuleCORS struct {
+	Origins        []string         `json:"origins,omitempty"`
+	Methods        ModuleStringList `json:"methods,omitempty"`
+	Headers        ModuleStringList `json:"headers,omitempty"`
+	Credentials    *bool            `json:"credentials,omitempty"`
+	ExposedHeaders ModuleStringList `json:"exposed_headers,omitempty"`
+	MaxAge         string           `json:"max_age,omitempty"`
+}
+
+// ModuleStringList is a list of strings, or one string of comma-separated values.
+type ModuleStringList []string
+
+func (l *ModuleStringList) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*l = nil
+		return nil
+	}
+
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		*l = ModuleStringList{str}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+type ModuleGRPCStats struct {
+	AllMethods    *bool                    `json:"all_methods,omitempty"`
+	Services      []ModuleGRPCStatsService `json:"services,omitempty"`
+	UpstreamStats *bool                    `json:"upstream_stats,omitempty"`
+}
+
+type ModuleGRPCStatsService struct {
+	Name        string   `json:"name,omitempty"`
+	MethodNames []string `json:"method_names,omitempty"`
+}
+
+type ModuleGzip struct {
+	MemoryLevel                *int     `json:"memory_level,omitempty"`
+	MinContentLength           *int     `json:"min_content_length,omitempty"`
+	CompressionLevel           string   `json:"compression_level,omitempty"`
+	CompressionStrategy        string   `json:"compression_strategy,omitempty"`
+	WindowBits                 *int     `json:"window_bits,omitempty"`
+	ContentType                []string `json:"content_type,omitempty"`
+	DisableOnEtagHeader        *bool    `json:"disable_on_etag_header,omitempty"`
+	RemoveAcceptEncodingHeader *bool    `json:"remove_accept_encoding_header,omitempty"`
+}
+
+type ModuleBuffer struct {
+	MaxRequestBytes *int `json:"max_request_bytes,omitempty"`
+
+	// MaxRequestTime did something in Emissary 1.x, but is ignored now.
+	MaxRequestTime *int `json:"max_request_time,omitempty"`
+}
+
+// ModuleIPPrincipal is one entry of ip_allow or ip_deny: exactly one of peer (the address of the
+// connection) or remote (the client address, after X-Forwarded-For), each a CIDR range.
+type ModuleIPPrincipal struct {
+	Peer   string `json:"peer,omitempty"`
+	Remote string `json:"remote,omitempty"`
+}
+
+type ModuleClientCertDetails struct {
+	Subject *bool `json:"subject,omitempty"`
+	Cert    *bool `json:"cert,omitempty"`
+	Chain   *bool `json:"chain,omitempty"`
+	DNS     *bool `json:"dns,omitempty"`
+	URI     *bool `json:"uri,omitempty"`
 }
 
 // AmbassadorConfigStatus defines the observed state of AmbassadorConfig
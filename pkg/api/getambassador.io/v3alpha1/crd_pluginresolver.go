@@ -0,0 +1,64 @@
+// Copyright 2020 Datawire.  All rights reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+///////////////////////////////////////////////////////////////////////////
+// Important: Run "make generate-fast" to regenerate code after modifying
+// this file.
+///////////////////////////////////////////////////////////////////////////
+
+package v3alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PluginResolverSpec tells Ambassador to get the endpoints of the services of the Mappings that use
+// it from a resolver plugin. Plugins are executables in AMBASSADOR_RESOLVER_PLUGINS_DIR that serve
+// the Resolver gRPC service in api/resolverplugin/resolver.proto; they're how to route to services
+// in registries that Ambassador doesn't support natively.
+type PluginResolverSpec struct {
+	AmbassadorID AmbassadorID `json:"ambassador_id,omitempty"`
+
+	// Plugin is the name of the plugin's executable in AMBASSADOR_RESOLVER_PLUGINS_DIR.
+	// +kubebuilder:validation:Required
+	Plugin string `json:"plugin"`
+
+	// Config is passed to the plugin unchanged, to tell it (for example) which registry to
+	// talk to.
+	Config map[string]string `json:"config,omitempty"`
+}
+
+// PluginResolver is the Schema for the pluginresolvers API
+//
+// +kubebuilder:object:root=true
+// +kubebuilder:storageversion
+type PluginResolver struct {
+	metav1.TypeMeta   `json:""`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec PluginResolverSpec `json:"spec,omitempty"`
+}
+
+// PluginResolverList contains a list of PluginResolvers.
+//
+// +kubebuilder:object:root=true
+type PluginResolverList struct {
+	metav1.TypeMeta `json:""`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []PluginResolver `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&PluginResolver{}, &PluginResolverList{})
+}
@@ -0,0 +1,88 @@
+// Copyright 2020 Datawire.  All rights reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+///////////////////////////////////////////////////////////////////////////
+// Important: Run "make generate-fast" to regenerate code after modifying
+// this file.
+///////////////////////////////////////////////////////////////////////////
+
+package v3alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RedirectSpec has Ambassador answer requests for some of its Hosts with a redirect, without a
+// Mapping or a service behind it. The host and the path to redirect to can be built out of the
+// capture groups of regular expressions that match the Host's hostname and the request's path.
+type RedirectSpec struct {
+	AmbassadorID AmbassadorID `json:"ambassador_id,omitempty"`
+
+	// HostRegex is a regular expression that has to match the whole hostname of a Host for the
+	// Redirect to apply to it. Only Hosts with a hostname that isn't a wildcard can match. If
+	// it's not given, the Redirect applies to every Host.
+	HostRegex string `json:"host_regex,omitempty"`
+
+	// PathRegex is a regular expression that has to match the whole path of a request for the
+	// Redirect to apply to it. If it's not given, the Redirect applies to every path.
+	PathRegex string `json:"path_regex,omitempty"`
+
+	// HostRewrite is the host to redirect to. It can use \1 through \9 for HostRegex's capture
+	// groups. If it's not given, the host stays the same.
+	HostRewrite string `json:"host_rewrite,omitempty"`
+
+	// PathRewrite is the path to redirect to. It can use \1 through \9 for PathRegex's capture
+	// groups. If it's not given, the path stays the same.
+	PathRewrite string `json:"path_rewrite,omitempty"`
+
+	// Scheme is the scheme to redirect to. If it's not given, the scheme stays the same.
+	// +kubebuilder:validation:Enum={"http","https"}
+	Scheme string `json:"scheme,omitempty"`
+
+	// StripPort, if true, leaves the port out of the redirect, so that it goes to the default
+	// port for its scheme. Redirecting to a HostRewrite always does.
+	StripPort bool `json:"strip_port,omitempty"`
+
+	// StatusCode is the status code of the redirect. Defaults to 301.
+	// +kubebuilder:validation:Enum={301,302,303,307,308}
+	StatusCode int `json:"status_code,omitempty"`
+
+	// Precedence decides which of the Redirects that match the same requests wins: the one
+	// with the highest precedence, or, for a tie, the first by namespace and name.
+	Precedence int `json:"precedence,omitempty"`
+}
+
+// Redirect is the Schema for the redirects API
+//
+// +kubebuilder:object:root=true
+// +kubebuilder:storageversion
+type Redirect struct {
+	metav1.TypeMeta   `json:""`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec RedirectSpec `json:"spec,omitempty"`
+}
+
+// RedirectList contains a list of Redirects.
+//
+// +kubebuilder:object:root=true
+type RedirectList struct {
+	metav1.TypeMeta `json:""`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Redirect `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Redirect{}, &RedirectList{})
+}
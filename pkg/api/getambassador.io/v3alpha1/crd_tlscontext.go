@@ -45,6 +45,70 @@ type TLSContextSpec struct {
 	SecretNamespacing     *bool    `json:"secret_namespacing,omitempty"`
 	RedirectCleartextFrom *int     `json:"redirect_cleartext_from,omitempty"`
 	SNI                   string   `json:"sni,omitempty"`
+
+	// Where to fetch a CRL from, to check client certificates against. It's
+	// fetched again whenever the CRL says it's due for an update. It is not
+	// valid to specify both `crl_secret` and `crl_url`.
+	CRLURL string `json:"crl_url,omitempty"`
+
+	// Turns on OCSP stapling for the certificate in `secret`: its OCSP
+	// responder is asked for a staple, which is refreshed well before it
+	// expires. With "lenient_stapling", connections go ahead without a
+	// staple if there isn't a good one; with "strict_stapling", they don't
+	// once a staple has expired; with "must_staple", they never do without
+	// one.
+	//
+	// +kubebuilder:validation:Enum={"lenient_stapling", "strict_stapling", "must_staple"}
+	OCSPStaplePolicy string `json:"ocsp_staple_policy,omitempty"`
+
+	// A named set of TLS versions, cipher suites, and ECDH curves:
+	// "modern" (TLS 1.3 only), "intermediate" (TLS 1.2 and up, with
+	// forward-secret AEAD ciphers), or "old" (TLS 1.0 and up, for
+	// ancient clients). `min_tls_version`, `max_tls_version`,
+	// `cipher_suites`, and `ecdh_curves` override the policy's. The
+	// default is the Listener's `tlsPolicy`, then the Ambassador Module's
+	// `tls_policy`; "custom" uses neither, leaving only the settings
+	// given here.
+	//
+	// +kubebuilder:validation:Enum={"modern", "intermediate", "old", "custom"}
+	TLSPolicy string `json:"tls_policy,omitempty"`
+
+	// Has Envoy use a private key provider for the private key of the
+	// certificate in `secret` (or `cert_chain_file`), so that the key
+	// itself never has to be in a Secret. The Secret then only needs
+	// `tls.crt`; a `tls.key` in it is ignored.
+	PrivateKeyProvider *PrivateKeyProvider `json:"private_key_provider,omitempty"`
+
+	// Has Envoy accept the peer's certificate only if one of its subject
+	// alternative names matches one of these. The certificate still has to
+	// be signed by `ca_secret` (or `cacert_chain_file`).
+	MatchSubjectAltNames []SubjectAltNameMatcher `json:"match_subject_alt_names,omitempty"`
+}
+
+// SubjectAltNameMatcher matches the subject alternative names of one type
+// in a certificate, either exactly or by prefix.
+type SubjectAltNameMatcher struct {
+	// +kubebuilder:validation:Enum={"DNS", "URI", "EMAIL", "IP_ADDRESS"}
+	// +kubebuilder:validation:Required
+	SANType string `json:"san_type"`
+
+	// Exactly one of these has to be set.
+	Exact  string `json:"exact,omitempty"`
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// PrivateKeyProvider is an Envoy private key provider: something that does
+// the TLS handshake's private key operations for Envoy, like a KMS or an HSM
+// or a local signing broker. The provider has to be built into Envoy.
+type PrivateKeyProvider struct {
+	// The name that the provider registered itself with in Envoy.
+	//
+	// +kubebuilder:validation:Required
+	ProviderName string `json:"provider_name"`
+
+	// The provider's configuration, with an "@type" saying what kind of
+	// configuration it is. This goes to Envoy as is.
+	TypedConfig *UntypedDict `json:"typed_config,omitempty"`
 }
 
 // TLSContext is the Schema for the tlscontexts API
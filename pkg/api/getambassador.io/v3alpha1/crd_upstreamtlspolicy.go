@@ -0,0 +1,98 @@
+// Copyright 2020 Datawire.  All rights reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+///////////////////////////////////////////////////////////////////////////
+// Important: Run "make generate-fast" to regenerate code after modifying
+// this file.
+///////////////////////////////////////////////////////////////////////////
+
+package v3alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// UpstreamTLSPolicySpec defines how Ambassador originates TLS to the upstream services of the
+// Mappings that it selects.
+type UpstreamTLSPolicySpec struct {
+	AmbassadorID AmbassadorID `json:"ambassador_id,omitempty"`
+
+	// MappingSelector selects the Mappings that this policy applies to. Only Mappings in the
+	// same namespace as the UpstreamTLSPolicy are considered.
+	// +kubebuilder:validation:Required
+	MappingSelector *metav1.LabelSelector `json:"mappingSelector"`
+
+	// SNI is the server name to send to the upstream, overriding the hostname of the service.
+	SNI string `json:"sni,omitempty"`
+	// CASecret is the name of a Secret holding the CA bundle used to verify the upstream's
+	// certificate.
+	CASecret string `json:"caSecret,omitempty"`
+	// ClientCertSecret is the name of a kubernetes.io/tls Secret holding the client
+	// certificate to present to the upstream, for mTLS.
+	ClientCertSecret string `json:"clientCertSecret,omitempty"`
+	// +kubebuilder:validation:Enum={"v1.0", "v1.1", "v1.2", "v1.3"}
+	MinTLSVersion string `json:"minTLSVersion,omitempty"`
+	// +kubebuilder:validation:Enum={"v1.0", "v1.1", "v1.2", "v1.3"}
+	MaxTLSVersion string `json:"maxTLSVersion,omitempty"`
+	// ALPNProtocols is the list of protocols to offer to the upstream during ALPN.
+	ALPNProtocols []string `json:"alpnProtocols,omitempty"`
+
+	// SPIFFE, if set, uses Ambassador's SPIFFE identity for mTLS to the upstream, instead of
+	// ClientCertSecret and CASecret.
+	SPIFFE *UpstreamTLSSPIFFE `json:"spiffe,omitempty"`
+
+	// Istio, if true, uses Ambassador's Istio workload certificate for mTLS to upstreams in
+	// the Istio mesh, instead of ClientCertSecret and CASecret. The certificate comes from
+	// AMBASSADOR_ISTIO_SECRET_DIR or AMBASSADOR_ISTIO_SDS_SOCKET.
+	Istio bool `json:"istio,omitempty"`
+}
+
+// UpstreamTLSSPIFFE configures identity-based mTLS using the X.509 SVID that Ambassador gets
+// from the SPIFFE Workload API (see AMBASSADOR_SPIFFE_ENDPOINT_SOCKET).
+type UpstreamTLSSPIFFE struct {
+	// TrustDomain is the SPIFFE trust domain that the upstream's identity must belong to:
+	// the upstream's certificate is verified against this trust domain's bundle, and no
+	// other.
+	// +kubebuilder:validation:Required
+	TrustDomain string `json:"trustDomain"`
+
+	// ID, if set, is the SPIFFE ID that the upstream's certificate must have, such as
+	// "spiffe://example.org/ns/foo/sa/backend". It has to be in TrustDomain. Without it, any
+	// ID in TrustDomain will do.
+	ID string `json:"id,omitempty"`
+}
+
+// UpstreamTLSPolicy is the Schema for the upstreamtlspolicies API
+//
+// +kubebuilder:object:root=true
+// +kubebuilder:storageversion
+type UpstreamTLSPolicy struct {
+	metav1.TypeMeta   `json:""`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec UpstreamTLSPolicySpec `json:"spec,omitempty"`
+}
+
+// UpstreamTLSPolicyList contains a list of UpstreamTLSPolicies.
+//
+// +kubebuilder:object:root=true
+type UpstreamTLSPolicyList struct {
+	metav1.TypeMeta `json:""`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []UpstreamTLSPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&UpstreamTLSPolicy{}, &UpstreamTLSPolicyList{})
+}
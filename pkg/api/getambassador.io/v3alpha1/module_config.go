@@ -0,0 +1,212 @@
+package v3alpha1
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// A Module's config is an UntypedDict, so the CRD schema takes anything in it, and Ambassador
+// ignores whatever it doesn't know about: a typo like `use_remote_adress` just quietly does
+// nothing. ConfigErrors checks the config of an "ambassador" Module against the fields and types
+// of AmbassadorConfigSpec instead, so that each unknown or mistyped field can be reported on its
+// own, with its full path.
+//
+// Values are only checked for their JSON type. Enums, ranges, and the like are still up to the
+// code that uses them.
+
+// moduleConfigTypes are the Modules whose config is typed, by name.
+var moduleConfigTypes = map[string]reflect.Type{
+	"ambassador": reflect.TypeOf(AmbassadorConfigSpec{}),
+}
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	rawMessageType      = reflect.TypeOf(json.RawMessage{})
+)
+
+// ConfigErrors returns an error for each field of the Module's config that's unknown, or of the
+// wrong type, sorted by path. Modules whose config isn't typed never have any.
+func (m *Module) ConfigErrors() []string {
+	t, ok := moduleConfigTypes[m.GetName()]
+	if !ok || m.Spec.Config.Values == nil {
+		return nil
+	}
+
+	raw, err := json.Marshal(m.Spec.Config.Values)
+	if err != nil {
+		return []string{fmt.Sprintf("spec.config: %v", err)}
+	}
+
+	errs := checkConfigValue("spec.config", raw, t)
+	sort.Strings(errs)
+	return errs
+}
+
+func checkConfigValue(path string, raw json.RawMessage, t reflect.Type) []string {
+	if string(raw) == "null" {
+		return nil
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == rawMessageType:
+		return nil
+	case reflect.PtrTo(t).Implements(jsonUnmarshalerType):
+		if err := json.Unmarshal(raw, reflect.New(t).Interface()); err != nil {
+			return []string{fmt.Sprintf("%s: must be %s, not %s", path, configTypeName(t), jsonTypeName(raw))}
+		}
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return []string{fmt.Sprintf("%s: must be an object, not %s", path, jsonTypeName(raw))}
+		}
+		known := configFields(t)
+		var errs []string
+		for name, value := range fields {
+			field, ok := known[name]
+			if !ok {
+				errs = append(errs, fmt.Sprintf("%s.%s: unknown field%s", path, name, suggestField(name, known)))
+				continue
+			}
+			errs = append(errs, checkConfigValue(path+"."+name, value, field)...)
+		}
+		return errs
+	case reflect.Map:
+		var entries map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &entries); err != nil {
+			return []string{fmt.Sprintf("%s: must be an object, not %s", path, jsonTypeName(raw))}
+		}
+		var errs []string
+		for key, value := range entries {
+			errs = append(errs, checkConfigValue(path+"."+key, value, t.Elem())...)
+		}
+		return errs
+	case reflect.Slice:
+		var items []json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return []string{fmt.Sprintf("%s: must be a list, not %s", path, jsonTypeName(raw))}
+		}
+		var errs []string
+		for i, item := range items {
+			errs = append(errs, checkConfigValue(fmt.Sprintf("%s[%d]", path, i), item, t.Elem())...)
+		}
+		return errs
+	default:
+		if err := json.Unmarshal(raw, reflect.New(t).Interface()); err != nil {
+			return []string{fmt.Sprintf("%s: must be %s, not %s", path, configTypeName(t), jsonTypeName(raw))}
+		}
+		return nil
+	}
+}
+
+// configFields returns the fields of a config struct, by their JSON names.
+func configFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" || field.PkgPath != "" {
+			continue
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+// suggestField returns a " (did you mean X?)" for an unknown field that's close enough to a known
+// one to be a typo of it, or "" if none is.
+func suggestField(name string, known map[string]reflect.Type) string {
+	normalize := func(s string) string {
+		return strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(s))
+	}
+
+	best, bestDistance := "", 3
+	for candidate := range known {
+		distance := editDistance(normalize(name), normalize(candidate))
+		if distance < bestDistance || (distance == bestDistance && candidate < best) {
+			best, bestDistance = candidate, distance
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean %s?)", best)
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func configTypeName(t reflect.Type) string {
+	switch t {
+	case reflect.TypeOf(MillisecondDuration{}):
+		return "an integer number of milliseconds"
+	case reflect.TypeOf(UntypedDict{}):
+		return "an object"
+	case reflect.TypeOf(ModuleStringList{}):
+		return "a string or a list of strings"
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice:
+		return "a list"
+	default:
+		return "an object"
+	}
+}
+
+func jsonTypeName(raw json.RawMessage) string {
+	switch strings.TrimSpace(string(raw))[0] {
+	case '"':
+		return "a string"
+	case '{':
+		return "an object"
+	case '[':
+		return "a list"
+	case 't', 'f':
+		return "a boolean"
+	default:
+		return "a number"
+	}
+}
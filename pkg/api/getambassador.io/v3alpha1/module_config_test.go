@@ -0,0 +1,87 @@
+package v3alpha1_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/yaml"
+
+	crds "github.com/emissary-ingress/emissary/v3/pkg/api/getambassador.io/v3alpha1"
+)
+
+func TestModuleConfigErrors(t *testing.T) {
+	t.Parallel()
+	type subtest struct {
+		inputYAML string
+		expected  []string
+	}
+	subtests := map[string]subtest{
+		"empty": {`
+metadata: {name: ambassador}
+spec: {}`, nil},
+		"valid": {`
+metadata: {name: ambassador}
+spec:
+  config:
+    use_remote_address: false
+    xff_num_trusted_hops: 1
+    cluster_request_timeout_ms: 5000
+    envoy_log_format: {start: "%START_TIME%"}
+    cors: {origins: ["https://example.com"], methods: "GET, POST"}
+    circuit_breakers: [{max_connections: 2048}]
+    ip_allow: [{peer: 127.0.0.1}, {remote: 10.0.0.0/8}]
+    lint: {unanchored-regex: error}
+    debug_headers: {jwks: '{"keys": []}', audiences: [support], max_lifetime_s: 600}
+    liveness_probe: {enabled: false}
+    defaults: {httpmapping: {timeout_ms: 10000}}`, nil},
+		"typos": {`
+metadata: {name: ambassador}
+spec:
+  config:
+    use_remote_adress: false
+    xffNumTrustedHops: 1
+    diagnostics: {enabeld: true}
+    ip_allow: [{peer: 127.0.0.1}, {remot: 10.0.0.0/8}]
+    frobnicate: true`, []string{
+			"spec.config.diagnostics.enabeld: unknown field (did you mean enabled?)",
+			"spec.config.frobnicate: unknown field",
+			"spec.config.ip_allow[1].remot: unknown field (did you mean remote?)",
+			"spec.config.use_remote_adress: unknown field (did you mean use_remote_address?)",
+			"spec.config.xffNumTrustedHops: unknown field (did you mean xff_num_trusted_hops?)",
+		}},
+		"types": {`
+metadata: {name: ambassador}
+spec:
+  config:
+    use_remote_address: "false"
+    service_port: 8080.5
+    cluster_idle_timeout_ms: 30s
+    header_case_overrides: X-Foo
+    load_balancer: round_robin
+    circuit_breakers: [{max_connections: lots}]
+    lint: {unanchored-regex: [error]}`, []string{
+			"spec.config.circuit_breakers[0].max_connections: must be an integer, not a string",
+			"spec.config.cluster_idle_timeout_ms: must be an integer number of milliseconds, not a string",
+			"spec.config.header_case_overrides: must be a list, not a string",
+			"spec.config.lint.unanchored-regex: must be a string, not a list",
+			"spec.config.load_balancer: must be an object, not a string",
+			"spec.config.service_port: must be an integer, not a number",
+			"spec.config.use_remote_address: must be a boolean, not a string",
+		}},
+		"untyped": {`
+metadata: {name: tls}
+spec:
+  config:
+    server: {enabled: true}`, nil},
+	}
+	for name, info := range subtests {
+		info := info // capture loop variable
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var module crds.Module
+			require.NoError(t, yaml.Unmarshal([]byte(info.inputYAML), &module))
+			assert.Equal(t, info.expected, module.ConfigErrors())
+		})
+	}
+}
@@ -14,3 +14,7 @@ func (*ConsulResolver) Hub()             {}
 func (*TCPMapping) Hub()                 {}
 func (*TLSContext) Hub()                 {}
 func (*TracingService) Hub()             {}
+func (*UpstreamTLSPolicy) Hub()          {}
+func (*PluginResolver) Hub()             {}
+func (*EgressPolicy) Hub()               {}
+func (*Redirect) Hub()                   {}
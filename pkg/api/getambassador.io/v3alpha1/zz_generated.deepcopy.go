@@ -99,16 +99,11 @@ func (in *AdditionalLogHeaders) DeepCopy() *AdditionalLogHeaders {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AmbassadorConfigSpec) DeepCopyInto(out *AmbassadorConfigSpec) {
 	*out = *in
-	if in.AmbassadorID != nil {
-		in, out := &in.AmbassadorID, &out.AmbassadorID
-		*out = make(AmbassadorID, len(*in))
+	if in.EnvoyLogFormat != nil {
+		in, out := &in.EnvoyLogFormat, &out.EnvoyLogFormat
+		*out = make(json.RawMessage, len(*in))
 		copy(*out, *in)
 	}
-	if in.Features != nil {
-		in, out := &in.Features, &out.Features
-		*out = new(Features)
-		**out = **in
-	}
 	if in.LoadBalancer != nil {
 		in, out := &in.LoadBalancer, &out.LoadBalancer
 		*out = new(LoadBalancer)
@@ -116,8 +111,14 @@ func (in *AmbassadorConfigSpec) DeepCopyInto(out *AmbassadorConfigSpec) {
 	}
 	if in.CircuitBreakers != nil {
 		in, out := &in.CircuitBreakers, &out.CircuitBreakers
-		*out = new(CircuitBreaker)
-		(*in).DeepCopyInto(*out)
+		*out = make([]*CircuitBreaker, len(*in))
+		for i := range *in {
+			if (*in)[i] != nil {
+				in, out := &(*in)[i], &(*out)[i]
+				*out = new(CircuitBreaker)
+				(*in).DeepCopyInto(*out)
+			}
+		}
 	}
 	if in.ErrorResponseOverrides != nil {
 		in, out := &in.ErrorResponseOverrides, &out.ErrorResponseOverrides
@@ -138,7 +139,7 @@ func (in *AmbassadorConfigSpec) DeepCopyInto(out *AmbassadorConfigSpec) {
 	}
 	if in.Cors != nil {
 		in, out := &in.Cors, &out.Cors
-		*out = new(CORS)
+		*out = new(ModuleCORS)
 		(*in).DeepCopyInto(*out)
 	}
 	if in.ClusterRequestTimeout != nil {
@@ -156,6 +157,212 @@ func (in *AmbassadorConfigSpec) DeepCopyInto(out *AmbassadorConfigSpec) {
 		*out = new(MillisecondDuration)
 		**out = **in
 	}
+	if in.AddLinkerdHeaders != nil {
+		in, out := &in.AddLinkerdHeaders, &out.AddLinkerdHeaders
+		*out = new(bool)
+		**out = **in
+	}
+	if in.AllowChunkedLength != nil {
+		in, out := &in.AllowChunkedLength, &out.AllowChunkedLength
+		*out = new(bool)
+		**out = **in
+	}
+	if in.AuthEnabled != nil {
+		in, out := &in.AuthEnabled, &out.AuthEnabled
+		*out = new(bool)
+		**out = **in
+	}
+	if in.Buffer != nil {
+		in, out := &in.Buffer, &out.Buffer
+		*out = new(ModuleBuffer)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.BufferLimitBytes != nil {
+		in, out := &in.BufferLimitBytes, &out.BufferLimitBytes
+		*out = new(int)
+		**out = **in
+	}
+	if in.DebugMode != nil {
+		in, out := &in.DebugMode, &out.DebugMode
+		*out = new(bool)
+		**out = **in
+	}
+	if in.DebugHeaders != nil {
+		in, out := &in.DebugHeaders, &out.DebugHeaders
+		*out = new(ModuleDebugHeaders)
+		(*in).DeepCopyInto(*out)
+	}
+	in.DefaultLabels.DeepCopyInto(&out.DefaultLabels)
+	in.Defaults.DeepCopyInto(&out.Defaults)
+	if in.Diagnostics != nil {
+		in, out := &in.Diagnostics, &out.Diagnostics
+		*out = new(ModuleDiagnostics)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.EnableGRPCHTTP11Bridge != nil {
+		in, out := &in.EnableGRPCHTTP11Bridge, &out.EnableGRPCHTTP11Bridge
+		*out = new(bool)
+		**out = **in
+	}
+	if in.EnableGRPCWeb != nil {
+		in, out := &in.EnableGRPCWeb, &out.EnableGRPCWeb
+		*out = new(bool)
+		**out = **in
+	}
+	if in.EnableHTTP10 != nil {
+		in, out := &in.EnableHTTP10, &out.EnableHTTP10
+		*out = new(bool)
+		**out = **in
+	}
+	if in.EnableIPv4 != nil {
+		in, out := &in.EnableIPv4, &out.EnableIPv4
+		*out = new(bool)
+		**out = **in
+	}
+	if in.EnableIPv6 != nil {
+		in, out := &in.EnableIPv6, &out.EnableIPv6
+		*out = new(bool)
+		**out = **in
+	}
+	if in.EnvoyValidationTimeout != nil {
+		in, out := &in.EnvoyValidationTimeout, &out.EnvoyValidationTimeout
+		*out = new(int)
+		**out = **in
+	}
+	if in.GRPCStats != nil {
+		in, out := &in.GRPCStats, &out.GRPCStats
+		*out = new(ModuleGRPCStats)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Gzip != nil {
+		in, out := &in.Gzip, &out.Gzip
+		*out = new(ModuleGzip)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.IPAllow != nil {
+		in, out := &in.IPAllow, &out.IPAllow
+		*out = make([]ModuleIPPrincipal, len(*in))
+		copy(*out, *in)
+	}
+	if in.IPDeny != nil {
+		in, out := &in.IPDeny, &out.IPDeny
+		*out = make([]ModuleIPPrincipal, len(*in))
+		copy(*out, *in)
+	}
+	if in.KeepAlive != nil {
+		in, out := &in.KeepAlive, &out.KeepAlive
+		*out = new(KeepAlive)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ListenerIdleTimeout != nil {
+		in, out := &in.ListenerIdleTimeout, &out.ListenerIdleTimeout
+		*out = new(MillisecondDuration)
+		**out = **in
+	}
+	if in.Lint != nil {
+		in, out := &in.Lint, &out.Lint
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.LivenessProbe != nil {
+		in, out := &in.LivenessProbe, &out.LivenessProbe
+		*out = new(ModuleProbe)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.LoadShedding != nil {
+		in, out := &in.LoadShedding, &out.LoadShedding
+		*out = new(ModuleLoadShedding)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.MaxRequestHeadersKb != nil {
+		in, out := &in.MaxRequestHeadersKb, &out.MaxRequestHeadersKb
+		*out = new(int)
+		**out = **in
+	}
+	if in.MergeSlashes != nil {
+		in, out := &in.MergeSlashes, &out.MergeSlashes
+		*out = new(bool)
+		**out = **in
+	}
+	if in.PreserveExternalRequestID != nil {
+		in, out := &in.PreserveExternalRequestID, &out.PreserveExternalRequestID
+		*out = new(bool)
+		**out = **in
+	}
+	if in.ProperCase != nil {
+		in, out := &in.ProperCase, &out.ProperCase
+		*out = new(bool)
+		**out = **in
+	}
+	if in.PruneUnreachableRoutes != nil {
+		in, out := &in.PruneUnreachableRoutes, &out.PruneUnreachableRoutes
+		*out = new(bool)
+		**out = **in
+	}
+	if in.ReadinessProbe != nil {
+		in, out := &in.ReadinessProbe, &out.ReadinessProbe
+		*out = new(ModuleProbe)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RejectRequestsWithEscapedSlashes != nil {
+		in, out := &in.RejectRequestsWithEscapedSlashes, &out.RejectRequestsWithEscapedSlashes
+		*out = new(bool)
+		**out = **in
+	}
+	if in.RequestNormalization != nil {
+		in, out := &in.RequestNormalization, &out.RequestNormalization
+		*out = new(ModuleRequestNormalization)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.SetCurrentClientCertDetails != nil {
+		in, out := &in.SetCurrentClientCertDetails, &out.SetCurrentClientCertDetails
+		*out = new(ModuleClientCertDetails)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.StatsSinks != nil {
+		in, out := &in.StatsSinks, &out.StatsSinks
+		*out = new(ModuleStatsSinks)
+		(*in).DeepCopyInto(*out)
+	}
+	in.Statsd.DeepCopyInto(&out.Statsd)
+	if in.StripMatchingHostPort != nil {
+		in, out := &in.StripMatchingHostPort, &out.StripMatchingHostPort
+		*out = new(bool)
+		**out = **in
+	}
+	if in.SuppressEnvoyHeaders != nil {
+		in, out := &in.SuppressEnvoyHeaders, &out.SuppressEnvoyHeaders
+		*out = new(bool)
+		**out = **in
+	}
+	in.TLS.DeepCopyInto(&out.TLS)
+	if in.UseAmbassadorNamespaceForServiceResolution != nil {
+		in, out := &in.UseAmbassadorNamespaceForServiceResolution, &out.UseAmbassadorNamespaceForServiceResolution
+		*out = new(bool)
+		**out = **in
+	}
+	if in.UseProxyProto != nil {
+		in, out := &in.UseProxyProto, &out.UseProxyProto
+		*out = new(bool)
+		**out = **in
+	}
+	if in.UseRemoteAddress != nil {
+		in, out := &in.UseRemoteAddress, &out.UseRemoteAddress
+		*out = new(bool)
+		**out = **in
+	}
+	if in.XForwardedProtoRedirect != nil {
+		in, out := &in.XForwardedProtoRedirect, &out.XForwardedProtoRedirect
+		*out = new(bool)
+		**out = **in
+	}
+	if in.XffNumTrustedHops != nil {
+		in, out := &in.XffNumTrustedHops, &out.XffNumTrustedHops
+		*out = new(int)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AmbassadorConfigSpec.
@@ -820,6 +1027,116 @@ func (in *DriverConfig) DeepCopy() *DriverConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressDestination) DeepCopyInto(out *EgressDestination) {
+	*out = *in
+	if in.Ports != nil {
+		in, out := &in.Ports, &out.Ports
+		*out = make([]int, len(*in))
+		copy(*out, *in)
+	}
+	if in.TLS != nil {
+		in, out := &in.TLS, &out.TLS
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressDestination.
+func (in *EgressDestination) DeepCopy() *EgressDestination {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressDestination)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressPolicy) DeepCopyInto(out *EgressPolicy) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressPolicy.
+func (in *EgressPolicy) DeepCopy() *EgressPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *EgressPolicy) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressPolicyList) DeepCopyInto(out *EgressPolicyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]EgressPolicy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressPolicyList.
+func (in *EgressPolicyList) DeepCopy() *EgressPolicyList {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressPolicyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *EgressPolicyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EgressPolicySpec) DeepCopyInto(out *EgressPolicySpec) {
+	*out = *in
+	if in.AmbassadorID != nil {
+		in, out := &in.AmbassadorID, &out.AmbassadorID
+		*out = make(AmbassadorID, len(*in))
+		copy(*out, *in)
+	}
+	if in.Destinations != nil {
+		in, out := &in.Destinations, &out.Destinations
+		*out = make([]EgressDestination, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EgressPolicySpec.
+func (in *EgressPolicySpec) DeepCopy() *EgressPolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(EgressPolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ErrorResponseOverride) DeepCopyInto(out *ErrorResponseOverride) {
 	*out = *in
@@ -888,16 +1205,36 @@ func (in *ErrorResponseTextFormatSource) DeepCopy() *ErrorResponseTextFormatSour
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *Features) DeepCopyInto(out *Features) {
+func (in *FailoverPolicy) DeepCopyInto(out *FailoverPolicy) {
 	*out = *in
+	if in.Services != nil {
+		in, out := &in.Services, &out.Services
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.HealthyPercent != nil {
+		in, out := &in.HealthyPercent, &out.HealthyPercent
+		*out = new(int)
+		**out = **in
+	}
+	if in.Consecutive5xx != nil {
+		in, out := &in.Consecutive5xx, &out.Consecutive5xx
+		*out = new(int)
+		**out = **in
+	}
+	if in.EjectionTime != nil {
+		in, out := &in.EjectionTime, &out.EjectionTime
+		*out = new(MillisecondDuration)
+		**out = **in
+	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Features.
-func (in *Features) DeepCopy() *Features {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FailoverPolicy.
+func (in *FailoverPolicy) DeepCopy() *FailoverPolicy {
 	if in == nil {
 		return nil
 	}
-	out := new(Features)
+	out := new(FailoverPolicy)
 	in.DeepCopyInto(out)
 	return out
 }
@@ -917,6 +1254,26 @@ func (in *GRPCHealthCheck) DeepCopy() *GRPCHealthCheck {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GRPCTranscoder) DeepCopyInto(out *GRPCTranscoder) {
+	*out = *in
+	if in.Services != nil {
+		in, out := &in.Services, &out.Services
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GRPCTranscoder.
+func (in *GRPCTranscoder) DeepCopy() *GRPCTranscoder {
+	if in == nil {
+		return nil
+	}
+	out := new(GRPCTranscoder)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *HTTPHealthCheck) DeepCopyInto(out *HTTPHealthCheck) {
 	*out = *in
@@ -1142,6 +1499,26 @@ func (in *HostSpec) DeepCopyInto(out *HostSpec) {
 		*out = new(TLSConfig)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.RequestTimeout != nil {
+		in, out := &in.RequestTimeout, &out.RequestTimeout
+		*out = new(MillisecondDuration)
+		**out = **in
+	}
+	if in.IdentityHeaders != nil {
+		in, out := &in.IdentityHeaders, &out.IdentityHeaders
+		*out = make([]IdentityHeader, len(*in))
+		copy(*out, *in)
+	}
+	if in.Telemetry != nil {
+		in, out := &in.Telemetry, &out.Telemetry
+		*out = new(HostTelemetry)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Maintenance != nil {
+		in, out := &in.Maintenance, &out.Maintenance
+		*out = new(Maintenance)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HostSpec.
@@ -1166,6 +1543,11 @@ func (in *HostStatus) DeepCopyInto(out *HostStatus) {
 		*out = new(v1.Duration)
 		**out = **in
 	}
+	if in.LoadBalancer != nil {
+		in, out := &in.LoadBalancer, &out.LoadBalancer
+		*out = new(corev1.LoadBalancerStatus)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HostStatus.
@@ -1178,6 +1560,46 @@ func (in *HostStatus) DeepCopy() *HostStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HostTelemetry) DeepCopyInto(out *HostTelemetry) {
+	*out = *in
+	if in.AccessLogSampling != nil {
+		in, out := &in.AccessLogSampling, &out.AccessLogSampling
+		*out = new(int)
+		**out = **in
+	}
+	if in.RouteStats != nil {
+		in, out := &in.RouteStats, &out.RouteStats
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HostTelemetry.
+func (in *HostTelemetry) DeepCopy() *HostTelemetry {
+	if in == nil {
+		return nil
+	}
+	out := new(HostTelemetry)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IdentityHeader) DeepCopyInto(out *IdentityHeader) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IdentityHeader.
+func (in *IdentityHeader) DeepCopy() *IdentityHeader {
+	if in == nil {
+		return nil
+	}
+	out := new(IdentityHeader)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *InsecureRequestPolicy) DeepCopyInto(out *InsecureRequestPolicy) {
 	*out = *in
@@ -1446,6 +1868,56 @@ func (in *ListenerList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ListenerRequestNormalization) DeepCopyInto(out *ListenerRequestNormalization) {
+	*out = *in
+	if in.NormalizePath != nil {
+		in, out := &in.NormalizePath, &out.NormalizePath
+		*out = new(bool)
+		**out = **in
+	}
+	if in.MergeSlashes != nil {
+		in, out := &in.MergeSlashes, &out.MergeSlashes
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ListenerRequestNormalization.
+func (in *ListenerRequestNormalization) DeepCopy() *ListenerRequestNormalization {
+	if in == nil {
+		return nil
+	}
+	out := new(ListenerRequestNormalization)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ListenerSocketOption) DeepCopyInto(out *ListenerSocketOption) {
+	*out = *in
+	if in.IntValue != nil {
+		in, out := &in.IntValue, &out.IntValue
+		*out = new(int64)
+		**out = **in
+	}
+	if in.BufValue != nil {
+		in, out := &in.BufValue, &out.BufValue
+		*out = make([]byte, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ListenerSocketOption.
+func (in *ListenerSocketOption) DeepCopy() *ListenerSocketOption {
+	if in == nil {
+		return nil
+	}
+	out := new(ListenerSocketOption)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ListenerSpec) DeepCopyInto(out *ListenerSpec) {
 	*out = *in
@@ -1460,6 +1932,33 @@ func (in *ListenerSpec) DeepCopyInto(out *ListenerSpec) {
 		copy(*out, *in)
 	}
 	in.HostBinding.DeepCopyInto(&out.HostBinding)
+	if in.ReusePort != nil {
+		in, out := &in.ReusePort, &out.ReusePort
+		*out = new(bool)
+		**out = **in
+	}
+	if in.TCPBacklogSize != nil {
+		in, out := &in.TCPBacklogSize, &out.TCPBacklogSize
+		*out = new(int32)
+		**out = **in
+	}
+	if in.SocketOptions != nil {
+		in, out := &in.SocketOptions, &out.SocketOptions
+		*out = make([]ListenerSocketOption, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.RequestNormalization != nil {
+		in, out := &in.RequestNormalization, &out.RequestNormalization
+		*out = new(ListenerRequestNormalization)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.TLSFingerprint != nil {
+		in, out := &in.TLSFingerprint, &out.TLSFingerprint
+		*out = new(ListenerTLSFingerprint)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ListenerSpec.
@@ -1472,6 +1971,21 @@ func (in *ListenerSpec) DeepCopy() *ListenerSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ListenerTLSFingerprint) DeepCopyInto(out *ListenerTLSFingerprint) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ListenerTLSFingerprint.
+func (in *ListenerTLSFingerprint) DeepCopy() *ListenerTLSFingerprint {
+	if in == nil {
+		return nil
+	}
+	out := new(ListenerTLSFingerprint)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *LoadBalancer) DeepCopyInto(out *LoadBalancer) {
 	*out = *in
@@ -1610,6 +2124,51 @@ func (in *LogServiceSpec) DeepCopy() *LogServiceSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Maintenance) DeepCopyInto(out *Maintenance) {
+	*out = *in
+	if in.StatusCode != nil {
+		in, out := &in.StatusCode, &out.StatusCode
+		*out = new(int)
+		**out = **in
+	}
+	if in.RetryAfter != nil {
+		in, out := &in.RetryAfter, &out.RetryAfter
+		*out = new(int)
+		**out = **in
+	}
+	if in.Body != nil {
+		in, out := &in.Body, &out.Body
+		*out = new(MaintenanceBody)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Maintenance.
+func (in *Maintenance) DeepCopy() *Maintenance {
+	if in == nil {
+		return nil
+	}
+	out := new(Maintenance)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MaintenanceBody) DeepCopyInto(out *MaintenanceBody) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MaintenanceBody.
+func (in *MaintenanceBody) DeepCopy() *MaintenanceBody {
+	if in == nil {
+		return nil
+	}
+	out := new(MaintenanceBody)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Mapping) DeepCopyInto(out *Mapping) {
 	*out = *in
@@ -1641,6 +2200,36 @@ func (in *Mapping) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MappingContractCapture) DeepCopyInto(out *MappingContractCapture) {
+	*out = *in
+	if in.SamplePercent != nil {
+		in, out := &in.SamplePercent, &out.SamplePercent
+		*out = new(int)
+		**out = **in
+	}
+	if in.MaxBodyBytes != nil {
+		in, out := &in.MaxBodyBytes, &out.MaxBodyBytes
+		*out = new(int)
+		**out = **in
+	}
+	if in.RedactHeaders != nil {
+		in, out := &in.RedactHeaders, &out.RedactHeaders
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MappingContractCapture.
+func (in *MappingContractCapture) DeepCopy() *MappingContractCapture {
+	if in == nil {
+		return nil
+	}
+	out := new(MappingContractCapture)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in MappingLabelGroup) DeepCopyInto(out *MappingLabelGroup) {
 	{
@@ -1882,6 +2471,21 @@ func (in *MappingList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MappingOpenAPI) DeepCopyInto(out *MappingOpenAPI) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MappingOpenAPI.
+func (in *MappingOpenAPI) DeepCopy() *MappingOpenAPI {
+	if in == nil {
+		return nil
+	}
+	out := new(MappingOpenAPI)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MappingSpec) DeepCopyInto(out *MappingSpec) {
 	*out = *in
@@ -2200,6 +2804,65 @@ func (in *MappingSpec) DeepCopyInto(out *MappingSpec) {
 			(*out)[key] = val
 		}
 	}
+	if in.SubsetLabels != nil {
+		in, out := &in.SubsetLabels, &out.SubsetLabels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Failover != nil {
+		in, out := &in.Failover, &out.Failover
+		*out = new(FailoverPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.GRPCTranscoder != nil {
+		in, out := &in.GRPCTranscoder, &out.GRPCTranscoder
+		*out = new(GRPCTranscoder)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Fallback != nil {
+		in, out := &in.Fallback, &out.Fallback
+		*out = new(bool)
+		**out = **in
+	}
+	if in.OpenAPI != nil {
+		in, out := &in.OpenAPI, &out.OpenAPI
+		*out = new(MappingOpenAPI)
+		**out = **in
+	}
+	if in.ContractCapture != nil {
+		in, out := &in.ContractCapture, &out.ContractCapture
+		*out = new(MappingContractCapture)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Views != nil {
+		in, out := &in.Views, &out.Views
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Maintenance != nil {
+		in, out := &in.Maintenance, &out.Maintenance
+		*out = new(Maintenance)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Upstreams != nil {
+		in, out := &in.Upstreams, &out.Upstreams
+		*out = make([]MappingUpstream, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.StaticResponse != nil {
+		in, out := &in.StaticResponse, &out.StaticResponse
+		*out = new(MappingStaticResponse)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Critical != nil {
+		in, out := &in.Critical, &out.Critical
+		*out = new(bool)
+		**out = **in
+	}
 	if in.V2ExplicitTLS != nil {
 		in, out := &in.V2ExplicitTLS, &out.V2ExplicitTLS
 		*out = new(V2ExplicitTLS)
@@ -2227,6 +2890,33 @@ func (in *MappingSpec) DeepCopy() *MappingSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MappingStaticResponse) DeepCopyInto(out *MappingStaticResponse) {
+	*out = *in
+	if in.StatusCode != nil {
+		in, out := &in.StatusCode, &out.StatusCode
+		*out = new(int)
+		**out = **in
+	}
+	if in.Headers != nil {
+		in, out := &in.Headers, &out.Headers
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MappingStaticResponse.
+func (in *MappingStaticResponse) DeepCopy() *MappingStaticResponse {
+	if in == nil {
+		return nil
+	}
+	out := new(MappingStaticResponse)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MappingStatus) DeepCopyInto(out *MappingStatus) {
 	*out = *in
@@ -2242,6 +2932,31 @@ func (in *MappingStatus) DeepCopy() *MappingStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MappingUpstream) DeepCopyInto(out *MappingUpstream) {
+	*out = *in
+	if in.Weight != nil {
+		in, out := &in.Weight, &out.Weight
+		*out = new(int)
+		**out = **in
+	}
+	if in.Priority != nil {
+		in, out := &in.Priority, &out.Priority
+		*out = new(int)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MappingUpstream.
+func (in *MappingUpstream) DeepCopy() *MappingUpstream {
+	if in == nil {
+		return nil
+	}
+	out := new(MappingUpstream)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MillisecondDuration) DeepCopyInto(out *MillisecondDuration) {
 	*out = *in
@@ -2284,56 +2999,502 @@ func (in *Module) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *ModuleList) DeepCopyInto(out *ModuleList) {
+func (in *ModuleBuffer) DeepCopyInto(out *ModuleBuffer) {
 	*out = *in
-	out.TypeMeta = in.TypeMeta
-	in.ListMeta.DeepCopyInto(&out.ListMeta)
-	if in.Items != nil {
-		in, out := &in.Items, &out.Items
-		*out = make([]Module, len(*in))
-		for i := range *in {
-			(*in)[i].DeepCopyInto(&(*out)[i])
-		}
+	if in.MaxRequestBytes != nil {
+		in, out := &in.MaxRequestBytes, &out.MaxRequestBytes
+		*out = new(int)
+		**out = **in
+	}
+	if in.MaxRequestTime != nil {
+		in, out := &in.MaxRequestTime, &out.MaxRequestTime
+		*out = new(int)
+		**out = **in
 	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModuleList.
-func (in *ModuleList) DeepCopy() *ModuleList {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModuleBuffer.
+func (in *ModuleBuffer) DeepCopy() *ModuleBuffer {
 	if in == nil {
 		return nil
 	}
-	out := new(ModuleList)
+	out := new(ModuleBuffer)
 	in.DeepCopyInto(out)
 	return out
 }
 
-// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *ModuleList) DeepCopyObject() runtime.Object {
-	if c := in.DeepCopy(); c != nil {
-		return c
-	}
-	return nil
-}
-
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *ModuleSpec) DeepCopyInto(out *ModuleSpec) {
+func (in *ModuleCORS) DeepCopyInto(out *ModuleCORS) {
 	*out = *in
-	if in.AmbassadorID != nil {
-		in, out := &in.AmbassadorID, &out.AmbassadorID
-		*out = make(AmbassadorID, len(*in))
+	if in.Origins != nil {
+		in, out := &in.Origins, &out.Origins
+		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
-	in.Config.DeepCopyInto(&out.Config)
-}
-
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModuleSpec.
-func (in *ModuleSpec) DeepCopy() *ModuleSpec {
-	if in == nil {
-		return nil
-	}
-	out := new(ModuleSpec)
+	if in.Methods != nil {
+		in, out := &in.Methods, &out.Methods
+		*out = make(ModuleStringList, len(*in))
+		copy(*out, *in)
+	}
+	if in.Headers != nil {
+		in, out := &in.Headers, &out.Headers
+		*out = make(ModuleStringList, len(*in))
+		copy(*out, *in)
+	}
+	if in.Credentials != nil {
+		in, out := &in.Credentials, &out.Credentials
+		*out = new(bool)
+		**out = **in
+	}
+	if in.ExposedHeaders != nil {
+		in, out := &in.ExposedHeaders, &out.ExposedHeaders
+		*out = make(ModuleStringList, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModuleCORS.
+func (in *ModuleCORS) DeepCopy() *ModuleCORS {
+	if in == nil {
+		return nil
+	}
+	out := new(ModuleCORS)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ModuleClientCertDetails) DeepCopyInto(out *ModuleClientCertDetails) {
+	*out = *in
+	if in.Subject != nil {
+		in, out := &in.Subject, &out.Subject
+		*out = new(bool)
+		**out = **in
+	}
+	if in.Cert != nil {
+		in, out := &in.Cert, &out.Cert
+		*out = new(bool)
+		**out = **in
+	}
+	if in.Chain != nil {
+		in, out := &in.Chain, &out.Chain
+		*out = new(bool)
+		**out = **in
+	}
+	if in.DNS != nil {
+		in, out := &in.DNS, &out.DNS
+		*out = new(bool)
+		**out = **in
+	}
+	if in.URI != nil {
+		in, out := &in.URI, &out.URI
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModuleClientCertDetails.
+func (in *ModuleClientCertDetails) DeepCopy() *ModuleClientCertDetails {
+	if in == nil {
+		return nil
+	}
+	out := new(ModuleClientCertDetails)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ModuleDebugHeaders) DeepCopyInto(out *ModuleDebugHeaders) {
+	*out = *in
+	if in.Audiences != nil {
+		in, out := &in.Audiences, &out.Audiences
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.MaxLifetimeS != nil {
+		in, out := &in.MaxLifetimeS, &out.MaxLifetimeS
+		*out = new(int)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModuleDebugHeaders.
+func (in *ModuleDebugHeaders) DeepCopy() *ModuleDebugHeaders {
+	if in == nil {
+		return nil
+	}
+	out := new(ModuleDebugHeaders)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ModuleDiagnostics) DeepCopyInto(out *ModuleDiagnostics) {
+	*out = *in
+	if in.Enabled != nil {
+		in, out := &in.Enabled, &out.Enabled
+		*out = new(bool)
+		**out = **in
+	}
+	if in.AllowNonLocal != nil {
+		in, out := &in.AllowNonLocal, &out.AllowNonLocal
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModuleDiagnostics.
+func (in *ModuleDiagnostics) DeepCopy() *ModuleDiagnostics {
+	if in == nil {
+		return nil
+	}
+	out := new(ModuleDiagnostics)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ModuleGRPCStats) DeepCopyInto(out *ModuleGRPCStats) {
+	*out = *in
+	if in.AllMethods != nil {
+		in, out := &in.AllMethods, &out.AllMethods
+		*out = new(bool)
+		**out = **in
+	}
+	if in.Services != nil {
+		in, out := &in.Services, &out.Services
+		*out = make([]ModuleGRPCStatsService, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.UpstreamStats != nil {
+		in, out := &in.UpstreamStats, &out.UpstreamStats
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModuleGRPCStats.
+func (in *ModuleGRPCStats) DeepCopy() *ModuleGRPCStats {
+	if in == nil {
+		return nil
+	}
+	out := new(ModuleGRPCStats)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ModuleGRPCStatsService) DeepCopyInto(out *ModuleGRPCStatsService) {
+	*out = *in
+	if in.MethodNames != nil {
+		in, out := &in.MethodNames, &out.MethodNames
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModuleGRPCStatsService.
+func (in *ModuleGRPCStatsService) DeepCopy() *ModuleGRPCStatsService {
+	if in == nil {
+		return nil
+	}
+	out := new(ModuleGRPCStatsService)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ModuleGzip) DeepCopyInto(out *ModuleGzip) {
+	*out = *in
+	if in.MemoryLevel != nil {
+		in, out := &in.MemoryLevel, &out.MemoryLevel
+		*out = new(int)
+		**out = **in
+	}
+	if in.MinContentLength != nil {
+		in, out := &in.MinContentLength, &out.MinContentLength
+		*out = new(int)
+		**out = **in
+	}
+	if in.WindowBits != nil {
+		in, out := &in.WindowBits, &out.WindowBits
+		*out = new(int)
+		**out = **in
+	}
+	if in.ContentType != nil {
+		in, out := &in.ContentType, &out.ContentType
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.DisableOnEtagHeader != nil {
+		in, out := &in.DisableOnEtagHeader, &out.DisableOnEtagHeader
+		*out = new(bool)
+		**out = **in
+	}
+	if in.RemoveAcceptEncodingHeader != nil {
+		in, out := &in.RemoveAcceptEncodingHeader, &out.RemoveAcceptEncodingHeader
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModuleGzip.
+func (in *ModuleGzip) DeepCopy() *ModuleGzip {
+	if in == nil {
+		return nil
+	}
+	out := new(ModuleGzip)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ModuleIPPrincipal) DeepCopyInto(out *ModuleIPPrincipal) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModuleIPPrincipal.
+func (in *ModuleIPPrincipal) DeepCopy() *ModuleIPPrincipal {
+	if in == nil {
+		return nil
+	}
+	out := new(ModuleIPPrincipal)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ModuleList) DeepCopyInto(out *ModuleList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]Module, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModuleList.
+func (in *ModuleList) DeepCopy() *ModuleList {
+	if in == nil {
+		return nil
+	}
+	out := new(ModuleList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ModuleList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ModuleLoadShedding) DeepCopyInto(out *ModuleLoadShedding) {
+	*out = *in
+	if in.MaxHeapBytes != nil {
+		in, out := &in.MaxHeapBytes, &out.MaxHeapBytes
+		*out = new(int64)
+		**out = **in
+	}
+	if in.CPU != nil {
+		in, out := &in.CPU, &out.CPU
+		*out = new(bool)
+		**out = **in
+	}
+	if in.ScalingThreshold != nil {
+		in, out := &in.ScalingThreshold, &out.ScalingThreshold
+		*out = new(float64)
+		**out = **in
+	}
+	if in.SaturationThreshold != nil {
+		in, out := &in.SaturationThreshold, &out.SaturationThreshold
+		*out = new(float64)
+		**out = **in
+	}
+	if in.RetryAfter != nil {
+		in, out := &in.RetryAfter, &out.RetryAfter
+		*out = new(int)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModuleLoadShedding.
+func (in *ModuleLoadShedding) DeepCopy() *ModuleLoadShedding {
+	if in == nil {
+		return nil
+	}
+	out := new(ModuleLoadShedding)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ModuleOTLPSink) DeepCopyInto(out *ModuleOTLPSink) {
+	*out = *in
+	if in.Deltas != nil {
+		in, out := &in.Deltas, &out.Deltas
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModuleOTLPSink.
+func (in *ModuleOTLPSink) DeepCopy() *ModuleOTLPSink {
+	if in == nil {
+		return nil
+	}
+	out := new(ModuleOTLPSink)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ModuleProbe) DeepCopyInto(out *ModuleProbe) {
+	*out = *in
+	if in.Enabled != nil {
+		in, out := &in.Enabled, &out.Enabled
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModuleProbe.
+func (in *ModuleProbe) DeepCopy() *ModuleProbe {
+	if in == nil {
+		return nil
+	}
+	out := new(ModuleProbe)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ModuleRequestNormalization) DeepCopyInto(out *ModuleRequestNormalization) {
+	*out = *in
+	if in.NormalizePath != nil {
+		in, out := &in.NormalizePath, &out.NormalizePath
+		*out = new(bool)
+		**out = **in
+	}
+	if in.MergeSlashes != nil {
+		in, out := &in.MergeSlashes, &out.MergeSlashes
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModuleRequestNormalization.
+func (in *ModuleRequestNormalization) DeepCopy() *ModuleRequestNormalization {
+	if in == nil {
+		return nil
+	}
+	out := new(ModuleRequestNormalization)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ModuleSpec) DeepCopyInto(out *ModuleSpec) {
+	*out = *in
+	if in.AmbassadorID != nil {
+		in, out := &in.AmbassadorID, &out.AmbassadorID
+		*out = make(AmbassadorID, len(*in))
+		copy(*out, *in)
+	}
+	in.Config.DeepCopyInto(&out.Config)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModuleSpec.
+func (in *ModuleSpec) DeepCopy() *ModuleSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ModuleSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ModuleStatsDSink) DeepCopyInto(out *ModuleStatsDSink) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModuleStatsDSink.
+func (in *ModuleStatsDSink) DeepCopy() *ModuleStatsDSink {
+	if in == nil {
+		return nil
+	}
+	out := new(ModuleStatsDSink)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ModuleStatsSinks) DeepCopyInto(out *ModuleStatsSinks) {
+	*out = *in
+	if in.FlushInterval != nil {
+		in, out := &in.FlushInterval, &out.FlushInterval
+		*out = new(int)
+		**out = **in
+	}
+	if in.StatsD != nil {
+		in, out := &in.StatsD, &out.StatsD
+		*out = new(ModuleStatsDSink)
+		**out = **in
+	}
+	if in.OTLP != nil {
+		in, out := &in.OTLP, &out.OTLP
+		*out = new(ModuleOTLPSink)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Include != nil {
+		in, out := &in.Include, &out.Include
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Exclude != nil {
+		in, out := &in.Exclude, &out.Exclude
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModuleStatsSinks.
+func (in *ModuleStatsSinks) DeepCopy() *ModuleStatsSinks {
+	if in == nil {
+		return nil
+	}
+	out := new(ModuleStatsSinks)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in ModuleStringList) DeepCopyInto(out *ModuleStringList) {
+	{
+		in := &in
+		*out = make(ModuleStringList, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModuleStringList.
+func (in ModuleStringList) DeepCopy() ModuleStringList {
+	if in == nil {
+		return nil
+	}
+	out := new(ModuleStringList)
 	in.DeepCopyInto(out)
-	return out
+	return *out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
@@ -2351,6 +3512,91 @@ func (in *NamespaceBindingType) DeepCopy() *NamespaceBindingType {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PluginResolver) DeepCopyInto(out *PluginResolver) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PluginResolver.
+func (in *PluginResolver) DeepCopy() *PluginResolver {
+	if in == nil {
+		return nil
+	}
+	out := new(PluginResolver)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *PluginResolver) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PluginResolverList) DeepCopyInto(out *PluginResolverList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]PluginResolver, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PluginResolverList.
+func (in *PluginResolverList) DeepCopy() *PluginResolverList {
+	if in == nil {
+		return nil
+	}
+	out := new(PluginResolverList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *PluginResolverList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PluginResolverSpec) DeepCopyInto(out *PluginResolverSpec) {
+	*out = *in
+	if in.AmbassadorID != nil {
+		in, out := &in.AmbassadorID, &out.AmbassadorID
+		*out = make(AmbassadorID, len(*in))
+		copy(*out, *in)
+	}
+	if in.Config != nil {
+		in, out := &in.Config, &out.Config
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PluginResolverSpec.
+func (in *PluginResolverSpec) DeepCopy() *PluginResolverSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PluginResolverSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PreviewURLSpec) DeepCopyInto(out *PreviewURLSpec) {
 	*out = *in
@@ -2371,6 +3617,26 @@ func (in *PreviewURLSpec) DeepCopy() *PreviewURLSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PrivateKeyProvider) DeepCopyInto(out *PrivateKeyProvider) {
+	*out = *in
+	if in.TypedConfig != nil {
+		in, out := &in.TypedConfig, &out.TypedConfig
+		*out = new(UntypedDict)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PrivateKeyProvider.
+func (in *PrivateKeyProvider) DeepCopy() *PrivateKeyProvider {
+	if in == nil {
+		return nil
+	}
+	out := new(PrivateKeyProvider)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RateLimitService) DeepCopyInto(out *RateLimitService) {
 	*out = *in
@@ -2459,6 +3725,84 @@ func (in *RateLimitServiceSpec) DeepCopy() *RateLimitServiceSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Redirect) DeepCopyInto(out *Redirect) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Redirect.
+func (in *Redirect) DeepCopy() *Redirect {
+	if in == nil {
+		return nil
+	}
+	out := new(Redirect)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *Redirect) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RedirectList) DeepCopyInto(out *RedirectList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]Redirect, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RedirectList.
+func (in *RedirectList) DeepCopy() *RedirectList {
+	if in == nil {
+		return nil
+	}
+	out := new(RedirectList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *RedirectList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RedirectSpec) DeepCopyInto(out *RedirectSpec) {
+	*out = *in
+	if in.AmbassadorID != nil {
+		in, out := &in.AmbassadorID, &out.AmbassadorID
+		*out = make(AmbassadorID, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RedirectSpec.
+func (in *RedirectSpec) DeepCopy() *RedirectSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RedirectSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RegexMap) DeepCopyInto(out *RegexMap) {
 	*out = *in
@@ -2540,6 +3884,21 @@ func (in *StatusRange) DeepCopy() *StatusRange {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SubjectAltNameMatcher) DeepCopyInto(out *SubjectAltNameMatcher) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SubjectAltNameMatcher.
+func (in *SubjectAltNameMatcher) DeepCopy() *SubjectAltNameMatcher {
+	if in == nil {
+		return nil
+	}
+	out := new(SubjectAltNameMatcher)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TCPMapping) DeepCopyInto(out *TCPMapping) {
 	*out = *in
@@ -2776,6 +4135,16 @@ func (in *TLSContextSpec) DeepCopyInto(out *TLSContextSpec) {
 		*out = new(int)
 		**out = **in
 	}
+	if in.PrivateKeyProvider != nil {
+		in, out := &in.PrivateKeyProvider, &out.PrivateKeyProvider
+		*out = new(PrivateKeyProvider)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.MatchSubjectAltNames != nil {
+		in, out := &in.MatchSubjectAltNames, &out.MatchSubjectAltNames
+		*out = make([]SubjectAltNameMatcher, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TLSContextSpec.
@@ -3063,6 +4432,114 @@ func (in *UntypedDict) DeepCopy() *UntypedDict {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UpstreamTLSPolicy) DeepCopyInto(out *UpstreamTLSPolicy) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UpstreamTLSPolicy.
+func (in *UpstreamTLSPolicy) DeepCopy() *UpstreamTLSPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(UpstreamTLSPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *UpstreamTLSPolicy) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UpstreamTLSPolicyList) DeepCopyInto(out *UpstreamTLSPolicyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]UpstreamTLSPolicy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UpstreamTLSPolicyList.
+func (in *UpstreamTLSPolicyList) DeepCopy() *UpstreamTLSPolicyList {
+	if in == nil {
+		return nil
+	}
+	out := new(UpstreamTLSPolicyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *UpstreamTLSPolicyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UpstreamTLSPolicySpec) DeepCopyInto(out *UpstreamTLSPolicySpec) {
+	*out = *in
+	if in.AmbassadorID != nil {
+		in, out := &in.AmbassadorID, &out.AmbassadorID
+		*out = make(AmbassadorID, len(*in))
+		copy(*out, *in)
+	}
+	if in.MappingSelector != nil {
+		in, out := &in.MappingSelector, &out.MappingSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ALPNProtocols != nil {
+		in, out := &in.ALPNProtocols, &out.ALPNProtocols
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.SPIFFE != nil {
+		in, out := &in.SPIFFE, &out.SPIFFE
+		*out = new(UpstreamTLSSPIFFE)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UpstreamTLSPolicySpec.
+func (in *UpstreamTLSPolicySpec) DeepCopy() *UpstreamTLSPolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(UpstreamTLSPolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UpstreamTLSSPIFFE) DeepCopyInto(out *UpstreamTLSSPIFFE) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UpstreamTLSSPIFFE.
+func (in *UpstreamTLSSPIFFE) DeepCopy() *UpstreamTLSSPIFFE {
+	if in == nil {
+		return nil
+	}
+	out := new(UpstreamTLSSPIFFE)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *V2ExplicitTLS) DeepCopyInto(out *V2ExplicitTLS) {
 	*out = *in
@@ -0,0 +1,208 @@
+// Package bootstrapca is a small certificate authority, for deployments that want TLS everywhere
+// from the start without having any PKI of their own yet. It mints ECDSA P-256 certificates: a
+// long-lived CA, and short-lived leaf certificates signed by it that get renewed once they're two
+// thirds of the way through their lives.
+//
+// Everything is PEM in and PEM out, since that's what Kubernetes Secrets and Envoy both want.
+package bootstrapca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+)
+
+const (
+	// CAValidity is how long a CA is good for.
+	CAValidity = 10 * 365 * 24 * time.Hour
+	// DefaultLeafValidity is how long a leaf certificate is good for, unless the LeafSpec says
+	// otherwise.
+	DefaultLeafValidity = 90 * 24 * time.Hour
+
+	// Certificates are backdated a little, to allow for clock skew.
+	backdate = 5 * time.Minute
+)
+
+// A CA signs leaf certificates.
+type CA struct {
+	Cert *x509.Certificate
+	Key  crypto.Signer
+
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// LeafSpec describes a leaf certificate to issue.
+type LeafSpec struct {
+	CommonName string
+	DNSNames   []string
+	IPs        []net.IP
+	// Server and Client say what the certificate can be used for; at least one must be set.
+	Server bool
+	Client bool
+	// Validity is how long the certificate is good for. Zero means DefaultLeafValidity.
+	Validity time.Duration
+}
+
+// New generates a new CA, named after the given organization.
+func New(organization string, now time.Time) (*CA, error) {
+	key, keyPEM, err := genKey()
+	if err != nil {
+		return nil, err
+	}
+	serial, err := genSerial()
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{organization},
+			CommonName:   organization + " bootstrap CA",
+		},
+		NotBefore:             now.Add(-backdate),
+		NotAfter:              now.Add(CAValidity),
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{
+		Cert:    cert,
+		Key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  keyPEM,
+	}, nil
+}
+
+// Parse loads a CA from its PEM-encoded certificate and private key.
+func Parse(certPEM, keyPEM []byte) (*CA, error) {
+	cert, err := parseCert(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	if !cert.IsCA {
+		return nil, errors.New("certificate is not a CA")
+	}
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, errors.New("no PEM private key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("private key is a %T, which can't sign", key)
+	}
+	return &CA{Cert: cert, Key: signer, certPEM: certPEM, keyPEM: keyPEM}, nil
+}
+
+// CertPEM returns the CA's certificate, which is what clients need to trust.
+func (ca *CA) CertPEM() []byte {
+	return ca.certPEM
+}
+
+// KeyPEM returns the CA's private key, as PKCS#8.
+func (ca *CA) KeyPEM() []byte {
+	return ca.keyPEM
+}
+
+// Issue mints a new leaf certificate and key, signed by the CA.
+func (ca *CA) Issue(spec LeafSpec, now time.Time) (certPEM, keyPEM []byte, err error) {
+	if !spec.Server && !spec.Client {
+		return nil, nil, errors.New("a certificate needs to be for a server, a client, or both")
+	}
+	validity := spec.Validity
+	if validity == 0 {
+		validity = DefaultLeafValidity
+	}
+	notAfter := now.Add(validity)
+	// Nothing outlives the CA that signed it.
+	if notAfter.After(ca.Cert.NotAfter) {
+		notAfter = ca.Cert.NotAfter
+	}
+
+	key, keyPEM, err := genKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := genSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: ca.Cert.Subject.Organization, CommonName: spec.CommonName},
+		NotBefore:             now.Add(-backdate),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		DNSNames:              spec.DNSNames,
+		IPAddresses:           spec.IPs,
+	}
+	if spec.Server {
+		template.ExtKeyUsage = append(template.ExtKeyUsage, x509.ExtKeyUsageServerAuth)
+	}
+	if spec.Client {
+		template.ExtKeyUsage = append(template.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Cert, key.Public(), ca.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), keyPEM, nil
+}
+
+// NeedsRenewal returns whether a PEM certificate is missing, unparseable, or at least two thirds of
+// the way through its life.
+func NeedsRenewal(certPEM []byte, now time.Time) bool {
+	cert, err := parseCert(certPEM)
+	if err != nil {
+		return true
+	}
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	return now.Sub(cert.NotBefore) >= 2*lifetime/3
+}
+
+func parseCert(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func genKey() (*ecdsa.PrivateKey, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func genSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
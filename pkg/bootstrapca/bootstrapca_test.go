@@ -0,0 +1,67 @@
+package bootstrapca_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/bootstrapca"
+)
+
+func TestCA(t *testing.T) {
+	now := time.Now()
+	ca, err := bootstrapca.New("Example", now)
+	require.NoError(t, err)
+	assert.True(t, ca.Cert.IsCA)
+
+	// The CA round-trips through PEM.
+	parsed, err := bootstrapca.Parse(ca.CertPEM(), ca.KeyPEM())
+	require.NoError(t, err)
+	assert.Equal(t, ca.Cert.Raw, parsed.Cert.Raw)
+
+	certPEM, keyPEM, err := parsed.Issue(bootstrapca.LeafSpec{
+		CommonName: "xds",
+		DNSNames:   []string{"localhost"},
+		IPs:        []net.IP{net.ParseIP("127.0.0.1")},
+		Server:     true,
+	}, now)
+	require.NoError(t, err)
+	_, err = tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+
+	block, _ := pem.Decode(certPEM)
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+	_, err = leaf.Verify(x509.VerifyOptions{Roots: roots, DNSName: "localhost"})
+	assert.NoError(t, err)
+	_, err = leaf.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	assert.Error(t, err, "a server certificate isn't a client certificate")
+
+	// Leaf certificates need renewing two thirds of the way through their lives.
+	assert.False(t, bootstrapca.NeedsRenewal(certPEM, now.Add(59*24*time.Hour)))
+	assert.True(t, bootstrapca.NeedsRenewal(certPEM, now.Add(61*24*time.Hour)))
+	assert.True(t, bootstrapca.NeedsRenewal(nil, now))
+
+	_, _, err = ca.Issue(bootstrapca.LeafSpec{CommonName: "nothing"}, now)
+	assert.Error(t, err)
+
+	// Nothing outlives the CA.
+	certPEM, _, err = ca.Issue(bootstrapca.LeafSpec{CommonName: "late", Client: true}, ca.Cert.NotAfter.Add(-time.Hour))
+	require.NoError(t, err)
+	block, _ = pem.Decode(certPEM)
+	leaf, err = x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	assert.Equal(t, ca.Cert.NotAfter, leaf.NotAfter)
+
+	// A leaf isn't a CA.
+	_, err = bootstrapca.Parse(certPEM, keyPEM)
+	assert.Error(t, err)
+}
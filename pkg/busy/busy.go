@@ -11,12 +11,14 @@ import (
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
 
 	//nolint:depguard // This is one of the few places where it is approrpiate to not go through
 	// to initialize dlog.
 	"github.com/sirupsen/logrus"
 
 	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/logsink"
 )
 
 type Command struct {
@@ -67,6 +69,71 @@ func GetLogLevel() logrus.Level {
 	return logrusLogger.GetLevel()
 }
 
+// AddLogSink sends everything logged from now on to sink too, as well as to stderr.
+//
+// BUG(lukeshu): AddLogSink mutates global state, and global state is bad.
+func AddLogSink(sink logsink.Sink) {
+	logrusLogger.AddHook(sinkHook{sink: sink})
+}
+
+// sinkHook is a logrus.Hook that hands entries to a logsink.Sink.
+type sinkHook struct {
+	sink logsink.Sink
+}
+
+// sinkFormatter formats entries for a sink, which has its own idea of timestamps.
+var sinkFormatter = &logrus.TextFormatter{
+	DisableColors:    true,
+	DisableTimestamp: true,
+}
+
+func (h sinkHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h sinkHook) Fire(entry *logrus.Entry) error {
+	// Take the caller and the level out of the message: the sink's entry has a severity, and
+	// the caller is a lot of noise for a log pipeline.
+	data := make(logrus.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		data[k] = v
+	}
+	app, _ := data["CMD"].(string)
+	delete(data, "CMD")
+	msg, err := sinkFormatter.Format(&logrus.Entry{
+		Logger:  entry.Logger,
+		Data:    data,
+		Time:    entry.Time,
+		Level:   entry.Level,
+		Message: entry.Message,
+	})
+	if err != nil {
+		return err
+	}
+	h.sink.Send(logsink.Entry{
+		Time:     entry.Time,
+		Severity: sinkSeverity(entry.Level),
+		App:      app,
+		Message:  strings.TrimSuffix(strings.TrimPrefix(string(msg), "level="+entry.Level.String()+" "), "\n"),
+	})
+	return nil
+}
+
+func sinkSeverity(level logrus.Level) logsink.Severity {
+	switch level {
+	case logrus.PanicLevel, logrus.FatalLevel:
+		return logsink.Critical
+	case logrus.ErrorLevel:
+		return logsink.Error
+	case logrus.WarnLevel:
+		return logsink.Warning
+	case logrus.InfoLevel:
+		return logsink.Informational
+	default:
+		return logsink.Debug
+	}
+}
+
 // Main should be called from your actual main() function.
 func Main(binName, humanName string, version string, cmds map[string]Command) {
 	name := filepath.Base(os.Args[0])
@@ -1,14 +1,19 @@
 package busy
 
 import (
+	"context"
 	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	//nolint:depguard // This is one of the few places where it is approrpiate to not go through
 	// dlog: to initialize dlog.
 	"github.com/sirupsen/logrus"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/emissary-ingress/emissary/v3/pkg/logsink"
 )
 
 func TestLoggingTextFormatterDefault(t *testing.T) {
@@ -33,3 +38,33 @@ func TestLoggingJsonFormatter(t *testing.T) {
 	}
 	assert.Equal(t, "2006-01-02 15:04:05.0000", fm.TimestampFormat)
 }
+
+type fakeSink struct {
+	entries []logsink.Entry
+}
+
+func (s *fakeSink) Send(e logsink.Entry) { s.entries = append(s.entries, e) }
+func (s *fakeSink) Dropped() uint64      { return 0 }
+func (s *fakeSink) Close() error         { return nil }
+
+func TestLogSink(t *testing.T) {
+	os.Unsetenv("AMBASSADOR_JSON_LOGGING")
+	testInit()
+	sink := &fakeSink{}
+	AddLogSink(sink)
+
+	ctx := dlog.WithLogger(context.Background(), dlog.WrapLogrus(logrusLogger).WithField("CMD", "entrypoint"))
+	dlog.Infof(ctx, "hello %s", "there")
+	dlog.Debugf(ctx, "not at this level")
+	dlog.Warnln(dlog.WithField(ctx, "count", 3), "careful")
+
+	require.Len(t, sink.entries, 2)
+	assert.Equal(t, logsink.Entry{
+		Time:     sink.entries[0].Time,
+		Severity: logsink.Informational,
+		App:      "entrypoint",
+		Message:  `msg="hello there"`,
+	}, sink.entries[0])
+	assert.Equal(t, logsink.Warning, sink.entries[1].Severity)
+	assert.Equal(t, "msg=careful count=3", sink.entries[1].Message)
+}
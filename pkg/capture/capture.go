@@ -0,0 +1,415 @@
+// Package capture records real request/response pairs for a single Mapping, so that a misbehaving
+// route can be debugged (or its traffic replayed) without resorting to tcpdump.
+//
+// When the TrafficCapture feature gate is on, diagd puts an Envoy tap filter on every HTTP listener,
+// configured with ConfigID. The filter does nothing until a tap is attached to it through Envoy's
+// /tap admin endpoint. A Capturer attaches one that matches a Mapping's prefix, hostname, and
+// method, and keeps the traces that Envoy streams back, sampled, with their bodies truncated and
+// their secrets redacted, until it has enough of them, runs out of time, or is stopped.
+//
+// A Mirror does the same all the time, for every Mapping with `contract_capture` set, and sends each
+// exchange on to the Mapping's capture endpoint instead of keeping it. It gets the traffic from
+// Envoy's ext_proc filter rather than the tap filter, since there can only be one tap at a time.
+package capture
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	v3admin "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/admin/v3"
+	v3commonmatcher "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/common/matcher/v3"
+	v3core "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/core/v3"
+	v3route "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/route/v3"
+	v3tapconfig "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/tap/v3"
+	v3tapdata "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/data/tap/v3"
+	v3matcher "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/type/matcher/v3"
+)
+
+// ConfigID is the admin config ID of the tap filter that diagd configures. It has to match
+// CAPTURE_CONFIG_ID in python/ambassador/envoy/v3/v3listener.py.
+const ConfigID = "ambassador_capture"
+
+// Redacted replaces the value of every redacted header.
+const Redacted = "[REDACTED]"
+
+// DefaultRedactHeaders are always redacted, on top of whatever the Spec asks for.
+var DefaultRedactHeaders = []string{
+	"authorization",
+	"proxy-authorization",
+	"cookie",
+	"set-cookie",
+	"x-api-key",
+}
+
+// Defaults and limits for a Spec.
+const (
+	DefaultSampleRate   = 1.0
+	DefaultMaxBodyBytes = 4 * 1024
+	DefaultMaxTraces    = 100
+	DefaultDuration     = 5 * time.Minute
+
+	MaxMaxBodyBytes = 1024 * 1024
+	MaxMaxTraces    = 1000
+	MaxDuration     = 1 * time.Hour
+)
+
+// ErrRunning is returned by Start when a capture is already running. Envoy only allows one tap per
+// config ID, so there can only be one capture at a time.
+var ErrRunning = errors.New("a capture is already running")
+
+// Spec says what to capture, and how much of it.
+type Spec struct {
+	// Mapping is the "namespace/name" of the Mapping being captured. It's only informational; the
+	// match fields below are what matter.
+	Mapping string `json:"mapping"`
+
+	Prefix      string `json:"prefix,omitempty"`
+	PrefixRegex bool   `json:"prefix_regex,omitempty"`
+	Hostname    string `json:"hostname,omitempty"`
+	Method      string `json:"method,omitempty"`
+	MethodRegex bool   `json:"method_regex,omitempty"`
+
+	// SampleRate is the fraction of matching requests to keep, in (0, 1].
+	SampleRate float64 `json:"sample_rate"`
+	// MaxBodyBytes is how much of each request and response body Envoy buffers; the rest is
+	// dropped, and the trace marked as truncated.
+	MaxBodyBytes uint32 `json:"max_body_bytes"`
+	// MaxTraces is how many traces to keep before stopping.
+	MaxTraces int `json:"max_traces"`
+	// Duration is how long to capture for, at most.
+	Duration time.Duration `json:"-"`
+	// RedactHeaders are redacted in addition to DefaultRedactHeaders.
+	RedactHeaders []string `json:"redact_headers,omitempty"`
+}
+
+// FillDefaults fills in the zero fields of s, and returns an error if anything is out of range.
+func (s *Spec) FillDefaults() error {
+	if s.SampleRate == 0 {
+		s.SampleRate = DefaultSampleRate
+	}
+	if s.MaxBodyBytes == 0 {
+		s.MaxBodyBytes = DefaultMaxBodyBytes
+	}
+	if s.MaxTraces == 0 {
+		s.MaxTraces = DefaultMaxTraces
+	}
+	if s.Duration == 0 {
+		s.Duration = DefaultDuration
+	}
+
+	switch {
+	case s.SampleRate < 0 || s.SampleRate > 1:
+		return fmt.Errorf("sample rate %v is not between 0 and 1", s.SampleRate)
+	case s.MaxBodyBytes > MaxMaxBodyBytes:
+		return fmt.Errorf("max body bytes %d is more than %d", s.MaxBodyBytes, MaxMaxBodyBytes)
+	case s.MaxTraces < 0 || s.MaxTraces > MaxMaxTraces:
+		return fmt.Errorf("max traces %d is not between 1 and %d", s.MaxTraces, MaxMaxTraces)
+	case s.Duration < 0 || s.Duration > MaxDuration:
+		return fmt.Errorf("duration %v is not between 0 and %v", s.Duration, MaxDuration)
+	}
+	return nil
+}
+
+// Status is the state of the current (or most recent) capture.
+type Status struct {
+	Spec
+	Started  time.Time `json:"started"`
+	Deadline time.Time `json:"deadline"`
+	Running  bool      `json:"running"`
+	// Seen is how many traces Envoy sent, before sampling.
+	Seen  int    `json:"seen"`
+	Error string `json:"error,omitempty"`
+	// Traces are envoy.data.tap.v3.TraceWrapper messages, in JSON.
+	Traces []json.RawMessage `json:"traces"`
+}
+
+// A Capturer runs captures against a single Envoy.
+type Capturer struct {
+	adminURL string
+	client   *http.Client
+
+	// The mutex protects status and cancel.
+	mutex  sync.Mutex
+	status *Status
+	cancel context.CancelFunc
+}
+
+// NewCapturer returns a Capturer that talks to the Envoy admin interface at adminURL.
+func NewCapturer(adminURL string) *Capturer {
+	return &Capturer{
+		adminURL: strings.TrimSuffix(adminURL, "/"),
+		client:   &http.Client{},
+	}
+}
+
+// Start starts capturing in the background, until the Spec's limits are reached, Stop is called,
+// or ctx is canceled. It returns ErrRunning if there's already a capture running.
+func (c *Capturer) Start(ctx context.Context, spec Spec) error {
+	if err := spec.FillDefaults(); err != nil {
+		return err
+	}
+	body, err := TapRequest(spec)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.status != nil && c.status.Running {
+		return ErrRunning
+	}
+
+	now := time.Now()
+	ctx, cancel := context.WithDeadline(ctx, now.Add(spec.Duration))
+	status := &Status{
+		Spec:     spec,
+		Started:  now,
+		Deadline: now.Add(spec.Duration),
+		Running:  true,
+		Traces:   []json.RawMessage{},
+	}
+	c.status = status
+	c.cancel = cancel
+
+	go c.run(ctx, cancel, status, body)
+	return nil
+}
+
+// Stop stops the running capture, if there is one. Its traces are kept until the next Start.
+func (c *Capturer) Stop() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+// Status returns a copy of the state of the current (or most recent) capture, or nil if there
+// hasn't been one.
+func (c *Capturer) Status() *Status {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.status == nil {
+		return nil
+	}
+	status := *c.status
+	status.Traces = append([]json.RawMessage{}, c.status.Traces...)
+	return &status
+}
+
+func (c *Capturer) run(ctx context.Context, cancel context.CancelFunc, status *Status, body []byte) {
+	defer cancel()
+	err := c.stream(ctx, status, body)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	status.Running = false
+	// Running out of time, or being stopped, is how most captures end; that's not an error.
+	if err != nil && ctx.Err() == nil {
+		status.Error = err.Error()
+	}
+}
+
+func (c *Capturer) stream(ctx context.Context, status *Status, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.adminURL+"/tap", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("envoy refused the tap: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	redact := append(append([]string{}, DefaultRedactHeaders...), status.RedactHeaders...)
+
+	// Envoy streams one JSON TraceWrapper after another, for as long as we keep the request open.
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				return errors.New("envoy ended the tap (was the tap filter reconfigured?)")
+			}
+			return err
+		}
+		trace, err := Redact(raw, redact)
+		if err != nil {
+			return err
+		}
+
+		c.mutex.Lock()
+		status.Seen++
+		if rand.Float64() < status.SampleRate {
+			status.Traces = append(status.Traces, trace)
+		}
+		done := len(status.Traces) >= status.MaxTraces
+		c.mutex.Unlock()
+
+		if done {
+			return nil
+		}
+	}
+}
+
+// TapRequest returns the body of the Envoy admin /tap request for spec.
+func TapRequest(spec Spec) ([]byte, error) {
+	match, err := matchPredicate(spec)
+	if err != nil {
+		return nil, err
+	}
+	req := &v3admin.TapRequest{
+		ConfigId: ConfigID,
+		TapConfig: &v3tapconfig.TapConfig{
+			Match: match,
+			OutputConfig: &v3tapconfig.OutputConfig{
+				Sinks: []*v3tapconfig.OutputSink{{
+					// Bytes, not strings, so that binary bodies survive for replay.
+					Format: v3tapconfig.OutputSink_JSON_BODY_AS_BYTES,
+					OutputSinkType: &v3tapconfig.OutputSink_StreamingAdmin{
+						StreamingAdmin: &v3tapconfig.StreamingAdminSink{},
+					},
+				}},
+				MaxBufferedRxBytes: &wrappers.UInt32Value{Value: spec.MaxBodyBytes},
+				MaxBufferedTxBytes: &wrappers.UInt32Value{Value: spec.MaxBodyBytes},
+			},
+		},
+	}
+	return protojson.MarshalOptions{UseProtoNames: true}.Marshal(req)
+}
+
+// matchPredicate matches the requests that the Mapping described by spec would. The tap filter runs
+// before routing, so it can't just ask which route was picked; it has to match the headers that
+// the route would have.
+func matchPredicate(spec Spec) (*v3commonmatcher.MatchPredicate, error) {
+	var headers []*v3route.HeaderMatcher
+
+	if spec.Prefix != "" {
+		var pathMatch *v3matcher.StringMatcher
+		if spec.PrefixRegex {
+			if _, err := regexp.Compile(spec.Prefix); err != nil {
+				return nil, fmt.Errorf("prefix: %w", err)
+			}
+			// A route regex matches the path without the query, but :path has the query too.
+			pathMatch = safeRegex(`(?:` + spec.Prefix + `)(?:\?.*)?`)
+		} else {
+			pathMatch = &v3matcher.StringMatcher{MatchPattern: &v3matcher.StringMatcher_Prefix{Prefix: spec.Prefix}}
+		}
+		headers = append(headers, stringMatchHeader(":path", pathMatch))
+	}
+
+	if spec.Hostname != "" && spec.Hostname != "*" {
+		headers = append(headers, stringMatchHeader(":authority", safeRegex(hostnameRegex(spec.Hostname))))
+	}
+
+	if spec.Method != "" {
+		var methodMatch *v3matcher.StringMatcher
+		if spec.MethodRegex {
+			if _, err := regexp.Compile(spec.Method); err != nil {
+				return nil, fmt.Errorf("method: %w", err)
+			}
+			methodMatch = safeRegex(spec.Method)
+		} else {
+			methodMatch = &v3matcher.StringMatcher{MatchPattern: &v3matcher.StringMatcher_Exact{Exact: spec.Method}}
+		}
+		headers = append(headers, stringMatchHeader(":method", methodMatch))
+	}
+
+	if len(headers) == 0 {
+		return &v3commonmatcher.MatchPredicate{
+			Rule: &v3commonmatcher.MatchPredicate_AnyMatch{AnyMatch: true},
+		}, nil
+	}
+	return &v3commonmatcher.MatchPredicate{
+		Rule: &v3commonmatcher.MatchPredicate_HttpRequestHeadersMatch{
+			HttpRequestHeadersMatch: &v3commonmatcher.HttpHeadersMatch{Headers: headers},
+		},
+	}, nil
+}
+
+// hostnameRegex matches an :authority against a Mapping hostname, which may have a leading or
+// trailing "*" wildcard. The :authority may have a port on it.
+func hostnameRegex(hostname string) string {
+	var re string
+	switch {
+	case strings.HasPrefix(hostname, "*"):
+		re = `.*` + regexp.QuoteMeta(hostname[1:])
+	case strings.HasSuffix(hostname, "*"):
+		re = regexp.QuoteMeta(hostname[:len(hostname)-1]) + `.*`
+	default:
+		re = regexp.QuoteMeta(hostname)
+	}
+	return `(?i)` + re + `(?::[0-9]+)?`
+}
+
+func safeRegex(re string) *v3matcher.StringMatcher {
+	return &v3matcher.StringMatcher{
+		MatchPattern: &v3matcher.StringMatcher_SafeRegex{
+			SafeRegex: &v3matcher.RegexMatcher{Regex: re},
+		},
+	}
+}
+
+func stringMatchHeader(name string, match *v3matcher.StringMatcher) *v3route.HeaderMatcher {
+	return &v3route.HeaderMatcher{
+		Name:                 name,
+		HeaderMatchSpecifier: &v3route.HeaderMatcher_StringMatch{StringMatch: match},
+	}
+}
+
+// Redact replaces the values of the named headers (and trailers), case-insensitively, in a JSON
+// TraceWrapper from Envoy.
+func Redact(raw []byte, headers []string) (json.RawMessage, error) {
+	var trace v3tapdata.TraceWrapper
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(raw, &trace); err != nil {
+		return nil, fmt.Errorf("bad trace from envoy: %w", err)
+	}
+	redactTrace(&trace, headers)
+	return protojson.MarshalOptions{UseProtoNames: true}.Marshal(&trace)
+}
+
+func redactTrace(trace *v3tapdata.TraceWrapper, headers []string) {
+	redact := make(map[string]bool, len(headers))
+	for _, h := range headers {
+		redact[strings.ToLower(h)] = true
+	}
+	redactHeaders := func(values []*v3core.HeaderValue) {
+		for _, hv := range values {
+			if redact[strings.ToLower(hv.Key)] {
+				hv.Value = Redacted
+			}
+		}
+	}
+
+	if buffered := trace.GetHttpBufferedTrace(); buffered != nil {
+		for _, msg := range []*v3tapdata.HttpBufferedTrace_Message{buffered.GetRequest(), buffered.GetResponse()} {
+			redactHeaders(msg.GetHeaders())
+			redactHeaders(msg.GetTrailers())
+		}
+	}
+	if segment := trace.GetHttpStreamedTraceSegment(); segment != nil {
+		for _, hm := range []*v3core.HeaderMap{
+			segment.GetRequestHeaders(), segment.GetRequestTrailers(),
+			segment.GetResponseHeaders(), segment.GetResponseTrailers(),
+		} {
+			redactHeaders(hm.GetHeaders())
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package capture_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/capture"
+)
+
+const trace = `{
+  "http_buffered_trace": {
+    "request": {
+      "headers": [
+        {"key": ":path", "value": "/hello/%d"},
+        {"key": "Authorization", "value": "Bearer s3cret"},
+        {"key": "x-internal-token", "value": "t0ken"}
+      ],
+      "body": {"as_bytes": "aGk=", "truncated": true}
+    },
+    "response": {
+      "headers": [{"key": ":status", "value": "200"}, {"key": "set-cookie", "value": "session=abc"}]
+    }
+  }
+}`
+
+func TestTapRequest(t *testing.T) {
+	spec := capture.Spec{Prefix: "/hello/", Hostname: "*.example.com", Method: "GET", MaxBodyBytes: 512}
+	body, err := capture.TapRequest(spec)
+	require.NoError(t, err)
+
+	var req map[string]interface{}
+	require.NoError(t, json.Unmarshal(body, &req))
+	assert.Equal(t, capture.ConfigID, req["config_id"])
+
+	tapConfig := req["tap_config"].(map[string]interface{})
+	headers := tapConfig["match"].(map[string]interface{})["http_request_headers_match"].(map[string]interface{})["headers"].([]interface{})
+	require.Len(t, headers, 3)
+	assert.Equal(t, map[string]interface{}{"name": ":path", "string_match": map[string]interface{}{"prefix": "/hello/"}}, headers[0])
+	assert.Equal(t, ":authority", headers[1].(map[string]interface{})["name"])
+	assert.Equal(t, `(?i).*\.example\.com(?::[0-9]+)?`,
+		headers[1].(map[string]interface{})["string_match"].(map[string]interface{})["safe_regex"].(map[string]interface{})["regex"])
+	assert.Equal(t, map[string]interface{}{"name": ":method", "string_match": map[string]interface{}{"exact": "GET"}}, headers[2])
+
+	output := tapConfig["output_config"].(map[string]interface{})
+	assert.Equal(t, float64(512), output["max_buffered_rx_bytes"])
+	assert.Equal(t, float64(512), output["max_buffered_tx_bytes"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"streaming_admin": map[string]interface{}{}}}, output["sinks"])
+
+	_, err = capture.TapRequest(capture.Spec{Prefix: "/(unclosed", PrefixRegex: true})
+	assert.Error(t, err)
+}
+
+func TestRedact(t *testing.T) {
+	out, err := capture.Redact([]byte(fmt.Sprintf(trace, 1)), append(capture.DefaultRedactHeaders, "X-Internal-Token"))
+	require.NoError(t, err)
+
+	var got struct {
+		Trace struct {
+			Request struct {
+				Headers []struct{ Key, Value string }
+				Body    struct {
+					AsBytes   string `json:"as_bytes"`
+					Truncated bool
+				}
+			}
+			Response struct {
+				Headers []struct{ Key, Value string }
+			}
+		} `json:"http_buffered_trace"`
+	}
+	require.NoError(t, json.Unmarshal(out, &got))
+	assert.Equal(t, "/hello/1", got.Trace.Request.Headers[0].Value)
+	assert.Equal(t, capture.Redacted, got.Trace.Request.Headers[1].Value)
+	assert.Equal(t, capture.Redacted, got.Trace.Request.Headers[2].Value)
+	assert.Equal(t, "aGk=", got.Trace.Request.Body.AsBytes)
+	assert.True(t, got.Trace.Request.Body.Truncated)
+	assert.Equal(t, "200", got.Trace.Response.Headers[0].Value)
+	assert.Equal(t, capture.Redacted, got.Trace.Response.Headers[1].Value)
+}
+
+// fakeEnvoy serves /tap like Envoy's admin interface does: it streams traces until the client goes
+// away.
+func fakeEnvoy(requests chan<- []byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- body
+		for i := 0; ; i++ {
+			if _, err := fmt.Fprintf(w, trace+"\n", i); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}))
+}
+
+func TestCapturer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	requests := make(chan []byte, 10)
+	envoy := fakeEnvoy(requests)
+	defer envoy.Close()
+
+	c := capture.NewCapturer(envoy.URL + "/")
+	assert.Nil(t, c.Status())
+
+	require.NoError(t, c.Start(ctx, capture.Spec{Mapping: "default/hello", Prefix: "/hello/", MaxTraces: 5}))
+	assert.ErrorIs(t, c.Start(ctx, capture.Spec{Prefix: "/other/"}), capture.ErrRunning)
+	<-requests
+
+	require.Eventually(t, func() bool { return !c.Status().Running }, 10*time.Second, 10*time.Millisecond)
+	status := c.Status()
+	assert.Equal(t, "default/hello", status.Mapping)
+	assert.Empty(t, status.Error)
+	assert.Len(t, status.Traces, 5)
+	assert.Equal(t, 5, status.Seen)
+
+	// Sampling throws some away.
+	require.NoError(t, c.Start(ctx, capture.Spec{Prefix: "/hello/", SampleRate: 0.5, MaxTraces: 20}))
+	<-requests
+	require.Eventually(t, func() bool { return !c.Status().Running }, 10*time.Second, 10*time.Millisecond)
+	status = c.Status()
+	assert.Len(t, status.Traces, 20)
+	assert.Greater(t, status.Seen, 20)
+
+	// Stopping keeps what's been captured so far.
+	require.NoError(t, c.Start(ctx, capture.Spec{Prefix: "/hello/", SampleRate: 0.001}))
+	<-requests
+	c.Stop()
+	require.Eventually(t, func() bool { return !c.Status().Running }, 10*time.Second, 10*time.Millisecond)
+	assert.Empty(t, c.Status().Error)
+
+	assert.Error(t, c.Start(ctx, capture.Spec{SampleRate: 2}))
+}
@@ -0,0 +1,296 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	v3tapdata "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/data/tap/v3"
+	v3extprocfilter "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/extensions/filters/http/ext_proc/v3"
+	v3extproc "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/service/ext_proc/v3"
+)
+
+// MirrorMetadata is the gRPC metadata that each route's ext_proc override sends the Mapping's
+// "name.namespace" in. It has to match CONTRACT_CAPTURE_METADATA in
+// python/ambassador/ir/ircontractcapture.py.
+const MirrorMetadata = "x-ambassador-mapping"
+
+// MirrorPostTimeout is the most that sending one exchange to a capture endpoint can take.
+const MirrorPostTimeout = 10 * time.Second
+
+// A MirrorTarget says where to mirror a Mapping's traffic to, and how much of it.
+type MirrorTarget struct {
+	// Endpoint is the URL that each exchange is POSTed to.
+	Endpoint string `json:"endpoint"`
+	// SampleRate is the fraction of requests to mirror, in [0, 1].
+	SampleRate float64 `json:"sample_rate"`
+	// MaxBodyBytes is how much of each request and response body to keep.
+	MaxBodyBytes int `json:"max_body_bytes"`
+	// RedactHeaders are redacted in addition to DefaultRedactHeaders.
+	RedactHeaders []string `json:"redact_headers,omitempty"`
+}
+
+// An Exchange is what gets POSTed to a capture endpoint: one request and its response.
+type Exchange struct {
+	// Mapping is the "name.namespace" of the Mapping that the request went through.
+	Mapping string    `json:"mapping"`
+	Time    time.Time `json:"time"`
+	// Trace is an envoy.data.tap.v3.TraceWrapper with an http_buffered_trace in it, in JSON, just
+	// like the traces that a Capturer keeps.
+	Trace json.RawMessage `json:"trace"`
+}
+
+// MirrorStats count what a Mirror has done with the requests it's seen.
+type MirrorStats struct {
+	// Seen is how many requests Envoy asked about, before sampling.
+	Seen uint64 `json:"seen"`
+	// Sampled is how many of them were mirrored.
+	Sampled uint64 `json:"sampled"`
+	// Sent is how many exchanges their capture endpoints accepted.
+	Sent uint64 `json:"sent"`
+	// Dropped is how many exchanges were thrown away because too many were waiting to be sent.
+	Dropped uint64 `json:"dropped"`
+	// Failed is how many exchanges couldn't be sent, or were refused.
+	Failed uint64 `json:"failed"`
+}
+
+type mirrorPost struct {
+	endpoint string
+	body     []byte
+}
+
+// A Mirror is an ext_proc server that samples the requests and responses of the Mappings it has
+// targets for, and sends them to the targets' endpoints. Mirroring never holds up the traffic
+// itself: Envoy gets its answer before the exchange is sent anywhere, and if the endpoints fall
+// behind, exchanges are dropped.
+type Mirror struct {
+	v3extproc.UnimplementedExternalProcessorServer
+
+	client *http.Client
+	queue  chan mirrorPost
+
+	// The mutex protects targets and stats.
+	mutex   sync.Mutex
+	targets map[string]MirrorTarget
+	stats   MirrorStats
+}
+
+// NewMirror returns a Mirror with no targets, which holds up to queueSize exchanges that are
+// waiting to be sent.
+func NewMirror(queueSize int) *Mirror {
+	return &Mirror{
+		client:  &http.Client{Timeout: MirrorPostTimeout},
+		queue:   make(chan mirrorPost, queueSize),
+		targets: map[string]MirrorTarget{},
+	}
+}
+
+// SetTargets replaces the Mirror's targets, by Mapping "name.namespace". Requests that are already
+// being mirrored carry on with the target they started with.
+func (m *Mirror) SetTargets(targets map[string]MirrorTarget) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.targets = targets
+}
+
+// Stats returns a copy of the Mirror's counters.
+func (m *Mirror) Stats() MirrorStats {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.stats
+}
+
+func (m *Mirror) count(counter *uint64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	*counter++
+}
+
+// sample says whether to mirror a request through the named Mapping, and where to.
+func (m *Mirror) sample(mapping string) (MirrorTarget, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.stats.Seen++
+	target, ok := m.targets[mapping]
+	if !ok || rand.Float64() >= target.SampleRate {
+		return MirrorTarget{}, false
+	}
+	m.stats.Sampled++
+	return target, true
+}
+
+// skipTheRest tells Envoy not to send anything more about a request that isn't being mirrored.
+var skipTheRest = &v3extprocfilter.ProcessingMode{
+	RequestHeaderMode:   v3extprocfilter.ProcessingMode_SKIP,
+	ResponseHeaderMode:  v3extprocfilter.ProcessingMode_SKIP,
+	RequestBodyMode:     v3extprocfilter.ProcessingMode_NONE,
+	ResponseBodyMode:    v3extprocfilter.ProcessingMode_NONE,
+	RequestTrailerMode:  v3extprocfilter.ProcessingMode_SKIP,
+	ResponseTrailerMode: v3extprocfilter.ProcessingMode_SKIP,
+}
+
+// Process implements ExternalProcessorServer. It lets every request and response through
+// unchanged, and builds up a trace of the ones it samples as they go by.
+func (m *Mirror) Process(stream v3extproc.ExternalProcessor_ProcessServer) error {
+	var mapping string
+	if md, ok := metadata.FromIncomingContext(stream.Context()); ok {
+		if values := md.Get(MirrorMetadata); len(values) > 0 {
+			mapping = values[0]
+		}
+	}
+
+	var target MirrorTarget
+	var trace *v3tapdata.HttpBufferedTrace
+	started := time.Now()
+	// finish sends what there is of the trace, the first time it's called.
+	finish := func() {
+		if trace != nil {
+			m.send(mapping, started, target, trace)
+			trace = nil
+		}
+	}
+	// A request can end before its response is complete, or before there's a response at all;
+	// whatever was seen of it still goes to the endpoint.
+	defer finish()
+
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		resp := &v3extproc.ProcessingResponse{}
+		switch r := req.Request.(type) {
+		case *v3extproc.ProcessingRequest_RequestHeaders:
+			resp.Response = &v3extproc.ProcessingResponse_RequestHeaders{RequestHeaders: &v3extproc.HeadersResponse{}}
+			var sampled bool
+			if target, sampled = m.sample(mapping); !sampled {
+				resp.ModeOverride = skipTheRest
+				break
+			}
+			trace = &v3tapdata.HttpBufferedTrace{
+				Request:  &v3tapdata.HttpBufferedTrace_Message{Headers: r.RequestHeaders.GetHeaders().GetHeaders()},
+				Response: &v3tapdata.HttpBufferedTrace_Message{},
+			}
+		case *v3extproc.ProcessingRequest_RequestBody:
+			resp.Response = &v3extproc.ProcessingResponse_RequestBody{RequestBody: &v3extproc.BodyResponse{}}
+			if trace != nil {
+				trace.Request.Body = mirrorBody(r.RequestBody, target.MaxBodyBytes)
+			}
+		case *v3extproc.ProcessingRequest_RequestTrailers:
+			resp.Response = &v3extproc.ProcessingResponse_RequestTrailers{RequestTrailers: &v3extproc.TrailersResponse{}}
+			if trace != nil {
+				trace.Request.Trailers = r.RequestTrailers.GetTrailers().GetHeaders()
+			}
+		case *v3extproc.ProcessingRequest_ResponseHeaders:
+			resp.Response = &v3extproc.ProcessingResponse_ResponseHeaders{ResponseHeaders: &v3extproc.HeadersResponse{}}
+			if trace != nil {
+				trace.Response.Headers = r.ResponseHeaders.GetHeaders().GetHeaders()
+				if r.ResponseHeaders.GetEndOfStream() {
+					finish()
+				}
+			}
+		case *v3extproc.ProcessingRequest_ResponseBody:
+			resp.Response = &v3extproc.ProcessingResponse_ResponseBody{ResponseBody: &v3extproc.BodyResponse{}}
+			if trace != nil {
+				trace.Response.Body = mirrorBody(r.ResponseBody, target.MaxBodyBytes)
+				finish()
+			}
+		case *v3extproc.ProcessingRequest_ResponseTrailers:
+			resp.Response = &v3extproc.ProcessingResponse_ResponseTrailers{ResponseTrailers: &v3extproc.TrailersResponse{}}
+			if trace != nil {
+				trace.Response.Trailers = r.ResponseTrailers.GetTrailers().GetHeaders()
+				finish()
+			}
+		default:
+			return status.Errorf(codes.InvalidArgument, "unknown request type %T", req.Request)
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// mirrorBody keeps up to maxBytes of a body. Envoy only sends as much of a body as it buffers, so a
+// body that doesn't end the stream is truncated too.
+func mirrorBody(body *v3extproc.HttpBody, maxBytes int) *v3tapdata.Body {
+	data := body.GetBody()
+	truncated := !body.GetEndOfStream()
+	if len(data) > maxBytes {
+		data = data[:maxBytes]
+		truncated = true
+	}
+	return &v3tapdata.Body{
+		BodyType:  &v3tapdata.Body_AsBytes{AsBytes: data},
+		Truncated: truncated,
+	}
+}
+
+// send redacts a trace and queues it to be POSTed to the target's endpoint.
+func (m *Mirror) send(mapping string, started time.Time, target MirrorTarget, trace *v3tapdata.HttpBufferedTrace) {
+	wrapper := &v3tapdata.TraceWrapper{Trace: &v3tapdata.TraceWrapper_HttpBufferedTrace{HttpBufferedTrace: trace}}
+	redactTrace(wrapper, append(append([]string{}, DefaultRedactHeaders...), target.RedactHeaders...))
+	traceJSON, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(wrapper)
+	if err != nil {
+		m.count(&m.stats.Failed)
+		return
+	}
+	body, err := json.Marshal(Exchange{Mapping: mapping, Time: started, Trace: traceJSON})
+	if err != nil {
+		m.count(&m.stats.Failed)
+		return
+	}
+	select {
+	case m.queue <- mirrorPost{endpoint: target.Endpoint, body: body}:
+	default:
+		m.count(&m.stats.Dropped)
+	}
+}
+
+// Run sends queued exchanges to their endpoints, one at a time, until ctx is done.
+func (m *Mirror) Run(ctx context.Context) error {
+	for {
+		select {
+		case post := <-m.queue:
+			if err := m.post(ctx, post); err != nil {
+				m.count(&m.stats.Failed)
+				continue
+			}
+			m.count(&m.stats.Sent)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (m *Mirror) post(ctx context.Context, post mirrorPost) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, post.endpoint, bytes.NewReader(post.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s refused the exchange: %s", post.endpoint, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package capture_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	v3core "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/core/v3"
+	v3extprocfilter "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/extensions/filters/http/ext_proc/v3"
+	v3extproc "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/service/ext_proc/v3"
+	"github.com/emissary-ingress/emissary/v3/pkg/capture"
+)
+
+func headers(kv ...string) *v3core.HeaderMap {
+	hm := &v3core.HeaderMap{}
+	for i := 0; i < len(kv); i += 2 {
+		hm.Headers = append(hm.Headers, &v3core.HeaderValue{Key: kv[i], Value: kv[i+1]})
+	}
+	return hm
+}
+
+// exchange sends Envoy's side of one request to the Mirror, as the named Mapping, and returns the
+// Mirror's answers.
+func exchange(t *testing.T, client v3extproc.ExternalProcessorClient, mapping string, reqs ...*v3extproc.ProcessingRequest) []*v3extproc.ProcessingResponse {
+	ctx := metadata.AppendToOutgoingContext(context.Background(), capture.MirrorMetadata, mapping)
+	stream, err := client.Process(ctx)
+	require.NoError(t, err)
+	var resps []*v3extproc.ProcessingResponse
+	for _, req := range reqs {
+		require.NoError(t, stream.Send(req))
+		resp, err := stream.Recv()
+		require.NoError(t, err)
+		resps = append(resps, resp)
+	}
+	require.NoError(t, stream.CloseSend())
+	_, err = stream.Recv()
+	require.Equal(t, io.EOF, err)
+	return resps
+}
+
+func TestMirror(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	received := make(chan capture.Exchange, 10)
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ex capture.Exchange
+		if err := json.NewDecoder(r.Body).Decode(&ex); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		received <- ex
+	}))
+	defer endpoint.Close()
+
+	mirror := capture.NewMirror(10)
+	mirror.SetTargets(map[string]capture.MirrorTarget{
+		"quote.default": {Endpoint: endpoint.URL, SampleRate: 1, MaxBodyBytes: 4, RedactHeaders: []string{"X-Secret"}},
+		"never.default": {Endpoint: endpoint.URL, SampleRate: 0, MaxBodyBytes: 4},
+	})
+	go func() {
+		_ = mirror.Run(ctx)
+	}()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer()
+	v3extproc.RegisterExternalProcessorServer(server, mirror)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := v3extproc.NewExternalProcessorClient(conn)
+
+	resps := exchange(t, client, "quote.default",
+		&v3extproc.ProcessingRequest{Request: &v3extproc.ProcessingRequest_RequestHeaders{RequestHeaders: &v3extproc.HttpHeaders{
+			Headers: headers(":path", "/quote/", "authorization", "Bearer s3cret", "x-secret", "shh"),
+		}}},
+		&v3extproc.ProcessingRequest{Request: &v3extproc.ProcessingRequest_RequestBody{RequestBody: &v3extproc.HttpBody{
+			Body: []byte("hello world"), EndOfStream: true,
+		}}},
+		&v3extproc.ProcessingRequest{Request: &v3extproc.ProcessingRequest_ResponseHeaders{ResponseHeaders: &v3extproc.HttpHeaders{
+			Headers: headers(":status", "200"),
+		}}},
+		&v3extproc.ProcessingRequest{Request: &v3extproc.ProcessingRequest_ResponseBody{ResponseBody: &v3extproc.HttpBody{
+			Body: []byte("ok"), EndOfStream: true,
+		}}},
+	)
+	// Nothing is ever changed.
+	require.Len(t, resps, 4)
+	assert.Nil(t, resps[0].ModeOverride)
+	assert.NotNil(t, resps[0].GetRequestHeaders())
+	assert.NotNil(t, resps[1].GetRequestBody())
+	assert.NotNil(t, resps[2].GetResponseHeaders())
+	assert.NotNil(t, resps[3].GetResponseBody())
+
+	var ex capture.Exchange
+	select {
+	case ex = <-received:
+	case <-time.After(10 * time.Second):
+		t.Fatal("nothing was mirrored")
+	}
+	assert.Equal(t, "quote.default", ex.Mapping)
+
+	var got struct {
+		Trace struct {
+			Request struct {
+				Headers []struct{ Key, Value string }
+				Body    struct {
+					AsBytes   string `json:"as_bytes"`
+					Truncated bool
+				}
+			}
+			Response struct {
+				Headers []struct{ Key, Value string }
+				Body    struct {
+					AsBytes   string `json:"as_bytes"`
+					Truncated bool
+				}
+			}
+		} `json:"http_buffered_trace"`
+	}
+	require.NoError(t, json.Unmarshal(ex.Trace, &got))
+	require.Len(t, got.Trace.Request.Headers, 3)
+	assert.Equal(t, "/quote/", got.Trace.Request.Headers[0].Value)
+	assert.Equal(t, capture.Redacted, got.Trace.Request.Headers[1].Value)
+	assert.Equal(t, capture.Redacted, got.Trace.Request.Headers[2].Value)
+	assert.Equal(t, "aGVsbA==", got.Trace.Request.Body.AsBytes) // "hell"
+	assert.True(t, got.Trace.Request.Body.Truncated)
+	assert.Equal(t, "200", got.Trace.Response.Headers[0].Value)
+	assert.Equal(t, "b2s=", got.Trace.Response.Body.AsBytes) // "ok"
+	assert.False(t, got.Trace.Response.Body.Truncated)
+
+	// Requests that aren't sampled, or aren't for a target, tell Envoy to skip the rest.
+	for _, mapping := range []string{"never.default", "other.default"} {
+		resps = exchange(t, client, mapping,
+			&v3extproc.ProcessingRequest{Request: &v3extproc.ProcessingRequest_RequestHeaders{RequestHeaders: &v3extproc.HttpHeaders{
+				Headers: headers(":path", "/"), EndOfStream: true,
+			}}},
+		)
+		require.NotNil(t, resps[0].ModeOverride)
+		assert.Equal(t, v3extprocfilter.ProcessingMode_SKIP, resps[0].ModeOverride.ResponseHeaderMode)
+		assert.Equal(t, v3extprocfilter.ProcessingMode_NONE, resps[0].ModeOverride.ResponseBodyMode)
+	}
+
+	require.Eventually(t, func() bool { return mirror.Stats().Sent == 1 }, 10*time.Second, 10*time.Millisecond)
+	assert.Equal(t, capture.MirrorStats{Seen: 3, Sampled: 1, Sent: 1}, mirror.Stats())
+	assert.Empty(t, received)
+}
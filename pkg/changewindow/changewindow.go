@@ -0,0 +1,233 @@
+// Package changewindow parses and evaluates schedules of maintenance windows, for deployments whose
+// change-management rules only allow configuration changes at certain times.
+//
+// A schedule is a semicolon-separated list of windows. Each window is a cron-style start time (the
+// usual five fields: minute, hour, day of month, month, day of week) followed by how long the window
+// stays open, as a Go duration. For example,
+//
+//	0 2 * * 6 4h; 30 22 * * 1-5 90m
+//
+// is open from 02:00 to 06:00 on Saturdays, and from 22:30 to midnight on weekdays. Fields take
+// numbers, "*", ranges ("1-5"), lists ("1,3,5") and steps ("*/15", "0-30/10"). Day of week is 0
+// (Sunday) through 6, and 7 is Sunday too. As in cron, if both the day of month and the day of week
+// are restricted, a day that matches either one counts.
+package changewindow
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+
+	// Schedules are usually in some local time zone, and the image might not have zoneinfo.
+	_ "time/tzdata"
+)
+
+// MaxDuration is how long a single window can stay open.
+const MaxDuration = 7 * 24 * time.Hour
+
+// How far ahead NextOpen looks before giving up.
+const searchHorizon = 366 * 24 * time.Hour
+
+// A Schedule is a set of maintenance windows in a particular time zone.
+type Schedule struct {
+	spec     string
+	location *time.Location
+	windows  []window
+}
+
+type window struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+	duration                      time.Duration
+}
+
+type field struct {
+	name     string
+	min, max int
+}
+
+var fields = [5]field{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// Parse parses a schedule whose times are in the given location.
+func Parse(spec string, location *time.Location) (*Schedule, error) {
+	s := &Schedule{spec: spec, location: location}
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		w, err := parseWindow(entry)
+		if err != nil {
+			return nil, fmt.Errorf("change window %q: %w", entry, err)
+		}
+		s.windows = append(s.windows, w)
+	}
+	if len(s.windows) == 0 {
+		return nil, fmt.Errorf("no change windows in %q", spec)
+	}
+	return s, nil
+}
+
+func parseWindow(entry string) (window, error) {
+	var w window
+	parts := strings.Fields(entry)
+	if len(parts) != 6 {
+		return w, fmt.Errorf("want 5 cron fields and a duration, got %d fields", len(parts))
+	}
+
+	var sets [5]uint64
+	for i, f := range fields {
+		set, err := parseField(parts[i], f)
+		if err != nil {
+			return w, err
+		}
+		sets[i] = set
+	}
+	w.minute, w.hour, w.dom, w.month, w.dow = sets[0], sets[1], sets[2], sets[3], sets[4]
+	// 7 is Sunday too.
+	if w.dow&(1<<7) != 0 {
+		w.dow |= 1
+	}
+	w.domStar = parts[2] == "*"
+	w.dowStar = parts[4] == "*"
+
+	duration, err := time.ParseDuration(parts[5])
+	if err != nil {
+		return w, err
+	}
+	if duration < time.Minute || duration > MaxDuration {
+		return w, fmt.Errorf("duration %s is not between 1m and %s", duration, MaxDuration)
+	}
+	w.duration = duration
+	return w, nil
+}
+
+// parseField parses one cron field into a bitset of the values it matches.
+func parseField(text string, f field) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(text, ",") {
+		rng, stepText, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepText)
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("%s: bad step %q", f.name, stepText)
+			}
+		}
+
+		lo, hi := f.min, f.max
+		if rng != "*" {
+			loText, hiText, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(loText); err != nil {
+				return 0, fmt.Errorf("%s: bad value %q", f.name, loText)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(hiText); err != nil {
+					return 0, fmt.Errorf("%s: bad value %q", f.name, hiText)
+				}
+			} else if hasStep {
+				hi = f.max
+			}
+		}
+		if lo < f.min || hi > f.max || lo > hi {
+			return 0, fmt.Errorf("%s: %q is not within %d-%d", f.name, rng, f.min, f.max)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// String returns the spec that the schedule was parsed from.
+func (s *Schedule) String() string {
+	return s.spec
+}
+
+// starts returns whether the window opens at the given time, which must be in the schedule's
+// location.
+func (w window) starts(t time.Time) bool {
+	if w.minute&(1<<t.Minute()) == 0 || w.hour&(1<<t.Hour()) == 0 || w.month&(1<<int(t.Month())) == 0 {
+		return false
+	}
+	return w.matchesDay(t)
+}
+
+func (w window) matchesDay(t time.Time) bool {
+	domMatch := w.dom&(1<<t.Day()) != 0
+	dowMatch := w.dow&(1<<int(t.Weekday())) != 0
+	if w.domStar || w.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Contains returns whether some window is open at t.
+func (s *Schedule) Contains(t time.Time) bool {
+	t = t.In(s.location)
+	start := t.Truncate(time.Minute)
+	for _, w := range s.windows {
+		// The window is open if it started less than its duration ago.
+		for at := start; t.Sub(at) < w.duration; at = at.Add(-time.Minute) {
+			if w.starts(at) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NextOpen returns the first time after t that a window opens, or the zero Time if none does in
+// the next year.
+func (s *Schedule) NextOpen(t time.Time) time.Time {
+	var next time.Time
+	for _, w := range s.windows {
+		if at := w.next(t.In(s.location)); !at.IsZero() && (next.IsZero() || at.Before(next)) {
+			next = at
+		}
+	}
+	return next
+}
+
+func (w window) next(t time.Time) time.Time {
+	end := t.Add(searchHorizon)
+	at := t.Truncate(time.Minute).Add(time.Minute)
+	for at.Before(end) {
+		switch {
+		case w.month&(1<<int(at.Month())) == 0:
+			at = time.Date(at.Year(), at.Month()+1, 1, 0, 0, 0, 0, at.Location())
+		case !w.matchesDay(at):
+			at = time.Date(at.Year(), at.Month(), at.Day()+1, 0, 0, 0, 0, at.Location())
+		case w.hour&(1<<at.Hour()) == 0:
+			at = nextHour(at)
+		case w.minute&(1<<at.Minute()) == 0:
+			// Skip straight to the next minute that matches, if there's one left in this hour.
+			rest := w.minute >> at.Minute()
+			if rest == 0 {
+				at = nextHour(at)
+			} else {
+				at = at.Add(time.Duration(bits.TrailingZeros64(rest)) * time.Minute)
+			}
+		default:
+			return at
+		}
+	}
+	return time.Time{}
+}
+
+// nextHour returns the start of the hour after t's, in t's location. (time.Truncate works in UTC,
+// which isn't the same thing in places with a half-hour offset.)
+func nextHour(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,87 @@
+package changewindow_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/changewindow"
+)
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		";",
+		"0 2 * * 6",
+		"0 2 * * 6 4h extra",
+		"60 2 * * 6 4h",
+		"0 24 * * 6 4h",
+		"0 2 0 * 6 4h",
+		"0 2 * 13 6 4h",
+		"0 2 * * 8 4h",
+		"0 5-2 * * * 1h",
+		"*/0 * * * * 1h",
+		"0 2 * * sat 4h",
+		"0 2 * * 6 forever",
+		"0 2 * * 6 30s",
+		"0 2 * * 6 200h",
+	} {
+		_, err := changewindow.Parse(spec, time.UTC)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestContains(t *testing.T) {
+	s, err := changewindow.Parse("0 2 * * 6 4h; 30 22 * * 1-5 90m", time.UTC)
+	require.NoError(t, err)
+
+	// 2026-10-17 is a Saturday.
+	for at, open := range map[string]bool{
+		"2026-10-17T01:59:00Z": false,
+		"2026-10-17T02:00:00Z": true,
+		"2026-10-17T05:59:59Z": true,
+		"2026-10-17T06:00:00Z": false,
+		"2026-10-16T22:29:00Z": false,
+		"2026-10-16T22:30:00Z": true,
+		"2026-10-16T23:59:00Z": true,
+		"2026-10-17T00:00:00Z": false,
+		"2026-10-18T22:45:00Z": false, // Sunday
+	} {
+		tm, err := time.Parse(time.RFC3339, at)
+		require.NoError(t, err)
+		assert.Equal(t, open, s.Contains(tm), at)
+	}
+}
+
+func TestNextOpen(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	s, err := changewindow.Parse("0 2 * * 6 4h; */20 9-10 1,15 * 2 30m", berlin)
+	require.NoError(t, err)
+
+	for from, want := range map[string]string{
+		// The Saturday window, in Berlin time.
+		"2026-10-16T12:00:00Z": "2026-10-17T02:00:00+02:00",
+		// The day-of-month/day-of-week window: Tuesday the 20th counts, even though it's not the
+		// 1st or the 15th.
+		"2026-10-19T12:00:00Z": "2026-10-20T09:00:00+02:00",
+		"2026-10-20T07:05:00Z": "2026-10-20T09:20:00+02:00",
+		"2026-10-20T08:30:00Z": "2026-10-20T10:40:00+02:00",
+		"2026-10-20T09:00:00Z": "2026-10-24T02:00:00+02:00",
+		// Opening at exactly the time given doesn't count.
+		"2026-10-17T00:00:00Z": "2026-10-20T09:00:00+02:00",
+	} {
+		tm, err := time.Parse(time.RFC3339, from)
+		require.NoError(t, err)
+		next := s.NextOpen(tm)
+		assert.Equal(t, want, next.Format(time.RFC3339), from)
+		assert.True(t, s.Contains(next), from)
+	}
+
+	// February 30th never comes.
+	s, err = changewindow.Parse("0 0 30 2 * 1h", time.UTC)
+	require.NoError(t, err)
+	assert.True(t, s.NextOpen(time.Now()).IsZero())
+}
@@ -0,0 +1,138 @@
+// Package clock is the time that timing-dependent code runs on: grace periods, debouncing, drain
+// and rate limiting, backoff, and schedules. Code that takes a Clock (or gets one with FromContext)
+// instead of calling time.Now and time.NewTimer can be tested with a Fake, whose time only moves
+// when the test moves it, instead of with real sleeps.
+//
+// # Clock jumps
+//
+// Go's time.Now carries a monotonic reading along with the wall clock, and durations between two
+// such times (and timers) use the monotonic one, so NTP corrections don't stretch or shrink grace
+// periods, debounce delays, or backoff. That's what those want.
+//
+// Schedules are different: a change window opens at 02:00 by the wall clock, however long the
+// machine was suspended before then, and however far NTP moved the clock. A timer for the time
+// until then runs on the monotonic clock, which doesn't move while a VM is suspended, and doesn't
+// notice the wall clock being set. So anything waiting for a wall-clock deadline waits for Until,
+// which never waits more than MaxWait, and then checks the wall clock again.
+package clock
+
+import (
+	"context"
+	"time"
+)
+
+// The Clock interface tells the time, and makes timers.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// NewTimer returns a Timer that sends the time on its channel once d has passed.
+	NewTimer(d time.Duration) Timer
+	// NewTicker returns a Ticker that sends the time on its channel every d.
+	NewTicker(d time.Duration) Ticker
+	// AfterFunc calls f in its own goroutine once d has passed. The Timer's channel is nil.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// The Timer interface is a time.Timer from a Clock.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// The Ticker interface is a time.Ticker from a Clock.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the real clock.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return realTimer{time.AfterFunc(d, f)}
+}
+
+// NowFunc is a Clock that tells the time with the function, and makes real timers. It's for code
+// that has only ever needed to tell the time, and used to take a func() time.Time to do it with.
+type NowFunc func() time.Time
+
+func (f NowFunc) Now() time.Time {
+	return f()
+}
+
+func (f NowFunc) NewTimer(d time.Duration) Timer {
+	return Real.NewTimer(d)
+}
+
+func (f NowFunc) NewTicker(d time.Duration) Ticker {
+	return Real.NewTicker(d)
+}
+
+func (f NowFunc) AfterFunc(d time.Duration, fn func()) Timer {
+	return Real.AfterFunc(d, fn)
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// MaxWait is the longest that Until ever says to wait, so that something waiting for a wall-clock
+// deadline notices a clock jump within MaxWait.
+const MaxWait = time.Minute
+
+// Until returns how long to wait for a wall-clock deadline: the time until then by the wall clock,
+// but no more than MaxWait, and no less than zero. Whatever waits for it has to check the deadline
+// again when it wakes up.
+func Until(c Clock, deadline time.Time) time.Duration {
+	// Round(0) drops the monotonic readings, so that Sub uses the wall clock.
+	d := deadline.Round(0).Sub(c.Now().Round(0))
+	if d > MaxWait {
+		return MaxWait
+	}
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+type clockKey struct{}
+
+// WithClock returns a context that FromContext gets c from.
+func WithClock(ctx context.Context, c Clock) context.Context {
+	return context.WithValue(ctx, clockKey{}, c)
+}
+
+// FromContext returns the Clock of the context, or Real if it doesn't have one.
+func FromContext(ctx context.Context) Clock {
+	if c, ok := ctx.Value(clockKey{}).(Clock); ok {
+		return c
+	}
+	return Real
+}
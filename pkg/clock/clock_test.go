@@ -0,0 +1,101 @@
+package clock_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+)
+
+var start = time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+func fired(c <-chan time.Time) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestFakeTimer(t *testing.T) {
+	fake := clock.NewFake(start)
+	timer := fake.NewTimer(time.Minute)
+	assert.Equal(t, 1, fake.Timers())
+
+	fake.Advance(59 * time.Second)
+	assert.False(t, fired(timer.C()))
+	fake.Advance(time.Second)
+	assert.Equal(t, start.Add(time.Minute), <-timer.C())
+	assert.Equal(t, 0, fake.Timers())
+	assert.False(t, timer.Stop())
+
+	assert.False(t, timer.Reset(time.Minute))
+	assert.True(t, timer.Stop())
+	fake.Advance(time.Hour)
+	assert.False(t, fired(timer.C()))
+}
+
+func TestFakeTicker(t *testing.T) {
+	fake := clock.NewFake(start)
+	ticker := fake.NewTicker(10 * time.Second)
+
+	fake.Advance(10 * time.Second)
+	assert.True(t, fired(ticker.C()))
+	// Ticks that nobody is there for are dropped.
+	fake.Advance(25 * time.Second)
+	assert.True(t, fired(ticker.C()))
+	assert.False(t, fired(ticker.C()))
+	fake.Advance(5 * time.Second)
+	assert.True(t, fired(ticker.C()))
+
+	ticker.Stop()
+	assert.Equal(t, 0, fake.Timers())
+}
+
+func TestFakeAfterFunc(t *testing.T) {
+	fake := clock.NewFake(start)
+	done := make(chan struct{})
+	timer := fake.AfterFunc(time.Second, func() { close(done) })
+	assert.Nil(t, timer.C())
+
+	fake.Advance(time.Second)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("AfterFunc didn't call the func")
+	}
+}
+
+func TestFakeJump(t *testing.T) {
+	fake := clock.NewFake(start)
+	timer := fake.NewTimer(time.Minute)
+
+	// Timers only count the time that passes.
+	fake.Jump(start.Add(time.Hour))
+	assert.False(t, fired(timer.C()))
+	fake.Jump(start.Add(-time.Hour))
+	fake.Advance(30 * time.Second)
+	assert.False(t, fired(timer.C()))
+	fake.Advance(30 * time.Second)
+	assert.True(t, fired(timer.C()))
+	assert.Equal(t, start.Add(-time.Hour+time.Minute), fake.Now())
+}
+
+func TestUntil(t *testing.T) {
+	fake := clock.NewFake(start)
+	assert.Equal(t, 30*time.Second, clock.Until(fake, start.Add(30*time.Second)))
+	assert.Equal(t, clock.MaxWait, clock.Until(fake, start.Add(time.Hour)))
+	assert.Equal(t, time.Duration(0), clock.Until(fake, start.Add(-time.Hour)))
+}
+
+func TestFromContext(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, clock.Real, clock.FromContext(ctx))
+
+	fake := clock.NewFake(start)
+	assert.Equal(t, clock.Clock(fake), clock.FromContext(clock.WithClock(ctx, fake)))
+}
@@ -0,0 +1,167 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// The Fake struct is a Clock for tests. Its time only moves when Advance or Jump moves it. Advance
+// passes time, and fires the timers and tickers whose time has come, in order. Jump just sets the
+// wall clock, forward or backward, and timers, like real ones, don't notice.
+//
+// A test that moves the clock usually has to wait for the code under test to have started a timer
+// first: see Timers.
+type Fake struct {
+	mutex  sync.Mutex // protects the whole struct
+	now    time.Time
+	timers []*fakeTimer // the active ones
+}
+
+// The NewFake function returns a Fake that starts at now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+type fakeTimer struct {
+	fake    *Fake
+	elapsed time.Duration // how much time has passed for this timer
+	at      time.Duration // when it fires, by elapsed
+	period  time.Duration // for tickers, how often it fires; zero for timers
+	c       chan time.Time
+	f       func()
+}
+
+func (f *Fake) Now() time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.now
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	return f.add(&fakeTimer{at: d, c: make(chan time.Time, 1)})
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for clock.Fake.NewTicker")
+	}
+	return fakeTicker{f.add(&fakeTimer{at: d, period: d, c: make(chan time.Time, 1)})}
+}
+
+func (f *Fake) AfterFunc(d time.Duration, fn func()) Timer {
+	return f.add(&fakeTimer{at: d, f: fn})
+}
+
+func (f *Fake) add(t *fakeTimer) *fakeTimer {
+	t.fake = f
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.timers = append(f.timers, t)
+	f.fire(0)
+	return t
+}
+
+// Timers returns how many timers and tickers are active, i.e. made and neither stopped nor (for
+// timers) fired yet.
+func (f *Fake) Timers() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return len(f.timers)
+}
+
+// Advance moves the clock forward by d.
+func (f *Fake) Advance(d time.Duration) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.now = f.now.Add(d)
+	f.fire(d)
+}
+
+// Jump sets the clock to now without passing any time for timers, the way the wall clock jumps
+// when NTP corrects it, or when a suspended VM wakes up.
+func (f *Fake) Jump(now time.Time) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.now = now
+}
+
+// fire passes d for every timer, and fires whatever's due, earliest first. It must be called with
+// the mutex held.
+func (f *Fake) fire(d time.Duration) {
+	for _, t := range f.timers {
+		t.elapsed += d
+	}
+	sort.SliceStable(f.timers, func(i, j int) bool {
+		return f.timers[i].at-f.timers[i].elapsed < f.timers[j].at-f.timers[j].elapsed
+	})
+	active := f.timers[:0]
+	for _, t := range f.timers {
+		if t.elapsed < t.at {
+			active = append(active, t)
+			continue
+		}
+		switch {
+		case t.f != nil:
+			go t.f()
+		default:
+			// Like a real ticker, drop ticks that nobody's there for.
+			select {
+			case t.c <- f.now:
+			default:
+			}
+		}
+		if t.period > 0 {
+			for t.at <= t.elapsed {
+				t.at += t.period
+			}
+			active = append(active, t)
+		}
+	}
+	f.timers = active
+}
+
+// remove stops t, and returns whether it was active.
+func (f *Fake) remove(t *fakeTimer) bool {
+	for i, other := range f.timers {
+		if other == t {
+			f.timers = append(f.timers[:i], f.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	if t.c == nil {
+		return nil
+	}
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.fake.mutex.Lock()
+	defer t.fake.mutex.Unlock()
+	return t.fake.remove(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.fake.mutex.Lock()
+	defer t.fake.mutex.Unlock()
+	active := t.fake.remove(t)
+	t.elapsed, t.at = 0, d
+	if t.period > 0 {
+		t.period = d
+	}
+	t.fake.timers = append(t.fake.timers, t)
+	t.fake.fire(0)
+	return active
+}
+
+type fakeTicker struct {
+	*fakeTimer
+}
+
+func (t fakeTicker) Stop() {
+	t.fakeTimer.Stop()
+}
@@ -0,0 +1,341 @@
+// Package compilepool schedules config compiles for a gateway shared by several tenants: several
+// ambassador_ids, or namespaces, each with its own ControlPlane (see cmd/entrypoint/controlplane.go)
+// compiling its own snapshots. Left to themselves, they'd all compile at once whenever a change
+// touches all of them, and a tenant with an enormous config would hold up everyone else's
+// reconfiguration for as long as its compiles take.
+//
+// A Pool runs only so many compiles at a time, and no more than one per tenant. When a slot opens
+// up, it goes to whichever waiting tenant has used the least compile time, the way an operating
+// system's fair scheduler hands out the CPU, so a tenant whose compiles are slow, or that compiles
+// constantly, waits behind the ones that don't. A tenant that comes back after being idle starts
+// level with the others that are compiling rather than ahead of them, so it can't make up for
+// lost time by taking over the pool.
+//
+// A compile that has run for longer than the Pool's time slice, while other tenants are waiting,
+// is preempted: its Context is canceled, and once it returns, it waits its turn to run again from
+// the start. It only gets preempted once, so however big a config is, it gets compiled eventually.
+// Preemption only helps compiles that stop when their Context is canceled; one that runs to the
+// end anyway keeps its slot until it does.
+package compilepool
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+)
+
+// Config is how a Pool is set up. Anything left zero gets its default.
+type Config struct {
+	// Workers is how many compiles run at once. The default is 1.
+	Workers int
+	// Slice is how long a compile runs before other tenants can preempt it. Zero (the default)
+	// never preempts anything.
+	Slice time.Duration
+	// Clock is what compiles are timed with. The default is clock.Real.
+	Clock clock.Clock
+}
+
+// The Pool struct schedules compiles. Its zero value isn't usable; use New.
+type Pool struct {
+	workers int
+	slice   time.Duration
+	clock   clock.Clock
+
+	// The mutex protects everything below.
+	mutex   sync.Mutex
+	seq     uint64
+	queue   []*job
+	active  map[*job]struct{}
+	tenants map[string]*tenant
+}
+
+type tenant struct {
+	// usage is how much compile time the tenant has used, for deciding whose turn it is.
+	usage time.Duration
+	busy  bool
+	stats TenantStats
+}
+
+// Where a job is.
+const (
+	jobQueued = iota
+	jobRunning
+	jobDone
+)
+
+type job struct {
+	tenant      *tenant
+	ctx         context.Context
+	seq         uint64
+	state       int
+	preemptible bool
+	preempted   bool
+	queuedAt    time.Time
+	startedAt   time.Time
+	ready       chan struct{} // closed when the job gets a slot
+	runCtx      context.Context
+	cancel      context.CancelFunc
+	timer       clock.Timer
+}
+
+// TenantStats are what a Pool has done for one tenant.
+type TenantStats struct {
+	Tenant string `json:"tenant"`
+	// Compiles is how many compiles have finished, and Preemptions how many were cut short (and
+	// run again).
+	Compiles    uint64 `json:"compiles"`
+	Preemptions uint64 `json:"preemptions"`
+	// CompileTime is how long the tenant's compiles have run, preempted ones included, and
+	// WaitTime how long they waited for a slot.
+	CompileTime time.Duration `json:"compile_time"`
+	WaitTime    time.Duration `json:"wait_time"`
+	// LastWait is how long the tenant's latest compile waited for a slot.
+	LastWait time.Duration `json:"last_wait"`
+}
+
+// New returns a Pool with nothing running.
+func New(config Config) *Pool {
+	if config.Workers < 1 {
+		config.Workers = 1
+	}
+	if config.Clock == nil {
+		config.Clock = clock.Real
+	}
+	return &Pool{
+		workers: config.Workers,
+		slice:   config.Slice,
+		clock:   config.Clock,
+		active:  map[*job]struct{}{},
+		tenants: map[string]*tenant{},
+	}
+}
+
+// Do runs compile for the tenant once it's the tenant's turn, and returns what compile returns. If ctx
+// is canceled before then, Do returns ctx.Err() without running compile at all. If compile is
+// preempted, whatever it returns is thrown away, and it's called again when its turn comes back
+// around.
+func (p *Pool) Do(ctx context.Context, tenantName string, compile func(context.Context) error) error {
+	p.mutex.Lock()
+	t, ok := p.tenants[tenantName]
+	if !ok {
+		t = &tenant{stats: TenantStats{Tenant: tenantName}}
+		p.tenants[tenantName] = t
+	}
+	j := &job{tenant: t, ctx: ctx, preemptible: p.slice > 0}
+	p.enqueue(j)
+	p.mutex.Unlock()
+
+	for {
+		select {
+		case <-j.ready:
+		case <-ctx.Done():
+			p.mutex.Lock()
+			if j.state == jobQueued {
+				p.dequeue(j)
+				p.mutex.Unlock()
+				return ctx.Err()
+			}
+			p.mutex.Unlock()
+			// It got a slot anyway; compile will see that ctx is done.
+		}
+
+		err := compile(j.runCtx)
+
+		p.mutex.Lock()
+		preempted := j.preempted && ctx.Err() == nil
+		p.finish(j, preempted)
+		p.mutex.Unlock()
+		if !preempted {
+			return err
+		}
+	}
+}
+
+// Stats returns what the Pool has done for each tenant it's seen, by tenant.
+func (p *Pool) Stats() []TenantStats {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	ret := make([]TenantStats, 0, len(p.tenants))
+	for _, t := range p.tenants {
+		ret = append(ret, t.stats)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Tenant < ret[j].Tenant })
+	return ret
+}
+
+// enqueue puts j in line, and starts whatever can start. It must be called with the mutex held.
+func (p *Pool) enqueue(j *job) {
+	// A tenant that's been idle catches up with the others, so that it doesn't get a run of
+	// turns for all the time that it wasn't compiling.
+	if !j.tenant.busy && !p.waiting(j.tenant) {
+		if floor, ok := p.minActiveUsage(); ok && j.tenant.usage < floor {
+			j.tenant.usage = floor
+		}
+	}
+
+	p.seq++
+	j.seq = p.seq
+	j.state = jobQueued
+	j.queuedAt = p.clock.Now()
+	j.ready = make(chan struct{})
+	p.queue = append(p.queue, j)
+
+	p.dispatch()
+	if j.state == jobQueued {
+		p.preemptOverdue()
+	}
+}
+
+// dequeue takes j out of line. It must be called with the mutex held.
+func (p *Pool) dequeue(j *job) {
+	for i, other := range p.queue {
+		if other == j {
+			p.queue = append(p.queue[:i], p.queue[i+1:]...)
+			break
+		}
+	}
+	j.state = jobDone
+}
+
+// finish frees j's slot, puts it back in line if it was preempted, and starts whatever can start.
+// It must be called with the mutex held.
+func (p *Pool) finish(j *job, preempted bool) {
+	if j.timer != nil {
+		j.timer.Stop()
+	}
+	j.cancel()
+
+	elapsed := p.clock.Now().Sub(j.startedAt)
+	t := j.tenant
+	t.usage += elapsed
+	t.busy = false
+	t.stats.CompileTime += elapsed
+	delete(p.active, j)
+
+	if preempted {
+		t.stats.Preemptions++
+		j.preemptible = false
+		j.preempted = false
+		p.enqueue(j)
+		return
+	}
+	t.stats.Compiles++
+	j.state = jobDone
+	p.dispatch()
+}
+
+// dispatch starts the jobs whose turn it is, for as long as there are free slots. It must be
+// called with the mutex held.
+func (p *Pool) dispatch() {
+	for len(p.active) < p.workers {
+		best := -1
+		for i, j := range p.queue {
+			if j.tenant.busy {
+				continue
+			}
+			if best < 0 || j.tenant.usage < p.queue[best].tenant.usage ||
+				(j.tenant.usage == p.queue[best].tenant.usage && j.seq < p.queue[best].seq) {
+				best = i
+			}
+		}
+		if best < 0 {
+			return
+		}
+		j := p.queue[best]
+		p.queue = append(p.queue[:best], p.queue[best+1:]...)
+		p.start(j)
+	}
+}
+
+// start gives j a slot. It must be called with the mutex held.
+func (p *Pool) start(j *job) {
+	now := p.clock.Now()
+	wait := now.Sub(j.queuedAt)
+	j.tenant.stats.WaitTime += wait
+	j.tenant.stats.LastWait = wait
+	j.tenant.busy = true
+	p.active[j] = struct{}{}
+
+	j.state = jobRunning
+	j.startedAt = now
+	j.runCtx, j.cancel = context.WithCancel(j.ctx)
+	j.timer = nil
+	if j.preemptible {
+		j.timer = p.clock.AfterFunc(p.slice, func() {
+			p.mutex.Lock()
+			defer p.mutex.Unlock()
+			if j.state == jobRunning && j.preemptible && !j.preempted && p.blocked(j.tenant) {
+				p.preempt(j)
+			}
+		})
+	}
+	close(j.ready)
+}
+
+// preemptOverdue preempts the running job that has been running longest past its time slice, if
+// any has. It must be called with the mutex held.
+func (p *Pool) preemptOverdue() {
+	if !p.blocked(nil) {
+		return
+	}
+	now := p.clock.Now()
+	var victim *job
+	for j := range p.active {
+		if !j.preemptible || j.preempted || now.Sub(j.startedAt) < p.slice {
+			continue
+		}
+		if victim == nil || j.startedAt.Before(victim.startedAt) {
+			victim = j
+		}
+	}
+	if victim != nil {
+		p.preempt(victim)
+	}
+}
+
+// preempt cuts j short. Do puts it back in line once compile returns. It must be called with the
+// mutex held.
+func (p *Pool) preempt(j *job) {
+	j.preempted = true
+	j.cancel()
+}
+
+// blocked returns whether some tenant other than except is waiting for a slot that it could use.
+// It must be called with the mutex held.
+func (p *Pool) blocked(except *tenant) bool {
+	for _, j := range p.queue {
+		if j.tenant != except && !j.tenant.busy {
+			return true
+		}
+	}
+	return false
+}
+
+// waiting returns whether t has a job in line. It must be called with the mutex held.
+func (p *Pool) waiting(t *tenant) bool {
+	for _, j := range p.queue {
+		if j.tenant == t {
+			return true
+		}
+	}
+	return false
+}
+
+// minActiveUsage returns the least usage of the tenants that are compiling or waiting to, and
+// false if none are. It must be called with the mutex held.
+func (p *Pool) minActiveUsage() (time.Duration, bool) {
+	var floor time.Duration
+	found := false
+	for _, t := range p.tenants {
+		if !t.busy && !p.waiting(t) {
+			continue
+		}
+		if !found || t.usage < floor {
+			floor, found = t.usage, true
+		}
+	}
+	return floor, found
+}
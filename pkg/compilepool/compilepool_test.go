@@ -0,0 +1,221 @@
+package compilepool
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+)
+
+var start = time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+// compile is a compile that a test runs with Do, and controls from the outside.
+type compile struct {
+	started chan struct{} // gets a value every time the compile starts
+	release chan struct{} // closed to let the compile finish
+	done    chan error    // gets what Do returns
+	stop    bool          // whether the compile stops when its Context is canceled
+}
+
+func (c *compile) run(ctx context.Context) error {
+	c.started <- struct{}{}
+	if c.stop {
+		select {
+		case <-c.release:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	<-c.release
+	return nil
+}
+
+func doCompile(ctx context.Context, p *Pool, tenant string, stop bool) *compile {
+	c := &compile{
+		started: make(chan struct{}, 10),
+		release: make(chan struct{}),
+		done:    make(chan error, 1),
+		stop:    stop,
+	}
+	go func() { c.done <- p.Do(ctx, tenant, c.run) }()
+	return c
+}
+
+func (p *Pool) queued() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return len(p.queue)
+}
+
+func waitQueued(t *testing.T, p *Pool, n int) {
+	t.Helper()
+	require.Eventually(t, func() bool { return p.queued() == n }, time.Second, time.Millisecond)
+}
+
+// started returns whether c starts within a second.
+func started(c *compile) bool {
+	select {
+	case <-c.started:
+		return true
+	case <-time.After(time.Second):
+		return false
+	}
+}
+
+// notStarted returns whether c still hasn't started after a moment.
+func notStarted(c *compile) bool {
+	select {
+	case <-c.started:
+		return false
+	case <-time.After(10 * time.Millisecond):
+		return true
+	}
+}
+
+func TestFairness(t *testing.T) {
+	ctx := context.Background()
+	fake := clock.NewFake(start)
+	p := New(Config{Workers: 1, Clock: fake})
+
+	// The big tenant compiles for a minute.
+	big := doCompile(ctx, p, "big", false)
+	<-big.started
+	fake.Advance(time.Minute)
+	close(big.release)
+	require.NoError(t, <-big.done)
+
+	// While someone else compiles, the big tenant and a new one both get in line, the big one
+	// first...
+	other := doCompile(ctx, p, "other", false)
+	<-other.started
+	big = doCompile(ctx, p, "big", false)
+	waitQueued(t, p, 1)
+	small := doCompile(ctx, p, "small", false)
+	waitQueued(t, p, 2)
+
+	// ...but the new one has used less compile time, so it goes first.
+	fake.Advance(time.Second)
+	close(other.release)
+	require.NoError(t, <-other.done)
+	assert.True(t, started(small))
+	assert.True(t, notStarted(big))
+
+	fake.Advance(time.Second)
+	close(small.release)
+	require.NoError(t, <-small.done)
+	assert.True(t, started(big))
+	close(big.release)
+	require.NoError(t, <-big.done)
+
+	assert.Equal(t, []TenantStats{
+		{Tenant: "big", Compiles: 2, CompileTime: time.Minute, WaitTime: 2 * time.Second, LastWait: 2 * time.Second},
+		{Tenant: "other", Compiles: 1, CompileTime: time.Second},
+		{Tenant: "small", Compiles: 1, CompileTime: time.Second, WaitTime: time.Second, LastWait: time.Second},
+	}, p.Stats())
+}
+
+func TestOneCompilePerTenant(t *testing.T) {
+	ctx := context.Background()
+	p := New(Config{Workers: 2, Clock: clock.NewFake(start)})
+
+	first := doCompile(ctx, p, "a", false)
+	<-first.started
+	second := doCompile(ctx, p, "a", false)
+	waitQueued(t, p, 1)
+
+	// There's a free slot, but the tenant is already using the other one.
+	assert.True(t, notStarted(second))
+	other := doCompile(ctx, p, "b", false)
+	assert.True(t, started(other))
+
+	close(first.release)
+	require.NoError(t, <-first.done)
+	assert.True(t, started(second))
+	close(second.release)
+	close(other.release)
+	require.NoError(t, <-second.done)
+	require.NoError(t, <-other.done)
+}
+
+func TestPreemption(t *testing.T) {
+	ctx := context.Background()
+	fake := clock.NewFake(start)
+	p := New(Config{Workers: 1, Slice: 5 * time.Second, Clock: fake})
+
+	// Nobody else is waiting, so the big compile can run past its slice...
+	big := doCompile(ctx, p, "big", true)
+	<-big.started
+	fake.Advance(10 * time.Second)
+
+	// ...until someone is. Then it's preempted, and waits its turn to start over.
+	small := doCompile(ctx, p, "small", false)
+	assert.True(t, started(small))
+	assert.True(t, notStarted(big))
+
+	// The second time around, the big compile can't be preempted.
+	close(small.release)
+	require.NoError(t, <-small.done)
+	assert.True(t, started(big))
+	other := doCompile(ctx, p, "other", false)
+	waitQueued(t, p, 1)
+	fake.Advance(time.Minute)
+	assert.True(t, notStarted(other))
+
+	close(big.release)
+	require.NoError(t, <-big.done)
+	assert.True(t, started(other))
+	close(other.release)
+	require.NoError(t, <-other.done)
+
+	stats := p.Stats()
+	require.Len(t, stats, 3)
+	assert.Equal(t, uint64(1), stats[0].Preemptions)
+	assert.Equal(t, uint64(1), stats[0].Compiles)
+	assert.Equal(t, 70*time.Second, stats[0].CompileTime)
+}
+
+func TestPreemptionAtSliceEnd(t *testing.T) {
+	ctx := context.Background()
+	fake := clock.NewFake(start)
+	p := New(Config{Workers: 1, Slice: 5 * time.Second, Clock: fake})
+
+	big := doCompile(ctx, p, "big", true)
+	<-big.started
+	small := doCompile(ctx, p, "small", false)
+	waitQueued(t, p, 1)
+
+	// Someone is waiting already, so the big compile is preempted as soon as its slice is up.
+	fake.Advance(4 * time.Second)
+	assert.True(t, notStarted(small))
+	fake.Advance(time.Second)
+	assert.True(t, started(small))
+
+	close(small.release)
+	require.NoError(t, <-small.done)
+	assert.True(t, started(big))
+	close(big.release)
+	require.NoError(t, <-big.done)
+}
+
+func TestCanceledWhileWaiting(t *testing.T) {
+	p := New(Config{Workers: 1, Clock: clock.NewFake(start)})
+
+	first := doCompile(context.Background(), p, "a", false)
+	<-first.started
+	ctx, cancel := context.WithCancel(context.Background())
+	second := doCompile(ctx, p, "b", false)
+	waitQueued(t, p, 1)
+
+	cancel()
+	assert.ErrorIs(t, <-second.done, context.Canceled)
+	assert.True(t, notStarted(second))
+	assert.Equal(t, 0, p.queued())
+
+	close(first.release)
+	require.NoError(t, <-first.done)
+}
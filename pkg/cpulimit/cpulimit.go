@@ -0,0 +1,180 @@
+// Package cpulimit finds out how much CPU the container is allowed to use. Everything that sizes
+// itself by the number of CPUs (the Go runtime, Envoy's worker threads, diagd's threads) sees every
+// CPU on the node, however few of them the pod's CPU limit lets it use. A pod limited to one CPU on
+// a 64-CPU node that runs 64 Envoy workers and 64 Go threads spends its quota in bursts and then
+// gets throttled for the rest of every period, which shows up as latency.
+package cpulimit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultCgroupRoot is where the cgroup filesystem is mounted.
+const DefaultCgroupRoot = "/sys/fs/cgroup"
+
+// Read returns the CPU limit of the cgroup mounted at root, in CPUs (1.5 is a limit of 1500m), and
+// false if there's no limit, or no cgroup filesystem to read it from. It reads cgroup v2's cpu.max,
+// and failing that, cgroup v1's cpu.cfs_quota_us and cpu.cfs_period_us.
+func Read(root string) (float64, bool, error) {
+	limit, ok, err := readV2(filepath.Join(root, "cpu.max"))
+	if err == nil {
+		return limit, ok, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return 0, false, err
+	}
+
+	for _, dir := range []string{"cpu", "cpu,cpuacct"} {
+		limit, ok, err = readV1(filepath.Join(root, dir))
+		if err == nil {
+			return limit, ok, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return 0, false, err
+		}
+	}
+	return 0, false, nil
+}
+
+// Usage returns how much CPU time everything in the cgroup mounted at root has used, all told. It
+// reads cgroup v2's cpu.stat, and failing that, cgroup v1's cpuacct.usage. The error wraps
+// os.ErrNotExist if there's no cgroup filesystem to read it from.
+func Usage(root string) (time.Duration, error) {
+	usec, err := readStat(filepath.Join(root, "cpu.stat"), "usage_usec")
+	if err == nil {
+		return time.Duration(usec) * time.Microsecond, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+
+	for _, dir := range []string{"cpuacct", "cpu,cpuacct"} {
+		nsec, err := readInt(filepath.Join(root, dir, "cpuacct.usage"))
+		if err == nil {
+			return time.Duration(nsec), nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return 0, err
+		}
+	}
+	return 0, fmt.Errorf("%s: no CPU usage: %w", root, os.ErrNotExist)
+}
+
+// readV2 reads a cgroup v2 cpu.max, which is "<quota> <period>", or "max <period>".
+func readV2(path string) (float64, bool, error) {
+	line, err := readLine(path)
+	if err != nil {
+		return 0, false, err
+	}
+	fields := strings.Fields(line)
+	if len(fields) != 2 {
+		return 0, false, fmt.Errorf("%s: unexpected contents %q", path, line)
+	}
+	if fields[0] == "max" {
+		return 0, false, nil
+	}
+	quota, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s: %w", path, err)
+	}
+	period, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s: %w", path, err)
+	}
+	return ratio(quota, period)
+}
+
+// readV1 reads a cgroup v1 CPU controller's cpu.cfs_quota_us (which is -1 for no limit) and
+// cpu.cfs_period_us.
+func readV1(dir string) (float64, bool, error) {
+	quota, err := readInt(filepath.Join(dir, "cpu.cfs_quota_us"))
+	if err != nil {
+		return 0, false, err
+	}
+	if quota < 0 {
+		return 0, false, nil
+	}
+	period, err := readInt(filepath.Join(dir, "cpu.cfs_period_us"))
+	if err != nil {
+		return 0, false, err
+	}
+	return ratio(quota, period)
+}
+
+func ratio(quota, period int64) (float64, bool, error) {
+	if quota <= 0 || period <= 0 {
+		return 0, false, fmt.Errorf("nonsensical CPU quota %d for period %d", quota, period)
+	}
+	return float64(quota) / float64(period), true, nil
+}
+
+func readLine(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Scan()
+	return strings.TrimSpace(scanner.Text()), scanner.Err()
+}
+
+// readStat reads one "<key> <value>" line of a cgroup stat file like cpu.stat.
+func readStat(path, key string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || fields[0] != key {
+			continue
+		}
+		n, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %s: %w", path, key, err)
+		}
+		return n, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("%s: no %s", path, key)
+}
+
+func readInt(path string) (int64, error) {
+	line, err := readLine(path)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseInt(line, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	return n, nil
+}
+
+// Cores returns how many CPUs a limit of limit CPUs (if there is one) on a machine with numCPU of
+// them comes to: the limit, rounded up, but never more than numCPU, and never less than 1.
+func Cores(limit float64, hasLimit bool, numCPU int) int {
+	cores := numCPU
+	if hasLimit {
+		if c := int(math.Ceil(limit)); c < cores {
+			cores = c
+		}
+	}
+	if cores < 1 {
+		cores = 1
+	}
+	return cores
+}
@@ -0,0 +1,133 @@
+package cpulimit_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/cpulimit"
+)
+
+func cgroup(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, contents := range files {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(contents), 0o644))
+	}
+	return root
+}
+
+func TestRead(t *testing.T) {
+	t.Parallel()
+	type subtest struct {
+		files    map[string]string
+		limit    float64
+		hasLimit bool
+		err      bool
+	}
+	subtests := map[string]subtest{
+		"v2 limited": {
+			files: map[string]string{"cpu.max": "150000 100000\n"}, limit: 1.5, hasLimit: true,
+		},
+		"v2 unlimited": {
+			files: map[string]string{"cpu.max": "max 100000\n"},
+		},
+		"v2 garbage": {
+			files: map[string]string{"cpu.max": "lots\n"}, err: true,
+		},
+		"v1 limited": {
+			files: map[string]string{
+				"cpu/cpu.cfs_quota_us":  "50000\n",
+				"cpu/cpu.cfs_period_us": "100000\n",
+			},
+			limit: 0.5, hasLimit: true,
+		},
+		"v1 combined controller": {
+			files: map[string]string{
+				"cpu,cpuacct/cpu.cfs_quota_us":  "400000\n",
+				"cpu,cpuacct/cpu.cfs_period_us": "100000\n",
+			},
+			limit: 4, hasLimit: true,
+		},
+		"v1 unlimited": {
+			files: map[string]string{
+				"cpu/cpu.cfs_quota_us":  "-1\n",
+				"cpu/cpu.cfs_period_us": "100000\n",
+			},
+		},
+		"no cgroup": {},
+	}
+	for name, info := range subtests {
+		info := info // capture loop variable
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			limit, hasLimit, err := cpulimit.Read(cgroup(t, info.files))
+			if info.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, info.hasLimit, hasLimit)
+			assert.InDelta(t, info.limit, limit, 0.0001)
+		})
+	}
+}
+
+func TestCores(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, 2, cpulimit.Cores(1.5, true, 64))
+	assert.Equal(t, 1, cpulimit.Cores(0.25, true, 64))
+	assert.Equal(t, 8, cpulimit.Cores(16, true, 8))
+	assert.Equal(t, 64, cpulimit.Cores(0, false, 64))
+	assert.Equal(t, 1, cpulimit.Cores(0, false, 0))
+}
+
+func TestUsage(t *testing.T) {
+	t.Parallel()
+	type subtest struct {
+		files    map[string]string
+		usage    time.Duration
+		notExist bool
+		err      bool
+	}
+	subtests := map[string]subtest{
+		"v2": {
+			files: map[string]string{"cpu.stat": "usage_usec 1500000\nuser_usec 1000000\nsystem_usec 500000\n"},
+			usage: 1500 * time.Millisecond,
+		},
+		"v2 without usage": {
+			files: map[string]string{"cpu.stat": "user_usec 1000000\n"}, err: true,
+		},
+		"v1": {
+			files: map[string]string{"cpuacct/cpuacct.usage": "2500000000\n"},
+			usage: 2500 * time.Millisecond,
+		},
+		"v1 combined controller": {
+			files: map[string]string{"cpu,cpuacct/cpuacct.usage": "42\n"},
+			usage: 42,
+		},
+		"no cgroup": {notExist: true},
+	}
+	for name, info := range subtests {
+		info := info // capture loop variable
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			usage, err := cpulimit.Usage(cgroup(t, info.files))
+			switch {
+			case info.notExist:
+				assert.ErrorIs(t, err, os.ErrNotExist)
+			case info.err:
+				assert.Error(t, err)
+			default:
+				require.NoError(t, err)
+				assert.Equal(t, info.usage, usage)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"path"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -14,9 +15,11 @@ import (
 // that aggregate timing info for various kinds of actions, as well as atomic values that can be
 // updated as relevant state changes.
 type Debug struct {
-	mutex  sync.Mutex        // Protects the whole debug struct.
-	timers map[string]*Timer // Holds the debug timers.
-	values map[string]*Value // holds the debug values.
+	mutex     sync.Mutex                  // Protects the whole debug struct.
+	timers    map[string]*Timer           // Holds the debug timers.
+	values    map[string]*Value           // holds the debug values.
+	resources map[string]*ResourceTimings // holds the per-resource timings.
+	leaks     *LeakDetector               // tracks goroutines and watches.
 
 	clock ClockFunc // clock function to pass to all the timers
 }
@@ -57,7 +60,13 @@ func NewDebug() *Debug {
 
 // Create a new set of debug info with the specified clock function.
 func NewDebugWithClock(clock ClockFunc) *Debug {
-	return &Debug{clock: clock, timers: map[string]*Timer{}, values: map[string]*Value{}}
+	return &Debug{
+		clock:     clock,
+		timers:    map[string]*Timer{},
+		values:    map[string]*Value{},
+		resources: map[string]*ResourceTimings{},
+		leaks:     NewLeakDetectorWithClock(clock),
+	}
 }
 
 // Access the contexts of the debug info while holding the mutex.
@@ -93,12 +102,51 @@ func (d *Debug) Value(name string) (result *atomic.Value) {
 	return
 }
 
+// The ResourceTimings() method ensures the named ResourceTimings exists and returns it.
+func (d *Debug) ResourceTimings(name string) (result *ResourceTimings) {
+	d.withMutex(func() {
+		var ok bool
+		result, ok = d.resources[name]
+		if !ok {
+			result = NewResourceTimingsWithClock(d.clock)
+			d.resources[name] = result
+		}
+	})
+	return
+}
+
+// The Leaks() method returns the LeakDetector.
+func (d *Debug) Leaks() *LeakDetector {
+	return d.leaks
+}
+
+// The ResourceTimingsHandler() method returns an http.Handler that serves the named
+// ResourceTimings, where the name is the last element of the request path, e.g.
+// "/debug/resources/validate" serves the "validate" timings.
+func (d *Debug) ResourceTimingsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Base(r.URL.Path)
+
+		var rt *ResourceTimings
+		d.withMutex(func() {
+			rt = d.resources[name]
+		})
+		if rt == nil {
+			http.Error(w, fmt.Sprintf("no resource timings named %q", name), http.StatusNotFound)
+			return
+		}
+		rt.ServeHTTP(w, r)
+	})
+}
+
 // The ServeHTTP() method will serve a json representation of the contents of the debug root.
 func (d *Debug) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	d.withMutex(func() {
 		bytes, err := json.MarshalIndent(map[string]interface{}{
-			"timers": d.timers,
-			"values": d.values,
+			"timers":    d.timers,
+			"values":    d.values,
+			"resources": d.resources,
+			"leaks":     d.leaks,
 		}, "", "  ")
 
 		if err != nil {
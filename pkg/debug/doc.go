@@ -18,7 +18,7 @@
 // So how does this work? Well there is a new endpoint at `localhost:8877/debug` and you can run
 // `curl localhost:8877/debug` to see some useful information.
 //
-// There are currently two kinds of debug information that it exposes:
+// There are currently four kinds of debug information that it exposes:
 //
 // 1. Timers
 //
@@ -118,6 +118,51 @@
 //	  }
 //	}
 //
+// 3. Resource Timings
+//
+// Timers tell you how long an action takes in aggregate, but when a reconfigure is slow what you
+// usually want to know is which resource is making it slow. A ResourceTimings tracks elapsed time
+// per resource, and reports the resources whose most recent processing was slowest:
+//
+//	timings := dbg.ResourceTimings("validate")
+//	timings.Time("Mapping:default:my-mapping", func() {
+//	  // ... do some work on the resource
+//	})
+//
+//	{
+//	  ...
+//	  "resources": {
+//	    "validate": [
+//	      {"resource": "Mapping:default:my-mapping", "count": 3, "last": 1200000, ...},
+//	      ...
+//	    ]
+//	  }
+//	}
+//
+// 4. Leaks
+//
+// A goroutine dump of a long-running Ambassador has hundreds of goroutines in it, and a slow leak
+// is hard to spot among them. The LeakDetector counts goroutines by the code that started them, and
+// open watches by subsystem, and when the counts have grown at every one of the last few samples it
+// says what grew, and by how much. Anything that opens a long-lived watch should count it:
+//
+//	closeWatch := dbg.Leaks().OpenWatch("consul")
+//	// ... and once the watch is stopped
+//	closeWatch()
+//
+// The entrypoint samples every AMBASSADOR_LEAK_CHECK_INTERVAL_SECONDS (300 by default, 0 to
+// disable) and logs the growth when AMBASSADOR_LEAK_CHECK_WINDOW (6 by default) samples in a row
+// have grown. The counts, by subsystem, are also in /metrics:
+//
+//	{
+//	  ...
+//	  "leaks": {
+//	    "goroutines": {"cmd/entrypoint": 12, "pkg/ambex": 9, ...},
+//	    "watches": {"consul": 3, "fswatcher": 2},
+//	    "lastLeak": null
+//	  }
+//	}
+//
 // The full output of the debug endpoint now currently looks like this:
 //
 //	$ curl localhost:8877/debug
@@ -0,0 +1,346 @@
+package debug
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The LeakDetector struct tracks goroutines and open watches, per subsystem, and notices when they
+// keep growing. A long-running entrypoint that slowly accumulates goroutines is hard to debug from
+// a single goroutine dump: the leaked goroutines are there, but so are hundreds of perfectly good
+// ones. What helps is knowing which ones have grown, and by how much, and that's what a
+// LeakDetector reports.
+//
+// Goroutines are counted by their origin: the outermost function in their stack that's part of
+// Emissary (or, failing that, the function that created them), e.g.
+// "cmd/entrypoint.watchConsul.func2". The subsystem of a goroutine is the package of its origin.
+// Watches are counted by whatever subsystem opened them:
+//
+//	closeWatch := dbg.Leaks().OpenWatch("consul")
+//	// ...
+//	closeWatch()
+//
+// Sample has to be called every so often to count goroutines. If the total number of goroutines
+// has grown at every one of the last window samples, or the number of open watches of some
+// subsystem has, Sample returns a LeakReport saying what grew, and by how much. Like the Timer, a
+// LeakDetector is thread safe.
+type LeakDetector struct {
+	mutex   sync.Mutex     // protects the whole struct
+	watches map[string]int // open watches, by subsystem
+	window  int            // how many samples in a row have to grow for it to be a leak
+	history []leakSample   // the samples since the last report, oldest first, at most window+1
+	latest  *leakSample    // the most recent sample
+	report  *LeakReport    // the most recent report
+
+	clock ClockFunc // The clock function used for sampling.
+}
+
+type leakSample struct {
+	time       time.Time
+	goroutines map[string]int // by origin
+	watches    map[string]int // by subsystem
+}
+
+// The LeakReport struct says what grew at every sample for a whole window, from what to what.
+type LeakReport struct {
+	Since      time.Time    `json:"since"`
+	Until      time.Time    `json:"until"`
+	Goroutines []LeakGrowth `json:"goroutines,omitempty"` // by origin, most growth first
+	Watches    []LeakGrowth `json:"watches,omitempty"`    // by subsystem, most growth first
+}
+
+// The LeakGrowth struct is how much one thing grew over a LeakReport's window.
+type LeakGrowth struct {
+	Name string `json:"name"`
+	From int    `json:"from"`
+	To   int    `json:"to"`
+}
+
+// The DefaultLeakWindow constant is how many samples in a row have to grow, by default, before a
+// LeakDetector reports a leak.
+const DefaultLeakWindow = 6
+
+// The modulePath constant is trimmed from the functions that goroutines are counted by.
+const modulePath = "github.com/emissary-ingress/emissary/v3/"
+
+// The NewLeakDetector function creates a new LeakDetector using time.Now as the clock.
+func NewLeakDetector() *LeakDetector {
+	return NewLeakDetectorWithClock(time.Now)
+}
+
+// The NewLeakDetectorWithClock function creates a new LeakDetector with the given clock.
+func NewLeakDetectorWithClock(clock ClockFunc) *LeakDetector {
+	return &LeakDetector{
+		watches: map[string]int{},
+		window:  DefaultLeakWindow,
+		clock:   clock,
+	}
+}
+
+func (ld *LeakDetector) withMutex(f func()) {
+	ld.mutex.Lock()
+	defer ld.mutex.Unlock()
+	f()
+}
+
+// The SetWindow() method sets how many samples in a row have to grow before it's a leak.
+func (ld *LeakDetector) SetWindow(window int) {
+	if window < 1 {
+		window = 1
+	}
+	ld.withMutex(func() {
+		ld.window = window
+		if len(ld.history) > window+1 {
+			ld.history = ld.history[len(ld.history)-window-1:]
+		}
+	})
+}
+
+// The OpenWatch() method counts a watch opened by the given subsystem, and returns a function to
+// call when it's closed. Calling that more than once does no harm.
+func (ld *LeakDetector) OpenWatch(subsystem string) (closeWatch func()) {
+	ld.withMutex(func() {
+		ld.watches[subsystem]++
+	})
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			ld.withMutex(func() {
+				ld.watches[subsystem]--
+			})
+		})
+	}
+}
+
+// The Sample() method counts goroutines, and returns a LeakReport if anything has grown at every
+// sample of the window, or nil if nothing has.
+func (ld *LeakDetector) Sample() *LeakReport {
+	return ld.Record(ParseGoroutines(allStacks()))
+}
+
+// The Record() method is Sample with the goroutines, by origin, already counted.
+func (ld *LeakDetector) Record(goroutines map[string]int) (report *LeakReport) {
+	ld.withMutex(func() {
+		sample := leakSample{
+			time:       ld.clock(),
+			goroutines: goroutines,
+			watches:    make(map[string]int, len(ld.watches)),
+		}
+		for subsystem, count := range ld.watches {
+			if count != 0 {
+				sample.watches[subsystem] = count
+			}
+		}
+		ld.latest = &sample
+		ld.history = append(ld.history, sample)
+		if len(ld.history) > ld.window+1 {
+			ld.history = ld.history[1:]
+		}
+		if len(ld.history) < ld.window+1 {
+			return
+		}
+
+		report = ld.check()
+		if report != nil {
+			// Start over, so that the same growth isn't reported again at the next sample.
+			ld.report = report
+			ld.history = []leakSample{sample}
+		}
+	})
+	return report
+}
+
+// check looks for growth across the whole history. It must be called with the mutex held.
+func (ld *LeakDetector) check() *LeakReport {
+	first, last := ld.history[0], ld.history[len(ld.history)-1]
+	report := &LeakReport{Since: first.time, Until: last.time}
+
+	grew := true
+	for i := 1; i < len(ld.history); i++ {
+		if total(ld.history[i].goroutines) <= total(ld.history[i-1].goroutines) {
+			grew = false
+			break
+		}
+	}
+	if grew {
+		report.Goroutines = growth(first.goroutines, last.goroutines)
+	}
+
+	for subsystem := range last.watches {
+		grew := true
+		for i := 1; i < len(ld.history); i++ {
+			if ld.history[i].watches[subsystem] <= ld.history[i-1].watches[subsystem] {
+				grew = false
+				break
+			}
+		}
+		if grew {
+			report.Watches = append(report.Watches, LeakGrowth{
+				Name: subsystem,
+				From: first.watches[subsystem],
+				To:   last.watches[subsystem],
+			})
+		}
+	}
+	sortGrowth(report.Watches)
+
+	if len(report.Goroutines) == 0 && len(report.Watches) == 0 {
+		return nil
+	}
+	return report
+}
+
+// The Subsystems() method returns the goroutines, by subsystem, as of the latest sample, and the
+// open watches, by subsystem, as of right now.
+func (ld *LeakDetector) Subsystems() (goroutines map[string]int, watches map[string]int) {
+	ld.withMutex(func() {
+		goroutines = map[string]int{}
+		if ld.latest != nil {
+			for origin, count := range ld.latest.goroutines {
+				goroutines[originSubsystem(origin)] += count
+			}
+		}
+		watches = make(map[string]int, len(ld.watches))
+		for subsystem, count := range ld.watches {
+			watches[subsystem] = count
+		}
+	})
+	return goroutines, watches
+}
+
+// The MarshalJSON() method serves the counts by subsystem, and the most recent report.
+func (ld *LeakDetector) MarshalJSON() ([]byte, error) {
+	goroutines, watches := ld.Subsystems()
+	var report *LeakReport
+	ld.withMutex(func() {
+		report = ld.report
+	})
+	return json.Marshal(map[string]interface{}{
+		"goroutines": goroutines,
+		"watches":    watches,
+		"lastLeak":   report,
+	})
+}
+
+// The String() method renders a LeakReport as a differential dump, one line per thing that grew.
+func (r *LeakReport) String() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "growth from %s to %s:", r.Since.Format(time.RFC3339), r.Until.Format(time.RFC3339))
+	for _, g := range r.Goroutines {
+		fmt.Fprintf(&buf, "\n  goroutines %s: %d -> %d (+%d)", g.Name, g.From, g.To, g.To-g.From)
+	}
+	for _, g := range r.Watches {
+		fmt.Fprintf(&buf, "\n  watches %s: %d -> %d (+%d)", g.Name, g.From, g.To, g.To-g.From)
+	}
+	return buf.String()
+}
+
+func total(counts map[string]int) int {
+	n := 0
+	for _, count := range counts {
+		n += count
+	}
+	return n
+}
+
+func growth(from, to map[string]int) []LeakGrowth {
+	var result []LeakGrowth
+	for name, count := range to {
+		if count > from[name] {
+			result = append(result, LeakGrowth{Name: name, From: from[name], To: count})
+		}
+	}
+	sortGrowth(result)
+	return result
+}
+
+func sortGrowth(g []LeakGrowth) {
+	sort.Slice(g, func(i, j int) bool {
+		if di, dj := g[i].To-g[i].From, g[j].To-g[j].From; di != dj {
+			return di > dj
+		}
+		return g[i].Name < g[j].Name
+	})
+}
+
+// allStacks returns the stacks of all goroutines, as runtime.Stack formats them.
+func allStacks() []byte {
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// The ParseGoroutines function counts the goroutines in a dump from runtime.Stack, by origin.
+func ParseGoroutines(dump []byte) map[string]int {
+	counts := map[string]int{}
+	for _, block := range bytes.Split(dump, []byte("\n\n")) {
+		if origin := goroutineOrigin(string(block)); origin != "" {
+			counts[origin]++
+		}
+	}
+	return counts
+}
+
+// goroutineOrigin returns the origin of the goroutine with the given stack.
+func goroutineOrigin(stack string) string {
+	lines := strings.Split(strings.TrimSpace(stack), "\n")
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], "goroutine ") {
+		return ""
+	}
+
+	var funcs []string
+	createdBy := ""
+	for _, line := range lines[1:] {
+		switch {
+		case strings.HasPrefix(line, "\t"), line == "...":
+			// A file and line, or elided frames.
+		case strings.HasPrefix(line, "created by "):
+			createdBy = strings.TrimPrefix(line, "created by ")
+			// Go 1.21 and later say which goroutine did the creating.
+			if i := strings.Index(createdBy, " in goroutine "); i >= 0 {
+				createdBy = createdBy[:i]
+			}
+		default:
+			// Drop the arguments.
+			if i := strings.LastIndex(line, "("); i > 0 && strings.HasSuffix(line, ")") {
+				line = line[:i]
+			}
+			funcs = append(funcs, line)
+		}
+	}
+
+	// The outermost frame is last.
+	for i := len(funcs) - 1; i >= 0; i-- {
+		if strings.HasPrefix(funcs[i], modulePath) {
+			return strings.TrimPrefix(funcs[i], modulePath)
+		}
+	}
+	if createdBy != "" {
+		return createdBy
+	}
+	if len(funcs) > 0 {
+		return funcs[len(funcs)-1]
+	}
+	return ""
+}
+
+// originSubsystem returns the package of an origin, e.g. "cmd/entrypoint" for
+// "cmd/entrypoint.watchConsul.func2".
+func originSubsystem(origin string) string {
+	slash := strings.LastIndex(origin, "/")
+	if dot := strings.Index(origin[slash+1:], "."); dot >= 0 {
+		return origin[:slash+1+dot]
+	}
+	return origin
+}
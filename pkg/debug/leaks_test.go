@@ -0,0 +1,116 @@
+package debug_test
+
+import (
+	"encoding/json"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/debug"
+)
+
+func TestLeakDetector(t *testing.T) {
+	clock := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	ld := debug.NewLeakDetectorWithClock(func() time.Time {
+		return clock
+	})
+	ld.SetWindow(3)
+
+	sample := func(leaky int) *debug.LeakReport {
+		clock = clock.Add(time.Minute)
+		return ld.Record(map[string]int{"cmd/entrypoint.leaky": leaky, "pkg/ambex.Main": 4})
+	}
+
+	// Not enough samples yet.
+	assert.Nil(t, sample(1))
+	assert.Nil(t, sample(2))
+	assert.Nil(t, sample(3))
+
+	// Growth at every sample of the window.
+	report := sample(5)
+	require.NotNil(t, report)
+	assert.Equal(t, []debug.LeakGrowth{{Name: "cmd/entrypoint.leaky", From: 1, To: 5}}, report.Goroutines)
+	assert.Empty(t, report.Watches)
+	assert.Equal(t, "growth from 2026-10-16T12:01:00Z to 2026-10-16T12:04:00Z:\n"+
+		"  goroutines cmd/entrypoint.leaky: 1 -> 5 (+4)", report.String())
+
+	// The same growth isn't reported again right away...
+	assert.Nil(t, sample(6))
+	assert.Nil(t, sample(7))
+	// ...and growth that stops isn't reported at all.
+	assert.Nil(t, sample(7))
+	assert.Nil(t, sample(8))
+
+	goroutines, _ := ld.Subsystems()
+	assert.Equal(t, map[string]int{"cmd/entrypoint": 8, "pkg/ambex": 4}, goroutines)
+}
+
+func TestLeakDetectorWatches(t *testing.T) {
+	ld := debug.NewLeakDetector()
+	ld.SetWindow(2)
+
+	closeFirst := ld.OpenWatch("consul")
+	assert.Nil(t, ld.Record(nil))
+	ld.OpenWatch("consul")
+	assert.Nil(t, ld.Record(nil))
+	ld.OpenWatch("consul")
+	closeFS := ld.OpenWatch("fswatcher")
+	report := ld.Record(nil)
+	require.NotNil(t, report)
+	assert.Empty(t, report.Goroutines)
+	assert.Equal(t, []debug.LeakGrowth{{Name: "consul", From: 1, To: 3}}, report.Watches)
+
+	// Closing a watch twice only closes it once.
+	closeFirst()
+	closeFirst()
+	closeFS()
+	_, watches := ld.Subsystems()
+	assert.Equal(t, map[string]int{"consul": 2, "fswatcher": 0}, watches)
+
+	bytes, err := json.Marshal(ld)
+	require.NoError(t, err)
+	assert.Contains(t, string(bytes), `"watches":{"consul":2,"fswatcher":0}`)
+}
+
+func blockForever(ch chan struct{}) {
+	<-ch
+}
+
+func TestParseGoroutines(t *testing.T) {
+	ch := make(chan struct{})
+	defer close(ch)
+	for i := 0; i < 3; i++ {
+		go blockForever(ch)
+	}
+
+	var counts map[string]int
+	require.Eventually(t, func() bool {
+		buf := make([]byte, 1<<20)
+		counts = debug.ParseGoroutines(buf[:runtime.Stack(buf, true)])
+		return counts["pkg/debug_test.blockForever"] == 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Goroutines that never touch our code are counted by whatever created them.
+	dump := "goroutine 7 [IO wait]:\n" +
+		"internal/poll.runtime_pollWait(0x7f, 0x72)\n" +
+		"\t/usr/local/go/src/runtime/netpoll.go:343 +0x85\n" +
+		"net/http.(*persistConn).readLoop(0xc0001)\n" +
+		"\t/usr/local/go/src/net/http/transport.go:2205 +0x185\n" +
+		"created by net/http.(*Transport).dialConn in goroutine 6\n" +
+		"\t/usr/local/go/src/net/http/transport.go:1776 +0x16f1\n" +
+		"\n" +
+		"goroutine 9 [select]:\n" +
+		"github.com/emissary-ingress/emissary/v3/pkg/kates.(*Accumulator).run(0xc0002)\n" +
+		"\t/src/pkg/kates/accumulator.go:100 +0x10\n" +
+		"github.com/emissary-ingress/emissary/v3/cmd/entrypoint.watchAll.func1()\n" +
+		"\t/src/cmd/entrypoint/watcher.go:50 +0x10\n" +
+		"created by github.com/datawire/dlib/dgroup.(*Group).goWorker in goroutine 1\n" +
+		"\t/go/pkg/mod/github.com/datawire/dlib/dgroup/group.go:300 +0x10\n"
+	assert.Equal(t, map[string]int{
+		"net/http.(*Transport).dialConn": 1,
+		"cmd/entrypoint.watchAll.func1":  1,
+	}, debug.ParseGoroutines([]byte(dump)))
+}
@@ -0,0 +1,150 @@
+package debug
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The ResourceTimings struct tracks how long we spend processing each individual resource. Where a
+// Timer tells you that reconfiguring takes 30 seconds, a ResourceTimings tells you which resource
+// is responsible for those 30 seconds. Like the Timer, it is thread safe.
+//
+// Example
+//
+//	timings := dbg.ResourceTimings("validate")
+//
+//	for _, mapping := range mappings {
+//	  timings.Time("Mapping:"+mapping.Namespace+":"+mapping.Name, func() {
+//	    // ...
+//	  })
+//	}
+type ResourceTimings struct {
+	mutex   sync.Mutex                 // protects the whole struct
+	entries map[string]*ResourceTiming // timing info for each resource, by key
+	top     int                        // how many entries to report when marshalled
+
+	clock ClockFunc // The clock function used for timing.
+}
+
+// The ResourceTiming struct holds the timing info for a single resource.
+type ResourceTiming struct {
+	Resource string        `json:"resource"`
+	Count    int           `json:"count"` // how many times the resource has been processed
+	Last     time.Duration `json:"last"`  // how long the most recent processing took
+	Max      time.Duration `json:"max"`   // how long the slowest processing took
+	Total    time.Duration `json:"total"` // how long all processing has taken
+}
+
+// The DefaultResourceTimingsTop constant is how many of the slowest resources are reported when a
+// ResourceTimings is marshalled.
+const DefaultResourceTimingsTop = 10
+
+// The NewResourceTimings function creates a new ResourceTimings using time.Now as the clock.
+func NewResourceTimings() *ResourceTimings {
+	return NewResourceTimingsWithClock(time.Now)
+}
+
+// The NewResourceTimingsWithClock function creates a new ResourceTimings with the given clock.
+func NewResourceTimingsWithClock(clock ClockFunc) *ResourceTimings {
+	return &ResourceTimings{
+		entries: map[string]*ResourceTiming{},
+		top:     DefaultResourceTimingsTop,
+		clock:   clock,
+	}
+}
+
+func (rt *ResourceTimings) withMutex(f func()) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	f()
+}
+
+// The Time() method times how long the supplied function takes, and records it against the named
+// resource. It returns the elapsed time so that callers can act on slow resources.
+func (rt *ResourceTimings) Time(resource string, f func()) time.Duration {
+	start := rt.clock()
+	f()
+	elapsed := rt.clock().Sub(start)
+	rt.Record(resource, elapsed)
+	return elapsed
+}
+
+// The Record() method records an elapsed time against the named resource.
+func (rt *ResourceTimings) Record(resource string, elapsed time.Duration) {
+	rt.withMutex(func() {
+		entry, ok := rt.entries[resource]
+		if !ok {
+			entry = &ResourceTiming{Resource: resource}
+			rt.entries[resource] = entry
+		}
+		entry.Count++
+		entry.Last = elapsed
+		entry.Total += elapsed
+		if elapsed > entry.Max {
+			entry.Max = elapsed
+		}
+	})
+}
+
+// The Forget() method discards the timing info for the named resource, e.g. because the resource
+// has been deleted.
+func (rt *ResourceTimings) Forget(resource string) {
+	rt.withMutex(func() {
+		delete(rt.entries, resource)
+	})
+}
+
+// The Top() method returns the timing info for the n resources whose most recent processing was
+// slowest, slowest first. If n is not positive, all resources are returned.
+func (rt *ResourceTimings) Top(n int) []ResourceTiming {
+	var result []ResourceTiming
+	rt.withMutex(func() {
+		result = make([]ResourceTiming, 0, len(rt.entries))
+		for _, entry := range rt.entries {
+			result = append(result, *entry)
+		}
+	})
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Last != result[j].Last {
+			return result[i].Last > result[j].Last
+		}
+		return result[i].Resource < result[j].Resource
+	})
+
+	if n > 0 && len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+// The MarshalJSON() method marshals the slowest resources.
+func (rt *ResourceTimings) MarshalJSON() ([]byte, error) {
+	return json.Marshal(rt.Top(rt.top))
+}
+
+// The ServeHTTP() method serves the slowest resources as json. The number of resources reported
+// can be changed with the "top" query parameter.
+func (rt *ResourceTimings) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	top := rt.top
+	if str := r.URL.Query().Get("top"); str != "" {
+		n, err := strconv.Atoi(str)
+		if err != nil {
+			http.Error(w, "invalid top: "+str, http.StatusBadRequest)
+			return
+		}
+		top = n
+	}
+
+	bytes, err := json.MarshalIndent(rt.Top(top), "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(append(bytes, '\n'))
+}
@@ -0,0 +1,70 @@
+package debug_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/debug"
+)
+
+func TestResourceTimings(t *testing.T) {
+	clock := time.Now()
+	timings := debug.NewResourceTimingsWithClock(func() time.Time {
+		return clock
+	})
+
+	elapsed := timings.Time("fast", func() {
+		clock = clock.Add(10 * time.Millisecond)
+	})
+	assert.Equal(t, 10*time.Millisecond, elapsed)
+	timings.Time("slow", func() {
+		clock = clock.Add(2 * time.Second)
+	})
+	timings.Time("slow", func() {
+		clock = clock.Add(1 * time.Second)
+	})
+	timings.Record("medium", 500*time.Millisecond)
+
+	top := timings.Top(2)
+	require.Len(t, top, 2)
+	assert.Equal(t, "slow", top[0].Resource)
+	assert.Equal(t, 2, top[0].Count)
+	assert.Equal(t, 1*time.Second, top[0].Last)
+	assert.Equal(t, 2*time.Second, top[0].Max)
+	assert.Equal(t, 3*time.Second, top[0].Total)
+	assert.Equal(t, "medium", top[1].Resource)
+
+	assert.Len(t, timings.Top(0), 3)
+
+	timings.Forget("slow")
+	top = timings.Top(0)
+	require.Len(t, top, 2)
+	assert.Equal(t, "medium", top[0].Resource)
+}
+
+func TestResourceTimingsHandler(t *testing.T) {
+	dbg := debug.NewDebug()
+	dbg.ResourceTimings("validate").Record("Mapping:default:foo", time.Second)
+	dbg.ResourceTimings("validate").Record("Mapping:default:bar", time.Millisecond)
+
+	handler := dbg.ResourceTimingsHandler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/resources/validate?top=1", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var top []debug.ResourceTiming
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &top))
+	require.Len(t, top, 1)
+	assert.Equal(t, "Mapping:default:foo", top[0].Resource)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/resources/bogus", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
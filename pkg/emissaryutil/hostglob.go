@@ -0,0 +1,78 @@
+package emissaryutil
+
+import (
+	"strings"
+)
+
+// HostGlobMatches mimics `python/ambassador/ir/irutils.py:hostglob_matches()`: it says whether
+// there can be a hostname that matches both of two DNS globs (like a Host's hostname and a
+// Mapping's). Please keep them in-sync.
+func HostGlobMatches(g1, g2 string) bool {
+	if g1 == g2 {
+		return true
+	}
+	if g1 == "*" || g2 == "*" {
+		return true
+	}
+	if g1 == "" || g2 == "" || g1[0] == '.' || g2[0] == '.' {
+		return false
+	}
+
+	g1start, g1end := strings.HasPrefix(g1, "*"), strings.HasSuffix(g1, "*")
+	g2start, g2end := strings.HasPrefix(g2, "*"), strings.HasSuffix(g2, "*")
+
+	if (g1start && g1end) || (g2start && g2end) {
+		// Not a valid DNS glob.
+		return g1 == g2
+	}
+	if !(g1start || g1end || g2start || g2end) {
+		return false
+	}
+	if (g1start && g2end) || (g2start && g1end) {
+		return true
+	}
+
+	switch {
+	case g1start:
+		return hostGlobMatchesStart(g1, g2, g2start)
+	case g2start:
+		return hostGlobMatchesStart(g2, g1, g1start)
+	case g1end:
+		return hostGlobMatchesEnd(g1, g2, g2end)
+	default:
+		return hostGlobMatchesEnd(g2, g1, g1end)
+	}
+}
+
+// hostGlobMatchesStart mimics `hostglob_matches_start()`: g1 starts with "*", and g2 doesn't end
+// with one.
+func hostGlobMatchesStart(g1, g2 string, g2start bool) bool {
+	// A leading "*" can't match an empty string.
+	g1match, g2match := g1[1:], g2
+	if g2start {
+		g2match = g2[1:]
+	}
+	if len(g1) > len(g2match) {
+		if !g2start {
+			return false
+		}
+		g1match, g2match = g2[1:], g1[1:]
+	}
+	return strings.HasSuffix(g2match, g1match)
+}
+
+// hostGlobMatchesEnd mimics `hostglob_matches_end()`: g1 ends with "*", and g2 doesn't start with
+// one.
+func hostGlobMatchesEnd(g1, g2 string, g2end bool) bool {
+	g1match, g2match := g1[:len(g1)-1], g2
+	if g2end {
+		g2match = g2[:len(g2)-1]
+	}
+	if len(g1) > len(g2match) {
+		if !g2end {
+			return false
+		}
+		g1match, g2match = g2[:len(g2)-1], g1[:len(g1)-1]
+	}
+	return strings.HasPrefix(g2match, g1match)
+}
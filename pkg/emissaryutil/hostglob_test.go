@@ -0,0 +1,55 @@
+package emissaryutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// These are the cases from python/tests/unit/test_hostglob_matches.py.
+func TestHostGlobMatches(t *testing.T) {
+	t.Parallel()
+	testcases := []struct {
+		G1, G2 string
+		Match  bool
+	}{
+		{"a.example.com", "a.example.com", true},
+		{"a.example.com", "b.example.com", false},
+		{"*", "foo.example.com", true},
+		{"*.example.com", "a.example.com", true},
+		{"*example.com", "b.example.com", true},
+		{"*example.com", ".example.com", false},
+		{"foo.example*", "foo.example.com.", true},
+		{"*example.com", "example.com", false},
+		{"*ple.com", "b.example.com", true},
+		{"*.example.com", "a.example.org", false},
+		{"*example.com", "a.example.org", false},
+		{"*ple.com", "a.example.org", false},
+		{"a.example.*", "a.example.com", true},
+		{"a.example*", "a.example.com", true},
+		{"a.exa*", "a.example.com", true},
+		{"a.example.*", "a.example.org", true},
+		{"a.example.*", "b.example.com", false},
+		{"a.example*", "b.example.com", false},
+		{"a.exa*", "b.example.com", false},
+		{"a.*.com", "a.example.com", false},
+		{"*.com", "a.example.com", true},
+		{"*.com", "a.example.org", false},
+		{"*.example.com", "*.example.com", true},
+		{"*example.com", "*.example.com", true},
+		{"*.example.com", "a.example.*", true},
+		{"*.example.com", "a.b.example.*", true},
+		{"*.example.baz.com", "a.b.example.*", true},
+		{"*.foo.bar", "baz.zing.*", true},
+		{"*.local:8500", "quote.local", false},
+		{"*.local:8500", "quote.local:8500", true},
+		{"*", "quote.local:8500", true},
+		{"quote.*", "quote.local:8500", true},
+		{"quote.*", "*.local:8500", true},
+		{"quote.com:8500", "quote.com:8500", true},
+	}
+	for _, tc := range testcases {
+		assert.Equal(t, tc.Match, HostGlobMatches(tc.G1, tc.G2), "%q ~ %q", tc.G1, tc.G2)
+		assert.Equal(t, tc.Match, HostGlobMatches(tc.G2, tc.G1), "%q ~ %q", tc.G2, tc.G1)
+	}
+}
@@ -0,0 +1,127 @@
+// Package envoyprofile collects heap and CPU profiles from Envoy through its admin interface.
+//
+// The heap profile comes straight back from /heap_dump. The CPU profiler is turned on and off
+// through /cpuprofiler, and Envoy writes what it collected to the admin profile_path when it's
+// turned off, so the CPU profile is read from there. Both need an Envoy built with gperftools'
+// tcmalloc (as Emissary's is); any other Envoy refuses them.
+package envoyprofile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+)
+
+// ProfilePath is where Envoy writes its CPU profile. It has to match profile_path in
+// python/ambassador/envoy/v3/v3admin.py.
+const ProfilePath = "/tmp/envoy.prof"
+
+// MaxCPUDuration is the longest that Envoy's CPU profiler may run for.
+const MaxCPUDuration = 5 * time.Minute
+
+// ErrRunning is returned by CPU when a CPU profile is already being collected. Envoy only has the
+// one profiler.
+var ErrRunning = errors.New("an Envoy CPU profile is already being collected")
+
+// A Profiler collects profiles from a single Envoy.
+type Profiler struct {
+	adminURL    string
+	profilePath string
+	client      *http.Client
+
+	// The mutex makes sure only one CPU profile runs at a time.
+	mutex   sync.Mutex
+	running bool
+}
+
+// NewProfiler returns a Profiler that talks to the Envoy admin interface at adminURL, and reads
+// CPU profiles from profilePath.
+func NewProfiler(adminURL, profilePath string) *Profiler {
+	return &Profiler{
+		adminURL:    strings.TrimSuffix(adminURL, "/"),
+		profilePath: profilePath,
+		client:      &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Heap returns Envoy's current heap profile.
+func (p *Profiler) Heap(ctx context.Context) ([]byte, error) {
+	return p.admin(ctx, http.MethodGet, "/heap_dump")
+}
+
+// CPU runs Envoy's CPU profiler for d, or until ctx is canceled, and returns what it collected.
+func (p *Profiler) CPU(ctx context.Context, d time.Duration) ([]byte, error) {
+	if d <= 0 || d > MaxCPUDuration {
+		return nil, fmt.Errorf("duration %v is not between 0 and %v", d, MaxCPUDuration)
+	}
+
+	p.mutex.Lock()
+	if p.running {
+		p.mutex.Unlock()
+		return nil, ErrRunning
+	}
+	p.running = true
+	p.mutex.Unlock()
+	defer func() {
+		p.mutex.Lock()
+		p.running = false
+		p.mutex.Unlock()
+	}()
+
+	// A profile left over from last time would look just like a new one.
+	if err := os.Remove(p.profilePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if _, err := p.admin(ctx, http.MethodPost, "/cpuprofiler?enable=y"); err != nil {
+		return nil, err
+	}
+
+	timer := clock.FromContext(ctx).NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+	case <-ctx.Done():
+	}
+
+	// The profiler has to be turned off even if ctx is done, or it'll run until Envoy exits.
+	stopCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := p.admin(stopCtx, http.MethodPost, "/cpuprofiler?enable=n"); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	profile, err := os.ReadFile(p.profilePath)
+	if err != nil {
+		return nil, fmt.Errorf("envoy didn't write its CPU profile: %w", err)
+	}
+	return profile, nil
+}
+
+// admin makes a request of the Envoy admin interface, and returns the response body.
+func (p *Profiler) admin(ctx context.Context, method, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, p.adminURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("envoy refused %s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return io.ReadAll(resp.Body)
+}
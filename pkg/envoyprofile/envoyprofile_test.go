@@ -0,0 +1,145 @@
+package envoyprofile
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+)
+
+// fakeEnvoy pretends to be Envoy's admin interface, writing profile to path when its CPU profiler
+// is turned off.
+type fakeEnvoy struct {
+	path    string
+	profile string
+
+	mutex   sync.Mutex
+	calls   []string
+	enabled bool
+}
+
+func (e *fakeEnvoy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.calls = append(e.calls, r.Method+" "+r.URL.RequestURI())
+
+	switch r.URL.Path {
+	case "/heap_dump":
+		_, _ = w.Write([]byte("heap profile"))
+	case "/cpuprofiler":
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		enable := r.URL.Query().Get("enable") == "y"
+		if e.enabled && !enable {
+			_ = os.WriteFile(e.path, []byte(e.profile), 0o644)
+		}
+		e.enabled = enable
+		_, _ = w.Write([]byte("OK\n"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (e *fakeEnvoy) Calls() []string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return append([]string(nil), e.calls...)
+}
+
+func TestHeap(t *testing.T) {
+	envoy := &fakeEnvoy{}
+	srv := httptest.NewServer(envoy)
+	defer srv.Close()
+
+	heap, err := NewProfiler(srv.URL, "").Heap(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "heap profile", string(heap))
+}
+
+func TestCPU(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "envoy.prof")
+	// A stale profile mustn't be mistaken for the new one.
+	require.NoError(t, os.WriteFile(path, []byte("stale"), 0o644))
+
+	envoy := &fakeEnvoy{path: path, profile: "cpu profile"}
+	srv := httptest.NewServer(envoy)
+	defer srv.Close()
+
+	clk := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	ctx := clock.WithClock(context.Background(), clk)
+	p := NewProfiler(srv.URL, path)
+
+	type result struct {
+		profile []byte
+		err     error
+	}
+	done := make(chan result)
+	go func() {
+		profile, err := p.CPU(ctx, 30*time.Second)
+		done <- result{profile, err}
+	}()
+
+	require.Eventually(t, func() bool { return clk.Timers() > 0 }, 5*time.Second, time.Millisecond)
+
+	// Envoy only has the one profiler.
+	_, err := p.CPU(ctx, time.Second)
+	assert.ErrorIs(t, err, ErrRunning)
+
+	clk.Advance(30 * time.Second)
+	res := <-done
+	require.NoError(t, res.err)
+	assert.Equal(t, "cpu profile", string(res.profile))
+	assert.Equal(t, []string{"POST /cpuprofiler?enable=y", "POST /cpuprofiler?enable=n"}, envoy.Calls())
+}
+
+func TestCPUCanceled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "envoy.prof")
+	envoy := &fakeEnvoy{path: path}
+	srv := httptest.NewServer(envoy)
+	defer srv.Close()
+
+	clk := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	ctx, cancel := context.WithCancel(clock.WithClock(context.Background(), clk))
+	defer cancel()
+
+	done := make(chan error)
+	go func() {
+		_, err := NewProfiler(srv.URL, path).CPU(ctx, time.Minute)
+		done <- err
+	}()
+	require.Eventually(t, func() bool { return clk.Timers() > 0 }, 5*time.Second, time.Millisecond)
+	cancel()
+
+	// The profiler still gets turned off.
+	assert.ErrorIs(t, <-done, context.Canceled)
+	assert.Equal(t, []string{"POST /cpuprofiler?enable=y", "POST /cpuprofiler?enable=n"}, envoy.Calls())
+}
+
+func TestCPUDuration(t *testing.T) {
+	p := NewProfiler("http://127.0.0.1:1", "")
+	_, err := p.CPU(context.Background(), 0)
+	assert.Error(t, err)
+	_, err = p.CPU(context.Background(), MaxCPUDuration+time.Second)
+	assert.Error(t, err)
+}
+
+func TestEnvoyRefuses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "heap profiling is not supported", http.StatusNotImplemented)
+	}))
+	defer srv.Close()
+
+	_, err := NewProfiler(srv.URL, "").Heap(context.Background())
+	assert.ErrorContains(t, err, "heap profiling is not supported")
+}
@@ -0,0 +1,86 @@
+package envoystats
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// The Prometheus names (and labels) of the stats that say how a drain is going.
+const (
+	listenerAddressLabel = "envoy_listener_address"
+
+	listenerActiveMetric    = "envoy_listener_downstream_cx_active"
+	listenersDrainingMetric = "envoy_listener_manager_total_listeners_draining"
+	totalConnectionsMetric  = "envoy_server_total_connections"
+	serverStateMetric       = "envoy_server_state"
+)
+
+// serverStateDraining is the value of server.state while Envoy is draining.
+const serverStateDraining = 1
+
+// DrainStats are Envoy's downstream connections, and whether it's draining them.
+type DrainStats struct {
+	// Draining is whether Envoy as a whole is draining, as it does once its listeners have been
+	// told to drain for shutdown.
+	Draining bool
+	// ListenersDraining is the number of listeners that Envoy is draining because a
+	// configuration change replaced or removed them.
+	ListenersDraining int
+	// TotalConnections is the number of downstream connections Envoy has open.
+	TotalConnections uint64
+	// Listeners maps each listener's address (like "0.0.0.0_8080") to the number of downstream
+	// connections open on it.
+	Listeners map[string]uint64
+}
+
+// ParseDrain reads Envoy's drain stats out of its /stats/prometheus output.
+func ParseDrain(r io.Reader) (*DrainStats, error) {
+	stats := &DrainStats{Listeners: map[string]uint64{}}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, labels, value, err := parseSample(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineno, err)
+		}
+
+		switch name {
+		case listenerActiveMetric:
+			// The admin listener's stats have no address, and it's not draining anything.
+			if address, ok := labels[listenerAddressLabel]; ok {
+				stats.Listeners[address] = uint64(value)
+			}
+		case listenersDrainingMetric:
+			stats.ListenersDraining = int(value)
+		case totalConnectionsMetric:
+			stats.TotalConnections = uint64(value)
+		case serverStateMetric:
+			stats.Draining = int(value) == serverStateDraining
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// ScrapeDrain fetches and parses Envoy's drain stats from the Envoy admin interface at adminURL.
+func ScrapeDrain(ctx context.Context, client *http.Client, adminURL string) (*DrainStats, error) {
+	// Gauges that have dropped back to zero still count, so this can't be usedonly.
+	filter := `^(listener\..+\.downstream_cx_active|listener_manager\.total_listeners_draining|server\.(total_connections|state))$`
+	body, err := getStats(ctx, client, adminURL, filter, false)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return ParseDrain(body)
+}
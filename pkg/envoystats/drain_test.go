@@ -0,0 +1,56 @@
+package envoystats
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testDrainStats = `# TYPE envoy_listener_downstream_cx_active gauge
+envoy_listener_downstream_cx_active{envoy_listener_address="0.0.0.0_8080"} 12
+envoy_listener_downstream_cx_active{envoy_listener_address="0.0.0.0_8443"} 30
+envoy_listener_admin_downstream_cx_active{} 1
+# TYPE envoy_listener_worker_downstream_cx_active gauge
+envoy_listener_worker_downstream_cx_active{envoy_listener_address="0.0.0.0_8080",envoy_worker_id="0"} 12
+# TYPE envoy_listener_manager_total_listeners_draining gauge
+envoy_listener_manager_total_listeners_draining{} 1
+# TYPE envoy_server_total_connections gauge
+envoy_server_total_connections{} 42
+# TYPE envoy_server_state gauge
+envoy_server_state{} 1
+`
+
+func TestParseDrain(t *testing.T) {
+	stats, err := ParseDrain(strings.NewReader(testDrainStats))
+	require.NoError(t, err)
+
+	assert.Equal(t, &DrainStats{
+		Draining:          true,
+		ListenersDraining: 1,
+		TotalConnections:  42,
+		Listeners:         map[string]uint64{"0.0.0.0_8080": 12, "0.0.0.0_8443": 30},
+	}, stats)
+
+	stats, err = ParseDrain(strings.NewReader("envoy_server_state{} 0\n"))
+	require.NoError(t, err)
+	assert.False(t, stats.Draining)
+}
+
+func TestScrapeDrain(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/stats/prometheus", r.URL.Path)
+		_, usedOnly := r.URL.Query()["usedonly"]
+		assert.False(t, usedOnly)
+		_, _ = w.Write([]byte(testDrainStats))
+	}))
+	defer srv.Close()
+
+	stats, err := ScrapeDrain(context.Background(), srv.Client(), srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(42), stats.TotalConnections)
+}
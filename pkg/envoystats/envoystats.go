@@ -0,0 +1,316 @@
+// Package envoystats reads per-cluster traffic stats out of Envoy's /stats/prometheus, and rolls
+// them up across clusters, so that traffic can be reported for the things people actually
+// configure (Mappings and Hosts) rather than for generated cluster names.
+//
+// Only the stats that describe traffic are kept: requests, responses by class, and the request
+// time histogram. Envoy reports request times in milliseconds, and uses the same buckets for every
+// cluster, so histograms from different clusters can simply be added up.
+package envoystats
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The Prometheus names (and labels) of the stats we keep.
+const (
+	clusterLabel       = "envoy_cluster_name"
+	responseClassLabel = "envoy_response_code_class"
+
+	requestsMetric  = "envoy_cluster_upstream_rq_total"
+	responsesMetric = "envoy_cluster_upstream_rq_xx"
+	latencyMetric   = "envoy_cluster_upstream_rq_time"
+)
+
+// ClusterStats are the traffic stats for one cluster, keyed (like Envoy keys them) by its stat
+// name, which is its alt_stat_name if it has one, and its name if it doesn't.
+type ClusterStats struct {
+	// Requests is the number of requests sent to the cluster.
+	Requests uint64
+	// Responses counts responses by class, e.g. "2xx".
+	Responses map[string]uint64
+	// Latency is the request time histogram.
+	Latency Histogram
+}
+
+// A Histogram is a cumulative Prometheus histogram of milliseconds.
+type Histogram struct {
+	// Buckets maps each bucket's upper bound to the number of observations at or below it,
+	// including the +Inf bucket.
+	Buckets map[float64]uint64
+	Sum     float64
+	Count   uint64
+}
+
+// Add adds other's observations to h.
+func (h *Histogram) Add(other Histogram) {
+	if h.Buckets == nil {
+		h.Buckets = make(map[float64]uint64, len(other.Buckets))
+	}
+	for le, count := range other.Buckets {
+		h.Buckets[le] += count
+	}
+	h.Sum += other.Sum
+	h.Count += other.Count
+}
+
+// Mean returns the mean observation, or 0 if there aren't any.
+func (h *Histogram) Mean() float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / float64(h.Count)
+}
+
+// Quantile estimates the q-quantile (0 <= q <= 1) the way Prometheus's histogram_quantile does:
+// find the bucket it's in, and interpolate linearly within that bucket. It returns 0 if there are
+// no observations. If the quantile is in the +Inf bucket, the best it can say is the largest
+// finite bound.
+func (h *Histogram) Quantile(q float64) float64 {
+	if h.Count == 0 || len(h.Buckets) == 0 {
+		return 0
+	}
+	bounds := make([]float64, 0, len(h.Buckets))
+	for le := range h.Buckets {
+		bounds = append(bounds, le)
+	}
+	sort.Float64s(bounds)
+
+	rank := q * float64(h.Count)
+	lower, below := 0.0, uint64(0)
+	for _, le := range bounds {
+		count := h.Buckets[le]
+		if float64(count) >= rank {
+			if math.IsInf(le, +1) {
+				return lower
+			}
+			if count == below {
+				return le
+			}
+			return lower + (le-lower)*(rank-float64(below))/float64(count-below)
+		}
+		lower, below = le, count
+	}
+	return lower
+}
+
+// Parse reads the traffic stats for each cluster out of Envoy's /stats/prometheus output.
+func Parse(r io.Reader) (map[string]*ClusterStats, error) {
+	stats := make(map[string]*ClusterStats)
+	get := func(cluster string) *ClusterStats {
+		cs, ok := stats[cluster]
+		if !ok {
+			cs = &ClusterStats{Responses: map[string]uint64{}}
+			stats[cluster] = cs
+		}
+		return cs
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || !strings.HasPrefix(line, "envoy_cluster_upstream_rq_") {
+			continue
+		}
+		name, labels, value, err := parseSample(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineno, err)
+		}
+		cluster, ok := labels[clusterLabel]
+		if !ok {
+			continue
+		}
+
+		switch name {
+		case requestsMetric:
+			get(cluster).Requests = uint64(value)
+		case responsesMetric:
+			if class, ok := labels[responseClassLabel]; ok {
+				get(cluster).Responses[class+"xx"] = uint64(value)
+			}
+		case latencyMetric + "_bucket":
+			le, err := strconv.ParseFloat(labels["le"], 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: bad bucket bound %q", lineno, labels["le"])
+			}
+			latency := &get(cluster).Latency
+			if latency.Buckets == nil {
+				latency.Buckets = make(map[float64]uint64)
+			}
+			latency.Buckets[le] = uint64(value)
+		case latencyMetric + "_sum":
+			get(cluster).Latency.Sum = value
+		case latencyMetric + "_count":
+			get(cluster).Latency.Count = uint64(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// parseSample parses a single sample line of the Prometheus text format:
+//
+//	name{label="value",...} value [timestamp]
+func parseSample(line string) (string, map[string]string, float64, error) {
+	labels := map[string]string{}
+	name, rest := line, ""
+	if i := strings.IndexAny(line, "{ "); i >= 0 {
+		name, rest = line[:i], line[i:]
+	}
+	if strings.HasPrefix(rest, "{") {
+		rest = rest[1:]
+		for {
+			rest = strings.TrimLeft(rest, ", ")
+			if strings.HasPrefix(rest, "}") {
+				rest = rest[1:]
+				break
+			}
+			key, after, ok := strings.Cut(rest, "=")
+			if !ok || !strings.HasPrefix(after, `"`) {
+				return "", nil, 0, fmt.Errorf("bad label in %q", line)
+			}
+			value, after, err := parseLabelValue(after[1:])
+			if err != nil {
+				return "", nil, 0, fmt.Errorf("%w in %q", err, line)
+			}
+			labels[strings.TrimSpace(key)] = value
+			rest = after
+		}
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return "", nil, 0, fmt.Errorf("no value in %q", line)
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return "", nil, 0, fmt.Errorf("bad value in %q", line)
+	}
+	return name, labels, value, nil
+}
+
+// parseLabelValue parses an escaped label value, up to and including its closing quote, and
+// returns it along with what follows it.
+func parseLabelValue(s string) (string, string, error) {
+	var value strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return value.String(), s[i+1:], nil
+		case '\\':
+			i++
+			if i == len(s) {
+				return "", "", fmt.Errorf("unterminated label value")
+			}
+			switch s[i] {
+			case 'n':
+				value.WriteByte('\n')
+			default:
+				value.WriteByte(s[i])
+			}
+		default:
+			value.WriteByte(s[i])
+		}
+	}
+	return "", "", fmt.Errorf("unterminated label value")
+}
+
+// Scrape fetches and parses /stats/prometheus from the Envoy admin interface at adminURL. It only
+// asks for the stats that Parse keeps.
+func Scrape(ctx context.Context, client *http.Client, adminURL string) (map[string]*ClusterStats, error) {
+	body, err := getStats(ctx, client, adminURL, `^cluster\..+\.upstream_rq_`, true)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return Parse(body)
+}
+
+// getStats fetches the stats matching filter from /stats/prometheus. If usedOnly is true, Envoy
+// leaves out the stats that have never been updated.
+func getStats(ctx context.Context, client *http.Client, adminURL, filter string, usedOnly bool) (io.ReadCloser, error) {
+	query := url.Values{"filter": {filter}}
+	if usedOnly {
+		query.Set("usedonly", "")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		strings.TrimSuffix(adminURL, "/")+"/stats/prometheus?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("envoy refused the stats: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp.Body, nil
+}
+
+// Rate returns the requests per second to a cluster between two scrapes taken interval apart. If
+// the count went down, Envoy must have replaced the cluster (which starts its stats over) in
+// between, so everything it has counted is new.
+func Rate(prev, cur *ClusterStats, interval time.Duration) float64 {
+	if cur == nil || interval <= 0 {
+		return 0
+	}
+	delta := cur.Requests
+	if prev != nil && prev.Requests <= cur.Requests {
+		delta = cur.Requests - prev.Requests
+	}
+	return float64(delta) / interval.Seconds()
+}
+
+// A Rollup adds up the traffic for a set of clusters.
+type Rollup struct {
+	// Clusters are the stat names of the clusters rolled up.
+	Clusters          []string          `json:"clusters"`
+	Requests          uint64            `json:"requests"`
+	RequestsPerSecond float64           `json:"requests_per_second"`
+	Responses         map[string]uint64 `json:"responses,omitempty"`
+	MeanLatencyMs     float64           `json:"mean_latency_ms"`
+	P50LatencyMs      float64           `json:"p50_latency_ms"`
+	P99LatencyMs      float64           `json:"p99_latency_ms"`
+
+	latency Histogram
+}
+
+// Add adds a cluster's stats, and its request rate, to the rollup. Adding a cluster that's already
+// in the rollup does nothing.
+func (r *Rollup) Add(cluster string, cs *ClusterStats, rate float64) {
+	for _, c := range r.Clusters {
+		if c == cluster {
+			return
+		}
+	}
+	r.Clusters = append(r.Clusters, cluster)
+	if cs == nil {
+		return
+	}
+	r.Requests += cs.Requests
+	r.RequestsPerSecond += rate
+	for class, count := range cs.Responses {
+		if r.Responses == nil {
+			r.Responses = make(map[string]uint64)
+		}
+		r.Responses[class] += count
+	}
+	r.latency.Add(cs.Latency)
+	r.MeanLatencyMs = r.latency.Mean()
+	r.P50LatencyMs = r.latency.Quantile(0.5)
+	r.P99LatencyMs = r.latency.Quantile(0.99)
+}
@@ -0,0 +1,95 @@
+package envoystats
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testStats = `# TYPE envoy_cluster_upstream_rq_total counter
+envoy_cluster_upstream_rq_total{envoy_cluster_name="quote"} 100
+envoy_cluster_upstream_rq_total{envoy_cluster_name="cluster_echo_other"} 10
+# TYPE envoy_cluster_upstream_rq_xx counter
+envoy_cluster_upstream_rq_xx{envoy_response_code_class="2",envoy_cluster_name="quote"} 90
+envoy_cluster_upstream_rq_xx{envoy_response_code_class="5",envoy_cluster_name="quote"} 10
+envoy_cluster_upstream_rq_xx{envoy_response_code_class="2",envoy_cluster_name="cluster_echo_other"} 10
+# TYPE envoy_cluster_upstream_rq_time histogram
+envoy_cluster_upstream_rq_time_bucket{envoy_cluster_name="quote",le="10"} 50
+envoy_cluster_upstream_rq_time_bucket{envoy_cluster_name="quote",le="100"} 100
+envoy_cluster_upstream_rq_time_bucket{envoy_cluster_name="quote",le="+Inf"} 100
+envoy_cluster_upstream_rq_time_sum{envoy_cluster_name="quote"} 2500
+envoy_cluster_upstream_rq_time_count{envoy_cluster_name="quote"} 100
+envoy_cluster_upstream_rq_time_bucket{envoy_cluster_name="cluster_echo_other",le="10"} 0
+envoy_cluster_upstream_rq_time_bucket{envoy_cluster_name="cluster_echo_other",le="100"} 0
+envoy_cluster_upstream_rq_time_bucket{envoy_cluster_name="cluster_echo_other",le="+Inf"} 10
+envoy_cluster_upstream_rq_time_sum{envoy_cluster_name="cluster_echo_other"} 3000
+envoy_cluster_upstream_rq_time_count{envoy_cluster_name="cluster_echo_other"} 10
+# TYPE envoy_cluster_upstream_rq_timeout counter
+envoy_cluster_upstream_rq_timeout{envoy_cluster_name="quote"} 3
+envoy_cluster_upstream_rq_total{envoy_cluster_name="escaped \"name\""} 1
+`
+
+func TestParse(t *testing.T) {
+	stats, err := Parse(strings.NewReader(testStats))
+	require.NoError(t, err)
+	require.Len(t, stats, 3)
+
+	quote := stats["quote"]
+	assert.Equal(t, uint64(100), quote.Requests)
+	assert.Equal(t, map[string]uint64{"2xx": 90, "5xx": 10}, quote.Responses)
+	assert.Equal(t, uint64(100), quote.Latency.Count)
+	assert.Equal(t, 25.0, quote.Latency.Mean())
+	assert.Equal(t, 10.0, quote.Latency.Quantile(0.5))
+	assert.InDelta(t, 98.2, quote.Latency.Quantile(0.99), 0.001)
+
+	assert.Equal(t, uint64(1), stats[`escaped "name"`].Requests)
+
+	_, err = Parse(strings.NewReader(`envoy_cluster_upstream_rq_total{envoy_cluster_name="oops} 1`))
+	assert.Error(t, err)
+}
+
+func TestRollup(t *testing.T) {
+	stats, err := Parse(strings.NewReader(testStats))
+	require.NoError(t, err)
+
+	var r Rollup
+	r.Add("quote", stats["quote"], 2)
+	r.Add("cluster_echo_other", stats["cluster_echo_other"], 0.5)
+	r.Add("quote", stats["quote"], 2)
+	r.Add("unused", nil, 0)
+
+	assert.Equal(t, []string{"quote", "cluster_echo_other", "unused"}, r.Clusters)
+	assert.Equal(t, uint64(110), r.Requests)
+	assert.Equal(t, 2.5, r.RequestsPerSecond)
+	assert.Equal(t, map[string]uint64{"2xx": 100, "5xx": 10}, r.Responses)
+	assert.InDelta(t, 50.0, r.MeanLatencyMs, 0.001)
+	// 100 of 110 requests took 100ms or less, so the 99th percentile is somewhere in +Inf.
+	assert.Equal(t, 100.0, r.P99LatencyMs)
+}
+
+func TestRate(t *testing.T) {
+	assert.Equal(t, 2.0, Rate(&ClusterStats{Requests: 100}, &ClusterStats{Requests: 160}, 30*time.Second))
+	// The cluster was replaced, so it started counting over.
+	assert.Equal(t, 1.0, Rate(&ClusterStats{Requests: 100}, &ClusterStats{Requests: 30}, 30*time.Second))
+	assert.Equal(t, 1.0, Rate(nil, &ClusterStats{Requests: 30}, 30*time.Second))
+	assert.Equal(t, 0.0, Rate(&ClusterStats{Requests: 100}, nil, 30*time.Second))
+}
+
+func TestScrape(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/stats/prometheus", r.URL.Path)
+		assert.Equal(t, `^cluster\..+\.upstream_rq_`, r.URL.Query().Get("filter"))
+		_, _ = w.Write([]byte(testStats))
+	}))
+	defer srv.Close()
+
+	stats, err := Scrape(context.Background(), srv.Client(), srv.URL+"/")
+	require.NoError(t, err)
+	assert.Len(t, stats, 3)
+}
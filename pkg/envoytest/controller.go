@@ -106,6 +106,9 @@ func (e *EnvoyController) Configure(ctx context.Context, node, version string, s
 	if len(snapshot.GetResources(ecp_v3_resource.ListenerType)) > 0 {
 		typeURLs = append(typeURLs, ecp_v3_resource.ListenerType)
 	}
+	if len(snapshot.GetResources(ecp_v3_resource.SecretType)) > 0 {
+		typeURLs = append(typeURLs, ecp_v3_resource.SecretType)
+	}
 
 	for _, t := range typeURLs {
 		status, err := e.waitFor(ctx, version, t)
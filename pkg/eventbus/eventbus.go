@@ -0,0 +1,306 @@
+// Package eventbus carries what happens inside the entrypoint (resources changing, snapshots
+// going out, subsystems getting into trouble) from the code that notices it to whatever else wants
+// to know, without either side knowing about the other. A publisher hands an Event to the Bus; the
+// Bus hands it to every Subscription that asked for that Kind of event.
+//
+// # Bounded buffers
+//
+// Publishing never blocks. The watcher publishes from the middle of processing a snapshot, and a
+// consumer that's slow (or stuck) mustn't hold that up. Each Subscription has a buffer of its own
+// size instead, and when it's full, the oldest event in it is dropped to make room for the new one,
+// and counted. The newest event is the one worth having: a SnapshotEvent carries the whole
+// snapshot, so a consumer that missed some only missed the ones in between.
+//
+// For the same reason, a new Subscription to snapshots starts with the newest SnapshotEvent there's
+// been, if there's been one, so that a consumer that starts (or restarts) after the watcher has
+// sent its first snapshot doesn't have to wait for something to change to get one.
+package eventbus
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Kind is the kind of an Event, which is what a Subscription asks for.
+type Kind string
+
+const (
+	KindResource Kind = "resource"
+	KindSnapshot Kind = "snapshot"
+	KindHealth   Kind = "health"
+)
+
+// An Event is something that happened.
+type Event interface {
+	EventKind() Kind
+}
+
+// ResourceChange is what happened to a resource.
+type ResourceChange string
+
+const (
+	ResourceAdded   ResourceChange = "added"
+	ResourceUpdated ResourceChange = "updated"
+	ResourceDeleted ResourceChange = "deleted"
+)
+
+// A ResourceEvent is a change to one of the resources the watcher watches.
+type ResourceEvent struct {
+	Change    ResourceChange
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+func (ResourceEvent) EventKind() Kind { return KindResource }
+
+// A SnapshotEvent is a snapshot going out to diagd, as the JSON that diagd gets.
+type SnapshotEvent struct {
+	Time     time.Time
+	Snapshot []byte
+}
+
+func (SnapshotEvent) EventKind() Kind { return KindSnapshot }
+
+// A HealthEvent is a subsystem going unhealthy, or being healthy again.
+type HealthEvent struct {
+	Subsystem string
+	Healthy   bool
+	Reason    string
+}
+
+func (HealthEvent) EventKind() Kind { return KindHealth }
+
+// DefaultBufferSize is the buffer size of a Subscription that doesn't ask for one.
+const DefaultBufferSize = 16
+
+// A Bus hands every Event that's published on it to every Subscription for its Kind. The zero
+// value isn't usable; use New. A nil *Bus drops everything, so publishers don't have to check.
+type Bus struct {
+	mu     sync.Mutex
+	subs   map[Kind][]*Subscription
+	closed bool
+
+	// lastSnapshot is the newest SnapshotEvent, for new Subscriptions.
+	lastSnapshot Event
+
+	// retired is how many events Subscriptions that have been closed dropped, by name, so that
+	// Dropped only ever goes up.
+	retired map[string]uint64
+}
+
+// New returns an empty Bus.
+func New() *Bus {
+	return &Bus{
+		subs:    map[Kind][]*Subscription{},
+		retired: map[string]uint64{},
+	}
+}
+
+// A Subscription is one consumer's buffer of events.
+type Subscription struct {
+	bus     *Bus
+	name    string
+	kinds   []Kind
+	ch      chan Event
+	dropped uint64
+	closed  bool // protected by bus.mu
+}
+
+// Subscribe returns a Subscription, called name, to events of the given kinds, with a buffer of
+// size events (DefaultBufferSize if size isn't positive). Subscribing to no kinds at all gets
+// every kind.
+func (b *Bus) Subscribe(name string, size int, kinds ...Kind) *Subscription {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	if len(kinds) == 0 {
+		kinds = []Kind{KindResource, KindSnapshot, KindHealth}
+	}
+	unique := kinds[:0:0]
+	for _, kind := range kinds {
+		dup := false
+		for _, u := range unique {
+			dup = dup || u == kind
+		}
+		if !dup {
+			unique = append(unique, kind)
+		}
+	}
+	kinds = unique
+	s := &Subscription{
+		bus:   b,
+		name:  name,
+		kinds: kinds,
+		ch:    make(chan Event, size),
+	}
+	if b == nil {
+		close(s.ch)
+		s.closed = true
+		return s
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(s.ch)
+		s.closed = true
+		return s
+	}
+	for _, kind := range kinds {
+		b.subs[kind] = append(b.subs[kind], s)
+		if kind == KindSnapshot && b.lastSnapshot != nil {
+			s.deliver(b.lastSnapshot)
+		}
+	}
+	return s
+}
+
+// Publish hands ev to every Subscription for its Kind, without waiting for any of them.
+func (b *Bus) Publish(ev Event) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	if ev.EventKind() == KindSnapshot {
+		b.lastSnapshot = ev
+	}
+	for _, s := range b.subs[ev.EventKind()] {
+		s.deliver(ev)
+	}
+}
+
+// deliver puts ev in the buffer, dropping the oldest event if the buffer is full. The bus lock is
+// held, so there's only ever one deliver at a time per Subscription; the consumer can only ever
+// make more room.
+func (s *Subscription) deliver(ev Event) {
+	for {
+		select {
+		case s.ch <- ev:
+			return
+		default:
+		}
+		select {
+		case <-s.ch:
+			atomic.AddUint64(&s.dropped, 1)
+		default:
+		}
+	}
+}
+
+// Close stops delivering events to every Subscription, and closes their channels.
+func (b *Bus) Close() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	seen := map[*Subscription]bool{}
+	for _, subs := range b.subs {
+		for _, s := range subs {
+			if !seen[s] {
+				seen[s] = true
+				s.closed = true
+				close(s.ch)
+			}
+		}
+	}
+	b.subs = nil
+}
+
+// Dropped returns how many events every Subscription has dropped, by name, including the ones
+// that have since been closed. Subscriptions that share a name are added up.
+func (b *Bus) Dropped() map[string]uint64 {
+	dropped := map[string]uint64{}
+	if b == nil {
+		return dropped
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for name, n := range b.retired {
+		dropped[name] = n
+	}
+	seen := map[*Subscription]bool{}
+	for _, subs := range b.subs {
+		for _, s := range subs {
+			if !seen[s] {
+				seen[s] = true
+				dropped[s.name] += s.Dropped()
+			}
+		}
+	}
+	return dropped
+}
+
+// Name returns the name the Subscription was made with.
+func (s *Subscription) Name() string {
+	return s.name
+}
+
+// Events returns the channel that the Subscription's events come in on. It's closed once the
+// Subscription (or the Bus) is.
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+// Dropped returns how many events the Subscription has dropped because its buffer was full.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close unsubscribes, and closes the Subscription's channel. Events that were already in the
+// buffer can still be read.
+func (s *Subscription) Close() {
+	b := s.bus
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	for _, kind := range s.kinds {
+		subs := b.subs[kind]
+		for i, other := range subs {
+			if other == s {
+				b.subs[kind] = append(subs[:i:i], subs[i+1:]...)
+				break
+			}
+		}
+	}
+	s.retire()
+}
+
+// retire closes the Subscription's channel, and keeps its count of dropped events. The bus lock
+// must be held.
+func (s *Subscription) retire() {
+	s.closed = true
+	close(s.ch)
+	s.bus.retired[s.name] += s.Dropped()
+}
+
+type busKey struct{}
+
+// WithBus returns a context that FromContext gets b from.
+func WithBus(ctx context.Context, b *Bus) context.Context {
+	return context.WithValue(ctx, busKey{}, b)
+}
+
+// FromContext returns the Bus of the context, or nil if it doesn't have one. Publishing on a nil
+// Bus does nothing, and subscribing to one gets a Subscription that's already closed.
+func FromContext(ctx context.Context) *Bus {
+	b, _ := ctx.Value(busKey{}).(*Bus)
+	return b
+}
@@ -0,0 +1,146 @@
+package eventbus_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/eventbus"
+)
+
+func drain(s *eventbus.Subscription) []eventbus.Event {
+	var events []eventbus.Event
+	for {
+		select {
+		case ev, ok := <-s.Events():
+			if !ok {
+				return events
+			}
+			events = append(events, ev)
+		default:
+			return events
+		}
+	}
+}
+
+func TestSubscribeByKind(t *testing.T) {
+	t.Parallel()
+	bus := eventbus.New()
+	resources := bus.Subscribe("resources", 4, eventbus.KindResource)
+	everything := bus.Subscribe("everything", 4)
+
+	added := eventbus.ResourceEvent{Change: eventbus.ResourceAdded, Kind: "Mapping", Namespace: "default", Name: "quote"}
+	unhealthy := eventbus.HealthEvent{Subsystem: "ambex", Reason: "panicked"}
+	bus.Publish(added)
+	bus.Publish(unhealthy)
+
+	assert.Equal(t, []eventbus.Event{added}, drain(resources))
+	assert.Equal(t, []eventbus.Event{added, unhealthy}, drain(everything))
+}
+
+func TestDropOldest(t *testing.T) {
+	t.Parallel()
+	bus := eventbus.New()
+	slow := bus.Subscribe("slow", 2, eventbus.KindSnapshot)
+	fast := bus.Subscribe("fast", 8, eventbus.KindSnapshot)
+
+	var published []eventbus.Event
+	for _, s := range []string{"1", "2", "3", "4", "5"} {
+		ev := eventbus.SnapshotEvent{Snapshot: []byte(s)}
+		published = append(published, ev)
+		bus.Publish(ev)
+	}
+
+	// Only the newest ones are left.
+	assert.Equal(t, published[3:], drain(slow))
+	assert.Equal(t, uint64(3), slow.Dropped())
+	assert.Equal(t, published, drain(fast))
+	assert.Equal(t, uint64(0), fast.Dropped())
+	assert.Equal(t, map[string]uint64{"slow": 3, "fast": 0}, bus.Dropped())
+}
+
+func TestSnapshotReplay(t *testing.T) {
+	t.Parallel()
+	bus := eventbus.New()
+	assert.Empty(t, drain(bus.Subscribe("early", 1, eventbus.KindSnapshot)))
+
+	bus.Publish(eventbus.SnapshotEvent{Snapshot: []byte("1")})
+	bus.Publish(eventbus.SnapshotEvent{Snapshot: []byte("2")})
+	bus.Publish(eventbus.HealthEvent{Subsystem: "a"})
+
+	// A late subscriber gets the newest snapshot, and nothing else from before it subscribed.
+	late := bus.Subscribe("late", 4)
+	assert.Equal(t, []eventbus.Event{eventbus.SnapshotEvent{Snapshot: []byte("2")}}, drain(late))
+}
+
+func TestClose(t *testing.T) {
+	t.Parallel()
+	bus := eventbus.New()
+	sub := bus.Subscribe("sub", 1, eventbus.KindHealth, eventbus.KindHealth)
+	bus.Publish(eventbus.HealthEvent{Subsystem: "a"})
+	bus.Publish(eventbus.HealthEvent{Subsystem: "b"})
+	sub.Close()
+	sub.Close()
+
+	// What was buffered can still be read, and then the channel is closed.
+	ev, ok := <-sub.Events()
+	require.True(t, ok)
+	assert.Equal(t, eventbus.HealthEvent{Subsystem: "b"}, ev)
+	_, ok = <-sub.Events()
+	assert.False(t, ok)
+
+	// A closed Subscription gets nothing more, but what it dropped still counts.
+	bus.Publish(eventbus.HealthEvent{Subsystem: "c"})
+	assert.Equal(t, map[string]uint64{"sub": 1}, bus.Dropped())
+
+	other := bus.Subscribe("other", 1)
+	bus.Close()
+	_, ok = <-other.Events()
+	assert.False(t, ok)
+	bus.Publish(eventbus.HealthEvent{Subsystem: "d"})
+	_, ok = <-bus.Subscribe("late", 1).Events()
+	assert.False(t, ok)
+}
+
+func TestNilBus(t *testing.T) {
+	t.Parallel()
+	bus := eventbus.FromContext(context.Background())
+	require.Nil(t, bus)
+
+	bus.Publish(eventbus.HealthEvent{Subsystem: "a"})
+	sub := bus.Subscribe("sub", 1)
+	_, ok := <-sub.Events()
+	assert.False(t, ok)
+	sub.Close()
+	assert.Empty(t, bus.Dropped())
+
+	bus = eventbus.New()
+	assert.Same(t, bus, eventbus.FromContext(eventbus.WithBus(context.Background(), bus)))
+}
+
+func TestConcurrentConsumer(t *testing.T) {
+	t.Parallel()
+	bus := eventbus.New()
+	sub := bus.Subscribe("sub", 3, eventbus.KindResource)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	received := 0
+	go func() {
+		defer wg.Done()
+		for range sub.Events() {
+			received++
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		bus.Publish(eventbus.ResourceEvent{Change: eventbus.ResourceUpdated, Kind: "Host"})
+	}
+	bus.Close()
+	wg.Wait()
+
+	// Every event was either received or dropped, and publishing never waited for the consumer.
+	assert.Equal(t, uint64(1000), uint64(received)+sub.Dropped())
+}
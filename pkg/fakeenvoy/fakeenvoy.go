@@ -0,0 +1,482 @@
+// Package fakeenvoy is an in-memory stand-in for Envoy, for integration tests and local
+// development: it gets its configuration over ADS like Envoy does, ACKs or NACKs every update
+// according to its Rules, and serves /ready and /stats the way Envoy's admin interface does, so
+// that the whole control plane (ambex, the EnvoyWatcher, diagd) can run without an Envoy binary.
+//
+// It doesn't proxy anything. What it knows about the configuration is what the Rules check: by
+// default, that every resource unmarshals and passes its proto validation. That catches a lot of
+// what real Envoy NACKs, but nowhere near all of it, so a configuration that the FakeEnvoy accepts
+// can still be one that Envoy rejects.
+package fakeenvoy
+
+import (
+	// standard library
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	// third-party libraries
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	// envoy api v3
+	_ "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/cluster/v3"
+	v3core "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/core/v3"
+	_ "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/endpoint/v3"
+	_ "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/listener/v3"
+	_ "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/route/v3"
+	v3discovery "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/service/discovery/v3"
+
+	// envoy control plane
+	ecp_v3_cache "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/cache/v3"
+	ecp_v3_resource "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/resource/v3"
+
+	// first-party-libraries
+	"github.com/datawire/dlib/dgroup"
+	"github.com/datawire/dlib/dhttp"
+	"github.com/datawire/dlib/dlog"
+)
+
+// A Rule decides whether the FakeEnvoy accepts an update: it's given every resource of one type
+// that the update has, and returns an error to NACK it with, or nil to let it through. An update
+// is ACKed only if every Rule lets it through.
+type Rule func(typeURL string, resources []proto.Message) error
+
+// Validate is the Rule that NACKs any resource that fails its proto validation.
+func Validate(typeURL string, resources []proto.Message) error {
+	for _, res := range resources {
+		if v, ok := res.(interface{ ValidateAll() error }); ok {
+			if err := v.ValidateAll(); err != nil {
+				return fmt.Errorf("%s %q: %w", typeName(typeURL), resourceName(res), err)
+			}
+		}
+	}
+	return nil
+}
+
+// RejectNamed returns a Rule that NACKs any update with a resource of the type called name. It's
+// for tests that need a NACK on cue.
+func RejectNamed(typeURL, name string) Rule {
+	return func(t string, resources []proto.Message) error {
+		if t != typeURL {
+			return nil
+		}
+		for _, res := range resources {
+			if resourceName(res) == name {
+				return fmt.Errorf("%s %q rejected", typeName(typeURL), name)
+			}
+		}
+		return nil
+	}
+}
+
+// Config is how a FakeEnvoy is set up. Only ADSAddress is required.
+type Config struct {
+	// ADSAddress is the host:port of the ADS server to get configuration from.
+	ADSAddress string
+	// TLS, if not nil, is the client configuration for ADS over TLS.
+	TLS *tls.Config
+	// NodeID is the node ID to ask for configuration for. The default is "test-id", which is
+	// what ambex serves.
+	NodeID string
+
+	// ReadyAddress, if not empty, is the host:port to serve /ready and /stats on.
+	ReadyAddress string
+
+	// TypeURLs are the resource types to subscribe to. The default is clusters, endpoints,
+	// listeners, and routes.
+	TypeURLs []string
+	// Rules decide which updates to accept. The default is just Validate.
+	Rules []Rule
+
+	// RetryInterval is how long to wait before connecting again when the ADS stream fails. The
+	// default is a second.
+	RetryInterval time.Duration
+}
+
+// The FakeEnvoy struct is a fake Envoy. Make one with New, and Run it.
+type FakeEnvoy struct {
+	cfg Config
+
+	mutex     sync.Mutex // protects everything below
+	connected bool
+	accepted  map[string]*typeState // keyed by typeURL
+	changed   chan struct{}
+}
+
+// typeState is what a FakeEnvoy has accepted of one type, and how its updates have gone.
+type typeState struct {
+	version   string
+	resources map[string]proto.Message // keyed by name
+	attempts  int
+	successes int
+	rejects   int
+	lastError string
+}
+
+// New returns a FakeEnvoy with the configuration filled in with defaults.
+func New(cfg Config) *FakeEnvoy {
+	if cfg.NodeID == "" {
+		cfg.NodeID = "test-id"
+	}
+	if len(cfg.TypeURLs) == 0 {
+		cfg.TypeURLs = []string{
+			ecp_v3_resource.ClusterType,
+			ecp_v3_resource.EndpointType,
+			ecp_v3_resource.ListenerType,
+			ecp_v3_resource.RouteType,
+		}
+	}
+	if cfg.Rules == nil {
+		cfg.Rules = []Rule{Validate}
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = time.Second
+	}
+
+	accepted := make(map[string]*typeState, len(cfg.TypeURLs))
+	for _, typeURL := range cfg.TypeURLs {
+		accepted[typeURL] = &typeState{resources: map[string]proto.Message{}}
+	}
+	return &FakeEnvoy{
+		cfg:      cfg,
+		accepted: accepted,
+		changed:  make(chan struct{}),
+	}
+}
+
+// Run gets configuration from the ADS server, and serves /ready and /stats if there's a
+// ReadyAddress, until the context is canceled. Losing the ADS stream isn't an error: like Envoy,
+// the FakeEnvoy keeps what it has, and connects again.
+func (e *FakeEnvoy) Run(ctx context.Context) error {
+	grp := dgroup.NewGroup(ctx, dgroup.GroupConfig{})
+
+	if e.cfg.ReadyAddress != "" {
+		grp.Go("admin", func(ctx context.Context) error {
+			sc := &dhttp.ServerConfig{
+				Handler: e,
+			}
+			return sc.ListenAndServe(ctx, e.cfg.ReadyAddress)
+		})
+	}
+
+	grp.Go("ads", func(ctx context.Context) error {
+		creds := insecure.NewCredentials()
+		if e.cfg.TLS != nil {
+			creds = credentials.NewTLS(e.cfg.TLS)
+		}
+		conn, err := grpc.DialContext(ctx, e.cfg.ADSAddress, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		client := v3discovery.NewAggregatedDiscoveryServiceClient(conn)
+
+		for {
+			err := e.stream(ctx, client)
+			e.setConnected(false)
+			if ctx.Err() != nil {
+				return nil
+			}
+			dlog.Infof(ctx, "ADS stream to %s lost (%v); reconnecting in %v", e.cfg.ADSAddress, err, e.cfg.RetryInterval)
+			select {
+			case <-time.After(e.cfg.RetryInterval):
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	})
+
+	return grp.Wait()
+}
+
+// stream runs one ADS stream: it subscribes to every type, and answers every response with an ACK
+// or a NACK, until the stream fails.
+func (e *FakeEnvoy) stream(ctx context.Context, client v3discovery.AggregatedDiscoveryServiceClient) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := client.StreamAggregatedResources(ctx)
+	if err != nil {
+		return err
+	}
+
+	node := &v3core.Node{Id: e.cfg.NodeID}
+	for _, typeURL := range e.cfg.TypeURLs {
+		// Like Envoy, say which version we already have, so that a reconnect doesn't resend it.
+		err := stream.Send(&v3discovery.DiscoveryRequest{
+			Node:        node,
+			TypeUrl:     typeURL,
+			VersionInfo: e.Version(typeURL),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	e.setConnected(true)
+
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+
+		req := e.update(ctx, resp)
+		req.Node = node
+		if err := stream.Send(req); err != nil {
+			return err
+		}
+	}
+}
+
+// update applies a response, if the Rules let it through, and returns the ACK or NACK for it.
+func (e *FakeEnvoy) update(ctx context.Context, resp *v3discovery.DiscoveryResponse) *v3discovery.DiscoveryRequest {
+	typeURL := resp.GetTypeUrl()
+
+	resources, err := unmarshal(resp.GetResources())
+	if err == nil {
+		err = e.check(typeURL, resources)
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	defer e.notify()
+
+	state, ok := e.accepted[typeURL]
+	if !ok {
+		state = &typeState{resources: map[string]proto.Message{}}
+		e.accepted[typeURL] = state
+	}
+	state.attempts++
+
+	if err != nil {
+		dlog.Infof(ctx, "NACK %s version %s: %v", typeName(typeURL), resp.GetVersionInfo(), err)
+		state.rejects++
+		state.lastError = err.Error()
+		return &v3discovery.DiscoveryRequest{
+			TypeUrl:       typeURL,
+			VersionInfo:   state.version,
+			ResponseNonce: resp.GetNonce(),
+			ErrorDetail: &status.Status{
+				Code:    int32(codes.InvalidArgument),
+				Message: err.Error(),
+			},
+		}
+	}
+
+	dlog.Debugf(ctx, "ACK %s version %s: %d resources", typeName(typeURL), resp.GetVersionInfo(), len(resources))
+	state.successes++
+	state.lastError = ""
+	state.version = resp.GetVersionInfo()
+	state.resources = make(map[string]proto.Message, len(resources))
+	for _, res := range resources {
+		state.resources[resourceName(res)] = res
+	}
+	return &v3discovery.DiscoveryRequest{
+		TypeUrl:       typeURL,
+		VersionInfo:   resp.GetVersionInfo(),
+		ResponseNonce: resp.GetNonce(),
+	}
+}
+
+func (e *FakeEnvoy) check(typeURL string, resources []proto.Message) error {
+	for _, rule := range e.cfg.Rules {
+		if err := rule(typeURL, resources); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unmarshal(anys []*anypb.Any) ([]proto.Message, error) {
+	resources := make([]proto.Message, 0, len(anys))
+	for _, a := range anys {
+		res, err := a.UnmarshalNew()
+		if err != nil {
+			return nil, fmt.Errorf("unable to unmarshal %s: %w", a.GetTypeUrl(), err)
+		}
+		resources = append(resources, res)
+	}
+	return resources, nil
+}
+
+// notify wakes up everything waiting on Changed. It must be called with the mutex held.
+func (e *FakeEnvoy) notify() {
+	close(e.changed)
+	e.changed = make(chan struct{})
+}
+
+func (e *FakeEnvoy) setConnected(connected bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.connected != connected {
+		e.connected = connected
+		e.notify()
+	}
+}
+
+// Changed returns a channel that's closed the next time the FakeEnvoy ACKs or NACKs an update, or
+// connects or disconnects.
+func (e *FakeEnvoy) Changed() <-chan struct{} {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.changed
+}
+
+// Version returns the version of the type that the FakeEnvoy last accepted, or "" if it hasn't
+// accepted any.
+func (e *FakeEnvoy) Version(typeURL string) string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if state, ok := e.accepted[typeURL]; ok {
+		return state.version
+	}
+	return ""
+}
+
+// Resources returns the resources of the type that the FakeEnvoy last accepted, by name. The
+// resources are shared with the FakeEnvoy, so don't change them.
+func (e *FakeEnvoy) Resources(typeURL string) map[string]proto.Message {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	state, ok := e.accepted[typeURL]
+	if !ok {
+		return nil
+	}
+	resources := make(map[string]proto.Message, len(state.resources))
+	for name, res := range state.resources {
+		resources[name] = res
+	}
+	return resources
+}
+
+// Errors returns why the last update of each type was NACKed, for the types whose last update was.
+func (e *FakeEnvoy) Errors() map[string]error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	errs := map[string]error{}
+	for typeURL, state := range e.accepted {
+		if state.lastError != "" {
+			errs[typeURL] = errors.New(state.lastError)
+		}
+	}
+	return errs
+}
+
+// IsReady returns true once the FakeEnvoy has accepted clusters and listeners, which is when Envoy
+// finishes initializing.
+func (e *FakeEnvoy) IsReady() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.isReady()
+}
+
+// isReady must be called with the mutex held.
+func (e *FakeEnvoy) isReady() bool {
+	for _, typeURL := range []string{ecp_v3_resource.ClusterType, ecp_v3_resource.ListenerType} {
+		if state, ok := e.accepted[typeURL]; ok && state.successes == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// ServeHTTP serves the parts of Envoy's admin interface that Ambassador uses: /ready, which says
+// LIVE once the FakeEnvoy is ready and PRE_INITIALIZING until then, and /stats.
+func (e *FakeEnvoy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/ready":
+		w.Header().Set("Content-Type", "text/plain")
+		if !e.IsReady() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, "PRE_INITIALIZING")
+			return
+		}
+		fmt.Fprintln(w, "LIVE")
+	case "/stats":
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(e.Stats()))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// statPrefixes are the Envoy stats that the xDS update counts of each type show up as.
+var statPrefixes = map[string]string{
+	ecp_v3_resource.ClusterType:  "cluster_manager.cds",
+	ecp_v3_resource.EndpointType: "cluster_manager.eds",
+	ecp_v3_resource.ListenerType: "listener_manager.lds",
+	ecp_v3_resource.RouteType:    "http.rds",
+	ecp_v3_resource.SecretType:   "sds",
+}
+
+// Stats returns stats in the format of Envoy's /stats: one "name: value" per line, sorted by name.
+func (e *FakeEnvoy) Stats() string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	stats := map[string]string{
+		"control_plane.connected_state": boolStat(e.connected),
+		// Envoy's server.state is 0 for LIVE and 2 for PRE_INITIALIZING.
+		"server.state": "2",
+	}
+	if e.isReady() {
+		stats["server.state"] = "0"
+	}
+	for typeURL, state := range e.accepted {
+		prefix, ok := statPrefixes[typeURL]
+		if !ok {
+			prefix = "xds." + typeName(typeURL)
+		}
+		stats[prefix+".update_attempt"] = fmt.Sprint(state.attempts)
+		stats[prefix+".update_success"] = fmt.Sprint(state.successes)
+		stats[prefix+".update_rejected"] = fmt.Sprint(state.rejects)
+		stats[prefix+".version_text"] = fmt.Sprintf("%q", state.version)
+	}
+	if state, ok := e.accepted[ecp_v3_resource.ClusterType]; ok {
+		stats["cluster_manager.active_clusters"] = fmt.Sprint(len(state.resources))
+	}
+	if state, ok := e.accepted[ecp_v3_resource.ListenerType]; ok {
+		stats["listener_manager.total_listeners_active"] = fmt.Sprint(len(state.resources))
+	}
+
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var out strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&out, "%s: %s\n", name, stats[name])
+	}
+	return out.String()
+}
+
+func boolStat(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// typeName returns the short name of a type, e.g. "Cluster".
+func typeName(typeURL string) string {
+	return typeURL[strings.LastIndex(typeURL, ".")+1:]
+}
+
+// resourceName returns the name of a resource, the way go-control-plane names it.
+func resourceName(res proto.Message) string {
+	return ecp_v3_cache.GetResourceName(res)
+}
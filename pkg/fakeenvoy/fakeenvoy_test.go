@@ -0,0 +1,123 @@
+package fakeenvoy_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/datawire/dlib/dgroup"
+	"github.com/datawire/dlib/dlog"
+
+	v3cluster "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/cluster/v3"
+	v3listener "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/listener/v3"
+	ecp_cache_types "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/cache/types"
+	ecp_v3_cache "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/cache/v3"
+	ecp_v3_resource "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/resource/v3"
+	"github.com/emissary-ingress/emissary/v3/pkg/envoytest"
+	"github.com/emissary-ingress/emissary/v3/pkg/fakeenvoy"
+)
+
+func freeAddress(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func snapshot(t *testing.T, version string, clusters ...string) ecp_v3_cache.ResourceSnapshot {
+	t.Helper()
+	var resources []ecp_cache_types.Resource
+	for _, name := range clusters {
+		resources = append(resources, &v3cluster.Cluster{Name: name})
+	}
+	snap, err := ecp_v3_cache.NewSnapshot(version, map[ecp_v3_resource.Type][]ecp_cache_types.Resource{
+		ecp_v3_resource.ClusterType:  resources,
+		ecp_v3_resource.ListenerType: {&v3listener.Listener{Name: "listener"}},
+	})
+	require.NoError(t, err)
+	return snap
+}
+
+func get(t *testing.T, fe *fakeenvoy.FakeEnvoy, path string) (int, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	fe.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w.Code, w.Body.String()
+}
+
+func TestFakeEnvoy(t *testing.T) {
+	ctx, cancel := context.WithTimeout(dlog.NewTestContext(t, false), time.Minute)
+	defer cancel()
+
+	address := freeAddress(t)
+	controller := envoytest.NewEnvoyController(address)
+	fe := fakeenvoy.New(fakeenvoy.Config{
+		ADSAddress:    address,
+		Rules:         []fakeenvoy.Rule{fakeenvoy.Validate, fakeenvoy.RejectNamed(ecp_v3_resource.ClusterType, "bad")},
+		RetryInterval: 10 * time.Millisecond,
+	})
+
+	grp := dgroup.NewGroup(ctx, dgroup.GroupConfig{ShutdownOnNonError: true})
+	grp.Go("controller", controller.Run)
+	grp.Go("fakeenvoy", fe.Run)
+	defer func() {
+		cancel()
+		// The controller's server says "context canceled" when it shuts down.
+		_ = grp.Wait()
+	}()
+
+	code, body := get(t, fe, "/ready")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "PRE_INITIALIZING\n", body)
+
+	// A good snapshot is ACKed...
+	status, err := controller.Configure(ctx, "test-id", "v1", snapshot(t, "v1", "good"))
+	require.NoError(t, err)
+	assert.Nil(t, status)
+	assert.True(t, fe.IsReady())
+	assert.Equal(t, "v1", fe.Version(ecp_v3_resource.ClusterType))
+	assert.Contains(t, fe.Resources(ecp_v3_resource.ClusterType), "good")
+
+	code, body = get(t, fe, "/ready")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "LIVE\n", body)
+
+	// ...and a bad one is NACKed, with the error from the rule, and the good one stays.
+	status, err = controller.Configure(ctx, "test-id", "v2", snapshot(t, "v2", "good", "bad"))
+	require.NoError(t, err)
+	require.NotNil(t, status)
+	assert.Equal(t, `Cluster "bad" rejected`, status.Message)
+	assert.Equal(t, "v1", fe.Version(ecp_v3_resource.ClusterType))
+	assert.NotContains(t, fe.Resources(ecp_v3_resource.ClusterType), "bad")
+	assert.Contains(t, fe.Errors(), ecp_v3_resource.ClusterType)
+
+	code, body = get(t, fe, "/stats")
+	assert.Equal(t, http.StatusOK, code)
+	stats := strings.Split(body, "\n")
+	assert.Contains(t, stats, "cluster_manager.cds.update_success: 1")
+	assert.Contains(t, stats, "cluster_manager.cds.update_rejected: 1")
+	assert.Contains(t, stats, `cluster_manager.cds.version_text: "v1"`)
+	assert.Contains(t, stats, "cluster_manager.active_clusters: 1")
+	assert.Contains(t, stats, "control_plane.connected_state: 1")
+	assert.Contains(t, stats, "server.state: 0")
+}
+
+func TestValidate(t *testing.T) {
+	good := &v3cluster.Cluster{Name: "good", ConnectTimeout: durationpb.New(time.Second)}
+	assert.NoError(t, fakeenvoy.Validate(ecp_v3_resource.ClusterType, []proto.Message{good}))
+
+	bad := &v3cluster.Cluster{Name: "bad", ConnectTimeout: durationpb.New(-time.Second)}
+	err := fakeenvoy.Validate(ecp_v3_resource.ClusterType, []proto.Message{good, bad})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `Cluster "bad": `)
+}
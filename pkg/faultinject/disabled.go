@@ -0,0 +1,19 @@
+//go:build !faultinject
+
+package faultinject
+
+import (
+	"context"
+)
+
+// Enabled is whether the binary was built with the faultinject build tag. It wasn't.
+const Enabled = false
+
+// DropWatchEvent returns whether to drop a watch notification. It never does.
+func DropWatchEvent(context.Context) bool { return false }
+
+// DelaySnapshot holds up a snapshot hand-off. It doesn't.
+func DelaySnapshot(context.Context) {}
+
+// FailXDSPush returns whether to fail an xDS push. It never does.
+func FailXDSPush(context.Context) bool { return false }
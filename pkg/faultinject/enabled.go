@@ -0,0 +1,91 @@
+//go:build faultinject
+
+package faultinject
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+// Enabled is whether the binary was built with the faultinject build tag. It was.
+const Enabled = true
+
+var (
+	configOnce sync.Once
+	config     Config
+
+	rollMu sync.Mutex
+	rolls  = rand.New(rand.NewSource(time.Now().UnixNano())) //nolint:gosec // not for anything secret
+)
+
+// getConfig reads the Config from the environment the first time it's needed, and logs what it
+// found.
+func getConfig(ctx context.Context) Config {
+	configOnce.Do(func() {
+		var errs []error
+		config, errs = ParseConfig(os.Getenv)
+		for _, err := range errs {
+			dlog.Errorf(ctx, "FAULT INJECTION: ignoring %v", err)
+		}
+		if config.Any() {
+			dlog.Warnf(ctx, "FAULT INJECTION: %v", config)
+		}
+	})
+	return config
+}
+
+// roll returns a random number in [0, 1).
+func roll() float64 {
+	rollMu.Lock()
+	defer rollMu.Unlock()
+	return rolls.Float64()
+}
+
+// hit returns whether a roll lands within percent.
+func hit(percent float64) bool {
+	return percent > 0 && roll()*100 < percent
+}
+
+// DropWatchEvent returns whether to drop a watch notification, per
+// AMBASSADOR_FAULT_WATCH_DROP_PERCENT.
+func DropWatchEvent(ctx context.Context) bool {
+	if !hit(getConfig(ctx).WatchDropPercent) {
+		return false
+	}
+	dlog.Warnf(ctx, "FAULT INJECTION: dropping a watch notification")
+	return true
+}
+
+// DelaySnapshot holds up a snapshot hand-off, per AMBASSADOR_FAULT_SNAPSHOT_DELAY_MS and
+// AMBASSADOR_FAULT_SNAPSHOT_DELAY_JITTER_MS, or until ctx is done.
+func DelaySnapshot(ctx context.Context) {
+	cfg := getConfig(ctx)
+	delay := cfg.SnapshotDelay
+	if cfg.SnapshotDelayJitter > 0 {
+		delay += time.Duration(roll() * float64(cfg.SnapshotDelayJitter))
+	}
+	if delay <= 0 {
+		return
+	}
+	dlog.Warnf(ctx, "FAULT INJECTION: holding up the snapshot hand-off for %v", delay)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// FailXDSPush returns whether to fail an xDS push, per AMBASSADOR_FAULT_XDS_FAIL_PERCENT.
+func FailXDSPush(ctx context.Context) bool {
+	if !hit(getConfig(ctx).XDSFailPercent) {
+		return false
+	}
+	dlog.Warnf(ctx, "FAULT INJECTION: failing an xDS push")
+	return true
+}
@@ -0,0 +1,50 @@
+//go:build faultinject
+
+package faultinject
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+func TestEnabled(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	configOnce.Do(func() {})
+	defer func() { config = Config{} }()
+
+	// Nothing is injected until it's configured.
+	config = Config{}
+	for i := 0; i < 100; i++ {
+		assert.False(t, DropWatchEvent(ctx))
+		assert.False(t, FailXDSPush(ctx))
+	}
+
+	config = Config{WatchDropPercent: 100, XDSFailPercent: 100}
+	assert.True(t, DropWatchEvent(ctx))
+	assert.True(t, FailXDSPush(ctx))
+
+	config = Config{XDSFailPercent: 50}
+	failed := 0
+	for i := 0; i < 1000; i++ {
+		if FailXDSPush(ctx) {
+			failed++
+		}
+	}
+	assert.InDelta(t, 500, failed, 100)
+
+	config = Config{SnapshotDelay: 20 * time.Millisecond, SnapshotDelayJitter: 10 * time.Millisecond}
+	start := time.Now()
+	DelaySnapshot(ctx)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+	// A delay doesn't outlast the context.
+	config = Config{SnapshotDelay: time.Hour}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	DelaySnapshot(cancelled)
+}
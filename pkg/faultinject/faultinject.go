@@ -0,0 +1,86 @@
+// Package faultinject breaks the control plane on purpose, so that CI and soak environments find
+// out how the pipeline from the Kubernetes watch to Envoy copes with lost watch events, slow
+// snapshot hand-offs, and failed xDS pushes, before production does.
+//
+// None of it is there unless the binary is built with the faultinject build tag (as with
+// `make images GO_BUILD_TAGS=faultinject`). Without the tag, Enabled is false and every function
+// here does nothing, so a release build can't be talked into breaking itself by its environment.
+// With it, the faults are set up with environment variables, and all of them are off by default:
+//
+//   - AMBASSADOR_FAULT_WATCH_DROP_PERCENT drops that percentage of the watcher's notifications of
+//     Kubernetes changes. A dropped change is picked up with the next one, as happens when a
+//     watch event goes missing.
+//   - AMBASSADOR_FAULT_SNAPSHOT_DELAY_MS holds up every snapshot hand-off to diagd for that many
+//     milliseconds (and AMBASSADOR_FAULT_SNAPSHOT_DELAY_JITTER_MS adds up to that many more).
+//   - AMBASSADOR_FAULT_XDS_FAIL_PERCENT fails that percentage of ambex's pushes to Envoy. A failed
+//     push is tried again on the next tick of the updater, like one that was rate limited.
+//
+// Every fault injected is logged at warning level, starting with "FAULT INJECTION:".
+package faultinject
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Config is which faults to inject, and how often.
+type Config struct {
+	// WatchDropPercent is the percentage of watch notifications to drop.
+	WatchDropPercent float64
+	// SnapshotDelay is how long to hold up every snapshot hand-off.
+	SnapshotDelay time.Duration
+	// SnapshotDelayJitter is the most to add to SnapshotDelay, at random.
+	SnapshotDelayJitter time.Duration
+	// XDSFailPercent is the percentage of xDS pushes to fail.
+	XDSFailPercent float64
+}
+
+// ParseConfig reads a Config from the environment, through getenv. Settings that don't parse are
+// left off, and returned as errors.
+func ParseConfig(getenv func(string) string) (Config, []error) {
+	var cfg Config
+	var errs []error
+
+	percent := func(name string) float64 {
+		s := getenv(name)
+		if s == "" {
+			return 0
+		}
+		p, err := strconv.ParseFloat(s, 64)
+		if err != nil || p < 0 || p > 100 {
+			errs = append(errs, fmt.Errorf("%s=%q: must be a percentage from 0 to 100", name, s))
+			return 0
+		}
+		return p
+	}
+	millis := func(name string) time.Duration {
+		s := getenv(name)
+		if s == "" {
+			return 0
+		}
+		ms, err := strconv.Atoi(s)
+		if err != nil || ms < 0 {
+			errs = append(errs, fmt.Errorf("%s=%q: must be a whole number of milliseconds", name, s))
+			return 0
+		}
+		return time.Duration(ms) * time.Millisecond
+	}
+
+	cfg.WatchDropPercent = percent("AMBASSADOR_FAULT_WATCH_DROP_PERCENT")
+	cfg.SnapshotDelay = millis("AMBASSADOR_FAULT_SNAPSHOT_DELAY_MS")
+	cfg.SnapshotDelayJitter = millis("AMBASSADOR_FAULT_SNAPSHOT_DELAY_JITTER_MS")
+	cfg.XDSFailPercent = percent("AMBASSADOR_FAULT_XDS_FAIL_PERCENT")
+	return cfg, errs
+}
+
+// Any returns whether cfg injects any faults at all.
+func (cfg Config) Any() bool {
+	return cfg.WatchDropPercent > 0 || cfg.SnapshotDelay > 0 || cfg.SnapshotDelayJitter > 0 || cfg.XDSFailPercent > 0
+}
+
+// String describes cfg, for the logs.
+func (cfg Config) String() string {
+	return fmt.Sprintf("watch drop %g%%, snapshot delay %v (+%v jitter), xDS fail %g%%",
+		cfg.WatchDropPercent, cfg.SnapshotDelay, cfg.SnapshotDelayJitter, cfg.XDSFailPercent)
+}
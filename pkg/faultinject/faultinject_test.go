@@ -0,0 +1,46 @@
+package faultinject_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/faultinject"
+)
+
+func TestParseConfig(t *testing.T) {
+	t.Parallel()
+	getenv := func(env map[string]string) func(string) string {
+		return func(name string) string { return env[name] }
+	}
+
+	cfg, errs := faultinject.ParseConfig(getenv(nil))
+	assert.Empty(t, errs)
+	assert.False(t, cfg.Any())
+
+	cfg, errs = faultinject.ParseConfig(getenv(map[string]string{
+		"AMBASSADOR_FAULT_WATCH_DROP_PERCENT":       "12.5",
+		"AMBASSADOR_FAULT_SNAPSHOT_DELAY_MS":        "1500",
+		"AMBASSADOR_FAULT_SNAPSHOT_DELAY_JITTER_MS": "250",
+		"AMBASSADOR_FAULT_XDS_FAIL_PERCENT":         "100",
+	}))
+	assert.Empty(t, errs)
+	assert.True(t, cfg.Any())
+	assert.Equal(t, faultinject.Config{
+		WatchDropPercent:    12.5,
+		SnapshotDelay:       1500 * time.Millisecond,
+		SnapshotDelayJitter: 250 * time.Millisecond,
+		XDSFailPercent:      100,
+	}, cfg)
+	assert.Equal(t, "watch drop 12.5%, snapshot delay 1.5s (+250ms jitter), xDS fail 100%", cfg.String())
+
+	// Settings that don't parse are left off.
+	cfg, errs = faultinject.ParseConfig(getenv(map[string]string{
+		"AMBASSADOR_FAULT_WATCH_DROP_PERCENT": "150",
+		"AMBASSADOR_FAULT_SNAPSHOT_DELAY_MS":  "2s",
+		"AMBASSADOR_FAULT_XDS_FAIL_PERCENT":   "5",
+	}))
+	assert.Len(t, errs, 2)
+	assert.Equal(t, faultinject.Config{XDSFailPercent: 5}, cfg)
+}
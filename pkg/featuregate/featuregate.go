@@ -0,0 +1,215 @@
+// Package featuregate lets risky changes to how we process snapshots ship dark, and then be turned
+// on (or back off) per deployment, instead of being all-or-nothing per release.
+//
+// Every gate has a name, a default, and a stage. A deployment overrides the defaults with files in
+// a directory (normally a mounted ConfigMap, so each key is a gate name and each value is "true" or
+// "false"), and then with the AMBASSADOR_FEATURE_GATES environment variable, which is a
+// comma-separated list of Name=bool pairs, like Kubernetes' --feature-gates. The environment wins.
+//
+// To add a gate, add a constant and an entry in knownGates, then check it with
+// FromContext(ctx).Enabled(...). Once a gate has been GA for a release, delete it and its checks.
+package featuregate
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Gate is the name of a feature gate.
+type Gate string
+
+// Stage is how far along a gated feature is.
+type Stage string
+
+const (
+	// Alpha features are off by default, and may be broken.
+	Alpha Stage = "Alpha"
+	// Beta features are on by default, but can still be turned off if they cause trouble.
+	Beta Stage = "Beta"
+	// GA features are on, and the gate is only there so that it can be removed gracefully.
+	GA Stage = "GA"
+)
+
+const (
+	// DeltaXDS has Envoy use incremental xDS with ambex, so that a change to one resource doesn't
+	// send every resource of that type.
+	DeltaXDS Gate = "DeltaXDS"
+	// TrafficCapture puts an Envoy tap filter on every HTTP listener, so that request/response
+	// pairs for a Mapping can be captured through the admin port; see pkg/capture.
+	TrafficCapture Gate = "TrafficCapture"
+)
+
+// Spec describes a gate.
+type Spec struct {
+	Default     bool   `json:"default"`
+	Stage       Stage  `json:"stage"`
+	Description string `json:"description"`
+}
+
+var knownGates = map[Gate]Spec{
+	DeltaXDS: {
+		Default:     false,
+		Stage:       Alpha,
+		Description: "Use incremental (delta) xDS between Envoy and ambex",
+	},
+	TrafficCapture: {
+		Default:     false,
+		Stage:       Alpha,
+		Description: "Allow capturing a Mapping's requests and responses with Envoy tap filters",
+	},
+}
+
+// Where a gate's setting came from.
+const (
+	SourceDefault   = "default"
+	SourceConfigMap = "configmap"
+	SourceEnv       = "env"
+)
+
+// Status is the state of a single gate, as reported by the version endpoint.
+type Status struct {
+	Name    Gate   `json:"name"`
+	Enabled bool   `json:"enabled"`
+	Source  string `json:"source"`
+	Spec
+}
+
+// Gates is the set of feature gates in effect for this process. The zero value is not useful; use
+// Defaults or Load.
+type Gates struct {
+	status map[Gate]*Status
+}
+
+// Defaults returns the gates with none of their defaults overridden.
+func Defaults() *Gates {
+	g := &Gates{status: make(map[Gate]*Status, len(knownGates))}
+	for name, spec := range knownGates {
+		g.status[name] = &Status{Name: name, Enabled: spec.Default, Source: SourceDefault, Spec: spec}
+	}
+	return g
+}
+
+// Parse parses a comma-separated list of Name=bool pairs.
+func Parse(list string) (map[Gate]bool, error) {
+	result := make(map[Gate]bool)
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("feature gate %q: missing =true or =false", item)
+		}
+		enabled, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("feature gate %q: %q is not a boolean", name, value)
+		}
+		result[Gate(strings.TrimSpace(name))] = enabled
+	}
+	return result, nil
+}
+
+// Load starts from the defaults and applies the files in dir (if it exists), and then envList.
+// Errors are returned for unknown gates and unparseable values, but only after everything else
+// has been applied, so the caller can log them and carry on.
+func Load(dir, envList string) (*Gates, []error) {
+	g := Defaults()
+	var errs []error
+
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		errs = append(errs, fmt.Errorf("feature gates: %w", err))
+	}
+	for _, entry := range entries {
+		// ConfigMap volumes are full of dotfiles and symlinked directories; a gate is a plain file
+		// (or a symlink to one) whose name doesn't start with a dot.
+		if strings.HasPrefix(entry.Name(), ".") || entry.IsDir() {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("feature gate %q: %w", entry.Name(), err))
+			continue
+		}
+		enabled, err := strconv.ParseBool(strings.TrimSpace(string(content)))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("feature gate %q: %q is not a boolean", entry.Name(), content))
+			continue
+		}
+		if err := g.set(Gate(entry.Name()), enabled, SourceConfigMap); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	fromEnv, err := Parse(envList)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for name, enabled := range fromEnv {
+		if err := g.set(name, enabled, SourceEnv); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return g, errs
+}
+
+func (g *Gates) set(name Gate, enabled bool, source string) error {
+	status, ok := g.status[name]
+	if !ok {
+		return fmt.Errorf("feature gate %q is unknown", name)
+	}
+	status.Enabled = enabled
+	status.Source = source
+	return nil
+}
+
+// Enabled returns whether a gate is on. Unknown gates are always off.
+func (g *Gates) Enabled(name Gate) bool {
+	status, ok := g.status[name]
+	return ok && status.Enabled
+}
+
+// Statuses returns the state of every gate, sorted by name.
+func (g *Gates) Statuses() []Status {
+	result := make([]Status, 0, len(g.status))
+	for _, status := range g.status {
+		result = append(result, *status)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// String returns every gate as a list that Parse understands, so that the gates can be handed
+// to a subprocess in the environment.
+func (g *Gates) String() string {
+	statuses := g.Statuses()
+	items := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		items = append(items, fmt.Sprintf("%s=%t", status.Name, status.Enabled))
+	}
+	return strings.Join(items, ",")
+}
+
+// contextKey is its own type, rather than a pointer to an empty struct, because pointers to
+// different zero-size values can be equal, and then this would collide with debug's context key.
+type contextKey struct{}
+
+// NewContext returns a child context that carries the given gates.
+func NewContext(parent context.Context, gates *Gates) context.Context {
+	return context.WithValue(parent, contextKey{}, gates)
+}
+
+// FromContext returns the gates carried by the context, or the defaults if there aren't any.
+func FromContext(ctx context.Context) *Gates {
+	if gates, ok := ctx.Value(contextKey{}).(*Gates); ok {
+		return gates
+	}
+	return Defaults()
+}
@@ -0,0 +1,65 @@
+package featuregate_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/debug"
+	"github.com/emissary-ingress/emissary/v3/pkg/featuregate"
+)
+
+func TestParse(t *testing.T) {
+	gates, err := featuregate.Parse(" DeltaXDS=true, Other=false,")
+	require.NoError(t, err)
+	assert.Equal(t, map[featuregate.Gate]bool{"DeltaXDS": true, "Other": false}, gates)
+
+	_, err = featuregate.Parse("DeltaXDS")
+	assert.Error(t, err)
+
+	_, err = featuregate.Parse("DeltaXDS=maybe")
+	assert.Error(t, err)
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "DeltaXDS"), []byte("true\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "NoSuchGate"), []byte("true"), 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "..data"), 0o755))
+
+	gates, errs := featuregate.Load(dir, "")
+	assert.Len(t, errs, 1)
+	assert.True(t, gates.Enabled(featuregate.DeltaXDS))
+	assert.Equal(t, featuregate.SourceConfigMap, gates.Statuses()[0].Source)
+
+	// The environment wins over the ConfigMap.
+	gates, errs = featuregate.Load(dir, "DeltaXDS=false")
+	assert.Len(t, errs, 1)
+	assert.False(t, gates.Enabled(featuregate.DeltaXDS))
+	assert.Equal(t, featuregate.SourceEnv, gates.Statuses()[0].Source)
+	assert.Equal(t, "DeltaXDS=false,TrafficCapture=false", gates.String())
+
+	// A missing directory isn't an error.
+	gates, errs = featuregate.Load(filepath.Join(dir, "missing"), "")
+	assert.Empty(t, errs)
+	assert.False(t, gates.Enabled(featuregate.DeltaXDS))
+	assert.Equal(t, featuregate.SourceDefault, gates.Statuses()[0].Source)
+}
+
+func TestContext(t *testing.T) {
+	ctx := context.Background()
+	assert.False(t, featuregate.FromContext(ctx).Enabled(featuregate.DeltaXDS))
+
+	gates, errs := featuregate.Load("", "DeltaXDS=true")
+	require.Empty(t, errs)
+	ctx = featuregate.NewContext(ctx, gates)
+	assert.True(t, featuregate.FromContext(ctx).Enabled(featuregate.DeltaXDS))
+	assert.False(t, featuregate.FromContext(ctx).Enabled("NoSuchGate"))
+
+	// The gates don't get mistaken for anything else in the context.
+	assert.NotPanics(t, func() { debug.FromContext(ctx) })
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 
 	"github.com/pkg/errors"
 	"google.golang.org/protobuf/types/known/durationpb"
@@ -22,6 +23,7 @@ import (
 	ecp_v3_resource "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/resource/v3"
 	ecp_wellknown "github.com/emissary-ingress/emissary/v3/pkg/envoy-control-plane/wellknown"
 
+	"github.com/datawire/dlib/derror"
 	"github.com/datawire/dlib/dlog"
 	"github.com/emissary-ingress/emissary/v3/pkg/kates"
 )
@@ -118,8 +120,14 @@ func (d *Dispatcher) Upsert(resource kates.Object) error {
 
 	key := resourceKey(resource)
 
-	config, err := xform(resource)
+	config, err := transform(xform, resource)
 	if err != nil {
+		// Whatever the resource compiled to before doesn't stand any more: report it as being in
+		// error instead.
+		d.configs[key] = &CompiledConfig{
+			CompiledItem: NewCompiledItemError(SourceFromResource(resource), err.Error()),
+		}
+		d.snapshot = nil
 		return errors.Wrapf(err, "internal error processing %s", key)
 	}
 
@@ -129,6 +137,17 @@ func (d *Dispatcher) Upsert(resource kates.Object) error {
 	return nil
 }
 
+// transform runs a transform on a resource, turning a panic into an error: a malformed resource
+// that trips up a transform mustn't take the rest of the configuration down with it.
+func transform(xform func(kates.Object) (*CompiledConfig, error), resource kates.Object) (config *CompiledConfig, err error) {
+	defer func() {
+		if perr := derror.PanicToError(recover()); perr != nil {
+			config, err = nil, perr
+		}
+	}()
+	return xform(resource)
+}
+
 // Delete processes the deletion of the given kubernetes resource.
 func (d *Dispatcher) Delete(resource kates.Object) {
 	key := resourceKey(resource)
@@ -159,10 +178,28 @@ func (d *Dispatcher) UpsertYaml(manifests string) error {
 	return nil
 }
 
+// sortedConfigs returns all the compiled configs ordered by resource key. Anything that turns
+// d.configs into envoy configuration must go through this rather than ranging over the map
+// directly, otherwise the generated configuration (and in particular the order of routes) would
+// depend on map iteration order and differ from one snapshot to the next.
+func (d *Dispatcher) sortedConfigs() []*CompiledConfig {
+	keys := make([]string, 0, len(d.configs))
+	for key := range d.configs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := make([]*CompiledConfig, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, d.configs[key])
+	}
+	return result
+}
+
 // GetErrors returns all compiled items with errors.
 func (d *Dispatcher) GetErrors() []*CompiledItem {
 	var result []*CompiledItem
-	for _, config := range d.configs {
+	for _, config := range d.sortedConfigs() {
 		if config.Error != "" {
 			result = append(result, &config.CompiledItem)
 		}
@@ -251,7 +288,7 @@ func (d *Dispatcher) IsWatched(namespace, name string) bool {
 func (d *Dispatcher) buildClusterMap() (map[string]string, map[string]bool) {
 	refs := map[string]string{}
 	watches := map[string]bool{}
-	for _, config := range d.configs {
+	for _, config := range d.sortedConfigs() {
 		for _, route := range config.Routes {
 			for _, ref := range route.ClusterRefs {
 				refs[ref.Name] = ref.EndpointPath
@@ -267,7 +304,7 @@ func (d *Dispatcher) buildClusterMap() (map[string]string, map[string]bool) {
 
 func (d *Dispatcher) buildEndpointMap() map[string]*v3endpoint.ClusterLoadAssignment {
 	endpoints := map[string]*v3endpoint.ClusterLoadAssignment{}
-	for _, config := range d.configs {
+	for _, config := range d.sortedConfigs() {
 		for _, la := range config.LoadAssignments {
 			endpoints[la.LoadAssignment.ClusterName] = la.LoadAssignment
 		}
@@ -278,7 +315,7 @@ func (d *Dispatcher) buildEndpointMap() map[string]*v3endpoint.ClusterLoadAssign
 func (d *Dispatcher) buildRouteConfigurations() ([]ecp_cache_types.Resource, []ecp_cache_types.Resource) {
 	listeners := []ecp_cache_types.Resource{}
 	routes := []ecp_cache_types.Resource{}
-	for _, config := range d.configs {
+	for _, config := range d.sortedConfigs() {
 		for _, lst := range config.Listeners {
 			listeners = append(listeners, lst.Listener)
 			r := d.buildRouteConfiguration(lst)
@@ -297,7 +334,7 @@ func (d *Dispatcher) buildRouteConfiguration(lst *CompiledListener) *v3route.Rou
 	}
 
 	var routes []*v3route.Route
-	for _, config := range d.configs {
+	for _, config := range d.sortedConfigs() {
 		for _, route := range config.Routes {
 			if lst.Predicate(route) {
 				routes = append(routes, route.Routes...)
@@ -345,9 +382,16 @@ func (d *Dispatcher) buildSnapshot(ctx context.Context) {
 	endpointMap := d.buildEndpointMap()
 	clusterMap, endpointWatches := d.buildClusterMap()
 
+	clusterNames := make([]string, 0, len(clusterMap))
+	for name := range clusterMap {
+		clusterNames = append(clusterNames, name)
+	}
+	sort.Strings(clusterNames)
+
 	clusters := []ecp_cache_types.Resource{}
 	endpoints := []ecp_cache_types.Resource{}
-	for name, path := range clusterMap {
+	for _, name := range clusterNames {
+		path := clusterMap[name]
 		clusters = append(clusters, makeCluster(name, path))
 		key := path
 		if key == "" {
@@ -87,6 +87,30 @@ func TestDispatcherFaultIsolation2(t *testing.T) {
 	assertErrorContains(t, err, "error processing")
 }
 
+func TestDispatcherTransformPanic(t *testing.T) {
+	t.Parallel()
+	disp := gateway.NewDispatcher()
+	err := disp.Register("Foo", wrapFooCompiler(func(f *Foo) (*gateway.CompiledConfig, error) {
+		if f.Spec.PanicArg != nil {
+			panic(f.Spec.PanicArg)
+		}
+		return compile_Foo(f)
+	}))
+	require.NoError(t, err)
+	require.NoError(t, disp.Upsert(makeFoo("default", "foo", "bar")))
+
+	foo := makeFoo("default", "foo", "bar")
+	foo.Spec.PanicArg = errors.New("bang bang!")
+	err = disp.Upsert(foo)
+	assertErrorContains(t, err, "internal error processing Foo:default:foo: PANIC: bang bang!")
+
+	// The resource is in error now, and doesn't contribute its old configuration any more.
+	errs := disp.GetErrors()
+	require.Len(t, errs, 1)
+	assert.Equal(t, "Foo foo.default", errs[0].Source.Location())
+	assert.Contains(t, errs[0].Error, "bang bang!")
+}
+
 func TestDispatcherTransformError(t *testing.T) {
 	t.Parallel()
 	disp := gateway.NewDispatcher()
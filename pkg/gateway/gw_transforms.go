@@ -120,7 +120,11 @@ func Compile_HTTPRouteRule(src Source, rule gw.HTTPRouteRule, namespace string,
 	var clusters []*v3route.WeightedCluster_ClusterWeight
 	for idx, fwd := range rule.ForwardTo {
 		s := Sourcef("forwardTo %d in %s", idx, src)
-		clusters = append(clusters, Compile_HTTPRouteForwardTo(s, fwd, namespace, clusterRefs))
+		cluster, err := Compile_HTTPRouteForwardTo(s, fwd, namespace, clusterRefs)
+		if err != nil {
+			return nil, err
+		}
+		clusters = append(clusters, cluster)
 	}
 
 	wc := &v3route.WeightedCluster{Clusters: clusters}
@@ -142,7 +146,12 @@ func Compile_HTTPRouteRule(src Source, rule gw.HTTPRouteRule, namespace string,
 	return result, err
 }
 
-func Compile_HTTPRouteForwardTo(src Source, forward gw.HTTPRouteForwardTo, namespace string, clusterRefs *[]*ClusterRef) *v3route.WeightedCluster_ClusterWeight {
+func Compile_HTTPRouteForwardTo(src Source, forward gw.HTTPRouteForwardTo, namespace string, clusterRefs *[]*ClusterRef) (*v3route.WeightedCluster_ClusterWeight, error) {
+	// We don't do backendRefs, only Services.
+	if forward.ServiceName == nil {
+		return nil, errors.Errorf("%s: serviceName is required", src.Location())
+	}
+
 	suffix := ""
 	clusterName := *forward.ServiceName
 	if forward.Port != nil {
@@ -158,7 +167,7 @@ func Compile_HTTPRouteForwardTo(src Source, forward gw.HTTPRouteForwardTo, names
 	return &v3route.WeightedCluster_ClusterWeight{
 		Name:   clusterName,
 		Weight: &wrapperspb.UInt32Value{Value: uint32(forward.Weight)},
-	}
+	}, nil
 }
 
 func Compile_HTTPRouteMatches(matches []gw.HTTPRouteMatch) ([]*v3route.RouteMatch, error) {
@@ -211,6 +211,57 @@ spec:
       weight: 100
 `)
 	assertErrorContains(t, err, `processing HTTPRoute:default:my-route: unknown header match type: Bleh`)
+
+	// A forwardTo with only a backendRef used to crash the dispatcher.
+	err = d.UpsertYaml(`
+---
+kind: HTTPRoute
+apiVersion: networking.x-k8s.io/v1alpha1
+metadata:
+  name: my-route
+  namespace: default
+spec:
+  rules:
+  - forwardTo:
+    - backendRef:
+        group: example.com
+        kind: Backend
+        name: foo-backend-1
+`)
+	assertErrorContains(t, err, `processing HTTPRoute:default:my-route: forwardTo 0 in rule 0 in HTTPRoute my-route.default: serviceName is required`)
+	require.Len(t, d.GetErrors(), 1)
+}
+
+// FuzzCompile feeds arbitrary YAML to the Gateway API compilers. Anything that doesn't make sense
+// has to come back as an error: a panic, even one that the Dispatcher catches, is a bug.
+func FuzzCompile(f *testing.F) {
+	f.Add(`
+kind: Gateway
+apiVersion: networking.x-k8s.io/v1alpha1
+metadata: {name: my-gateway, namespace: default}
+spec:
+  listeners:
+  - {protocol: HTTP, port: 8080}
+`)
+	f.Add(`
+kind: HTTPRoute
+apiVersion: networking.x-k8s.io/v1alpha1
+metadata: {name: my-route, namespace: default}
+spec:
+  rules:
+  - matches:
+    - path: {type: Prefix, value: /prefix}
+      headers: {type: Exact, values: {exact: foo}}
+    forwardTo:
+    - {serviceName: foo-backend-1, port: 9000, weight: 100}
+`)
+	f.Fuzz(func(t *testing.T, manifests string) {
+		d, err := makeDispatcher()
+		require.NoError(t, err)
+		if err := d.UpsertYaml(manifests); err != nil {
+			assert.NotContains(t, err.Error(), "PANIC")
+		}
+	})
 }
 
 func makeDispatcher() (*gateway.Dispatcher, error) {
@@ -0,0 +1,192 @@
+// Package istio talks to the Istio agent (pilot-agent) over its SDS socket, which is how a
+// workload in the mesh gets its mTLS certificate and the mesh's root of trust without anybody
+// having to mount them into the filesystem.
+//
+// The agent serves the standard Envoy Secret Discovery Service, and it always names the
+// workload certificate "default" and the root certificate "ROOTCA", so that's all we ask for.
+package istio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/datawire/dlib/dlog"
+	v3core "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/core/v3"
+	v3tls "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/extensions/transport_sockets/tls/v3"
+	v3discovery "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/service/discovery/v3"
+	v3secret "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/service/secret/v3"
+)
+
+const (
+	// WorkloadCertName is the SDS resource name the Istio agent uses for the workload's own
+	// certificate and key.
+	WorkloadCertName = "default"
+	// RootCertName is the SDS resource name the Istio agent uses for the mesh root CA.
+	RootCertName = "ROOTCA"
+
+	secretTypeURL = "type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.Secret"
+
+	minBackoff = 1 * time.Second
+	maxBackoff = 30 * time.Second
+)
+
+// WorkloadCerts is what the Istio agent has given us so far.
+type WorkloadCerts struct {
+	// CertChainPEM is the workload certificate followed by any intermediates.
+	CertChainPEM []byte
+	// KeyPEM is the private key for the workload certificate.
+	KeyPEM []byte
+	// RootCertPEM is the mesh root CA, which upstream certificates must chain to.
+	RootCertPEM []byte
+}
+
+// Complete returns whether we have everything we need to do mTLS: a certificate and its key.
+// RootCertPEM is optional, since not verifying the upstream is still better than not
+// presenting a certificate at all.
+func (c *WorkloadCerts) Complete() bool {
+	return len(c.CertChainPEM) > 0 && len(c.KeyPEM) > 0
+}
+
+// WatchSDS streams WorkloadCerts from the Istio agent's SDS socket to handler, reconnecting (with
+// backoff) whenever the stream breaks, until ctx is canceled. The handler is only called once the
+// certs are Complete, and again every time the agent rotates them.
+func WatchSDS(ctx context.Context, socket, nodeID string, handler func(context.Context, *WorkloadCerts)) error {
+	backoff := minBackoff
+	for {
+		err := streamSDS(ctx, socket, nodeID, func(ctx context.Context, certs *WorkloadCerts) {
+			backoff = minBackoff
+			handler(ctx, certs)
+		})
+		if ctx.Err() != nil {
+			return nil
+		}
+		dlog.Errorf(ctx, "ISTIO: SDS stream from %s failed, retrying in %v: %v", socket, backoff, err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func streamSDS(ctx context.Context, socket, nodeID string, handler func(context.Context, *WorkloadCerts)) error {
+	conn, err := grpc.DialContext(ctx, socketTarget(socket),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := v3secret.NewSecretDiscoveryServiceClient(conn).StreamSecrets(ctx)
+	if err != nil {
+		return err
+	}
+
+	node := &v3core.Node{Id: nodeID}
+	names := []string{WorkloadCertName, RootCertName}
+	err = stream.Send(&v3discovery.DiscoveryRequest{
+		Node:          node,
+		ResourceNames: names,
+		TypeUrl:       secretTypeURL,
+	})
+	if err != nil {
+		return err
+	}
+
+	// The agent may send the certificate and the root in separate responses, so accumulate
+	// them across responses. (Fresh each time we connect, though.)
+	certs := &WorkloadCerts{}
+	var acceptedVersion string
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+
+		ack := &v3discovery.DiscoveryRequest{
+			Node:          node,
+			ResourceNames: names,
+			TypeUrl:       secretTypeURL,
+			ResponseNonce: resp.GetNonce(),
+		}
+		if err := ParseSDSResponse(resp, certs); err != nil {
+			// NACK it (by repeating the last version we accepted), and keep whatever we
+			// had before.
+			dlog.Errorf(ctx, "ISTIO: rejecting SDS response version %q: %v", resp.GetVersionInfo(), err)
+			ack.VersionInfo = acceptedVersion
+			ack.ErrorDetail = &status.Status{Message: err.Error()}
+		} else {
+			acceptedVersion = resp.GetVersionInfo()
+			ack.VersionInfo = acceptedVersion
+		}
+		if err := stream.Send(ack); err != nil {
+			return err
+		}
+		if ack.ErrorDetail == nil && certs.Complete() {
+			// Hand the handler a copy, since we'll keep updating ours.
+			snapshot := *certs
+			handler(ctx, &snapshot)
+		}
+	}
+}
+
+// ParseSDSResponse folds the Secrets in an SDS DiscoveryResponse into certs. Either all of the
+// response is applied, or (if it returns an error) none of it is.
+func ParseSDSResponse(resp *v3discovery.DiscoveryResponse, certs *WorkloadCerts) error {
+	updated := *certs
+	for _, resource := range resp.GetResources() {
+		var secret v3tls.Secret
+		if err := resource.UnmarshalTo(&secret); err != nil {
+			return fmt.Errorf("decoding resource: %w", err)
+		}
+		switch secret.GetName() {
+		case WorkloadCertName:
+			tlsCert := secret.GetTlsCertificate()
+			if tlsCert == nil {
+				return fmt.Errorf("secret %q is not a TLS certificate", secret.GetName())
+			}
+			chain := tlsCert.GetCertificateChain().GetInlineBytes()
+			key := tlsCert.GetPrivateKey().GetInlineBytes()
+			if len(chain) == 0 || len(key) == 0 {
+				return fmt.Errorf("secret %q is missing its certificate chain or key", secret.GetName())
+			}
+			updated.CertChainPEM = chain
+			updated.KeyPEM = key
+		case RootCertName:
+			validation := secret.GetValidationContext()
+			if validation == nil {
+				return fmt.Errorf("secret %q is not a validation context", secret.GetName())
+			}
+			root := validation.GetTrustedCa().GetInlineBytes()
+			if len(root) == 0 {
+				return errors.New("secret \"ROOTCA\" has no trusted CA")
+			}
+			updated.RootCertPEM = root
+		default:
+			// We didn't ask for it, so ignore it.
+		}
+	}
+	*certs = updated
+	return nil
+}
+
+// socketTarget turns a socket path or URL into a gRPC dial target.
+func socketTarget(socket string) string {
+	if strings.HasPrefix(socket, "unix:") {
+		return socket
+	}
+	return "unix://" + socket
+}
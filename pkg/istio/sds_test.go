@@ -0,0 +1,71 @@
+package istio_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	v3core "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/config/core/v3"
+	v3tls "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/extensions/transport_sockets/tls/v3"
+	v3discovery "github.com/emissary-ingress/emissary/v3/pkg/api/envoy/service/discovery/v3"
+	"github.com/emissary-ingress/emissary/v3/pkg/istio"
+)
+
+func inline(data string) *v3core.DataSource {
+	return &v3core.DataSource{
+		Specifier: &v3core.DataSource_InlineBytes{InlineBytes: []byte(data)},
+	}
+}
+
+func response(t *testing.T, secrets ...*v3tls.Secret) *v3discovery.DiscoveryResponse {
+	t.Helper()
+	resp := &v3discovery.DiscoveryResponse{VersionInfo: "1"}
+	for _, secret := range secrets {
+		resource, err := anypb.New(secret)
+		require.NoError(t, err)
+		resp.Resources = append(resp.Resources, resource)
+	}
+	return resp
+}
+
+func workloadCert(chain, key string) *v3tls.Secret {
+	return &v3tls.Secret{
+		Name: istio.WorkloadCertName,
+		Type: &v3tls.Secret_TlsCertificate{TlsCertificate: &v3tls.TlsCertificate{
+			CertificateChain: inline(chain),
+			PrivateKey:       inline(key),
+		}},
+	}
+}
+
+func rootCert(root string) *v3tls.Secret {
+	return &v3tls.Secret{
+		Name: istio.RootCertName,
+		Type: &v3tls.Secret_ValidationContext{ValidationContext: &v3tls.CertificateValidationContext{
+			TrustedCa: inline(root),
+		}},
+	}
+}
+
+func TestParseSDSResponse(t *testing.T) {
+	certs := &istio.WorkloadCerts{}
+
+	// The root can arrive before the workload cert.
+	require.NoError(t, istio.ParseSDSResponse(response(t, rootCert("root")), certs))
+	assert.False(t, certs.Complete())
+	assert.Equal(t, "root", string(certs.RootCertPEM))
+
+	require.NoError(t, istio.ParseSDSResponse(response(t, workloadCert("chain", "key")), certs))
+	assert.True(t, certs.Complete())
+	assert.Equal(t, "chain", string(certs.CertChainPEM))
+	assert.Equal(t, "key", string(certs.KeyPEM))
+	assert.Equal(t, "root", string(certs.RootCertPEM))
+
+	// A bad response changes nothing, even the parts of it that were fine.
+	err := istio.ParseSDSResponse(response(t, workloadCert("new-chain", "new-key"), rootCert("")), certs)
+	assert.Error(t, err)
+	assert.Equal(t, "chain", string(certs.CertChainPEM))
+	assert.Equal(t, "root", string(certs.RootCertPEM))
+}
@@ -3,6 +3,7 @@ package kates
 import (
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	xv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
@@ -68,6 +69,11 @@ type EndpointSubset = corev1.EndpointSubset
 type EndpointAddress = corev1.EndpointAddress
 type EndpointPort = corev1.EndpointPort
 
+type EndpointSlice = discoveryv1.EndpointSlice
+type EndpointSliceEndpoint = discoveryv1.Endpoint
+
+const LabelServiceName = discoveryv1.LabelServiceName
+
 type Protocol = corev1.Protocol
 
 var ProtocolTCP = corev1.ProtocolTCP
@@ -182,6 +188,8 @@ var ParseSelector = labels.Parse
 
 var IsNotFound = apierrors.IsNotFound
 var IsConflict = apierrors.IsConflict
+var IsAlreadyExists = apierrors.IsAlreadyExists
+var IsForbidden = apierrors.IsForbidden
 
 //
 
@@ -84,6 +84,7 @@ type Client struct {
 	mutex                  sync.Mutex
 	canonical              map[string]*Unstructured
 	maxAccumulatorInterval time.Duration
+	connectivity           func(kind string, err error)
 
 	// This is an internal interface for testing, it lets us deliberately introduce delays into the
 	// implementation, e.g. effectively increasing the latency to the api server in a controllable
@@ -156,6 +157,7 @@ func NewClientFromConfigFlags(config *ConfigFlags) (*Client, error) {
 		disco:                  disco,
 		canonical:              make(map[string]*Unstructured),
 		maxAccumulatorInterval: 1 * time.Second,
+		connectivity:           func(kind string, err error) {},
 		watchAdded:             func(oldObj, newObj *Unstructured) {},
 		watchUpdated:           func(oldObj, newObj *Unstructured) {},
 		watchDeleted:           func(oldObj, newObj *Unstructured) {},
@@ -225,6 +227,15 @@ func (c *Client) MaxAccumulatorInterval(interval time.Duration) error {
 	return nil
 }
 
+// OnConnectivity sets a function for watches to call every time they list or watch a kind, with
+// nil if the API server answered (even if only to say no), and the error if it didn't. It only
+// applies to watches started after it's called.
+func (c *Client) OnConnectivity(handler func(kind string, err error)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.connectivity = handler
+}
+
 // DynamicInterface is an accessor method to the k8s dynamic client
 func (c *Client) DynamicInterface() dynamic.Interface {
 	return c.cli
@@ -383,11 +394,14 @@ func (c *Client) watchRaw(ctx context.Context, query Query, target chan rawUpdat
 	// we override Watch to let us signal when our initial List is
 	// complete so we can send an update() even when there are no
 	// resource instances of the kind being watched
+	c.mutex.Lock()
+	connectivity := c.connectivity
+	c.mutex.Unlock()
 	lw := newListWatcher(ctx, cli, query, func(lw *lw) {
 		if lw.hasSynced() {
 			target <- rawUpdate{query.Name, true, nil, nil, time.Now()}
 		}
-	})
+	}, connectivity)
 	informer = cache.NewSharedInformer(lw, &Unstructured{}, 5*time.Minute)
 	// TODO: uncomment this when we get to kubernetes 1.19. Right now errors will get logged by
 	// klog. With this error handler in place we will log them to our own logger and provide a
@@ -481,6 +495,8 @@ type lw struct {
 	query  Query
 	synced func(*lw)
 	once   sync.Once
+	// connectivity is told how every List and Watch went (see Client.OnConnectivity).
+	connectivity func(kind string, err error)
 
 	// The mutex protects all the read-write fields.
 	mutex            sync.Mutex
@@ -490,8 +506,8 @@ type lw struct {
 	listForbidden    bool
 }
 
-func newListWatcher(ctx context.Context, client dynamic.ResourceInterface, query Query, synced func(*lw)) *lw {
-	return &lw{ctx: ctx, client: client, query: query, synced: synced}
+func newListWatcher(ctx context.Context, client dynamic.ResourceInterface, query Query, synced func(*lw), connectivity func(string, error)) *lw {
+	return &lw{ctx: ctx, client: client, query: query, synced: synced, connectivity: connectivity}
 }
 
 func (lw *lw) withMutex(f func()) {
@@ -506,6 +522,13 @@ func (lw *lw) countAddEvent() {
 	})
 }
 
+func (lw *lw) isListForbidden() (result bool) {
+	lw.withMutex(func() {
+		result = lw.listForbidden
+	})
+	return
+}
+
 // This computes whether we have synced a given watch. We used to use SharedInformer.HasSynced() for
 // this, but that seems to be a blatant lie that always return true. My best guess as to why it lies
 // is that it is actually reporting the synced state of an internal queue, but because the
@@ -558,6 +581,9 @@ func (lw *lw) List(opts ListOptions) (runtime.Object, error) {
 		dlog.Infof(lw.ctx, "couldn't list %s (will retry): %s", lw.query.Kind, err)
 	}
 
+	// A forbidden list is an answer from the API server, too.
+	lw.connectivity(lw.query.Kind, err)
+
 	lw.withMutex(func() {
 		if synced {
 			if !lw.initialListDone {
@@ -579,6 +605,12 @@ func (lw *lw) Watch(opts ListOptions) (watch.Interface, error) {
 
 	iface, err := lw.client.Watch(lw.ctx, opts)
 
+	if err == nil || lw.isListForbidden() {
+		lw.connectivity(lw.query.Kind, nil)
+	} else {
+		lw.connectivity(lw.query.Kind, err)
+	}
+
 	if err != nil {
 		// If the list was forbidden, this error will likely just be "unknown", since we
 		// returned an unstructured.UnstructuredList to fake out the lister, so in that
@@ -0,0 +1,107 @@
+package logsink
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// FileConfig says where a file sink writes entries.
+type FileConfig struct {
+	// Path is the file to write. Its directory is created if need be.
+	Path string
+	// MaxBytes is how big the file gets before it's rotated. The default is 100MiB.
+	MaxBytes int64
+	// MaxBackups is how many rotated files to keep, as Path.1 (the newest) through Path.N. The
+	// default is 5.
+	MaxBackups int
+	// QueueSize is how many entries the sink can hold. The default is DefaultQueueSize.
+	QueueSize int
+}
+
+// NewFile returns a Sink that appends entries to a file, one line each, and rotates the file when
+// it gets too big.
+func NewFile(cfg FileConfig) (Sink, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("log file path is required")
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = 100 * 1024 * 1024
+	}
+	if cfg.MaxBackups <= 0 {
+		cfg.MaxBackups = 5
+	}
+	w := &fileWriter{cfg: cfg}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return newQueue("file "+cfg.Path, w, cfg.QueueSize), nil
+}
+
+type fileWriter struct {
+	cfg  FileConfig
+	file *os.File
+	size int64
+}
+
+func (w *fileWriter) open() error {
+	if err := os.MkdirAll(filepath.Dir(w.cfg.Path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(w.cfg.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file, w.size = file, info.Size()
+	return nil
+}
+
+func (w *fileWriter) writeEntry(e Entry) error {
+	line := formatLine(e)
+	if w.file == nil {
+		// The last rotation couldn't reopen the file; try again.
+		if err := w.open(); err != nil {
+			return err
+		}
+	}
+	if w.size > 0 && w.size+int64(len(line)) > w.cfg.MaxBytes {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := w.file.Write(line)
+	w.size += int64(n)
+	return err
+}
+
+// rotate shifts Path.N-1 to Path.N, and so on down to Path to Path.1, and starts a new Path.
+func (w *fileWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	w.file = nil
+	for i := w.cfg.MaxBackups - 1; i >= 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", w.cfg.Path, i), fmt.Sprintf("%s.%d", w.cfg.Path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(w.cfg.Path, w.cfg.Path+".1"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return w.open()
+}
+
+func (w *fileWriter) close() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
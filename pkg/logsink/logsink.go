@@ -0,0 +1,196 @@
+// Package logsink sends log entries somewhere other than stdout: to a syslog server, or to a file
+// that rotates itself, for log pipelines that can't scrape container output.
+//
+// Sinks never hold up the code that's logging. Each one has a queue, and entries that arrive when
+// the queue is full are dropped (and counted). A sink that can't deliver an entry complains on
+// stderr, which is still going wherever it always went, rather than through the logger, which
+// would just hand the complaint back to the same sink.
+package logsink
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Severity is an RFC 5424 severity.
+type Severity int
+
+const (
+	Emergency Severity = iota
+	Alert
+	Critical
+	Error
+	Warning
+	Notice
+	Informational
+	Debug
+)
+
+var severityNames = []string{"EMERG", "ALERT", "CRIT", "ERROR", "WARNING", "NOTICE", "INFO", "DEBUG"}
+
+func (s Severity) String() string {
+	if s < 0 || int(s) >= len(severityNames) {
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+	return severityNames[s]
+}
+
+// An Entry is a single log message.
+type Entry struct {
+	Time     time.Time
+	Severity Severity
+	// App is the program that logged it, e.g. "entrypoint" or "diagd".
+	App     string
+	Message string
+}
+
+// A Sink takes log entries.
+type Sink interface {
+	// Send queues the entry to be delivered. It never blocks.
+	Send(Entry)
+	// Dropped returns how many entries have been dropped: because the queue was full, because
+	// they couldn't be delivered, or because the sink was closed.
+	Dropped() uint64
+	// Close delivers what's queued, and closes the sink. Anything sent after that is dropped.
+	Close() error
+}
+
+// DefaultQueueSize is how many entries a sink holds while it's busy delivering.
+const DefaultQueueSize = 1024
+
+// An entryWriter delivers entries for a queue.
+type entryWriter interface {
+	writeEntry(Entry) error
+	close() error
+}
+
+type queue struct {
+	name    string
+	w       entryWriter
+	entries chan Entry
+	done    chan struct{}
+	dropped uint64 // atomic
+
+	mu     sync.RWMutex // protects closed, and entries from being closed under Send
+	closed bool
+
+	// lastComplaint rate-limits complaints on stderr. Only run touches it.
+	lastComplaint time.Time
+}
+
+func newQueue(name string, w entryWriter, size int) *queue {
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+	q := &queue{
+		name:    name,
+		w:       w,
+		entries: make(chan Entry, size),
+		done:    make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+func (q *queue) Send(e Entry) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		atomic.AddUint64(&q.dropped, 1)
+		return
+	}
+	select {
+	case q.entries <- e:
+	default:
+		atomic.AddUint64(&q.dropped, 1)
+	}
+}
+
+func (q *queue) Dropped() uint64 {
+	return atomic.LoadUint64(&q.dropped)
+}
+
+func (q *queue) Close() error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.entries)
+	}
+	q.mu.Unlock()
+	<-q.done
+	return q.w.close()
+}
+
+func (q *queue) run() {
+	defer close(q.done)
+	for e := range q.entries {
+		if err := q.w.writeEntry(e); err != nil {
+			atomic.AddUint64(&q.dropped, 1)
+			if time.Since(q.lastComplaint) > time.Minute {
+				q.lastComplaint = time.Now()
+				fmt.Fprintf(os.Stderr, "log sink %s: %v (dropping entries until it works again)\n", q.name, err)
+			}
+		}
+	}
+}
+
+// LineWriter returns an io.Writer that sends each line written to it to every one of sinks, as an
+// entry from app with the given severity. It's for the output of subprocesses.
+func LineWriter(app string, severity Severity, sinks ...Sink) io.Writer {
+	return &lineWriter{app: app, severity: severity, sinks: sinks}
+}
+
+type lineWriter struct {
+	app      string
+	severity Severity
+	sinks    []Sink
+
+	mu      sync.Mutex
+	partial []byte
+}
+
+// maxLine is how much of a line a lineWriter holds on to before sending it anyway.
+const maxLine = 64 * 1024
+
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	lw.partial = append(lw.partial, p...)
+	for {
+		i := bytes.IndexByte(lw.partial, '\n')
+		if i < 0 {
+			if len(lw.partial) >= maxLine {
+				lw.send(lw.partial)
+				lw.partial = lw.partial[:0]
+			}
+			return len(p), nil
+		}
+		lw.send(lw.partial[:i])
+		lw.partial = lw.partial[i+1:]
+	}
+}
+
+func (lw *lineWriter) send(line []byte) {
+	line = bytes.TrimRight(line, "\r")
+	if len(line) == 0 {
+		return
+	}
+	e := Entry{Time: time.Now(), Severity: lw.severity, App: lw.app, Message: string(line)}
+	for _, sink := range lw.sinks {
+		sink.Send(e)
+	}
+}
+
+// formatLine formats an entry as a single line of text, for a file.
+func formatLine(e Entry) []byte {
+	return []byte(fmt.Sprintf("%s %s %s: %s\n",
+		e.Time.UTC().Format(timestampFormat), e.Severity, e.App, e.Message))
+}
+
+// timestampFormat is RFC 3339, with microseconds, as RFC 5424 allows.
+const timestampFormat = "2006-01-02T15:04:05.000000Z07:00"
@@ -0,0 +1,132 @@
+package logsink
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testTime = time.Date(2026, 1, 2, 3, 4, 5, 600000000, time.UTC)
+
+func TestSyslogUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	sink, err := NewSyslog(SyslogConfig{Network: "udp", Address: conn.LocalAddr().String(), Hostname: "pod-1"})
+	require.NoError(t, err)
+	sink.Send(Entry{Time: testTime, Severity: Warning, App: "entrypoint", Message: "hello there"})
+	require.NoError(t, sink.Close())
+
+	buf := make([]byte, 1024)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t,
+		fmt.Sprintf("<12>1 2026-01-02T03:04:05.600000Z pod-1 entrypoint %d - - hello there", os.Getpid()),
+		string(buf[:n]))
+}
+
+func TestSyslogTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		var messages []string
+		for {
+			var length int
+			if _, err := fmt.Fscanf(r, "%d ", &length); err != nil {
+				break
+			}
+			msg := make([]byte, length)
+			if _, err := io.ReadFull(r, msg); err != nil {
+				break
+			}
+			messages = append(messages, string(msg))
+		}
+		received <- strings.Join(messages, "|")
+	}()
+
+	sink, err := NewSyslog(SyslogConfig{Network: "tcp", Address: listener.Addr().String(), Facility: 16, Hostname: "pod 1"})
+	require.NoError(t, err)
+	sink.Send(Entry{Time: testTime, Severity: Error, App: "diagd", Message: "one"})
+	sink.Send(Entry{Time: testTime, Severity: Debug, App: "", Message: "two"})
+	require.NoError(t, sink.Close())
+
+	pid := os.Getpid()
+	select {
+	case got := <-received:
+		assert.Equal(t, fmt.Sprintf(
+			"<131>1 2026-01-02T03:04:05.600000Z pod1 diagd %d - - one|<135>1 2026-01-02T03:04:05.600000Z pod1 - %d - - two",
+			pid, pid), got)
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing received")
+	}
+	assert.Equal(t, uint64(0), sink.Dropped())
+}
+
+func TestSyslogConfig(t *testing.T) {
+	_, err := NewSyslog(SyslogConfig{Network: "carrier-pigeon", Address: "localhost:514"})
+	assert.Error(t, err)
+	_, err = NewSyslog(SyslogConfig{Network: "udp", Address: "localhost"})
+	assert.Error(t, err)
+	_, err = NewSyslog(SyslogConfig{Network: "udp", Address: "localhost:514", Facility: 24})
+	assert.Error(t, err)
+}
+
+func TestFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "ambassador.log")
+	line := string(formatLine(Entry{Time: testTime, Severity: Informational, App: "entrypoint", Message: "0"}))
+
+	// Room for two lines per file.
+	sink, err := NewFile(FileConfig{Path: path, MaxBytes: int64(2 * len(line)), MaxBackups: 2})
+	require.NoError(t, err)
+	for i := 0; i < 7; i++ {
+		sink.Send(Entry{Time: testTime, Severity: Informational, App: "entrypoint", Message: fmt.Sprint(i)})
+	}
+	require.NoError(t, sink.Close())
+
+	read := func(path string) string {
+		bytes, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return strings.ReplaceAll(string(bytes), "2026-01-02T03:04:05.600000Z INFO entrypoint: ", "")
+	}
+	assert.Equal(t, "6\n", read(path))
+	assert.Equal(t, "4\n5\n", read(path+".1"))
+	assert.Equal(t, "2\n3\n", read(path+".2"))
+	assert.NoFileExists(t, path+".3")
+}
+
+func TestLineWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "diagd.log")
+	sink, err := NewFile(FileConfig{Path: path})
+	require.NoError(t, err)
+
+	w := LineWriter("diagd", Notice, sink)
+	_, _ = w.Write([]byte("first line\nsecond "))
+	_, _ = w.Write([]byte("line\r\n\nthird, unfinished"))
+	require.NoError(t, sink.Close())
+
+	bytes, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(bytes)), "\n")
+	require.Len(t, lines, 2)
+	assert.True(t, strings.HasSuffix(lines[0], " NOTICE diagd: first line"), lines[0])
+	assert.True(t, strings.HasSuffix(lines[1], " NOTICE diagd: second line"), lines[1])
+}
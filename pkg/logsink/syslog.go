@@ -0,0 +1,144 @@
+package logsink
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SyslogConfig says where a syslog sink sends entries.
+type SyslogConfig struct {
+	// Network is "udp", "tcp", or "tls" (which is TCP, with TLS, as RFC 5425 has it).
+	Network string
+	// Address is the server's host:port.
+	Address string
+	// TLS is the client TLS configuration for "tls". If it's nil, the system roots are trusted.
+	TLS *tls.Config
+	// Facility is the syslog facility, 0 through 23. The default is 1, "user".
+	Facility int
+	// Hostname goes in every message. The default is os.Hostname().
+	Hostname string
+	// QueueSize is how many entries the sink can hold. The default is DefaultQueueSize.
+	QueueSize int
+}
+
+// dialTimeout is how long a syslog sink waits to connect, or to write.
+const dialTimeout = 10 * time.Second
+
+// NewSyslog returns a Sink that sends entries to a syslog server, formatted as RFC 5424 says. Over
+// TCP and TLS, the messages are framed by octet counting. Over UDP, each one is a datagram of its
+// own.
+//
+// It connects when it has something to send, and if sending fails, it connects again and retries
+// once, before giving up on that entry.
+func NewSyslog(cfg SyslogConfig) (Sink, error) {
+	switch cfg.Network {
+	case "udp", "tcp", "tls":
+	default:
+		return nil, fmt.Errorf("syslog network must be udp, tcp, or tls, not %q", cfg.Network)
+	}
+	if _, _, err := net.SplitHostPort(cfg.Address); err != nil {
+		return nil, fmt.Errorf("syslog address: %w", err)
+	}
+	if cfg.Facility == 0 {
+		cfg.Facility = 1
+	}
+	if cfg.Facility < 0 || cfg.Facility > 23 {
+		return nil, fmt.Errorf("syslog facility must be 0 through 23, not %d", cfg.Facility)
+	}
+	if cfg.Hostname == "" {
+		cfg.Hostname, _ = os.Hostname()
+	}
+	if cfg.Network == "tls" {
+		tlsConfig := &tls.Config{}
+		if cfg.TLS != nil {
+			tlsConfig = cfg.TLS.Clone()
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName, _, _ = net.SplitHostPort(cfg.Address)
+		}
+		cfg.TLS = tlsConfig
+	}
+	return newQueue("syslog "+cfg.Network+"://"+cfg.Address, &syslogWriter{cfg: cfg, pid: os.Getpid()}, cfg.QueueSize), nil
+}
+
+type syslogWriter struct {
+	cfg  SyslogConfig
+	pid  int
+	conn net.Conn
+}
+
+func (w *syslogWriter) writeEntry(e Entry) error {
+	msg := formatSyslog(w.cfg.Facility, w.cfg.Hostname, w.pid, e)
+	if w.cfg.Network != "udp" {
+		msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	}
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if w.conn == nil {
+			if w.conn, err = w.dial(); err != nil {
+				return err
+			}
+		}
+		_ = w.conn.SetWriteDeadline(time.Now().Add(dialTimeout))
+		if _, err = w.conn.Write(msg); err == nil {
+			return nil
+		}
+		w.conn.Close()
+		w.conn = nil
+	}
+	return err
+}
+
+func (w *syslogWriter) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	if w.cfg.Network == "tls" {
+		return tls.DialWithDialer(dialer, "tcp", w.cfg.Address, w.cfg.TLS)
+	}
+	return dialer.Dial(w.cfg.Network, w.cfg.Address)
+}
+
+func (w *syslogWriter) close() error {
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
+
+// formatSyslog formats an entry as an RFC 5424 message:
+//
+//	<PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+func formatSyslog(facility int, hostname string, pid int, e Entry) []byte {
+	return []byte(fmt.Sprintf("<%d>1 %s %s %s %d - - %s",
+		facility*8+int(e.Severity),
+		e.Time.UTC().Format(timestampFormat),
+		syslogField(hostname, 255),
+		syslogField(e.App, 48),
+		pid,
+		e.Message))
+}
+
+// syslogField makes s fit in a header field: printable ASCII, no spaces, at most max long, and "-"
+// if there's nothing left.
+func syslogField(s string, max int) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return -1
+		}
+		return r
+	}, s)
+	if len(s) > max {
+		s = s[:max]
+	}
+	if s == "" {
+		return "-"
+	}
+	return s
+}
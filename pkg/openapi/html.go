@@ -0,0 +1,81 @@
+package openapi
+
+import (
+	"html/template"
+	"io"
+)
+
+// An operation is one row of the docs page.
+type operation struct {
+	Method      string
+	Path        string
+	Summary     string
+	Description string
+	Tags        []string
+	Deprecated  bool
+}
+
+var docsTemplate = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; width: 100%; }
+td, th { text-align: left; padding: 0.4em 0.8em; border-bottom: 1px solid #ddd; vertical-align: top; }
+.method { font-family: monospace; font-weight: bold; text-transform: uppercase; }
+.path { font-family: monospace; }
+.deprecated { text-decoration: line-through; color: #888; }
+.tag { background: #eee; border-radius: 0.3em; padding: 0 0.3em; margin-right: 0.3em; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p><a href="{{.SpecURL}}">OpenAPI document</a></p>
+{{if .Operations}}<table>
+<tr><th>Method</th><th>Path</th><th>Summary</th></tr>
+{{range .Operations}}<tr{{if .Deprecated}} class="deprecated"{{end}}>
+<td class="method">{{.Method}}</td>
+<td class="path">{{.Path}}</td>
+<td>{{range .Tags}}<span class="tag">{{.}}</span>{{end}}{{.Summary}}{{if .Description}}<br>{{.Description}}{{end}}</td>
+</tr>
+{{end}}</table>{{else}}<p>No operations.</p>{{end}}
+</body>
+</html>
+`))
+
+// WriteHTML writes a page listing a document's operations, which links to the document itself at
+// specURL. It doesn't load anything from anywhere else, so it works without Internet access.
+func WriteHTML(w io.Writer, doc Document, specURL string) error {
+	info, _ := doc["info"].(map[string]interface{})
+	title, _ := info["title"].(string)
+
+	var operations []operation
+	paths, _ := doc["paths"].(map[string]interface{})
+	for _, path := range sortedKeys(paths) {
+		item, _ := paths[path].(map[string]interface{})
+		for _, method := range methods {
+			op, ok := item[method].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			row := operation{Method: method, Path: path}
+			row.Summary, _ = op["summary"].(string)
+			row.Description, _ = op["description"].(string)
+			row.Deprecated, _ = op["deprecated"].(bool)
+			tags, _ := op["tags"].([]interface{})
+			for _, tag := range tags {
+				if tag, ok := tag.(string); ok {
+					row.Tags = append(row.Tags, tag)
+				}
+			}
+			operations = append(operations, row)
+		}
+	}
+	return docsTemplate.Execute(w, struct {
+		Title      string
+		SpecURL    string
+		Operations []operation
+	}{title, specURL, operations})
+}
@@ -0,0 +1,352 @@
+// Package openapi gathers the OpenAPI 3 documents that upstream services publish, and turns them
+// into one document describing the API as clients see it through Ambassador.
+//
+// An upstream's document describes paths as the upstream sees them, below the path of its first
+// server URL. Rewrite maps each one back through the Mapping's prefix and rewrite to the path that
+// a client would use, and drops the paths the Mapping can't reach. Aggregate then merges any number
+// of rewritten documents, renaming components that collide so that each document's $refs still
+// point at its own definitions.
+//
+// Documents are handled as generic JSON, so that whatever parts of the specification this package
+// doesn't know about pass through untouched.
+package openapi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// A Document is an OpenAPI document, as decoded from JSON.
+type Document map[string]interface{}
+
+// methods are the keys of a Path Item Object that are operations.
+var methods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+func isMethod(key string) bool {
+	for _, method := range methods {
+		if key == method {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse parses an OpenAPI 3 document in either JSON or YAML.
+func Parse(data []byte) (Document, error) {
+	jsonBytes, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("not JSON or YAML: %w", err)
+	}
+	var doc Document
+	if err := json.Unmarshal(jsonBytes, &doc); err != nil {
+		return nil, fmt.Errorf("not an object: %w", err)
+	}
+	if _, ok := doc["swagger"]; ok {
+		return nil, fmt.Errorf("Swagger 2.0 documents aren't supported; convert it to OpenAPI 3")
+	}
+	version, _ := doc["openapi"].(string)
+	if !strings.HasPrefix(version, "3.") {
+		return nil, fmt.Errorf("not an OpenAPI 3 document: openapi is %q", version)
+	}
+	if _, ok := doc["paths"].(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("no paths")
+	}
+	return doc, nil
+}
+
+// A Route is how a Mapping takes requests to its upstream.
+type Route struct {
+	// Prefix is the Mapping's prefix.
+	Prefix string
+	// Rewrite is what the Mapping replaces the prefix with: "" leaves paths alone, and nil is
+	// Ambassador's default of "/".
+	Rewrite *string
+}
+
+// PublicPath returns the path that a client uses to reach upstreamPath through the Route, or false
+// if the Route doesn't go there.
+func (r Route) PublicPath(upstreamPath string) (string, bool) {
+	rewrite := "/"
+	if r.Rewrite != nil {
+		rewrite = *r.Rewrite
+	}
+	if rewrite == "" {
+		return upstreamPath, strings.HasPrefix(upstreamPath, r.Prefix)
+	}
+	if !strings.HasPrefix(upstreamPath, rewrite) {
+		return "", false
+	}
+	return r.Prefix + upstreamPath[len(rewrite):], true
+}
+
+// serverPath returns the path of the first of a list of Server Objects, without a trailing "/".
+func serverPath(servers interface{}) (string, error) {
+	list, _ := servers.([]interface{})
+	if len(list) == 0 {
+		return "", nil
+	}
+	server, _ := list[0].(map[string]interface{})
+	rawURL, _ := server["url"].(string)
+	// Server URLs can have {variables} in them, which url.Parse doesn't care for.
+	if variables, _ := server["variables"].(map[string]interface{}); variables != nil {
+		for name, variable := range variables {
+			variable, _ := variable.(map[string]interface{})
+			def, _ := variable["default"].(string)
+			rawURL = strings.ReplaceAll(rawURL, "{"+name+"}", def)
+		}
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("server %q: %w", rawURL, err)
+	}
+	return strings.TrimSuffix(u.Path, "/"), nil
+}
+
+// deepCopy copies a Document, or any part of one.
+func deepCopy(in interface{}) interface{} {
+	switch in := in.(type) {
+	case Document:
+		return Document(deepCopy(map[string]interface{}(in)).(map[string]interface{}))
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(in))
+		for k, v := range in {
+			out[k] = deepCopy(v)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(in))
+		for i, v := range in {
+			out[i] = deepCopy(v)
+		}
+		return out
+	default:
+		return in
+	}
+}
+
+// Rewrite returns a copy of an upstream's document with its paths as clients see them through the
+// Route, and without its servers. Paths that the Route doesn't go to are left out, with a warning
+// for each.
+func Rewrite(doc Document, route Route) (Document, []string) {
+	out := deepCopy(doc).(Document)
+	var warnings []string
+
+	basePath, err := serverPath(out["servers"])
+	if err != nil {
+		warnings = append(warnings, err.Error())
+	}
+	delete(out, "servers")
+
+	paths, _ := out["paths"].(map[string]interface{})
+	newPaths := make(map[string]interface{}, len(paths))
+	for _, path := range sortedKeys(paths) {
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		itemBase := basePath
+		if servers, ok := item["servers"]; ok {
+			if itemBase, err = serverPath(servers); err != nil {
+				warnings = append(warnings, fmt.Sprintf("%s: %v", path, err))
+				continue
+			}
+			delete(item, "servers")
+		}
+		for key, op := range item {
+			if op, ok := op.(map[string]interface{}); ok && isMethod(key) {
+				delete(op, "servers")
+			}
+		}
+		publicPath, ok := route.PublicPath(itemBase + path)
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("%s isn't reachable through prefix %q", itemBase+path, route.Prefix))
+			continue
+		}
+		newPaths[publicPath] = item
+	}
+	out["paths"] = newPaths
+	return out, warnings
+}
+
+// A Source is one of the documents that go into an aggregate.
+type Source struct {
+	// Name says where the document came from, usually "mapping.namespace". It tells the
+	// document's components apart from other documents' when their names collide.
+	Name     string
+	Document Document
+}
+
+var nonComponentChars = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+
+// Aggregate merges rewritten documents into one, whose info has the given title and whose servers
+// are at the given URLs. Where two documents have the same operation, or the same tag, the one
+// whose Source sorts first wins. Components that collide are renamed after their Source, as are
+// operationIds. Top-level security requirements move onto each document's operations, since they
+// can't stay top-level in the aggregate.
+func Aggregate(title string, servers []string, sources []Source) (Document, []string) {
+	sources = append([]Source(nil), sources...)
+	sort.SliceStable(sources, func(i, j int) bool { return sources[i].Name < sources[j].Name })
+
+	var warnings []string
+	version := "3.0.3"
+	paths := map[string]interface{}{}
+	components := map[string]interface{}{}
+	var tags []interface{}
+	tagNames := map[string]bool{}
+	operationIDs := map[string]bool{}
+	owners := map[string]string{}
+
+	for _, source := range sources {
+		doc := deepCopy(source.Document).(Document)
+		if v, _ := doc["openapi"].(string); strings.HasPrefix(v, "3.1") {
+			version = "3.1.0"
+		}
+		suffix := "_" + nonComponentChars.ReplaceAllString(source.Name, "_")
+
+		// Work out which components need renaming, and point the document's $refs at the new
+		// names, before merging anything.
+		renames := map[string]string{}
+		docComponents, _ := doc["components"].(map[string]interface{})
+		for section, rawDefs := range docComponents {
+			defs, _ := rawDefs.(map[string]interface{})
+			existing, _ := components[section].(map[string]interface{})
+			for name, def := range defs {
+				if prev, ok := existing[name]; ok && !reflect.DeepEqual(prev, def) {
+					renames["#/components/"+section+"/"+name] = "#/components/" + section + "/" + name + suffix
+				}
+			}
+		}
+		if len(renames) > 0 {
+			rewriteRefs(doc, renames)
+		}
+		for section, rawDefs := range docComponents {
+			defs, _ := rawDefs.(map[string]interface{})
+			merged, _ := components[section].(map[string]interface{})
+			if merged == nil {
+				merged = map[string]interface{}{}
+				components[section] = merged
+			}
+			for name, def := range defs {
+				if _, ok := renames["#/components/"+section+"/"+name]; ok {
+					name += suffix
+				}
+				merged[name] = def
+			}
+		}
+
+		security, hasSecurity := doc["security"]
+		docPaths, _ := doc["paths"].(map[string]interface{})
+		for _, path := range sortedKeys(docPaths) {
+			item, _ := docPaths[path].(map[string]interface{})
+			merged, _ := paths[path].(map[string]interface{})
+			if merged == nil {
+				merged = map[string]interface{}{}
+				paths[path] = merged
+			}
+			for key, value := range item {
+				if !isMethod(key) {
+					if _, ok := merged[key]; !ok {
+						merged[key] = value
+					}
+					continue
+				}
+				opKey := strings.ToUpper(key) + " " + path
+				if owner, ok := owners[opKey]; ok {
+					warnings = append(warnings, fmt.Sprintf("%s is in both %s and %s; using %s's", opKey, owner, source.Name, owner))
+					continue
+				}
+				owners[opKey] = source.Name
+				op, _ := value.(map[string]interface{})
+				if op != nil {
+					if _, ok := op["security"]; !ok && hasSecurity {
+						op["security"] = security
+					}
+					if id, ok := op["operationId"].(string); ok {
+						if operationIDs[id] {
+							id += suffix
+							op["operationId"] = id
+						}
+						operationIDs[id] = true
+					}
+				}
+				merged[key] = value
+			}
+		}
+
+		docTags, _ := doc["tags"].([]interface{})
+		for _, tag := range docTags {
+			name, _ := tag.(map[string]interface{})["name"].(string)
+			if !tagNames[name] {
+				tagNames[name] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	out := Document{
+		"openapi": version,
+		"paths":   paths,
+	}
+	if len(components) > 0 {
+		out["components"] = components
+	}
+	if len(tags) > 0 {
+		out["tags"] = tags
+	}
+	serverList := make([]interface{}, 0, len(servers))
+	for _, server := range servers {
+		serverList = append(serverList, map[string]interface{}{"url": server})
+	}
+	if len(serverList) > 0 {
+		out["servers"] = serverList
+	}
+
+	// The version is a digest of the API, so that clients can tell when it changes.
+	digest, _ := json.Marshal(out)
+	sum := sha256.Sum256(digest)
+	out["info"] = map[string]interface{}{
+		"title":   title,
+		"version": hex.EncodeToString(sum[:6]),
+	}
+	return out, warnings
+}
+
+// rewriteRefs points the $refs in part of a document somewhere else.
+func rewriteRefs(in interface{}, renames map[string]string) {
+	switch in := in.(type) {
+	case Document:
+		rewriteRefs(map[string]interface{}(in), renames)
+	case map[string]interface{}:
+		for k, v := range in {
+			if ref, ok := v.(string); ok && k == "$ref" {
+				if renamed, ok := renames[ref]; ok {
+					in[k] = renamed
+				}
+				continue
+			}
+			rewriteRefs(v, renames)
+		}
+	case []interface{}:
+		for _, v := range in {
+			rewriteRefs(v, renames)
+		}
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,170 @@
+package openapi_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/openapi"
+)
+
+func strPtr(s string) *string { return &s }
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+	_, err := openapi.Parse([]byte(`{"openapi": "3.0.0", "info": {}, "paths": {}}`))
+	assert.NoError(t, err)
+	_, err = openapi.Parse([]byte("openapi: 3.1.0\npaths:\n  /foo: {}\n"))
+	assert.NoError(t, err)
+	_, err = openapi.Parse([]byte(`{"swagger": "2.0", "paths": {}}`))
+	assert.EqualError(t, err, "Swagger 2.0 documents aren't supported; convert it to OpenAPI 3")
+	_, err = openapi.Parse([]byte(`openapi: 4.0.0`))
+	assert.EqualError(t, err, `not an OpenAPI 3 document: openapi is "4.0.0"`)
+	_, err = openapi.Parse([]byte(`[1, 2]`))
+	assert.Error(t, err)
+}
+
+func TestPublicPath(t *testing.T) {
+	t.Parallel()
+	testcases := []struct {
+		Route    openapi.Route
+		Upstream string
+		Public   string
+		OK       bool
+	}{
+		{openapi.Route{Prefix: "/quote/"}, "/health", "/quote/health", true},
+		{openapi.Route{Prefix: "/quote/", Rewrite: strPtr("/v1/")}, "/v1/quotes", "/quote/quotes", true},
+		{openapi.Route{Prefix: "/quote/", Rewrite: strPtr("/v1/")}, "/v2/quotes", "", false},
+		{openapi.Route{Prefix: "/quote/", Rewrite: strPtr("")}, "/quote/all", "/quote/all", true},
+		{openapi.Route{Prefix: "/quote/", Rewrite: strPtr("")}, "/other", "/other", false},
+	}
+	for _, tc := range testcases {
+		public, ok := tc.Route.PublicPath(tc.Upstream)
+		assert.Equal(t, tc.OK, ok, tc.Upstream)
+		if tc.OK {
+			assert.Equal(t, tc.Public, public, tc.Upstream)
+		}
+	}
+}
+
+const quoteSpec = `
+openapi: 3.0.3
+info: {title: quote, version: "1"}
+servers:
+- url: "{scheme}://quote.example.com/api/"
+  variables:
+    scheme: {default: https}
+security:
+- apiKey: []
+tags:
+- name: quotes
+paths:
+  /quotes:
+    get:
+      operationId: list
+      summary: List quotes
+      tags: [quotes]
+      responses:
+        "200":
+          content:
+            application/json:
+              schema: {$ref: "#/components/schemas/Quote"}
+  /internal:
+    servers:
+    - url: /admin
+    post:
+      operationId: reset
+components:
+  schemas:
+    Quote: {type: string}
+  securitySchemes:
+    apiKey: {type: apiKey, in: header, name: X-Key}
+`
+
+const userSpec = `
+openapi: 3.0.3
+info: {title: users, version: "1"}
+tags:
+- name: users
+- name: quotes
+paths:
+  /quotes:
+    get:
+      operationId: list
+  /users:
+    get:
+      operationId: list
+      responses:
+        "200":
+          content:
+            application/json:
+              schema: {$ref: "#/components/schemas/Quote"}
+components:
+  schemas:
+    Quote: {type: object}
+`
+
+func TestRewriteAndAggregate(t *testing.T) {
+	t.Parallel()
+	quote, err := openapi.Parse([]byte(quoteSpec))
+	require.NoError(t, err)
+	user, err := openapi.Parse([]byte(userSpec))
+	require.NoError(t, err)
+
+	rewrittenQuote, warnings := openapi.Rewrite(quote, openapi.Route{Prefix: "/q/", Rewrite: strPtr("/api/")})
+	assert.Equal(t, []string{`/admin/internal isn't reachable through prefix "/q/"`}, warnings)
+	assert.Nil(t, rewrittenQuote["servers"])
+	assert.Contains(t, rewrittenQuote["paths"], "/q/quotes")
+	// The original is left alone.
+	assert.Contains(t, quote["paths"], "/internal")
+	assert.NotNil(t, quote["servers"])
+
+	rewrittenUser, warnings := openapi.Rewrite(user, openapi.Route{Prefix: "/u/"})
+	assert.Empty(t, warnings)
+
+	doc, warnings := openapi.Aggregate("example.com", []string{"https://example.com"}, []openapi.Source{
+		{Name: "users.default", Document: rewrittenUser},
+		{Name: "quote.default", Document: rewrittenQuote},
+	})
+	assert.Empty(t, warnings)
+	assert.Equal(t, "example.com", doc["info"].(map[string]interface{})["title"])
+	assert.NotEmpty(t, doc["info"].(map[string]interface{})["version"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"url": "https://example.com"}}, doc["servers"])
+
+	paths := doc["paths"].(map[string]interface{})
+	assert.Len(t, paths, 3)
+
+	// quote.default sorts first, so it keeps its names.
+	quotes := paths["/q/quotes"].(map[string]interface{})["get"].(map[string]interface{})
+	assert.Equal(t, "list", quotes["operationId"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"apiKey": []interface{}{}}}, quotes["security"])
+	users := paths["/u/users"].(map[string]interface{})["get"].(map[string]interface{})
+	assert.Equal(t, "list_users.default", users["operationId"])
+	assert.Nil(t, users["security"])
+	assert.Equal(t, "#/components/schemas/Quote_users.default",
+		users["responses"].(map[string]interface{})["200"].(map[string]interface{})["content"].(map[string]interface{})["application/json"].(map[string]interface{})["schema"].(map[string]interface{})["$ref"])
+
+	schemas := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"type": "string"}, schemas["Quote"])
+	assert.Equal(t, map[string]interface{}{"type": "object"}, schemas["Quote_users.default"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"name": "quotes"},
+		map[string]interface{}{"name": "users"},
+	}, doc["tags"])
+
+	// The same operation from two places goes to the first.
+	_, warnings = openapi.Aggregate("example.com", nil, []openapi.Source{
+		{Name: "b", Document: rewrittenQuote},
+		{Name: "a", Document: rewrittenQuote},
+	})
+	assert.Equal(t, []string{"GET /q/quotes is in both a and b; using a's"}, warnings)
+
+	var buf bytes.Buffer
+	require.NoError(t, openapi.WriteHTML(&buf, doc, "openapi.json"))
+	assert.Contains(t, buf.String(), "<title>example.com</title>")
+	assert.Contains(t, buf.String(), "/q/quotes")
+	assert.Contains(t, buf.String(), "List quotes")
+	assert.Contains(t, buf.String(), `href="openapi.json"`)
+}
@@ -0,0 +1,247 @@
+// Package resolverplugin runs resolver plugins and talks to them.
+//
+// A resolver plugin is an executable that sources endpoints from a service registry that Ambassador
+// doesn't know how to talk to itself, and serves them over the Resolver gRPC service (see
+// api/resolverplugin/resolver.proto) on a Unix socket. Supervise runs the plugins, restarting any
+// that exit; Watch streams endpoints from one of them; and Serve is for plugins written in Go.
+package resolverplugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/datawire/dlib/dexec"
+	"github.com/datawire/dlib/dgroup"
+	"github.com/datawire/dlib/dlog"
+	rpb "github.com/emissary-ingress/emissary/v3/pkg/api/resolverplugin"
+)
+
+// SocketEnv is the environment variable that tells a plugin where to serve the Resolver service.
+const SocketEnv = "AMBASSADOR_RESOLVER_PLUGIN_SOCKET"
+
+// How long to wait before restarting a plugin, or retrying a Watch. The wait doubles each time, up
+// to maxBackoff, and goes back to minBackoff once things have been working for resetBackoff.
+var (
+	minBackoff   = 1 * time.Second
+	maxBackoff   = 30 * time.Second
+	resetBackoff = 1 * time.Minute
+)
+
+// Endpoints is the latest set of endpoints that a plugin has sent for a service.
+type Endpoints struct {
+	Resolver  string     `json:""`
+	Service   string     `json:""`
+	Endpoints []Endpoint `json:""`
+}
+
+// Endpoint is a single endpoint of a service. The Address is an IP address or a DNS name.
+type Endpoint struct {
+	Address string `json:""`
+	Port    uint32 `json:""`
+}
+
+// Socket returns the path of the socket that the named plugin serves on.
+func Socket(socketDir, plugin string) string {
+	return filepath.Join(socketDir, plugin+".sock")
+}
+
+// Plugins returns the names of the plugins in dir: every executable regular file whose name
+// doesn't start with a dot. A missing dir just means that there are no plugins.
+func Plugins(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var plugins []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if info.Mode().IsRegular() && info.Mode().Perm()&0o111 != 0 {
+			plugins = append(plugins, entry.Name())
+		}
+	}
+	return plugins, nil
+}
+
+// Supervise runs every plugin in dir until ctx is canceled, telling each to serve on its Socket in
+// socketDir. A plugin that exits, for whatever reason, is restarted after a backoff.
+func Supervise(ctx context.Context, dir, socketDir string) error {
+	plugins, err := Plugins(dir)
+	if err != nil {
+		return fmt.Errorf("resolver plugins: %w", err)
+	}
+	if len(plugins) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(socketDir, 0o755); err != nil {
+		return fmt.Errorf("resolver plugins: %w", err)
+	}
+
+	grp := dgroup.NewGroup(ctx, dgroup.GroupConfig{})
+	for _, plugin := range plugins {
+		plugin := plugin
+		grp.Go(plugin, func(ctx context.Context) error {
+			supervise(ctx, filepath.Join(dir, plugin), Socket(socketDir, plugin))
+			return nil
+		})
+	}
+	return grp.Wait()
+}
+
+func supervise(ctx context.Context, path, socket string) {
+	backoff := minBackoff
+	for {
+		// A plugin that crashed will have left its socket behind, and a fresh one won't be able to
+		// listen on it.
+		_ = os.Remove(socket)
+
+		started := time.Now()
+		cmd := dexec.CommandContext(ctx, path)
+		cmd.Env = append(os.Environ(), SocketEnv+"="+socket)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		err := cmd.Run()
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Since(started) > resetBackoff {
+			backoff = minBackoff
+		}
+		dlog.Errorf(ctx, "resolver plugin %s exited (%v); restarting in %v", path, err, backoff)
+		if !sleep(ctx, backoff) {
+			return
+		}
+		backoff = next(backoff)
+	}
+}
+
+// Watch streams endpoints for the services in req from the plugin serving on socket, sending each
+// update to out, until ctx is canceled. If the plugin isn't running (yet, or any more), Watch keeps
+// trying. It only returns once ctx is canceled.
+func Watch(ctx context.Context, socket string, req *rpb.WatchRequest, out chan<- Endpoints) {
+	conn, err := grpc.DialContext(ctx, "unix:"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		// This can only fail on bad options, which is a bug, not a plugin problem.
+		panic(err)
+	}
+	defer conn.Close()
+	client := rpb.NewResolverClient(conn)
+
+	backoff := minBackoff
+	for {
+		started := time.Now()
+		err := watch(ctx, client, req, out)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Since(started) > resetBackoff {
+			backoff = minBackoff
+		}
+		dlog.Warnf(ctx, "resolver plugin %s: watching %s for %s: %v; retrying in %v",
+			socket, strings.Join(req.Services, ", "), req.Resolver, err, backoff)
+		if !sleep(ctx, backoff) {
+			return
+		}
+		backoff = next(backoff)
+	}
+}
+
+func watch(ctx context.Context, client rpb.ResolverClient, req *rpb.WatchRequest, out chan<- Endpoints) error {
+	// Wait for the plugin to come up, rather than failing right away, so that we don't log an error
+	// every time Ambassador starts.
+	stream, err := client.Watch(ctx, req, grpc.WaitForReady(true))
+	if err != nil {
+		return err
+	}
+
+	for {
+		set, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+
+		update := Endpoints{Resolver: req.Resolver, Service: set.Service}
+		for _, ep := range set.Endpoints {
+			update.Endpoints = append(update.Endpoints, Endpoint{Address: ep.Address, Port: ep.Port})
+		}
+
+		select {
+		case out <- update:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Serve serves srv on the socket that Ambassador told the plugin to use, until ctx is canceled. It's
+// for plugins written in Go:
+//
+//	func main() {
+//		if err := resolverplugin.Serve(ctx, &myResolver{}); err != nil {
+//			...
+//		}
+//	}
+func Serve(ctx context.Context, srv rpb.ResolverServer) error {
+	socket := os.Getenv(SocketEnv)
+	if socket == "" {
+		return fmt.Errorf("%s is not set; resolver plugins must be run by Ambassador", SocketEnv)
+	}
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+
+	server := grpc.NewServer()
+	rpb.RegisterResolverServer(server, srv)
+
+	go func() {
+		<-ctx.Done()
+		server.GracefulStop()
+	}()
+
+	if err := server.Serve(listener); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		return err
+	}
+	return nil
+}
+
+func next(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
+
+// sleep waits for d, and returns false if ctx was canceled first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
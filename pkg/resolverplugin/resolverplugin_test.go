@@ -0,0 +1,113 @@
+package resolverplugin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	rpb "github.com/emissary-ingress/emissary/v3/pkg/api/resolverplugin"
+)
+
+type fakeResolver struct {
+	rpb.UnimplementedResolverServer
+	requests chan *rpb.WatchRequest
+}
+
+func (f *fakeResolver) Watch(req *rpb.WatchRequest, stream rpb.Resolver_WatchServer) error {
+	f.requests <- req
+	for _, svc := range req.Services {
+		err := stream.Send(&rpb.EndpointSet{
+			Service:   svc,
+			Endpoints: []*rpb.Endpoint{{Address: "10.0.0.1", Port: 8080}},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	<-stream.Context().Done()
+	return nil
+}
+
+func TestPlugins(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "eureka"), []byte("#!/bin/sh\n"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README"), []byte("not a plugin"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".hidden"), []byte("#!/bin/sh\n"), 0o755))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "subdir"), 0o755))
+
+	plugins, err := Plugins(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"eureka"}, plugins)
+
+	plugins, err = Plugins(filepath.Join(dir, "missing"))
+	require.NoError(t, err)
+	assert.Empty(t, plugins)
+}
+
+// Tests that Watch waits for a plugin that isn't up yet, and then streams its endpoints.
+func TestWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	socket := Socket(t.TempDir(), "fake")
+	out := make(chan Endpoints)
+	req := &rpb.WatchRequest{Resolver: "registry", Config: map[string]string{"url": "http://registry"}, Services: []string{"billing", "orders"}}
+	go Watch(ctx, socket, req, out)
+
+	// Give Watch a chance to find that there's nothing there.
+	time.Sleep(100 * time.Millisecond)
+
+	srv := &fakeResolver{requests: make(chan *rpb.WatchRequest, 1)}
+	t.Setenv(SocketEnv, socket)
+	go func() {
+		assert.NoError(t, Serve(ctx, srv))
+	}()
+
+	got := <-srv.requests
+	assert.Equal(t, "registry", got.Resolver)
+	assert.Equal(t, "http://registry", got.Config["url"])
+
+	for _, svc := range req.Services {
+		select {
+		case update := <-out:
+			assert.Equal(t, "registry", update.Resolver)
+			assert.Equal(t, svc, update.Service)
+			assert.Equal(t, []Endpoint{{Address: "10.0.0.1", Port: 8080}}, update.Endpoints)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out waiting for endpoints for %s", svc)
+		}
+	}
+}
+
+// Tests that Supervise restarts plugins that exit.
+func TestSupervise(t *testing.T) {
+	minBackoff = 10 * time.Millisecond
+	defer func() { minBackoff = 1 * time.Second }()
+
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	dir := t.TempDir()
+	runs := filepath.Join(t.TempDir(), "runs")
+	script := "#!/bin/sh\necho \"$" + SocketEnv + "\" >> " + runs + "\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "flaky"), []byte(script), 0o755))
+
+	socketDir := t.TempDir()
+	done := make(chan error)
+	go func() { done <- Supervise(ctx, dir, socketDir) }()
+
+	assert.Eventually(t, func() bool {
+		content, _ := os.ReadFile(runs)
+		return strings.Count(string(content), Socket(socketDir, "flaky")+"\n") >= 3
+	}, 10*time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.NoError(t, <-done)
+}
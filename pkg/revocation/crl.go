@@ -0,0 +1,43 @@
+package revocation
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"time"
+)
+
+// A CRL is a certificate revocation list, PEM-encoded, since that's what Envoy wants.
+type CRL struct {
+	PEM        []byte
+	ThisUpdate time.Time
+	NextUpdate time.Time
+}
+
+// FetchCRL fetches the CRL at url, which can be either DER (as CRL distribution points serve them)
+// or PEM.
+func FetchCRL(ctx context.Context, client *http.Client, url string) (*CRL, error) {
+	body, err := get(ctx, client, url)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCRL(body)
+}
+
+// ParseCRL parses a DER or PEM CRL.
+func ParseCRL(data []byte) (*CRL, error) {
+	der := data
+	if block, _ := pem.Decode(data); block != nil && block.Type == "X509 CRL" {
+		der = block.Bytes
+	}
+	list, err := x509.ParseRevocationList(der)
+	if err != nil {
+		return nil, err
+	}
+	return &CRL{
+		PEM:        pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}),
+		ThisUpdate: list.ThisUpdate,
+		NextUpdate: list.NextUpdate,
+	}, nil
+}
@@ -0,0 +1,107 @@
+package revocation
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// A Staple is a DER-encoded OCSP response that says a certificate is good, to staple to it.
+type Staple struct {
+	DER        []byte
+	ThisUpdate time.Time
+	NextUpdate time.Time
+}
+
+// FetchStaple asks the OCSP responders of the first certificate in a PEM chain whether it's good,
+// and returns the first answer. The chain has to include the certificate's issuer, right after it.
+//
+// A revoked certificate is an error, not a staple: there's no point in serving it.
+func FetchStaple(ctx context.Context, client *http.Client, chainPEM []byte) (*Staple, error) {
+	chain, err := parseChain(chainPEM)
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) < 2 {
+		return nil, errors.New("the certificate chain doesn't include the certificate's issuer")
+	}
+	leaf, issuer := chain[0], chain[1]
+	if len(leaf.OCSPServer) == 0 {
+		return nil, errors.New("the certificate doesn't name an OCSP responder")
+	}
+
+	ocspReq, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, url := range leaf.OCSPServer {
+		staple, err := fetchStaple(ctx, client, url, ocspReq, leaf, issuer)
+		if err == nil {
+			return staple, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", url, err))
+	}
+	return nil, errors.Join(errs...)
+}
+
+func fetchStaple(ctx context.Context, client *http.Client, url string, ocspReq []byte, leaf, issuer *x509.Certificate) (*Staple, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(ocspReq))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	req.Header.Set("Accept", "application/ocsp-response")
+	der, err := do(client, req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := ocsp.ParseResponseForCert(der, leaf, issuer)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.Status {
+	case ocsp.Good:
+	case ocsp.Revoked:
+		return nil, fmt.Errorf("the certificate was revoked at %s", resp.RevokedAt.Format(time.RFC3339))
+	default:
+		return nil, errors.New("the responder doesn't know the certificate")
+	}
+	return &Staple{
+		DER:        der,
+		ThisUpdate: resp.ThisUpdate,
+		NextUpdate: resp.NextUpdate,
+	}, nil
+}
+
+func parseChain(chainPEM []byte) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+	for rest := chainPEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("no PEM certificates")
+	}
+	return chain, nil
+}
@@ -0,0 +1,71 @@
+// Package revocation fetches certificate revocation information: OCSP responses to staple to the
+// certificates we serve, and CRLs to check client certificates against. It only fetches; deciding
+// what to fetch, and when, is up to the caller, with RefreshAt to help.
+package revocation
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultRefreshInterval is how long to wait before fetching again something that doesn't say
+	// when it'll next be updated.
+	DefaultRefreshInterval = time.Hour
+	// MinRefreshInterval is the shortest time to wait before fetching something again, however
+	// soon it says it'll be updated.
+	MinRefreshInterval = time.Minute
+
+	// maxResponseSize limits how much of an OCSP response or CRL we'll read. Big CRLs do exist,
+	// but not this big.
+	maxResponseSize = 32 << 20
+)
+
+// RefreshAt returns when to fetch something again that was issued at thisUpdate, and that will
+// next be updated at nextUpdate: halfway between the two, so that there's plenty of time to retry
+// if the fetch fails.
+func RefreshAt(thisUpdate, nextUpdate, now time.Time) time.Time {
+	if nextUpdate.IsZero() {
+		return now.Add(DefaultRefreshInterval)
+	}
+	at := thisUpdate.Add(nextUpdate.Sub(thisUpdate) / 2)
+	if earliest := now.Add(MinRefreshInterval); at.Before(earliest) {
+		at = earliest
+	}
+	return at
+}
+
+// do sends an HTTP request, and returns the body of a 200 response.
+func do(client *http.Client, req *http.Request) ([]byte, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: %s", req.Method, req.URL, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxResponseSize {
+		return nil, fmt.Errorf("%s %s: response is more than %d bytes", req.Method, req.URL, maxResponseSize)
+	}
+	return body, nil
+}
+
+// get is do for a GET.
+func get(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return do(client, req)
+}
@@ -0,0 +1,178 @@
+package revocation_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/revocation"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) issue(t *testing.T, serial int64, ocspServer string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "leaf"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		OCSPServer:   []string{ocspServer},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, key.Public(), ca.key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert
+}
+
+func chainPEM(certs ...*x509.Certificate) []byte {
+	var out []byte
+	for _, cert := range certs {
+		out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return out
+}
+
+func TestFetchStaple(t *testing.T) {
+	ca := newTestCA(t)
+	thisUpdate := time.Now().Add(-time.Minute).Truncate(time.Second).UTC()
+	nextUpdate := thisUpdate.Add(4 * time.Hour)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		req, err := ocsp.ParseRequest(body)
+		require.NoError(t, err)
+		status := ocsp.Good
+		if req.SerialNumber.Int64() == 3 {
+			status = ocsp.Revoked
+		}
+		resp, err := ocsp.CreateResponse(ca.cert, ca.cert, ocsp.Response{
+			Status:       status,
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   thisUpdate,
+			NextUpdate:   nextUpdate,
+			RevokedAt:    thisUpdate,
+		}, ca.key)
+		require.NoError(t, err)
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		_, _ = w.Write(resp)
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	good := ca.issue(t, 2, srv.URL)
+	staple, err := revocation.FetchStaple(ctx, srv.Client(), chainPEM(good, ca.cert))
+	require.NoError(t, err)
+	assert.Equal(t, thisUpdate, staple.ThisUpdate)
+	assert.Equal(t, nextUpdate, staple.NextUpdate)
+	resp, err := ocsp.ParseResponseForCert(staple.DER, good, ca.cert)
+	require.NoError(t, err)
+	assert.Equal(t, ocsp.Good, resp.Status)
+
+	revoked := ca.issue(t, 3, srv.URL)
+	_, err = revocation.FetchStaple(ctx, srv.Client(), chainPEM(revoked, ca.cert))
+	assert.ErrorContains(t, err, "revoked")
+
+	// Without the issuer, there's no way to ask.
+	_, err = revocation.FetchStaple(ctx, srv.Client(), chainPEM(good))
+	assert.Error(t, err)
+}
+
+func TestFetchCRL(t *testing.T) {
+	ca := newTestCA(t)
+	thisUpdate := time.Now().Add(-time.Minute).Truncate(time.Second).UTC()
+	nextUpdate := thisUpdate.Add(24 * time.Hour)
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: thisUpdate,
+		NextUpdate: nextUpdate,
+		RevokedCertificates: []pkix.RevokedCertificate{
+			{SerialNumber: big.NewInt(3), RevocationTime: thisUpdate},
+		},
+	}, ca.cert, ca.key)
+	require.NoError(t, err)
+
+	for name, body := range map[string][]byte{
+		"der": der,
+		"pem": pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}),
+	} {
+		body := body
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write(body)
+			}))
+			defer srv.Close()
+
+			crl, err := revocation.FetchCRL(context.Background(), srv.Client(), srv.URL)
+			require.NoError(t, err)
+			assert.Equal(t, thisUpdate, crl.ThisUpdate)
+			assert.Equal(t, nextUpdate, crl.NextUpdate)
+			block, _ := pem.Decode(crl.PEM)
+			require.NotNil(t, block)
+			assert.Equal(t, "X509 CRL", block.Type)
+			assert.Equal(t, der, block.Bytes)
+		})
+	}
+
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	_, err = revocation.FetchCRL(context.Background(), srv.Client(), srv.URL)
+	assert.ErrorContains(t, err, "404")
+}
+
+func TestRefreshAt(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, now.Add(revocation.DefaultRefreshInterval),
+		revocation.RefreshAt(now, time.Time{}, now))
+	assert.Equal(t, now.Add(2*time.Hour),
+		revocation.RefreshAt(now, now.Add(4*time.Hour), now))
+	// Never sooner than a minute from now, however stale it is.
+	assert.Equal(t, now.Add(revocation.MinRefreshInterval),
+		revocation.RefreshAt(now.Add(-2*time.Hour), now.Add(-time.Hour), now))
+}
@@ -71,6 +71,13 @@ func ValidateAndConvertObject(
 	ctx context.Context,
 	in *kates.Unstructured,
 ) (out kates.Object, err error) {
+	// Anything that crashes validation or conversion is just invalid.
+	defer func() {
+		if perr := derror.PanicToError(recover()); perr != nil {
+			out, err = nil, perr
+		}
+	}()
+
 	// Validate it
 	gvk := in.GetObjectKind().GroupVersionKind()
 	if !scheme.Recognizes(gvk) {
@@ -137,7 +144,15 @@ func convertAnnotationObject(srcUnstruct *kates.Unstructured) (kates.Object, err
 //
 // You should probably not be calling this directly; the only reason it's public is for use by
 // tests.
-func ParseAnnotationResources(resource kates.Object) ([]*kates.Unstructured, error) {
+func ParseAnnotationResources(resource kates.Object) (_ []*kates.Unstructured, err error) {
+	// The annotation is arbitrary YAML: whatever we can't make sense of (even if it crashes the
+	// parser) is an error.
+	defer func() {
+		if perr := derror.PanicToError(recover()); perr != nil {
+			err = fmt.Errorf("annotation getambassador.io/config: %w", perr)
+		}
+	}()
+
 	annotationStr, annotationStrOK := resource.GetAnnotations()["getambassador.io/config"]
 	if !annotationStrOK {
 		return nil, nil
@@ -418,3 +418,58 @@ config:
 		})
 	}
 }
+
+// FuzzAnnotations feeds arbitrary getambassador.io/config annotations through parsing, validation,
+// and conversion. Anything that doesn't make sense has to come back as an error: a panic, even
+// one that gets caught, is a bug.
+func FuzzAnnotations(f *testing.F) {
+	f.Add(`
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+name: quote-backend
+prefix: /backend/
+service: quote:80
+`)
+	f.Add(`
+---
+apiVersion: getambassador.io/v2
+kind: Module
+name: ambassador
+config:
+  diagnostics:
+    enabled: true
+`)
+	f.Add(`
+---
+apiVersion: ambassador/v1
+kind: Mapping
+name: legacy
+prefix: /legacy/
+service: legacy
+metadata_labels:
+  app: legacy
+`)
+	f.Fuzz(func(t *testing.T, annotation string) {
+		ctx := dlog.NewTestContext(t, false)
+		svc := &kates.Service{
+			TypeMeta: metav1.TypeMeta{Kind: "Service"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "svc",
+				Namespace:   "ambassador",
+				Annotations: map[string]string{"getambassador.io/config": annotation},
+			},
+		}
+
+		objs, err := snapshotTypes.ParseAnnotationResources(svc)
+		if err != nil {
+			assert.NotContains(t, err.Error(), "PANIC")
+			return
+		}
+		for _, obj := range objs {
+			if _, err := snapshotTypes.ValidateAndConvertObject(ctx, obj); err != nil {
+				assert.NotContains(t, err.Error(), "PANIC")
+			}
+		}
+	})
+}
@@ -39,7 +39,18 @@ type Snapshot struct {
 	// The Invalid field contains any kubernetes resources that have failed
 	// validation.
 	Invalid []*kates.Unstructured
-	Raw     json.RawMessage `json:"-"`
+	// The ModuleErrors field contains the problems with the config of each
+	// Module that's still being used (unknown fields, mostly), by
+	// name.namespace. diagd reports them as errors on the Module.
+	ModuleErrors map[string][]string `json:"ModuleErrors,omitempty"`
+	// The RouteOverlaps field contains the HTTPRoute matches that duplicate a
+	// Mapping's route. diagd reports them as errors on the Mapping.
+	RouteOverlaps []RouteOverlap `json:"RouteOverlaps,omitempty"`
+	// The Resync field is the generation of the resync that this snapshot
+	// finishes, if any. It tells diagd to rebuild from scratch rather than
+	// trusting its caches.
+	Resync uint64          `json:"Resync,omitempty"`
+	Raw    json.RawMessage `json:"-"`
 }
 
 type AmbassadorMetaInfo struct {
@@ -61,6 +72,12 @@ type KubernetesSnapshot struct {
 	Services       []*kates.Service   `json:"service"`
 	Endpoints      []*kates.Endpoints `json:"Endpoints"`
 
+	// EndpointSlices and SubsetPods are only watched for zone-aware routing and subset routing,
+	// for the zone of each endpoint and the labels of the Pod behind it. Neither goes in the JSON:
+	// Pods carry their containers' env, which can hold secrets. (The Agent's Pods are below.)
+	EndpointSlices []*kates.EndpointSlice `json:"-"`
+	SubsetPods     []*kates.Pod           `json:"-"`
+
 	// ambassador resources
 	Listeners   []*amb.Listener   `json:"Listener"`
 	Hosts       []*amb.Host       `json:"Host"`
@@ -69,6 +86,16 @@ type KubernetesSnapshot struct {
 	Modules     []*amb.Module     `json:"Module"`
 	TLSContexts []*amb.TLSContext `json:"TLSContext"`
 
+	// UpstreamTLSPolicies are resolved by the watcher into TLSContexts on the Mappings they
+	// select, so they're only here for visibility.
+	UpstreamTLSPolicies []*amb.UpstreamTLSPolicy `json:"UpstreamTLSPolicy,omitempty"`
+
+	// EgressPolicies set up egress listeners, which have nothing to do with Hosts or Mappings.
+	EgressPolicies []*amb.EgressPolicy `json:"EgressPolicy,omitempty"`
+
+	// Redirects answer for Hosts without any Mappings.
+	Redirects []*amb.Redirect `json:"Redirect,omitempty"`
+
 	// plugin services
 	AuthServices      []*amb.AuthService      `json:"AuthService"`
 	RateLimitServices []*amb.RateLimitService `json:"RateLimitService"`
@@ -80,6 +107,7 @@ type KubernetesSnapshot struct {
 	ConsulResolvers             []*amb.ConsulResolver             `json:"ConsulResolver"`
 	KubernetesEndpointResolvers []*amb.KubernetesEndpointResolver `json:"KubernetesEndpointResolver"`
 	KubernetesServiceResolvers  []*amb.KubernetesServiceResolver  `json:"KubernetesServiceResolver"`
+	PluginResolvers             []*amb.PluginResolver             `json:"PluginResolver"`
 
 	// gateway api
 	GatewayClasses []*gw.GatewayClass
@@ -92,6 +120,9 @@ type KubernetesSnapshot struct {
 	KNativeClusterIngresses []*kates.Unstructured `json:"clusteringresses.networking.internal.knative.dev,omitempty"`
 	KNativeIngresses        []*kates.Unstructured `json:"ingresses.networking.internal.knative.dev,omitempty"`
 
+	// Istio DestinationRules are only used to sanity-check Mappings to meshed services.
+	IstioDestinationRules []*kates.Unstructured `json:"destinationrules.networking.istio.io,omitempty"`
+
 	FilterPolicies []*kates.Unstructured `json:"filterpolicies.v3alpha1.getambassador.io,omitempty"`
 	Filters        []*kates.Unstructured `json:"filters.v3alpha1.getambassador.io,omitempty"`
 
@@ -101,6 +132,18 @@ type KubernetesSnapshot struct {
 
 	ConfigMaps []*kates.ConfigMap `json:"ConfigMaps,omitempty"`
 
+	// GRPCDescriptors are the ConfigMaps labeled getambassador.io/grpc-descriptors, which hold
+	// the protobuf descriptors that Mappings use for gRPC-JSON transcoding.
+	GRPCDescriptors []*kates.ConfigMap `json:"GRPCDescriptors,omitempty"`
+
+	// OpenAPIDocuments are the ConfigMaps labeled getambassador.io/openapi, which hold the
+	// OpenAPI documents of Mappings whose services don't serve their own.
+	OpenAPIDocuments []*kates.ConfigMap `json:"OpenAPIDocuments,omitempty"`
+
+	// MaintenancePages are the ConfigMaps labeled getambassador.io/maintenance-page, which hold
+	// the bodies of the static responses that Mappings and Hosts in maintenance answer with.
+	MaintenancePages []*kates.ConfigMap `json:"MaintenancePages,omitempty"`
+
 	// [kind/name.namespace][]kates.Object
 	Annotations map[string]AnnotationList `json:"annotations"`
 
@@ -163,6 +206,18 @@ func (al *AnnotationList) UnmarshalJSON(bs []byte) error {
 	return nil
 }
 
+// A RouteOverlap is an HTTPRoute match that routes the same requests as a
+// Mapping. The Mapping takes precedence: the match is dropped from the
+// HTTPRoute, or, in strict mode, the whole HTTPRoute is rejected.
+type RouteOverlap struct {
+	HTTPRoute string `json:"httpRoute"` // name.namespace
+	Rule      int    `json:"rule"`
+	Match     int    `json:"match"`
+	Mapping   string `json:"mapping"` // name.namespace
+	Route     string `json:"route"`   // hostname and path
+	Rejected  bool   `json:"rejected"`
+}
+
 // The APIDoc type is custom object built in the style of a Kubernetes resource (name, type, version)
 // which holds a reference to a Kubernetes object from which an OpenAPI document was scrapped (Data field)
 type APIDoc struct {
@@ -0,0 +1,325 @@
+// Package spiffe is a minimal client for the SPIFFE Workload API, which is how a workload gets its
+// X.509 identity (its SVID) and the CA bundles it needs to verify other workloads' identities
+// from a SPIFFE implementation such as SPIRE.
+//
+// We only need one call, FetchX509SVID, so rather than pull in all of go-spiffe (and its
+// generated protobufs) we speak the protocol directly: the request is empty, and the responses
+// are simple enough to decode field-by-field. See
+// https://github.com/spiffe/spiffe/blob/main/standards/SPIFFE_Workload_API.md.
+package spiffe
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+const (
+	fetchX509SVIDMethod = "/SpiffeWorkloadAPI/FetchX509SVID"
+
+	// Every Workload API request has to carry this header, to prove that it isn't a
+	// request that's been forwarded from somewhere else.
+	securityHeader = "workload.spiffe.io"
+
+	minBackoff = 1 * time.Second
+	maxBackoff = 30 * time.Second
+)
+
+// X509SVID is a single X.509 SPIFFE identity.
+type X509SVID struct {
+	// ID is the SPIFFE ID, e.g. "spiffe://example.org/ns/default/sa/emissary".
+	ID string
+	// TrustDomain is the trust domain from the ID, e.g. "example.org".
+	TrustDomain string
+	// CertChainPEM is the leaf certificate followed by any intermediates.
+	CertChainPEM []byte
+	// KeyPEM is the PKCS#8 private key for the leaf certificate.
+	KeyPEM []byte
+	// Hint is an optional operator-supplied hint for choosing between several SVIDs.
+	Hint string
+}
+
+// X509Context is everything the Workload API has told us about our X.509 identities.
+type X509Context struct {
+	// SVIDs are our identities. The first one is the default.
+	SVIDs []*X509SVID
+	// Bundles maps trust domains to their PEM-encoded CA bundles. This includes our own
+	// trust domain(s), and any federated trust domains.
+	Bundles map[string][]byte
+}
+
+// SVID returns the SVID with the given SPIFFE ID, or the default SVID if id is "".
+func (x *X509Context) SVID(id string) (*X509SVID, bool) {
+	for _, svid := range x.SVIDs {
+		if id == "" || svid.ID == id {
+			return svid, true
+		}
+	}
+	return nil, false
+}
+
+// WatchX509Context streams X509Contexts from the Workload API at the given socket to handler,
+// reconnecting (with backoff) whenever the stream breaks, until ctx is canceled. The socket may
+// be given either as a path or as a "unix:" URL, as in the SPIFFE_ENDPOINT_SOCKET convention.
+func WatchX509Context(ctx context.Context, socket string, handler func(context.Context, *X509Context)) error {
+	backoff := minBackoff
+	for {
+		err := streamX509Context(ctx, socket, func(ctx context.Context, x509Context *X509Context) {
+			backoff = minBackoff
+			handler(ctx, x509Context)
+		})
+		if ctx.Err() != nil {
+			return nil
+		}
+		dlog.Errorf(ctx, "SPIFFE: Workload API stream from %s failed, retrying in %v: %v", socket, backoff, err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func streamX509Context(ctx context.Context, socket string, handler func(context.Context, *X509Context)) error {
+	conn, err := grpc.DialContext(ctx, socketTarget(socket),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := conn.NewStream(
+		metadata.AppendToOutgoingContext(ctx, securityHeader, "true"),
+		&grpc.StreamDesc{StreamName: "FetchX509SVID", ServerStreams: true},
+		fetchX509SVIDMethod,
+		grpc.ForceCodec(rawCodec{}))
+	if err != nil {
+		return err
+	}
+	// X509SVIDRequest has no fields, so it's the empty message.
+	if err := stream.SendMsg(&[]byte{}); err != nil {
+		return err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+
+	for {
+		var msg []byte
+		if err := stream.RecvMsg(&msg); err != nil {
+			return err
+		}
+		x509Context, err := ParseX509SVIDResponse(msg)
+		if err != nil {
+			// A bad response doesn't mean the next one will be bad too.
+			dlog.Errorf(ctx, "SPIFFE: ignoring malformed X509SVIDResponse: %v", err)
+			continue
+		}
+		handler(ctx, x509Context)
+	}
+}
+
+// socketTarget turns a socket path or URL into a gRPC dial target.
+func socketTarget(socket string) string {
+	if strings.HasPrefix(socket, "unix:") {
+		return socket
+	}
+	return "unix://" + socket
+}
+
+// rawCodec lets us send and receive already-encoded protobuf messages.
+type rawCodec struct{}
+
+func (rawCodec) Name() string { return "proto" }
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	bs, ok := v.(*[]byte)
+	if !ok {
+		return nil, fmt.Errorf("rawCodec: cannot marshal %T", v)
+	}
+	return *bs, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	bs, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("rawCodec: cannot unmarshal into %T", v)
+	}
+	*bs = append([]byte(nil), data...)
+	return nil
+}
+
+// ParseX509SVIDResponse decodes a protobuf-encoded X509SVIDResponse:
+//
+//	message X509SVIDResponse {
+//	    repeated X509SVID svids = 1;
+//	    repeated bytes crl = 2;
+//	    map<string, bytes> federated_bundles = 3;
+//	}
+//
+// The bundle for each SVID's own trust domain comes along with the SVID, while the federated
+// bundles come separately; X509Context.Bundles has both.
+func ParseX509SVIDResponse(msg []byte) (*X509Context, error) {
+	x509Context := &X509Context{
+		Bundles: map[string][]byte{},
+	}
+	err := consumeBytesFields(msg, func(num protowire.Number, value []byte) error {
+		switch num {
+		case 1:
+			svid, bundle, err := parseX509SVID(value)
+			if err != nil {
+				return err
+			}
+			x509Context.SVIDs = append(x509Context.SVIDs, svid)
+			if _, ok := x509Context.Bundles[svid.TrustDomain]; !ok && len(bundle) > 0 {
+				x509Context.Bundles[svid.TrustDomain] = bundle
+			}
+		case 3:
+			var trustDomain string
+			var bundle []byte
+			err := consumeBytesFields(value, func(num protowire.Number, value []byte) error {
+				switch num {
+				case 1:
+					trustDomain = strings.TrimPrefix(string(value), "spiffe://")
+				case 2:
+					var err error
+					if bundle, err = certsToPEM(value); err != nil {
+						return fmt.Errorf("federated bundle: %w", err)
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if trustDomain != "" && len(bundle) > 0 {
+				x509Context.Bundles[trustDomain] = bundle
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(x509Context.SVIDs) == 0 {
+		return nil, errors.New("response contains no SVIDs")
+	}
+	return x509Context, nil
+}
+
+// parseX509SVID decodes a single X509SVID, returning the SVID and its trust domain's bundle:
+//
+//	message X509SVID {
+//	    string spiffe_id = 1;
+//	    bytes x509_svid = 2;      // ASN.1 DER certificates, leaf first
+//	    bytes x509_svid_key = 3;  // ASN.1 DER PKCS#8 private key
+//	    bytes bundle = 4;         // ASN.1 DER certificates
+//	    string hint = 5;
+//	}
+func parseX509SVID(msg []byte) (*X509SVID, []byte, error) {
+	svid := &X509SVID{}
+	var certs, key, bundle []byte
+	err := consumeBytesFields(msg, func(num protowire.Number, value []byte) error {
+		switch num {
+		case 1:
+			svid.ID = string(value)
+		case 2:
+			certs = value
+		case 3:
+			key = value
+		case 4:
+			bundle = value
+		case 5:
+			svid.Hint = string(value)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	id, err := url.Parse(svid.ID)
+	if err != nil || id.Scheme != "spiffe" || id.Host == "" {
+		return nil, nil, fmt.Errorf("invalid SPIFFE ID %q", svid.ID)
+	}
+	svid.TrustDomain = id.Host
+
+	if svid.CertChainPEM, err = certsToPEM(certs); err != nil {
+		return nil, nil, fmt.Errorf("SVID %s: %w", svid.ID, err)
+	}
+	if _, err := x509.ParsePKCS8PrivateKey(key); err != nil {
+		return nil, nil, fmt.Errorf("SVID %s: private key: %w", svid.ID, err)
+	}
+	svid.KeyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})
+
+	var bundlePEM []byte
+	if len(bundle) > 0 {
+		if bundlePEM, err = certsToPEM(bundle); err != nil {
+			return nil, nil, fmt.Errorf("SVID %s: bundle: %w", svid.ID, err)
+		}
+	}
+	return svid, bundlePEM, nil
+}
+
+// certsToPEM turns concatenated DER certificates into concatenated PEM certificates.
+func certsToPEM(der []byte) ([]byte, error) {
+	certs, err := x509.ParseCertificates(der)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates")
+	}
+	var out []byte
+	for _, cert := range certs {
+		out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return out, nil
+}
+
+// consumeBytesFields calls fn for every length-delimited field in a protobuf message, and skips
+// everything else.
+func consumeBytesFields(msg []byte, fn func(protowire.Number, []byte) error) error {
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		msg = msg[n:]
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, msg)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			msg = msg[n:]
+			continue
+		}
+		value, n := protowire.ConsumeBytes(msg)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		msg = msg[n:]
+		if err := fn(num, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
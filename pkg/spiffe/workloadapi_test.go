@@ -0,0 +1,105 @@
+package spiffe_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/spiffe"
+)
+
+func selfSigned(t *testing.T, spiffeID string) (certDER, keyDER []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if spiffeID != "" {
+		u, err := url.Parse(spiffeID)
+		require.NoError(t, err)
+		template.URIs = []*url.URL{u}
+	}
+	certDER, err = x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err = x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return certDER, keyDER
+}
+
+func appendBytesField(b []byte, num protowire.Number, value []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, value)
+}
+
+func TestParseX509SVIDResponse(t *testing.T) {
+	const id = "spiffe://example.org/ns/emissary/sa/emissary"
+	certDER, keyDER := selfSigned(t, id)
+	bundleDER, _ := selfSigned(t, "")
+	federatedDER, _ := selfSigned(t, "")
+
+	var svid []byte
+	svid = appendBytesField(svid, 1, []byte(id))
+	svid = appendBytesField(svid, 2, certDER)
+	svid = appendBytesField(svid, 3, keyDER)
+	svid = appendBytesField(svid, 4, bundleDER)
+
+	var federated []byte
+	federated = appendBytesField(federated, 1, []byte("spiffe://partner.example.com"))
+	federated = appendBytesField(federated, 2, federatedDER)
+
+	var msg []byte
+	msg = appendBytesField(msg, 1, svid)
+	// Fields we don't care about should be skipped, whatever their type.
+	msg = protowire.AppendTag(msg, 99, protowire.VarintType)
+	msg = protowire.AppendVarint(msg, 42)
+	msg = appendBytesField(msg, 3, federated)
+
+	x509Context, err := spiffe.ParseX509SVIDResponse(msg)
+	require.NoError(t, err)
+
+	got, ok := x509Context.SVID("")
+	require.True(t, ok)
+	assert.Equal(t, id, got.ID)
+	assert.Equal(t, "example.org", got.TrustDomain)
+
+	block, rest := pem.Decode(got.CertChainPEM)
+	require.NotNil(t, block)
+	assert.Equal(t, "CERTIFICATE", block.Type)
+	assert.Equal(t, certDER, block.Bytes)
+	assert.Empty(t, rest)
+
+	block, _ = pem.Decode(got.KeyPEM)
+	require.NotNil(t, block)
+	assert.Equal(t, "PRIVATE KEY", block.Type)
+	assert.Equal(t, keyDER, block.Bytes)
+
+	require.Len(t, x509Context.Bundles, 2)
+	block, _ = pem.Decode(x509Context.Bundles["example.org"])
+	require.NotNil(t, block)
+	assert.Equal(t, bundleDER, block.Bytes)
+	block, _ = pem.Decode(x509Context.Bundles["partner.example.com"])
+	require.NotNil(t, block)
+	assert.Equal(t, federatedDER, block.Bytes)
+
+	_, ok = x509Context.SVID("spiffe://example.org/somebody-else")
+	assert.False(t, ok)
+
+	// A response without any SVIDs is no use to anyone.
+	_, err = spiffe.ParseX509SVIDResponse(appendBytesField(nil, 3, federated))
+	assert.Error(t, err)
+}
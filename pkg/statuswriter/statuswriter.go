@@ -0,0 +1,304 @@
+// Package statuswriter writes the status of Kubernetes resources for everything in the pod that
+// has status to report, instead of each of them writing it for itself. A big snapshot can change
+// the status of thousands of resources at once, and writing each of those as soon as it changes
+// (with a process of its own, as diagd used to) gets the whole pod throttled by the API server,
+// and everything else that it asks the API server for held up behind that.
+//
+// A Writer takes Updates, and writes them in batches:
+//
+//   - Updates to the same resource are coalesced: only the newest status gets written, once.
+//   - A status that's the same as the one last written is skipped.
+//   - Writes are rate limited, by a token bucket that refills at Config.QPS, up to Config.Burst.
+//   - A write that fails (because somebody else updated the resource first, say) is retried with
+//     exponential backoff, unless a newer status for the resource comes along first, up to
+//     Config.MaxAttempts times.
+//
+// Resources get written in the order their status first changed, so a resource that keeps
+// changing doesn't keep the rest waiting.
+package statuswriter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+)
+
+// Key is the resource an Update is for.
+type Key struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+func (k Key) String() string {
+	return k.Kind + "/" + k.Name + "." + k.Namespace
+}
+
+// An Update is a new status for a resource.
+type Update struct {
+	Key
+	Status json.RawMessage
+}
+
+var (
+	// ErrConflict is what a Client returns (wrapped or not) when the resource changed under it.
+	// The write is retried.
+	ErrConflict = errors.New("conflict")
+	// ErrNotFound is what a Client returns (wrapped or not) when the resource is gone. The write
+	// isn't retried.
+	ErrNotFound = errors.New("not found")
+)
+
+// The Client interface writes a resource's status.
+type Client interface {
+	WriteStatus(ctx context.Context, key Key, status json.RawMessage) error
+}
+
+// Config is how fast a Writer writes. Anything left zero gets its default.
+type Config struct {
+	// Interval is how often a batch gets written.
+	Interval time.Duration
+	// QPS is how many writes a second the Writer makes, on average.
+	QPS float64
+	// Burst is the most writes the Writer makes at once.
+	Burst int
+	// MinBackoff and MaxBackoff are how long a failed write waits before its first and its
+	// later retries.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// MaxAttempts is how many times a status gets tried before the Writer gives up on it.
+	MaxAttempts int
+}
+
+const (
+	DefaultInterval    = 1 * time.Second
+	DefaultQPS         = 5
+	DefaultBurst       = 20
+	DefaultMinBackoff  = 1 * time.Second
+	DefaultMaxBackoff  = 1 * time.Minute
+	DefaultMaxAttempts = 5
+)
+
+func (c Config) withDefaults() Config {
+	if c.Interval <= 0 {
+		c.Interval = DefaultInterval
+	}
+	if c.QPS <= 0 {
+		c.QPS = DefaultQPS
+	}
+	if c.Burst <= 0 {
+		c.Burst = DefaultBurst
+	}
+	if c.MinBackoff <= 0 {
+		c.MinBackoff = DefaultMinBackoff
+	}
+	if c.MaxBackoff < c.MinBackoff {
+		c.MaxBackoff = DefaultMaxBackoff
+		if c.MaxBackoff < c.MinBackoff {
+			c.MaxBackoff = c.MinBackoff
+		}
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = DefaultMaxAttempts
+	}
+	return c
+}
+
+// Stats are what a Writer has done so far.
+type Stats struct {
+	// Queued is how many resources have a status waiting to be written.
+	Queued int
+	// Written is how many statuses have been written.
+	Written uint64
+	// Coalesced is how many statuses were replaced by a newer one before they got written.
+	Coalesced uint64
+	// Unchanged is how many statuses were skipped because they'd already been written.
+	Unchanged uint64
+	// Conflicts and Errors are how many writes failed, because the resource had changed, and
+	// for anything else.
+	Conflicts uint64
+	Errors    uint64
+	// NotFound is how many writes were for resources that were gone.
+	NotFound uint64
+	// Dropped is how many statuses were given up on after Config.MaxAttempts tries.
+	Dropped uint64
+}
+
+// pending is a status waiting to be written.
+type pending struct {
+	Update
+	attempts  int
+	notBefore time.Time
+}
+
+// A Writer writes statuses. It's safe to use from multiple goroutines.
+type Writer struct {
+	client Client
+	clock  clock.Clock
+	cfg    Config
+
+	mu      sync.Mutex
+	pending map[Key]*pending
+	order   []Key          // the keys in pending, in the order they got there
+	written map[Key]string // the last status written, by resource
+	tokens  float64        // writes the rate limit allows right now
+	refill  time.Time      // when tokens was last topped up
+	stats   Stats
+}
+
+// New returns a Writer that writes with client, on clk.
+func New(client Client, clk clock.Clock, cfg Config) *Writer {
+	cfg = cfg.withDefaults()
+	return &Writer{
+		client:  client,
+		clock:   clk,
+		cfg:     cfg,
+		pending: make(map[Key]*pending),
+		written: make(map[Key]string),
+		tokens:  float64(cfg.Burst),
+		refill:  clk.Now(),
+	}
+}
+
+// Enqueue queues updates to be written in a later batch.
+func (w *Writer) Enqueue(updates ...Update) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, u := range updates {
+		if p, ok := w.pending[u.Key]; ok {
+			// A newer status means starting over, retries and all.
+			w.stats.Coalesced++
+			p.Update = u
+			p.attempts = 0
+			p.notBefore = time.Time{}
+			continue
+		}
+		if last, ok := w.written[u.Key]; ok && last == string(u.Status) {
+			w.stats.Unchanged++
+			continue
+		}
+		w.push(&pending{Update: u})
+	}
+}
+
+// push adds p to the end of the queue. It must be called with the mutex held.
+func (w *Writer) push(p *pending) {
+	w.pending[p.Key] = p
+	w.order = append(w.order, p.Key)
+}
+
+// Stats returns what the Writer has done so far.
+func (w *Writer) Stats() Stats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	stats := w.stats
+	stats.Queued = len(w.pending)
+	return stats
+}
+
+// Run writes a batch every Config.Interval until ctx is done.
+func (w *Writer) Run(ctx context.Context) error {
+	ticker := w.clock.NewTicker(w.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C():
+			w.Flush(ctx)
+		}
+	}
+}
+
+// Flush writes as much of the queue as the rate limit allows right now, and returns how many
+// writes it made.
+func (w *Writer) Flush(ctx context.Context) int {
+	batch := w.take()
+	for _, p := range batch {
+		err := w.client.WriteStatus(ctx, p.Key, p.Status)
+		w.done(ctx, p, err)
+	}
+	return len(batch)
+}
+
+// take takes the writes for the next batch off the queue, and spends their tokens.
+func (w *Writer) take() []*pending {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := w.clock.Now()
+	w.tokens += now.Sub(w.refill).Seconds() * w.cfg.QPS
+	if w.tokens > float64(w.cfg.Burst) {
+		w.tokens = float64(w.cfg.Burst)
+	}
+	w.refill = now
+
+	var batch []*pending
+	rest := w.order[:0]
+	for _, key := range w.order {
+		p := w.pending[key]
+		if float64(len(batch)+1) <= w.tokens && !now.Before(p.notBefore) {
+			batch = append(batch, p)
+			delete(w.pending, key)
+			continue
+		}
+		rest = append(rest, key)
+	}
+	w.order = rest
+	w.tokens -= float64(len(batch))
+	return batch
+}
+
+// done records how writing p went, and queues it to be retried if it should be.
+func (w *Writer) done(ctx context.Context, p *pending, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	switch {
+	case err == nil:
+		w.stats.Written++
+		w.written[p.Key] = string(p.Status)
+		return
+	case errors.Is(err, ErrNotFound):
+		w.stats.NotFound++
+		delete(w.written, p.Key)
+		return
+	case errors.Is(err, ErrConflict):
+		w.stats.Conflicts++
+	default:
+		w.stats.Errors++
+	}
+
+	if _, newer := w.pending[p.Key]; newer {
+		// It's been superseded while we were writing it.
+		return
+	}
+	p.attempts++
+	if p.attempts >= w.cfg.MaxAttempts {
+		w.stats.Dropped++
+		dlog.Errorf(ctx, "statuswriter: giving up on %v after %d attempts: %v", p.Key, p.attempts, err)
+		return
+	}
+	backoff := w.backoff(p.attempts)
+	dlog.Debugf(ctx, "statuswriter: retrying %v in %v: %v", p.Key, backoff, err)
+	p.notBefore = w.clock.Now().Add(backoff)
+	w.push(p)
+}
+
+// backoff returns how long to wait after the attempts'th failed attempt.
+func (w *Writer) backoff(attempts int) time.Duration {
+	d := w.cfg.MinBackoff
+	for i := 1; i < attempts && d < w.cfg.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > w.cfg.MaxBackoff {
+		d = w.cfg.MaxBackoff
+	}
+	return d
+}
@@ -0,0 +1,165 @@
+package statuswriter_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/datawire/dlib/dlog"
+
+	"github.com/emissary-ingress/emissary/v3/pkg/clock"
+	"github.com/emissary-ingress/emissary/v3/pkg/statuswriter"
+)
+
+// fakeClient records what it writes, and fails the writes it's told to.
+type fakeClient struct {
+	mu     sync.Mutex
+	writes []string
+	fail   map[string][]error
+}
+
+func (c *fakeClient) WriteStatus(_ context.Context, key statuswriter.Key, status json.RawMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writes = append(c.writes, fmt.Sprintf("%s %s", key.Name, status))
+	if errs := c.fail[key.Name]; len(errs) > 0 {
+		c.fail[key.Name] = errs[1:]
+		return errs[0]
+	}
+	return nil
+}
+
+func (c *fakeClient) take() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writes := c.writes
+	c.writes = nil
+	return writes
+}
+
+func update(name, status string) statuswriter.Update {
+	return statuswriter.Update{
+		Key:    statuswriter.Key{Kind: "Mapping", Namespace: "default", Name: name},
+		Status: json.RawMessage(status),
+	}
+}
+
+func setup(t *testing.T, cfg statuswriter.Config) (context.Context, *fakeClient, *clock.Fake, *statuswriter.Writer) {
+	ctx := dlog.NewTestContext(t, false)
+	client := &fakeClient{fail: map[string][]error{}}
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	return ctx, client, clk, statuswriter.New(client, clk, cfg)
+}
+
+func TestCoalesce(t *testing.T) {
+	t.Parallel()
+	ctx, client, _, w := setup(t, statuswriter.Config{})
+
+	w.Enqueue(update("a", `{"state":"Pending"}`), update("b", `{"state":"Running"}`))
+	w.Enqueue(update("a", `{"state":"Running"}`))
+	assert.Equal(t, 2, w.Stats().Queued)
+
+	assert.Equal(t, 2, w.Flush(ctx))
+	assert.Equal(t, []string{`a {"state":"Running"}`, `b {"state":"Running"}`}, client.take())
+
+	// Writing the same thing again is skipped.
+	w.Enqueue(update("a", `{"state":"Running"}`))
+	assert.Equal(t, 0, w.Flush(ctx))
+
+	stats := w.Stats()
+	assert.Equal(t, uint64(2), stats.Written)
+	assert.Equal(t, uint64(1), stats.Coalesced)
+	assert.Equal(t, uint64(1), stats.Unchanged)
+	assert.Equal(t, 0, stats.Queued)
+}
+
+func TestRateLimit(t *testing.T) {
+	t.Parallel()
+	ctx, client, clk, w := setup(t, statuswriter.Config{QPS: 2, Burst: 3})
+
+	for i := 0; i < 10; i++ {
+		w.Enqueue(update(fmt.Sprintf("m%d", i), `{}`))
+	}
+
+	assert.Equal(t, 3, w.Flush(ctx))
+	assert.Equal(t, 0, w.Flush(ctx))
+	assert.Equal(t, 7, w.Stats().Queued)
+
+	clk.Advance(time.Second)
+	assert.Equal(t, 2, w.Flush(ctx))
+
+	// The bucket doesn't fill past the burst, however long it's been.
+	clk.Advance(time.Minute)
+	assert.Equal(t, 3, w.Flush(ctx))
+	assert.Equal(t, 2, w.Stats().Queued)
+
+	// First come, first written.
+	assert.Equal(t, "m0 {}", client.take()[0])
+}
+
+func TestRetry(t *testing.T) {
+	t.Parallel()
+	ctx, client, clk, w := setup(t, statuswriter.Config{MinBackoff: time.Second, MaxBackoff: 3 * time.Second})
+	client.fail["a"] = []error{
+		fmt.Errorf("updating: %w", statuswriter.ErrConflict),
+		fmt.Errorf("updating: %w", statuswriter.ErrConflict),
+	}
+
+	w.Enqueue(update("a", `{}`))
+	assert.Equal(t, 1, w.Flush(ctx))
+
+	// The first retry waits MinBackoff.
+	assert.Equal(t, 0, w.Flush(ctx))
+	clk.Advance(time.Second)
+	assert.Equal(t, 1, w.Flush(ctx))
+
+	// The second waits twice that.
+	clk.Advance(time.Second)
+	assert.Equal(t, 0, w.Flush(ctx))
+	clk.Advance(time.Second)
+	assert.Equal(t, 1, w.Flush(ctx))
+
+	stats := w.Stats()
+	assert.Equal(t, uint64(2), stats.Conflicts)
+	assert.Equal(t, uint64(1), stats.Written)
+	assert.Equal(t, 0, stats.Queued)
+}
+
+func TestGiveUp(t *testing.T) {
+	t.Parallel()
+	ctx, client, clk, w := setup(t, statuswriter.Config{MaxAttempts: 2})
+	client.fail["a"] = []error{fmt.Errorf("boom"), fmt.Errorf("boom"), fmt.Errorf("boom")}
+	client.fail["gone"] = []error{statuswriter.ErrNotFound}
+
+	w.Enqueue(update("a", `{}`), update("gone", `{}`))
+	assert.Equal(t, 2, w.Flush(ctx))
+	clk.Advance(time.Minute)
+	assert.Equal(t, 1, w.Flush(ctx))
+	clk.Advance(time.Minute)
+	assert.Equal(t, 0, w.Flush(ctx))
+
+	stats := w.Stats()
+	assert.Equal(t, uint64(2), stats.Errors)
+	assert.Equal(t, uint64(1), stats.Dropped)
+	assert.Equal(t, uint64(1), stats.NotFound)
+	assert.Equal(t, 0, stats.Queued)
+}
+
+func TestNewerStatusReplacesRetry(t *testing.T) {
+	t.Parallel()
+	ctx, client, _, w := setup(t, statuswriter.Config{})
+	client.fail["a"] = []error{statuswriter.ErrConflict}
+
+	w.Enqueue(update("a", `{"n":1}`))
+	assert.Equal(t, 1, w.Flush(ctx))
+
+	// A newer status doesn't wait out the old one's backoff.
+	w.Enqueue(update("a", `{"n":2}`))
+	assert.Equal(t, 1, w.Flush(ctx))
+	assert.Equal(t, []string{`a {"n":1}`, `a {"n":2}`}, client.take())
+}
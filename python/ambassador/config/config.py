@@ -21,9 +21,12 @@ from typing import cast as typecast
 
 from pkg_resources import Requirement, resource_filename
 
+from .. import errorcodes
+from ..errorcodes import ErrorCode
 from ..resource import Resource
 from ..utils import RichStatus, dump_json, parse_bool
 from .acresource import ACResource
+from .interpolation import interpolate_resource, interpolation_variables
 
 if TYPE_CHECKING:
     from ambassador.fetch.fetcher import ResourceFetcher
@@ -57,12 +60,15 @@ class Config:
     StorageByKind: ClassVar[Dict[str, str]] = {
         "authservice": "auth_configs",
         "consulresolver": "resolvers",
+        "egresspolicy": "egress_policies",
         "host": "hosts",
         "listener": "listeners",
         "mapping": "mappings",
         "kubernetesendpointresolver": "resolvers",
         "kubernetesserviceresolver": "resolvers",
+        "pluginresolver": "resolvers",
         "ratelimitservice": "ratelimit_configs",
+        "redirect": "redirects",
         "devportal": "devportals",
         "tcpmapping": "tcpmappings",
         "tlscontext": "tls_contexts",
@@ -95,6 +101,9 @@ class Config:
     # Invalid objects (currently loaded using load_invalid())
     invalid: List[Dict]
 
+    # Environment variables that resources may interpolate => their values
+    interpolation_variables: Dict[str, Optional[str]]
+
     errors: Dict[str, List[dict]]  # errors to post to the UI
     notices: Dict[str, List[str]]  # notices to post to the UI
     fatal_errors: int
@@ -135,6 +144,8 @@ class Config:
         self.k8s_status_updates: Dict[
             str, Tuple[str, str, Optional[Dict[str, Any]]]
         ] = {}  # Tuple is (name, namespace, status_json)
+        # Where Ambassador can be reached from outside the cluster; see fetch/addresses.py.
+        self.external_addresses: List[Dict[str, Any]] = []
         self.pod_labels: Dict[str, str] = {}
         self._reset()
 
@@ -162,6 +173,9 @@ class Config:
         self.save_source(ACResource.internal_resource())
         self.save_source(ACResource.diagnostics_resource())
 
+        # Which environment variables resources may use; see interpolation.py.
+        self.interpolation_variables = interpolation_variables()
+
         self.errors = {}
         self.notices = {}
         self.fatal_errors = 0
@@ -336,6 +350,7 @@ class Config:
         resource: Optional[Resource] = None,
         rkey: Optional[str] = None,
         log_level=logging.INFO,
+        code: Optional[ErrorCode] = None,
     ) -> None:
         assert False
 
@@ -346,10 +361,11 @@ class Config:
         resource: Optional[Resource] = None,
         rkey: Optional[str] = None,
         log_level=logging.INFO,
+        code: Optional[ErrorCode] = None,
     ):
         rc = RichStatus.fromError(msg)
 
-        self.post_error(rc, resource=resource, log_level=log_level)
+        self.post_error(rc, resource=resource, rkey=rkey, log_level=log_level, code=code)
 
     @post_error.register
     def post_error_richstatus(
@@ -358,10 +374,20 @@ class Config:
         resource: Optional[Resource] = None,
         rkey: Optional[str] = None,
         log_level=logging.INFO,
+        code: Optional[ErrorCode] = None,
     ):
         if resource is None:
             resource = self.current_resource
 
+        # Every error gets a code (see errorcodes.py). A code given here wins
+        # over one already in the RichStatus, and if there's neither, we fall
+        # back on a generic code for the kind of resource that's in error.
+        if code:
+            rc.info["code"] = str(code)
+        elif "code" not in rc:
+            kind = resource.kind if resource is not None else None
+            rc.info["code"] = str(errorcodes.code_for_kind(kind))
+
         if not rkey:
             rkey = "-global-"
 
@@ -371,27 +397,35 @@ class Config:
                 if isinstance(resource, ACResource):
                     self.save_source(resource)
 
+        # A Mapping marked critical: false still gets its errors reported, but
+        # they don't count against Ambassador's own health (see diagd's
+        # check_environment).
+        if (resource is not None) and (resource.get("critical", True) is False):
+            rc.info["critical"] = False
+
         errors = self.errors.setdefault(rkey, [])
         errors.append(rc.as_dict())
 
-        self.logger.log(log_level, "%s: %s" % (rkey, rc))
+        self.logger.log(log_level, "%s: %s %s" % (rkey, rc.code, rc))
 
     def process(self, resource: ACResource) -> RichStatus:
         # This should be impossible.
         if not resource:
-            return RichStatus.fromError("undefined object???")
+            return RichStatus.fromError(
+                "undefined object???", code=str(errorcodes.MALFORMED_RESOURCE)
+            )
 
         self.current_resource = resource
 
         if not resource.apiVersion:
-            return RichStatus.fromError("need apiVersion")
+            return RichStatus.fromError("need apiVersion", code=str(errorcodes.MALFORMED_RESOURCE))
 
         if not resource.kind:
-            return RichStatus.fromError("need kind")
+            return RichStatus.fromError("need kind", code=str(errorcodes.MALFORMED_RESOURCE))
 
         # Make sure this resource has a name...
         if "name" not in resource:
-            return RichStatus.fromError("need name")
+            return RichStatus.fromError("need name", code=str(errorcodes.MALFORMED_RESOURCE))
 
         # ...and also make sure it has a namespace.
         if not resource.get("namespace", None):
@@ -407,6 +441,12 @@ class Config:
             # Well that's no good.
             return rc
 
+        # ...fill in any ${VARIABLES} it uses...
+        rc = self.interpolate(resource)
+
+        if not rc:
+            return rc
+
         # OK, so far so good. Should we just stash this somewhere?
         lkind = resource.kind.lower()
         store_as = Config.StorageByKind.get(lkind)
@@ -442,7 +482,9 @@ class Config:
     def validate_object(self, resource: ACResource) -> RichStatus:
         # This is basically "impossible"
         if not (("apiVersion" in resource) and ("kind" in resource) and ("name" in resource)):
-            return RichStatus.fromError("must have apiVersion, kind, and name")
+            return RichStatus.fromError(
+                "must have apiVersion, kind, and name", code=str(errorcodes.MALFORMED_RESOURCE)
+            )
 
         apiVersion = resource.apiVersion
 
@@ -456,7 +498,10 @@ class Config:
             # here
             pass
         else:
-            return RichStatus.fromError("apiVersion %s unsupported" % apiVersion)
+            return RichStatus.fromError(
+                "apiVersion %s unsupported" % apiVersion,
+                code=str(errorcodes.UNSUPPORTED_API_VERSION),
+            )
 
         ns = resource.get("namespace") or self.ambassador_namespace
         name = f"{resource.name} ns {ns}"
@@ -475,10 +520,27 @@ class Config:
 
             # ...and, assuming that we're left with any error message, post it.
             if watt_errors:
-                return RichStatus.fromError(watt_errors)
+                return RichStatus.fromError(watt_errors, code=str(errorcodes.SCHEMA_VIOLATION))
 
         return RichStatus.OK(msg=f"good {resource.kind}")
 
+    def interpolate(self, resource: ACResource) -> RichStatus:
+        # See interpolation.py. Only our own resources get interpolated, and only once
+        # some variables have been allowed.
+        if (not self.interpolation_variables) or (
+            not resource.apiVersion.startswith("getambassador.io/")
+        ):
+            return RichStatus.OK(msg="nothing to interpolate")
+
+        errors = interpolate_resource(resource, self.interpolation_variables)
+
+        if errors:
+            return RichStatus.fromError(
+                "; ".join(sorted(set(errors))), code=str(errorcodes.UNRESOLVED_VARIABLE)
+            )
+
+        return RichStatus.OK(msg="interpolated")
+
     def safe_store(self, storage_name: str, resource: ACResource, allow_log: bool = True) -> None:
         """
         Safely store a ACResource under a given storage name. The storage_name is separate
@@ -500,6 +562,7 @@ class Config:
                     "%s defines %s %s, which is already defined by %s"
                     % (resource, resource.kind, resource.name, storage[resource.name].location),
                     resource=resource,
+                    code=errorcodes.DUPLICATE_RESOURCE,
                 )
             else:
                 # Here, we deal with the case when multiple resources have the same name but they exist in different
@@ -589,6 +652,44 @@ class Config:
                 "%s defines %s %s, which is already defined by %s"
                 % (resource, resource.kind, key, storage[key].location),
                 resource=resource,
+                code=errorcodes.DUPLICATE_RESOURCE,
+            )
+
+        storage[key] = resource
+
+    def handle_grpcdescriptorset(self, resource: ACResource) -> None:
+        """
+        Handles a gRPC descriptor set, from a ConfigMap. Like Secrets, these are keyed by
+        rkey, since a Mapping can refer to one in another namespace.
+        """
+
+        storage = self.config.setdefault("grpc_descriptor_sets", {})
+        key = resource.rkey
+
+        if key in storage:
+            self.post_error(
+                "%s defines %s %s, which is already defined by %s"
+                % (resource, resource.kind, key, storage[key].location),
+                resource=resource,
+                code=errorcodes.DUPLICATE_RESOURCE,
+            )
+
+        storage[key] = resource
+
+    def handle_maintenancepage(self, resource: ACResource) -> None:
+        """
+        Handles maintenance pages, from a ConfigMap. These are keyed by rkey too.
+        """
+
+        storage = self.config.setdefault("maintenance_pages", {})
+        key = resource.rkey
+
+        if key in storage:
+            self.post_error(
+                "%s defines %s %s, which is already defined by %s"
+                % (resource, resource.kind, key, storage[key].location),
+                resource=resource,
+                code=errorcodes.DUPLICATE_RESOURCE,
             )
 
         storage[key] = resource
@@ -602,6 +703,7 @@ class Config:
                 "%s defines %s %s, which is already defined by %s"
                 % (resource, resource.kind, key, storage[key].location),
                 resource=resource,
+                code=errorcodes.DUPLICATE_RESOURCE,
             )
 
         storage[key] = resource
@@ -620,6 +722,7 @@ class Config:
                 "%s defines %s %s, which is already defined by %s"
                 % (resource, resource.kind, key, storage[key].location),
                 resource=resource,
+                code=errorcodes.DUPLICATE_RESOURCE,
             )
 
         self.logger.debug("%s: saving %s %s" % (resource, resource.kind, key))
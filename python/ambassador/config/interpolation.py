@@ -0,0 +1,114 @@
+import os
+import re
+from typing import Any, Dict, List, Mapping, Optional
+
+#############################################################################
+## interpolation.py -- ${VARIABLES} in Ambassador resources
+##
+## A string anywhere in the spec of an Ambassador resource can say ${NAME} to
+## get the value of the environment variable NAME, so that the same manifests
+## can be promoted from one environment to the next without a templating engine:
+##
+##   hostname: api.${AMBASSADOR_CLUSTER_DOMAIN}
+##
+## Only the variables listed (comma-separated) in AMBASSADOR_INTERPOLATION_VARS
+## can be used. If that's empty, as it is by default, nothing gets interpolated
+## at all, so a ${ in an existing resource means just what it always did. Use
+## $${ for a literal ${.
+##
+## A resource that uses a variable that isn't allowed, or that isn't set, is
+## rejected, rather than being loaded with a hole in it.
+
+ALLOWLIST_VAR = "AMBASSADOR_INTERPOLATION_VARS"
+
+REFERENCE_RE = re.compile(r"\$(\$)?\{([^}]*)\}")
+NAME_RE = re.compile(r"^[A-Za-z_][A-Za-z0-9_]*$")
+
+# These keys of an ACResource come from its metadata (or from us), not its spec,
+# so they're never interpolated.
+METADATA_KEYS = {
+    "apiVersion",
+    "generation",
+    "kind",
+    "location",
+    "metadata_labels",
+    "name",
+    "namespace",
+    "rkey",
+    "serialization",
+}
+
+
+def interpolation_variables(
+    environ: Optional[Mapping[str, str]] = None
+) -> Dict[str, Optional[str]]:
+    """
+    Return the variables that resources may use, with their values (None for
+    any that aren't set).
+    """
+
+    if environ is None:
+        environ = os.environ
+
+    names = [n.strip() for n in environ.get(ALLOWLIST_VAR, "").split(",")]
+
+    return {name: environ.get(name, None) for name in names if name}
+
+
+def interpolate(value: Any, variables: Dict[str, Optional[str]], errors: List[str]) -> Any:
+    """
+    Interpolate variables into every string in value, recursing into dicts
+    (values only, not keys) and lists. Anything wrong is appended to errors,
+    and the reference is left alone.
+    """
+
+    if isinstance(value, str):
+
+        def replace(match: re.Match) -> str:
+            name = match.group(2)
+
+            if match.group(1):
+                return "${" + name + "}"
+
+            resolved = variables.get(name, None)
+
+            if not NAME_RE.match(name):
+                errors.append(f"${{{name}}} is not a valid variable reference")
+            elif name not in variables:
+                errors.append(f"variable {name} is not allowed by {ALLOWLIST_VAR}")
+            elif resolved is None:
+                errors.append(f"variable {name} is not set")
+            else:
+                return resolved
+
+            return match.group(0)
+
+        return REFERENCE_RE.sub(replace, value)
+
+    if isinstance(value, dict):
+        return {k: interpolate(v, variables, errors) for k, v in value.items()}
+
+    if isinstance(value, list):
+        return [interpolate(v, variables, errors) for v in value]
+
+    return value
+
+
+def interpolate_resource(
+    resource: Dict[str, Any], variables: Dict[str, Optional[str]]
+) -> List[str]:
+    """
+    Interpolate variables into the spec of a resource, in place. Returns the
+    errors, if any, in which case the resource is left as it was.
+    """
+
+    errors: List[str] = []
+    interpolated = {
+        k: (v if k in METADATA_KEYS else interpolate(v, variables, errors))
+        for k, v in resource.items()
+    }
+
+    if not errors:
+        resource.update(interpolated)
+
+    return errors
@@ -0,0 +1,90 @@
+"""
+Snapshot history: which snapshot the configuration was built from at a given time.
+
+diagd keeps the last AMBASSADOR_SNAPSHOT_COUNT snapshots that made it to Envoy
+as well as the current one: snapshot.yaml is the current one, snapshot-1.yaml
+the one before it, and so on. Each file's mtime is when it arrived, so the
+snapshot in effect at any time since the oldest one arrived is the newest one
+that had arrived by then. That's what an incident review wants to look at:
+not what the routes are now, but what they were when things went wrong.
+"""
+
+import datetime
+import os
+import re
+from typing import List, NamedTuple, Optional
+
+SNAPSHOT_RE = re.compile(r"^snapshot(-(\d+))?\.yaml$")
+
+
+class ArchivedSnapshot(NamedTuple):
+    path: str
+    # When the snapshot arrived, in seconds since the epoch.
+    since: float
+
+
+def archived_snapshots(snapshot_dir: str) -> List[ArchivedSnapshot]:
+    """
+    Return the snapshots in snapshot_dir, newest first.
+    """
+
+    snapshots: List[ArchivedSnapshot] = []
+
+    try:
+        names = os.listdir(snapshot_dir)
+    except OSError:
+        return snapshots
+
+    for name in names:
+        if not SNAPSHOT_RE.match(name):
+            continue
+
+        path = os.path.join(snapshot_dir, name)
+
+        try:
+            since = os.stat(path).st_mtime
+        except OSError:
+            # Rotated away while we were looking.
+            continue
+
+        snapshots.append(ArchivedSnapshot(path, since))
+
+    return sorted(snapshots, key=lambda s: s.since, reverse=True)
+
+
+def snapshot_at(snapshot_dir: str, when: float) -> Optional[ArchivedSnapshot]:
+    """
+    Return the snapshot that was in effect at when (in seconds since the epoch),
+    or None if it's older than every snapshot that's been kept.
+    """
+
+    for snapshot in archived_snapshots(snapshot_dir):
+        if snapshot.since <= when:
+            return snapshot
+
+    return None
+
+
+def parse_time(value: str) -> float:
+    """
+    Parse a time given as seconds since the epoch or in ISO 8601 (with a "Z" for
+    UTC if you like; a time with no offset is taken to be UTC), and return it in
+    seconds since the epoch. Raises ValueError if value is neither.
+    """
+
+    try:
+        return float(value)
+    except ValueError:
+        pass
+
+    value = value.strip()
+
+    if value.endswith(("Z", "z")):
+        value = value[:-1] + "+00:00"
+
+    dt = datetime.datetime.fromisoformat(value)
+
+    if dt.tzinfo is None:
+        dt = dt.replace(tzinfo=datetime.timezone.utc)
+
+    return dt.timestamp()
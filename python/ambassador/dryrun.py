@@ -0,0 +1,144 @@
+"""
+Dry runs: what applying some resources would do, without applying them.
+
+A dry run takes the snapshot the running configuration was built from, puts
+the proposed resources into it (in place of any resource with the same kind,
+name, and namespace), and compiles it. What comes back is whether the proposed
+resources are valid -- the errors they get, if any -- and how the Envoy
+configuration would change, as a JSON patch (RFC 6902) from the configuration
+the snapshot compiles to now to the one it would compile to.
+
+Nothing is persisted, and nothing goes to Envoy. The proposed resources don't
+go through the Kubernetes API server, though, so whatever it would reject (a
+CRD schema violation, say) can still look fine here.
+"""
+
+import json
+import logging
+from typing import Any, Dict, List, Optional, Tuple
+
+import jsonpatch
+
+from .compile import Compile
+from .utils import SecretHandler
+
+
+class DryRunError(Exception):
+    pass
+
+
+def _identity(obj: Dict[str, Any]) -> Tuple[str, str, str]:
+    metadata = obj.get("metadata") or {}
+
+    return (obj.get("kind", ""), metadata.get("name", ""), metadata.get("namespace", ""))
+
+
+def check_proposed(proposed: Any) -> List[Dict[str, Any]]:
+    """
+    Make sure that what somebody proposed looks like a list of Kubernetes
+    resources, giving every resource without a namespace the default one, and
+    raise DryRunError if it doesn't.
+    """
+
+    if isinstance(proposed, dict):
+        proposed = [proposed]
+
+    if not isinstance(proposed, list) or not proposed:
+        raise DryRunError("a dry run needs one or more resources")
+
+    resources: List[Dict[str, Any]] = []
+
+    for obj in proposed:
+        if obj is None:
+            # An empty YAML document.
+            continue
+
+        if not isinstance(obj, dict):
+            raise DryRunError(f"{obj!r} isn't a resource")
+
+        metadata = obj.get("metadata")
+
+        if not obj.get("apiVersion") or not obj.get("kind") or not isinstance(metadata, dict):
+            raise DryRunError("every resource needs an apiVersion, a kind, and metadata")
+
+        if not metadata.get("name"):
+            raise DryRunError(f"{obj['kind']} with no name")
+
+        metadata.setdefault("namespace", "default")
+        resources.append(obj)
+
+    if not resources:
+        raise DryRunError("a dry run needs one or more resources")
+
+    return resources
+
+
+def propose(serialization: str, proposed: List[Dict[str, Any]]) -> str:
+    """
+    Return the watt snapshot in serialization with the proposed resources in it.
+    """
+
+    watt_dict = json.loads(serialization)
+
+    # The deltas are what changed to get to this snapshot, not to the proposed one.
+    watt_dict.pop("Deltas", None)
+
+    watt_k8s = watt_dict.get("Kubernetes") or {}
+    watt_dict["Kubernetes"] = watt_k8s
+
+    replaced = {_identity(obj) for obj in proposed}
+
+    for key, objs in watt_k8s.items():
+        if isinstance(objs, list):
+            watt_k8s[key] = [obj for obj in objs if _identity(obj) not in replaced]
+
+    for obj in proposed:
+        # The kind is as good a key as any: the fetcher goes by the kind of each
+        # resource, not by where it is in the snapshot.
+        # (Many keys have explicit nulls, so setdefault() won't do.)
+        objs = watt_k8s.get(obj["kind"]) or []
+        objs.append(obj)
+        watt_k8s[obj["kind"]] = objs
+
+    return json.dumps(watt_dict)
+
+
+def dry_run(
+    logger: logging.Logger,
+    serialization: str,
+    proposed: List[Dict[str, Any]],
+    secret_handler: Optional[SecretHandler] = None,
+) -> Dict[str, Any]:
+    """
+    Compile the watt snapshot in serialization with and without the proposed
+    resources, and return:
+
+    {
+        "valid": whether the proposed resources compiled without errors
+        "errors": the errors for each proposed resource, by rkey
+        "diff": the JSON patch from the current Envoy config to the proposed one
+    }
+    """
+
+    # Both sides get compiled here, the same way, so that the diff doesn't pick
+    # up anything that's only different because of how they were compiled (the
+    # cache, say, or where secrets end up).
+    current = Compile(logger, serialization, secret_handler=secret_handler)
+    result = Compile(logger, propose(serialization, proposed), secret_handler=secret_handler)
+
+    ir = result["ir"]
+    prefixes = [f"{name}.{namespace}" for _, name, namespace in map(_identity, proposed)]
+    errors: Dict[str, List[str]] = {}
+
+    for rkey, errs in ir.aconf.errors.items():
+        if any((rkey == pfx) or rkey.startswith(pfx + ".") for pfx in prefixes):
+            errors[rkey] = [e.get("error", str(e)) for e in errs]
+
+    before = current["xds"].as_dict() if "xds" in current else {}
+    after = result["xds"].as_dict() if "xds" in result else {}
+
+    return {
+        "valid": ("xds" in result) and not errors,
+        "errors": errors,
+        "diff": jsonpatch.make_patch(before, after).patch,
+    }
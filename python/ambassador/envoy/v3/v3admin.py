@@ -27,6 +27,8 @@ class V3Admin(dict):
         self.update(
             {
                 "access_log_path": "/tmp/admin_access_log",
+                # Envoy writes CPU profiles here (see pkg/envoyprofile).
+                "profile_path": "/tmp/envoy.prof",
                 "address": {"socket_address": {"address": "127.0.0.1", "port_value": aport}},
             }
         )
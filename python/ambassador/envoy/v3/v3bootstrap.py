@@ -1,17 +1,23 @@
 import os
-from typing import TYPE_CHECKING, Optional, Tuple
+from typing import TYPE_CHECKING, Any, Dict, List, Optional, Tuple
 from typing import cast as typecast
 from urllib.parse import urlparse
 
 from ...ir.ircluster import IRCluster
 from ...ir.irlogservice import IRLogService
 from ...ir.irtracing import IRTracing
+from ...utils import feature_gate_enabled
 from .v3cluster import V3Cluster
+from .v3extensions import load_envoy_probe, missing_extensions
 
 if TYPE_CHECKING:
     from . import V3Config  # pragma: no cover
 
 
+# The cluster for the ambassador Module's stats_sinks otlp.
+OTLP_CLUSTER = "stats_sink_otlp"
+
+
 class V3Bootstrap(dict):
     def __init__(self, config: "V3Config") -> None:
         api_version = "V3"
@@ -24,7 +30,8 @@ class V3Bootstrap(dict):
                 "static_resources": {},  # Filled in later
                 "dynamic_resources": {
                     "ads_config": {
-                        "api_type": "GRPC",
+                        # ambex can do either; the DeltaXDS gate decides.
+                        "api_type": "DELTA_GRPC" if feature_gate_enabled("DeltaXDS") else "GRPC",
                         "transport_api_version": api_version,
                         "grpc_services": [{"envoy_grpc": {"cluster_name": "xds_cluster"}}],
                     },
@@ -82,6 +89,36 @@ class V3Bootstrap(dict):
             }
         ]
 
+        # With the bootstrap CA (see cmd/entrypoint/bootstrapca.go), ambex serves ADS over mutual
+        # TLS, and the certificates for both ends are in this directory. Keep the filenames in
+        # sync with pkg/ambex/tls.go.
+        xds_tls_dir = os.environ.get("AMBASSADOR_XDS_TLS_DIR")
+
+        if xds_tls_dir:
+            clusters[0]["transport_socket"] = {
+                "name": "envoy.transport_sockets.tls",
+                "typed_config": {
+                    "@type": "type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.UpstreamTlsContext",
+                    "sni": "localhost",
+                    "common_tls_context": {
+                        "alpn_protocols": ["h2"],
+                        "tls_certificates": [
+                            {
+                                "certificate_chain": {
+                                    "filename": os.path.join(xds_tls_dir, "envoy-client.crt")
+                                },
+                                "private_key": {
+                                    "filename": os.path.join(xds_tls_dir, "envoy-client.key")
+                                },
+                            }
+                        ],
+                        "validation_context": {
+                            "trusted_ca": {"filename": os.path.join(xds_tls_dir, "ca.crt")}
+                        },
+                    },
+                },
+            }
+
         if config.tracing:
             self["tracing"] = dict(config.tracing)
 
@@ -122,74 +159,232 @@ class V3Bootstrap(dict):
                         },
                     }
                 )
-                clusters.append(
-                    {
-                        "name": "envoy_metrics_service",
-                        "type": "strict_dns",
-                        "connect_timeout": "1s",
-                        "http2_protocol_options": {},
-                        "load_assignment": {
-                            "cluster_name": "envoy_metrics_service",
-                            "endpoints": [
-                                {
-                                    "lb_endpoints": [
-                                        {
-                                            "endpoint": {
-                                                "address": {
-                                                    "socket_address": {
-                                                        "address": host,
-                                                        "port_value": port,
-                                                        "protocol": "TCP",
-                                                    }
-                                                }
-                                            }
-                                        }
-                                    ]
-                                }
-                            ],
-                        },
-                    }
+                clusters.append(grpc_cluster("envoy_metrics_service", host, port))
+
+        stats_sinks_settings = config.ir.ambassador_module.get("stats_sinks", None)
+
+        if stats_sinks_settings:
+            if config.ir.statsd["enabled"]:
+                config.ir.aconf.post_notice(
+                    "stats_sinks overrides STATSD_ENABLED", resource=config.ir.ambassador_module
                 )
 
-        if config.ir.statsd["enabled"]:
-            if config.ir.statsd["dogstatsd"]:
-                name = "envoy.stat_sinks.dog_statsd"
-                typename = "type.googleapis.com/envoy.config.metrics.v3.DogStatsdSink"
-                dd_entity_id = os.environ.get("DD_ENTITY_ID", None)
-                if dd_entity_id:
-                    stats_tags = self.setdefault("stats_config", {}).setdefault("stats_tags", [])
-                    stats_tags.append(
-                        {"tag_name": "dd.internal.entity_id", "fixed_value": dd_entity_id}
-                    )
-            else:
-                name = "envoy.stats_sinks.statsd"
-                typename = "type.googleapis.com/envoy.config.metrics.v3.StatsdSink"
+            stats_sinks.extend(module_stats_sinks(config, stats_sinks_settings, clusters))
+
+            if stats_sinks_settings["statsd"] and stats_sinks_settings["statsd"]["dogstatsd"]:
+                self.add_dd_entity_id()
+
+            if stats_sinks_settings["flush_interval"]:
+                self["stats_flush_interval"] = {"seconds": stats_sinks_settings["flush_interval"]}
+
+            stats_matcher = module_stats_matcher(stats_sinks_settings)
 
+            if stats_matcher:
+                self.setdefault("stats_config", {})["stats_matcher"] = stats_matcher
+        elif config.ir.statsd["enabled"]:
             stats_sinks.append(
-                {
-                    "name": name,
-                    "typed_config": {
-                        "@type": typename,
-                        "address": {
-                            "socket_address": {
-                                "protocol": "UDP",
-                                "address": config.ir.statsd["ip"],
-                                "port_value": 8125,
-                            }
-                        },
-                    },
-                }
+                statsd_sink(config.ir.statsd["ip"], 8125, config.ir.statsd["dogstatsd"])
             )
 
+            if config.ir.statsd["dogstatsd"]:
+                self.add_dd_entity_id()
+
             self["stats_flush_interval"] = {"seconds": config.ir.statsd["interval"]}
         self["stats_sinks"] = stats_sinks
         self["static_resources"]["clusters"] = clusters
 
+        load_shedding = config.ir.ambassador_module.get("load_shedding", None)
+
+        if load_shedding:
+            self["overload_manager"] = overload_manager(load_shedding)
+
+    def add_dd_entity_id(self) -> None:
+        dd_entity_id = os.environ.get("DD_ENTITY_ID", None)
+
+        if dd_entity_id:
+            stats_tags = self.setdefault("stats_config", {}).setdefault("stats_tags", [])
+            stats_tags.append({"tag_name": "dd.internal.entity_id", "fixed_value": dd_entity_id})
+
     @classmethod
     def generate(cls, config: "V3Config") -> None:
         config.bootstrap = V3Bootstrap(config)
 
 
+def statsd_sink(
+    ip: str, port: int, dogstatsd: bool, prefix: Optional[str] = None
+) -> Dict[str, Any]:
+    if dogstatsd:
+        name = "envoy.stat_sinks.dog_statsd"
+        typename = "type.googleapis.com/envoy.config.metrics.v3.DogStatsdSink"
+    else:
+        name = "envoy.stats_sinks.statsd"
+        typename = "type.googleapis.com/envoy.config.metrics.v3.StatsdSink"
+
+    typed_config: Dict[str, Any] = {
+        "@type": typename,
+        "address": {
+            "socket_address": {
+                "protocol": "UDP",
+                "address": ip,
+                "port_value": port,
+            }
+        },
+    }
+
+    if prefix:
+        typed_config["prefix"] = prefix
+
+    return {"name": name, "typed_config": typed_config}
+
+
+def grpc_cluster(name: str, host: Optional[str], port: int) -> Dict[str, Any]:
+    return {
+        "name": name,
+        "type": "strict_dns",
+        "connect_timeout": "1s",
+        "http2_protocol_options": {},
+        "load_assignment": {
+            "cluster_name": name,
+            "endpoints": [
+                {
+                    "lb_endpoints": [
+                        {
+                            "endpoint": {
+                                "address": {
+                                    "socket_address": {
+                                        "address": host,
+                                        "port_value": port,
+                                        "protocol": "TCP",
+                                    }
+                                }
+                            }
+                        }
+                    ]
+                }
+            ],
+        },
+    }
+
+
+def module_stats_sinks(
+    config: "V3Config", stats_sinks: Dict[str, Any], clusters: List[Dict[str, Any]]
+) -> List[Dict[str, Any]]:
+    """
+    The sinks for the ambassador Module's stats_sinks (see irstatssinks.py). The OTLP sink's
+    cluster goes in clusters.
+    """
+
+    sinks: List[Dict[str, Any]] = []
+    statsd = stats_sinks["statsd"]
+
+    if statsd:
+        sinks.append(
+            statsd_sink(statsd["ip"], statsd["port"], statsd["dogstatsd"], statsd["prefix"])
+        )
+
+    otlp = stats_sinks["otlp"]
+
+    if otlp:
+        sink = {
+            "name": "envoy.stat_sinks.open_telemetry",
+            "typed_config": {
+                "@type": "type.googleapis.com/envoy.extensions.stat_sinks.open_telemetry.v3.SinkConfig",
+                "grpc_service": {"envoy_grpc": {"cluster_name": OTLP_CLUSTER}},
+                "report_counters_as_deltas": otlp["deltas"],
+                "report_histograms_as_deltas": otlp["deltas"],
+            },
+        }
+
+        # The Envoy in the image doesn't have this sink, and Envoy won't start with a bootstrap
+        # config that it can't take, so it's only for an AMBASSADOR_ENVOY_BINARY that does.
+        probe = load_envoy_probe()
+
+        if (not probe) or missing_extensions({"stats_sinks": [sink]}, probe):
+            binary = probe.get("binary", "Envoy") if probe else "the Envoy in the image"
+            config.ir.post_error(
+                "stats_sinks otlp needs an Envoy with envoy.stat_sinks.open_telemetry, which "
+                f"{binary} doesn't have (see AMBASSADOR_ENVOY_BINARY), ignoring otlp",
+                resource=config.ir.ambassador_module,
+            )
+        else:
+            sinks.append(sink)
+            clusters.append(grpc_cluster(OTLP_CLUSTER, otlp["host"], otlp["port"]))
+
+    return sinks
+
+
+def module_stats_matcher(stats_sinks: Dict[str, Any]) -> Optional[Dict[str, Any]]:
+    """
+    Envoy's stats matcher for the ambassador Module's stats_sinks include or exclude, if
+    either is set.
+    """
+
+    for key, matcher in [("include", "inclusion_list"), ("exclude", "exclusion_list")]:
+        if stats_sinks[key]:
+            return {
+                matcher: {
+                    "patterns": [
+                        {"safe_regex": {"google_re2": {}, "regex": pattern}}
+                        for pattern in stats_sinks[key]
+                    ]
+                }
+            }
+
+    return None
+
+
+def overload_manager(load_shedding: Dict[str, Any]) -> Dict[str, Any]:
+    """
+    Envoy's overload manager for the ambassador Module's load_shedding (see
+    irloadshedding.py): each kind of pressure that's watched scales the
+    stop_accepting_requests action, which sheds that fraction of new requests.
+    """
+
+    monitors = []
+
+    if load_shedding["max_heap_bytes"]:
+        monitors.append(
+            {
+                "name": "envoy.resource_monitors.fixed_heap",
+                "typed_config": {
+                    "@type": "type.googleapis.com/envoy.extensions.resource_monitors.fixed_heap.v3.FixedHeapConfig",
+                    "max_heap_size_bytes": load_shedding["max_heap_bytes"],
+                },
+            }
+        )
+
+    if load_shedding["cpu_pressure_file"]:
+        monitors.append(
+            {
+                "name": "envoy.resource_monitors.injected_resource",
+                "typed_config": {
+                    "@type": "type.googleapis.com/envoy.extensions.resource_monitors.injected_resource.v3.InjectedResourceConfig",
+                    "filename": load_shedding["cpu_pressure_file"],
+                },
+            }
+        )
+
+    return {
+        "refresh_interval": "0.25s",
+        "resource_monitors": monitors,
+        "actions": [
+            {
+                "name": "envoy.overload_actions.stop_accepting_requests",
+                "triggers": [
+                    {
+                        "name": monitor["name"],
+                        "scaled": {
+                            "scaling_threshold": load_shedding["scaling_threshold"],
+                            "saturation_threshold": load_shedding["saturation_threshold"],
+                        },
+                    }
+                    for monitor in monitors
+                ],
+            }
+        ],
+    }
+
+
 def split_host_port(value: str) -> Tuple[Optional[str], int]:
     parsed = urlparse("//" + value)
     return parsed.hostname, int(parsed.port or 80)
@@ -13,10 +13,11 @@
 # limitations under the License
 
 import urllib
-from typing import TYPE_CHECKING, Dict, List, Union
+from typing import TYPE_CHECKING, Any, Dict, List, Union
 
 from ...cache import Cacheable
 from ...ir.ircluster import IRCluster
+from ...ir.irupstreams import UPSTREAMS_METADATA_KEY
 from .v3tls import V3TLSContext
 
 if TYPE_CHECKING:
@@ -56,9 +57,26 @@ class V3Cluster(Cacheable):
                     % (ctype)
                 )
                 ctype = "STRICT_DNS"
+
+            # An ExternalName Service goes to whatever its target resolves to right now, and
+            # the target may well be a CNAME for a load balancer with a short TTL. LOGICAL_DNS
+            # keeps connections to the old addresses until they close, and re-resolves as
+            # often as the TTL says, so a changed target is picked up without being waited
+            # out, or hammered on.
+            if cmap_entry.get("external_name"):
+                ctype = "LOGICAL_DNS"
         else:
             ctype = "EDS"
 
+        # A cluster with upstreams gets its endpoints from all of them, through ambex, no
+        # matter what its service's resolver is (see irupstreams.py).
+        upstream_sources = cluster.get("upstream_sources", None)
+        service_name = cmap_entry.get("endpoint_path", None)
+
+        if upstream_sources is not None:
+            ctype = "EDS"
+            service_name = "upstreams/%s" % cluster.envoy_name
+
         fields = {
             "name": cluster.envoy_name,
             "type": ctype,
@@ -70,8 +88,8 @@ class V3Cluster(Cacheable):
         if cluster.get("stats_name", ""):
             fields["alt_stat_name"] = cluster.stats_name
 
-        if cluster.respect_dns_ttl:
-            fields["respect_dns_ttl"] = cluster.respect_dns_ttl
+        if cluster.respect_dns_ttl or cmap_entry.get("external_name"):
+            fields["respect_dns_ttl"] = True
 
         # Don't feed the raw health_checks object to envoy, grab its config where the econf has already been built
         if cluster.health_checks is not None:
@@ -85,6 +103,36 @@ class V3Cluster(Cacheable):
                         % (cluster.name)
                     )
 
+        # Subsets are picked by the labels of the Pods behind the endpoints, which only EDS
+        # endpoints carry. Each route says which subset it wants (see V3Route); a route whose
+        # subset has no endpoints gets no fallback, rather than going to some other version.
+        subset_keys = cluster.get("subset_keys", None)
+
+        if subset_keys:
+            if ctype == "EDS":
+                fields["lb_subset_config"] = {
+                    "fallback_policy": "NO_FALLBACK",
+                    "subset_selectors": [{"keys": subset_keys}],
+                }
+            else:
+                cluster.ir.logger.error(
+                    "subset_labels is only supported when using the endpoint resolver. Ignoring subset_labels for cluster: %s..."
+                    % (cluster.name)
+                )
+
+        # Failover puts the fallback services in the load assignment as lower priorities, so
+        # Envoy sends traffic down to them as the services above lose healthy endpoints. EDS
+        # load assignments come from ambex instead, which knows nothing of fallbacks, and a
+        # LOGICAL_DNS cluster can only have a single endpoint.
+        failover = cluster.get("failover", None)
+
+        if failover and (ctype != "STRICT_DNS"):
+            cluster.ir.logger.error(
+                "failover is only supported for STRICT_DNS clusters. Ignoring failover for cluster: %s..."
+                % (cluster.name)
+            )
+            failover = None
+
         if ctype == "EDS":
             fields["eds_cluster_config"] = {
                 "eds_config": {
@@ -92,14 +140,25 @@ class V3Cluster(Cacheable):
                     # Envoy may default to an older API version if we are not explicit about V3 here.
                     "resource_api_version": "V3",
                 },
-                "service_name": cmap_entry["endpoint_path"],
+                "service_name": service_name,
             }
+
+            if upstream_sources is not None:
+                fields["metadata"] = {
+                    "filter_metadata": {UPSTREAMS_METADATA_KEY: {"upstreams": upstream_sources}}
+                }
+
+                # Without this, Envoy ignores the weights of the upstreams' localities.
+                fields["common_lb_config"] = {"locality_weighted_lb_config": {}}
         else:
             fields["load_assignment"] = {
                 "cluster_name": cluster.envoy_name,
                 "endpoints": [{"lb_endpoints": self.get_endpoints(cluster)}],
             }
 
+            if failover:
+                self.add_failover(fields, cluster, failover)
+
         if cluster.cluster_idle_timeout_ms:
             cluster_idle_timeout_ms = cluster.cluster_idle_timeout_ms
         else:
@@ -128,9 +187,14 @@ class V3Cluster(Cacheable):
         if circuit_breakers is not None:
             fields["circuit_breakers"] = circuit_breakers
 
-        # If this cluster is using http2 for grpc, set http2_protocol_options
-        # Otherwise, check for http1-specific configuration.
-        if cluster.get("grpc", False):
+        # If this cluster is using http2 (for grpc, or because its upstream_protocol is h2
+        # or h2c), set http2_protocol_options. Otherwise, check for http1-specific
+        # configuration: even with auto, the upstream might pick HTTP/1.1.
+        upstream_protocol = cluster.get("upstream_protocol", None)
+
+        if (upstream_protocol in ["h2", "h2c"]) or (
+            cluster.get("grpc", False) and (upstream_protocol != "auto")
+        ):
             self["http2_protocol_options"] = {}
         else:
             proper_case: bool = cluster.ir.ambassador_module["proper_case"]
@@ -159,6 +223,9 @@ class V3Cluster(Cacheable):
                     http_options = self.setdefault("http_protocol_options", {})
                     http_options["header_key_format"] = custom_header_rules
 
+        if upstream_protocol == "auto":
+            self.use_auto_config()
+
         ctx = cluster.get("tls_context", None)
 
         if ctx is not None:
@@ -175,6 +242,28 @@ class V3Cluster(Cacheable):
             else:
                 envoy_ctx = V3TLSContext(ctx=ctx, host_rewrite=cluster.get("host_rewrite", None))
 
+                # OCSP stapling is for the certificates we serve, not the ones we originate with.
+                envoy_ctx.pop("ocsp_staple_policy", None)
+                for cert in envoy_ctx.get_certs():
+                    cert.pop("ocsp_staple", None)
+
+            # The upstream behind an ExternalName Service knows itself by the target's name,
+            # not the Service's, so that's the SNI to send unless the TLSContext says otherwise.
+            ext_name = cmap_entry.get("external_name")
+
+            if ext_name and not envoy_ctx.get("sni"):
+                envoy_ctx["sni"] = ext_name
+
+            # Offer the upstream the protocols we're willing to speak, unless the TLSContext
+            # has its own ideas.
+            alpn = {"h2": ["h2"], "auto": ["h2", "http/1.1"]}.get(upstream_protocol or "", None)
+
+            if alpn:
+                common_tls_context = envoy_ctx.setdefault("common_tls_context", {})
+
+                if not common_tls_context.get("alpn_protocols"):
+                    common_tls_context["alpn_protocols"] = alpn
+
             if envoy_ctx:
                 fields["transport_socket"] = {
                     "name": "envoy.transport_sockets.tls",
@@ -207,12 +296,67 @@ class V3Cluster(Cacheable):
 
         self.update(fields)
 
+    def use_auto_config(self) -> None:
+        # Envoy only lets ALPN pick the protocol through the HttpProtocolOptions extension,
+        # which can't be used alongside the cluster's own protocol options, so those move
+        # in there.
+        options: Dict[str, Any] = {
+            "@type": "type.googleapis.com/envoy.extensions.upstreams.http.v3.HttpProtocolOptions",
+            "auto_config": {
+                "http_protocol_options": self.pop("http_protocol_options", {}),
+                "http2_protocol_options": {},
+            },
+        }
+
+        common_http_options = self.pop("common_http_protocol_options", None)
+
+        if common_http_options:
+            options["common_http_protocol_options"] = common_http_options
+
+        self["typed_extension_protocol_options"] = {
+            "envoy.extensions.upstreams.http.v3.HttpProtocolOptions": options
+        }
+
+    def add_failover(self, fields: dict, cluster: IRCluster, failover: dict) -> None:
+        load_assignment = fields["load_assignment"]
+        failover_targets = cluster.get("failover_targets", [])
+
+        for i, url in enumerate(failover["urls"]):
+            targets = failover_targets[i] if i < len(failover_targets) else None
+
+            load_assignment["endpoints"].append(
+                {
+                    "priority": i + 1,
+                    "lb_endpoints": self.endpoints_for(targets or [], [url]),
+                }
+            )
+
+        # Envoy's overprovisioning factor says how far below 100% healthy a priority can get
+        # before it starts spilling traffic to the next one down.
+        healthy_percent = failover.get("healthy_percent", None)
+
+        if healthy_percent:
+            load_assignment["policy"] = {
+                "overprovisioning_factor": int(round(10000 / healthy_percent))
+            }
+
+        # DNS clusters have no active health checks, so an endpoint is unhealthy when it
+        # keeps failing requests. Let every endpoint get ejected, or a dead primary region
+        # would keep some of its traffic forever.
+        fields["outlier_detection"] = {
+            "consecutive_5xx": failover.get("consecutive_5xx", None) or 5,
+            "base_ejection_time": "%0.3fs"
+            % (float(failover.get("ejection_time_ms", None) or 30000) / 1000.0),
+            "max_ejection_percent": 100,
+        }
+
     def get_endpoints(self, cluster: IRCluster):
-        result = []
+        return self.endpoints_for(cluster.get("targets", []), cluster.urls)
 
-        targetlist = cluster.get("targets", [])
+    def endpoints_for(self, targetlist, urls: List[str]):
+        result = []
 
-        if len(targetlist) > 0:
+        if targetlist:
             for target in targetlist:
                 endpoint = {
                     "address": {
@@ -225,7 +369,7 @@ class V3Cluster(Cacheable):
                 }
                 result.append({"endpoint": endpoint})
         else:
-            for u in cluster.urls:
+            for u in urls:
                 p = urllib.parse.urlparse(u)
                 endpoint = {
                     "address": {
@@ -273,6 +417,19 @@ class V3Cluster(Cacheable):
         config.clusters = []
         config.clustermap = {}
 
+        # The clustermap also records which Mappings route to each cluster, so that ambex can
+        # tell people where a cluster came from without guessing (see pkg/ambex/introspect.go).
+        mappings: Dict[str, List[Dict[str, str]]] = {}
+
+        for group in config.ir.groups.values():
+            for mapping in group.get("mappings", []):
+                mapping_cluster = mapping.get("cluster", None)
+
+                if mapping_cluster and mapping.get_label("ambassador_crd"):
+                    mappings.setdefault(mapping_cluster.envoy_name, []).append(
+                        {"name": mapping.name.split(".", 1)[0], "namespace": mapping.namespace}
+                    )
+
         # Sort by the envoy cluster name (x.envoy_name), not the symbolic IR cluster name (x.name)
         for ircluster in sorted(config.ir.clusters.values(), key=lambda x: x.envoy_name):
             # XXX This magic format is duplicated for now in ir.py.
@@ -302,4 +459,12 @@ class V3Cluster(Cacheable):
                 cluster = cached_cluster
 
             config.clusters.append(cluster)
-            config.clustermap[ircluster.envoy_name] = ircluster.clustermap_entry()
+
+            entry: Dict[str, Any] = dict(ircluster.clustermap_entry())
+            k8s_service = ircluster.k8s_service()
+
+            if k8s_service:
+                entry["k8s_service"] = {"name": k8s_service[0], "namespace": k8s_service[1]}
+
+            entry["mappings"] = mappings.get(ircluster.envoy_name, [])
+            config.clustermap[ircluster.envoy_name] = entry
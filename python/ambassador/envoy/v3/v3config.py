@@ -20,6 +20,8 @@ from .v3_static_resources import V3StaticResources
 from .v3admin import V3Admin
 from .v3bootstrap import V3Bootstrap
 from .v3cluster import V3Cluster
+from .v3egress import V3Egress
+from .v3extensions import V3Extensions
 from .v3listener import V3Listener
 from .v3ratelimit import V3RateLimit
 from .v3ready import V3Ready
@@ -46,6 +48,8 @@ class V3Config(EnvoyConfig):
     clusters: List[V3Cluster]
     static_resources: V3StaticResources
     clustermap: Dict[str, Any]
+    # Which routes these are, for debug headers; None if debug headers are off.
+    debug_config_version: Optional[str]
 
     def __init__(self, ir: "IR", cache: Optional[Cache] = None) -> None:
         ir.logger.info("EnvoyConfig: Generating V3")
@@ -66,6 +70,10 @@ class V3Config(EnvoyConfig):
         V3StaticResources.generate(self)
         V3Bootstrap.generate(self)
         V3Ready.generate(self)
+        V3Egress.generate(self)
+
+        # If we're configuring an Envoy of the user's own, make sure that it can take this.
+        V3Extensions.check(self)
 
     def has_listeners(self) -> bool:
         return len(self.listeners) > 0
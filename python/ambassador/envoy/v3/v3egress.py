@@ -0,0 +1,229 @@
+# Copyright 2021 Datawire. All rights reserved.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License
+
+import hashlib
+import os
+import re
+from typing import TYPE_CHECKING, Any, Dict, List
+
+from ...config import Config
+
+if TYPE_CHECKING:
+    from . import V3Config  # pragma: no cover
+
+# The CA bundle that egress TLS verifies destinations against can be changed with
+# AMBASSADOR_EGRESS_CA_BUNDLE.
+ambassador_egress_ca_bundle = os.getenv(
+    "AMBASSADOR_EGRESS_CA_BUNDLE", "/etc/ssl/certs/ca-certificates.crt"
+)
+
+# Envoy stat names get unwieldy past this.
+MAX_CLUSTER_NAME = 60
+
+
+def egress_cluster_name(host: str, port: int) -> str:
+    name = re.sub(r"[^0-9A-Za-z_]", "_", f"egress_{host}_{port}")
+
+    if len(name) > MAX_CLUSTER_NAME:
+        h = hashlib.new("sha1")
+        h.update(name.encode("utf-8"))
+        name = name[: MAX_CLUSTER_NAME - 17] + "-" + h.hexdigest()[:16]
+
+    return name
+
+
+class V3Egress(dict):
+    @classmethod
+    def generate(cls, config: "V3Config") -> None:
+        for port, destinations in sorted(config.ir.egress_ports.items()):
+            config.ir.logger.info(f"V3Egress: ==== listen on {port}")
+
+            dests = [destinations[k] for k in sorted(destinations.keys())]
+
+            config.static_resources["listeners"].append(cls.listener(config, port, dests))
+
+            for dest in dests:
+                config.static_resources["clusters"].append(cls.cluster(config, dest))
+
+    @classmethod
+    def listener(cls, config: "V3Config", port: int, dests: List[Dict[str, Any]]) -> dict:
+        virtual_hosts: List[dict] = []
+        defaulted = set()
+
+        for dest in dests:
+            host = dest["host"]
+            domains = [f"{host}:{dest['port']}"]
+
+            # A bare host goes to the destination's first port, but only one of them.
+            if dest["default"] and (host not in defaulted):
+                domains.append(host)
+                defaulted.add(host)
+
+            cluster_name = egress_cluster_name(host, dest["port"])
+
+            virtual_hosts.append(
+                {
+                    "name": cluster_name,
+                    "domains": domains,
+                    "routes": [
+                        {
+                            "name": cluster_name,
+                            "match": {"prefix": "/"},
+                            "route": {
+                                "cluster": cluster_name,
+                                "host_rewrite_literal": host,
+                            },
+                        }
+                    ],
+                }
+            )
+
+        # Anything that isn't on the allowlist gets refused.
+        virtual_hosts.append(
+            {
+                "name": "egress_deny",
+                "domains": ["*"],
+                "routes": [
+                    {
+                        "name": "egress_deny",
+                        "match": {"prefix": "/"},
+                        "direct_response": {
+                            "status": 403,
+                            "body": {
+                                "inline_string": "egress to this destination is not allowed\n"
+                            },
+                        },
+                    }
+                ],
+            }
+        )
+
+        typed_config = {
+            "@type": "type.googleapis.com/envoy.extensions.filters.network.http_connection_manager.v3.HttpConnectionManager",
+            "stat_prefix": f"egress_{port}",
+            "route_config": {"name": f"egress_{port}", "virtual_hosts": virtual_hosts},
+            "http_filters": [{"name": "envoy.filters.http.router"}],
+            "access_log": cls.access_log(config, port),
+        }
+
+        return {
+            "name": f"ambassador-egress-{port}",
+            "address": {
+                "socket_address": {
+                    "address": Config.envoy_bind_address,
+                    "port_value": port,
+                    "protocol": "TCP",
+                }
+            },
+            "filter_chains": [
+                {
+                    "filter_chain_match": {},
+                    "filters": [
+                        {
+                            "name": "envoy.filters.network.http_connection_manager",
+                            "typed_config": typed_config,
+                        }
+                    ],
+                }
+            ],
+        }
+
+    @classmethod
+    def cluster(cls, config: "V3Config", dest: Dict[str, Any]) -> dict:
+        cluster_name = egress_cluster_name(dest["host"], dest["port"])
+
+        dns_lookup_family = "V4_ONLY"
+
+        if config.ir.ambassador_module.get("enable_ipv6", False):
+            dns_lookup_family = "AUTO"
+
+        cluster = {
+            "name": cluster_name,
+            "type": "LOGICAL_DNS",
+            "connect_timeout": "3.000s",
+            "dns_lookup_family": dns_lookup_family,
+            "lb_policy": "ROUND_ROBIN",
+            "load_assignment": {
+                "cluster_name": cluster_name,
+                "endpoints": [
+                    {
+                        "lb_endpoints": [
+                            {
+                                "endpoint": {
+                                    "address": {
+                                        "socket_address": {
+                                            "address": dest["host"],
+                                            "port_value": dest["port"],
+                                            "protocol": "TCP",
+                                        }
+                                    }
+                                }
+                            }
+                        ]
+                    }
+                ],
+            },
+        }
+
+        if dest["tls"]:
+            cluster["transport_socket"] = {
+                "name": "envoy.transport_sockets.tls",
+                "typed_config": {
+                    "@type": "type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.UpstreamTlsContext",
+                    "sni": dest["sni"],
+                    "common_tls_context": {
+                        "validation_context": {
+                            "trusted_ca": {"filename": ambassador_egress_ca_bundle},
+                            "match_typed_subject_alt_names": [
+                                {"san_type": "DNS", "matcher": {"exact": dest["sni"]}}
+                            ],
+                        }
+                    },
+                },
+            }
+
+        return cluster
+
+    # access_log is the audit log for an egress listener: always JSON, so that it's
+    # easy to pick out where each request went, and whether it was allowed.
+    @classmethod
+    def access_log(cls, config: "V3Config", port: int) -> List[dict]:
+        log_format = {
+            "egress_port": str(port),
+            "start_time": "%START_TIME%",
+            "method": "%REQ(:METHOD)%",
+            "authority": "%REQ(:AUTHORITY)%",
+            "path": "%REQ(X-ENVOY-ORIGINAL-PATH?:PATH)%",
+            "route_name": "%ROUTE_NAME%",
+            "response_code": "%RESPONSE_CODE%",
+            "response_flags": "%RESPONSE_FLAGS%",
+            "bytes_received": "%BYTES_RECEIVED%",
+            "bytes_sent": "%BYTES_SENT%",
+            "duration": "%DURATION%",
+            "downstream_remote_address": "%DOWNSTREAM_REMOTE_ADDRESS%",
+            "upstream_host": "%UPSTREAM_HOST%",
+            "upstream_cluster": "%UPSTREAM_CLUSTER%",
+            "upstream_transport_failure_reason": "%UPSTREAM_TRANSPORT_FAILURE_REASON%",
+        }
+
+        return [
+            {
+                "name": "envoy.access_loggers.file",
+                "typed_config": {
+                    "@type": "type.googleapis.com/envoy.extensions.access_loggers.file.v3.FileAccessLog",
+                    "path": config.ir.ambassador_module.envoy_log_path,
+                    "json_format": log_format,
+                },
+            }
+        ]
@@ -0,0 +1,113 @@
+import json
+import os
+from typing import TYPE_CHECKING, Any, Dict, List, Optional, Set, Tuple
+
+if TYPE_CHECKING:
+    from . import V3Config  # pragma: no cover
+
+#############################################################################
+## v3extensions.py -- can the Envoy that we're configuring take this?
+##
+## With AMBASSADOR_ENVOY_BINARY, the entrypoint runs an Envoy of the user's
+## own instead of the image's, and leaves what it found out about it in
+## AMBASSADOR_ENVOY_PROBE_FILE (see cmd/entrypoint/envoyprobe.go), including
+## the extensions it has, and the types of their configurations. The
+## entrypoint already refused to start if the binary was missing anything that
+## every configuration uses; this catches the rest, like an AuthService on a
+## build without ext_authz, and says which extension is missing, and where.
+##
+## Nothing is left out of the configuration, since quietly dropping an auth
+## filter, say, is worse than not configuring Envoy at all: the configuration
+## fails validation, and Envoy keeps the last one it took.
+
+# The keys whose values are lists of extensions, and the ones whose values are an extension.
+EXTENSION_LISTS = {"access_log", "filters", "http_filters", "listener_filters", "stats_sinks"}
+EXTENSION_KEYS = {"transport_socket"}
+
+TYPE_PREFIX = "type.googleapis.com/"
+
+
+def load_envoy_probe(path: Optional[str] = None) -> Optional[Dict[str, Any]]:
+    """
+    Load the entrypoint's probe of AMBASSADOR_ENVOY_BINARY, or return None if there isn't one.
+    """
+
+    path = path or os.environ.get("AMBASSADOR_ENVOY_PROBE_FILE", None)
+
+    if not path or not os.path.exists(path):
+        return None
+
+    with open(path, "r") as f:
+        return json.load(f)
+
+
+def _extensions(config: Any, where: str) -> List[Tuple[str, Dict[str, Any]]]:
+    found: List[Tuple[str, Dict[str, Any]]] = []
+
+    if isinstance(config, dict):
+        for key, value in config.items():
+            here = f"{where}.{key}" if where else key
+
+            if (key in EXTENSION_LISTS) and isinstance(value, list):
+                found.extend(
+                    (here, ext) for ext in value if isinstance(ext, dict) and ("name" in ext)
+                )
+            elif (key in EXTENSION_KEYS) and isinstance(value, dict) and ("name" in value):
+                found.append((here, value))
+
+            found.extend(_extensions(value, here))
+    elif isinstance(config, list):
+        for value in config:
+            name = value.get("name", None) if isinstance(value, dict) else None
+            found.extend(_extensions(value, f"{where}[{name}]" if name else where))
+
+    return found
+
+
+def missing_extensions(config: Dict[str, Any], probe: Dict[str, Any]) -> List[Tuple[str, str]]:
+    """
+    Return (where, extension) for every extension in config that the probed Envoy doesn't have.
+    Envoy looks an extension up by the type of its typed_config, or by its name.
+    """
+
+    extensions: Dict[str, List[str]] = probe.get("extensions", None) or {}
+    types: Set[str] = {t for type_urls in extensions.values() for t in (type_urls or [])}
+    missing: List[Tuple[str, str]] = []
+
+    for where, ext in _extensions(config, ""):
+        type_url = ext.get("typed_config", {}).get("@type", "")
+
+        if type_url.startswith(TYPE_PREFIX):
+            type_url = type_url[len(TYPE_PREFIX) :]
+
+        if (type_url in types) or (ext["name"] in extensions):
+            continue
+
+        missing.append((where, ext["name"]))
+
+    return missing
+
+
+class V3Extensions:
+    @classmethod
+    def check(cls, config: "V3Config") -> None:
+        probe = load_envoy_probe()
+
+        if not probe:
+            return
+
+        _, ads_config, _ = config.split_config()
+        seen: Set[Tuple[str, str]] = set()
+
+        for where, name in missing_extensions(
+            {"bootstrap": dict(config.bootstrap), **ads_config}, probe
+        ):
+            if (where, name) in seen:
+                continue
+
+            seen.add((where, name))
+            config.ir.post_error(
+                f"{where} needs the Envoy extension {name}, which {probe.get('binary', 'Envoy')} "
+                f"doesn't have",
+                resource=config.ir.ambassador_module,
+            )
@@ -19,6 +19,9 @@ from typing import cast as typecast
 from ...ir.irauth import IRAuth
 from ...ir.irbuffer import IRBuffer
 from ...ir.ircluster import IRCluster
+from ...ir.ircontractcapture import FILTER_NAME as CONTRACT_CAPTURE_FILTER_NAME
+from ...ir.ircontractcapture import SKIP_PROCESSING_MODE, IRContractCapture
+from ...ir.irdebugheaders import jwt_authn_config, lua_code
 from ...ir.irerrorresponse import IRErrorResponse
 from ...ir.irfilter import IRFilter
 from ...ir.irgzip import IRGzip
@@ -84,9 +87,12 @@ def V3HTTPFilter(irfilter: IRFilter, v3config: "V3Config"):
         "ir.grpc_http1_bridge": V3HTTPFilter_grpc_http1_bridge,
         "ir.grpc_web": V3HTTPFilter_grpc_web,
         "ir.grpc_stats": V3HTTPFilter_grpc_stats,
+        "ir.grpc_json_transcoder": V3HTTPFilter_grpc_json_transcoder,
         "ir.cors": V3HTTPFilter_cors,
         "ir.router": V3HTTPFilter_router,
         "ir.lua_scripts": V3HTTPFilter_lua,
+        "ir.debug_token": V3HTTPFilter_debug_token,
+        "ir.debug_headers": V3HTTPFilter_debug_headers,
     }[irfilter.kind]
 
     return fn(irfilter, v3config)
@@ -163,6 +169,51 @@ def V3HTTPFilter_grpc_stats(irfilter: IRFilter, v3config: "V3Config"):
     }
 
 
+def V3HTTPFilter_grpc_json_transcoder(irfilter: IRFilter, v3config: "V3Config"):
+    del irfilter  # silence unused-variable warning
+
+    # Envoy insists on descriptors even for a transcoder that does nothing, so borrow the
+    # ones from the first route that transcodes, but with no services: each transcoding
+    # route turns it on with its own (see V3Route). With no routes transcoding, there's
+    # no need for the filter at all.
+    for route in v3config.routes:
+        per_route = route.get("typed_per_filter_config", {}).get(
+            "envoy.filters.http.grpc_json_transcoder", None
+        )
+
+        if per_route:
+            return {
+                "name": "envoy.filters.http.grpc_json_transcoder",
+                "typed_config": {
+                    "@type": per_route["@type"],
+                    "proto_descriptor_bin": per_route["proto_descriptor_bin"],
+                    "services": [],
+                },
+            }
+
+    return None
+
+
+@V3HTTPFilter.register
+def V3HTTPFilter_contract_capture(capture: IRContractCapture, v3config: "V3Config"):
+    del v3config  # silence unused-variable warning
+
+    # Without a cluster, no Mapping captures, so there's no need for the filter. With one, it
+    # skips everything except on the routes that turn it on (see V3Route).
+    if not capture.cluster:
+        return None
+
+    return {
+        "name": CONTRACT_CAPTURE_FILTER_NAME,
+        "typed_config": {
+            "@type": "type.googleapis.com/envoy.extensions.filters.http.ext_proc.v3.ExternalProcessor",
+            "grpc_service": {"envoy_grpc": {"cluster_name": capture.cluster.envoy_name}},
+            "failure_mode_allow": True,
+            "processing_mode": SKIP_PROCESSING_MODE,
+        },
+    }
+
+
 def auth_cluster_uri(auth: IRAuth, cluster: IRCluster) -> str:
     cluster_context = cluster.get("tls_context")
     scheme = "https" if cluster_context else "http"
@@ -497,3 +548,26 @@ def V3HTTPFilter_lua(irfilter: IRFilter, v3config: "V3Config"):
         ] = "type.googleapis.com/envoy.extensions.filters.http.lua.v3.Lua"
 
     return config
+
+
+def V3HTTPFilter_debug_token(irfilter: IRFilter, v3config: "V3Config"):
+    del v3config  # silence unused-variable warning
+
+    typed_config = jwt_authn_config(irfilter.config_dict() or {})
+    typed_config[
+        "@type"
+    ] = "type.googleapis.com/envoy.extensions.filters.http.jwt_authn.v3.JwtAuthentication"
+
+    return {"name": "envoy.filters.http.jwt_authn", "typed_config": typed_config}
+
+
+def V3HTTPFilter_debug_headers(irfilter: IRFilter, v3config: "V3Config"):
+    del v3config  # silence unused-variable warning
+
+    return {
+        "name": "envoy.filters.http.lua",
+        "typed_config": {
+            "@type": "type.googleapis.com/envoy.extensions.filters.http.lua.v3.Lua",
+            "inline_code": lua_code(irfilter.config_dict() or {}),
+        },
+    }
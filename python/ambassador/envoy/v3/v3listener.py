@@ -11,15 +11,28 @@
 # WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
 # See the License for the specific language governing permissions and
 # limitations under the License
+import hashlib
+import json
 import logging
+import re
 from typing import TYPE_CHECKING, Any, Dict, List, Literal, Optional, Set, Tuple, Union
 from typing import cast as typecast
 
+from ...ir.irdebugheaders import debug_response_headers
 from ...ir.irhost import IRHost
 from ...ir.irlistener import IRListener
 from ...ir.irtcpmappinggroup import IRTCPMappingGroup
-from ...utils import parse_bool
-from .v3route import DictifiedV3Route, V3Route, V3RouteVariants, hostglob_matches, v3prettyroute
+from ...ir.irtimeouts import envoy_duration
+from ...ir.irtlspolicy import effective_tls_policy
+from ...utils import feature_gate_enabled, parse_bool
+from .v3route import (
+    DictifiedV3Route,
+    V3Route,
+    V3RouteVariants,
+    hostglob_matches,
+    regex_matcher,
+    v3prettyroute,
+)
 from .v3tls import V3TLSContext
 
 if TYPE_CHECKING:
@@ -27,6 +40,10 @@ if TYPE_CHECKING:
     from ...ir.irtlscontext import IRTLSContext  # pragma: no cover
     from . import V3Config  # pragma: no cover
 
+# The admin config ID of the tap filter that the TrafficCapture feature gate adds. pkg/capture
+# attaches taps to it through Envoy's /tap admin endpoint, and has to agree on the ID.
+CAPTURE_CONFIG_ID = "ambassador_capture"
+
 
 # Model an Envoy filter chain.
 #
@@ -237,6 +254,16 @@ class V3Listener:
     def _security_model(self) -> Literal["XFP", "SECURE", "INSECURE"]:
         return self._irlistener.securityModel
 
+    @property
+    def _request_normalization(self) -> Dict[str, Any]:
+        # See irrequestnormalization.py.
+        return self._irlistener.request_normalization
+
+    @property
+    def _tls_fingerprint(self) -> Optional[Dict[str, Any]]:
+        # See irtlsfingerprint.py.
+        return self._irlistener.tls_fingerprint
+
     @property
     def _l7_depth(self) -> int:
         return self._irlistener.get("l7Depth", 0)
@@ -298,7 +325,15 @@ class V3Listener:
                 ## When UDP we assume it is http/3 listener and configured for quic which has TLS built into the protocol
                 ## therefore, we only need to add this when socket_protocol is TCP
                 if self.isProtocolTCP():
-                    self.listener_filters.append({"name": "envoy.filters.listener.tls_inspector"})
+                    tls_inspector: Dict[str, Any] = {"name": "envoy.filters.listener.tls_inspector"}
+
+                    if self._tls_fingerprint:
+                        tls_inspector["typed_config"] = {
+                            "@type": "type.googleapis.com/envoy.extensions.filters.listener.tls_inspector.v3.TlsInspector",
+                            "enable_ja3_fingerprinting": True,
+                        }
+
+                    self.listener_filters.append(tls_inspector)
 
             if proto == "TCP":
                 # Nothing to do.
@@ -420,6 +455,9 @@ class V3Listener:
                     log_format["dd.trace_id"] = "%REQ(X-DATADOG-TRACE-ID)%"
                     log_format["dd.span_id"] = "%REQ(X-DATADOG-PARENT-ID)%"
 
+                if self._tls_fingerprint:
+                    log_format["tls_ja3_fingerprint"] = "%TLS_JA3_FINGERPRINT%"
+
             access_log.append(
                 {
                     "name": "envoy.access_loggers.file",
@@ -437,6 +475,9 @@ class V3Listener:
             if not log_format:
                 log_format = 'ACCESS [%START_TIME%] "%REQ(:METHOD)% %REQ(X-ENVOY-ORIGINAL-PATH?:PATH)% %PROTOCOL%" %RESPONSE_CODE% %RESPONSE_FLAGS% %BYTES_RECEIVED% %BYTES_SENT% %DURATION% %RESP(X-ENVOY-UPSTREAM-SERVICE-TIME)% "%REQ(X-FORWARDED-FOR)%" "%REQ(USER-AGENT)%" "%REQ(X-REQUEST-ID)%" "%REQ(:AUTHORITY)%" "%UPSTREAM_HOST%"'
 
+                if self._tls_fingerprint:
+                    log_format += ' "%TLS_JA3_FINGERPRINT%"'
+
             if self._log_debug:
                 self.config.ir.logger.debug("V3Listener: Using log_format '%s'" % log_format)
             access_log.append(
@@ -459,7 +500,7 @@ class V3Listener:
             "stat_prefix": self._stats_prefix,
             "access_log": self.access_log(),
             "http_filters": [],
-            "normalize_path": True,
+            "normalize_path": self._request_normalization["normalize_path"],
         }
 
         # Instructs the HTTP Connection Mananger to support http/3. This is required for both TCP and UDP Listeners
@@ -482,6 +523,23 @@ class V3Listener:
             if v3hf:
                 base_http_config["http_filters"].append(v3hf)
 
+        self.add_auth_fingerprint_header(base_http_config["http_filters"])
+
+        if feature_gate_enabled("TrafficCapture"):
+            # The tap filter goes first, so that captures show requests as the client sent them,
+            # even if a later filter (like auth) turns them away. It does nothing until a tap is
+            # attached to it.
+            base_http_config["http_filters"].insert(
+                0,
+                {
+                    "name": "envoy.filters.http.tap",
+                    "typed_config": {
+                        "@type": "type.googleapis.com/envoy.extensions.filters.http.tap.v3.Tap",
+                        "common_config": {"admin_config": {"config_id": CAPTURE_CONFIG_ID}},
+                    },
+                },
+            )
+
         if "use_remote_address" in self.config.ir.ambassador_module:
             base_http_config[
                 "use_remote_address"
@@ -493,6 +551,28 @@ class V3Listener:
         if "server_name" in self.config.ir.ambassador_module:
             base_http_config["server_name"] = self.config.ir.ambassador_module.server_name
 
+        # When the overload manager sheds a request (see V3Bootstrap), it's turned away before
+        # any HTTP filter sees it, so the Retry-After has to come from the local reply config.
+        load_shedding = self.config.ir.ambassador_module.get("load_shedding", None)
+
+        if load_shedding and load_shedding["retry_after"]:
+            base_http_config["local_reply_config"] = {
+                "mappers": [
+                    {
+                        "filter": {"response_flag_filter": {"flags": ["OM"]}},
+                        "headers_to_add": [
+                            {
+                                "header": {
+                                    "key": "retry-after",
+                                    "value": str(load_shedding["retry_after"]),
+                                },
+                                "append": False,
+                            }
+                        ],
+                    }
+                ]
+            }
+
         listener_idle_timeout_ms = self.config.ir.ambassador_module.get(
             "listener_idle_timeout_ms", None
         )
@@ -506,14 +586,17 @@ class V3Listener:
                     "idle_timeout": "%0.3fs" % (float(listener_idle_timeout_ms) / 1000.0)
                 }
 
-        if "headers_with_underscores_action" in self.config.ir.ambassador_module:
+        headers_with_underscores_action = self._request_normalization[
+            "headers_with_underscores_action"
+        ]
+        if headers_with_underscores_action is not None:
             if "common_http_protocol_options" in base_http_config:
                 base_http_config["common_http_protocol_options"][
                     "headers_with_underscores_action"
-                ] = self.config.ir.ambassador_module.headers_with_underscores_action
+                ] = headers_with_underscores_action
             else:
                 base_http_config["common_http_protocol_options"] = {
-                    "headers_with_underscores_action": self.config.ir.ambassador_module.headers_with_underscores_action
+                    "headers_with_underscores_action": headers_with_underscores_action
                 }
 
         max_request_headers_kb = self.config.ir.ambassador_module.get(
@@ -702,6 +785,10 @@ class V3Listener:
                     # Note that we're modifying the filter_chain itself here, not
                     # filter_chain_match.
                     envoy_ctx = V3TLSContext(chain.context)
+                    tls_policy = effective_tls_policy(
+                        self.config.ir, chain.context, self._irlistener
+                    )
+                    envoy_ctx.add_tls_policy(tls_policy["settings"])
 
                     filter_chain["transport_socket"] = {
                         "name": "envoy.transport_sockets.tls",
@@ -838,6 +925,14 @@ class V3Listener:
                         f"        consider route {v3prettyroute(dict(rv.route))}"
                     )
 
+                # A Mapping with views only belongs on Listeners in one of those views.
+                views = rv.route._group.get("views", None)
+
+                if views and (self._irlistener.get("view", None) not in views):
+                    if self._log_debug:
+                        self.config.ir.logger.debug(f"          reject: not in views {views}")
+                    continue
+
                 matching_hosts = chain.matching_hosts(rv.route)
 
                 if self._log_debug:
@@ -911,6 +1006,7 @@ class V3Listener:
 
                             variant = dict(rv.get_variant(matcher, action.lower()))
                             variant["_host_constraints"] = set([hostname])
+                            self.apply_host_timeouts(host, variant)
                             # virtual_host domains are key by the hostname for :authority header matching
                             chain.add_route(hostname, variant)
                         else:
@@ -919,6 +1015,16 @@ class V3Listener:
                                     f"          route: reject matcher={matcher} action={action} {extra_info}"
                                 )
 
+            # Redirects go ahead of every Mapping on their Hosts (see irredirect.py), but
+            # not ahead of the ACME hole below.
+            for hostname, host in chain.hosts.items():
+                redirects = self.config.ir.redirects.get(hostname, None)
+
+                if isinstance(host, IRHost) and redirects:
+                    chain.routes[hostname][0:0] = [
+                        V3Route.for_redirect(self.config, hostname, r) for r in redirects
+                    ]
+
             # If we're on Edge Stack and we don't already have an ACME route, add one.
             if self.config.ir.edge_stack_allowed and not found_acme:
                 # This route is needed to trigger an ExtAuthz request for the AuthService.
@@ -953,6 +1059,186 @@ class V3Listener:
                             f"  CHAIN ROUTE: vhost={hostname} {v3prettyroute(route)}"
                         )
 
+    def apply_host_timeouts(self, host: IRHost, variant: DictifiedV3Route) -> None:
+        # The Host's request timeout overrides the Module's, but not the Mapping's (see
+        # irtimeouts.py). Variants are shared between Hosts, so copy before changing anything.
+        host_timeout_ms = host.get("request_timeout_ms", None)
+
+        if (
+            (host_timeout_ms is not None)
+            and ("route" in variant)
+            and not variant.get("_mapping_timeout", False)
+        ):
+            variant["route"] = dict(variant["route"], timeout=envoy_duration(host_timeout_ms))
+
+    def add_identity_headers(self, host: IRHost, vhost: Dict[str, Any]) -> None:
+        # Envoy removes a virtual host's request_headers_to_remove before it adds its
+        # request_headers_to_add, and does both after the route's, so a client (or a
+        # Mapping) can't get its own value into an identity header. See iridentity.py.
+        headers = host.get("identity_headers", None)
+
+        if not headers:
+            return
+
+        vhost["request_headers_to_remove"] = [h["name"] for h in headers]
+        vhost["request_headers_to_add"] = [
+            {"header": {"key": h["name"], "value": h["value"]}, "append": False}
+            for h in headers
+        ]
+
+    def add_auth_fingerprint_header(self, http_filters: List[Dict[str, Any]]) -> None:
+        # HTTP AuthServices get the TLS fingerprint header too. The vhost's headers only
+        # get added once the request gets to the router, after ext_authz, so it has to
+        # go in the check itself. See irtlsfingerprint.py.
+        header = (self._tls_fingerprint or {}).get("header", None)
+
+        if not header:
+            return
+
+        for i, f in enumerate(http_filters):
+            http_service = f.get("typed_config", {}).get("http_service", None)
+
+            if (f["name"] != "envoy.filters.http.ext_authz") or not http_service:
+                continue
+
+            authorization_request = dict(http_service.get("authorization_request", {}))
+            authorization_request["headers_to_add"] = [
+                h for h in authorization_request.get("headers_to_add", []) if h["key"] != header
+            ] + [{"key": header, "value": "%TLS_JA3_FINGERPRINT%"}]
+
+            http_filters[i] = dict(
+                f,
+                typed_config=dict(
+                    f["typed_config"],
+                    http_service=dict(http_service, authorization_request=authorization_request),
+                ),
+            )
+
+    def add_fingerprint_header(self, vhost: Dict[str, Any]) -> None:
+        # Like the identity headers, the fingerprint header is removed before it's added,
+        # so that a client can't send its own when Envoy has no fingerprint (Envoy doesn't
+        # add a header with an empty value). See irtlsfingerprint.py.
+        header = (self._tls_fingerprint or {}).get("header", None)
+
+        if not header:
+            return
+
+        vhost.setdefault("request_headers_to_remove", []).append(header)
+        vhost.setdefault("request_headers_to_add", []).append(
+            {"header": {"key": header, "value": "%TLS_JA3_FINGERPRINT%"}, "append": False}
+        )
+
+    def add_debug_headers(self, vhost: Dict[str, Any]) -> None:
+        # The Mapping's debug header comes from its route; the rest are the same for
+        # every route. The debug_headers Lua filter takes them all out of responses to
+        # requests without a debug token. See irdebugheaders.py.
+        version = self.config.debug_config_version
+
+        if not version:
+            return
+
+        vhost.setdefault("response_headers_to_add", []).extend(debug_response_headers(version))
+
+    def host_route(self, host: IRHost, route: Dict[str, Any]) -> Dict[str, Any]:
+        # Make certain that no internal keys from the route make it into the Envoy
+        # configuration. Envoy only keeps stats for routes with a stat_prefix, which
+        # are rooted at vhost.<vhost name>.route.<stat_prefix>.
+        envoy_route = {k: v for k, v in route.items() if k[0] != "_"}
+
+        telemetry = host.get("telemetry", None) or {}
+
+        if telemetry.get("routeStats", False) and route.get("_stat_name", None):
+            envoy_route["stat_prefix"] = route["_stat_name"]
+
+        # A Host in maintenance answers for everything that would go upstream, except ACME
+        # challenges: certificates still have to renew. Redirects are left alone.
+        maintenance = host.get("maintenance", None)
+
+        if (
+            maintenance
+            and ("route" in envoy_route)
+            and (envoy_route["match"].get("prefix", None) != "/.well-known/acme-challenge/")
+        ):
+            V3Route.answer_for_maintenance(envoy_route, maintenance)
+
+        return envoy_route
+
+    def access_log_filter(self, hosts: List[IRHost]) -> Optional[Dict[str, Any]]:
+        # Access logs belong to the HTTP connection manager, not to the virtual host, so
+        # a chain with more than one Host has to sort requests out by :authority, the way
+        # the virtual hosts do. Requests for the Hosts that sample their logs get logged
+        # by a runtime filter keyed on the Host; everything else always gets logged.
+        sampled = []
+
+        for host in hosts:
+            telemetry = host.get("telemetry", None) or {}
+            percent = telemetry.get("accessLogSampling", None)
+
+            if percent is not None:
+                sampled.append((host, percent))
+
+        if not sampled:
+            return None
+
+        if len(hosts) == 1:
+            host, percent = sampled[0]
+            return self.access_log_sampling(host, percent)
+
+        filters = [
+            {
+                "and_filter": {
+                    "filters": [
+                        self.authority_filter(host),
+                        self.access_log_sampling(host, percent),
+                    ]
+                }
+            }
+            for host, percent in sampled
+        ]
+
+        unsampled = [self.authority_filter(host, invert=True) for host, _ in sampled]
+
+        if len(unsampled) == 1:
+            filters.append(unsampled[0])
+        else:
+            filters.append({"and_filter": {"filters": unsampled}})
+
+        return {"or_filter": {"filters": filters}}
+
+    def access_log_sampling(self, host: IRHost, percent: int) -> Dict[str, Any]:
+        # The runtime key lets an operator change a Host's sampling on the fly through
+        # Envoy's admin runtime, without a reconfiguration.
+        return {
+            "runtime_filter": {
+                "runtime_key": f"ambassador.access_log_sampling.{host.name}.{host.namespace}",
+                "percent_sampled": {"numerator": percent, "denominator": "HUNDRED"},
+            }
+        }
+
+    def authority_filter(self, host: IRHost, invert: bool = False) -> Dict[str, Any]:
+        # A virtual host's domains match :authority with or without a port, and a
+        # leading or trailing "*" matches anything at all.
+        hostname = host.hostname
+
+        if hostname == "*":
+            regex = ".*"
+        elif hostname.startswith("*"):
+            regex = ".+" + re.escape(hostname[1:]) + "(:[0-9]+)?"
+        elif hostname.endswith("*"):
+            regex = re.escape(hostname[:-1]) + ".+"
+        else:
+            regex = re.escape(hostname) + "(:[0-9]+)?"
+
+        matcher: Dict[str, Any] = {
+            "name": ":authority",
+            "string_match": regex_matcher(self.config, f"(?i)^{regex}$"),
+        }
+
+        if invert:
+            matcher["invert_match"] = True
+
+        return {"header_filter": {"header": matcher}}
+
     def finalize_http(self) -> None:
         # Finalize everything HTTP. Like the TCP side of the world, this is about walking
         # chains and generating Envoy config.
@@ -1051,6 +1337,8 @@ class V3Listener:
                 )
 
                 envoy_ctx = V3TLSContext(chain.context)
+                tls_policy = effective_tls_policy(self.config.ir, chain.context, self._irlistener)
+                envoy_ctx.add_tls_policy(tls_policy["settings"])
 
                 envoy_tls_config = {
                     "name": "envoy.transport_sockets.tls",
@@ -1086,12 +1374,10 @@ class V3Listener:
                 if self._log_debug:
                     self._irlistener.logger.debug(f"      adding vhost {repr(host.hostname)}")
 
-                # Make certain that no internal keys from the route make it into the Envoy
-                # configuration.
                 routes = []
 
                 for r in chain.routes[host.hostname]:
-                    routes.append({k: v for k, v in r.items() if k[0] != "_"})
+                    routes.append(self.host_route(host, r))
 
                 # Do we - somehow - already have a vhost for this hostname? (This should
                 # be "impossible".)
@@ -1122,7 +1408,12 @@ class V3Listener:
                     else:
                         del vhost["response_headers_to_add"]
 
+                    self.add_identity_headers(host, vhost)
+                    self.add_fingerprint_header(vhost)
+                    self.add_debug_headers(vhost)
+
                     filter_chain["_vhosts"][host.hostname] = vhost
+                    filter_chain.setdefault("_hosts", []).append(host)
 
                 vhost["routes"] += routes
 
@@ -1134,24 +1425,44 @@ class V3Listener:
             # ...and unfold our vhosts dict into a list for Envoy.
             http_config["route_config"] = {"virtual_hosts": list(filter_chain["_vhosts"].values())}
 
+            # Envoy won't send a direct response body bigger than 4KiB unless it's told to.
+            body_size = max(
+                [
+                    len(r["direct_response"]["body"]["inline_string"].encode("utf-8"))
+                    for vhost in http_config["route_config"]["virtual_hosts"]
+                    for r in vhost["routes"]
+                    if "inline_string" in r.get("direct_response", {}).get("body", {})
+                ],
+                default=0,
+            )
+
+            if body_size > 4096:
+                http_config["route_config"]["max_direct_response_body_size_bytes"] = body_size
+
             # Now that we've saved our vhosts as a list, drop the dict version.
             del filter_chain["_vhosts"]
 
+            # Sample the access logs of any Hosts that want that.
+            log_filter = self.access_log_filter(filter_chain.pop("_hosts", []))
+
+            if log_filter and http_config.get("access_log", None):
+                http_config["access_log"] = [
+                    dict(al, filter=log_filter) for al in http_config["access_log"]
+                ]
+
             # Finish up config for this filter chain...
             if parse_bool(
                 self.config.ir.ambassador_module.get("strip_matching_host_port", "false")
             ):
                 http_config["strip_matching_host_port"] = True
 
-            if parse_bool(self.config.ir.ambassador_module.get("merge_slashes", "false")):
+            if self._request_normalization["merge_slashes"]:
                 http_config["merge_slashes"] = True
 
-            if parse_bool(
-                self.config.ir.ambassador_module.get(
-                    "reject_requests_with_escaped_slashes", "false"
-                )
-            ):
-                http_config["path_with_escaped_slashes_action"] = "REJECT_REQUEST"
+            escaped_slashes_action = self._request_normalization["escaped_slashes_action"]
+
+            if escaped_slashes_action is not None:
+                http_config["path_with_escaped_slashes_action"] = escaped_slashes_action
 
             filter_chain["filters"] = [
                 {
@@ -1194,8 +1505,50 @@ class V3Listener:
         if self.listener_filters:
             listener["listener_filters"] = self.listener_filters
 
+        # Socket tuning from the Listener. All of these are left unset unless the Listener
+        # sets them, so that Envoy's defaults (SO_REUSEPORT on, in particular) stand.
+        reuse_port = self._irlistener.get("reusePort", None)
+
+        if reuse_port is not None:
+            listener["enable_reuse_port"] = reuse_port
+
+        if self._irlistener.get("connectionBalance", None) == "exact":
+            listener["connection_balance_config"] = {"exact_balance": {}}
+
+        tcp_backlog_size = self._irlistener.get("tcpBacklogSize", None)
+
+        if tcp_backlog_size:
+            listener["tcp_backlog_size"] = tcp_backlog_size
+
+        socket_options = self._irlistener.get("socketOptions", None)
+
+        if socket_options:
+            listener["socket_options"] = [self.socket_option(o) for o in socket_options]
+
         return listener
 
+    @staticmethod
+    def socket_option(option: Dict[str, Any]) -> Dict[str, Any]:
+        """
+        Translate one of a Listener's (already checked) socketOptions into Envoy's SocketOption.
+        """
+
+        envoy_option: Dict[str, Any] = {
+            "level": option["level"],
+            "name": option["name"],
+            "state": f"STATE_{option.get('state', None) or 'PREBIND'}",
+        }
+
+        if option.get("description", None):
+            envoy_option["description"] = option["description"]
+
+        if option.get("intValue", None) is not None:
+            envoy_option["int_value"] = option["intValue"]
+        else:
+            envoy_option["buf_value"] = option["bufValue"]
+
+        return envoy_option
+
     def __str__(self) -> str:
         return "<V3Listener %s %s on %s:%d [%s]>" % (
             "HTTP" if self._base_http_config else "TCP",
@@ -1209,6 +1562,15 @@ class V3Listener:
         """Whether the listener is configured to use the TCP protocol or not?"""
         return self.socket_protocol == "TCP"
 
+    @staticmethod
+    def routes_version(routes: List[V3Route]) -> str:
+        # A short hash of the routes, leaving out our own bookkeeping (the keys that
+        # start with an underscore), so that it changes when the routes do.
+        public = [{k: v for k, v in route.items() if not k.startswith("_")} for route in routes]
+        serialized = json.dumps(public, sort_keys=True, default=str)
+
+        return hashlib.sha1(serialized.encode("utf-8")).hexdigest()[:12]
+
     def isProtocolUDP(self) -> bool:
         """Whether the listener is configured to use the UDP protocol or not?"""
         return self.socket_protocol == "UDP"
@@ -1216,6 +1578,10 @@ class V3Listener:
     @classmethod
     def generate(cls, config: "V3Config") -> None:
         config.listeners = []
+        config.debug_config_version = None
+
+        if config.ir.ambassador_module.get("debug_headers", None):
+            config.debug_config_version = cls.routes_version(config.routes)
 
         for key in config.ir.listeners.keys():
             irlistener = config.ir.listeners[key]
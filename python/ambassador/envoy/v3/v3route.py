@@ -12,12 +12,19 @@
 # See the License for the specific language governing permissions and
 # limitations under the License
 
-from typing import TYPE_CHECKING, Any, Dict, List, Optional, Union
+import hashlib
+import os
+from typing import TYPE_CHECKING, Any, Dict, List, Optional, Tuple, Union
 from typing import cast as typecast
 
 from ...cache import Cacheable
 from ...ir.irbasemapping import IRBaseMapping
+from ...ir.ircontractcapture import CAPTURE_PROCESSING_MODE, CONTRACT_CAPTURE_METADATA
+from ...ir.ircontractcapture import FILTER_NAME as CONTRACT_CAPTURE_FILTER_NAME
+from ...ir.irdebugheaders import MAPPING_HEADER
+from ...ir.irgrpctranscoder import GRPCDescriptorFactory
 from ...ir.irhttpmappinggroup import IRHTTPMappingGroup
+from ...ir.irtimeouts import effective_timeouts, envoy_duration, mapping_sets_request_timeout
 from ...ir.irutils import hostglob_matches
 from ..common import EnvoyRoute
 from .v3ratelimitaction import V3RateLimitAction
@@ -89,6 +96,10 @@ def v3prettyroute(route: DictifiedV3Route) -> str:
     return f"<V3Route {hcstr}: {match_str} -> {target_str}>"
 
 
+# Envoy's RedirectResponseCode enum, by the status code that it sends.
+REDIRECT_RESPONSE_CODES = {301: 0, 302: 1, 303: 2, 307: 3, 308: 4}
+
+
 # regex_matcher generates Envoy configuration to do a regex match in a Route. It's complex
 # here because, even though we don't have to deal with safe and unsafe regexes, it's simpler
 # to keep the weird baroqueness of this stuff wrapped in a function.
@@ -237,6 +248,7 @@ class V3RouteVariants:
     # instead.
     def action_redirect(self, variant) -> None:
         variant.pop("route", None)
+        variant.pop("direct_response", None)
         variant["redirect"] = {"https_redirect": True}
         for filter in self.route._group.ir.filters:
             if filter.kind == "IRAuth":
@@ -261,9 +273,55 @@ class V3RouteVariants:
 # mess with the one host glob at this point.
 
 
+# With AMBASSADOR_CONFIG_LIMITS_MODE=enforce, ambex leaves out the routes of whichever Mappings
+# it has to to keep Envoy's configuration within the config limits, and marks those Mappings
+# Inactive. Each route lists its Mappings in its metadata under this key, so that ambex knows
+# which they are (see pkg/ambex/guardrails.go).
+MAPPINGS_METADATA_KEY = "getambassador.io/mappings"
+
+
+def config_limits_enforced() -> bool:
+    return os.environ.get("AMBASSADOR_CONFIG_LIMITS_MODE", "").lower() == "enforce"
+
+
+# The (Mapping, weight) pairs of IRHTTPMappingGroup.weighted_mappings, with the weights in
+# hundredths of a percent.
+WeightedMappings = List[Tuple[IRBaseMapping, int]]
+
+
 class V3Route(Cacheable):
+    # What a route takes from its Mapping rather than its group. Envoy can split a route's
+    # requests among weighted clusters, but nothing else, so Mappings that share a route that
+    # way have to agree on all of these.
+    MappingRouteKeys = [
+        "auth_context_extensions",
+        "auto_host_rewrite",
+        "bypass_auth",
+        "bypass_error_response_overrides",
+        "case_sensitive",
+        "error_response_overrides",
+        "grpc_transcoder",
+        "host_rewrite",
+        "idle_timeout_ms",
+        "maintenance",
+        "prefix",
+        "rewrite",
+        "static_response",
+        "subset_labels",
+        "timeout_ms",
+    ]
+
+    # A V3Route normally sends the requests it matches to mapping's cluster, for mapping's
+    # weight in its group. weight, in hundredths of a percent, overrides the Mapping's weight;
+    # weighted splits the requests among the clusters of all of its Mappings instead, and
+    # takes everything else from mapping.
     def __init__(
-        self, config: "V3Config", group: IRHTTPMappingGroup, mapping: IRBaseMapping
+        self,
+        config: "V3Config",
+        group: IRHTTPMappingGroup,
+        mapping: IRBaseMapping,
+        weight: Optional[int] = None,
+        weighted: Optional[WeightedMappings] = None,
     ) -> None:
         super().__init__()
 
@@ -290,9 +348,12 @@ class V3Route(Cacheable):
             else group.get("case_sensitive", True)
         )
 
-        runtime_fraction: Dict[str, Union[dict, str]] = {
-            "default_value": {"numerator": mapping.get("_weight", 100), "denominator": "HUNDRED"}
-        }
+        if weight is not None:
+            default_value = {"numerator": weight, "denominator": "TEN_THOUSAND"}
+        else:
+            default_value = {"numerator": mapping.get("_weight", 100), "denominator": "HUNDRED"}
+
+        runtime_fraction: Dict[str, Union[dict, str]] = {"default_value": default_value}
 
         if len(mapping) > 0:
             if not "cluster" in mapping:
@@ -308,7 +369,11 @@ class V3Route(Cacheable):
                     "runtime_key"
                 ] = f"routing.traffic_shift.{mapping.cluster.envoy_name}"
 
-        match = {"case_sensitive": case_sensitive, "runtime_fraction": runtime_fraction}
+        match: Dict[str, Any] = {"case_sensitive": case_sensitive}
+
+        # A route with weighted clusters takes all the requests it matches, and splits them.
+        if not weighted:
+            match["runtime_fraction"] = runtime_fraction
 
         if envoy_route == "prefix":
             match["prefix"] = route_prefix
@@ -392,6 +457,41 @@ class V3Route(Cacheable):
                     "check_settings": {"context_extensions": auth_context_extensions},
                 }
 
+        # The transcoder filter is in the chain with no services, which Envoy takes to mean
+        # "do nothing", so here's where a transcoding Mapping turns it on. It has to keep the
+        # route that matched the REST path, rather than looking for one for the gRPC path.
+        grpc_transcoder = mapping.get("grpc_transcoder", None)
+        if grpc_transcoder:
+            typed_per_filter_config["envoy.filters.http.grpc_json_transcoder"] = {
+                "@type": "type.googleapis.com/envoy.extensions.filters.http.grpc_json_transcoder.v3.GrpcJsonTranscoder",
+                "proto_descriptor_bin": GRPCDescriptorFactory.descriptor_bin(
+                    config.ir, grpc_transcoder
+                ),
+                "services": grpc_transcoder["services"],
+                "match_incoming_request_route": True,
+            }
+
+        # The contract capture filter skips everything unless the route says otherwise. The
+        # entrypoint's capture server gets the Mapping's name in the gRPC metadata, to find
+        # out where to send what it captures.
+        capture_cluster = config.ir.contract_capture.cluster if config.ir.contract_capture else None
+        if mapping.get("contract_capture", None) and capture_cluster:
+            typed_per_filter_config[CONTRACT_CAPTURE_FILTER_NAME] = {
+                "@type": "type.googleapis.com/envoy.extensions.filters.http.ext_proc.v3.ExtProcPerRoute",
+                "overrides": {
+                    "processing_mode": CAPTURE_PROCESSING_MODE,
+                    "grpc_service": {
+                        "envoy_grpc": {"cluster_name": capture_cluster.envoy_name},
+                        "initial_metadata": [
+                            {
+                                "key": CONTRACT_CAPTURE_METADATA,
+                                "value": f"{mapping.name}.{mapping.namespace}",
+                            }
+                        ],
+                    },
+                },
+            }
+
         if len(typed_per_filter_config) > 0:
             self["typed_per_filter_config"] = typed_per_filter_config
 
@@ -403,6 +503,17 @@ class V3Route(Cacheable):
         if response_headers_to_add:
             self["response_headers_to_add"] = self.generate_headers_to_add(response_headers_to_add)
 
+        # Say which Mapping (or Mappings, for a weighted route) this is, for debug tokens. The
+        # debug_headers Lua filter takes this out of responses to everyone else.
+        if config.ir.ambassador_module.get("debug_headers", None):
+            matched = [m for m, _ in weighted] if weighted else [mapping]
+            names = [f"{m.name}.{m.namespace}" for m in matched if m.get("name", None)]
+
+            if names:
+                self.setdefault("response_headers_to_add", []).append(
+                    {"header": {"key": MAPPING_HEADER, "value": ",".join(names)}, "append": False}
+                )
+
         request_headers_to_remove = group.get("remove_request_headers", None)
         if request_headers_to_remove:
             if type(request_headers_to_remove) != list:
@@ -415,6 +526,20 @@ class V3Route(Cacheable):
                 response_headers_to_remove = [response_headers_to_remove]
             self["response_headers_to_remove"] = response_headers_to_remove
 
+        # A Mapping in maintenance answers for itself instead of going upstream.
+        maintenance = mapping.get("maintenance", None)
+
+        if maintenance:
+            V3Route.answer_for_maintenance(self, maintenance)
+            return
+
+        # So does a Mapping with a static response.
+        static = mapping.get("static_response", None)
+
+        if static:
+            self.answer_with_static_response(config, static)
+            return
+
         host_redirect = group.get("host_redirect", None)
 
         if host_redirect:
@@ -463,22 +588,54 @@ class V3Route(Cacheable):
 
             return
 
-        # Take the default `timeout_ms` value from the Ambassador module using `cluster_request_timeout_ms`.
-        # If that isn't set, use 3000ms. The mapping below will override this if its own `timeout_ms` is set.
-        default_timeout_ms = config.ir.ambassador_module.get("cluster_request_timeout_ms", 3000)
+        # See irtimeouts.py for how the timeouts are inherited. Routes aren't specific to a Host,
+        # so the Host's request timeout gets applied later, in V3Listener, unless the Mapping sets
+        # its own.
+        timeouts = effective_timeouts(config.ir, group, mapping)
         route = {
             "priority": group.get("priority"),
-            "timeout": "%0.3fs" % (mapping.get("timeout_ms", default_timeout_ms) / 1000.0),
-            "cluster": mapping.cluster.envoy_name,
+            "timeout": envoy_duration(timeouts["request_timeout_ms"]["value"]),
         }
 
-        idle_timeout_ms = mapping.get("idle_timeout_ms", None)
+        if weighted:
+            route["weighted_clusters"] = {"clusters": self.generate_weighted_clusters(weighted)}
+        else:
+            route["cluster"] = mapping.cluster.envoy_name
+
+        if mapping_sets_request_timeout(mapping):
+            self["_mapping_timeout"] = True
+
+        # A Host with telemetry.routeStats turns this into the route's stat_prefix.
+        if mapping.get("name", None):
+            self["_stat_name"] = f"{mapping.name}.{mapping.namespace}"
+
+        # Only Mappings that are CRDs have a status to say that they were rejected, and the
+        # name in the status is the resource's, as diagd posts it.
+        if config_limits_enforced():
+            matched = [m for m, _ in weighted] if weighted else [mapping]
+            refs = [
+                {"name": m.name.split(".", 1)[0], "namespace": m.namespace}
+                for m in matched
+                if m.get_label("ambassador_crd")
+            ]
+
+            if refs:
+                self["metadata"] = {"filter_metadata": {MAPPINGS_METADATA_KEY: {"mappings": refs}}}
 
-        if idle_timeout_ms is not None:
-            route["idle_timeout"] = "%0.3fs" % (idle_timeout_ms / 1000.0)
+        # The cluster has a subset selector for these labels' keys (see V3Cluster), and the
+        # endpoints carry the labels of their Pods as envoy.lb metadata.
+        if mapping.get("subset_labels", None) and mapping.cluster.get("subset_keys", None):
+            route["metadata_match"] = {"filter_metadata": {"envoy.lb": mapping.subset_labels}}
+
+        if timeouts["idle_timeout_ms"] is not None:
+            route["idle_timeout"] = envoy_duration(timeouts["idle_timeout_ms"]["value"])
 
         regex_rewrite = self.generate_regex_rewrite(config, group)
-        if len(regex_rewrite) > 0:
+        if mapping.get("grpc_transcoder", None):
+            # The transcoder sets the path to the gRPC method, so rewriting it would only
+            # break it.
+            pass
+        elif len(regex_rewrite) > 0:
             route["regex_rewrite"] = regex_rewrite
         elif mapping.get("rewrite", None):
             route["prefix_rewrite"] = mapping["rewrite"]
@@ -593,7 +750,12 @@ class V3Route(Cacheable):
 
     @classmethod
     def get_route(
-        cls, config: "V3Config", cache_key: str, irgroup: IRHTTPMappingGroup, mapping: IRBaseMapping
+        cls,
+        config: "V3Config",
+        cache_key: str,
+        irgroup: IRHTTPMappingGroup,
+        mapping: IRBaseMapping,
+        **kwargs,
     ) -> "V3Route":
         route: "V3Route"
 
@@ -603,7 +765,7 @@ class V3Route(Cacheable):
             # Cache miss.
             # config.ir.logger.info(f"V3Route: cache miss for {cache_key}, synthesizing route")
 
-            route = V3Route(config, irgroup, mapping)
+            route = V3Route(config, irgroup, mapping, **kwargs)
 
             # Cheat a bit and force the route's cache_key.
             route.cache_key = cache_key
@@ -651,6 +813,12 @@ class V3Route(Cacheable):
                 )
                 config.routes.append(route)
 
+            weighted = irgroup.get("weighted_mappings", None)
+
+            if weighted:
+                config.routes.extend(cls.generate_weighted(config, irgroup, weighted))
+                continue
+
             # Repeat for our real mappings.
             for mapping in irgroup.mappings:
                 key = f"Route-{irgroup.group_id}-{mapping.cache_key}"
@@ -667,6 +835,67 @@ class V3Route(Cacheable):
             # Set up a currently-empty set of variants for this route.
             config.route_variants.append(V3RouteVariants(route))
 
+    @classmethod
+    def generate_weighted(
+        cls, config: "V3Config", irgroup: IRHTTPMappingGroup, weighted: WeightedMappings
+    ) -> List[DictifiedV3Route]:
+        # A group that falls back gets a single route that splits its requests among the
+        # clusters of all the Mappings in weighted_mappings, as long as they agree on the rest
+        # of the route. If they don't, each Mapping gets a route of its own, with a runtime
+        # fraction that takes its share; Envoy draws one random number per request for all of
+        # a request's runtime fractions, so cumulative fractions split requests just the same.
+        first = weighted[0][0]
+        routes: List[V3Route] = []
+
+        if all(
+            all(mapping.get(k, None) == first.get(k, None) for k in cls.MappingRouteKeys)
+            for mapping, _ in weighted
+        ):
+            plan = ",".join(
+                f"{mapping.cache_key}={mapping.cluster.envoy_name}={share}"
+                for mapping, share in weighted
+            )
+            digest = hashlib.sha1(plan.encode("utf-8")).hexdigest()
+            key = f"Route-{irgroup.group_id}-weighted-{digest}"
+
+            routes.append(cls.get_route(config, key, irgroup, first, weighted=weighted))
+        else:
+            claimed = 0
+
+            for mapping, share in weighted:
+                claimed += share
+                key = f"Route-{irgroup.group_id}-{mapping.cache_key}-{claimed}"
+
+                routes.append(cls.get_route(config, key, irgroup, mapping, weight=claimed))
+
+        result = []
+
+        for route in routes:
+            if route.get("_failed", False):
+                continue
+
+            # Changes to the groups fallen back to change the route, too.
+            for mapping, _ in weighted:
+                fallback_group = config.ir.groups.get(mapping.group_id, None)
+
+                if fallback_group and (fallback_group is not irgroup):
+                    config.cache.link(fallback_group, route)
+
+            result.append(config.save_element("route", irgroup, route))
+
+        return result
+
+    @staticmethod
+    def generate_weighted_clusters(weighted: WeightedMappings) -> List[dict]:
+        # Mappings can share a cluster, but Envoy wants each cluster listed once.
+        weights: Dict[str, int] = {}
+
+        for mapping, share in weighted:
+            name = mapping.cluster.envoy_name
+            weights[name] = weights.get(name, 0) + share
+
+        return [{"name": name, "weight": share} for name, share in weights.items() if share > 0]
+
     @staticmethod
     def generate_headers(config: "V3Config", mapping_group: IRHTTPMappingGroup) -> List[dict]:
         headers = []
@@ -763,6 +992,116 @@ class V3Route(Cacheable):
 
         return hash_policy
 
+    @staticmethod
+    def answer_for_maintenance(route: Dict[str, Any], maintenance: Dict[str, Any]) -> None:
+        # Answer with what maintenance says (see irmaintenance.py), rather than routing.
+        # Envoy adds the route's response headers to a direct response, too.
+        route.pop("route", None)
+        route["direct_response"] = {"status": maintenance["status"]}
+        headers = {}
+
+        if maintenance["body"]:
+            route["direct_response"]["body"] = {"inline_string": maintenance["body"]}
+            headers["content-type"] = maintenance["content_type"]
+
+        if maintenance.get("retry_after", None) is not None:
+            headers["retry-after"] = str(maintenance["retry_after"])
+
+        if headers:
+            route["response_headers_to_add"] = (route.get("response_headers_to_add") or []) + [
+                {"header": {"key": k, "value": v}, "append": False} for k, v in headers.items()
+            ]
+
+    @staticmethod
+    def for_redirect(config: "V3Config", hostname: str, redirect: Dict[str, Any]) -> Dict[str, Any]:
+        # A route for a Redirect (see irredirect.py), which only ever goes on the Host it was
+        # worked out for. The path_regex has to match the whole path, so anchor it for the
+        # rewrite too, or Envoy would rewrite every match in the path.
+        path_regex = redirect["path_regex"]
+        action: Dict[str, Any] = {
+            "response_code": REDIRECT_RESPONSE_CODES[redirect["status_code"]],
+        }
+
+        if redirect["host_redirect"]:
+            action["host_redirect"] = redirect["host_redirect"]
+
+        if redirect["scheme"]:
+            action["scheme_redirect"] = redirect["scheme"]
+
+        if redirect["path_rewrite"]:
+            action["regex_rewrite"] = {
+                **regex_matcher(config, f"^(?:{path_regex})$", safe_key="pattern"),
+                "substitution": redirect["path_rewrite"],
+            }
+
+        return {
+            "_host_constraints": set([hostname]),
+            "match": regex_matcher(config, path_regex) if path_regex else {"prefix": "/"},
+            "redirect": action,
+        }
+
+    def answer_with_static_response(self, config: "V3Config", static: Dict[str, Any]) -> None:
+        # Answer with the static response (see irstaticresponse.py), rather than routing.
+        self.pop("route", None)
+        self["direct_response"] = {"status": static["status"]}
+        headers = {}
+
+        if static["body"]:
+            self["direct_response"]["body"] = {"inline_string": static["body"]}
+            headers["content-type"] = static["content_type"]
+
+        # Envoy always formats header values, so a literal % has to be written as %%.
+        for name, value in static["headers"].items():
+            headers[name] = value if static["templated"] else value.replace("%", "%%")
+
+        if headers:
+            self["response_headers_to_add"] = (self.get("response_headers_to_add") or []) + [
+                {"header": {"key": k, "value": v}, "append": False} for k, v in headers.items()
+            ]
+
+        # Envoy doesn't format direct response bodies, but the response_map filter formats
+        # the bodies it puts in, so a templated body goes through that; otherwise, keep the
+        # Module's error_response_overrides from replacing the body.
+        typed_per_filter_config = dict(self.get("typed_per_filter_config") or {})
+        typed_per_filter_config.pop("envoy.filters.http.response_map", None)
+
+        if static["templated"] and static["body"]:
+            typed_per_filter_config["envoy.filters.http.response_map"] = {
+                "@type": "type.googleapis.com/envoy.extensions.filters.http.response_map.v3.ResponseMapPerRoute",
+                "response_map": {
+                    "mappers": [
+                        {
+                            "filter": {
+                                "status_code_filter": {
+                                    "comparison": {
+                                        "op": "EQ",
+                                        "value": {
+                                            "default_value": str(static["status"]),
+                                            # See IRErrorResponse about this key.
+                                            "runtime_key": "_donotsetthiskey",
+                                        },
+                                    }
+                                }
+                            },
+                            "body_format_override": {
+                                "text_format": static["body"],
+                                "content_type": static["content_type"],
+                            },
+                        }
+                    ]
+                },
+            }
+        elif config.ir.ambassador_module.get("error_response_overrides", None):
+            typed_per_filter_config["envoy.filters.http.response_map"] = {
+                "@type": "type.googleapis.com/envoy.extensions.filters.http.response_map.v3.ResponseMapPerRoute",
+                "disabled": True,
+            }
+
+        if typed_per_filter_config:
+            self["typed_per_filter_config"] = typed_per_filter_config
+        else:
+            self.pop("typed_per_filter_config", None)
+
     @staticmethod
     def generate_headers_to_add(header_dict: dict) -> List[dict]:
         headers = []
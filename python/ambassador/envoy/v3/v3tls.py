@@ -13,7 +13,7 @@
 # limitations under the License
 
 import os
-from typing import TYPE_CHECKING, Callable, Dict, List, Optional, Union
+from typing import TYPE_CHECKING, Any, Callable, Dict, List, Optional, Union
 from typing import cast as typecast
 
 from ...ir.irtlscontext import IRTLSContext
@@ -28,7 +28,7 @@ EnvoyCoreSource = Dict[str, str]
 EnvoyTLSCert = Dict[str, EnvoyCoreSource]
 ListOfCerts = List[EnvoyTLSCert]
 
-EnvoyValidationElements = Union[EnvoyCoreSource, bool]
+EnvoyValidationElements = Union[EnvoyCoreSource, bool, List[Dict[str, Any]]]
 EnvoyValidationContext = Dict[str, EnvoyValidationElements]
 
 EnvoyTLSParams = Dict[str, Union[str, List[str]]]
@@ -90,6 +90,33 @@ class V3TLSContext(Dict):
         src: EnvoyCoreSource = {"filename": value}
         certs[0][key] = src
 
+    def update_cert_zero_inline(self, key: str, value: str) -> None:
+        # value is already base64, which is how inline_bytes goes in JSON.
+        certs = self.get_certs()
+
+        if not certs:
+            certs.append({})
+
+        src: EnvoyCoreSource = {"inline_bytes": value}
+        certs[0][key] = src
+
+    def update_private_key_provider(self, value: Dict[str, Any]) -> None:
+        # Envoy won't take both a private_key and a private_key_provider. The provider
+        # wins: the whole point of it is that the key isn't here.
+        certs = self.get_certs()
+
+        if not certs:
+            certs.append({})
+
+        certs[0].pop("private_key", None)
+
+        provider: Dict[str, Any] = {"provider_name": value["provider_name"]}
+
+        if value.get("typed_config", None):
+            provider["typed_config"] = value["typed_config"]
+
+        certs[0]["private_key_provider"] = typecast(EnvoyCoreSource, provider)
+
     def update_alpn(self, key: str, value: str) -> None:
         common = self.get_common()
         common[key] = [value]
@@ -100,6 +127,10 @@ class V3TLSContext(Dict):
         if value in V3TLSContext.TLSVersionMap:
             params[key] = V3TLSContext.TLSVersionMap[value]
 
+    def update_ocsp_staple_policy(self, key: str, value: str) -> None:
+        # lenient_stapling -> LENIENT_STAPLING, and so on.
+        self[key] = value.upper()
+
     def update_tls_cipher(self, key: str, value: List[str]) -> None:
         params = self.get_params()
 
@@ -119,6 +150,24 @@ class V3TLSContext(Dict):
         src: EnvoyCoreSource = {"filename": value}
         validation[key] = src
 
+    def update_subject_alt_names(self, matchers: List[Dict[str, str]]) -> None:
+        # IRTLSContext.setup has already made sure each matcher has exactly one of exact
+        # and prefix.
+        empty_context: EnvoyValidationContext = {}
+
+        validation = typecast(
+            EnvoyValidationContext,
+            self.get_common().setdefault("validation_context", empty_context),
+        )
+
+        validation["match_typed_subject_alt_names"] = [
+            {
+                "san_type": m["san_type"],
+                "matcher": {"exact": m["exact"]} if "exact" in m else {"prefix": m["prefix"]},
+            }
+            for m in matchers
+        ]
+
     def add_context(self, ctx: IRTLSContext) -> None:
         if TYPE_CHECKING:
             # This is needed because otherwise self.__setitem__ confuses things.
@@ -132,16 +181,24 @@ class V3TLSContext(Dict):
             ("private_key_file", self.update_cert_zero, "private_key"),
             ("cacert_chain_file", self.update_validation, "trusted_ca"),
             ("crl_file", self.update_validation, "crl"),
+            ("ocsp_staple", self.update_cert_zero_inline, "ocsp_staple"),
         ]:
             if secretinfokey in ctx["secret_info"]:
                 handler(hkey, ctx["secret_info"][secretinfokey])
 
+        if ctx.get("private_key_provider", None):
+            self.update_private_key_provider(ctx.private_key_provider)
+
+        if ctx.get("match_subject_alt_names", None):
+            self.update_subject_alt_names(ctx.match_subject_alt_names)
+
         for ctxkey, handler, hkey in [
             ("alpn_protocols", self.update_alpn, "alpn_protocols"),
             ("cert_required", self.__setitem__, "require_client_certificate"),
             ("min_tls_version", self.update_tls_version, "tls_minimum_protocol_version"),
             ("max_tls_version", self.update_tls_version, "tls_maximum_protocol_version"),
             ("sni", self.__setitem__, "sni"),
+            ("ocsp_staple_policy", self.update_ocsp_staple_policy, "ocsp_staple_policy"),
         ]:
             value = ctx.get(ctxkey, None)
 
@@ -161,6 +218,21 @@ class V3TLSContext(Dict):
             if value is not None:
                 list_handler(hkey, value)
 
+    def add_tls_policy(self, settings: Dict[str, Any]) -> None:
+        # settings is the "settings" of effective_tls_policy (see irtlspolicy.py), which
+        # already includes anything that the context itself set, so it's safe to apply
+        # on top of add_context.
+        for key, hkey in [
+            ("min_tls_version", "tls_minimum_protocol_version"),
+            ("max_tls_version", "tls_maximum_protocol_version"),
+        ]:
+            if key in settings:
+                self.update_tls_version(hkey, settings[key])
+
+        for key in ["cipher_suites", "ecdh_curves"]:
+            if key in settings:
+                self.update_tls_cipher(key, settings[key])
+
     def pretty(self) -> str:
         common_ctx = self.get("common_tls_context", {})
         certs = common_ctx.get("tls_certificates", [])
@@ -0,0 +1,146 @@
+"""
+Stable codes for the configuration errors we report to users.
+
+The text of an error message changes all the time, as we add detail or fix
+typos, so nothing outside of Ambassador should ever key off of it. Every
+error we post carries one of these codes instead: it's logged along with the
+message, it's in the errors returned by the diagnostics API, and it's in the
+status of a Mapping that's inactive because of the error. Runbooks and docs
+can refer to the code, and it won't change out from under them.
+
+Once a code has shipped, its meaning must never change, and it must never be
+reused for something else. If an error becomes impossible, leave its code
+registered so that old runbooks still make sense.
+
+Codes are grouped by hundreds:
+
+- AMB10xx: loading and parsing resources, before we know what they are
+- AMB2xxx: validating a particular kind of resource
+- AMB3xxx: lint findings (see lint.py); these are about configuration that's
+  valid, but probably not what was meant
+"""
+
+import dataclasses
+from typing import Dict, Optional
+
+
+@dataclasses.dataclass(frozen=True)
+class ErrorCode:
+    code: str
+    summary: str
+
+    def __str__(self) -> str:
+        return self.code
+
+
+# All the codes we know about, by code.
+ERROR_CODES: Dict[str, ErrorCode] = {}
+
+
+def _register(code: str, summary: str) -> ErrorCode:
+    assert code not in ERROR_CODES, f"error code {code} registered twice"
+
+    ERROR_CODES[code] = ErrorCode(code, summary)
+    return ERROR_CODES[code]
+
+
+UNKNOWN = _register("AMB1000", "Configuration error with no more specific code")
+UNREADABLE = _register("AMB1001", "A configuration file or manifest could not be read")
+UNPARSEABLE = _register("AMB1002", "A configuration file or snapshot is not valid YAML or JSON")
+MALFORMED_RESOURCE = _register(
+    "AMB1003", "A resource is empty, or is missing its apiVersion, kind, or name"
+)
+UNSUPPORTED_API_VERSION = _register("AMB1004", "A resource has an unsupported apiVersion")
+SCHEMA_VIOLATION = _register("AMB1005", "A resource does not match its CRD schema")
+DUPLICATE_RESOURCE = _register("AMB1006", "The same resource is defined more than once")
+MISSING_CRDS = _register("AMB1007", "CRD definitions are not installed in the cluster")
+PERMISSION_DENIED = _register("AMB1008", "Ambassador is not permitted to read a resource type")
+MISSING_POD_LABELS = _register("AMB1009", "Pod labels are not mounted in the Ambassador container")
+UNRESOLVED_VARIABLE = _register(
+    "AMB1010", "A resource uses a ${VARIABLE} that is not allowed, or is not set"
+)
+
+INVALID_MAPPING = _register("AMB2000", "Invalid Mapping or TCPMapping")
+UNKNOWN_RESOLVER = _register("AMB2001", "A Mapping refers to a resolver that does not exist")
+MAPPING_ROUTE_OVERLAP = _register("AMB2002", "A Mapping routes the same requests as an HTTPRoute")
+MAPPING_OVER_LIMITS = _register(
+    "AMB2003", "A Mapping was left out of Envoy's configuration to keep it within the config limits"
+)
+INVALID_HOST = _register("AMB2100", "Invalid Host")
+MISSING_TLS_CONTEXT = _register("AMB2101", "A Host refers to a TLSContext that does not exist")
+INVALID_TLS_CONTEXT = _register("AMB2200", "Invalid TLSContext")
+INVALID_MODULE = _register("AMB2300", "Invalid Module")
+INVALID_MODULE_CONFIG = _register(
+    "AMB2301", "A Module's config has an unknown field, or a field of the wrong type"
+)
+INVALID_AUTH_SERVICE = _register("AMB2400", "Invalid AuthService")
+INVALID_RATE_LIMIT_SERVICE = _register("AMB2500", "Invalid RateLimitService")
+INVALID_TRACING_SERVICE = _register("AMB2600", "Invalid TracingService")
+INVALID_LOG_SERVICE = _register("AMB2700", "Invalid LogService")
+INVALID_LISTENER = _register("AMB2800", "Invalid Listener")
+INVALID_RESOLVER = _register("AMB2900", "Invalid resolver")
+INVALID_EGRESS_POLICY = _register("AMB2950", "Invalid EgressPolicy")
+INVALID_GRPC_DESCRIPTORS = _register("AMB2960", "Invalid gRPC descriptors in a ConfigMap")
+INVALID_REDIRECT = _register("AMB2970", "Invalid Redirect")
+REDIRECT_CONFLICT = _register(
+    "AMB2971", "A Redirect matches the same requests as another Redirect with the same precedence"
+)
+
+LINT_UNANCHORED_REGEX = _register("AMB3001", "A Mapping's prefix regex has no anchors")
+LINT_EXTERNAL_UPSTREAM_NO_TIMEOUT = _register(
+    "AMB3002", "A Mapping to a service outside the cluster has no timeout_ms"
+)
+LINT_WILDCARD_HOST_ACME = _register("AMB3003", "A wildcard Host has ACME enabled")
+LINT_DUPLICATE_REWRITE = _register("AMB3004", "A Mapping has both rewrite and regex_rewrite")
+
+# The code to use for an error about a resource of a given kind (in lower
+# case), when the error isn't posted with a more specific code. Both the kinds
+# of the input resources and the kinds of the IR resources built from them
+# are here, since errors get posted against either.
+_CODES_BY_KIND: Dict[str, ErrorCode] = {
+    "mapping": INVALID_MAPPING,
+    "tcpmapping": INVALID_MAPPING,
+    "irhttpmapping": INVALID_MAPPING,
+    "irhttpmappinggroup": INVALID_MAPPING,
+    "irtcpmapping": INVALID_MAPPING,
+    "irtcpmappinggroup": INVALID_MAPPING,
+    "irbasemappinggroup": INVALID_MAPPING,
+    "host": INVALID_HOST,
+    "irhost": INVALID_HOST,
+    "tlscontext": INVALID_TLS_CONTEXT,
+    "irtlscontext": INVALID_TLS_CONTEXT,
+    "module": INVALID_MODULE,
+    "irambassador": INVALID_MODULE,
+    "irtlsmodule": INVALID_MODULE,
+    "authservice": INVALID_AUTH_SERVICE,
+    "irauth": INVALID_AUTH_SERVICE,
+    "ratelimitservice": INVALID_RATE_LIMIT_SERVICE,
+    "irratelimit": INVALID_RATE_LIMIT_SERVICE,
+    "tracingservice": INVALID_TRACING_SERVICE,
+    "ir.tracing": INVALID_TRACING_SERVICE,
+    "logservice": INVALID_LOG_SERVICE,
+    "ir.logservice": INVALID_LOG_SERVICE,
+    "listener": INVALID_LISTENER,
+    "irlistener": INVALID_LISTENER,
+    "consulresolver": INVALID_RESOLVER,
+    "kubernetesendpointresolver": INVALID_RESOLVER,
+    "kubernetesserviceresolver": INVALID_RESOLVER,
+    "pluginresolver": INVALID_RESOLVER,
+    "irserviceresolver": INVALID_RESOLVER,
+    "egresspolicy": INVALID_EGRESS_POLICY,
+    "iregresspolicy": INVALID_EGRESS_POLICY,
+    "grpcdescriptorset": INVALID_GRPC_DESCRIPTORS,
+    "redirect": INVALID_REDIRECT,
+    "irredirect": INVALID_REDIRECT,
+}
+
+
+def code_for_kind(kind: Optional[str]) -> ErrorCode:
+    """
+    Returns the code for an error about a resource of the given kind, when
+    there's no more specific code for it.
+    """
+    if not kind:
+        return UNKNOWN
+
+    return _CODES_BY_KIND.get(kind.lower(), UNKNOWN)
@@ -0,0 +1,139 @@
+import dataclasses
+from typing import Any, ClassVar, Dict, Iterable, List, Optional, Tuple
+
+from .k8sobject import KubernetesObject
+
+
+@dataclasses.dataclass(frozen=True)
+class ExternalAddress:
+    """
+    A single place from which traffic can reach Ambassador from outside the
+    cluster, as discovered from one of Ambassador's own Services.
+    """
+
+    LOAD_BALANCER: ClassVar[str] = "LoadBalancer"
+    EXTERNAL_IP: ClassVar[str] = "ExternalIP"
+    NODE_PORT: ClassVar[str] = "NodePort"
+
+    # The Service this address came from, as "name.namespace".
+    service: str
+
+    # One of LOAD_BALANCER, EXTERNAL_IP, or NODE_PORT.
+    type: str
+
+    # At most one of these is set. Neither is set for NODE_PORT addresses,
+    # since those are reachable on every node's address, and we don't watch
+    # Nodes.
+    ip: Optional[str] = None
+    hostname: Optional[str] = None
+
+    # The ports reachable at this address: the Service ports, or for NODE_PORT
+    # addresses, the node ports.
+    ports: Tuple[int, ...] = ()
+
+    def ingress_point(self) -> Optional[Dict[str, str]]:
+        """
+        Returns this address in the form of a Kubernetes LoadBalancerIngress,
+        or None if it doesn't have an address of its own.
+        """
+        if self.ip:
+            return {"ip": self.ip}
+
+        if self.hostname:
+            return {"hostname": self.hostname}
+
+        return None
+
+    def as_dict(self) -> Dict[str, Any]:
+        od: Dict[str, Any] = {"service": self.service, "type": self.type}
+
+        if self.ip:
+            od["ip"] = self.ip
+
+        if self.hostname:
+            od["hostname"] = self.hostname
+
+        if self.ports:
+            od["ports"] = list(self.ports)
+
+        return od
+
+
+def _service_rank(svc: KubernetesObject) -> Tuple[int, str, str]:
+    # When several Services front the same Ambassador, prefer the ones that
+    # are actually reachable from outside: a LoadBalancer that has been given
+    # an address, then any LoadBalancer, then NodePort, then everything else.
+    # Break ties by name so that the answer doesn't depend on snapshot order.
+    svc_type = svc.spec.get("type", "ClusterIP")
+    has_ingress = bool(svc.status.get("loadBalancer", {}).get("ingress"))
+
+    if svc_type == "LoadBalancer" and has_ingress:
+        rank = 0
+    elif svc_type == "LoadBalancer":
+        rank = 1
+    elif svc_type == "NodePort":
+        rank = 2
+    else:
+        rank = 3
+
+    return (rank, svc.namespace, svc.name)
+
+
+def sort_ambassador_services(services: Iterable[KubernetesObject]) -> List[KubernetesObject]:
+    """
+    Sorts Ambassador's Services from most to least useful for reaching
+    Ambassador from outside the cluster.
+    """
+    return sorted(services, key=_service_rank)
+
+
+def discover_external_addresses(services: Iterable[KubernetesObject]) -> List[ExternalAddress]:
+    """
+    Returns every external address of the given Services (which should all
+    be Ambassador's own), most useful first, without duplicates.
+    """
+    addresses: List[ExternalAddress] = []
+    seen = set()
+
+    def add(address: ExternalAddress) -> None:
+        key = (address.type, address.ip, address.hostname, address.ports)
+
+        if key not in seen:
+            seen.add(key)
+            addresses.append(address)
+
+    for svc in sort_ambassador_services(services):
+        source = f"{svc.name}.{svc.namespace}"
+        svc_type = svc.spec.get("type", "ClusterIP")
+        svc_ports = svc.spec.get("ports", [])
+        ports = tuple(sorted(p["port"] for p in svc_ports if p.get("port")))
+
+        for ingress in svc.status.get("loadBalancer", {}).get("ingress", []):
+            ip = ingress.get("ip") or None
+            hostname = None if ip else ingress.get("hostname") or None
+
+            if ip or hostname:
+                add(
+                    ExternalAddress(
+                        service=source,
+                        type=ExternalAddress.LOAD_BALANCER,
+                        ip=ip,
+                        hostname=hostname,
+                        ports=ports,
+                    )
+                )
+
+        for ip in svc.spec.get("externalIPs", []):
+            add(ExternalAddress(service=source, type=ExternalAddress.EXTERNAL_IP, ip=ip, ports=ports))
+
+        if svc_type == "NodePort":
+            node_ports = tuple(sorted(p["nodePort"] for p in svc_ports if p.get("nodePort")))
+
+            if node_ports:
+                add(
+                    ExternalAddress(
+                        service=source, type=ExternalAddress.NODE_PORT, ports=node_ports
+                    )
+                )
+
+    return addresses
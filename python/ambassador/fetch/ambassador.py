@@ -29,11 +29,19 @@ class AmbassadorProcessor(ManagedKubernetesProcessor):
             "TracingService",
         ]
 
+        # These kinds only exist in v3alpha1.
+        v3alpha1_kinds = [
+            "EgressPolicy",
+            "PluginResolver",
+            "Redirect",
+        ]
+
         return frozenset(
             [
                 KubernetesGVK.for_ambassador(kind, version=version)
                 for (kind, version) in itertools.product(kinds, ["v1", "v2", "v3alpha1"])
             ]
+            + [KubernetesGVK.for_ambassador(kind, version="v3alpha1") for kind in v3alpha1_kinds]
         )
 
     def _process(self, obj: KubernetesObject) -> None:
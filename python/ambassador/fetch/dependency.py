@@ -5,6 +5,7 @@ from typing import (
     Collection,
     Dict,
     Iterator,
+    List,
     Mapping,
     MutableSet,
     Optional,
@@ -14,6 +15,7 @@ from typing import (
     TypeVar,
 )
 
+from .addresses import ExternalAddress, discover_external_addresses, sort_ambassador_services
 from .k8sobject import KubernetesObject, KubernetesObjectKey
 
 
@@ -33,15 +35,29 @@ class ServiceDependency(Dependency):
     """
     A dependency that exposes information about the Kubernetes service for
     Ambassador itself.
+
+    There may be more than one Service fronting Ambassador (say, an internal
+    and an external load balancer). All of them are in ambassador_services;
+    ambassador_service is the one most useful for reaching Ambassador from
+    outside the cluster.
     """
 
     ambassador_service: Optional[KubernetesObject]
+    ambassador_services: List[KubernetesObject]
     discovered_services: Dict[KubernetesObjectKey, KubernetesObject]
 
     def __init__(self) -> None:
         self.ambassador_service = None
+        self.ambassador_services = []
         self.discovered_services = {}
 
+    def add_ambassador_service(self, svc: KubernetesObject) -> None:
+        self.ambassador_services.append(svc)
+        self.ambassador_service = sort_ambassador_services(self.ambassador_services)[0]
+
+    def external_addresses(self) -> List[ExternalAddress]:
+        return discover_external_addresses(self.ambassador_services)
+
     def watt_key(self) -> str:
         return "service"
 
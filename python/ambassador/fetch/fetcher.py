@@ -6,6 +6,7 @@ from typing import Any, Dict, List, Optional, Tuple, Union
 
 import yaml
 
+from .. import errorcodes
 from ..config import ACResource, Config
 from ..utils import parse_bool, parse_json, parse_yaml
 from .ambassador import AmbassadorProcessor
@@ -15,6 +16,8 @@ from .dependency import (
     SecretDependency,
     ServiceDependency,
 )
+from .grpcdescriptors import GRPCDescriptorProcessor
+from .hoststatus import HostStatusProcessor
 from .ingress import IngressClassProcessor, IngressProcessor
 from .k8sobject import KubernetesGVK, KubernetesObject
 from .k8sprocessor import (
@@ -24,6 +27,7 @@ from .k8sprocessor import (
     KubernetesProcessor,
 )
 from .knative import KnativeIngressProcessor
+from .maintenancepages import MaintenancePageProcessor
 from .resource import NormalizedResource, ResourceManager
 from .secret import SecretProcessor
 from .service import ServiceProcessor
@@ -90,10 +94,13 @@ class ResourceFetcher:
                     ),
                     AmbassadorProcessor(self.manager),
                     SecretProcessor(self.manager),
+                    GRPCDescriptorProcessor(self.manager),
+                    MaintenancePageProcessor(self.manager),
                     IngressClassProcessor(self.manager),
                     IngressProcessor(self.manager),
                     ServiceProcessor(self.manager, watch_only=watch_only),
                     KnativeIngressProcessor(self.manager),
+                    HostStatusProcessor(self.manager),
                 ]
             )
         )
@@ -103,6 +110,9 @@ class ResourceFetcher:
         # Deltas, for managing the cache.
         self.deltas: List[Dict[str, Union[str, Dict[str, str]]]] = []
 
+        # Nonzero if this snapshot finishes a resync, which wants nothing from the cache.
+        self.resync = 0
+
         # Paranoia: make sure self.invalid is empty.
         #
         # TODO(Flynn): The only reason this is here is because filesystem configuration
@@ -228,14 +238,19 @@ spec:
                 serialization = open(filepath, "r").read()
                 self.parse_yaml(serialization, k8s=k8s, filename=filename, finalize=False)
             except IOError as e:
-                self.aconf.post_error("could not read YAML from %s: %s" % (filepath, e))
+                self.aconf.post_error(
+                    "could not read YAML from %s: %s" % (filepath, e), code=errorcodes.UNREADABLE
+                )
 
         for manifest in automatic_manifests:
             self.logger.debug("reading automatic manifest: %s" % manifest)
             try:
                 self.parse_yaml(manifest, k8s=k8s, filename="_automatic_", finalize=False)
             except IOError as e:
-                self.aconf.post_error("could not read automatic manifest: %s\n%s" % (manifest, e))
+                self.aconf.post_error(
+                    "could not read automatic manifest: %s\n%s" % (manifest, e),
+                    code=errorcodes.UNREADABLE,
+                )
 
         if finalize:
             self.finalize()
@@ -266,7 +281,10 @@ spec:
                     else:
                         self.manager.emit(NormalizedResource(obj, rkey=rkey))
             except yaml.error.YAMLError as e:
-                self.aconf.post_error("%s: could not parse YAML: %s" % (self.location, e))
+                self.aconf.post_error(
+                    "%s: could not parse YAML: %s" % (self.location, e),
+                    code=errorcodes.UNPARSEABLE,
+                )
 
         if finalize:
             self.finalize()
@@ -276,27 +294,32 @@ spec:
 
         if os.path.isfile(os.path.join(basedir, ".ambassador_ignore_crds")):
             self.aconf.post_error(
-                "Ambassador could not find core CRD definitions. Please visit https://www.getambassador.io/docs/edge-stack/latest/topics/install/upgrade-to-edge-stack/#5-update-and-restart for more information. You can continue using Ambassador via Kubernetes annotations, any configuration via CRDs will be ignored..."
+                "Ambassador could not find core CRD definitions. Please visit https://www.getambassador.io/docs/edge-stack/latest/topics/install/upgrade-to-edge-stack/#5-update-and-restart for more information. You can continue using Ambassador via Kubernetes annotations, any configuration via CRDs will be ignored...",
+                code=errorcodes.MISSING_CRDS,
             )
 
         if os.path.isfile(os.path.join(basedir, ".ambassador_ignore_crds_2")):
             self.aconf.post_error(
-                "Ambassador could not find Resolver type CRD definitions. Please visit https://www.getambassador.io/docs/edge-stack/latest/topics/install/upgrade-to-edge-stack/#5-update-and-restart for more information. You can continue using Ambassador, but ConsulResolver, KubernetesEndpointResolver, and KubernetesServiceResolver resources will be ignored..."
+                "Ambassador could not find Resolver type CRD definitions. Please visit https://www.getambassador.io/docs/edge-stack/latest/topics/install/upgrade-to-edge-stack/#5-update-and-restart for more information. You can continue using Ambassador, but ConsulResolver, KubernetesEndpointResolver, and KubernetesServiceResolver resources will be ignored...",
+                code=errorcodes.MISSING_CRDS,
             )
 
         if os.path.isfile(os.path.join(basedir, ".ambassador_ignore_crds_3")):
             self.aconf.post_error(
-                "Ambassador could not find the Host CRD definition. Please visit https://www.getambassador.io/docs/edge-stack/latest/topics/install/upgrade-to-edge-stack/#5-update-and-restart for more information. You can continue using Ambassador, but Host resources will be ignored..."
+                "Ambassador could not find the Host CRD definition. Please visit https://www.getambassador.io/docs/edge-stack/latest/topics/install/upgrade-to-edge-stack/#5-update-and-restart for more information. You can continue using Ambassador, but Host resources will be ignored...",
+                code=errorcodes.MISSING_CRDS,
             )
 
         if os.path.isfile(os.path.join(basedir, ".ambassador_ignore_crds_4")):
             self.aconf.post_error(
-                "Ambassador could not find the LogService CRD definition. Please visit https://www.getambassador.io/docs/edge-stack/latest/topics/install/upgrade-to-edge-stack/#5-update-and-restart for more information. You can continue using Ambassador, but LogService resources will be ignored..."
+                "Ambassador could not find the LogService CRD definition. Please visit https://www.getambassador.io/docs/edge-stack/latest/topics/install/upgrade-to-edge-stack/#5-update-and-restart for more information. You can continue using Ambassador, but LogService resources will be ignored...",
+                code=errorcodes.MISSING_CRDS,
             )
 
         if os.path.isfile(os.path.join(basedir, ".ambassador_ignore_crds_5")):
             self.aconf.post_error(
-                "Ambassador could not find the DevPortal CRD definition. Please visit https://www.getambassador.io/docs/edge-stack/latest/topics/install/upgrade-to-edge-stack/#5-update-and-restart for more information. You can continue using Ambassador, but DevPortal resources will be ignored..."
+                "Ambassador could not find the DevPortal CRD definition. Please visit https://www.getambassador.io/docs/edge-stack/latest/topics/install/upgrade-to-edge-stack/#5-update-and-restart for more information. You can continue using Ambassador, but DevPortal resources will be ignored...",
+                code=errorcodes.MISSING_CRDS,
             )
 
         # We could be posting errors about the missing IngressClass resource, but given it's new in K8s 1.18
@@ -307,7 +330,8 @@ spec:
 
         if os.path.isfile(os.path.join(basedir, ".ambassador_ignore_ingress")):
             self.aconf.post_error(
-                "Ambassador is not permitted to read Ingress resources. Please visit https://www.getambassador.io/docs/edge-stack/latest/topics/running/ingress-controller/#ambassador-as-an-ingress-controller for more information. You can continue using Ambassador, but Ingress resources will be ignored..."
+                "Ambassador is not permitted to read Ingress resources. Please visit https://www.getambassador.io/docs/edge-stack/latest/topics/running/ingress-controller/#ambassador-as-an-ingress-controller for more information. You can continue using Ambassador, but Ingress resources will be ignored...",
+                code=errorcodes.PERMISSION_DENIED,
             )
 
         # Expand environment variables allowing interpolation in manifests.
@@ -320,6 +344,7 @@ spec:
 
             # Grab deltas if they're present...
             self.deltas = watt_dict.get("Deltas", [])
+            self.resync = watt_dict.get("Resync", 0)
 
             # ...then it's off to deal with Kubernetes.
             watt_k8s = watt_dict.get("Kubernetes", {})
@@ -377,13 +402,44 @@ spec:
                             for ann_obj in annotations.get(ann_parent_key) or []:
                                 self.handle_annotation(ann_parent_key, ann_obj)
 
+            # Modules with unknown or mistyped fields in their config are still used (see
+            # moduleconfig.go), so the problems with them come separately.
+            module_errors = watt_dict.get("ModuleErrors") or {}
+
+            for module_rkey, errors in sorted(module_errors.items()):
+                for error in errors:
+                    self.aconf.post_error(
+                        f"Module {module_rkey}: {error}",
+                        rkey=module_rkey,
+                        code=errorcodes.INVALID_MODULE_CONFIG,
+                    )
+
+            # HTTPRoutes that duplicate a Mapping's route lose to the Mapping (see
+            # routeoverlap.go); the Mapping is where someone will look for why.
+            for overlap in watt_dict.get("RouteOverlaps") or []:
+                outcome = (
+                    "the HTTPRoute is rejected"
+                    if overlap.get("rejected")
+                    else "the Mapping takes precedence"
+                )
+
+                self.aconf.post_error(
+                    f"Mapping {overlap['mapping']}: HTTPRoute {overlap['httpRoute']} (rule "
+                    f"{overlap['rule']}, match {overlap['match']}) also routes "
+                    f"{overlap['route']}; {outcome}",
+                    rkey=overlap["mapping"],
+                    code=errorcodes.MAPPING_ROUTE_OVERLAP,
+                )
+
             watt_consul = watt_dict.get("Consul", {})
             consul_endpoints = watt_consul.get("Endpoints", {})
 
             for consul_rkey, consul_object in consul_endpoints.items():
                 self.handle_consul_service(consul_rkey, consul_object)
         except json.decoder.JSONDecodeError as e:
-            self.aconf.post_error("%s: could not parse WATT: %s" % (self.location, e))
+            self.aconf.post_error(
+                "%s: could not parse WATT: %s" % (self.location, e), code=errorcodes.UNPARSEABLE
+            )
 
         if finalize:
             self.finalize()
@@ -393,7 +449,8 @@ spec:
         if not os.path.isfile(pod_labels_path):
             if not self.alerted_about_labels:
                 self.aconf.post_error(
-                    f"Pod labels are not mounted in the Ambassador container; Kubernetes Ingress support is likely to be limited"
+                    f"Pod labels are not mounted in the Ambassador container; Kubernetes Ingress support is likely to be limited",
+                    code=errorcodes.MISSING_POD_LABELS,
                 )
                 self.alerted_about_labels = True
 
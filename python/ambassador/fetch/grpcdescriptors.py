@@ -0,0 +1,55 @@
+from typing import FrozenSet
+
+from ..config import Config
+from .k8sobject import KubernetesGVK, KubernetesObject
+from .k8sprocessor import ManagedKubernetesProcessor
+from .resource import NormalizedResource
+
+
+class GRPCDescriptorProcessor(ManagedKubernetesProcessor):
+    """
+    A Kubernetes object processor that emits gRPC descriptor sets from ConfigMaps
+    labeled getambassador.io/grpc-descriptors. Each key in the ConfigMap's binaryData
+    is one version of the descriptors; see ir/irgrpctranscoder.py.
+    """
+
+    LABEL = "getambassador.io/grpc-descriptors"
+    DEFAULT_VERSION_ANNOTATION = "getambassador.io/grpc-descriptors-default"
+
+    def kinds(self) -> FrozenSet[KubernetesGVK]:
+        return frozenset([KubernetesGVK("v1", "ConfigMap")])
+
+    def _process(self, obj: KubernetesObject) -> None:
+        # We see other ConfigMaps too (the Cloud Connect token, for one), so skip
+        # anything that isn't labeled as descriptors.
+        if self.LABEL not in obj.labels:
+            return
+
+        versions = obj.get("binaryData") or {}
+
+        if not versions:
+            self.logger.debug(
+                f"ignoring gRPC descriptor ConfigMap {obj.name}.{obj.namespace} with no binaryData"
+            )
+            return
+
+        spec = {
+            "ambassador_id": Config.ambassador_id,
+            "versions": dict(versions),
+        }
+
+        default_version = obj.annotations.get(self.DEFAULT_VERSION_ANNOTATION, None)
+
+        if default_version:
+            spec["default_version"] = default_version
+
+        self.manager.emit(
+            NormalizedResource.from_data(
+                "GRPCDescriptorSet",
+                obj.name,
+                namespace=obj.namespace,
+                labels=obj.labels,
+                spec=spec,
+                errors=obj.get("errors"),
+            )
+        )
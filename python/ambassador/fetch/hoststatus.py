@@ -0,0 +1,111 @@
+import os
+from typing import Any, ClassVar, Dict, FrozenSet, List, Optional
+
+from ..config import Config
+from .dependency import ServiceDependency
+from .k8sobject import KubernetesGVK, KubernetesObject
+from .k8sprocessor import ManagedKubernetesProcessor
+from .resource import ResourceManager
+
+
+class HostStatusProcessor(ManagedKubernetesProcessor):
+    """
+    A Kubernetes object processor that publishes where traffic for each Host
+    should be sent into the Host's status, as a standard LoadBalancerStatus.
+    That's the same shape external-dns reads from Services and Ingresses, so
+    DNS records for a Host's hostname can be created from it automatically.
+
+    The address comes from, in order of preference:
+
+    1. the Host's own external-dns.alpha.kubernetes.io/target annotation;
+    2. the AMBASSADOR_EXTERNAL_ADDRESS environment variable (a comma-separated
+       list of hostnames and/or IPs, for when Ambassador sits behind something
+       that its own Service doesn't know about); or
+    3. the external addresses of Ambassador's own Services (see addresses.py).
+    """
+
+    TARGET_ANNOTATION: ClassVar[str] = "external-dns.alpha.kubernetes.io/target"
+
+    service_dep: ServiceDependency
+    external_address: Optional[str]
+
+    def __init__(self, manager: ResourceManager) -> None:
+        super().__init__(manager)
+
+        self.service_dep = self.deps.want(ServiceDependency)
+        self.external_address = os.environ.get("AMBASSADOR_EXTERNAL_ADDRESS") or None
+
+    def kinds(self) -> FrozenSet[KubernetesGVK]:
+        return frozenset(
+            [
+                KubernetesGVK.for_ambassador("Host", version="v2"),
+                KubernetesGVK.for_ambassador("Host", version="v3alpha1"),
+            ]
+        )
+
+    @staticmethod
+    def _ingress_points(addresses: str) -> List[Dict[str, str]]:
+        points: List[Dict[str, str]] = []
+
+        for address in addresses.split(","):
+            address = address.strip()
+
+            if not address:
+                continue
+
+            # Anything that parses as an IP is an IP; everything else is a
+            # hostname.
+            if address.replace(".", "").isdigit() or ":" in address:
+                points.append({"ip": address})
+            else:
+                points.append({"hostname": address})
+
+        return points
+
+    def _load_balancer(self, obj: KubernetesObject) -> Optional[Dict[str, Any]]:
+        target = obj.annotations.get(self.TARGET_ANNOTATION) or self.external_address
+
+        if target:
+            ingress = self._ingress_points(target)
+        else:
+            ingress = []
+
+            for address in self.service_dep.external_addresses():
+                point = address.ingress_point()
+
+                if point and point not in ingress:
+                    ingress.append(point)
+
+        if not ingress:
+            return None
+
+        return {"ingress": ingress}
+
+    def _process(self, obj: KubernetesObject) -> None:
+        ambassador_id = obj.spec.get("ambassador_id", ["default"])
+        if isinstance(ambassador_id, str):
+            ambassador_id = [ambassador_id]
+
+        if Config.ambassador_id not in ambassador_id:
+            return
+
+        load_balancer = self._load_balancer(obj)
+
+        if not load_balancer:
+            self.logger.debug(
+                f"Not publishing an address for Host {obj.name}: no target annotation, no "
+                f"AMBASSADOR_EXTERNAL_ADDRESS, and no external address on the Ambassador services"
+            )
+            return
+
+        if obj.status.get("loadBalancer") == load_balancer:
+            self.logger.debug(f"Not reconciling Host {obj.name}: address is already published")
+            return
+
+        # Status updates replace the whole status, and other controllers (like
+        # the ACME client) also write to Host status, so keep what's there.
+        status = {**obj.status, "loadBalancer": load_balancer}
+        status_update = (obj.gvk.domain, obj.namespace, status)
+
+        self.logger.info(f"Updating Host {obj.name} status to {status_update}")
+        self.aconf.k8s_status_updates[f"{obj.name}.{obj.namespace}"] = status_update
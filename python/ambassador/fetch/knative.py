@@ -20,6 +20,12 @@ class KnativeIngressProcessor(ManagedKubernetesProcessor):
 
     INGRESS_CLASS: ClassVar[str] = "ambassador.ingress.networking.knative.dev"
 
+    # Current Knative uses "ingress-class"; releases before 1.0 only used "ingress.class".
+    INGRESS_CLASS_ANNOTATIONS: ClassVar[List[str]] = [
+        "networking.knative.dev/ingress-class",
+        "networking.knative.dev/ingress.class",
+    ]
+
     service_dep: ServiceDependency
 
     def __init__(self, manager: ResourceManager):
@@ -34,13 +40,17 @@ class KnativeIngressProcessor(ManagedKubernetesProcessor):
         annotations = obj.annotations
 
         # Let's not parse KnativeIngress if it's not meant for us. We only need
-        # to ignore KnativeIngress iff networking.knative.dev/ingress.class is
-        # present in annotation. If it's not there, then we accept all ingress
-        # classes.
-        ingress_class = annotations.get("networking.knative.dev/ingress.class", self.INGRESS_CLASS)
+        # to ignore KnativeIngress iff an ingress class annotation is present.
+        # If it's not there, then we accept all ingress classes.
+        ingress_class = self.INGRESS_CLASS
+        for key in self.INGRESS_CLASS_ANNOTATIONS:
+            if key in annotations:
+                ingress_class = annotations[key]
+                break
+
         if ingress_class.lower() != self.INGRESS_CLASS:
             self.logger.debug(
-                f"Ignoring Knative {obj.kind} {obj.name}; set networking.knative.dev/ingress.class "
+                f"Ignoring Knative {obj.kind} {obj.name}; set the {self.INGRESS_CLASS_ANNOTATIONS[0]} "
                 f"annotation to {self.INGRESS_CLASS} for ambassador to parse it."
             )
             return False
@@ -63,29 +73,60 @@ class KnativeIngressProcessor(ManagedKubernetesProcessor):
         for path in paths:
             global_headers = path.get("appendHeaders", {})
 
+            # Header matches are how Knative does tag-based routing (the
+            # Knative-Serving-Tag header). Knative only supports exact matches.
+            match_headers: Dict[str, str] = {}
+            for header_name, header_match in (path.get("headers") or {}).items():
+                if "exact" not in header_match:
+                    self.logger.warning(
+                        f"Knative {obj.kind} {obj.name}: ignoring unsupported match "
+                        f"for header {header_name}: {header_match}"
+                    )
+                    continue
+                match_headers[header_name] = header_match["exact"]
+
+            # Knative stopped sending per-path timeouts: the activator and
+            # queue-proxy enforce the Revision's timeout. Older Knatives still
+            # send one, so honor it if it's there, but otherwise don't cut off
+            # requests that Knative is happy to let run.
+            timeout_ms = 0
+            if "timeout" in path:
+                timeout_ms = int(durationpy.from_str(path["timeout"]).total_seconds() * 1000)
+
             splits = path.get("splits", [])
             for split in splits:
                 service_name = split.get("serviceName")
                 if not service_name:
                     continue
 
+                # A split with no traffic is just a placeholder (Knative can
+                # leave these around for tags), and a Mapping with weight 0 is
+                # not what we want.
+                percent = split.get("percent", 100)
+                if percent <= 0 and len(splits) > 1:
+                    continue
+
                 service_namespace = split.get("serviceNamespace", obj.namespace)
                 service_port = split.get("servicePort", 80)
 
                 headers = split.get("appendHeaders", {})
                 headers = {**global_headers, **headers}
 
-                split_mapping_specs.append(
-                    {
-                        "service": f"{service_name}.{service_namespace}:{service_port}",
-                        "add_request_headers": headers,
-                        "weight": split.get("percent", 100),
-                        "prefix": path.get("path", "/"),
-                        "timeout_ms": int(
-                            durationpy.from_str(path.get("timeout", "15s")).total_seconds() * 1000
-                        ),
-                    }
-                )
+                split_mapping_spec: Dict[str, Any] = {
+                    "service": f"{service_name}.{service_namespace}:{service_port}",
+                    "add_request_headers": headers,
+                    "weight": percent,
+                    "prefix": path.get("path", "/"),
+                    "timeout_ms": timeout_ms,
+                }
+
+                if match_headers:
+                    split_mapping_spec["headers"] = match_headers
+
+                if path.get("rewriteHost"):
+                    split_mapping_spec["host_rewrite"] = path["rewriteHost"]
+
+                split_mapping_specs.append(split_mapping_spec)
 
         for split_count, (host, split_mapping_spec) in enumerate(
             itertools.product(hosts, split_mapping_specs)
@@ -130,7 +171,10 @@ class KnativeIngressProcessor(ManagedKubernetesProcessor):
                 ]
             }
 
-            status["loadBalancer"] = load_balancer
+            # Current Knative only knows about the public and private load
+            # balancers; the old catch-all "loadBalancer" is gone, and a status
+            # that includes it gets rejected.
+            status["publicLoadBalancer"] = load_balancer
             status["privateLoadBalancer"] = load_balancer
 
         return status
@@ -157,12 +201,13 @@ class KnativeIngressProcessor(ManagedKubernetesProcessor):
             # code as well and probably should just be fixed all at once.
             current_lb_domain = f"{self.service_dep.ambassador_service.name}.{self.service_dep.ambassador_service.namespace}.svc.cluster.local"
 
-        observed_ingress: Dict[str, Any] = next(
-            iter(obj.status.get("privateLoadBalancer", {}).get("ingress", [])), {}
-        )
-        observed_lb_domain = observed_ingress.get("domainInternal")
-
-        has_new_lb_domain = current_lb_domain != observed_lb_domain
+        has_new_lb_domain = False
+        for lb_key in ["publicLoadBalancer", "privateLoadBalancer"]:
+            observed_ingress: Dict[str, Any] = next(
+                iter(obj.status.get(lb_key, {}).get("ingress", [])), {}
+            )
+            if current_lb_domain != observed_ingress.get("domainInternal"):
+                has_new_lb_domain = True
 
         if has_new_generation or has_new_lb_domain:
             status = self._make_status(generation=obj.generation, lb_domain=current_lb_domain)
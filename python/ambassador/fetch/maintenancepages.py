@@ -0,0 +1,38 @@
+from typing import FrozenSet
+
+from ..config import Config
+from .k8sobject import KubernetesGVK, KubernetesObject
+from .k8sprocessor import ManagedKubernetesProcessor
+from .resource import NormalizedResource
+
+
+class MaintenancePageProcessor(ManagedKubernetesProcessor):
+    """
+    A Kubernetes object processor that emits maintenance pages from ConfigMaps
+    labeled getambassador.io/maintenance-page. Each key in the ConfigMap's data
+    is one page; see ir/irmaintenance.py.
+    """
+
+    LABEL = "getambassador.io/maintenance-page"
+
+    def kinds(self) -> FrozenSet[KubernetesGVK]:
+        return frozenset([KubernetesGVK("v1", "ConfigMap")])
+
+    def _process(self, obj: KubernetesObject) -> None:
+        # As with gRPC descriptors, skip anything that isn't labeled as maintenance pages.
+        if self.LABEL not in obj.labels:
+            return
+
+        self.manager.emit(
+            NormalizedResource.from_data(
+                "MaintenancePage",
+                obj.name,
+                namespace=obj.namespace,
+                labels=obj.labels,
+                spec={
+                    "ambassador_id": Config.ambassador_id,
+                    "pages": dict(obj.get("data") or {}),
+                },
+                errors=obj.get("errors"),
+            )
+        )
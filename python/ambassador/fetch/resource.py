@@ -5,6 +5,7 @@ import logging
 import os
 from typing import Any, ClassVar, Dict, List, Optional
 
+from .. import errorcodes
 from ..config import ACResource, Config
 from ..utils import dump_json, dump_yaml, parse_bool
 from .dependency import DependencyManager
@@ -129,10 +130,11 @@ class ResourceManager:
         if not isinstance(obj, dict):
             # Bug!!
             if not obj:
-                self.aconf.post_error("%s is empty" % self.location)
+                self.aconf.post_error("%s is empty" % self.location, code=errorcodes.MALFORMED_RESOURCE)
             else:
                 self.aconf.post_error(
-                    "%s is not a dictionary? %s" % (self.location, dump_json(obj, pretty=True))
+                    "%s is not a dictionary? %s" % (self.location, dump_json(obj, pretty=True)),
+                    code=errorcodes.MALFORMED_RESOURCE,
                 )
             return True
 
@@ -143,7 +145,8 @@ class ResourceManager:
         if "kind" not in obj:
             # Bug!!
             self.aconf.post_error(
-                "%s is missing 'kind'?? %s" % (self.location, dump_json(obj, pretty=True))
+                "%s is missing 'kind'?? %s" % (self.location, dump_json(obj, pretty=True)),
+                code=errorcodes.MALFORMED_RESOURCE,
             )
             return True
 
@@ -26,6 +26,7 @@ class SecretProcessor(ManagedKubernetesProcessor):
         "key.pem",  # type="istio.io/key-and-cert"
         "root-cert.pem",  # type="istio.io/key-and-cert"
         "crl.pem",  # type="Opaque", used for TLS CRL
+        "ocsp.der",  # type="Opaque", used for OCSP stapling
     ]
 
     def __init__(self, manager: ResourceManager) -> None:
@@ -32,6 +32,22 @@ class Endpoints:
     labels: Dict[str, str]
 
 
+def external_name(obj: KubernetesObject) -> Optional[str]:
+    """
+    Returns the name an ExternalName Service points at, or None if it's some
+    other kind of Service.
+    """
+
+    if obj.spec.get("type") != "ExternalName":
+        return None
+
+    # DNS names don't care about case, and the trailing dot of a fully-qualified
+    # name would only make for a different cluster for the same target.
+    name = str(obj.spec.get("externalName") or "").strip().rstrip(".").lower()
+
+    return name or None
+
+
 class InternalServiceProcessor(ManagedKubernetesProcessor):
     """
     An internal Kubernetes object processor for services. Used by the
@@ -86,7 +102,9 @@ class InternalServiceProcessor(ManagedKubernetesProcessor):
         if chart_version and not self.helm_chart:
             self.helm_chart = chart_version
 
-        if not obj.spec.get("ports"):
+        if not obj.spec.get("ports") and not external_name(obj):
+            # An ExternalName Service needn't list any ports, since all it does is point at
+            # another name: the Mapping says which port to use.
             self.logger.debug(
                 f"not saving Kubernetes Service {obj.name}.{obj.namespace} with no ports"
             )
@@ -95,7 +113,7 @@ class InternalServiceProcessor(ManagedKubernetesProcessor):
 
             if self._is_ambassador_service(obj):
                 self.logger.debug(f"Found Ambassador service: {obj.name}")
-                self.service_dep.ambassador_service = obj
+                self.service_dep.add_ambassador_service(obj)
 
 
 class InternalEndpointsProcessor(ManagedKubernetesProcessor):
@@ -226,6 +244,10 @@ class ServiceProcessor(ManagedKubernetesProcessor):
     def finalize(self) -> None:
         self.delegate.finalize()
 
+        self.aconf.external_addresses = [
+            address.as_dict() for address in self.service_dep.external_addresses()
+        ]
+
         # The point here is to sort out self.service_dep.discovered_services and
         # self.endpoints.discovered_endpoints and turn them into proper
         # Ambassador Service resources. This is a bit annoying, because of the
@@ -262,8 +284,15 @@ class ServiceProcessor(ManagedKubernetesProcessor):
             target_ports = {}
             target_addrs = []
             svc_endpoints = {}
-
-            if not self.watch_only:
+            ext_name = external_name(k8s_svc)
+
+            if ext_name:
+                # An ExternalName Service is a CNAME in the cluster's DNS, with no Endpoints
+                # behind it. The resolvers send traffic to ext_name itself (see
+                # IRServiceResolver.external_name), so that Envoy follows the target when it
+                # changes, and originates TLS with the right SNI.
+                self.logger.debug(f"{key}: ExternalName for {ext_name}")
+            elif not self.watch_only:
                 # If we're not in watch mode, try to find endpoints for this service.
                 k8s_ep_key = KubernetesObjectKey(
                     KubernetesGVK("v1", "Endpoints"), k8s_svc.namespace, k8s_svc.name
@@ -396,7 +425,7 @@ class ServiceProcessor(ManagedKubernetesProcessor):
                         target_addrs.append(addr.ip)
 
             # OK! If we have no target addresses, just use service routing.
-            if not target_addrs:
+            if not target_addrs and not ext_name:
                 if not self.watch_only:
                     self.logger.debug(f"{key} falling back to service routing")
                 target_addrs = [key]
@@ -411,6 +440,19 @@ class ServiceProcessor(ManagedKubernetesProcessor):
                 "endpoints": svc_endpoints,
             }
 
+            if ext_name:
+                spec["external_name"] = ext_name
+
+            # What each port speaks, for the Mappings that don't say (see IRCluster).
+            app_protocols = {
+                str(port["port"]): port["appProtocol"]
+                for port in k8s_svc.spec.get("ports", [])
+                if port.get("port") and port.get("appProtocol")
+            }
+
+            if app_protocols:
+                spec["app_protocols"] = app_protocols
+
             if self.services.helm_chart:
                 spec["helm_chart"] = self.services.helm_chart
 
@@ -21,7 +21,9 @@ from typing import cast as typecast
 from ..cache import Cache, NullCache
 from ..config import Config
 from ..constants import Constants
+from ..errorcodes import ErrorCode
 from ..fetch import ResourceFetcher
+from ..lint import Linter
 from ..utils import RichStatus, SavedSecret, SecretHandler, SecretInfo, dump_json, parse_bool
 from ..VERSION import Commit, Version
 from .irambassador import IRAmbassador
@@ -29,18 +31,23 @@ from .irauth import IRAuth
 from .irbasemapping import IRBaseMapping
 from .irbasemappinggroup import IRBaseMappingGroup
 from .ircluster import IRCluster
+from .ircontractcapture import IRContractCapture
+from .iregress import EgressPolicyFactory
 from .irerrorresponse import IRErrorResponse
 from .irfilter import IRFilter
+from .irgrpctranscoder import GRPCDescriptorFactory
 from .irhost import HostFactory, IRHost
 from .irhttpmapping import IRHTTPMapping
 from .irlistener import IRListener, ListenerFactory
 from .irlogservice import IRLogService, IRLogServiceFactory
 from .irmappingfactory import MappingFactory
 from .irratelimit import IRRateLimit
+from .irredirect import RedirectFactory
 from .irresource import IRResource
 from .irserviceresolver import IRServiceResolver, IRServiceResolverFactory, SvcEndpointSet
 from .irtls import IRAmbassadorTLS, TLSModuleFactory
 from .irtlscontext import IRTLSContext, TLSContextFactory
+from .irtlspolicy import check_tls_policies
 from .irtracing import IRTracing
 
 #############################################################################
@@ -79,10 +86,16 @@ class IR:
     agent_active: bool
     agent_service: Optional[str]
     agent_origination_ctx: Optional[IRTLSContext]
+    contract_capture: Optional[IRContractCapture]
     edge_stack_allowed: bool
+    external_addresses: List[Dict[str, Any]]
     file_checker: IRFileChecker
     filters: List[IRFilter]
     groups: Dict[str, IRBaseMappingGroup]
+    # The key for grpc_descriptor_sets is the rkey of the ConfigMap (see irgrpctranscoder.py).
+    grpc_descriptor_sets: Dict[str, Dict[str, Any]]
+    # The key for egress_ports is the egress port; the destinations are keyed by "{host}:{port}".
+    egress_ports: Dict[int, Dict[str, Dict[str, Any]]]
     grpc_services: Dict[str, IRCluster]
     hosts: Dict[str, IRHost]
     invalid: List[Dict]
@@ -92,6 +105,8 @@ class IR:
     log_services: Dict[str, IRLogService]
     ratelimit: Optional[IRRateLimit]
     redirect_cleartext_from: Optional[int]
+    # The key for redirects is the hostname of a Host (see irredirect.py).
+    redirects: Dict[str, List[Dict[str, Any]]]
     resolvers: Dict[str, IRServiceResolver]
     router_config: Dict[str, Any]
     saved_resources: Dict[str, IRResource]
@@ -140,6 +155,12 @@ class IR:
             # unless there are no deltas at all.
             reset_cache = len(fetcher.deltas) > 0
 
+            # A resync relisted everything, because something might be stale, so it gets
+            # the full treatment no matter what the deltas say.
+            if fetcher.resync:
+                logger.info(f"Resync {fetcher.resync}: resetting the cache")
+                return (config_type, True, invalidate_groups_for)
+
             # Next up: are there any deltas?
             if fetcher.deltas:
                 # Yes. We're going to walk over them all and assemble a list
@@ -218,6 +239,10 @@ class IR:
         # ...then make sure we have a logger...
         self.logger = logger or logging.getLogger("ambassador.ir")
 
+        # ...and somewhere to note how long each resource takes to set up (see
+        # record_resource_timing)...
+        self.resource_timings: Dict[Tuple[str, str, str], float] = {}
+
         # ...then make sure we have a cache (which might be a NullCache)...
         self.cache = cache or NullCache(self.logger)
         self.invalidate_groups_for = invalidate_groups_for or []
@@ -261,6 +286,7 @@ class IR:
         # Also, we have no saved secret stuff yet...
         self.saved_secrets = {}
         self.secret_info: Dict[str, SecretInfo] = {}
+        self.ocsp_staples: Dict[str, str] = {}
 
         # ...and the initial IR state is empty _except for k8s_status_updates_.
         #
@@ -270,8 +296,11 @@ class IR:
 
         self.breakers = {}
         self.clusters = {}
+        self.contract_capture = None
+        self.egress_ports = {}
         self.filters = []
         self.groups = {}
+        self.grpc_descriptor_sets = {}
         self.grpc_services = {}
         self.hosts = {}
         # self.invalidate_groups_for is handled above.
@@ -281,17 +310,20 @@ class IR:
         self.outliers = {}
         self.ratelimit = None
         self.redirect_cleartext_from = None
+        self.redirects = {}
         self.resolvers = {}
         self.saved_secrets = {}
         self.secret_info = {}
+        self.ocsp_staples = {}
         self.services = {}
         self.sidecar_cluster_name = None
         self.tls_contexts = {}
         self.tls_module = None
         self.tracing = None
 
-        # Copy k8s_status_updates from our aconf.
+        # Copy k8s_status_updates and external_addresses from our aconf.
         self.k8s_status_updates = aconf.k8s_status_updates
+        self.external_addresses = aconf.external_addresses
 
         # Check on the intercept agent and edge stack. Note that the Edge Stack touchfile is _not_
         # within $AMBASSADOR_CONFIG_BASE_DIR: it stays in /ambassador no matter what.
@@ -431,6 +463,25 @@ class IR:
             )
         )
 
+        # ...and contract capture, which sees requests before they're transcoded, and only
+        # ends up in the chain if some Mapping captures...
+        self.contract_capture = typecast(
+            IRContractCapture, self.save_resource(IRContractCapture(self, aconf))
+        )
+        self.save_filter(self.contract_capture, already_saved=True)
+
+        # ...and the gRPC-JSON transcoder, which does nothing unless a Mapping turns it on...
+        self.save_filter(
+            IRFilter(
+                ir=self,
+                aconf=aconf,
+                rkey="ir.grpc_json_transcoder",
+                kind="ir.grpc_json_transcoder",
+                name="grpc_json_transcoder",
+                config={},
+            )
+        )
+
         # ...and, finally, the barely-configurable router filter.
         router_config = {}
 
@@ -451,7 +502,9 @@ class IR:
 
         # We would handle other modules here -- but guess what? There aren't any.
         # At this point ambassador, tls, and the deprecated auth module are all there
-        # are, and they're handled above. So. At this point go sort out all the Mappings.
+        # are, and they're handled above. So. At this point go sort out all the Mappings,
+        # after the gRPC descriptors that they might need for transcoding.
+        GRPCDescriptorFactory.load_all(self, aconf)
         MappingFactory.load_all(self, aconf)
 
         self.walk_saved_resources(aconf, "add_mappings")
@@ -463,6 +516,17 @@ class IR:
         # information we might need, so that happens here.
         ListenerFactory.finalize(self, aconf)
 
+        # Now that we have all the Listeners, we can tell which Hosts end up with weaker
+        # TLS than the Module's tls_policy calls for.
+        check_tls_policies(self)
+
+        # EgressPolicies get listeners of their own, so they need to know which ports the
+        # real Listeners already have.
+        EgressPolicyFactory.load_all(self, aconf)
+
+        # Redirects need to know every Host's hostname.
+        RedirectFactory.load_all(self, aconf)
+
         # At this point we should know the full set of clusters, so we can generate
         # appropriate envoy names.
         #
@@ -548,6 +612,10 @@ class IR:
         for filter in self.filters:
             filter.finalize()
 
+        # Everything's built, so we can look for things that are valid but
+        # probably wrong.
+        Linter(self, aconf).run()
+
     # XXX Brutal hackery here! Probably this is a clue that Config and IR and such should have
     # a common container that can hold errors.
     def post_error(
@@ -556,8 +624,9 @@ class IR:
         resource: Optional[IRResource] = None,
         rkey: Optional[str] = None,
         log_level=logging.INFO,
+        code: Optional[ErrorCode] = None,
     ):
-        self.aconf.post_error(rc, resource=resource, rkey=rkey, log_level=log_level)
+        self.aconf.post_error(rc, resource=resource, rkey=rkey, log_level=log_level, code=code)
 
     def agent_init(self, aconf: Config) -> None:
         """
@@ -762,6 +831,20 @@ class IR:
         """
         self.cache.link(owner, owned)
 
+    def record_resource_timing(self, resource: IRResource, seconds: float) -> None:
+        """
+        Note how long it took to set up a resource, so that diagd can report the
+        slowest ones. Resources we made up ourselves don't count: what we want to
+        find is the Mapping (usually) that somebody wrote that makes every
+        reconfigure slow. The time for a resource includes the time for anything
+        that it sets up along the way, like its cluster.
+        """
+        if resource.location == "--internal--":
+            return
+
+        key = (resource.kind, resource.namespace, resource.name)
+        self.resource_timings[key] = self.resource_timings.get(key, 0.0) + seconds
+
     def save_resource(self, resource: IRResource) -> IRResource:
         if resource.is_active():
             self.saved_resources[resource.rkey] = resource
@@ -814,6 +897,12 @@ class IR:
                     secret_key,
                 )
                 self.secret_info[f"{secret_name}.{secret_namespace}"] = secret_info
+            elif aconf_secret.get("ocsp_der"):
+                # An OCSP staple, posted by the revocation watcher. It goes straight into the
+                # Envoy config, still base64-encoded, so there's nothing to save to disk.
+                ocsp_key = f"{aconf_secret.name}.{aconf_secret.namespace}"
+                self.logger.debug('saving OCSP staple "%s" (from %s)', ocsp_key, secret_key)
+                self.ocsp_staples[ocsp_key] = aconf_secret["ocsp_der"]
             else:
                 self.logger.debug(
                     "not saving secret_info from %s because there is no public half", secret_key
@@ -1081,8 +1170,20 @@ class IR:
             "tls_contexts": [context.as_dict() for context in self.tls_contexts.values()],
             "services": self.services,
             "k8s_status_updates": self.k8s_status_updates,
+            "external_addresses": self.external_addresses,
         }
 
+        if self.egress_ports:
+            od["egress_ports"] = {
+                port: [dests[k] for k in sorted(dests.keys())]
+                for port, dests in sorted(self.egress_ports.items())
+            }
+
+        if self.redirects:
+            od["redirects"] = {
+                hostname: routes for hostname, routes in sorted(self.redirects.items())
+            }
+
         if self.log_services:
             od["log_services"] = [srv.as_dict() for srv in self.log_services.values()]
 
@@ -1339,6 +1440,7 @@ class IR:
         group_resolver_kube_service = 0  # groups using the KubernetesServiceResolver
         group_resolver_kube_endpoint = 0  # groups using the KubernetesServiceResolver
         group_resolver_consul = 0  # groups using the ConsulResolver
+        group_resolver_plugin = 0  # groups using a PluginResolver
         mapping_count = 0  # total mappings
 
         for group in self.ordered_groups():
@@ -1397,6 +1499,8 @@ class IR:
                     group_resolver_kube_endpoint += 1
                 elif resolver.kind == "ConsulResolver":
                     group_resolver_consul += 1
+                elif resolver.kind == "PluginResolver":
+                    group_resolver_plugin += 1
 
         od["group_count"] = group_count
         od["group_http_count"] = group_http_count
@@ -1413,6 +1517,7 @@ class IR:
         od["group_resolver_kube_service"] = group_resolver_kube_service
         od["group_resolver_kube_endpoint"] = group_resolver_kube_endpoint
         od["group_resolver_consul"] = group_resolver_consul
+        od["group_resolver_plugin"] = group_resolver_plugin
         od["mapping_count"] = mapping_count
 
         od["listener_count"] = len(self.listeners)
@@ -5,12 +5,17 @@ from ..constants import Constants
 from .irbasemapping import IRBaseMapping
 from .irbuffer import IRBuffer
 from .ircors import IRCORS
+from .irdebugheaders import debug_headers_config
 from .irfilter import IRFilter
 from .irgzip import IRGzip
 from .irhttpmapping import IRHTTPMapping
 from .iripallowdeny import IRIPAllowDeny
+from .irloadshedding import load_shedding_config
+from .irrequestnormalization import module_request_normalization
 from .irresource import IRResource
 from .irretrypolicy import IRRetryPolicy
+from .irstatssinks import stats_sinks_config
+from .irtlspolicy import valid_tls_policy
 
 if TYPE_CHECKING:
     from .ir import IR  # pragma: no cover
@@ -50,6 +55,7 @@ class IRAmbassador(IRResource):
         "headers_with_underscores_action",
         "keepalive",
         "listener_idle_timeout_ms",
+        "lint",
         "liveness_probe",
         "load_balancer",
         "max_request_headers_kb",
@@ -70,6 +76,7 @@ class IRAmbassador(IRResource):
         "statsd",
         "strip_matching_host_port",
         "suppress_envoy_headers",
+        # Do not include tls_policy; we let finalize() check it.
         "use_ambassador_namespace_for_service_resolution",
         "use_proxy_proto",
         "use_remote_address",
@@ -217,6 +224,13 @@ class IRAmbassador(IRResource):
                 except ValueError:
                     self.post_error("envoy_validation_timeout must be an integer number of seconds")
 
+            # If we have a tls_policy, it had better be a real one.
+            if "tls_policy" in amod:
+                if valid_tls_policy(amod["tls_policy"], allow_custom=False):
+                    self.tls_policy = amod["tls_policy"]
+                else:
+                    self.post_error(f"invalid tls_policy {amod['tls_policy']}, ignoring")
+
         # If we don't have a default label domain, force it to 'ambassador'.
         if not self.get("default_label_domain"):
             self.default_label_domain = "ambassador"
@@ -244,6 +258,61 @@ class IRAmbassador(IRResource):
                 if not cur.get("service", None):
                     cur["service"] = diag_service
 
+        # Debug headers go first in the filter chain, so that their Lua filter is the last to
+        # see the response, and nothing can put a debug header back after it's taken it out.
+        if amod and ("debug_headers" in amod):
+            debug_headers, error = debug_headers_config(amod.debug_headers)
+
+            if error:
+                self.post_error(f"{error}, ignoring debug_headers")
+            else:
+                self.debug_headers = debug_headers
+
+                for kind, name in [
+                    ("ir.debug_token", "debug_token"),
+                    ("ir.debug_headers", "debug_headers"),
+                ]:
+                    debug_filter = IRFilter(
+                        ir=ir, aconf=aconf, kind=kind, name=name, config=debug_headers
+                    )
+                    debug_filter.sourced_by(amod)
+                    ir.save_filter(debug_filter)
+
+        # Load shedding is Envoy's overload manager, in the bootstrap config, and a Retry-After
+        # on the 503s that it sends; see V3Bootstrap and V3Listener.
+        if amod and ("load_shedding" in amod):
+            load_shedding, error = load_shedding_config(amod.load_shedding)
+
+            if error:
+                self.post_error(f"{error}, ignoring load_shedding")
+            else:
+                self.load_shedding = load_shedding
+
+        # Stats sinks, and the stats matcher, are in the bootstrap config; see V3Bootstrap.
+        if amod and ("stats_sinks" in amod):
+            stats_sinks, errors, notices = stats_sinks_config(amod.stats_sinks)
+
+            for error in errors:
+                self.post_error(f"{error}, ignoring stats_sinks")
+
+            for notice in notices:
+                ir.aconf.post_notice(notice, resource=self)
+
+            if stats_sinks:
+                self.stats_sinks = stats_sinks
+
+        # Request normalization goes for every Listener that doesn't override it; see
+        # IRListener and V3Listener.
+        request_normalization, errors, notices = module_request_normalization(amod)
+
+        for error in errors:
+            self.post_error(error)
+
+        for notice in notices:
+            ir.aconf.post_notice(notice, resource=self)
+
+        self.request_normalization = request_normalization
+
         if amod and ("enable_grpc_http11_bridge" in amod):
             self.grpc_http11_bridge = IRFilter(
                 ir=ir,
@@ -5,6 +5,7 @@ from urllib.parse import scheme_chars
 from urllib.parse import unquote as urlunquote
 from urllib.parse import urlparse
 
+from .. import errorcodes
 from ..config import Config
 from ..utils import dump_json
 from .irresource import IRResource
@@ -202,7 +203,7 @@ class IRBaseMapping(IRResource):
         resolver = self.ir.get_resolver(self.resolver)
 
         if not resolver:
-            self.post_error(f"resolver {self.resolver} is unknown!")
+            self.post_error(f"resolver {self.resolver} is unknown!", code=errorcodes.UNKNOWN_RESOLVER)
             return False
 
         self.ir.logger.debug(
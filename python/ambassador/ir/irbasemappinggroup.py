@@ -57,8 +57,9 @@ class IRBaseMappingGroup(IRResource):
 
     def normalize_weights_in_mappings(self) -> bool:
         # If there's only one mapping in the group, it's automatically weighted
-        # at 100%.
-        if len(self.mappings) == 1:
+        # at 100% -- unless the group falls back to other Mappings for whatever
+        # its weights leave over.
+        if (len(self.mappings) == 1) and not self.get("fallback", False):
             self.logger.debug(
                 "Assigning weight 100 to single mapping %s in group", self.mappings[0].name
             )
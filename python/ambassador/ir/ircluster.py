@@ -14,7 +14,7 @@
 
 import re
 import urllib.parse
-from typing import TYPE_CHECKING, Any, Dict, List, Optional, Union
+from typing import TYPE_CHECKING, Any, Dict, List, Optional, Tuple, Union
 from typing import cast as typecast
 
 from ..config import Config
@@ -22,6 +22,7 @@ from ..utils import RichStatus
 from .irhealthchecks import IRHealthChecks
 from .irresource import IRResource
 from .irtlscontext import IRTLSContext
+from .irupstreams import upstream_sources
 
 if TYPE_CHECKING:
     from .ir import IR  # pragma: no cover
@@ -35,6 +36,51 @@ if TYPE_CHECKING:
 ## things in Ambassador -- they are basically the generic "upstream service"
 ## entity.
 
+# What a Mapping can ask to speak to its upstream: h2 is HTTP/2 over TLS, h2c is
+# HTTP/2 in cleartext, and auto lets the upstream pick with ALPN.
+UPSTREAM_PROTOCOLS = ["http/1.1", "h2", "h2c", "auto"]
+
+
+def upstream_protocol_error(protocol: str, originate_tls: bool) -> Optional[str]:
+    """
+    Check an upstream_protocol against whether the cluster originates TLS.
+    Returns None if it's OK, and an error if not.
+    """
+
+    if protocol not in UPSTREAM_PROTOCOLS:
+        return f"upstream_protocol {protocol} is not one of {', '.join(UPSTREAM_PROTOCOLS)}"
+
+    if (protocol == "h2") and not originate_tls:
+        return "upstream_protocol h2 needs TLS origination (h2c is HTTP/2 in cleartext)"
+
+    if (protocol == "h2c") and originate_tls:
+        return "upstream_protocol h2c is HTTP/2 in cleartext, but this service originates TLS"
+
+    if (protocol == "auto") and not originate_tls:
+        return "upstream_protocol auto picks a protocol with ALPN, which needs TLS origination"
+
+    return None
+
+
+def app_protocol_upstream(app_protocol: str, originate_tls: bool) -> Optional[str]:
+    """
+    The upstream_protocol that a Service port's appProtocol means, or None if
+    it's not one we know.
+    """
+
+    proto = app_protocol.lower()
+
+    if proto == "kubernetes.io/h2c":
+        return "h2c"
+
+    if proto in ["http2", "h2", "grpc"]:
+        return "h2" if originate_tls else "h2c"
+
+    if proto in ["http", "https", "http/1.1", "kubernetes.io/ws", "kubernetes.io/wss"]:
+        return "http/1.1"
+
+    return None
+
 
 class IRCluster(IRResource):
     def __init__(
@@ -57,12 +103,16 @@ class IRCluster(IRResource):
         enable_ipv6: Optional[bool] = None,
         lb_type: str = "round_robin",
         grpc: Optional[bool] = False,
+        upstream_protocol: Optional[str] = None,
         allow_scheme: Optional[bool] = True,
         load_balancer: Optional[dict] = None,
         keepalive: Optional[dict] = None,
         circuit_breakers: Optional[list] = None,
         respect_dns_ttl: Optional[bool] = False,
         health_checks: Optional[IRHealthChecks] = None,
+        subset_keys: Optional[List[str]] = None,
+        failover: Optional[dict] = None,
+        upstreams: Optional[List[Dict[str, Any]]] = None,
         rkey: str = "-override-",
         kind: str = "IRCluster",
         apiVersion: str = "getambassador.io/v0",  # Not a typo! See below.
@@ -164,6 +214,20 @@ class IRCluster(IRResource):
 
             service = service[idx + 3 :]
 
+        # An upstream_protocol has to agree with whether we originate TLS. (gRPC is
+        # HTTP/2 either way, so it can't be http/1.1 either.)
+        if upstream_protocol:
+            error = upstream_protocol_error(upstream_protocol, originate_tls)
+
+            if (not error) and grpc and (upstream_protocol == "http/1.1"):
+                error = "gRPC needs HTTP/2, so upstream_protocol can't be http/1.1"
+
+            if error:
+                errors.append(f"{error}; ignoring upstream_protocol")
+                upstream_protocol = None
+            else:
+                name_fields.append("up-%s" % upstream_protocol)
+
         # XXX Should this be checking originate_tls? Why does it do that?
         if originate_tls and host_rewrite:
             name_fields.append("hr-%s" % host_rewrite)
@@ -273,6 +337,59 @@ class IRCluster(IRResource):
 
                     name_fields.append("-".join(key_fields))
 
+        # Mappings that pick out endpoints by different label keys need clusters with different
+        # subset selectors (see V3Cluster). Mappings that use the same keys with different
+        # values share a cluster, and pick their subset in the route.
+        if subset_keys:
+            name_fields.append("ss-" + "-".join(subset_keys))
+
+        # Failing over makes the fallback services part of this cluster (as lower priorities;
+        # see V3Cluster), so a Mapping with failover can't share a cluster with one without.
+        failover_hosts: List[Tuple[str, int]] = []
+
+        if failover:
+            for fallback in failover["services"]:
+                fp = urllib.parse.urlparse("random://" + fallback.split("://", 1)[-1])
+
+                try:
+                    fport = fp.port
+                except ValueError as e:
+                    errors.append(
+                        "found invalid port for failover service {} - {}; ignoring it".format(
+                            fallback, e
+                        )
+                    )
+                    continue
+
+                if not fp.hostname:
+                    errors.append(
+                        "failover service %s has no hostname and will be ignored" % fallback
+                    )
+                    continue
+
+                failover_hosts.append((fp.hostname, fport or port))
+
+            name_fields.append(
+                "fo-" + "-".join("%s-%d" % (host, fport) for host, fport in failover_hosts)
+            )
+
+        # Upstreams replace the service's endpoints with their own (see irupstreams.py), so
+        # a cluster with upstreams is only shared by Mappings with the very same ones.
+        if upstreams:
+            name_fields.append(
+                "us-"
+                + "-".join(
+                    "%s-%s-%d-%d"
+                    % (
+                        upstream["service"],
+                        upstream.get("resolver", None) or "",
+                        upstream.get("weight", None) or 1,
+                        upstream.get("priority", None) or 0,
+                    )
+                    for upstream in upstreams
+                )
+            )
+
         # Finally we can construct the cluster name.
         name = "_".join(name_fields)
         name = re.sub(r"[^0-9A-Za-z_]", "_", name)
@@ -311,6 +428,20 @@ class IRCluster(IRResource):
             "health_checks": health_checks,
         }
 
+        if subset_keys:
+            new_args["subset_keys"] = subset_keys
+
+        if upstreams:
+            new_args["upstreams"] = upstreams
+
+        if failover_hosts:
+            new_args["failover"] = {
+                "urls": ["tcp://%s:%d" % (host, fport) for host, fport in failover_hosts],
+                "healthy_percent": failover.get("healthy_percent", None),
+                "consecutive_5xx": failover.get("consecutive_5xx", None),
+                "ejection_time_ms": failover.get("ejection_time_ms", None),
+            }
+
         # If we have a stats_name, use it. If not, default it to the service to make life
         # easier for people trying to find stats later -- but translate unusual characters
         # to underscores, just in case.
@@ -323,6 +454,9 @@ class IRCluster(IRResource):
         if grpc:
             new_args["grpc"] = True
 
+        if upstream_protocol:
+            new_args["upstream_protocol"] = upstream_protocol
+
         if host_rewrite:
             new_args["host_rewrite"] = host_rewrite
 
@@ -340,6 +474,12 @@ class IRCluster(IRResource):
         self._hostname = hostname
         self._namespace = namespace
         self._port = port
+        self._failover_hosts = failover_hosts
+        self._upstreams = upstreams
+        self._originate_tls = originate_tls
+        # Only an HTTP Mapping's upstream can be told what to speak by its appProtocol.
+        # (Auth services and the like already know, and TCPMappings don't speak HTTP.)
+        self._use_app_protocol = parent_ir_resource.kind == "IRHTTPMapping"
         self._is_sidecar = False
 
         if self._hostname == "127.0.0.1" and self._port == 8500:
@@ -379,11 +519,69 @@ class IRCluster(IRResource):
         if not targets:
             self.ir.logger.debug("accepting cluster with no endpoints: %s" % self.name)
 
+        if self._use_app_protocol:
+            self.check_app_protocol(ir)
+
+        # Each fallback is resolved just like the primary, one list of targets per priority.
+        if self._failover_hosts:
+            self.failover_targets = [
+                ir.resolve_targets(self, self._resolver, host, self._namespace, port)
+                for host, port in self._failover_hosts
+            ]
+
+        # Upstreams are resolved to endpoint paths here; ambex does the rest.
+        if self._upstreams:
+            self.upstream_sources = upstream_sources(ir, self, self._upstreams, self._port)
+
         # If we have health checking config then generate IR for it
         if "health_checks" in self:
             self.health_checks = IRHealthChecks(ir, aconf, self.get("health_checks", None))
         return True
 
+    def check_app_protocol(self, ir: "IR") -> None:
+        # The appProtocol of the service's port says what the upstream speaks there. If the
+        # Mapping doesn't say, and isn't gRPC, that's the protocol to use; if it does say,
+        # and they disagree, someone should hear about it, but the Mapping wins.
+        resolver = ir.resolve_resolver(self, self._resolver)
+        app_protocol = resolver.app_protocol(ir, self._hostname, self._namespace, self._port)
+
+        if not app_protocol:
+            return
+
+        implied = app_protocol_upstream(app_protocol, self._originate_tls)
+
+        if not implied:
+            self.logger.debug(f"{self.name}: ignoring unknown appProtocol {app_protocol}")
+            return
+
+        explicit = self.get("upstream_protocol", None)
+
+        if explicit:
+            if (explicit != "auto") and ((explicit == "http/1.1") != (implied == "http/1.1")):
+                ir.aconf.post_notice(
+                    f"service {self.service} has appProtocol {app_protocol}, "
+                    f"but is using upstream_protocol {explicit}",
+                    resource=self,
+                )
+        elif self.get("grpc", False):
+            if implied == "http/1.1":
+                ir.aconf.post_notice(
+                    f"service {self.service} has appProtocol {app_protocol}, "
+                    f"but gRPC is HTTP/2",
+                    resource=self,
+                )
+        else:
+            error = upstream_protocol_error(implied, self._originate_tls)
+
+            if error:
+                ir.aconf.post_notice(
+                    f"service {self.service} has appProtocol {app_protocol}, but {error}",
+                    resource=self,
+                )
+            else:
+                self.logger.debug(f"{self.name}: appProtocol {app_protocol} means {implied}")
+                self.upstream_protocol = implied
+
     def is_edge_stack_sidecar(self) -> bool:
         return self.is_active() and self._is_sidecar
 
@@ -418,9 +616,13 @@ class IRCluster(IRResource):
             "tls_context",
             "originate_tls",
             "grpc",
+            "upstream_protocol",
             "connect_timeout_ms",
             "cluster_idle_timeout_ms",
             "cluster_max_connection_lifetime_ms",
+            "subset_keys",
+            "failover",
+            "upstreams",
         ]:
             if self.get(key, None) != other.get(key, None):
                 mismatches.append(key)
@@ -459,3 +661,6 @@ class IRCluster(IRResource):
         return self.get_resolver().clustermap_entry(
             self.ir, self, self._hostname, self._namespace, self._port
         )
+
+    def k8s_service(self) -> Optional[Tuple[str, str]]:
+        return self.get_resolver().k8s_service(self.ir, self._hostname, self._namespace)
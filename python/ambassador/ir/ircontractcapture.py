@@ -0,0 +1,142 @@
+import os
+from typing import TYPE_CHECKING, Any, Optional
+from urllib.parse import urlparse
+
+from ..config import Config
+from .ircluster import IRCluster
+from .irfilter import IRFilter
+
+if TYPE_CHECKING:
+    from .ir import IR  # pragma: no cover
+
+#############################################################################
+## ircontractcapture.py -- mirroring traffic for contract testing
+##
+## A Mapping's contract_capture mirrors a sampled, redacted copy of its
+## requests and responses to a capture endpoint, so that teams can build
+## consumer-driven contract tests from real traffic. The sampling, redaction,
+## and sending all happen in the entrypoint (see pkg/capture/mirror.go),
+## which Envoy reaches with an ext_proc filter:
+##
+##   - the filter is in the chain whenever any Mapping captures, but set to
+##     skip everything, so it does nothing for the routes that don't;
+##   - each capturing route turns it on with an override that sends headers
+##     and (buffered) bodies, and that names the Mapping in the gRPC
+##     metadata, so that the entrypoint knows where to send the exchange.
+##
+## The entrypoint answers before it sends anything anywhere, and the filter
+## allows failures, so a slow or broken capture endpoint never holds up the
+## traffic being captured.
+
+FILTER_NAME = "envoy.filters.http.ext_proc"
+
+# This has to match MirrorMetadata in pkg/capture/mirror.go.
+CONTRACT_CAPTURE_METADATA = "x-ambassador-mapping"
+
+# The most of a body that a Mapping can keep; this has to match the CRD.
+MAX_BODY_BYTES = 1024 * 1024
+
+# What the filter sends the entrypoint by default (nothing), and for a capturing route.
+SKIP_PROCESSING_MODE = {
+    "request_header_mode": "SKIP",
+    "response_header_mode": "SKIP",
+    "request_body_mode": "NONE",
+    "response_body_mode": "NONE",
+    "request_trailer_mode": "SKIP",
+    "response_trailer_mode": "SKIP",
+}
+
+CAPTURE_PROCESSING_MODE = {
+    "request_header_mode": "SEND",
+    "response_header_mode": "SEND",
+    "request_body_mode": "BUFFERED_PARTIAL",
+    "response_body_mode": "BUFFERED_PARTIAL",
+    "request_trailer_mode": "SKIP",
+    "response_trailer_mode": "SKIP",
+}
+
+
+def contract_capture_port() -> int:
+    """
+    The port that the entrypoint's ext_proc server listens on, from
+    AMBASSADOR_CONTRACT_CAPTURE_PORT.
+    """
+    return int(os.getenv("AMBASSADOR_CONTRACT_CAPTURE_PORT", "8007"))
+
+
+def contract_capture_error(spec: Any) -> Optional[str]:
+    """
+    Check a Mapping's contract_capture. Returns None if it's OK, and what's
+    wrong with it if not.
+    """
+    if not isinstance(spec, dict):
+        return "contract_capture must be an object"
+
+    endpoint = spec.get("endpoint", None)
+    parsed = urlparse(endpoint) if isinstance(endpoint, str) else None
+
+    if (not parsed) or (parsed.scheme not in ("http", "https")) or (not parsed.netloc):
+        return f"endpoint {endpoint!r} is not an http or https URL"
+
+    for key, limit in (("sample_percent", 100), ("max_body_bytes", MAX_BODY_BYTES)):
+        value = spec.get(key, None)
+
+        if value is not None:
+            if isinstance(value, bool) or (not isinstance(value, int)) or not (0 <= value <= limit):
+                return f"{key} {value!r} is not between 0 and {limit}"
+
+    redact_headers = spec.get("redact_headers", None)
+
+    if redact_headers is not None:
+        if (not isinstance(redact_headers, list)) or (
+            not all(isinstance(h, str) and h for h in redact_headers)
+        ):
+            return "redact_headers must be a list of header names"
+
+    return None
+
+
+class IRContractCapture(IRFilter):
+    cluster: Optional[IRCluster]
+
+    def __init__(
+        self,
+        ir: "IR",
+        aconf: Config,
+        rkey: str = "ir.contract_capture",
+        kind: str = "ir.contract_capture",
+        name: str = "contract_capture",
+        **kwargs,
+    ) -> None:
+        super().__init__(
+            ir=ir, aconf=aconf, rkey=rkey, kind=kind, name=name, type="decoder", config={}
+        )
+
+    def setup(self, ir: "IR", aconf: Config) -> bool:
+        # The cluster only shows up once there's a Mapping that needs it.
+        self.cluster = None
+        return True
+
+    def add_mappings(self, ir: "IR", aconf: Config) -> None:
+        capturing = any(
+            mapping.get("contract_capture", None)
+            for group in ir.groups.values()
+            for mapping in group.mappings
+        )
+
+        if not capturing:
+            return
+
+        cluster = ir.add_cluster(
+            IRCluster(
+                ir=ir,
+                aconf=aconf,
+                parent_ir_resource=self,
+                location=self.location,
+                service=f"127.0.0.1:{contract_capture_port()}",
+                grpc=True,
+            )
+        )
+
+        cluster.referenced_by(self)
+        self.cluster = cluster
@@ -0,0 +1,195 @@
+import json
+import re
+from typing import Any, Dict, List, Optional, Tuple
+
+#############################################################################
+## irdebugheaders.py -- response headers for debugging one request at a time
+##
+## The Ambassador Module's debug_headers turns on a handful of response
+## headers that say how Envoy handled a request: which Mapping it matched,
+## which cluster and upstream it went to, which Ambassador pod handled it,
+## how many tries it took, and which route config it was. They only show up
+## on responses to requests that carry a valid debug token: a JWT, signed by
+## a key in debug_headers.jwks, that expires soon (within max_lifetime_s).
+## Support can mint one for a user having trouble, and see what's happening
+## to their requests in production, without anyone else seeing any of it.
+##
+## This takes two filters at the front of the chain:
+##
+##   - jwt_authn checks the token, and puts its payload in the dynamic
+##     metadata. A missing or bad token doesn't fail the request.
+##   - a Lua filter that marks the request for debugging if the payload is
+##     there, and isn't too long-lived; and removes the debug headers from
+##     the response if it isn't marked.
+##
+## Envoy's router adds the headers to every response (V3Route adds the
+## Mapping's, V3Listener the rest to every virtual host), so that the Lua
+## filter, which sees the response after everything else, has the last word.
+
+DEFAULT_TOKEN_HEADER = "x-ambassador-debug-token"
+DEFAULT_MAX_LIFETIME_S = 900
+
+JWT_PROVIDER = "ambassador_debug"
+JWT_AUTHN_NAMESPACE = "envoy.filters.http.jwt_authn"
+JWT_PAYLOAD_KEY = "debug_token"
+
+# Where the Lua filter marks a request for debugging.
+DEBUG_NAMESPACE = "ambassador.debug_headers"
+
+MAPPING_HEADER = "x-ambassador-debug-mapping"
+CONFIG_VERSION_HEADER = "x-ambassador-debug-config-version"
+
+# The headers that are the same for every route, and Envoy's formatters for them.
+COMMON_HEADERS = [
+    ("x-ambassador-debug-cluster", "%UPSTREAM_CLUSTER%"),
+    ("x-ambassador-debug-upstream", "%UPSTREAM_HOST%"),
+    ("x-ambassador-debug-pod", "%HOSTNAME%"),
+    ("x-ambassador-debug-attempts", "%UPSTREAM_REQUEST_ATTEMPT_COUNT%"),
+]
+
+DEBUG_HEADERS = [MAPPING_HEADER, CONFIG_VERSION_HEADER] + [name for name, _ in COMMON_HEADERS]
+
+HEADER_NAME_RE = re.compile(r"^[A-Za-z0-9!#$%&'*+.^_`|~-]+$")
+
+
+def debug_headers_config(spec: Any) -> Tuple[Optional[Dict[str, Any]], Optional[str]]:
+    """
+    Check the Module's debug_headers. Returns (config, None) with the defaults
+    filled in if it's OK, and (None, error) if not.
+    """
+
+    if not isinstance(spec, dict):
+        return None, f"debug_headers must be an object, not {spec}"
+
+    jwks = spec.get("jwks", None)
+
+    if not isinstance(jwks, str):
+        return None, "debug_headers needs a jwks to check debug tokens with"
+
+    try:
+        keys = json.loads(jwks).get("keys", None)
+    except (ValueError, AttributeError):
+        keys = None
+
+    if not isinstance(keys, list) or not keys:
+        return None, "debug_headers.jwks must be a JSON Web Key Set with at least one key"
+
+    header = spec.get("header", DEFAULT_TOKEN_HEADER)
+
+    if (not isinstance(header, str)) or (not HEADER_NAME_RE.match(header)):
+        return None, f"debug_headers.header {header} is not a valid header name"
+
+    issuer = spec.get("issuer", None)
+
+    if (issuer is not None) and not isinstance(issuer, str):
+        return None, f"debug_headers.issuer must be a string, not {issuer}"
+
+    audiences = spec.get("audiences", [])
+
+    if (not isinstance(audiences, list)) or not all(isinstance(a, str) for a in audiences):
+        return None, f"debug_headers.audiences must be a list of strings, not {audiences}"
+
+    max_lifetime_s = spec.get("max_lifetime_s", DEFAULT_MAX_LIFETIME_S)
+
+    if (
+        isinstance(max_lifetime_s, bool)
+        or not isinstance(max_lifetime_s, int)
+        or max_lifetime_s <= 0
+    ):
+        return None, f"debug_headers.max_lifetime_s must be a positive number, not {max_lifetime_s}"
+
+    config: Dict[str, Any] = {
+        "jwks": jwks,
+        "header": header.lower(),
+        "audiences": audiences,
+        "max_lifetime_s": max_lifetime_s,
+    }
+
+    if issuer:
+        config["issuer"] = issuer
+
+    return config, None
+
+
+def jwt_authn_config(config: Dict[str, Any]) -> Dict[str, Any]:
+    """
+    The jwt_authn filter's config, less its @type, for checking debug tokens.
+    """
+
+    provider: Dict[str, Any] = {
+        "local_jwks": {"inline_string": config["jwks"]},
+        "from_headers": [{"name": config["header"]}],
+        "payload_in_metadata": JWT_PAYLOAD_KEY,
+        # The token is only for us, not for the upstream.
+        "forward": False,
+    }
+
+    if config.get("issuer"):
+        provider["issuer"] = config["issuer"]
+
+    if config["audiences"]:
+        provider["audiences"] = config["audiences"]
+
+    return {
+        "providers": {JWT_PROVIDER: provider},
+        "rules": [
+            {
+                "match": {"prefix": "/"},
+                "requires": {
+                    "requires_any": {
+                        "requirements": [
+                            {"provider_name": JWT_PROVIDER},
+                            {"allow_missing_or_failed": {}},
+                        ]
+                    }
+                },
+            }
+        ],
+    }
+
+
+def lua_code(config: Dict[str, Any]) -> str:
+    """
+    The Lua filter's code, which decides whether a request gets the debug
+    headers. jwt_authn has already turned away expired tokens, but a token
+    that expires too far in the future isn't short-lived, so it doesn't count.
+    """
+
+    headers = ", ".join(f'"{name}"' for name in DEBUG_HEADERS)
+
+    return f"""
+local max_lifetime = {config["max_lifetime_s"]}
+local debug_headers = {{ {headers} }}
+
+function envoy_on_request(request_handle)
+  local verified = request_handle:streamInfo():dynamicMetadata():get("{JWT_AUTHN_NAMESPACE}")
+  local payload = verified and verified["{JWT_PAYLOAD_KEY}"]
+  local exp = payload and tonumber(payload["exp"])
+
+  if exp and (exp - os.time() <= max_lifetime) then
+    request_handle:streamInfo():dynamicMetadata():set("{DEBUG_NAMESPACE}", "enabled", true)
+  end
+end
+
+function envoy_on_response(response_handle)
+  local debug = response_handle:streamInfo():dynamicMetadata():get("{DEBUG_NAMESPACE}")
+
+  if debug and debug["enabled"] then
+    return
+  end
+
+  for _, name in ipairs(debug_headers) do
+    response_handle:headers():remove(name)
+  end
+end
+"""
+
+
+def debug_response_headers(config_version: str) -> List[Dict[str, Any]]:
+    """
+    The debug headers for a virtual host's response_headers_to_add.
+    """
+
+    headers = COMMON_HEADERS + [(CONFIG_VERSION_HEADER, config_version)]
+
+    return [{"header": {"key": name, "value": value}, "append": False} for name, value in headers]
@@ -0,0 +1,186 @@
+import re
+from typing import TYPE_CHECKING, Any, Dict, List, Optional
+
+from ..config import Config
+from .irresource import IRResource
+
+if TYPE_CHECKING:
+    from .ir import IR  # pragma: no cover
+
+#############################################################################
+## iregress.py -- allowlisted egress through Ambassador
+##
+## An EgressPolicy opens an egress port on Ambassador. Workloads send plain
+## HTTP to that port, with the destination they want as the Host header
+## (so "api.example.com" or "api.example.com:8443"), and Ambassador either
+## sends the request on to the destination or refuses it with a 403.
+##
+## Every EgressPolicy with the same port adds to the same allowlist. A
+## destination is a (host, port) pair; a bare host in the Host header means
+## the first of the destination's ports. Destinations on port 443 get TLS
+## originated by default, verified against the system CA bundle and the SNI.
+##
+## V3Egress turns ir.egress_ports into one listener per egress port, and one
+## cluster per destination, so each destination gets its own stats. All of
+## it gets a JSON access log, whatever envoy_log_type says, so that there's
+## always an audit trail of what went where.
+
+# A DNS name, without wildcards.
+HOSTNAME_RE = re.compile(
+    r"^(?=.{1,253}$)[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?)*$"
+)
+
+DEFAULT_EGRESS_PORTS = [443]
+
+
+def valid_port(port: Any) -> bool:
+    return isinstance(port, int) and (not isinstance(port, bool)) and (1 <= port <= 65535)
+
+
+class IREgressPolicy(IRResource):
+    AllowedKeys = {"port", "destinations"}
+
+    port: int
+    destinations: List[Dict[str, Any]]
+
+    def __init__(
+        self,
+        ir: "IR",
+        aconf: Config,
+        rkey: str,  # REQUIRED
+        name: str,  # REQUIRED
+        location: str,  # REQUIRED
+        namespace: Optional[str] = None,
+        kind: str = "IREgressPolicy",
+        apiVersion: str = "getambassador.io/v3alpha1",
+        **kwargs,
+    ) -> None:
+        new_args = {x: kwargs[x] for x in kwargs.keys() if x in IREgressPolicy.AllowedKeys}
+
+        super().__init__(
+            ir=ir,
+            aconf=aconf,
+            rkey=rkey,
+            location=location,
+            kind=kind,
+            name=name,
+            namespace=namespace,
+            apiVersion=apiVersion,
+            **new_args,
+        )
+
+    def setup(self, ir: "IR", aconf: Config) -> bool:
+        port = self.get("port", None)
+
+        if not valid_port(port):
+            self.post_error(f"EgressPolicy {self.name}: port {port} is not a valid port")
+            return False
+
+        specs = self.get("destinations", None)
+
+        if (not isinstance(specs, list)) or (not specs):
+            self.post_error(f"EgressPolicy {self.name}: destinations must be a non-empty list")
+            return False
+
+        destinations: List[Dict[str, Any]] = []
+
+        for spec in specs:
+            error = self.add_destinations(destinations, spec)
+
+            if error:
+                self.post_error(f"EgressPolicy {self.name}: {error}; ignoring it")
+
+        if not destinations:
+            self.post_error(f"EgressPolicy {self.name}: no valid destinations")
+            return False
+
+        self.destinations = destinations
+        return True
+
+    @staticmethod
+    def add_destinations(destinations: List[Dict[str, Any]], spec: Any) -> Optional[str]:
+        """
+        Add one { "host", "port", "default", "tls", "sni" } dict to destinations
+        for each port of the destination spec. Returns an error, without adding
+        anything, if the spec isn't valid.
+        """
+
+        if not isinstance(spec, dict):
+            return f"destination {spec} must be an object"
+
+        host = spec.get("host", None)
+
+        if (not isinstance(host, str)) or (not HOSTNAME_RE.match(host)):
+            return f"destination host {host} is not a valid DNS name"
+
+        host = host.lower()
+        ports = spec.get("ports", None) or DEFAULT_EGRESS_PORTS
+
+        if (not isinstance(ports, list)) or (not all(valid_port(p) for p in ports)):
+            return f"destination {host}: ports {ports} must be a list of valid ports"
+
+        tls = spec.get("tls", None)
+
+        if (tls is not None) and (not isinstance(tls, bool)):
+            return f"destination {host}: tls must be true or false, not {tls}"
+
+        sni = spec.get("sni", None) or host
+
+        if (not isinstance(sni, str)) or (not HOSTNAME_RE.match(sni)):
+            return f"destination {host}: sni {sni} is not a valid DNS name"
+
+        for i, port in enumerate(ports):
+            destinations.append(
+                {
+                    "host": host,
+                    "port": port,
+                    "default": i == 0,
+                    "tls": tls if (tls is not None) else (port == 443),
+                    "sni": sni,
+                }
+            )
+
+        return None
+
+
+class EgressPolicyFactory:
+    @classmethod
+    def load_all(cls, ir: "IR", aconf: Config) -> None:
+        policies = aconf.get_config("egress_policies")
+
+        if not policies:
+            return
+
+        listener_ports = {listener.port for listener in ir.listeners.values()}
+
+        # Go in name order, so that it's predictable which policy wins a conflict.
+        for config in sorted(policies.values(), key=lambda c: (c.get("namespace", ""), c.name)):
+            policy = IREgressPolicy(ir, aconf, **config)
+
+            if not policy.is_active():
+                continue
+
+            if policy.port in listener_ports:
+                policy.post_error(
+                    f"EgressPolicy {policy.name}: port {policy.port} is already in use by a Listener"
+                )
+                continue
+
+            egress = ir.egress_ports.setdefault(policy.port, {})
+
+            for dest in policy.destinations:
+                key = f"{dest['host']}:{dest['port']}"
+                extant = egress.get(key, None)
+
+                if extant is None:
+                    egress[key] = dest
+                elif (extant["tls"] != dest["tls"]) or (extant["sni"] != dest["sni"]):
+                    policy.post_error(
+                        f"EgressPolicy {policy.name}: destination {key} conflicts with an "
+                        f"earlier EgressPolicy on port {policy.port}; keeping the earlier one"
+                    )
+                elif dest["default"] and not extant["default"]:
+                    extant["default"] = True
+
+            policy.referenced_by(config)
+            ir.save_resource(policy)
@@ -0,0 +1,236 @@
+import base64
+import binascii
+from typing import TYPE_CHECKING, Any, Dict, Iterator, List, Optional, Tuple
+
+from ..config import Config
+
+if TYPE_CHECKING:
+    from .ir import IR  # pragma: no cover
+
+#############################################################################
+## irgrpctranscoder.py -- gRPC-JSON transcoding, with descriptors from ConfigMaps
+##
+## To transcode between REST and gRPC, Envoy needs the protobuf descriptors of
+## the gRPC services. Those live in ConfigMaps labeled
+## getambassador.io/grpc-descriptors: every key in the ConfigMap's binaryData
+## is one version of a FileDescriptorSet (what
+## `protoc --include_imports --descriptor_set_out` makes).
+##
+## Keeping each version under its own key is what makes rollouts safe. A
+## Mapping names the version that it wants, so a new version can go to one
+## Mapping (a canary, say) before the rest, and a broken version never gets
+## near the Mappings that don't ask for it. A Mapping that doesn't name a
+## version gets the ConfigMap's getambassador.io/grpc-descriptors-default
+## annotation, or its only version if it has just one.
+##
+##   grpc_transcoder:
+##     descriptors: bookstore            # the ConfigMap: name, or name.namespace
+##     version: bookstore-v2.pb          # optional
+##     services: [ bookstore.Bookstore ]
+##
+## A Mapping whose descriptors are missing, broken, or don't have all of its
+## services is invalid, and its status says why.
+##
+## The transcoder filter goes into the filter chain with no services (which
+## Envoy takes to mean "do nothing") and each transcoding Mapping's route turns
+## it on with its own descriptors and services; see V3HTTPFilter and V3Route.
+
+# One version of a descriptor set: { "descriptor_bin": base64, "services": [ names ] }
+GRPCDescriptorVersion = Dict[str, Any]
+
+
+def _varint(data: bytes, i: int) -> Tuple[int, int]:
+    value = 0
+    shift = 0
+
+    while True:
+        if i >= len(data):
+            raise ValueError("truncated varint")
+
+        b = data[i]
+        i += 1
+        value |= (b & 0x7F) << shift
+        shift += 7
+
+        if not (b & 0x80):
+            return value, i
+
+        if shift > 63:
+            raise ValueError("varint too long")
+
+
+def _fields(data: bytes) -> Iterator[Tuple[int, Optional[bytes]]]:
+    """
+    Walk the fields of a protobuf message, yielding (field number, value) for
+    each, where the value is only there for length-delimited fields. Raises
+    ValueError if the message is malformed.
+    """
+
+    i = 0
+
+    while i < len(data):
+        key, i = _varint(data, i)
+        field, wire_type = key >> 3, key & 0x07
+        value: Optional[bytes] = None
+
+        if field == 0:
+            raise ValueError("field number 0")
+
+        if wire_type == 0:
+            _, i = _varint(data, i)
+        elif wire_type == 1:
+            i += 8
+        elif wire_type == 2:
+            length, i = _varint(data, i)
+            value = data[i : i + length]
+            i += length
+        elif wire_type == 5:
+            i += 4
+        else:
+            raise ValueError(f"unsupported wire type {wire_type}")
+
+        if i > len(data):
+            raise ValueError("truncated field")
+
+        yield field, value
+
+
+def descriptor_services(data: bytes) -> List[str]:
+    """
+    Return the fully-qualified names of the services in a serialized
+    FileDescriptorSet, or raise ValueError if it isn't one.
+    """
+
+    services: List[str] = []
+    files = 0
+
+    # FileDescriptorSet.file = 1
+    for field, file_proto in _fields(data):
+        if (field != 1) or (file_proto is None):
+            continue
+
+        files += 1
+        package = ""
+        names: List[str] = []
+
+        # FileDescriptorProto.package = 2, FileDescriptorProto.service = 6
+        for ffield, fvalue in _fields(file_proto):
+            if fvalue is None:
+                continue
+
+            if ffield == 2:
+                package = fvalue.decode("utf-8")
+            elif ffield == 6:
+                # ServiceDescriptorProto.name = 1
+                for sfield, svalue in _fields(fvalue):
+                    if (sfield == 1) and (svalue is not None):
+                        names.append(svalue.decode("utf-8"))
+
+        services += [f"{package}.{name}" if package else name for name in names]
+
+    if not files:
+        raise ValueError("no files in the descriptor set")
+
+    return services
+
+
+class GRPCDescriptorFactory:
+    @classmethod
+    def load_all(cls, ir: "IR", aconf: Config) -> None:
+        descriptor_sets = aconf.get_config("grpc_descriptor_sets")
+
+        if not descriptor_sets:
+            return
+
+        for rkey, config in descriptor_sets.items():
+            versions: Dict[str, GRPCDescriptorVersion] = {}
+
+            for version, encoded in (config.get("versions") or {}).items():
+                try:
+                    services = descriptor_services(base64.b64decode(encoded, validate=True))
+                except (binascii.Error, ValueError, UnicodeDecodeError) as e:
+                    ir.post_error(
+                        f"gRPC descriptors {rkey}: {version} is not a valid FileDescriptorSet "
+                        f"({e}); ignoring it",
+                        resource=config,
+                    )
+                    continue
+
+                versions[version] = {"descriptor_bin": encoded, "services": services}
+
+            ir.grpc_descriptor_sets[rkey] = {
+                "default_version": config.get("default_version", None),
+                "versions": versions,
+            }
+
+    @classmethod
+    def resolve(
+        cls, ir: "IR", transcoder: Any, namespace: str
+    ) -> Tuple[Optional[Dict[str, Any]], Optional[str]]:
+        """
+        Work out which descriptors a Mapping's grpc_transcoder uses. Returns
+        ({ "descriptors", "version", "services" }, None) if all is well, and
+        (None, error) if not.
+        """
+
+        if not isinstance(transcoder, dict):
+            return None, f"grpc_transcoder {transcoder} must be an object"
+
+        name = transcoder.get("descriptors", None)
+
+        if (not isinstance(name, str)) or (not name):
+            return None, "grpc_transcoder needs the name of a descriptor ConfigMap"
+
+        # ConfigMap names can have dots in them, so try the Mapping's namespace first.
+        rkey = f"{name}.{namespace}"
+
+        if (rkey not in ir.grpc_descriptor_sets) and ("." in name):
+            rkey = name
+
+        descriptor_set = ir.grpc_descriptor_sets.get(rkey, None)
+
+        if descriptor_set is None:
+            return None, f"no gRPC descriptor ConfigMap {rkey}"
+
+        versions = descriptor_set["versions"]
+        version = transcoder.get("version", None) or descriptor_set["default_version"]
+
+        if not versions:
+            return None, f"gRPC descriptor ConfigMap {rkey} has no valid versions"
+
+        if not version:
+            if len(versions) != 1:
+                return None, (
+                    f"gRPC descriptor ConfigMap {rkey} has more than one version, "
+                    "and no default; grpc_transcoder needs a version"
+                )
+
+            version = list(versions.keys())[0]
+
+        if version not in versions:
+            return None, f"gRPC descriptor ConfigMap {rkey} has no valid version {version}"
+
+        services = transcoder.get("services", None)
+
+        if (not isinstance(services, list)) or (not services):
+            return None, "grpc_transcoder needs a list of services"
+
+        missing = [svc for svc in services if svc not in versions[version]["services"]]
+
+        if missing:
+            return None, (
+                f"gRPC descriptors {rkey} version {version} have no service "
+                f"{', '.join(str(svc) for svc in missing)}"
+            )
+
+        return {"descriptors": rkey, "version": version, "services": services}, None
+
+    @classmethod
+    def descriptor_bin(cls, ir: "IR", transcoder: Dict[str, Any]) -> str:
+        """
+        Return the (base64) descriptors for a grpc_transcoder that resolve() accepted.
+        """
+
+        return ir.grpc_descriptor_sets[transcoder["descriptors"]]["versions"][
+            transcoder["version"]
+        ]["descriptor_bin"]
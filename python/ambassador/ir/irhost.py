@@ -1,7 +1,10 @@
-from typing import TYPE_CHECKING, List, Optional, Union
+from typing import TYPE_CHECKING, Any, Dict, List, Optional, Union
 
+from .. import errorcodes
 from ..config import Config
 from ..utils import SavedSecret, dump_json
+from .iridentity import identity_headers
+from .irmaintenance import maintenance_response
 from .irresource import IRResource
 from .irtlscontext import IRTLSContext
 from .irutils import disable_strict_selectors, hostglob_matches, selector_matches
@@ -15,10 +18,15 @@ class IRHost(IRResource):
     AllowedKeys = {
         "acmeProvider",
         "hostname",
+        "identityHeaders",
+        "maintenance",
         "mappingSelector",
         "metadata_labels",
+        "probe_expected_status",
         "requestPolicy",
+        "request_timeout_ms",
         "selector",
+        "telemetry",
         "tlsSecret",
         "tlsContext",
         "tls",
@@ -170,6 +178,9 @@ class IRHost(IRResource):
                             "cacertChainFile": "cacert_chain_file",
                             "crlSecret": "crl_secret",
                             "crlFile": "crl_file",
+                            "crlUrl": "crl_url",
+                            "ocspStaplePolicy": "ocsp_staple_policy",
+                            "tlsPolicy": "tls_policy",
                             "caSecret": "ca_secret",
                             # 'sni': 'sni' (this field is not required in snake-camel but adding for completeness)
                         }
@@ -350,15 +361,76 @@ class IRHost(IRResource):
                             f"continuing with invalid ACME private key secret {pkey_name}; ACME will not be able to renew this certificate"
                         )
 
+        # A bad identity header is skipped, not fatal: the rest still get stripped and set.
+        if self.get("identityHeaders", None) is not None:
+            headers, errors = identity_headers(self.identityHeaders)
+
+            for error in errors:
+                self.post_error(f"{error}; ignoring it")
+
+            self.identity_headers = headers
+
+        # Likewise a bad telemetry setting: the Host just gets the default for it.
+        if self.get("telemetry", None) is not None:
+            self.telemetry = self.check_telemetry(self.telemetry)
+
+        # A Host in maintenance answers for all of its routes (see V3Listener); one whose
+        # maintenance doesn't make sense keeps routing as usual.
+        if self.get("maintenance", None) is not None:
+            response, error = maintenance_response(ir, self.maintenance, self.namespace)
+
+            if error:
+                self.post_error(f"{error}; ignoring it")
+
+            if response:
+                self.maintenance = response
+            else:
+                self.pop("maintenance", None)
+
         ir.logger.debug(f"Host setup OK: {self}")
         return True
 
+    def check_telemetry(self, telemetry: Any) -> Dict[str, Any]:
+        if not isinstance(telemetry, dict):
+            self.post_error(f"telemetry {telemetry} must be an object; ignoring it")
+            return {}
+
+        checked: Dict[str, Any] = {}
+
+        sampling = telemetry.get("accessLogSampling", None)
+
+        if sampling is not None:
+            # bool is an int, as far as isinstance is concerned.
+            if isinstance(sampling, bool) or not isinstance(sampling, int):
+                self.post_error(
+                    f"telemetry.accessLogSampling {sampling} must be an integer; ignoring it"
+                )
+            elif (sampling < 0) or (sampling > 100):
+                self.post_error(
+                    f"telemetry.accessLogSampling {sampling} must be between 0 and 100; ignoring it"
+                )
+            else:
+                checked["accessLogSampling"] = sampling
+
+        route_stats = telemetry.get("routeStats", None)
+
+        if route_stats is not None:
+            if not isinstance(route_stats, bool):
+                self.post_error(
+                    f"telemetry.routeStats {route_stats} must be true or false; ignoring it"
+                )
+            else:
+                checked["routeStats"] = route_stats
+
+        return checked
+
     # Check a TLSContext name, and save the linked TLSContext if it'll work for us.
     def save_context(self, ir: "IR", ctx_name: str, tls_ss: SavedSecret, tls_name: str):
         # First obvious thing: does a TLSContext with the right name even exist?
         if not ir.has_tls_context(ctx_name):
             self.post_error(
-                "Host %s: Specified TLSContext does not exist: %s" % (self.name, ctx_name)
+                "Host %s: Specified TLSContext does not exist: %s" % (self.name, ctx_name),
+                code=errorcodes.MISSING_TLS_CONTEXT,
             )
             return False
 
@@ -7,10 +7,16 @@ from ambassador.utils import RichStatus
 from ..config import Config
 from .irbasemapping import IRBaseMapping, normalize_service_name
 from .irbasemappinggroup import IRBaseMappingGroup
+from .ircontractcapture import contract_capture_error
 from .ircors import IRCORS
 from .irerrorresponse import IRErrorResponse
+from .irgrpctranscoder import GRPCDescriptorFactory
 from .irhttpmappinggroup import IRHTTPMappingGroup
+from .irmaintenance import maintenance_response
+from .irpreflight import mapping_warning
 from .irretrypolicy import IRRetryPolicy
+from .irstaticresponse import static_response
+from .irupstreams import upstreams_error
 
 if TYPE_CHECKING:
     from .ir import IR  # pragma: no cover
@@ -84,13 +90,18 @@ class IRHTTPMapping(IRBaseMapping):
         "cluster_max_connection_lifetime_ms": False,
         # Do not include cluster_tag
         "connect_timeout_ms": False,
+        "contract_capture": False,
         "cors": False,
+        "critical": False,
         "docs": False,
         "dns_type": False,
         "enable_ipv4": False,
         "enable_ipv6": False,
         "error_response_overrides": False,
+        "failover": False,
+        "fallback": False,
         "grpc": False,
+        "grpc_transcoder": False,
         # Do not include headers
         # Do not include host
         # Do not include hostname
@@ -102,6 +113,7 @@ class IRHTTPMapping(IRBaseMapping):
         "keepalive": False,
         "labels": False,  # Not supported in v0; requires v1+; handled in setup
         "load_balancer": False,
+        "maintenance": False,
         "metadata_labels": False,
         # Do not include method
         "method_regex": False,
@@ -124,11 +136,16 @@ class IRHTTPMapping(IRBaseMapping):
         # Do not include rewrite
         "service": False,  # See notes above
         "shadow": False,
+        "static_response": False,
         "stats_name": True,
+        "subset_labels": False,
         "timeout_ms": False,
         "tls": False,
+        "upstream_protocol": False,
+        "upstreams": False,
         "use_websocket": False,
         "allow_upgrade": False,
+        "views": False,
         "weight": False,
         # Include the serialization, too.
         "serialization": False,
@@ -463,6 +480,138 @@ class IRHTTPMapping(IRBaseMapping):
                 )
                 return False
 
+        # subset_labels picks out endpoints by their Pods' labels, so it has to be a
+        # non-empty map of label names to values.
+        if self.get("subset_labels", None) is not None:
+            subset_labels = self["subset_labels"]
+
+            if (
+                (not isinstance(subset_labels, dict))
+                or (not subset_labels)
+                or (not all(isinstance(v, str) for v in subset_labels.values()))
+            ):
+                self.post_error(
+                    "Invalid subset_labels specified: {}, invalidating mapping".format(
+                        subset_labels
+                    )
+                )
+                return False
+
+        if self.get("failover", None) is not None:
+            if not self.validate_failover(self["failover"]):
+                self.post_error(
+                    "Invalid failover specified: {}, invalidating mapping".format(
+                        self["failover"]
+                    )
+                )
+                return False
+
+        # Upstreams have priorities of their own, so they can't be mixed with failover.
+        if self.get("upstreams", None) is not None:
+            error = upstreams_error(ir, self["upstreams"], self.get("resolver", None))
+
+            if (not error) and (self.get("failover", None) is not None):
+                error = "upstreams can't be used with failover"
+
+            if error:
+                self.post_error(
+                    "Invalid upstreams specified: {}, invalidating mapping".format(error)
+                )
+                return False
+
+        if not isinstance(self.get("fallback", False), bool):
+            self.post_error(
+                "Invalid fallback specified: {}, invalidating mapping".format(self["fallback"])
+            )
+            return False
+
+        # grpc_transcoder needs descriptors that have all the services it asks for, and
+        # only makes sense for a gRPC upstream.
+        if self.get("grpc_transcoder", None) is not None:
+            transcoder, error = GRPCDescriptorFactory.resolve(
+                ir, self["grpc_transcoder"], self.namespace
+            )
+
+            if (not error) and (not self.get("grpc", False)):
+                error = "grpc_transcoder needs grpc: true"
+
+            if error:
+                self.post_error(
+                    "Invalid grpc_transcoder specified: {}, invalidating mapping".format(error)
+                )
+                return False
+
+            self.grpc_transcoder = transcoder
+
+        if self.get("contract_capture", None) is not None:
+            error = contract_capture_error(self["contract_capture"])
+
+            if error:
+                self.post_error(
+                    "Invalid contract_capture specified: {}, invalidating mapping".format(error)
+                )
+                return False
+
+        # In maintenance, the Mapping's routes answer with what maintenance says instead of
+        # going upstream; out of maintenance, it's as if maintenance weren't there.
+        if self.get("maintenance", None) is not None:
+            response, error = maintenance_response(ir, self["maintenance"], self.namespace)
+
+            if error:
+                self.post_error(
+                    "Invalid maintenance specified: {}, invalidating mapping".format(error)
+                )
+                return False
+
+            if response:
+                self["maintenance"] = response
+            else:
+                self.pop("maintenance", None)
+
+        # With a static response, the Mapping's routes answer for themselves, too.
+        if self.get("static_response", None) is not None:
+            response, error = static_response(self["static_response"])
+
+            if not error and self.get("host_redirect", False):
+                error = "static_response can't be used with host_redirect"
+
+            if not error and self.get("shadow", False):
+                error = "static_response can't be used with shadow"
+
+            if error:
+                self.post_error(
+                    "Invalid static_response specified: {}, invalidating mapping".format(error)
+                )
+                return False
+
+            self["static_response"] = response
+
+        # critical: false only changes how Config counts this Mapping's errors (see
+        # Config.post_error), but it has to be a boolean for that.
+        if ("critical" in self) and not isinstance(self["critical"], bool):
+            self.post_error(
+                "Invalid critical specified: {}, invalidating mapping".format(
+                    repr(self["critical"])
+                )
+            )
+            return False
+
+        if self.get("views", None) is not None:
+            views = self["views"]
+
+            if (
+                (not isinstance(views, list))
+                or (not views)
+                or (not all(isinstance(v, str) and v for v in views))
+            ):
+                self.post_error(
+                    "Invalid views specified: {}, invalidating mapping".format(repr(views))
+                )
+                return False
+
+            # Mappings in a group have to agree on their views, so don't let order matter.
+            self["views"] = sorted(set(views))
+
         # All three redirect fields are mutually exclusive.
         #
         # Prefer path_redirect over the other two. If only prefix_redirect and
@@ -503,6 +652,35 @@ class IRHTTPMapping(IRBaseMapping):
 
         return is_valid
 
+    @staticmethod
+    def validate_failover(failover) -> bool:
+        if not isinstance(failover, dict):
+            return False
+
+        services = failover.get("services", None)
+
+        if (
+            (not isinstance(services, list))
+            or (not services)
+            or (not all(isinstance(svc, str) and svc for svc in services))
+        ):
+            return False
+
+        healthy_percent = failover.get("healthy_percent", None)
+
+        if healthy_percent is not None:
+            if (not isinstance(healthy_percent, int)) or not (1 <= healthy_percent <= 100):
+                return False
+
+        for key in ["consecutive_5xx", "ejection_time_ms"]:
+            value = failover.get(key, None)
+
+            if value is not None:
+                if (not isinstance(value, int)) or (value < 1):
+                    return False
+
+        return True
+
     def _group_id(self) -> str:
         # Yes, we're using a cryptographic hash here. Cope. [ :) ]
 
@@ -533,6 +711,14 @@ class IRHTTPMapping(IRBaseMapping):
         if self.precedence != 0:
             h.update(str(self.precedence).encode("utf-8"))
 
+        # Mappings in different views are never alternatives to each other. (Leave the hash
+        # alone when there are no views, so that group IDs don't change under people.)
+        views = self.get("views", None)
+
+        if isinstance(views, list):
+            for view in sorted(str(v) for v in views):
+                h.update(f"VIEW-{view}".encode("utf-8"))
+
         return h.hexdigest()
 
     def _route_weight(self) -> List[Union[str, int]]:
@@ -574,6 +760,24 @@ class IRHTTPMapping(IRBaseMapping):
 
     def status(self) -> Dict[str, str]:
         if not self.is_active():
-            return {"state": "Inactive", "reason": self.summarize_errors()}
+            status = {"state": "Inactive", "reason": self.summarize_errors()}
+
+            # The reason is for humans; the code is for anything that wants to
+            # react to the error (see errorcodes.py).
+            errors = self.ir.aconf.errors.get(self.rkey, [])
+
+            if errors and errors[0].get("code"):
+                status["code"] = errors[0]["code"]
+
+            return status
         else:
-            return {"state": "Running"}
+            status = {"state": "Running"}
+
+            # A running Mapping can still have an upstream that failed its
+            # pre-flight check (see irpreflight.py).
+            warning = mapping_warning(self)
+
+            if warning:
+                status["warning"] = warning
+
+            return status
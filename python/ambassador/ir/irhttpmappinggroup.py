@@ -1,4 +1,4 @@
-from typing import TYPE_CHECKING, Any, ClassVar, Dict, List, Optional, Tuple
+from typing import TYPE_CHECKING, Any, ClassVar, Dict, List, Optional, Set, Tuple
 from typing import cast as typecast
 
 from ambassador.utils import RichStatus
@@ -33,6 +33,7 @@ class IRHTTPMappingGroup(IRBaseMappingGroup):
         "connect_timeout_ms": True,
         "cluster_idle_timeout_ms": True,
         "cluster_max_connection_lifetime_ms": True,
+        "fallback": True,
         "group_id": True,
         "headers": True,
         # 'host_rewrite': True,
@@ -50,6 +51,7 @@ class IRHTTPMappingGroup(IRBaseMappingGroup):
         "prefix_exact": True,
         # 'rewrite': True,
         # 'timeout_ms': True
+        "views": True,
     }
 
     # We don't flatten cluster_key and stats_name because the whole point of those
@@ -86,6 +88,15 @@ class IRHTTPMappingGroup(IRBaseMappingGroup):
     def helper_shadows(res: IRResource, k: str) -> Tuple[str, List[dict]]:
         return k, list([x.as_dict() for x in res[k]])
 
+    @staticmethod
+    def helper_weighted_mappings(res: IRResource, k: str) -> Tuple[str, List[dict]]:
+        return k, list(
+            [
+                {"mapping": mapping.name, "cluster": mapping.cluster.name, "weight": weight}
+                for mapping, weight in res[k]
+            ]
+        )
+
     def __init__(
         self,
         ir: "IR",
@@ -134,6 +145,7 @@ class IRHTTPMappingGroup(IRBaseMappingGroup):
 
         self.add_dict_helper("mappings", IRHTTPMappingGroup.helper_mappings)
         self.add_dict_helper("shadows", IRHTTPMappingGroup.helper_shadows)
+        self.add_dict_helper("weighted_mappings", IRHTTPMappingGroup.helper_weighted_mappings)
 
         # Time to lift a bunch of core stuff from the first mapping up into the
         # group.
@@ -281,6 +293,7 @@ class IRHTTPMappingGroup(IRBaseMappingGroup):
                 enable_ipv4=mapping.get("enable_ipv4", None),
                 enable_ipv6=mapping.get("enable_ipv6", None),
                 grpc=mapping.get("grpc", False),
+                upstream_protocol=mapping.get("upstream_protocol", None),
                 load_balancer=mapping.get("load_balancer", None),
                 keepalive=mapping.get("keepalive", None),
                 connect_timeout_ms=mapping.get("connect_timeout_ms", 3000),
@@ -292,6 +305,9 @@ class IRHTTPMappingGroup(IRBaseMappingGroup):
                 marker=marker,
                 stats_name=mapping.get("stats_name"),
                 respect_dns_ttl=mapping.get("respect_dns_ttl", False),
+                subset_keys=sorted(mapping.get("subset_labels", None) or {}),
+                failover=mapping.get("failover", None),
+                upstreams=mapping.get("upstreams", None),
             )
 
         # Make sure that the cluster is actually in our IR...
@@ -474,3 +490,107 @@ class IRHTTPMappingGroup(IRBaseMappingGroup):
                 self["case_sensitive"] = redir["case_sensitive"]
 
             return []
+
+    def covers(self, other: "IRHTTPMappingGroup") -> bool:
+        """
+        Returns whether every request that other matches gets matched by this group, too: the
+        path match is the same, and this group's header matches (which include the host and
+        method) and query parameter matches are some of other's.
+        """
+
+        for k in ["prefix", "prefix_regex", "prefix_exact", "case_sensitive"]:
+            if self.get(k, None) != other.get(k, None):
+                return False
+
+        def matchers(group: IRResource, k: str) -> Set[Tuple[str, Optional[str], bool]]:
+            return set((m.name, m.value, bool(m.regex)) for m in (group.get(k, None) or []))
+
+        for k in ["headers", "query_parameters"]:
+            if not matchers(self, k) <= matchers(other, k):
+                return False
+
+        return True
+
+    def fallback_group(self, ir: "IR") -> Optional["IRHTTPMappingGroup"]:
+        """
+        Returns the group that gets the requests this one leaves over: the first one after it
+        in route order that matches all of its requests, which is where Envoy would send them
+        if this group weren't there.
+        """
+
+        after = False
+
+        for group in ir.ordered_groups():
+            if group is self:
+                after = True
+            elif (
+                after
+                and isinstance(group, IRHTTPMappingGroup)
+                and group.mappings
+                and not group.get("host_redirect", None)
+                and group.covers(self)
+            ):
+                return group
+
+        return None
+
+    def mapping_shares(self, ir: "IR") -> List[Tuple[IRBaseMapping, int]]:
+        """
+        Returns the share of this group's requests that each Mapping gets, in hundredths of a
+        percent, so that they add up to 10000. With fallback, what this group's own weights
+        leave over is split among the Mappings of the fallback group in the same way, and so on
+        down the line, in route order.
+        """
+
+        shares: List[Tuple[IRBaseMapping, int]] = []
+        claimed = 0
+
+        # Normalized weights are cumulative.
+        for mapping in self.mappings:
+            share = mapping._weight - claimed
+            claimed = mapping._weight
+
+            if share > 0:
+                shares.append((mapping, share * 100))
+
+        left = 10000 - (claimed * 100)
+
+        if (left > 0) and self.get("fallback", False):
+            fallback = self.fallback_group(ir)
+
+            if fallback:
+                for mapping, share in fallback.mapping_shares(ir):
+                    shares.append((mapping, (left * share) // 10000))
+            else:
+                self.post_error(
+                    "Mapping %s falls back, but no other Mapping gets its requests; its weights will be scaled up to 100"
+                    % self.mappings[0].name
+                )
+
+        # Rounding, or having nothing to fall back to, can leave a few hundredths over.
+        total = sum([share for _, share in shares])
+
+        if total and (total != 10000):
+            shares = [(mapping, (share * 10000) // total) for mapping, share in shares]
+            last_mapping, last_share = shares[-1]
+            shares[-1] = (last_mapping, last_share + 10000 - sum([s for _, s in shares]))
+
+        return shares
+
+    def resolve_fallback(self, ir: "IR") -> None:
+        """
+        Works out weighted_mappings for a group that falls back: the Mappings, from this group
+        and the ones it falls back to, that its requests are split among, and how. V3Route
+        turns them into a single route with weighted clusters, where it can.
+        """
+
+        self.pop("weighted_mappings", None)
+
+        if not all("_weight" in mapping for mapping in self.mappings):
+            # Normalizing the weights failed, and there's already an error for it.
+            return
+
+        shares = self.mapping_shares(ir)
+
+        if shares:
+            self.weighted_mappings = shares
@@ -0,0 +1,124 @@
+import re
+from typing import Any, Dict, List, Optional, Tuple
+
+#############################################################################
+## iridentity.py -- upstream headers that carry a verified identity
+##
+## A Host's identityHeaders name the upstream headers that say who the client
+## is, and where each one's value comes from:
+##
+##   clientCert:   a field of the client's (verified) TLS certificate
+##   jwtClaim:     a claim of the JWT that the AuthService verified, which the
+##                 AuthService returns in its dynamic metadata as `jwt_payload`
+##   authMetadata: any other key in the AuthService's dynamic metadata
+##
+## Every one of these headers is removed from each request to the Host before
+## it gets set, so nothing a client sends in one of them ever reaches a
+## backend. If a request doesn't have the identity (no client certificate, or
+## no such claim), Envoy leaves the header off instead of setting it empty.
+##
+## V3Listener puts all of this on the Host's virtual host, so it applies after
+## anything a Mapping does with the same headers.
+
+# Envoy's header formatters for the fields of the client's certificate.
+CLIENT_CERT_FIELDS = {
+    "uriSAN": "%DOWNSTREAM_PEER_URI_SAN%",
+    "dnsSAN": "%DOWNSTREAM_PEER_DNS_SAN%",
+    "subject": "%DOWNSTREAM_PEER_SUBJECT%",
+    "fingerprint": "%DOWNSTREAM_PEER_FINGERPRINT_256%",
+}
+
+# ext_authz puts whatever metadata the AuthService returns here.
+AUTH_METADATA_NAMESPACE = "envoy.filters.http.ext_authz"
+JWT_PAYLOAD_KEY = "jwt_payload"
+
+SOURCES = ["clientCert", "jwtClaim", "authMetadata"]
+
+# Envoy won't remove pseudo-headers or Host, so those can't be identity headers.
+HEADER_NAME_RE = re.compile(r"^[A-Za-z0-9!#$%&'*+.^_`|~-]+$")
+
+
+def auth_metadata_value(path: List[str]) -> str:
+    return "%%DYNAMIC_METADATA(%s:%s)%%" % (AUTH_METADATA_NAMESPACE, ":".join(path))
+
+
+def identity_header_value(spec: Any) -> Tuple[Optional[str], Optional[str]]:
+    """
+    Work out the Envoy header value for one of a Host's identityHeaders.
+    Returns (value, None) if the spec is OK, and (None, error) if not.
+    """
+
+    if not isinstance(spec, dict):
+        return None, f"identity header {spec} must be an object"
+
+    name = spec.get("name", None)
+
+    if (not isinstance(name, str)) or (not HEADER_NAME_RE.match(name)):
+        return None, f"identity header name {name} is not a valid header name"
+
+    if name.lower() == "host":
+        return None, f"identity header {name} cannot be the Host header"
+
+    sources = [s for s in SOURCES if spec.get(s, None)]
+
+    if len(sources) != 1:
+        return None, f"identity header {name} must have exactly one of {', '.join(SOURCES)}"
+
+    source = sources[0]
+    value = spec[source]
+
+    if not isinstance(value, str):
+        return None, f"identity header {name}: {source} must be a string"
+
+    if source == "clientCert":
+        if value not in CLIENT_CERT_FIELDS:
+            return None, (
+                f"identity header {name}: clientCert must be one of "
+                f"{', '.join(CLIENT_CERT_FIELDS.keys())}, not {value}"
+            )
+
+        return CLIENT_CERT_FIELDS[value], None
+
+    if source == "jwtClaim":
+        return auth_metadata_value([JWT_PAYLOAD_KEY, value]), None
+
+    path = value.split(".")
+
+    if not all(path):
+        return None, f"identity header {name}: authMetadata {value} has an empty key"
+
+    return auth_metadata_value(path), None
+
+
+def identity_headers(specs: Any) -> Tuple[List[Dict[str, str]], List[str]]:
+    """
+    Turn a Host's identityHeaders into a list of { "name": ..., "value": ... }
+    dicts for V3Listener, with header names in lowercase. Returns the list and
+    the errors for any specs that had to be skipped.
+    """
+
+    headers: List[Dict[str, str]] = []
+    errors: List[str] = []
+    seen = set()
+
+    if not isinstance(specs, list):
+        return headers, [f"identityHeaders must be a list, not {specs}"]
+
+    for spec in specs:
+        value, error = identity_header_value(spec)
+
+        if error:
+            errors.append(error)
+            continue
+
+        assert value is not None
+        name = spec["name"].lower()
+
+        if name in seen:
+            errors.append(f"identity header {name} is listed more than once")
+            continue
+
+        seen.add(name)
+        headers.append({"name": name, "value": value})
+
+    return headers, errors
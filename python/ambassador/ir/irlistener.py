@@ -1,10 +1,17 @@
-from typing import TYPE_CHECKING, Dict, List, Literal, Optional
+import base64
+import binascii
+import os
+import re
+from typing import TYPE_CHECKING, Any, Dict, List, Literal, Optional
 
 from ..config import Config
 from .irhost import IRHost
+from .irrequestnormalization import listener_request_normalization, module_request_normalization
 from .irresource import IRResource
 from .irtcpmappinggroup import IRTCPMappingGroup
 from .irtlscontext import IRTLSContext
+from .irtlsfingerprint import listener_tls_fingerprint
+from .irtlspolicy import valid_tls_policy
 from .irutils import selector_matches
 
 if TYPE_CHECKING:
@@ -27,18 +34,33 @@ class IRListener(IRResource):
     namespace_literal: str  # Literal namespace to be matched
     namespace_selector: Dict[str, str]  # Namespace selector
     host_selector: Dict[str, str]  # Host selector
+    request_normalization: Dict[str, Any]  # See irrequestnormalization.py
+    tls_fingerprint: Optional[Dict[str, Any]]  # See irtlsfingerprint.py
 
     AllowedKeys = {
         "bind_address",
+        "connectionBalance",
         "l7Depth",
         "hostBinding",  # Note that hostBinding gets processed and deleted in setup.
         "port",
         "protocol",
         "protocolStack",
+        "requestNormalization",
+        "reusePort",
         "securityModel",
+        "socketOptions",
         "statsPrefix",
+        "tcpBacklogSize",
+        "tlsFingerprint",
+        "tlsPolicy",
+        "view",
     }
 
+    SocketOptionStates = {"PREBIND", "BOUND", "LISTENING"}
+
+    # A view is a DNS label, like "internal" or "external"; this has to match the CRD.
+    ViewPattern = re.compile(r"^[a-z0-9]([-a-z0-9]*[a-z0-9])?$")
+
     ProtocolStacks: Dict[str, List[str]] = {
         # HTTP: accepts cleartext HTTP/1.1 sessions over TCP.
         "HTTP": ["HTTP", "TCP"],
@@ -105,6 +127,20 @@ class IRListener(IRResource):
 
         ir.logger.debug(f"Listener {self.name} setting up on {self.bind_address}:{self.port}")
 
+        # The entrypoint tells us the lowest port that Envoy can bind (see
+        # cmd/entrypoint/privports.go). Envoy would fail to bind a Listener below that and
+        # reject the update, so catch it here, where we can say why.
+        unprivileged_port_start = int(os.environ.get("AMBASSADOR_UNPRIVILEGED_PORT_START") or 0)
+        port = self.get("port", 0)
+
+        if isinstance(port, int) and (0 < port < unprivileged_port_start):
+            self.post_error(
+                f"port {self.port} is privileged, and Envoy can't bind ports below "
+                f"{unprivileged_port_start} without CAP_NET_BIND_SERVICE; use a port of "
+                f"{unprivileged_port_start} or above, or see AMBASSADOR_ENVOY_CAPABILITIES"
+            )
+            return False
+
         pstack = self.get("protocolStack", None)
         protocol = self.get("protocol", None)
         securityModel = self.get("securityModel", None)
@@ -162,6 +198,53 @@ class IRListener(IRResource):
             self.post_error("securityModel is required")
             return False
 
+        tlsPolicy = self.get("tlsPolicy", None)
+
+        if not valid_tls_policy(tlsPolicy, allow_custom=False):
+            self.post_error(f"Listener {self.name}: invalid tlsPolicy {tlsPolicy}, ignoring")
+            self.pop("tlsPolicy")
+
+        self.setup_socket_tuning()
+
+        # Request normalization is the Module's, unless this Listener overrides it.
+        module_settings = ir.ambassador_module.get("request_normalization", None)
+
+        if module_settings is None:
+            module_settings, _, _ = module_request_normalization(None)
+
+        request_normalization, errors, notices = listener_request_normalization(
+            module_settings, self.get("requestNormalization", None), self.name
+        )
+
+        for error in errors:
+            self.post_error(error)
+
+        for notice in notices:
+            ir.aconf.post_notice(notice, resource=self)
+
+        self.request_normalization = request_normalization
+
+        tls_fingerprint, errors, notices = listener_tls_fingerprint(
+            self.get("tlsFingerprint", None), self.name, self.protocolStack
+        )
+
+        for error in errors:
+            self.post_error(error)
+
+        for notice in notices:
+            ir.aconf.post_notice(notice, resource=self)
+
+        self.tls_fingerprint = tls_fingerprint
+
+        # A view splits the horizon: Mappings that list views only get routes on Listeners in
+        # one of them (see V3Listener.compute_http_routes).
+        view = self.get("view", None)
+
+        if view is not None:
+            if (not isinstance(view, str)) or (not IRListener.ViewPattern.match(view)):
+                self.post_error(f"Listener {self.name}: invalid view {view}, ignoring")
+                self.pop("view")
+
         # Deal with statsPrefix, if it's not set.
         if not self.get("statsPrefix", ""):
             # OK, we need to default the thing per the protocolStack...
@@ -237,6 +320,107 @@ class IRListener(IRResource):
 
         return True
 
+    def setup_socket_tuning(self) -> None:
+        """
+        Check the settings that tune the Listener's socket: reusePort, connectionBalance,
+        tcpBacklogSize, and socketOptions. Anything that's wrong gets an error and is
+        dropped, leaving Envoy's default, rather than taking the whole Listener down.
+        """
+
+        reusePort = self.get("reusePort", None)
+
+        if (reusePort is not None) and not isinstance(reusePort, bool):
+            self.post_error(f"Listener {self.name}: invalid reusePort {reusePort}, ignoring")
+            self.pop("reusePort")
+
+        connectionBalance = self.get("connectionBalance", None)
+
+        if connectionBalance is not None:
+            if connectionBalance != "exact":
+                self.post_error(
+                    f"Listener {self.name}: invalid connectionBalance {connectionBalance}, ignoring"
+                )
+                self.pop("connectionBalance")
+            elif self.socket_protocol != "TCP":
+                self.post_error(
+                    f"Listener {self.name}: connectionBalance is only supported for TCP, ignoring"
+                )
+                self.pop("connectionBalance")
+
+        tcpBacklogSize = self.get("tcpBacklogSize", None)
+
+        if tcpBacklogSize is not None:
+            if isinstance(tcpBacklogSize, bool) or not isinstance(tcpBacklogSize, int):
+                tcpBacklogSize = -1
+
+            if tcpBacklogSize < 1:
+                self.post_error(
+                    f"Listener {self.name}: invalid tcpBacklogSize {self.tcpBacklogSize}, ignoring"
+                )
+                self.pop("tcpBacklogSize")
+            elif self.socket_protocol != "TCP":
+                self.post_error(
+                    f"Listener {self.name}: tcpBacklogSize is only supported for TCP, ignoring"
+                )
+                self.pop("tcpBacklogSize")
+
+        socketOptions = self.get("socketOptions", None)
+
+        if socketOptions is not None:
+            if not isinstance(socketOptions, list):
+                self.post_error(f"Listener {self.name}: socketOptions must be a list, ignoring")
+                self.pop("socketOptions")
+                return
+
+            valid: List[Dict] = []
+
+            for i, option in enumerate(socketOptions):
+                error = self.socket_option_error(option)
+
+                if error:
+                    self.post_error(f"Listener {self.name}: socketOptions[{i}] {error}, ignoring")
+                else:
+                    valid.append(option)
+
+            self.socketOptions = valid
+
+    @classmethod
+    def socket_option_error(cls, option: Any) -> Optional[str]:
+        """
+        Return what's wrong with one of a Listener's socketOptions, or None if it's OK.
+        """
+
+        if not isinstance(option, dict):
+            return "must be an object"
+
+        for key in ["level", "name"]:
+            value = option.get(key, None)
+
+            if isinstance(value, bool) or not isinstance(value, int):
+                return f"needs an integer {key}"
+
+        has_int = option.get("intValue", None) is not None
+        has_buf = option.get("bufValue", None) is not None
+
+        if has_int == has_buf:
+            return "needs exactly one of intValue and bufValue"
+
+        if has_int:
+            if isinstance(option["intValue"], bool) or not isinstance(option["intValue"], int):
+                return "intValue must be an integer"
+        else:
+            try:
+                base64.b64decode(option["bufValue"], validate=True)
+            except (binascii.Error, TypeError, ValueError):
+                return "bufValue must be base64"
+
+        state = option.get("state", None)
+
+        if (state is not None) and (state not in cls.SocketOptionStates):
+            return f"has invalid state {state}"
+
+        return None
+
     def matches_host(self, host: IRHost) -> bool:
         """
         Returns True IFF this Listener wants to take the given IRHost -- meaning,
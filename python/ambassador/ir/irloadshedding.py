@@ -0,0 +1,118 @@
+import os
+from typing import Any, Dict, Optional, Tuple
+
+#############################################################################
+## irloadshedding.py -- shedding load when the pod is short of memory or CPU
+##
+## The ambassador Module's load_shedding has Envoy's overload manager turn
+## away some of the new requests, with a 503 and a Retry-After, when the pod
+## is under memory or CPU pressure, rather than letting every request slow
+## down:
+##
+##   load_shedding:
+##     max_heap_bytes: 1073741824    # memory pressure is Envoy's heap over this
+##     cpu: true                     # CPU pressure, from the entrypoint
+##     scaling_threshold: 0.9        # optional; start shedding at this pressure
+##     saturation_threshold: 0.98    # optional; shed every request at this pressure
+##     retry_after: 5                # optional; the Retry-After, in seconds; 0 leaves it out
+##
+## At least one of max_heap_bytes and cpu has to be set. Between the two
+## thresholds, the fraction of requests that gets shed goes from none to all
+## of them in proportion to the pressure, so with the defaults, a pressure of
+## 0.94 sheds half. Envoy counts the requests that it sheds in its
+## downstream_rq_overload_close stat, and how far along it is in the
+## stop_accepting_requests action's scale_percent.
+##
+## Envoy can't see the cgroup's CPU usage, so the entrypoint writes it to a
+## file every second (see cmd/entrypoint/cpupressure.go), and Envoy's
+## injected_resource monitor reads it. The overload manager is part of
+## Envoy's bootstrap config, so a change to load_shedding takes effect only
+## when Envoy restarts. See V3Bootstrap and V3Listener.
+
+DEFAULT_SCALING_THRESHOLD = 0.9
+DEFAULT_SATURATION_THRESHOLD = 0.98
+DEFAULT_RETRY_AFTER = 5
+
+
+def cpu_pressure_file() -> str:
+    # Keep this in sync with GetCPUPressureFile in cmd/entrypoint/cpupressure.go.
+    return os.environ.get(
+        "AMBASSADOR_CPU_PRESSURE_FILE",
+        os.path.join(os.environ.get("AMBASSADOR_CONFIG_BASE_DIR", "/ambassador"), "cpu_pressure"),
+    )
+
+
+def _threshold(
+    load_shedding: Dict[str, Any], name: str, default: float
+) -> Tuple[float, Optional[str]]:
+    value = load_shedding.get(name, None)
+
+    if value is None:
+        return default, None
+
+    if isinstance(value, bool) or (not isinstance(value, (int, float))) or not (0 <= value <= 1):
+        return default, f"load_shedding {name} {value} must be a number between 0 and 1"
+
+    return float(value), None
+
+
+def load_shedding_config(load_shedding: Any) -> Tuple[Optional[Dict[str, Any]], Optional[str]]:
+    """
+    Check the ambassador Module's load_shedding. Returns ({ "max_heap_bytes",
+    "cpu_pressure_file", "scaling_threshold", "saturation_threshold",
+    "retry_after" }, None), where max_heap_bytes and cpu_pressure_file are
+    None for the kinds of pressure that aren't watched, or (None, error).
+    """
+
+    if not isinstance(load_shedding, dict):
+        return None, f"load_shedding {load_shedding} must be an object"
+
+    max_heap_bytes = load_shedding.get("max_heap_bytes", None)
+
+    if (max_heap_bytes is not None) and (
+        isinstance(max_heap_bytes, bool)
+        or (not isinstance(max_heap_bytes, int))
+        or (max_heap_bytes <= 0)
+    ):
+        return None, f"load_shedding max_heap_bytes {max_heap_bytes} must be a positive integer"
+
+    cpu = load_shedding.get("cpu", False)
+
+    if not isinstance(cpu, bool):
+        return None, f"load_shedding cpu {cpu} must be true or false"
+
+    if (max_heap_bytes is None) and not cpu:
+        return None, "load_shedding needs max_heap_bytes, or cpu: true, or both"
+
+    scaling, error = _threshold(load_shedding, "scaling_threshold", DEFAULT_SCALING_THRESHOLD)
+
+    if error:
+        return None, error
+
+    saturation, error = _threshold(
+        load_shedding, "saturation_threshold", DEFAULT_SATURATION_THRESHOLD
+    )
+
+    if error:
+        return None, error
+
+    if scaling >= saturation:
+        return None, (
+            f"load_shedding scaling_threshold {scaling} must be less than "
+            f"saturation_threshold {saturation}"
+        )
+
+    retry_after = load_shedding.get("retry_after", None)
+
+    if retry_after is None:
+        retry_after = DEFAULT_RETRY_AFTER
+    elif isinstance(retry_after, bool) or (not isinstance(retry_after, int)) or (retry_after < 0):
+        return None, f"load_shedding retry_after {retry_after} must be a non-negative integer"
+
+    return {
+        "max_heap_bytes": max_heap_bytes,
+        "cpu_pressure_file": cpu_pressure_file() if cpu else None,
+        "scaling_threshold": scaling,
+        "saturation_threshold": saturation,
+        "retry_after": retry_after,
+    }, None
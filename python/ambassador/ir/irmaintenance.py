@@ -0,0 +1,135 @@
+from typing import TYPE_CHECKING, Any, Dict, Optional, Tuple
+
+if TYPE_CHECKING:
+    from .ir import IR  # pragma: no cover
+
+#############################################################################
+## irmaintenance.py -- static responses for Mappings and Hosts in maintenance
+##
+## A Mapping or Host whose maintenance is enabled answers its requests itself,
+## with a direct response, instead of routing them upstream:
+##
+##   maintenance:
+##     enabled: true
+##     status_code: 503                  # optional; the default is 503
+##     retry_after: 300                  # optional; sent as Retry-After
+##     body:                             # optional; the default is no body
+##       config_map: maintenance-pages   # the ConfigMap: name, or name.namespace
+##       key: storefront.html            # optional if the ConfigMap has only one key
+##       content_type: text/html         # optional; the default is text/html
+##
+## Bodies come from ConfigMaps labeled getambassador.io/maintenance-page (see
+## fetch/maintenancepages.py), so one page can be shared by many Mappings.
+## The entrypoint can flip `enabled` from an annotation or its admin API
+## without anyone editing the spec (see cmd/entrypoint/maintenance.go).
+##
+## A Mapping in maintenance answers with its own routes; a Host in maintenance
+## answers for every route on it, except the ACME challenge route, so that
+## certificates keep renewing. See V3Route and V3Listener.
+
+DEFAULT_STATUS = 503
+DEFAULT_CONTENT_TYPE = "text/html"
+
+# The biggest body that we'll serve; Envoy has to be told about anything over 4KiB.
+MAX_BODY_BYTES = 1024 * 1024
+
+
+def _int_field(maintenance: Dict[str, Any], name: str, low: int, high: int) -> Optional[str]:
+    value = maintenance.get(name, None)
+
+    if value is None:
+        return None
+
+    if isinstance(value, bool) or (not isinstance(value, int)) or (value < low) or (value > high):
+        return f"maintenance {name} {value} must be an integer between {low} and {high}"
+
+    return None
+
+
+def maintenance_response(
+    ir: "IR", maintenance: Any, namespace: str
+) -> Tuple[Optional[Dict[str, Any]], Optional[str]]:
+    """
+    Work out what a Mapping or Host in maintenance answers with. Returns
+    ({ "status", "body", "content_type", "retry_after" }, None) if it's in
+    maintenance, (None, None) if it isn't, and (None, error) if its
+    maintenance doesn't make sense.
+    """
+
+    if not isinstance(maintenance, dict):
+        return None, f"maintenance {maintenance} must be an object"
+
+    enabled = maintenance.get("enabled", False)
+
+    if not isinstance(enabled, bool):
+        return None, f"maintenance enabled {enabled} must be true or false"
+
+    error = _int_field(maintenance, "status_code", 200, 599) or _int_field(
+        maintenance, "retry_after", 0, 2**31 - 1
+    )
+
+    if error:
+        return None, error
+
+    # The page itself only has to be there when it's needed: a missing page shouldn't take
+    # down a Mapping that isn't in maintenance.
+    if not enabled:
+        return None, None
+
+    body_spec = maintenance.get("body", None)
+    body = ""
+    content_type = DEFAULT_CONTENT_TYPE
+
+    if body_spec is not None:
+        if not isinstance(body_spec, dict):
+            return None, f"maintenance body {body_spec} must be an object"
+
+        name = body_spec.get("config_map", None)
+
+        if (not isinstance(name, str)) or (not name):
+            return None, "maintenance body needs the name of a maintenance page ConfigMap"
+
+        pages_by_rkey = ir.aconf.get_config("maintenance_pages") or {}
+
+        # ConfigMap names can have dots in them, so try the resource's namespace first.
+        rkey = f"{name}.{namespace}"
+
+        if (rkey not in pages_by_rkey) and ("." in name):
+            rkey = name
+
+        config_map = pages_by_rkey.get(rkey, None)
+
+        if config_map is None:
+            return None, f"no maintenance page ConfigMap {rkey}"
+
+        pages = config_map.get("pages") or {}
+        key = body_spec.get("key", None)
+
+        if not key:
+            if len(pages) != 1:
+                return None, (
+                    f"maintenance page ConfigMap {rkey} doesn't have exactly one key; "
+                    "maintenance body needs a key"
+                )
+
+            key = list(pages.keys())[0]
+
+        if key not in pages:
+            return None, f"maintenance page ConfigMap {rkey} has no key {key}"
+
+        body = pages[key]
+
+        if len(body.encode("utf-8")) > MAX_BODY_BYTES:
+            return None, f"maintenance page {rkey} {key} is bigger than {MAX_BODY_BYTES} bytes"
+
+        content_type = body_spec.get("content_type", None) or DEFAULT_CONTENT_TYPE
+
+        if not isinstance(content_type, str):
+            return None, f"maintenance body content_type {content_type} must be a string"
+
+    return {
+        "status": maintenance.get("status_code", None) or DEFAULT_STATUS,
+        "body": body,
+        "content_type": content_type,
+        "retry_after": maintenance.get("retry_after", None),
+    }, None
@@ -3,6 +3,7 @@ from typing import TYPE_CHECKING, List, Optional, Type
 from ..config import Config
 from .irbasemapping import IRBaseMapping
 from .irhttpmapping import IRHTTPMapping
+from .irhttpmappinggroup import IRHTTPMappingGroup
 from .irtcpmapping import IRTCPMapping
 
 if TYPE_CHECKING:
@@ -100,4 +101,15 @@ class MappingFactory:
             group.finalize(ir, aconf)
             ir.logger.debug("IR: MappingFactory finalized group %s", group.group_id)
 
+        # Falling back takes the weights of the groups fallen back to, so it has to wait until
+        # every group is finalized.
+        for group in ir.groups.values():
+            if (
+                isinstance(group, IRHTTPMappingGroup)
+                and group.get("fallback", False)
+                and group.mappings
+                and not group.get("host_redirect", None)
+            ):
+                group.resolve_fallback(ir)
+
         ir.logger.debug("IR: MappingFactory finalized")
@@ -0,0 +1,281 @@
+## Upstream pre-flight checks.
+##
+## When AMBASSADOR_MAPPING_PREFLIGHT is set, the first time we compile a Mapping
+## (or the first time we compile it with a different service), we check, in the
+## background, that its upstream is reachable: that the hostname resolves, that
+## we can connect to it, and, if the Mapping originates TLS, that a TLS
+## handshake works. A failure doesn't stop the Mapping from running -- the
+## upstream might just not be up yet -- but it goes in the Mapping's status as
+## a warning, so that a typo'd service name shows up at apply time instead of
+## as a pile of 503s.
+##
+## Results are remembered for as long as the Mapping exists, so a Mapping is
+## only checked again when its upstream changes. The checker lives for the life
+## of the process, not of an IR: diagd sets it up with enable(), and the IR
+## looks it up with checker().
+
+import concurrent.futures
+import ipaddress
+import logging
+import os
+import socket
+import ssl
+import threading
+from dataclasses import dataclass
+from typing import TYPE_CHECKING, Callable, Dict, Iterable, Optional, Set, Tuple
+
+if TYPE_CHECKING:
+    from .irhttpmapping import IRHTTPMapping  # pragma: no cover
+
+# The resolvers whose services are plain DNS names.
+DNS_RESOLVERS = {"", "kubernetes-service", "kubernetes-endpoint"}
+
+
+@dataclass(frozen=True)
+class PreflightTarget:
+    host: str
+    port: int
+    tls: bool
+
+    def __str__(self) -> str:
+        return f"{'https' if self.tls else 'http'}://{self.host}:{self.port}"
+
+
+def target_for(
+    service: str, namespace: str, tls: bool = False, resolver: str = ""
+) -> Optional[PreflightTarget]:
+    """
+    Works out where a Mapping's service really is, the way the cluster for it
+    would: an https:// scheme (or an originating TLSContext) means TLS, the port
+    defaults to 443 or 80 to match, and a bare Kubernetes service name is
+    qualified with the Mapping's namespace. Returns None for services that
+    aren't DNS names (those of a Consul resolver, say), which can't be checked.
+    """
+    if resolver not in DNS_RESOLVERS:
+        return None
+
+    service = service.strip()
+
+    if service.lower().startswith("https://"):
+        service = service[len("https://") :]
+        tls = True
+    elif service.lower().startswith("http://"):
+        service = service[len("http://") :]
+
+    # Anything after the host and port is ignored, just as the cluster ignores it.
+    service = service.split("/", 1)[0]
+
+    if not service:
+        return None
+
+    host, port = service, 0
+
+    if service.startswith("["):
+        # [IPv6]:port
+        host, _, rest = service[1:].partition("]")
+        if rest.startswith(":"):
+            port = _port(rest[1:])
+    elif service.count(":") == 1:
+        host, portstr = service.split(":")
+        port = _port(portstr)
+
+    if port < 0:
+        return None
+
+    if not port:
+        port = 443 if tls else 80
+
+    if ("." not in host) and (not _is_ip(host)) and namespace:
+        host = f"{host}.{namespace}"
+
+    return PreflightTarget(host=host, port=port, tls=tls)
+
+
+def _port(s: str) -> int:
+    try:
+        port = int(s)
+    except ValueError:
+        return -1
+
+    return port if 0 < port < 65536 else -1
+
+
+def _is_ip(host: str) -> bool:
+    try:
+        ipaddress.ip_address(host)
+        return True
+    except ValueError:
+        return False
+
+
+def run_preflight(target: PreflightTarget, timeout: float) -> Optional[str]:
+    """
+    Checks that target resolves, accepts a connection, and, if it's TLS,
+    finishes a TLS handshake. Returns what went wrong, or None if nothing did.
+    The certificate isn't verified: that's the TLSContext's business, and a
+    self-signed upstream is fine.
+    """
+    try:
+        socket.getaddrinfo(target.host, target.port, type=socket.SOCK_STREAM)
+    except (socket.gaierror, UnicodeError) as e:
+        return f"{target.host} does not resolve: {e}"
+
+    try:
+        sock = socket.create_connection((target.host, target.port), timeout=timeout)
+    except OSError as e:
+        return f"could not connect to {target.host}:{target.port}: {e}"
+
+    with sock:
+        if target.tls:
+            ctx = ssl.create_default_context()
+            ctx.check_hostname = False
+            ctx.verify_mode = ssl.CERT_NONE
+
+            try:
+                server_hostname = None if _is_ip(target.host) else target.host
+
+                with ctx.wrap_socket(sock, server_hostname=server_hostname):
+                    pass
+            except (ssl.SSLError, OSError) as e:
+                return f"TLS handshake with {target.host}:{target.port} failed: {e}"
+
+    return None
+
+
+# Results are kept by the Mapping's name and namespace, and the target checked.
+PreflightKey = Tuple[str, str, PreflightTarget]
+
+
+class PreflightChecker:
+    """
+    Runs pre-flight checks in the background, and remembers their results.
+    on_result is called, from a worker thread, with the Mapping's name and
+    namespace and the warning, whenever a check finds something wrong.
+    """
+
+    def __init__(
+        self,
+        logger: logging.Logger,
+        timeout: float = 3.0,
+        on_result: Optional[Callable[[str, str, str], None]] = None,
+        executor: Optional[concurrent.futures.Executor] = None,
+    ) -> None:
+        self.logger = logger
+        self.timeout = timeout
+        self.on_result = on_result
+        self.executor = executor or concurrent.futures.ThreadPoolExecutor(
+            max_workers=4, thread_name_prefix="preflight"
+        )
+
+        self.lock = threading.Lock()
+        self.results: Dict[PreflightKey, Optional[str]] = {}
+        self.pending: Set[PreflightKey] = set()
+
+    def warning(self, name: str, namespace: str, target: PreflightTarget) -> Optional[str]:
+        """
+        Returns the warning for a Mapping's upstream, if its check has finished
+        and found something wrong. If it hasn't been checked yet, this starts
+        the check and returns None.
+        """
+        key = (name, namespace, target)
+
+        with self.lock:
+            if key in self.results:
+                result = self.results[key]
+                return self._warning(target, result) if result else None
+
+            if key in self.pending:
+                return None
+
+            # A Mapping whose service changed gets checked again; forget the old result.
+            for old in [k for k in self.results if k[:2] == key[:2]]:
+                del self.results[old]
+
+            self.pending.add(key)
+
+        self.executor.submit(self._check, key)
+        return None
+
+    def _check(self, key: PreflightKey) -> None:
+        name, namespace, target = key
+
+        try:
+            result = run_preflight(target, self.timeout)
+        except Exception as e:
+            result = f"check failed: {e}"
+
+        with self.lock:
+            self.pending.discard(key)
+            self.results[key] = result
+
+        if result:
+            self.logger.warning(
+                f"Mapping {name}.{namespace}: pre-flight check of {target}: {result}"
+            )
+
+            if self.on_result:
+                self.on_result(name, namespace, self._warning(target, result))
+        else:
+            self.logger.debug(f"Mapping {name}.{namespace}: pre-flight check of {target} OK")
+
+    @staticmethod
+    def _warning(target: PreflightTarget, result: str) -> str:
+        return f"pre-flight check of {target} failed: {result}"
+
+    def retain(self, live: Iterable[Tuple[str, str]]) -> None:
+        """
+        Forgets the results for every Mapping that isn't live.
+        """
+        keep = set(live)
+
+        with self.lock:
+            for key in [k for k in self.results if k[:2] not in keep]:
+                del self.results[key]
+
+
+_checker: Optional[PreflightChecker] = None
+
+
+def preflight_enabled() -> bool:
+    return os.environ.get("AMBASSADOR_MAPPING_PREFLIGHT", "").lower() in ("true", "1", "yes")
+
+
+def preflight_timeout() -> float:
+    try:
+        return float(os.environ.get("AMBASSADOR_MAPPING_PREFLIGHT_TIMEOUT", "3"))
+    except ValueError:
+        return 3.0
+
+
+def enable(checker: Optional[PreflightChecker]) -> None:
+    global _checker
+    _checker = checker
+
+
+def checker() -> Optional[PreflightChecker]:
+    return _checker
+
+
+def mapping_warning(mapping: "IRHTTPMapping") -> Optional[str]:
+    """
+    Returns the pre-flight warning for a Mapping, if checks are on and its
+    upstream has failed one.
+    """
+    c = checker()
+
+    # Only Mappings that are CRDs have a status to put the warning in.
+    if not c or not mapping.get_label("ambassador_crd"):
+        return None
+
+    target = target_for(
+        mapping.get("service", ""),
+        mapping.get("namespace", ""),
+        tls=bool(mapping.get("tls", None)),
+        resolver=mapping.get("resolver", "") or "",
+    )
+
+    if not target:
+        return None
+
+    # Strip off any namespace in the name, as diagd does when it posts status.
+    return c.warning(mapping.name.split(".", 1)[0], mapping.get("namespace", ""), target)
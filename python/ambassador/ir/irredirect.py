@@ -0,0 +1,344 @@
+import re
+from typing import TYPE_CHECKING, Any, Dict, List, Optional, Set, Tuple
+
+from .. import errorcodes
+from ..config import Config
+from .iregress import HOSTNAME_RE
+from .irresource import IRResource
+
+if TYPE_CHECKING:
+    from .ir import IR  # pragma: no cover
+
+#############################################################################
+## irredirect.py -- redirects without Mappings
+##
+## A Redirect answers requests for some of Ambassador's Hosts with a
+## redirect, without a Mapping or a service behind it:
+##
+##   host_regex: "^(.+)\.example\.org$"   # optional; the default is every Host
+##   path_regex: "^/blog/(.*)$"           # optional; the default is every path
+##   host_rewrite: "\1.example.com"       # optional; the default is the same host
+##   path_rewrite: "/posts/\1"            # optional; the default is the same path
+##   scheme: https                        # optional; the default is the same scheme
+##   strip_port: true                     # optional
+##   status_code: 308                     # optional; the default is 301
+##   precedence: 10                       # optional; the default is 0
+##
+## The path is matched and rewritten by Envoy, for every request. The host
+## is different: Envoy can't rewrite a host with a regex, but every Host has
+## a hostname that we know already, so we match host_regex against each
+## Host's hostname here, and work out host_rewrite for it once. That means
+## that host_regex can't match a Host with a wildcard hostname.
+##
+## Envoy leaves the port out of a redirect to a new host, so strip_port only
+## has anything to do when the host stays the same, and then we redirect to
+## the Host's own hostname. That can't work for a wildcard hostname either.
+##
+## A Host's Redirects go ahead of all of its Mappings, highest precedence
+## first, then in namespace and name order. Two Redirects with the same
+## path_regex on the same Host are a conflict if they have the same
+## precedence; either way, only the first of them gets a route.
+##
+## RedirectFactory puts what each Host needs in ir.redirects, and
+## V3Route.for_redirect turns that into Envoy routes.
+
+VALID_STATUS_CODES = [301, 302, 303, 307, 308]
+DEFAULT_STATUS_CODE = 301
+
+# A hostname with an optional port, which is what host_rewrite has to come out as.
+HOST_PORT_RE = re.compile(r"^(?P<host>[^:]+)(:(?P<port>[0-9]{1,5}))?$")
+
+# The capture group references in a rewrite: \1 through \9.
+GROUP_REF_RE = re.compile(r"\\([0-9])")
+
+
+class IRRedirect(IRResource):
+    AllowedKeys = {
+        "host_regex",
+        "path_regex",
+        "host_rewrite",
+        "path_rewrite",
+        "scheme",
+        "strip_port",
+        "status_code",
+        "precedence",
+    }
+
+    host_regex: Optional[str]
+    path_regex: Optional[str]
+    host_rewrite: Optional[str]
+    path_rewrite: Optional[str]
+    scheme: Optional[str]
+    strip_port: bool
+    status_code: int
+    precedence: int
+
+    def __init__(
+        self,
+        ir: "IR",
+        aconf: Config,
+        rkey: str,  # REQUIRED
+        name: str,  # REQUIRED
+        location: str,  # REQUIRED
+        namespace: Optional[str] = None,
+        kind: str = "IRRedirect",
+        apiVersion: str = "getambassador.io/v3alpha1",
+        **kwargs,
+    ) -> None:
+        new_args = {x: kwargs[x] for x in kwargs.keys() if x in IRRedirect.AllowedKeys}
+
+        super().__init__(
+            ir=ir,
+            aconf=aconf,
+            rkey=rkey,
+            location=location,
+            kind=kind,
+            name=name,
+            namespace=namespace,
+            apiVersion=apiVersion,
+            **new_args,
+        )
+
+    def setup(self, ir: "IR", aconf: Config) -> bool:
+        host_regex, host_groups, error = self.compile_regex("host_regex")
+
+        if error:
+            self.post_error(f"Redirect {self.name}: {error}")
+            return False
+
+        path_regex, path_groups, error = self.compile_regex("path_regex")
+
+        if error:
+            self.post_error(f"Redirect {self.name}: {error}")
+            return False
+
+        self.host_regex = host_regex.pattern if host_regex else None
+        self.path_regex = path_regex.pattern if path_regex else None
+
+        for what, regex, groups in [
+            ("host_rewrite", "host_regex", host_groups),
+            ("path_rewrite", "path_regex", path_groups),
+        ]:
+            rewrite = self.get(what, None) or None
+
+            if rewrite is None:
+                self[what] = None
+                continue
+
+            if not isinstance(rewrite, str):
+                self.post_error(f"Redirect {self.name}: {what} {rewrite} must be a string")
+                return False
+
+            for ref in GROUP_REF_RE.findall(rewrite):
+                if int(ref) > groups:
+                    self.post_error(
+                        f"Redirect {self.name}: {what} uses \\{ref}, but {regex} only has "
+                        f"{groups} capture group(s)"
+                    )
+                    return False
+
+            self[what] = rewrite
+
+        if self.path_rewrite and not self.path_regex:
+            self.post_error(f"Redirect {self.name}: path_rewrite needs a path_regex")
+            return False
+
+        scheme = self.get("scheme", None) or None
+
+        if scheme not in (None, "http", "https"):
+            self.post_error(f"Redirect {self.name}: scheme {scheme} must be http or https")
+            return False
+
+        self.scheme = scheme
+
+        strip_port = self.get("strip_port", False)
+
+        if not isinstance(strip_port, bool):
+            self.post_error(f"Redirect {self.name}: strip_port must be true or false")
+            return False
+
+        self.strip_port = strip_port
+
+        status_code = self.get("status_code", None) or DEFAULT_STATUS_CODE
+
+        if status_code not in VALID_STATUS_CODES:
+            self.post_error(
+                f"Redirect {self.name}: status_code {status_code} must be one of {VALID_STATUS_CODES}"
+            )
+            return False
+
+        self.status_code = status_code
+
+        precedence = self.get("precedence", None) or 0
+
+        if isinstance(precedence, bool) or not isinstance(precedence, int):
+            self.post_error(f"Redirect {self.name}: precedence {precedence} must be an integer")
+            return False
+
+        self.precedence = precedence
+
+        if not (self.host_rewrite or self.path_rewrite or self.scheme or self.strip_port):
+            self.post_error(
+                f"Redirect {self.name}: needs at least one of host_rewrite, path_rewrite, "
+                "scheme, or strip_port, or it would redirect requests to where they already are"
+            )
+            return False
+
+        return True
+
+    def compile_regex(self, what: str) -> Tuple[Optional["re.Pattern[str]"], int, Optional[str]]:
+        """
+        Compile one of the Redirect's regexes, if it has it. Returns the
+        compiled regex (or None), how many capture groups it has, and an error,
+        if it doesn't compile.
+        """
+
+        regex = self.get(what, None) or None
+
+        if regex is None:
+            return None, 0, None
+
+        if not isinstance(regex, str):
+            return None, 0, f"{what} {regex} must be a string"
+
+        try:
+            compiled = re.compile(regex)
+        except re.error as e:
+            return None, 0, f"{what} {regex} is not a valid regular expression: {e}"
+
+        return compiled, compiled.groups, None
+
+    def target_for(self, hostname: str) -> Tuple[bool, Optional[Dict[str, Any]], Optional[str]]:
+        """
+        Work out what this Redirect does for a Host with the given hostname.
+        Returns (False, None, None) if the Redirect doesn't apply to the Host,
+        and otherwise either (True, { "name", "path_regex", "path_rewrite",
+        "host_redirect", "scheme", "status_code" }, None) or (True, None,
+        error) if it can't do what it's asked to for the Host.
+        """
+
+        wildcard = "*" in hostname
+        # The hostname of a Host can have a port on it; the Redirect doesn't care about that.
+        bare_hostname = HOST_PORT_RE.sub(r"\g<host>", hostname).lower()
+        host_redirect = None
+
+        if self.host_regex:
+            if wildcard:
+                return False, None, None
+
+            match = re.fullmatch(self.host_regex, bare_hostname)
+
+            if not match:
+                return False, None, None
+
+            if self.host_rewrite:
+                host_redirect = GROUP_REF_RE.sub(
+                    lambda ref: match.group(int(ref.group(1))) or "", self.host_rewrite
+                )
+        elif self.host_rewrite:
+            host_redirect = self.host_rewrite
+
+        if host_redirect is not None:
+            parts = HOST_PORT_RE.match(host_redirect)
+
+            if (
+                (not parts)
+                or (not HOSTNAME_RE.match(parts.group("host")))
+                or (parts.group("port") and not (1 <= int(parts.group("port")) <= 65535))
+            ):
+                return (
+                    True,
+                    None,
+                    f"host_rewrite gives {host_redirect!r} for Host {hostname}, which is not a valid host",
+                )
+        elif self.strip_port:
+            # Redirecting to the Host's own hostname leaves the port out.
+            if wildcard:
+                return (
+                    True,
+                    None,
+                    f"strip_port can't work without host_rewrite for Host {hostname}, which is a wildcard",
+                )
+
+            host_redirect = bare_hostname
+
+        return (
+            True,
+            {
+                "name": self.name,
+                "namespace": self.namespace,
+                "path_regex": self.path_regex,
+                "path_rewrite": self.path_rewrite,
+                "host_redirect": host_redirect,
+                "scheme": self.scheme,
+                "status_code": self.status_code,
+            },
+            None,
+        )
+
+
+class RedirectFactory:
+    @classmethod
+    def load_all(cls, ir: "IR", aconf: Config) -> None:
+        configs = aconf.get_config("redirects")
+
+        if not configs:
+            return
+
+        redirects: List[IRRedirect] = []
+
+        # Go in name order, then sort by precedence, so that ties go in name order.
+        for config in sorted(configs.values(), key=lambda c: (c.get("namespace", ""), c.name)):
+            redirect = IRRedirect(ir, aconf, **config)
+
+            if redirect.is_active():
+                redirect.referenced_by(config)
+                ir.save_resource(redirect)
+                redirects.append(redirect)
+
+        redirects.sort(key=lambda r: -r.precedence)
+
+        # Errors about a Redirect can turn up for more than one Host; only say each once.
+        posted: Set[str] = set()
+
+        def post_once(redirect: IRRedirect, error: str, **kwargs) -> None:
+            if error not in posted:
+                posted.add(error)
+                redirect.post_error(error, **kwargs)
+
+        for hostname in sorted({host.hostname for host in ir.hosts.values()}):
+            # The key for winners is the path_regex, which is None for every path.
+            winners: Dict[Optional[str], IRRedirect] = {}
+            routes: List[Dict[str, Any]] = []
+
+            for redirect in redirects:
+                applies, target, error = redirect.target_for(hostname)
+
+                if not applies:
+                    continue
+
+                if error:
+                    post_once(redirect, f"Redirect {redirect.name}: {error}; ignoring it there")
+                    continue
+
+                assert target is not None
+
+                winner = winners.get(redirect.path_regex, None)
+
+                if winner:
+                    if winner.precedence == redirect.precedence:
+                        post_once(
+                            redirect,
+                            f"Redirect {redirect.name}: matches the same requests as Redirect "
+                            f"{winner.name}, with the same precedence, for Host {hostname}; "
+                            f"Redirect {winner.name} wins",
+                            code=errorcodes.REDIRECT_CONFLICT,
+                        )
+
+                    continue
+
+                winners[redirect.path_regex] = redirect
+                routes.append(target)
+
+            if routes:
+                ir.redirects[hostname] = routes
@@ -0,0 +1,171 @@
+from typing import Any, Dict, List, Tuple
+
+from ..utils import parse_bool
+
+#############################################################################
+## irrequestnormalization.py -- how Envoy normalizes requests before routing
+##
+## Routing on one spelling of a path while the upstream sees another is how
+## path-confusion bugs happen: /public/../admin, /admin//secret, and
+## /public%2F..%2Fadmin can all get past a route that only meant to let
+## through /public. The ambassador Module's request_normalization says what
+## Envoy does about that, for every Listener:
+##
+##   request_normalization:
+##     normalize_path: true                     # resolve . and .. (the default)
+##     merge_slashes: true                      # turn // into / (off by default)
+##     escaped_slashes_action: REJECT_REQUEST   # %2F and %5C: KEEP_UNCHANGED (the
+##                                              # default), REJECT_REQUEST,
+##                                              # UNESCAPE_AND_REDIRECT, UNESCAPE_AND_FORWARD
+##     headers_with_underscores_action: DROP_HEADER   # ALLOW (the default),
+##                                                    # REJECT_REQUEST, DROP_HEADER
+##
+## and a Listener's requestNormalization (normalizePath, mergeSlashes,
+## escapedSlashesAction, headersWithUnderscoresAction) overrides it for that
+## Listener. Anything that request_normalization doesn't set comes from the
+## older Module settings, merge_slashes, reject_requests_with_escaped_slashes,
+## and headers_with_underscores_action, so they keep working.
+##
+## Turning normalize_path off, or having a Listener do less than the Module
+## asks for, still works, but gets a notice, since it reopens the door.
+
+# The settings, by their Module names, with their Listener names.
+LISTENER_KEYS = {
+    "normalize_path": "normalizePath",
+    "merge_slashes": "mergeSlashes",
+    "escaped_slashes_action": "escapedSlashesAction",
+    "headers_with_underscores_action": "headersWithUnderscoresAction",
+}
+
+# The actions, weakest first; None is Envoy's default, which is the weakest.
+ESCAPED_SLASHES_ACTIONS = [
+    "KEEP_UNCHANGED",
+    "UNESCAPE_AND_FORWARD",
+    "UNESCAPE_AND_REDIRECT",
+    "REJECT_REQUEST",
+]
+
+HEADERS_WITH_UNDERSCORES_ACTIONS = ["ALLOW", "DROP_HEADER", "REJECT_REQUEST"]
+
+ACTIONS = {
+    "escaped_slashes_action": ESCAPED_SLASHES_ACTIONS,
+    "headers_with_underscores_action": HEADERS_WITH_UNDERSCORES_ACTIONS,
+}
+
+
+def _strength(key: str, value: Any) -> int:
+    if key in ACTIONS:
+        return ACTIONS[key].index(value) if value in ACTIONS[key] else 0
+
+    return 1 if value else 0
+
+
+def _check(
+    settings: Dict[str, Any], overrides: Dict[str, Any], names: Dict[str, str], what: str
+) -> List[str]:
+    """
+    Apply the overrides that are valid to settings, returning errors for the rest. names maps
+    each setting to what overrides calls it.
+    """
+
+    errors: List[str] = []
+
+    for key, name in names.items():
+        value = overrides.get(name, None)
+
+        if value is None:
+            continue
+
+        if key in ACTIONS:
+            if value not in ACTIONS[key]:
+                errors.append(
+                    f"{what} {name} {value} must be one of {', '.join(ACTIONS[key])}, ignoring"
+                )
+                continue
+        elif not isinstance(value, bool):
+            errors.append(f"{what} {name} {value} must be true or false, ignoring")
+            continue
+
+        settings[key] = value
+
+    return errors
+
+
+def module_request_normalization(amod: Any) -> Tuple[Dict[str, Any], List[str], List[str]]:
+    """
+    Work out the request normalization for the ambassador Module (which may be None). Returns
+    ({ "normalize_path", "merge_slashes", "escaped_slashes_action",
+    "headers_with_underscores_action" }, errors, notices). The actions are None to leave
+    Envoy's default.
+    """
+
+    settings: Dict[str, Any] = {
+        "normalize_path": True,
+        "merge_slashes": False,
+        "escaped_slashes_action": None,
+        "headers_with_underscores_action": None,
+    }
+
+    if not amod:
+        return settings, [], []
+
+    settings["merge_slashes"] = parse_bool(amod.get("merge_slashes", False))
+
+    if parse_bool(amod.get("reject_requests_with_escaped_slashes", False)):
+        settings["escaped_slashes_action"] = "REJECT_REQUEST"
+
+    # headers_with_underscores_action has always gone straight to Envoy, which checks it.
+    settings["headers_with_underscores_action"] = amod.get("headers_with_underscores_action", None)
+
+    overrides = amod.get("request_normalization", None)
+
+    if overrides is None:
+        return settings, [], []
+
+    if not isinstance(overrides, dict):
+        return settings, [f"request_normalization {overrides} must be an object, ignoring"], []
+
+    errors = _check(
+        settings, overrides, {key: key for key in LISTENER_KEYS}, "request_normalization"
+    )
+    notices: List[str] = []
+
+    if not settings["normalize_path"]:
+        notices.append(
+            "request_normalization normalize_path is false: Envoy will route on paths with . and "
+            ".. in them, which upstreams may resolve differently"
+        )
+
+    return settings, errors, notices
+
+
+def listener_request_normalization(
+    module_settings: Dict[str, Any], overrides: Any, listener: str
+) -> Tuple[Dict[str, Any], List[str], List[str]]:
+    """
+    Work out the request normalization for a Listener, from the Module's and the Listener's
+    requestNormalization (which may be None). Returns (settings, errors, notices), like
+    module_request_normalization.
+    """
+
+    settings = dict(module_settings)
+
+    if overrides is None:
+        return settings, [], []
+
+    what = f"Listener {listener}: requestNormalization"
+
+    if not isinstance(overrides, dict):
+        return settings, [f"{what} must be an object, ignoring"], []
+
+    errors = _check(settings, overrides, LISTENER_KEYS, what)
+    notices: List[str] = []
+
+    for key, name in LISTENER_KEYS.items():
+        if _strength(key, settings[key]) < _strength(key, module_settings[key]):
+            notices.append(
+                f"{what} {name} {settings[key]} is weaker than the ambassador Module's "
+                f"{module_settings[key]}"
+            )
+
+    return settings, errors, notices
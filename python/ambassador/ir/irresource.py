@@ -1,8 +1,10 @@
 import copy
 import logging
+import time
 from typing import TYPE_CHECKING, Any, Dict, List, Optional, Tuple, Union
 
 from ..config import Config
+from ..errorcodes import ErrorCode
 from ..resource import Resource
 from ..utils import RichStatus
 
@@ -78,8 +80,10 @@ class IRResource(Resource):
         # ...and start with an empty cache key...
         self._cache_key = None
 
-        # ...before we override it with the setup results.
+        # ...before we override it with the setup results, noting how long setup takes.
+        start = time.perf_counter()
         self.set_active(self.setup(ir, aconf))
+        ir.record_resource_timing(self, time.perf_counter() - start)
 
     # XXX WTFO, I hear you cry. Why is this "type: ignore here?" So here's the deal:
     # mypy doesn't like it if you override just the getter of a property that has a
@@ -201,13 +205,18 @@ class IRResource(Resource):
         # If you don't override add_mappings, uh, no mappings will get added.
         pass
 
-    def post_error(self, error: Union[str, RichStatus], log_level=logging.INFO):
+    def post_error(
+        self,
+        error: Union[str, RichStatus],
+        log_level=logging.INFO,
+        code: Optional[ErrorCode] = None,
+    ):
         self._errored = True
 
         if not self.ir:
             raise Exception("post_error cannot be called before __init__")
 
-        self.ir.post_error(error, resource=self, log_level=log_level)
+        self.ir.post_error(error, resource=self, log_level=log_level, code=code)
 
     def skip_key(self, k: str) -> bool:
         if k.startswith("__") or k.startswith("_IRResource__"):
@@ -20,7 +20,7 @@ if TYPE_CHECKING:
 ## When you create an AConf, you must hand in Service objects and Resolver
 ## objects. (This will generally happen by virtue of the ResourceFetcher
 ## finding them someplace.) There can be multiple kinds of Resolver objects
-## (e.g. ConsulResolver, KubernetesEndpointResolver, etc.).
+## (e.g. ConsulResolver, KubernetesEndpointResolver, PluginResolver, etc.).
 ##
 ## When you create an IR from that AConf, the various kinds of Resolvers
 ## all get turned into IRServiceResolvers, and the IR uses those to handle
@@ -57,6 +57,12 @@ class IRServiceResolver(IRResource):
             self.resolve_with = "k8s"
         elif self.kind == "KubernetesEndpointResolver":
             self.resolve_with = "k8s"
+        elif self.kind == "PluginResolver":
+            self.resolve_with = "plugin"
+
+            if not self.get("plugin"):
+                self.post_error("PluginResolver is required to have a plugin")
+                return False
         else:
             self.post_error(f"Resolver kind {self.kind} unknown")
             return False
@@ -68,6 +74,7 @@ class IRServiceResolver(IRResource):
             "KubernetesServiceResolver": self._k8s_svc_valid_mapping,
             "KubernetesEndpointResolver": self._k8s_valid_mapping,
             "ConsulResolver": self._consul_valid_mapping,
+            "PluginResolver": self._plugin_valid_mapping,
         }[self.kind]
 
         return fn(ir, mapping)
@@ -105,13 +112,72 @@ class IRServiceResolver(IRResource):
 
         return valid
 
+    def _plugin_valid_mapping(self, ir: "IR", mapping: "IRBaseMapping"):
+        # The plugin gets the service exactly as the Mapping spells it, and it's up to the plugin
+        # to decide what's valid.
+        return True
+
+    def external_name(self, ir: "IR", svc_name: str, svc_namespace: str) -> Optional[str]:
+        # An ExternalName Service has no Endpoints, and the cluster's DNS only hands out a
+        # CNAME for it, so the Kubernetes resolvers both send its traffic straight to the
+        # name it points at. Envoy can then follow the target as it changes, and originate
+        # TLS with the target's name for SNI, rather than the Service's.
+        if self.resolve_with != "k8s" or is_ip_address(svc_name):
+            return None
+
+        svc, namespace = self.parse_service(ir, svc_name, svc_namespace)
+        service = ir.services.get(f"k8s-{svc}-{namespace}")
+
+        if not service:
+            return None
+
+        return service.get("external_name", None)
+
+    def app_protocol(
+        self, ir: "IR", svc_name: str, svc_namespace: str, port: int
+    ) -> Optional[str]:
+        # The appProtocol of the Service's port, if it has one, says what the upstream
+        # speaks there. Only the Kubernetes resolvers know which Service a Mapping means.
+        if self.resolve_with != "k8s" or is_ip_address(svc_name):
+            return None
+
+        svc, namespace = self.parse_service(ir, svc_name, svc_namespace)
+        service = ir.services.get(f"k8s-{svc}-{namespace}")
+
+        if not service:
+            return None
+
+        return service.get("app_protocols", {}).get(str(port), None)
+
+    def k8s_service(
+        self, ir: "IR", svc_name: str, svc_namespace: str
+    ) -> Optional[Tuple[str, str]]:
+        # The (name, namespace) of the Kubernetes Service that svc_name means, if there really
+        # is one. A hostname like api.example.com parses as service api in namespace example,
+        # so we only believe that if we've actually seen that Service.
+        if self.resolve_with != "k8s" or is_ip_address(svc_name):
+            return None
+
+        svc, namespace = self.parse_service(ir, svc_name, svc_namespace)
+
+        if not ir.services.get(f"k8s-{svc}-{namespace}"):
+            return None
+
+        return svc, namespace
+
     def resolve(
         self, ir: "IR", cluster: "IRCluster", svc_name: str, svc_namespace: str, port: int
     ) -> Optional[SvcEndpointSet]:
+        ext_name = self.external_name(ir, svc_name, svc_namespace)
+
+        if ext_name:
+            return [{"ip": ext_name, "port": port, "target_kind": "DNSname"}]
+
         fn = {
             "KubernetesServiceResolver": self._k8s_svc_resolver,
             "KubernetesEndpointResolver": self._k8s_resolver,
             "ConsulResolver": self._consul_resolver,
+            "PluginResolver": self._plugin_resolver,
         }[self.kind]
 
         return fn(ir, cluster, svc_name, svc_namespace, port)
@@ -166,6 +232,13 @@ class IRServiceResolver(IRResource):
 
         return self.get_endpoints(ir, f"consul-{svc_name}-{self.datacenter}", None)
 
+    def _plugin_resolver(
+        self, ir: "IR", cluster: "IRCluster", svc_name: str, svc_namespace: str, port: int
+    ) -> Optional[SvcEndpointSet]:
+        # Plugin endpoints go straight from the plugin to ambex, and never show up in the
+        # snapshot, so there's nothing for us to find here: EDS takes care of it.
+        return None
+
     def get_endpoints(self, ir: "IR", key: str, port: Optional[int]) -> Optional[SvcEndpointSet]:
         # OK. Do we have a Service by this key?
         service = ir.services.get(key)
@@ -208,10 +281,23 @@ class IRServiceResolver(IRResource):
     def clustermap_entry(
         self, ir: "IR", cluster: "IRCluster", svc_name: str, svc_namespace: str, port: int
     ) -> ClustermapEntry:
+        ext_name = self.external_name(ir, svc_name, svc_namespace)
+
+        if ext_name:
+            svc, namespace = self.parse_service(ir, svc_name, svc_namespace)
+            return {
+                "service": svc,
+                "namespace": namespace,
+                "port": port,
+                "kind": "KubernetesServiceResolver",
+                "external_name": ext_name,
+            }
+
         fn = {
             "KubernetesServiceResolver": self._k8s_svc_clustermap_entry,
             "KubernetesEndpointResolver": self._k8s_clustermap_entry,
             "ConsulResolver": self._consul_clustermap_entry,
+            "PluginResolver": self._plugin_clustermap_entry,
         }[self.kind]
 
         return fn(ir, cluster, svc_name, svc_namespace, port)
@@ -271,6 +357,26 @@ class IRServiceResolver(IRResource):
             "endpoint_path": "consul/%s/%s" % (self.datacenter, svc_name),
         }
 
+    def _plugin_clustermap_entry(
+        self, ir: "IR", cluster: "IRCluster", svc_name: str, svc_namespace: str, port: int
+    ) -> ClustermapEntry:
+        # Fallback to the KubernetesServiceResolver for ip addresses.
+        if is_ip_address(svc_name):
+            return {
+                "service": svc_name,
+                "namespace": svc_namespace,
+                "port": port,
+                "kind": "KubernetesServiceResolver",
+            }
+
+        # The entrypoint keys plugin endpoints by resolver name and service, just like Consul's
+        # are keyed by datacenter and service.
+        return {
+            "service": svc_name,
+            "kind": self.kind,
+            "endpoint_path": "plugin/%s/%s" % (self.name, svc_name),
+        }
+
 
 class IRServiceResolverFactory:
     @classmethod
@@ -0,0 +1,117 @@
+import re
+from typing import Any, Dict, Optional, Tuple
+
+from .irerrorresponse import ALLOWED_ENVOY_FMT_TOKENS, ENVOY_FMT_TOKEN_REGEX
+
+#############################################################################
+## irstaticresponse.py -- Mappings that answer for themselves
+##
+## A Mapping with a static_response has Envoy answer its requests directly,
+## with a direct response, instead of routing them to its service, so that
+## health check stubs, robots.txt, and redirects don't need anything behind
+## them:
+##
+##   static_response:
+##     status_code: 301                  # optional; the default is 200
+##     headers:                          # optional
+##       location: "https://www.example.com%REQ(:path)%"
+##     body: "Moved"                     # optional; the default is no body
+##     content_type: text/plain          # optional; the default is text/plain
+##     templated: true                   # optional; see below
+##
+## Without templated, the body and headers are sent exactly as written. With
+## it, they can use Envoy's command operators to put attributes of the
+## request in the response, the same ones that error_response_overrides can
+## use. Envoy formats header values itself; the body goes through the
+## response_map filter, so a templated body replaces any
+## error_response_overrides for the Mapping. A static response that isn't
+## templated bypasses the Ambassador Module's error_response_overrides, so
+## that it's always what was asked for.
+##
+## The Mapping's service still has to be given, but nothing has to answer
+## there. See V3Route.answer_with_static_response.
+
+DEFAULT_STATUS = 200
+DEFAULT_CONTENT_TYPE = "text/plain"
+
+# The biggest body that we'll serve; Envoy has to be told about anything over 4KiB.
+MAX_BODY_BYTES = 64 * 1024
+
+# What Envoy lets a route's response_headers_to_add set.
+HEADER_NAME_REGEX = re.compile(r"^[A-Za-z0-9!#$&'*+.^_`|~-]+$")
+
+
+def _template_error(what: str, template: str) -> Optional[str]:
+    for match in re.findall(ENVOY_FMT_TOKEN_REGEX, template):
+        if match[0] not in ALLOWED_ENVOY_FMT_TOKENS:
+            return f"static_response {what} uses unsupported command operator {match[0]}"
+
+    return None
+
+
+def static_response(response: Any) -> Tuple[Optional[Dict[str, Any]], Optional[str]]:
+    """
+    Work out what a Mapping with a static_response answers with. Returns
+    ({ "status", "headers", "body", "content_type", "templated" }, None) if the
+    static_response makes sense, and (None, error) if it doesn't.
+    """
+
+    if not isinstance(response, dict):
+        return None, f"static_response {response} must be an object"
+
+    status = response.get("status_code", None)
+
+    if status is None:
+        status = DEFAULT_STATUS
+    elif isinstance(status, bool) or (not isinstance(status, int)) or not (200 <= status <= 599):
+        return None, f"static_response status_code {status} must be between 200 and 599"
+
+    templated = response.get("templated", False)
+
+    if not isinstance(templated, bool):
+        return None, f"static_response templated {templated} must be true or false"
+
+    body = response.get("body", None) or ""
+
+    if not isinstance(body, str):
+        return None, "static_response body must be a string"
+
+    if len(body.encode("utf-8")) > MAX_BODY_BYTES:
+        return None, f"static_response body is bigger than {MAX_BODY_BYTES} bytes"
+
+    content_type = response.get("content_type", None) or DEFAULT_CONTENT_TYPE
+
+    if not isinstance(content_type, str):
+        return None, f"static_response content_type {content_type} must be a string"
+
+    headers = response.get("headers", None) or {}
+
+    if not isinstance(headers, dict):
+        return None, f"static_response headers {headers} must be an object"
+
+    for name, value in headers.items():
+        if (not isinstance(name, str)) or (not HEADER_NAME_REGEX.match(name)):
+            return None, f"static_response header name {name!r} is not valid"
+
+        if name.lower() == "host":
+            return None, f"static_response can't set the {name} header"
+
+        if not isinstance(value, str):
+            return None, f"static_response header {name} value {value!r} must be a string"
+
+    if templated:
+        error = _template_error("body", body)
+
+        for name, value in headers.items():
+            error = error or _template_error(f"header {name}", value)
+
+        if error:
+            return None, error
+
+    return {
+        "status": status,
+        "headers": {name.lower(): value for name, value in headers.items()},
+        "body": body,
+        "content_type": content_type,
+        "templated": templated,
+    }, None
@@ -0,0 +1,217 @@
+import re
+import socket
+from typing import Any, Dict, List, Optional, Tuple
+from urllib.parse import urlparse
+
+#############################################################################
+## irstatssinks.py -- where Envoy sends its stats
+##
+## STATSD_ENABLED and friends can only send stats to one StatsD server, and
+## anything more (another port, a prefix, OTLP, fewer stats) used to take a
+## bootstrap overlay that broke on upgrade. The ambassador Module's
+## stats_sinks says it all instead:
+##
+##   stats_sinks:
+##     flush_interval: 10                # optional; seconds between flushes (Envoy's default is 5)
+##     statsd:
+##       address: statsd-exporter.monitoring:8125   # the port defaults to 8125
+##       tag_style: dogstatsd            # statsd (the default) has no tags
+##       prefix: emissary                # optional; Envoy's default is "envoy"
+##     otlp:
+##       address: otel-collector.monitoring:4317    # the port defaults to 4317
+##       deltas: true                    # optional; counters and histograms as deltas
+##     include:                          # optional; only instantiate these stats...
+##     - ^cluster\.
+##     exclude:                          # ...or, instead, all but these
+##     - ^vhost\.
+##
+## With tag_style: statsd, Envoy folds its tags back into the stat names; with
+## dogstatsd, they're DogStatsD tags (and DD_ENTITY_ID is one of them, if it's
+## set). A statsd address's hostname is resolved when the configuration is
+## generated, since Envoy's StatsD sinks need an IP. The OTLP exporter talks
+## gRPC to the collector. It needs an Envoy with the OpenTelemetry stats sink,
+## which the Envoy in the image doesn't have; see V3Bootstrap.
+##
+## include and exclude are Envoy's stats matcher, so they decide which stats
+## exist at all, for /stats and the diag UI as much as for the sinks. Emissary
+## watches some of Envoy's own stats, to decide whether it's ready and to
+## report on its upstreams, so include always keeps those, and an exclude that
+## would drop them gets a notice.
+##
+## stats_sinks overrides STATSD_ENABLED. It's part of Envoy's bootstrap
+## config, so a change takes effect only when Envoy restarts.
+
+DEFAULT_STATSD_PORT = 8125
+DEFAULT_OTLP_PORT = 4317
+
+TAG_STYLES = ["statsd", "dogstatsd"]
+
+# The stats that Emissary itself watches (see pkg/acp/envoy.go and envoyclusterhealth.go, and the
+# diag UI's cluster health), which include always keeps.
+REQUIRED_STATS_PATTERNS = [
+    r"^(server|listener_manager|cluster_manager)\.",
+    r"^cluster\..+\.membership_(healthy|degraded|total)$",
+]
+REQUIRED_STATS = [
+    "server.live",
+    "listener_manager.lds.update_success",
+    "listener_manager.total_listeners_draining",
+    "cluster_manager.cds.update_success",
+    "cluster.example.membership_healthy",
+]
+
+
+def _address(value: Any, default_port: int, what: str) -> Tuple[Optional[Tuple[str, int]], str]:
+    if not isinstance(value, str) or not value:
+        return None, f"stats_sinks {what} address {value} must be a host, or a host:port"
+
+    try:
+        parsed = urlparse("//" + value)
+        port = parsed.port or default_port
+    except ValueError:
+        parsed = None
+
+    if (not parsed) or (not parsed.hostname) or parsed.path:
+        return None, f"stats_sinks {what} address {value} must be a host, or a host:port"
+
+    return (parsed.hostname, port), ""
+
+
+def _patterns(stats_sinks: Dict[str, Any], key: str) -> Tuple[List[str], Optional[str]]:
+    patterns = stats_sinks.get(key, None) or []
+
+    if not isinstance(patterns, list) or not all(isinstance(p, str) for p in patterns):
+        return [], f"stats_sinks {key} must be a list of regular expressions"
+
+    for pattern in patterns:
+        try:
+            re.compile(pattern)
+        except re.error as e:
+            return [], f"stats_sinks {key} {pattern} is not a valid regular expression: {e}"
+
+    return patterns, None
+
+
+def resolve_host(hostname: str) -> str:
+    return socket.gethostbyname(hostname)
+
+
+def stats_sinks_config(stats_sinks: Any) -> Tuple[Optional[Dict[str, Any]], List[str], List[str]]:
+    """
+    Check the ambassador Module's stats_sinks. Returns ({ "flush_interval", "statsd", "otlp",
+    "include", "exclude" }, errors, notices), where "statsd" is { "ip", "port", "dogstatsd",
+    "prefix" } and "otlp" is { "host", "port", "deltas" }, or None if it's not set. With any
+    errors, the settings are None.
+    """
+
+    if not isinstance(stats_sinks, dict):
+        return None, [f"stats_sinks {stats_sinks} must be an object"], []
+
+    notices: List[str] = []
+    settings: Dict[str, Any] = {
+        "flush_interval": None,
+        "statsd": None,
+        "otlp": None,
+        "include": [],
+        "exclude": [],
+    }
+
+    flush_interval = stats_sinks.get("flush_interval", None)
+
+    if flush_interval is not None:
+        if isinstance(flush_interval, bool) or (not isinstance(flush_interval, int)) or (
+            flush_interval < 1
+        ):
+            return (
+                None,
+                [f"stats_sinks flush_interval {flush_interval} must be a positive integer"],
+                [],
+            )
+
+        settings["flush_interval"] = flush_interval
+
+    statsd = stats_sinks.get("statsd", None)
+
+    if statsd is not None:
+        if not isinstance(statsd, dict):
+            return None, [f"stats_sinks statsd {statsd} must be an object"], []
+
+        address, error = _address(statsd.get("address", None), DEFAULT_STATSD_PORT, "statsd")
+
+        if not address:
+            return None, [error], []
+
+        tag_style = statsd.get("tag_style", "statsd")
+
+        if tag_style not in TAG_STYLES:
+            return (
+                None,
+                [f"stats_sinks statsd tag_style {tag_style} must be one of {TAG_STYLES}"],
+                [],
+            )
+
+        prefix = statsd.get("prefix", None)
+
+        if (prefix is not None) and (not isinstance(prefix, str) or not prefix):
+            return None, [f"stats_sinks statsd prefix {prefix} must be a non-empty string"], []
+
+        try:
+            ip = resolve_host(address[0])
+        except (socket.gaierror, UnicodeError) as e:
+            return None, [f"stats_sinks statsd address {address[0]} can't be resolved: {e}"], []
+
+        settings["statsd"] = {
+            "ip": ip,
+            "port": address[1],
+            "dogstatsd": tag_style == "dogstatsd",
+            "prefix": prefix,
+        }
+
+    otlp = stats_sinks.get("otlp", None)
+
+    if otlp is not None:
+        if not isinstance(otlp, dict):
+            return None, [f"stats_sinks otlp {otlp} must be an object"], []
+
+        address, error = _address(otlp.get("address", None), DEFAULT_OTLP_PORT, "otlp")
+
+        if not address:
+            return None, [error], []
+
+        deltas = otlp.get("deltas", False)
+
+        if not isinstance(deltas, bool):
+            return None, [f"stats_sinks otlp deltas {deltas} must be true or false"], []
+
+        settings["otlp"] = {"host": address[0], "port": address[1], "deltas": deltas}
+
+    include, error = _patterns(stats_sinks, "include")
+
+    if error:
+        return None, [error], []
+
+    exclude, error = _patterns(stats_sinks, "exclude")
+
+    if error:
+        return None, [error], []
+
+    if include and exclude:
+        return None, ["stats_sinks can have include or exclude, but not both"], []
+
+    if include:
+        settings["include"] = include + REQUIRED_STATS_PATTERNS
+
+    if exclude:
+        settings["exclude"] = exclude
+
+        for pattern in exclude:
+            dropped = [name for name in REQUIRED_STATS if re.search(pattern, name)]
+
+            if dropped:
+                notices.append(
+                    f"stats_sinks exclude {pattern} drops stats that Emissary watches, like "
+                    f"{dropped[0]}, so it can't tell as much about whether Envoy is ready, or "
+                    f"about its upstreams"
+                )
+
+    return settings, [], notices
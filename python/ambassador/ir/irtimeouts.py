@@ -0,0 +1,133 @@
+from typing import TYPE_CHECKING, Any, Dict, List, Optional
+
+if TYPE_CHECKING:
+    from .ir import IR  # pragma: no cover
+    from .irbasemapping import IRBaseMapping  # pragma: no cover
+    from .irhost import IRHost  # pragma: no cover
+    from .irhttpmappinggroup import IRHTTPMappingGroup  # pragma: no cover
+
+#############################################################################
+## irtimeouts.py -- work out which timeouts apply to a route
+##
+## Several resources can set the timeouts for a route, and the most specific
+## one that sets a timeout wins. For the request timeout, that's (from least to
+## most specific):
+##
+##   the default (3000ms)
+##   -> the Ambassador Module's cluster_request_timeout_ms
+##   -> the Host's request_timeout_ms
+##   -> the Mapping's timeout_ms
+##
+## Then the retry policy's per_try_timeout (the Mapping's retry_policy if it has
+## one, otherwise the Module's) bounds each attempt within the request timeout.
+## It can't extend it: once the request timeout is up, no more retries happen.
+##
+## Setting a timeout to 0 means "no timeout", and it still counts as setting it:
+## a Mapping with timeout_ms: 0 has no request timeout, whatever its Host or the
+## Module say. The idle timeout only comes from the Mapping's idle_timeout_ms.
+##
+## V3Route and V3Listener use this to build routes, and diagd uses it to report
+## on them, so they can't disagree.
+
+DEFAULT_REQUEST_TIMEOUT_MS = 3000
+
+
+def resource_ref(resource: Any) -> str:
+    return f"{resource.kind} {resource.name}.{resource.namespace}"
+
+
+def effective_timeouts(
+    ir: "IR",
+    group: "IRHTTPMappingGroup",
+    mapping: "IRBaseMapping",
+    host: Optional["IRHost"] = None,
+) -> Dict[str, Any]:
+    """
+    Return the timeouts for the route that the mapping (in the group) gets on
+    the host (or on no Host in particular), along with where each one came from:
+
+    {
+        "request_timeout_ms": { "value": 3000, "source": "default" },
+        "per_try_timeout": { "value": "1s", "source": "Mapping foo.default" },
+        "idle_timeout_ms": None
+    }
+
+    A None means that the route doesn't set that timeout at all.
+    """
+
+    amod = ir.ambassador_module
+    chain: List[Dict[str, Any]] = [{"value": DEFAULT_REQUEST_TIMEOUT_MS, "source": "default"}]
+
+    if amod.get("cluster_request_timeout_ms", None) is not None:
+        chain.append({"value": amod.cluster_request_timeout_ms, "source": "Module ambassador"})
+
+    if host and (host.get("request_timeout_ms", None) is not None):
+        chain.append({"value": host.request_timeout_ms, "source": resource_ref(host)})
+
+    if mapping.get("timeout_ms", None) is not None:
+        chain.append({"value": mapping.timeout_ms, "source": resource_ref(mapping)})
+
+    per_try: Optional[Dict[str, Any]] = None
+
+    if "retry_policy" in group:
+        if group.retry_policy.get("per_try_timeout", None):
+            per_try = {
+                "value": group.retry_policy.per_try_timeout,
+                "source": resource_ref(mapping),
+            }
+    elif "retry_policy" in amod:
+        if amod.retry_policy.get("per_try_timeout", None):
+            per_try = {"value": amod.retry_policy.per_try_timeout, "source": "Module ambassador"}
+
+    idle: Optional[Dict[str, Any]] = None
+
+    if mapping.get("idle_timeout_ms", None) is not None:
+        idle = {"value": mapping.idle_timeout_ms, "source": resource_ref(mapping)}
+
+    return {
+        "request_timeout_ms": chain[-1],
+        "per_try_timeout": per_try,
+        "idle_timeout_ms": idle,
+    }
+
+
+def mapping_sets_request_timeout(mapping: "IRBaseMapping") -> bool:
+    """
+    Return whether the mapping sets its own request timeout, which nothing can override.
+    """
+
+    return mapping.get("timeout_ms", None) is not None
+
+
+def envoy_duration(timeout_ms: int) -> str:
+    return "%0.3fs" % (timeout_ms / 1000.0)
+
+
+def timeout_report(ir: "IR") -> List[Dict[str, Any]]:
+    """
+    Return the effective timeouts for every HTTP route, on every Host that it's
+    on. This is what /ambassador/v0/timeouts serves.
+    """
+
+    from .irhttpmappinggroup import IRHTTPMappingGroup
+
+    report: List[Dict[str, Any]] = []
+
+    for group in ir.ordered_groups():
+        if not isinstance(group, IRHTTPMappingGroup):
+            continue
+
+        for mapping in group.mappings:
+            for host in ir.get_hosts():
+                if not host.matches_httpgroup(group):
+                    continue
+
+                entry: Dict[str, Any] = {
+                    "mapping": f"{mapping.name}.{mapping.namespace}",
+                    "host": host.hostname,
+                    "prefix": group.get("prefix"),
+                }
+                entry.update(effective_timeouts(ir, group, mapping, host))
+                report.append(entry)
+
+    return report
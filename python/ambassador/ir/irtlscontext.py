@@ -1,10 +1,12 @@
 import base64
+import hashlib
 import logging
-from typing import TYPE_CHECKING, ClassVar, Dict, List, Optional
+from typing import TYPE_CHECKING, Any, ClassVar, Dict, List, Optional
 
 from ..config import Config
 from ..utils import SavedSecret
 from .irresource import IRResource
+from .irtlspolicy import valid_tls_policy
 
 if TYPE_CHECKING:
     from .ir import IR  # pragma: no cover
@@ -20,6 +22,7 @@ class IRTLSContext(IRResource):
         "cacert_chain_file",
         "crl_secret",
         "crl_file",
+        "crl_url",
     }
 
     AllowedKeys: ClassVar = {
@@ -30,27 +33,39 @@ class IRTLSContext(IRResource):
         "cipher_suites",
         "ecdh_curves",
         "hosts",
+        "match_subject_alt_names",
         "max_tls_version",
         "min_tls_version",
+        "ocsp_staple_policy",
+        "private_key_provider",
         "redirect_cleartext_from",
         "secret_namespacing",
         "sni",
+        "tls_policy",
     }
 
     AllowedTLSVersions = ["v1.0", "v1.1", "v1.2", "v1.3"]
 
+    AllowedOCSPStaplePolicies = ["lenient_stapling", "strict_stapling", "must_staple"]
+
+    AllowedSANTypes = ["DNS", "URI", "EMAIL", "IP_ADDRESS"]
+
     name: str
     hosts: Optional[List[str]]
+    match_subject_alt_names: Optional[List[Dict[str, str]]]
     alpn_protocols: Optional[str]
     cert_required: Optional[bool]
     min_tls_version: Optional[str]
     max_tls_version: Optional[str]
+    ocsp_staple_policy: Optional[str]
+    private_key_provider: Optional[Dict[str, Any]]
     cipher_suites: Optional[str]
     ecdh_curves: Optional[str]
     redirect_cleartext_from: Optional[int]
     secret_namespacing: Optional[bool]
     secret_info: dict
     sni: Optional[str]
+    tls_policy: Optional[str]
 
     is_fallback: bool
 
@@ -110,10 +125,19 @@ class IRTLSContext(IRResource):
             if self.get("secret", None):
                 spec_count += 1
 
+            pkp = self.get("private_key_provider", None)
+
+            if pkp is not None:
+                if not isinstance(pkp, dict) or not pkp.get("provider_name", None):
+                    self.post_error(
+                        f"TLSContext {self.name}: 'private_key_provider' requires a 'provider_name'"
+                    )
+                    errors += 1
+
             if self.get("cert_chain_file", None):
                 spec_count += 1
 
-                if not self.get("private_key_file", None):
+                if not (self.get("private_key_file", None) or pkp):
                     err_msg = f"TLSContext {self.name}: 'cert_chain_file' requires 'private_key_file' as well"
 
                     self.post_error(err_msg)
@@ -142,6 +166,26 @@ class IRTLSContext(IRResource):
                 self.post_error(err_msg)
                 self.redirect_cleartext_from = None
 
+        # A bad subject alt name matcher could only ever make Envoy accept the wrong peer (or
+        # reject every peer), so rather than drop it and carry on, we refuse the whole context.
+        for matcher in self.get("match_subject_alt_names", None) or []:
+            if (
+                not isinstance(matcher, dict)
+                or matcher.get("san_type", None) not in IRTLSContext.AllowedSANTypes
+                or (("exact" in matcher) == ("prefix" in matcher))
+            ):
+                self.post_error(
+                    f"TLSContext {self.name}: each of 'match_subject_alt_names' needs a valid "
+                    f"'san_type' and exactly one of 'exact' and 'prefix', not {matcher}"
+                )
+                return False
+
+        tls_policy = self.get("tls_policy", None)
+
+        if not valid_tls_policy(tls_policy):
+            self.post_error(f"TLSContext {self.name}: invalid tls_policy {tls_policy}, ignoring")
+            self.pop("tls_policy")
+
         # Finally, move cert keys into secret_info.
         self.secret_info = {}
 
@@ -220,8 +264,9 @@ class IRTLSContext(IRResource):
                 self.secret_info.pop("secret")
                 secret_valid = False
             else:
-                # If they only gave a public key, that's an error
-                if not ss.key_path:
+                # If they only gave a public key, that's an error -- unless a private key
+                # provider has the private key, in which case that's the whole point.
+                if not ss.key_path and not self.get("private_key_provider", None):
                     self.post_error(
                         "TLSContext %s found no private key in %s" % (self.name, ss.name)
                     )
@@ -232,18 +277,54 @@ class IRTLSContext(IRResource):
 
                 # Update paths for this cert.
                 self.secret_info["cert_chain_file"] = ss.cert_path
-                self.secret_info["private_key_file"] = ss.key_path
+
+                if not self.get("private_key_provider", None):
+                    self.secret_info["private_key_file"] = ss.key_path
+                elif ss.key_path:
+                    self.ir.aconf.post_notice(
+                        "TLSContext %s has a private_key_provider, ignoring the private key in %s"
+                        % (self.name, ss.name),
+                        resource=self,
+                        log_level=logging.WARNING,
+                    )
 
                 if ss.root_cert_path:
                     self.secret_info["cacert_chain_file"] = ss.root_cert_path
 
+                self.resolve_ocsp_staple(ss)
+
         self.ir.logger.debug(
             "TLSContext - successfully processed the cert_chain_file, private_key_file, and cacert_chain_file: %s"
             % self.secret_info
         )
 
-        # OK. Repeat for the crl_secret.
+        # OK. Repeat for the crl_secret -- or for the crl_url, which the revocation watcher
+        # fetches into a secret of its own.
         crl_secret = self.secret_info.get("crl_secret")
+        crl_url = self.secret_info.get("crl_url")
+
+        if crl_url:
+            if crl_secret:
+                self.post_error(
+                    "TLSContext %s has both crl_secret and crl_url, ignoring crl_url" % self.name
+                )
+            else:
+                crls = self.ir.resolve_secret(
+                    self, IRTLSContext.crl_secret_name(crl_url), self.namespace
+                )
+
+                if not crls:
+                    # Not fetched yet, or the fetch failed. Unlike a missing crl_secret, this
+                    # isn't fatal: carry on without the CRL until the fetch works.
+                    self.ir.aconf.post_notice(
+                        "TLSContext %s has no certificate revocation list from %s yet"
+                        % (self.name, crl_url),
+                        resource=self,
+                        log_level=logging.WARNING,
+                    )
+                else:
+                    self.secret_info["crl_file"] = crls.user_path
+
         if crl_secret:
             # They gave a secret name for the certificate revocation list. Try loading it.
             crls = self.resolve_secret(crl_secret)
@@ -348,6 +429,43 @@ class IRTLSContext(IRResource):
 
         return True
 
+    def resolve_ocsp_staple(self, ss: SavedSecret) -> None:
+        # If we're stapling OCSP responses, find the one that the revocation watcher fetched
+        # for the certificate in ss.
+        policy = self.get("ocsp_staple_policy", None)
+
+        if not policy:
+            return
+
+        if policy not in IRTLSContext.AllowedOCSPStaplePolicies:
+            self.post_error(
+                "TLSContext %s: invalid ocsp_staple_policy %s, ignoring" % (self.name, policy)
+            )
+            self.pop("ocsp_staple_policy")
+            return
+
+        staple = self.ir.ocsp_staples.get(f"{ss.secret_name}-ocsp.{ss.namespace}")
+
+        if staple:
+            self.secret_info["ocsp_staple"] = staple
+        else:
+            self.ir.aconf.post_notice(
+                "TLSContext %s has no OCSP response for %s yet" % (self.name, ss.name),
+                resource=self,
+                log_level=logging.WARNING,
+            )
+
+            if policy == "must_staple":
+                # Envoy refuses a must_staple certificate with no staple, and with it the whole
+                # configuration. Staple when we can until there is one.
+                self["ocsp_staple_policy"] = "strict_stapling"
+
+    @staticmethod
+    def crl_secret_name(crl_url: str) -> str:
+        # The name of the secret that the revocation watcher fetches crl_url into. This has to
+        # match crlSecretName in cmd/entrypoint/revocation.go.
+        return "crl-" + hashlib.sha256(crl_url.encode("utf-8")).hexdigest()[:16]
+
     def has_secret(self) -> bool:
         # Safely verify that self.secret_info['secret'] exists -- in other words, verify
         # that this IRTLSContext is based on a Secret we load from elsewhere, rather than
@@ -0,0 +1,73 @@
+import re
+from typing import Any, Dict, List, Optional, Tuple
+
+#############################################################################
+## irtlsfingerprint.py -- fingerprinting TLS clients by their ClientHello
+##
+## Clients that share a TLS library tend to send the same ClientHello, so a
+## fingerprint of it says something about the client that its User-Agent
+## can't be trusted to: a script claiming to be a browser usually doesn't
+## look like one. A Listener's tlsFingerprint has Envoy compute the JA3
+## fingerprint of each client's ClientHello, in the tls_inspector listener
+## filter:
+##
+##   tlsFingerprint:
+##     ja3: true
+##     header: x-client-ja3     # optional
+##
+## With ja3 on, the fingerprint is in the Listener's access logs: the default
+## formats get %TLS_JA3_FINGERPRINT% (custom envoy_log_formats can use it too),
+## and LogServices get it in the TLS properties of every entry. With a header,
+## every request on the Listener gets the fingerprint in that header, for
+## upstreams, and so do the checks sent to HTTP AuthServices. (Envoy has no way
+## to put it in the checks sent to gRPC AuthServices.) Whatever a client sends
+## in the header itself is dropped, so it can't claim someone else's
+## fingerprint.
+##
+## Envoy only fingerprints TLS over TCP: there's no ClientHello to look at on a
+## Listener without TLS, and QUIC doesn't go through the tls_inspector. This
+## Envoy doesn't do JA4.
+
+HeaderPattern = re.compile(r"^[-a-zA-Z0-9]+$")
+
+
+def listener_tls_fingerprint(
+    spec: Any, listener: str, protocol_stack: List[str]
+) -> Tuple[Optional[Dict[str, Any]], List[str], List[str]]:
+    """
+    Work out a Listener's TLS fingerprinting from its tlsFingerprint (which may be None).
+    Returns ({ "ja3", "header" }, errors, notices), where the settings are None if Envoy
+    isn't to fingerprint anything, and "header" is None if there's no header.
+    """
+
+    if spec is None:
+        return None, [], []
+
+    what = f"Listener {listener}: tlsFingerprint"
+
+    if not isinstance(spec, dict):
+        return None, [f"{what} must be an object, ignoring"], []
+
+    ja3 = spec.get("ja3", False)
+
+    if not isinstance(ja3, bool):
+        return None, [f"{what} ja3 {ja3} must be true or false, ignoring"], []
+
+    header = spec.get("header", None)
+
+    if header is not None and (not isinstance(header, str) or not HeaderPattern.match(header)):
+        return None, [f"{what} header {header} is not a valid header name, ignoring"], []
+
+    if not ja3:
+        if header:
+            return None, [], [f"{what} header {header} does nothing without ja3"]
+
+        return None, [], []
+
+    if "TLS" not in protocol_stack:
+        return None, [], [f"{what} does nothing on a Listener without TLS"]
+
+    if protocol_stack[-1] != "TCP":
+        return None, [], [f"{what} does nothing on a UDP Listener: Envoy can't fingerprint QUIC"]
+
+    return {"ja3": True, "header": header.lower() if header else None}, [], []
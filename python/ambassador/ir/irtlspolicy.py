@@ -0,0 +1,258 @@
+import logging
+from typing import TYPE_CHECKING, Any, Dict, Iterator, List, Optional, Tuple
+
+if TYPE_CHECKING:
+    from .ir import IR  # pragma: no cover
+    from .irhost import IRHost  # pragma: no cover
+    from .irlistener import IRListener  # pragma: no cover
+    from .irtlscontext import IRTLSContext  # pragma: no cover
+
+#############################################################################
+## irtlspolicy.py -- named TLS policies for downstream TLS
+##
+## A TLS policy is a named set of TLS settings, so that nobody has to work out
+## cipher suites by hand. The presets follow Mozilla's server-side TLS
+## guidelines, minus the ciphers that Envoy (BoringSSL) doesn't have:
+##
+##   modern        TLS 1.3 only
+##   intermediate  TLS 1.2 and up, forward-secret AEAD ciphers only
+##   old           TLS 1.0 and up, for clients that can't do any better
+##   custom        no preset: just whatever the TLSContext sets
+##
+## The policy for a Host on a Listener comes from (most specific first):
+##
+##   the Host's tls.tls_policy, or its TLSContext's tls_policy
+##   -> the Listener's tlsPolicy
+##   -> the Ambassador Module's tls_policy
+##
+## and min_tls_version, max_tls_version, cipher_suites, and ecdh_curves set on
+## the Host or TLSContext itself override the policy's. With no policy at all,
+## Envoy's own defaults apply, as they always have.
+##
+## The Module's policy is the fleet default. A Host that ends up weaker than it
+## (an older TLS version, or a cipher suite that it doesn't allow) still works,
+## but gets a notice saying so, and shows up with its reasons in
+## /ambassador/v0/tls_policies.
+
+CUSTOM_POLICY = "custom"
+
+# (Mozilla's intermediate and old lists include DHE suites; BoringSSL has none.)
+_AEAD_CIPHERS = [
+    "ECDHE-ECDSA-AES128-GCM-SHA256",
+    "ECDHE-RSA-AES128-GCM-SHA256",
+    "ECDHE-ECDSA-AES256-GCM-SHA384",
+    "ECDHE-RSA-AES256-GCM-SHA384",
+    "ECDHE-ECDSA-CHACHA20-POLY1305",
+    "ECDHE-RSA-CHACHA20-POLY1305",
+]
+
+_CURVES = ["X25519", "P-256", "P-384"]
+
+TLS_POLICIES: Dict[str, Dict[str, Any]] = {
+    "modern": {
+        "min_tls_version": "v1.3",
+        "max_tls_version": "v1.3",
+        "ecdh_curves": _CURVES,
+    },
+    "intermediate": {
+        "min_tls_version": "v1.2",
+        "max_tls_version": "v1.3",
+        "cipher_suites": _AEAD_CIPHERS,
+        "ecdh_curves": _CURVES,
+    },
+    "old": {
+        "min_tls_version": "v1.0",
+        "max_tls_version": "v1.3",
+        "cipher_suites": _AEAD_CIPHERS
+        + [
+            "ECDHE-ECDSA-AES128-SHA",
+            "ECDHE-RSA-AES128-SHA",
+            "ECDHE-ECDSA-AES256-SHA",
+            "ECDHE-RSA-AES256-SHA",
+            "AES128-GCM-SHA256",
+            "AES256-GCM-SHA384",
+            "AES128-SHA",
+            "AES256-SHA",
+            "DES-CBC3-SHA",
+        ],
+        "ecdh_curves": _CURVES,
+    },
+}
+
+# What Envoy does for a downstream context that doesn't say, for comparing with.
+ENVOY_DEFAULTS: Dict[str, Any] = {
+    "min_tls_version": "v1.2",
+    "max_tls_version": "v1.3",
+    "cipher_suites": _AEAD_CIPHERS,
+    "ecdh_curves": ["X25519", "P-256"],
+}
+
+TLS_POLICY_SETTINGS = ["min_tls_version", "max_tls_version", "cipher_suites", "ecdh_curves"]
+
+_VERSION_RANK = {"v1.0": 0, "v1.1": 1, "v1.2": 2, "v1.3": 3}
+
+
+def resource_ref(resource: Any) -> str:
+    return f"{resource.kind} {resource.name}.{resource.namespace}"
+
+
+def valid_tls_policy(name: Optional[str], allow_custom: bool = True) -> bool:
+    if name is None:
+        return True
+
+    return (name in TLS_POLICIES) or (allow_custom and (name == CUSTOM_POLICY))
+
+
+def effective_tls_policy(
+    ir: "IR",
+    ctx: "IRTLSContext",
+    listener: Optional["IRListener"] = None,
+    host: Optional["IRHost"] = None,
+) -> Dict[str, Any]:
+    """
+    Return the TLS policy for the context (of the host, on the listener, if
+    any), where it came from, and the settings it ends up with:
+
+    {
+        "policy": "intermediate",
+        "source": "Listener https.default",
+        "settings": { "min_tls_version": "v1.2", ... }
+    }
+
+    "settings" only has what goes into the Envoy config: anything missing is up
+    to Envoy. "policy" and "source" are None if no policy applies.
+    """
+
+    policy: Optional[str] = None
+    source: Optional[str] = None
+
+    if ctx.get("tls_policy", None):
+        policy = ctx.tls_policy
+        source = context_ref(ctx, host)
+    elif listener and listener.get("tlsPolicy", None):
+        policy = listener.tlsPolicy
+        source = resource_ref(listener)
+    elif ir.ambassador_module.get("tls_policy", None):
+        policy = ir.ambassador_module.tls_policy
+        source = "Module ambassador"
+
+    settings: Dict[str, Any] = dict(TLS_POLICIES.get(policy or "", {}))
+
+    for key in TLS_POLICY_SETTINGS:
+        if ctx.get(key, None) is not None:
+            settings[key] = ctx[key]
+
+    return {"policy": policy, "source": source, "settings": settings}
+
+
+def context_ref(ctx: "IRTLSContext", host: Optional["IRHost"]) -> str:
+    # A Host's tls settings become a TLSContext of its own, which nobody has
+    # heard of: call it the Host.
+    if host and (ctx.rkey == host.rkey):
+        return resource_ref(host)
+
+    return resource_ref(ctx)
+
+
+def tls_policy_drift(settings: Dict[str, Any], baseline: Dict[str, Any]) -> List[str]:
+    """
+    Return the ways in which settings are weaker than baseline. Both are filled
+    out with Envoy's defaults first.
+    """
+
+    settings = dict(ENVOY_DEFAULTS, **settings)
+    baseline = dict(ENVOY_DEFAULTS, **baseline)
+    reasons: List[str] = []
+
+    min_rank = _VERSION_RANK.get(settings["min_tls_version"], 0)
+    baseline_min_rank = _VERSION_RANK.get(baseline["min_tls_version"], 0)
+
+    if min_rank < baseline_min_rank:
+        reasons.append(
+            f"min_tls_version {settings['min_tls_version']} is older than {baseline['min_tls_version']}"
+        )
+
+    # Cipher suites only matter below TLS 1.3, and if the baseline doesn't allow
+    # anything below TLS 1.3 at all, the version has already been reported.
+    if (min_rank < _VERSION_RANK["v1.3"]) and (baseline_min_rank < _VERSION_RANK["v1.3"]):
+        extra = [c for c in settings["cipher_suites"] if c not in baseline["cipher_suites"]]
+
+        if extra:
+            reasons.append(f"cipher_suites allows {', '.join(extra)}")
+
+    return reasons
+
+
+def _host_tls_policies(ir: "IR") -> Iterator[Tuple["IRHost", "IRListener", Dict[str, Any]]]:
+    fleet_policy = ir.ambassador_module.get("tls_policy", None)
+    baseline = TLS_POLICIES.get(fleet_policy or "", None)
+
+    for listener in sorted(ir.listeners.values(), key=lambda listener: listener.name):
+        if "TLS" not in listener.get("protocolStack", []):
+            continue
+
+        for host in ir.get_hosts():
+            ctx = host.get("context", None)
+
+            if not ctx or not listener.matches_host(host):
+                continue
+
+            entry: Dict[str, Any] = {
+                "host": f"{host.name}.{host.namespace}",
+                "hostname": host.hostname,
+                "listener": f"{listener.name}.{listener.namespace}",
+            }
+            entry.update(effective_tls_policy(ir, ctx, listener, host))
+            entry["drift"] = tls_policy_drift(entry["settings"], baseline) if baseline else []
+
+            yield host, listener, entry
+
+
+def tls_policy_report(ir: "IR") -> List[Dict[str, Any]]:
+    """
+    Return the TLS policy of every Host with TLS, on every Listener that it's
+    on, and how it's weaker than the Module's policy (if it is). This is what
+    /ambassador/v0/tls_policies serves.
+    """
+
+    return [entry for _, _, entry in _host_tls_policies(ir)]
+
+
+def check_tls_policies(ir: "IR") -> None:
+    """
+    Post a notice on every Host, TLSContext, or Listener that makes a Host's
+    TLS weaker than the Module's policy.
+    """
+
+    noticed = set()
+
+    for host, listener, entry in _host_tls_policies(ir):
+        if not entry["drift"]:
+            continue
+
+        # Blame whatever picked the weaker settings: the Host's TLSContext if
+        # it set any itself, otherwise the Listener.
+        ctx = host.context
+
+        if ctx.get("tls_policy", None) or any(
+            ctx.get(key, None) is not None for key in TLS_POLICY_SETTINGS
+        ):
+            resource = ctx
+            what = context_ref(ctx, host)
+        elif listener.get("tlsPolicy", None):
+            resource = listener
+            what = resource_ref(listener)
+        else:
+            continue
+
+        if (what, entry["host"]) in noticed:
+            continue
+
+        noticed.add((what, entry["host"]))
+
+        ir.aconf.post_notice(
+            f"{what}: TLS for Host {entry['host']} is weaker than the Module's tls_policy "
+            f"{ir.ambassador_module.tls_policy}: {'; '.join(entry['drift'])}",
+            resource=resource,
+            log_level=logging.WARNING,
+        )
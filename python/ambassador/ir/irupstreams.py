@@ -0,0 +1,160 @@
+from typing import TYPE_CHECKING, Any, Dict, List, Optional, Tuple
+from urllib.parse import urlparse
+
+from ..config import Config
+from .irserviceresolver import is_ip_address
+
+if TYPE_CHECKING:
+    from .ir import IR  # pragma: no cover
+    from .ircluster import IRCluster  # pragma: no cover
+
+#############################################################################
+## irupstreams.py -- one cluster, endpoints from several places
+##
+## A Mapping's upstreams say where its cluster's endpoints come from, in
+## place of its service, so that one Mapping can send traffic to the same
+## application running on several platforms at once while it moves between
+## them:
+##
+##   upstreams:
+##   - service: quote:8080                 # Kubernetes endpoints
+##     resolver: endpoint
+##     weight: 90
+##   - service: quote                      # Consul
+##     resolver: consul-dc1
+##     weight: 10
+##   - service: 10.0.12.7:8080             # a VM that nothing else knows about
+##     priority: 1
+##
+## Each upstream is a locality of the cluster, with the upstream's weight
+## (default 1); Envoy splits each priority's traffic by those weights, and
+## fails over to the next priority (lower priorities come first; the default
+## is 0) as the upstreams above it lose their healthy endpoints.
+##
+## The cluster is always an EDS cluster. diagd lists its upstreams in the
+## cluster's metadata, and ambex puts its endpoints together from theirs (see
+## pkg/ambex/upstreams.go), so every upstream that isn't an IP address needs
+## a resolver that has endpoints: a KubernetesEndpointResolver, a
+## ConsulResolver, or a PluginResolver. The Mapping's service still says
+## whether to originate TLS, and names the cluster's stats.
+
+# This has to match UpstreamsMetadataKey in pkg/ambex/upstreams.go.
+UPSTREAMS_METADATA_KEY = "getambassador.io/upstreams"
+
+# These have to match the CRD.
+MAX_WEIGHT = 1000000
+MAX_PRIORITY = 127
+
+ENDPOINT_RESOLVER_KINDS = ["KubernetesEndpointResolver", "ConsulResolver", "PluginResolver"]
+
+
+def _int_field(upstream: Dict[str, Any], name: str, low: int, high: int) -> Optional[str]:
+    value = upstream.get(name, None)
+
+    if value is None:
+        return None
+
+    # bool is an int as far as isinstance is concerned.
+    if isinstance(value, bool) or (not isinstance(value, int)) or not (low <= value <= high):
+        return f"{name} {value!r} is not an integer between {low} and {high}"
+
+    return None
+
+
+def _host_port(service: str, port: int) -> Tuple[Optional[str], int]:
+    # Like IRCluster, supply a scheme so that urllib will parse the host and port.
+    p = urlparse("random://" + service)
+    return p.hostname, p.port or port
+
+
+def upstreams_error(ir: "IR", upstreams: Any, resolver_name: Optional[str]) -> Optional[str]:
+    """
+    Returns what's wrong with a Mapping's upstreams, or None if they're OK.
+    resolver_name is the Mapping's resolver, which is what an upstream without one uses.
+    """
+
+    if not Config.enable_endpoints:
+        return "upstreams need endpoint routing, which is disabled"
+
+    if (not isinstance(upstreams, list)) or (not upstreams):
+        return "upstreams must be a list of at least one upstream"
+
+    for upstream in upstreams:
+        if not isinstance(upstream, dict):
+            return f"upstream {upstream!r} is not an object"
+
+        service = upstream.get("service", None)
+
+        if (not isinstance(service, str)) or (not service):
+            return f"upstream {upstream!r} has no service"
+
+        if "://" in service:
+            return f"upstream service {service} can't have a scheme"
+
+        try:
+            host, _ = _host_port(service, 0)
+        except ValueError as e:
+            return f"upstream service {service} has an invalid port: {e}"
+
+        if not host:
+            return f"upstream service {service} has no hostname"
+
+        for name, low, high in [("weight", 1, MAX_WEIGHT), ("priority", 0, MAX_PRIORITY)]:
+            error = _int_field(upstream, name, low, high)
+
+            if error:
+                return f"upstream {service}: {error}"
+
+        if is_ip_address(host):
+            continue
+
+        name = upstream.get("resolver", None) or resolver_name
+        name = name or ir.ambassador_module.get("resolver", "kubernetes-service")
+        resolver = ir.get_resolver(name)
+
+        if not resolver:
+            return f"upstream {service}: resolver {name} does not exist"
+
+        if resolver.kind not in ENDPOINT_RESOLVER_KINDS:
+            return f"upstream {service}: {resolver.kind} {name} has no endpoints"
+
+    return None
+
+
+def upstream_sources(
+    ir: "IR", cluster: "IRCluster", upstreams: List[Dict[str, Any]], port: int
+) -> List[Dict[str, Any]]:
+    """
+    Returns where each of a cluster's (valid) upstreams gets its endpoints -- an endpoint
+    path, or an address and port -- with its weight and priority, as ambex wants them.
+    port is the cluster's port, which is what an upstream without one uses.
+    """
+
+    sources: List[Dict[str, Any]] = []
+
+    for upstream in upstreams:
+        service = upstream["service"]
+        host, uport = _host_port(service, port)
+        assert host  # upstreams_error checked this
+
+        source: Dict[str, Any]
+
+        if is_ip_address(host):
+            source = {"address": host, "port": uport}
+        else:
+            resolver = ir.resolve_resolver(cluster, upstream.get("resolver") or cluster._resolver)
+            entry = resolver.clustermap_entry(ir, cluster, host, cluster._namespace, uport)
+            endpoint_path = entry.get("endpoint_path", None)
+
+            if not endpoint_path:
+                # An ExternalName service, say, has no endpoints of its own.
+                cluster.post_error(f"upstream {service} has no endpoints; ignoring it")
+                continue
+
+            source = {"endpoint_path": endpoint_path}
+
+        source["weight"] = upstream.get("weight", None) or 1
+        source["priority"] = upstream.get("priority", None) or 0
+        sources.append(source)
+
+    return sources
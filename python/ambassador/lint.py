@@ -0,0 +1,243 @@
+"""
+Lint rules for Ambassador configuration.
+
+Linting is about configuration that's perfectly valid, but that's probably not
+doing what its author meant: a regex that looks like a prefix match but isn't,
+say. Each rule has a name, an error code (see errorcodes.py), and a default
+severity, which can be overridden in the Ambassador Module:
+
+    lint:
+      unanchored-regex: error
+      external-upstream-no-timeout: ignore
+
+A severity is one of:
+
+- "ignore": the rule doesn't run at all;
+- "warn": findings are posted as notices; or
+- "error": findings are posted as errors, so they show up in the errors API
+  and make `ambassador validate` fail. The resource is still used, though:
+  linting never changes the configuration we generate.
+
+Rules look at the resources as they were written (from the aconf), rather than
+at the IR, since building the IR smooths over some of the very things we want
+to catch.
+"""
+
+import dataclasses
+import ipaddress
+import re
+from typing import TYPE_CHECKING, Callable, ClassVar, Dict, Iterable, List, Optional, Tuple
+
+from . import errorcodes
+from .config import ACResource, Config
+from .errorcodes import ErrorCode
+
+if TYPE_CHECKING:
+    from .ir import IR  # pragma: no cover
+
+
+# A finding is the resource the rule is unhappy with, and why.
+Finding = Tuple[ACResource, str]
+
+
+@dataclasses.dataclass(frozen=True)
+class LintRule:
+    IGNORE: ClassVar[str] = "ignore"
+    WARN: ClassVar[str] = "warn"
+    ERROR: ClassVar[str] = "error"
+
+    SEVERITIES: ClassVar[Tuple[str, ...]] = (IGNORE, WARN, ERROR)
+
+    name: str
+    code: ErrorCode
+    severity: str
+    check: Callable[["Linter"], Iterable[Finding]]
+
+
+def _resources(aconf: Config, key: str) -> List[ACResource]:
+    resources = aconf.get_config(key) or {}
+
+    return [resources[name] for name in sorted(resources.keys())]
+
+
+def _service_host(service: str) -> str:
+    # A Mapping's service can be "host", "host:port", "scheme://host:port", or
+    # any of those with a path tacked on.
+    if "://" in service:
+        service = service.split("://", 1)[1]
+
+    service = service.split("/", 1)[0]
+
+    if service.startswith("["):
+        # Bracketed IPv6 address.
+        return service[1:].split("]", 1)[0]
+
+    return service.rsplit(":", 1)[0] if service.count(":") == 1 else service
+
+
+def _is_external(host: str) -> bool:
+    try:
+        return ipaddress.ip_address(host).is_global
+    except ValueError:
+        pass
+
+    # "svc" and "svc.namespace" are in-cluster. Anything with more dots is
+    # external, unless it's obviously a cluster-local name.
+    if host.count(".") < 2:
+        return False
+
+    return not re.search(r"\.svc(\.|$)|\.local$|\.internal$", host)
+
+
+def check_unanchored_regex(linter: "Linter") -> Iterable[Finding]:
+    for mapping in _resources(linter.aconf, "mappings"):
+        prefix = mapping.get("prefix", "")
+
+        if not mapping.get("prefix_regex") or not prefix:
+            continue
+
+        if prefix.startswith("^") or prefix.endswith("$") or prefix.endswith(".*"):
+            continue
+
+        yield (
+            mapping,
+            f"prefix regex {prefix} has no anchors; Envoy matches it against the entire path, "
+            f"so it won't match as a prefix (add .* to the end if that's what you want)",
+        )
+
+
+def check_external_upstream_no_timeout(linter: "Linter") -> Iterable[Finding]:
+    default_timeout = linter.ir.ambassador_module.get("cluster_request_timeout_ms", 3000)
+
+    for mapping in _resources(linter.aconf, "mappings"):
+        service = mapping.get("service", "")
+
+        if not service or "timeout_ms" in mapping:
+            continue
+
+        host = _service_host(service)
+
+        if _is_external(host):
+            yield (
+                mapping,
+                f"service {host} is outside the cluster but the Mapping has no timeout_ms; "
+                f"the default of {default_timeout}ms applies",
+            )
+
+
+def check_wildcard_host_acme(linter: "Linter") -> Iterable[Finding]:
+    for host in _resources(linter.aconf, "hosts"):
+        hostname = host.get("hostname", "")
+
+        if "*" not in hostname:
+            continue
+
+        authority = (host.get("acmeProvider") or {}).get("authority")
+
+        if authority is None:
+            # Edge Stack turns ACME on by default.
+            acme = linter.ir.edge_stack_allowed
+        else:
+            acme = authority.lower() != "none"
+
+        if acme:
+            yield (
+                host,
+                f"hostname {hostname} is a wildcard, so ACME HTTP-01 can't get a certificate "
+                f"for it; set acmeProvider.authority to none and supply tlsSecret",
+            )
+
+
+def check_duplicate_rewrite(linter: "Linter") -> Iterable[Finding]:
+    for mapping in _resources(linter.aconf, "mappings"):
+        rewrite = mapping.get("rewrite")
+
+        # "/" is the default, so it doesn't count.
+        if rewrite and rewrite != "/" and mapping.get("regex_rewrite"):
+            yield (mapping, f"both rewrite and regex_rewrite are set; rewrite {rewrite} is ignored")
+
+
+RULES: List[LintRule] = [
+    LintRule(
+        "unanchored-regex",
+        errorcodes.LINT_UNANCHORED_REGEX,
+        LintRule.WARN,
+        check_unanchored_regex,
+    ),
+    LintRule(
+        "external-upstream-no-timeout",
+        errorcodes.LINT_EXTERNAL_UPSTREAM_NO_TIMEOUT,
+        LintRule.WARN,
+        check_external_upstream_no_timeout,
+    ),
+    LintRule(
+        "wildcard-host-acme",
+        errorcodes.LINT_WILDCARD_HOST_ACME,
+        LintRule.WARN,
+        check_wildcard_host_acme,
+    ),
+    LintRule(
+        "duplicate-rewrite",
+        errorcodes.LINT_DUPLICATE_REWRITE,
+        LintRule.WARN,
+        check_duplicate_rewrite,
+    ),
+]
+
+
+class Linter:
+    ir: "IR"
+    aconf: Config
+    severities: Dict[str, str]
+
+    def __init__(self, ir: "IR", aconf: Config) -> None:
+        self.ir = ir
+        self.aconf = aconf
+        self.severities = {rule.name: rule.severity for rule in RULES}
+
+        overrides = ir.ambassador_module.get("lint", None) or {}
+
+        if not isinstance(overrides, dict):
+            ir.post_error(
+                f"lint must be a dictionary of rule names to severities, not {overrides}",
+                resource=ir.ambassador_module,
+                code=errorcodes.INVALID_MODULE,
+            )
+            return
+
+        for name, severity in overrides.items():
+            error: Optional[str] = None
+
+            if name not in self.severities:
+                error = f"lint rule {name} is unknown; known rules are {sorted(self.severities)}"
+            elif severity not in LintRule.SEVERITIES:
+                error = f"lint severity {severity} for {name} must be one of {LintRule.SEVERITIES}"
+
+            if error:
+                ir.post_error(error, resource=ir.ambassador_module, code=errorcodes.INVALID_MODULE)
+            else:
+                self.severities[name] = severity
+
+    def run(self) -> int:
+        """
+        Runs every rule that isn't ignored, and posts what they find. Returns
+        the number of findings.
+        """
+        count = 0
+
+        for rule in RULES:
+            severity = self.severities[rule.name]
+
+            if severity == LintRule.IGNORE:
+                continue
+
+            for resource, message in rule.check(self):
+                count += 1
+                message = f"{rule.name}: {message}"
+
+                if severity == LintRule.ERROR:
+                    self.aconf.post_error(message, resource=resource, code=rule.code)
+                else:
+                    self.aconf.post_notice(f"{rule.code} {message}", resource=resource)
+
+        return count
@@ -108,7 +108,8 @@ def _load_url_contents(
     saved = False
 
     try:
-        with requests.get(url) as r:
+        # This is a snapshot from entrypoint, over loopback: compressing it would only cost time.
+        with requests.get(url, headers={"Accept-Encoding": "identity"}) as r:
             if r.status_code == 200:
 
                 # All's well, pull the config down.
@@ -182,6 +183,22 @@ def parse_bool(s: Optional[Union[str, bool]]) -> bool:
         return False
 
 
+def feature_gate_enabled(name: str) -> bool:
+    """
+    Return whether a feature gate is on. The entrypoint settles every gate at
+    startup (see pkg/featuregate) and passes them all to us in the environment,
+    as a comma-separated list of Name=bool pairs.
+    """
+
+    for item in os.environ.get("AMBASSADOR_FEATURE_GATES", "").split(","):
+        gate, _, value = item.partition("=")
+
+        if gate.strip() == name:
+            return parse_bool(value.strip())
+
+    return False
+
+
 class SystemInfo:
     MyHostName = os.environ.get("HOSTNAME", None)
 
@@ -492,6 +492,11 @@ def config(
                     output.write(ir.as_json())
                     output.write("\n")
 
+            # Building the IR can turn up more errors (including lint findings
+            # at "error" severity), so check again.
+            if exit_on_error and aconf.errors:
+                raise Exception("errors in: {0}".format(", ".join(aconf.errors.keys())))
+
             logger.info("Writing envoy configuration")
             config = V3Config(ir)
             rc = RichStatus.OK(msg="huh_xds")
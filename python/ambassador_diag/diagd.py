@@ -38,6 +38,7 @@ import click
 import gunicorn.app.base
 import jsonpatch
 import requests
+import yaml
 from expiringdict import ExpiringDict
 from flask import Flask, Response
 from flask import json as flask_json
@@ -49,10 +50,16 @@ from typing_extensions import NotRequired, TypedDict
 
 from ambassador import IR, Cache, Config, Diagnostics, EnvoyConfig, Scout, Version
 from ambassador.ambscout import LocalScout
+from ambassador.compile import Compile
 from ambassador.constants import Constants
 from ambassador.diagnostics import EnvoyStats, EnvoyStatsMgr
+from ambassador.diagnostics.history import parse_time, snapshot_at
+from ambassador.dryrun import DryRunError, check_proposed, dry_run
 from ambassador.fetch import ResourceFetcher
+from ambassador.ir import irpreflight
 from ambassador.ir.irambassador import IRAmbassador
+from ambassador.ir.irtimeouts import timeout_report
+from ambassador.ir.irtlspolicy import tls_policy_report
 from ambassador.reconfig_stats import ReconfigStats
 from ambassador.utils import (
     FSSecretHandler,
@@ -64,6 +71,7 @@ from ambassador.utils import (
     load_url_contents,
     parse_bool,
     parse_json,
+    parse_yaml,
 )
 
 if TYPE_CHECKING:
@@ -137,6 +145,12 @@ def number_of_workers():
     return (multiprocessing.cpu_count() * 2) + 1
 
 
+def is_critical_error(err: Dict[str, Any]) -> bool:
+    # Config marks the errors of a Mapping with critical: false, which are
+    # reported like any others but don't count against Ambassador's health.
+    return err.get("critical", True) is not False
+
+
 class DiagApp(Flask):
     cache: Optional[Cache]
     ambex_pid: int
@@ -172,9 +186,17 @@ class DiagApp(Flask):
     banner_endpoint: Optional[str]
     metrics_endpoint: Optional[str]
 
+    # The Host prober's most recent results, from the entrypoint.
+    host_probes: List[Dict[str, Any]]
+
     # Reconfiguration stats
     reconf_stats: ReconfigStats
 
+    # How long each resource took to set up in the IR, the last time it was set up, by
+    # (kind, namespace, name).
+    resource_timings: Dict[Tuple[str, str, str], float]
+    slow_resource_threshold: float
+
     # Custom metrics registry to weed-out default metrics collectors because the
     # default collectors can't be prefixed/namespaced with ambassador_.
     # Using the default metrics collectors would lead to name clashes between the Python and Go instrumentations.
@@ -221,6 +243,7 @@ class DiagApp(Flask):
         self.report_action_keys = report_action_keys
         self.banner_endpoint = banner_endpoint
         self.metrics_endpoint = metrics_endpoint
+        self.host_probes = []
         self.metrics_registry = CollectorRegistry(auto_describe=True)
         self.enable_fast_reconfigure = enable_fast_reconfigure
 
@@ -260,6 +283,12 @@ class DiagApp(Flask):
             namespace="ambassador",
             registry=self.metrics_registry,
         )
+        self.diag_noncritical_errors = Gauge(
+            f"diagnostics_noncritical_errors",
+            f"Number of configuration errors for Mappings marked critical: false",
+            namespace="ambassador",
+            registry=self.metrics_registry,
+        )
         self.diag_notices = Gauge(
             f"diagnostics_notices",
             f"Number of configuration notices",
@@ -274,6 +303,27 @@ class DiagApp(Flask):
             registry=self.metrics_registry,
         )
 
+        # The slowest resources to set up in the IR. The entrypoint times what it does to each
+        # resource, too, on /debug/resources/<phase>; this is diagd's share.
+        self.resource_timings = {}
+        self.resource_compile_seconds = Gauge(
+            f"resource_compile_seconds",
+            f"Seconds it took to compile each of the slowest resources into the IR, the last time it was compiled",
+            ["kind", "namespace", "name"],
+            namespace="ambassador",
+            registry=self.metrics_registry,
+        )
+
+        try:
+            self.slow_resource_threshold = (
+                int(os.environ.get("AMBASSADOR_SLOW_RESOURCE_THRESHOLD_MS", "1000")) / 1000.0
+            )
+        except ValueError:
+            self.slow_resource_threshold = 1.0
+
+        if self.slow_resource_threshold < 0:
+            self.slow_resource_threshold = 1.0
+
         if debug:
             self.logger.setLevel(logging.DEBUG)
             self.diag_log_level.labels("debug").set(1)
@@ -292,6 +342,19 @@ class DiagApp(Flask):
 
         self.kubestatus = ksclass(self)
 
+        # Pre-flight checks finish after the Mapping's status has been posted,
+        # so a failure gets posted on its own.
+        if irpreflight.preflight_enabled():
+            self.logger.info("WILL run pre-flight checks on Mapping upstreams")
+
+            irpreflight.enable(
+                irpreflight.PreflightChecker(
+                    self.logger,
+                    irpreflight.preflight_timeout(),
+                    on_result=self.post_preflight_warning,
+                )
+            )
+
         self.config_path = config_path
         self.bootstrap_path = bootstrap_path
         self.ads_path = ads_path
@@ -347,6 +410,37 @@ class DiagApp(Flask):
             }
         )
 
+    # How many of the slowest resources go on /metrics.
+    RESOURCE_TIMINGS_TOP = 10
+
+    def record_resource_timings(
+        self, timings: Dict[Tuple[str, str, str], float], reset: bool
+    ) -> None:
+        """
+        Remember how long the resources set up for a new IR took, and put the
+        slowest on /metrics. An incremental reconfigure takes the resources that
+        didn't change from the cache, without setting them up again, so their old
+        timings stand until the next full reconfigure starts over.
+        """
+        if reset:
+            self.resource_timings = {}
+
+        self.resource_timings.update(timings)
+
+        for (kind, namespace, name), seconds in timings.items():
+            if self.slow_resource_threshold > 0 and seconds > self.slow_resource_threshold:
+                self.logger.warning(
+                    f"slow resource: {kind} {name}.{namespace} took {seconds:.3f}s to compile "
+                    f"(threshold {self.slow_resource_threshold:.3f}s)"
+                )
+
+        slowest = sorted(self.resource_timings.items(), key=lambda item: (-item[1], item[0]))
+
+        self.resource_compile_seconds.clear()
+
+        for (kind, namespace, name), seconds in slowest[: self.RESOURCE_TIMINGS_TOP]:
+            self.resource_compile_seconds.labels(kind, namespace, name).set(seconds)
+
     @property
     def diag(self) -> Optional[Diagnostics]:
         """
@@ -433,7 +527,10 @@ class DiagApp(Flask):
 
             # Update some metrics data points given the new generated Diagnostics
             diag_dict = _diag.as_dict()
-            self.diag_errors.set(len(diag_dict.get("errors", [])))
+            errors = diag_dict.get("errors", {})
+            critical = [k for k, errs in errors.items() if any(is_critical_error(e) for e in errs)]
+            self.diag_errors.set(len(critical))
+            self.diag_noncritical_errors.set(len(errors) - len(critical))
             self.diag_notices.set(len(diag_dict.get("notices", [])))
 
             # Note that we've updated diagnostics, since that might trigger a
@@ -445,6 +542,14 @@ class DiagApp(Flask):
     def check_scout(self, what: str) -> None:
         self.watcher.post("SCOUT", (what, self.ir))
 
+    def post_preflight_warning(self, name: str, namespace: str, warning: str) -> None:
+        # Called from a pre-flight worker thread; the Mapping is running, it
+        # just has an upstream that looks wrong.
+        self.kubestatus.post(
+            "Mapping", name, namespace, dump_json({"state": "Running", "warning": warning})
+        )
+        self.kubestatus.flush()
+
     def post_timer_event(self) -> None:
         # Post an event to do a timer check.
         self.watcher.post("TIMER", None)
@@ -843,6 +948,7 @@ def system_info(app):
         "env_failures": getattr(app.watcher, "failure_list", ["no IR loaded"]),
         "env_status": status_dict,
         "debug_mode": debug_mode,
+        "external_addresses": ir.external_addresses if ir else [],
     }
 
 
@@ -941,6 +1047,20 @@ def handle_watt_update():
     return info, status
 
 
+@app.route("/_internal/v0/host_probes", methods=["POST"])
+@internal_handler
+def handle_host_probes():
+    # The entrypoint's Host prober posts what it found after every round.
+    probes = request.get_json(silent=True)
+
+    if not isinstance(probes, list):
+        return "error: host probes must be a JSON list\n", 400
+
+    app.host_probes = probes
+
+    return "OK\n", 200
+
+
 @app.route("/_internal/v0/fs", methods=["POST"])
 @internal_handler
 def handle_fs():
@@ -1003,6 +1123,142 @@ def check_ready():
         return "ambassador not ready (%s)\n" % status["since_update"], 503
 
 
+@app.route("/ambassador/v0/addresses", methods=["GET"])
+def show_addresses():
+    # This is where anything that needs to know how Ambassador is reached from
+    # outside the cluster (the ACME client checking that an HTTP-01 challenge
+    # will actually reach us, for example) should look, rather than guessing
+    # which Service is the right one.
+    if not app.ir:
+        return "ambassador waiting for config\n", 503
+
+    return jsonify(app.ir.external_addresses), 200
+
+
+@app.route("/ambassador/v0/timeouts", methods=["GET"])
+def show_timeouts():
+    # The effective timeouts of every route on every Host, and which resource each
+    # one came from. ?mapping=name.namespace and ?host=hostname narrow it down.
+    if not app.ir:
+        return "ambassador waiting for config\n", 503
+
+    report = timeout_report(app.ir)
+
+    mapping = request.args.get("mapping", None)
+    host = request.args.get("host", None)
+
+    if mapping:
+        report = [entry for entry in report if entry["mapping"] == mapping]
+
+    if host:
+        report = [entry for entry in report if entry["host"] == host]
+
+    return jsonify(report), 200
+
+
+@app.route("/ambassador/v0/tls_policies", methods=["GET"])
+def show_tls_policies():
+    # The TLS policy of every Host on every Listener, where it came from, and how
+    # it's weaker than the Module's tls_policy. ?host=name.namespace narrows it down.
+    if not app.ir:
+        return "ambassador waiting for config\n", 503
+
+    report = tls_policy_report(app.ir)
+
+    host = request.args.get("host", None)
+
+    if host:
+        report = [entry for entry in report if entry["host"] == host]
+
+    return jsonify(report), 200
+
+
+@app.route("/ambassador/v0/dry_run", methods=["POST"])
+def dry_run_proposed():
+    # POST one or more resources (YAML or JSON) to find out whether they're valid,
+    # and how they'd change the Envoy config, without applying them. They're
+    # compiled along with the last snapshot that made it to Envoy.
+    if not app.ir:
+        return "ambassador waiting for config\n", 503
+
+    try:
+        with open(os.path.join(app.snapshot_path, "snapshot.yaml"), "r") as f:
+            serialization = f.read()
+    except OSError:
+        return "no snapshot to dry run against\n", 503
+
+    try:
+        proposed = check_proposed(parse_yaml(request.get_data(as_text=True)))
+    except (yaml.YAMLError, DryRunError) as e:
+        return f"error: {e}\n", 400
+
+    scc = SecretHandler(app.logger, "dry_run", app.snapshot_path, "dry_run")
+    result = dry_run(app.logger, serialization, proposed, secret_handler=scc)
+
+    return jsonify(result), 200
+
+
+@app.route("/ambassador/v0/diag/at", methods=["GET"])
+@standard_handler
+def show_diag_at(reqid=None):
+    # What the route table looked like at ?time=... (seconds since the epoch, or
+    # ISO 8601), from the snapshot that was in effect then. Only the snapshots
+    # that AMBASSADOR_SNAPSHOT_COUNT says to keep are there to look at.
+    if not app.ir:
+        return "ambassador waiting for config\n", 503
+
+    if not _allow_diag_ui():
+        return Response("Not found\n", 404)
+
+    value = request.args.get("time", None)
+
+    if not value:
+        return "error: time is required\n", 400
+
+    try:
+        when = parse_time(value)
+    except ValueError:
+        return f"error: can't make sense of time {value!r}\n", 400
+
+    snapshot = snapshot_at(app.snapshot_path, when)
+
+    if not snapshot:
+        return f"no snapshot kept from as long ago as {value}\n", 404
+
+    app.logger.debug("AT %s - compiling %s for %s" % (reqid, snapshot.path, value))
+
+    try:
+        with open(snapshot.path, "r") as f:
+            serialization = f.read()
+    except OSError as e:
+        # It got rotated away while we were looking.
+        return f"snapshot for {value} is gone: {e}\n", 404
+
+    scc = SecretHandler(app.logger, "diag_at", app.snapshot_path, "diag_at")
+    compiled = Compile(app.logger, serialization, secret_handler=scc)
+
+    if "xds" not in compiled:
+        return f"snapshot for {value} doesn't compile\n", 500
+
+    # Today's Envoy stats don't say anything about back then.
+    diag = Diagnostics(compiled["ir"], compiled["xds"])
+    ov = diag.overview(request, EnvoyStats())
+    diag_dict = diag.as_dict()
+
+    return jsonify(
+        {
+            "time": datetime.datetime.fromtimestamp(when, datetime.timezone.utc).isoformat(),
+            "snapshot": os.path.basename(snapshot.path),
+            "since": datetime.datetime.fromtimestamp(
+                snapshot.since, datetime.timezone.utc
+            ).isoformat(),
+            "route_info": ov.get("route_info", []),
+            "errors": diag_dict["errors"],
+            "notices": diag_dict["notices"],
+        }
+    )
+
+
 @app.route("/ambassador/v0/diag/", methods=["GET"])
 @standard_handler
 def show_overview(reqid=None):
@@ -1055,6 +1311,7 @@ def show_overview(reqid=None):
         envoy_status=envoy_status(estats),
         loginfo=app.estatsmgr.loginfo,
         notices=app.notices.notices,
+        host_probes=app.host_probes,
         banner_content=banner_content,
         **ov,
         **ddict,
@@ -1126,7 +1383,7 @@ def collect_errors_and_notices(request, reqid, what: str, diag: Diagnostics) ->
             err_key = ""
 
         for err in err_list:
-            errors.append((err_key, err["error"]))
+            errors.append((err_key, err["error"], err.get("code", "")))
 
     dnotices = ddict.pop("notices", {})
 
@@ -1315,6 +1572,14 @@ class KubeStatus:
         self.current_status: Dict[str, str] = {}
         self.pool = concurrent.futures.ProcessPoolExecutor(max_workers=5)
 
+        # Updates go to the entrypoint's status writer in batches, which coalesces them and
+        # rate-limits the writes to the API server.
+        self.status_endpoint = os.environ.get(
+            "AMBASSADOR_STATUS_ENDPOINT", "http://localhost:9696/status"
+        )
+        self.pending: List[Dict[str, Any]] = []
+        self.pending_lock = threading.Lock()
+
     def mark_live(self, kind: str, name: str, namespace: str) -> None:
         key = f"{kind}/{name}.{namespace}"
 
@@ -1346,7 +1611,20 @@ class KubeStatus:
 
             # For now we're going to assume that this works.
             self.current_status[key] = text
-            f = self.pool.submit(kubestatus_update, kind, name, namespace, text)
+
+            with self.pending_lock:
+                self.pending.append(
+                    {"kind": kind, "name": name, "namespace": namespace, "status": json.loads(text)}
+                )
+
+    def flush(self) -> None:
+        # Send everything posted since the last flush off in one batch.
+        with self.pending_lock:
+            updates = self.pending
+            self.pending = []
+
+        if updates:
+            f = self.pool.submit(statuswriter_update, self.status_endpoint, updates)
             f.add_done_callback(kubestatus_update_done)
 
 
@@ -1400,6 +1678,26 @@ def kubestatus_update(kind: str, name: str, namespace: str, text: str) -> str:
         return f"{name}.{namespace}: timed out\n\n{e.output}"
 
 
+def statuswriter_update(endpoint: str, updates: List[Dict[str, Any]]) -> str:
+    try:
+        rc = requests.post(
+            endpoint,
+            data=json.dumps(updates),
+            headers={"Content-Type": "application/json"},
+            timeout=5,
+        )
+        if rc.status_code == 202:
+            return f"{len(updates)} updates queued"
+    except requests.exceptions.RequestException:
+        pass
+
+    # The status writer isn't there, so write them ourselves.
+    return "\n".join(
+        kubestatus_update(u["kind"], u["name"], u["namespace"], json.dumps(u["status"]))
+        for u in updates
+    )
+
+
 def kubestatus_update_done(f: concurrent.futures.Future) -> None:
     # print(f"KubeStatus DONE {os.getpid()}: result {f.result()}")
     pass
@@ -1675,6 +1973,8 @@ class AmbassadorEventWatcher(threading.Thread):
                 cache=self.app.cache,
             )
 
+        self.app.record_resource_timings(ir.resource_timings, reset=reset_cache)
+
         ir_path = os.path.join(app.snapshot_path, "ir-tmp.json")
         open(ir_path, "w").write(ir.as_json())
 
@@ -1806,6 +2106,14 @@ class AmbassadorEventWatcher(threading.Thread):
 
         app.kubestatus.prune()
 
+        preflight = irpreflight.checker()
+
+        if preflight:
+            preflight.retain(
+                (name.split(".", 1)[0], mapping.get("namespace", Config.ambassador_namespace))
+                for name, mapping in (mappings or {}).items()
+            )
+
         if app.ir.k8s_status_updates:
             update_count = 0
 
@@ -1820,6 +2128,8 @@ class AmbassadorEventWatcher(threading.Thread):
 
                 app.kubestatus.post(kind, resource_name, namespace, text)
 
+            app.kubestatus.flush()
+
         group_count = len(app.ir.groups)
         cluster_count = len(app.ir.clusters)
         listener_count = len(app.ir.listeners)
@@ -1887,6 +2197,7 @@ class AmbassadorEventWatcher(threading.Thread):
         env_status = SystemStatus()
 
         error_count = 0
+        noncritical_count = 0
         tls_count = 0
         mapping_count = 0
 
@@ -1906,11 +2217,16 @@ class AmbassadorEventWatcher(threading.Thread):
                         err_key = ""
 
                     for err in err_list:
-                        error_count += 1
                         err_text = err["error"]
 
                         self.app.logger.info(f"error {err_key} {err_text}")
 
+                        if not is_critical_error(err):
+                            noncritical_count += 1
+                            continue
+
+                        error_count += 1
+
                         if err_text.find("CRD") >= 0:
                             if err_text.find("core") >= 0:
                                 chime_failures["core CRDs"] = True
@@ -1951,6 +2267,12 @@ class AmbassadorEventWatcher(threading.Thread):
         else:
             env_status.OK("Error check", "No errors logged")
 
+        if noncritical_count:
+            env_status.OK(
+                "Non-critical errors",
+                f'{noncritical_count} error{"" if (noncritical_count == 1) else "s"} logged for non-critical Mappings',
+            )
+
         if tls_count:
             env_status.OK(
                 "TLS", f'{tls_count} TLSContext{" is" if (tls_count == 1) else "s are"} active'
@@ -2153,8 +2475,9 @@ class AmbassadorEventWatcher(threading.Thread):
         with open(econf_validation_path, "w") as output:
             output.write(config_json)
 
+        # Validate with the Envoy that's going to run this (see cmd/entrypoint/envoyprobe.go).
         command = [
-            "envoy",
+            os.environ.get("AMBASSADOR_ENVOY_BINARY", "") or "envoy",
             "--service-node",
             "test-id",
             "--service-cluster",
@@ -80,3 +80,40 @@ def test_dd_entity_id_set_typical():
             ]
         },
     )
+
+
+def _xds_cluster(bootstrap):
+    for cluster in bootstrap["static_resources"]["clusters"]:
+        if cluster["name"] == "xds_cluster":
+            return cluster
+    assert False, "no xds_cluster"
+
+
+def test_xds_tls():
+    yaml = module_and_mapping_manifests(None, [])
+
+    # By default, ADS is cleartext...
+    econf = econf_compile(yaml)
+    assert "transport_socket" not in _xds_cluster(econf["bootstrap"])
+
+    # ...but with the bootstrap CA, it's mutual TLS.
+    os.environ["AMBASSADOR_XDS_TLS_DIR"] = "/ambassador/bootstrap-ca"
+    try:
+        econf = econf_compile(yaml)
+    finally:
+        del os.environ["AMBASSADOR_XDS_TLS_DIR"]
+
+    bootstrap = econf["bootstrap"]
+    bootstrap.pop("@type", None)
+    assert_valid_envoy_config(bootstrap)
+
+    tls = _xds_cluster(bootstrap)["transport_socket"]["typed_config"]["common_tls_context"]
+    assert tls["tls_certificates"] == [
+        {
+            "certificate_chain": {"filename": "/ambassador/bootstrap-ca/envoy-client.crt"},
+            "private_key": {"filename": "/ambassador/bootstrap-ca/envoy-client.key"},
+        }
+    ]
+    assert tls["validation_context"] == {
+        "trusted_ca": {"filename": "/ambassador/bootstrap-ca/ca.crt"}
+    }
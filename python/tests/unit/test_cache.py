@@ -493,6 +493,25 @@ def test_long_cluster_1(tmp_path):
     print("test_long_cluster_1 done")
 
 
+@pytest.mark.compilertest
+def test_resync_resets_cache():
+    # A snapshot that finishes a resync resets the cache, even when its deltas alone
+    # would have allowed an incremental reconfiguration.
+    delta = {
+        "kind": "Mapping",
+        "apiVersion": "getambassador.io/v3alpha1",
+        "metadata": {"name": "foo", "namespace": "default"},
+        "deltaType": "add",
+    }
+
+    for resync, wanted in [(0, ("incremental", False)), (3, ("complete", True))]:
+        fetcher = ResourceFetcher(logger, Config())
+        fetcher.parse_watt(json.dumps({"Kubernetes": {}, "Deltas": [delta], "Resync": resync}))
+
+        config_type, reset_cache, _ = IR.check_deltas(logger, fetcher, Cache(logger))
+        assert (config_type, reset_cache) == wanted
+
+
 @pytest.mark.compilertest
 def test_mappings_same_name_delta(tmp_path):
     # Tests that multiple Mappings with the same name (but in different namespaces)
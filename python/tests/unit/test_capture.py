@@ -0,0 +1,38 @@
+import pytest
+
+from tests.utils import econf_compile, econf_foreach_hcm, module_and_mapping_manifests
+
+
+def _http_filter_names(yaml):
+    econf = econf_compile(yaml)
+    names = []
+
+    def check(typed_config):
+        names.append([f["name"] for f in typed_config["http_filters"]])
+
+    econf_foreach_hcm(econf, check)
+    return names
+
+
+@pytest.mark.compilertest
+def test_capture_tap_filter(monkeypatch):
+    yaml = module_and_mapping_manifests(None, [])
+
+    # Without the feature gate, there's no tap filter.
+    monkeypatch.setenv("AMBASSADOR_FEATURE_GATES", "DeltaXDS=false,TrafficCapture=false")
+    for names in _http_filter_names(yaml):
+        assert "envoy.filters.http.tap" not in names
+
+    # With it, every HCM starts with one.
+    monkeypatch.setenv("AMBASSADOR_FEATURE_GATES", "DeltaXDS=false,TrafficCapture=true")
+    econf = econf_compile(yaml)
+
+    def check(typed_config):
+        tap = typed_config["http_filters"][0]
+        assert tap["name"] == "envoy.filters.http.tap"
+        assert tap["typed_config"]["common_config"] == {
+            "admin_config": {"config_id": "ambassador_capture"}
+        }
+        assert typed_config["http_filters"][-1]["name"] == "envoy.filters.http.router"
+
+    econf_foreach_hcm(econf, check)
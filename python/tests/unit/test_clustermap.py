@@ -0,0 +1,47 @@
+import pytest
+
+from tests.utils import econf_compile, module_and_mapping_manifests
+
+HTTPBIN_SERVICE = """
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: httpbin
+  namespace: default
+spec:
+  ports:
+  - port: 80
+"""
+
+
+def _mapping_entries(econf):
+    # The clustermap entries that the test Mapping routes to.
+    return [
+        entry
+        for entry in econf["clustermap"].values()
+        if {"name": "ambassador", "namespace": "default"} in entry["mappings"]
+    ]
+
+
+@pytest.mark.compilertest
+def test_clustermap_k8s_service():
+    yaml = module_and_mapping_manifests(None, []) + HTTPBIN_SERVICE
+    econf = econf_compile(yaml)
+
+    entries = _mapping_entries(econf)
+    assert len(entries) == 1
+    assert entries[0]["k8s_service"] == {"name": "httpbin", "namespace": "default"}
+
+
+@pytest.mark.compilertest
+def test_clustermap_hostname_is_not_a_service():
+    # api.example.com looks like service api in namespace example, but there's no such Service.
+    yaml = module_and_mapping_manifests(None, []).replace(
+        "service: httpbin", "service: api.example.com"
+    )
+    econf = econf_compile(yaml)
+
+    entries = _mapping_entries(econf)
+    assert len(entries) == 1
+    assert "k8s_service" not in entries[0]
@@ -0,0 +1,119 @@
+import pytest
+
+from ambassador.ir.ircontractcapture import (
+    CAPTURE_PROCESSING_MODE,
+    CONTRACT_CAPTURE_METADATA,
+    SKIP_PROCESSING_MODE,
+    contract_capture_error,
+)
+from tests.utils import compile_with_cachecheck, module_and_mapping_manifests
+
+CAPTURE_FILTER = "envoy.filters.http.ext_proc"
+
+
+def _capture(compiled):
+    routes = []
+    filters = []
+
+    for listener in compiled["xds"].as_dict()["static_resources"]["listeners"]:
+        for chain in listener["filter_chains"]:
+            for f in chain["filters"]:
+                if f["name"] != "envoy.filters.network.http_connection_manager":
+                    continue
+
+                hcm = f["typed_config"]
+                filters += [hf for hf in hcm["http_filters"] if hf["name"] == CAPTURE_FILTER]
+
+                for vhost in hcm["route_config"]["virtual_hosts"]:
+                    for r in vhost["routes"]:
+                        if CAPTURE_FILTER in r.get("typed_per_filter_config", {}):
+                            routes.append(r)
+
+    return routes, filters
+
+
+def _errors(compiled):
+    return [e["error"] for errs in compiled["ir"].aconf.errors.values() for e in errs]
+
+
+def test_contract_capture_error():
+    assert contract_capture_error({"endpoint": "https://contracts.example.com/ingest"}) is None
+    assert (
+        contract_capture_error(
+            {
+                "endpoint": "http://contracts:8080/",
+                "sample_percent": 0,
+                "max_body_bytes": 1024,
+                "redact_headers": ["x-session"],
+            }
+        )
+        is None
+    )
+
+    for bad in [
+        "http://contracts/",
+        {},
+        {"endpoint": "contracts:8080"},
+        {"endpoint": "grpc://contracts:8080"},
+        {"endpoint": "http://c/", "sample_percent": 101},
+        {"endpoint": "http://c/", "sample_percent": True},
+        {"endpoint": "http://c/", "max_body_bytes": -1},
+        {"endpoint": "http://c/", "redact_headers": "x-session"},
+    ]:
+        assert contract_capture_error(bad), bad
+
+
+@pytest.mark.compilertest
+def test_contract_capture():
+    yaml = module_and_mapping_manifests(
+        None, ["contract_capture: {endpoint: 'http://contracts.default:8080/ingest'}"]
+    )
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+    assert not _errors(compiled)
+
+    routes, filters = _capture(compiled)
+    assert routes
+
+    for route in routes:
+        overrides = route["typed_per_filter_config"][CAPTURE_FILTER]["overrides"]
+        assert overrides["processing_mode"] == CAPTURE_PROCESSING_MODE
+
+        grpc_service = overrides["grpc_service"]
+        assert grpc_service["initial_metadata"] == [
+            {"key": CONTRACT_CAPTURE_METADATA, "value": "ambassador.default"}
+        ]
+        cluster_name = grpc_service["envoy_grpc"]["cluster_name"]
+
+    # The filter skips everything, and allows failures, so that routes that don't capture (and
+    # the ones that do, if the entrypoint isn't answering) aren't held up.
+    assert filters
+    for f in filters:
+        assert f["typed_config"]["processing_mode"] == SKIP_PROCESSING_MODE
+        assert f["typed_config"]["failure_mode_allow"] is True
+        assert f["typed_config"]["grpc_service"]["envoy_grpc"]["cluster_name"] == cluster_name
+
+    clusters = compiled["xds"].as_dict()["static_resources"]["clusters"]
+    assert any(c["name"] == cluster_name for c in clusters)
+
+
+@pytest.mark.compilertest
+def test_contract_capture_off():
+    compiled = compile_with_cachecheck(module_and_mapping_manifests(None, []), errors_ok=True)
+
+    # With no Mapping capturing, neither the filter nor its cluster is there.
+    routes, filters = _capture(compiled)
+    assert not routes
+    assert not filters
+
+
+@pytest.mark.compilertest
+def test_contract_capture_invalid():
+    yaml = module_and_mapping_manifests(None, ["contract_capture: {endpoint: 'not a url'}"])
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+
+    errors = _errors(compiled)
+    assert any("Invalid contract_capture specified" in e for e in errors), errors
+
+    routes, filters = _capture(compiled)
+    assert not routes
+    assert not filters
@@ -0,0 +1,137 @@
+import json
+
+import pytest
+
+from ambassador.ir.irdebugheaders import (
+    CONFIG_VERSION_HEADER,
+    DEBUG_HEADERS,
+    MAPPING_HEADER,
+    debug_headers_config,
+)
+from tests.utils import compile_with_cachecheck, econf_foreach_hcm, module_and_mapping_manifests
+
+JWKS = json.dumps({"keys": [{"kty": "oct", "kid": "support", "k": "c2VjcmV0"}]})
+
+DEBUG_HEADERS_CONF = "debug_headers: {jwks: '%s', audiences: [support], max_lifetime_s: 300}" % JWKS
+
+
+def _compile(module_confs, errors_ok=False):
+    yaml = module_and_mapping_manifests(module_confs, [])
+    return compile_with_cachecheck(yaml, errors_ok=errors_ok)
+
+
+def _header_names(headers):
+    return [h["header"]["key"] for h in headers]
+
+
+def test_debug_headers_config():
+    config, error = debug_headers_config({"jwks": JWKS, "header": "X-Debug"})
+
+    assert error is None
+    assert config == {
+        "jwks": JWKS,
+        "header": "x-debug",
+        "audiences": [],
+        "max_lifetime_s": 900,
+    }
+
+
+@pytest.mark.parametrize(
+    "spec",
+    [
+        "yes",
+        {},
+        {"jwks": "not json"},
+        {"jwks": json.dumps({"keys": []})},
+        {"jwks": JWKS, "header": "x debug"},
+        {"jwks": JWKS, "audiences": "support"},
+        {"jwks": JWKS, "max_lifetime_s": 0},
+        {"jwks": JWKS, "max_lifetime_s": "5m"},
+    ],
+)
+def test_debug_headers_config_errors(spec):
+    config, error = debug_headers_config(spec)
+
+    assert config is None
+    assert error
+
+
+@pytest.mark.compilertest
+def test_debug_headers():
+    econf = _compile([DEBUG_HEADERS_CONF])["xds"].as_dict()
+
+    def check(typed_config):
+        filters = typed_config["http_filters"]
+
+        # The token check and the Lua filter come first, so the Lua filter sees the
+        # response last.
+        assert filters[0]["name"] == "envoy.filters.http.jwt_authn"
+        assert filters[1]["name"] == "envoy.filters.http.lua"
+
+        jwt_authn = filters[0]["typed_config"]
+        provider = jwt_authn["providers"]["ambassador_debug"]
+        assert provider["local_jwks"] == {"inline_string": JWKS}
+        assert provider["audiences"] == ["support"]
+        assert provider["from_headers"] == [{"name": "x-ambassador-debug-token"}]
+        assert provider["forward"] is False
+
+        lua = filters[1]["typed_config"]["inline_code"]
+        assert "local max_lifetime = 300" in lua
+
+        for header in DEBUG_HEADERS:
+            assert f'"{header}"' in lua
+
+        for vhost in typed_config["route_config"]["virtual_hosts"]:
+            names = _header_names(vhost["response_headers_to_add"])
+            assert sorted(names) == sorted(h for h in DEBUG_HEADERS if h != MAPPING_HEADER)
+
+            version = [
+                h["header"]["value"]
+                for h in vhost["response_headers_to_add"]
+                if h["header"]["key"] == CONFIG_VERSION_HEADER
+            ]
+            assert len(version) == 1 and version[0]
+
+            for route in vhost["routes"]:
+                if route["match"].get("prefix") != "/httpbin/":
+                    continue
+
+                assert {
+                    "header": {"key": MAPPING_HEADER, "value": "ambassador.default"},
+                    "append": False,
+                } in route["response_headers_to_add"]
+
+    econf_foreach_hcm(econf, check)
+
+
+@pytest.mark.compilertest
+def test_debug_headers_off():
+    econf = _compile(None)["xds"].as_dict()
+
+    def check(typed_config):
+        names = [f["name"] for f in typed_config["http_filters"]]
+        assert "envoy.filters.http.jwt_authn" not in names
+
+        for vhost in typed_config["route_config"]["virtual_hosts"]:
+            assert CONFIG_VERSION_HEADER not in _header_names(
+                vhost.get("response_headers_to_add", [])
+            )
+
+            for route in vhost["routes"]:
+                assert MAPPING_HEADER not in _header_names(route.get("response_headers_to_add", []))
+
+    econf_foreach_hcm(econf, check)
+
+
+@pytest.mark.compilertest
+def test_debug_headers_invalid():
+    compiled = _compile(["debug_headers: {jwks: 'nope'}"], errors_ok=True)
+
+    errors = compiled["ir"].aconf.errors
+    assert any("debug_headers" in e.get("error", "") for errs in errors.values() for e in errs)
+
+    def check(typed_config):
+        names = [f["name"] for f in typed_config["http_filters"]]
+        assert "envoy.filters.http.jwt_authn" not in names
+
+    econf_foreach_hcm(compiled["xds"].as_dict(), check)
@@ -0,0 +1,116 @@
+import json
+import logging
+
+import jsonpatch
+import pytest
+
+from ambassador.compile import Compile
+from ambassador.dryrun import DryRunError, check_proposed, dry_run, propose
+from ambassador.utils import parse_yaml
+from tests.utils import default_listener_manifests
+
+logger = logging.getLogger("ambassador")
+
+MAPPING = """
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+metadata:
+  name: httpbin
+  namespace: default
+spec:
+  hostname: "*"
+  prefix: /httpbin/
+  service: httpbin
+"""
+
+
+def _snapshot(yaml):
+    watt_k8s = {}
+
+    for obj in parse_yaml(yaml):
+        watt_k8s.setdefault(obj["kind"], []).append(obj)
+
+    return json.dumps({"Kubernetes": watt_k8s})
+
+
+def _mapping(name, prefix, service):
+    return {
+        "apiVersion": "getambassador.io/v3alpha1",
+        "kind": "Mapping",
+        "metadata": {"name": name},
+        "spec": {"hostname": "*", "prefix": prefix, "service": service},
+    }
+
+
+def _new_clusters(snapshot, patch):
+    # The clusters that applying the patch to the current config adds.
+    before = Compile(logger, snapshot)["xds"].as_dict()
+    after = jsonpatch.apply_patch(before, patch)
+
+    def names(econf):
+        return {c["name"] for c in econf["static_resources"]["clusters"]}
+
+    return sorted(names(after) - names(before))
+
+
+@pytest.mark.compilertest
+def test_dry_run_new_mapping():
+    snapshot = _snapshot(default_listener_manifests() + MAPPING)
+    result = dry_run(logger, snapshot, check_proposed(_mapping("quote", "/quote/", "quote")))
+
+    assert result["valid"]
+    assert result["errors"] == {}
+    assert _new_clusters(snapshot, result["diff"]) == ["cluster_quote_default"]
+
+
+@pytest.mark.compilertest
+def test_dry_run_replaces_existing():
+    snapshot = _snapshot(default_listener_manifests() + MAPPING)
+    proposed = check_proposed([_mapping("httpbin", "/httpbin/", "httpbin-v2")])
+
+    # The proposed Mapping takes the place of the one with its name.
+    watt_k8s = json.loads(propose(snapshot, proposed))["Kubernetes"]
+    assert [m["spec"]["service"] for m in watt_k8s["Mapping"]] == ["httpbin-v2"]
+
+    result = dry_run(logger, snapshot, proposed)
+    assert result["valid"]
+    assert _new_clusters(snapshot, result["diff"]) == ["cluster_httpbin_v2_default"]
+
+
+@pytest.mark.compilertest
+def test_dry_run_invalid():
+    snapshot = _snapshot(default_listener_manifests() + MAPPING)
+    bad = _mapping("broken", "/broken/", "broken")
+    bad["spec"]["load_balancer"] = {"policy": "whatever"}
+
+    result = dry_run(logger, snapshot, check_proposed(bad))
+
+    assert not result["valid"]
+    assert result["errors"]
+    assert all(rkey.startswith("broken.default") for rkey in result["errors"])
+    assert _new_clusters(snapshot, result["diff"]) == []
+
+
+@pytest.mark.compilertest
+def test_dry_run_no_change():
+    snapshot = _snapshot(default_listener_manifests() + MAPPING)
+    result = dry_run(logger, snapshot, check_proposed(parse_yaml(MAPPING)))
+
+    assert result["valid"]
+    assert result["diff"] == []
+
+
+@pytest.mark.compilertest
+@pytest.mark.parametrize(
+    "proposed",
+    [
+        [],
+        "Mapping",
+        [{"kind": "Mapping", "metadata": {"name": "x"}}],
+        [{"apiVersion": "getambassador.io/v3alpha1", "kind": "Mapping", "metadata": {}}],
+    ],
+)
+def test_check_proposed_rejects(proposed):
+    with pytest.raises(DryRunError):
+        check_proposed(proposed)
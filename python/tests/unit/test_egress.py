@@ -0,0 +1,138 @@
+import pytest
+
+from ambassador.envoy.v3.v3egress import egress_cluster_name
+from ambassador.ir.iregress import IREgressPolicy
+from tests.utils import compile_with_cachecheck, module_and_mapping_manifests
+
+POLICIES = """
+---
+apiVersion: getambassador.io/v3alpha1
+kind: EgressPolicy
+metadata:
+  name: payments
+  namespace: default
+spec:
+  port: 9080
+  destinations:
+  - host: api.payments.example.com
+  - host: ledger.example.com
+    ports: [8080, 8443]
+---
+apiVersion: getambassador.io/v3alpha1
+kind: EgressPolicy
+metadata:
+  name: partners
+  namespace: default
+spec:
+  port: 9080
+  destinations:
+  - host: partner.example.com
+    ports: [8443]
+    tls: true
+    sni: tls.partner.example.com
+  - host: "*.example.com"
+"""
+
+
+def _egress_listener(compiled):
+    for listener in compiled["xds"].as_dict()["static_resources"]["listeners"]:
+        if listener["name"] == "ambassador-egress-9080":
+            return listener
+
+    return None
+
+
+def test_egress_destinations():
+    dests = []
+    assert IREgressPolicy.add_destinations(dests, {"host": "API.example.com"}) is None
+    assert (
+        IREgressPolicy.add_destinations(dests, {"host": "db.example.com", "ports": [5432, 443]})
+        is None
+    )
+
+    assert [(d["host"], d["port"], d["default"], d["tls"], d["sni"]) for d in dests] == [
+        ("api.example.com", 443, True, True, "api.example.com"),
+        ("db.example.com", 5432, True, False, "db.example.com"),
+        ("db.example.com", 443, False, True, "db.example.com"),
+    ]
+
+    for bad in [
+        {"host": "*.example.com"},
+        {"host": "example.com", "ports": [0]},
+        {"host": "example.com", "ports": "443"},
+        {"host": "example.com", "tls": "yes"},
+        {"host": "example.com", "sni": "bad sni"},
+        "example.com",
+    ]:
+        assert IREgressPolicy.add_destinations(dests, bad), bad
+
+    assert len(dests) == 3
+
+
+def test_egress_cluster_names():
+    assert egress_cluster_name("api.example.com", 443) == "egress_api_example_com_443"
+
+    long_name = egress_cluster_name("a" * 80 + ".example.com", 443)
+    assert len(long_name) == 60
+    assert long_name != egress_cluster_name("a" * 80 + ".example.org", 443)
+
+
+@pytest.mark.compilertest
+def test_egress_listener():
+    yaml = module_and_mapping_manifests(None, []) + POLICIES
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+
+    listener = _egress_listener(compiled)
+    assert listener is not None
+    assert listener["address"]["socket_address"]["port_value"] == 9080
+
+    hcm = listener["filter_chains"][0]["filters"][0]["typed_config"]
+    assert hcm["access_log"][0]["typed_config"]["json_format"]["egress_port"] == "9080"
+
+    vhosts = {vh["name"]: vh for vh in hcm["route_config"]["virtual_hosts"]}
+
+    assert vhosts["egress_api_payments_example_com_443"]["domains"] == [
+        "api.payments.example.com:443",
+        "api.payments.example.com",
+    ]
+    assert vhosts["egress_ledger_example_com_8080"]["domains"] == [
+        "ledger.example.com:8080",
+        "ledger.example.com",
+    ]
+    assert vhosts["egress_ledger_example_com_8443"]["domains"] == ["ledger.example.com:8443"]
+
+    # Everything else is refused, and that has to come last.
+    deny = hcm["route_config"]["virtual_hosts"][-1]
+    assert deny["domains"] == ["*"]
+    assert deny["routes"][0]["direct_response"]["status"] == 403
+
+    clusters = {c["name"]: c for c in compiled["xds"].as_dict()["static_resources"]["clusters"]}
+
+    payments = clusters["egress_api_payments_example_com_443"]
+    assert payments["type"] == "LOGICAL_DNS"
+    assert payments["transport_socket"]["typed_config"]["sni"] == "api.payments.example.com"
+
+    assert "transport_socket" not in clusters["egress_ledger_example_com_8080"]
+
+    partner = clusters["egress_partner_example_com_8443"]
+    tls = partner["transport_socket"]["typed_config"]
+    assert tls["sni"] == "tls.partner.example.com"
+    assert tls["common_tls_context"]["validation_context"]["match_typed_subject_alt_names"] == [
+        {"san_type": "DNS", "matcher": {"exact": "tls.partner.example.com"}}
+    ]
+
+    # The wildcard destination is reported, and skipped.
+    errors = compiled["ir"].aconf.errors
+    assert any("*.example.com" in e["error"] for errs in errors.values() for e in errs), errors
+
+
+@pytest.mark.compilertest
+def test_egress_listener_port_conflict():
+    yaml = module_and_mapping_manifests(None, []) + POLICIES.replace("9080", "8080")
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+
+    for listener in compiled["xds"].as_dict()["static_resources"]["listeners"]:
+        assert not listener["name"].startswith("ambassador-egress-")
+
+    errors = compiled["ir"].aconf.errors
+    assert any("already in use" in e["error"] for errs in errors.values() for e in errs), errors
@@ -0,0 +1,89 @@
+from ambassador.envoy.v3.v3extensions import missing_extensions
+
+PROBE = {
+    "binary": "/envoy/envoy",
+    "extensions": {
+        "envoy.filters.http.router": ["envoy.extensions.filters.http.router.v3.Router"],
+        "envoy.filters.listener.tls_inspector": [],
+        "envoy.filters.network.http_connection_manager": [
+            "envoy.extensions.filters.network.http_connection_manager.v3.HttpConnectionManager"
+        ],
+        "envoy.transport_sockets.tls": [
+            "envoy.extensions.transport_sockets.tls.v3.DownstreamTlsContext"
+        ],
+    },
+}
+
+
+def _listener(http_filters):
+    return {
+        "static_resources": {
+            "listeners": [
+                {
+                    "name": "ambassador-listener-8443",
+                    "listener_filters": [{"name": "envoy.filters.listener.tls_inspector"}],
+                    "filter_chains": [
+                        {
+                            "filters": [
+                                {
+                                    "name": "envoy.filters.network.http_connection_manager",
+                                    "typed_config": {
+                                        "@type": "type.googleapis.com/envoy.extensions.filters.network.http_connection_manager.v3.HttpConnectionManager",
+                                        "http_filters": http_filters,
+                                    },
+                                }
+                            ],
+                            "transport_socket": {
+                                "name": "envoy.transport_sockets.tls",
+                                "typed_config": {
+                                    "@type": "type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.DownstreamTlsContext"
+                                },
+                            },
+                        }
+                    ],
+                }
+            ]
+        }
+    }
+
+
+def test_envoy_extensions_present():
+    config = _listener([{"name": "envoy.filters.http.router"}])
+    assert missing_extensions(config, PROBE) == []
+
+
+def test_envoy_extensions_missing():
+    config = _listener(
+        [
+            {
+                "name": "envoy.filters.http.ext_authz",
+                "typed_config": {
+                    "@type": "type.googleapis.com/envoy.extensions.filters.http.ext_authz.v3.ExtAuthz"
+                },
+            },
+            {"name": "envoy.filters.http.router"},
+        ]
+    )
+
+    assert missing_extensions(config, PROBE) == [
+        (
+            "static_resources.listeners[ambassador-listener-8443].filter_chains.filters"
+            "[envoy.filters.network.http_connection_manager].typed_config.http_filters",
+            "envoy.filters.http.ext_authz",
+        )
+    ]
+
+
+def test_envoy_extensions_by_type():
+    # An extension registered under another name is still found by its type.
+    config = _listener(
+        [
+            {
+                "name": "router",
+                "typed_config": {
+                    "@type": "type.googleapis.com/envoy.extensions.filters.http.router.v3.Router"
+                },
+            }
+        ]
+    )
+    assert missing_extensions(config, PROBE) == []
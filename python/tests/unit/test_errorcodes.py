@@ -0,0 +1,28 @@
+import re
+
+import pytest
+
+from ambassador import errorcodes
+
+
+@pytest.mark.compilertest
+def test_error_code_format():
+    for code, error_code in errorcodes.ERROR_CODES.items():
+        assert re.fullmatch(r"AMB\d{4}", code), f"{code} is not of the form AMBnnnn"
+        assert error_code.code == code
+        assert str(error_code) == code
+        assert error_code.summary, f"{code} has no summary"
+
+
+@pytest.mark.compilertest
+def test_code_for_kind():
+    for kind, wanted in [
+        ("Mapping", errorcodes.INVALID_MAPPING),
+        ("IRHTTPMapping", errorcodes.INVALID_MAPPING),
+        ("Host", errorcodes.INVALID_HOST),
+        ("ir.tracing", errorcodes.INVALID_TRACING_SERVICE),
+        ("KubernetesServiceResolver", errorcodes.INVALID_RESOLVER),
+        ("SomethingElse", errorcodes.UNKNOWN),
+        (None, errorcodes.UNKNOWN),
+    ]:
+        assert errorcodes.code_for_kind(kind) == wanted, f"wrong code for {kind}"
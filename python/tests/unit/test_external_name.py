@@ -0,0 +1,70 @@
+import pytest
+
+from tests.utils import econf_compile, econf_foreach_cluster, module_and_mapping_manifests
+
+EXTERNAL_NAME_SERVICE = """
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: httpbin
+  namespace: default
+spec:
+  type: ExternalName
+  externalName: HTTPBin.Example.COM.
+"""
+
+
+def _endpoint_address(cluster):
+    lb_endpoints = cluster["load_assignment"]["endpoints"][0]["lb_endpoints"]
+    assert len(lb_endpoints) == 1
+    return lb_endpoints[0]["endpoint"]["address"]["socket_address"]
+
+
+@pytest.mark.compilertest
+@pytest.mark.parametrize("resolver", [None, "endpoint"])
+def test_external_name_logical_dns(resolver):
+    # Both Kubernetes resolvers go to the target, and leave it to Envoy to keep up with it.
+    mapping_confs = [f"resolver: {resolver}"] if resolver else []
+    yaml = module_and_mapping_manifests(None, mapping_confs) + EXTERNAL_NAME_SERVICE
+    econf = econf_compile(yaml)
+
+    def check(cluster):
+        assert cluster["type"] == "LOGICAL_DNS"
+        assert cluster["respect_dns_ttl"] is True
+        assert "eds_cluster_config" not in cluster
+
+        address = _endpoint_address(cluster)
+        assert address["address"] == "httpbin.example.com"
+        assert address["port_value"] == 80
+
+    econf_foreach_cluster(econf, check)
+
+
+@pytest.mark.compilertest
+def test_external_name_sni():
+    yaml = module_and_mapping_manifests(None, []) + EXTERNAL_NAME_SERVICE
+    yaml = yaml.replace("service: httpbin", "service: https://httpbin")
+    econf = econf_compile(yaml)
+
+    def check(cluster):
+        assert cluster["type"] == "LOGICAL_DNS"
+        assert _endpoint_address(cluster)["port_value"] == 443
+
+        tls = cluster["transport_socket"]["typed_config"]
+        assert tls["sni"] == "httpbin.example.com"
+
+    econf_foreach_cluster(econf, check, name="cluster_https___httpbin_otls_default")
+
+
+@pytest.mark.compilertest
+def test_cluster_ip_service_unchanged():
+    yaml = module_and_mapping_manifests(None, [])
+    econf = econf_compile(yaml)
+
+    def check(cluster):
+        assert cluster["type"] == "STRICT_DNS"
+        assert "respect_dns_ttl" not in cluster
+        assert _endpoint_address(cluster)["address"] == "httpbin"
+
+    econf_foreach_cluster(econf, check)
@@ -0,0 +1,89 @@
+import pytest
+
+from tests.utils import (
+    compile_with_cachecheck,
+    econf_compile,
+    econf_foreach_cluster,
+    module_and_mapping_manifests,
+)
+
+FAILOVER_CLUSTER = "cluster_httpbin_default_fo_httpbin_backup_80"
+
+
+@pytest.mark.compilertest
+def test_failover_priorities():
+    yaml = module_and_mapping_manifests(
+        None,
+        [
+            "failover:",
+            "    services: [httpbin.backup]",
+            "    healthy_percent: 80",
+            "    consecutive_5xx: 3",
+            "    ejection_time_ms: 10000",
+        ],
+    )
+    econf = econf_compile(yaml)
+
+    def check(cluster):
+        assert cluster["type"] == "STRICT_DNS"
+
+        endpoints = cluster["load_assignment"]["endpoints"]
+        assert len(endpoints) == 2
+        assert "priority" not in endpoints[0]
+        assert endpoints[1]["priority"] == 1
+
+        fallback = endpoints[1]["lb_endpoints"][0]["endpoint"]["address"]["socket_address"]
+        assert fallback["address"].startswith("httpbin.backup")
+        assert fallback["port_value"] == 80
+
+        assert cluster["load_assignment"]["policy"] == {"overprovisioning_factor": 125}
+        assert cluster["outlier_detection"] == {
+            "consecutive_5xx": 3,
+            "base_ejection_time": "10.000s",
+            "max_ejection_percent": 100,
+        }
+
+    econf_foreach_cluster(econf, check, name=FAILOVER_CLUSTER)
+
+
+@pytest.mark.compilertest
+def test_failover_defaults():
+    yaml = module_and_mapping_manifests(None, ["failover: {services: [httpbin.backup]}"])
+    econf = econf_compile(yaml)
+
+    def check(cluster):
+        assert "policy" not in cluster["load_assignment"]
+        assert cluster["outlier_detection"] == {
+            "consecutive_5xx": 5,
+            "base_ejection_time": "30.000s",
+            "max_ejection_percent": 100,
+        }
+
+    econf_foreach_cluster(econf, check, name=FAILOVER_CLUSTER)
+
+
+@pytest.mark.compilertest
+def test_failover_endpoint_resolver():
+    # EDS load assignments come from ambex, so there's nowhere to put the fallbacks.
+    yaml = module_and_mapping_manifests(
+        None, ["resolver: endpoint", "failover: {services: [httpbin.backup]}"]
+    )
+    econf = econf_compile(yaml)
+
+    def check(cluster):
+        assert cluster["type"] == "EDS"
+        assert "load_assignment" not in cluster
+        assert "outlier_detection" not in cluster
+
+    econf_foreach_cluster(econf, check, name=FAILOVER_CLUSTER)
+
+
+@pytest.mark.compilertest
+def test_failover_invalid():
+    yaml = module_and_mapping_manifests(None, ["failover: {services: []}"])
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+
+    errors = compiled["ir"].aconf.errors
+    assert any(
+        "Invalid failover" in e["error"] for errs in errors.values() for e in errs
+    ), errors
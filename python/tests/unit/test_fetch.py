@@ -16,6 +16,7 @@ logger = logging.getLogger("ambassador")
 
 from ambassador import Config
 from ambassador.fetch import ResourceFetcher
+from ambassador.fetch.addresses import ExternalAddress
 from ambassador.fetch.ambassador import AmbassadorProcessor
 from ambassador.fetch.dependency import (
     DependencyManager,
@@ -35,6 +36,7 @@ from ambassador.fetch.k8sprocessor import (
     DeduplicatingKubernetesProcessor,
     KubernetesProcessor,
 )
+from ambassador.fetch.knative import KnativeIngressProcessor
 from ambassador.fetch.location import LocationManager
 from ambassador.fetch.resource import NormalizedResource, ResourceManager
 from ambassador.utils import parse_yaml
@@ -481,5 +483,267 @@ class TestDependencyManager:
         assert self.deps.sorted_watt_keys() == ["secret", "service", "ingressclasses"]
 
 
+internal_ambassador_service = k8s_object_from_yaml(
+    """
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: ambassador-internal
+  namespace: ambassador
+spec:
+  type: NodePort
+  ports:
+  - port: 80
+    nodePort: 30080
+  - port: 443
+    nodePort: 30443
+"""
+)
+
+external_ambassador_service = k8s_object_from_yaml(
+    """
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: ambassador
+  namespace: ambassador
+spec:
+  type: LoadBalancer
+  externalIPs:
+  - 192.0.2.10
+  ports:
+  - port: 443
+  - port: 80
+status:
+  loadBalancer:
+    ingress:
+    - hostname: lb.example.com
+    - ip: 192.0.2.10
+"""
+)
+
+
+class TestServiceDependency:
+    def test_prefers_load_balancer(self):
+        dep = ServiceDependency()
+
+        # Order shouldn't matter: the LoadBalancer always wins.
+        dep.add_ambassador_service(external_ambassador_service)
+        dep.add_ambassador_service(internal_ambassador_service)
+
+        assert dep.ambassador_service == external_ambassador_service
+
+    def test_external_addresses(self):
+        dep = ServiceDependency()
+        dep.add_ambassador_service(internal_ambassador_service)
+        dep.add_ambassador_service(external_ambassador_service)
+
+        assert dep.external_addresses() == [
+            ExternalAddress(
+                service="ambassador.ambassador",
+                type=ExternalAddress.LOAD_BALANCER,
+                hostname="lb.example.com",
+                ports=(80, 443),
+            ),
+            ExternalAddress(
+                service="ambassador.ambassador",
+                type=ExternalAddress.LOAD_BALANCER,
+                ip="192.0.2.10",
+                ports=(80, 443),
+            ),
+            ExternalAddress(
+                service="ambassador.ambassador",
+                type=ExternalAddress.EXTERNAL_IP,
+                ip="192.0.2.10",
+                ports=(80, 443),
+            ),
+            ExternalAddress(
+                service="ambassador-internal.ambassador",
+                type=ExternalAddress.NODE_PORT,
+                ports=(30080, 30443),
+            ),
+        ]
+
+
+def knative_ingress(annotations: dict, paths: list) -> KubernetesObject:
+    return KubernetesObject(
+        {
+            "apiVersion": "networking.internal.knative.dev/v1alpha1",
+            "kind": "Ingress",
+            "metadata": {
+                "annotations": annotations,
+                "generation": 1,
+                "name": "helloworld-go",
+                "namespace": "test",
+            },
+            "spec": {
+                "rules": [
+                    {
+                        "hosts": ["helloworld-go.test.example.com"],
+                        "http": {"paths": paths},
+                    }
+                ],
+            },
+        }
+    )
+
+
+tagged_knative_ingress = knative_ingress(
+    {"networking.knative.dev/ingress-class": "ambassador.ingress.networking.knative.dev"},
+    [
+        {
+            "headers": {"Knative-Serving-Tag": {"exact": "latest"}},
+            "rewriteHost": "latest-helloworld-go.test.svc.cluster.local",
+            "splits": [
+                {"percent": 100, "serviceName": "helloworld-go-00002"},
+                {"percent": 0, "serviceName": "helloworld-go-00001"},
+            ],
+        },
+        {
+            "splits": [
+                {"percent": 90, "serviceName": "helloworld-go-00001"},
+                {"percent": 10, "serviceName": "helloworld-go-00002"},
+            ],
+        },
+    ],
+)
+
+
+class TestKnativeIngressProcessor:
+    def process(self, obj: KubernetesObject):
+        aconf = Config()
+        service_dep = ServiceDependency()
+        service_dep.add_ambassador_service(internal_ambassador_service)
+        mgr = ResourceManager(logger, aconf, DependencyManager([service_dep]))
+
+        assert KnativeIngressProcessor(mgr).try_process(obj)
+
+        return aconf, {m.name: m for m in mgr.elements}
+
+    def test_ingress_class(self):
+        paths = tagged_knative_ingress.spec["rules"][0]["http"]["paths"]
+
+        for annotation in [
+            "networking.knative.dev/ingress-class",
+            "networking.knative.dev/ingress.class",
+        ]:
+            for ingress_class, wanted in [
+                ("ambassador.ingress.networking.knative.dev", True),
+                ("Ambassador.Ingress.Networking.Knative.Dev", True),
+                ("kourier.ingress.networking.knative.dev", False),
+            ]:
+                aconf, mappings = self.process(knative_ingress({annotation: ingress_class}, paths))
+
+                assert bool(mappings) == wanted, f"{annotation}: {ingress_class}"
+                assert bool(aconf.k8s_status_updates) == wanted, f"{annotation}: {ingress_class}"
+
+        # No annotation at all means any ingress class.
+        _, mappings = self.process(knative_ingress({}, paths))
+        assert mappings
+
+    def test_mappings(self):
+        _, mappings = self.process(tagged_knative_ingress)
+
+        # The 0% split on the tagged path is skipped; the untagged path keeps both.
+        assert sorted(mappings) == ["helloworld-go-0-0", "helloworld-go-0-1", "helloworld-go-0-2"]
+
+        tagged = mappings["helloworld-go-0-0"]
+        assert tagged.host == "helloworld-go.test.example.com"
+        assert tagged.prefix == "/"
+        assert tagged.service == "helloworld-go-00002.test:80"
+        assert tagged.weight == 100
+        assert tagged.headers == {"Knative-Serving-Tag": "latest"}
+        assert tagged.host_rewrite == "latest-helloworld-go.test.svc.cluster.local"
+
+        for name, revision, weight in [
+            ("helloworld-go-0-1", "helloworld-go-00001", 90),
+            ("helloworld-go-0-2", "helloworld-go-00002", 10),
+        ]:
+            mapping = mappings[name]
+            assert mapping.service == f"{revision}.test:80"
+            assert mapping.weight == weight
+            assert "headers" not in mapping
+            assert "host_rewrite" not in mapping
+
+    def test_unsupported_header_match(self):
+        obj = knative_ingress(
+            {},
+            [
+                {
+                    "headers": {
+                        "Knative-Serving-Tag": {"exact": "latest"},
+                        "X-Other": {"prefix": "foo"},
+                    },
+                    "splits": [{"serviceName": "helloworld-go-00002"}],
+                },
+            ],
+        )
+        _, mappings = self.process(obj)
+
+        assert mappings["helloworld-go-0-0"].headers == {"Knative-Serving-Tag": "latest"}
+
+    def test_only_split_at_zero_percent(self):
+        # A path's only split is kept even at 0%, rather than leaving the path with no route.
+        obj = knative_ingress(
+            {}, [{"splits": [{"percent": 0, "serviceName": "helloworld-go-00001"}]}]
+        )
+        _, mappings = self.process(obj)
+
+        assert list(mappings) == ["helloworld-go-0-0"]
+
+    def test_timeout(self):
+        # Without a timeout from Knative, there's no timeout at all, not the old 15s.
+        _, mappings = self.process(tagged_knative_ingress)
+        for mapping in mappings.values():
+            assert mapping.timeout_ms == 0
+
+        # Older Knatives still send one, and it's honored.
+        obj = knative_ingress(
+            {}, [{"timeout": "10m0s", "splits": [{"serviceName": "helloworld-go-00001"}]}]
+        )
+        _, mappings = self.process(obj)
+
+        assert mappings["helloworld-go-0-0"].timeout_ms == 600000
+
+    def test_status(self):
+        aconf, _ = self.process(tagged_knative_ingress)
+
+        domain, namespace, status = aconf.k8s_status_updates["helloworld-go.test"]
+        assert domain == tagged_knative_ingress.gvk.domain
+        assert namespace == "test"
+        assert status["observedGeneration"] == 1
+
+        lb = {"ingress": [{"domainInternal": "ambassador-internal.ambassador.svc.cluster.local"}]}
+        assert status["publicLoadBalancer"] == lb
+        assert status["privateLoadBalancer"] == lb
+        assert "loadBalancer" not in status
+
+    def test_status_in_sync(self):
+        lb = {"ingress": [{"domainInternal": "ambassador-internal.ambassador.svc.cluster.local"}]}
+        obj = KubernetesObject(
+            {
+                **tagged_knative_ingress,
+                "status": {
+                    "observedGeneration": 1,
+                    "publicLoadBalancer": lb,
+                    "privateLoadBalancer": lb,
+                },
+            }
+        )
+        aconf, _ = self.process(obj)
+
+        assert not aconf.k8s_status_updates
+
+        # An old status that only has the catch-all loadBalancer gets rewritten.
+        obj = KubernetesObject(
+            {**tagged_knative_ingress, "status": {"observedGeneration": 1, "loadBalancer": lb}}
+        )
+        aconf, _ = self.process(obj)
+
+        assert "helloworld-go.test" in aconf.k8s_status_updates
+
+
 if __name__ == "__main__":
     pytest.main(sys.argv)
@@ -0,0 +1,174 @@
+import base64
+
+import pytest
+
+from ambassador.ir.irgrpctranscoder import descriptor_services
+from tests.utils import compile_with_cachecheck, module_and_mapping_manifests
+
+TRANSCODER_FILTER = "envoy.filters.http.grpc_json_transcoder"
+
+
+def _field(number: int, payload: bytes) -> bytes:
+    # A length-delimited protobuf field; everything here is short enough for one-byte lengths.
+    assert len(payload) < 128
+    return bytes([(number << 3) | 2, len(payload)]) + payload
+
+
+def _descriptor_set(package: str, *services: str) -> bytes:
+    file_proto = _field(1, f"{package}.proto".encode()) + _field(2, package.encode())
+
+    for service in services:
+        file_proto += _field(6, _field(1, service.encode()))
+
+    return _field(1, file_proto)
+
+
+V1 = base64.b64encode(_descriptor_set("bookstore", "Bookstore")).decode()
+V2 = base64.b64encode(_descriptor_set("bookstore", "Bookstore", "Shelves")).decode()
+
+DESCRIPTORS = f"""
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: bookstore
+  namespace: default
+  labels:
+    getambassador.io/grpc-descriptors: "true"
+  annotations:
+    getambassador.io/grpc-descriptors-default: v1.pb
+binaryData:
+  v1.pb: {V1}
+  v2.pb: {V2}
+  broken.pb: {base64.b64encode(b"not a descriptor set").decode()}
+"""
+
+
+def _transcoding_routes(compiled):
+    routes = []
+    filters = []
+
+    for listener in compiled["xds"].as_dict()["static_resources"]["listeners"]:
+        for chain in listener["filter_chains"]:
+            for f in chain["filters"]:
+                if f["name"] != "envoy.filters.network.http_connection_manager":
+                    continue
+
+                hcm = f["typed_config"]
+                filters += [hf for hf in hcm["http_filters"] if hf["name"] == TRANSCODER_FILTER]
+
+                for vhost in hcm["route_config"]["virtual_hosts"]:
+                    for r in vhost["routes"]:
+                        if TRANSCODER_FILTER in r.get("typed_per_filter_config", {}):
+                            routes.append(r)
+
+    return routes, filters
+
+
+def _errors(compiled):
+    return [e["error"] for errs in compiled["ir"].aconf.errors.values() for e in errs]
+
+
+def test_descriptor_services():
+    assert descriptor_services(_descriptor_set("bookstore", "Bookstore", "Shelves")) == [
+        "bookstore.Bookstore",
+        "bookstore.Shelves",
+    ]
+    assert descriptor_services(_descriptor_set("", "Bare")) == ["Bare"]
+
+    for bad in [b"", b"\x0a\x10short", b"\x07"]:
+        with pytest.raises(ValueError):
+            descriptor_services(bad)
+
+
+@pytest.mark.compilertest
+def test_grpc_transcoder_versions():
+    yaml = (
+        module_and_mapping_manifests(
+            None,
+            [
+                "grpc: true",
+                "grpc_transcoder: {descriptors: bookstore, services: [bookstore.Bookstore]}",
+            ],
+        )
+        + DESCRIPTORS
+    )
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+
+    routes, filters = _transcoding_routes(compiled)
+    assert routes
+
+    for route in routes:
+        per_route = route["typed_per_filter_config"][TRANSCODER_FILTER]
+
+        # The default version, and no rewriting of the path that the transcoder sets.
+        assert per_route["proto_descriptor_bin"] == V1
+        assert per_route["services"] == ["bookstore.Bookstore"]
+        assert per_route["match_incoming_request_route"] is True
+        assert "prefix_rewrite" not in route["route"]
+
+    # The filter itself does nothing until a route turns it on.
+    assert filters
+    for f in filters:
+        assert f["typed_config"]["services"] == []
+
+    # The broken version is reported, and doesn't get in the way of the others.
+    assert any("broken.pb" in e for e in _errors(compiled)), _errors(compiled)
+
+
+@pytest.mark.compilertest
+def test_grpc_transcoder_pinned_version():
+    yaml = (
+        module_and_mapping_manifests(
+            None,
+            [
+                "grpc: true",
+                "grpc_transcoder: {descriptors: bookstore.default, version: v2.pb, services: [bookstore.Shelves]}",
+            ],
+        )
+        + DESCRIPTORS
+    )
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+
+    routes, _ = _transcoding_routes(compiled)
+    assert routes
+
+    for route in routes:
+        assert route["typed_per_filter_config"][TRANSCODER_FILTER]["proto_descriptor_bin"] == V2
+
+
+@pytest.mark.compilertest
+@pytest.mark.parametrize(
+    "transcoder",
+    [
+        "{descriptors: nonesuch, services: [bookstore.Bookstore]}",
+        "{descriptors: bookstore, version: v3.pb, services: [bookstore.Bookstore]}",
+        "{descriptors: bookstore, version: broken.pb, services: [bookstore.Bookstore]}",
+        "{descriptors: bookstore, services: [bookstore.Shelves]}",
+    ],
+)
+def test_grpc_transcoder_invalid(transcoder):
+    yaml = (
+        module_and_mapping_manifests(None, ["grpc: true", f"grpc_transcoder: {transcoder}"])
+        + DESCRIPTORS
+    )
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+
+    routes, filters = _transcoding_routes(compiled)
+    assert not routes
+    assert not filters
+
+    assert any("Invalid grpc_transcoder" in e for e in _errors(compiled)), _errors(compiled)
+
+
+@pytest.mark.compilertest
+def test_grpc_transcoder_needs_grpc():
+    yaml = (
+        module_and_mapping_manifests(
+            None, ["grpc_transcoder: {descriptors: bookstore, services: [bookstore.Bookstore]}"]
+        )
+        + DESCRIPTORS
+    )
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+
+    assert any("needs grpc: true" in e for e in _errors(compiled)), _errors(compiled)
@@ -0,0 +1,181 @@
+import pytest
+
+from tests.utils import compile_with_cachecheck, module_and_mapping_manifests
+
+HOSTS = """
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Host
+metadata:
+  name: busy-host
+  namespace: default
+spec:
+  hostname: busy.example.com
+  acmeProvider:
+    authority: none
+  requestPolicy:
+    insecure:
+      action: Route
+  telemetry:
+    accessLogSampling: 5
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Host
+metadata:
+  name: flaky-host
+  namespace: default
+spec:
+  hostname: "*.flaky.example.com"
+  acmeProvider:
+    authority: none
+  requestPolicy:
+    insecure:
+      action: Route
+  telemetry:
+    routeStats: true
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Host
+metadata:
+  name: plain-host
+  namespace: default
+spec:
+  hostname: plain.example.com
+  acmeProvider:
+    authority: none
+  requestPolicy:
+    insecure:
+      action: Route
+"""
+
+BROKEN_HOST = """
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Host
+metadata:
+  name: broken-host
+  namespace: default
+spec:
+  hostname: broken.example.com
+  acmeProvider:
+    authority: none
+  requestPolicy:
+    insecure:
+      action: Route
+  telemetry:
+    accessLogSampling: 250
+    routeStats: true
+"""
+
+
+def _http_configs(compiled):
+    for listener in compiled["xds"].as_dict()["static_resources"]["listeners"]:
+        for chain in listener["filter_chains"]:
+            for f in chain["filters"]:
+                if f["name"] == "envoy.filters.network.http_connection_manager":
+                    yield f["typed_config"]
+
+
+def _vhosts(compiled):
+    vhosts = {}
+
+    for http_config in _http_configs(compiled):
+        for vhost in http_config["route_config"]["virtual_hosts"]:
+            vhosts[vhost["domains"][0]] = vhost
+
+    return vhosts
+
+
+def _authority(regex, invert=False):
+    matcher = {
+        "name": ":authority",
+        "string_match": {"safe_regex": {"google_re2": {"max_program_size": 200}, "regex": regex}},
+    }
+
+    if invert:
+        matcher["invert_match"] = True
+
+    return {"header_filter": {"header": matcher}}
+
+
+@pytest.mark.compilertest
+def test_access_log_sampling():
+    yaml = module_and_mapping_manifests(None, []) + HOSTS
+    compiled = compile_with_cachecheck(yaml)
+
+    busy = r"(?i)^busy\.example\.com(:[0-9]+)?$"
+    runtime_key = "ambassador.access_log_sampling.busy-host.default"
+    expected = {
+        "or_filter": {
+            "filters": [
+                {
+                    "and_filter": {
+                        "filters": [
+                            _authority(busy),
+                            {
+                                "runtime_filter": {
+                                    "runtime_key": runtime_key,
+                                    "percent_sampled": {"numerator": 5, "denominator": "HUNDRED"},
+                                }
+                            },
+                        ]
+                    }
+                },
+                # Everything else is always logged.
+                _authority(busy, invert=True),
+            ]
+        }
+    }
+
+    http_configs = list(_http_configs(compiled))
+    assert http_configs
+
+    for http_config in http_configs:
+        assert http_config["access_log"]
+
+        for al in http_config["access_log"]:
+            assert al["filter"] == expected
+
+
+@pytest.mark.compilertest
+def test_route_stats():
+    yaml = module_and_mapping_manifests(None, []) + HOSTS
+    vhosts = _vhosts(compile_with_cachecheck(yaml))
+
+    flaky = vhosts["*.flaky.example.com"]["routes"]
+    assert any(r.get("stat_prefix") == "ambassador.default" for r in flaky), flaky
+
+    # Other Hosts share the same routes, but don't get stats for them.
+    for hostname in ["busy.example.com", "plain.example.com"]:
+        assert not any("stat_prefix" in r for r in vhosts[hostname]["routes"])
+
+
+@pytest.mark.compilertest
+def test_no_telemetry():
+    yaml = module_and_mapping_manifests(None, [])
+    compiled = compile_with_cachecheck(yaml)
+
+    for http_config in _http_configs(compiled):
+        for al in http_config["access_log"]:
+            assert "filter" not in al
+
+
+@pytest.mark.compilertest
+def test_bad_telemetry():
+    yaml = module_and_mapping_manifests(None, []) + BROKEN_HOST
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+
+    # The bad sampling is reported and ignored, and the rest still applies.
+    errors = compiled["ir"].aconf.errors
+    assert any(
+        "accessLogSampling 250 must be between 0 and 100" in e["error"]
+        for errs in errors.values()
+        for e in errs
+    ), errors
+
+    for http_config in _http_configs(compiled):
+        for al in http_config["access_log"]:
+            assert "filter" not in al
+
+    routes = _vhosts(compiled)["broken.example.com"]["routes"]
+    assert any(r.get("stat_prefix") == "ambassador.default" for r in routes), routes
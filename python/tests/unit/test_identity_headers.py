@@ -0,0 +1,133 @@
+import pytest
+
+from ambassador.ir.iridentity import identity_headers
+from tests.utils import compile_with_cachecheck, module_and_mapping_manifests
+
+HOSTS = """
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Host
+metadata:
+  name: identity-host
+  namespace: default
+spec:
+  hostname: identity.example.com
+  acmeProvider:
+    authority: none
+  requestPolicy:
+    insecure:
+      action: Route
+  identityHeaders:
+  - name: X-Client-SPIFFE-ID
+    clientCert: uriSAN
+  - name: x-user
+    jwtClaim: sub
+  - name: x-tenant
+    authMetadata: tenant.id
+  - name: x-broken
+    clientCert: uriSAN
+    jwtClaim: sub
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Host
+metadata:
+  name: plain-host
+  namespace: default
+spec:
+  hostname: plain.example.com
+  acmeProvider:
+    authority: none
+  requestPolicy:
+    insecure:
+      action: Route
+"""
+
+
+def _vhosts(compiled):
+    vhosts = {}
+
+    for listener in compiled["xds"].as_dict()["static_resources"]["listeners"]:
+        for chain in listener["filter_chains"]:
+            for f in chain["filters"]:
+                if f["name"] != "envoy.filters.network.http_connection_manager":
+                    continue
+
+                for vhost in f["typed_config"]["route_config"]["virtual_hosts"]:
+                    vhosts[vhost["domains"][0]] = vhost
+
+    return vhosts
+
+
+def test_identity_header_values():
+    headers, errors = identity_headers(
+        [
+            {"name": "X-Cert-Hash", "clientCert": "fingerprint"},
+            {"name": "x-user", "jwtClaim": "email"},
+            {"name": "x-org", "authMetadata": "org.name"},
+        ]
+    )
+
+    assert errors == []
+    assert headers == [
+        {"name": "x-cert-hash", "value": "%DOWNSTREAM_PEER_FINGERPRINT_256%"},
+        {
+            "name": "x-user",
+            "value": "%DYNAMIC_METADATA(envoy.filters.http.ext_authz:jwt_payload:email)%",
+        },
+        {"name": "x-org", "value": "%DYNAMIC_METADATA(envoy.filters.http.ext_authz:org:name)%"},
+    ]
+
+
+def test_identity_header_errors():
+    headers, errors = identity_headers(
+        [
+            {"name": "x-user", "jwtClaim": "sub"},
+            {"name": "X-User", "jwtClaim": "email"},
+            {"name": ":authority", "jwtClaim": "sub"},
+            {"name": "host", "jwtClaim": "sub"},
+            {"name": "x-none"},
+            {"name": "x-cert", "clientCert": "issuer"},
+            {"name": "x-meta", "authMetadata": "a..b"},
+        ]
+    )
+
+    assert [h["name"] for h in headers] == ["x-user"]
+    assert len(errors) == 6
+
+
+@pytest.mark.compilertest
+def test_identity_headers_on_vhost():
+    yaml = module_and_mapping_manifests(None, []) + HOSTS
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+    vhosts = _vhosts(compiled)
+
+    vhost = vhosts["identity.example.com"]
+    assert vhost["request_headers_to_remove"] == ["x-client-spiffe-id", "x-user", "x-tenant"]
+    assert vhost["request_headers_to_add"] == [
+        {
+            "header": {"key": "x-client-spiffe-id", "value": "%DOWNSTREAM_PEER_URI_SAN%"},
+            "append": False,
+        },
+        {
+            "header": {
+                "key": "x-user",
+                "value": "%DYNAMIC_METADATA(envoy.filters.http.ext_authz:jwt_payload:sub)%",
+            },
+            "append": False,
+        },
+        {
+            "header": {
+                "key": "x-tenant",
+                "value": "%DYNAMIC_METADATA(envoy.filters.http.ext_authz:tenant:id)%",
+            },
+            "append": False,
+        },
+    ]
+
+    # Other Hosts are left alone.
+    assert "request_headers_to_remove" not in vhosts["plain.example.com"]
+    assert "request_headers_to_add" not in vhosts["plain.example.com"]
+
+    # The broken header is reported, and skipped.
+    errors = compiled["ir"].aconf.errors
+    assert any("x-broken" in e["error"] for errs in errors.values() for e in errs), errors
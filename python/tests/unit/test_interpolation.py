@@ -0,0 +1,120 @@
+import pytest
+
+from ambassador.config.interpolation import (
+    interpolate,
+    interpolate_resource,
+    interpolation_variables,
+)
+from tests.utils import compile_with_cachecheck, module_and_mapping_manifests
+
+ENVIRON = {
+    "AMBASSADOR_INTERPOLATION_VARS": "CLUSTER_DOMAIN, UNSET",
+    "CLUSTER_DOMAIN": "prod.example.com",
+    "SECRET": "hunter2",
+}
+
+
+def test_interpolation_variables():
+    assert interpolation_variables(ENVIRON) == {
+        "CLUSTER_DOMAIN": "prod.example.com",
+        "UNSET": None,
+    }
+    assert interpolation_variables({"CLUSTER_DOMAIN": "prod.example.com"}) == {}
+
+
+@pytest.mark.parametrize(
+    "value, expected, error",
+    [
+        ("api.${CLUSTER_DOMAIN}", "api.prod.example.com", None),
+        ("$${CLUSTER_DOMAIN}", "${CLUSTER_DOMAIN}", None),
+        ("${CLUSTER_DOMAIN", "${CLUSTER_DOMAIN", None),
+        ("$CLUSTER_DOMAIN", "$CLUSTER_DOMAIN", None),
+        ("${SECRET}", "${SECRET}", "variable SECRET is not allowed"),
+        ("${UNSET}", "${UNSET}", "variable UNSET is not set"),
+        ("${cluster-domain}", "${cluster-domain}", "is not a valid variable reference"),
+    ],
+)
+def test_interpolate(value, expected, error):
+    errors = []
+    assert interpolate(value, interpolation_variables(ENVIRON), errors) == expected
+
+    if error:
+        assert len(errors) == 1
+        assert error in errors[0]
+    else:
+        assert errors == []
+
+
+def test_interpolate_resource():
+    variables = interpolation_variables(ENVIRON)
+    resource = {
+        "kind": "Mapping",
+        "name": "${CLUSTER_DOMAIN}",
+        "hostname": "api.${CLUSTER_DOMAIN}",
+        "add_request_headers": {"x-domain": {"value": "${CLUSTER_DOMAIN}"}},
+        "query_parameters": [{"name": "${CLUSTER_DOMAIN}"}],
+        "weight": 10,
+    }
+
+    assert interpolate_resource(resource, variables) == []
+    assert resource == {
+        "kind": "Mapping",
+        # Metadata isn't interpolated.
+        "name": "${CLUSTER_DOMAIN}",
+        "hostname": "api.prod.example.com",
+        "add_request_headers": {"x-domain": {"value": "prod.example.com"}},
+        "query_parameters": [{"name": "prod.example.com"}],
+        "weight": 10,
+    }
+
+    # A resource with any bad reference is left alone.
+    resource = {"hostname": "api.${CLUSTER_DOMAIN}", "prefix": "/${SECRET}/"}
+    assert len(interpolate_resource(resource, variables)) == 1
+    assert resource["hostname"] == "api.${CLUSTER_DOMAIN}"
+
+
+def _hostnames(compiled):
+    return {
+        m["name"]: m.get("host")
+        for group in compiled["ir"].groups.values()
+        for m in group.mappings
+    }
+
+
+@pytest.mark.compilertest
+def test_interpolated_mapping(monkeypatch):
+    for name, value in ENVIRON.items():
+        monkeypatch.setenv(name, value)
+
+    yaml = module_and_mapping_manifests(None, ['hostname: "api.${CLUSTER_DOMAIN}"'])
+    compiled = compile_with_cachecheck(yaml)
+
+    assert _hostnames(compiled)["ambassador"] == "api.prod.example.com"
+
+
+@pytest.mark.compilertest
+def test_unresolved_variable(monkeypatch):
+    for name, value in ENVIRON.items():
+        monkeypatch.setenv(name, value)
+
+    yaml = module_and_mapping_manifests(None, ['hostname: "api.${SECRET}"'])
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+
+    # The Mapping is rejected, and says why.
+    errors = compiled["ir"].aconf.errors
+    assert any(
+        "variable SECRET is not allowed" in e["error"] and e["code"] == "AMB1010"
+        for errs in errors.values()
+        for e in errs
+    ), errors
+    assert "ambassador" not in _hostnames(compiled)
+
+
+@pytest.mark.compilertest
+def test_no_interpolation_by_default(monkeypatch):
+    monkeypatch.delenv("AMBASSADOR_INTERPOLATION_VARS", raising=False)
+
+    yaml = module_and_mapping_manifests(None, ['hostname: "api.${CLUSTER_DOMAIN}"'])
+    compiled = compile_with_cachecheck(yaml)
+
+    assert _hostnames(compiled)["ambassador"] == "api.${CLUSTER_DOMAIN}"
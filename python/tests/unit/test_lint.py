@@ -0,0 +1,106 @@
+import pytest
+
+from tests.utils import compile_with_cachecheck
+
+unanchored_mapping = """
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+metadata:
+  name: unanchored
+  namespace: default
+spec:
+  hostname: "*"
+  prefix: /foo/[a-z]+
+  prefix_regex: true
+  service: foo
+"""
+
+
+def _module(lint: str) -> str:
+    return f"""
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Module
+metadata:
+  name: ambassador
+  namespace: default
+spec:
+  config:
+    lint:
+{lint}
+"""
+
+
+@pytest.mark.compilertest
+def test_lint_warns_by_default():
+    r = compile_with_cachecheck(unanchored_mapping, errors_ok=True)
+    aconf = r["ir"].aconf
+
+    assert not aconf.errors
+    notices = aconf.notices["unanchored.default.1"]
+    assert len(notices) == 1
+    assert notices[0].startswith("AMB3001 unanchored-regex: ")
+
+
+@pytest.mark.compilertest
+def test_lint_severity_error():
+    yaml = unanchored_mapping + _module("      unanchored-regex: error")
+    r = compile_with_cachecheck(yaml, errors_ok=True)
+    aconf = r["ir"].aconf
+
+    errors = aconf.errors["unanchored.default.1"]
+    assert len(errors) == 1
+    assert errors[0]["code"] == "AMB3001"
+    assert "unanchored.default.1" not in aconf.notices
+
+
+@pytest.mark.compilertest
+def test_lint_severity_ignore():
+    yaml = unanchored_mapping + _module("      unanchored-regex: ignore")
+    r = compile_with_cachecheck(yaml, errors_ok=True)
+    aconf = r["ir"].aconf
+
+    assert not aconf.errors
+    assert "unanchored.default.1" not in aconf.notices
+
+
+@pytest.mark.compilertest
+def test_lint_bad_config():
+    yaml = unanchored_mapping + _module("      no-such-rule: error\n      unanchored-regex: fatal")
+    r = compile_with_cachecheck(yaml, errors_ok=True)
+
+    errors = [err for errs in r["ir"].aconf.errors.values() for err in errs]
+    assert len(errors) == 2
+    assert all(err["code"] == "AMB2300" for err in errors)
+
+
+@pytest.mark.compilertest
+def test_lint_external_upstream():
+    yaml = """
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+metadata:
+  name: external
+  namespace: default
+spec:
+  hostname: "*"
+  prefix: /external/
+  service: https://api.example.com
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+metadata:
+  name: internal
+  namespace: default
+spec:
+  hostname: "*"
+  prefix: /internal/
+  service: backend.default.svc.cluster.local:8080
+"""
+    r = compile_with_cachecheck(yaml, errors_ok=True)
+    notices = r["ir"].aconf.notices
+
+    assert notices["external.default.1"][0].startswith("AMB3002 ")
+    assert "internal.default.1" not in notices
@@ -0,0 +1,78 @@
+import pytest
+
+from tests.utils import compile_with_cachecheck
+
+LISTENERS = """
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Listener
+metadata:
+  name: http-listener
+  namespace: default
+spec:
+  port: 80
+  protocol: HTTP
+  securityModel: XFP
+  hostBinding:
+    namespace:
+      from: ALL
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Listener
+metadata:
+  name: alternate-listener
+  namespace: default
+spec:
+  port: 8080
+  protocol: HTTP
+  securityModel: XFP
+  hostBinding:
+    namespace:
+      from: ALL
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Host
+metadata:
+  name: wildcard-host
+  namespace: default
+spec:
+  hostname: "*"
+  requestPolicy:
+    insecure:
+      action: Route
+"""
+
+
+def _ports(compiled):
+    listeners = compiled["xds"].as_dict()["static_resources"]["listeners"]
+
+    return sorted(l["address"]["socket_address"]["port_value"] for l in listeners)
+
+
+def _errors(compiled):
+    return [e["error"] for errs in compiled["ir"].aconf.errors.values() for e in errs]
+
+
+@pytest.mark.compilertest
+@pytest.mark.parametrize("start", [None, "0"])
+def test_listener_privileged_ports(monkeypatch, start):
+    if start is not None:
+        monkeypatch.setenv("AMBASSADOR_UNPRIVILEGED_PORT_START", start)
+
+    compiled = compile_with_cachecheck(LISTENERS)
+
+    assert not _errors(compiled)
+    assert _ports(compiled) == [80, 8080]
+
+
+@pytest.mark.compilertest
+def test_listener_privileged_ports_unbindable(monkeypatch):
+    monkeypatch.setenv("AMBASSADOR_UNPRIVILEGED_PORT_START", "1024")
+
+    compiled = compile_with_cachecheck(LISTENERS, errors_ok=True)
+    errors = _errors(compiled)
+
+    assert any(
+        ("port 80 is privileged" in e) and ("use a port of 1024 or above" in e) for e in errors
+    ), errors
+    assert _ports(compiled) == [8080]
@@ -0,0 +1,170 @@
+import pytest
+
+from tests.utils import compile_with_cachecheck
+
+LISTENERS = """
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Listener
+metadata:
+  name: tuned-listener
+  namespace: default
+spec:
+  port: 8080
+  protocol: HTTP
+  securityModel: XFP
+  hostBinding:
+    namespace:
+      from: ALL
+  reusePort: false
+  connectionBalance: exact
+  tcpBacklogSize: 4096
+  socketOptions:
+  - description: TCP_FASTOPEN
+    level: 6
+    name: 23
+    intValue: 1024
+    state: LISTENING
+  - level: 1
+    name: 25
+    bufValue: ZXRoMA==
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Listener
+metadata:
+  name: plain-listener
+  namespace: default
+spec:
+  port: 8443
+  protocol: HTTP
+  securityModel: XFP
+  hostBinding:
+    namespace:
+      from: ALL
+"""
+
+BROKEN = """
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Listener
+metadata:
+  name: broken-listener
+  namespace: default
+spec:
+  port: 8080
+  protocol: HTTP
+  securityModel: XFP
+  hostBinding:
+    namespace:
+      from: ALL
+  connectionBalance: random
+  tcpBacklogSize: 0
+  socketOptions:
+  - level: 6
+    name: 23
+  - level: 6
+    name: 23
+    intValue: 1
+    bufValue: AA==
+  - level: 6
+    name: 23
+    intValue: 1
+    state: EVENTUALLY
+  - level: 6
+    name: 13
+    intValue: 1
+"""
+
+HOST_AND_MAPPING = """
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Host
+metadata:
+  name: wildcard-host
+  namespace: default
+spec:
+  hostname: "*"
+  requestPolicy:
+    insecure:
+      action: Route
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+metadata:
+  name: quote-backend
+  namespace: default
+spec:
+  prefix: /backend/
+  service: quote
+  hostname: "*"
+"""
+
+
+def _listeners(compiled):
+    listeners = compiled["xds"].as_dict()["static_resources"]["listeners"]
+
+    return {l["address"]["socket_address"]["port_value"]: l for l in listeners}
+
+
+def _errors(compiled):
+    return [e["error"] for errs in compiled["ir"].aconf.errors.values() for e in errs]
+
+
+@pytest.mark.compilertest
+def test_listener_socket_tuning():
+    listeners = _listeners(compile_with_cachecheck(LISTENERS + HOST_AND_MAPPING))
+
+    tuned = listeners[8080]
+    assert tuned["enable_reuse_port"] is False
+    assert tuned["connection_balance_config"] == {"exact_balance": {}}
+    assert tuned["tcp_backlog_size"] == 4096
+    assert tuned["socket_options"] == [
+        {
+            "description": "TCP_FASTOPEN",
+            "level": 6,
+            "name": 23,
+            "int_value": 1024,
+            "state": "STATE_LISTENING",
+        },
+        {"level": 1, "name": 25, "buf_value": "ZXRoMA==", "state": "STATE_PREBIND"},
+    ]
+
+    # A Listener that doesn't tune anything leaves all of it to Envoy.
+    plain = listeners[8443]
+
+    for key in [
+        "enable_reuse_port",
+        "connection_balance_config",
+        "tcp_backlog_size",
+        "socket_options",
+    ]:
+        assert key not in plain
+
+
+@pytest.mark.compilertest
+def test_listener_socket_tuning_invalid():
+    compiled = compile_with_cachecheck(BROKEN + HOST_AND_MAPPING, errors_ok=True)
+    errors = _errors(compiled)
+
+    assert "Listener broken-listener: invalid connectionBalance random, ignoring" in errors
+    assert "Listener broken-listener: invalid tcpBacklogSize 0, ignoring" in errors
+    assert (
+        "Listener broken-listener: socketOptions[0] needs exactly one of intValue and bufValue, ignoring"
+        in errors
+    )
+    assert (
+        "Listener broken-listener: socketOptions[1] needs exactly one of intValue and bufValue, ignoring"
+        in errors
+    )
+    assert (
+        "Listener broken-listener: socketOptions[2] has invalid state EVENTUALLY, ignoring"
+        in errors
+    )
+
+    # The Listener itself is still there, with only the option that was OK.
+    listener = _listeners(compiled)[8080]
+    assert "connection_balance_config" not in listener
+    assert "tcp_backlog_size" not in listener
+    assert listener["socket_options"] == [
+        {"level": 6, "name": 13, "int_value": 1, "state": "STATE_PREBIND"}
+    ]
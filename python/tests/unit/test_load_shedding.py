@@ -0,0 +1,98 @@
+import pytest
+
+from tests.utils import compile_with_cachecheck, module_and_mapping_manifests
+
+
+def _http_managers(compiled):
+    return [
+        f["typed_config"]
+        for listener in compiled["xds"].as_dict()["static_resources"]["listeners"]
+        for chain in listener["filter_chains"]
+        for f in chain["filters"]
+        if f["name"] == "envoy.filters.network.http_connection_manager"
+    ]
+
+
+def _errors(compiled):
+    return [e["error"] for errs in compiled["ir"].aconf.errors.values() for e in errs]
+
+
+@pytest.mark.compilertest
+def test_load_shedding(monkeypatch):
+    monkeypatch.setenv("AMBASSADOR_CPU_PRESSURE_FILE", "/tmp/cpu_pressure")
+
+    yaml = module_and_mapping_manifests(
+        [
+            "load_shedding:",
+            "    max_heap_bytes: 1073741824",
+            "    cpu: true",
+            "    scaling_threshold: 0.8",
+            "    retry_after: 10",
+        ],
+        [],
+    )
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+    assert not _errors(compiled)
+
+    overload = compiled["xds"].as_dict()["bootstrap"]["overload_manager"]
+    assert [m["name"] for m in overload["resource_monitors"]] == [
+        "envoy.resource_monitors.fixed_heap",
+        "envoy.resource_monitors.injected_resource",
+    ]
+    assert overload["resource_monitors"][0]["typed_config"]["max_heap_size_bytes"] == 1073741824
+    assert overload["resource_monitors"][1]["typed_config"]["filename"] == "/tmp/cpu_pressure"
+
+    (action,) = overload["actions"]
+    assert action["name"] == "envoy.overload_actions.stop_accepting_requests"
+    assert [t["scaled"] for t in action["triggers"]] == [
+        {"scaling_threshold": 0.8, "saturation_threshold": 0.98}
+    ] * 2
+
+    managers = _http_managers(compiled)
+    assert managers
+
+    for hcm in managers:
+        (mapper,) = hcm["local_reply_config"]["mappers"]
+        assert mapper["filter"] == {"response_flag_filter": {"flags": ["OM"]}}
+        assert mapper["headers_to_add"] == [
+            {"header": {"key": "retry-after", "value": "10"}, "append": False}
+        ]
+
+
+@pytest.mark.compilertest
+def test_load_shedding_without_retry_after():
+    yaml = module_and_mapping_manifests(
+        ["load_shedding: {max_heap_bytes: 1073741824, retry_after: 0}"], []
+    )
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+    assert not _errors(compiled)
+
+    overload = compiled["xds"].as_dict()["bootstrap"]["overload_manager"]
+    assert [m["name"] for m in overload["resource_monitors"]] == [
+        "envoy.resource_monitors.fixed_heap"
+    ]
+
+    for hcm in _http_managers(compiled):
+        assert "local_reply_config" not in hcm
+
+
+@pytest.mark.compilertest
+@pytest.mark.parametrize(
+    "load_shedding, error",
+    [
+        ("true", "must be an object"),
+        ("{retry_after: 5}", "needs max_heap_bytes, or cpu: true, or both"),
+        ("{max_heap_bytes: -1}", "must be a positive integer"),
+        ("{cpu: 'yes'}", "must be true or false"),
+        ("{cpu: true, scaling_threshold: 2}", "must be a number between 0 and 1"),
+        ("{cpu: true, scaling_threshold: 0.99}", "must be less than saturation_threshold"),
+        ("{cpu: true, retry_after: -5}", "must be a non-negative integer"),
+    ],
+)
+def test_load_shedding_invalid(load_shedding, error):
+    yaml = module_and_mapping_manifests([f"load_shedding: {load_shedding}"], [])
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+    errors = _errors(compiled)
+
+    assert any((error in e) and ("ignoring load_shedding" in e) for e in errors), errors
+    assert "overload_manager" not in compiled["xds"].as_dict()["bootstrap"]
@@ -0,0 +1,206 @@
+import pytest
+
+from tests.utils import compile_with_cachecheck, module_and_mapping_manifests
+
+BIG_PAGE = "<p>" + "Back soon. " * 1000 + "</p>"
+
+PAGES = f"""
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: maintenance-pages
+  namespace: default
+  labels:
+    getambassador.io/maintenance-page: "true"
+data:
+  storefront.html: "<h1>Back soon</h1>"
+  big.html: "{BIG_PAGE}"
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: only-page
+  namespace: default
+  labels:
+    getambassador.io/maintenance-page: "true"
+data:
+  down.json: '{{"status": "down"}}'
+"""
+
+HOST = """
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Host
+metadata:
+  name: maintenance-host
+  namespace: default
+spec:
+  hostname: down.example.com
+  acmeProvider:
+    authority: none
+  requestPolicy:
+    insecure:
+      action: Route
+  maintenance:
+    enabled: true
+    status_code: 503
+    body:
+      config_map: only-page
+      content_type: application/json
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Host
+metadata:
+  name: plain-host
+  namespace: default
+spec:
+  hostname: up.example.com
+  acmeProvider:
+    authority: none
+  requestPolicy:
+    insecure:
+      action: Route
+"""
+
+
+def _http_configs(compiled):
+    for listener in compiled["xds"].as_dict()["static_resources"]["listeners"]:
+        for chain in listener["filter_chains"]:
+            for f in chain["filters"]:
+                if f["name"] == "envoy.filters.network.http_connection_manager":
+                    yield f["typed_config"]
+
+
+def _routes(compiled, prefix):
+    routes = {}
+
+    for http_config in _http_configs(compiled):
+        for vhost in http_config["route_config"]["virtual_hosts"]:
+            for r in vhost["routes"]:
+                if r["match"].get("prefix", None) == prefix:
+                    routes.setdefault(vhost["domains"][0], []).append(r)
+
+    return routes
+
+
+def _headers(route):
+    return {h["header"]["key"]: h["header"]["value"] for h in route["response_headers_to_add"]}
+
+
+def _errors(compiled):
+    return [e["error"] for errs in compiled["ir"].aconf.errors.values() for e in errs]
+
+
+@pytest.mark.compilertest
+def test_mapping_maintenance():
+    yaml = (
+        module_and_mapping_manifests(
+            None,
+            [
+                "maintenance: {enabled: true, status_code: 503, retry_after: 300, "
+                "body: {config_map: maintenance-pages, key: storefront.html}}",
+            ],
+        )
+        + PAGES
+    )
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+    assert not _errors(compiled)
+
+    routes = [r for rs in _routes(compiled, "/httpbin/").values() for r in rs]
+    assert routes
+
+    for route in routes:
+        assert "route" not in route
+        assert route["direct_response"] == {
+            "status": 503,
+            "body": {"inline_string": "<h1>Back soon</h1>"},
+        }
+        assert _headers(route) == {"content-type": "text/html", "retry-after": "300"}
+
+
+@pytest.mark.compilertest
+def test_mapping_maintenance_off():
+    yaml = (
+        module_and_mapping_manifests(
+            None, ["maintenance: {enabled: false, body: {config_map: nonesuch}}"]
+        )
+        + PAGES
+    )
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+
+    # Out of maintenance, the page isn't needed, so a missing one isn't an error.
+    assert not _errors(compiled)
+
+    routes = [r for rs in _routes(compiled, "/httpbin/").values() for r in rs]
+    assert routes
+
+    for route in routes:
+        assert "direct_response" not in route
+        assert route["route"]["cluster"]
+
+
+@pytest.mark.compilertest
+def test_mapping_maintenance_big_page():
+    yaml = (
+        module_and_mapping_manifests(
+            None,
+            ["maintenance: {enabled: true, body: {config_map: maintenance-pages, key: big.html}}"],
+        )
+        + PAGES
+    )
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+    assert not _errors(compiled)
+
+    http_configs = list(_http_configs(compiled))
+    assert http_configs
+
+    for http_config in http_configs:
+        assert http_config["route_config"]["max_direct_response_body_size_bytes"] == len(
+            BIG_PAGE
+        )
+
+
+@pytest.mark.compilertest
+def test_host_maintenance():
+    yaml = module_and_mapping_manifests(None, []) + PAGES + HOST
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+    assert not _errors(compiled)
+
+    routes = _routes(compiled, "/httpbin/")
+
+    # The Host in maintenance answers for the Mapping; the other one still routes.
+    assert routes["down.example.com"]
+    for route in routes["down.example.com"]:
+        assert route["direct_response"] == {
+            "status": 503,
+            "body": {"inline_string": '{"status": "down"}'},
+        }
+        assert _headers(route) == {"content-type": "application/json"}
+
+    assert routes["up.example.com"]
+    for route in routes["up.example.com"]:
+        assert "direct_response" not in route
+        assert route["route"]["cluster"]
+
+
+@pytest.mark.compilertest
+@pytest.mark.parametrize(
+    "maintenance",
+    [
+        "true",
+        "{enabled: true, status_code: 99}",
+        "{enabled: true, retry_after: -1}",
+        "{enabled: true, body: {config_map: nonesuch}}",
+        "{enabled: true, body: {config_map: maintenance-pages}}",
+        "{enabled: true, body: {config_map: maintenance-pages, key: nonesuch.html}}",
+    ],
+)
+def test_mapping_maintenance_invalid(maintenance):
+    yaml = module_and_mapping_manifests(None, [f"maintenance: {maintenance}"]) + PAGES
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+
+    assert any("Invalid maintenance specified" in e for e in _errors(compiled)), _errors(
+        compiled
+    )
+    assert not _routes(compiled, "/httpbin/")
@@ -31,6 +31,7 @@ spec:
 
     assert errors[0]["ok"] == False
     assert errors[0]["error"] == "host exact-match * contains *, which cannot match anything."
+    assert errors[0]["code"] == "AMB2000"
 
     for g in ir.groups.values():
         assert g.prefix != "/star/"
@@ -67,6 +68,7 @@ spec:
     assert (
         errors[0]["error"] == ":authority exact-match '*' contains *, which cannot match anything."
     )
+    assert errors[0]["code"] == "AMB2000"
 
     for g in ir.groups.values():
         assert g.prefix != "/star/"
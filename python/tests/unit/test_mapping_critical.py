@@ -0,0 +1,33 @@
+import pytest
+
+from tests.utils import compile_with_cachecheck, module_and_mapping_manifests
+
+
+def _errors(compiled):
+    return [e for errs in compiled["ir"].aconf.errors.values() for e in errs]
+
+
+@pytest.mark.compilertest
+@pytest.mark.parametrize("critical, expected", [(None, True), ("true", True), ("false", False)])
+def test_mapping_critical(critical, expected):
+    mapping = ["load_balancer: {policy: nonesuch}"]
+
+    if critical is not None:
+        mapping.append(f"critical: {critical}")
+
+    compiled = compile_with_cachecheck(module_and_mapping_manifests(None, mapping), errors_ok=True)
+    errors = [e for e in _errors(compiled) if "Invalid load_balancer specified" in e["error"]]
+
+    assert errors, _errors(compiled)
+
+    for e in errors:
+        assert e.get("critical", True) is expected
+
+
+@pytest.mark.compilertest
+def test_mapping_critical_invalid():
+    yaml = module_and_mapping_manifests(None, ["critical: 'no'"])
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+    errors = [e["error"] for e in _errors(compiled)]
+
+    assert any("Invalid critical specified: 'no'" in e for e in errors), errors
@@ -0,0 +1,147 @@
+import pytest
+
+from tests.utils import compile_with_cachecheck, default_listener_manifests, econf_compile
+
+
+def _mapping(name, service, *extra):
+    yaml = f"""
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+metadata:
+  name: {name}
+  namespace: default
+spec:
+  hostname: "*"
+  prefix: /httpbin/
+  service: {service}"""
+
+    for line in extra:
+        yaml += f"\n  {line}"
+
+    return yaml + "\n"
+
+
+DARK_LAUNCH = _mapping(
+    "httpbin-v2", "httpbin-v2", "headers: {x-beta: 'true'}", "weight: 10", "fallback: true"
+)
+
+
+def _httpbin_routes(econf, with_headers):
+    routes = []
+
+    for listener in econf["static_resources"]["listeners"]:
+        for chain in listener["filter_chains"]:
+            for f in chain["filters"]:
+                if f["name"] != "envoy.filters.network.http_connection_manager":
+                    continue
+
+                for vhost in f["typed_config"]["route_config"]["virtual_hosts"]:
+                    for r in vhost["routes"]:
+                        if ("route" not in r) or (r["match"].get("prefix") != "/httpbin/"):
+                            continue
+
+                        if bool(r["match"].get("headers")) == with_headers:
+                            routes.append(r)
+
+    assert routes
+    return routes
+
+
+@pytest.mark.compilertest
+def test_fallback_weighted_clusters():
+    yaml = default_listener_manifests() + _mapping("httpbin", "httpbin") + DARK_LAUNCH
+    econf = econf_compile(yaml)
+
+    for r in _httpbin_routes(econf, with_headers=True):
+        assert "runtime_fraction" not in r["match"]
+        assert "cluster" not in r["route"]
+        assert r["route"]["weighted_clusters"] == {
+            "clusters": [
+                {"name": "cluster_httpbin_v2_default", "weight": 1000},
+                {"name": "cluster_httpbin_default", "weight": 9000},
+            ]
+        }
+
+    # The Mapping fallen back to keeps its own route, too.
+    for r in _httpbin_routes(econf, with_headers=False):
+        assert r["route"]["cluster"] == "cluster_httpbin_default"
+
+
+@pytest.mark.compilertest
+def test_fallback_through_canary():
+    yaml = (
+        default_listener_manifests()
+        + _mapping("httpbin", "httpbin")
+        + _mapping("httpbin-canary", "httpbin-canary", "weight: 20")
+        + DARK_LAUNCH
+    )
+    econf = econf_compile(yaml)
+
+    for r in _httpbin_routes(econf, with_headers=True):
+        assert r["route"]["weighted_clusters"] == {
+            "clusters": [
+                {"name": "cluster_httpbin_v2_default", "weight": 1000},
+                {"name": "cluster_httpbin_canary_default", "weight": 1800},
+                {"name": "cluster_httpbin_default", "weight": 7200},
+            ]
+        }
+
+
+@pytest.mark.compilertest
+def test_fallback_separate_routes():
+    # The Mappings disagree on the rewrite, which weighted clusters can't do, so each gets a
+    # route of its own with its cumulative share.
+    yaml = (
+        default_listener_manifests()
+        + _mapping("httpbin", "httpbin", "rewrite: /v1/")
+        + DARK_LAUNCH
+    )
+    econf = econf_compile(yaml)
+
+    routes = _httpbin_routes(econf, with_headers=True)
+    fractions = [
+        (r["route"]["cluster"], r["match"]["runtime_fraction"]["default_value"]) for r in routes
+    ]
+
+    assert fractions == [
+        ("cluster_httpbin_v2_default", {"numerator": 1000, "denominator": "TEN_THOUSAND"}),
+        ("cluster_httpbin_default", {"numerator": 10000, "denominator": "TEN_THOUSAND"}),
+    ]
+    assert routes[1]["route"]["prefix_rewrite"] == "/v1/"
+
+
+@pytest.mark.compilertest
+def test_fallback_with_nothing_to_fall_back_to():
+    yaml = default_listener_manifests() + DARK_LAUNCH
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+
+    errors = compiled["ir"].aconf.errors
+    assert any(
+        "no other Mapping gets its requests" in e["error"] for errs in errors.values() for e in errs
+    ), errors
+
+    econf = compiled["xds"].as_dict()
+
+    for r in _httpbin_routes(econf, with_headers=True):
+        assert r["route"]["weighted_clusters"] == {
+            "clusters": [{"name": "cluster_httpbin_v2_default", "weight": 10000}]
+        }
+
+
+@pytest.mark.compilertest
+def test_no_fallback_unchanged():
+    # Without fallback, a lone weighted Mapping still gets all of its group's requests.
+    yaml = (
+        default_listener_manifests()
+        + _mapping("httpbin", "httpbin")
+        + _mapping("httpbin-v2", "httpbin-v2", "headers: {x-beta: 'true'}", "weight: 10")
+    )
+    econf = econf_compile(yaml)
+
+    for r in _httpbin_routes(econf, with_headers=True):
+        assert r["route"]["cluster"] == "cluster_httpbin_v2_default"
+        assert r["match"]["runtime_fraction"]["default_value"] == {
+            "numerator": 100,
+            "denominator": "HUNDRED",
+        }
@@ -0,0 +1,134 @@
+import pytest
+
+from tests.utils import compile_with_cachecheck, module_and_mapping_manifests
+
+RESPONSE_MAP = "envoy.filters.http.response_map"
+
+
+def _routes(compiled, prefix="/httpbin/"):
+    return [
+        r
+        for listener in compiled["xds"].as_dict()["static_resources"]["listeners"]
+        for chain in listener["filter_chains"]
+        for f in chain["filters"]
+        if f["name"] == "envoy.filters.network.http_connection_manager"
+        for vhost in f["typed_config"]["route_config"]["virtual_hosts"]
+        for r in vhost["routes"]
+        if r["match"].get("prefix", None) == prefix
+    ]
+
+
+def _headers(route):
+    return {h["header"]["key"]: h["header"]["value"] for h in route["response_headers_to_add"]}
+
+
+def _errors(compiled):
+    return [e["error"] for errs in compiled["ir"].aconf.errors.values() for e in errs]
+
+
+@pytest.mark.compilertest
+def test_mapping_static_response():
+    yaml = module_and_mapping_manifests(
+        None,
+        [
+            "static_response:",
+            '  body: "User-agent: *\\nDisallow: /\\n"',
+            "  headers: {Cache-Control: max-age=86400, X-Discount: 100%}",
+        ],
+    )
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+    assert not _errors(compiled)
+
+    routes = _routes(compiled)
+    assert routes
+
+    for route in routes:
+        assert "route" not in route
+        assert route["direct_response"] == {
+            "status": 200,
+            "body": {"inline_string": "User-agent: *\nDisallow: /\n"},
+        }
+        # Without templated, a % is just a %.
+        assert _headers(route) == {
+            "content-type": "text/plain",
+            "cache-control": "max-age=86400",
+            "x-discount": "100%%",
+        }
+        assert RESPONSE_MAP not in route.get("typed_per_filter_config", {})
+
+
+@pytest.mark.compilertest
+def test_mapping_static_response_templated():
+    yaml = module_and_mapping_manifests(
+        None,
+        [
+            "static_response:",
+            "  status_code: 301",
+            "  headers: {location: 'https://www.example.com%REQ(:path)%'}",
+            "  body: 'Moved from %DOWNSTREAM_REMOTE_ADDRESS_WITHOUT_PORT%'",
+            "  templated: true",
+        ],
+    )
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+    assert not _errors(compiled)
+
+    routes = _routes(compiled)
+    assert routes
+
+    for route in routes:
+        assert route["direct_response"]["status"] == 301
+        assert _headers(route)["location"] == "https://www.example.com%REQ(:path)%"
+
+        mappers = route["typed_per_filter_config"][RESPONSE_MAP]["response_map"]["mappers"]
+        assert len(mappers) == 1
+        assert mappers[0]["filter"]["status_code_filter"]["comparison"]["value"][
+            "default_value"
+        ] == "301"
+        assert mappers[0]["body_format_override"] == {
+            "text_format": "Moved from %DOWNSTREAM_REMOTE_ADDRESS_WITHOUT_PORT%",
+            "content_type": "text/plain",
+        }
+
+
+@pytest.mark.compilertest
+def test_mapping_static_response_bypasses_error_response_overrides():
+    yaml = module_and_mapping_manifests(
+        [
+            "error_response_overrides:",
+            "    - on_status_code: 404",
+            "      body: {text_format: 'nope'}",
+        ],
+        ["static_response: {status_code: 404, body: 'not here'}"],
+    )
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+    assert not _errors(compiled)
+
+    routes = _routes(compiled)
+    assert routes
+
+    for route in routes:
+        assert route["typed_per_filter_config"][RESPONSE_MAP]["disabled"] is True
+
+
+@pytest.mark.compilertest
+@pytest.mark.parametrize(
+    "static_response, error",
+    [
+        ("true", "must be an object"),
+        ("{status_code: 100}", "must be between 200 and 599"),
+        ("{body: [1]}", "body must be a string"),
+        (f"{{body: '{'x' * 65537}'}}", "bigger than 65536 bytes"),
+        ("{headers: {'bad header': x}}", "is not valid"),
+        ("{headers: {host: example.com}}", "can't set the host header"),
+        ("{body: '%NONESUCH%', templated: true}", "unsupported command operator NONESUCH"),
+    ],
+)
+def test_mapping_static_response_invalid(static_response, error):
+    yaml = module_and_mapping_manifests(None, [f"static_response: {static_response}"])
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+    errors = _errors(compiled)
+
+    assert any(
+        ("Invalid static_response specified" in e) and (error in e) for e in errors
+    ), errors
+    assert not _routes(compiled)
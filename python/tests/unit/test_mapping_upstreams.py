@@ -0,0 +1,107 @@
+import pytest
+
+from tests.utils import compile_with_cachecheck, econf_compile, module_and_mapping_manifests
+
+UPSTREAMS_METADATA_KEY = "getambassador.io/upstreams"
+
+CONSUL_RESOLVER = """
+---
+apiVersion: getambassador.io/v3alpha1
+kind: ConsulResolver
+metadata:
+  name: consul-dc1
+  namespace: default
+spec:
+  address: consul-server.default.svc.cluster.local:8500
+  datacenter: dc1
+"""
+
+
+def _upstreams_clusters(econf):
+    return [
+        cluster
+        for cluster in econf["static_resources"]["clusters"]
+        if UPSTREAMS_METADATA_KEY in cluster.get("metadata", {}).get("filter_metadata", {})
+    ]
+
+
+def _errors(yaml):
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+    errors = compiled["ir"].aconf.errors
+    return [e["error"] for errs in errors.values() for e in errs]
+
+
+@pytest.mark.compilertest
+def test_mapping_upstreams():
+    yaml = (
+        module_and_mapping_manifests(
+            None,
+            [
+                "upstreams:",
+                "  - {service: 'httpbin:8080', resolver: endpoint, weight: 90}",
+                "  - {service: httpbin, resolver: consul-dc1, weight: 10}",
+                "  - {service: '10.0.0.1', priority: 5}",
+            ],
+        )
+        + CONSUL_RESOLVER
+    )
+    econf = econf_compile(yaml)
+
+    clusters = _upstreams_clusters(econf)
+    assert len(clusters) == 1
+    cluster = clusters[0]
+
+    # The Mapping's service uses the kubernetes-service resolver, but the cluster's endpoints
+    # come from its upstreams, through ambex.
+    assert cluster["type"] == "EDS"
+    assert cluster["eds_cluster_config"]["service_name"] == "upstreams/" + cluster["name"]
+    assert cluster["common_lb_config"] == {"locality_weighted_lb_config": {}}
+    assert "load_assignment" not in cluster
+    assert cluster["alt_stat_name"] == "httpbin"
+
+    assert cluster["metadata"]["filter_metadata"][UPSTREAMS_METADATA_KEY] == {
+        "upstreams": [
+            {"endpoint_path": "k8s/default/httpbin/8080", "weight": 90, "priority": 0},
+            {"endpoint_path": "consul/dc1/httpbin", "weight": 10, "priority": 0},
+            {"address": "10.0.0.1", "port": 80, "weight": 1, "priority": 5},
+        ]
+    }
+
+
+@pytest.mark.compilertest
+def test_mapping_without_upstreams():
+    econf = econf_compile(module_and_mapping_manifests(None, []))
+    assert not _upstreams_clusters(econf)
+
+
+@pytest.mark.compilertest
+@pytest.mark.parametrize(
+    "upstreams, error",
+    [
+        ("[]", "at least one upstream"),
+        ("[{service: 'http://httpbin'}]", "can't have a scheme"),
+        ("[{service: httpbin}]", "KubernetesServiceResolver kubernetes-service has no endpoints"),
+        ("[{service: httpbin, resolver: nope}]", "resolver nope does not exist"),
+        ("[{service: httpbin, resolver: endpoint, weight: 0}]", "weight 0 is not an integer"),
+        ("[{service: '10.0.0.1', priority: 128}]", "priority 128 is not an integer"),
+    ],
+)
+def test_mapping_upstreams_invalid(upstreams, error):
+    yaml = module_and_mapping_manifests(None, [f"upstreams: {upstreams}"])
+    errors = _errors(yaml)
+
+    assert any(("Invalid upstreams specified" in e) and (error in e) for e in errors), errors
+
+
+@pytest.mark.compilertest
+def test_mapping_upstreams_with_failover():
+    yaml = module_and_mapping_manifests(
+        None,
+        [
+            "upstreams: [{service: '10.0.0.1'}]",
+            "failover: {services: [httpbin-west]}",
+        ],
+    )
+    errors = _errors(yaml)
+
+    assert any("upstreams can't be used with failover" in e for e in errors), errors
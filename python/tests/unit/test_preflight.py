@@ -0,0 +1,97 @@
+import concurrent.futures
+import logging
+import socket
+
+import pytest
+
+from ambassador.ir.irpreflight import PreflightChecker, PreflightTarget, run_preflight, target_for
+
+logger = logging.getLogger("ambassador")
+
+
+# SyncExecutor runs each check as it's submitted, so there's nothing to wait for.
+class SyncExecutor(concurrent.futures.Executor):
+    def submit(self, fn, *args, **kwargs):
+        future: concurrent.futures.Future = concurrent.futures.Future()
+        future.set_result(fn(*args, **kwargs))
+        return future
+
+
+@pytest.mark.compilertest
+def test_target_for():
+    for service, namespace, tls, resolver, wanted in [
+        ("quote", "default", False, "", PreflightTarget("quote.default", 80, False)),
+        ("quote:8080", "default", False, "", PreflightTarget("quote.default", 8080, False)),
+        ("quote.other", "default", False, "", PreflightTarget("quote.other", 80, False)),
+        ("https://quote", "default", False, "", PreflightTarget("quote.default", 443, True)),
+        (
+            "http://quote:9000/path",
+            "default",
+            True,
+            "",
+            PreflightTarget("quote.default", 9000, True),
+        ),
+        ("10.0.0.1:8080", "default", False, "", PreflightTarget("10.0.0.1", 8080, False)),
+        ("[::1]:8443", "default", True, "", PreflightTarget("::1", 8443, True)),
+        (
+            "quote",
+            "default",
+            False,
+            "kubernetes-endpoint",
+            PreflightTarget("quote.default", 80, False),
+        ),
+        ("quote", "default", False, "consul-dc1", None),
+        ("quote:notaport", "default", False, "", None),
+        ("quote:70000", "default", False, "", None),
+        ("", "default", False, "", None),
+    ]:
+        assert target_for(service, namespace, tls=tls, resolver=resolver) == wanted, service
+
+
+@pytest.mark.compilertest
+def test_run_preflight():
+    with socket.socket(socket.AF_INET, socket.SOCK_STREAM) as listener:
+        listener.bind(("127.0.0.1", 0))
+        listener.listen(1)
+        port = listener.getsockname()[1]
+
+        assert run_preflight(PreflightTarget("127.0.0.1", port, False), 1.0) is None
+
+    # The listener is closed now, so nothing should be there.
+    result = run_preflight(PreflightTarget("127.0.0.1", port, False), 1.0)
+    assert result and result.startswith(f"could not connect to 127.0.0.1:{port}")
+
+    result = run_preflight(PreflightTarget("nonexistent.invalid", 80, False), 1.0)
+    assert result and result.startswith("nonexistent.invalid does not resolve")
+
+
+@pytest.mark.compilertest
+def test_preflight_checker():
+    posted = []
+    checker = PreflightChecker(
+        logger,
+        timeout=1.0,
+        on_result=lambda name, namespace, warning: posted.append((name, namespace, warning)),
+        executor=SyncExecutor(),
+    )
+
+    bad = PreflightTarget("nonexistent.invalid", 80, False)
+
+    # The first look starts the check; the result is there for the next one.
+    assert checker.warning("quote", "default", bad) is None
+    assert len(posted) == 1
+    assert posted[0][:2] == ("quote", "default")
+    assert posted[0][2].startswith("pre-flight check of http://nonexistent.invalid:80 failed")
+
+    assert checker.warning("quote", "default", bad) == posted[0][2]
+    assert len(posted) == 1
+
+    # A new service is checked again, and the old result forgotten.
+    worse = PreflightTarget("other.invalid", 80, False)
+    assert checker.warning("quote", "default", worse) is None
+    assert len(posted) == 2
+    assert list(checker.results.keys()) == [("quote", "default", worse)]
+
+    # A Mapping that goes away is forgotten.
+    checker.retain([("other", "default")])
+    assert not checker.results
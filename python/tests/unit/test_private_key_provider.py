@@ -0,0 +1,86 @@
+import pytest
+
+from tests.utils import compile_with_cachecheck, default_listener_manifests
+
+# A Secret with a certificate and no private key: the key is in the KMS.
+MANIFESTS = """
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: kms-cert
+  namespace: default
+type: Opaque
+data:
+  tls.crt: LS0tLS1CRUdJTiBDRVJUSUZJQ0FURS0tLS0tCk1JSUNzRENDQVpnQ0NRRFd2TnRjRzNpelZEQU5CZ2txaGtpRzl3MEJBUXNGQURBYU1SZ3dGZ1lEVlFRRERBOWgKYldKaGMzTmhaRzl5TFdObGNuUXdIaGNOTWpFd056QTRNakF5T0RNd1doY05Nakl3TnpBNE1qQXlPRE13V2pBYQpNUmd3RmdZRFZRUUREQTloYldKaGMzTmhaRzl5TFdObGNuUXdnZ0VpTUEwR0NTcUdTSWIzRFFFQkFRVUFBNElCCkR3QXdnZ0VLQW9JQkFRQ1pVbXhqT1lrTWlKRm0yZSttZDlMelNwd0oxSWlic1lUWHp5a1NiMExZYlNqcG5jMGoKV0dWMEppOXdlU3FSSFFPMHM4NUZreENzT2s1K2ZCWDFJOTYra1Z2V3NyeWgwcDlsdjI3ZUpHZFp1Q1ZsSmR3cApuYnBaWFF6R3JjWVVaeTA2WEVWOGxkaFdOSVhMazc1bmxsWmE5M2xjajRXRzNTRHpzT2MrdEtWaEtNaG9QSkVaClVGbXNxZ080dm8yZkJxYk0zNXhBT3lFSHhodXgvVlNLeVIxbHN0S0dsd25icGliZDc2UUZCdWYwbHN2bEJRTFAKV2xiRW8zZzI0NWxMNFhMWjg2UURoaTJseTdSNFN5em4yZ2E2TjZYQWNxMjFYTzNQUzhPaFp6d2J1cGpEMHRadApxL0JjY01kTElXbm9zVmlpc0FVdElLUHpCbjVkNFhBaGRtVnhBZ01CQUFFd0RRWUpLb1pJaHZjTkFRRUxCUUFECmdnRUJBSmFONUVxcTlqMi9IVnJWWk9wT3BuWVRSZlU0OU1pNDlvbkF1ZjlmQk9tR3dlMHBWSmpqTVlyQW9kZ1IKYWVyUHVWUlBEWGRzZXczejJkMjliRzBMVTJTdEpBMEY0Z05vWTY0bGVZUTN0RjFDUmxsczdKaWVWelN1RVVyUwpLZjZiaWJ0aUlLSU4waEdTV3R2YU04ZXhqb2Y3ZGUyeWFLNEVPeE1pQmJyZkFPNnJ6MXgzc1ovOENGTnp3OXNRClhCNWpZSWhNZWhsb2xhR0U5RGNydUdrbStFQ3ZCNjZkajFNcm5UamVJcWc4QnN4Wm5WYlZ4cDlUZTJRZ2hyTmkKckVySndjV1NSU3lUZzBEZXdUektYQUx2aW5iRTliZ3pNdFhNSEhkUmZQYUMvWmFCTUd1QXExeWJTOUV3M2MvWgo1dk00aFdOaHU5MS9DSmN5UVJHdlJRWXFiZTA9Ci0tLS0tRU5EIENFUlRJRklDQVRFLS0tLS0K
+---
+apiVersion: getambassador.io/v3alpha1
+kind: TLSContext
+metadata:
+  name: kms-context
+  namespace: default
+spec:
+  hosts:
+  - kms.example.com
+  secret: kms-cert
+  private_key_provider:
+    provider_name: kms
+    typed_config:
+      "@type": type.googleapis.com/example.kms.v1.KeyConfig
+      key_uri: projects/p/locations/global/keyRings/r/cryptoKeys/k
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Host
+metadata:
+  name: kms-host
+  namespace: default
+spec:
+  hostname: kms.example.com
+  acmeProvider:
+    authority: none
+  tlsSecret:
+    name: kms-cert
+  tlsContext:
+    name: kms-context
+"""
+
+
+@pytest.mark.compilertest
+def test_private_key_provider():
+    compiled = compile_with_cachecheck(default_listener_manifests() + MANIFESTS)
+
+    certs = []
+
+    for listener in compiled["xds"].as_dict()["static_resources"]["listeners"]:
+        for chain in listener["filter_chains"]:
+            if "kms.example.com" not in chain["filter_chain_match"].get("server_names", []):
+                continue
+
+            typed_config = chain["transport_socket"]["typed_config"]
+            certs.extend(typed_config["common_tls_context"]["tls_certificates"])
+
+    assert certs
+
+    for cert in certs:
+        assert "certificate_chain" in cert
+        assert "private_key" not in cert
+        assert cert["private_key_provider"] == {
+            "provider_name": "kms",
+            "typed_config": {
+                "@type": "type.googleapis.com/example.kms.v1.KeyConfig",
+                "key_uri": "projects/p/locations/global/keyRings/r/cryptoKeys/k",
+            },
+        }
+
+
+@pytest.mark.compilertest
+def test_private_key_provider_needs_a_name():
+    yaml = default_listener_manifests() + MANIFESTS.replace("    provider_name: kms\n", "")
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+
+    errors = compiled["ir"].aconf.errors
+    assert any(
+        "'private_key_provider' requires a 'provider_name'" in e["error"]
+        for errs in errors.values()
+        for e in errs
+    )
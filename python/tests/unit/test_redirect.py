@@ -0,0 +1,199 @@
+import pytest
+
+from tests.utils import compile_with_cachecheck, module_and_mapping_manifests
+
+HOSTS = """
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Host
+metadata:
+  name: blog-host
+  namespace: default
+spec:
+  hostname: blog.example.org
+  acmeProvider:
+    authority: none
+  requestPolicy:
+    insecure:
+      action: Route
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Host
+metadata:
+  name: wildcard-host
+  namespace: default
+spec:
+  hostname: "*.example.net"
+  acmeProvider:
+    authority: none
+  requestPolicy:
+    insecure:
+      action: Route
+"""
+
+REDIRECTS = r"""
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Redirect
+metadata:
+  name: moved-domain
+  namespace: default
+spec:
+  host_regex: '^(.+)\.example\.org$'
+  host_rewrite: '\1.example.com'
+  path_regex: '^/old/(.*)$'
+  path_rewrite: '/new/\1'
+  status_code: 308
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Redirect
+metadata:
+  name: force-https
+  namespace: default
+spec:
+  scheme: https
+  precedence: -1
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Redirect
+metadata:
+  name: same-as-moved
+  namespace: default
+spec:
+  host_regex: '^blog\.example\.org$'
+  path_regex: '^/old/(.*)$'
+  scheme: https
+"""
+
+
+def _vhost_routes(compiled, hostname):
+    routes = []
+
+    for listener in compiled["xds"].as_dict()["static_resources"]["listeners"]:
+        for chain in listener["filter_chains"]:
+            for f in chain["filters"]:
+                if f["name"] != "envoy.filters.network.http_connection_manager":
+                    continue
+
+                for vhost in f["typed_config"]["route_config"]["virtual_hosts"]:
+                    if vhost["domains"] == [hostname]:
+                        routes.append(vhost["routes"])
+
+    return routes
+
+
+def _errors(compiled):
+    return [e["error"] for errs in compiled["ir"].aconf.errors.values() for e in errs]
+
+
+@pytest.mark.compilertest
+def test_redirects():
+    yaml = module_and_mapping_manifests(None, []) + HOSTS + REDIRECTS
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+
+    blog = _vhost_routes(compiled, "blog.example.org")
+    assert blog
+
+    for routes in blog:
+        # The Redirects go first, highest precedence first, and the Mapping comes after them.
+        assert routes[0] == {
+            "match": {
+                "safe_regex": {"google_re2": {"max_program_size": 200}, "regex": "^/old/(.*)$"}
+            },
+            "redirect": {
+                "response_code": 4,
+                "host_redirect": "blog.example.com",
+                "regex_rewrite": {
+                    "pattern": {
+                        "google_re2": {"max_program_size": 200},
+                        "regex": "^(?:^/old/(.*)$)$",
+                    },
+                    "substitution": "/new/\\1",
+                },
+            },
+        }
+        assert routes[1] == {
+            "match": {"prefix": "/"},
+            "redirect": {"response_code": 0, "scheme_redirect": "https"},
+        }
+        assert any(r["match"].get("prefix", None) == "/httpbin/" for r in routes[2:])
+
+    # A host_regex can't match a wildcard, but a Redirect without one applies anyway.
+    wildcard = _vhost_routes(compiled, "*.example.net")
+    assert wildcard
+
+    for routes in wildcard:
+        assert [r.get("redirect", None) for r in routes[:1]] == [
+            {"response_code": 0, "scheme_redirect": "https"}
+        ]
+
+    errors = _errors(compiled)
+    assert any(
+        ("same-as-moved" in e) and ("Redirect moved-domain wins" in e) for e in errors
+    ), errors
+    assert not any("force-https" in e for e in errors), errors
+
+
+@pytest.mark.compilertest
+def test_redirect_strip_port():
+    yaml = (
+        module_and_mapping_manifests(None, [])
+        + HOSTS
+        + """
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Redirect
+metadata:
+  name: strip-port
+  namespace: default
+spec:
+  strip_port: true
+  status_code: 302
+"""
+    )
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+
+    for routes in _vhost_routes(compiled, "blog.example.org"):
+        assert routes[0]["redirect"] == {"response_code": 1, "host_redirect": "blog.example.org"}
+
+    # There's no hostname to redirect to for a wildcard, so it gets left alone.
+    for routes in _vhost_routes(compiled, "*.example.net"):
+        assert not any("redirect" in r for r in routes)
+
+    errors = _errors(compiled)
+    assert any(("strip-port" in e) and ("*.example.net" in e) for e in errors), errors
+
+
+@pytest.mark.compilertest
+@pytest.mark.parametrize(
+    "spec, error",
+    [
+        ("{host_regex: '^(.+)$', host_rewrite: '\\2.example.com'}", "only has 1 capture group"),
+        ("{path_regex: '/(', scheme: https}", "not a valid regular expression"),
+        ("{path_rewrite: /new}", "path_rewrite needs a path_regex"),
+        ("{scheme: ftp}", "must be http or https"),
+        ("{scheme: https, status_code: 200}", "must be one of [301, 302, 303, 307, 308]"),
+        ("{path_regex: '^/old$'}", "needs at least one of"),
+    ],
+)
+def test_redirect_invalid(spec, error):
+    yaml = (
+        module_and_mapping_manifests(None, [])
+        + HOSTS
+        + f"""
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Redirect
+metadata:
+  name: bad-redirect
+  namespace: default
+spec: {spec}
+"""
+    )
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+
+    errors = _errors(compiled)
+    assert any(("bad-redirect" in e) and (error in e) for e in errors), errors
+
+    for routes in _vhost_routes(compiled, "blog.example.org"):
+        assert not any("redirect" in r for r in routes)
@@ -0,0 +1,216 @@
+import pytest
+
+from tests.utils import compile_with_cachecheck
+
+MODULE = """
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Module
+metadata:
+  name: ambassador
+  namespace: default
+spec:
+  config:
+    merge_slashes: true
+    request_normalization:
+      escaped_slashes_action: REJECT_REQUEST
+      headers_with_underscores_action: DROP_HEADER
+"""
+
+STRICT_LISTENER = """
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Listener
+metadata:
+  name: strict-listener
+  namespace: default
+spec:
+  port: 8080
+  protocol: HTTP
+  securityModel: XFP
+  hostBinding:
+    namespace:
+      from: ALL
+"""
+
+LAX_LISTENER = """
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Listener
+metadata:
+  name: lax-listener
+  namespace: default
+spec:
+  port: 8443
+  protocol: HTTP
+  securityModel: XFP
+  hostBinding:
+    namespace:
+      from: ALL
+  requestNormalization:
+    mergeSlashes: false
+    escapedSlashesAction: KEEP_UNCHANGED
+    headersWithUnderscoresAction: REJECT_REQUEST
+"""
+
+HOST_AND_MAPPING = """
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Host
+metadata:
+  name: wildcard-host
+  namespace: default
+spec:
+  hostname: "*"
+  requestPolicy:
+    insecure:
+      action: Route
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+metadata:
+  name: quote-backend
+  namespace: default
+spec:
+  prefix: /backend/
+  service: quote
+  hostname: "*"
+"""
+
+
+def _http_managers(compiled):
+    managers = {}
+
+    for listener in compiled["xds"].as_dict()["static_resources"]["listeners"]:
+        port = listener["address"]["socket_address"]["port_value"]
+
+        for chain in listener["filter_chains"]:
+            for f in chain["filters"]:
+                if f["name"] == "envoy.filters.network.http_connection_manager":
+                    managers.setdefault(port, []).append(f["typed_config"])
+
+    return managers
+
+
+def _errors(compiled):
+    return [e["error"] for errs in compiled["ir"].aconf.errors.values() for e in errs]
+
+
+def _notices(compiled):
+    return [n for notices in compiled["ir"].aconf.notices.values() for n in notices]
+
+
+@pytest.mark.compilertest
+def test_request_normalization_defaults():
+    compiled = compile_with_cachecheck(STRICT_LISTENER + HOST_AND_MAPPING)
+
+    for hcm in _http_managers(compiled)[8080]:
+        assert hcm["normalize_path"] is True
+        assert "merge_slashes" not in hcm
+        assert "path_with_escaped_slashes_action" not in hcm
+        assert "headers_with_underscores_action" not in hcm.get(
+            "common_http_protocol_options", {}
+        )
+
+
+@pytest.mark.compilertest
+def test_request_normalization_listener_overrides():
+    compiled = compile_with_cachecheck(MODULE + STRICT_LISTENER + LAX_LISTENER + HOST_AND_MAPPING)
+    assert not _errors(compiled)
+    managers = _http_managers(compiled)
+
+    # The Listener without requestNormalization gets the Module's, including the older
+    # merge_slashes setting.
+    for hcm in managers[8080]:
+        assert hcm["normalize_path"] is True
+        assert hcm["merge_slashes"] is True
+        assert hcm["path_with_escaped_slashes_action"] == "REJECT_REQUEST"
+        assert hcm["common_http_protocol_options"]["headers_with_underscores_action"] == (
+            "DROP_HEADER"
+        )
+
+    for hcm in managers[8443]:
+        assert hcm["normalize_path"] is True
+        assert "merge_slashes" not in hcm
+        assert hcm["path_with_escaped_slashes_action"] == "KEEP_UNCHANGED"
+        assert hcm["common_http_protocol_options"]["headers_with_underscores_action"] == (
+            "REJECT_REQUEST"
+        )
+
+    # Doing less than the Module works, but gets noticed; doing more doesn't.
+    notices = _notices(compiled)
+    assert (
+        "Listener lax-listener: requestNormalization mergeSlashes False is weaker than the "
+        "ambassador Module's True"
+    ) in notices
+    assert (
+        "Listener lax-listener: requestNormalization escapedSlashesAction KEEP_UNCHANGED is "
+        "weaker than the ambassador Module's REJECT_REQUEST"
+    ) in notices
+    assert not [n for n in notices if "headersWithUnderscoresAction" in n]
+
+
+@pytest.mark.compilertest
+def test_request_normalization_without_normalize_path():
+    module = """
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Module
+metadata:
+  name: ambassador
+  namespace: default
+spec:
+  config:
+    request_normalization:
+      normalize_path: false
+"""
+    compiled = compile_with_cachecheck(module + STRICT_LISTENER + HOST_AND_MAPPING)
+
+    for hcm in _http_managers(compiled)[8080]:
+        assert hcm["normalize_path"] is False
+
+    assert any("normalize_path is false" in n for n in _notices(compiled))
+
+
+@pytest.mark.compilertest
+@pytest.mark.parametrize(
+    "settings, error",
+    [
+        (
+            "escapedSlashesAction: DECODE",
+            "Listener broken-listener: requestNormalization escapedSlashesAction DECODE must be "
+            "one of KEEP_UNCHANGED, UNESCAPE_AND_FORWARD, UNESCAPE_AND_REDIRECT, REJECT_REQUEST, "
+            "ignoring",
+        ),
+        (
+            "normalizePath: maybe",
+            "Listener broken-listener: requestNormalization normalizePath maybe must be true or "
+            "false, ignoring",
+        ),
+    ],
+)
+def test_request_normalization_invalid(settings, error):
+    listener = f"""
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Listener
+metadata:
+  name: broken-listener
+  namespace: default
+spec:
+  port: 8080
+  protocol: HTTP
+  securityModel: XFP
+  hostBinding:
+    namespace:
+      from: ALL
+  requestNormalization:
+    {settings}
+"""
+    compiled = compile_with_cachecheck(listener + HOST_AND_MAPPING, errors_ok=True)
+    assert error in _errors(compiled)
+
+    # The Listener is still there, with the Module's settings.
+    for hcm in _http_managers(compiled)[8080]:
+        assert hcm["normalize_path"] is True
+        assert "path_with_escaped_slashes_action" not in hcm
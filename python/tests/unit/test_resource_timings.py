@@ -0,0 +1,14 @@
+import pytest
+
+from tests.utils import compile_with_cachecheck, module_and_mapping_manifests
+
+
+@pytest.mark.compilertest
+def test_resource_timings():
+    ir = compile_with_cachecheck(module_and_mapping_manifests(None, []))["ir"]
+
+    # The Mapping that somebody wrote gets timed...
+    assert ("Mapping", "default", "ambassador") in ir.resource_timings
+
+    # ...and nothing takes negative time.
+    assert all(seconds >= 0 for seconds in ir.resource_timings.values())
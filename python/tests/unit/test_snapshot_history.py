@@ -0,0 +1,74 @@
+import os
+
+import pytest
+
+from ambassador.diagnostics.history import archived_snapshots, parse_time, snapshot_at
+
+
+def _write(path, contents, mtime):
+    with open(path, "w") as f:
+        f.write(contents)
+
+    os.utime(path, (mtime, mtime))
+
+
+@pytest.fixture
+def snapshot_dir(tmp_path):
+    _write(tmp_path / "snapshot-2.yaml", "oldest", 1000)
+    _write(tmp_path / "snapshot-1.yaml", "older", 2000)
+    _write(tmp_path / "snapshot.yaml", "current", 3000)
+
+    # None of these are snapshots that made it to Envoy.
+    _write(tmp_path / "snapshot-tmp.yaml", "in progress", 4000)
+    _write(tmp_path / "ir.json", "{}", 3000)
+
+    return str(tmp_path)
+
+
+def test_archived_snapshots(snapshot_dir):
+    names = [os.path.basename(s.path) for s in archived_snapshots(snapshot_dir)]
+    assert names == ["snapshot.yaml", "snapshot-1.yaml", "snapshot-2.yaml"]
+
+
+@pytest.mark.parametrize(
+    "when,wanted",
+    [
+        (999, None),
+        (1000, "snapshot-2.yaml"),
+        (1999, "snapshot-2.yaml"),
+        (2500, "snapshot-1.yaml"),
+        (3000, "snapshot.yaml"),
+        (9999, "snapshot.yaml"),
+    ],
+)
+def test_snapshot_at(snapshot_dir, when, wanted):
+    snapshot = snapshot_at(snapshot_dir, when)
+
+    if wanted is None:
+        assert snapshot is None
+    else:
+        assert snapshot is not None
+        assert os.path.basename(snapshot.path) == wanted
+
+
+def test_snapshot_at_nothing_kept(tmp_path):
+    assert snapshot_at(str(tmp_path / "nope"), 1000) is None
+
+
+@pytest.mark.parametrize(
+    "value,wanted",
+    [
+        ("1700000000", 1700000000),
+        ("1700000000.5", 1700000000.5),
+        ("2023-11-14T22:13:20Z", 1700000000),
+        ("2023-11-14T22:13:20", 1700000000),
+        ("2023-11-14T23:13:20+01:00", 1700000000),
+    ],
+)
+def test_parse_time(value, wanted):
+    assert parse_time(value) == wanted
+
+
+def test_parse_time_garbage():
+    with pytest.raises(ValueError):
+        parse_time("around nine")
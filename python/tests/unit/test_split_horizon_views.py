@@ -0,0 +1,179 @@
+import pytest
+
+from tests.utils import compile_with_cachecheck
+
+LISTENERS = """
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Listener
+metadata:
+  name: external-listener
+  namespace: default
+spec:
+  port: 8080
+  protocol: HTTP
+  securityModel: XFP
+  hostBinding:
+    namespace:
+      from: ALL
+  view: external
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Listener
+metadata:
+  name: internal-listener
+  namespace: default
+spec:
+  port: 8081
+  protocol: HTTP
+  securityModel: XFP
+  hostBinding:
+    namespace:
+      from: ALL
+  view: internal
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Listener
+metadata:
+  name: plain-listener
+  namespace: default
+spec:
+  port: 8082
+  protocol: HTTP
+  securityModel: XFP
+  hostBinding:
+    namespace:
+      from: ALL
+"""
+
+HOST_AND_MAPPINGS = """
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Host
+metadata:
+  name: wildcard-host
+  namespace: default
+spec:
+  hostname: "*"
+  requestPolicy:
+    insecure:
+      action: Route
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+metadata:
+  name: quote-external
+  namespace: default
+spec:
+  prefix: /backend/
+  service: quote-external
+  hostname: "*"
+  views: [ external ]
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+metadata:
+  name: quote-internal
+  namespace: default
+spec:
+  prefix: /backend/
+  service: quote-internal
+  hostname: "*"
+  views: [ internal ]
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+metadata:
+  name: quote-everywhere
+  namespace: default
+spec:
+  prefix: /everywhere/
+  service: quote-everywhere
+  hostname: "*"
+"""
+
+BROKEN = """
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Listener
+metadata:
+  name: broken-listener
+  namespace: default
+spec:
+  port: 8080
+  protocol: HTTP
+  securityModel: XFP
+  hostBinding:
+    namespace:
+      from: ALL
+  view: Not_A_View
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+metadata:
+  name: broken-mapping
+  namespace: default
+spec:
+  prefix: /broken/
+  service: broken
+  hostname: "*"
+  views: []
+"""
+
+
+def _clusters_by_port(compiled):
+    clusters = {}
+
+    for listener in compiled["xds"].as_dict()["static_resources"]["listeners"]:
+        port = listener["address"]["socket_address"]["port_value"]
+
+        for chain in listener["filter_chains"]:
+            for f in chain["filters"]:
+                if f["name"] != "envoy.filters.network.http_connection_manager":
+                    continue
+
+                for vhost in f["typed_config"]["route_config"]["virtual_hosts"]:
+                    for r in vhost["routes"]:
+                        prefix = r["match"].get("prefix", None)
+                        cluster = r.get("route", {}).get("cluster", None)
+
+                        if prefix and cluster:
+                            clusters.setdefault(port, {})[prefix] = cluster
+
+    return clusters
+
+
+def _errors(compiled):
+    return [e["error"] for errs in compiled["ir"].aconf.errors.values() for e in errs]
+
+
+@pytest.mark.compilertest
+def test_split_horizon_views():
+    compiled = compile_with_cachecheck(LISTENERS + HOST_AND_MAPPINGS)
+    clusters = _clusters_by_port(compiled)
+
+    # The same prefix goes to a different upstream depending on the Listener's view...
+    assert "quote_external" in clusters[8080]["/backend/"]
+    assert "quote_internal" in clusters[8081]["/backend/"]
+
+    # ...a Listener without a view only gets the Mappings that don't have any...
+    assert "/backend/" not in clusters[8082]
+
+    # ...and a Mapping without views is everywhere.
+    for port in [8080, 8081, 8082]:
+        assert "quote_everywhere" in clusters[port]["/everywhere/"]
+
+
+@pytest.mark.compilertest
+def test_split_horizon_views_invalid():
+    compiled = compile_with_cachecheck(BROKEN + HOST_AND_MAPPINGS, errors_ok=True)
+    errors = _errors(compiled)
+
+    assert "Listener broken-listener: invalid view Not_A_View, ignoring" in errors
+    assert any("Invalid views specified: []" in e for e in errors), errors
+
+    # The Listener is still there, without a view, and the broken Mapping isn't.
+    clusters = _clusters_by_port(compiled)
+    assert "/backend/" not in clusters[8080]
+    assert "/broken/" not in clusters[8080]
+    assert "quote_everywhere" in clusters[8080]["/everywhere/"]
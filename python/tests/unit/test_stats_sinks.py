@@ -0,0 +1,142 @@
+import json
+
+import pytest
+
+from ambassador.ir.irstatssinks import REQUIRED_STATS_PATTERNS, stats_sinks_config
+from tests.utils import compile_with_cachecheck, module_and_mapping_manifests
+
+
+def _errors(compiled):
+    return [e["error"] for errs in compiled["ir"].aconf.errors.values() for e in errs]
+
+
+def test_stats_sinks_config():
+    settings, errors, notices = stats_sinks_config(
+        {
+            "flush_interval": 10,
+            "statsd": {"address": "127.0.0.1:9125", "tag_style": "dogstatsd", "prefix": "emissary"},
+            "otlp": {"address": "otel-collector.monitoring", "deltas": True},
+            "include": [r"^cluster\."],
+        }
+    )
+    assert not errors
+    assert not notices
+    assert settings == {
+        "flush_interval": 10,
+        "statsd": {"ip": "127.0.0.1", "port": 9125, "dogstatsd": True, "prefix": "emissary"},
+        "otlp": {"host": "otel-collector.monitoring", "port": 4317, "deltas": True},
+        "include": [r"^cluster\."] + REQUIRED_STATS_PATTERNS,
+        "exclude": [],
+    }
+
+
+def test_stats_sinks_config_exclude():
+    settings, errors, notices = stats_sinks_config({"exclude": [r"^vhost\."]})
+    assert not errors
+    assert not notices
+    assert settings["exclude"] == [r"^vhost\."]
+
+    settings, errors, notices = stats_sinks_config({"exclude": [r"^cluster\."]})
+    assert not errors
+    assert len(notices) == 1
+    assert "drops stats that Emissary watches" in notices[0]
+
+
+@pytest.mark.parametrize(
+    "stats_sinks, error",
+    [
+        (True, "must be an object"),
+        ({"flush_interval": 0}, "must be a positive integer"),
+        ({"statsd": {}}, "must be a host, or a host:port"),
+        ({"statsd": {"address": "127.0.0.1:lots"}}, "must be a host, or a host:port"),
+        ({"statsd": {"address": "127.0.0.1", "tag_style": "influx"}}, "must be one of"),
+        ({"otlp": {"address": "collector", "deltas": "yes"}}, "must be true or false"),
+        ({"include": "^cluster"}, "must be a list of regular expressions"),
+        ({"exclude": ["("]}, "is not a valid regular expression"),
+        ({"include": ["^a"], "exclude": ["^b"]}, "not both"),
+    ],
+)
+def test_stats_sinks_config_invalid(stats_sinks, error):
+    settings, errors, _ = stats_sinks_config(stats_sinks)
+    assert settings is None
+    assert any(error in e for e in errors), errors
+
+
+@pytest.mark.compilertest
+def test_stats_sinks():
+    yaml = module_and_mapping_manifests(
+        [
+            "stats_sinks:",
+            "    flush_interval: 10",
+            "    statsd:",
+            "        address: 127.0.0.1:9125",
+            "        tag_style: dogstatsd",
+            "        prefix: emissary",
+            "    exclude:",
+            "    - ^vhost\\.",
+        ],
+        [],
+    )
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+    assert not _errors(compiled)
+
+    bootstrap = compiled["xds"].as_dict()["bootstrap"]
+    (sink,) = bootstrap["stats_sinks"]
+    assert sink["name"] == "envoy.stat_sinks.dog_statsd"
+    assert sink["typed_config"]["prefix"] == "emissary"
+    assert sink["typed_config"]["address"]["socket_address"] == {
+        "protocol": "UDP",
+        "address": "127.0.0.1",
+        "port_value": 9125,
+    }
+    assert bootstrap["stats_flush_interval"] == {"seconds": 10}
+    assert bootstrap["stats_config"]["stats_matcher"] == {
+        "exclusion_list": {"patterns": [{"safe_regex": {"google_re2": {}, "regex": "^vhost\\."}}]}
+    }
+
+
+@pytest.mark.compilertest
+def test_stats_sinks_otlp(monkeypatch, tmp_path):
+    yaml = module_and_mapping_manifests(
+        ["stats_sinks: {otlp: {address: otel-collector.monitoring}}"], []
+    )
+
+    # Without a probed Envoy that has the sink, otlp is ignored.
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+    assert any("ignoring otlp" in e for e in _errors(compiled))
+    assert not compiled["xds"].as_dict()["bootstrap"]["stats_sinks"]
+
+    probe = tmp_path / "envoy-probe.json"
+    probe.write_text(
+        json.dumps(
+            {
+                "binary": "/usr/local/bin/envoy-contrib",
+                "extensions": {
+                    "envoy.stat_sinks.open_telemetry": [
+                        "envoy.extensions.stat_sinks.open_telemetry.v3.SinkConfig"
+                    ]
+                },
+            }
+        )
+    )
+    monkeypatch.setenv("AMBASSADOR_ENVOY_PROBE_FILE", str(probe))
+
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+    assert not any("ignoring otlp" in e for e in _errors(compiled))
+
+    bootstrap = compiled["xds"].as_dict()["bootstrap"]
+    (sink,) = bootstrap["stats_sinks"]
+    assert sink["name"] == "envoy.stat_sinks.open_telemetry"
+    assert sink["typed_config"]["grpc_service"] == {
+        "envoy_grpc": {"cluster_name": "stats_sink_otlp"}
+    }
+    assert "stats_sink_otlp" in [c["name"] for c in bootstrap["static_resources"]["clusters"]]
+
+
+@pytest.mark.compilertest
+def test_stats_sinks_invalid():
+    yaml = module_and_mapping_manifests(["stats_sinks: {flush_interval: -1}"], [])
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+
+    assert any("ignoring stats_sinks" in e for e in _errors(compiled))
+    assert "stats_flush_interval" not in compiled["xds"].as_dict()["bootstrap"]
@@ -0,0 +1,75 @@
+import pytest
+
+from tests.utils import (
+    compile_with_cachecheck,
+    econf_compile,
+    econf_foreach_cluster,
+    module_and_mapping_manifests,
+)
+
+SUBSET_CLUSTER = "cluster_httpbin_default_ss_version"
+
+
+def _httpbin_routes(econf):
+    routes = []
+
+    for listener in econf["static_resources"]["listeners"]:
+        for chain in listener["filter_chains"]:
+            for f in chain["filters"]:
+                if f["name"] != "envoy.filters.network.http_connection_manager":
+                    continue
+
+                for vhost in f["typed_config"]["route_config"]["virtual_hosts"]:
+                    for r in vhost["routes"]:
+                        if ("route" in r) and (r["match"].get("prefix") == "/httpbin/"):
+                            routes.append(r["route"])
+
+    return routes
+
+
+@pytest.mark.compilertest
+def test_subset_labels_endpoint_resolver():
+    yaml = module_and_mapping_manifests(
+        None, ["resolver: endpoint", "subset_labels: {version: v2}"]
+    )
+    econf = econf_compile(yaml)
+
+    def check(cluster):
+        assert cluster["type"] == "EDS"
+        assert cluster["lb_subset_config"] == {
+            "fallback_policy": "NO_FALLBACK",
+            "subset_selectors": [{"keys": ["version"]}],
+        }
+
+    econf_foreach_cluster(econf, check, name=SUBSET_CLUSTER)
+
+    routes = _httpbin_routes(econf)
+    assert routes
+
+    for route in routes:
+        assert route["cluster"] == SUBSET_CLUSTER
+        assert route["metadata_match"] == {"filter_metadata": {"envoy.lb": {"version": "v2"}}}
+
+
+@pytest.mark.compilertest
+def test_subset_labels_service_resolver():
+    # Without endpoints there are no labels to pick a subset by, so there's no subset config.
+    yaml = module_and_mapping_manifests(None, ["subset_labels: {version: v2}"])
+    econf = econf_compile(yaml)
+
+    def check(cluster):
+        assert cluster["type"] == "STRICT_DNS"
+        assert "lb_subset_config" not in cluster
+
+    econf_foreach_cluster(econf, check, name=SUBSET_CLUSTER)
+
+
+@pytest.mark.compilertest
+def test_subset_labels_invalid():
+    yaml = module_and_mapping_manifests(None, ["resolver: endpoint", "subset_labels: [v2]"])
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+
+    errors = compiled["ir"].aconf.errors
+    assert any(
+        "Invalid subset_labels" in e["error"] for errs in errors.values() for e in errs
+    ), errors
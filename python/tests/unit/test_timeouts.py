@@ -0,0 +1,96 @@
+import pytest
+
+from ambassador.ir.irtimeouts import timeout_report
+from tests.utils import compile_with_cachecheck, module_and_mapping_manifests
+
+HOSTS = """
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Host
+metadata:
+  name: slow-host
+  namespace: default
+spec:
+  hostname: slow.example.com
+  acmeProvider:
+    authority: none
+  requestPolicy:
+    insecure:
+      action: Route
+  request_timeout_ms: 20000
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Host
+metadata:
+  name: plain-host
+  namespace: default
+spec:
+  hostname: plain.example.com
+  acmeProvider:
+    authority: none
+  requestPolicy:
+    insecure:
+      action: Route
+"""
+
+
+def _route_timeouts(compiled):
+    # Map each virtual host's domain to the timeout of its /httpbin/ route.
+    timeouts = {}
+
+    for listener in compiled["xds"].as_dict()["static_resources"]["listeners"]:
+        for chain in listener["filter_chains"]:
+            for f in chain["filters"]:
+                if f["name"] != "envoy.filters.network.http_connection_manager":
+                    continue
+
+                for vhost in f["typed_config"]["route_config"]["virtual_hosts"]:
+                    for r in vhost["routes"]:
+                        if ("route" in r) and (r["match"].get("prefix") == "/httpbin/"):
+                            timeouts[vhost["domains"][0]] = r["route"]["timeout"]
+
+    return timeouts
+
+
+@pytest.mark.compilertest
+def test_host_timeout_overrides_module():
+    yaml = module_and_mapping_manifests(["cluster_request_timeout_ms: 4000"], []) + HOSTS
+    compiled = compile_with_cachecheck(yaml)
+
+    assert _route_timeouts(compiled) == {
+        "slow.example.com": "20.000s",
+        "plain.example.com": "4.000s",
+    }
+
+    report = {entry["host"]: entry for entry in timeout_report(compiled["ir"])}
+    assert report["slow.example.com"]["request_timeout_ms"] == {
+        "value": 20000,
+        "source": "Host slow-host.default",
+    }
+    assert report["plain.example.com"]["request_timeout_ms"] == {
+        "value": 4000,
+        "source": "Module ambassador",
+    }
+
+
+@pytest.mark.compilertest
+def test_mapping_timeout_overrides_host():
+    yaml = (
+        module_and_mapping_manifests(
+            ["cluster_request_timeout_ms: 4000"],
+            ["timeout_ms: 0", "retry_policy:", "  retry_on: 5xx", "  per_try_timeout: 1s"],
+        )
+        + HOSTS
+    )
+    compiled = compile_with_cachecheck(yaml)
+
+    # timeout_ms: 0 turns the timeout off, and nothing can turn it back on.
+    assert _route_timeouts(compiled) == {
+        "slow.example.com": "0.000s",
+        "plain.example.com": "0.000s",
+    }
+
+    for entry in timeout_report(compiled["ir"]):
+        assert entry["request_timeout_ms"] == {"value": 0, "source": "Mapping ambassador.default"}
+        assert entry["per_try_timeout"] == {"value": "1s", "source": "Mapping ambassador.default"}
+        assert entry["idle_timeout_ms"] is None
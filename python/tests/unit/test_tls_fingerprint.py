@@ -0,0 +1,158 @@
+import pytest
+
+from ambassador.ir.irtlsfingerprint import listener_tls_fingerprint
+from tests.utils import compile_with_cachecheck
+
+TLS_STACK = ["TLS", "HTTP", "TCP"]
+
+CONFIG = """
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Listener
+metadata:
+  name: fingerprint-listener
+  namespace: default
+spec:
+  port: 8443
+  protocol: HTTPS
+  securityModel: XFP
+  hostBinding:
+    namespace:
+      from: ALL
+  tlsFingerprint:
+    ja3: true
+    header: X-Client-JA3
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Host
+metadata:
+  name: wildcard-host
+  namespace: default
+spec:
+  hostname: "*"
+  requestPolicy:
+    insecure:
+      action: Route
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+metadata:
+  name: quote-backend
+  namespace: default
+spec:
+  prefix: /backend/
+  service: quote
+  hostname: "*"
+---
+apiVersion: getambassador.io/v3alpha1
+kind: AuthService
+metadata:
+  name: http-auth
+  namespace: default
+spec:
+  auth_service: auth:8080
+  proto: http
+"""
+
+
+def test_tls_fingerprint_settings():
+    assert listener_tls_fingerprint(None, "l", TLS_STACK) == (None, [], [])
+    assert listener_tls_fingerprint({"ja3": True}, "l", TLS_STACK) == (
+        {"ja3": True, "header": None},
+        [],
+        [],
+    )
+    assert listener_tls_fingerprint({"ja3": True, "header": "X-JA3"}, "l", TLS_STACK) == (
+        {"ja3": True, "header": "x-ja3"},
+        [],
+        [],
+    )
+
+
+@pytest.mark.parametrize(
+    "spec, stack, errors, notices",
+    [
+        (
+            {"ja3": "yes"},
+            TLS_STACK,
+            ["Listener l: tlsFingerprint ja3 yes must be true or false, ignoring"],
+            [],
+        ),
+        (
+            {"ja3": True, "header": "x ja3"},
+            TLS_STACK,
+            ["Listener l: tlsFingerprint header x ja3 is not a valid header name, ignoring"],
+            [],
+        ),
+        (
+            {"header": "x-ja3"},
+            TLS_STACK,
+            [],
+            ["Listener l: tlsFingerprint header x-ja3 does nothing without ja3"],
+        ),
+        (
+            {"ja3": True},
+            ["HTTP", "TCP"],
+            [],
+            ["Listener l: tlsFingerprint does nothing on a Listener without TLS"],
+        ),
+        (
+            {"ja3": True},
+            ["TLS", "HTTP", "UDP"],
+            [],
+            [
+                "Listener l: tlsFingerprint does nothing on a UDP Listener: Envoy can't "
+                "fingerprint QUIC"
+            ],
+        ),
+    ],
+)
+def test_tls_fingerprint_ignored(spec, stack, errors, notices):
+    assert listener_tls_fingerprint(spec, "l", stack) == (None, errors, notices)
+
+
+@pytest.mark.compilertest
+def test_tls_fingerprint_envoy_config():
+    compiled = compile_with_cachecheck(CONFIG)
+
+    listener = [
+        l
+        for l in compiled["xds"].as_dict()["static_resources"]["listeners"]
+        if l["address"]["socket_address"]["port_value"] == 8443
+    ][0]
+
+    assert {
+        "name": "envoy.filters.listener.tls_inspector",
+        "typed_config": {
+            "@type": "type.googleapis.com/envoy.extensions.filters.listener.tls_inspector.v3.TlsInspector",
+            "enable_ja3_fingerprinting": True,
+        },
+    } in listener["listener_filters"]
+
+    for chain in listener["filter_chains"]:
+        for f in chain["filters"]:
+            if f["name"] != "envoy.filters.network.http_connection_manager":
+                continue
+
+            hcm = f["typed_config"]
+
+            # The fingerprint's in the access log...
+            log_format = hcm["access_log"][0]["typed_config"]["log_format"]
+            assert "%TLS_JA3_FINGERPRINT%" in log_format["text_format_source"]["inline_string"]
+
+            # ...in the auth check...
+            ext_authz = [
+                hf for hf in hcm["http_filters"] if hf["name"] == "envoy.filters.http.ext_authz"
+            ][0]
+            headers_to_add = ext_authz["typed_config"]["http_service"]["authorization_request"][
+                "headers_to_add"
+            ]
+            assert {"key": "x-client-ja3", "value": "%TLS_JA3_FINGERPRINT%"} in headers_to_add
+
+            # ...and sent upstream, in place of whatever the client sent.
+            for vhost in hcm["route_config"]["virtual_hosts"]:
+                assert "x-client-ja3" in vhost["request_headers_to_remove"]
+                assert {
+                    "header": {"key": "x-client-ja3", "value": "%TLS_JA3_FINGERPRINT%"},
+                    "append": False,
+                } in vhost["request_headers_to_add"]
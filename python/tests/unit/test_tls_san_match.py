@@ -0,0 +1,76 @@
+import pytest
+
+from tests.utils import compile_with_cachecheck, default_listener_manifests
+
+MANIFESTS = """
+---
+apiVersion: getambassador.io/v3alpha1
+kind: TLSContext
+metadata:
+  name: mesh-upstream
+  namespace: default
+spec:
+  match_subject_alt_names:
+  - san_type: URI
+    prefix: spiffe://example.org/
+  - san_type: DNS
+    exact: backend.example.org
+---
+apiVersion: getambassador.io/v3alpha1
+kind: Mapping
+metadata:
+  name: meshed
+  namespace: default
+spec:
+  hostname: "*"
+  prefix: /meshed/
+  service: https://backend
+  tls: mesh-upstream
+"""
+
+
+def _upstream_validation_contexts(compiled):
+    contexts = []
+
+    for cluster in compiled["xds"].as_dict()["static_resources"]["clusters"]:
+        if not cluster["name"].startswith("cluster_https___backend"):
+            continue
+
+        typed_config = cluster.get("transport_socket", {}).get("typed_config", {})
+        contexts.append(typed_config.get("common_tls_context", {}).get("validation_context", {}))
+
+    return contexts
+
+
+@pytest.mark.compilertest
+def test_match_subject_alt_names():
+    compiled = compile_with_cachecheck(default_listener_manifests() + MANIFESTS)
+
+    contexts = _upstream_validation_contexts(compiled)
+    assert contexts
+
+    for context in contexts:
+        assert context["match_typed_subject_alt_names"] == [
+            {"san_type": "URI", "matcher": {"prefix": "spiffe://example.org/"}},
+            {"san_type": "DNS", "matcher": {"exact": "backend.example.org"}},
+        ]
+
+
+@pytest.mark.compilertest
+def test_match_subject_alt_names_needs_one_matcher():
+    yaml = default_listener_manifests() + MANIFESTS.replace(
+        "    exact: backend.example.org\n",
+        "    exact: backend.example.org\n    prefix: backend.\n",
+    )
+    compiled = compile_with_cachecheck(yaml, errors_ok=True)
+
+    errors = compiled["ir"].aconf.errors
+    assert any(
+        "exactly one of 'exact' and 'prefix'" in e["error"]
+        for errs in errors.values()
+        for e in errs
+    )
+
+    # Without the context, nothing should be checking SANs at all.
+    for context in _upstream_validation_contexts(compiled):
+        assert "match_typed_subject_alt_names" not in context
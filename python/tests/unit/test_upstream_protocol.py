@@ -0,0 +1,184 @@
+import pytest
+
+from ambassador.ir.ircluster import app_protocol_upstream, upstream_protocol_error
+from tests.utils import (
+    compile_with_cachecheck,
+    econf_compile,
+    econf_foreach_cluster,
+    module_and_mapping_manifests,
+)
+
+HTTP_OPTIONS = "envoy.extensions.upstreams.http.v3.HttpProtocolOptions"
+
+APP_PROTOCOL_SERVICE = """
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: httpbin
+  namespace: default
+spec:
+  ports:
+  - name: http
+    port: 80
+    appProtocol: %s
+"""
+
+
+def _compile(mapping_confs, module_confs=None, service=None, app_protocol=None):
+    yaml = module_and_mapping_manifests(module_confs, mapping_confs)
+
+    if service:
+        yaml = yaml.replace("service: httpbin", f"service: {service}")
+
+    if app_protocol:
+        yaml += APP_PROTOCOL_SERVICE % app_protocol
+
+    return yaml
+
+
+def _alpn(cluster):
+    tls = cluster["transport_socket"]["typed_config"]
+    return tls["common_tls_context"].get("alpn_protocols")
+
+
+@pytest.mark.parametrize(
+    "protocol, originate_tls, ok",
+    [
+        ("http/1.1", False, True),
+        ("http/1.1", True, True),
+        ("h2", True, True),
+        ("h2", False, False),
+        ("h2c", False, True),
+        ("h2c", True, False),
+        ("auto", True, True),
+        ("auto", False, False),
+        ("spdy", False, False),
+    ],
+)
+def test_upstream_protocol_error(protocol, originate_tls, ok):
+    assert (upstream_protocol_error(protocol, originate_tls) is None) == ok
+
+
+@pytest.mark.parametrize(
+    "app_protocol, originate_tls, expected",
+    [
+        ("kubernetes.io/h2c", False, "h2c"),
+        ("grpc", False, "h2c"),
+        ("GRPC", True, "h2"),
+        ("http2", True, "h2"),
+        ("kubernetes.io/ws", False, "http/1.1"),
+        ("https", True, "http/1.1"),
+        ("example.com/custom", False, None),
+    ],
+)
+def test_app_protocol_upstream(app_protocol, originate_tls, expected):
+    assert app_protocol_upstream(app_protocol, originate_tls) == expected
+
+
+@pytest.mark.compilertest
+def test_upstream_protocol_h2c():
+    econf = econf_compile(_compile(["upstream_protocol: h2c"]))
+
+    def check(cluster):
+        assert cluster["http2_protocol_options"] == {}
+        assert "transport_socket" not in cluster
+
+    econf_foreach_cluster(econf, check, name="cluster_httpbin_up_h2c_default")
+
+
+@pytest.mark.compilertest
+def test_upstream_protocol_h2():
+    econf = econf_compile(_compile(["upstream_protocol: h2"], service="https://httpbin"))
+
+    def check(cluster):
+        assert cluster["http2_protocol_options"] == {}
+        assert _alpn(cluster) == ["h2"]
+
+    econf_foreach_cluster(econf, check, name="cluster_https___httpbin_otls_up_h2_default")
+
+
+@pytest.mark.compilertest
+def test_upstream_protocol_auto():
+    yaml = _compile(
+        ["upstream_protocol: auto"],
+        module_confs=["cluster_idle_timeout_ms: 30000"],
+        service="https://httpbin",
+    )
+    econf = econf_compile(yaml)
+
+    def check(cluster):
+        # Everything about the protocol has to be in the extension, with auto_config.
+        assert "http2_protocol_options" not in cluster
+        assert "http_protocol_options" not in cluster
+        assert "common_http_protocol_options" not in cluster
+
+        options = cluster["typed_extension_protocol_options"][HTTP_OPTIONS]
+        assert options["auto_config"] == {
+            "http_protocol_options": {},
+            "http2_protocol_options": {},
+        }
+        assert options["common_http_protocol_options"] == {"idle_timeout": "30.000s"}
+        assert _alpn(cluster) == ["h2", "http/1.1"]
+
+    econf_foreach_cluster(econf, check, name="cluster_https___httpbin_otls_up_auto_default")
+
+
+@pytest.mark.compilertest
+def test_upstream_protocol_invalid():
+    # HTTP/2 over TLS needs TLS, so this is just an HTTP/1.1 cluster.
+    compiled = compile_with_cachecheck(_compile(["upstream_protocol: h2"]), errors_ok=True)
+
+    errors = compiled["ir"].aconf.errors
+    assert any(
+        "upstream_protocol h2 needs TLS origination" in e["error"]
+        for errs in errors.values()
+        for e in errs
+    ), errors
+
+    def check(cluster):
+        assert "http2_protocol_options" not in cluster
+
+    econf_foreach_cluster(compiled["xds"].as_dict(), check)
+
+
+@pytest.mark.compilertest
+@pytest.mark.parametrize("app_protocol", ["kubernetes.io/h2c", "grpc"])
+def test_app_protocol_h2c(app_protocol):
+    # No magic service names or grpc: true, just the Service saying what it speaks.
+    econf = econf_compile(_compile([], app_protocol=app_protocol))
+
+    def check(cluster):
+        assert cluster["http2_protocol_options"] == {}
+
+    econf_foreach_cluster(econf, check)
+
+
+@pytest.mark.compilertest
+def test_app_protocol_http():
+    econf = econf_compile(_compile([], app_protocol="kubernetes.io/ws"))
+
+    def check(cluster):
+        assert "http2_protocol_options" not in cluster
+
+    econf_foreach_cluster(econf, check)
+
+
+@pytest.mark.compilertest
+def test_app_protocol_mismatch():
+    # The Mapping wins, but someone should hear about it.
+    yaml = _compile(["upstream_protocol: http/1.1"], app_protocol="grpc")
+    compiled = compile_with_cachecheck(yaml)
+
+    notices = compiled["ir"].aconf.notices
+    assert any(
+        "has appProtocol grpc, but is using upstream_protocol http/1.1" in n
+        for ns in notices.values()
+        for n in ns
+    ), notices
+
+    def check(cluster):
+        assert "http2_protocol_options" not in cluster
+
+    econf = compiled["xds"].as_dict()
+    econf_foreach_cluster(econf, check, name="cluster_httpbin_up_http_1_1_default")